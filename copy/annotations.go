@@ -0,0 +1,128 @@
+package copy
+
+import (
+	"bytes"
+	"context"
+	"sort"
+	"text/template"
+
+	"github.com/containers/image/v5/manifest"
+	"github.com/containers/image/v5/types"
+	imgspecv1 "github.com/opencontainers/image-spec/specs-go/v1"
+	"github.com/pkg/errors"
+)
+
+// AnnotationChanges describes changes to the annotations of copied OCI manifests and indexes.
+type AnnotationChanges struct {
+	// Set maps annotation keys to values to set.  The values are text/template templates,
+	// executed with an AnnotationTemplateData value.
+	Set map[string]string
+	// Remove lists annotation keys to remove.  Keys are removed before Set is applied.
+	Remove []string
+	// Metadata is made available to templates in Set as .Metadata, e.g. {{.Metadata.version}}.
+	// Typically it contains build metadata, like a version or a VCS revision.
+	Metadata map[string]string
+}
+
+// AnnotationTemplateData is the data available to AnnotationChanges.Set templates.
+type AnnotationTemplateData struct {
+	Metadata map[string]string // AnnotationChanges.Metadata
+	// Platform of the image, if known; nil when annotating an index.
+	Platform *imgspecv1.Platform
+}
+
+// annotationEditor applies AnnotationChanges to manifests.
+type annotationEditor struct {
+	set      map[string]*template.Template
+	remove   []string
+	metadata map[string]string
+}
+
+// newAnnotationEditor parses the templates in changes.
+func newAnnotationEditor(changes *AnnotationChanges) (*annotationEditor, error) {
+	res := annotationEditor{
+		set:      make(map[string]*template.Template, len(changes.Set)),
+		remove:   changes.Remove,
+		metadata: changes.Metadata,
+	}
+	for key, value := range changes.Set {
+		t, err := template.New(key).Option("missingkey=error").Parse(value)
+		if err != nil {
+			return nil, errors.Wrapf(err, "parsing template for annotation %q", key)
+		}
+		res.set[key] = t
+	}
+	return &res, nil
+}
+
+// apply updates annotations per e, using platform for the template data.
+// annotations may be nil; the return value is the updated map, or nil if empty.
+func (e *annotationEditor) apply(annotations map[string]string, platform *imgspecv1.Platform) (map[string]string, error) {
+	res := make(map[string]string, len(annotations)+len(e.set))
+	for k, v := range annotations {
+		res[k] = v
+	}
+	for _, k := range e.remove {
+		delete(res, k)
+	}
+	data := AnnotationTemplateData{Metadata: e.metadata, Platform: platform}
+	keys := make([]string, 0, len(e.set))
+	for k := range e.set {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys) // To have deterministic error messages
+	for _, k := range keys {
+		var value bytes.Buffer
+		if err := e.set[k].Execute(&value, data); err != nil {
+			return nil, errors.Wrapf(err, "computing value of annotation %q", k)
+		}
+		res[k] = value.String()
+	}
+	if len(res) == 0 {
+		return nil, nil
+	}
+	return res, nil
+}
+
+// updateManifest returns man, a manifest of pendingImage, with annotations updated per e.
+func (e *annotationEditor) updateManifest(ctx context.Context, pendingImage types.Image, man []byte) ([]byte, error) {
+	_, mimeType, err := pendingImage.Manifest(ctx)
+	if err != nil {
+		return nil, errors.Wrap(err, "reading manifest")
+	}
+	if mimeType != imgspecv1.MediaTypeImageManifest {
+		return nil, errors.Errorf("Annotations can only be changed in OCI manifests, not in %q", mimeType)
+	}
+	m, err := manifest.OCI1FromManifest(man)
+	if err != nil {
+		return nil, err
+	}
+	var platform *imgspecv1.Platform
+	if config, err := pendingImage.OCIConfig(ctx); err == nil {
+		platform = &imgspecv1.Platform{
+			Architecture: config.Architecture,
+			OS:           config.OS,
+			Variant:      config.Variant,
+			OSVersion:    config.OSVersion,
+		}
+	}
+	m.Annotations, err = e.apply(m.Annotations, platform)
+	if err != nil {
+		return nil, err
+	}
+	return m.Serialize()
+}
+
+// updateList updates annotations of list per e.
+func (e *annotationEditor) updateList(list manifest.List) error {
+	index, ok := list.(*manifest.OCI1Index)
+	if !ok {
+		return errors.Errorf("Annotations can only be changed in OCI indexes, not in %q", list.MIMEType())
+	}
+	annotations, err := e.apply(index.Annotations, nil)
+	if err != nil {
+		return err
+	}
+	index.Annotations = annotations
+	return nil
+}
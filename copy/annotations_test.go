@@ -0,0 +1,117 @@
+package copy
+
+import (
+	"context"
+	"testing"
+
+	"github.com/containers/image/v5/manifest"
+	"github.com/containers/image/v5/types"
+	imgspecv1 "github.com/opencontainers/image-spec/specs-go/v1"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// annotationsImage is a types.Image implementing only the methods used by annotationEditor.updateManifest.
+type annotationsImage struct {
+	types.Image
+	mimeType string
+	config   *imgspecv1.Image
+}
+
+func (i annotationsImage) Manifest(ctx context.Context) ([]byte, string, error) {
+	return nil, i.mimeType, nil
+}
+func (i annotationsImage) OCIConfig(context.Context) (*imgspecv1.Image, error) {
+	return i.config, nil
+}
+
+func TestNewAnnotationEditor(t *testing.T) {
+	_, err := newAnnotationEditor(&AnnotationChanges{Set: map[string]string{"a": "{{.Metadata.version}}"}})
+	assert.NoError(t, err)
+	_, err = newAnnotationEditor(&AnnotationChanges{Set: map[string]string{"a": "{{.Metadata.version"}})
+	assert.Error(t, err)
+}
+
+func TestAnnotationEditorApply(t *testing.T) {
+	e, err := newAnnotationEditor(&AnnotationChanges{
+		Set: map[string]string{
+			"org.opencontainers.image.version": "{{.Metadata.version}}",
+			"org.example.arch":                 "{{with .Platform}}{{.Architecture}}{{end}}",
+		},
+		Remove:   []string{"org.example.remove", "org.example.missing"},
+		Metadata: map[string]string{"version": "1.2.3"},
+	})
+	require.NoError(t, err)
+
+	res, err := e.apply(map[string]string{
+		"org.example.keep":                 "kept",
+		"org.example.remove":               "removed",
+		"org.opencontainers.image.version": "old",
+	}, &imgspecv1.Platform{Architecture: "arm64", OS: "linux"})
+	require.NoError(t, err)
+	assert.Equal(t, map[string]string{
+		"org.example.keep":                 "kept",
+		"org.opencontainers.image.version": "1.2.3",
+		"org.example.arch":                 "arm64",
+	}, res)
+
+	res, err = e.apply(nil, nil)
+	require.NoError(t, err)
+	assert.Equal(t, map[string]string{
+		"org.opencontainers.image.version": "1.2.3",
+		"org.example.arch":                 "",
+	}, res)
+
+	// Removing all annotations results in a nil map
+	e, err = newAnnotationEditor(&AnnotationChanges{Remove: []string{"a"}})
+	require.NoError(t, err)
+	res, err = e.apply(map[string]string{"a": "b"}, nil)
+	require.NoError(t, err)
+	assert.Nil(t, res)
+
+	// Missing metadata is an error
+	e, err = newAnnotationEditor(&AnnotationChanges{Set: map[string]string{"a": "{{.Metadata.version}}"}})
+	require.NoError(t, err)
+	_, err = e.apply(nil, nil)
+	assert.Error(t, err)
+}
+
+func TestAnnotationEditorUpdateManifest(t *testing.T) {
+	e, err := newAnnotationEditor(&AnnotationChanges{
+		Set: map[string]string{"org.example.platform": "{{.Platform.OS}}/{{.Platform.Architecture}}"},
+	})
+	require.NoError(t, err)
+	img := annotationsImage{
+		mimeType: imgspecv1.MediaTypeImageManifest,
+		config:   &imgspecv1.Image{Architecture: "amd64", OS: "linux"},
+	}
+	original := []byte(`{"schemaVersion":2,"mediaType":"application/vnd.oci.image.manifest.v1+json",` +
+		`"config":{"mediaType":"application/vnd.oci.image.config.v1+json","digest":"sha256:a8b1b2d8a1e5d4f1a3f9a4e3a6f8f1d0c3e8c6a5b4d3e2f1a0b9c8d7e6f5a4b3","size":2},` +
+		`"layers":[]}`)
+	updated, err := e.updateManifest(context.Background(), img, original)
+	require.NoError(t, err)
+	m, err := manifest.OCI1FromManifest(updated)
+	require.NoError(t, err)
+	assert.Equal(t, map[string]string{"org.example.platform": "linux/amd64"}, m.Annotations)
+
+	// Non-OCI manifests are rejected
+	img.mimeType = manifest.DockerV2Schema2MediaType
+	_, err = e.updateManifest(context.Background(), img, original)
+	assert.Error(t, err)
+}
+
+func TestAnnotationEditorUpdateList(t *testing.T) {
+	e, err := newAnnotationEditor(&AnnotationChanges{
+		Set:      map[string]string{"org.opencontainers.image.revision": "{{.Metadata.revision}}"},
+		Metadata: map[string]string{"revision": "abcdef"},
+	})
+	require.NoError(t, err)
+
+	index := manifest.OCI1IndexFromComponents(nil, nil)
+	err = e.updateList(index)
+	require.NoError(t, err)
+	assert.Equal(t, map[string]string{"org.opencontainers.image.revision": "abcdef"}, index.Annotations)
+
+	err = e.updateList(manifest.Schema2ListFromComponents(nil))
+	assert.Error(t, err)
+}
@@ -0,0 +1,35 @@
+package copy
+
+import (
+	"github.com/containers/image/v5/manifest"
+	imgspecv1 "github.com/opencontainers/image-spec/specs-go/v1"
+	"github.com/pkg/errors"
+)
+
+// nonImageArtifactConfigType returns the config media type of man, a manifest with mimeType, if it is an OCI artifact
+// which is not a container image (e.g. a Helm chart, a WASM module, or an artifact using the image-spec v1.1 empty
+// config descriptor); "" if it describes a container image.
+func nonImageArtifactConfigType(man []byte, mimeType string) (string, error) {
+	if manifest.NormalizedMIMEType(mimeType) != imgspecv1.MediaTypeImageManifest {
+		return "", nil
+	}
+	m, err := manifest.OCI1FromManifest(man)
+	if err != nil {
+		return "", errors.Wrap(err, "parsing manifest")
+	}
+	if m.IsImage() {
+		return "", nil
+	}
+	return m.Config.MediaType, nil
+}
+
+// isImageConfigMediaType returns true if mediaType is the media type of a container image config.
+func isImageConfigMediaType(mediaType string) bool {
+	return mediaType == imgspecv1.MediaTypeImageConfig || mediaType == manifest.DockerV2Schema2ConfigMediaType
+}
+
+// mustPreserveLayer returns true if layers with mediaType must be copied byte-for-byte, because the source is
+// a non-image artifact, or because of Options.StrictMediaTypePreservation.
+func (ic *imageCopier) mustPreserveLayer(mediaType string) bool {
+	return ic.artifactConfigType != "" || (ic.c.strictMediaTypePreservation && !isKnownLayerMediaType(mediaType))
+}
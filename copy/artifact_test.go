@@ -0,0 +1,102 @@
+package copy
+
+import (
+	"context"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/containers/image/v5/manifest"
+	"github.com/containers/image/v5/oci/layout"
+	"github.com/containers/image/v5/pkg/compression"
+	"github.com/containers/image/v5/signature"
+	"github.com/containers/image/v5/types"
+	digest "github.com/opencontainers/go-digest"
+	imgspecv1 "github.com/opencontainers/image-spec/specs-go/v1"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// newTestOCIArtifact creates an OCI layout containing a WASM module artifact, using the image-spec v1.1 empty config
+// descriptor, and returns a reference to it and its manifest.
+func newTestOCIArtifact(t *testing.T) (types.ImageReference, []byte) {
+	config := []byte("{}")
+	layer := []byte("\x00asm\x01\x00\x00\x00")
+	m := manifest.OCI1FromComponents(imgspecv1.Descriptor{
+		MediaType: "application/vnd.oci.empty.v1+json",
+		Digest:    digest.FromBytes(config),
+		Size:      int64(len(config)),
+	}, []imgspecv1.Descriptor{{
+		MediaType: "application/vnd.wasm.content.layer.v1+wasm",
+		Digest:    digest.FromBytes(layer),
+		Size:      int64(len(layer)),
+	}})
+	m.ArtifactType = "application/vnd.wasm.config.v1+json"
+	man, err := m.Serialize()
+	require.NoError(t, err)
+	srcDir := t.TempDir()
+	writeOCILayout(t, srcDir, "src", man)
+	for _, blob := range [][]byte{config, layer} {
+		d := digest.FromBytes(blob)
+		require.NoError(t, os.WriteFile(filepath.Join(srcDir, "blobs", d.Algorithm().String(), d.Hex()), blob, 0644))
+	}
+	srcRef, err := layout.NewReference(srcDir, "src")
+	require.NoError(t, err)
+	return srcRef, man
+}
+
+func TestCopyNonImageArtifact(t *testing.T) {
+	srcRef, srcManifest := newTestOCIArtifact(t)
+	policyContext, err := signature.NewPolicyContext(&signature.Policy{Default: []signature.PolicyRequirement{signature.NewPRInsecureAcceptAnything()}})
+	require.NoError(t, err)
+	defer func() { _ = policyContext.Destroy() }()
+
+	// The artifact is copied unmodified, although the destination compresses layers by default,
+	// and a reproducible timestamp would modify an image config.
+	timestamp := time.Unix(0, 0)
+	for _, options := range []*Options{
+		{},
+		{Reproducible: &ReproducibleOptions{Timestamp: &timestamp}},
+		{LayerCompressionPolicy: func(LayerCompressionInfo) LayerCompression {
+			return LayerCompression{Format: &compression.Zstd}
+		}},
+	} {
+		destRef, err := layout.NewReference(t.TempDir(), "dest")
+		require.NoError(t, err)
+		res, err := ImageWithResult(context.Background(), policyContext, destRef, srcRef, options)
+		require.NoError(t, err)
+		assert.Equal(t, srcManifest, res.Manifest)
+	}
+
+	// The artifact can't be converted to other manifest formats
+	destRef, err := layout.NewReference(t.TempDir(), "dest")
+	require.NoError(t, err)
+	_, err = Image(context.Background(), policyContext, destRef, srcRef, &Options{ForceManifestMIMEType: manifest.DockerV2Schema2MediaType})
+	assert.ErrorContains(t, err, `"application/vnd.oci.empty.v1+json"`)
+
+	// The artifact can't be encrypted
+	_, err = Image(context.Background(), policyContext, destRef, srcRef, &Options{OciEncryptLayers: &[]int{}})
+	assert.Error(t, err)
+}
+
+func TestNonImageArtifactConfigType(t *testing.T) {
+	_, artifact := newTestOCIArtifact(t)
+	res, err := nonImageArtifactConfigType(artifact, imgspecv1.MediaTypeImageManifest)
+	require.NoError(t, err)
+	assert.Equal(t, "application/vnd.oci.empty.v1+json", res)
+
+	for _, c := range []struct{ fixture, mimeType string }{
+		{"../../manifest/fixtures/ociv1.manifest.json", imgspecv1.MediaTypeImageManifest},
+		{"../../manifest/fixtures/v2s2.manifest.json", manifest.DockerV2Schema2MediaType},
+	} {
+		man, err := os.ReadFile(filepath.Join("fixtures", c.fixture))
+		require.NoError(t, err)
+		res, err := nonImageArtifactConfigType(man, c.mimeType)
+		require.NoError(t, err)
+		assert.Equal(t, "", res, c.fixture)
+	}
+
+	_, err = nonImageArtifactConfigType([]byte("invalid"), imgspecv1.MediaTypeImageManifest)
+	assert.Error(t, err)
+}
@@ -0,0 +1,110 @@
+package copy
+
+import (
+	"context"
+	"io"
+
+	"github.com/containers/image/v5/types"
+	"github.com/opencontainers/go-digest"
+	"github.com/pkg/errors"
+	"github.com/sirupsen/logrus"
+)
+
+// BlobExporter receives copies of layer blobs as they are written to the destination, e.g. to also store them in
+// an external content-addressable store, without reading them from the source a second time.
+// Only layers which are read from the source are exported; layers which are reused (e.g. because they already exist
+// at the destination) or pulled partially are not.
+type BlobExporter interface {
+	// ExportBlob is called when writing a layer to the destination starts.  info describes the blob as far as it is known
+	// at that point; in particular, its Digest is "" if the layer is being modified, e.g. compressed.
+	// It returns a BlobExportWriter to receive the contents of the blob, or nil if the blob should not be exported
+	// (e.g. because the store already contains it).
+	// ExportBlob may be called concurrently for different layers.
+	ExportBlob(ctx context.Context, info types.BlobInfo) (BlobExportWriter, error)
+}
+
+// BlobExportWriter receives the contents of a single blob, as written to the destination.
+// Errors returned by Write or Commit cause the copy to fail.
+type BlobExportWriter interface {
+	io.Writer
+	// Commit is called after all of the blob has been written, and both the data read from the source and the data written
+	// to the destination have been verified to match their digests.  info describes the blob as written to the destination.
+	Commit(info types.BlobInfo) error
+	// Abort is called instead of Commit if the blob is not exported completely, e.g. because the copy failed,
+	// or because the destination did not read all of the blob.
+	Abort()
+}
+
+// blobExport is an io.Reader which passes the data read from a blob stream to a BlobExportWriter.
+type blobExport struct {
+	source   io.Reader
+	writer   BlobExportWriter
+	digester digest.Digester
+	size     int64
+	eof      bool
+	done     bool // Commit or Abort has been called
+}
+
+// newBlobExport returns a blobExport exporting the contents of stream, a blob with info, using c.blobExporter,
+// or nil if the blob should not be exported.
+func (c *copier) newBlobExport(ctx context.Context, stream io.Reader, info types.BlobInfo) (*blobExport, error) {
+	writer, err := c.blobExporter.ExportBlob(ctx, info)
+	if err != nil {
+		return nil, errors.Wrap(err, "preparing to export blob")
+	}
+	if writer == nil {
+		return nil, nil
+	}
+	return &blobExport{
+		source:   stream,
+		writer:   writer,
+		digester: digest.Canonical.Digester(),
+	}, nil
+}
+
+// Read implements io.Reader.
+func (e *blobExport) Read(p []byte) (int, error) {
+	n, err := e.source.Read(p)
+	if n > 0 {
+		if _, err := e.writer.Write(p[:n]); err != nil {
+			return n, errors.Wrap(err, "exporting blob")
+		}
+		_, _ = e.digester.Hash().Write(p[:n]) // Writing to a hash never fails
+		e.size += int64(n)
+	}
+	if err == io.EOF {
+		e.eof = true
+	}
+	return n, err
+}
+
+// commit calls Commit on the BlobExportWriter if all of the blob has been exported, the source data has been verified
+// (as indicated by sourceVerified), and the exported data matches uploadedInfo; otherwise it calls Abort.
+func (e *blobExport) commit(uploadedInfo types.BlobInfo, sourceVerified bool) error {
+	e.done = true
+	switch {
+	case !e.eof:
+		logrus.Debugf("Not exporting blob %s: the destination did not read all of it", uploadedInfo.Digest)
+	case !sourceVerified:
+		logrus.Debugf("Not exporting blob %s: the source data was not verified", uploadedInfo.Digest)
+	case e.digester.Digest() != uploadedInfo.Digest || (uploadedInfo.Size != -1 && e.size != uploadedInfo.Size):
+		logrus.Debugf("Not exporting blob %s: the exported data has digest %s, size %d", uploadedInfo.Digest, e.digester.Digest(), e.size)
+	default:
+		info := uploadedInfo
+		info.Size = e.size
+		if err := e.writer.Commit(info); err != nil {
+			return errors.Wrapf(err, "exporting blob %s", uploadedInfo.Digest)
+		}
+		return nil
+	}
+	e.writer.Abort()
+	return nil
+}
+
+// abortUnlessDone calls Abort on the BlobExportWriter if neither Commit nor Abort has been called.
+func (e *blobExport) abortUnlessDone() {
+	if !e.done {
+		e.done = true
+		e.writer.Abort()
+	}
+}
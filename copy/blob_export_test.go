@@ -0,0 +1,185 @@
+package copy
+
+import (
+	"bytes"
+	"context"
+	"io"
+	"os"
+	"path/filepath"
+	"sync"
+	"testing"
+
+	"github.com/containers/image/v5/manifest"
+	"github.com/containers/image/v5/oci/layout"
+	"github.com/containers/image/v5/signature"
+	"github.com/containers/image/v5/types"
+	digest "github.com/opencontainers/go-digest"
+	imgspecv1 "github.com/opencontainers/image-spec/specs-go/v1"
+	"github.com/pkg/errors"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// memoryBlobExporter is a BlobExporter which stores blobs in memory.
+type memoryBlobExporter struct {
+	lock      sync.Mutex
+	skip      map[digest.Digest]bool // Digests of blobs to not export
+	failWrite bool
+	started   []types.BlobInfo
+	blobs     map[digest.Digest][]byte
+	aborted   int
+}
+
+type memoryBlobExportWriter struct {
+	exporter *memoryBlobExporter
+	buf      bytes.Buffer
+}
+
+func (e *memoryBlobExporter) ExportBlob(ctx context.Context, info types.BlobInfo) (BlobExportWriter, error) {
+	e.lock.Lock()
+	defer e.lock.Unlock()
+	e.started = append(e.started, info)
+	if e.skip[info.Digest] {
+		return nil, nil
+	}
+	return &memoryBlobExportWriter{exporter: e}, nil
+}
+
+func (w *memoryBlobExportWriter) Write(p []byte) (int, error) {
+	if w.exporter.failWrite {
+		return 0, errors.New("store failed")
+	}
+	return w.buf.Write(p)
+}
+
+func (w *memoryBlobExportWriter) Commit(info types.BlobInfo) error {
+	w.exporter.lock.Lock()
+	defer w.exporter.lock.Unlock()
+	w.exporter.blobs[info.Digest] = w.buf.Bytes()
+	return nil
+}
+
+func (w *memoryBlobExportWriter) Abort() {
+	w.exporter.lock.Lock()
+	defer w.exporter.lock.Unlock()
+	w.exporter.aborted++
+}
+
+func TestBlobExporter(t *testing.T) {
+	config := []byte(`{"architecture":"amd64","os":"linux","rootfs":{"type":"layers","diff_ids":[]}}`)
+	layer := []byte("uncompressed layer contents")
+	configDigest, layerDigest := digest.FromBytes(config), digest.FromBytes(layer)
+	m := manifest.OCI1FromComponents(imgspecv1.Descriptor{
+		MediaType: imgspecv1.MediaTypeImageConfig,
+		Digest:    configDigest,
+		Size:      int64(len(config)),
+	}, []imgspecv1.Descriptor{{
+		MediaType: imgspecv1.MediaTypeImageLayer,
+		Digest:    layerDigest,
+		Size:      int64(len(layer)),
+	}})
+	m.SchemaVersion = 2
+	man, err := m.Serialize()
+	require.NoError(t, err)
+
+	srcDir := t.TempDir()
+	writeOCILayout(t, srcDir, "src", man)
+	for _, blob := range [][]byte{config, layer} {
+		d := digest.FromBytes(blob)
+		require.NoError(t, os.WriteFile(filepath.Join(srcDir, "blobs", d.Algorithm().String(), d.Hex()), blob, 0644))
+	}
+	srcRef, err := layout.NewReference(srcDir, "src")
+	require.NoError(t, err)
+	policyContext, err := signature.NewPolicyContext(&signature.Policy{Default: []signature.PolicyRequirement{signature.NewPRInsecureAcceptAnything()}})
+	require.NoError(t, err)
+	defer func() { _ = policyContext.Destroy() }()
+
+	copyWithExporter := func(destDir string, exporter *memoryBlobExporter) error {
+		destRef, err := layout.NewReference(destDir, "dest")
+		require.NoError(t, err)
+		_, err = Image(context.Background(), policyContext, destRef, srcRef, &Options{BlobExporter: exporter})
+		return err
+	}
+
+	// The layer is compressed by the destination; the compressed blob, as written, is exported.  The config is not exported.
+	destDir := t.TempDir()
+	exporter := &memoryBlobExporter{blobs: map[digest.Digest][]byte{}}
+	err = copyWithExporter(destDir, exporter)
+	require.NoError(t, err)
+	require.Len(t, exporter.started, 1)
+	assert.Equal(t, digest.Digest(""), exporter.started[0].Digest)
+	require.Len(t, exporter.blobs, 1)
+	for d, blob := range exporter.blobs {
+		assert.NotEqual(t, layerDigest, d)
+		assert.Equal(t, d, digest.FromBytes(blob))
+		written, err := os.ReadFile(filepath.Join(destDir, "blobs", d.Algorithm().String(), d.Hex()))
+		require.NoError(t, err)
+		assert.Equal(t, written, blob)
+	}
+	assert.Equal(t, 0, exporter.aborted)
+
+	// A layer which already exists in the destination is reused, and not exported.
+	destDir = t.TempDir()
+	require.NoError(t, os.MkdirAll(filepath.Join(destDir, "blobs", layerDigest.Algorithm().String()), 0755))
+	require.NoError(t, os.WriteFile(filepath.Join(destDir, "blobs", layerDigest.Algorithm().String(), layerDigest.Hex()), layer, 0644))
+	exporter = &memoryBlobExporter{blobs: map[digest.Digest][]byte{}}
+	err = copyWithExporter(destDir, exporter)
+	require.NoError(t, err)
+	assert.Empty(t, exporter.started)
+	assert.Empty(t, exporter.blobs)
+
+	// The exporter can decline to export a blob.
+	exporter = &memoryBlobExporter{blobs: map[digest.Digest][]byte{}, skip: map[digest.Digest]bool{"": true}}
+	err = copyWithExporter(t.TempDir(), exporter)
+	require.NoError(t, err)
+	assert.Len(t, exporter.started, 1)
+	assert.Empty(t, exporter.blobs)
+
+	// Export failures fail the copy.
+	exporter = &memoryBlobExporter{blobs: map[digest.Digest][]byte{}, failWrite: true}
+	err = copyWithExporter(t.TempDir(), exporter)
+	assert.Error(t, err)
+	assert.Empty(t, exporter.blobs)
+	assert.Equal(t, 1, exporter.aborted)
+}
+
+func TestBlobExportCommit(t *testing.T) {
+	data := []byte("data")
+	info := types.BlobInfo{Digest: digest.FromBytes(data), Size: int64(len(data))}
+	c := &copier{}
+	for _, tc := range []struct {
+		readAll        bool
+		sourceVerified bool
+		info           types.BlobInfo
+		committed      bool
+	}{
+		{true, true, info, true},
+		{true, true, types.BlobInfo{Digest: info.Digest, Size: -1}, true},
+		{false, true, info, false},
+		{true, false, info, false},
+		{true, true, types.BlobInfo{Digest: digest.FromString("other"), Size: info.Size}, false},
+		{true, true, types.BlobInfo{Digest: info.Digest, Size: 1}, false},
+	} {
+		exporter := &memoryBlobExporter{blobs: map[digest.Digest][]byte{}}
+		c.blobExporter = exporter
+		export, err := c.newBlobExport(context.Background(), bytes.NewReader(data), types.BlobInfo{Digest: info.Digest, Size: -1})
+		require.NoError(t, err)
+		require.NotNil(t, export)
+		if tc.readAll {
+			_, err = io.Copy(io.Discard, export)
+		} else {
+			_, err = export.Read(make([]byte, 2))
+		}
+		require.NoError(t, err)
+		err = export.commit(tc.info, tc.sourceVerified)
+		require.NoError(t, err)
+		export.abortUnlessDone()
+		if tc.committed {
+			assert.Equal(t, map[digest.Digest][]byte{info.Digest: data}, exporter.blobs, "%#v", tc)
+			assert.Equal(t, 0, exporter.aborted, "%#v", tc)
+		} else {
+			assert.Empty(t, exporter.blobs, "%#v", tc)
+			assert.Equal(t, 1, exporter.aborted, "%#v", tc)
+		}
+	}
+}
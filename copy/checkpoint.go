@@ -0,0 +1,159 @@
+package copy
+
+import (
+	"context"
+	"encoding/json"
+	"os"
+	"path/filepath"
+	"sync"
+
+	"github.com/containers/image/v5/internal/private"
+	"github.com/containers/image/v5/pkg/compression"
+	"github.com/containers/image/v5/transports"
+	"github.com/containers/image/v5/types"
+	"github.com/containers/storage/pkg/ioutils"
+	digest "github.com/opencontainers/go-digest"
+	"github.com/pkg/errors"
+	"github.com/sirupsen/logrus"
+)
+
+// checkpoint records the layers written to the destination by a copy in Options.CheckpointFile, so that
+// an interrupted copy can be resumed without copying them again.
+type checkpoint struct {
+	path  string
+	lock  sync.Mutex // Protects state, and serializes writes to path
+	state checkpointState
+}
+
+// checkpointState is the JSON representation of a checkpoint file.
+type checkpointState struct {
+	Source      string                           `json:"source"`      // transports.ImageName of the source
+	Destination string                           `json:"destination"` // transports.ImageName of the destination
+	Blobs       map[digest.Digest]checkpointBlob `json:"blobs"`       // Keyed by the digest of the source layer
+}
+
+// checkpointBlob is a layer written to the destination.
+type checkpointBlob struct {
+	Digest               digest.Digest          `json:"digest"`
+	Size                 int64                  `json:"size"`
+	MediaType            string                 `json:"mediaType,omitempty"`
+	CompressionOperation types.LayerCompression `json:"compressionOperation,omitempty"`
+	CompressionAlgorithm string                 `json:"compressionAlgorithm,omitempty"` // The name of a compression algorithm, if any
+}
+
+// openCheckpoint returns a checkpoint for a copy from srcRef to destRef recorded in path, or nil if path is "".
+// A checkpoint file recorded for a different copy is ignored, and replaced as the copy progresses.
+func openCheckpoint(path string, srcRef, destRef types.ImageReference) (*checkpoint, error) {
+	if path == "" {
+		return nil, nil
+	}
+	res := &checkpoint{
+		path: path,
+		state: checkpointState{
+			Source:      transports.ImageName(srcRef),
+			Destination: transports.ImageName(destRef),
+			Blobs:       map[digest.Digest]checkpointBlob{},
+		},
+	}
+	data, err := os.ReadFile(path)
+	if err != nil {
+		if os.IsNotExist(err) {
+			return res, nil
+		}
+		return nil, errors.Wrapf(err, "reading copy checkpoint")
+	}
+	var state checkpointState
+	if err := json.Unmarshal(data, &state); err != nil {
+		return nil, errors.Wrapf(err, "parsing copy checkpoint %s", path)
+	}
+	if state.Source != res.state.Source || state.Destination != res.state.Destination {
+		logrus.Infof("Ignoring copy checkpoint %s recorded for a copy from %s to %s", path, state.Source, state.Destination)
+		return res, nil
+	}
+	for srcDigest, blob := range state.Blobs {
+		res.state.Blobs[srcDigest] = blob
+	}
+	logrus.Debugf("Resuming copy using checkpoint %s with %d layers", path, len(res.state.Blobs))
+	return res, nil
+}
+
+// tryReusingBlob returns a BlobInfo for a layer recorded in c as a copy of srcInfo, and true, if dest still contains it.
+// It returns false if c is nil.
+func (c *checkpoint) tryReusingBlob(ctx context.Context, dest private.ImageDestination, srcInfo types.BlobInfo, options private.TryReusingBlobOptions) (types.BlobInfo, bool, error) {
+	if c == nil {
+		return types.BlobInfo{}, false, nil
+	}
+	c.lock.Lock()
+	blob, ok := c.state.Blobs[srcInfo.Digest]
+	c.lock.Unlock()
+	if !ok {
+		return types.BlobInfo{}, false, nil
+	}
+	candidate := types.BlobInfo{
+		Digest:               blob.Digest,
+		Size:                 blob.Size,
+		MediaType:            blob.MediaType,
+		CompressionOperation: blob.CompressionOperation,
+	}
+	if blob.CompressionAlgorithm != "" {
+		algo, err := compression.AlgorithmByName(blob.CompressionAlgorithm)
+		if err != nil {
+			logrus.Debugf("Ignoring checkpoint of layer %s: %v", srcInfo.Digest, err)
+			return types.BlobInfo{}, false, nil
+		}
+		candidate.CompressionAlgorithm = &algo
+	}
+	// Only reuse exactly the recorded blob; the destination may have removed it since it was recorded.
+	options.CanSubstitute = false
+	reused, _, err := dest.TryReusingBlobWithOptions(ctx, candidate, options)
+	if err != nil || !reused {
+		return types.BlobInfo{}, false, err
+	}
+	candidate.Annotations = srcInfo.Annotations
+	return candidate, true, nil
+}
+
+// recordBlob records in c that destInfo was written to the destination as a copy of the layer with srcDigest.
+// It does nothing if c is nil; failures to update the checkpoint file are only logged.
+func (c *checkpoint) recordBlob(srcDigest digest.Digest, destInfo types.BlobInfo) {
+	if c == nil || srcDigest == "" || destInfo.Digest == "" {
+		return
+	}
+	blob := checkpointBlob{
+		Digest:               destInfo.Digest,
+		Size:                 destInfo.Size,
+		MediaType:            destInfo.MediaType,
+		CompressionOperation: destInfo.CompressionOperation,
+	}
+	if destInfo.CompressionAlgorithm != nil {
+		blob.CompressionAlgorithm = destInfo.CompressionAlgorithm.Name()
+	}
+
+	c.lock.Lock()
+	defer c.lock.Unlock()
+	c.state.Blobs[srcDigest] = blob
+	data, err := json.Marshal(c.state)
+	if err != nil {
+		logrus.Warnf("Error marshaling copy checkpoint: %v", err)
+		return
+	}
+	if err := os.MkdirAll(filepath.Dir(c.path), 0700); err != nil {
+		logrus.Warnf("Error creating copy checkpoint directory: %v", err)
+		return
+	}
+	if err := ioutils.AtomicWriteFile(c.path, data, 0600); err != nil {
+		logrus.Warnf("Error writing copy checkpoint %s: %v", c.path, err)
+	}
+}
+
+// remove removes the checkpoint file of c, after the copy has succeeded.  It does nothing if c is nil.
+func (c *checkpoint) remove() {
+	if c == nil {
+		return
+	}
+	c.lock.Lock()
+	defer c.lock.Unlock()
+	if err := os.Remove(c.path); err != nil && !os.IsNotExist(err) {
+		logrus.Warnf("Error removing copy checkpoint %s: %v", c.path, err)
+	}
+}
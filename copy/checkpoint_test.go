@@ -0,0 +1,146 @@
+package copy
+
+import (
+	"context"
+	"errors"
+	"io"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/containers/image/v5/manifest"
+	"github.com/containers/image/v5/oci/layout"
+	"github.com/containers/image/v5/signature"
+	"github.com/containers/image/v5/types"
+	digest "github.com/opencontainers/go-digest"
+	imgspecv1 "github.com/opencontainers/image-spec/specs-go/v1"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// putBlobCountingReference is a types.ImageReference whose destinations count PutBlob calls,
+// and fail the call number failAt, if not 0.
+type putBlobCountingReference struct {
+	types.ImageReference
+	calls  int
+	failAt int
+}
+
+func (ref *putBlobCountingReference) NewImageDestination(ctx context.Context, sys *types.SystemContext) (types.ImageDestination, error) {
+	dest, err := ref.ImageReference.NewImageDestination(ctx, sys)
+	if err != nil {
+		return nil, err
+	}
+	return &putBlobCountingDestination{ImageDestination: dest, ref: ref}, nil
+}
+
+type putBlobCountingDestination struct {
+	types.ImageDestination
+	ref *putBlobCountingReference
+}
+
+func (d *putBlobCountingDestination) PutBlob(ctx context.Context, stream io.Reader, inputInfo types.BlobInfo, cache types.BlobInfoCache, isConfig bool) (types.BlobInfo, error) {
+	d.ref.calls++
+	if d.ref.calls == d.ref.failAt {
+		return types.BlobInfo{}, errors.New("simulated network failure")
+	}
+	return d.ImageDestination.PutBlob(ctx, stream, inputInfo, cache, isConfig)
+}
+
+func TestCopyCheckpoint(t *testing.T) {
+	config := []byte(`{"architecture":"amd64","os":"linux","rootfs":{"type":"layers","diff_ids":[]}}`)
+	layers := [][]byte{[]byte("layer 1"), []byte("layer 2")}
+	srcDir := t.TempDir()
+	require.NoError(t, os.MkdirAll(filepath.Join(srcDir, "blobs", "sha256"), 0755))
+	descriptors := []imgspecv1.Descriptor{}
+	for _, blob := range append([][]byte{config}, layers...) {
+		d := digest.FromBytes(blob)
+		require.NoError(t, os.WriteFile(filepath.Join(srcDir, "blobs", d.Algorithm().String(), d.Hex()), blob, 0644))
+		descriptors = append(descriptors, imgspecv1.Descriptor{MediaType: imgspecv1.MediaTypeImageLayer, Digest: d, Size: int64(len(blob))})
+	}
+	descriptors[0].MediaType = imgspecv1.MediaTypeImageConfig
+	m := manifest.OCI1FromComponents(descriptors[0], descriptors[1:])
+	m.SchemaVersion = 2
+	man, err := m.Serialize()
+	require.NoError(t, err)
+	writeOCILayout(t, srcDir, "src", man)
+	srcRef, err := layout.NewReference(srcDir, "src")
+	require.NoError(t, err)
+	policyContext, err := signature.NewPolicyContext(&signature.Policy{Default: []signature.PolicyRequirement{signature.NewPRInsecureAcceptAnything()}})
+	require.NoError(t, err)
+	defer func() { _ = policyContext.Destroy() }()
+
+	layoutRef, err := layout.NewReference(t.TempDir(), "dest")
+	require.NoError(t, err)
+	checkpointFile := filepath.Join(t.TempDir(), "checkpoint.json")
+	options := func() *Options {
+		// Use a new blob info cache for every copy, so that only the checkpoint allows reusing the compressed layers.
+		return &Options{
+			CheckpointFile: checkpointFile,
+			DestinationCtx: &types.SystemContext{BlobInfoCacheDir: t.TempDir()},
+		}
+	}
+
+	// The copy of the second layer fails
+	destRef := &putBlobCountingReference{ImageReference: layoutRef, failAt: 2}
+	_, err = Image(context.Background(), policyContext, destRef, srcRef, options())
+	require.Error(t, err)
+	cp, err := openCheckpoint(checkpointFile, srcRef, destRef)
+	require.NoError(t, err)
+	require.Len(t, cp.state.Blobs, 1)
+	layer1 := cp.state.Blobs[descriptors[1].Digest]
+	assert.NotEqual(t, descriptors[1].Digest, layer1.Digest) // The layer was compressed
+	assert.Equal(t, "gzip", layer1.CompressionAlgorithm)
+
+	// Resuming the copy does not copy the first layer again
+	destRef = &putBlobCountingReference{ImageReference: layoutRef}
+	copiedManifest, err := Image(context.Background(), policyContext, destRef, srcRef, options())
+	require.NoError(t, err)
+	assert.Equal(t, 2, destRef.calls) // The second layer, and the config
+	copied, err := manifest.OCI1FromManifest(copiedManifest)
+	require.NoError(t, err)
+	require.Len(t, copied.Layers, 2)
+	assert.Equal(t, layer1.Digest, copied.Layers[0].Digest)
+	assert.Equal(t, imgspecv1.MediaTypeImageLayerGzip, copied.Layers[0].MediaType)
+	// The checkpoint is removed after a successful copy
+	_, err = os.Stat(checkpointFile)
+	assert.True(t, os.IsNotExist(err))
+}
+
+func TestOpenCheckpoint(t *testing.T) {
+	srcRef, err := layout.NewReference(t.TempDir(), "src")
+	require.NoError(t, err)
+	destRef, err := layout.NewReference(t.TempDir(), "dest")
+	require.NoError(t, err)
+	path := filepath.Join(t.TempDir(), "checkpoint.json")
+
+	// No checkpoint file
+	cp, err := openCheckpoint("", srcRef, destRef)
+	require.NoError(t, err)
+	assert.Nil(t, cp)
+	cp.recordBlob(digest.FromString("src"), types.BlobInfo{Digest: digest.FromString("dest")}) // Does not crash
+	cp.remove()
+
+	// A missing file is not an error
+	cp, err = openCheckpoint(path, srcRef, destRef)
+	require.NoError(t, err)
+	assert.Empty(t, cp.state.Blobs)
+	cp.recordBlob(digest.FromString("src"), types.BlobInfo{Digest: digest.FromString("dest"), Size: 4})
+
+	// The recorded state is loaded
+	cp, err = openCheckpoint(path, srcRef, destRef)
+	require.NoError(t, err)
+	assert.Equal(t, map[digest.Digest]checkpointBlob{
+		digest.FromString("src"): {Digest: digest.FromString("dest"), Size: 4},
+	}, cp.state.Blobs)
+
+	// A checkpoint of a different copy is ignored
+	cp, err = openCheckpoint(path, destRef, srcRef)
+	require.NoError(t, err)
+	assert.Empty(t, cp.state.Blobs)
+
+	// Invalid files are rejected
+	require.NoError(t, os.WriteFile(path, []byte("{"), 0600))
+	_, err = openCheckpoint(path, srcRef, destRef)
+	assert.Error(t, err)
+}
@@ -0,0 +1,54 @@
+package copy
+
+import (
+	compressiontypes "github.com/containers/image/v5/pkg/compression/types"
+	"github.com/opencontainers/go-digest"
+	"github.com/sirupsen/logrus"
+)
+
+// LayerCompressionInfo describes a layer for Options.LayerCompressionPolicy.
+type LayerCompressionInfo struct {
+	// Digest, Size (or -1 if unknown) and MediaType describe the layer in the source.
+	Digest     digest.Digest
+	Size       int64
+	MediaType  string
+	LayerIndex int
+	// SourceCompression is the compression algorithm detected in the source layer, or nil if it is not compressed.
+	SourceCompression *compressiontypes.Algorithm
+}
+
+// LayerCompression is the compression of a single layer, as chosen by Options.LayerCompressionPolicy.
+// The zero value uses DestinationCtx.CompressionFormat and DestinationCtx.CompressionLevel, as if there were no policy.
+type LayerCompression struct {
+	// If Preserve is set, the layer is written as it is in the source, without compressing, recompressing
+	// or decompressing it; Format and Level are ignored.
+	Preserve bool
+	// Format, if not nil, is the compression algorithm used if the layer is compressed, instead of DestinationCtx.CompressionFormat.
+	Format *compressiontypes.Algorithm
+	// Level, if not nil, is the compression level used if the layer is compressed, instead of DestinationCtx.CompressionLevel.
+	Level *int
+}
+
+// layerCompression returns the compression algorithm (or nil if not explicitly requested) and level to use for a layer
+// described by info, and whether the layer must be preserved unmodified, per c.layerCompressionPolicy.
+func (c *copier) layerCompression(info LayerCompressionInfo) (*compressiontypes.Algorithm, *int, bool) {
+	if c.layerCompressionPolicy == nil {
+		return c.compressionFormat, c.compressionLevel, false
+	}
+	choice := c.layerCompressionPolicy(info)
+	if choice.Preserve {
+		logrus.Debugf("Compression policy: preserving layer %s", info.Digest)
+		return nil, nil, true
+	}
+	format, level := c.compressionFormat, c.compressionLevel
+	if choice.Format != nil {
+		format = choice.Format
+	}
+	if choice.Level != nil {
+		level = choice.Level
+	}
+	if format != nil {
+		logrus.Debugf("Compression policy: using %s for layer %s", format.Name(), info.Digest)
+	}
+	return format, level, false
+}
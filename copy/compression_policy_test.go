@@ -0,0 +1,97 @@
+package copy
+
+import (
+	"context"
+	"sync"
+	"testing"
+
+	"github.com/containers/image/v5/manifest"
+	"github.com/containers/image/v5/oci/layout"
+	"github.com/containers/image/v5/pkg/compression"
+	compressiontypes "github.com/containers/image/v5/pkg/compression/types"
+	"github.com/containers/image/v5/signature"
+	"github.com/containers/image/v5/types"
+	imgspecv1 "github.com/opencontainers/image-spec/specs-go/v1"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestCopyLayerCompressionPolicy(t *testing.T) {
+	srcRef, _, layerDigest := newTestOCIImage(t)
+	policyContext, err := signature.NewPolicyContext(&signature.Policy{Default: []signature.PolicyRequirement{signature.NewPRInsecureAcceptAnything()}})
+	require.NoError(t, err)
+	defer func() { _ = policyContext.Destroy() }()
+
+	for _, c := range []struct {
+		name         string
+		choice       LayerCompression
+		destCtx      *types.SystemContext
+		expectedType string
+		expectedSame bool
+	}{
+		{"default", LayerCompression{}, nil, imgspecv1.MediaTypeImageLayerGzip, false},
+		{"default with a global format", LayerCompression{}, &types.SystemContext{CompressionFormat: &compression.Zstd},
+			imgspecv1.MediaTypeImageLayerZstd, false},
+		{"format", LayerCompression{Format: &compression.Zstd}, nil, imgspecv1.MediaTypeImageLayerZstd, false},
+		{"preserve", LayerCompression{Preserve: true}, &types.SystemContext{CompressionFormat: &compression.Zstd},
+			imgspecv1.MediaTypeImageLayer, true},
+	} {
+		var mutex sync.Mutex
+		infos := []LayerCompressionInfo{}
+		destRef, err := layout.NewReference(t.TempDir(), "dest")
+		require.NoError(t, err, c.name)
+		man, err := Image(context.Background(), policyContext, destRef, srcRef, &Options{
+			DestinationCtx: c.destCtx,
+			LayerCompressionPolicy: func(info LayerCompressionInfo) LayerCompression {
+				mutex.Lock()
+				defer mutex.Unlock()
+				infos = append(infos, info)
+				return c.choice
+			},
+		})
+		require.NoError(t, err, c.name)
+		require.Len(t, infos, 1, c.name)
+		assert.Equal(t, LayerCompressionInfo{
+			Digest:     layerDigest,
+			Size:       27,
+			MediaType:  imgspecv1.MediaTypeImageLayer,
+			LayerIndex: 0,
+		}, infos[0], c.name)
+		m, err := manifest.OCI1FromManifest(man)
+		require.NoError(t, err, c.name)
+		require.Len(t, m.Layers, 1, c.name)
+		assert.Equal(t, c.expectedType, m.Layers[0].MediaType, c.name)
+		assert.Equal(t, c.expectedSame, m.Layers[0].Digest == layerDigest, c.name)
+	}
+}
+
+func TestCopierLayerCompression(t *testing.T) {
+	level1, level2 := 1, 2
+	info := LayerCompressionInfo{Digest: "sha256:0000000000000000000000000000000000000000000000000000000000000000", Size: 1}
+
+	// No policy
+	c := &copier{compressionFormat: &compression.Gzip, compressionLevel: &level1}
+	format, level, preserve := c.layerCompression(info)
+	assert.Equal(t, &compression.Gzip, format)
+	assert.Equal(t, &level1, level)
+	assert.False(t, preserve)
+
+	for _, tc := range []struct {
+		choice           LayerCompression
+		expectedFormat   *compressiontypes.Algorithm
+		expectedLevel    *int
+		expectedPreserve bool
+	}{
+		{LayerCompression{}, &compression.Gzip, &level1, false},
+		{LayerCompression{Format: &compression.ZstdChunked}, &compression.ZstdChunked, &level1, false},
+		{LayerCompression{Level: &level2}, &compression.Gzip, &level2, false},
+		{LayerCompression{Format: &compression.Zstd, Level: &level2}, &compression.Zstd, &level2, false},
+		{LayerCompression{Preserve: true, Format: &compression.Zstd}, nil, nil, true},
+	} {
+		c.layerCompressionPolicy = func(LayerCompressionInfo) LayerCompression { return tc.choice }
+		format, level, preserve := c.layerCompression(info)
+		assert.Equal(t, tc.expectedFormat, format, tc.choice)
+		assert.Equal(t, tc.expectedLevel, level, tc.choice)
+		assert.Equal(t, tc.expectedPreserve, preserve, tc.choice)
+	}
+}
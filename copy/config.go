@@ -0,0 +1,173 @@
+package copy
+
+import (
+	"os"
+	"path/filepath"
+	"time"
+
+	"github.com/BurntSushi/toml"
+	"github.com/containers/image/v5/pkg/compression"
+	"github.com/containers/image/v5/types"
+	"github.com/containers/storage/pkg/homedir"
+	"github.com/pkg/errors"
+	"github.com/sirupsen/logrus"
+)
+
+// systemCopyConfPath is the path to the system-wide configuration file providing defaults for copy.Options.
+// You can override this at build time with
+// -ldflags '-X github.com/containers/image/v5/copy.systemCopyConfPath=$your_path'
+var systemCopyConfPath = builtinCopyConfPath
+
+// builtinCopyConfPath is the path to the copy defaults configuration file.
+// DO NOT change this, instead see systemCopyConfPath above.
+const builtinCopyConfPath = "/etc/containers/containers-copy.conf"
+
+// userCopyConfPath is the path to the per-user copy defaults configuration file, relative to the home directory.
+// If it exists, it is used instead of systemCopyConfPath.
+var userCopyConfPath = filepath.FromSlash(".config/containers/containers-copy.conf")
+
+// copyConf is the format of containers-copy.conf.
+type copyConf struct {
+	Copy copyConfDefaults `toml:"copy"`
+}
+
+// copyConfDefaults are the defaults for copy.Options, as specified in the [copy] table of containers-copy.conf.
+// Unset fields do not affect copy.Options.
+type copyConfDefaults struct {
+	// CompressionFormat is the name of the compression algorithm used for layers which are compressed during the copy.
+	CompressionFormat string `toml:"compression-format,omitempty"`
+	// CompressionLevel is the compression level used for layers which are compressed during the copy.
+	CompressionLevel *int `toml:"compression-level,omitempty"`
+	// MaxParallelDownloads is the maximum number of layers copied at the same time.
+	MaxParallelDownloads uint `toml:"max-parallel-downloads,omitempty"`
+	// MaxParallelUploads is the maximum number of blobs written to the destination at the same time.
+	MaxParallelUploads uint `toml:"max-parallel-uploads,omitempty"`
+	// ProgressInterval is the interval between reports sent to copy.Options.Progress, in time.ParseDuration syntax.
+	ProgressInterval string `toml:"progress-interval,omitempty"`
+	// RegistryMaintenanceRetryBudget is the total time spent waiting for registries reporting a maintenance window,
+	// in time.ParseDuration syntax; see types.SystemContext.DockerRegistryMaintenanceRetryBudget.
+	RegistryMaintenanceRetryBudget string `toml:"registry-maintenance-retry-budget,omitempty"`
+}
+
+// copyConfPath returns the path to the copy defaults configuration file to use for sys, and whether it must exist.
+func copyConfPath(sys *types.SystemContext) (string, bool) {
+	return copyConfPathWithHomeDir(sys, homedir.Get())
+}
+
+// copyConfPathWithHomeDir is an internal implementation detail of copyConfPath,
+// it exists only to allow testing it with an artificial home directory.
+func copyConfPathWithHomeDir(sys *types.SystemContext, homeDir string) (string, bool) {
+	if sys != nil && sys.CopyConfPath != "" {
+		return sys.CopyConfPath, true
+	}
+	userPath := filepath.Join(homeDir, userCopyConfPath)
+	if _, err := os.Stat(userPath); err == nil {
+		return userPath, false
+	}
+	if sys != nil && sys.RootForImplicitAbsolutePaths != "" {
+		return filepath.Join(sys.RootForImplicitAbsolutePaths, systemCopyConfPath), false
+	}
+	return systemCopyConfPath, false
+}
+
+// loadCopyConfDefaults returns the copy defaults configured for sys.
+// A missing configuration file is not an error, unless its path was explicitly specified in sys.
+func loadCopyConfDefaults(sys *types.SystemContext) (copyConfDefaults, error) {
+	path, required := copyConfPath(sys)
+	var conf copyConf
+	meta, err := toml.DecodeFile(path, &conf)
+	if err != nil {
+		if os.IsNotExist(err) && !required {
+			return copyConfDefaults{}, nil
+		}
+		return copyConfDefaults{}, errors.Wrapf(err, "loading copy defaults from %s", path)
+	}
+	if keys := meta.Undecoded(); len(keys) > 0 {
+		logrus.Debugf("Failed to decode keys %q from %q", keys, path)
+	}
+	return conf.Copy, nil
+}
+
+// applyCopyConfDefaults returns options updated with the defaults from the copy defaults configuration file,
+// for fields which are not explicitly set in options.  options is not modified.
+func applyCopyConfDefaults(options *Options) (*Options, error) {
+	defaults, err := loadCopyConfDefaults(options.DestinationCtx)
+	if err != nil {
+		return nil, err
+	}
+	return defaults.apply(options)
+}
+
+// apply returns options updated with defaults, for fields which are not explicitly set in options.
+// options, and the SystemContext values it points to, are not modified.
+func (defaults copyConfDefaults) apply(options *Options) (*Options, error) {
+	res := *options
+
+	// Defaults set in the SystemContexts take precedence over the configuration file.
+	if res.MaxParallelDownloads == 0 && res.SourceCtx != nil {
+		res.MaxParallelDownloads = res.SourceCtx.CopyMaxParallelDownloads
+	}
+	if res.MaxParallelUploads == 0 && res.DestinationCtx != nil {
+		res.MaxParallelUploads = res.DestinationCtx.CopyMaxParallelUploads
+	}
+	if defaults.MaxParallelDownloads != 0 && res.MaxParallelDownloads == 0 {
+		res.MaxParallelDownloads = defaults.MaxParallelDownloads
+	}
+	if defaults.MaxParallelUploads != 0 && res.MaxParallelUploads == 0 {
+		res.MaxParallelUploads = defaults.MaxParallelUploads
+	}
+	if defaults.ProgressInterval != "" && res.ProgressInterval == 0 {
+		interval, err := time.ParseDuration(defaults.ProgressInterval)
+		if err != nil {
+			return nil, errors.Wrapf(err, "parsing copy default progress-interval %q", defaults.ProgressInterval)
+		}
+		res.ProgressInterval = interval
+	}
+
+	var destCtx types.SystemContext
+	if res.DestinationCtx != nil {
+		destCtx = *res.DestinationCtx
+	}
+	destChanged := false
+	if defaults.CompressionFormat != "" && destCtx.CompressionFormat == nil {
+		algo, err := compression.AlgorithmByName(defaults.CompressionFormat)
+		if err != nil {
+			return nil, errors.Wrapf(err, "parsing copy default compression-format")
+		}
+		destCtx.CompressionFormat = &algo
+		destChanged = true
+	}
+	if defaults.CompressionLevel != nil && destCtx.CompressionLevel == nil {
+		level := *defaults.CompressionLevel
+		destCtx.CompressionLevel = &level
+		destChanged = true
+	}
+
+	var srcCtx types.SystemContext
+	if res.SourceCtx != nil {
+		srcCtx = *res.SourceCtx
+	}
+	srcChanged := false
+	if defaults.RegistryMaintenanceRetryBudget != "" {
+		budget, err := time.ParseDuration(defaults.RegistryMaintenanceRetryBudget)
+		if err != nil {
+			return nil, errors.Wrapf(err, "parsing copy default registry-maintenance-retry-budget %q", defaults.RegistryMaintenanceRetryBudget)
+		}
+		if srcCtx.DockerRegistryMaintenanceRetryBudget == 0 {
+			srcCtx.DockerRegistryMaintenanceRetryBudget = budget
+			srcChanged = true
+		}
+		if destCtx.DockerRegistryMaintenanceRetryBudget == 0 {
+			destCtx.DockerRegistryMaintenanceRetryBudget = budget
+			destChanged = true
+		}
+	}
+
+	if destChanged {
+		res.DestinationCtx = &destCtx
+	}
+	if srcChanged {
+		res.SourceCtx = &srcCtx
+	}
+	return &res, nil
+}
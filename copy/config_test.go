@@ -0,0 +1,146 @@
+package copy
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/containers/image/v5/pkg/compression"
+	"github.com/containers/image/v5/types"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestCopyConfPath(t *testing.T) {
+	homeDir := t.TempDir()
+
+	path, required := copyConfPathWithHomeDir(&types.SystemContext{CopyConfPath: "/explicit/path"}, homeDir)
+	assert.Equal(t, "/explicit/path", path)
+	assert.True(t, required)
+
+	path, required = copyConfPathWithHomeDir(nil, homeDir)
+	assert.Equal(t, systemCopyConfPath, path)
+	assert.False(t, required)
+	path, _ = copyConfPathWithHomeDir(&types.SystemContext{RootForImplicitAbsolutePaths: "/root/prefix"}, homeDir)
+	assert.Equal(t, filepath.Join("/root/prefix", systemCopyConfPath), path)
+
+	userPath := filepath.Join(homeDir, userCopyConfPath)
+	require.NoError(t, os.MkdirAll(filepath.Dir(userPath), 0700))
+	require.NoError(t, os.WriteFile(userPath, []byte{}, 0600))
+	path, required = copyConfPathWithHomeDir(&types.SystemContext{RootForImplicitAbsolutePaths: "/root/prefix"}, homeDir)
+	assert.Equal(t, userPath, path)
+	assert.False(t, required)
+}
+
+func TestLoadCopyConfDefaults(t *testing.T) {
+	tmpDir := t.TempDir()
+	confPath := filepath.Join(tmpDir, "containers-copy.conf")
+	err := os.WriteFile(confPath, []byte(`[copy]
+compression-format = "zstd"
+compression-level = 5
+max-parallel-downloads = 3
+max-parallel-uploads = 2
+progress-interval = "500ms"
+registry-maintenance-retry-budget = "2m"
+`), 0600)
+	require.NoError(t, err)
+	level := 5
+	defaults, err := loadCopyConfDefaults(&types.SystemContext{CopyConfPath: confPath})
+	require.NoError(t, err)
+	assert.Equal(t, copyConfDefaults{
+		CompressionFormat:              "zstd",
+		CompressionLevel:               &level,
+		MaxParallelDownloads:           3,
+		MaxParallelUploads:             2,
+		ProgressInterval:               "500ms",
+		RegistryMaintenanceRetryBudget: "2m",
+	}, defaults)
+
+	// An explicitly specified file must exist
+	_, err = loadCopyConfDefaults(&types.SystemContext{CopyConfPath: filepath.Join(tmpDir, "missing.conf")})
+	assert.Error(t, err)
+	// Missing system files are ignored
+	defaults, err = loadCopyConfDefaults(&types.SystemContext{RootForImplicitAbsolutePaths: tmpDir})
+	require.NoError(t, err)
+	assert.Equal(t, copyConfDefaults{}, defaults)
+
+	// Invalid syntax
+	invalidPath := filepath.Join(tmpDir, "invalid.conf")
+	require.NoError(t, os.WriteFile(invalidPath, []byte("[copy\n"), 0600))
+	_, err = loadCopyConfDefaults(&types.SystemContext{CopyConfPath: invalidPath})
+	assert.Error(t, err)
+}
+
+func TestCopyConfDefaultsApply(t *testing.T) {
+	level := 5
+	defaults := copyConfDefaults{
+		CompressionFormat:              "zstd",
+		CompressionLevel:               &level,
+		MaxParallelDownloads:           3,
+		MaxParallelUploads:             2,
+		ProgressInterval:               "500ms",
+		RegistryMaintenanceRetryBudget: "2m",
+	}
+
+	// Defaults are used for unset values
+	res, err := defaults.apply(&Options{})
+	require.NoError(t, err)
+	assert.Equal(t, uint(3), res.MaxParallelDownloads)
+	assert.Equal(t, uint(2), res.MaxParallelUploads)
+	assert.Equal(t, 500*time.Millisecond, res.ProgressInterval)
+	require.NotNil(t, res.DestinationCtx)
+	require.NotNil(t, res.DestinationCtx.CompressionFormat)
+	assert.Equal(t, compression.Zstd.Name(), res.DestinationCtx.CompressionFormat.Name())
+	assert.Equal(t, &level, res.DestinationCtx.CompressionLevel)
+	assert.Equal(t, 2*time.Minute, res.DestinationCtx.DockerRegistryMaintenanceRetryBudget)
+	require.NotNil(t, res.SourceCtx)
+	assert.Equal(t, 2*time.Minute, res.SourceCtx.DockerRegistryMaintenanceRetryBudget)
+
+	// Explicitly set values are not overridden, and the caller’s values are not modified
+	explicitLevel := 1
+	destCtx := &types.SystemContext{
+		CompressionFormat:                    &compression.Gzip,
+		CompressionLevel:                     &explicitLevel,
+		DockerRegistryMaintenanceRetryBudget: time.Minute,
+	}
+	srcCtx := &types.SystemContext{}
+	options := &Options{
+		MaxParallelDownloads: 10,
+		ProgressInterval:     time.Second,
+		SourceCtx:            srcCtx,
+		DestinationCtx:       destCtx,
+	}
+	res, err = defaults.apply(options)
+	require.NoError(t, err)
+	assert.Equal(t, uint(10), res.MaxParallelDownloads)
+	assert.Equal(t, time.Second, res.ProgressInterval)
+	assert.Same(t, destCtx, res.DestinationCtx)
+	assert.Equal(t, 2*time.Minute, res.SourceCtx.DockerRegistryMaintenanceRetryBudget)
+	assert.Equal(t, time.Duration(0), srcCtx.DockerRegistryMaintenanceRetryBudget)
+	assert.Same(t, srcCtx, options.SourceCtx)
+
+	// Empty defaults don’t change anything
+	res, err = copyConfDefaults{}.apply(options)
+	require.NoError(t, err)
+	assert.Equal(t, options, res)
+
+	// Defaults in SystemContexts take precedence over the configuration file
+	res, err = defaults.apply(&Options{
+		SourceCtx:      &types.SystemContext{CopyMaxParallelDownloads: 8},
+		DestinationCtx: &types.SystemContext{CopyMaxParallelUploads: 4},
+	})
+	require.NoError(t, err)
+	assert.Equal(t, uint(8), res.MaxParallelDownloads)
+	assert.Equal(t, uint(4), res.MaxParallelUploads)
+
+	// Invalid values
+	for _, invalid := range []copyConfDefaults{
+		{CompressionFormat: "this-is-not-a-format"},
+		{ProgressInterval: "soon"},
+		{RegistryMaintenanceRetryBudget: "forever"},
+	} {
+		_, err := invalid.apply(&Options{})
+		assert.Error(t, err, "%#v", invalid)
+	}
+}
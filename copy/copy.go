@@ -14,15 +14,18 @@ import (
 	"github.com/containers/image/v5/docker/reference"
 	"github.com/containers/image/v5/image"
 	internalblobinfocache "github.com/containers/image/v5/internal/blobinfocache"
+	"github.com/containers/image/v5/internal/digestpolicy"
 	"github.com/containers/image/v5/internal/imagedestination"
 	"github.com/containers/image/v5/internal/imagesource"
 	"github.com/containers/image/v5/internal/pkg/platform"
 	"github.com/containers/image/v5/internal/private"
+	"github.com/containers/image/v5/internal/tracing"
 	"github.com/containers/image/v5/manifest"
 	"github.com/containers/image/v5/pkg/blobinfocache"
 	"github.com/containers/image/v5/pkg/compression"
 	compressiontypes "github.com/containers/image/v5/pkg/compression/types"
 	"github.com/containers/image/v5/signature"
+	"github.com/containers/image/v5/signature/sigstore"
 	"github.com/containers/image/v5/transports"
 	"github.com/containers/image/v5/types"
 	"github.com/containers/ocicrypt"
@@ -32,6 +35,8 @@ import (
 	"github.com/pkg/errors"
 	"github.com/sirupsen/logrus"
 	"github.com/vbauerster/mpb/v7"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/trace"
 	"golang.org/x/sync/semaphore"
 	"golang.org/x/term"
 )
@@ -63,19 +68,44 @@ var expectedCompressionFormats = map[string]*compressiontypes.Algorithm{
 // copier allows us to keep track of diffID values for blobs, and other
 // data shared across one or more images in a possible manifest list.
 type copier struct {
-	dest                          private.ImageDestination
-	rawSource                     private.ImageSource
-	reportWriter                  io.Writer
-	progressOutput                io.Writer
-	progressInterval              time.Duration
-	progress                      chan types.ProgressProperties
-	blobInfoCache                 internalblobinfocache.BlobInfoCache2
-	compressionFormat             *compressiontypes.Algorithm // Compression algorithm to use, if the user explicitly requested one, or nil.
-	compressionLevel              *int
-	ociDecryptConfig              *encconfig.DecryptConfig
-	ociEncryptConfig              *encconfig.EncryptConfig
-	concurrentBlobCopiesSemaphore *semaphore.Weighted // Limits the amount of concurrently copied blobs
-	downloadForeignLayers         bool
+	dest                           private.ImageDestination
+	rawSource                      private.ImageSource
+	reportWriter                   io.Writer
+	progressOutput                 io.Writer
+	progressInterval               time.Duration
+	progress                       chan types.ProgressProperties
+	progressEvents                 *progressEventReporter // or nil if Options.ProgressEventCallback is not set
+	blobInfoCache                  internalblobinfocache.BlobInfoCache2
+	compressionFormat              *compressiontypes.Algorithm // Compression algorithm to use, if the user explicitly requested one, or nil.
+	compressionLevel               *int
+	layerCompressionPolicy         func(LayerCompressionInfo) LayerCompression // or nil
+	ociDecryptConfig               *encconfig.DecryptConfig
+	ociEncryptConfig               *encconfig.EncryptConfig
+	concurrentBlobCopiesSemaphore  *semaphore.Weighted // Limits the amount of concurrently copied blobs
+	concurrentBlobUploadsSemaphore *semaphore.Weighted // Limits the amount of concurrently written blobs, or nil if not limited separately
+	checkpoint                     *checkpoint         // or nil if Options.CheckpointFile is not set
+	plan                           *planReport         // or nil if Options.DryRun is not set
+	downloadForeignLayers          bool
+	strictMediaTypePreservation    bool
+	strictSchemaValidation         bool
+	blobExporter                   BlobExporter
+	tracerProvider                 trace.TracerProvider // or nil if tracing is disabled
+	digestPolicy                   *types.DigestPolicy  // The digest policy of the source, or nil
+	checkDestinationImageFn        func(ctx context.Context, image DestinationImage) error
+	annotationEditor               *annotationEditor                                                           // or nil if no annotation changes were requested
+	modifyConfig                   func(ctx context.Context, config []byte, mediaType string) ([]byte, error)  // or nil
+	modifyManifest                 func(ctx context.Context, manifest []byte, mimeType string) ([]byte, error) // or nil
+	degradations                   *degradationReport
+	timings                        *timingReport
+	rewriteSubjects                bool
+	subjectRewrites                map[digest.Digest]imgspecv1.Descriptor // Only used if rewriteSubjects
+	convertedManifests             map[digest.Digest]imgspecv1.Descriptor // Manifests written with a different digest, see Result.ConvertedManifests
+	sigstoreSigner                 *sigstore.Signer                       // or nil if none of Options.SignBySigstorePrivateKeyFile, SignBySigstoreKeyless and SignBySigstoreKMSKey is set
+	sigstoreSignatures             []sigstoreSignature                    // Created signatures, to be stored by putSigstoreSignatures
+	reproducible                   bool                                   // Options.Reproducible is set
+	layerRetryPolicy               *types.DockerRetryPolicy               // or nil if layers should not be retried
+	layerAttempts                  *layerAttemptsReport
+	statistics                     *statisticsReport
 }
 
 // imageCopier tracks state specific to a single image (possibly an item of a manifest list)
@@ -87,6 +117,8 @@ type imageCopier struct {
 	cannotModifyManifestReason string // The reason the manifest cannot be modified, or an empty string if it can
 	canSubstituteBlobs         bool
 	ociEncryptLayers           *[]int
+	subjectRewrite             *imgspecv1.Descriptor // The new subject of the manifest, or nil if it should not be changed
+	artifactConfigType         string                // The config media type, if the image is an OCI artifact which is not a container image; "" otherwise
 }
 
 const (
@@ -132,6 +164,33 @@ type Options struct {
 	ProgressInterval time.Duration                 // time to wait between reports to signal the progress channel
 	Progress         chan types.ProgressProperties // Reported to when ProgressInterval has arrived for a single artifact+offset.
 
+	// If SignBySigstorePrivateKeyFile is set, a sigstore (cosign) signature of every written manifest is created using the
+	// private key in that file, as accepted by sigstore.NewSignerFromPrivateKey, decrypted using SignSigstorePrivateKeyPassphrase.
+	// The signatures use SignIdentity, like SignBy, and are stored in the destination using the cosign tag scheme
+	// (sha256-<hex>.sig), which requires a destination transport which can write tags (e.g. docker://).
+	// Sigstore signatures already stored in the destination, e.g. copied using CopyReferrers, are preserved unless
+	// RemoveSignatures is set.
+	SignBySigstorePrivateKeyFile     string
+	SignSigstorePrivateKeyPassphrase []byte
+	// If SignBySigstoreKeyless is set, sigstore signatures are created like with SignBySigstorePrivateKeyFile, but using
+	// an ephemeral key certified by Fulcio and recorded in Rekor, as configured by SignBySigstoreKeyless
+	// (see sigstore.NewKeylessSigner).  It can't be used together with SignBySigstorePrivateKeyFile.
+	SignBySigstoreKeyless *sigstore.KeylessOptions
+	// If SignBySigstoreKMSKey is set, sigstore signatures are created like with SignBySigstorePrivateKeyFile, but by a key
+	// held in a key management service, which never exposes the private key (see e.g. the signature/sigstore/kms package).
+	// It can't be used together with SignBySigstorePrivateKeyFile or SignBySigstoreKeyless.
+	SignBySigstoreKMSKey sigstore.KMSKey
+	// If SignSigstoreTimestampAuthority is set, sigstore signatures include an RFC 3161 timestamp obtained from that
+	// timestamp authority (see sigstore.Signer.UseTimestampAuthority), so that they can be verified after the signing
+	// certificate expires.  It requires one of SignBySigstorePrivateKeyFile, SignBySigstoreKeyless and SignBySigstoreKMSKey.
+	SignSigstoreTimestampAuthority *sigstore.TimestampAuthorityOptions
+
+	// If ProgressEventCallback is set, it is called with machine-readable events as blobs are copied
+	// (see ProgressEventKind) and manifests are written.  Progress of a single blob is reported at most once per
+	// ProgressInterval, or once per second if ProgressInterval is not set.  Calls are serialized, but may happen
+	// on any goroutine; the callback should return quickly, because it blocks the copy.
+	ProgressEventCallback func(ProgressEvent)
+
 	// Preserve digests, and fail if we cannot.
 	PreserveDigests bool
 	// manifest MIME type of image set by user. "" is default and means use the autodetection to the the manifest MIME type
@@ -160,6 +219,47 @@ type Options struct {
 	// MaxParallelDownloads indicates the maximum layers to pull at the same time. Applies to a single copy operation. A reasonable default is used if this is left as 0. Ignored if ConcurrentBlobCopiesSemaphore is set.
 	MaxParallelDownloads uint
 
+	// MaxParallelUploads indicates the maximum number of blobs written to the destination at the same time. Applies to a single copy operation.
+	// If 0, uploads are only limited by MaxParallelDownloads (or ConcurrentBlobCopiesSemaphore).
+	// Blobs are written concurrently only if the destination supports it; the manifest is always written after all blobs, with layers in the original order.
+	MaxParallelUploads uint
+
+	// If not "", the path of a file recording the layers already written to the destination.  If the file exists,
+	// e.g. after an interrupted copy of the same image to the same destination with the same options, layers recorded
+	// in it are not copied again as long as the destination still contains them; this does not depend on the destination
+	// preserving partial uploads.  The file is updated as layers are copied, and removed when the copy succeeds.
+	CheckpointFile string
+
+	// If DryRun is set, the source is read and the destination is checked for blobs it already contains, but no blobs,
+	// manifests or signatures are written; ImageWithResult returns the Plan of the copy in Result.Plan, and the source
+	// manifest in Result.Manifest.  Note that opening the destination may still have side effects with some transports
+	// (e.g. dir: removes the previous contents of the directory).
+	DryRun bool
+
+	// If LayerCompressionPolicy is set, it is called for every layer which can be modified, and the compression it returns
+	// is used for that layer, instead of DestinationCtx.CompressionFormat and DestinationCtx.CompressionLevel;
+	// e.g. to use zstd:chunked only for large layers.  It only affects destinations which want compressed layers
+	// (see types.ImageDestination.DesiredLayerCompression), and layers which are not reused from the destination.
+	// It may be called concurrently for different layers.
+	LayerCompressionPolicy func(LayerCompressionInfo) LayerCompression
+
+	// If LayerRetryPolicy is set, a layer whose copy fails is copied again, instead of failing the whole copy, using
+	// the MaxAttempts, InitialDelay, MaxDelay and Jitter fields of the policy (the other fields are ignored).  Each attempt
+	// first checks whether the destination already has the layer, or can reuse it, e.g. using a cross-repository mount;
+	// the docker:// transport also resumes interrupted chunked uploads (see types.SystemContext.DockerChunkedUploadSize).
+	// Failures caused by cancelling the context are not retried.  The attempts are reported in Result.LayerAttempts.
+	LayerRetryPolicy *types.DockerRetryPolicy
+
+	// If Reproducible is set, the copy is made reproducible: copying the same source twice, with the same options,
+	// yields byte-identical destination manifests and blobs.  See ReproducibleOptions for details.
+	Reproducible *ReproducibleOptions
+
+	// If SkipIfDestinationUpToDate is set, the destination is checked before copying anything; if it already contains
+	// exactly the image (or list) which the copy would write, including signatures, the copy is skipped, and
+	// Result.UpToDate is set.  The check is only made if the result of the copy is predictable, e.g. it is not made
+	// if the copy would sign, encrypt or convert the image, or change the compression of its layers.
+	SkipIfDestinationUpToDate bool
+
 	// When OptimizeDestinationImageAlreadyExists is set, optimize the copy assuming that the destination image already
 	// exists (and is equivalent). Making the eventual (no-op) copy more performant for this case. Enabling the option
 	// is slightly pessimistic if the destination image doesn't exist, or is not equivalent.
@@ -168,6 +268,90 @@ type Options struct {
 	// Download layer contents with "nondistributable" media types ("foreign" layers) and translate the layer media type
 	// to not indicate "nondistributable".
 	DownloadForeignLayers bool
+
+	// If StrictMediaTypePreservation is set, layers with media types this package does not know (e.g. artifact
+	// layers like WASM modules or ML models) are copied byte-for-byte: they are never compressed, decompressed,
+	// recompressed, encrypted, substituted with a differently-compressed variant, or re-typed by a manifest
+	// format conversion.  If that is not possible, the copy fails instead.
+	StrictMediaTypePreservation bool
+
+	// If StrictSchemaValidation is set, source manifests, manifest lists and image configs are validated against
+	// the JSON schemas of their formats (see manifest.ValidateSchema) before they are used, so that invalid data
+	// fails the copy early, with an error identifying the invalid values.  Formats without a known schema,
+	// e.g. Docker schema1 manifests or configs of non-image artifacts, are not validated.
+	StrictSchemaValidation bool
+
+	// If CheckDestinationImage is set, it is called for every image (i.e. every instance of a copied manifest list,
+	// but not the list itself) after its layers have been copied, but before its config and manifest are written
+	// to the destination, so that callers can enforce policies such as required labels, allowed licenses
+	// or maximum image size.  If it returns an error, the copy fails with ErrDestinationImageRejected and
+	// the image manifest is not written.
+	// It may be called more than once for a single image, if the destination rejects the first manifest format.
+	CheckDestinationImage func(ctx context.Context, image DestinationImage) error
+
+	// If AnnotationChanges is set, the annotations of all copied manifests, and of the manifest list if copying
+	// a list, are updated accordingly; instance digests in the list are updated to match.
+	// This requires the copied manifests and lists to use the OCI formats (see ForceManifestMIMEType),
+	// and fails if the manifests can't be modified, e.g. because they are signed and RemoveSignatures is not set.
+	AnnotationChanges *AnnotationChanges
+
+	// If ModifyConfig is set, it is called with the config of every copied image (and its media type), and the returned
+	// config is written instead; the manifest is updated to refer to it.  This is only supported for OCI and Docker schema2 images.
+	// If ModifyManifest is set, it is called with every copied image manifest (and its MIME type), after all other
+	// modifications, including ModifyConfig; the returned manifest, which must use the same MIME type and refer to the
+	// same config, is written instead, and instance digests in a copied manifest list are updated to match.
+	// Both may be called more than once for a single image, if the destination rejects the first manifest format.
+	// Modifying the image fails if the manifests can't be modified, e.g. because they are signed and RemoveSignatures is not set.
+	ModifyConfig   func(ctx context.Context, config []byte, mediaType string) ([]byte, error)
+	ModifyManifest func(ctx context.Context, manifest []byte, mimeType string) ([]byte, error)
+
+	// If DegradationCallback is set, it is called for every fallback used to copy the image (e.g. a registry mirror
+	// failover, or a manifest format conversion), as soon as it is used, in addition to being listed in Result.Degradations
+	// returned by ImageWithResult.  Calls are serialized, but may happen on any goroutine.
+	DegradationCallback func(Degradation)
+
+	// If RewriteSubjects is set, the subject (an image-spec v1.1 field) of a copied OCI manifest or index which refers to
+	// a manifest that was written with a different digest earlier during the same copy (e.g. another instance of a copied
+	// manifest list which was converted to a different format), or which refers to a key of SubjectRewrites, is updated
+	// to refer to the written manifest.  This modifies the manifest, so the copy fails if it can't be modified.
+	RewriteSubjects bool
+	// SubjectRewrites maps digests of manifests which were written with a different digest, e.g. by an earlier copy
+	// (see Result.ConvertedManifests), to descriptors of the written manifests.  It is only used if RewriteSubjects is set.
+	SubjectRewrites map[digest.Digest]imgspecv1.Descriptor
+
+	// AdditionalTags are tags (in the destination repository) which are set to the copied manifest, in addition to
+	// the tag of destRef, after the image is copied; the manifest is only written again, without copying any blobs.
+	// This is only supported by some transports (e.g. docker://); with other transports, the copy fails before copying anything.
+	// The outcome for each tag is listed in Result.AdditionalTags; any failures are also reported as an *AdditionalTagsError.
+	AdditionalTags []string
+
+	// If Metrics is set, it receives the timings of the phases of the copy (e.g. policy checks, or writing manifests)
+	// and of individual blobs as they happen, in addition to the totals being listed in Result.Timings returned by ImageWithResult.
+	Metrics Metrics
+
+	// If BlobExporter is set, layers read from the source are also passed to it as they are written to the destination,
+	// e.g. to store them in an external content-addressable store.
+	BlobExporter BlobExporter
+
+	// If BlobInfoCache is set, it is used to record and look up known locations and compression of blobs, instead of
+	// the default cache for DestinationCtx; e.g. to share a single cache between many copies.
+	BlobInfoCache types.BlobInfoCache
+
+	// If CopyReferrers is set, artifacts referring to the copied image (or manifest list) using the image-spec v1.1 subject
+	// field, e.g. signatures, SBOMs or scan results, and optionally artifacts stored using the cosign tag scheme, which are
+	// selected by it, are also copied.  If the copied manifest is modified by the copy, the subject fields of the artifacts are
+	// updated to refer to the written manifest; otherwise the artifacts are copied unmodified.  (Note that signatures
+	// of the original manifest, as opposed to signatures of the artifacts, are not valid for a modified manifest.)
+	// Only direct referrers of the top-level copied manifest are copied, not referrers of referrers, or of manifest list
+	// instances.  This requires a source transport which can list referrers (e.g. docker://), and, for the cosign tag
+	// scheme, source and destination transports which support reading and writing tags in the same repository.
+	CopyReferrers *ReferrerFilter
+
+	// If TracerProvider is set, it is used to record OpenTelemetry spans for the copy, its layers, config and manifests,
+	// as children of the span in the context passed to Image, if any.  Registry requests (including bearer token requests)
+	// made by the docker transport, and lookups of registry credentials (including executions of external credential helpers),
+	// are recorded as children of those spans.
+	TracerProvider trace.TracerProvider
 }
 
 // validateImageListSelection returns an error if the passed-in value is not one that we recognize as a valid ImageListSelection value
@@ -183,7 +367,47 @@ func validateImageListSelection(selection ImageListSelection) error {
 // Image copies image from srcRef to destRef, using policyContext to validate
 // source image admissibility.  It returns the manifest which was written to
 // the new copy of the image.
-func Image(ctx context.Context, policyContext *signature.PolicyContext, destRef, srcRef types.ImageReference, options *Options) (copiedManifest []byte, retErr error) {
+func Image(ctx context.Context, policyContext *signature.PolicyContext, destRef, srcRef types.ImageReference, options *Options) ([]byte, error) {
+	res, err := ImageWithResult(ctx, policyContext, destRef, srcRef, options)
+	if err != nil {
+		return nil, err
+	}
+	return res.Manifest, nil
+}
+
+// ImageWithResult is Image, and also returns details about the copy, notably the fallbacks
+// which were necessary to copy the image.
+// If the image was copied, but writing it under some of Options.AdditionalTags failed, ImageWithResult
+// returns both the result and an *AdditionalTagsError.
+func ImageWithResult(ctx context.Context, policyContext *signature.PolicyContext, destRef, srcRef types.ImageReference, options *Options) (*Result, error) {
+	var callback func(Degradation)
+	var metrics Metrics
+	var tp trace.TracerProvider
+	if options != nil {
+		callback = options.DegradationCallback
+		metrics = options.Metrics
+		tp = options.TracerProvider
+	}
+	ctx, span := tracing.Start(tracing.WithProvider(ctx, tp), tp, "copy image",
+		attribute.String("image.source", transports.ImageName(srcRef)), attribute.String("image.destination", transports.ImageName(destRef)))
+	report := newDegradationReport(callback)
+	timings := newTimingReport(metrics)
+	res, err := imageWithReport(ctx, policyContext, destRef, srcRef, options, report, timings)
+	tracing.End(span, err)
+	if err != nil {
+		return nil, err
+	}
+	res.Degradations = report.list()
+	res.Timings = timings.timings()
+	if err := additionalTagsError(res.AdditionalTags); err != nil {
+		return res, err
+	}
+	return res, nil
+}
+
+// imageWithReport implements ImageWithResult, recording degradations into report, and timings into timings.
+// It returns the copied manifest, and the manifests written with a different digest (see Result.ConvertedManifests).
+func imageWithReport(ctx context.Context, policyContext *signature.PolicyContext, destRef, srcRef types.ImageReference, options *Options, report *degradationReport, timings *timingReport) (res *Result, retErr error) {
 	// NOTE this function uses an output parameter for the error return value.
 	// Setting this and returning is the ideal way to return an error.
 	//
@@ -192,6 +416,10 @@ func Image(ctx context.Context, policyContext *signature.PolicyContext, destRef,
 	if options == nil {
 		options = &Options{}
 	}
+	options, err := applyCopyConfDefaults(options)
+	if err != nil {
+		return nil, err
+	}
 
 	if err := validateImageListSelection(options.ImageListSelection); err != nil {
 		return nil, err
@@ -203,7 +431,9 @@ func Image(ctx context.Context, policyContext *signature.PolicyContext, destRef,
 		reportWriter = options.ReportWriter
 	}
 
+	destOpenStart := time.Now()
 	publicDest, err := destRef.NewImageDestination(ctx, options.DestinationCtx)
+	timings.recordPhaseSince(PhaseDestinationOpen, destOpenStart)
 	if err != nil {
 		return nil, errors.Wrapf(err, "initializing destination %s", transports.ImageName(destRef))
 	}
@@ -213,8 +443,67 @@ func Image(ctx context.Context, policyContext *signature.PolicyContext, destRef,
 			retErr = errors.Wrapf(retErr, " (dest: %v)", err)
 		}
 	}()
+	tagger, _ := dest.(private.ManifestTagger)
+	if len(options.AdditionalTags) != 0 && tagger == nil {
+		return nil, errors.Errorf("destination transport %q does not support writing images under additional tags", destRef.Transport().Name())
+	}
+	copyCosignArtifacts := options.CopyReferrers != nil && len(options.CopyReferrers.CosignTagSuffixes) != 0
+	if copyCosignArtifacts && tagger == nil {
+		return nil, errors.Errorf("destination transport %q does not support writing cosign artifact tags", destRef.Transport().Name())
+	}
+	var sigstoreSigner *sigstore.Signer
+	sigstoreKeySources := 0
+	if options.SignBySigstorePrivateKeyFile != "" {
+		sigstoreKeySources++
+	}
+	if options.SignBySigstoreKeyless != nil {
+		sigstoreKeySources++
+	}
+	if options.SignBySigstoreKMSKey != nil {
+		sigstoreKeySources++
+	}
+	if sigstoreKeySources != 0 && tagger == nil {
+		return nil, errors.Errorf("destination transport %q does not support storing sigstore signatures", destRef.Transport().Name())
+	}
+	if sigstoreKeySources > 1 {
+		return nil, errors.New("only one of SignBySigstorePrivateKeyFile, SignBySigstoreKeyless and SignBySigstoreKMSKey can be used")
+	}
+	if options.SignBySigstorePrivateKeyFile != "" {
+		keyPEM, err := os.ReadFile(options.SignBySigstorePrivateKeyFile)
+		if err != nil {
+			return nil, errors.Wrap(err, "reading sigstore private key")
+		}
+		sigstoreSigner, err = sigstore.NewSignerFromPrivateKey(keyPEM, options.SignSigstorePrivateKeyPassphrase)
+		if err != nil {
+			return nil, errors.Wrapf(err, "loading sigstore private key %s", options.SignBySigstorePrivateKeyFile)
+		}
+	}
+	if options.SignBySigstoreKeyless != nil {
+		s, err := sigstore.NewKeylessSigner(ctx, *options.SignBySigstoreKeyless)
+		if err != nil {
+			return nil, errors.Wrap(err, "initializing sigstore keyless signing")
+		}
+		sigstoreSigner = s
+	}
+	if options.SignBySigstoreKMSKey != nil {
+		s, err := sigstore.NewSignerFromKMSKey(ctx, options.SignBySigstoreKMSKey)
+		if err != nil {
+			return nil, errors.Wrap(err, "initializing sigstore signing using a key management service")
+		}
+		sigstoreSigner = s
+	}
+	if options.SignSigstoreTimestampAuthority != nil {
+		if sigstoreSigner == nil {
+			return nil, errors.New("SignSigstoreTimestampAuthority requires one of SignBySigstorePrivateKeyFile, SignBySigstoreKeyless and SignBySigstoreKMSKey")
+		}
+		if err := sigstoreSigner.UseTimestampAuthority(*options.SignSigstoreTimestampAuthority); err != nil {
+			return nil, errors.Wrap(err, "initializing sigstore signature timestamping")
+		}
+	}
 
+	srcOpenStart := time.Now()
 	publicRawSource, err := srcRef.NewImageSource(ctx, options.SourceCtx)
+	timings.recordPhaseSince(PhaseSourceOpen, srcOpenStart)
 	if err != nil {
 		return nil, errors.Wrapf(err, "initializing source %s", transports.ImageName(srcRef))
 	}
@@ -224,6 +513,41 @@ func Image(ctx context.Context, policyContext *signature.PolicyContext, destRef,
 			retErr = errors.Wrapf(retErr, " (src: %v)", err)
 		}
 	}()
+	var referrersLister private.ReferrersLister
+	if options.CopyReferrers != nil {
+		l, ok := rawSource.(private.ReferrersLister)
+		if !ok {
+			return nil, errors.Errorf("source transport %q does not support listing referrers", srcRef.Transport().Name())
+		}
+		referrersLister = l
+	}
+	var cosignReader private.TaggedManifestReader
+	if copyCosignArtifacts {
+		r, ok := rawSource.(private.TaggedManifestReader)
+		if !ok {
+			return nil, errors.Errorf("source transport %q does not support reading cosign artifact tags", srcRef.Transport().Name())
+		}
+		cosignReader = r
+	}
+	defer func() { // Runs before closing rawSource and dest above.
+		var authDuration time.Duration
+		reported := false
+		for _, v := range []interface{}{rawSource, dest} {
+			if reporter, ok := v.(private.AuthenticationDurationReporter); ok {
+				authDuration += reporter.AuthenticationDuration()
+				reported = true
+			}
+		}
+		if reported {
+			timings.recordPhase(PhaseAuthentication, authDuration)
+		}
+	}()
+	if reporter, ok := rawSource.(private.MirrorFailoverReporter); ok {
+		if failed := reporter.FailedPullSources(); len(failed) != 0 {
+			report.record(DegradationMirrorFailover, fmt.Sprintf("reading %s after failing to access %s",
+				transports.ImageName(srcRef), strings.Join(failed, "; ")))
+		}
+	}
 
 	// If reportWriter is not a TTY (e.g., when piping to a file), do not
 	// print the progress bars to avoid long and hard to parse output.
@@ -234,19 +558,64 @@ func Image(ctx context.Context, policyContext *signature.PolicyContext, destRef,
 	}
 
 	c := &copier{
-		dest:             dest,
-		rawSource:        rawSource,
-		reportWriter:     reportWriter,
-		progressOutput:   progressOutput,
-		progressInterval: options.ProgressInterval,
-		progress:         options.Progress,
+		dest:                        dest,
+		rawSource:                   rawSource,
+		reportWriter:                reportWriter,
+		progressOutput:              progressOutput,
+		progressInterval:            options.ProgressInterval,
+		progress:                    options.Progress,
+		progressEvents:              newProgressEventReporter(options.ProgressEventCallback, options.ProgressInterval),
+		ociDecryptConfig:            options.OciDecryptConfig,
+		ociEncryptConfig:            options.OciEncryptConfig,
+		downloadForeignLayers:       options.DownloadForeignLayers,
+		strictMediaTypePreservation: options.StrictMediaTypePreservation,
+		strictSchemaValidation:      options.StrictSchemaValidation,
+		checkDestinationImageFn:     options.CheckDestinationImage,
+		degradations:                report,
+		timings:                     timings,
+		rewriteSubjects:             options.RewriteSubjects,
+		modifyConfig:                options.ModifyConfig,
+		modifyManifest:              options.ModifyManifest,
+		layerCompressionPolicy:      options.LayerCompressionPolicy,
+		blobExporter:                options.BlobExporter,
+		tracerProvider:              options.TracerProvider,
+		digestPolicy:                digestpolicy.FromSystemContext(options.SourceCtx),
+		sigstoreSigner:              sigstoreSigner,
+		reproducible:                options.Reproducible != nil,
+		layerRetryPolicy:            options.LayerRetryPolicy,
+		layerAttempts:               &layerAttemptsReport{},
+		statistics:                  &statisticsReport{},
+	}
+	if options.Reproducible != nil && options.Reproducible.Timestamp != nil {
+		c.modifyConfig = withConfigTimestamp(c.modifyConfig, *options.Reproducible.Timestamp)
+		c.modifyManifest = withManifestTimestamp(c.modifyManifest, *options.Reproducible.Timestamp)
+	}
+	if options.BlobInfoCache != nil {
+		c.blobInfoCache = internalblobinfocache.FromBlobInfoCache(options.BlobInfoCache)
+	} else {
 		// FIXME? The cache is used for sources and destinations equally, but we only have a SourceCtx and DestinationCtx.
 		// For now, use DestinationCtx (because blob reuse changes the behavior of the destination side more); eventually
 		// we might want to add a separate CommonCtx — or would that be too confusing?
-		blobInfoCache:         internalblobinfocache.FromBlobInfoCache(blobinfocache.DefaultCache(options.DestinationCtx)),
-		ociDecryptConfig:      options.OciDecryptConfig,
-		ociEncryptConfig:      options.OciEncryptConfig,
-		downloadForeignLayers: options.DownloadForeignLayers,
+		c.blobInfoCache = internalblobinfocache.FromBlobInfoCache(blobinfocache.DefaultCache(options.DestinationCtx))
+	}
+	if options.DryRun {
+		c.plan = newPlanReport()
+	}
+	c.checkpoint, err = openCheckpoint(options.CheckpointFile, srcRef, destRef)
+	if err != nil {
+		return nil, err
+	}
+	if c.rewriteSubjects {
+		c.subjectRewrites = make(map[digest.Digest]imgspecv1.Descriptor, len(options.SubjectRewrites))
+		for k, v := range options.SubjectRewrites {
+			c.subjectRewrites[k] = v
+		}
+	}
+	if options.AnnotationChanges != nil {
+		c.annotationEditor, err = newAnnotationEditor(options.AnnotationChanges)
+		if err != nil {
+			return nil, err
+		}
 	}
 
 	// Set the concurrentBlobCopiesSemaphore if we can copy layers in parallel.
@@ -259,6 +628,9 @@ func Image(ctx context.Context, policyContext *signature.PolicyContext, destRef,
 			}
 			c.concurrentBlobCopiesSemaphore = semaphore.NewWeighted(int64(max))
 		}
+		if options.MaxParallelUploads != 0 {
+			c.concurrentBlobUploadsSemaphore = semaphore.NewWeighted(int64(options.MaxParallelUploads))
+		}
 	} else {
 		c.concurrentBlobCopiesSemaphore = semaphore.NewWeighted(int64(1))
 		if options.ConcurrentBlobCopiesSemaphore != nil {
@@ -275,12 +647,45 @@ func Image(ctx context.Context, policyContext *signature.PolicyContext, destRef,
 		c.compressionLevel = options.DestinationCtx.CompressionLevel
 	}
 
+	var copiedManifest []byte
 	unparsedToplevel := image.UnparsedInstance(rawSource, nil)
+	var toplevelDigest digest.Digest // The digest the top-level manifest is verified against, if any.
+	if named := srcRef.DockerReference(); named != nil {
+		if digested, ok := named.(reference.Digested); ok {
+			toplevelDigest = digested.Digest()
+			if err := c.checkSourceDigest(toplevelDigest); err != nil {
+				return nil, err
+			}
+		}
+	}
+	if options.SkipIfDestinationUpToDate {
+		destManifest, upToDate, err := c.destinationUpToDate(ctx, policyContext, options, unparsedToplevel)
+		if err != nil {
+			return nil, err
+		}
+		if upToDate {
+			c.Printf("Skipping: image already up to date at destination\n")
+			return &Result{Manifest: destManifest, UpToDate: true}, nil
+		}
+	}
+	manifestFetchStart := time.Now()
 	multiImage, err := isMultiImage(ctx, unparsedToplevel)
+	timings.recordPhaseSince(PhaseManifestFetch, manifestFetchStart)
 	if err != nil {
 		return nil, errors.Wrapf(err, "determining manifest MIME type for %s", transports.ImageName(srcRef))
 	}
+	if toplevelDigest != "" {
+		c.reportSourceDigestAccepted(types.DigestKindManifest, toplevelDigest)
+	}
+	if multiImage {
+		// Single-image manifests are validated by copyOneImage, after the policy check.
+		if err := c.validateManifestSchema(ctx, unparsedToplevel); err != nil {
+			return nil, err
+		}
+	}
 
+	// The digest of the source manifest corresponding to copiedManifest, used for copying referrers.
+	var copiedSourceDigest digest.Digest
 	if !multiImage {
 		// The simple case: just copy a single image.
 		if copiedManifest, _, _, err = c.copyOneImage(ctx, policyContext, options, unparsedToplevel, unparsedToplevel, nil); err != nil {
@@ -302,11 +707,16 @@ func Image(ctx context.Context, policyContext *signature.PolicyContext, destRef,
 			return nil, errors.Wrapf(err, "choosing an image from manifest list %s", transports.ImageName(srcRef))
 		}
 		logrus.Debugf("Source is a manifest list; copying (only) instance %s for current system", instanceDigest)
+		if err := c.checkSourceDigest(instanceDigest); err != nil {
+			return nil, err
+		}
 		unparsedInstance := image.UnparsedInstance(rawSource, &instanceDigest)
 
 		if copiedManifest, _, _, err = c.copyOneImage(ctx, policyContext, options, unparsedToplevel, unparsedInstance, nil); err != nil {
 			return nil, err
 		}
+		c.reportSourceDigestAccepted(types.DigestKindManifest, instanceDigest)
+		copiedSourceDigest = instanceDigest
 	} else { /* options.ImageListSelection == CopyAllImages or options.ImageListSelection == CopySpecificImages, */
 		// If we were asked to copy multiple images and can't, that's an error.
 		if !supportsMultipleImages(c.dest) {
@@ -324,11 +734,76 @@ func Image(ctx context.Context, policyContext *signature.PolicyContext, destRef,
 		}
 	}
 
+	if c.plan != nil {
+		return &Result{Manifest: copiedManifest, Plan: c.plan.result()}, nil
+	}
+
+	if referrersLister != nil {
+		if copiedSourceDigest == "" {
+			srcManifest, _, err := unparsedToplevel.Manifest(ctx)
+			if err != nil {
+				return nil, errors.Wrapf(err, "reading manifest for %s", transports.ImageName(srcRef))
+			}
+			if copiedSourceDigest, err = manifest.Digest(srcManifest); err != nil {
+				return nil, errors.Wrap(err, "computing manifest digest")
+			}
+		}
+		copiedDigest, err := manifest.Digest(copiedManifest)
+		if err != nil {
+			return nil, errors.Wrap(err, "computing manifest digest")
+		}
+		var newSubject *imgspecv1.Descriptor
+		if copiedDigest != copiedSourceDigest {
+			logrus.Debugf("Manifest %s was written as %s, updating subjects of referrers", copiedSourceDigest, copiedDigest)
+			newSubject = &imgspecv1.Descriptor{
+				MediaType: manifest.GuessMIMEType(copiedManifest),
+				Digest:    copiedDigest,
+				Size:      int64(len(copiedManifest)),
+			}
+		}
+		if err := c.copyReferrers(ctx, referrersLister, copiedSourceDigest, newSubject, options.CopyReferrers); err != nil {
+			return nil, err
+		}
+		if cosignReader != nil {
+			if err := c.copyCosignArtifacts(ctx, cosignReader, tagger, copiedSourceDigest, copiedDigest, newSubject, options.CopyReferrers); err != nil {
+				return nil, err
+			}
+		}
+	}
+
+	// Stored after copying referrers, so that signatures copied from the source are preserved.
+	if len(c.sigstoreSignatures) != 0 {
+		signaturePutStart := time.Now()
+		err := c.putSigstoreSignatures(ctx, tagger, options.RemoveSignatures)
+		c.timings.recordPhaseSince(PhaseSignaturePut, signaturePutStart)
+		if err != nil {
+			return nil, err
+		}
+	}
+
 	if err := c.dest.Commit(ctx, unparsedToplevel); err != nil {
 		return nil, errors.Wrap(err, "committing the finished image")
 	}
+	if reporter, ok := rawSource.(private.ReferrersFallbackReporter); ok && reporter.UsedReferrersFallback() {
+		report.record(DegradationReferrersFallback, fmt.Sprintf("referrers listed in %s using the referrers tag schema, because the registry does not support the referrers API",
+			transports.ImageName(srcRef)))
+	}
+	if reporter, ok := dest.(private.ReferrersFallbackReporter); ok && reporter.UsedReferrersFallback() {
+		report.record(DegradationReferrersFallback, fmt.Sprintf("referrers stored in %s using the referrers tag schema, because the registry does not support the referrers API",
+			transports.ImageName(destRef)))
+	}
 
-	return copiedManifest, nil
+	res = &Result{
+		Manifest:           copiedManifest,
+		ConvertedManifests: c.convertedManifests,
+		LayerAttempts:      c.layerAttempts.list(),
+		Statistics:         c.statistics.result(),
+	}
+	if len(options.AdditionalTags) != 0 {
+		res.AdditionalTags = c.putAdditionalTags(ctx, tagger, copiedManifest, options.AdditionalTags)
+	}
+	c.checkpoint.remove()
+	return res, nil
 }
 
 // Checks if the destination supports accepting multiple images by checking if it can support
@@ -398,6 +873,10 @@ func (c *copier) copyMultipleImages(ctx context.Context, policyContext *signatur
 	if err != nil {
 		return nil, errors.Wrapf(err, "parsing manifest list %q", string(manifestList))
 	}
+	originalListDigest, err := manifest.Digest(manifestList)
+	if err != nil {
+		return nil, errors.Wrapf(err, "computing digest of manifest list")
+	}
 	updatedList := originalList.Clone()
 
 	// Read and/or clear the set of signatures for this list.
@@ -448,6 +927,12 @@ func (c *copier) copyMultipleImages(ctx context.Context, policyContext *signatur
 	if options.PreserveDigests {
 		cannotModifyManifestListReason = "Instructed to preserve digests"
 	}
+	if c.annotationEditor != nil && cannotModifyManifestListReason != "" {
+		return nil, errors.Errorf("Annotations of the manifest list must be changed, but we cannot modify it: %q", cannotModifyManifestListReason)
+	}
+	if c.rewriteListSubject(updatedList) && cannotModifyManifestListReason != "" {
+		return nil, errors.Errorf("The subject of the manifest list must be rewritten, but we cannot modify it: %q", cannotModifyManifestListReason)
+	}
 
 	// Determine if we'll need to convert the manifest list to a different format.
 	forceListMIMEType := options.ForceManifestMIMEType
@@ -498,11 +983,15 @@ func (c *copier) copyMultipleImages(ctx context.Context, policyContext *signatur
 		}
 		logrus.Debugf("Copying instance %s (%d/%d)", instanceDigest, i+1, len(instanceDigests))
 		c.Printf("Copying image %s (%d/%d)\n", instanceDigest, instancesCopied+1, imagesToCopy)
+		if err := c.checkSourceDigest(instanceDigest); err != nil {
+			return nil, err
+		}
 		unparsedInstance := image.UnparsedInstance(c.rawSource, &instanceDigest)
 		updatedManifest, updatedManifestType, updatedManifestDigest, err := c.copyOneImage(ctx, policyContext, options, unparsedToplevel, unparsedInstance, &instanceDigest)
 		if err != nil {
 			return nil, err
 		}
+		c.reportSourceDigestAccepted(types.DigestKindManifest, instanceDigest)
 		instancesCopied++
 		// Record the result of a possible conversion here.
 		update := manifest.ListUpdate{
@@ -512,6 +1001,10 @@ func (c *copier) copyMultipleImages(ctx context.Context, policyContext *signatur
 		}
 		updates[i] = update
 	}
+	if c.plan != nil {
+		c.plan.recordManifest(originalListDigest, manifestType, selectedListType)
+		return manifestList, nil
+	}
 
 	// Now reset the digest/size/types of the manifests in the list to account for any conversions that we made.
 	if err = updatedList.UpdateInstances(updates); err != nil {
@@ -521,6 +1014,7 @@ func (c *copier) copyMultipleImages(ctx context.Context, policyContext *signatur
 	// Iterate through supported list types, preferred format first.
 	c.Printf("Writing manifest list to image destination\n")
 	var errs []string
+	var updatedListMIMEType string
 	for _, thisListType := range append([]string{selectedListType}, otherManifestMIMETypeCandidates...) {
 		attemptedList := updatedList
 
@@ -533,6 +1027,14 @@ func (c *copier) copyMultipleImages(ctx context.Context, policyContext *signatur
 				return nil, errors.Wrapf(err, "converting manifest list to list with MIME type %q", thisListType)
 			}
 		}
+		if c.annotationEditor != nil {
+			if attemptedList == updatedList {
+				attemptedList = updatedList.Clone()
+			}
+			if err := c.annotationEditor.updateList(attemptedList); err != nil {
+				return nil, err
+			}
+		}
 
 		// Check if the updates or a type conversion meaningfully changed the list of images
 		// by serializing them both so that we can compare them.
@@ -557,7 +1059,7 @@ func (c *copier) copyMultipleImages(ctx context.Context, policyContext *signatur
 		}
 
 		// Save the manifest list.
-		err = c.dest.PutManifest(ctx, attemptedManifestList, nil)
+		err = c.putManifest(ctx, attemptedManifestList, nil)
 		if err != nil {
 			logrus.Debugf("Upload of manifest list type %s failed: %v", thisListType, err)
 			errs = append(errs, fmt.Sprintf("%s(%v)", thisListType, err))
@@ -565,23 +1067,45 @@ func (c *copier) copyMultipleImages(ctx context.Context, policyContext *signatur
 		}
 		errs = nil
 		manifestList = attemptedManifestList
+		updatedListMIMEType = thisListType
+		if thisListType != originalList.MIMEType() && forceListMIMEType == "" {
+			c.degradations.record(DegradationManifestConversion, fmt.Sprintf("manifest list converted from %s to %s", originalList.MIMEType(), thisListType))
+		}
 		break
 	}
 	if errs != nil {
 		return nil, fmt.Errorf("Uploading manifest list failed, attempted the following formats: %s", strings.Join(errs, ", "))
 	}
+	listDigest, err := manifest.Digest(manifestList)
+	if err != nil {
+		return nil, errors.Wrapf(err, "computing digest of manifest list")
+	}
+	c.recordConvertedManifest(originalListDigest, imgspecv1.Descriptor{MediaType: updatedListMIMEType, Digest: listDigest, Size: int64(len(manifestList))})
 
 	// Sign the manifest list.
 	if options.SignBy != "" {
+		signingStart := time.Now()
 		newSig, err := c.createSignature(manifestList, options.SignBy, options.SignPassphrase, options.SignIdentity)
+		c.timings.recordPhaseSince(PhaseSigning, signingStart)
 		if err != nil {
 			return nil, err
 		}
 		sigs = append(sigs, newSig)
 	}
+	if c.sigstoreSigner != nil {
+		signingStart := time.Now()
+		err := c.createSigstoreSignature(ctx, manifestList, listDigest, options.SignIdentity)
+		c.timings.recordPhaseSince(PhaseSigning, signingStart)
+		if err != nil {
+			return nil, err
+		}
+	}
 
 	c.Printf("Storing list signatures\n")
-	if err := c.dest.PutSignatures(ctx, sigs, nil); err != nil {
+	signaturePutStart := time.Now()
+	err = c.dest.PutSignatures(ctx, sigs, nil)
+	c.timings.recordPhaseSince(PhaseSignaturePut, signaturePutStart)
+	if err != nil {
 		return nil, errors.Wrap(err, "writing signatures")
 	}
 
@@ -593,7 +1117,9 @@ func (c *copier) copyMultipleImages(ctx context.Context, policyContext *signatur
 func (c *copier) copyOneImage(ctx context.Context, policyContext *signature.PolicyContext, options *Options, unparsedToplevel, unparsedImage *image.UnparsedImage, targetInstance *digest.Digest) (retManifest []byte, retManifestType string, retManifestDigest digest.Digest, retErr error) {
 	// The caller is handling manifest lists; this could happen only if a manifest list contains a manifest list.
 	// Make sure we fail cleanly in such cases.
+	manifestFetchStart := time.Now()
 	multiImage, err := isMultiImage(ctx, unparsedImage)
+	c.timings.recordPhaseSince(PhaseManifestFetch, manifestFetchStart)
 	if err != nil {
 		// FIXME FIXME: How to name a reference for the sub-image?
 		return nil, "", "", errors.Wrapf(err, "determining manifest MIME type for %s", transports.ImageName(unparsedImage.Reference()))
@@ -605,13 +1131,22 @@ func (c *copier) copyOneImage(ctx context.Context, policyContext *signature.Poli
 	// Please keep this policy check BEFORE reading any other information about the image.
 	// (The multiImage check above only matches the MIME type, which we have received anyway.
 	// Actual parsing of anything should be deferred.)
-	if allowed, err := policyContext.IsRunningImageAllowed(ctx, unparsedImage); !allowed || err != nil { // Be paranoid and fail if either return value indicates so.
+	policyCheckStart := time.Now()
+	allowed, err := policyContext.IsRunningImageAllowed(ctx, unparsedImage)
+	c.timings.recordPhaseSince(PhasePolicyCheck, policyCheckStart)
+	if !allowed || err != nil { // Be paranoid and fail if either return value indicates so.
 		return nil, "", "", errors.Wrap(err, "Source image rejected")
 	}
+	if err := c.validateManifestSchema(ctx, unparsedImage); err != nil {
+		return nil, "", "", err
+	}
 	src, err := image.FromUnparsedImage(ctx, options.SourceCtx, unparsedImage)
 	if err != nil {
 		return nil, "", "", errors.Wrapf(err, "initializing image from source %s", transports.ImageName(c.rawSource.Reference()))
 	}
+	if err := c.validateConfigSchema(ctx, src); err != nil {
+		return nil, "", "", err
+	}
 
 	// If the destination is a digested reference, make a note of that, determine what digest value we're
 	// expecting, and check that the source manifest matches it.  If the source manifest doesn't, but it's
@@ -679,6 +1214,32 @@ func (c *copier) copyOneImage(ctx context.Context, policyContext *signature.Poli
 	if options.PreserveDigests {
 		cannotModifyManifestReason = "Instructed to preserve digests"
 	}
+	if c.annotationEditor != nil && cannotModifyManifestReason != "" {
+		return nil, "", "", errors.Errorf("Annotations of the image manifest must be changed, but we cannot modify it: %q", cannotModifyManifestReason)
+	}
+	if (c.modifyConfig != nil || c.modifyManifest != nil) && cannotModifyManifestReason != "" {
+		return nil, "", "", errors.Errorf("The image must be modified as requested, but we cannot modify it: %q", cannotModifyManifestReason)
+	}
+	srcManifest, srcManifestType, err := src.Manifest(ctx)
+	if err != nil {
+		return nil, "", "", errors.Wrapf(err, "reading manifest from source image")
+	}
+	subjectRewrite, err := c.manifestSubjectRewrite(srcManifest, srcManifestType)
+	if err != nil {
+		return nil, "", "", err
+	}
+	if subjectRewrite != nil && cannotModifyManifestReason != "" {
+		return nil, "", "", errors.Errorf("The subject of the image manifest must be rewritten, but we cannot modify it: %q", cannotModifyManifestReason)
+	}
+	// Artifacts which are not container images are copied without any image-specific processing: their layers
+	// are never decompressed, recompressed or substituted, and their manifests are never converted.
+	artifactConfigType, err := nonImageArtifactConfigType(srcManifest, srcManifestType)
+	if err != nil {
+		return nil, "", "", err
+	}
+	if artifactConfigType != "" && options.OciEncryptLayers != nil {
+		return nil, "", "", errors.Errorf("Encrypting an artifact with config type %q is not supported", artifactConfigType)
+	}
 
 	ic := imageCopier{
 		c:               c,
@@ -687,6 +1248,8 @@ func (c *copier) copyOneImage(ctx context.Context, policyContext *signature.Poli
 		// diffIDsAreNeeded is computed later
 		cannotModifyManifestReason: cannotModifyManifestReason,
 		ociEncryptLayers:           options.OciEncryptLayers,
+		subjectRewrite:             subjectRewrite,
+		artifactConfigType:         artifactConfigType,
 	}
 	// Ensure _this_ copy sees exactly the intended data when either processing a signed image or signing it.
 	// This may be too conservative, but for now, better safe than sorry, _especially_ on the SignBy path:
@@ -694,7 +1257,9 @@ func (c *copier) copyOneImage(ctx context.Context, policyContext *signature.Poli
 	// We do intend the RecordDigestUncompressedPair calls to only work with reliable data, but at least there’s a risk
 	// that the compressed version coming from a third party may be designed to attack some other decompressor implementation,
 	// and we would reuse and sign it.
-	ic.canSubstituteBlobs = ic.cannotModifyManifestReason == "" && options.SignBy == ""
+	// Reproducible copies must not substitute blobs either: a substitute may have been created by other tools, or with other parameters.
+	ic.canSubstituteBlobs = ic.cannotModifyManifestReason == "" && options.SignBy == "" && options.SignBySigstorePrivateKeyFile == "" && options.SignBySigstoreKeyless == nil && options.SignBySigstoreKMSKey == nil && options.Reproducible == nil &&
+		artifactConfigType == ""
 
 	if err := ic.updateEmbeddedDockerReference(); err != nil {
 		return nil, "", "", err
@@ -708,17 +1273,31 @@ func (c *copier) copyOneImage(ctx context.Context, policyContext *signature.Poli
 	if err != nil {
 		return nil, "", "", err
 	}
+	if c.strictMediaTypePreservation {
+		hasUnknownMediaTypes, err := checkStrictMediaTypePreservation(ctx, src, preferredManifestMIMEType)
+		if err != nil {
+			return nil, "", "", err
+		}
+		if hasUnknownMediaTypes {
+			otherManifestMIMETypeCandidates = nil
+		}
+	}
 
 	// If src.UpdatedImageNeedsLayerDiffIDs(ic.manifestUpdates) will be true, it needs to be true by the time we get here.
 	ic.diffIDsAreNeeded = src.UpdatedImageNeedsLayerDiffIDs(*ic.manifestUpdates)
 
+	if c.plan != nil {
+		return ic.planImage(ctx, preferredManifestMIMEType)
+	}
+
 	// If enabled, fetch and compare the destination's manifest. And as an optimization skip updating the destination iff equal
 	if options.OptimizeDestinationImageAlreadyExists {
-		shouldUpdateSigs := len(sigs) > 0 || options.SignBy != "" // TODO: Consider allowing signatures updates only and skipping the image's layers/manifest copy if possible
+		shouldUpdateSigs := len(sigs) > 0 || options.SignBy != "" || options.SignBySigstorePrivateKeyFile != "" || options.SignBySigstoreKeyless != nil || options.SignBySigstoreKMSKey != nil // TODO: Consider allowing signatures updates only and skipping the image's layers/manifest copy if possible
 		noPendingManifestUpdates := ic.noPendingManifestUpdates()
 
 		logrus.Debugf("Checking if we can skip copying: has signatures=%t, OCI encryption=%t, no manifest updates=%t", shouldUpdateSigs, destRequiresOciEncryption, noPendingManifestUpdates)
-		if !shouldUpdateSigs && !destRequiresOciEncryption && noPendingManifestUpdates {
+		if !shouldUpdateSigs && !destRequiresOciEncryption && noPendingManifestUpdates && ic.subjectRewrite == nil &&
+			c.modifyConfig == nil && c.modifyManifest == nil {
 			isSrcDestManifestEqual, retManifest, retManifestType, retManifestDigest, err := compareImageDestinationManifestEqual(ctx, options, src, targetInstance, c.dest)
 			if err != nil {
 				logrus.Warnf("Failed to compare destination image manifest: %v", err)
@@ -788,20 +1367,61 @@ func (c *copier) copyOneImage(ctx context.Context, policyContext *signature.Poli
 			return nil, "", "", fmt.Errorf("Uploading manifest failed, attempted the following formats: %s", strings.Join(errs, ", "))
 		}
 	}
+	// Conversions the caller asked for, directly or by requiring OCI encryption, are not degradations; falling back
+	// to a format other than the preferred one is.
+	requestedConversion := options.ForceManifestMIMEType != "" || destRequiresOciEncryption
+	if !requestedConversion || retManifestType != preferredManifestMIMEType {
+		_, srcType, err := src.Manifest(ctx)
+		if err != nil {
+			return nil, "", "", errors.Wrapf(err, "reading manifest from source image")
+		}
+		if normalizedSrcType := manifest.NormalizedMIMEType(srcType); retManifestType != normalizedSrcType {
+			message := fmt.Sprintf("manifest of %s converted from %s to %s", transports.ImageName(unparsedImage.Reference()), normalizedSrcType, retManifestType)
+			if retManifestType != preferredManifestMIMEType {
+				message = fmt.Sprintf("%s, after writing %s failed", message, preferredManifestMIMEType)
+			}
+			c.degradations.record(DegradationManifestConversion, message)
+		}
+	}
+	srcManifestDigest, err := manifest.Digest(srcManifest)
+	if err != nil {
+		return nil, "", "", errors.Wrapf(err, "computing digest of source image's manifest")
+	}
+	c.recordConvertedManifest(srcManifestDigest, imgspecv1.Descriptor{MediaType: retManifestType, Digest: retManifestDigest, Size: int64(len(manifestBytes))})
+	c.statistics.recordImage(ImageStatistics{
+		Platform:            imagePlatform(ctx, src),
+		SourceDigest:        srcManifestDigest,
+		SourceMIMEType:      manifest.NormalizedMIMEType(srcManifestType),
+		DestinationDigest:   retManifestDigest,
+		DestinationMIMEType: retManifestType,
+	})
 	if targetInstance != nil {
 		targetInstance = &retManifestDigest
 	}
 
 	if options.SignBy != "" {
+		signingStart := time.Now()
 		newSig, err := c.createSignature(manifestBytes, options.SignBy, options.SignPassphrase, options.SignIdentity)
+		c.timings.recordPhaseSince(PhaseSigning, signingStart)
 		if err != nil {
 			return nil, "", "", err
 		}
 		sigs = append(sigs, newSig)
 	}
+	if c.sigstoreSigner != nil {
+		signingStart := time.Now()
+		err := c.createSigstoreSignature(ctx, manifestBytes, retManifestDigest, options.SignIdentity)
+		c.timings.recordPhaseSince(PhaseSigning, signingStart)
+		if err != nil {
+			return nil, "", "", err
+		}
+	}
 
 	c.Printf("Storing signatures\n")
-	if err := c.dest.PutSignatures(ctx, sigs, targetInstance); err != nil {
+	signaturePutStart := time.Now()
+	err = c.dest.PutSignatures(ctx, sigs, targetInstance)
+	c.timings.recordPhaseSince(PhaseSignaturePut, signaturePutStart)
+	if err != nil {
 		return nil, "", "", errors.Wrap(err, "writing signatures")
 	}
 
@@ -937,7 +1557,16 @@ func (ic *imageCopier) copyLayers(ctx context.Context) error {
 				logrus.Debugf("Skipping foreign layer %q copy to %s", cld.destInfo.Digest, ic.c.dest.Reference().Transport().Name())
 			}
 		} else {
-			cld.destInfo, cld.diffID, cld.err = ic.copyLayer(ctx, srcLayer, toEncrypt, pool, index, srcRef, manifestLayerInfos[index].EmptyLayer)
+			layerCtx, span := tracing.Start(ctx, ic.c.tracerProvider, "copy layer",
+				attribute.String("blob.digest", srcLayer.Digest.String()), attribute.Int("layer.index", index))
+			cld.destInfo, cld.diffID, cld.err = ic.copyLayerWithRetries(layerCtx, srcLayer, toEncrypt, pool, index, srcRef, manifestLayerInfos[index].EmptyLayer)
+			tracing.End(span, cld.err)
+			if cld.err == nil && !toEncrypt {
+				ic.c.checkpoint.recordBlob(srcLayer.Digest, cld.destInfo)
+			}
+			if cld.err == nil && ic.mustPreserveLayer(srcLayer.MediaType) {
+				cld.destInfo, cld.err = checkLayerMediaTypePreserved(srcLayer, cld.destInfo)
+			}
 		}
 		data[index] = cld
 	}
@@ -1042,34 +1671,91 @@ func (ic *imageCopier) copyUpdatedConfigAndManifest(ctx context.Context, instanc
 		}
 		pendingImage = pi
 	}
-	man, _, err := pendingImage.Manifest(ctx)
+	man, manifestType, err := pendingImage.Manifest(ctx)
 	if err != nil {
 		return nil, "", errors.Wrap(err, "reading manifest")
 	}
-
-	if err := ic.c.copyConfig(ctx, pendingImage); err != nil {
-		return nil, "", err
+	if ic.c.modifyConfig != nil {
+		pendingImage, man, err = ic.applyModifyConfig(ctx, pendingImage, man, manifestType)
+		if err != nil {
+			return nil, "", err
+		}
+	}
+	if ic.c.annotationEditor != nil {
+		man, err = ic.c.annotationEditor.updateManifest(ctx, pendingImage, man)
+		if err != nil {
+			return nil, "", err
+		}
+	}
+	if ic.subjectRewrite != nil && manifest.NormalizedMIMEType(manifestType) == imgspecv1.MediaTypeImageManifest {
+		man, err = rewriteManifestSubject(man, ic.subjectRewrite)
+		if err != nil {
+			return nil, "", err
+		}
+	}
+	if ic.c.modifyManifest != nil {
+		man, err = ic.applyModifyManifest(ctx, pendingImage, man, manifestType)
+		if err != nil {
+			return nil, "", err
+		}
 	}
 
-	ic.c.Printf("Writing manifest to image destination\n")
 	manifestDigest, err := manifest.Digest(man)
 	if err != nil {
 		return nil, "", err
 	}
+	if err := ic.c.checkDestinationImage(ctx, pendingImage, man, manifestDigest); err != nil {
+		return nil, "", err
+	}
+
+	if err := ic.c.copyConfig(ctx, pendingImage); err != nil {
+		return nil, "", err
+	}
+
+	ic.c.Printf("Writing manifest to image destination\n")
 	if instanceDigest != nil {
 		instanceDigest = &manifestDigest
 	}
-	if err := ic.c.dest.PutManifest(ctx, man, instanceDigest); err != nil {
+	err = ic.c.putManifest(ctx, man, instanceDigest)
+	if err != nil {
 		logrus.Debugf("Error %v while writing manifest %q", err, string(man))
 		return nil, "", errors.Wrapf(err, "writing manifest")
 	}
 	return man, manifestDigest, nil
 }
 
+// putManifest writes man (with instanceDigest, if not nil) to c.dest, recording the time it takes.
+func (c *copier) putManifest(ctx context.Context, man []byte, instanceDigest *digest.Digest) error {
+	ctx, span := tracing.Start(ctx, c.tracerProvider, "write manifest", attribute.String("manifest.digest", digest.FromBytes(man).String()))
+	manifestPutStart := time.Now()
+	err := c.dest.PutManifest(ctx, man, instanceDigest)
+	c.timings.recordPhaseSince(PhaseManifestPut, manifestPutStart)
+	tracing.End(span, err)
+	if err == nil && c.progressEvents != nil {
+		manifestDigest, digestErr := manifest.Digest(man)
+		if digestErr != nil {
+			manifestDigest = digest.FromBytes(man)
+		}
+		c.progressEvents.report(ProgressEvent{
+			Kind:       ProgressEventManifestWritten,
+			Digest:     manifestDigest,
+			LayerIndex: -1,
+			Size:       int64(len(man)),
+			MediaType:  manifest.GuessMIMEType(man),
+		})
+	}
+	return err
+}
+
 // copyConfig copies config.json, if any, from src to dest.
-func (c *copier) copyConfig(ctx context.Context, src types.Image) error {
+func (c *copier) copyConfig(ctx context.Context, src types.Image) (retErr error) {
 	srcInfo := src.ConfigInfo()
 	if srcInfo.Digest != "" {
+		ctx, span := tracing.Start(ctx, c.tracerProvider, "copy config", attribute.String("blob.digest", srcInfo.Digest.String()))
+		defer func() { tracing.End(span, retErr) }()
+		if err := c.checkSourceDigest(srcInfo.Digest); err != nil {
+			return err
+		}
 		if err := c.concurrentBlobCopiesSemaphore.Acquire(ctx, 1); err != nil {
 			// This can only fail with ctx.Err(), so no need to blame acquiring the semaphore.
 			return fmt.Errorf("copying config: %w", err)
@@ -1082,12 +1768,15 @@ func (c *copier) copyConfig(ctx context.Context, src types.Image) error {
 			bar := c.createProgressBar(progressPool, false, srcInfo, "config", "done")
 			defer bar.Abort(false)
 
+			timer := &blobTimer{}
+			configStart := time.Now()
 			configBlob, err := src.ConfigBlob(ctx)
+			timer.openDuration = time.Since(configStart)
 			if err != nil {
 				return types.BlobInfo{}, errors.Wrapf(err, "reading config blob %s", srcInfo.Digest)
 			}
 
-			destInfo, err := c.copyBlobFromStream(ctx, bytes.NewReader(configBlob), srcInfo, nil, false, true, false, bar, -1, false)
+			destInfo, err := c.copyBlobFromStream(ctx, bytes.NewReader(configBlob), timer, srcInfo, nil, false, true, false, bar, -1, false)
 			if err != nil {
 				return types.BlobInfo{}, err
 			}
@@ -1136,6 +1825,22 @@ func (ic *imageCopier) copyLayer(ctx context.Context, srcInfo types.BlobInfo, to
 		}
 	}
 
+	if err := ic.c.checkSourceDigest(srcInfo.Digest); err != nil {
+		return types.BlobInfo{}, "", err
+	}
+
+	// Layers of non-image artifacts, and layers with unknown media types if strict media type preservation is enabled,
+	// must not be modified in any way.
+	canModifyBlob := ic.cannotModifyManifestReason == ""
+	canSubstitute := ic.canSubstituteBlobs
+	if ic.mustPreserveLayer(srcInfo.MediaType) {
+		if toEncrypt {
+			return types.BlobInfo{}, "", errors.Errorf("Encrypting layer %s would change its media type %q, and strict media type preservation is enabled", srcInfo.Digest, srcInfo.MediaType)
+		}
+		canModifyBlob = false
+		canSubstitute = false
+	}
+
 	cachedDiffID := ic.c.blobInfoCache.UncompressedDigest(srcInfo.Digest) // May be ""
 	diffIDIsNeeded := ic.diffIDsAreNeeded && cachedDiffID == ""
 	// When encrypting to decrypting, only use the simple code path. We might be able to optimize more
@@ -1152,15 +1857,31 @@ func (ic *imageCopier) copyLayer(ctx context.Context, srcInfo types.BlobInfo, to
 		// a failure when we eventually try to update the manifest with the digest and MIME type of the reused blob.
 		// Fixing that will probably require passing more information to TryReusingBlob() than the current version of
 		// the ImageDestination interface lets us pass in.
-		reused, blobInfo, err := ic.c.dest.TryReusingBlobWithOptions(ctx, srcInfo, private.TryReusingBlobOptions{
+		reuseOptions := private.TryReusingBlobOptions{
 			Cache:         ic.c.blobInfoCache,
-			CanSubstitute: ic.canSubstituteBlobs,
+			CanSubstitute: canSubstitute,
 			EmptyLayer:    emptyLayer,
 			LayerIndex:    &layerIndex,
 			SrcRef:        srcRef,
-		})
-		if err != nil {
-			return types.BlobInfo{}, "", errors.Wrapf(err, "trying to reuse blob %s at destination", srcInfo.Digest)
+		}
+		reused, blobInfo := false, types.BlobInfo{}
+		event := ProgressEventBlobSkipped
+		if canModifyBlob && canSubstitute {
+			var err error
+			blobInfo, reused, err = ic.c.checkpoint.tryReusingBlob(ctx, ic.c.dest, srcInfo, reuseOptions)
+			if err != nil {
+				return types.BlobInfo{}, "", errors.Wrapf(err, "trying to reuse checkpointed copy of blob %s at destination", srcInfo.Digest)
+			}
+			if reused {
+				event = ProgressEventBlobResumed
+			}
+		}
+		if !reused {
+			var err error
+			reused, blobInfo, err = ic.c.dest.TryReusingBlobWithOptions(ctx, srcInfo, reuseOptions)
+			if err != nil {
+				return types.BlobInfo{}, "", errors.Wrapf(err, "trying to reuse blob %s at destination", srcInfo.Digest)
+			}
 		}
 		if reused {
 			logrus.Debugf("Skipping blob %s (already present):", srcInfo.Digest)
@@ -1177,6 +1898,12 @@ func (ic *imageCopier) copyLayer(ctx context.Context, srcInfo types.BlobInfo, to
 					Artifact: srcInfo,
 				}
 			}
+			if ic.c.progressEvents != nil {
+				e := blobEvent(event, srcInfo, false, layerIndex)
+				e.DestinationDigest = blobInfo.Digest
+				e.DestinationSize = blobInfo.Size
+				ic.c.progressEvents.report(e)
+			}
 
 			// If the reused blob has the same digest as the one we asked for, but
 			// the transport didn't/couldn't supply compression info, fill it in based
@@ -1190,6 +1917,7 @@ func (ic *imageCopier) copyLayer(ctx context.Context, srcInfo types.BlobInfo, to
 				blobInfo.CompressionOperation = srcInfo.CompressionOperation
 				blobInfo.CompressionAlgorithm = srcInfo.CompressionAlgorithm
 			}
+			ic.c.statistics.recordReusedBlob(blobInfo.Size, reusedLayerStatistics(srcInfo, blobInfo))
 			return blobInfo, cachedDiffID, nil
 		}
 	}
@@ -1210,13 +1938,28 @@ func (ic *imageCopier) copyLayer(ctx context.Context, srcInfo types.BlobInfo, to
 				wrapped: ic.c.rawSource,
 				bar:     bar,
 			}
+			partialStart := time.Now()
 			info, err := ic.c.dest.PutBlobPartial(ctx, &proxy, srcInfo, ic.c.blobInfoCache)
 			if err == nil {
+				// The destination reads the chunks it needs and writes the blob in a single operation; attribute all of it to downloading.
+				ic.c.timings.recordBlob(BlobTiming{Digest: srcInfo.Digest, Download: time.Since(partialStart)})
 				if srcInfo.Size != -1 {
 					bar.SetRefill(srcInfo.Size - bar.Current())
 				}
+				// Only the chunks which were not present in the destination were downloaded; the rest was reused.
+				layer := reusedLayerStatistics(srcInfo, info)
+				layer.Reused = false
+				ic.c.statistics.recordBlob(bar.Current(), info.Size, layer)
 				bar.mark100PercentComplete()
 				hideProgressBar = false
+				if ic.c.progressEvents != nil {
+					e := blobEvent(ProgressEventBlobDone, srcInfo, false, layerIndex)
+					e.Transferred = bar.Current()
+					e.DestinationDigest = info.Digest
+					e.DestinationSize = info.Size
+					e.CompressionOperation = info.CompressionOperation
+					ic.c.progressEvents.report(e)
+				}
 				logrus.Debugf("Retrieved partial blob %v", srcInfo.Digest)
 				return true, info
 			}
@@ -1232,13 +1975,16 @@ func (ic *imageCopier) copyLayer(ctx context.Context, srcInfo types.BlobInfo, to
 		bar := ic.c.createProgressBar(pool, false, srcInfo, "blob", "done")
 		defer bar.Abort(false)
 
+		timer := &blobTimer{}
+		getBlobStart := time.Now()
 		srcStream, srcBlobSize, err := ic.c.rawSource.GetBlob(ctx, srcInfo, ic.c.blobInfoCache)
+		timer.openDuration = time.Since(getBlobStart)
 		if err != nil {
 			return types.BlobInfo{}, "", errors.Wrapf(err, "reading blob %s", srcInfo.Digest)
 		}
 		defer srcStream.Close()
 
-		blobInfo, diffIDChan, err := ic.copyLayerFromStream(ctx, srcStream, types.BlobInfo{Digest: srcInfo.Digest, Size: srcBlobSize, MediaType: srcInfo.MediaType, Annotations: srcInfo.Annotations}, diffIDIsNeeded, toEncrypt, bar, layerIndex, emptyLayer)
+		blobInfo, diffIDChan, err := ic.copyLayerFromStream(ctx, srcStream, timer, types.BlobInfo{Digest: srcInfo.Digest, Size: srcBlobSize, MediaType: srcInfo.MediaType, Annotations: srcInfo.Annotations}, diffIDIsNeeded, canModifyBlob, toEncrypt, bar, layerIndex, emptyLayer)
 		if err != nil {
 			return types.BlobInfo{}, "", err
 		}
@@ -1275,10 +2021,10 @@ func (ic *imageCopier) copyLayer(ctx context.Context, srcInfo types.BlobInfo, to
 
 // copyLayerFromStream is an implementation detail of copyLayer; mostly providing a separate “defer” scope.
 // it copies a blob with srcInfo (with known Digest and Annotations and possibly known Size) from srcStream to dest,
-// perhaps (de/re/)compressing the stream,
+// perhaps (de/re/)compressing the stream if canModifyBlob,
 // and returns a complete blobInfo of the copied blob and perhaps a <-chan diffIDResult if diffIDIsNeeded, to be read by the caller.
-func (ic *imageCopier) copyLayerFromStream(ctx context.Context, srcStream io.Reader, srcInfo types.BlobInfo,
-	diffIDIsNeeded bool, toEncrypt bool, bar *progressBar, layerIndex int, emptyLayer bool) (types.BlobInfo, <-chan diffIDResult, error) {
+func (ic *imageCopier) copyLayerFromStream(ctx context.Context, srcStream io.Reader, timer *blobTimer, srcInfo types.BlobInfo,
+	diffIDIsNeeded bool, canModifyBlob bool, toEncrypt bool, bar *progressBar, layerIndex int, emptyLayer bool) (types.BlobInfo, <-chan diffIDResult, error) {
 	var getDiffIDRecorder func(compressiontypes.DecompressorFunc) io.Writer // = nil
 	var diffIDChan chan diffIDResult
 
@@ -1303,7 +2049,7 @@ func (ic *imageCopier) copyLayerFromStream(ctx context.Context, srcStream io.Rea
 		}
 	}
 
-	blobInfo, err := ic.c.copyBlobFromStream(ctx, srcStream, srcInfo, getDiffIDRecorder, ic.cannotModifyManifestReason == "", false, toEncrypt, bar, layerIndex, emptyLayer) // Sets err to nil on success
+	blobInfo, err := ic.c.copyBlobFromStream(ctx, srcStream, timer, srcInfo, getDiffIDRecorder, canModifyBlob, false, toEncrypt, bar, layerIndex, emptyLayer) // Sets err to nil on success
 	return blobInfo, diffIDChan, err
 	// We need the defer … pipeWriter.CloseWithError() to happen HERE so that the caller can block on reading from diffIDChan
 }
@@ -1353,7 +2099,7 @@ func (r errorAnnotationReader) Read(b []byte) (n int, err error) {
 // perhaps sending a copy to an io.Writer if getOriginalLayerCopyWriter != nil,
 // perhaps (de/re/)compressing it if canModifyBlob,
 // and returns a complete blobInfo of the copied blob.
-func (c *copier) copyBlobFromStream(ctx context.Context, srcStream io.Reader, srcInfo types.BlobInfo,
+func (c *copier) copyBlobFromStream(ctx context.Context, srcStream io.Reader, timer *blobTimer, srcInfo types.BlobInfo,
 	getOriginalLayerCopyWriter func(decompressor compressiontypes.DecompressorFunc) io.Writer,
 	canModifyBlob bool, isConfig bool, toEncrypt bool, bar *progressBar, layerIndex int, emptyLayer bool) (types.BlobInfo, error) {
 	if isConfig { // This is guaranteed by the caller, but set it here to be explicit.
@@ -1362,6 +2108,14 @@ func (c *copier) copyBlobFromStream(ctx context.Context, srcStream io.Reader, sr
 
 	// The copying happens through a pipeline of connected io.Readers.
 	// === Input: srcStream
+	originalDigest := srcInfo.Digest // srcInfo.Digest is modified if the blob is decrypted.
+	originalSize := srcInfo.Size
+	srcStream = timer.sourceReads.wrap(srcStream)
+	downloaded := &byteCounter{}
+	srcStream = downloaded.wrap(srcStream)
+	blobStarted := blobEvent(ProgressEventBlobStarted, srcInfo, isConfig, layerIndex)
+	eventStream := newProgressEventReader(srcStream, c.progressEvents, blobStarted)
+	srcStream = eventStream
 
 	// === Process input through digestingReader to validate against the expected digest.
 	// Be paranoid; in case PutBlob somehow managed to ignore an error from digestingReader,
@@ -1411,6 +2165,18 @@ func (c *copier) copyBlobFromStream(ctx context.Context, srcStream io.Reader, sr
 	if expectedCompressionFormat, known := expectedCompressionFormats[srcInfo.MediaType]; known && isCompressed && compressionFormat.Name() != expectedCompressionFormat.Name() {
 		logrus.Debugf("blob %s with type %s should be compressed with %s, but compressor appears to be %s", srcInfo.Digest.String(), srcInfo.MediaType, expectedCompressionFormat.Name(), compressionFormat.Name())
 	}
+	desiredCompressionFormat, desiredCompressionLevel := c.compressionFormat, c.compressionLevel
+	if canModifyBlob && !isOciEncrypted(srcInfo.MediaType) {
+		info := LayerCompressionInfo{Digest: srcInfo.Digest, Size: srcInfo.Size, MediaType: srcInfo.MediaType, LayerIndex: layerIndex}
+		if isCompressed {
+			info.SourceCompression = &compressionFormat
+		}
+		var preserve bool
+		desiredCompressionFormat, desiredCompressionLevel, preserve = c.layerCompression(info)
+		if preserve {
+			canModifyBlob = false
+		}
+	}
 
 	// === Send a copy of the original, uncompressed, stream, to a separate path if necessary.
 	var originalLayerReader io.Reader // DO NOT USE this other than to drain the input if no other consumer in the pipeline has done so.
@@ -1431,6 +2197,7 @@ func (c *copier) copyBlobFromStream(ctx context.Context, srcStream io.Reader, sr
 		srcCompressorName = compressionFormat.Name()
 	}
 	var uploadCompressorName string
+	var uncompressed *byteCounter // Counts the uncompressed layer contents, if they are processed; nil otherwise.
 	if canModifyBlob && isOciEncrypted(srcInfo.MediaType) {
 		// PreserveOriginal due to any compression not being able to be done on an encrypted blob unless decrypted
 		logrus.Debugf("Using original blob without modification for encrypted blob")
@@ -1445,21 +2212,22 @@ func (c *copier) copyBlobFromStream(ctx context.Context, srcStream io.Reader, sr
 		pipeReader, pipeWriter := io.Pipe()
 		defer pipeReader.Close()
 
-		if c.compressionFormat != nil {
-			uploadCompressionFormat = c.compressionFormat
+		if desiredCompressionFormat != nil {
+			uploadCompressionFormat = desiredCompressionFormat
 		} else {
 			uploadCompressionFormat = defaultCompressionFormat
 		}
 		// If this fails while writing data, it will do pipeWriter.CloseWithError(); if it fails otherwise,
 		// e.g. because we have exited and due to pipeReader.Close() above further writing to the pipe has failed,
 		// we don’t care.
-		go c.compressGoroutine(pipeWriter, destStream, compressionMetadata, *uploadCompressionFormat) // Closes pipeWriter
+		uncompressed = &byteCounter{}
+		go c.compressGoroutine(pipeWriter, uncompressed.wrap(destStream), compressionMetadata, *uploadCompressionFormat, desiredCompressionLevel) // Closes pipeWriter
 		destStream = pipeReader
 		inputInfo.Digest = ""
 		inputInfo.Size = -1
 		uploadCompressorName = uploadCompressionFormat.Name()
 	} else if canModifyBlob && c.dest.DesiredLayerCompression() == types.Compress && isCompressed &&
-		c.compressionFormat != nil && c.compressionFormat.Name() != compressionFormat.Name() {
+		desiredCompressionFormat != nil && desiredCompressionFormat.Name() != compressionFormat.Name() {
 		// When the blob is compressed, but the desired format is different, it first needs to be decompressed and finally
 		// re-compressed using the desired format.
 		logrus.Debugf("Blob will be converted")
@@ -1474,8 +2242,9 @@ func (c *copier) copyBlobFromStream(ctx context.Context, srcStream io.Reader, sr
 		pipeReader, pipeWriter := io.Pipe()
 		defer pipeReader.Close()
 
-		uploadCompressionFormat = c.compressionFormat
-		go c.compressGoroutine(pipeWriter, s, compressionMetadata, *uploadCompressionFormat) // Closes pipeWriter
+		uploadCompressionFormat = desiredCompressionFormat
+		uncompressed = &byteCounter{}
+		go c.compressGoroutine(pipeWriter, uncompressed.wrap(s), compressionMetadata, *uploadCompressionFormat, desiredCompressionLevel) // Closes pipeWriter
 
 		destStream = pipeReader
 		inputInfo.Digest = ""
@@ -1489,7 +2258,8 @@ func (c *copier) copyBlobFromStream(ctx context.Context, srcStream io.Reader, sr
 			return types.BlobInfo{}, err
 		}
 		defer s.Close()
-		destStream = s
+		uncompressed = &byteCounter{}
+		destStream = uncompressed.wrap(s)
 		inputInfo.Digest = ""
 		inputInfo.Size = -1
 		uploadCompressionFormat = nil
@@ -1509,6 +2279,11 @@ func (c *copier) copyBlobFromStream(ctx context.Context, srcStream io.Reader, sr
 			uploadCompressionFormat = nil
 		}
 		uploadCompressorName = srcCompressorName
+		if !isConfig && desiredCompressionFormat != nil && c.dest.DesiredLayerCompression() == types.Compress &&
+			uploadCompressorName != desiredCompressionFormat.Name() {
+			c.degradations.record(DegradationCompressionChange, fmt.Sprintf("layer %s written with compression %s instead of the requested %s, because the layer can not be modified",
+				srcInfo.Digest, uploadCompressorName, desiredCompressionFormat.Name()))
+		}
 	}
 
 	// === Encrypt the stream for valid mediatypes if ociEncryptConfig provided
@@ -1558,6 +2333,19 @@ func (c *copier) copyBlobFromStream(ctx context.Context, srcStream io.Reader, sr
 		destStream = progressReader
 	}
 
+	// === Export the layer stream, if required.
+	var export *blobExport
+	if !isConfig && c.blobExporter != nil {
+		export, err = c.newBlobExport(ctx, destStream, inputInfo)
+		if err != nil {
+			return types.BlobInfo{}, err
+		}
+		if export != nil {
+			defer export.abortUnlessDone()
+			destStream = export
+		}
+	}
+
 	// === Finally, send the layer stream to dest.
 	options := private.PutBlobOptions{
 		Cache:      c.blobInfoCache,
@@ -1567,7 +2355,17 @@ func (c *copier) copyBlobFromStream(ctx context.Context, srcStream io.Reader, sr
 	if !isConfig {
 		options.LayerIndex = &layerIndex
 	}
+	destStream = timer.inputReads.wrap(destStream)
+	if c.concurrentBlobUploadsSemaphore != nil {
+		if err := c.concurrentBlobUploadsSemaphore.Acquire(ctx, 1); err != nil {
+			// This can only fail with ctx.Err(), so no need to blame acquiring the semaphore.
+			return types.BlobInfo{}, fmt.Errorf("writing blob: %w", err)
+		}
+		defer c.concurrentBlobUploadsSemaphore.Release(1)
+	}
+	timer.startPut()
 	uploadedInfo, err := c.dest.PutBlobWithOptions(ctx, &errorAnnotationReader{destStream}, inputInfo, options)
+	timer.endPut()
 	if err != nil {
 		return types.BlobInfo{}, errors.Wrap(err, "writing blob")
 	}
@@ -1612,6 +2410,7 @@ func (c *copier) copyBlobFromStream(ctx context.Context, srcStream io.Reader, sr
 		return types.BlobInfo{}, errors.Errorf("Internal error writing blob %s, blob with digest %s saved with digest %s", srcInfo.Digest, inputInfo.Digest, uploadedInfo.Digest)
 	}
 	if digestingReader.validationSucceeded {
+		c.reportSourceDigestAccepted(types.DigestKindBlob, digestingReader.expectedDigest)
 		// Don’t record any associations that involve encrypted data. This is a bit crude,
 		// some blob substitutions (replacing pulls of encrypted data with local reuse of known decryption outcomes)
 		// might be safe, but it’s not trivially obvious, so let’s be conservative for now.
@@ -1650,6 +2449,41 @@ func (c *copier) copyBlobFromStream(ctx context.Context, srcStream io.Reader, sr
 		uploadedInfo.Annotations[k] = v
 	}
 
+	if export != nil {
+		if err := export.commit(uploadedInfo, digestingReader.validationSucceeded); err != nil {
+			return types.BlobInfo{}, err
+		}
+	}
+
+	c.timings.recordBlob(timer.timing(originalDigest))
+	var layer *LayerStatistics
+	if !isConfig {
+		layer = &LayerStatistics{
+			SourceDigest:           originalDigest,
+			SourceSize:             originalSize,
+			DestinationDigest:      uploadedInfo.Digest,
+			DestinationSize:        uploadedInfo.Size,
+			UncompressedSize:       -1,
+			SourceCompression:      srcCompressorName,
+			DestinationCompression: uploadCompressorName,
+		}
+		switch {
+		case uncompressed != nil:
+			layer.UncompressedSize = uncompressed.count()
+		case uploadCompressorName == internalblobinfocache.Uncompressed && !encrypted:
+			layer.UncompressedSize = uploadedInfo.Size
+		}
+	}
+	c.statistics.recordBlob(downloaded.count(), uploadedInfo.Size, layer)
+	if c.progressEvents != nil {
+		e := blobStarted
+		e.Kind = ProgressEventBlobDone
+		e.Transferred = transferred(eventStream)
+		e.DestinationDigest = uploadedInfo.Digest
+		e.DestinationSize = uploadedInfo.Size
+		e.CompressionOperation = uploadedInfo.CompressionOperation
+		c.progressEvents.report(e)
+	}
 	return uploadedInfo, nil
 }
 
@@ -1671,12 +2505,16 @@ func doCompression(dest io.Writer, src io.Reader, metadata map[string]string, co
 	return compressor.Close()
 }
 
-// compressGoroutine reads all input from src and writes its compressed equivalent to dest.
-func (c *copier) compressGoroutine(dest *io.PipeWriter, src io.Reader, metadata map[string]string, compressionFormat compressiontypes.Algorithm) {
+// compressGoroutine reads all input from src and writes its compressed equivalent, using compressionFormat and compressionLevel
+// (if not nil), to dest.
+func (c *copier) compressGoroutine(dest *io.PipeWriter, src io.Reader, metadata map[string]string, compressionFormat compressiontypes.Algorithm, compressionLevel *int) {
 	err := errors.New("Internal error: unexpected panic in compressGoroutine")
 	defer func() { // Note that this is not the same as {defer dest.CloseWithError(err)}; we need err to be evaluated lazily.
 		_ = dest.CloseWithError(err) // CloseWithError(nil) is equivalent to Close(), always returns nil
 	}()
 
-	err = doCompression(dest, src, metadata, compressionFormat, c.compressionLevel)
+	if c.reproducible {
+		compressionLevel = reproducibleCompressionLevel(compressionFormat, compressionLevel)
+	}
+	err = doCompression(dest, src, metadata, compressionFormat, compressionLevel)
 }
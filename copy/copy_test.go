@@ -2,17 +2,29 @@ package copy
 
 import (
 	"bytes"
+	"context"
 	"io"
 	"os"
+	"path/filepath"
+	"sync"
 	"testing"
 	"time"
 
+	"github.com/containers/image/v5/manifest"
+	"github.com/containers/image/v5/oci/layout"
 	"github.com/containers/image/v5/pkg/compression"
 	compressiontypes "github.com/containers/image/v5/pkg/compression/types"
+	"github.com/containers/image/v5/signature"
+	"github.com/containers/image/v5/transports"
+	"github.com/containers/image/v5/types"
 	digest "github.com/opencontainers/go-digest"
+	imgspecv1 "github.com/opencontainers/image-spec/specs-go/v1"
 	"github.com/pkg/errors"
 	"github.com/stretchr/testify/assert"
 	"github.com/stretchr/testify/require"
+	"go.opentelemetry.io/otel/attribute"
+	sdktrace "go.opentelemetry.io/otel/sdk/trace"
+	"go.opentelemetry.io/otel/sdk/trace/tracetest"
 )
 
 func goDiffIDComputationGoroutineWithTimeout(layerStream io.ReadCloser, decompressor compressiontypes.DecompressorFunc) *diffIDResult {
@@ -77,3 +89,201 @@ func TestComputeDiffID(t *testing.T) {
 	_, err = computeDiffID(reader, nil)
 	assert.Error(t, err)
 }
+
+// newTestOCIImage creates an OCI layout containing an image with a config and a single layer, and returns
+// a reference to it, and the digests of the config and the layer.
+func newTestOCIImage(t *testing.T) (types.ImageReference, digest.Digest, digest.Digest) {
+	config := []byte(`{"architecture":"amd64","os":"linux","rootfs":{"type":"layers","diff_ids":[]}}`)
+	layer := []byte("uncompressed layer contents")
+	m := manifest.OCI1FromComponents(imgspecv1.Descriptor{
+		MediaType: imgspecv1.MediaTypeImageConfig,
+		Digest:    digest.FromBytes(config),
+		Size:      int64(len(config)),
+	}, []imgspecv1.Descriptor{{
+		MediaType: imgspecv1.MediaTypeImageLayer,
+		Digest:    digest.FromBytes(layer),
+		Size:      int64(len(layer)),
+	}})
+	m.SchemaVersion = 2
+	man, err := m.Serialize()
+	require.NoError(t, err)
+	srcDir := t.TempDir()
+	writeOCILayout(t, srcDir, "src", man)
+	for _, blob := range [][]byte{config, layer} {
+		d := digest.FromBytes(blob)
+		require.NoError(t, os.WriteFile(filepath.Join(srcDir, "blobs", d.Algorithm().String(), d.Hex()), blob, 0644))
+	}
+	srcRef, err := layout.NewReference(srcDir, "src")
+	require.NoError(t, err)
+	return srcRef, digest.FromBytes(config), digest.FromBytes(layer)
+}
+
+func TestCopyTracing(t *testing.T) {
+	srcRef, _, layerDigest := newTestOCIImage(t)
+	destRef, err := layout.NewReference(t.TempDir(), "dest")
+	require.NoError(t, err)
+	policyContext, err := signature.NewPolicyContext(&signature.Policy{Default: []signature.PolicyRequirement{signature.NewPRInsecureAcceptAnything()}})
+	require.NoError(t, err)
+	defer func() { _ = policyContext.Destroy() }()
+
+	recorder := tracetest.NewSpanRecorder()
+	tp := sdktrace.NewTracerProvider(sdktrace.WithSpanProcessor(recorder))
+	_, err = Image(context.Background(), policyContext, destRef, srcRef, &Options{TracerProvider: tp})
+	require.NoError(t, err)
+
+	spans := recorder.Ended()
+	names := []string{}
+	for _, span := range spans {
+		names = append(names, span.Name())
+	}
+	assert.Equal(t, []string{"copy layer", "copy config", "write manifest", "copy image"}, names)
+	for _, span := range spans[:3] {
+		assert.Equal(t, spans[3].SpanContext().SpanID(), span.Parent().SpanID(), span.Name())
+	}
+	assert.Contains(t, spans[0].Attributes(), attribute.String("blob.digest", layerDigest.String()))
+	assert.Contains(t, spans[3].Attributes(), attribute.String("image.source", transports.ImageName(srcRef)))
+}
+
+func TestCopyDigestPolicy(t *testing.T) {
+	srcRef, configDigest, layerDigest := newTestOCIImage(t)
+	policyContext, err := signature.NewPolicyContext(&signature.Policy{Default: []signature.PolicyRequirement{signature.NewPRInsecureAcceptAnything()}})
+	require.NoError(t, err)
+	defer func() { _ = policyContext.Destroy() }()
+
+	// Allowed algorithms are accepted, and reported
+	destRef, err := layout.NewReference(t.TempDir(), "dest")
+	require.NoError(t, err)
+	accepted := []types.DigestAcceptance{}
+	_, err = Image(context.Background(), policyContext, destRef, srcRef, &Options{
+		SourceCtx: &types.SystemContext{DigestPolicy: &types.DigestPolicy{
+			AllowedAlgorithms:     []digest.Algorithm{digest.SHA256, digest.SHA512},
+			DigestAcceptedHandler: func(a types.DigestAcceptance) { accepted = append(accepted, a) },
+		}},
+	})
+	require.NoError(t, err)
+	assert.Equal(t, []types.DigestAcceptance{
+		{Image: transports.ImageName(srcRef), Kind: types.DigestKindBlob, Digest: layerDigest},
+		{Image: transports.ImageName(srcRef), Kind: types.DigestKindBlob, Digest: configDigest},
+	}, accepted)
+
+	// Other algorithms are rejected
+	destRef, err = layout.NewReference(t.TempDir(), "dest")
+	require.NoError(t, err)
+	_, err = Image(context.Background(), policyContext, destRef, srcRef, &Options{
+		SourceCtx: &types.SystemContext{DigestPolicy: &types.DigestPolicy{
+			AllowedAlgorithms: []digest.Algorithm{digest.SHA512},
+		}},
+	})
+	var notAllowed types.DigestAlgorithmNotAllowedError
+	require.ErrorAs(t, err, &notAllowed)
+	assert.Equal(t, layerDigest, notAllowed.Digest)
+}
+
+// threadSafeSourceReference is a types.ImageReference whose sources claim to support concurrent GetBlob calls, which
+// is true for OCI layouts used by a single process, although the OCI layout transport does not promise it.
+type threadSafeSourceReference struct {
+	types.ImageReference
+}
+
+func (ref threadSafeSourceReference) NewImageSource(ctx context.Context, sys *types.SystemContext) (types.ImageSource, error) {
+	src, err := ref.ImageReference.NewImageSource(ctx, sys)
+	if err != nil {
+		return nil, err
+	}
+	return threadSafeSource{src}, nil
+}
+
+type threadSafeSource struct {
+	types.ImageSource
+}
+
+func (s threadSafeSource) HasThreadSafeGetBlob() bool {
+	return true
+}
+
+// uploadTrackingReference is a types.ImageReference which records the maximum number of concurrent PutBlob calls
+// to its destinations.
+type uploadTrackingReference struct {
+	types.ImageReference
+	lock         sync.Mutex
+	current, max int
+}
+
+func (ref *uploadTrackingReference) NewImageDestination(ctx context.Context, sys *types.SystemContext) (types.ImageDestination, error) {
+	dest, err := ref.ImageReference.NewImageDestination(ctx, sys)
+	if err != nil {
+		return nil, err
+	}
+	return &uploadTrackingDestination{ImageDestination: dest, ref: ref}, nil
+}
+
+type uploadTrackingDestination struct {
+	types.ImageDestination
+	ref *uploadTrackingReference
+}
+
+func (d *uploadTrackingDestination) DesiredLayerCompression() types.LayerCompression {
+	return types.PreserveOriginal
+}
+
+func (d *uploadTrackingDestination) PutBlob(ctx context.Context, stream io.Reader, inputInfo types.BlobInfo, cache types.BlobInfoCache, isConfig bool) (types.BlobInfo, error) {
+	d.ref.lock.Lock()
+	d.ref.current++
+	if d.ref.current > d.ref.max {
+		d.ref.max = d.ref.current
+	}
+	d.ref.lock.Unlock()
+	defer func() {
+		d.ref.lock.Lock()
+		d.ref.current--
+		d.ref.lock.Unlock()
+	}()
+	time.Sleep(50 * time.Millisecond)
+	return d.ImageDestination.PutBlob(ctx, stream, inputInfo, cache, isConfig)
+}
+
+func TestCopyMaxParallelUploads(t *testing.T) {
+	config := []byte(`{"architecture":"amd64","os":"linux","rootfs":{"type":"layers","diff_ids":[]}}`)
+	layers := []imgspecv1.Descriptor{}
+	srcDir := t.TempDir()
+	require.NoError(t, os.MkdirAll(filepath.Join(srcDir, "blobs", "sha256"), 0755))
+	for _, blob := range [][]byte{config, []byte("layer 1"), []byte("layer 2"), []byte("layer 3"), []byte("layer 4"), []byte("layer 5")} {
+		d := digest.FromBytes(blob)
+		require.NoError(t, os.WriteFile(filepath.Join(srcDir, "blobs", d.Algorithm().String(), d.Hex()), blob, 0644))
+		layers = append(layers, imgspecv1.Descriptor{MediaType: imgspecv1.MediaTypeImageLayer, Digest: d, Size: int64(len(blob))})
+	}
+	configDesc := layers[0]
+	configDesc.MediaType = imgspecv1.MediaTypeImageConfig
+	m := manifest.OCI1FromComponents(configDesc, layers[1:])
+	m.SchemaVersion = 2
+	man, err := m.Serialize()
+	require.NoError(t, err)
+	writeOCILayout(t, srcDir, "src", man)
+	layoutRef, err := layout.NewReference(srcDir, "src")
+	require.NoError(t, err)
+	srcRef := threadSafeSourceReference{layoutRef}
+	policyContext, err := signature.NewPolicyContext(&signature.Policy{Default: []signature.PolicyRequirement{signature.NewPRInsecureAcceptAnything()}})
+	require.NoError(t, err)
+	defer func() { _ = policyContext.Destroy() }()
+
+	for _, c := range []struct {
+		options  *Options
+		expected int
+	}{
+		{&Options{MaxParallelDownloads: 4, MaxParallelUploads: 2}, 2},
+		{&Options{MaxParallelDownloads: 3}, 3},
+		{&Options{MaxParallelDownloads: 4, DestinationCtx: &types.SystemContext{CopyMaxParallelUploads: 1}}, 1},
+		{&Options{SourceCtx: &types.SystemContext{CopyMaxParallelDownloads: 2}}, 2},
+	} {
+		layoutRef, err := layout.NewReference(t.TempDir(), "dest")
+		require.NoError(t, err)
+		destRef := &uploadTrackingReference{ImageReference: layoutRef}
+		copiedManifest, err := Image(context.Background(), policyContext, destRef, srcRef, c.options)
+		require.NoError(t, err)
+		assert.Equal(t, c.expected, destRef.max)
+		// Layers are recorded in the original order
+		copied, err := manifest.OCI1FromManifest(copiedManifest)
+		require.NoError(t, err)
+		assert.Equal(t, m.Layers, copied.Layers)
+	}
+}
@@ -0,0 +1,102 @@
+package copy
+
+import (
+	"sync"
+
+	"github.com/opencontainers/go-digest"
+	imgspecv1 "github.com/opencontainers/image-spec/specs-go/v1"
+	"github.com/sirupsen/logrus"
+)
+
+// DegradationKind identifies a kind of fallback used by copy.Image.
+type DegradationKind string
+
+const (
+	// DegradationMirrorFailover means that the source image could only be read after accessing some of
+	// the configured locations (registry mirrors, or the primary location) failed.
+	DegradationMirrorFailover DegradationKind = "mirror-failover"
+	// DegradationManifestConversion means that a manifest or a manifest list was converted to a different format
+	// than the source used, because the destination does not support, or has rejected, the original format.
+	// Conversions requested by the caller, e.g. using Options.ForceManifestMIMEType or Options.OciEncryptLayers, are not reported.
+	DegradationManifestConversion DegradationKind = "manifest-conversion"
+	// DegradationCompressionChange means that a layer was not written using the compression format
+	// requested in the destination SystemContext, e.g. because the image could not be modified.
+	DegradationCompressionChange DegradationKind = "compression-change"
+	// DegradationReferrersFallback means that referrers (see Options.CopyReferrers) were listed in the source, or stored
+	// in the destination, using the referrers tag schema, because the registry does not support the referrers API.
+	DegradationReferrersFallback DegradationKind = "referrers-fallback"
+)
+
+// Degradation describes a fallback which copy.Image used to successfully copy an image,
+// and which might make the result differ from what the caller intended.
+type Degradation struct {
+	Kind DegradationKind
+	// Message is a human-readable description of the degradation.
+	Message string
+}
+
+// Result describes an image copied by ImageWithResult.
+type Result struct {
+	// Manifest is the manifest which was written to the new copy of the image, as returned by Image.
+	Manifest []byte
+	// UpToDate is true if the copy was skipped because the destination already contained the image,
+	// see Options.SkipIfDestinationUpToDate.  Manifest is then the manifest already present in the destination.
+	UpToDate bool
+	// Degradations lists the fallbacks used to copy the image, in the order they were used; nil if none were necessary.
+	Degradations []Degradation
+	// Timings describes the time spent in the phases of the copy.
+	Timings Timings
+	// ConvertedManifests maps digests of source manifests (and manifest lists) which were written with a different
+	// digest, e.g. because they were converted to a different format, to descriptors of the written manifests;
+	// nil if there are none.  It can be used as Options.SubjectRewrites of later copies.
+	ConvertedManifests map[digest.Digest]imgspecv1.Descriptor
+	// AdditionalTags lists the outcome of writing the manifest under each of Options.AdditionalTags, in the same order;
+	// nil if Options.AdditionalTags was empty.
+	AdditionalTags []TagResult
+	// LayerAttempts lists the attempts made to copy each layer which was copied or reused (not foreign layers which were
+	// skipped), in completion order; see Options.LayerRetryPolicy.
+	LayerAttempts []LayerAttempts
+	// Statistics describes the data transferred by the copy.
+	Statistics Statistics
+	// Plan describes what the copy would do, if Options.DryRun was set and UpToDate is false; nil otherwise.
+	Plan *Plan
+}
+
+// degradationReport collects the degradations of a single copy operation.
+// It is safe for concurrent use.
+type degradationReport struct {
+	callback func(Degradation) // Or nil
+
+	mutex        sync.Mutex // Protects degradations, and serializes calls to callback
+	degradations []Degradation
+}
+
+// newDegradationReport returns a degradationReport which also reports degradations to callback, if not nil.
+func newDegradationReport(callback func(Degradation)) *degradationReport {
+	return &degradationReport{callback: callback}
+}
+
+// record adds a degradation of kind, described by message.
+func (r *degradationReport) record(kind DegradationKind, message string) {
+	logrus.Debugf("Copy degraded (%s): %s", kind, message)
+	d := Degradation{Kind: kind, Message: message}
+
+	r.mutex.Lock()
+	defer r.mutex.Unlock()
+	r.degradations = append(r.degradations, d)
+	if r.callback != nil {
+		r.callback(d)
+	}
+}
+
+// list returns the recorded degradations.
+func (r *degradationReport) list() []Degradation {
+	r.mutex.Lock()
+	defer r.mutex.Unlock()
+	if len(r.degradations) == 0 {
+		return nil
+	}
+	res := make([]Degradation, len(r.degradations))
+	copy(res, r.degradations)
+	return res
+}
@@ -0,0 +1,42 @@
+package copy
+
+import (
+	"fmt"
+	"sync"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestDegradationReport(t *testing.T) {
+	// Nothing recorded
+	r := newDegradationReport(nil)
+	assert.Nil(t, r.list())
+
+	// Without a callback
+	r.record(DegradationMirrorFailover, "mirror failed")
+	assert.Equal(t, []Degradation{{Kind: DegradationMirrorFailover, Message: "mirror failed"}}, r.list())
+
+	// With a callback, from concurrent goroutines
+	var reported []Degradation
+	r = newDegradationReport(func(d Degradation) {
+		reported = append(reported, d) // Calls are serialized, so this is safe
+	})
+	var wg sync.WaitGroup
+	for i := 0; i < 10; i++ {
+		wg.Add(1)
+		go func(i int) {
+			defer wg.Done()
+			r.record(DegradationCompressionChange, fmt.Sprintf("layer %d", i))
+		}(i)
+	}
+	wg.Wait()
+	res := r.list()
+	assert.Len(t, res, 10)
+	assert.Equal(t, reported, res)
+
+	// The returned value is not affected by later records
+	r.record(DegradationManifestConversion, "converted")
+	assert.Len(t, res, 10)
+	assert.Len(t, r.list(), 11)
+}
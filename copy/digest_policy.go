@@ -0,0 +1,18 @@
+package copy
+
+import (
+	"github.com/containers/image/v5/internal/digestpolicy"
+	"github.com/containers/image/v5/transports"
+	"github.com/containers/image/v5/types"
+	digest "github.com/opencontainers/go-digest"
+)
+
+// checkSourceDigest returns an error if the digest policy of the source does not allow the algorithm of d.
+func (c *copier) checkSourceDigest(d digest.Digest) error {
+	return digestpolicy.CheckAlgorithm(c.digestPolicy, d)
+}
+
+// reportSourceDigestAccepted reports that source contents of kind have been verified against d.
+func (c *copier) reportSourceDigestAccepted(kind types.DigestKind, d digest.Digest) {
+	digestpolicy.ReportAccepted(c.digestPolicy, transports.ImageName(c.rawSource.Reference()), kind, d)
+}
@@ -0,0 +1,128 @@
+package copy
+
+import (
+	"context"
+	"math/rand"
+	"sync"
+	"time"
+
+	"github.com/containers/image/v5/docker/reference"
+	"github.com/containers/image/v5/types"
+	digest "github.com/opencontainers/go-digest"
+	"github.com/pkg/errors"
+	"github.com/sirupsen/logrus"
+	"github.com/vbauerster/mpb/v7"
+)
+
+// Defaults of the fields of Options.LayerRetryPolicy; the same as those of the docker transport.
+const (
+	defaultLayerMaxAttempts  = 5
+	defaultLayerInitialDelay = 2 * time.Second
+	defaultLayerMaxDelay     = 60 * time.Second
+)
+
+// LayerAttempts describes the attempts made to copy a single layer.
+type LayerAttempts struct {
+	// Digest is the digest of the layer in the source.
+	Digest digest.Digest
+	// Attempts is the number of times the layer was copied, including the final, successful, one.
+	Attempts int
+	// Errors are the errors of the failed attempts, in order; nil if the first attempt succeeded.
+	Errors []string
+}
+
+// layerAttemptsReport collects the LayerAttempts of a single copy operation.
+// It is safe for concurrent use.
+type layerAttemptsReport struct {
+	mutex    sync.Mutex // Protects attempts
+	attempts []LayerAttempts
+}
+
+// record adds attempts.
+func (r *layerAttemptsReport) record(attempts LayerAttempts) {
+	r.mutex.Lock()
+	defer r.mutex.Unlock()
+	r.attempts = append(r.attempts, attempts)
+}
+
+// list returns the recorded attempts.
+func (r *layerAttemptsReport) list() []LayerAttempts {
+	r.mutex.Lock()
+	defer r.mutex.Unlock()
+	if len(r.attempts) == 0 {
+		return nil
+	}
+	res := make([]LayerAttempts, len(r.attempts))
+	copy(res, r.attempts)
+	return res
+}
+
+// layerRetryDelay returns the delay before the retry following attempt attempts (starting at 1) of a layer copy,
+// according to policy.
+func layerRetryDelay(policy *types.DockerRetryPolicy, attempts int) time.Duration {
+	delay, maxDelay := policy.InitialDelay, policy.MaxDelay
+	if delay <= 0 {
+		delay = defaultLayerInitialDelay
+	}
+	if maxDelay <= 0 {
+		maxDelay = defaultLayerMaxDelay
+	}
+	for i := 1; i < attempts && delay < maxDelay; i++ {
+		delay *= 2
+	}
+	if delay > maxDelay {
+		delay = maxDelay
+	}
+	jitter := policy.Jitter
+	switch {
+	case jitter < 0:
+		jitter = 0
+	case jitter > 1:
+		jitter = 1
+	}
+	return delay - time.Duration(jitter*rand.Float64()*float64(delay))
+}
+
+// layerErrorIsRetryable returns true if err, a failure to copy a layer using ctx, may be fixed by copying the layer again.
+func layerErrorIsRetryable(ctx context.Context, err error) bool {
+	if ctx.Err() != nil || errors.Is(err, context.Canceled) || errors.Is(err, context.DeadlineExceeded) {
+		return false
+	}
+	var notAllowed types.DigestAlgorithmNotAllowedError
+	return !errors.As(err, &notAllowed)
+}
+
+// copyLayerWithRetries is copyLayer, copying the layer again after failures as allowed by c.layerRetryPolicy,
+// and recording the attempts in c.layerAttempts.
+func (ic *imageCopier) copyLayerWithRetries(ctx context.Context, srcInfo types.BlobInfo, toEncrypt bool, pool *mpb.Progress, layerIndex int, srcRef reference.Named, emptyLayer bool) (types.BlobInfo, digest.Digest, error) {
+	maxAttempts := 1
+	if policy := ic.c.layerRetryPolicy; policy != nil {
+		maxAttempts = policy.MaxAttempts
+		if maxAttempts < 1 {
+			maxAttempts = defaultLayerMaxAttempts
+		}
+	}
+	attempts := LayerAttempts{Digest: srcInfo.Digest}
+	for {
+		// A new attempt first checks whether the destination already has the blob, or can reuse it (e.g. using
+		// a cross-repository mount), and transports may resume partial uploads of the previous attempt.
+		destInfo, diffID, err := ic.copyLayer(ctx, srcInfo, toEncrypt, pool, layerIndex, srcRef, emptyLayer)
+		attempts.Attempts++
+		if err == nil {
+			ic.c.layerAttempts.record(attempts)
+			return destInfo, diffID, nil
+		}
+		if attempts.Attempts >= maxAttempts || !layerErrorIsRetryable(ctx, err) {
+			return types.BlobInfo{}, "", err
+		}
+		attempts.Errors = append(attempts.Errors, err.Error())
+		delay := layerRetryDelay(ic.c.layerRetryPolicy, attempts.Attempts)
+		logrus.Debugf("Copying layer %s failed: %v; retrying in %v", srcInfo.Digest, err, delay)
+		ic.c.Printf("Copying blob %s failed, retrying (attempt %d of %d)\n", srcInfo.Digest, attempts.Attempts+1, maxAttempts)
+		select {
+		case <-ctx.Done():
+			return types.BlobInfo{}, "", ctx.Err()
+		case <-time.After(delay):
+		}
+	}
+}
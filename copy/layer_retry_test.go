@@ -0,0 +1,77 @@
+package copy
+
+import (
+	"context"
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/containers/image/v5/oci/layout"
+	"github.com/containers/image/v5/signature"
+	"github.com/containers/image/v5/types"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestCopyLayerRetries(t *testing.T) {
+	srcRef, _, layerDigest := newTestOCIImage(t)
+	policyContext, err := signature.NewPolicyContext(&signature.Policy{Default: []signature.PolicyRequirement{signature.NewPRInsecureAcceptAnything()}})
+	require.NoError(t, err)
+	defer func() { _ = policyContext.Destroy() }()
+
+	// Without a retry policy, a failed layer fails the copy
+	layoutRef, err := layout.NewReference(t.TempDir(), "dest")
+	require.NoError(t, err)
+	destRef := &putBlobCountingReference{ImageReference: layoutRef, failAt: 1}
+	_, err = ImageWithResult(context.Background(), policyContext, destRef, srcRef, &Options{})
+	assert.Error(t, err)
+
+	// With a retry policy, the layer is copied again
+	layoutRef, err = layout.NewReference(t.TempDir(), "dest")
+	require.NoError(t, err)
+	destRef = &putBlobCountingReference{ImageReference: layoutRef, failAt: 1}
+	res, err := ImageWithResult(context.Background(), policyContext, destRef, srcRef, &Options{
+		LayerRetryPolicy: &types.DockerRetryPolicy{MaxAttempts: 2, InitialDelay: time.Millisecond},
+	})
+	require.NoError(t, err)
+	require.Len(t, res.LayerAttempts, 1)
+	assert.Equal(t, layerDigest, res.LayerAttempts[0].Digest)
+	assert.Equal(t, 2, res.LayerAttempts[0].Attempts)
+	require.Len(t, res.LayerAttempts[0].Errors, 1)
+	assert.Contains(t, res.LayerAttempts[0].Errors[0], "simulated network failure")
+
+	// The number of attempts is limited
+	layoutRef, err = layout.NewReference(t.TempDir(), "dest")
+	require.NoError(t, err)
+	destRef = &putBlobCountingReference{ImageReference: layoutRef, failAt: 1}
+	_, err = ImageWithResult(context.Background(), policyContext, destRef, srcRef, &Options{
+		LayerRetryPolicy: &types.DockerRetryPolicy{MaxAttempts: 1, InitialDelay: time.Millisecond},
+	})
+	assert.Error(t, err)
+}
+
+func TestLayerRetryDelay(t *testing.T) {
+	policy := &types.DockerRetryPolicy{InitialDelay: time.Second, MaxDelay: 5 * time.Second}
+	for attempts, expected := range []time.Duration{1: time.Second, 2: 2 * time.Second, 3: 4 * time.Second, 4: 5 * time.Second, 5: 5 * time.Second} {
+		if attempts == 0 {
+			continue
+		}
+		assert.Equal(t, expected, layerRetryDelay(policy, attempts), attempts)
+	}
+	assert.Equal(t, defaultLayerInitialDelay, layerRetryDelay(&types.DockerRetryPolicy{}, 1))
+
+	policy.Jitter = 0.5
+	for i := 0; i < 10; i++ {
+		delay := layerRetryDelay(policy, 1)
+		assert.True(t, delay > 500*time.Millisecond && delay <= time.Second, delay)
+	}
+}
+
+func TestLayerErrorIsRetryable(t *testing.T) {
+	assert.True(t, layerErrorIsRetryable(context.Background(), errors.New("connection reset")))
+	assert.False(t, layerErrorIsRetryable(context.Background(), context.Canceled))
+	assert.False(t, layerErrorIsRetryable(context.Background(), types.DigestAlgorithmNotAllowedError{}))
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+	assert.False(t, layerErrorIsRetryable(ctx, errors.New("connection reset")))
+}
@@ -79,6 +79,14 @@ func (ic *imageCopier) determineManifestConversion(ctx context.Context, destSupp
 	if _, ok := supportedByDest[srcType]; ok {
 		prioritizedTypes.append(srcType)
 	}
+	if ic.artifactConfigType != "" {
+		// Non-image artifacts can't be represented in other manifest formats.
+		if len(prioritizedTypes.list) == 0 {
+			return "", nil, errors.Errorf("The artifact with config type %q can not be converted from %s, which is not supported by the destination (supported: %s)",
+				ic.artifactConfigType, srcType, strings.Join(destSupportedManifestMIMETypes, ", "))
+		}
+		return srcType, []string{}, nil
+	}
 	if ic.cannotModifyManifestReason != "" {
 		// We could also drop this check and have the caller
 		// make the choice; it is already doing that to an extent, to improve error
@@ -0,0 +1,97 @@
+package copy
+
+import (
+	"context"
+	"strings"
+
+	"github.com/containers/image/v5/manifest"
+	"github.com/containers/image/v5/types"
+	imgspecv1 "github.com/opencontainers/image-spec/specs-go/v1"
+	"github.com/pkg/errors"
+)
+
+// knownLayerMediaTypes are the layer media types which this package knows how to compress, decompress,
+// encrypt, and convert between manifest formats.
+var knownLayerMediaTypes = map[string]struct{}{
+	manifest.DockerV2Schema2LayerMediaType:            {},
+	manifest.DockerV2SchemaLayerMediaTypeUncompressed: {},
+	manifest.DockerV2Schema2ForeignLayerMediaType:     {},
+	manifest.DockerV2Schema2ForeignLayerMediaTypeGzip: {},
+	imgspecv1.MediaTypeImageLayer:                     {},
+	imgspecv1.MediaTypeImageLayerGzip:                 {},
+	imgspecv1.MediaTypeImageLayerZstd:                 {},
+	imgspecv1.MediaTypeImageLayerNonDistributable:     {},
+	imgspecv1.MediaTypeImageLayerNonDistributableGzip: {},
+	imgspecv1.MediaTypeImageLayerNonDistributableZstd: {},
+	// Schema1
+	"": {},
+}
+
+// knownConfigMediaTypes are the config media types which this package knows how to convert between manifest formats.
+// "" is used by schema1 manifests, which have no separate config.
+var knownConfigMediaTypes = map[string]struct{}{
+	"":                                      {},
+	manifest.DockerV2Schema2ConfigMediaType: {},
+	imgspecv1.MediaTypeImageConfig:          {},
+}
+
+// isKnownLayerMediaType returns true if mediaType is one of knownLayerMediaTypes, possibly encrypted.
+func isKnownLayerMediaType(mediaType string) bool {
+	_, ok := knownLayerMediaTypes[strings.TrimSuffix(mediaType, "+encrypted")]
+	return ok
+}
+
+// unknownMediaTypes returns the config and layer media types of src which are not known to this package, if any.
+func unknownMediaTypes(src types.Image) []string {
+	res := []string{}
+	seen := map[string]struct{}{}
+	add := func(mediaType string) {
+		if _, ok := seen[mediaType]; !ok {
+			seen[mediaType] = struct{}{}
+			res = append(res, mediaType)
+		}
+	}
+	if mt := src.ConfigInfo().MediaType; mt != "" {
+		if _, ok := knownConfigMediaTypes[mt]; !ok {
+			add(mt)
+		}
+	}
+	for _, layer := range src.LayerInfos() {
+		if !isKnownLayerMediaType(layer.MediaType) {
+			add(layer.MediaType)
+		}
+	}
+	return res
+}
+
+// checkStrictMediaTypePreservation returns an error if copying src using manifestMIMEType would require
+// re-typing any of its config or layers which use media types unknown to this package.
+// If that is not the case, it returns true if such media types are used, i.e. no manifest conversions may be attempted.
+func checkStrictMediaTypePreservation(ctx context.Context, src types.Image, manifestMIMEType string) (bool, error) {
+	unknown := unknownMediaTypes(src)
+	if len(unknown) == 0 {
+		return false, nil
+	}
+	_, srcType, err := src.Manifest(ctx)
+	if err != nil {
+		return false, errors.Wrapf(err, "reading manifest from source image")
+	}
+	if normalizedSrcType := manifest.NormalizedMIMEType(srcType); manifestMIMEType != normalizedSrcType {
+		return false, errors.Errorf("Converting the manifest from %s to %s would change media types %s, and strict media type preservation is enabled",
+			normalizedSrcType, manifestMIMEType, strings.Join(unknown, ", "))
+	}
+	return true, nil
+}
+
+// checkLayerMediaTypePreserved returns an error if destInfo, the result of copying a layer with srcInfo
+// which uses a media type unknown to this package, is not byte-identical to the source.
+// Otherwise it returns destInfo, updated so that the media type is not changed when updating the manifest.
+func checkLayerMediaTypePreserved(srcInfo, destInfo types.BlobInfo) (types.BlobInfo, error) {
+	if destInfo.Digest != srcInfo.Digest {
+		return types.BlobInfo{}, errors.Errorf("Layer %s with media type %q would be modified (written as %s), and strict media type preservation is enabled",
+			srcInfo.Digest, srcInfo.MediaType, destInfo.Digest)
+	}
+	destInfo.CompressionOperation = types.PreserveOriginal
+	destInfo.CompressionAlgorithm = nil
+	return destInfo, nil
+}
@@ -0,0 +1,112 @@
+package copy
+
+import (
+	"context"
+	"testing"
+
+	"github.com/containers/image/v5/manifest"
+	"github.com/containers/image/v5/pkg/compression"
+	"github.com/containers/image/v5/types"
+	"github.com/opencontainers/go-digest"
+	imgspecv1 "github.com/opencontainers/image-spec/specs-go/v1"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+const (
+	wasmLayerMediaType  = "application/vnd.wasm.content.layer.v1+wasm"
+	wasmConfigMediaType = "application/vnd.wasm.config.v1+json"
+)
+
+// mediaTypesImage is a types.Image implementing only the methods used by checkStrictMediaTypePreservation.
+type mediaTypesImage struct {
+	types.Image
+	mimeType string
+	config   types.BlobInfo
+	layers   []types.BlobInfo
+}
+
+func (i mediaTypesImage) Manifest(ctx context.Context) ([]byte, string, error) {
+	return nil, i.mimeType, nil
+}
+func (i mediaTypesImage) ConfigInfo() types.BlobInfo {
+	return i.config
+}
+func (i mediaTypesImage) LayerInfos() []types.BlobInfo {
+	return i.layers
+}
+
+func TestIsKnownLayerMediaType(t *testing.T) {
+	for _, mt := range []string{
+		"",
+		manifest.DockerV2Schema2LayerMediaType,
+		manifest.DockerV2Schema2ForeignLayerMediaTypeGzip,
+		imgspecv1.MediaTypeImageLayerZstd,
+		imgspecv1.MediaTypeImageLayerGzip + "+encrypted",
+	} {
+		assert.True(t, isKnownLayerMediaType(mt), mt)
+	}
+	for _, mt := range []string{
+		wasmLayerMediaType,
+		wasmLayerMediaType + "+encrypted",
+		manifest.DockerV2Schema2ConfigMediaType,
+	} {
+		assert.False(t, isKnownLayerMediaType(mt), mt)
+	}
+}
+
+func TestCheckStrictMediaTypePreservation(t *testing.T) {
+	ctx := context.Background()
+	image := mediaTypesImage{
+		mimeType: imgspecv1.MediaTypeImageManifest,
+		config:   types.BlobInfo{MediaType: imgspecv1.MediaTypeImageConfig},
+		layers: []types.BlobInfo{
+			{MediaType: imgspecv1.MediaTypeImageLayerGzip},
+			{MediaType: imgspecv1.MediaTypeImageLayerGzip},
+		},
+	}
+	// Only known media types: anything goes
+	assert.Empty(t, unknownMediaTypes(image))
+	hasUnknown, err := checkStrictMediaTypePreservation(ctx, image, manifest.DockerV2Schema2MediaType)
+	require.NoError(t, err)
+	assert.False(t, hasUnknown)
+
+	// Unknown media types: no conversion allowed
+	image.config = types.BlobInfo{MediaType: wasmConfigMediaType}
+	image.layers = []types.BlobInfo{{MediaType: wasmLayerMediaType}, {MediaType: imgspecv1.MediaTypeImageLayerGzip}, {MediaType: wasmLayerMediaType}}
+	assert.Equal(t, []string{wasmConfigMediaType, wasmLayerMediaType}, unknownMediaTypes(image))
+	hasUnknown, err = checkStrictMediaTypePreservation(ctx, image, imgspecv1.MediaTypeImageManifest)
+	require.NoError(t, err)
+	assert.True(t, hasUnknown)
+	_, err = checkStrictMediaTypePreservation(ctx, image, manifest.DockerV2Schema2MediaType)
+	assert.Error(t, err)
+
+	// An unknown config type alone is enough
+	image.layers = []types.BlobInfo{{MediaType: imgspecv1.MediaTypeImageLayerGzip}}
+	_, err = checkStrictMediaTypePreservation(ctx, image, manifest.DockerV2Schema2MediaType)
+	assert.Error(t, err)
+}
+
+func TestCheckLayerMediaTypePreserved(t *testing.T) {
+	srcInfo := types.BlobInfo{
+		Digest:    digest.Digest("sha256:6a5a5368e0c2d3e5909184fa28ddfd56072e7ff3ee9a945876f7eee5896ef5bb"),
+		Size:      42,
+		MediaType: wasmLayerMediaType,
+	}
+	res, err := checkLayerMediaTypePreserved(srcInfo, types.BlobInfo{
+		Digest:               srcInfo.Digest,
+		Size:                 42,
+		CompressionOperation: types.PreserveOriginal,
+		CompressionAlgorithm: &compression.Gzip,
+	})
+	require.NoError(t, err)
+	assert.Equal(t, types.BlobInfo{Digest: srcInfo.Digest, Size: 42, CompressionOperation: types.PreserveOriginal}, res)
+
+	_, err = checkLayerMediaTypePreserved(srcInfo, types.BlobInfo{
+		Digest:               digest.Digest("sha256:1111111111111111111111111111111111111111111111111111111111111111"),
+		Size:                 50,
+		CompressionOperation: types.Compress,
+		CompressionAlgorithm: &compression.Gzip,
+	})
+	assert.Error(t, err)
+}
@@ -0,0 +1,138 @@
+package copy
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+
+	"github.com/containers/image/v5/manifest"
+	"github.com/containers/image/v5/types"
+	"github.com/opencontainers/go-digest"
+	imgspecv1 "github.com/opencontainers/image-spec/specs-go/v1"
+	"github.com/pkg/errors"
+)
+
+// applyModifyConfig returns pendingImage with man, a manifest with manifestType, both updated to use a config
+// modified by ic.c.modifyConfig.
+func (ic *imageCopier) applyModifyConfig(ctx context.Context, pendingImage types.Image, man []byte, manifestType string) (types.Image, []byte, error) {
+	configInfo := pendingImage.ConfigInfo()
+	if configInfo.Digest == "" {
+		return nil, nil, errors.Errorf("Modifying the config of a %s image is not supported, it has no config", manifestType)
+	}
+	config, err := pendingImage.ConfigBlob(ctx)
+	if err != nil {
+		return nil, nil, errors.Wrapf(err, "reading config blob %s", configInfo.Digest)
+	}
+	modified, err := ic.c.modifyConfig(ctx, config, configInfo.MediaType)
+	if err != nil {
+		return nil, nil, errors.Wrap(err, "modifying config")
+	}
+	if bytes.Equal(modified, config) {
+		return pendingImage, man, nil
+	}
+
+	modifiedInfo := types.BlobInfo{
+		Digest:    digest.FromBytes(modified),
+		Size:      int64(len(modified)),
+		MediaType: configInfo.MediaType,
+	}
+	man, err = setManifestConfig(man, manifestType, modifiedInfo)
+	if err != nil {
+		return nil, nil, err
+	}
+	parsed, err := manifest.FromBlob(man, manifestType)
+	if err != nil {
+		return nil, nil, err
+	}
+	return &modifiedConfigImage{
+		Image:        pendingImage,
+		manifest:     man,
+		manifestType: manifestType,
+		parsed:       parsed,
+		config:       modified,
+		configInfo:   modifiedInfo,
+	}, man, nil
+}
+
+// setManifestConfig returns man, a manifest with manifestType, updated to refer to a config described by info.
+func setManifestConfig(man []byte, manifestType string, info types.BlobInfo) ([]byte, error) {
+	switch manifest.NormalizedMIMEType(manifestType) {
+	case imgspecv1.MediaTypeImageManifest:
+		m, err := manifest.OCI1FromManifest(man)
+		if err != nil {
+			return nil, err
+		}
+		m.Config.Digest = info.Digest
+		m.Config.Size = info.Size
+		return m.Serialize()
+	case manifest.DockerV2Schema2MediaType:
+		m, err := manifest.Schema2FromManifest(man)
+		if err != nil {
+			return nil, err
+		}
+		m.ConfigDescriptor.Digest = info.Digest
+		m.ConfigDescriptor.Size = info.Size
+		return m.Serialize()
+	default:
+		return nil, errors.Errorf("Modifying the config of a %s image is not supported", manifestType)
+	}
+}
+
+// applyModifyManifest returns man, a manifest of pendingImage with manifestType, modified by ic.c.modifyManifest.
+func (ic *imageCopier) applyModifyManifest(ctx context.Context, pendingImage types.Image, man []byte, manifestType string) ([]byte, error) {
+	modified, err := ic.c.modifyManifest(ctx, man, manifestType)
+	if err != nil {
+		return nil, errors.Wrap(err, "modifying manifest")
+	}
+	parsed, err := manifest.FromBlob(modified, manifestType)
+	if err != nil {
+		return nil, errors.Wrapf(err, "parsing modified manifest")
+	}
+	// The config is copied based on pendingImage; use Options.ModifyConfig to change it.
+	if configInfo := pendingImage.ConfigInfo(); parsed.ConfigInfo().Digest != configInfo.Digest {
+		return nil, errors.Errorf("Modified manifest refers to config %s instead of %s; use Options.ModifyConfig to modify the config",
+			parsed.ConfigInfo().Digest, configInfo.Digest)
+	}
+	return modified, nil
+}
+
+// modifiedConfigImage is a types.Image with a config modified by Options.ModifyConfig.
+type modifiedConfigImage struct {
+	types.Image
+	manifest     []byte
+	manifestType string
+	parsed       manifest.Manifest
+	config       []byte
+	configInfo   types.BlobInfo
+}
+
+// Manifest is like ImageSource.GetManifest, but the result is cached; it is OK to call this however often you need.
+func (i *modifiedConfigImage) Manifest(ctx context.Context) ([]byte, string, error) {
+	return i.manifest, i.manifestType, nil
+}
+
+// ConfigInfo returns a complete BlobInfo for the separate config object, or a BlobInfo{Digest:""} if there isn't a separate object.
+func (i *modifiedConfigImage) ConfigInfo() types.BlobInfo {
+	return i.configInfo
+}
+
+// ConfigBlob returns the blob described by ConfigInfo, if ConfigInfo().Digest != ""; nil otherwise.
+func (i *modifiedConfigImage) ConfigBlob(ctx context.Context) ([]byte, error) {
+	return i.config, nil
+}
+
+// OCIConfig returns the image configuration as per OCI v1 image-spec.
+func (i *modifiedConfigImage) OCIConfig(ctx context.Context) (*imgspecv1.Image, error) {
+	config := &imgspecv1.Image{}
+	if err := json.Unmarshal(i.config, config); err != nil {
+		return nil, err
+	}
+	return config, nil
+}
+
+// Inspect returns various information for (skopeo inspect) parsed from the manifest and configuration.
+func (i *modifiedConfigImage) Inspect(ctx context.Context) (*types.ImageInspectInfo, error) {
+	return i.parsed.Inspect(func(types.BlobInfo) ([]byte, error) {
+		return i.config, nil
+	})
+}
@@ -0,0 +1,122 @@
+package copy
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"testing"
+
+	"github.com/containers/image/v5/image"
+	"github.com/containers/image/v5/manifest"
+	"github.com/containers/image/v5/oci/layout"
+	"github.com/containers/image/v5/signature"
+	"github.com/containers/image/v5/types"
+	digest "github.com/opencontainers/go-digest"
+	imgspecv1 "github.com/opencontainers/image-spec/specs-go/v1"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestCopyModifyHooks(t *testing.T) {
+	srcRef, configDigest, _ := newTestOCIImage(t)
+	policyContext, err := signature.NewPolicyContext(&signature.Policy{Default: []signature.PolicyRequirement{signature.NewPRInsecureAcceptAnything()}})
+	require.NoError(t, err)
+	defer func() { _ = policyContext.Destroy() }()
+
+	addLabel := func(ctx context.Context, config []byte, mediaType string) ([]byte, error) {
+		assert.Equal(t, imgspecv1.MediaTypeImageConfig, mediaType)
+		c := imgspecv1.Image{}
+		if err := json.Unmarshal(config, &c); err != nil {
+			return nil, err
+		}
+		c.Config.Labels = map[string]string{"org.example.label": "value"}
+		return json.Marshal(c)
+	}
+	addAnnotation := func(ctx context.Context, man []byte, mimeType string) ([]byte, error) {
+		assert.Equal(t, imgspecv1.MediaTypeImageManifest, mimeType)
+		m, err := manifest.OCI1FromManifest(man)
+		if err != nil {
+			return nil, err
+		}
+		m.Annotations = map[string]string{"org.example.annotation": "value"}
+		return m.Serialize()
+	}
+
+	destRef, err := layout.NewReference(t.TempDir(), "dest")
+	require.NoError(t, err)
+	man, err := Image(context.Background(), policyContext, destRef, srcRef, &Options{
+		ModifyConfig:   addLabel,
+		ModifyManifest: addAnnotation,
+	})
+	require.NoError(t, err)
+	m, err := manifest.OCI1FromManifest(man)
+	require.NoError(t, err)
+	assert.Equal(t, map[string]string{"org.example.annotation": "value"}, m.Annotations)
+	assert.NotEqual(t, configDigest, m.Config.Digest)
+
+	src, err := destRef.NewImageSource(context.Background(), nil)
+	require.NoError(t, err)
+	defer src.Close()
+	img, err := image.FromUnparsedImage(context.Background(), nil, image.UnparsedInstance(src, nil))
+	require.NoError(t, err)
+	config, err := img.ConfigBlob(context.Background()) // Also verifies the digest
+	require.NoError(t, err)
+	assert.Equal(t, int64(len(config)), m.Config.Size)
+	ociConfig, err := img.OCIConfig(context.Background())
+	require.NoError(t, err)
+	assert.Equal(t, map[string]string{"org.example.label": "value"}, ociConfig.Config.Labels)
+
+	// The manifest hook can't change the config
+	destRef, err = layout.NewReference(t.TempDir(), "dest")
+	require.NoError(t, err)
+	_, err = Image(context.Background(), policyContext, destRef, srcRef, &Options{
+		ModifyManifest: func(ctx context.Context, man []byte, mimeType string) ([]byte, error) {
+			m, err := manifest.OCI1FromManifest(man)
+			if err != nil {
+				return nil, err
+			}
+			m.Config.Digest = digest.FromString("other")
+			return m.Serialize()
+		},
+	})
+	assert.Error(t, err)
+
+	// Hook failures are reported
+	_, err = Image(context.Background(), policyContext, destRef, srcRef, &Options{
+		ModifyConfig: func(ctx context.Context, config []byte, mediaType string) ([]byte, error) {
+			return nil, errors.New("hook failed")
+		},
+	})
+	assert.ErrorContains(t, err, "hook failed")
+
+	// The image must be modifiable
+	_, err = Image(context.Background(), policyContext, destRef, srcRef, &Options{
+		ModifyManifest:  addAnnotation,
+		PreserveDigests: true,
+	})
+	assert.Error(t, err)
+}
+
+func TestSetManifestConfig(t *testing.T) {
+	info := types.BlobInfo{Digest: digest.FromString("config"), Size: 6}
+
+	for _, c := range []struct {
+		mimeType string
+		man      []byte
+	}{
+		{imgspecv1.MediaTypeImageManifest, []byte(`{"schemaVersion":2,"mediaType":"application/vnd.oci.image.manifest.v1+json",` +
+			`"config":{"mediaType":"application/vnd.oci.image.config.v1+json","digest":"sha256:0000000000000000000000000000000000000000000000000000000000000000","size":1},"layers":[]}`)},
+		{manifest.DockerV2Schema2MediaType, []byte(`{"schemaVersion":2,"mediaType":"application/vnd.docker.distribution.manifest.v2+json",` +
+			`"config":{"mediaType":"application/vnd.docker.container.image.v1+json","digest":"sha256:0000000000000000000000000000000000000000000000000000000000000000","size":1},"layers":[]}`)},
+	} {
+		res, err := setManifestConfig(c.man, c.mimeType, info)
+		require.NoError(t, err, c.mimeType)
+		m, err := manifest.FromBlob(res, c.mimeType)
+		require.NoError(t, err, c.mimeType)
+		assert.Equal(t, info.Digest, m.ConfigInfo().Digest, c.mimeType)
+		assert.Equal(t, info.Size, m.ConfigInfo().Size, c.mimeType)
+	}
+
+	_, err := setManifestConfig([]byte(`{}`), manifest.DockerV2Schema1SignedMediaType, info)
+	assert.Error(t, err)
+}
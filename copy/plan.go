@@ -0,0 +1,172 @@
+package copy
+
+import (
+	"context"
+	"sync"
+
+	"github.com/containers/image/v5/internal/private"
+	"github.com/containers/image/v5/manifest"
+	"github.com/containers/image/v5/pkg/blobinfocache/memory"
+	"github.com/containers/image/v5/types"
+	"github.com/opencontainers/go-digest"
+	"github.com/pkg/errors"
+)
+
+// Plan describes what a copy would do, as computed by ImageWithResult if Options.DryRun is set.
+type Plan struct {
+	// Blobs lists the blobs (configs and layers) of the copied images, each only once.
+	Blobs []PlannedBlob
+	// Manifests lists the manifests which would be written; a manifest list, if any, is last.
+	Manifests []PlannedManifest
+	// BytesToTransfer is the total size of the blobs which would be copied, as read from the source.
+	// It is an estimate: blobs may be (de)compressed during the copy, the destination may be able to reuse
+	// equivalent blobs it already contains, and blobs of unknown size are not included.
+	BytesToTransfer int64
+}
+
+// PlannedBlob describes a blob in a Plan.
+type PlannedBlob struct {
+	// Digest, Size (or -1 if unknown) and MediaType describe the blob in the source.
+	Digest    digest.Digest
+	Size      int64
+	MediaType string
+	IsConfig  bool
+	// Present is true if the destination already contains the blob, so that it would not be copied.
+	Present bool
+}
+
+// PlannedManifest describes a manifest (or a manifest list) in a Plan.
+type PlannedManifest struct {
+	// Digest is the digest of the manifest in the source.
+	Digest         digest.Digest
+	SourceMIMEType string
+	// DestinationMIMEType is the MIME type the manifest would be written with.  With some transports
+	// (e.g. docker://), the destination may reject it, and a different MIME type would be used.
+	DestinationMIMEType string
+	// Converted is true if the manifest would be converted, i.e. DestinationMIMEType differs from SourceMIMEType.
+	Converted bool
+}
+
+// planReport collects the Plan of a single copy operation.
+// It is safe for concurrent use.
+type planReport struct {
+	mutex sync.Mutex // Protects all fields
+	plan  Plan
+	blobs map[digest.Digest]struct{} // Digests of the blobs in plan.Blobs
+}
+
+// newPlanReport returns an empty planReport.
+func newPlanReport() *planReport {
+	return &planReport{blobs: map[digest.Digest]struct{}{}}
+}
+
+// recordBlob adds blob to the plan, unless it is already included.
+func (r *planReport) recordBlob(blob PlannedBlob) {
+	r.mutex.Lock()
+	defer r.mutex.Unlock()
+	if _, ok := r.blobs[blob.Digest]; ok {
+		return
+	}
+	r.blobs[blob.Digest] = struct{}{}
+	r.plan.Blobs = append(r.plan.Blobs, blob)
+	if !blob.Present && blob.Size > 0 {
+		r.plan.BytesToTransfer += blob.Size
+	}
+}
+
+// recordManifest adds a manifest with srcDigest and srcMIMEType, which would be written as destMIMEType, to the plan.
+func (r *planReport) recordManifest(srcDigest digest.Digest, srcMIMEType, destMIMEType string) {
+	srcMIMEType = manifest.NormalizedMIMEType(srcMIMEType)
+	r.mutex.Lock()
+	defer r.mutex.Unlock()
+	r.plan.Manifests = append(r.plan.Manifests, PlannedManifest{
+		Digest:              srcDigest,
+		SourceMIMEType:      srcMIMEType,
+		DestinationMIMEType: destMIMEType,
+		Converted:           srcMIMEType != destMIMEType,
+	})
+}
+
+// result returns the collected plan.
+func (r *planReport) result() *Plan {
+	r.mutex.Lock()
+	defer r.mutex.Unlock()
+	res := r.plan
+	res.Blobs = append([]PlannedBlob(nil), r.plan.Blobs...)
+	res.Manifests = append([]PlannedManifest(nil), r.plan.Manifests...)
+	return &res
+}
+
+// planImage records the blobs and the manifest of ic.src, which would be written as manifestMIMEType, in ic.c.plan,
+// without writing anything to the destination.  It returns the source manifest, its MIME type, and its digest.
+func (ic *imageCopier) planImage(ctx context.Context, manifestMIMEType string) ([]byte, string, digest.Digest, error) {
+	srcManifest, srcManifestType, err := ic.src.Manifest(ctx)
+	if err != nil {
+		return nil, "", "", errors.Wrapf(err, "reading manifest from source image")
+	}
+	srcManifestDigest, err := manifest.Digest(srcManifest)
+	if err != nil {
+		return nil, "", "", errors.Wrapf(err, "computing digest of source image's manifest")
+	}
+
+	if configInfo := ic.src.ConfigInfo(); configInfo.Digest != "" {
+		if err := ic.planBlob(ctx, configInfo, true, -1, false); err != nil {
+			return nil, "", "", err
+		}
+	}
+	srcInfos := ic.src.LayerInfos()
+	updatedSrcInfos, err := ic.src.LayerInfosForCopy(ctx)
+	if err != nil {
+		return nil, "", "", err
+	}
+	if updatedSrcInfos != nil {
+		srcInfos = updatedSrcInfos
+	}
+	man, err := manifest.FromBlob(srcManifest, srcManifestType)
+	if err != nil {
+		return nil, "", "", err
+	}
+	manifestLayerInfos := man.LayerInfos()
+	for i, srcInfo := range srcInfos {
+		if !ic.c.downloadForeignLayers && ic.c.dest.AcceptsForeignLayerURLs() && len(srcInfo.URLs) != 0 {
+			continue // The layer would not be copied.
+		}
+		if err := ic.planBlob(ctx, srcInfo, false, i, manifestLayerInfos[i].EmptyLayer); err != nil {
+			return nil, "", "", err
+		}
+	}
+
+	ic.c.plan.recordManifest(srcManifestDigest, srcManifestType, manifestMIMEType)
+	return srcManifest, srcManifestType, srcManifestDigest, nil
+}
+
+// planBlob records srcInfo, a config if isConfig or the layer at layerIndex, in ic.c.plan,
+// checking whether the destination already contains it.
+func (ic *imageCopier) planBlob(ctx context.Context, srcInfo types.BlobInfo, isConfig bool, layerIndex int, emptyLayer bool) error {
+	if err := ic.c.checkSourceDigest(srcInfo.Digest); err != nil {
+		return err
+	}
+	// Use an empty cache and don’t allow substitutes: reusing a blob from a different location
+	// (e.g. mounting it from a different repository) would modify the destination.
+	options := private.TryReusingBlobOptions{
+		Cache:         memory.New(),
+		CanSubstitute: false,
+		EmptyLayer:    emptyLayer,
+		SrcRef:        ic.c.rawSource.Reference().DockerReference(),
+	}
+	if !isConfig {
+		options.LayerIndex = &layerIndex
+	}
+	present, _, err := ic.c.dest.TryReusingBlobWithOptions(ctx, srcInfo, options)
+	if err != nil {
+		return errors.Wrapf(err, "checking whether blob %s exists at destination", srcInfo.Digest)
+	}
+	ic.c.plan.recordBlob(PlannedBlob{
+		Digest:    srcInfo.Digest,
+		Size:      srcInfo.Size,
+		MediaType: srcInfo.MediaType,
+		IsConfig:  isConfig,
+		Present:   present,
+	})
+	return nil
+}
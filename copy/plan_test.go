@@ -0,0 +1,99 @@
+package copy
+
+import (
+	"context"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/containers/image/v5/directory"
+	"github.com/containers/image/v5/manifest"
+	"github.com/containers/image/v5/oci/layout"
+	"github.com/containers/image/v5/signature"
+	digest "github.com/opencontainers/go-digest"
+	imgspecv1 "github.com/opencontainers/image-spec/specs-go/v1"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestCopyDryRun(t *testing.T) {
+	srcRef, configDigest, layerDigest := newTestOCIImage(t)
+	destDir := t.TempDir()
+	destRef, err := layout.NewReference(destDir, "dest")
+	require.NoError(t, err)
+	policyContext, err := signature.NewPolicyContext(&signature.Policy{Default: []signature.PolicyRequirement{signature.NewPRInsecureAcceptAnything()}})
+	require.NoError(t, err)
+	defer func() { _ = policyContext.Destroy() }()
+
+	// Nothing is present in an empty destination, and nothing is written.
+	res, err := ImageWithResult(context.Background(), policyContext, destRef, srcRef, &Options{DryRun: true})
+	require.NoError(t, err)
+	require.NotNil(t, res.Plan)
+	require.Len(t, res.Plan.Blobs, 2)
+	config, layer := res.Plan.Blobs[0], res.Plan.Blobs[1]
+	assert.Equal(t, PlannedBlob{Digest: configDigest, Size: config.Size, MediaType: imgspecv1.MediaTypeImageConfig, IsConfig: true}, config)
+	assert.Equal(t, PlannedBlob{Digest: layerDigest, Size: 27, MediaType: imgspecv1.MediaTypeImageLayer}, layer)
+	assert.Equal(t, config.Size+layer.Size, res.Plan.BytesToTransfer)
+	assert.Equal(t, []PlannedManifest{{
+		Digest:              digest.FromBytes(res.Manifest),
+		SourceMIMEType:      imgspecv1.MediaTypeImageManifest,
+		DestinationMIMEType: imgspecv1.MediaTypeImageManifest,
+	}}, res.Plan.Manifests)
+	_, err = os.Stat(filepath.Join(destDir, "index.json"))
+	assert.True(t, os.IsNotExist(err))
+	blobs, err := os.ReadDir(filepath.Join(destDir, "blobs", "sha256"))
+	if err == nil {
+		assert.Empty(t, blobs)
+	}
+
+	// Blobs already present in the destination are reported, and not counted in BytesToTransfer.
+	res, err = ImageWithResult(context.Background(), policyContext, destRef, srcRef, nil)
+	require.NoError(t, err)
+	assert.Nil(t, res.Plan)
+	otherRef, err := layout.NewReference(destDir, "other")
+	require.NoError(t, err)
+	res, err = ImageWithResult(context.Background(), policyContext, otherRef, destRef, &Options{DryRun: true})
+	require.NoError(t, err)
+	require.Len(t, res.Plan.Blobs, 2)
+	for _, b := range res.Plan.Blobs {
+		assert.True(t, b.Present, b.Digest)
+	}
+	assert.Equal(t, int64(0), res.Plan.BytesToTransfer)
+	_, err = otherRef.NewImageSource(context.Background(), nil)
+	assert.Error(t, err)
+
+	// Manifest conversions are reported.
+	dirRef, err := directory.NewReference(t.TempDir())
+	require.NoError(t, err)
+	res, err = ImageWithResult(context.Background(), policyContext, dirRef, srcRef, &Options{
+		DryRun:                true,
+		ForceManifestMIMEType: manifest.DockerV2Schema2MediaType,
+	})
+	require.NoError(t, err)
+	require.Len(t, res.Plan.Manifests, 1)
+	assert.Equal(t, manifest.DockerV2Schema2MediaType, res.Plan.Manifests[0].DestinationMIMEType)
+	assert.True(t, res.Plan.Manifests[0].Converted)
+}
+
+func TestPlanReport(t *testing.T) {
+	r := newPlanReport()
+	d1, d2, d3 := digest.FromString("1"), digest.FromString("2"), digest.FromString("3")
+	r.recordBlob(PlannedBlob{Digest: d1, Size: 10, IsConfig: true})
+	r.recordBlob(PlannedBlob{Digest: d2, Size: 20, Present: true})
+	r.recordBlob(PlannedBlob{Digest: d3, Size: -1})
+	r.recordBlob(PlannedBlob{Digest: d1, Size: 10}) // Duplicate
+	r.recordManifest(d1, manifest.DockerV2Schema2MediaType, imgspecv1.MediaTypeImageManifest)
+	r.recordManifest(d2, imgspecv1.MediaTypeImageManifest, imgspecv1.MediaTypeImageManifest)
+	assert.Equal(t, &Plan{
+		Blobs: []PlannedBlob{
+			{Digest: d1, Size: 10, IsConfig: true},
+			{Digest: d2, Size: 20, Present: true},
+			{Digest: d3, Size: -1},
+		},
+		Manifests: []PlannedManifest{
+			{Digest: d1, SourceMIMEType: manifest.DockerV2Schema2MediaType, DestinationMIMEType: imgspecv1.MediaTypeImageManifest, Converted: true},
+			{Digest: d2, SourceMIMEType: imgspecv1.MediaTypeImageManifest, DestinationMIMEType: imgspecv1.MediaTypeImageManifest},
+		},
+		BytesToTransfer: 10,
+	}, r.result())
+}
@@ -0,0 +1,95 @@
+package copy
+
+import (
+	"context"
+	"fmt"
+
+	"github.com/containers/image/v5/manifest"
+	"github.com/containers/image/v5/types"
+	digest "github.com/opencontainers/go-digest"
+	"github.com/pkg/errors"
+)
+
+// DestinationImage describes a single image which is about to be committed to the destination,
+// as passed to Options.CheckDestinationImage.
+type DestinationImage struct {
+	Manifest         []byte
+	ManifestMIMEType string
+	ManifestDigest   digest.Digest
+	ConfigInfo       types.BlobInfo // Digest is "" if the image has no config (e.g. docker schema1)
+	ConfigBlob       []byte         // nil if the image has no config
+	Layers           []types.BlobInfo
+	// Inspect contains the data of the image as it will be written to the destination, most importantly Labels.
+	// It is nil if the image is an OCI artifact which is not a container image.
+	Inspect *types.ImageInspectInfo
+}
+
+// Size returns the sum of sizes of the config and all layers of i, ignoring the manifest.
+// The result is -1 if the size of any of the blobs is unknown.
+func (i DestinationImage) Size() int64 {
+	var size int64
+	if i.ConfigInfo.Digest != "" {
+		size = int64(len(i.ConfigBlob))
+	}
+	for _, l := range i.Layers {
+		if l.Size < 0 {
+			return -1
+		}
+		size += l.Size
+	}
+	return size
+}
+
+// ErrDestinationImageRejected is returned by copy.Image if Options.CheckDestinationImage
+// rejects the image; Err is the error returned by the callback.
+type ErrDestinationImageRejected struct {
+	ManifestDigest digest.Digest
+	Err            error
+}
+
+func (e ErrDestinationImageRejected) Error() string {
+	return fmt.Sprintf("image %s rejected by destination policy: %v", e.ManifestDigest, e.Err)
+}
+
+// Unwrap returns e.Err.
+func (e ErrDestinationImageRejected) Unwrap() error {
+	return e.Err
+}
+
+// checkDestinationImage calls c.checkDestinationImageFn, if set, for pendingImage with manifest man.
+func (c *copier) checkDestinationImage(ctx context.Context, pendingImage types.Image, man []byte, manifestDigest digest.Digest) error {
+	if c.checkDestinationImageFn == nil {
+		return nil
+	}
+	_, manifestMIMEType, err := pendingImage.Manifest(ctx)
+	if err != nil {
+		return errors.Wrap(err, "reading manifest")
+	}
+	configInfo := pendingImage.ConfigInfo()
+	var configBlob []byte
+	if configInfo.Digest != "" {
+		configBlob, err = pendingImage.ConfigBlob(ctx)
+		if err != nil {
+			return errors.Wrapf(err, "reading config blob %s", configInfo.Digest)
+		}
+	}
+	inspect, err := pendingImage.Inspect(ctx)
+	if _, isArtifact := errors.Cause(err).(manifest.NonImageArtifactError); isArtifact {
+		inspect, err = nil, nil
+	}
+	if err != nil {
+		return errors.Wrap(err, "inspecting image")
+	}
+	if err := c.checkDestinationImageFn(ctx, DestinationImage{
+		Manifest:         man,
+		ManifestMIMEType: manifestMIMEType,
+		ManifestDigest:   manifestDigest,
+		ConfigInfo:       configInfo,
+		ConfigBlob:       configBlob,
+		Layers:           pendingImage.LayerInfos(),
+		Inspect:          inspect,
+	}); err != nil {
+		return ErrDestinationImageRejected{ManifestDigest: manifestDigest, Err: err}
+	}
+	return nil
+}
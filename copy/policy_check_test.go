@@ -0,0 +1,104 @@
+package copy
+
+import (
+	"context"
+	"errors"
+	"testing"
+
+	"github.com/containers/image/v5/manifest"
+	"github.com/containers/image/v5/types"
+	digest "github.com/opencontainers/go-digest"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// policyCheckImage is a types.Image implementing only the methods used by checkDestinationImage.
+type policyCheckImage struct {
+	types.Image
+	configBlob []byte
+	layers     []types.BlobInfo
+	labels     map[string]string
+}
+
+func (i policyCheckImage) Manifest(ctx context.Context) ([]byte, string, error) {
+	return nil, manifest.DockerV2Schema2MediaType, nil
+}
+func (i policyCheckImage) ConfigInfo() types.BlobInfo {
+	return types.BlobInfo{Digest: digest.FromBytes(i.configBlob), Size: int64(len(i.configBlob))}
+}
+func (i policyCheckImage) ConfigBlob(context.Context) ([]byte, error) {
+	return i.configBlob, nil
+}
+func (i policyCheckImage) LayerInfos() []types.BlobInfo {
+	return i.layers
+}
+func (i policyCheckImage) Inspect(context.Context) (*types.ImageInspectInfo, error) {
+	return &types.ImageInspectInfo{Labels: i.labels}, nil
+}
+
+func TestCheckDestinationImage(t *testing.T) {
+	img := policyCheckImage{
+		configBlob: []byte("{}"),
+		layers: []types.BlobInfo{
+			{Digest: digest.FromString("layer1"), Size: 100},
+			{Digest: digest.FromString("layer2"), Size: 20},
+		},
+		labels: map[string]string{"license": "MIT"},
+	}
+	man := []byte("manifest")
+	manDigest := digest.FromBytes(man)
+
+	// No callback: nothing to do.
+	c := &copier{}
+	err := c.checkDestinationImage(context.Background(), img, man, manDigest)
+	assert.NoError(t, err)
+
+	// The callback receives the image data.
+	var received DestinationImage
+	c = &copier{checkDestinationImageFn: func(ctx context.Context, image DestinationImage) error {
+		received = image
+		return nil
+	}}
+	err = c.checkDestinationImage(context.Background(), img, man, manDigest)
+	require.NoError(t, err)
+	assert.Equal(t, man, received.Manifest)
+	assert.Equal(t, manifest.DockerV2Schema2MediaType, received.ManifestMIMEType)
+	assert.Equal(t, manDigest, received.ManifestDigest)
+	assert.Equal(t, img.ConfigInfo(), received.ConfigInfo)
+	assert.Equal(t, img.configBlob, received.ConfigBlob)
+	assert.Equal(t, img.layers, received.Layers)
+	assert.Equal(t, "MIT", received.Inspect.Labels["license"])
+	assert.Equal(t, int64(122), received.Size())
+
+	// A rejection is reported as ErrDestinationImageRejected.
+	policyErr := errors.New("image is too large")
+	c = &copier{checkDestinationImageFn: func(ctx context.Context, image DestinationImage) error {
+		if image.Size() > 100 {
+			return policyErr
+		}
+		return nil
+	}}
+	err = c.checkDestinationImage(context.Background(), img, man, manDigest)
+	require.Error(t, err)
+	var rejected ErrDestinationImageRejected
+	require.True(t, errors.As(err, &rejected))
+	assert.Equal(t, manDigest, rejected.ManifestDigest)
+	assert.True(t, errors.Is(err, policyErr))
+}
+
+func TestDestinationImageSize(t *testing.T) {
+	for _, c := range []struct {
+		image    DestinationImage
+		expected int64
+	}{
+		{DestinationImage{}, 0},
+		{DestinationImage{
+			ConfigInfo: types.BlobInfo{Digest: digest.FromString("config")},
+			ConfigBlob: []byte("config"),
+			Layers:     []types.BlobInfo{{Size: 10}, {Size: 5}},
+		}, 21},
+		{DestinationImage{Layers: []types.BlobInfo{{Size: 10}, {Size: -1}}}, -1},
+	} {
+		assert.Equal(t, c.expected, c.image.Size())
+	}
+}
@@ -0,0 +1,156 @@
+package copy
+
+import (
+	"io"
+	"sync"
+	"time"
+
+	"github.com/containers/image/v5/types"
+	"github.com/opencontainers/go-digest"
+)
+
+// defaultProgressEventInterval is the minimum time between ProgressEventBlobProgress events for a single blob,
+// if Options.ProgressInterval is not set.
+const defaultProgressEventInterval = time.Second
+
+// ProgressEventKind identifies a kind of event reported to Options.ProgressEventCallback.
+type ProgressEventKind string
+
+const (
+	// ProgressEventBlobStarted means that the copy of a blob (a layer or a config) has started, and its contents
+	// are being read from the source.
+	ProgressEventBlobStarted ProgressEventKind = "blob-started"
+	// ProgressEventBlobProgress reports the number of bytes read from the source so far, in ProgressEvent.Transferred.
+	ProgressEventBlobProgress ProgressEventKind = "blob-progress"
+	// ProgressEventBlobDone means that a blob was written to the destination; ProgressEvent.DestinationDigest and
+	// ProgressEvent.DestinationSize describe the written blob, which may differ from the source if it was (de)compressed.
+	// For blobs which were only partially read from the source by the destination, this is not preceded by
+	// ProgressEventBlobStarted.
+	ProgressEventBlobDone ProgressEventKind = "blob-done"
+	// ProgressEventBlobSkipped means that a blob was not copied, because the destination already contained it
+	// (or an equivalent blob).
+	ProgressEventBlobSkipped ProgressEventKind = "blob-skipped"
+	// ProgressEventBlobResumed means that a blob was not copied, because it was written to the destination
+	// by an earlier, interrupted, copy, as recorded in Options.CheckpointFile.
+	ProgressEventBlobResumed ProgressEventKind = "blob-resumed"
+	// ProgressEventManifestWritten means that a manifest (or a manifest list) was written to the destination;
+	// ProgressEvent.Digest, ProgressEvent.Size and ProgressEvent.MediaType describe the written manifest.
+	ProgressEventManifestWritten ProgressEventKind = "manifest-written"
+)
+
+// ProgressEvent describes a step in copying an image, as reported to Options.ProgressEventCallback.
+type ProgressEvent struct {
+	Kind ProgressEventKind
+	// Digest is the digest of the blob in the source, or of the written manifest.
+	Digest digest.Digest
+	// IsConfig is true if the blob is a config.
+	IsConfig bool
+	// LayerIndex is the index of the layer in the source manifest, or -1 if the event does not concern a layer.
+	LayerIndex int
+	// Size is the size of the blob in the source (or -1 if unknown), or of the written manifest.
+	Size int64
+	// Transferred is the number of bytes read from the source so far; only set for
+	// ProgressEventBlobProgress and ProgressEventBlobDone.
+	Transferred int64
+	// DestinationDigest and DestinationSize describe the blob as present in the destination; only set for
+	// ProgressEventBlobDone, ProgressEventBlobSkipped and ProgressEventBlobResumed.  DestinationSize may be -1 if unknown.
+	DestinationDigest digest.Digest
+	DestinationSize   int64
+	// CompressionOperation is the compression operation applied to the blob between the source and the destination;
+	// only set for ProgressEventBlobDone.
+	CompressionOperation types.LayerCompression
+	// MediaType is the MIME type of the written manifest; only set for ProgressEventManifestWritten.
+	MediaType string
+}
+
+// progressEventReporter reports ProgressEvent values to a callback.
+// It is safe for concurrent use; all methods do nothing on a nil receiver.
+type progressEventReporter struct {
+	interval time.Duration
+
+	mutex    sync.Mutex // Serializes calls to callback
+	callback func(ProgressEvent)
+}
+
+// newProgressEventReporter returns a progressEventReporter which reports to callback, reporting the progress of
+// each blob at most once per interval (or defaultProgressEventInterval if 0), or nil if callback is nil.
+func newProgressEventReporter(callback func(ProgressEvent), interval time.Duration) *progressEventReporter {
+	if callback == nil {
+		return nil
+	}
+	if interval <= 0 {
+		interval = defaultProgressEventInterval
+	}
+	return &progressEventReporter{interval: interval, callback: callback}
+}
+
+// report reports event.
+func (r *progressEventReporter) report(event ProgressEvent) {
+	if r == nil {
+		return
+	}
+	r.mutex.Lock()
+	defer r.mutex.Unlock()
+	r.callback(event)
+}
+
+// blobEvent returns a ProgressEvent of kind for a blob with srcInfo.
+func blobEvent(kind ProgressEventKind, srcInfo types.BlobInfo, isConfig bool, layerIndex int) ProgressEvent {
+	if isConfig {
+		layerIndex = -1
+	}
+	return ProgressEvent{
+		Kind:       kind,
+		Digest:     srcInfo.Digest,
+		IsConfig:   isConfig,
+		LayerIndex: layerIndex,
+		Size:       srcInfo.Size,
+	}
+}
+
+// progressEventReader wraps a blob stream, reporting ProgressEventBlobProgress events as it is read.
+type progressEventReader struct {
+	source     io.Reader
+	reporter   *progressEventReporter
+	event      ProgressEvent // A template for the reported events
+	lastReport time.Time
+	offset     int64
+}
+
+// newProgressEventReader returns a reader of source, which reports progress of reading a blob described by event
+// (a ProgressEventBlobStarted event, which is reported immediately) to reporter.
+// It returns source unmodified if reporter is nil.
+func newProgressEventReader(source io.Reader, reporter *progressEventReporter, event ProgressEvent) io.Reader {
+	if reporter == nil {
+		return source
+	}
+	reporter.report(event)
+	return &progressEventReader{
+		source:     source,
+		reporter:   reporter,
+		event:      event,
+		lastReport: time.Now(),
+	}
+}
+
+// Read implements io.Reader
+func (r *progressEventReader) Read(p []byte) (int, error) {
+	n, err := r.source.Read(p)
+	r.offset += int64(n)
+	if n > 0 && time.Since(r.lastReport) >= r.reporter.interval {
+		r.lastReport = time.Now()
+		event := r.event
+		event.Kind = ProgressEventBlobProgress
+		event.Transferred = r.offset
+		r.reporter.report(event)
+	}
+	return n, err
+}
+
+// transferred returns the number of bytes read from a stream returned by newProgressEventReader.
+func transferred(stream io.Reader) int64 {
+	if r, ok := stream.(*progressEventReader); ok {
+		return r.offset
+	}
+	return 0
+}
@@ -0,0 +1,110 @@
+package copy
+
+import (
+	"bytes"
+	"context"
+	"io"
+	"testing"
+	"time"
+
+	"github.com/containers/image/v5/oci/layout"
+	"github.com/containers/image/v5/signature"
+	"github.com/containers/image/v5/types"
+	digest "github.com/opencontainers/go-digest"
+	imgspecv1 "github.com/opencontainers/image-spec/specs-go/v1"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestCopyProgressEvents(t *testing.T) {
+	srcRef, configDigest, layerDigest := newTestOCIImage(t)
+	destDir := t.TempDir()
+	destRef, err := layout.NewReference(destDir, "dest")
+	require.NoError(t, err)
+	policyContext, err := signature.NewPolicyContext(&signature.Policy{Default: []signature.PolicyRequirement{signature.NewPRInsecureAcceptAnything()}})
+	require.NoError(t, err)
+	defer func() { _ = policyContext.Destroy() }()
+
+	copyWithEvents := func(dest, src types.ImageReference) ([]byte, []ProgressEvent) {
+		events := []ProgressEvent{}
+		man, err := Image(context.Background(), policyContext, dest, src, &Options{
+			ProgressEventCallback: func(e ProgressEvent) {
+				if e.Kind != ProgressEventBlobProgress { // Timing-dependent
+					events = append(events, e)
+				}
+			},
+		})
+		require.NoError(t, err)
+		return man, events
+	}
+
+	man, events := copyWithEvents(destRef, srcRef)
+	require.Len(t, events, 5)
+	assert.Equal(t, ProgressEvent{Kind: ProgressEventBlobStarted, Digest: layerDigest, LayerIndex: 0, Size: 27}, events[0])
+	layerDone := events[1]
+	assert.Equal(t, ProgressEventBlobDone, layerDone.Kind)
+	assert.Equal(t, layerDigest, layerDone.Digest)
+	assert.Equal(t, int64(27), layerDone.Transferred)
+	assert.NotEqual(t, layerDigest, layerDone.DestinationDigest) // The layer is compressed
+	assert.NotEqual(t, int64(-1), layerDone.DestinationSize)
+	assert.Equal(t, types.Compress, layerDone.CompressionOperation)
+	assert.Equal(t, ProgressEventBlobStarted, events[2].Kind)
+	assert.Equal(t, configDigest, events[2].Digest)
+	assert.True(t, events[2].IsConfig)
+	assert.Equal(t, -1, events[2].LayerIndex)
+	assert.Equal(t, ProgressEventBlobDone, events[3].Kind)
+	assert.Equal(t, configDigest, events[3].DestinationDigest)
+	assert.Equal(t, ProgressEvent{
+		Kind:       ProgressEventManifestWritten,
+		Digest:     digest.FromBytes(man),
+		LayerIndex: -1,
+		Size:       int64(len(man)),
+		MediaType:  imgspecv1.MediaTypeImageManifest,
+	}, events[4])
+
+	// A copy to a destination which already contains the layer skips it.
+	otherRef, err := layout.NewReference(destDir, "other")
+	require.NoError(t, err)
+	_, events = copyWithEvents(otherRef, destRef)
+	require.NotEmpty(t, events)
+	assert.Equal(t, ProgressEvent{
+		Kind:              ProgressEventBlobSkipped,
+		Digest:            layerDone.DestinationDigest,
+		LayerIndex:        0,
+		Size:              layerDone.DestinationSize,
+		DestinationDigest: layerDone.DestinationDigest,
+		DestinationSize:   layerDone.DestinationSize,
+	}, events[0])
+	assert.Equal(t, ProgressEventManifestWritten, events[len(events)-1].Kind)
+}
+
+func TestProgressEventReader(t *testing.T) {
+	// No reporter
+	src := bytes.NewReader([]byte("contents"))
+	assert.Equal(t, io.Reader(src), newProgressEventReader(src, nil, ProgressEvent{}))
+
+	events := []ProgressEvent{}
+	reporter := newProgressEventReporter(func(e ProgressEvent) { events = append(events, e) }, time.Nanosecond)
+	started := ProgressEvent{Kind: ProgressEventBlobStarted, Digest: digest.FromString("contents"), LayerIndex: 1, Size: 8}
+	reader := newProgressEventReader(io.LimitReader(bytes.NewReader([]byte("contents")), 8), reporter, started)
+	buf := make([]byte, 5)
+	time.Sleep(time.Millisecond)
+	_, err := io.ReadFull(reader, buf)
+	require.NoError(t, err)
+	time.Sleep(time.Millisecond)
+	rest, err := io.ReadAll(reader)
+	require.NoError(t, err)
+	assert.Equal(t, "nts", string(rest))
+	assert.Equal(t, int64(8), transferred(reader))
+
+	require.Len(t, events, 3)
+	assert.Equal(t, started, events[0])
+	progress := started
+	progress.Kind = ProgressEventBlobProgress
+	progress.Transferred = 5
+	assert.Equal(t, progress, events[1])
+	progress.Transferred = 8
+	assert.Equal(t, progress, events[2])
+
+	assert.Nil(t, newProgressEventReporter(nil, time.Second))
+}
@@ -0,0 +1,273 @@
+package copy
+
+import (
+	"context"
+	"fmt"
+	"time"
+
+	"github.com/containers/image/v5/internal/private"
+	"github.com/containers/image/v5/manifest"
+	"github.com/containers/image/v5/types"
+	digest "github.com/opencontainers/go-digest"
+	imgspecv1 "github.com/opencontainers/image-spec/specs-go/v1"
+	"github.com/pkg/errors"
+	"github.com/sirupsen/logrus"
+)
+
+// ReferrerFilter selects artifacts which refer to a copied image using the image-spec v1.1 subject field
+// (e.g. signatures, SBOMs or scan results), to be copied together with the image; see Options.CopyReferrers.
+// A referrer is copied only if it matches all of the conditions which are set.
+type ReferrerFilter struct {
+	// ArtifactTypes, if not empty, only selects referrers with one of these artifact types.
+	ArtifactTypes []string
+	// Annotations, if not empty, only selects referrers which have all of these annotations, with the same values.
+	Annotations map[string]string
+	// Match, if not nil, only selects referrers for which it returns true; it can be used e.g. to only copy signatures
+	// made by a specific signer, as recorded in the referrer’s annotations.
+	Match func(referrer manifest.OCI1Referrer) bool
+	// CosignTagSuffixes, if not empty, also selects artifacts stored by cosign under tags derived from the digest of
+	// the copied manifest, sha256-<hex>.<suffix>, for each of these suffixes (e.g. "sig", "att" and "sbom").
+	// They are written to the destination under tags derived from the digest of the written manifest.
+	// ArtifactTypes does not apply to these artifacts; Annotations and Match do.
+	CosignTagSuffixes []string
+}
+
+// matches returns true if referrer matches f.
+func (f *ReferrerFilter) matches(referrer manifest.OCI1Referrer) bool {
+	if len(f.ArtifactTypes) != 0 {
+		found := false
+		for _, t := range f.ArtifactTypes {
+			if t == referrer.ArtifactType {
+				found = true
+				break
+			}
+		}
+		if !found {
+			return false
+		}
+	}
+	for k, v := range f.Annotations {
+		if value, ok := referrer.Annotations[k]; !ok || value != v {
+			return false
+		}
+	}
+	return f.Match == nil || f.Match(referrer)
+}
+
+// copyReferrers copies the referrers of subject, selected by filter, from lister to c.dest.
+// If newSubject is not nil, subject was written to c.dest as newSubject, and the subject fields of the referrers are updated
+// to refer to it.
+func (c *copier) copyReferrers(ctx context.Context, lister private.ReferrersLister, subject digest.Digest, newSubject *imgspecv1.Descriptor, filter *ReferrerFilter) error {
+	// Ask the source to filter by artifact type only if there is a single one; otherwise filter locally.
+	artifactType := ""
+	if len(filter.ArtifactTypes) == 1 {
+		artifactType = filter.ArtifactTypes[0]
+	}
+	referrers, err := lister.GetReferrers(ctx, subject, artifactType)
+	if err != nil {
+		return errors.Wrapf(err, "listing referrers of %s", subject)
+	}
+	for _, referrer := range referrers {
+		if !filter.matches(referrer) {
+			logrus.Debugf("Skipping referrer %s of type %q", referrer.Digest, referrer.ArtifactType)
+			continue
+		}
+		c.Printf("Copying referrer %s of type %q\n", referrer.Digest, referrer.ArtifactType)
+		if err := c.copyReferrer(ctx, referrer.Digest, subject, newSubject); err != nil {
+			return errors.Wrapf(err, "copying referrer %s of %s", referrer.Digest, subject)
+		}
+	}
+	return nil
+}
+
+// cosignTag returns the tag used by cosign to store artifacts with suffix which refer to the manifest with manifestDigest.
+func cosignTag(manifestDigest digest.Digest, suffix string) string {
+	return manifestDigest.Algorithm().String() + "-" + manifestDigest.Encoded() + "." + suffix
+}
+
+// copyCosignArtifacts copies the artifacts stored using the cosign tag scheme for subject, selected by filter,
+// from reader to c.dest, and tags them using tagger for destDigest, the digest of subject as written to c.dest.
+// If newSubject is not nil, the subject fields of the artifacts, if any, are updated to refer to it.
+func (c *copier) copyCosignArtifacts(ctx context.Context, reader private.TaggedManifestReader, tagger private.ManifestTagger,
+	subject, destDigest digest.Digest, newSubject *imgspecv1.Descriptor, filter *ReferrerFilter) error {
+	cosignFilter := *filter
+	cosignFilter.ArtifactTypes = nil
+	for _, suffix := range filter.CosignTagSuffixes {
+		srcTag := cosignTag(subject, suffix)
+		man, mimeType, err := reader.GetManifestForTag(ctx, srcTag)
+		if err != nil {
+			return errors.Wrapf(err, "reading cosign artifact %s", srcTag)
+		}
+		if man == nil {
+			logrus.Debugf("No cosign artifact %s", srcTag)
+			continue
+		}
+		if mimeType == "" {
+			mimeType = manifest.GuessMIMEType(man)
+		}
+		referrer, err := cosignReferrer(man, mimeType)
+		if err != nil {
+			return errors.Wrapf(err, "parsing cosign artifact %s", srcTag)
+		}
+		if !cosignFilter.matches(referrer) {
+			logrus.Debugf("Skipping cosign artifact %s", srcTag)
+			continue
+		}
+		destTag := cosignTag(destDigest, suffix)
+		c.Printf("Copying cosign artifact %s\n", destTag)
+		written, err := c.copyReferrerManifest(ctx, man, mimeType, subject, newSubject)
+		if err != nil {
+			return errors.Wrapf(err, "copying cosign artifact %s", srcTag)
+		}
+		if err := tagger.PutManifestTag(ctx, written, destTag); err != nil {
+			return errors.Wrapf(err, "writing cosign artifact %s", destTag)
+		}
+	}
+	return nil
+}
+
+// cosignReferrer returns a descriptor of man, an artifact with mimeType stored using the cosign tag scheme,
+// for matching it against a ReferrerFilter.
+func cosignReferrer(man []byte, mimeType string) (manifest.OCI1Referrer, error) {
+	res := manifest.OCI1Referrer{
+		Descriptor: imgspecv1.Descriptor{
+			MediaType: mimeType,
+			Digest:    digest.FromBytes(man),
+			Size:      int64(len(man)),
+		},
+	}
+	if manifest.NormalizedMIMEType(mimeType) == imgspecv1.MediaTypeImageManifest {
+		m, err := manifest.OCI1FromManifest(man)
+		if err != nil {
+			return manifest.OCI1Referrer{}, err
+		}
+		res.Annotations = m.Annotations
+		res.ArtifactType = m.ArtifactType
+		if res.ArtifactType == "" {
+			res.ArtifactType = m.Config.MediaType
+		}
+	}
+	return res, nil
+}
+
+// copyReferrer copies the referrer artifact manifest with referrerDigest, and its blobs, from c.rawSource to c.dest.
+// The manifest is not modified, unless newSubject is not nil, in which case its subject field is updated from subject
+// to newSubject.
+func (c *copier) copyReferrer(ctx context.Context, referrerDigest digest.Digest, subject digest.Digest, newSubject *imgspecv1.Descriptor) error {
+	man, mimeType, err := c.rawSource.GetManifest(ctx, &referrerDigest)
+	if err != nil {
+		return err
+	}
+	matches, err := manifest.MatchesDigest(man, referrerDigest)
+	if err != nil {
+		return errors.Wrap(err, "computing manifest digest")
+	}
+	if !matches {
+		return errors.Errorf("manifest does not match digest %s", referrerDigest)
+	}
+	if mimeType == "" {
+		mimeType = manifest.GuessMIMEType(man)
+	}
+	_, err = c.copyReferrerManifest(ctx, man, mimeType, subject, newSubject)
+	return err
+}
+
+// copyReferrerManifest copies the blobs of man, an artifact manifest with mimeType, from c.rawSource to c.dest, and writes
+// the manifest, with its subject field updated from subject to newSubject if newSubject is not nil.
+// It returns the manifest as written.
+func (c *copier) copyReferrerManifest(ctx context.Context, man []byte, mimeType string, subject digest.Digest, newSubject *imgspecv1.Descriptor) ([]byte, error) {
+	if manifest.MIMETypeIsMultiImage(mimeType) {
+		return nil, errors.Errorf("copying referrers of type %s is not supported", mimeType)
+	}
+	m, err := manifest.FromBlob(man, mimeType)
+	if err != nil {
+		return nil, err
+	}
+
+	blobs := []types.BlobInfo{m.ConfigInfo()}
+	for _, layer := range m.LayerInfos() {
+		blobs = append(blobs, layer.BlobInfo)
+	}
+	for i, blob := range blobs {
+		if blob.Digest == "" {
+			continue
+		}
+		if err := c.copyReferrerBlob(ctx, blob, i == 0, i-1); err != nil {
+			return nil, err
+		}
+	}
+
+	if newSubject != nil {
+		if oci, ok := m.(*manifest.OCI1); ok && oci.Subject != nil && oci.Subject.Digest == subject {
+			s := *oci.Subject
+			s.MediaType = newSubject.MediaType
+			s.Digest = newSubject.Digest
+			s.Size = newSubject.Size
+			if man, err = rewriteManifestSubject(man, &s); err != nil {
+				return nil, errors.Wrap(err, "updating manifest subject")
+			}
+			logrus.Debugf("Updated subject of referrer from %s to %s", subject, newSubject.Digest)
+		}
+	}
+
+	manifestDigest, err := manifest.Digest(man)
+	if err != nil {
+		return nil, errors.Wrap(err, "computing manifest digest")
+	}
+	if err := c.putManifest(ctx, man, &manifestDigest); err != nil {
+		return nil, errors.Wrap(err, "writing manifest")
+	}
+	return man, nil
+}
+
+// copyReferrerBlob copies blob of a referrer artifact, the config if isConfig, otherwise the layer with layerIndex,
+// from c.rawSource to c.dest without modifying it.
+func (c *copier) copyReferrerBlob(ctx context.Context, blob types.BlobInfo, isConfig bool, layerIndex int) error {
+	if err := c.concurrentBlobCopiesSemaphore.Acquire(ctx, 1); err != nil {
+		// This can only fail with ctx.Err(), so no need to blame acquiring the semaphore.
+		return fmt.Errorf("copying blob %s: %w", blob.Digest, err)
+	}
+	defer c.concurrentBlobCopiesSemaphore.Release(1)
+
+	options := private.TryReusingBlobOptions{Cache: c.blobInfoCache}
+	if !isConfig {
+		options.LayerIndex = &layerIndex
+	}
+	reused, _, err := c.dest.TryReusingBlobWithOptions(ctx, blob, options)
+	if err != nil {
+		return errors.Wrapf(err, "trying to reuse blob %s at destination", blob.Digest)
+	}
+	if reused {
+		logrus.Debugf("Skipping blob %s (already present)", blob.Digest)
+		var layer *LayerStatistics
+		if !isConfig {
+			layer = reusedLayerStatistics(blob, blob)
+		}
+		c.statistics.recordReusedBlob(blob.Size, layer)
+		return nil
+	}
+
+	progressPool := c.newProgressPool()
+	defer progressPool.Wait()
+	bar := c.createProgressBar(progressPool, false, blob, "blob", "done")
+	defer bar.Abort(false)
+
+	timer := &blobTimer{}
+	getBlobStart := time.Now()
+	stream, size, err := c.rawSource.GetBlob(ctx, blob, c.blobInfoCache)
+	timer.openDuration = time.Since(getBlobStart)
+	if err != nil {
+		return errors.Wrapf(err, "reading blob %s", blob.Digest)
+	}
+	defer stream.Close()
+	srcInfo := types.BlobInfo{Digest: blob.Digest, Size: size, MediaType: blob.MediaType, Annotations: blob.Annotations}
+	destInfo, err := c.copyBlobFromStream(ctx, stream, timer, srcInfo, nil, false, isConfig, false, bar, layerIndex, false)
+	if err != nil {
+		return err
+	}
+	if destInfo.Digest != blob.Digest {
+		return errors.Errorf("Internal error: copying blob %s changed digest to %s", blob.Digest, destInfo.Digest)
+	}
+	bar.mark100PercentComplete()
+	return nil
+}
@@ -0,0 +1,268 @@
+package copy
+
+import (
+	"context"
+	"io"
+	"os"
+	"path/filepath"
+	"testing"
+
+	internalblobinfocache "github.com/containers/image/v5/internal/blobinfocache"
+	"github.com/containers/image/v5/internal/imagedestination"
+	"github.com/containers/image/v5/internal/imagesource"
+	"github.com/containers/image/v5/internal/private"
+	"github.com/containers/image/v5/manifest"
+	"github.com/containers/image/v5/oci/layout"
+	"github.com/containers/image/v5/pkg/blobinfocache/memory"
+	"github.com/containers/image/v5/signature"
+	digest "github.com/opencontainers/go-digest"
+	imgspecv1 "github.com/opencontainers/image-spec/specs-go/v1"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"golang.org/x/sync/semaphore"
+)
+
+func TestReferrerFilterMatches(t *testing.T) {
+	signature := manifest.OCI1Referrer{ArtifactType: "signature", Descriptor: imgspecv1.Descriptor{Annotations: map[string]string{"signer": "a"}}}
+	sbom := manifest.OCI1Referrer{ArtifactType: "sbom"}
+	for _, c := range []struct {
+		filter          ReferrerFilter
+		signature, sbom bool
+	}{
+		{ReferrerFilter{}, true, true},
+		{ReferrerFilter{ArtifactTypes: []string{"signature"}}, true, false},
+		{ReferrerFilter{ArtifactTypes: []string{"sbom", "signature"}}, true, true},
+		{ReferrerFilter{ArtifactTypes: []string{"other"}}, false, false},
+		{ReferrerFilter{Annotations: map[string]string{"signer": "a"}}, true, false},
+		{ReferrerFilter{Annotations: map[string]string{"signer": "b"}}, false, false},
+		{ReferrerFilter{Match: func(r manifest.OCI1Referrer) bool { return r.ArtifactType == "sbom" }}, false, true},
+		{ReferrerFilter{ArtifactTypes: []string{"signature"}, Match: func(r manifest.OCI1Referrer) bool { return false }}, false, false},
+	} {
+		assert.Equal(t, c.signature, c.filter.matches(signature), "%#v", c.filter)
+		assert.Equal(t, c.sbom, c.filter.matches(sbom), "%#v", c.filter)
+	}
+}
+
+// referrersTestSource is an image source which lists a fixed set of referrers.
+type referrersTestSource struct {
+	private.ImageSource
+	referrers []manifest.OCI1Referrer
+}
+
+func (s *referrersTestSource) GetReferrers(ctx context.Context, manifestDigest digest.Digest, artifactType string) ([]manifest.OCI1Referrer, error) {
+	res := []manifest.OCI1Referrer{}
+	for _, r := range s.referrers {
+		if artifactType == "" || r.ArtifactType == artifactType {
+			res = append(res, r)
+		}
+	}
+	return res, nil
+}
+
+func TestCopyReferrers(t *testing.T) {
+	subjectManifest := []byte(`{"schemaVersion":2,"mediaType":"application/vnd.oci.image.manifest.v1+json","config":{},"layers":[]}`)
+	subjectDigest := digest.FromBytes(subjectManifest)
+	srcDir := t.TempDir()
+	writeOCILayout(t, srcDir, "src", subjectManifest)
+	srcRef, err := layout.NewReference(srcDir, "src")
+	require.NoError(t, err)
+
+	referrers := []manifest.OCI1Referrer{}
+	for _, artifact := range []layout.ReferrerArtifact{
+		{ArtifactType: "application/vnd.example.signature", Blobs: []layout.ReferrerBlob{{MediaType: "application/octet-stream", Data: []byte("signature")}},
+			Annotations: map[string]string{"signer": "trusted"}},
+		{ArtifactType: "application/vnd.example.signature", Blobs: []layout.ReferrerBlob{{MediaType: "application/octet-stream", Data: []byte("other signature")}},
+			Annotations: map[string]string{"signer": "untrusted"}},
+		{ArtifactType: "application/vnd.example.sbom", Blobs: []layout.ReferrerBlob{{MediaType: "application/json", Data: []byte("large SBOM")}}},
+	} {
+		desc, err := layout.PutReferrer(context.Background(), nil, srcRef, subjectDigest, artifact)
+		require.NoError(t, err)
+		desc.Annotations = artifact.Annotations
+		referrers = append(referrers, manifest.OCI1Referrer{Descriptor: desc, ArtifactType: artifact.ArtifactType})
+	}
+
+	for _, c := range []struct {
+		filter   ReferrerFilter
+		expected []int // Indexes of referrers
+	}{
+		{ReferrerFilter{}, []int{0, 1, 2}},
+		{ReferrerFilter{ArtifactTypes: []string{"application/vnd.example.signature"}}, []int{0, 1}},
+		{ReferrerFilter{ArtifactTypes: []string{"application/vnd.example.signature"}, Annotations: map[string]string{"signer": "trusted"}}, []int{0}},
+		{ReferrerFilter{ArtifactTypes: []string{"application/vnd.example.none"}}, []int{}},
+	} {
+		src, err := srcRef.NewImageSource(context.Background(), nil)
+		require.NoError(t, err)
+		destDir := t.TempDir()
+		destRef, err := layout.NewReference(destDir, "dest")
+		require.NoError(t, err)
+		dest, err := destRef.NewImageDestination(context.Background(), nil)
+		require.NoError(t, err)
+
+		lister := &referrersTestSource{ImageSource: imagesource.FromPublic(src), referrers: referrers}
+		copier := &copier{
+			dest:                          imagedestination.FromPublic(dest),
+			rawSource:                     lister,
+			reportWriter:                  io.Discard,
+			progressOutput:                io.Discard,
+			blobInfoCache:                 internalblobinfocache.FromBlobInfoCache(memory.New()),
+			concurrentBlobCopiesSemaphore: semaphore.NewWeighted(1),
+			timings:                       newTimingReport(nil),
+			statistics:                    &statisticsReport{},
+		}
+		err = copier.copyReferrers(context.Background(), lister, subjectDigest, nil, &c.filter)
+		require.NoError(t, err)
+		dest.Close()
+		src.Close()
+
+		exists := func(d digest.Digest) bool {
+			_, err := os.Stat(filepath.Join(destDir, "blobs", d.Algorithm().String(), d.Hex()))
+			return err == nil
+		}
+		for i, r := range referrers {
+			expected := false
+			for _, e := range c.expected {
+				if e == i {
+					expected = true
+				}
+			}
+			assert.Equal(t, expected, exists(r.Digest), "%#v %d", c.filter, i)
+		}
+		assert.Equal(t, len(c.expected) != 0, exists(digest.FromString("{}")), "%#v", c.filter) // The empty config
+	}
+
+	// The source transport must support listing referrers.
+	policyContext, err := signature.NewPolicyContext(&signature.Policy{Default: []signature.PolicyRequirement{signature.NewPRInsecureAcceptAnything()}})
+	require.NoError(t, err)
+	defer func() { _ = policyContext.Destroy() }()
+	destRef, err := layout.NewReference(t.TempDir(), "dest")
+	require.NoError(t, err)
+	_, err = Image(context.Background(), policyContext, destRef, srcRef, &Options{CopyReferrers: &ReferrerFilter{}})
+	assert.ErrorContains(t, err, "does not support listing referrers")
+}
+
+// cosignTestSource is an image source which lists no referrers, and returns manifests for a fixed set of tags.
+type cosignTestSource struct {
+	referrersTestSource
+	tags map[string][]byte
+}
+
+func (s *cosignTestSource) GetManifestForTag(ctx context.Context, tag string) ([]byte, string, error) {
+	m, ok := s.tags[tag]
+	if !ok {
+		return nil, "", nil
+	}
+	return m, imgspecv1.MediaTypeImageManifest, nil
+}
+
+func TestCopyReferrersUpdatingSubject(t *testing.T) {
+	subjectManifest := []byte(`{"schemaVersion":2,"mediaType":"application/vnd.oci.image.manifest.v1+json","config":{},"layers":[]}`)
+	subjectDigest := digest.FromBytes(subjectManifest)
+	srcDir := t.TempDir()
+	writeOCILayout(t, srcDir, "src", subjectManifest)
+	srcRef, err := layout.NewReference(srcDir, "src")
+	require.NoError(t, err)
+
+	artifacts := map[string][]byte{} // Cosign tag suffix -> manifest
+	referrers := []manifest.OCI1Referrer{}
+	for suffix, signer := range map[string]string{"sig": "trusted", "att": "untrusted"} {
+		desc, err := layout.PutReferrer(context.Background(), nil, srcRef, subjectDigest, layout.ReferrerArtifact{
+			ArtifactType: "application/vnd.example." + suffix,
+			Blobs:        []layout.ReferrerBlob{{MediaType: "application/octet-stream", Data: []byte(suffix)}},
+			Annotations:  map[string]string{"signer": signer},
+		})
+		require.NoError(t, err)
+		artifacts[suffix], err = os.ReadFile(filepath.Join(srcDir, "blobs", desc.Digest.Algorithm().String(), desc.Digest.Hex()))
+		require.NoError(t, err)
+		desc.Annotations = map[string]string{"signer": signer}
+		referrers = append(referrers, manifest.OCI1Referrer{Descriptor: desc, ArtifactType: "application/vnd.example." + suffix})
+	}
+	newSubject := &imgspecv1.Descriptor{MediaType: manifest.DockerV2Schema2MediaType, Digest: digest.FromString("converted"), Size: 9}
+
+	for _, c := range []struct {
+		newSubject   *imgspecv1.Descriptor
+		expectedTags []string
+	}{
+		{nil, []string{cosignTag(subjectDigest, "sig")}},
+		{newSubject, []string{cosignTag(newSubject.Digest, "sig")}},
+	} {
+		src, err := srcRef.NewImageSource(context.Background(), nil)
+		require.NoError(t, err)
+		destDir := t.TempDir()
+		destRef, err := layout.NewReference(destDir, "dest")
+		require.NoError(t, err)
+		dest, err := destRef.NewImageDestination(context.Background(), nil)
+		require.NoError(t, err)
+
+		source := &cosignTestSource{
+			referrersTestSource: referrersTestSource{ImageSource: imagesource.FromPublic(src), referrers: referrers},
+			tags: map[string][]byte{
+				cosignTag(subjectDigest, "sig"): artifacts["sig"],
+				cosignTag(subjectDigest, "att"): artifacts["att"],
+			},
+		}
+		copier := &copier{
+			dest:                          imagedestination.FromPublic(dest),
+			rawSource:                     source,
+			reportWriter:                  io.Discard,
+			progressOutput:                io.Discard,
+			blobInfoCache:                 internalblobinfocache.FromBlobInfoCache(memory.New()),
+			concurrentBlobCopiesSemaphore: semaphore.NewWeighted(1),
+			timings:                       newTimingReport(nil),
+			statistics:                    &statisticsReport{},
+		}
+		destDigest := subjectDigest
+		if c.newSubject != nil {
+			destDigest = c.newSubject.Digest
+		}
+		filter := &ReferrerFilter{CosignTagSuffixes: []string{"sig", "att", "sbom"}, Annotations: map[string]string{"signer": "trusted"}}
+		err = copier.copyReferrers(context.Background(), source, subjectDigest, c.newSubject, filter)
+		require.NoError(t, err)
+		tagger := &testTagger{tags: map[string][]byte{}}
+		err = copier.copyCosignArtifacts(context.Background(), source, tagger, subjectDigest, destDigest, c.newSubject, filter)
+		require.NoError(t, err)
+		dest.Close()
+		src.Close()
+
+		tags := []string{}
+		for tag, m := range tagger.tags {
+			tags = append(tags, tag)
+			_, err := os.Stat(filepath.Join(destDir, "blobs", "sha256", digest.FromBytes(m).Hex()))
+			assert.NoError(t, err, tag)
+		}
+		assert.Equal(t, c.expectedTags, tags)
+
+		// The trusted referrer, and the trusted cosign artifact, which is the same manifest, refer to the written subject.
+		written := tagger.tags[cosignTag(destDigest, "sig")]
+		parsed, err := manifest.OCI1FromManifest(written)
+		require.NoError(t, err)
+		require.NotNil(t, parsed.Subject)
+		assert.Equal(t, destDigest, parsed.Subject.Digest)
+		if c.newSubject == nil {
+			assert.Equal(t, artifacts["sig"], written)
+		} else {
+			assert.Equal(t, *c.newSubject, *parsed.Subject)
+		}
+		_, err = os.Stat(filepath.Join(destDir, "blobs", "sha256", digest.FromBytes(artifacts["att"]).Hex()))
+		assert.True(t, os.IsNotExist(err))
+	}
+}
+
+func TestCosignReferrer(t *testing.T) {
+	man := []byte(`{"schemaVersion":2,"mediaType":"application/vnd.oci.image.manifest.v1+json",` +
+		`"config":{"mediaType":"application/vnd.oci.image.config.v1+json","digest":"sha256:0000000000000000000000000000000000000000000000000000000000000000","size":1},` +
+		`"layers":[],"annotations":{"a":"b"}}`)
+	r, err := cosignReferrer(man, imgspecv1.MediaTypeImageManifest)
+	require.NoError(t, err)
+	assert.Equal(t, manifest.OCI1Referrer{
+		Descriptor: imgspecv1.Descriptor{
+			MediaType:   imgspecv1.MediaTypeImageManifest,
+			Digest:      digest.FromBytes(man),
+			Size:        int64(len(man)),
+			Annotations: map[string]string{"a": "b"},
+		},
+		ArtifactType: imgspecv1.MediaTypeImageConfig,
+	}, r)
+
+	d := digest.Digest("sha256:0123456789abcdef0123456789abcdef0123456789abcdef0123456789abcdef")
+	assert.Equal(t, "sha256-0123456789abcdef0123456789abcdef0123456789abcdef0123456789abcdef.sig", cosignTag(d, "sig"))
+}
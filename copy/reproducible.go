@@ -0,0 +1,131 @@
+package copy
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"time"
+
+	"github.com/containers/image/v5/manifest"
+	compressiontypes "github.com/containers/image/v5/pkg/compression/types"
+	imgspecv1 "github.com/opencontainers/image-spec/specs-go/v1"
+	"github.com/pkg/errors"
+)
+
+// ReproducibleOptions control a reproducible copy; see Options.Reproducible.
+//
+// In a reproducible copy, layers are compressed using fixed compression levels (unless a level is explicitly requested),
+// and are never substituted by other variants of the same layer recorded in the blob info cache, which could have been
+// compressed by other tools, or with other parameters.  Manifests and configs which are modified by the copy are
+// always serialized as canonical JSON, with sorted keys (and therefore sorted annotations).
+type ReproducibleOptions struct {
+	// Timestamp, if set, replaces the creation timestamps of the image: the created fields of the config and of its
+	// history entries, and the org.opencontainers.image.created annotation of OCI manifests, if present.
+	// Use e.g. time.Unix(0, 0) for zeroed timestamps, or the value of SOURCE_DATE_EPOCH.
+	// This modifies the copied image, like Options.ModifyConfig; it fails if the image has no config (schema1) or
+	// can't be modified.  Configs of OCI artifacts which are not container images are not modified.
+	Timestamp *time.Time
+}
+
+// reproducibleCompressionLevels are the compression levels used in reproducible copies if no level is requested.
+// They are the current defaults of the compression implementations, fixed so that changing the defaults does not change
+// the output.
+var reproducibleCompressionLevels = map[string]int{
+	compressiontypes.GzipAlgorithmName:        6,
+	compressiontypes.ZstdAlgorithmName:        3,
+	compressiontypes.ZstdChunkedAlgorithmName: 3,
+}
+
+// reproducibleCompressionLevel returns the compression level to use for algorithm in a reproducible copy, if level
+// (which may be nil) is not set.
+func reproducibleCompressionLevel(algorithm compressiontypes.Algorithm, level *int) *int {
+	if level != nil {
+		return level
+	}
+	if l, ok := reproducibleCompressionLevels[algorithm.Name()]; ok {
+		return &l
+	}
+	return nil
+}
+
+// withConfigTimestamp returns a function suitable for Options.ModifyConfig which calls hook (if not nil), and sets
+// the creation timestamps of the config to timestamp.
+func withConfigTimestamp(hook func(ctx context.Context, config []byte, mediaType string) ([]byte, error), timestamp time.Time) func(ctx context.Context, config []byte, mediaType string) ([]byte, error) {
+	return func(ctx context.Context, config []byte, mediaType string) ([]byte, error) {
+		if hook != nil {
+			c, err := hook(ctx, config, mediaType)
+			if err != nil {
+				return nil, err
+			}
+			config = c
+		}
+		if !isImageConfigMediaType(mediaType) {
+			return config, nil
+		}
+		return setConfigTimestamp(config, timestamp)
+	}
+}
+
+// setConfigTimestamp returns config, an OCI or Docker image config, with its created fields set to timestamp.
+// If no change is necessary, config is returned unmodified.
+func setConfigTimestamp(config []byte, timestamp time.Time) ([]byte, error) {
+	ts, err := json.Marshal(timestamp.UTC())
+	if err != nil {
+		return nil, err
+	}
+	parsed := map[string]json.RawMessage{}
+	if err := json.Unmarshal(config, &parsed); err != nil {
+		return nil, errors.Wrap(err, "parsing config")
+	}
+	changed := false
+	if !bytes.Equal(parsed["created"], ts) {
+		parsed["created"] = ts
+		changed = true
+	}
+	if rawHistory, ok := parsed["history"]; ok && string(rawHistory) != "null" {
+		history := []map[string]json.RawMessage{}
+		if err := json.Unmarshal(rawHistory, &history); err != nil {
+			return nil, errors.Wrap(err, "parsing config history")
+		}
+		for _, h := range history {
+			if created, ok := h["created"]; ok && !bytes.Equal(created, ts) {
+				h["created"] = ts
+				changed = true
+			}
+		}
+		if parsed["history"], err = json.Marshal(history); err != nil {
+			return nil, err
+		}
+	}
+	if !changed {
+		return config, nil
+	}
+	return json.Marshal(parsed)
+}
+
+// withManifestTimestamp returns a function suitable for Options.ModifyManifest which calls hook (if not nil), and sets
+// the org.opencontainers.image.created annotation of OCI manifests, if present, to timestamp.
+func withManifestTimestamp(hook func(ctx context.Context, manifest []byte, mimeType string) ([]byte, error), timestamp time.Time) func(ctx context.Context, manifest []byte, mimeType string) ([]byte, error) {
+	return func(ctx context.Context, man []byte, mimeType string) ([]byte, error) {
+		if hook != nil {
+			m, err := hook(ctx, man, mimeType)
+			if err != nil {
+				return nil, err
+			}
+			man = m
+		}
+		if manifest.NormalizedMIMEType(mimeType) != imgspecv1.MediaTypeImageManifest {
+			return man, nil
+		}
+		m, err := manifest.OCI1FromManifest(man)
+		if err != nil {
+			return nil, err
+		}
+		value := timestamp.UTC().Format(time.RFC3339)
+		if created, ok := m.Annotations[imgspecv1.AnnotationCreated]; !ok || created == value {
+			return man, nil
+		}
+		m.Annotations[imgspecv1.AnnotationCreated] = value
+		return m.Serialize()
+	}
+}
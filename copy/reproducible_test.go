@@ -0,0 +1,104 @@
+package copy
+
+import (
+	"context"
+	"io"
+	"testing"
+	"time"
+
+	"github.com/containers/image/v5/image"
+	"github.com/containers/image/v5/manifest"
+	"github.com/containers/image/v5/oci/layout"
+	"github.com/containers/image/v5/pkg/blobinfocache/none"
+	"github.com/containers/image/v5/pkg/compression"
+	"github.com/containers/image/v5/signature"
+	"github.com/containers/image/v5/types"
+	imgspecv1 "github.com/opencontainers/image-spec/specs-go/v1"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestCopyReproducible(t *testing.T) {
+	srcRef, _, _ := newTestOCIImage(t)
+	policyContext, err := signature.NewPolicyContext(&signature.Policy{Default: []signature.PolicyRequirement{signature.NewPRInsecureAcceptAnything()}})
+	require.NoError(t, err)
+	defer func() { _ = policyContext.Destroy() }()
+
+	timestamp := time.Unix(0, 0)
+	for _, algo := range []*compression.Algorithm{nil, &compression.Zstd} {
+		var blobs [][]byte
+		var manifests [][]byte
+		for i := 0; i < 2; i++ {
+			destRef, err := layout.NewReference(t.TempDir(), "dest")
+			require.NoError(t, err)
+			man, err := Image(context.Background(), policyContext, destRef, srcRef, &Options{
+				DestinationCtx: &types.SystemContext{CompressionFormat: algo},
+				Reproducible:   &ReproducibleOptions{Timestamp: &timestamp},
+			})
+			require.NoError(t, err)
+			manifests = append(manifests, man)
+
+			m, err := manifest.OCI1FromManifest(man)
+			require.NoError(t, err)
+			require.Len(t, m.Layers, 1)
+			src, err := destRef.NewImageSource(context.Background(), nil)
+			require.NoError(t, err)
+			defer src.Close()
+			rc, _, err := src.GetBlob(context.Background(), types.BlobInfo{Digest: m.Layers[0].Digest, Size: -1}, none.NoCache)
+			require.NoError(t, err)
+			blob, err := io.ReadAll(rc)
+			rc.Close()
+			require.NoError(t, err)
+			blobs = append(blobs, blob)
+
+			img, err := image.FromUnparsedImage(context.Background(), nil, image.UnparsedInstance(src, nil))
+			require.NoError(t, err)
+			config, err := img.OCIConfig(context.Background())
+			require.NoError(t, err)
+			require.NotNil(t, config.Created)
+			assert.True(t, timestamp.Equal(*config.Created))
+		}
+		assert.Equal(t, manifests[0], manifests[1])
+		assert.Equal(t, blobs[0], blobs[1])
+	}
+}
+
+func TestSetConfigTimestamp(t *testing.T) {
+	timestamp := time.Date(2020, 1, 2, 3, 4, 5, 0, time.FixedZone("", 3600))
+
+	res, err := setConfigTimestamp([]byte(`{"created":"2021-01-01T00:00:00Z","history":[{"created":"2021-01-01T00:00:00Z","created_by":"a"},{"created_by":"b"}],"os":"linux"}`), timestamp)
+	require.NoError(t, err)
+	assert.JSONEq(t, `{"created":"2020-01-02T02:04:05Z","history":[{"created":"2020-01-02T02:04:05Z","created_by":"a"},{"created_by":"b"}],"os":"linux"}`, string(res))
+
+	// No change is necessary
+	unchanged := []byte(`{"os": "linux", "created": "2020-01-02T02:04:05Z", "history": null}`)
+	res, err = setConfigTimestamp(unchanged, timestamp)
+	require.NoError(t, err)
+	assert.Equal(t, unchanged, res)
+
+	_, err = setConfigTimestamp([]byte(`{"history":{}}`), timestamp)
+	assert.Error(t, err)
+}
+
+func TestWithManifestTimestamp(t *testing.T) {
+	timestamp := time.Unix(0, 0)
+	hook := withManifestTimestamp(nil, timestamp)
+
+	m := manifest.OCI1FromComponents(imgspecv1.Descriptor{MediaType: imgspecv1.MediaTypeImageConfig}, nil)
+	m.Annotations = map[string]string{imgspecv1.AnnotationCreated: "2021-01-01T00:00:00Z", "other": "value"}
+	man, err := m.Serialize()
+	require.NoError(t, err)
+	res, err := hook(context.Background(), man, imgspecv1.MediaTypeImageManifest)
+	require.NoError(t, err)
+	m2, err := manifest.OCI1FromManifest(res)
+	require.NoError(t, err)
+	assert.Equal(t, map[string]string{imgspecv1.AnnotationCreated: "1970-01-01T00:00:00Z", "other": "value"}, m2.Annotations)
+
+	// The annotation is not added if missing
+	m.Annotations = nil
+	man, err = m.Serialize()
+	require.NoError(t, err)
+	res, err = hook(context.Background(), man, imgspecv1.MediaTypeImageManifest)
+	require.NoError(t, err)
+	assert.Equal(t, man, res)
+}
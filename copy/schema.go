@@ -0,0 +1,51 @@
+package copy
+
+import (
+	"context"
+
+	"github.com/containers/image/v5/image"
+	"github.com/containers/image/v5/manifest"
+	"github.com/containers/image/v5/types"
+	"github.com/pkg/errors"
+)
+
+// validateManifestSchema checks the manifest (or manifest list) of unparsed against the JSON schema of its format,
+// if Options.StrictSchemaValidation is set.
+func (c *copier) validateManifestSchema(ctx context.Context, unparsed *image.UnparsedImage) error {
+	if !c.strictSchemaValidation {
+		return nil
+	}
+	man, mimeType, err := unparsed.Manifest(ctx)
+	if err != nil {
+		return errors.Wrap(err, "reading manifest for schema validation")
+	}
+	return validateSchema(man, mimeType, "source manifest")
+}
+
+// validateConfigSchema checks the config of src against the JSON schema of its format,
+// if Options.StrictSchemaValidation is set.
+func (c *copier) validateConfigSchema(ctx context.Context, src types.Image) error {
+	if !c.strictSchemaValidation {
+		return nil
+	}
+	configInfo := src.ConfigInfo()
+	if configInfo.Digest == "" || !manifest.SchemaValidationSupported(configInfo.MediaType) {
+		return nil // No config, e.g. Docker schema1, or an artifact config
+	}
+	config, err := src.ConfigBlob(ctx)
+	if err != nil {
+		return errors.Wrap(err, "reading config for schema validation")
+	}
+	return validateSchema(config, configInfo.MediaType, "source config")
+}
+
+// validateSchema checks blob of mimeType, described as what, against the JSON schema of its format, if one is known.
+func validateSchema(blob []byte, mimeType string, what string) error {
+	if !manifest.SchemaValidationSupported(mimeType) {
+		return nil
+	}
+	if err := manifest.ValidateSchema(blob, mimeType); err != nil {
+		return errors.Wrapf(err, "validating %s", what)
+	}
+	return nil
+}
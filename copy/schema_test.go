@@ -0,0 +1,79 @@
+package copy
+
+import (
+	"context"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/containers/image/v5/manifest"
+	"github.com/containers/image/v5/oci/layout"
+	"github.com/containers/image/v5/signature"
+	"github.com/containers/image/v5/types"
+	digest "github.com/opencontainers/go-digest"
+	imgspecv1 "github.com/opencontainers/image-spec/specs-go/v1"
+	"github.com/pkg/errors"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// newTestOCIImageWithConfig creates an OCI layout containing an image with config and an uncompressed layer,
+// and returns a reference to it.
+func newTestOCIImageWithConfig(t *testing.T, config []byte) types.ImageReference {
+	layer := []byte("not really a tar file")
+	man, err := manifest.OCI1FromComponents(imgspecv1.Descriptor{
+		MediaType: imgspecv1.MediaTypeImageConfig,
+		Digest:    digest.FromBytes(config),
+		Size:      int64(len(config)),
+	}, []imgspecv1.Descriptor{{
+		MediaType: imgspecv1.MediaTypeImageLayer,
+		Digest:    digest.FromBytes(layer),
+		Size:      int64(len(layer)),
+	}}).Serialize()
+	require.NoError(t, err)
+	srcDir := t.TempDir()
+	writeOCILayout(t, srcDir, "src", man)
+	for _, blob := range [][]byte{config, layer} {
+		d := digest.FromBytes(blob)
+		require.NoError(t, os.WriteFile(filepath.Join(srcDir, "blobs", d.Algorithm().String(), d.Hex()), blob, 0644))
+	}
+	srcRef, err := layout.NewReference(srcDir, "src")
+	require.NoError(t, err)
+	return srcRef
+}
+
+func TestCopyStrictSchemaValidation(t *testing.T) {
+	policyContext, err := signature.NewPolicyContext(&signature.Policy{Default: []signature.PolicyRequirement{signature.NewPRInsecureAcceptAnything()}})
+	require.NoError(t, err)
+	defer func() { _ = policyContext.Destroy() }()
+
+	validConfig := []byte(`{"architecture":"amd64","os":"linux","rootfs":{"type":"layers","diff_ids":["sha256:e692418e4cbaf90ca69d05a66403747baa33ee08806650b51fab815ad7fc331f"]}}`)
+	invalidConfig := []byte(`{"architecture":"amd64","os":"linux","config":{"Env":["PATH"]},"rootfs":{"type":"layers","diff_ids":["sha256:e692418e4cbaf90ca69d05a66403747baa33ee08806650b51fab815ad7fc331f"]}}`)
+	artifactRef, _ := newTestOCIArtifact(t)
+
+	for _, c := range []struct {
+		src     types.ImageReference
+		invalid bool
+	}{
+		{newTestOCIImageWithConfig(t, validConfig), false},
+		{newTestOCIImageWithConfig(t, invalidConfig), true},
+		{artifactRef, false}, // Artifact configs are not validated
+	} {
+		// Invalid data is only rejected if requested
+		for _, strict := range []bool{false, true} {
+			destRef, err := layout.NewReference(t.TempDir(), "dest")
+			require.NoError(t, err)
+			_, err = Image(context.Background(), policyContext, destRef, c.src, &Options{StrictSchemaValidation: strict})
+			if c.invalid && strict {
+				require.Error(t, err)
+				var schemaErr manifest.SchemaValidationError
+				require.True(t, errors.As(err, &schemaErr))
+				assert.Equal(t, imgspecv1.MediaTypeImageConfig, schemaErr.MIMEType)
+				require.Len(t, schemaErr.Violations, 1)
+				assert.Equal(t, "/config/Env/0", schemaErr.Violations[0].Location)
+			} else {
+				assert.NoError(t, err)
+			}
+		}
+	}
+}
@@ -1,12 +1,38 @@
 package copy
 
 import (
+	"bytes"
+	"context"
+	"encoding/json"
+
 	"github.com/containers/image/v5/docker/reference"
+	"github.com/containers/image/v5/internal/private"
+	"github.com/containers/image/v5/manifest"
 	"github.com/containers/image/v5/signature"
+	"github.com/containers/image/v5/signature/sigstore"
 	"github.com/containers/image/v5/transports"
+	"github.com/containers/image/v5/types"
+	digest "github.com/opencontainers/go-digest"
+	imgspecv1 "github.com/opencontainers/image-spec/specs-go/v1"
 	"github.com/pkg/errors"
+	"github.com/sirupsen/logrus"
 )
 
+// signIdentity returns the identity to use when signing, identity if it is set, or the docker reference of c.dest.
+func (c *copier) signIdentity(identity reference.Named) (reference.Named, error) {
+	if identity != nil {
+		if reference.IsNameOnly(identity) {
+			return nil, errors.Errorf("Sign identity must be a fully specified reference %s", identity)
+		}
+		return identity, nil
+	}
+	identity = c.dest.Reference().DockerReference()
+	if identity == nil {
+		return nil, errors.Errorf("Cannot determine canonical Docker reference for destination %s", transports.ImageName(c.dest.Reference()))
+	}
+	return identity, nil
+}
+
 // createSignature creates a new signature of manifest using keyIdentity.
 func (c *copier) createSignature(manifest []byte, keyIdentity string, passphrase string, identity reference.Named) ([]byte, error) {
 	mech, err := signature.NewGPGSigningMechanism()
@@ -18,15 +44,9 @@ func (c *copier) createSignature(manifest []byte, keyIdentity string, passphrase
 		return nil, errors.Wrap(err, "Signing not supported")
 	}
 
-	if identity != nil {
-		if reference.IsNameOnly(identity) {
-			return nil, errors.Errorf("Sign identity must be a fully specified reference %s", identity)
-		}
-	} else {
-		identity = c.dest.Reference().DockerReference()
-		if identity == nil {
-			return nil, errors.Errorf("Cannot determine canonical Docker reference for destination %s", transports.ImageName(c.dest.Reference()))
-		}
+	identity, err = c.signIdentity(identity)
+	if err != nil {
+		return nil, err
 	}
 
 	c.Printf("Signing manifest\n")
@@ -36,3 +56,127 @@ func (c *copier) createSignature(manifest []byte, keyIdentity string, passphrase
 	}
 	return newSig, nil
 }
+
+// sigstoreSignature is a sigstore signature of a written manifest, to be stored by putSigstoreSignatures.
+type sigstoreSignature struct {
+	manifestDigest digest.Digest
+	payload        []byte
+	annotations    map[string]string
+}
+
+// createSigstoreSignature creates a new sigstore signature of manifest, which was written with manifestDigest, using
+// c.sigstoreSigner, and records it to be stored by putSigstoreSignatures.
+func (c *copier) createSigstoreSignature(ctx context.Context, manifest []byte, manifestDigest digest.Digest, identity reference.Named) error {
+	identity, err := c.signIdentity(identity)
+	if err != nil {
+		return err
+	}
+	c.Printf("Creating sigstore signature\n")
+	sig, err := c.sigstoreSigner.SignImage(ctx, manifest, identity.String())
+	if err != nil {
+		return errors.Wrap(err, "creating sigstore signature")
+	}
+	c.sigstoreSignatures = append(c.sigstoreSignatures, sigstoreSignature{
+		manifestDigest: manifestDigest,
+		payload:        sig.Payload,
+		annotations:    sig.Annotations,
+	})
+	return nil
+}
+
+// putSigstoreSignatures stores the signatures recorded by createSigstoreSignature in c.dest using the cosign tag scheme,
+// tagging the signature artifacts using tagger.  Unless removeExisting, signatures which already exist in c.dest are
+// preserved.
+func (c *copier) putSigstoreSignatures(ctx context.Context, tagger private.ManifestTagger, removeExisting bool) error {
+	reader, _ := c.dest.(private.TaggedManifestReader)
+	if reader == nil && !removeExisting {
+		logrus.Debugf("Destination can't read existing sigstore signatures, replacing them")
+	}
+	for _, s := range c.sigstoreSignatures {
+		tag := cosignTag(s.manifestDigest, sigstore.SignatureTagSuffix)
+		c.Printf("Storing sigstore signature %s\n", tag)
+		layers := []imgspecv1.Descriptor{}
+		if reader != nil && !removeExisting {
+			existing, mimeType, err := reader.GetManifestForTag(ctx, tag)
+			if err != nil {
+				return errors.Wrapf(err, "reading existing sigstore signatures %s", tag)
+			}
+			if existing != nil {
+				if mimeType == "" {
+					mimeType = manifest.GuessMIMEType(existing)
+				}
+				if manifest.NormalizedMIMEType(mimeType) != imgspecv1.MediaTypeImageManifest {
+					return errors.Errorf("existing sigstore signatures %s have unexpected type %s", tag, mimeType)
+				}
+				m, err := manifest.OCI1FromManifest(existing)
+				if err != nil {
+					return errors.Wrapf(err, "parsing existing sigstore signatures %s", tag)
+				}
+				layers = append(layers, m.Layers...)
+			}
+		}
+
+		payloadInfo, err := c.putSigstoreBlob(ctx, s.payload, sigstore.SignatureMIMEType, false, len(layers))
+		if err != nil {
+			return err
+		}
+		layers = append(layers, imgspecv1.Descriptor{
+			MediaType:   payloadInfo.MediaType,
+			Digest:      payloadInfo.Digest,
+			Size:        payloadInfo.Size,
+			Annotations: s.annotations,
+		})
+		// The payloads are not compressed, so the DiffIDs are the layer digests.
+		config := imgspecv1.Image{RootFS: imgspecv1.RootFS{Type: "layers"}}
+		for _, l := range layers {
+			config.RootFS.DiffIDs = append(config.RootFS.DiffIDs, l.Digest)
+		}
+		configBlob, err := json.Marshal(config)
+		if err != nil {
+			return err
+		}
+		configInfo, err := c.putSigstoreBlob(ctx, configBlob, imgspecv1.MediaTypeImageConfig, true, -1)
+		if err != nil {
+			return err
+		}
+
+		man, err := manifest.OCI1FromComponents(imgspecv1.Descriptor{
+			MediaType: configInfo.MediaType,
+			Digest:    configInfo.Digest,
+			Size:      configInfo.Size,
+		}, layers).Serialize()
+		if err != nil {
+			return err
+		}
+		manifestDigest, err := manifest.Digest(man)
+		if err != nil {
+			return errors.Wrap(err, "computing manifest digest")
+		}
+		if err := c.putManifest(ctx, man, &manifestDigest); err != nil {
+			return errors.Wrapf(err, "writing sigstore signatures %s", tag)
+		}
+		if err := tagger.PutManifestTag(ctx, man, tag); err != nil {
+			return errors.Wrapf(err, "writing sigstore signatures %s", tag)
+		}
+	}
+	return nil
+}
+
+// putSigstoreBlob writes blob with mediaType, the config if isConfig, otherwise the layer with layerIndex,
+// of a sigstore signature artifact to c.dest.
+func (c *copier) putSigstoreBlob(ctx context.Context, blob []byte, mediaType string, isConfig bool, layerIndex int) (types.BlobInfo, error) {
+	options := private.PutBlobOptions{Cache: c.blobInfoCache, IsConfig: isConfig}
+	if !isConfig {
+		options.LayerIndex = &layerIndex
+	}
+	info, err := c.dest.PutBlobWithOptions(ctx, bytes.NewReader(blob), types.BlobInfo{
+		Digest:    digest.FromBytes(blob),
+		Size:      int64(len(blob)),
+		MediaType: mediaType,
+	}, options)
+	if err != nil {
+		return types.BlobInfo{}, errors.Wrap(err, "writing sigstore signature blob")
+	}
+	info.MediaType = mediaType
+	return info, nil
+}
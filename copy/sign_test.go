@@ -2,17 +2,37 @@ package copy
 
 import (
 	"context"
+	"crypto"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/sha256"
+	"crypto/x509"
+	"encoding/base64"
+	"encoding/json"
+	"encoding/pem"
 	"io"
+	"net/http/httptest"
 	"os"
+	"path/filepath"
 	"testing"
+	"time"
 
 	"github.com/containers/image/v5/directory"
 	"github.com/containers/image/v5/docker"
+	internalblobinfocache "github.com/containers/image/v5/internal/blobinfocache"
 	"github.com/containers/image/v5/internal/imagedestination"
+	"github.com/containers/image/v5/internal/private"
+	"github.com/containers/image/v5/internal/testing/tsa"
 	"github.com/containers/image/v5/manifest"
+	"github.com/containers/image/v5/oci/layout"
+	"github.com/containers/image/v5/pkg/blobinfocache/memory"
 	"github.com/containers/image/v5/signature"
+	"github.com/containers/image/v5/signature/sigstore"
 	"github.com/containers/image/v5/types"
 	"github.com/docker/distribution/reference"
+	digest "github.com/opencontainers/go-digest"
+	imgspecv1 "github.com/opencontainers/image-spec/specs-go/v1"
 	"github.com/stretchr/testify/assert"
 	"github.com/stretchr/testify/require"
 )
@@ -98,3 +118,164 @@ func TestCreateSignature(t *testing.T) {
 	assert.Equal(t, "myregistry.io/myrepo:mytag", verified.DockerReference)
 	assert.Equal(t, manifestDigest, verified.DockerManifestDigest)
 }
+
+// sigstoreTestDestination is an image destination which stores tags in memory.
+type sigstoreTestDestination struct {
+	private.ImageDestination
+	tags map[string][]byte
+}
+
+func (d *sigstoreTestDestination) PutManifestTag(ctx context.Context, manifest []byte, tag string) error {
+	d.tags[tag] = manifest
+	return nil
+}
+
+func (d *sigstoreTestDestination) GetManifestForTag(ctx context.Context, tag string) ([]byte, string, error) {
+	m, ok := d.tags[tag]
+	if !ok {
+		return nil, "", nil
+	}
+	return m, imgspecv1.MediaTypeImageManifest, nil
+}
+
+// testKMSKey is a sigstore.KMSKey backed by a local private key.
+type testKMSKey struct {
+	key *ecdsa.PrivateKey
+}
+
+func (k testKMSKey) PublicKey(ctx context.Context) (crypto.PublicKey, error) {
+	return &k.key.PublicKey, nil
+}
+
+func (k testKMSKey) SignDigest(ctx context.Context, digest []byte) ([]byte, error) {
+	return ecdsa.SignASN1(rand.Reader, k.key, digest)
+}
+
+func TestPutSigstoreSignatures(t *testing.T) {
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	require.NoError(t, err)
+	der, err := x509.MarshalPKCS8PrivateKey(key)
+	require.NoError(t, err)
+	keyFile := filepath.Join(t.TempDir(), "cosign.key")
+	require.NoError(t, os.WriteFile(keyFile, pem.EncodeToMemory(&pem.Block{Type: "PRIVATE KEY", Bytes: der}), 0600))
+	signer, err := sigstore.NewSignerFromPrivateKey(pem.EncodeToMemory(&pem.Block{Type: "PRIVATE KEY", Bytes: der}), nil)
+	require.NoError(t, err)
+
+	manifestBlob := []byte(`{"schemaVersion":2}`)
+	manifestDigest := digest.FromBytes(manifestBlob)
+	tag := "sha256-" + manifestDigest.Hex() + ".sig"
+	identity, err := reference.ParseNamed("example.com/repo:tag")
+	require.NoError(t, err)
+
+	destDir := t.TempDir()
+	destRef, err := layout.NewReference(destDir, "dest")
+	require.NoError(t, err)
+	dest, err := destRef.NewImageDestination(context.Background(), nil)
+	require.NoError(t, err)
+	defer dest.Close()
+	taggingDest := &sigstoreTestDestination{ImageDestination: imagedestination.FromPublic(dest), tags: map[string][]byte{}}
+
+	for _, c := range []struct {
+		removeExisting bool
+		expectedLayers int
+	}{
+		{false, 1},
+		{false, 2},
+		{true, 1},
+	} {
+		copier := &copier{
+			dest:           taggingDest,
+			reportWriter:   io.Discard,
+			blobInfoCache:  internalblobinfocache.FromBlobInfoCache(memory.New()),
+			timings:        newTimingReport(nil),
+			statistics:     &statisticsReport{},
+			sigstoreSigner: signer,
+		}
+		err = copier.createSigstoreSignature(context.Background(), manifestBlob, manifestDigest, identity)
+		require.NoError(t, err)
+		err = copier.putSigstoreSignatures(context.Background(), taggingDest, c.removeExisting)
+		require.NoError(t, err)
+
+		m, err := manifest.OCI1FromManifest(taggingDest.tags[tag])
+		require.NoError(t, err)
+		require.Len(t, m.Layers, c.expectedLayers)
+		for _, l := range m.Layers {
+			assert.Equal(t, sigstore.SignatureMIMEType, l.MediaType)
+			payload, err := os.ReadFile(filepath.Join(destDir, "blobs", "sha256", l.Digest.Hex()))
+			require.NoError(t, err)
+			assert.Contains(t, string(payload), manifestDigest.String())
+			sig, err := base64.StdEncoding.DecodeString(l.Annotations[sigstore.SignatureAnnotationKey])
+			require.NoError(t, err)
+			payloadDigest := sha256.Sum256(payload)
+			assert.True(t, ecdsa.VerifyASN1(&key.PublicKey, payloadDigest[:], sig))
+		}
+		_, err = os.Stat(filepath.Join(destDir, "blobs", "sha256", m.Config.Digest.Hex()))
+		assert.NoError(t, err)
+		_, err = os.Stat(filepath.Join(destDir, "blobs", "sha256", digest.FromBytes(taggingDest.tags[tag]).Hex()))
+		assert.NoError(t, err)
+	}
+
+	// Sigstore signatures require a destination which supports tags.
+	srcRef, _, _ := newTestOCIImage(t)
+	policyContext, err := signature.NewPolicyContext(&signature.Policy{Default: []signature.PolicyRequirement{signature.NewPRInsecureAcceptAnything()}})
+	require.NoError(t, err)
+	defer func() { _ = policyContext.Destroy() }()
+	_, err = Image(context.Background(), policyContext, destRef, srcRef, &Options{SignBySigstorePrivateKeyFile: keyFile})
+	assert.ErrorContains(t, err, "does not support storing sigstore signatures")
+	_, err = Image(context.Background(), policyContext, destRef, srcRef, &Options{SignBySigstoreKMSKey: testKMSKey{key: key}})
+	assert.ErrorContains(t, err, "does not support storing sigstore signatures")
+	// Timestamps require a sigstore signer.
+	_, err = Image(context.Background(), policyContext, destRef, srcRef,
+		&Options{SignSigstoreTimestampAuthority: &sigstore.TimestampAuthorityOptions{URL: "https://tsa.example.com"}})
+	assert.ErrorContains(t, err, "SignSigstoreTimestampAuthority requires")
+}
+
+func TestCreateSigstoreSignatureWithTimestamp(t *testing.T) {
+	authority, err := tsa.New(time.Now(), x509.ExtKeyUsageTimeStamping)
+	require.NoError(t, err)
+	server := httptest.NewServer(authority)
+	defer server.Close()
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	require.NoError(t, err)
+	signer, err := sigstore.NewSignerFromKMSKey(context.Background(), testKMSKey{key: key})
+	require.NoError(t, err)
+	err = signer.UseTimestampAuthority(sigstore.TimestampAuthorityOptions{URL: server.URL})
+	require.NoError(t, err)
+	identity, err := reference.ParseNamed("example.com/repo:tag")
+	require.NoError(t, err)
+
+	manifestBlob := []byte(`{"schemaVersion":2}`)
+	manifestDigest := digest.FromBytes(manifestBlob)
+	copier := &copier{sigstoreSigner: signer, reportWriter: io.Discard}
+	err = copier.createSigstoreSignature(context.Background(), manifestBlob, manifestDigest, identity)
+	require.NoError(t, err)
+	require.Len(t, copier.sigstoreSignatures, 1)
+	annotations := copier.sigstoreSignatures[0].annotations
+	sig, err := base64.StdEncoding.DecodeString(annotations[sigstore.SignatureAnnotationKey])
+	require.NoError(t, err)
+	var timestamp sigstore.RFC3161Timestamp
+	err = json.Unmarshal([]byte(annotations[sigstore.TimestampAnnotationKey]), &timestamp)
+	require.NoError(t, err)
+	_, err = sigstore.VerifyRFC3161Timestamp([]*x509.Certificate{authority.CACertificate}, timestamp.SignedRFC3161Timestamp, sig)
+	assert.NoError(t, err)
+}
+
+func TestCreateSigstoreSignatureUsingKMSKey(t *testing.T) {
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	require.NoError(t, err)
+	signer, err := sigstore.NewSignerFromKMSKey(context.Background(), testKMSKey{key: key})
+	require.NoError(t, err)
+	identity, err := reference.ParseNamed("example.com/repo:tag")
+	require.NoError(t, err)
+
+	manifestBlob := []byte(`{"schemaVersion":2}`)
+	manifestDigest := digest.FromBytes(manifestBlob)
+	copier := &copier{sigstoreSigner: signer, reportWriter: io.Discard}
+	err = copier.createSigstoreSignature(context.Background(), manifestBlob, manifestDigest, identity)
+	require.NoError(t, err)
+	require.Len(t, copier.sigstoreSignatures, 1)
+	sig, err := base64.StdEncoding.DecodeString(copier.sigstoreSignatures[0].annotations[sigstore.SignatureAnnotationKey])
+	require.NoError(t, err)
+	payloadDigest := sha256.Sum256(copier.sigstoreSignatures[0].payload)
+	assert.True(t, ecdsa.VerifyASN1(&key.PublicKey, payloadDigest[:], sig))
+}
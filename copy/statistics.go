@@ -0,0 +1,186 @@
+package copy
+
+import (
+	"context"
+	"io"
+	"sync"
+	"sync/atomic"
+
+	internalblobinfocache "github.com/containers/image/v5/internal/blobinfocache"
+	"github.com/containers/image/v5/types"
+	digest "github.com/opencontainers/go-digest"
+	imgspecv1 "github.com/opencontainers/image-spec/specs-go/v1"
+	"github.com/sirupsen/logrus"
+)
+
+// Statistics describes the data transferred by an image copy, e.g. to be exported as metrics.
+// Time spent in the phases of the copy is described separately, in Result.Timings.
+type Statistics struct {
+	// BytesDownloaded is the total size of blobs (layers and configs) read from the source.  Manifests and signatures
+	// are not included.
+	BytesDownloaded int64
+	// BytesUploaded is the total size of blobs written to the destination.
+	BytesUploaded int64
+	// BytesReused is the total size of blobs which were not written, because the destination already contained them,
+	// or could reuse them, e.g. using a cross-repository mount, or a differently compressed variant recorded in the
+	// blob info cache.
+	BytesReused int64
+	// Layers describes the layers which were copied or reused, including layers of referrers copied because of
+	// Options.CopyReferrers, in completion order.
+	Layers []LayerStatistics
+	// Images describes the copied images (a single one, or instances of a manifest list), in the order they were written.
+	Images []ImageStatistics
+}
+
+// LayerStatistics describes a single layer copied by an image copy.
+type LayerStatistics struct {
+	// SourceDigest and SourceSize describe the layer in the source; SourceSize is -1 if unknown.
+	SourceDigest digest.Digest
+	SourceSize   int64
+	// DestinationDigest and DestinationSize describe the layer written to, or reused in, the destination.
+	DestinationDigest digest.Digest
+	DestinationSize   int64
+	// UncompressedSize is the size of the uncompressed layer contents, or -1 if unknown (e.g. if a compressed layer
+	// was neither decompressed nor recompressed by the copy).
+	UncompressedSize int64
+	// SourceCompression and DestinationCompression are the names of the compression algorithms used by the layer
+	// in the source and in the destination (e.g. "gzip"), "uncompressed", or "unknown".
+	SourceCompression      string
+	DestinationCompression string
+	// Reused is true if the layer was not written, because the destination already contained it, or could reuse it.
+	Reused bool
+}
+
+// CompressionRatio returns the ratio of the size of the layer in the destination to the size of the uncompressed
+// layer contents, or 0 if either is unknown.
+func (s LayerStatistics) CompressionRatio() float64 {
+	if s.UncompressedSize <= 0 || s.DestinationSize < 0 {
+		return 0
+	}
+	return float64(s.DestinationSize) / float64(s.UncompressedSize)
+}
+
+// ImageStatistics describes a single image (not a manifest list) copied by an image copy.
+type ImageStatistics struct {
+	// Platform is the platform of the image, as described by its config, or nil if unknown.
+	Platform *imgspecv1.Platform
+	// SourceDigest and SourceMIMEType describe the manifest of the image in the source.
+	SourceDigest   digest.Digest
+	SourceMIMEType string
+	// DestinationDigest and DestinationMIMEType describe the manifest written to the destination; they differ from
+	// SourceDigest and SourceMIMEType if the image was modified or converted.
+	DestinationDigest   digest.Digest
+	DestinationMIMEType string
+}
+
+// statisticsReport collects the Statistics of a single copy operation.
+// It is safe for concurrent use.
+type statisticsReport struct {
+	mutex      sync.Mutex // Protects statistics
+	statistics Statistics
+}
+
+// recordBlob records a blob which was copied, and the statistics of the blob if it is a layer (layer != nil).
+func (r *statisticsReport) recordBlob(downloaded, uploaded int64, layer *LayerStatistics) {
+	r.mutex.Lock()
+	defer r.mutex.Unlock()
+	r.statistics.BytesDownloaded += downloaded
+	r.statistics.BytesUploaded += uploaded
+	if layer != nil {
+		r.statistics.Layers = append(r.statistics.Layers, *layer)
+	}
+}
+
+// recordReusedBlob records a blob of size (or -1 if unknown) which was reused, and the statistics of the blob
+// if it is a layer (layer != nil).
+func (r *statisticsReport) recordReusedBlob(size int64, layer *LayerStatistics) {
+	r.mutex.Lock()
+	defer r.mutex.Unlock()
+	if size > 0 {
+		r.statistics.BytesReused += size
+	}
+	if layer != nil {
+		r.statistics.Layers = append(r.statistics.Layers, *layer)
+	}
+}
+
+// recordImage records a copied image.
+func (r *statisticsReport) recordImage(image ImageStatistics) {
+	r.mutex.Lock()
+	defer r.mutex.Unlock()
+	r.statistics.Images = append(r.statistics.Images, image)
+}
+
+// result returns the recorded statistics.
+func (r *statisticsReport) result() Statistics {
+	r.mutex.Lock()
+	defer r.mutex.Unlock()
+	res := r.statistics
+	res.Layers = append([]LayerStatistics(nil), r.statistics.Layers...)
+	res.Images = append([]ImageStatistics(nil), r.statistics.Images...)
+	return res
+}
+
+// imagePlatform returns the platform of src, as described by its config, or nil if it is not known.
+func imagePlatform(ctx context.Context, src types.Image) *imgspecv1.Platform {
+	info, err := src.Inspect(ctx)
+	if err != nil {
+		logrus.Debugf("Error determining the platform of the image: %v", err)
+		return nil
+	}
+	if info.Architecture == "" && info.Os == "" {
+		return nil
+	}
+	return &imgspecv1.Platform{Architecture: info.Architecture, OS: info.Os, Variant: info.Variant}
+}
+
+// reusedLayerStatistics returns LayerStatistics for a layer with srcInfo, reused in the destination as destInfo.
+func reusedLayerStatistics(srcInfo, destInfo types.BlobInfo) *LayerStatistics {
+	s := &LayerStatistics{
+		SourceDigest:           srcInfo.Digest,
+		SourceSize:             srcInfo.Size,
+		DestinationDigest:      destInfo.Digest,
+		DestinationSize:        destInfo.Size,
+		UncompressedSize:       -1,
+		SourceCompression:      internalblobinfocache.UnknownCompression,
+		DestinationCompression: internalblobinfocache.UnknownCompression,
+		Reused:                 true,
+	}
+	if srcInfo.CompressionAlgorithm != nil {
+		s.SourceCompression = srcInfo.CompressionAlgorithm.Name()
+	}
+	if destInfo.CompressionAlgorithm != nil {
+		s.DestinationCompression = destInfo.CompressionAlgorithm.Name()
+	} else if destInfo.Digest == srcInfo.Digest {
+		s.DestinationCompression = s.SourceCompression
+	}
+	return s
+}
+
+// byteCounter counts the bytes read from readers returned by wrap.
+// It is safe for concurrent use, because some of the readers are consumed by other goroutines.
+type byteCounter struct {
+	bytes int64 // Accessed using sync/atomic
+}
+
+// wrap returns a reader which reads from source, and adds the number of bytes read to c.
+func (c *byteCounter) wrap(source io.Reader) io.Reader {
+	return &countingReader{source: source, counter: c}
+}
+
+// count returns the number of bytes read.
+func (c *byteCounter) count() int64 {
+	return atomic.LoadInt64(&c.bytes)
+}
+
+// countingReader is an io.Reader which counts bytes read from source.
+type countingReader struct {
+	source  io.Reader
+	counter *byteCounter
+}
+
+func (r *countingReader) Read(p []byte) (int, error) {
+	n, err := r.source.Read(p)
+	atomic.AddInt64(&r.counter.bytes, int64(n))
+	return n, err
+}
@@ -0,0 +1,110 @@
+package copy
+
+import (
+	"context"
+	"testing"
+
+	"github.com/containers/image/v5/manifest"
+	"github.com/containers/image/v5/oci/layout"
+	"github.com/containers/image/v5/pkg/blobinfocache/memory"
+	"github.com/containers/image/v5/signature"
+	digest "github.com/opencontainers/go-digest"
+	imgspecv1 "github.com/opencontainers/image-spec/specs-go/v1"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestCopyStatistics(t *testing.T) {
+	srcRef, configDigest, layerDigest := newTestOCIImage(t)
+	policyContext, err := signature.NewPolicyContext(&signature.Policy{Default: []signature.PolicyRequirement{signature.NewPRInsecureAcceptAnything()}})
+	require.NoError(t, err)
+	defer func() { _ = policyContext.Destroy() }()
+	src, err := srcRef.NewImageSource(context.Background(), nil)
+	require.NoError(t, err)
+	srcManifest, _, err := src.GetManifest(context.Background(), nil)
+	require.NoError(t, err)
+	require.NoError(t, src.Close())
+	srcManifestDigest, err := manifest.Digest(srcManifest)
+	require.NoError(t, err)
+	srcM, err := manifest.OCI1FromManifest(srcManifest)
+	require.NoError(t, err)
+	configSize, layerSize := srcM.Config.Size, srcM.Layers[0].Size
+
+	// The uncompressed layer is compressed when writing to an OCI layout
+	destRef, err := layout.NewReference(t.TempDir(), "dest")
+	require.NoError(t, err)
+	cache := memory.New()
+	res, err := ImageWithResult(context.Background(), policyContext, destRef, srcRef, &Options{BlobInfoCache: cache})
+	require.NoError(t, err)
+	m, err := manifest.OCI1FromManifest(res.Manifest)
+	require.NoError(t, err)
+	destManifestDigest, err := manifest.Digest(res.Manifest)
+	require.NoError(t, err)
+	stats := res.Statistics
+	assert.Equal(t, configSize+layerSize, stats.BytesDownloaded)
+	assert.Equal(t, configSize+m.Layers[0].Size, stats.BytesUploaded)
+	assert.Equal(t, int64(0), stats.BytesReused)
+	assert.Equal(t, []LayerStatistics{{
+		SourceDigest:           layerDigest,
+		SourceSize:             layerSize,
+		DestinationDigest:      m.Layers[0].Digest,
+		DestinationSize:        m.Layers[0].Size,
+		UncompressedSize:       layerSize,
+		SourceCompression:      "uncompressed",
+		DestinationCompression: "gzip",
+	}}, stats.Layers)
+	assert.Equal(t, float64(m.Layers[0].Size)/float64(layerSize), stats.Layers[0].CompressionRatio())
+	assert.Equal(t, []ImageStatistics{{
+		Platform:            &imgspecv1.Platform{Architecture: "amd64", OS: "linux"},
+		SourceDigest:        srcManifestDigest,
+		SourceMIMEType:      imgspecv1.MediaTypeImageManifest,
+		DestinationDigest:   destManifestDigest,
+		DestinationMIMEType: imgspecv1.MediaTypeImageManifest,
+	}}, stats.Images)
+	assert.Equal(t, configDigest, m.Config.Digest)
+
+	// The compressed layer is copied without modification, and reused by a second copy
+	dest2Ref, err := layout.NewReference(t.TempDir(), "dest")
+	require.NoError(t, err)
+	res, err = ImageWithResult(context.Background(), policyContext, dest2Ref, destRef, &Options{BlobInfoCache: cache})
+	require.NoError(t, err)
+	stats = res.Statistics
+	assert.Equal(t, configSize+m.Layers[0].Size, stats.BytesDownloaded)
+	assert.Equal(t, configSize+m.Layers[0].Size, stats.BytesUploaded)
+	assert.Equal(t, []LayerStatistics{{
+		SourceDigest:           m.Layers[0].Digest,
+		SourceSize:             m.Layers[0].Size,
+		DestinationDigest:      m.Layers[0].Digest,
+		DestinationSize:        m.Layers[0].Size,
+		UncompressedSize:       -1,
+		SourceCompression:      "gzip",
+		DestinationCompression: "gzip",
+	}}, stats.Layers)
+	assert.Equal(t, float64(0), stats.Layers[0].CompressionRatio())
+
+	res, err = ImageWithResult(context.Background(), policyContext, dest2Ref, destRef, &Options{BlobInfoCache: cache})
+	require.NoError(t, err)
+	stats = res.Statistics
+	assert.Equal(t, configSize, stats.BytesDownloaded)
+	assert.Equal(t, configSize, stats.BytesUploaded)
+	assert.Equal(t, m.Layers[0].Size, stats.BytesReused)
+	require.Len(t, stats.Layers, 1)
+	assert.True(t, stats.Layers[0].Reused)
+	assert.Equal(t, m.Layers[0].Digest, stats.Layers[0].DestinationDigest)
+}
+
+func TestLayerStatisticsCompressionRatio(t *testing.T) {
+	for _, c := range []struct {
+		destinationSize, uncompressedSize int64
+		expected                          float64
+	}{
+		{50, 100, 0.5},
+		{100, 100, 1},
+		{50, -1, 0},
+		{50, 0, 0},
+		{-1, 100, 0},
+	} {
+		s := LayerStatistics{SourceDigest: digest.FromString("layer"), DestinationSize: c.destinationSize, UncompressedSize: c.uncompressedSize}
+		assert.Equal(t, c.expected, s.CompressionRatio(), "%d/%d", c.destinationSize, c.uncompressedSize)
+	}
+}
@@ -0,0 +1,75 @@
+package copy
+
+import (
+	"github.com/containers/image/v5/manifest"
+	"github.com/opencontainers/go-digest"
+	imgspecv1 "github.com/opencontainers/image-spec/specs-go/v1"
+)
+
+// newSubject returns the descriptor which should replace subject (the image-spec v1.1 subject field of a copied manifest,
+// or nil), or nil if subject should not be changed.
+func (c *copier) newSubject(subject *imgspecv1.Descriptor) *imgspecv1.Descriptor {
+	if !c.rewriteSubjects || subject == nil {
+		return nil
+	}
+	replacement, ok := c.subjectRewrites[subject.Digest]
+	if !ok || replacement.Digest == subject.Digest {
+		return nil
+	}
+	res := *subject
+	res.MediaType = replacement.MediaType
+	res.Digest = replacement.Digest
+	res.Size = replacement.Size
+	return &res
+}
+
+// manifestSubjectRewrite returns the descriptor which should replace the subject of man with mimeType, or nil if
+// the subject should not be changed (including the case where man is not an OCI manifest).
+func (c *copier) manifestSubjectRewrite(man []byte, mimeType string) (*imgspecv1.Descriptor, error) {
+	if !c.rewriteSubjects || manifest.NormalizedMIMEType(mimeType) != imgspecv1.MediaTypeImageManifest {
+		return nil, nil
+	}
+	m, err := manifest.OCI1FromManifest(man)
+	if err != nil {
+		return nil, err
+	}
+	return c.newSubject(m.Subject), nil
+}
+
+// rewriteManifestSubject returns the OCI manifest man with its subject replaced by newSubject.
+func rewriteManifestSubject(man []byte, newSubject *imgspecv1.Descriptor) ([]byte, error) {
+	m, err := manifest.OCI1FromManifest(man)
+	if err != nil {
+		return nil, err
+	}
+	m.Subject = newSubject
+	return m.Serialize()
+}
+
+// rewriteListSubject updates the subject of list, if necessary, and returns true if it was changed.
+func (c *copier) rewriteListSubject(list manifest.List) bool {
+	index, ok := list.(*manifest.OCI1Index)
+	if !ok {
+		return false
+	}
+	newSubject := c.newSubject(index.Subject)
+	if newSubject == nil {
+		return false
+	}
+	index.Subject = newSubject
+	return true
+}
+
+// recordConvertedManifest records that a manifest with srcDigest was written as written, with a different digest.
+func (c *copier) recordConvertedManifest(srcDigest digest.Digest, written imgspecv1.Descriptor) {
+	if srcDigest == written.Digest {
+		return
+	}
+	if c.convertedManifests == nil {
+		c.convertedManifests = map[digest.Digest]imgspecv1.Descriptor{}
+	}
+	c.convertedManifests[srcDigest] = written
+	if c.rewriteSubjects {
+		c.subjectRewrites[srcDigest] = written
+	}
+}
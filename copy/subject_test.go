@@ -0,0 +1,77 @@
+package copy
+
+import (
+	"testing"
+
+	"github.com/containers/image/v5/manifest"
+	"github.com/opencontainers/go-digest"
+	imgspecv1 "github.com/opencontainers/image-spec/specs-go/v1"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestCopierSubjectRewrites(t *testing.T) {
+	original := digest.FromString("original")
+	converted := imgspecv1.Descriptor{MediaType: imgspecv1.MediaTypeImageManifest, Digest: digest.FromString("converted"), Size: 10}
+	subject := &imgspecv1.Descriptor{
+		MediaType:   manifest.DockerV2Schema2MediaType,
+		Digest:      original,
+		Size:        20,
+		Annotations: map[string]string{"a": "b"},
+	}
+
+	// Rewriting disabled
+	c := &copier{subjectRewrites: map[digest.Digest]imgspecv1.Descriptor{}}
+	c.recordConvertedManifest(original, converted)
+	assert.Equal(t, map[digest.Digest]imgspecv1.Descriptor{original: converted}, c.convertedManifests)
+	assert.Empty(t, c.subjectRewrites)
+	assert.Nil(t, c.newSubject(subject))
+
+	// Rewriting enabled
+	c = &copier{rewriteSubjects: true, subjectRewrites: map[digest.Digest]imgspecv1.Descriptor{}}
+	c.recordConvertedManifest(original, imgspecv1.Descriptor{Digest: original})
+	assert.Nil(t, c.convertedManifests)
+	assert.Nil(t, c.newSubject(subject))
+	c.recordConvertedManifest(original, converted)
+	assert.Nil(t, c.newSubject(nil))
+	assert.Nil(t, c.newSubject(&imgspecv1.Descriptor{Digest: digest.FromString("unrelated")}))
+	assert.Equal(t, &imgspecv1.Descriptor{
+		MediaType:   converted.MediaType,
+		Digest:      converted.Digest,
+		Size:        converted.Size,
+		Annotations: map[string]string{"a": "b"},
+	}, c.newSubject(subject))
+	assert.Equal(t, original, subject.Digest) // The input is not modified
+
+	// Manifests
+	m := manifest.OCI1FromComponents(imgspecv1.Descriptor{MediaType: imgspecv1.MediaTypeImageConfig}, nil)
+	m.Subject = subject
+	man, err := m.Serialize()
+	require.NoError(t, err)
+	newSubject, err := c.manifestSubjectRewrite(man, imgspecv1.MediaTypeImageManifest)
+	require.NoError(t, err)
+	require.NotNil(t, newSubject)
+	assert.Equal(t, converted.Digest, newSubject.Digest)
+	newSubject, err = c.manifestSubjectRewrite(man, manifest.DockerV2Schema2MediaType)
+	require.NoError(t, err)
+	assert.Nil(t, newSubject)
+	_, err = c.manifestSubjectRewrite([]byte("this is invalid"), imgspecv1.MediaTypeImageManifest)
+	assert.Error(t, err)
+
+	rewritten, err := rewriteManifestSubject(man, c.newSubject(subject))
+	require.NoError(t, err)
+	m2, err := manifest.OCI1FromManifest(rewritten)
+	require.NoError(t, err)
+	require.NotNil(t, m2.Subject)
+	assert.Equal(t, converted.Digest, m2.Subject.Digest)
+	assert.Equal(t, m.Config, m2.Config)
+
+	// Lists
+	index := manifest.OCI1IndexFromComponents(nil, nil)
+	assert.False(t, c.rewriteListSubject(index))
+	index.Subject = subject
+	assert.True(t, c.rewriteListSubject(index))
+	assert.Equal(t, converted.Digest, index.Subject.Digest)
+	assert.False(t, c.rewriteListSubject(index))
+	assert.False(t, c.rewriteListSubject(manifest.Schema2ListFromComponents(nil)))
+}
@@ -0,0 +1,57 @@
+package copy
+
+import (
+	"context"
+	"fmt"
+	"strings"
+	"time"
+
+	"github.com/containers/image/v5/internal/private"
+)
+
+// TagResult is the outcome of writing a copied manifest under one of Options.AdditionalTags.
+type TagResult struct {
+	Tag string
+	Err error // nil if the manifest was written successfully
+}
+
+// AdditionalTagsError is returned by ImageWithResult if the image was copied, but writing it under some of
+// Options.AdditionalTags failed.
+type AdditionalTagsError struct {
+	Failed []TagResult // Only the failed tags
+}
+
+func (e *AdditionalTagsError) Error() string {
+	failures := make([]string, 0, len(e.Failed))
+	for _, r := range e.Failed {
+		failures = append(failures, fmt.Sprintf("%s: %v", r.Tag, r.Err))
+	}
+	return fmt.Sprintf("writing the image under additional tags failed: %s", strings.Join(failures, "; "))
+}
+
+// additionalTagsError returns an *AdditionalTagsError describing the failures in results, or nil if there are none.
+func additionalTagsError(results []TagResult) error {
+	var failed []TagResult
+	for _, r := range results {
+		if r.Err != nil {
+			failed = append(failed, r)
+		}
+	}
+	if len(failed) == 0 {
+		return nil
+	}
+	return &AdditionalTagsError{Failed: failed}
+}
+
+// putAdditionalTags writes man, which has already been written to c.dest, under each of tags,
+// and returns the outcome for each tag.
+func (c *copier) putAdditionalTags(ctx context.Context, tagger private.ManifestTagger, man []byte, tags []string) []TagResult {
+	res := make([]TagResult, 0, len(tags))
+	for _, tag := range tags {
+		start := time.Now()
+		err := tagger.PutManifestTag(ctx, man, tag)
+		c.timings.recordPhaseSince(PhaseManifestPut, start)
+		res = append(res, TagResult{Tag: tag, Err: err})
+	}
+	return res
+}
@@ -0,0 +1,45 @@
+package copy
+
+import (
+	"context"
+	"errors"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// testTagger is a private.ManifestTagger recording the written tags.
+type testTagger struct {
+	tags map[string][]byte
+}
+
+func (t *testTagger) PutManifestTag(ctx context.Context, manifest []byte, tag string) error {
+	if tag == "fail" {
+		return errors.New("failed")
+	}
+	t.tags[tag] = manifest
+	return nil
+}
+
+func TestPutAdditionalTags(t *testing.T) {
+	c := &copier{timings: newTimingReport(nil)}
+	tagger := &testTagger{tags: map[string][]byte{}}
+
+	res := c.putAdditionalTags(context.Background(), tagger, []byte("manifest"), []string{"a", "fail", "b"})
+	require.Len(t, res, 3)
+	assert.Equal(t, TagResult{Tag: "a"}, res[0])
+	assert.Equal(t, "fail", res[1].Tag)
+	assert.Error(t, res[1].Err)
+	assert.Equal(t, TagResult{Tag: "b"}, res[2])
+	assert.Equal(t, map[string][]byte{"a": []byte("manifest"), "b": []byte("manifest")}, tagger.tags)
+
+	err := additionalTagsError(res)
+	var tagsErr *AdditionalTagsError
+	require.True(t, errors.As(err, &tagsErr))
+	assert.Equal(t, []TagResult{res[1]}, tagsErr.Failed)
+	assert.Contains(t, err.Error(), "fail: failed")
+
+	assert.NoError(t, additionalTagsError([]TagResult{res[0], res[2]}))
+	assert.NoError(t, additionalTagsError(nil))
+}
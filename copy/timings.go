@@ -0,0 +1,204 @@
+package copy
+
+import (
+	"io"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/opencontainers/go-digest"
+)
+
+// Phase identifies a phase of copy.Image whose duration is measured.
+type Phase string
+
+const (
+	// PhaseSourceOpen is creating the image source.  For some transports (e.g. docker://) this includes
+	// reading the manifest, and authentication.
+	PhaseSourceOpen Phase = "source-open"
+	// PhaseDestinationOpen is creating the image destination.
+	PhaseDestinationOpen Phase = "destination-open"
+	// PhaseAuthentication is looking up credentials and authenticating to the source and destination, as reported
+	// by transports which support it.  This overlaps with other phases, e.g. PhaseSourceOpen or PhaseBlobUpload.
+	PhaseAuthentication Phase = "authentication"
+	// PhaseManifestFetch is reading the manifests (and manifest lists) from the source.
+	PhaseManifestFetch Phase = "manifest-fetch"
+	// PhasePolicyCheck is evaluating the signature policy for the source images.
+	PhasePolicyCheck Phase = "policy-check"
+	// PhaseBlobDownload is opening and reading blobs (layers and configs) from the source.
+	PhaseBlobDownload Phase = "blob-download"
+	// PhaseBlobCompression is processing of the blob contents between the source and the destination:
+	// mostly (de/re)compression, but also e.g. encryption and digest verification.
+	PhaseBlobCompression Phase = "blob-compression"
+	// PhaseBlobUpload is writing blobs to the destination.
+	PhaseBlobUpload Phase = "blob-upload"
+	// PhaseManifestPut is writing manifests (and manifest lists) to the destination.
+	PhaseManifestPut Phase = "manifest-put"
+	// PhaseSigning is creating signatures.
+	PhaseSigning Phase = "signing"
+	// PhaseSignaturePut is writing signatures to the destination.
+	PhaseSignaturePut Phase = "signature-put"
+)
+
+// BlobTiming describes the time spent copying a single blob.
+// Downloading, processing and uploading happen concurrently, as a single stream; the time the stream
+// spent in each stage is attributed to that stage, so the values are approximations.
+type BlobTiming struct {
+	// Digest is the digest of the blob in the source.
+	Digest digest.Digest
+	// Download is the time spent opening and reading the blob from the source.
+	Download time.Duration
+	// Compression is the time spent processing the blob contents, see PhaseBlobCompression.
+	Compression time.Duration
+	// Upload is the time spent writing the blob to the destination, not counting time waiting for the blob contents.
+	Upload time.Duration
+}
+
+// Timings describes the time spent in the phases of an image copy.
+type Timings struct {
+	// Phases contains the total duration of each phase which occurred during the copy.  Blobs are copied
+	// concurrently, and some phases overlap, so the total of all phases may exceed the duration of the copy.
+	Phases map[Phase]time.Duration
+	// Blobs lists the timings of blobs which were copied (not those which were reused at the destination), in completion order.
+	Blobs []BlobTiming
+}
+
+// Metrics receives timings of copy operations, e.g. to export them to a monitoring system.
+// Implementations must be safe for concurrent use, and should return quickly.
+type Metrics interface {
+	// ObservePhase is called after each occurrence of phase, other than the per-blob phases, with its duration.
+	ObservePhase(phase Phase, duration time.Duration)
+	// ObserveBlob is called after each blob has been copied.
+	ObserveBlob(timing BlobTiming)
+}
+
+// timingReport collects the timings of a single copy operation.
+// It is safe for concurrent use.
+type timingReport struct {
+	metrics Metrics // Or nil
+
+	mutex  sync.Mutex // Protects phases and blobs
+	phases map[Phase]time.Duration
+	blobs  []BlobTiming
+}
+
+// newTimingReport returns a timingReport which also reports timings to metrics, if not nil.
+func newTimingReport(metrics Metrics) *timingReport {
+	return &timingReport{
+		metrics: metrics,
+		phases:  map[Phase]time.Duration{},
+	}
+}
+
+// recordPhase records an occurrence of phase which took duration.
+func (r *timingReport) recordPhase(phase Phase, duration time.Duration) {
+	r.mutex.Lock()
+	r.phases[phase] += duration
+	r.mutex.Unlock()
+	if r.metrics != nil {
+		r.metrics.ObservePhase(phase, duration)
+	}
+}
+
+// recordPhaseSince records an occurrence of phase which started at start and has just ended.
+func (r *timingReport) recordPhaseSince(phase Phase, start time.Time) {
+	r.recordPhase(phase, time.Since(start))
+}
+
+// recordBlob records the timing of a copied blob.
+func (r *timingReport) recordBlob(timing BlobTiming) {
+	r.mutex.Lock()
+	r.blobs = append(r.blobs, timing)
+	r.phases[PhaseBlobDownload] += timing.Download
+	r.phases[PhaseBlobCompression] += timing.Compression
+	r.phases[PhaseBlobUpload] += timing.Upload
+	r.mutex.Unlock()
+	if r.metrics != nil {
+		r.metrics.ObserveBlob(timing)
+	}
+}
+
+// timings returns the recorded timings.
+func (r *timingReport) timings() Timings {
+	r.mutex.Lock()
+	defer r.mutex.Unlock()
+	res := Timings{Phases: make(map[Phase]time.Duration, len(r.phases))}
+	for phase, duration := range r.phases {
+		res.Phases[phase] = duration
+	}
+	if len(r.blobs) != 0 {
+		res.Blobs = make([]BlobTiming, len(r.blobs))
+		copy(res.Blobs, r.blobs)
+	}
+	return res
+}
+
+// blobTimer measures the time spent in the stages of copying a single blob, see BlobTiming.
+type blobTimer struct {
+	openDuration time.Duration // Time spent opening the source stream
+	sourceReads  readTimer     // Reads from the source stream
+	inputReads   readTimer     // Reads by the destination from its input, which include sourceReads
+	putStart     time.Time
+	putDuration  time.Duration
+}
+
+// startPut records that writing to the destination has started.
+func (t *blobTimer) startPut() {
+	t.putStart = time.Now()
+}
+
+// endPut records that writing to the destination has ended.
+func (t *blobTimer) endPut() {
+	t.putDuration = time.Since(t.putStart)
+}
+
+// timing returns a BlobTiming for a blob with digest.
+func (t *blobTimer) timing(digest digest.Digest) BlobTiming {
+	sourceReads := t.sourceReads.duration()
+	inputReads := t.inputReads.duration()
+	// The source may have been read after the destination has finished (see getOriginalLayerCopyWriter),
+	// and with concurrent stages the differences might not be accurate; so, clamp the values to 0.
+	compression := inputReads - sourceReads
+	if compression < 0 {
+		compression = 0
+	}
+	upload := t.putDuration - inputReads
+	if upload < 0 {
+		upload = 0
+	}
+	return BlobTiming{
+		Digest:      digest,
+		Download:    t.openDuration + sourceReads,
+		Compression: compression,
+		Upload:      upload,
+	}
+}
+
+// readTimer measures the total time spent in Read calls of readers returned by wrap.
+// It is safe for concurrent use, because some of the readers are consumed by other goroutines.
+type readTimer struct {
+	nanoseconds int64 // Accessed using sync/atomic
+}
+
+// wrap returns a reader which reads from source, and adds the time spent in its Read calls to t.
+func (t *readTimer) wrap(source io.Reader) io.Reader {
+	return &timedReader{source: source, timer: t}
+}
+
+// duration returns the total time spent in Read calls.
+func (t *readTimer) duration() time.Duration {
+	return time.Duration(atomic.LoadInt64(&t.nanoseconds))
+}
+
+// timedReader is an io.Reader which measures time spent in Read calls of source.
+type timedReader struct {
+	source io.Reader
+	timer  *readTimer
+}
+
+func (r *timedReader) Read(p []byte) (int, error) {
+	start := time.Now()
+	n, err := r.source.Read(p)
+	atomic.AddInt64(&r.timer.nanoseconds, int64(time.Since(start)))
+	return n, err
+}
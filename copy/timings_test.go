@@ -0,0 +1,117 @@
+package copy
+
+import (
+	"bytes"
+	"io"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/opencontainers/go-digest"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// testMetrics is a Metrics implementation recording all observations.
+type testMetrics struct {
+	mutex  sync.Mutex
+	phases map[Phase]int
+	blobs  []BlobTiming
+}
+
+func (m *testMetrics) ObservePhase(phase Phase, duration time.Duration) {
+	m.mutex.Lock()
+	defer m.mutex.Unlock()
+	m.phases[phase]++
+}
+
+func (m *testMetrics) ObserveBlob(timing BlobTiming) {
+	m.mutex.Lock()
+	defer m.mutex.Unlock()
+	m.blobs = append(m.blobs, timing)
+}
+
+func TestTimingReport(t *testing.T) {
+	// Nothing recorded
+	r := newTimingReport(nil)
+	assert.Equal(t, Timings{Phases: map[Phase]time.Duration{}}, r.timings())
+
+	// Without metrics
+	r.recordPhase(PhasePolicyCheck, time.Second)
+	r.recordPhase(PhasePolicyCheck, 2*time.Second)
+	blob := BlobTiming{Digest: digest.FromString("blob"), Download: 3 * time.Second, Compression: time.Second, Upload: 2 * time.Second}
+	r.recordBlob(blob)
+	assert.Equal(t, Timings{
+		Phases: map[Phase]time.Duration{
+			PhasePolicyCheck:     3 * time.Second,
+			PhaseBlobDownload:    3 * time.Second,
+			PhaseBlobCompression: time.Second,
+			PhaseBlobUpload:      2 * time.Second,
+		},
+		Blobs: []BlobTiming{blob},
+	}, r.timings())
+
+	// With metrics, from concurrent goroutines
+	m := &testMetrics{phases: map[Phase]int{}}
+	r = newTimingReport(m)
+	var wg sync.WaitGroup
+	for i := 0; i < 10; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			r.recordPhaseSince(PhaseManifestPut, time.Now())
+			r.recordBlob(blob)
+		}()
+	}
+	wg.Wait()
+	res := r.timings()
+	assert.Len(t, res.Blobs, 10)
+	assert.Equal(t, 30*time.Second, res.Phases[PhaseBlobDownload])
+	assert.Equal(t, map[Phase]int{PhaseManifestPut: 10}, m.phases)
+	assert.Equal(t, res.Blobs, m.blobs)
+
+	// The returned value is not affected by later records
+	r.recordPhase(PhaseSigning, time.Second)
+	r.recordBlob(blob)
+	assert.Len(t, res.Blobs, 10)
+	assert.NotContains(t, res.Phases, PhaseSigning)
+}
+
+// sleepingReader is an io.Reader which sleeps for delay in every Read call.
+type sleepingReader struct {
+	source io.Reader
+	delay  time.Duration
+}
+
+func (r sleepingReader) Read(p []byte) (int, error) {
+	time.Sleep(r.delay)
+	return r.source.Read(p)
+}
+
+func TestBlobTimer(t *testing.T) {
+	const delay = 10 * time.Millisecond
+	d := digest.FromString("blob")
+
+	timer := &blobTimer{openDuration: time.Second}
+	source := timer.sourceReads.wrap(sleepingReader{source: bytes.NewReader([]byte("contents")), delay: delay})
+	input := timer.inputReads.wrap(sleepingReader{source: source, delay: delay})
+	timer.startPut()
+	_, err := io.ReadAll(input)
+	require.NoError(t, err)
+	time.Sleep(delay)
+	timer.endPut()
+	res := timer.timing(d)
+	assert.Equal(t, d, res.Digest)
+	// Two reads: one returning data, one returning io.EOF.
+	assert.GreaterOrEqual(t, res.Download, time.Second+2*delay)
+	assert.GreaterOrEqual(t, res.Compression, 2*delay)
+	assert.GreaterOrEqual(t, res.Upload, delay)
+
+	// Inconsistent measurements are clamped
+	timer = &blobTimer{}
+	_, err = io.ReadAll(timer.sourceReads.wrap(sleepingReader{source: bytes.NewReader([]byte("contents")), delay: delay}))
+	require.NoError(t, err)
+	res = timer.timing(d)
+	assert.Equal(t, time.Duration(0), res.Compression)
+	assert.Equal(t, time.Duration(0), res.Upload)
+}
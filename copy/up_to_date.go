@@ -0,0 +1,248 @@
+package copy
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"strings"
+
+	"github.com/containers/image/v5/image"
+	"github.com/containers/image/v5/manifest"
+	"github.com/containers/image/v5/signature"
+	"github.com/containers/image/v5/transports"
+	"github.com/containers/image/v5/types"
+	digest "github.com/opencontainers/go-digest"
+	imgspecv1 "github.com/opencontainers/image-spec/specs-go/v1"
+	"github.com/pkg/errors"
+	"github.com/sirupsen/logrus"
+)
+
+// unpredictableCopyReason returns a human-readable reason why the result of a copy with options can't be predicted
+// without actually copying the image, or "" if it may be predictable.
+func unpredictableCopyReason(options *Options) string {
+	switch {
+	case options.SignBy != "" || options.SignBySigstorePrivateKeyFile != "" || options.SignBySigstoreKeyless != nil || options.SignBySigstoreKMSKey != nil:
+		return "signing is requested"
+	case options.OciEncryptConfig != nil || options.OciDecryptConfig != nil:
+		return "encryption or decryption is requested"
+	case options.AnnotationChanges != nil:
+		return "annotation changes are requested"
+	case options.RewriteSubjects:
+		return "subject rewriting is requested"
+	case options.ModifyConfig != nil || options.ModifyManifest != nil:
+		return "image modifications are requested"
+	case options.Reproducible != nil && options.Reproducible.Timestamp != nil:
+		return "timestamp changes are requested"
+	case options.DownloadForeignLayers:
+		return "downloading foreign layers is requested"
+	case len(options.AdditionalTags) != 0:
+		return "additional tags are requested"
+	case options.ImageListSelection == CopySpecificImages:
+		return "only specific images of a list are copied"
+	case options.DestinationCtx != nil && options.DestinationCtx.CompressionFormat != nil:
+		return "a compression format is requested"
+	case options.LayerCompressionPolicy != nil:
+		return "a layer compression policy is set"
+	}
+	return ""
+}
+
+// layerCompressionIsPreserved returns true if the compression of a layer with mimeType, in a manifest of manifestMIMEType,
+// is known not to be changed by a copy to a destination which desires destCompression.
+func layerCompressionIsPreserved(manifestMIMEType, mimeType string, destCompression types.LayerCompression, strictMediaTypes bool) bool {
+	if destCompression == types.PreserveOriginal || strings.HasSuffix(mimeType, "+encrypted") {
+		return true
+	}
+	var compressed bool
+	switch mimeType {
+	case manifest.DockerV2Schema2LayerMediaType, manifest.DockerV2Schema2ForeignLayerMediaTypeGzip,
+		imgspecv1.MediaTypeImageLayerGzip, imgspecv1.MediaTypeImageLayerZstd,
+		imgspecv1.MediaTypeImageLayerNonDistributableGzip, imgspecv1.MediaTypeImageLayerNonDistributableZstd:
+		compressed = true
+	case manifest.DockerV2SchemaLayerMediaTypeUncompressed, manifest.DockerV2Schema2ForeignLayerMediaType,
+		imgspecv1.MediaTypeImageLayer, imgspecv1.MediaTypeImageLayerNonDistributable:
+		compressed = false
+	default:
+		// Layers of unknown types are only preserved byte-for-byte if requested; otherwise, their compression
+		// is detected from their contents.
+		return manifestMIMEType == imgspecv1.MediaTypeImageManifest && strictMediaTypes
+	}
+	return (destCompression == types.Compress) == compressed
+}
+
+// manifestIsPreserved returns "" if the manifest man with mimeType is known to be written unmodified by a copy with options,
+// or a human-readable reason why it might not be.
+func (c *copier) manifestIsPreserved(options *Options, man []byte, mimeType string) string {
+	mimeType = manifest.NormalizedMIMEType(mimeType)
+	if options.ForceManifestMIMEType != "" && manifest.NormalizedMIMEType(options.ForceManifestMIMEType) != mimeType {
+		return fmt.Sprintf("conversion to %s is requested", options.ForceManifestMIMEType)
+	}
+	if supported := c.dest.SupportedManifestMIMETypes(); len(supported) != 0 {
+		found := false
+		for _, t := range supported {
+			if manifest.NormalizedMIMEType(t) == mimeType {
+				found = true
+				break
+			}
+		}
+		if !found {
+			return fmt.Sprintf("the destination does not support %s", mimeType)
+		}
+	}
+	if manifest.MIMETypeIsMultiImage(mimeType) {
+		return ""
+	}
+	if mimeType == manifest.DockerV2Schema1MediaType || mimeType == manifest.DockerV2Schema1SignedMediaType {
+		return "schema1 manifests may be updated to refer to the destination"
+	}
+	m, err := manifest.FromBlob(man, mimeType)
+	if err != nil {
+		return fmt.Sprintf("the manifest can't be parsed: %v", err)
+	}
+	destCompression := c.dest.DesiredLayerCompression()
+	for _, layer := range m.LayerInfos() {
+		if !layerCompressionIsPreserved(mimeType, layer.MediaType, destCompression, options.StrictMediaTypePreservation) {
+			return fmt.Sprintf("the compression of layer %s may be changed", layer.Digest)
+		}
+	}
+	return ""
+}
+
+// signaturesAreEqual returns true if the destination contains the same signatures of instanceDigest as the source
+// image unparsedImage, if they would be copied.
+func signaturesAreEqual(ctx context.Context, options *Options, unparsedImage *image.UnparsedImage, destSource types.ImageSource, instanceDigest *digest.Digest) (bool, error) {
+	if options.RemoveSignatures {
+		return true, nil
+	}
+	srcSigs, err := unparsedImage.Signatures(ctx)
+	if err != nil {
+		return false, errors.Wrap(err, "reading signatures")
+	}
+	if len(srcSigs) == 0 {
+		return true, nil
+	}
+	destSigs, err := destSource.GetSignatures(ctx, instanceDigest)
+	if err != nil {
+		logrus.Debugf("Unable to read destination signatures: %v", err)
+		return false, nil
+	}
+	if len(srcSigs) != len(destSigs) {
+		return false, nil
+	}
+	for i := range srcSigs {
+		if !bytes.Equal(srcSigs[i], destSigs[i]) {
+			return false, nil
+		}
+	}
+	return true, nil
+}
+
+// destinationUpToDate returns the destination manifest, and true, if the destination already contains exactly
+// the image (or list) which would be written by a copy of unparsedToplevel with options, so that the copy can be skipped.
+// It returns false if this is not the case, or can't be predicted without copying the image.
+func (c *copier) destinationUpToDate(ctx context.Context, policyContext *signature.PolicyContext, options *Options, unparsedToplevel *image.UnparsedImage) ([]byte, bool, error) {
+	if reason := unpredictableCopyReason(options); reason != "" {
+		logrus.Debugf("Not checking whether the destination is up to date: %s", reason)
+		return nil, false, nil
+	}
+
+	srcManifest, srcManifestType, err := unparsedToplevel.Manifest(ctx)
+	if err != nil {
+		return nil, false, errors.Wrapf(err, "reading manifest for %s", transports.ImageName(c.rawSource.Reference()))
+	}
+	// The single images which would be copied, with their instance digests within the destination (nil for the top-level image).
+	type copiedImage struct {
+		unparsed     *image.UnparsedImage
+		destInstance *digest.Digest
+		manifest     []byte
+		manifestType string
+	}
+	var images []copiedImage
+	expectedManifest := srcManifest
+	if !manifest.MIMETypeIsMultiImage(manifest.NormalizedMIMEType(srcManifestType)) {
+		images = []copiedImage{{unparsed: unparsedToplevel, manifest: srcManifest, manifestType: srcManifestType}}
+	} else {
+		list, err := manifest.ListFromBlob(srcManifest, srcManifestType)
+		if err != nil {
+			return nil, false, errors.Wrapf(err, "parsing primary manifest as list for %s", transports.ImageName(c.rawSource.Reference()))
+		}
+		var instances []digest.Digest
+		if options.ImageListSelection == CopySystemImage {
+			instanceDigest, err := list.ChooseInstance(options.SourceCtx)
+			if err != nil {
+				return nil, false, errors.Wrapf(err, "choosing an image from manifest list %s", transports.ImageName(c.rawSource.Reference()))
+			}
+			instances = []digest.Digest{instanceDigest}
+		} else {
+			if reason := c.manifestIsPreserved(options, srcManifest, srcManifestType); reason != "" {
+				logrus.Debugf("Not checking whether the destination is up to date: %s", reason)
+				return nil, false, nil
+			}
+			instances = list.Instances()
+		}
+		for i := range instances {
+			instanceDigest := instances[i]
+			unparsed := image.UnparsedInstance(c.rawSource, &instanceDigest)
+			man, manType, err := unparsed.Manifest(ctx)
+			if err != nil {
+				return nil, false, errors.Wrapf(err, "reading manifest of instance %s", instanceDigest)
+			}
+			img := copiedImage{unparsed: unparsed, manifest: man, manifestType: manType}
+			if options.ImageListSelection == CopySystemImage {
+				expectedManifest = man
+			} else {
+				img.destInstance = &instanceDigest
+			}
+			images = append(images, img)
+		}
+	}
+	for _, img := range images {
+		if reason := c.manifestIsPreserved(options, img.manifest, img.manifestType); reason != "" {
+			logrus.Debugf("Not checking whether the destination is up to date: %s", reason)
+			return nil, false, nil
+		}
+	}
+
+	destSource, err := c.dest.Reference().NewImageSource(ctx, options.DestinationCtx)
+	if err != nil {
+		logrus.Debugf("Unable to create destination image %s source: %v", transports.ImageName(c.dest.Reference()), err)
+		return nil, false, nil
+	}
+	defer destSource.Close()
+	destManifest, _, err := destSource.GetManifest(ctx, nil)
+	if err != nil {
+		logrus.Debugf("Unable to get destination image %s manifest: %v", transports.ImageName(c.dest.Reference()), err)
+		return nil, false, nil
+	}
+	expectedDigest, err := manifest.Digest(expectedManifest)
+	if err != nil {
+		return nil, false, errors.Wrap(err, "calculating manifest digest")
+	}
+	destDigest, err := manifest.Digest(destManifest)
+	if err != nil {
+		return nil, false, errors.Wrap(err, "calculating manifest digest")
+	}
+	logrus.Debugf("Comparing expected and destination manifest digests: %v vs. %v", expectedDigest, destDigest)
+	if expectedDigest != destDigest {
+		return nil, false, nil
+	}
+
+	if len(images) > 1 || images[0].destInstance != nil { // A list is copied
+		equal, err := signaturesAreEqual(ctx, options, unparsedToplevel, destSource, nil)
+		if err != nil || !equal {
+			return nil, false, err
+		}
+	}
+	for _, img := range images {
+		// Don't report an image as up to date if copying it would be rejected.
+		allowed, err := policyContext.IsRunningImageAllowed(ctx, img.unparsed)
+		if !allowed || err != nil { // Be paranoid and fail if either return value indicates so.
+			return nil, false, errors.Wrap(err, "Source image rejected")
+		}
+		equal, err := signaturesAreEqual(ctx, options, img.unparsed, destSource, img.destInstance)
+		if err != nil || !equal {
+			return nil, false, err
+		}
+	}
+	return destManifest, true, nil
+}
@@ -0,0 +1,139 @@
+package copy
+
+import (
+	"context"
+	"encoding/json"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/containers/image/v5/image"
+	"github.com/containers/image/v5/internal/imagedestination"
+	"github.com/containers/image/v5/internal/imagesource"
+	"github.com/containers/image/v5/manifest"
+	"github.com/containers/image/v5/oci/layout"
+	"github.com/containers/image/v5/signature"
+	"github.com/containers/image/v5/signature/sigstore"
+	"github.com/containers/image/v5/types"
+	digest "github.com/opencontainers/go-digest"
+	imgspec "github.com/opencontainers/image-spec/specs-go"
+	imgspecv1 "github.com/opencontainers/image-spec/specs-go/v1"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestLayerCompressionIsPreserved(t *testing.T) {
+	for _, c := range []struct {
+		manifestType, layerType string
+		destCompression         types.LayerCompression
+		strict                  bool
+		expected                bool
+	}{
+		{manifest.DockerV2Schema2MediaType, manifest.DockerV2Schema2LayerMediaType, types.Compress, false, true},
+		{manifest.DockerV2Schema2MediaType, manifest.DockerV2Schema2LayerMediaType, types.Decompress, false, false},
+		{manifest.DockerV2Schema2MediaType, manifest.DockerV2SchemaLayerMediaTypeUncompressed, types.Compress, false, false},
+		{manifest.DockerV2Schema2MediaType, manifest.DockerV2SchemaLayerMediaTypeUncompressed, types.Decompress, false, true},
+		{imgspecv1.MediaTypeImageManifest, imgspecv1.MediaTypeImageLayerZstd, types.Compress, false, true},
+		{imgspecv1.MediaTypeImageManifest, imgspecv1.MediaTypeImageLayer, types.PreserveOriginal, false, true},
+		{imgspecv1.MediaTypeImageManifest, imgspecv1.MediaTypeImageLayerGzip + "+encrypted", types.Decompress, false, true},
+		{imgspecv1.MediaTypeImageManifest, "application/vnd.example.model", types.Compress, false, false},
+		{imgspecv1.MediaTypeImageManifest, "application/vnd.example.model", types.Compress, true, true},
+	} {
+		res := layerCompressionIsPreserved(c.manifestType, c.layerType, c.destCompression, c.strict)
+		assert.Equal(t, c.expected, res, "%s %s %v %v", c.manifestType, c.layerType, c.destCompression, c.strict)
+	}
+}
+
+// writeOCILayout writes an OCI layout to dir, containing only man as an image named name.
+func writeOCILayout(t *testing.T, dir, name string, man []byte) {
+	d := digest.FromBytes(man)
+	blobDir := filepath.Join(dir, "blobs", d.Algorithm().String())
+	require.NoError(t, os.MkdirAll(blobDir, 0755))
+	require.NoError(t, os.WriteFile(filepath.Join(blobDir, d.Hex()), man, 0644))
+	require.NoError(t, os.WriteFile(filepath.Join(dir, imgspecv1.ImageLayoutFile), []byte(`{"imageLayoutVersion":"1.0.0"}`), 0644))
+	index, err := json.Marshal(imgspecv1.Index{
+		Versioned: imgspec.Versioned{SchemaVersion: 2},
+		Manifests: []imgspecv1.Descriptor{{
+			MediaType:   imgspecv1.MediaTypeImageManifest,
+			Digest:      d,
+			Size:        int64(len(man)),
+			Annotations: map[string]string{imgspecv1.AnnotationRefName: name},
+		}},
+	})
+	require.NoError(t, err)
+	require.NoError(t, os.WriteFile(filepath.Join(dir, "index.json"), index, 0644))
+}
+
+func TestDestinationUpToDate(t *testing.T) {
+	newManifest := func(layer string) []byte {
+		m := manifest.OCI1FromComponents(imgspecv1.Descriptor{
+			MediaType: imgspecv1.MediaTypeImageConfig,
+			Digest:    digest.FromString("config"),
+			Size:      6,
+		}, []imgspecv1.Descriptor{{
+			MediaType: imgspecv1.MediaTypeImageLayerGzip,
+			Digest:    digest.FromString(layer),
+			Size:      int64(len(layer)),
+		}})
+		m.SchemaVersion = 2
+		man, err := m.Serialize()
+		require.NoError(t, err)
+		return man
+	}
+	srcManifest := newManifest("layer")
+
+	srcDir, destDir := t.TempDir(), t.TempDir()
+	writeOCILayout(t, srcDir, "src", srcManifest)
+	srcRef, err := layout.NewReference(srcDir, "src")
+	require.NoError(t, err)
+	destRef, err := layout.NewReference(destDir, "dest")
+	require.NoError(t, err)
+	policyContext, err := signature.NewPolicyContext(&signature.Policy{Default: []signature.PolicyRequirement{signature.NewPRInsecureAcceptAnything()}})
+	require.NoError(t, err)
+	defer func() { _ = policyContext.Destroy() }()
+
+	check := func(options *Options) bool {
+		src, err := srcRef.NewImageSource(context.Background(), nil)
+		require.NoError(t, err)
+		defer src.Close()
+		dest, err := destRef.NewImageDestination(context.Background(), nil)
+		require.NoError(t, err)
+		defer dest.Close()
+		c := &copier{dest: imagedestination.FromPublic(dest), rawSource: imagesource.FromPublic(src)}
+		man, res, err := c.destinationUpToDate(context.Background(), policyContext, options, image.UnparsedInstance(c.rawSource, nil))
+		require.NoError(t, err)
+		if res {
+			assert.Equal(t, srcManifest, man)
+		}
+		return res
+	}
+
+	// The destination does not exist
+	assert.False(t, check(&Options{}))
+
+	// The destination contains a different image
+	writeOCILayout(t, destDir, "dest", newManifest("other layer"))
+	assert.False(t, check(&Options{}))
+
+	// The destination contains the image
+	writeOCILayout(t, destDir, "dest", srcManifest)
+	assert.True(t, check(&Options{}))
+	assert.True(t, check(&Options{ForceManifestMIMEType: imgspecv1.MediaTypeImageManifest}))
+	// … but the copy would change it
+	assert.False(t, check(&Options{ForceManifestMIMEType: manifest.DockerV2Schema2MediaType}))
+	assert.False(t, check(&Options{SignBy: "key"}))
+	assert.False(t, check(&Options{SignBySigstorePrivateKeyFile: "cosign.key"}))
+	assert.False(t, check(&Options{SignBySigstoreKeyless: &sigstore.KeylessOptions{}}))
+	assert.False(t, check(&Options{SignBySigstoreKMSKey: testKMSKey{}}))
+	assert.False(t, check(&Options{AnnotationChanges: &AnnotationChanges{}}))
+	assert.False(t, check(&Options{Reproducible: &ReproducibleOptions{Timestamp: &time.Time{}}}))
+
+	// The copy is skipped by ImageWithResult.  (The source layout does not contain any blobs, so the copy would fail otherwise.)
+	res, err := ImageWithResult(context.Background(), policyContext, destRef, srcRef, &Options{SkipIfDestinationUpToDate: true})
+	require.NoError(t, err)
+	assert.True(t, res.UpToDate)
+	assert.Equal(t, srcManifest, res.Manifest)
+	_, err = ImageWithResult(context.Background(), policyContext, destRef, srcRef, &Options{})
+	assert.Error(t, err)
+}
@@ -0,0 +1,267 @@
+package docker
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"net/http"
+	"strconv"
+	"strings"
+
+	"github.com/containers/image/v5/docker/reference"
+	"github.com/containers/image/v5/internal/private"
+	"github.com/containers/image/v5/types"
+	"github.com/pkg/errors"
+	"github.com/sirupsen/logrus"
+)
+
+// RangeRequestSupport describes the support for HTTP range requests detected for a registry.
+type RangeRequestSupport int
+
+const (
+	// RangeRequestsUnknown means that no range request has been made yet.
+	RangeRequestsUnknown RangeRequestSupport = iota
+	// RangeRequestsUnsupported means that the registry ignores range requests, and returns complete blobs.
+	// Ranges are still returned correctly, but the data before and between them must be downloaded and discarded.
+	RangeRequestsUnsupported
+	// RangeRequestsSupported means that the registry supports requests for a single range; it is not yet known
+	// whether it supports multiple ranges in a single request.
+	RangeRequestsSupported
+	// MultiRangeRequestsSupported means that the registry supports multiple ranges in a single request.
+	MultiRangeRequestsSupported
+	// MultiRangeRequestsUnsupported means that the registry supports requests for a single range, but not for multiple
+	// ranges; multiple ranges are read using separate requests.
+	MultiRangeRequestsUnsupported
+)
+
+// rangeRequestSupport returns the support for range requests detected for c.
+func (c *dockerClient) rangeRequestSupport() RangeRequestSupport {
+	c.rangeSupportLock.Lock()
+	defer c.rangeSupportLock.Unlock()
+	return c.rangeSupport
+}
+
+// setRangeRequestSupport records that a range request to c has shown support.
+func (c *dockerClient) setRangeRequestSupport(support RangeRequestSupport) {
+	c.rangeSupportLock.Lock()
+	defer c.rangeSupportLock.Unlock()
+	if support == RangeRequestsSupported && (c.rangeSupport == MultiRangeRequestsSupported || c.rangeSupport == MultiRangeRequestsUnsupported) {
+		return // A single-range request does not tell us anything new.
+	}
+	c.rangeSupport = support
+}
+
+// parseContentRange parses a Content-Range header value of a single-part 206 response, e.g. "bytes 0-99/1000",
+// and returns the first and last byte positions.
+func parseContentRange(value string) (uint64, uint64, error) {
+	rangeValue := strings.TrimPrefix(value, "bytes ")
+	if rangeValue == value {
+		return 0, 0, errors.Errorf("invalid Content-Range %q", value)
+	}
+	if i := strings.IndexByte(rangeValue, '/'); i != -1 {
+		rangeValue = rangeValue[:i]
+	}
+	parts := strings.SplitN(rangeValue, "-", 2)
+	if len(parts) != 2 {
+		return 0, 0, errors.Errorf("invalid Content-Range %q", value)
+	}
+	start, err := strconv.ParseUint(parts[0], 10, 64)
+	if err != nil {
+		return 0, 0, errors.Errorf("invalid Content-Range %q", value)
+	}
+	end, err := strconv.ParseUint(parts[1], 10, 64)
+	if err != nil || end < start {
+		return 0, 0, errors.Errorf("invalid Content-Range %q", value)
+	}
+	return start, end, nil
+}
+
+// getBlobRanges makes a single request for chunks of the blob described by info.
+func (s *dockerImageSource) getBlobRanges(ctx context.Context, info types.BlobInfo, chunks []private.ImageSourceChunk) (*http.Response, error) {
+	var rangeVals []string
+	for _, c := range chunks {
+		rangeVals = append(rangeVals, fmt.Sprintf("%d-%d", c.Offset, c.Offset+c.Length-1))
+	}
+	headers := map[string][]string{
+		"Range": {fmt.Sprintf("bytes=%s", strings.Join(rangeVals, ","))},
+	}
+
+	path := fmt.Sprintf(blobsPath, reference.Path(s.physicalRef.ref), info.Digest.String())
+	logrus.Debugf("Downloading %s", path)
+	return s.c.makeRequest(ctx, http.MethodGet, path, headers, nil, v2Auth, nil)
+}
+
+// getBlobChunk returns a stream for chunk of the blob described by info.
+func (s *dockerImageSource) getBlobChunk(ctx context.Context, info types.BlobInfo, chunk private.ImageSourceChunk) (io.ReadCloser, error) {
+	res, err := s.getBlobRanges(ctx, info, []private.ImageSourceChunk{chunk})
+	if err != nil {
+		return nil, err
+	}
+	switch res.StatusCode {
+	case http.StatusPartialContent:
+		return res.Body, nil
+	case http.StatusOK:
+		s.c.setRangeRequestSupport(RangeRequestsUnsupported)
+		if _, err := io.CopyN(io.Discard, res.Body, int64(chunk.Offset)); err != nil {
+			res.Body.Close()
+			return nil, err
+		}
+		return struct {
+			io.Reader
+			io.Closer
+		}{
+			Reader: io.LimitReader(res.Body, int64(chunk.Length)),
+			Closer: res.Body,
+		}, nil
+	case http.StatusBadRequest, http.StatusRequestedRangeNotSatisfiable:
+		res.Body.Close()
+		return nil, private.BadPartialRequestError{Status: res.Status}
+	default:
+		err := httpResponseToError(res, "Error fetching partial blob")
+		if err == nil {
+			err = errors.Errorf("invalid status code returned when fetching blob %d (%s)", res.StatusCode, http.StatusText(res.StatusCode))
+		}
+		res.Body.Close()
+		return nil, err
+	}
+}
+
+// getBlobChunksSeparately is GetBlobAt, using a separate request for each chunk.
+func (s *dockerImageSource) getBlobChunksSeparately(ctx context.Context, info types.BlobInfo, chunks []private.ImageSourceChunk) (chan io.ReadCloser, chan error, error) {
+	streams := make(chan io.ReadCloser)
+	errs := make(chan error)
+	go func() {
+		defer close(streams)
+		defer close(errs)
+		for _, c := range chunks {
+			body, err := s.getBlobChunk(ctx, info, c)
+			if err != nil {
+				errs <- err
+				return
+			}
+			s := signalCloseReader{
+				closed: make(chan interface{}),
+				stream: body,
+			}
+			streams <- s
+			// Wait until the stream is closed before going to the next chunk
+			<-s.closed
+		}
+	}()
+	return streams, errs, nil
+}
+
+// BlobChunk is a portion of a blob, as used by GetBlobChunks.
+type BlobChunk struct {
+	Offset uint64
+	Length uint64
+}
+
+// dockerImageSourceFromPublic returns src as a *dockerImageSource, or an error if it was not created by this transport.
+func dockerImageSourceFromPublic(src types.ImageSource) (*dockerImageSource, error) {
+	s, ok := src.(*dockerImageSource)
+	if !ok {
+		return nil, errors.Errorf("image source for %q is not a docker:// image source", src.Reference().Transport().Name())
+	}
+	return s, nil
+}
+
+// GetBlobChunks reads chunks of a blob from src, which must be an image source created by this transport, using HTTP
+// range requests.  Multiple chunks are requested in a single request, if the registry supports that.
+// It returns a sequential channel of readers that contain data for the requested chunks, and a channel that might
+// get a single error value.
+// The specified chunks must be not overlapping and sorted by their offset.
+// The readers must be fully consumed, in the order they are returned, before blocking to read the next chunk.
+func GetBlobChunks(ctx context.Context, src types.ImageSource, info types.BlobInfo, chunks []BlobChunk) (chan io.ReadCloser, chan error, error) {
+	s, err := dockerImageSourceFromPublic(src)
+	if err != nil {
+		return nil, nil, err
+	}
+	privateChunks := make([]private.ImageSourceChunk, 0, len(chunks))
+	for _, c := range chunks {
+		privateChunks = append(privateChunks, private.ImageSourceChunk{Offset: c.Offset, Length: c.Length})
+	}
+	return s.GetBlobAt(ctx, info, privateChunks)
+}
+
+// BlobRangeRequestSupport returns the support for HTTP range requests detected so far for the registry of src,
+// which must be an image source created by this transport.
+func BlobRangeRequestSupport(src types.ImageSource) (RangeRequestSupport, error) {
+	s, err := dockerImageSourceFromPublic(src)
+	if err != nil {
+		return RangeRequestsUnknown, err
+	}
+	return s.c.rangeRequestSupport(), nil
+}
+
+// BlobReaderAt is an io.ReaderAt for a blob in a registry, which reads the requested data using HTTP range requests.
+// It is safe for concurrent use.
+type BlobReaderAt struct {
+	ctx  context.Context // Used for all requests, because io.ReaderAt does not allow passing a context.
+	src  *dockerImageSource
+	info types.BlobInfo
+}
+
+// NewBlobReaderAt returns a BlobReaderAt for the blob described by info, in src, which must be an image source created
+// by this transport.  If info.Size is not known, it is determined from the registry.
+// ctx is used for all requests made by the returned BlobReaderAt.
+func NewBlobReaderAt(ctx context.Context, src types.ImageSource, info types.BlobInfo) (*BlobReaderAt, error) {
+	s, err := dockerImageSourceFromPublic(src)
+	if err != nil {
+		return nil, err
+	}
+	if len(info.URLs) != 0 {
+		return nil, errors.New("external URLs not supported with BlobReaderAt")
+	}
+	if info.Size < 0 {
+		path := fmt.Sprintf(blobsPath, reference.Path(s.physicalRef.ref), info.Digest.String())
+		res, err := s.c.makeRequest(ctx, http.MethodHead, path, nil, nil, v2Auth, nil)
+		if err != nil {
+			return nil, err
+		}
+		defer res.Body.Close()
+		if err := httpResponseToError(res, "Error checking blob size"); err != nil {
+			return nil, err
+		}
+		info.Size = getBlobSize(res)
+		if info.Size < 0 {
+			return nil, errors.Errorf("size of blob %s is unknown", info.Digest)
+		}
+	}
+	return &BlobReaderAt{ctx: ctx, src: s, info: info}, nil
+}
+
+// Size returns the size of the blob.
+func (r *BlobReaderAt) Size() int64 {
+	return r.info.Size
+}
+
+// ReadAt implements io.ReaderAt.
+func (r *BlobReaderAt) ReadAt(p []byte, off int64) (int, error) {
+	if off < 0 {
+		return 0, errors.Errorf("invalid offset %d", off)
+	}
+	if off >= r.info.Size {
+		return 0, io.EOF
+	}
+	length := int64(len(p))
+	if length > r.info.Size-off {
+		length = r.info.Size - off
+	}
+	if length == 0 {
+		return 0, nil
+	}
+	stream, err := r.src.getBlobChunk(r.ctx, r.info, private.ImageSourceChunk{Offset: uint64(off), Length: uint64(length)})
+	if err != nil {
+		return 0, err
+	}
+	defer stream.Close()
+	n, err := io.ReadFull(stream, p[:length])
+	if err != nil {
+		return n, err
+	}
+	if length < int64(len(p)) {
+		return n, io.EOF
+	}
+	return n, nil
+}
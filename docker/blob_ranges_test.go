@@ -0,0 +1,198 @@
+package docker
+
+import (
+	"bytes"
+	"context"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/containers/image/v5/manifest"
+	"github.com/containers/image/v5/types"
+	"github.com/opencontainers/go-digest"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestParseContentRange(t *testing.T) {
+	start, end, err := parseContentRange("bytes 10-99/1000")
+	require.NoError(t, err)
+	assert.Equal(t, uint64(10), start)
+	assert.Equal(t, uint64(99), end)
+	start, end, err = parseContentRange("bytes 0-0/*")
+	require.NoError(t, err)
+	assert.Equal(t, uint64(0), start)
+	assert.Equal(t, uint64(0), end)
+	for _, v := range []string{"", "10-99/1000", "bytes 10/1000", "bytes 99-10/1000", "bytes x-10/1000", "bytes 0-x/1000"} {
+		_, _, err := parseContentRange(v)
+		assert.Error(t, err, v)
+	}
+}
+
+// Modes of rangeTestRegistry
+const (
+	rangesMultipart  = iota // Multiple ranges are returned in a multipart response
+	rangesSingleOnly        // Requests for multiple ranges are rejected
+	rangesFirstOnly         // Only the first of multiple ranges is returned
+	rangesCoalesced         // Multiple ranges are returned as a single range covering all of them
+	rangesIgnored           // Range headers are ignored
+)
+
+// rangeTestRegistry serves a single blob, with varying support for range requests.
+type rangeTestRegistry struct {
+	blob     []byte
+	mode     int
+	requests int
+}
+
+func (r *rangeTestRegistry) ServeHTTP(w http.ResponseWriter, req *http.Request) {
+	switch {
+	case req.URL.Path == "/v2/":
+		w.WriteHeader(http.StatusOK)
+	case req.URL.Path == "/v2/repo/manifests/tag":
+		w.Header().Set("Content-Type", manifest.DockerV2Schema2MediaType)
+		_, _ = w.Write([]byte(`{"schemaVersion":2}`))
+	case req.URL.Path == "/v2/repo/blobs/"+digest.FromBytes(r.blob).String():
+		r.requests++
+		rangeHeader := req.Header.Get("Range")
+		multiple := strings.Contains(rangeHeader, ",")
+		switch {
+		case r.mode == rangesIgnored:
+			req.Header.Del("Range")
+		case r.mode == rangesSingleOnly && multiple:
+			w.WriteHeader(http.StatusRequestedRangeNotSatisfiable)
+			return
+		case r.mode == rangesFirstOnly && multiple:
+			req.Header.Set("Range", rangeHeader[:strings.Index(rangeHeader, ",")])
+		case r.mode == rangesCoalesced && multiple:
+			first := strings.TrimPrefix(rangeHeader[:strings.Index(rangeHeader, ",")], "bytes=")
+			last := rangeHeader[strings.LastIndex(rangeHeader, ",")+1:]
+			req.Header.Set("Range", "bytes="+first[:strings.Index(first, "-")]+last[strings.Index(last, "-"):])
+		}
+		http.ServeContent(w, req, "", time.Time{}, bytes.NewReader(r.blob))
+	default:
+		w.WriteHeader(http.StatusNotFound)
+	}
+}
+
+// readChunks reads all data from the result of GetBlobChunks.
+func readChunks(t *testing.T, streams chan io.ReadCloser, errs chan error) []string {
+	res := []string{}
+	for streams != nil || errs != nil {
+		select {
+		case s, ok := <-streams:
+			if !ok {
+				streams = nil
+				continue
+			}
+			data, err := io.ReadAll(s)
+			require.NoError(t, err)
+			s.Close()
+			res = append(res, string(data))
+		case err, ok := <-errs:
+			if !ok {
+				errs = nil
+				continue
+			}
+			require.NoError(t, err)
+		}
+	}
+	return res
+}
+
+func TestGetBlobChunks(t *testing.T) {
+	r := &rangeTestRegistry{blob: []byte("0123456789abcdefghij")}
+	s := httptest.NewServer(r)
+	defer s.Close()
+	ref, err := ParseReference("//" + strings.TrimPrefix(s.URL, "http://") + "/repo:tag")
+	require.NoError(t, err)
+	info := types.BlobInfo{Digest: digest.FromBytes(r.blob), Size: -1}
+	chunks := []BlobChunk{{Offset: 1, Length: 2}, {Offset: 5, Length: 3}, {Offset: 15, Length: 5}}
+	expected := []string{"12", "567", "fghij"}
+
+	for _, c := range []struct {
+		mode              int
+		expectedRequests  int
+		expectedSupport   RangeRequestSupport
+		expectedRequests2 int // For a second request on the same source
+	}{
+		{rangesMultipart, 1, MultiRangeRequestsSupported, 1},
+		{rangesSingleOnly, 4, MultiRangeRequestsUnsupported, 3},
+		{rangesFirstOnly, 4, MultiRangeRequestsUnsupported, 3},
+		{rangesCoalesced, 1, MultiRangeRequestsSupported, 1},
+		{rangesIgnored, 1, RangeRequestsUnsupported, 1},
+	} {
+		r.mode = c.mode
+		r.requests = 0
+		src, err := ref.NewImageSource(context.Background(), &types.SystemContext{DockerInsecureSkipTLSVerify: types.OptionalBoolTrue})
+		require.NoError(t, err)
+		support, err := BlobRangeRequestSupport(src)
+		require.NoError(t, err)
+		assert.Equal(t, RangeRequestsUnknown, support)
+
+		streams, errs, err := GetBlobChunks(context.Background(), src, info, chunks)
+		require.NoError(t, err)
+		assert.Equal(t, expected, readChunks(t, streams, errs), c.mode)
+		assert.Equal(t, c.expectedRequests, r.requests, c.mode)
+		support, err = BlobRangeRequestSupport(src)
+		require.NoError(t, err)
+		assert.Equal(t, c.expectedSupport, support, c.mode)
+
+		r.requests = 0
+		streams, errs, err = GetBlobChunks(context.Background(), src, info, chunks)
+		require.NoError(t, err)
+		assert.Equal(t, expected, readChunks(t, streams, errs), c.mode)
+		assert.Equal(t, c.expectedRequests2, r.requests, c.mode)
+
+		// A single chunk does not lose the information about multi-range support
+		streams, errs, err = GetBlobChunks(context.Background(), src, info, chunks[:1])
+		require.NoError(t, err)
+		assert.Equal(t, expected[:1], readChunks(t, streams, errs), c.mode)
+		support, err = BlobRangeRequestSupport(src)
+		require.NoError(t, err)
+		assert.Equal(t, c.expectedSupport, support, c.mode)
+
+		src.Close()
+	}
+}
+
+func TestBlobReaderAt(t *testing.T) {
+	r := &rangeTestRegistry{blob: []byte("0123456789abcdefghij")}
+	s := httptest.NewServer(r)
+	defer s.Close()
+	ref, err := ParseReference("//" + strings.TrimPrefix(s.URL, "http://") + "/repo:tag")
+	require.NoError(t, err)
+	src, err := ref.NewImageSource(context.Background(), &types.SystemContext{DockerInsecureSkipTLSVerify: types.OptionalBoolTrue})
+	require.NoError(t, err)
+	defer src.Close()
+
+	for _, mode := range []int{rangesMultipart, rangesIgnored} {
+		r.mode = mode
+		reader, err := NewBlobReaderAt(context.Background(), src, types.BlobInfo{Digest: digest.FromBytes(r.blob), Size: -1})
+		require.NoError(t, err)
+		assert.Equal(t, int64(len(r.blob)), reader.Size())
+
+		buf := make([]byte, 5)
+		n, err := reader.ReadAt(buf, 3)
+		require.NoError(t, err)
+		assert.Equal(t, "34567", string(buf[:n]))
+		n, err = reader.ReadAt(buf, 17)
+		assert.Equal(t, io.EOF, err)
+		assert.Equal(t, "hij", string(buf[:n]))
+		_, err = reader.ReadAt(buf, 20)
+		assert.Equal(t, io.EOF, err)
+		_, err = reader.ReadAt(buf, -1)
+		assert.Error(t, err)
+
+		// io.SectionReader can be used for sequential reads
+		data, err := io.ReadAll(io.NewSectionReader(reader, 8, 4))
+		require.NoError(t, err)
+		assert.Equal(t, "89ab", string(data))
+	}
+
+	_, err = NewBlobReaderAt(context.Background(), src, types.BlobInfo{Digest: digest.FromString("missing"), Size: -1})
+	assert.Error(t, err)
+}
@@ -0,0 +1,302 @@
+package docker
+
+import (
+	"bytes"
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+
+	"github.com/containers/image/v5/docker/reference"
+	"github.com/containers/storage/pkg/ioutils"
+	"github.com/opencontainers/go-digest"
+	"github.com/pkg/errors"
+	"github.com/sirupsen/logrus"
+)
+
+// uploadSession is the JSON representation of an upload session recorded in DockerUploadSessionDir.
+type uploadSession struct {
+	Location string `json:"location"`
+}
+
+// uploadSessionPath returns the path to a file in DockerUploadSessionDir recording an upload of blobDigest,
+// or "" if the upload session should not be recorded.
+func (d *dockerImageDestination) uploadSessionPath(blobDigest digest.Digest) string {
+	if d.c.sys == nil || d.c.sys.DockerUploadSessionDir == "" || blobDigest == "" {
+		return ""
+	}
+	key := sha256.Sum256([]byte(fmt.Sprintf("%s/%s@%s", d.c.registry, reference.Path(d.ref.ref), blobDigest.String())))
+	return filepath.Join(d.c.sys.DockerUploadSessionDir, hex.EncodeToString(key[:])+".json")
+}
+
+// readUploadSession returns the upload location recorded in path, if any.
+func readUploadSession(path string) (*url.URL, bool) {
+	if path == "" {
+		return nil, false
+	}
+	data, err := os.ReadFile(path)
+	if err != nil {
+		if !os.IsNotExist(err) {
+			logrus.Debugf("Error reading upload session %s: %v", path, err)
+		}
+		return nil, false
+	}
+	var session uploadSession
+	if err := json.Unmarshal(data, &session); err != nil {
+		logrus.Debugf("Error parsing upload session %s: %v", path, err)
+		return nil, false
+	}
+	location, err := url.Parse(session.Location)
+	if err != nil || !location.IsAbs() {
+		logrus.Debugf("Invalid location %q in upload session %s", session.Location, path)
+		return nil, false
+	}
+	return location, true
+}
+
+// writeUploadSession records location in path, if path is not "".  Failures are only logged.
+func writeUploadSession(path string, location *url.URL) {
+	if path == "" {
+		return
+	}
+	data, err := json.Marshal(uploadSession{Location: location.String()})
+	if err != nil {
+		logrus.Debugf("Error marshaling upload session: %v", err)
+		return
+	}
+	if err := os.MkdirAll(filepath.Dir(path), 0700); err != nil {
+		logrus.Debugf("Error creating upload session directory: %v", err)
+		return
+	}
+	if err := ioutils.AtomicWriteFile(path, data, 0600); err != nil {
+		logrus.Debugf("Error writing upload session %s: %v", path, err)
+	}
+}
+
+// removeUploadSession removes the upload session recorded in path, if any.
+func removeUploadSession(path string) {
+	if path == "" {
+		return
+	}
+	if err := os.Remove(path); err != nil && !os.IsNotExist(err) {
+		logrus.Debugf("Error removing upload session %s: %v", path, err)
+	}
+}
+
+// uploadSessionRecord records the upload session of a blob with a known digest, both in memory, so that a later upload
+// of the same blob to the same destination (e.g. a retry after a failure) can resume it, and in DockerUploadSessionDir,
+// if configured, so that other processes can resume it.  It does nothing if the digest is not known.
+type uploadSessionRecord struct {
+	d          *dockerImageDestination
+	blobDigest digest.Digest
+	path       string // or "", see uploadSessionPath
+}
+
+// uploadSessionRecord returns an uploadSessionRecord for an upload of blobDigest, which may be "".
+func (d *dockerImageDestination) uploadSessionRecord(blobDigest digest.Digest) uploadSessionRecord {
+	return uploadSessionRecord{d: d, blobDigest: blobDigest, path: d.uploadSessionPath(blobDigest)}
+}
+
+// read returns the recorded upload location, if any.
+func (r uploadSessionRecord) read() (*url.URL, bool) {
+	if r.blobDigest == "" {
+		return nil, false
+	}
+	r.d.uploadSessionsLock.Lock()
+	location, ok := r.d.uploadSessions[r.blobDigest]
+	r.d.uploadSessionsLock.Unlock()
+	if ok {
+		return location, true
+	}
+	return readUploadSession(r.path)
+}
+
+// write records location.
+func (r uploadSessionRecord) write(location *url.URL) {
+	if r.blobDigest == "" {
+		return
+	}
+	r.d.uploadSessionsLock.Lock()
+	if r.d.uploadSessions == nil {
+		r.d.uploadSessions = map[digest.Digest]*url.URL{}
+	}
+	r.d.uploadSessions[r.blobDigest] = location
+	r.d.uploadSessionsLock.Unlock()
+	writeUploadSession(r.path, location)
+}
+
+// remove removes the recorded upload location, if any.
+func (r uploadSessionRecord) remove() {
+	if r.blobDigest == "" {
+		return
+	}
+	r.d.uploadSessionsLock.Lock()
+	delete(r.d.uploadSessions, r.blobDigest)
+	r.d.uploadSessionsLock.Unlock()
+	removeUploadSession(r.path)
+}
+
+// parseUploadRange parses the Range header value of an upload status response, e.g. "0-1023",
+// and returns the number of bytes received by the registry.
+func parseUploadRange(value string) (int64, error) {
+	if value == "" {
+		return 0, nil
+	}
+	parts := strings.SplitN(strings.TrimPrefix(value, "bytes="), "-", 2)
+	if len(parts) != 2 || parts[0] != "0" {
+		return -1, errors.Errorf("invalid upload range %q", value)
+	}
+	end, err := strconv.ParseInt(parts[1], 10, 64)
+	if err != nil || end < 0 {
+		return -1, errors.Errorf("invalid upload range %q", value)
+	}
+	// docker/distribution reports an empty upload as "0-0", so we can't tell that apart from a single received byte.
+	// Assume nothing was received; at worst, the registry will reject the next chunk, and the upload will fail
+	// as if it could not be resumed.
+	if end == 0 {
+		return 0, nil
+	}
+	return end + 1, nil
+}
+
+// uploadStatus returns the current location of the upload session at location,
+// and the number of bytes acknowledged by the registry.
+func (d *dockerImageDestination) uploadStatus(ctx context.Context, location *url.URL) (*url.URL, int64, error) {
+	res, err := d.c.makeRequestToResolvedURL(ctx, http.MethodGet, location, nil, nil, -1, v2Auth, nil)
+	if err != nil {
+		return nil, -1, err
+	}
+	defer res.Body.Close()
+	if res.StatusCode != http.StatusNoContent {
+		return nil, -1, errors.Wrapf(registryHTTPResponseToError(res), "checking status of upload %s", location.Redacted())
+	}
+	offset, err := parseUploadRange(res.Header.Get("Range"))
+	if err != nil {
+		return nil, -1, err
+	}
+	newLocation := location
+	if res.Header.Get("Location") != "" {
+		newLocation, err = res.Location()
+		if err != nil {
+			return nil, -1, errors.Wrap(err, "determining upload URL")
+		}
+	}
+	return newLocation, offset, nil
+}
+
+// uploadChunked uploads stream in chunks of chunkSize, and returns the location to use to finish the upload.
+// The upload session is recorded in session after every chunk, and an upload session previously recorded there
+// is resumed, skipping the data already acknowledged by the registry.
+func (d *dockerImageDestination) uploadChunked(ctx context.Context, stream io.Reader, session uploadSessionRecord, chunkSize int64) (*url.URL, error) {
+	var uploadLocation *url.URL
+	offset := int64(0)
+	if location, ok := session.read(); ok {
+		newLocation, acknowledged, err := d.uploadStatus(ctx, location)
+		if err != nil {
+			logrus.Debugf("Not resuming upload %s: %v", location.Redacted(), err)
+			session.remove()
+		} else {
+			logrus.Debugf("Resuming upload %s after %d bytes", location.Redacted(), acknowledged)
+			uploadLocation, offset = newLocation, acknowledged
+		}
+	}
+	if uploadLocation == nil {
+		location, err := d.startUpload(ctx)
+		if err != nil {
+			return nil, err
+		}
+		uploadLocation = location
+		session.write(uploadLocation)
+	}
+	if offset > 0 {
+		if _, err := io.CopyN(io.Discard, stream, offset); err != nil {
+			return nil, errors.Wrapf(err, "skipping %d already uploaded bytes", offset)
+		}
+	}
+
+	chunk := make([]byte, chunkSize)
+	for {
+		n, err := io.ReadFull(stream, chunk)
+		if err != nil && err != io.EOF && err != io.ErrUnexpectedEOF {
+			return nil, err
+		}
+		if n == 0 {
+			break
+		}
+		uploadLocation, err = d.uploadChunk(ctx, uploadLocation, chunk[:n], offset)
+		if err != nil {
+			return nil, err
+		}
+		offset += int64(n)
+		session.write(uploadLocation)
+		if int64(n) < chunkSize {
+			break
+		}
+	}
+	return uploadLocation, nil
+}
+
+// uploadChunk uploads chunk, which starts at offset within the blob, to uploadLocation, and returns the location to use
+// for the next request.  If the upload fails, it is resumed from the last byte acknowledged by the registry,
+// as long as the retry policy allows.
+func (d *dockerImageDestination) uploadChunk(ctx context.Context, uploadLocation *url.URL, chunk []byte, offset int64) (*url.URL, error) {
+	policy := d.c.retryPolicy()
+	backoff := policy.initialDelay
+	sent := int64(0) // Bytes of chunk acknowledged by the registry
+	for attempts := 1; ; attempts++ {
+		newLocation, err := d.patchChunk(ctx, uploadLocation, chunk[sent:], offset+sent)
+		if err == nil {
+			return newLocation, nil
+		}
+		if attempts >= policy.maxAttempts || ctx.Err() != nil {
+			return nil, err
+		}
+		if err := sleepForRetry(ctx, policy.delay(nil, backoff)); err != nil {
+			return nil, err
+		}
+		backoff *= 2
+		newLocation, acknowledged, statusErr := d.uploadStatus(ctx, uploadLocation)
+		if statusErr != nil {
+			logrus.Debugf("Error checking upload status: %v", statusErr)
+			return nil, err
+		}
+		if acknowledged < offset || acknowledged > offset+int64(len(chunk)) {
+			return nil, errors.Wrapf(err, "can not resume upload: registry acknowledged %d bytes, expected between %d and %d", acknowledged, offset, offset+int64(len(chunk)))
+		}
+		logrus.Debugf("Error uploading chunk at offset %d: %v; resuming at offset %d", offset+sent, err, acknowledged)
+		uploadLocation, sent = newLocation, acknowledged-offset
+		if sent == int64(len(chunk)) { // The registry received the chunk, but we did not receive the response.
+			return uploadLocation, nil
+		}
+	}
+}
+
+// patchChunk uploads data, which starts at offset within the blob, to uploadLocation, and returns the location to use
+// for the next request.
+func (d *dockerImageDestination) patchChunk(ctx context.Context, uploadLocation *url.URL, data []byte, offset int64) (*url.URL, error) {
+	headers := map[string][]string{
+		"Content-Type":  {"application/octet-stream"},
+		"Content-Range": {fmt.Sprintf("%d-%d", offset, offset+int64(len(data))-1)},
+	}
+	res, err := d.c.makeRequestToResolvedURL(ctx, http.MethodPatch, uploadLocation, headers, bytes.NewReader(data), int64(len(data)), v2Auth, nil)
+	if err != nil {
+		return nil, err
+	}
+	defer res.Body.Close()
+	if res.StatusCode != http.StatusAccepted {
+		return nil, errors.Wrapf(registryHTTPResponseToError(res), "uploading chunk at offset %d", offset)
+	}
+	newLocation, err := res.Location()
+	if err != nil {
+		return nil, errors.Wrap(err, "determining upload URL")
+	}
+	return newLocation, nil
+}
@@ -0,0 +1,243 @@
+package docker
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"strconv"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/containers/image/v5/pkg/blobinfocache/none"
+	"github.com/containers/image/v5/types"
+	"github.com/opencontainers/go-digest"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestParseUploadRange(t *testing.T) {
+	for _, c := range []struct {
+		input    string
+		expected int64
+	}{
+		{"", 0},
+		{"0-0", 0},
+		{"0-1", 2},
+		{"0-1023", 1024},
+		{"bytes=0-1023", 1024},
+	} {
+		res, err := parseUploadRange(c.input)
+		require.NoError(t, err, c.input)
+		assert.Equal(t, c.expected, res, c.input)
+	}
+	for _, input := range []string{"1-10", "0-", "0--1", "0-x", "10"} {
+		_, err := parseUploadRange(input)
+		assert.Error(t, err, input)
+	}
+}
+
+// chunkedUploadRegistry is a minimal registry accepting chunked blob uploads.
+type chunkedUploadRegistry struct {
+	mutex         sync.Mutex
+	uploads       map[string][]byte // Indexed by upload ID
+	blobs         map[digest.Digest][]byte
+	patches       int
+	patchedBytes  int
+	failPatch     int  // If > 0, the PATCH request with this number is accepted partially, and then the connection is dropped
+	rejectPatches bool // If true, PATCH requests are rejected
+}
+
+func (r *chunkedUploadRegistry) ServeHTTP(w http.ResponseWriter, req *http.Request) {
+	r.mutex.Lock()
+	defer r.mutex.Unlock()
+	switch {
+	case req.URL.Path == "/v2/":
+		w.WriteHeader(http.StatusOK)
+	case req.Method == http.MethodHead && strings.HasPrefix(req.URL.Path, "/v2/repo/blobs/"):
+		w.WriteHeader(http.StatusNotFound)
+	case req.Method == http.MethodPost && req.URL.Path == "/v2/repo/blobs/uploads/":
+		id := strconv.Itoa(len(r.uploads))
+		r.uploads[id] = []byte{}
+		w.Header().Set("Location", "/upload/"+id)
+		w.WriteHeader(http.StatusAccepted)
+	case strings.HasPrefix(req.URL.Path, "/upload/"):
+		id := strings.TrimPrefix(req.URL.Path, "/upload/")
+		data, ok := r.uploads[id]
+		if !ok {
+			w.WriteHeader(http.StatusNotFound)
+			return
+		}
+		switch req.Method {
+		case http.MethodGet:
+			w.Header().Set("Location", "/upload/"+id)
+			end := len(data)
+			if end > 0 {
+				end--
+			}
+			w.Header().Set("Range", fmt.Sprintf("0-%d", end))
+			w.WriteHeader(http.StatusNoContent)
+		case http.MethodPatch:
+			r.patches++
+			if r.rejectPatches {
+				w.WriteHeader(http.StatusBadRequest)
+				return
+			}
+			if req.Header.Get("Content-Range") != fmt.Sprintf("%d-%d", len(data), len(data)+int(req.ContentLength)-1) {
+				w.WriteHeader(http.StatusRequestedRangeNotSatisfiable)
+				return
+			}
+			body, err := io.ReadAll(req.Body)
+			if err != nil {
+				w.WriteHeader(http.StatusBadRequest)
+				return
+			}
+			if r.patches == r.failPatch {
+				body = body[:len(body)/2]
+				r.uploads[id] = append(data, body...)
+				r.patchedBytes += len(body)
+				hj, ok := w.(http.Hijacker)
+				if !ok {
+					panic("Hijacking not supported")
+				}
+				conn, _, err := hj.Hijack()
+				if err == nil {
+					conn.Close()
+				}
+				return
+			}
+			r.uploads[id] = append(data, body...)
+			r.patchedBytes += len(body)
+			w.Header().Set("Location", "/upload/"+id+"?_state="+strconv.Itoa(len(r.uploads[id])))
+			w.WriteHeader(http.StatusAccepted)
+		case http.MethodPut:
+			d := digest.Digest(req.URL.Query().Get("digest"))
+			if d != digest.FromBytes(data) {
+				w.WriteHeader(http.StatusBadRequest)
+				return
+			}
+			delete(r.uploads, id)
+			r.blobs[d] = data
+			w.WriteHeader(http.StatusCreated)
+		}
+	default:
+		w.WriteHeader(http.StatusNotFound)
+	}
+}
+
+func TestPutBlobChunked(t *testing.T) {
+	r := &chunkedUploadRegistry{uploads: map[string][]byte{}, blobs: map[digest.Digest][]byte{}}
+	s := httptest.NewServer(r)
+	defer s.Close()
+	registry := strings.TrimPrefix(s.URL, "http://")
+	ref, err := ParseReference("//" + registry + "/repo:tag")
+	require.NoError(t, err)
+	sessionDir := t.TempDir()
+
+	blob := []byte("0123456789")
+	blobDigest := digest.FromBytes(blob)
+	putBlob := func(blobInfo types.BlobInfo, sessionDir string) (types.BlobInfo, error) {
+		dest, err := ref.NewImageDestination(context.Background(), &types.SystemContext{
+			DockerInsecureSkipTLSVerify: types.OptionalBoolTrue,
+			DockerChunkedUploadSize:     3,
+			DockerUploadSessionDir:      sessionDir,
+			DockerRetryPolicy:           &types.DockerRetryPolicy{MaxAttempts: 3, InitialDelay: time.Millisecond},
+		})
+		require.NoError(t, err)
+		defer dest.Close()
+		return dest.PutBlob(context.Background(), bytes.NewReader(blob), blobInfo, none.NoCache, false)
+	}
+	reset := func() {
+		r.mutex.Lock()
+		defer r.mutex.Unlock()
+		r.uploads = map[string][]byte{}
+		r.blobs = map[digest.Digest][]byte{}
+		r.patches, r.patchedBytes, r.failPatch = 0, 0, 0
+	}
+
+	// Unknown digest, uploaded in chunks
+	res, err := putBlob(types.BlobInfo{Size: -1}, "")
+	require.NoError(t, err)
+	assert.Equal(t, types.BlobInfo{Digest: blobDigest, Size: int64(len(blob))}, res)
+	assert.Equal(t, blob, r.blobs[blobDigest])
+	assert.Equal(t, 4, r.patches)
+
+	// An interrupted chunk is resumed
+	reset()
+	r.failPatch = 2
+	res, err = putBlob(types.BlobInfo{Size: -1}, "")
+	require.NoError(t, err)
+	assert.Equal(t, blobDigest, res.Digest)
+	assert.Equal(t, blob, r.blobs[blobDigest])
+	assert.Equal(t, 5, r.patches)
+	assert.Equal(t, len(blob), r.patchedBytes)
+
+	// An interrupted upload is resumed by another destination, using the recorded session
+	reset()
+	r.mutex.Lock()
+	r.rejectPatches = true
+	r.mutex.Unlock()
+	_, err = putBlob(types.BlobInfo{Digest: blobDigest, Size: int64(len(blob))}, sessionDir)
+	require.Error(t, err)
+	// Simulate a partially completed upload
+	r.mutex.Lock()
+	r.uploads["0"] = append(r.uploads["0"], blob[:4]...)
+	r.rejectPatches = false
+	r.mutex.Unlock()
+	sessions, err := os.ReadDir(sessionDir)
+	require.NoError(t, err)
+	require.Len(t, sessions, 1)
+	res, err = putBlob(types.BlobInfo{Digest: blobDigest, Size: int64(len(blob))}, sessionDir)
+	require.NoError(t, err)
+	assert.Equal(t, blobDigest, res.Digest)
+	assert.Equal(t, blob, r.blobs[blobDigest])
+	assert.Equal(t, len(blob)-4, r.patchedBytes)
+	sessions, err = os.ReadDir(sessionDir)
+	require.NoError(t, err)
+	assert.Empty(t, sessions)
+
+	// An interrupted upload is resumed by the same destination, even if no session directory is configured
+	reset()
+	r.mutex.Lock()
+	r.rejectPatches = true
+	r.mutex.Unlock()
+	dest, err := ref.NewImageDestination(context.Background(), &types.SystemContext{
+		DockerInsecureSkipTLSVerify: types.OptionalBoolTrue,
+		DockerChunkedUploadSize:     3,
+		DockerRetryPolicy:           &types.DockerRetryPolicy{MaxAttempts: 1},
+	})
+	require.NoError(t, err)
+	defer dest.Close()
+	_, err = dest.PutBlob(context.Background(), bytes.NewReader(blob), types.BlobInfo{Digest: blobDigest, Size: int64(len(blob))}, none.NoCache, false)
+	require.Error(t, err)
+	r.mutex.Lock()
+	r.uploads["0"] = append(r.uploads["0"], blob[:4]...)
+	r.rejectPatches = false
+	r.mutex.Unlock()
+	res, err = dest.PutBlob(context.Background(), bytes.NewReader(blob), types.BlobInfo{Digest: blobDigest, Size: int64(len(blob))}, none.NoCache, false)
+	require.NoError(t, err)
+	assert.Equal(t, blobDigest, res.Digest)
+	assert.Equal(t, blob, r.blobs[blobDigest])
+	assert.Equal(t, len(blob)-4, r.patchedBytes)
+
+	// A session which is no longer known to the registry is not resumed
+	reset()
+	r.mutex.Lock()
+	r.rejectPatches = true
+	r.mutex.Unlock()
+	_, err = putBlob(types.BlobInfo{Digest: blobDigest, Size: int64(len(blob))}, sessionDir)
+	require.Error(t, err)
+	reset()
+	r.mutex.Lock()
+	r.rejectPatches = false
+	r.mutex.Unlock()
+	res, err = putBlob(types.BlobInfo{Digest: blobDigest, Size: int64(len(blob))}, sessionDir)
+	require.NoError(t, err)
+	assert.Equal(t, blobDigest, res.Digest)
+	assert.Equal(t, len(blob), r.patchedBytes)
+}
@@ -0,0 +1,70 @@
+package docker
+
+import (
+	"net/http"
+
+	"github.com/containers/image/v5/internal/digestpolicy"
+	"github.com/containers/image/v5/manifest"
+	digest "github.com/opencontainers/go-digest"
+	"github.com/pkg/errors"
+	"github.com/sirupsen/logrus"
+)
+
+// contentDigestHeader returns the value of the Docker-Content-Digest header of res, if it is present and usable.
+func contentDigestHeader(res *http.Response) (digest.Digest, bool) {
+	value := res.Header.Get("Docker-Content-Digest")
+	if value == "" {
+		return "", false
+	}
+	d, err := digest.Parse(value)
+	if err != nil {
+		logrus.Debugf("Ignoring invalid Docker-Content-Digest header %q: %v", value, err)
+		return "", false
+	}
+	if !d.Algorithm().Available() {
+		logrus.Debugf("Ignoring Docker-Content-Digest header %q using an unsupported algorithm", value)
+		return "", false
+	}
+	return d, true
+}
+
+// checkManifestContentDigest verifies manblob, described by what, returned in res, against the Docker-Content-Digest
+// header of res, if any, as required by the digest policy of c.
+func (c *dockerClient) checkManifestContentDigest(res *http.Response, manblob []byte, what string) error {
+	d, ok := contentDigestHeader(res)
+	if !ok {
+		return nil
+	}
+	var matches bool
+	if d.Algorithm() == digest.Canonical {
+		// manifest.MatchesDigest handles signed schema1 manifests, but only supports digest.Canonical.
+		m, err := manifest.MatchesDigest(manblob, d)
+		if err != nil {
+			return errors.Wrapf(err, "computing digest of manifest %s", what)
+		}
+		matches = m
+	} else {
+		matches = d.Algorithm().FromBytes(manblob) == d
+	}
+	if !matches {
+		return digestpolicy.ContentDigestMismatch(digestpolicy.FromSystemContext(c.sys),
+			errors.Errorf("manifest %s does not match the Docker-Content-Digest %s returned by %s", what, d, c.registry))
+	}
+	return nil
+}
+
+// checkBlobContentDigest verifies that the Docker-Content-Digest header of res, returned for a blob with expected digest,
+// if any, matches, as required by the digest policy of c.
+// The blob contents are not read; they are expected to be verified against the expected digest by the consumer.
+func (c *dockerClient) checkBlobContentDigest(res *http.Response, expected digest.Digest) error {
+	d, ok := contentDigestHeader(res)
+	// A digest using a different algorithm can't be compared without reading the blob.
+	if !ok || d.Algorithm() != expected.Algorithm() {
+		return nil
+	}
+	if d != expected {
+		return digestpolicy.ContentDigestMismatch(digestpolicy.FromSystemContext(c.sys),
+			errors.Errorf("blob %s was returned by %s with a mismatching Docker-Content-Digest %s", expected, c.registry, d))
+	}
+	return nil
+}
@@ -0,0 +1,105 @@
+package docker
+
+import (
+	"context"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/containers/image/v5/pkg/blobinfocache/none"
+	"github.com/containers/image/v5/types"
+	digest "github.com/opencontainers/go-digest"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestContentDigestVerification(t *testing.T) {
+	manifestBlob := []byte(`{"schemaVersion":2,"mediaType":"application/vnd.oci.image.manifest.v1+json","config":{},"layers":[]}`)
+	blob := []byte("blob contents")
+	blobDigest := digest.FromBytes(blob)
+	var manifestDigestHeader, blobDigestHeader string
+	server := httptest.NewServer(http.HandlerFunc(func(rw http.ResponseWriter, r *http.Request) {
+		switch {
+		case r.URL.Path == "/v2/":
+			rw.WriteHeader(http.StatusOK)
+		case strings.HasPrefix(r.URL.Path, "/v2/repo/manifests/"):
+			rw.Header().Set("Content-Type", "application/vnd.oci.image.manifest.v1+json")
+			if manifestDigestHeader != "" {
+				rw.Header().Set("Docker-Content-Digest", manifestDigestHeader)
+			}
+			_, _ = rw.Write(manifestBlob)
+		case r.URL.Path == "/v2/repo/blobs/"+blobDigest.String():
+			if blobDigestHeader != "" {
+				rw.Header().Set("Docker-Content-Digest", blobDigestHeader)
+			}
+			_, _ = rw.Write(blob)
+		default:
+			rw.WriteHeader(http.StatusNotFound)
+		}
+	}))
+	defer server.Close()
+	registry := strings.TrimPrefix(server.URL, "http://")
+	ref, err := ParseReference("//" + registry + "/repo:tag")
+	require.NoError(t, err)
+
+	otherDigest := digest.FromString("other")
+	for _, c := range []struct {
+		manifestHeader, blobHeader string
+		strict                     bool
+		manifestOK, blobOK         bool
+	}{
+		{"", "", true, true, true},
+		{digest.FromBytes(manifestBlob).String(), blobDigest.String(), true, true, true},
+		{otherDigest.String(), otherDigest.String(), false, true, true},
+		{otherDigest.String(), blobDigest.String(), true, false, true},
+		{digest.FromBytes(manifestBlob).String(), otherDigest.String(), true, true, false},
+		// Digests which can't be compared are ignored
+		{"invalid", "invalid", true, true, true},
+		{"unknown:0123", "unknown:0123", true, true, true},
+		{digest.SHA512.FromBytes(manifestBlob).String(), digest.SHA512.FromString("other").String(), true, true, true},
+	} {
+		manifestDigestHeader, blobDigestHeader = c.manifestHeader, c.blobHeader
+		sys := &types.SystemContext{
+			DockerInsecureSkipTLSVerify: types.OptionalBoolTrue,
+			DigestPolicy:                &types.DigestPolicy{RequireContentDigestMatch: c.strict},
+		}
+		src, err := ref.NewImageSource(context.Background(), sys)
+		if !c.manifestOK {
+			assert.Error(t, err, "%#v", c)
+			continue
+		}
+		require.NoError(t, err, "%#v", c)
+		reader, _, err := src.GetBlob(context.Background(), types.BlobInfo{Digest: blobDigest, Size: -1}, none.NoCache)
+		if c.blobOK {
+			require.NoError(t, err, "%#v", c)
+			contents, err := io.ReadAll(reader)
+			require.NoError(t, err)
+			assert.Equal(t, blob, contents)
+			reader.Close()
+		} else {
+			assert.Error(t, err, "%#v", c)
+		}
+		src.Close()
+	}
+
+	// Digest algorithms
+	manifestDigestHeader, blobDigestHeader = "", ""
+	sys := &types.SystemContext{
+		DockerInsecureSkipTLSVerify: types.OptionalBoolTrue,
+		DigestPolicy:                &types.DigestPolicy{AllowedAlgorithms: []digest.Algorithm{digest.SHA512}},
+	}
+	src, err := ref.NewImageSource(context.Background(), sys)
+	require.NoError(t, err)
+	defer src.Close()
+	_, _, err = src.GetBlob(context.Background(), types.BlobInfo{Digest: blobDigest, Size: -1}, none.NoCache)
+	var notAllowed types.DigestAlgorithmNotAllowedError
+	assert.ErrorAs(t, err, &notAllowed)
+	_, _, err = src.GetManifest(context.Background(), &blobDigest)
+	assert.ErrorAs(t, err, &notAllowed)
+	digestedRef, err := ParseReference("//" + registry + "/repo@" + digest.FromBytes(manifestBlob).String())
+	require.NoError(t, err)
+	_, err = digestedRef.NewImageSource(context.Background(), sys)
+	assert.ErrorAs(t, err, &notAllowed)
+}
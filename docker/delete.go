@@ -0,0 +1,178 @@
+package docker
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"strings"
+
+	"github.com/containers/image/v5/docker/reference"
+	"github.com/containers/image/v5/internal/iolimits"
+	"github.com/containers/image/v5/internal/warnings"
+	"github.com/containers/image/v5/manifest"
+	"github.com/containers/image/v5/types"
+	"github.com/docker/distribution/registry/api/errcode"
+	"github.com/opencontainers/go-digest"
+	"github.com/pkg/errors"
+	"github.com/sirupsen/logrus"
+)
+
+// DeleteTag removes the tag of ref from its repository, without deleting the manifest it refers to
+// or any other tags referring to the same manifest.
+//
+// The distribution-spec tag deletion endpoint is used if the registry supports it.  Otherwise, the manifest is
+// deleted by digest, and uploaded again under all of its other tags; this is not atomic, and the other tags are
+// briefly missing.  If the tag was the only tag of the manifest, the manifest is deleted.
+// If the registry does not allow deleting the manifest either, an ErrDeletionUnsupported is returned.
+func DeleteTag(ctx context.Context, sys *types.SystemContext, ref types.ImageReference) error {
+	dr, ok := ref.(dockerReference)
+	if !ok {
+		return errors.Errorf("ref must be a dockerReference")
+	}
+	if _, isDigested := dr.ref.(reference.Canonical); isDigested {
+		return errors.Errorf("deleting a tag requires a reference without a digest, got %s", reference.FamiliarString(dr.ref))
+	}
+	tagged, ok := dr.ref.(reference.NamedTagged)
+	if !ok {
+		return errors.Errorf("deleting a tag requires a tagged reference, got %s", reference.FamiliarString(dr.ref))
+	}
+
+	// See deleteImage for the choice of the action.
+	c, err := newDockerClientFromRef(ctx, sys, dr, true, "*")
+	if err != nil {
+		return errors.Wrap(err, "failed to create client")
+	}
+	return c.deleteTag(ctx, dr.ref, tagged.Tag())
+}
+
+// DeleteManifest deletes the manifest with manifestDigest, and all tags referring to it, from the repository of ref.
+// The tag or digest provided inside the ImageReference will be ignored.
+// Unlike ImageReference.DeleteImage, this does not delete signatures stored in lookaside storage.
+// If the registry does not support, or does not allow, deleting manifests, an ErrDeletionUnsupported is returned.
+func DeleteManifest(ctx context.Context, sys *types.SystemContext, ref types.ImageReference, manifestDigest digest.Digest) error {
+	dr, ok := ref.(dockerReference)
+	if !ok {
+		return errors.Errorf("ref must be a dockerReference")
+	}
+	if err := manifestDigest.Validate(); err != nil {
+		return err
+	}
+
+	// See deleteImage for the choice of the action.
+	c, err := newDockerClientFromRef(ctx, sys, dr, true, "*")
+	if err != nil {
+		return errors.Wrap(err, "failed to create client")
+	}
+	return c.deleteManifest(ctx, dr.ref, manifestDigest)
+}
+
+// deleteTag removes tag from repo, using the tag deletion endpoint if available, and deleting and re-uploading
+// the manifest otherwise.
+func (c *dockerClient) deleteTag(ctx context.Context, repo reference.Named, tag string) error {
+	path := fmt.Sprintf(manifestPath, reference.Path(repo), tag)
+	res, err := c.makeRequest(ctx, http.MethodDelete, path, nil, nil, v2Auth, nil)
+	if err != nil {
+		return err
+	}
+	defer res.Body.Close()
+	switch res.StatusCode {
+	case http.StatusAccepted:
+		return nil
+	case http.StatusBadRequest, http.StatusMethodNotAllowed:
+		// The distribution spec allows both status codes when tag deletion is not supported.
+		warnings.Report(c.sys, types.Warning{
+			Kind:    types.WarningCapabilityGap,
+			Code:    types.WarningCodeTagDeletionUnsupported,
+			Subject: c.registry,
+			Message: fmt.Sprintf("Deleting tags is not supported by %s, deleting the manifest and restoring other tags instead", c.registry),
+		})
+	default:
+		return errors.Wrapf(registryHTTPResponseToError(res), "deleting tag %s in %s", tag, repo.Name())
+	}
+
+	m, err := c.fetchManifestForDeletion(ctx, repo, tag)
+	if err != nil {
+		return err
+	}
+	manifestDigest, err := manifest.Digest(m)
+	if err != nil {
+		return errors.Wrap(err, "computing manifest digest")
+	}
+	list, err := c.listTags(ctx, repo, &ListTagsOptions{})
+	if err != nil {
+		return err
+	}
+	if err := c.resolveTagDigests(ctx, repo, list.Tags); err != nil {
+		return err
+	}
+	otherTags := []string{}
+	for _, t := range list.Tags {
+		if t.Name != tag && t.Digest == manifestDigest {
+			otherTags = append(otherTags, t.Name)
+		}
+	}
+
+	if err := c.deleteManifest(ctx, repo, manifestDigest); err != nil {
+		return errors.Wrapf(err, "deleting tag %s in %s", tag, repo.Name())
+	}
+	failed := []string{}
+	var lastErr error
+	for _, t := range otherTags {
+		if _, err := c.uploadManifest(ctx, repo, m, t); err != nil {
+			logrus.Debugf("Error restoring tag %s: %v", t, err)
+			failed = append(failed, t)
+			lastErr = err
+		}
+	}
+	if len(failed) != 0 {
+		return errors.Wrapf(lastErr, "deleting tag %s in %s: manifest %s was deleted, but restoring tags %s failed",
+			tag, repo.Name(), manifestDigest, strings.Join(failed, ", "))
+	}
+	return nil
+}
+
+// fetchManifestForDeletion returns the manifest tagOrDigest in repo, as stored in the registry.
+func (c *dockerClient) fetchManifestForDeletion(ctx context.Context, repo reference.Named, tagOrDigest string) ([]byte, error) {
+	path := fmt.Sprintf(manifestPath, reference.Path(repo), tagOrDigest)
+	headers := map[string][]string{
+		"Accept": manifest.DefaultRequestedManifestMIMETypes,
+	}
+	res, err := c.makeRequest(ctx, http.MethodGet, path, headers, nil, v2Auth, nil)
+	if err != nil {
+		return nil, err
+	}
+	defer res.Body.Close()
+	if res.StatusCode != http.StatusOK {
+		return nil, errors.Wrapf(manifestHTTPResponseToError(res), "reading manifest %s in %s", tagOrDigest, repo.Name())
+	}
+	return iolimits.ReadAtMost(res.Body, iolimits.MaxManifestBodySize)
+}
+
+// deleteManifest deletes the manifest with manifestDigest from repo.
+func (c *dockerClient) deleteManifest(ctx context.Context, repo reference.Named, manifestDigest digest.Digest) error {
+	path := fmt.Sprintf(manifestPath, reference.Path(repo), manifestDigest.String())
+	res, err := c.makeRequest(ctx, http.MethodDelete, path, nil, nil, v2Auth, nil)
+	if err != nil {
+		return err
+	}
+	defer res.Body.Close()
+	if res.StatusCode == http.StatusAccepted {
+		return nil
+	}
+	operation := fmt.Sprintf("deleting manifest %s in %s", manifestDigest, repo.Name())
+	rawErr := registryHTTPResponseToError(res)
+	if res.StatusCode == http.StatusMethodNotAllowed || isUnsupportedError(rawErr) {
+		return ErrDeletionUnsupported{Operation: operation, Err: rawErr}
+	}
+	return errors.Wrap(rawErr, operation)
+}
+
+// isUnsupportedError returns true iff err from registryHTTPResponseToError is an “unsupported operation” error.
+func isUnsupportedError(err error) bool {
+	var regErr RegistryError
+	if !errors.As(err, &regErr) {
+		return false
+	}
+	code, ok := registryErrorCode(regErr.Err)
+	return ok && code == errcode.ErrorCodeUnsupported
+}
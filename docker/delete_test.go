@@ -0,0 +1,187 @@
+package docker
+
+import (
+	"context"
+	"encoding/json"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"sort"
+	"strings"
+	"testing"
+
+	"github.com/containers/image/v5/types"
+	"github.com/opencontainers/go-digest"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// deleteTestRegistry is a registry storing manifests, which optionally supports deleting tags and manifests.
+type deleteTestRegistry struct {
+	t                *testing.T
+	tagDeletion      bool
+	manifestDeletion bool
+	manifests        map[digest.Digest][]byte
+	tags             map[string]digest.Digest
+}
+
+func (r *deleteTestRegistry) ServeHTTP(w http.ResponseWriter, req *http.Request) {
+	switch {
+	case req.URL.Path == "/v2/":
+		w.WriteHeader(http.StatusOK)
+	case req.URL.Path == "/v2/repo/tags/list":
+		tags := []string{}
+		for tag := range r.tags {
+			tags = append(tags, tag)
+		}
+		sort.Strings(tags)
+		body, err := json.Marshal(map[string]interface{}{"name": "repo", "tags": tags})
+		require.NoError(r.t, err)
+		_, _ = w.Write(body)
+	case strings.HasPrefix(req.URL.Path, "/v2/repo/manifests/"):
+		refTail := strings.TrimPrefix(req.URL.Path, "/v2/repo/manifests/")
+		d, isDigest := digest.Digest(refTail), strings.Contains(refTail, ":")
+		if !isDigest && req.Method != http.MethodPut {
+			var ok bool
+			if d, ok = r.tags[refTail]; !ok {
+				w.WriteHeader(http.StatusNotFound)
+				return
+			}
+		}
+		switch req.Method {
+		case http.MethodGet, http.MethodHead:
+			m, ok := r.manifests[d]
+			if !ok {
+				w.WriteHeader(http.StatusNotFound)
+				return
+			}
+			w.Header().Set("Docker-Content-Digest", d.String())
+			if req.Method == http.MethodGet {
+				_, _ = w.Write(m)
+			}
+		case http.MethodPut:
+			body, err := io.ReadAll(req.Body)
+			require.NoError(r.t, err)
+			d = digest.FromBytes(body)
+			r.manifests[d] = body
+			if !isDigest {
+				r.tags[refTail] = d
+			}
+			w.WriteHeader(http.StatusCreated)
+		case http.MethodDelete:
+			switch {
+			case !isDigest && r.tagDeletion:
+				delete(r.tags, refTail)
+			case !isDigest:
+				w.WriteHeader(http.StatusBadRequest)
+				return
+			case !r.manifestDeletion:
+				w.WriteHeader(http.StatusMethodNotAllowed)
+				_, _ = w.Write([]byte(`{"errors":[{"code":"UNSUPPORTED","message":"The operation is unsupported."}]}`))
+				return
+			default:
+				if _, ok := r.manifests[d]; !ok {
+					w.WriteHeader(http.StatusNotFound)
+					return
+				}
+				delete(r.manifests, d)
+				for tag, tagDigest := range r.tags {
+					if tagDigest == d {
+						delete(r.tags, tag)
+					}
+				}
+			}
+			w.WriteHeader(http.StatusAccepted)
+		default:
+			w.WriteHeader(http.StatusMethodNotAllowed)
+		}
+	default:
+		w.WriteHeader(http.StatusNotFound)
+	}
+}
+
+func TestDeleteTagAndManifest(t *testing.T) {
+	m1 := []byte(`{"schemaVersion":2,"mediaType":"application/vnd.oci.image.manifest.v1+json","config":{},"layers":[]}`)
+	m2 := []byte(`{"schemaVersion":2,"mediaType":"application/vnd.oci.image.manifest.v1+json","config":{},"layers":[{}]}`)
+	d1, d2 := digest.FromBytes(m1), digest.FromBytes(m2)
+	warningCodes := []string{}
+	sys := &types.SystemContext{
+		DockerInsecureSkipTLSVerify: types.OptionalBoolTrue,
+		WarningHandler:              func(w types.Warning) { warningCodes = append(warningCodes, w.Code) },
+	}
+
+	for _, c := range []struct {
+		tagDeletion, manifestDeletion bool
+	}{
+		{true, true},
+		{true, false},
+		{false, true},
+		{false, false},
+	} {
+		r := &deleteTestRegistry{
+			t:                t,
+			tagDeletion:      c.tagDeletion,
+			manifestDeletion: c.manifestDeletion,
+			manifests:        map[digest.Digest][]byte{d1: m1, d2: m2},
+			tags:             map[string]digest.Digest{"a": d1, "b": d1, "c": d1, "d": d2},
+		}
+		s := httptest.NewServer(r)
+		defer s.Close()
+		registry := strings.TrimPrefix(s.URL, "http://")
+		ref := func(s string) types.ImageReference {
+			res, err := ParseReference("//" + registry + "/repo" + s)
+			require.NoError(t, err)
+			return res
+		}
+
+		warningCodes = []string{}
+		err := DeleteTag(context.Background(), sys, ref(":a"))
+		if c.tagDeletion {
+			assert.Empty(t, warningCodes, "%#v", c)
+		} else {
+			assert.Equal(t, []string{types.WarningCodeTagDeletionUnsupported}, warningCodes, "%#v", c)
+		}
+		if !c.tagDeletion && !c.manifestDeletion {
+			var unsupported ErrDeletionUnsupported
+			assert.ErrorAs(t, err, &unsupported, "%#v", c)
+			assert.Equal(t, map[string]digest.Digest{"a": d1, "b": d1, "c": d1, "d": d2}, r.tags, "%#v", c)
+		} else {
+			require.NoError(t, err, "%#v", c)
+			assert.Equal(t, map[string]digest.Digest{"b": d1, "c": d1, "d": d2}, r.tags, "%#v", c)
+			assert.Equal(t, m1, r.manifests[d1], "%#v", c)
+		}
+
+		err = DeleteTag(context.Background(), sys, ref(":nonexistent"))
+		assert.Error(t, err, "%#v", c)
+		err = DeleteTag(context.Background(), sys, ref("@"+d1.String()))
+		assert.Error(t, err, "%#v", c)
+
+		err = DeleteManifest(context.Background(), sys, ref(":ignored"), d2)
+		if c.manifestDeletion {
+			require.NoError(t, err, "%#v", c)
+			assert.NotContains(t, r.manifests, d2, "%#v", c)
+			assert.NotContains(t, r.tags, "d", "%#v", c)
+		} else {
+			var unsupported ErrDeletionUnsupported
+			require.ErrorAs(t, err, &unsupported, "%#v", c)
+			assert.Contains(t, unsupported.Error(), d2.String())
+			assert.Contains(t, r.manifests, d2, "%#v", c)
+		}
+	}
+
+	// Deleting the only tag of a manifest without tag deletion support deletes the manifest.
+	r := &deleteTestRegistry{
+		t:                t,
+		manifestDeletion: true,
+		manifests:        map[digest.Digest][]byte{d1: m1},
+		tags:             map[string]digest.Digest{"a": d1},
+	}
+	s := httptest.NewServer(r)
+	defer s.Close()
+	ref, err := ParseReference("//" + strings.TrimPrefix(s.URL, "http://") + "/repo:a")
+	require.NoError(t, err)
+	err = DeleteTag(context.Background(), sys, ref)
+	require.NoError(t, err)
+	assert.Empty(t, r.tags)
+	assert.Empty(t, r.manifests)
+}
@@ -0,0 +1,46 @@
+package docker
+
+import (
+	"context"
+	"io"
+	"net/http"
+
+	"github.com/containers/image/v5/internal/distributionclient"
+	"github.com/containers/image/v5/types"
+	"github.com/pkg/errors"
+)
+
+func init() {
+	distributionclient.Register(newDistributionClient)
+}
+
+// distributionClient implements distributionclient.Client using a dockerClient.
+type distributionClient struct {
+	c *dockerClient
+}
+
+// newDistributionClient implements distributionclient.NewFunc.
+func newDistributionClient(sys *types.SystemContext, registry string) (distributionclient.Client, error) {
+	c, err := newRegistryClient(sys, registry, registry)
+	if err != nil {
+		return nil, err
+	}
+	return &distributionClient{c: c}, nil
+}
+
+// Do implements distributionclient.Client.
+func (d *distributionClient) Do(ctx context.Context, method, path string, headers map[string][]string, body io.Reader, scope *distributionclient.Scope) (*http.Response, error) {
+	var extraScope *authScope
+	if scope != nil {
+		extraScope = &authScope{remoteName: scope.Repository, actions: scope.Actions}
+	}
+	return d.c.makeRequest(ctx, method, path, headers, body, v2Auth, extraScope)
+}
+
+// ResponseToError implements distributionclient.Client.
+func (d *distributionClient) ResponseToError(res *http.Response, context string) error {
+	if res.StatusCode >= 200 && res.StatusCode <= 299 {
+		return nil
+	}
+	return errors.Wrap(registryHTTPResponseToError(res), context)
+}
@@ -15,8 +15,11 @@ import (
 	"sync"
 	"time"
 
+	"github.com/containers/image/v5/docker/internal/objectstore"
 	"github.com/containers/image/v5/docker/reference"
+	"github.com/containers/image/v5/internal/authcheck"
 	"github.com/containers/image/v5/internal/iolimits"
+	"github.com/containers/image/v5/internal/tracing"
 	"github.com/containers/image/v5/pkg/docker/config"
 	"github.com/containers/image/v5/pkg/sysregistriesv2"
 	"github.com/containers/image/v5/pkg/tlsclientconfig"
@@ -28,12 +31,13 @@ import (
 	digest "github.com/opencontainers/go-digest"
 	"github.com/pkg/errors"
 	"github.com/sirupsen/logrus"
+	"go.opentelemetry.io/otel/attribute"
 )
 
 const (
-	dockerHostname   = "docker.io"
-	dockerV1Hostname = "index.docker.io"
-	dockerRegistry   = "registry-1.docker.io"
+	dockerHostname   = reference.DockerHubDomain
+	dockerV1Hostname = reference.DockerHubLegacyDomain
+	dockerRegistry   = reference.DockerHubRegistry
 
 	resolvedPingV2URL       = "%s://%s/v2/"
 	resolvedPingV1URL       = "%s://%s/v1/_ping"
@@ -87,6 +91,7 @@ type bearerToken struct {
 	AccessToken    string    `json:"access_token"`
 	ExpiresIn      int       `json:"expires_in"`
 	IssuedAt       time.Time `json:"issued_at"`
+	RefreshToken   string    `json:"refresh_token"`
 	expirationTime time.Time
 }
 
@@ -96,15 +101,23 @@ type dockerClient struct {
 	sys       *types.SystemContext
 	registry  string
 	userAgent string
+	certDir   string       // The directory tlsClientConfig was set up from, if any
+	session   *Session     // The session from sys.DockerSession, if any
+	limiter   *rateLimiter // The limit on requests to registry, if any
 
 	// tlsClientConfig is setup by newDockerClient and will be used and updated
 	// by detectProperties(). Callers can edit tlsClientConfig.InsecureSkipVerify in the meantime.
 	tlsClientConfig *tls.Config
+	// insecureDigestOnly is set by newDockerClient if TLS verification is skipped only because of insecure-digest-only;
+	// no credentials are sent over such connections, see dropCredentials.
+	insecureDigestOnly bool
 	// The following members are not set by newDockerClient and must be set by callers if needed.
 	auth          types.DockerAuthConfig
 	registryToken string
 	signatureBase signatureStorageBase
 	scope         authScope
+	// additionalScopes are requested in every bearer token, in addition to scope; set by newDockerClient.
+	additionalScopes []authScope
 
 	// The following members are detected registry properties:
 	// They are set after a successful detectProperties(), and never change afterwards.
@@ -118,6 +131,23 @@ type dockerClient struct {
 	// Private state for detectProperties:
 	detectPropertiesOnce  sync.Once // detectPropertiesOnce is used to execute detectProperties() at most once.
 	detectPropertiesError error     // detectPropertiesError caches the initial error.
+	// Private state for addAuthenticationDuration:
+	authDurationLock sync.Mutex
+	authDuration     time.Duration // The total time spent looking up credentials and obtaining bearer tokens.
+	// Private state for recordTokenScopes and bearerTokenScopes:
+	tokenScopesLock      sync.Mutex
+	requestedTokenScopes map[string]struct{} // Scopes in the "repository:name:actions" format
+	// Private state for lookasideObjectStore:
+	objectStoreLock sync.Mutex
+	objectStore     objectstore.Store // Set on first use; all lookaside URLs of a client share the bucket and options of signatureBase.
+	// Private state for reportWarningHeaders (key: registryWarning, value: struct{}{})
+	reportedWarnings sync.Map
+	// Private state for rangeRequestSupport and setRangeRequestSupport:
+	rangeSupportLock sync.Mutex
+	rangeSupport     RangeRequestSupport
+	// Private state for reportReferrersAPIUnsupported and usedReferrersFallback:
+	referrersFallbackLock sync.Mutex
+	referrersFallback     bool // The referrers tag schema was used because the registry does not support the referrers API.
 }
 
 type authScope struct {
@@ -210,11 +240,20 @@ func dockerCertDir(sys *types.SystemContext, hostPort string) (string, error) {
 // newDockerClientFromRef returns a new dockerClient instance for refHostname (a host a specified in the Docker image reference, not canonicalized to dockerRegistry)
 // “write” specifies whether the client will be used for "write" access (in particular passed to lookaside.go:toplevelFromSection)
 // signatureBase is always set in the return value
-func newDockerClientFromRef(sys *types.SystemContext, ref dockerReference, write bool, actions string) (*dockerClient, error) {
-	auth, err := config.GetCredentialsForRef(sys, ref.ref)
+func newDockerClientFromRef(ctx context.Context, sys *types.SystemContext, ref dockerReference, write bool, actions string) (*dockerClient, error) {
+	credentialLookupStart := time.Now()
+	getCredentials := config.GetCredentialsForRefWithContext
+	if actionsRequireWriteAccess(actions) {
+		getCredentials = config.GetPushCredentialsForRefWithContext
+	}
+	spanCtx, span := tracing.Start(ctx, tracing.Provider(ctx), "registry credentials lookup",
+		attribute.String("registry", reference.Domain(ref.ref)))
+	auth, err := getCredentials(spanCtx, sys, ref.ref)
+	tracing.End(span, err)
 	if err != nil {
 		return nil, errors.Wrapf(err, "getting username and password")
 	}
+	credentialLookupDuration := time.Since(credentialLookupStart)
 
 	sigBase, err := SignatureStorageBaseURL(sys, ref, write)
 	if err != nil {
@@ -222,29 +261,48 @@ func newDockerClientFromRef(sys *types.SystemContext, ref dockerReference, write
 	}
 
 	registry := reference.Domain(ref.ref)
-	client, err := newDockerClient(sys, registry, ref.ref.Name())
+	client, err := newDockerClient(sys, registry, ref.ref.String())
 	if err != nil {
 		return nil, err
 	}
-	client.auth = auth
+	if err := client.setAuth(auth); err != nil {
+		return nil, err
+	}
+	client.addAuthenticationDuration(credentialLookupDuration)
 	if sys != nil {
 		client.registryToken = sys.DockerBearerRegistryToken
 	}
+	if client.insecureDigestOnly {
+		client.dropCredentials()
+	}
 	client.signatureBase = sigBase
 	client.scope.actions = actions
 	client.scope.remoteName = reference.Path(ref.ref)
 	return client, nil
 }
 
+// actionsRequireWriteAccess returns true if actions, a value of authScope.actions, includes write access,
+// so that credentials intended for writing should be used.
+func actionsRequireWriteAccess(actions string) bool {
+	for _, action := range strings.Split(actions, ",") {
+		if action == "push" || action == "delete" || action == "*" {
+			return true
+		}
+	}
+	return false
+}
+
 // newDockerClient returns a new dockerClient instance for the given registry
 // and reference.  The reference is used to query the registry configuration
 // and can either be a registry (e.g, "registry.com[:5000]"), a repository
-// (e.g., "registry.com[:5000][/some/namespace]/repo").
+// (e.g., "registry.com[:5000][/some/namespace]/repo"), or an image reference
+// (e.g., "registry.com[:5000][/some/namespace]/repo@sha256:…"); only image references
+// containing a digest may use a registry configured with insecure-digest-only.
 // Please note that newDockerClient does not set all members of dockerClient
 // (e.g., username and password); those must be set by callers if necessary.
-func newDockerClient(sys *types.SystemContext, registry, reference string) (*dockerClient, error) {
+func newDockerClient(sys *types.SystemContext, registry, ref string) (*dockerClient, error) {
 	hostName := registry
-	if registry == dockerHostname {
+	if registry == dockerHostname && !hubNormalizationDisabled(sys) {
 		registry = dockerRegistry
 	}
 	tlsClientConfig := serverDefault()
@@ -265,7 +323,8 @@ func newDockerClient(sys *types.SystemContext, registry, reference string) (*doc
 	// Check if TLS verification shall be skipped (default=false) which can
 	// be specified in the sysregistriesv2 configuration.
 	skipVerify := false
-	reg, err := sysregistriesv2.FindRegistry(sys, reference)
+	insecureDigestOnly := false
+	reg, err := sysregistriesv2.FindRegistry(sys, ref)
 	if err != nil {
 		return nil, errors.Wrapf(err, "loading registries")
 	}
@@ -274,6 +333,12 @@ func newDockerClient(sys *types.SystemContext, registry, reference string) (*doc
 			return nil, fmt.Errorf("registry %s is blocked in %s or %s", reg.Prefix, sysregistriesv2.ConfigPath(sys), sysregistriesv2.ConfigDirPath(sys))
 		}
 		skipVerify = reg.Insecure
+		if !skipVerify && reg.InsecureDigestOnly {
+			if named, err := reference.ParseNamed(ref); err == nil {
+				skipVerify = reg.InsecureFor(named)
+				insecureDigestOnly = skipVerify
+			}
+		}
 	}
 	tlsClientConfig.InsecureSkipVerify = skipVerify
 
@@ -282,14 +347,75 @@ func newDockerClient(sys *types.SystemContext, registry, reference string) (*doc
 		userAgent = sys.DockerRegistryUserAgent
 	}
 
+	session, err := sessionFromSystemContext(sys)
+	if err != nil {
+		return nil, err
+	}
+
+	// Like certDir above, look up the limits using the user-visible hostName.
+	limits, err := rateLimitForRegistry(sys, hostName)
+	if err != nil {
+		return nil, errors.Wrapf(err, "loading registries")
+	}
+
+	var additionalScopes []authScope
+	if sys != nil {
+		additionalScopes, err = parseTokenScopes(sys.DockerAdditionalTokenScopes)
+		if err != nil {
+			return nil, err
+		}
+	}
+
 	return &dockerClient{
-		sys:             sys,
-		registry:        registry,
-		userAgent:       userAgent,
-		tlsClientConfig: tlsClientConfig,
+		sys:                sys,
+		registry:           registry,
+		userAgent:          userAgent,
+		certDir:            certDir,
+		session:            session,
+		limiter:            sharedRateLimiter(registry, limits),
+		tlsClientConfig:    tlsClientConfig,
+		insecureDigestOnly: insecureDigestOnly,
+		additionalScopes:   additionalScopes,
 	}, nil
 }
 
+// setAuth sets the credentials used by c to auth, including the TLS client certificate, if any.
+func (c *dockerClient) setAuth(auth types.DockerAuthConfig) error {
+	c.auth = auth
+	if auth.ClientCertPath == "" && auth.ClientKeyPath == "" {
+		return nil
+	}
+	if auth.ClientCertPath == "" || auth.ClientKeyPath == "" {
+		return errors.Errorf("credentials for %s specify only one of a client certificate and a client key", c.registry)
+	}
+	cert, err := tls.LoadX509KeyPair(auth.ClientCertPath, auth.ClientKeyPath)
+	if err != nil {
+		return errors.Wrapf(err, "loading the client certificate for %s", c.registry)
+	}
+	// Prefer the certificate from the credentials over any found in the certificate directories.
+	c.tlsClientConfig.Certificates = append([]tls.Certificate{cert}, c.tlsClientConfig.Certificates...)
+	return nil
+}
+
+// dropCredentials removes all credentials set by setAuth, and the registry token, from c.
+// This is used for connections allowed only by insecure-digest-only: the image contents are verified
+// against the digest, but credentials could be observed, or obtained by an impostor server.
+func (c *dockerClient) dropCredentials() {
+	if c.auth.ClientCertPath != "" {
+		c.tlsClientConfig.Certificates = c.tlsClientConfig.Certificates[1:] // Added by setAuth.
+	}
+	if c.auth != (types.DockerAuthConfig{}) || c.registryToken != "" {
+		logrus.Debugf("Not using credentials for %s, which is accessed using insecure-digest-only", c.registry)
+	}
+	c.auth = types.DockerAuthConfig{}
+	c.registryToken = ""
+}
+
+// hubNormalizationDisabled returns true if sys disables special-casing docker.io.
+func hubNormalizationDisabled(sys *types.SystemContext) bool {
+	return sys != nil && sys.DockerDisableHubNormalization
+}
+
 // CheckAuth validates the credentials by attempting to log into the registry
 // returns an error if an error occurred while making the http request or the status code received was 401
 func CheckAuth(ctx context.Context, sys *types.SystemContext, username, password, registry string) error {
@@ -311,6 +437,63 @@ func CheckAuth(ctx context.Context, sys *types.SystemContext, username, password
 	return httpResponseToError(resp, "")
 }
 
+func init() {
+	authcheck.Register(checkAuthForConfig)
+}
+
+// checkAuthForConfig implements config.CheckAuth: it validates the credentials by pinging registry and,
+// if required, obtaining a token, and classifies failures as a *config.CheckAuthError.
+func checkAuthForConfig(ctx context.Context, sys *types.SystemContext, registry, username, password string) error {
+	authErr := func(reason config.CheckAuthErrorReason, err error) error {
+		return &config.CheckAuthError{Reason: reason, Registry: registry, Err: err}
+	}
+
+	client, err := newDockerClient(sys, registry, registry)
+	if err != nil {
+		return errors.Wrapf(err, "creating new docker client")
+	}
+	client.auth = types.DockerAuthConfig{
+		Username: username,
+		Password: password,
+	}
+
+	if err := client.detectProperties(ctx); err != nil {
+		return authErr(config.CheckAuthRegistryUnreachable, err)
+	}
+	if len(client.challenges) == 0 {
+		logrus.Debugf("Registry %s does not require authentication", registry)
+		return nil
+	}
+	supported := false
+	schemeNames := make([]string, 0, len(client.challenges))
+	for _, challenge := range client.challenges {
+		schemeNames = append(schemeNames, challenge.Scheme)
+		if challenge.Scheme == "basic" || challenge.Scheme == "bearer" {
+			supported = true
+		}
+	}
+	if !supported {
+		return authErr(config.CheckAuthUnsupportedScheme, errors.Errorf("registry offers only %s", strings.Join(schemeNames, ", ")))
+	}
+
+	resp, err := client.makeRequest(ctx, http.MethodGet, "/v2/", nil, nil, v2Auth, nil)
+	if err != nil {
+		if _, ok := errors.Cause(err).(ErrUnauthorizedForCredentials); ok {
+			return authErr(config.CheckAuthInvalidCredentials, err)
+		}
+		return authErr(config.CheckAuthRegistryUnreachable, err)
+	}
+	defer resp.Body.Close()
+	switch err := httpResponseToError(resp, ""); err.(type) {
+	case nil:
+		return nil
+	case ErrUnauthorizedForCredentials:
+		return authErr(config.CheckAuthInvalidCredentials, err)
+	default:
+		return authErr(config.CheckAuthRegistryUnreachable, err)
+	}
+}
+
 // SearchResult holds the information of each matching image
 // It matches the output returned by the v1 endpoint
 type SearchResult struct {
@@ -340,29 +523,30 @@ func SearchRegistry(ctx context.Context, sys *types.SystemContext, registry, ima
 	}
 	v1Res := &V1Results{}
 
-	// Get credentials from authfile for the underlying hostname
-	// We can't use GetCredentialsForRef here because we want to search the whole registry.
-	auth, err := config.GetCredentials(sys, registry)
-	if err != nil {
-		return nil, errors.Wrapf(err, "getting username and password")
-	}
-
 	// The /v2/_catalog endpoint has been disabled for docker.io therefore
 	// the call made to that endpoint will fail.  So using the v1 hostname
 	// for docker.io for simplicity of implementation and the fact that it
 	// returns search results.
 	hostname := registry
-	if registry == dockerHostname {
+	isDockerHub := registry == dockerHostname && !hubNormalizationDisabled(sys)
+	if isDockerHub {
 		hostname = dockerV1Hostname
 	}
 
-	client, err := newDockerClient(sys, hostname, registry)
+	client, err := newRegistryClient(sys, hostname, registry)
 	if err != nil {
-		return nil, errors.Wrapf(err, "creating new docker client")
+		return nil, err
 	}
-	client.auth = auth
-	if sys != nil {
-		client.registryToken = sys.DockerBearerRegistryToken
+
+	// Prefer a search API extension, if the registry advertises one; docker.io never does.
+	if image != "" && !isDockerHub {
+		res, ok, err := client.searchUsingExtensions(ctx, image, limit)
+		switch {
+		case err != nil:
+			logrus.Debugf("error searching %q using registry API extensions: %v", registry, err)
+		case ok:
+			return res, nil
+		}
 	}
 
 	// Only try the v1 search endpoint if the search query is not empty. If it is
@@ -461,7 +645,7 @@ func (c *dockerClient) makeRequest(ctx context.Context, method, path string, hea
 		return nil, err
 	}
 
-	urlString := fmt.Sprintf("%s://%s%s", c.scheme, c.registry, path)
+	urlString := fmt.Sprintf("%s://%s%s", c.scheme, registryURLHost(c.registry), path)
 	url, err := url.Parse(urlString)
 	if err != nil {
 		return nil, err
@@ -469,6 +653,12 @@ func (c *dockerClient) makeRequest(ctx context.Context, method, path string, hea
 	return c.makeRequestToResolvedURL(ctx, method, url, headers, stream, -1, auth, extraScope)
 }
 
+// registryURLHost returns registry, a host[:port] value, in the form used in URLs, i.e. with the zone identifier
+// of an IPv6 address literal (as in "[fe80::1%eth0]:5000"), if any, escaped as required by RFC 6874.
+func registryURLHost(registry string) string {
+	return strings.Replace(registry, "%", "%25", 1)
+}
+
 // parseRetryAfter determines the delay required by the "Retry-After" header in res and returns it,
 // silently falling back to fallbackDelay if the header is missing or invalid.
 func parseRetryAfter(res *http.Response, fallbackDelay time.Duration) time.Duration {
@@ -500,42 +690,81 @@ func parseRetryAfter(res *http.Response, fallbackDelay time.Duration) time.Durat
 // makeRequestToResolvedURL creates and executes a http.Request with the specified parameters, adding authentication and TLS options for the Docker client.
 // streamLen, if not -1, specifies the length of the data expected on stream.
 // makeRequest should generally be preferred.
-// In case of an HTTP 429 status code in the response, it may automatically retry a few times.
+// Requests which fail with a retryable status (by default, HTTP 429) are automatically retried according to c.sys.DockerRetryPolicy,
+// if stream is nil or can be rewound.
+// If c.sys.DockerRegistryMaintenanceRetryBudget is set, it also retries HTTP 503 responses during registry maintenance windows.
 // TODO(runcom): too many arguments here, use a struct
 func (c *dockerClient) makeRequestToResolvedURL(ctx context.Context, method string, url *url.URL, headers map[string][]string, stream io.Reader, streamLen int64, auth sendAuth, extraScope *authScope) (*http.Response, error) {
-	delay := backoffInitialDelay
+	policy := c.retryPolicy()
+	rewind, canRetry := rewindableStream(stream)
+	backoff := policy.initialDelay
 	attempts := 0
+	var maintenanceWaited time.Duration
 	for {
 		res, err := c.makeRequestToResolvedURLOnce(ctx, method, url, headers, stream, streamLen, auth, extraScope)
 		attempts++
-		if res == nil || res.StatusCode != http.StatusTooManyRequests || // Only retry on StatusTooManyRequests, success or other failure is returned to caller immediately
-			stream != nil || // We can't retry with a body (which is not restartable in the general case)
-			attempts == backoffNumIterations {
+		if res != nil && stream == nil {
+			if wait, ok := c.maintenanceRetryDelay(res, maintenanceWaited); ok {
+				res.Body.Close()
+				logrus.Debugf("Registry %s is in maintenance: sleeping for %f seconds before next attempt", c.registry, wait.Seconds())
+				if err := sleepForRetry(ctx, wait); err != nil {
+					return nil, err
+				}
+				maintenanceWaited += wait
+				continue
+			}
+		}
+		if !canRetry || !policy.shouldRetry(res, attempts) { // Success or other failure is returned to caller immediately
 			return res, err
 		}
+		if err := rewind(); err != nil {
+			logrus.Debugf("Error rewinding request body, not retrying: %v", err)
+			return res, nil
+		}
 		// close response body before retry or context done
 		res.Body.Close()
 
-		delay = parseRetryAfter(res, delay)
-		if delay > backoffMaxDelay {
-			delay = backoffMaxDelay
-		}
-		logrus.Debugf("Too many requests to %s: sleeping for %f seconds before next attempt", url.Redacted(), delay.Seconds())
-		select {
-		case <-ctx.Done():
-			return nil, ctx.Err()
-		case <-time.After(delay):
-			// Nothing
+		delay := policy.delay(res, backoff)
+		logrus.Debugf("Request to %s failed with status %d: sleeping for %f seconds before next attempt", url.Redacted(), res.StatusCode, delay.Seconds())
+		if err := sleepForRetry(ctx, delay); err != nil {
+			return nil, err
 		}
-		delay = delay * 2 // exponential back off
+		backoff *= 2 // exponential back off
 	}
 }
 
+// maintenanceRetryDelay returns the delay before retrying a request which received res, and true,
+// if res reports a registry maintenance window with a known duration, and waiting for it (after already
+// waiting for alreadyWaited) fits within c.sys.DockerRegistryMaintenanceRetryBudget.
+func (c *dockerClient) maintenanceRetryDelay(res *http.Response, alreadyWaited time.Duration) (time.Duration, bool) {
+	if c.sys == nil || c.sys.DockerRegistryMaintenanceRetryBudget <= 0 {
+		return 0, false
+	}
+	maintenance, ok := registryMaintenanceError(res)
+	if !ok || maintenance.RetryAfter <= 0 {
+		return 0, false
+	}
+	if alreadyWaited+maintenance.RetryAfter > c.sys.DockerRegistryMaintenanceRetryBudget {
+		logrus.Debugf("Registry %s is in maintenance for %s, exceeding the retry budget", c.registry, maintenance.RetryAfter)
+		return 0, false
+	}
+	return maintenance.RetryAfter, true
+}
+
 // makeRequestToResolvedURLOnce creates and executes a http.Request with the specified parameters, adding authentication and TLS options for the Docker client.
 // streamLen, if not -1, specifies the length of the data expected on stream.
 // makeRequest should generally be preferred.
 // Note that no exponential back off is performed when receiving an http 429 status code.
-func (c *dockerClient) makeRequestToResolvedURLOnce(ctx context.Context, method string, url *url.URL, headers map[string][]string, stream io.Reader, streamLen int64, auth sendAuth, extraScope *authScope) (*http.Response, error) {
+func (c *dockerClient) makeRequestToResolvedURLOnce(ctx context.Context, method string, url *url.URL, headers map[string][]string, stream io.Reader, streamLen int64, auth sendAuth, extraScope *authScope) (res *http.Response, retErr error) {
+	ctx, span := tracing.Start(ctx, tracing.Provider(ctx), "registry "+registryRequestKind(url.Path)+" "+method,
+		attribute.String("registry", c.registry), attribute.String("http.method", method))
+	defer func() {
+		if res != nil {
+			span.SetAttributes(attribute.Int("http.status_code", res.StatusCode))
+		}
+		tracing.End(span, retErr)
+	}()
+
 	req, err := http.NewRequestWithContext(ctx, method, url.String(), stream)
 	if err != nil {
 		return nil, err
@@ -556,13 +785,36 @@ func (c *dockerClient) makeRequestToResolvedURLOnce(ctx context.Context, method
 		}
 	}
 	logrus.Debugf("%s %s", method, url.Redacted())
-	res, err := c.client.Do(req)
+	res, err = c.client.Do(req)
 	if err != nil {
 		return nil, err
 	}
+	c.reportWarningHeaders(res)
 	return res, nil
 }
 
+// registryRequestKind returns a short description of the kind of a registry API request for path, for use in tracing span names.
+func registryRequestKind(path string) string {
+	switch {
+	case path == "/v2/" || path == "/v1/_ping":
+		return "ping"
+	case path == "/v2/_catalog":
+		return "catalog"
+	case strings.Contains(path, "/manifests/"):
+		return "manifest"
+	case strings.Contains(path, "/blobs/uploads/"):
+		return "blob upload"
+	case strings.Contains(path, "/blobs/"):
+		return "blob"
+	case strings.HasSuffix(path, "/tags/list"):
+		return "tags list"
+	case strings.Contains(path, "/referrers/"):
+		return "referrers"
+	default:
+		return "request"
+	}
+}
+
 // we're using the challenges from the /v2/ ping response and not the one from the destination
 // URL in this request because:
 //
@@ -584,34 +836,23 @@ func (c *dockerClient) setupRequestAuth(req *http.Request, extraScope *authScope
 		case "bearer":
 			registryToken := c.registryToken
 			if registryToken == "" {
-				cacheKey := ""
-				scopes := []authScope{c.scope}
-				if extraScope != nil {
-					// Using ':' as a separator here is unambiguous because getBearerToken below uses the same separator when formatting a remote request (and because repository names can't contain colons).
-					cacheKey = fmt.Sprintf("%s:%s", extraScope.remoteName, extraScope.actions)
-					scopes = append(scopes, *extraScope)
-				}
+				scopes, cacheKey := c.tokenScopes(extraScope)
 				var token bearerToken
 				t, inCache := c.tokenCache.Load(cacheKey)
 				if inCache {
 					token = t.(bearerToken)
 				}
 				if !inCache || time.Now().After(token.expirationTime) {
-					var (
-						t   *bearerToken
-						err error
-					)
-					if c.auth.IdentityToken != "" {
-						t, err = c.getBearerTokenOAuth2(req.Context(), challenge, scopes)
-					} else {
-						t, err = c.getBearerToken(req.Context(), challenge, scopes)
-					}
+					tokenStart := time.Now()
+					t, err := c.obtainBearerToken(req.Context(), challenge, scopes)
+					c.addAuthenticationDuration(time.Since(tokenStart))
 					if err != nil {
 						return err
 					}
 
-					token = *t
+					token = t
 					c.tokenCache.Store(cacheKey, token)
+					c.recordTokenScopes(scopes)
 				}
 				registryToken = token.Token
 			}
@@ -625,6 +866,20 @@ func (c *dockerClient) setupRequestAuth(req *http.Request, extraScope *authScope
 	return nil
 }
 
+// addAuthenticationDuration adds duration to the total time c has spent looking up credentials and obtaining bearer tokens.
+func (c *dockerClient) addAuthenticationDuration(duration time.Duration) {
+	c.authDurationLock.Lock()
+	defer c.authDurationLock.Unlock()
+	c.authDuration += duration
+}
+
+// authenticationDuration returns the total time c has spent looking up credentials and obtaining bearer tokens.
+func (c *dockerClient) authenticationDuration() time.Duration {
+	c.authDurationLock.Lock()
+	defer c.authDurationLock.Unlock()
+	return c.authDuration
+}
+
 func (c *dockerClient) getBearerTokenOAuth2(ctx context.Context, challenge challenge,
 	scopes []authScope) (*bearerToken, error) {
 	realm, ok := challenge.Parameters["realm"]
@@ -632,6 +887,18 @@ func (c *dockerClient) getBearerTokenOAuth2(ctx context.Context, challenge chall
 		return nil, errors.Errorf("missing realm in bearer auth challenge")
 	}
 
+	scopeStrings := []string{}
+	for _, scope := range scopes {
+		if scope.remoteName != "" && scope.actions != "" {
+			scopeStrings = append(scopeStrings, fmt.Sprintf("repository:%s:%s", scope.remoteName, scope.actions))
+		}
+	}
+	return c.getBearerTokenForRefreshToken(ctx, realm, challenge.Parameters["service"], scopeStrings, c.auth.IdentityToken)
+}
+
+// getBearerTokenForRefreshToken exchanges refreshToken for an access token for scopes (in the "repository:name:actions" format)
+// at the OAuth2 token endpoint realm, for service (if not "").
+func (c *dockerClient) getBearerTokenForRefreshToken(ctx context.Context, realm, service string, scopes []string, refreshToken string) (*bearerToken, error) {
 	authReq, err := http.NewRequestWithContext(ctx, http.MethodPost, realm, nil)
 	if err != nil {
 		return nil, err
@@ -640,23 +907,24 @@ func (c *dockerClient) getBearerTokenOAuth2(ctx context.Context, challenge chall
 	// Make the form data required against the oauth2 authentication
 	// More details here: https://docs.docker.com/registry/spec/auth/oauth/
 	params := authReq.URL.Query()
-	if service, ok := challenge.Parameters["service"]; ok && service != "" {
+	if service != "" {
 		params.Add("service", service)
 	}
 	for _, scope := range scopes {
-		if scope.remoteName != "" && scope.actions != "" {
-			params.Add("scope", fmt.Sprintf("repository:%s:%s", scope.remoteName, scope.actions))
-		}
+		params.Add("scope", scope)
 	}
 	params.Add("grant_type", "refresh_token")
-	params.Add("refresh_token", c.auth.IdentityToken)
+	params.Add("refresh_token", refreshToken)
 	params.Add("client_id", "containers/image")
 
-	authReq.Body = io.NopCloser(strings.NewReader(params.Encode()))
+	body := params.Encode()
 	authReq.Header.Add("User-Agent", c.userAgent)
 	authReq.Header.Add("Content-Type", "application/x-www-form-urlencoded")
-	logrus.Debugf("%s %s", authReq.Method, authReq.URL.Redacted())
-	res, err := c.client.Do(authReq)
+	res, err := c.doWithRetries(ctx, func() (*http.Request, error) {
+		req := authReq.Clone(ctx)
+		req.Body = io.NopCloser(strings.NewReader(body))
+		return req, nil
+	})
 	if err != nil {
 		return nil, err
 	}
@@ -707,8 +975,9 @@ func (c *dockerClient) getBearerToken(ctx context.Context, challenge challenge,
 	}
 	authReq.Header.Add("User-Agent", c.userAgent)
 
-	logrus.Debugf("%s %s", authReq.Method, authReq.URL.Redacted())
-	res, err := c.client.Do(authReq)
+	res, err := c.doWithRetries(ctx, func() (*http.Request, error) {
+		return authReq.Clone(ctx), nil
+	})
 	if err != nil {
 		return nil, err
 	}
@@ -724,6 +993,18 @@ func (c *dockerClient) getBearerToken(ctx context.Context, challenge challenge,
 	return newBearerTokenFromJSONBlob(tokenBlob)
 }
 
+// wrapRoundTripper returns the round tripper to use for c, based on the default rt, applying c.limiter and
+// as requested by SystemContext.DockerRoundTripperWrapper.
+func (c *dockerClient) wrapRoundTripper(rt http.RoundTripper) http.RoundTripper {
+	if c.limiter != nil {
+		rt = &rateLimitedRoundTripper{host: c.registry, limiter: c.limiter, next: rt}
+	}
+	if c.sys == nil || c.sys.DockerRoundTripperWrapper == nil {
+		return rt
+	}
+	return c.sys.DockerRoundTripperWrapper(c.registry, rt)
+}
+
 // detectPropertiesHelper performs the work of detectProperties which executes
 // it at most once.
 func (c *dockerClient) detectPropertiesHelper(ctx context.Context) error {
@@ -732,12 +1013,37 @@ func (c *dockerClient) detectPropertiesHelper(ctx context.Context) error {
 	if c.sys != nil && c.sys.DockerInsecureSkipTLSVerify != types.OptionalBoolUndefined {
 		c.tlsClientConfig.InsecureSkipVerify = c.sys.DockerInsecureSkipTLSVerify == types.OptionalBoolTrue
 	}
-	tr := tlsclientconfig.NewTransport()
-	tr.TLSClientConfig = c.tlsClientConfig
-	c.client = &http.Client{Transport: tr}
+	registryKey := sessionRegistryKey{registry: c.registry, insecureSkipVerify: c.tlsClientConfig.InsecureSkipVerify}
+	if c.session != nil {
+		tr, err := c.session.transport(sessionTransportKey{
+			registry:           c.registry,
+			insecureSkipVerify: c.tlsClientConfig.InsecureSkipVerify,
+			certDir:            c.certDir,
+			clientCertPath:     c.auth.ClientCertPath,
+			clientKeyPath:      c.auth.ClientKeyPath,
+			srvLookupHost:      srvLookupHost(c.sys, c.registry),
+		}, c.tlsClientConfig)
+		if err != nil {
+			return err
+		}
+		c.client = &http.Client{Transport: c.wrapRoundTripper(tr)}
+		if props, ok := c.session.registryProperties(registryKey); ok {
+			c.scheme = props.scheme
+			c.challenges = props.challenges
+			c.supportsSignatures = props.supportsSignatures
+			return nil
+		}
+	} else {
+		tr := tlsclientconfig.NewTransport()
+		tr.TLSClientConfig = c.tlsClientConfig
+		if host := srvLookupHost(c.sys, c.registry); host != "" {
+			useSRVLookup(tr, host)
+		}
+		c.client = &http.Client{Transport: c.wrapRoundTripper(tr)}
+	}
 
 	ping := func(scheme string) error {
-		url, err := url.Parse(fmt.Sprintf(resolvedPingV2URL, scheme, c.registry))
+		url, err := url.Parse(fmt.Sprintf(resolvedPingV2URL, scheme, registryURLHost(c.registry)))
 		if err != nil {
 			return err
 		}
@@ -760,6 +1066,13 @@ func (c *dockerClient) detectPropertiesHelper(ctx context.Context) error {
 	if err != nil && c.tlsClientConfig.InsecureSkipVerify {
 		err = ping("http")
 	}
+	if err == nil && c.session != nil {
+		c.session.setRegistryProperties(registryKey, registryProperties{
+			scheme:             c.scheme,
+			challenges:         c.challenges,
+			supportsSignatures: c.supportsSignatures,
+		})
+	}
 	if err != nil {
 		err = errors.Wrapf(err, "pinging container registry %s", c.registry)
 		if c.sys != nil && c.sys.DockerDisableV1Ping {
@@ -767,7 +1080,7 @@ func (c *dockerClient) detectPropertiesHelper(ctx context.Context) error {
 		}
 		// best effort to understand if we're talking to a V1 registry
 		pingV1 := func(scheme string) bool {
-			url, err := url.Parse(fmt.Sprintf(resolvedPingV1URL, scheme, c.registry))
+			url, err := url.Parse(fmt.Sprintf(resolvedPingV1URL, scheme, registryURLHost(c.registry)))
 			if err != nil {
 				return false
 			}
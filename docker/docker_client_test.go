@@ -2,17 +2,27 @@ package docker
 
 import (
 	"context"
+	"errors"
 	"fmt"
+	"io"
 	"net/http"
 	"net/http/httptest"
+	"net/url"
+	"os"
 	"path/filepath"
 	"strings"
 	"testing"
 	"time"
 
+	"github.com/containers/image/v5/internal/tracing"
+	"github.com/containers/image/v5/pkg/docker/config"
 	"github.com/containers/image/v5/types"
 	"github.com/stretchr/testify/assert"
 	"github.com/stretchr/testify/require"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/codes"
+	sdktrace "go.opentelemetry.io/otel/sdk/trace"
+	"go.opentelemetry.io/otel/sdk/trace/tracetest"
 )
 
 func TestDockerCertDir(t *testing.T) {
@@ -187,3 +197,354 @@ func TestUserAgent(t *testing.T) {
 		}
 	}
 }
+
+func TestCheckAuthForConfig(t *testing.T) {
+	var challenge string
+	s := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if challenge == "" {
+			w.WriteHeader(http.StatusOK)
+			return
+		}
+		if user, pass, ok := r.BasicAuth(); ok && user == "user" && pass == "pass" {
+			w.WriteHeader(http.StatusOK)
+			return
+		}
+		w.Header().Set("WWW-Authenticate", challenge)
+		w.WriteHeader(http.StatusUnauthorized)
+	}))
+	defer s.Close()
+	registry := strings.TrimPrefix(s.URL, "http://")
+	// For this test against localhost, we don't care.
+	sys := &types.SystemContext{DockerInsecureSkipTLSVerify: types.OptionalBoolTrue}
+
+	reasonOf := func(err error) config.CheckAuthErrorReason {
+		var authErr *config.CheckAuthError
+		require.True(t, errors.As(err, &authErr), "%v", err)
+		assert.Equal(t, registry, authErr.Registry)
+		return authErr.Reason
+	}
+
+	// No authentication required
+	challenge = ""
+	err := config.CheckAuth(context.Background(), sys, registry, "user", "wrong")
+	assert.NoError(t, err)
+
+	// Basic authentication
+	challenge = `Basic realm="test"`
+	err = config.CheckAuth(context.Background(), sys, registry+"/ns/repo", "user", "pass")
+	assert.NoError(t, err)
+	err = config.CheckAuth(context.Background(), sys, registry, "user", "wrong")
+	assert.Equal(t, config.CheckAuthInvalidCredentials, reasonOf(err))
+
+	// Unsupported scheme
+	challenge = `Negotiate`
+	err = config.CheckAuth(context.Background(), sys, registry, "user", "pass")
+	assert.Equal(t, config.CheckAuthUnsupportedScheme, reasonOf(err))
+
+	// Unreachable registry
+	s.Close()
+	err = config.CheckAuth(context.Background(), &types.SystemContext{DockerInsecureSkipTLSVerify: types.OptionalBoolTrue, DockerDisableV1Ping: true},
+		registry, "user", "pass")
+	assert.Equal(t, config.CheckAuthRegistryUnreachable, reasonOf(err))
+}
+
+func TestMakeRequestMaintenanceRetry(t *testing.T) {
+	var maintenanceResponses int
+	s := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path == "/v2/" || maintenanceResponses == 0 {
+			w.WriteHeader(http.StatusOK)
+			return
+		}
+		maintenanceResponses--
+		w.Header().Set("Retry-After", "1")
+		w.WriteHeader(http.StatusServiceUnavailable)
+		fmt.Fprint(w, "Registry is in read-only mode for maintenance")
+	}))
+	defer s.Close()
+	registry := strings.TrimPrefix(s.URL, "http://")
+
+	for _, c := range []struct {
+		budget       time.Duration
+		expectedCode int
+	}{
+		{0, http.StatusServiceUnavailable},                      // No retries by default
+		{500 * time.Millisecond, http.StatusServiceUnavailable}, // Retry-After exceeds the budget
+		{5 * time.Second, http.StatusOK},
+	} {
+		maintenanceResponses = 1
+		// For this test against localhost, we don't care.
+		sys := &types.SystemContext{DockerInsecureSkipTLSVerify: types.OptionalBoolTrue, DockerRegistryMaintenanceRetryBudget: c.budget}
+		client, err := newDockerClient(sys, registry, "")
+		require.NoError(t, err)
+		res, err := client.makeRequest(context.Background(), http.MethodGet, "/v2/repo/tags/list", nil, nil, noAuth, nil)
+		require.NoError(t, err)
+		assert.Equal(t, c.expectedCode, res.StatusCode, c.budget)
+		if res.StatusCode != http.StatusOK {
+			err := registryHTTPResponseToError(res)
+			var maintenance ErrRegistryMaintenance
+			require.True(t, errors.As(err, &maintenance), "%v", err)
+			assert.Equal(t, time.Second, maintenance.RetryAfter)
+			assert.Equal(t, "Registry is in read-only mode for maintenance", maintenance.Message)
+		}
+		res.Body.Close()
+	}
+}
+
+func TestSetAuthClientCertificate(t *testing.T) {
+	certDir, err := filepath.Abs(filepath.Join("..", "pkg", "tlsclientconfig", "testdata", "full"))
+	require.NoError(t, err)
+	authFile := filepath.Join(t.TempDir(), "auth.json")
+	err = os.WriteFile(authFile, []byte(fmt.Sprintf(`{"auths":{`+
+		`"cert.example.com":{"clientcert":%q,"clientkey":%q},`+
+		`"incomplete.example.com":{"clientcert":%q},`+
+		`"missing.example.com":{"clientcert":"/this/does/not/exist.cert","clientkey":"/this/does/not/exist.key"}}}`,
+		filepath.Join(certDir, "client-cert-1.cert"), filepath.Join(certDir, "client-cert-1.key"), filepath.Join(certDir, "client-cert-1.cert"))), 0600)
+	require.NoError(t, err)
+	sys := &types.SystemContext{
+		AuthFilePath:   authFile,
+		DockerCertPath: t.TempDir(),
+	}
+
+	client, err := newRegistryClient(sys, "cert.example.com", "cert.example.com")
+	require.NoError(t, err)
+	assert.Equal(t, filepath.Join(certDir, "client-cert-1.cert"), client.auth.ClientCertPath)
+	assert.Len(t, client.tlsClientConfig.Certificates, 1)
+
+	_, err = newRegistryClient(sys, "incomplete.example.com", "incomplete.example.com")
+	assert.Error(t, err)
+	_, err = newRegistryClient(sys, "missing.example.com", "missing.example.com")
+	assert.Error(t, err)
+
+	client, err = newRegistryClient(sys, "other.example.com", "other.example.com")
+	require.NoError(t, err)
+	assert.Empty(t, client.tlsClientConfig.Certificates)
+}
+
+func TestNewDockerClientFromRefInsecureDigestOnly(t *testing.T) {
+	confPath := filepath.Join(t.TempDir(), "registries.conf")
+	err := os.WriteFile(confPath, []byte("[[registry]]\nlocation = \"lab.example.com\"\ninsecure-digest-only = true\n\n"+
+		"[[registry]]\nlocation = \"insecure.example.com\"\ninsecure = true\n"), 0600)
+	require.NoError(t, err)
+	sys := &types.SystemContext{
+		SystemRegistriesConfPath:    confPath,
+		SystemRegistriesConfDirPath: "/this/does/not/exist",
+		DockerCertPath:              t.TempDir(),
+		DockerAuthConfig:            &types.DockerAuthConfig{Username: "user", Password: "pass"},
+		DockerBearerRegistryToken:   "token",
+	}
+	const dig = "@sha256:0123456789abcdef0123456789abcdef0123456789abcdef0123456789abcdef"
+
+	for _, c := range []struct {
+		ref             string
+		expected        bool
+		usesCredentials bool
+	}{
+		{"//lab.example.com/repo:tag", false, true},
+		{"//lab.example.com/repo" + dig, true, false},
+		{"//insecure.example.com/repo:tag", true, true},
+		{"//insecure.example.com/repo" + dig, true, true},
+		{"//secure.example.com/repo" + dig, false, true},
+	} {
+		ref, err := ParseReference(c.ref)
+		require.NoError(t, err, c.ref)
+		client, err := newDockerClientFromRef(context.Background(), sys, ref.(dockerReference), false, "pull")
+		require.NoError(t, err, c.ref)
+		assert.Equal(t, c.expected, client.tlsClientConfig.InsecureSkipVerify, c.ref)
+		if c.usesCredentials {
+			assert.Equal(t, "user", client.auth.Username, c.ref)
+			assert.Equal(t, "token", client.registryToken, c.ref)
+		} else {
+			assert.Equal(t, types.DockerAuthConfig{}, client.auth, c.ref)
+			assert.Equal(t, "", client.registryToken, c.ref)
+		}
+	}
+}
+
+func TestActionsRequireWriteAccess(t *testing.T) {
+	for _, c := range []struct {
+		actions  string
+		expected bool
+	}{
+		{"pull", false},
+		{"", false},
+		{"pull,push", true},
+		{"push", true},
+		{"*", true},
+		{"pull,delete", true},
+		{"pushy", false},
+	} {
+		assert.Equal(t, c.expected, actionsRequireWriteAccess(c.actions), c.actions)
+	}
+}
+
+// roundTripperFunc is a http.RoundTripper implemented by a function.
+type roundTripperFunc func(*http.Request) (*http.Response, error)
+
+func (f roundTripperFunc) RoundTrip(req *http.Request) (*http.Response, error) {
+	return f(req)
+}
+
+func TestDockerRoundTripperWrapper(t *testing.T) {
+	s := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		switch r.URL.Path {
+		case "/v2/":
+			w.WriteHeader(http.StatusOK)
+		case "/v2/repo/tags/list":
+			_, _ = w.Write([]byte(`{"name":"repo","tags":["tag"]}`))
+		default:
+			w.WriteHeader(http.StatusNotFound)
+		}
+	}))
+	defer s.Close()
+	registry := strings.TrimPrefix(s.URL, "http://")
+
+	// Wrapping the default round tripper
+	registries := []string{}
+	paths := []string{}
+	sys := &types.SystemContext{
+		DockerInsecureSkipTLSVerify: types.OptionalBoolTrue,
+		DockerRoundTripperWrapper: func(registry string, rt http.RoundTripper) http.RoundTripper {
+			registries = append(registries, registry)
+			return roundTripperFunc(func(req *http.Request) (*http.Response, error) {
+				paths = append(paths, req.URL.Scheme+" "+req.URL.Path)
+				return rt.RoundTrip(req)
+			})
+		},
+	}
+	ref, err := ParseReference("//" + registry + "/repo:tag")
+	require.NoError(t, err)
+	tags, err := GetRepositoryTags(context.Background(), sys, ref)
+	require.NoError(t, err)
+	assert.Equal(t, []string{"tag"}, tags)
+	assert.Equal(t, []string{registry}, registries)
+	assert.Equal(t, []string{"https /v2/", "http /v2/", "http /v2/repo/tags/list"}, paths)
+
+	// Replacing the round tripper entirely, without any server
+	sys = &types.SystemContext{
+		DockerRoundTripperWrapper: func(registry string, rt http.RoundTripper) http.RoundTripper {
+			return roundTripperFunc(func(req *http.Request) (*http.Response, error) {
+				res := &http.Response{StatusCode: http.StatusOK, Header: http.Header{}, Request: req,
+					Body: io.NopCloser(strings.NewReader(""))}
+				switch {
+				case req.URL.Host != "registry.test":
+					res.StatusCode = http.StatusNotFound
+				case req.URL.Path == "/v2/repo/tags/list":
+					res.Body = io.NopCloser(strings.NewReader(`{"name":"repo","tags":["replayed"]}`))
+				case req.URL.Path != "/v2/":
+					res.StatusCode = http.StatusNotFound
+				}
+				return res, nil
+			})
+		},
+	}
+	ref, err = ParseReference("//registry.test/repo:tag")
+	require.NoError(t, err)
+	tags, err = GetRepositoryTags(context.Background(), sys, ref)
+	require.NoError(t, err)
+	assert.Equal(t, []string{"replayed"}, tags)
+}
+
+func TestNewDockerClientDisableHubNormalization(t *testing.T) {
+	sys := &types.SystemContext{
+		SystemRegistriesConfPath:    "/dev/null",
+		SystemRegistriesConfDirPath: "/dev/null",
+	}
+	client, err := newDockerClient(sys, dockerHostname, dockerHostname)
+	require.NoError(t, err)
+	assert.Equal(t, dockerRegistry, client.registry)
+
+	sys.DockerDisableHubNormalization = true
+	client, err = newDockerClient(sys, dockerHostname, dockerHostname)
+	require.NoError(t, err)
+	assert.Equal(t, dockerHostname, client.registry)
+	client, err = newDockerClient(sys, "registry.example.org", "registry.example.org")
+	require.NoError(t, err)
+	assert.Equal(t, "registry.example.org", client.registry)
+}
+
+func TestRegistryRequestKind(t *testing.T) {
+	for _, c := range []struct{ path, expected string }{
+		{"/v2/", "ping"},
+		{"/v1/_ping", "ping"},
+		{"/v2/_catalog", "catalog"},
+		{"/v2/ns/repo/manifests/latest", "manifest"},
+		{"/v2/ns/repo/blobs/sha256:0123", "blob"},
+		{"/v2/ns/repo/blobs/uploads/", "blob upload"},
+		{"/v2/ns/repo/blobs/uploads/uuid", "blob upload"},
+		{"/v2/ns/repo/tags/list", "tags list"},
+		{"/v2/ns/repo/referrers/sha256:0123", "referrers"},
+		{"/v2/ns/repo/other", "request"},
+	} {
+		assert.Equal(t, c.expected, registryRequestKind(c.path), c.path)
+	}
+}
+
+func TestDockerClientTracing(t *testing.T) {
+	var s *httptest.Server
+	s = httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		switch {
+		case r.URL.Path == "/token":
+			_, _ = w.Write([]byte(`{"token":"token"}`))
+		case r.Header.Get("Authorization") != "Bearer token":
+			w.Header().Set("WWW-Authenticate", fmt.Sprintf(`Bearer realm="%s/token",service="registry"`, s.URL))
+			w.WriteHeader(http.StatusUnauthorized)
+		case r.URL.Path == "/v2/repo/tags/list":
+			_, _ = w.Write([]byte(`{"name":"repo","tags":["tag"]}`))
+		default:
+			w.WriteHeader(http.StatusNotFound)
+		}
+	}))
+	defer s.Close()
+	registry := strings.TrimPrefix(s.URL, "http://")
+	ref, err := ParseReference("//" + registry + "/repo:tag")
+	require.NoError(t, err)
+
+	// Tracing is disabled by default.
+	recorder := tracetest.NewSpanRecorder()
+	tp := sdktrace.NewTracerProvider(sdktrace.WithSpanProcessor(recorder))
+	ctx, parent := tp.Tracer("test").Start(context.Background(), "parent")
+	sys := &types.SystemContext{DockerInsecureSkipTLSVerify: types.OptionalBoolTrue, DockerSharedTokenCache: types.OptionalBoolFalse}
+	_, err = GetRepositoryTags(ctx, sys, ref)
+	require.NoError(t, err)
+	parent.End()
+	require.Len(t, recorder.Ended(), 1)
+
+	recorder = tracetest.NewSpanRecorder()
+	tp = sdktrace.NewTracerProvider(sdktrace.WithSpanProcessor(recorder))
+	ctx, parent = tp.Tracer("test").Start(tracing.WithProvider(context.Background(), tp), "parent")
+	tags, err := GetRepositoryTags(ctx, sys, ref)
+	require.NoError(t, err)
+	assert.Equal(t, []string{"tag"}, tags)
+	parent.End()
+
+	spans := recorder.Ended()
+	names := []string{}
+	for _, span := range spans {
+		names = append(names, span.Name())
+	}
+	assert.Equal(t, []string{"registry credentials lookup", "registry ping GET", "registry ping GET", "registry token fetch", "registry tags list GET", "parent"}, names)
+	parentID := spans[5].SpanContext().SpanID()
+	for _, i := range []int{0, 1, 2, 4} {
+		assert.Equal(t, parentID, spans[i].Parent().SpanID(), names[i])
+	}
+	assert.Equal(t, spans[4].SpanContext().SpanID(), spans[3].Parent().SpanID()) // The token is obtained for the tags list request
+	assert.Equal(t, codes.Error, spans[1].Status().Code)                         // The HTTPS ping fails
+	assert.Contains(t, spans[2].Attributes(), attribute.Int("http.status_code", http.StatusUnauthorized))
+	assert.Contains(t, spans[4].Attributes(), attribute.Int("http.status_code", http.StatusOK))
+	assert.Contains(t, spans[4].Attributes(), attribute.String("registry", registry))
+}
+
+func TestRegistryURLHost(t *testing.T) {
+	for _, c := range []struct{ registry, expected string }{
+		{"registry.example.com", "registry.example.com"},
+		{"registry.example.com:5000", "registry.example.com:5000"},
+		{"[2001:db8::1]:5000", "[2001:db8::1]:5000"},
+		{"[fe80::1%eth0]:5000", "[fe80::1%25eth0]:5000"},
+	} {
+		host := registryURLHost(c.registry)
+		assert.Equal(t, c.expected, host, c.registry)
+		u, err := url.Parse("https://" + host + "/v2/")
+		require.NoError(t, err, c.registry)
+		assert.Equal(t, c.registry, u.Host, c.registry)
+	}
+}
@@ -2,11 +2,8 @@ package docker
 
 import (
 	"context"
-	"encoding/json"
 	"fmt"
 	"net/http"
-	"net/url"
-	"strings"
 
 	"github.com/containers/image/v5/docker/reference"
 	"github.com/containers/image/v5/image"
@@ -53,56 +50,15 @@ func (i *Image) GetRepositoryTags(ctx context.Context) ([]string, error) {
 
 // GetRepositoryTags list all tags available in the repository. The tag
 // provided inside the ImageReference will be ignored.
+// See ListRepositoryTags for more control over the listing.
 func GetRepositoryTags(ctx context.Context, sys *types.SystemContext, ref types.ImageReference) ([]string, error) {
-	dr, ok := ref.(dockerReference)
-	if !ok {
-		return nil, errors.Errorf("ref must be a dockerReference")
-	}
-
-	path := fmt.Sprintf(tagsPath, reference.Path(dr.ref))
-	client, err := newDockerClientFromRef(sys, dr, false, "pull")
+	list, err := ListRepositoryTags(ctx, sys, ref, nil)
 	if err != nil {
-		return nil, errors.Wrap(err, "failed to create client")
+		return nil, err
 	}
-
-	tags := make([]string, 0)
-
-	for {
-		res, err := client.makeRequest(ctx, http.MethodGet, path, nil, nil, v2Auth, nil)
-		if err != nil {
-			return nil, err
-		}
-		defer res.Body.Close()
-		if err := httpResponseToError(res, "fetching tags list"); err != nil {
-			return nil, err
-		}
-
-		var tagsHolder struct {
-			Tags []string
-		}
-		if err = json.NewDecoder(res.Body).Decode(&tagsHolder); err != nil {
-			return nil, err
-		}
-		tags = append(tags, tagsHolder.Tags...)
-
-		link := res.Header.Get("Link")
-		if link == "" {
-			break
-		}
-
-		linkURLStr := strings.Trim(strings.Split(link, ";")[0], "<>")
-		linkURL, err := url.Parse(linkURLStr)
-		if err != nil {
-			return tags, err
-		}
-
-		// can be relative or absolute, but we only want the path (and I
-		// guess we're in trouble if it forwards to a new place...)
-		path = linkURL.Path
-		if linkURL.RawQuery != "" {
-			path += "?"
-			path += linkURL.RawQuery
-		}
+	tags := make([]string, 0, len(list.Tags))
+	for _, tag := range list.Tags {
+		tags = append(tags, tag.Name)
 	}
 	return tags, nil
 }
@@ -124,24 +80,29 @@ func GetDigest(ctx context.Context, sys *types.SystemContext, ref types.ImageRef
 		return "", err
 	}
 
-	client, err := newDockerClientFromRef(sys, dr, false, "pull")
+	client, err := newDockerClientFromRef(ctx, sys, dr, false, "pull")
 	if err != nil {
 		return "", errors.Wrap(err, "failed to create client")
 	}
 
-	path := fmt.Sprintf(manifestPath, reference.Path(dr.ref), tagOrDigest)
+	return client.getManifestDigest(ctx, dr.ref, tagOrDigest)
+}
+
+// getManifestDigest returns the digest of the manifest of tagOrDigest in repo, using a HEAD request.
+func (c *dockerClient) getManifestDigest(ctx context.Context, repo reference.Named, tagOrDigest string) (digest.Digest, error) {
+	path := fmt.Sprintf(manifestPath, reference.Path(repo), tagOrDigest)
 	headers := map[string][]string{
 		"Accept": manifest.DefaultRequestedManifestMIMETypes,
 	}
 
-	res, err := client.makeRequest(ctx, http.MethodHead, path, headers, nil, v2Auth, nil)
+	res, err := c.makeRequest(ctx, http.MethodHead, path, headers, nil, v2Auth, nil)
 	if err != nil {
 		return "", err
 	}
 
 	defer res.Body.Close()
 	if res.StatusCode != http.StatusOK {
-		return "", errors.Wrapf(registryHTTPResponseToError(res), "reading digest %s in %s", tagOrDigest, dr.ref.Name())
+		return "", errors.Wrapf(manifestHTTPResponseToError(res), "reading digest %s in %s", tagOrDigest, repo.Name())
 	}
 
 	dig, err := digest.Parse(res.Header.Get("Docker-Content-Digest"))
@@ -12,7 +12,10 @@ import (
 	"os"
 	"path/filepath"
 	"strings"
+	"sync"
+	"time"
 
+	"github.com/containers/image/v5/docker/internal/objectstore"
 	"github.com/containers/image/v5/docker/reference"
 	"github.com/containers/image/v5/internal/blobinfocache"
 	"github.com/containers/image/v5/internal/putblobdigest"
@@ -32,12 +35,14 @@ type dockerImageDestination struct {
 	ref dockerReference
 	c   *dockerClient
 	// State
-	manifestDigest digest.Digest // or "" if not yet known.
+	manifestDigest     digest.Digest              // or "" if not yet known.
+	uploadSessionsLock sync.Mutex                 // Protects uploadSessions
+	uploadSessions     map[digest.Digest]*url.URL // Upload sessions of blobs with known digests, see uploadSessionRecord
 }
 
 // newImageDestination creates a new ImageDestination for the specified image reference.
-func newImageDestination(sys *types.SystemContext, ref dockerReference) (types.ImageDestination, error) {
-	c, err := newDockerClientFromRef(sys, ref, true, "pull,push")
+func newImageDestination(ctx context.Context, sys *types.SystemContext, ref dockerReference) (types.ImageDestination, error) {
+	c, err := newDockerClientFromRef(ctx, sys, ref, true, "pull,push")
 	if err != nil {
 		return nil, err
 	}
@@ -53,6 +58,17 @@ func (d *dockerImageDestination) Reference() types.ImageReference {
 	return d.ref
 }
 
+// AuthenticationDuration returns the total time spent so far looking up credentials and obtaining bearer tokens.
+func (d *dockerImageDestination) AuthenticationDuration() time.Duration {
+	return d.c.authenticationDuration()
+}
+
+// BearerTokenScopes returns the scopes, in the "repository:name:actions" format, requested in bearer tokens used
+// so far to access the registry, for debugging.
+func (d *dockerImageDestination) BearerTokenScopes() []string {
+	return d.c.bearerTokenScopes()
+}
+
 // Close removes resources associated with an initialized ImageDestination, if any.
 func (d *dockerImageDestination) Close() error {
 	return nil
@@ -155,47 +171,19 @@ func (d *dockerImageDestination) PutBlob(ctx context.Context, stream io.Reader,
 		}
 	}
 
-	// FIXME? Chunked upload, progress reporting, etc.
-	uploadPath := fmt.Sprintf(blobUploadPath, reference.Path(d.ref.ref))
-	logrus.Debugf("Uploading %s", uploadPath)
-	res, err := d.c.makeRequest(ctx, http.MethodPost, uploadPath, nil, nil, v2Auth, nil)
-	if err != nil {
-		return types.BlobInfo{}, err
-	}
-	defer res.Body.Close()
-	if res.StatusCode != http.StatusAccepted {
-		logrus.Debugf("Error initiating layer upload, response %#v", *res)
-		return types.BlobInfo{}, errors.Wrapf(registryHTTPResponseToError(res), "initiating layer upload to %s in %s", uploadPath, d.c.registry)
-	}
-	uploadLocation, err := res.Location()
-	if err != nil {
-		return types.BlobInfo{}, errors.Wrap(err, "determining upload URL")
-	}
-
 	digester, stream := putblobdigest.DigestIfCanonicalUnknown(stream, inputInfo)
 	sizeCounter := &sizeCounter{}
 	stream = io.TeeReader(stream, sizeCounter)
 
-	uploadLocation, err = func() (*url.URL, error) { // A scope for defer
-		uploadReader := uploadreader.NewUploadReader(stream)
-		// This error text should never be user-visible, we terminate only after makeRequestToResolvedURL
-		// returns, so there isn’t a way for the error text to be provided to any of our callers.
-		defer uploadReader.Terminate(errors.New("Reading data from an already terminated upload"))
-		res, err = d.c.makeRequestToResolvedURL(ctx, http.MethodPatch, uploadLocation, map[string][]string{"Content-Type": {"application/octet-stream"}}, uploadReader, inputInfo.Size, v2Auth, nil)
-		if err != nil {
-			logrus.Debugf("Error uploading layer chunked %v", err)
-			return nil, err
-		}
-		defer res.Body.Close()
-		if !successStatus(res.StatusCode) {
-			return nil, errors.Wrapf(registryHTTPResponseToError(res), "uploading layer chunked")
-		}
-		uploadLocation, err := res.Location()
-		if err != nil {
-			return nil, errors.Wrap(err, "determining upload URL")
-		}
-		return uploadLocation, nil
-	}()
+	var uploadLocation *url.URL
+	var err error
+	session := uploadSessionRecord{} // Records nothing
+	if d.c.sys != nil && d.c.sys.DockerChunkedUploadSize > 0 {
+		session = d.uploadSessionRecord(inputInfo.Digest)
+		uploadLocation, err = d.uploadChunked(ctx, stream, session, d.c.sys.DockerChunkedUploadSize)
+	} else {
+		uploadLocation, err = d.uploadMonolithic(ctx, stream, inputInfo.Size)
+	}
 	if err != nil {
 		return types.BlobInfo{}, err
 	}
@@ -206,7 +194,7 @@ func (d *dockerImageDestination) PutBlob(ctx context.Context, stream io.Reader,
 	locationQuery := uploadLocation.Query()
 	locationQuery.Set("digest", blobDigest.String())
 	uploadLocation.RawQuery = locationQuery.Encode()
-	res, err = d.c.makeRequestToResolvedURL(ctx, http.MethodPut, uploadLocation, map[string][]string{"Content-Type": {"application/octet-stream"}}, nil, -1, v2Auth, nil)
+	res, err := d.c.makeRequestToResolvedURL(ctx, http.MethodPut, uploadLocation, map[string][]string{"Content-Type": {"application/octet-stream"}}, nil, -1, v2Auth, nil)
 	if err != nil {
 		return types.BlobInfo{}, err
 	}
@@ -215,12 +203,60 @@ func (d *dockerImageDestination) PutBlob(ctx context.Context, stream io.Reader,
 		logrus.Debugf("Error uploading layer, response %#v", *res)
 		return types.BlobInfo{}, errors.Wrapf(registryHTTPResponseToError(res), "uploading layer to %s", uploadLocation)
 	}
+	session.remove()
 
 	logrus.Debugf("Upload of layer %s complete", blobDigest)
 	cache.RecordKnownLocation(d.ref.Transport(), bicTransportScope(d.ref), blobDigest, newBICLocationReference(d.ref))
 	return types.BlobInfo{Digest: blobDigest, Size: sizeCounter.size}, nil
 }
 
+// startUpload initiates a blob upload, and returns the location of the upload session.
+func (d *dockerImageDestination) startUpload(ctx context.Context) (*url.URL, error) {
+	uploadPath := fmt.Sprintf(blobUploadPath, reference.Path(d.ref.ref))
+	logrus.Debugf("Uploading %s", uploadPath)
+	res, err := d.c.makeRequest(ctx, http.MethodPost, uploadPath, nil, nil, v2Auth, nil)
+	if err != nil {
+		return nil, err
+	}
+	defer res.Body.Close()
+	if res.StatusCode != http.StatusAccepted {
+		logrus.Debugf("Error initiating layer upload, response %#v", *res)
+		return nil, errors.Wrapf(registryHTTPResponseToError(res), "initiating layer upload to %s in %s", uploadPath, d.c.registry)
+	}
+	uploadLocation, err := res.Location()
+	if err != nil {
+		return nil, errors.Wrap(err, "determining upload URL")
+	}
+	return uploadLocation, nil
+}
+
+// uploadMonolithic uploads stream, with size (or -1 if unknown), in a single request to a new upload session,
+// and returns the location to use to finish the upload.
+func (d *dockerImageDestination) uploadMonolithic(ctx context.Context, stream io.Reader, size int64) (*url.URL, error) {
+	uploadLocation, err := d.startUpload(ctx)
+	if err != nil {
+		return nil, err
+	}
+	uploadReader := uploadreader.NewUploadReader(stream)
+	// This error text should never be user-visible, we terminate only after makeRequestToResolvedURL
+	// returns, so there isn’t a way for the error text to be provided to any of our callers.
+	defer uploadReader.Terminate(errors.New("Reading data from an already terminated upload"))
+	res, err := d.c.makeRequestToResolvedURL(ctx, http.MethodPatch, uploadLocation, map[string][]string{"Content-Type": {"application/octet-stream"}}, uploadReader, size, v2Auth, nil)
+	if err != nil {
+		logrus.Debugf("Error uploading layer chunked %v", err)
+		return nil, err
+	}
+	defer res.Body.Close()
+	if !successStatus(res.StatusCode) {
+		return nil, errors.Wrapf(registryHTTPResponseToError(res), "uploading layer chunked")
+	}
+	uploadLocation, err = res.Location()
+	if err != nil {
+		return nil, errors.Wrap(err, "determining upload URL")
+	}
+	return uploadLocation, nil
+}
+
 // blobExists returns true iff repo contains a blob with digest, and if so, also its size.
 // If the destination does not contain the blob, or it is unknown, blobExists ordinarily returns (false, -1, nil);
 // it returns a non-nil error only on an unexpected failure.
@@ -442,25 +478,49 @@ func (d *dockerImageDestination) PutManifest(ctx context.Context, m []byte, inst
 		}
 	}
 
-	path := fmt.Sprintf(manifestPath, reference.Path(d.ref.ref), refTail)
+	header, err := d.uploadManifest(ctx, m, refTail)
+	if err != nil {
+		return err
+	}
+	return d.updateReferrersFallback(ctx, m, header)
+}
+
+// PutManifestTag writes manifest, which has already been written using PutManifest (with a nil instanceDigest),
+// under tag in the same repository.
+func (d *dockerImageDestination) PutManifestTag(ctx context.Context, m []byte, tag string) error {
+	if _, err := reference.WithTag(reference.TrimNamed(d.ref.ref), tag); err != nil {
+		return err
+	}
+	_, err := d.uploadManifest(ctx, m, tag)
+	return err
+}
+
+// uploadManifest writes m to the repository of d.ref, under refTail (a tag or digest), and returns the headers of the response.
+func (d *dockerImageDestination) uploadManifest(ctx context.Context, m []byte, refTail string) (http.Header, error) {
+	return d.c.uploadManifest(ctx, d.ref.ref, m, refTail)
+}
+
+// uploadManifest writes m to repo, under refTail (a tag or digest), and returns the headers of the response.
+func (c *dockerClient) uploadManifest(ctx context.Context, repo reference.Named, m []byte, refTail string) (http.Header, error) {
+	path := fmt.Sprintf(manifestPath, reference.Path(repo), refTail)
 
 	headers := map[string][]string{}
 	mimeType := manifest.GuessMIMEType(m)
 	if mimeType != "" {
 		headers["Content-Type"] = []string{mimeType}
 	}
-	res, err := d.c.makeRequest(ctx, http.MethodPut, path, headers, bytes.NewReader(m), v2Auth, nil)
+	res, err := c.makeRequest(ctx, http.MethodPut, path, headers, bytes.NewReader(m), v2Auth, nil)
 	if err != nil {
-		return err
+		return nil, err
 	}
 	defer res.Body.Close()
 	if !successStatus(res.StatusCode) {
 		rawErr := registryHTTPResponseToError(res)
-		err := errors.Wrapf(rawErr, "uploading manifest %s to %s", refTail, d.ref.ref.Name())
+		err := errors.Wrapf(rawErr, "uploading manifest %s to %s", refTail, repo.Name())
 		if isManifestInvalidError(rawErr) {
 			err = types.ManifestTypeRejectedError{Err: err}
 		}
-		return err
+		return nil, err
 	}
 	// A HTTP server may not be a registry at all, and just return 200 OK to everything
 	// (in particular that can fairly easily happen after tearing down a website and
@@ -474,7 +534,7 @@ func (d *dockerImageDestination) PutManifest(ctx context.Context, m []byte, inst
 	if v := res.Header.Values("Docker-Content-Digest"); len(v) == 0 {
 		logrus.Debugf("Manifest upload response didn’t contain a Docker-Content-Digest header, it might not be a container registry")
 	}
-	return nil
+	return res.Header, nil
 }
 
 // successStatus returns true if the argument is a successful HTTP response
@@ -483,19 +543,18 @@ func successStatus(status int) bool {
 	return status >= 200 && status <= 399
 }
 
-// isManifestInvalidError returns true iff err from client.HandleErrorResponse is a “manifest invalid” error.
+// isManifestInvalidError returns true iff err from registryHTTPResponseToError is a “manifest invalid” error.
 func isManifestInvalidError(err error) bool {
-	errors, ok := err.(errcode.Errors)
-	if !ok || len(errors) == 0 {
+	var regErr RegistryError
+	if !errors.As(err, &regErr) {
 		return false
 	}
-	err = errors[0]
-	ec, ok := err.(errcode.ErrorCoder)
+	code, ok := registryErrorCode(regErr.Err)
 	if !ok {
 		return false
 	}
 
-	switch ec.ErrorCode() {
+	switch code {
 	// ErrorCodeManifestInvalid is returned by OpenShift with acceptschema2=false.
 	case v2.ErrorCodeManifestInvalid:
 		return true
@@ -508,7 +567,7 @@ func isManifestInvalidError(err error) bool {
 	// a top-level media type. See libpod issue #1719
 	// FIXME: remove this case when ECR behavior is fixed
 	case errcode.ErrorCodeUnsupported:
-		return strings.Contains(err.Error(), "Invalid JSON syntax")
+		return strings.Contains(regErr.Error(), "Invalid JSON syntax")
 	default:
 		return false
 	}
@@ -537,7 +596,7 @@ func (d *dockerImageDestination) PutSignatures(ctx context.Context, signatures [
 	case d.c.supportsSignatures:
 		return d.putSignaturesToAPIExtension(ctx, signatures, *instanceDigest)
 	case d.c.signatureBase != nil:
-		return d.putSignaturesToLookaside(signatures, *instanceDigest)
+		return d.putSignaturesToLookaside(ctx, signatures, *instanceDigest)
 	default:
 		return errors.Errorf("Internal error: X-Registry-Supports-Signatures extension not supported, and lookaside should not be empty configuration")
 	}
@@ -545,7 +604,7 @@ func (d *dockerImageDestination) PutSignatures(ctx context.Context, signatures [
 
 // putSignaturesToLookaside implements PutSignatures() from the lookaside location configured in s.c.signatureBase,
 // which is not nil, for a manifest with manifestDigest.
-func (d *dockerImageDestination) putSignaturesToLookaside(signatures [][]byte, manifestDigest digest.Digest) error {
+func (d *dockerImageDestination) putSignaturesToLookaside(ctx context.Context, signatures [][]byte, manifestDigest digest.Digest) error {
 	// FIXME? This overwrites files one at a time, definitely not atomic.
 	// A failure when updating signatures with a reordered copy could lose some of them.
 
@@ -557,7 +616,7 @@ func (d *dockerImageDestination) putSignaturesToLookaside(signatures [][]byte, m
 	// NOTE: Keep this in sync with docs/signature-protocols.md!
 	for i, signature := range signatures {
 		url := signatureStorageURL(d.c.signatureBase, manifestDigest, i)
-		err := d.putOneSignature(url, signature)
+		err := d.putOneSignature(ctx, url, signature)
 		if err != nil {
 			return err
 		}
@@ -569,7 +628,7 @@ func (d *dockerImageDestination) putSignaturesToLookaside(signatures [][]byte, m
 	// is sufficient.
 	for i := len(signatures); ; i++ {
 		url := signatureStorageURL(d.c.signatureBase, manifestDigest, i)
-		missing, err := d.c.deleteOneSignature(url)
+		missing, err := d.c.deleteOneSignature(ctx, url)
 		if err != nil {
 			return err
 		}
@@ -583,7 +642,7 @@ func (d *dockerImageDestination) putSignaturesToLookaside(signatures [][]byte, m
 
 // putOneSignature stores one signature to url.
 // NOTE: Keep this in sync with docs/signature-protocols.md!
-func (d *dockerImageDestination) putOneSignature(url *url.URL, signature []byte) error {
+func (d *dockerImageDestination) putOneSignature(ctx context.Context, url *url.URL, signature []byte) error {
 	switch url.Scheme {
 	case "file":
 		logrus.Debugf("Writing to %s", url.Path)
@@ -599,6 +658,15 @@ func (d *dockerImageDestination) putOneSignature(url *url.URL, signature []byte)
 
 	case "http", "https":
 		return errors.Errorf("Writing directly to a %s sigstore %s is not supported. Configure a sigstore-staging: location", url.Scheme, url.Redacted())
+	case "s3", "gs", "azblob":
+		store, err := d.c.lookasideObjectStore(url)
+		if err != nil {
+			return err
+		}
+		if err := store.Put(ctx, objectstore.Key(url), signature); err != nil {
+			return errors.Wrapf(err, "writing signature to %s", url.Redacted())
+		}
+		return nil
 	default:
 		return errors.Errorf("Unsupported scheme when writing signature to %s", url.Redacted())
 	}
@@ -607,7 +675,7 @@ func (d *dockerImageDestination) putOneSignature(url *url.URL, signature []byte)
 // deleteOneSignature deletes a signature from url, if it exists.
 // If it successfully determines that the signature does not exist, returns (true, nil)
 // NOTE: Keep this in sync with docs/signature-protocols.md!
-func (c *dockerClient) deleteOneSignature(url *url.URL) (missing bool, err error) {
+func (c *dockerClient) deleteOneSignature(ctx context.Context, url *url.URL) (missing bool, err error) {
 	switch url.Scheme {
 	case "file":
 		logrus.Debugf("Deleting %s", url.Path)
@@ -619,6 +687,16 @@ func (c *dockerClient) deleteOneSignature(url *url.URL) (missing bool, err error
 
 	case "http", "https":
 		return false, errors.Errorf("Writing directly to a %s sigstore %s is not supported. Configure a sigstore-staging: location", url.Scheme, url.Redacted())
+	case "s3", "gs", "azblob":
+		store, err := c.lookasideObjectStore(url)
+		if err != nil {
+			return false, err
+		}
+		missing, err := store.Delete(ctx, objectstore.Key(url))
+		if err != nil {
+			return false, errors.Wrapf(err, "deleting signature from %s", url.Redacted())
+		}
+		return missing, nil
 	default:
 		return false, errors.Errorf("Unsupported scheme when deleting signature from %s", url.Redacted())
 	}
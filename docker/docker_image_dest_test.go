@@ -3,9 +3,15 @@ package docker
 import (
 	"bufio"
 	"bytes"
+	"context"
+	"io"
 	"net/http"
+	"net/http/httptest"
+	"strings"
 	"testing"
 
+	"github.com/containers/image/v5/internal/private"
+	"github.com/containers/image/v5/types"
 	"github.com/stretchr/testify/assert"
 	"github.com/stretchr/testify/require"
 )
@@ -30,3 +36,37 @@ func TestIsManifestInvalidError(t *testing.T) {
 	res := isManifestInvalidError(err)
 	assert.True(t, res, "%#v", err)
 }
+
+func TestPutManifestTag(t *testing.T) {
+	manifests := map[string]string{}
+	s := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path == "/v2/" {
+			w.WriteHeader(http.StatusOK)
+			return
+		}
+		if r.Method != http.MethodPut || !strings.HasPrefix(r.URL.Path, "/v2/repo/manifests/") {
+			w.WriteHeader(http.StatusNotFound)
+			return
+		}
+		body, err := io.ReadAll(r.Body)
+		require.NoError(t, err)
+		manifests[strings.TrimPrefix(r.URL.Path, "/v2/repo/manifests/")] = string(body)
+		w.WriteHeader(http.StatusCreated)
+	}))
+	defer s.Close()
+
+	ref, err := ParseReference("//" + strings.TrimPrefix(s.URL, "http://") + "/repo:tag")
+	require.NoError(t, err)
+	dest, err := ref.NewImageDestination(context.Background(), &types.SystemContext{DockerInsecureSkipTLSVerify: types.OptionalBoolTrue})
+	require.NoError(t, err)
+	defer dest.Close()
+	tagger, ok := dest.(private.ManifestTagger)
+	require.True(t, ok)
+
+	err = tagger.PutManifestTag(context.Background(), []byte("manifest"), "other")
+	require.NoError(t, err)
+	assert.Equal(t, map[string]string{"other": "manifest"}, manifests)
+
+	err = tagger.PutManifestTag(context.Background(), []byte("manifest"), "invalid/tag")
+	assert.Error(t, err)
+}
@@ -12,10 +12,14 @@ import (
 	"strconv"
 	"strings"
 	"sync"
+	"time"
 
+	"github.com/containers/image/v5/docker/internal/objectstore"
 	"github.com/containers/image/v5/docker/reference"
+	"github.com/containers/image/v5/internal/digestpolicy"
 	"github.com/containers/image/v5/internal/iolimits"
 	"github.com/containers/image/v5/internal/private"
+	"github.com/containers/image/v5/internal/warnings"
 	"github.com/containers/image/v5/manifest"
 	"github.com/containers/image/v5/pkg/sysregistriesv2"
 	"github.com/containers/image/v5/types"
@@ -28,9 +32,12 @@ type dockerImageSource struct {
 	logicalRef  dockerReference // The reference the user requested.
 	physicalRef dockerReference // The actual reference we are accessing (possibly a mirror)
 	c           *dockerClient
+	// Pull sources which failed before physicalRef was successfully accessed, as "reference: error" strings; nil if none did.
+	failedPullSources []string
 	// State
 	cachedManifest         []byte // nil if not loaded yet
 	cachedManifestMIMEType string // Only valid if cachedManifest != nil
+	lookasideWarningOnce   sync.Once
 }
 
 // newImageSource creates a new ImageSource for the specified image reference.
@@ -56,13 +63,26 @@ func newImageSource(ctx context.Context, sys *types.SystemContext, ref dockerRef
 	// non-mirror original location last; this both transparently handles the case
 	// of no mirrors configured, and ensures we return the error encountered when
 	// accessing the upstream location if all endpoints fail.
-	pullSources, err := registry.PullSourcesFromReference(ref.ref)
+	pullRef, err := resolveTagUsingHook(ctx, sys, ref.ref)
 	if err != nil {
 		return nil, err
 	}
+	pullSources, err := registry.PullSourcesFromReference(pullRef)
+	if err != nil {
+		return nil, err
+	}
+	primary := pullSources[len(pullSources)-1]
+	health, err := mirrorHealthTrackerForSystemContext(sys)
+	if err != nil {
+		return nil, err
+	}
+	if health != nil {
+		pullSources = health.orderPullSources(pullSources, time.Now())
+	}
 	type attempt struct {
-		ref reference.Named
-		err error
+		ref     reference.Named
+		err     error
+		primary bool
 	}
 	attempts := []attempt{}
 	for _, pullSource := range pullSources {
@@ -71,14 +91,36 @@ func newImageSource(ctx context.Context, sys *types.SystemContext, ref dockerRef
 		} else {
 			logrus.Debugf("Trying to access %q", pullSource.Reference)
 		}
+		isMirror := pullSource.Endpoint.Location != primary.Endpoint.Location
+		attemptStart := time.Now()
 		s, err := newImageSourceAttempt(ctx, sys, ref, pullSource)
 		if err == nil {
+			if health != nil && isMirror {
+				health.recordSuccess(pullSource.Endpoint.Location, time.Since(attemptStart))
+			}
+			for _, a := range attempts {
+				s.failedPullSources = append(s.failedPullSources, fmt.Sprintf("%s: %v", a.ref.String(), a.err))
+			}
+			if sys != nil && sys.DockerPullSourceCallback != nil {
+				sys.DockerPullSourceCallback(types.DockerPullSourceChoice{
+					Reference:         ref.ref,
+					PullSource:        pullSource.Reference,
+					Endpoint:          pullSource.Endpoint.Location,
+					Mirror:            isMirror,
+					FailedPullSources: s.failedPullSources,
+				})
+			}
 			return s, nil
 		}
 		logrus.Debugf("Accessing %q failed: %v", pullSource.Reference, err)
+		if health != nil && isMirror && isMirrorHealthFailure(err) {
+			logrus.Debugf("Demoting mirror %q", pullSource.Endpoint.Location)
+			health.recordFailure(pullSource.Endpoint.Location, time.Now())
+		}
 		attempts = append(attempts, attempt{
-			ref: pullSource.Reference,
-			err: err,
+			ref:     pullSource.Reference,
+			err:     err,
+			primary: !isMirror,
 		})
 	}
 	switch len(attempts) {
@@ -88,15 +130,46 @@ func newImageSource(ctx context.Context, sys *types.SystemContext, ref dockerRef
 		return nil, attempts[0].err // If no mirrors are used, perfectly preserve the error type and add no noise.
 	default:
 		// Don’t just build a string, try to preserve the typed error.
-		primary := &attempts[len(attempts)-1]
+		// Report the error of the primary location, which is not necessarily the last one tried if some mirrors have been demoted.
+		primaryIndex := len(attempts) - 1
+		for i, a := range attempts {
+			if a.primary {
+				primaryIndex = i
+				break
+			}
+		}
 		extras := []string{}
-		for i := 0; i < len(attempts)-1; i++ {
+		for i := range attempts {
+			if i == primaryIndex {
+				continue
+			}
 			// This is difficult to fit into a single-line string, when the error can contain arbitrary strings including any metacharacters we decide to use.
 			// The paired [] at least have some chance of being unambiguous.
 			extras = append(extras, fmt.Sprintf("[%s: %v]", attempts[i].ref.String(), attempts[i].err))
 		}
-		return nil, errors.Wrapf(primary.err, "(Mirrors also failed: %s): %s", strings.Join(extras, "\n"), primary.ref.String())
+		return nil, errors.Wrapf(attempts[primaryIndex].err, "(Mirrors also failed: %s): %s", strings.Join(extras, "\n"), attempts[primaryIndex].ref.String())
+	}
+}
+
+// resolveTagUsingHook returns the reference to pull ref from: if ref is tagged and sys.DockerTagResolver returns a digest for it,
+// a reference to that digest, otherwise ref.
+func resolveTagUsingHook(ctx context.Context, sys *types.SystemContext, ref reference.Named) (reference.Named, error) {
+	tagged, ok := ref.(reference.NamedTagged)
+	if sys == nil || sys.DockerTagResolver == nil || !ok {
+		return ref, nil
+	}
+	d, err := sys.DockerTagResolver(ctx, tagged)
+	if err != nil {
+		return nil, errors.Wrapf(err, "resolving %s to a trusted digest", reference.FamiliarString(ref))
+	}
+	if d == "" {
+		return ref, nil
 	}
+	if err := d.Validate(); err != nil {
+		return nil, errors.Wrapf(err, "invalid digest returned when resolving %s", reference.FamiliarString(ref))
+	}
+	logrus.Debugf("Resolved %s to trusted digest %s", reference.FamiliarString(ref), d.String())
+	return reference.WithDigest(reference.TrimNamed(ref), d)
 }
 
 // newImageSourceAttempt is an internal helper for newImageSource. Everyone else must call newImageSource.
@@ -117,11 +190,16 @@ func newImageSourceAttempt(ctx context.Context, sys *types.SystemContext, logica
 		endpointSys = &copy
 	}
 
-	client, err := newDockerClientFromRef(endpointSys, physicalRef, false, "pull")
+	client, err := newDockerClientFromRef(ctx, endpointSys, physicalRef, false, "pull")
 	if err != nil {
 		return nil, err
 	}
-	client.tlsClientConfig.InsecureSkipVerify = pullSource.Endpoint.Insecure
+	client.tlsClientConfig.InsecureSkipVerify = pullSource.Endpoint.InsecureFor(pullSource.Reference)
+	if client.tlsClientConfig.InsecureSkipVerify && !pullSource.Endpoint.Insecure && !client.insecureDigestOnly {
+		// The endpoint is a mirror allowed to be insecure only for digests; don’t expose credentials to it.
+		client.insecureDigestOnly = true
+		client.dropCredentials()
+	}
 
 	s := &dockerImageSource{
 		logicalRef:  logicalRef,
@@ -146,6 +224,23 @@ func (s *dockerImageSource) Close() error {
 	return nil
 }
 
+// FailedPullSources returns human-readable descriptions of the locations (registry mirrors or the primary location)
+// which were tried, and failed, before the image source was successfully opened; nil if the first location was used.
+func (s *dockerImageSource) FailedPullSources() []string {
+	return s.failedPullSources
+}
+
+// AuthenticationDuration returns the total time spent so far looking up credentials and obtaining bearer tokens.
+func (s *dockerImageSource) AuthenticationDuration() time.Duration {
+	return s.c.authenticationDuration()
+}
+
+// BearerTokenScopes returns the scopes, in the "repository:name:actions" format, requested in bearer tokens used
+// so far to access the registry, for debugging.
+func (s *dockerImageSource) BearerTokenScopes() []string {
+	return s.c.bearerTokenScopes()
+}
+
 // SupportsGetBlobAt() returns true if GetBlobAt (BlobChunkAccessor) is supported.
 func (s *dockerImageSource) SupportsGetBlobAt() bool {
 	return true
@@ -192,6 +287,11 @@ func (s *dockerImageSource) GetManifest(ctx context.Context, instanceDigest *dig
 }
 
 func (s *dockerImageSource) fetchManifest(ctx context.Context, tagOrDigest string) ([]byte, string, error) {
+	if d, err := digest.Parse(tagOrDigest); err == nil {
+		if err := digestpolicy.CheckAlgorithm(digestpolicy.FromSystemContext(s.c.sys), d); err != nil {
+			return nil, "", err
+		}
+	}
 	path := fmt.Sprintf(manifestPath, reference.Path(s.physicalRef.ref), tagOrDigest)
 	headers := map[string][]string{
 		"Accept": manifest.DefaultRequestedManifestMIMETypes,
@@ -203,13 +303,16 @@ func (s *dockerImageSource) fetchManifest(ctx context.Context, tagOrDigest strin
 	logrus.Debugf("Content-Type from manifest GET is %q", res.Header.Get("Content-Type"))
 	defer res.Body.Close()
 	if res.StatusCode != http.StatusOK {
-		return nil, "", errors.Wrapf(registryHTTPResponseToError(res), "reading manifest %s in %s", tagOrDigest, s.physicalRef.ref.Name())
+		return nil, "", errors.Wrapf(manifestHTTPResponseToError(res), "reading manifest %s in %s", tagOrDigest, s.physicalRef.ref.Name())
 	}
 
 	manblob, err := iolimits.ReadAtMost(res.Body, iolimits.MaxManifestBodySize)
 	if err != nil {
 		return nil, "", err
 	}
+	if err := s.c.checkManifestContentDigest(res, manblob, fmt.Sprintf("%s in %s", tagOrDigest, s.physicalRef.ref.Name())); err != nil {
+		return nil, "", err
+	}
 	return manblob, simplifyContentType(res.Header.Get("Content-Type")), nil
 }
 
@@ -225,16 +328,26 @@ func (s *dockerImageSource) ensureManifestIsLoaded(ctx context.Context) error {
 		return nil
 	}
 
-	reference, err := s.physicalRef.tagOrDigest()
+	tagOrDigest, err := s.physicalRef.tagOrDigest()
 	if err != nil {
 		return err
 	}
 
-	manblob, mt, err := s.fetchManifest(ctx, reference)
+	manblob, mt, err := s.fetchManifest(ctx, tagOrDigest)
 	if err != nil {
 		return err
 	}
-	// We might validate manblob against the Docker-Content-Digest header here to protect against transport errors.
+	if digested, ok := s.physicalRef.ref.(reference.Canonical); ok {
+		// Usually also verified by the caller against the logical reference, but that does not contain
+		// the digest if it was obtained from sys.DockerTagResolver.
+		matches, err := manifest.MatchesDigest(manblob, digested.Digest())
+		if err != nil {
+			return errors.Wrapf(err, "computing manifest digest")
+		}
+		if !matches {
+			return errors.Errorf("Manifest %s does not match the expected digest", reference.FamiliarString(s.physicalRef.ref))
+		}
+	}
 	s.cachedManifest = manblob
 	s.cachedManifestMIMEType = mt
 	return nil
@@ -367,23 +480,17 @@ func handle206Response(streams chan io.ReadCloser, errs chan error, body io.Read
 // The specified chunks must be not overlapping and sorted by their offset.
 // The readers must be fully consumed, in the order they are returned, before blocking
 // to read the next chunk.
+// If the registry is known not to support multiple ranges in a single request, or turns out
+// not to support them, the chunks are read using separate requests.
 func (s *dockerImageSource) GetBlobAt(ctx context.Context, info types.BlobInfo, chunks []private.ImageSourceChunk) (chan io.ReadCloser, chan error, error) {
-	headers := make(map[string][]string)
-
-	var rangeVals []string
-	for _, c := range chunks {
-		rangeVals = append(rangeVals, fmt.Sprintf("%d-%d", c.Offset, c.Offset+c.Length-1))
-	}
-
-	headers["Range"] = []string{fmt.Sprintf("bytes=%s", strings.Join(rangeVals, ","))}
-
 	if len(info.URLs) != 0 {
 		return nil, nil, fmt.Errorf("external URLs not supported with GetBlobAt")
 	}
+	if len(chunks) > 1 && s.c.rangeRequestSupport() == MultiRangeRequestsUnsupported {
+		return s.getBlobChunksSeparately(ctx, info, chunks)
+	}
 
-	path := fmt.Sprintf(blobsPath, reference.Path(s.physicalRef.ref), info.Digest.String())
-	logrus.Debugf("Downloading %s", path)
-	res, err := s.c.makeRequest(ctx, http.MethodGet, path, headers, nil, v2Auth, nil)
+	res, err := s.getBlobRanges(ctx, info, chunks)
 	if err != nil {
 		return nil, nil, err
 	}
@@ -392,6 +499,7 @@ func (s *dockerImageSource) GetBlobAt(ctx context.Context, info types.BlobInfo,
 	case http.StatusOK:
 		// if the server replied with a 200 status code, convert the full body response to a series of
 		// streams as it would have been done with 206.
+		s.c.setRangeRequestSupport(RangeRequestsUnsupported)
 		streams := make(chan io.ReadCloser)
 		errs := make(chan error)
 		go splitHTTP200ResponseToPartial(streams, errs, res.Body, chunks)
@@ -399,16 +507,47 @@ func (s *dockerImageSource) GetBlobAt(ctx context.Context, info types.BlobInfo,
 	case http.StatusPartialContent:
 		mediaType, params, err := mime.ParseMediaType(res.Header.Get("Content-Type"))
 		if err != nil {
+			res.Body.Close()
 			return nil, nil, err
 		}
+		if len(chunks) > 1 && !strings.HasPrefix(mediaType, "multipart/") {
+			// The server has either coalesced the ranges, or (incorrectly) returned only one of them.
+			start, end, err := parseContentRange(res.Header.Get("Content-Range"))
+			last := chunks[len(chunks)-1]
+			if err != nil || start > chunks[0].Offset || end < last.Offset+last.Length-1 {
+				res.Body.Close()
+				logrus.Debugf("Registry %s returned %q for a multi-range request, using separate requests", s.c.registry, res.Header.Get("Content-Range"))
+				s.c.setRangeRequestSupport(MultiRangeRequestsUnsupported)
+				return s.getBlobChunksSeparately(ctx, info, chunks)
+			}
+			s.c.setRangeRequestSupport(MultiRangeRequestsSupported)
+			relativeChunks := make([]private.ImageSourceChunk, 0, len(chunks))
+			for _, c := range chunks {
+				relativeChunks = append(relativeChunks, private.ImageSourceChunk{Offset: c.Offset - start, Length: c.Length})
+			}
+			streams := make(chan io.ReadCloser)
+			errs := make(chan error)
+			go splitHTTP200ResponseToPartial(streams, errs, res.Body, relativeChunks)
+			return streams, errs, nil
+		}
+		if len(chunks) > 1 {
+			s.c.setRangeRequestSupport(MultiRangeRequestsSupported)
+		} else {
+			s.c.setRangeRequestSupport(RangeRequestsSupported)
+		}
 
 		streams := make(chan io.ReadCloser)
 		errs := make(chan error)
 
 		go handle206Response(streams, errs, res.Body, chunks, mediaType, params)
 		return streams, errs, nil
-	case http.StatusBadRequest:
+	case http.StatusBadRequest, http.StatusRequestedRangeNotSatisfiable:
 		res.Body.Close()
+		if len(chunks) > 1 {
+			logrus.Debugf("Registry %s rejected a multi-range request with %q, using separate requests", s.c.registry, res.Status)
+			s.c.setRangeRequestSupport(MultiRangeRequestsUnsupported)
+			return s.getBlobChunksSeparately(ctx, info, chunks)
+		}
 		return nil, nil, private.BadPartialRequestError{Status: res.Status}
 	default:
 		err := httpResponseToError(res, "Error fetching partial blob")
@@ -424,6 +563,9 @@ func (s *dockerImageSource) GetBlobAt(ctx context.Context, info types.BlobInfo,
 // The Digest field in BlobInfo is guaranteed to be provided, Size may be -1 and MediaType may be optionally provided.
 // May update BlobInfoCache, preferably after it knows for certain that a blob truly exists at a specific location.
 func (s *dockerImageSource) GetBlob(ctx context.Context, info types.BlobInfo, cache types.BlobInfoCache) (io.ReadCloser, int64, error) {
+	if err := digestpolicy.CheckAlgorithm(digestpolicy.FromSystemContext(s.c.sys), info.Digest); err != nil {
+		return nil, 0, err
+	}
 	if len(info.URLs) != 0 {
 		r, s, err := s.getExternalBlob(ctx, info.URLs)
 		if err != nil {
@@ -439,10 +581,19 @@ func (s *dockerImageSource) GetBlob(ctx context.Context, info types.BlobInfo, ca
 	if err != nil {
 		return nil, 0, err
 	}
+	if res.StatusCode == http.StatusNotFound {
+		err := blobHTTPResponseToError(res)
+		res.Body.Close()
+		return nil, 0, errors.Wrapf(err, "fetching blob %s", info.Digest)
+	}
 	if err := httpResponseToError(res, "Error fetching blob"); err != nil {
 		res.Body.Close()
 		return nil, 0, err
 	}
+	if err := s.c.checkBlobContentDigest(res, info.Digest); err != nil {
+		res.Body.Close()
+		return nil, 0, err
+	}
 	cache.RecordKnownLocation(s.physicalRef.Transport(), bicTransportScope(s.physicalRef), info.Digest, newBICLocationReference(s.physicalRef))
 	return res.Body, getBlobSize(res), nil
 }
@@ -459,6 +610,14 @@ func (s *dockerImageSource) GetSignatures(ctx context.Context, instanceDigest *d
 	case s.c.supportsSignatures:
 		return s.getSignaturesFromAPIExtension(ctx, instanceDigest)
 	case s.c.signatureBase != nil:
+		s.lookasideWarningOnce.Do(func() {
+			warnings.Report(s.c.sys, types.Warning{
+				Kind:    types.WarningDeprecation,
+				Code:    types.WarningCodeLookasideSignatures,
+				Subject: s.c.registry,
+				Message: fmt.Sprintf("Registry %s does not support storing signatures, reading them from lookaside storage", s.c.registry),
+			})
+		})
 		return s.getSignaturesFromLookaside(ctx, instanceDigest)
 	default:
 		return nil, errors.Errorf("Internal error: X-Registry-Supports-Signatures extension not supported, and lookaside should not be empty configuration")
@@ -545,6 +704,17 @@ func (s *dockerImageSource) getOneSignature(ctx context.Context, url *url.URL) (
 		}
 		return sig, false, nil
 
+	case "s3", "gs", "azblob":
+		store, err := s.c.lookasideObjectStore(url)
+		if err != nil {
+			return nil, false, err
+		}
+		sig, missing, err := store.Get(ctx, objectstore.Key(url), iolimits.MaxSignatureBodySize)
+		if err != nil {
+			return nil, false, errors.Wrapf(err, "reading signature from %s", url.Redacted())
+		}
+		return sig, missing, nil
+
 	default:
 		return nil, false, errors.Errorf("Unsupported scheme when reading signature from %s", url.Redacted())
 	}
@@ -580,7 +750,7 @@ func deleteImage(ctx context.Context, sys *types.SystemContext, ref dockerRefere
 	// OpenShift ignores the action string (both the password and the token is an OpenShift API token identifying a user).
 	//
 	// We have to hard-code a single string, luckily both docker/distribution and quay.io support "*" to mean "everything".
-	c, err := newDockerClientFromRef(sys, ref, true, "*")
+	c, err := newDockerClientFromRef(ctx, sys, ref, true, "*")
 	if err != nil {
 		return err
 	}
@@ -634,7 +804,7 @@ func deleteImage(ctx context.Context, sys *types.SystemContext, ref dockerRefere
 
 	for i := 0; ; i++ {
 		url := signatureStorageURL(c.signatureBase, manifestDigest, i)
-		missing, err := c.deleteOneSignature(url)
+		missing, err := c.deleteOneSignature(ctx, url)
 		if err != nil {
 			return err
 		}
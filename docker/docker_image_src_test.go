@@ -3,6 +3,7 @@ package docker
 import (
 	"bytes"
 	"context"
+	"errors"
 	"io"
 	"net/http"
 	"net/http/httptest"
@@ -12,13 +13,16 @@ import (
 	"strings"
 	"testing"
 
+	"github.com/containers/image/v5/docker/reference"
 	"github.com/containers/image/v5/internal/private"
 	"github.com/containers/image/v5/types"
+	"github.com/opencontainers/go-digest"
 	"github.com/stretchr/testify/assert"
 	"github.com/stretchr/testify/require"
 )
 
 var _ private.ImageSource = (*dockerImageSource)(nil)
+var _ private.MirrorFailoverReporter = (*dockerImageSource)(nil)
 
 func TestDockerImageSourceReference(t *testing.T) {
 	manifestPathRegex := regexp.MustCompile("^/v2/.*/manifests/latest$")
@@ -27,6 +31,8 @@ func TestDockerImageSourceReference(t *testing.T) {
 		switch {
 		case r.Method == http.MethodGet && r.URL.Path == "/v2/":
 			rw.WriteHeader(http.StatusOK)
+		case r.Method == http.MethodGet && strings.HasPrefix(r.URL.Path, "/v2/broken-mirror/"):
+			rw.WriteHeader(http.StatusNotFound)
 		case r.Method == http.MethodGet && manifestPathRegex.MatchString(r.URL.Path):
 			rw.WriteHeader(http.StatusOK)
 			// Empty body is good enough for this test
@@ -47,6 +53,15 @@ location = "@REGISTRY@/primary-override"
 [[registry]]
 location = "with-mirror.example.com"
 
+[[registry.mirror]]
+location = "@REGISTRY@/with-mirror"
+
+[[registry]]
+location = "with-broken-mirror.example.com"
+
+[[registry.mirror]]
+location = "@REGISTRY@/broken-mirror"
+
 [[registry.mirror]]
 location = "@REGISTRY@/with-mirror"
 `, "@REGISTRY@", registry, -1)
@@ -57,10 +72,14 @@ location = "@REGISTRY@/with-mirror"
 	err = os.WriteFile(registriesConf.Name(), []byte(mirrorConfiguration), 0600)
 	require.NoError(t, err)
 
-	for _, c := range []struct{ input, physical string }{
-		{registry + "/no-redirection/busybox:latest", registry + "/no-redirection/busybox:latest"},
-		{"primary-override.example.com/busybox:latest", registry + "/primary-override/busybox:latest"},
-		{"with-mirror.example.com/busybox:latest", registry + "/with-mirror/busybox:latest"},
+	for _, c := range []struct {
+		input, physical   string
+		failedPullSources int
+	}{
+		{registry + "/no-redirection/busybox:latest", registry + "/no-redirection/busybox:latest", 0},
+		{"primary-override.example.com/busybox:latest", registry + "/primary-override/busybox:latest", 0},
+		{"with-mirror.example.com/busybox:latest", registry + "/with-mirror/busybox:latest", 0},
+		{"with-broken-mirror.example.com/busybox:latest", registry + "/with-mirror/busybox:latest", 1},
 	} {
 		ref, err := ParseReference("//" + c.input)
 		require.NoError(t, err, c.input)
@@ -80,6 +99,11 @@ location = "@REGISTRY@/with-mirror"
 		require.True(t, ok, c.input)
 		assert.Equal(t, "//"+c.input, src2.logicalRef.StringWithinTransport(), c.input)
 		assert.Equal(t, "//"+c.physical, src2.physicalRef.StringWithinTransport(), c.input)
+		failed := src2.FailedPullSources()
+		assert.Len(t, failed, c.failedPullSources, c.input)
+		if c.failedPullSources != 0 {
+			assert.Contains(t, failed[0], registry+"/broken-mirror/busybox:latest", c.input)
+		}
 	}
 }
 
@@ -183,3 +207,48 @@ func TestHandle206Response(t *testing.T) {
 	}
 	verifyGetBlobAtOutput(t, streams, errs, expected)
 }
+
+func TestResolveTagUsingHook(t *testing.T) {
+	d := digest.FromString("manifest")
+	var resolved []string
+	sys := &types.SystemContext{DockerTagResolver: func(ctx context.Context, ref reference.NamedTagged) (digest.Digest, error) {
+		resolved = append(resolved, ref.String())
+		switch ref.Tag() {
+		case "trusted":
+			return d, nil
+		case "untrusted":
+			return "", errors.New("no trust data")
+		case "invalid":
+			return "sha256:invalid", nil
+		default:
+			return "", nil
+		}
+	}}
+	parse := func(s string) reference.Named {
+		ref, err := reference.ParseNormalizedNamed(s)
+		require.NoError(t, err)
+		return ref
+	}
+
+	res, err := resolveTagUsingHook(context.Background(), sys, parse("example.com/ns/repo:trusted"))
+	require.NoError(t, err)
+	assert.Equal(t, "example.com/ns/repo@"+d.String(), res.String())
+	res, err = resolveTagUsingHook(context.Background(), sys, parse("example.com/ns/repo:other"))
+	require.NoError(t, err)
+	assert.Equal(t, "example.com/ns/repo:other", res.String())
+	_, err = resolveTagUsingHook(context.Background(), sys, parse("example.com/ns/repo:untrusted"))
+	assert.Error(t, err)
+	_, err = resolveTagUsingHook(context.Background(), sys, parse("example.com/ns/repo:invalid"))
+	assert.Error(t, err)
+	assert.Equal(t, []string{"example.com/ns/repo:trusted", "example.com/ns/repo:other", "example.com/ns/repo:untrusted",
+		"example.com/ns/repo:invalid"}, resolved)
+
+	// Digest references, and a missing hook
+	res, err = resolveTagUsingHook(context.Background(), sys, parse("example.com/ns/repo@"+d.String()))
+	require.NoError(t, err)
+	assert.Equal(t, "example.com/ns/repo@"+d.String(), res.String())
+	assert.Len(t, resolved, 4)
+	res, err = resolveTagUsingHook(context.Background(), nil, parse("example.com/ns/repo:trusted"))
+	require.NoError(t, err)
+	assert.Equal(t, "example.com/ns/repo:trusted", res.String())
+}
@@ -1,10 +1,16 @@
 package docker
 
 import (
+	"bytes"
 	"errors"
 	"fmt"
+	"io"
 	"net/http"
+	"strings"
+	"time"
 
+	"github.com/docker/distribution/registry/api/errcode"
+	v2 "github.com/docker/distribution/registry/api/v2"
 	"github.com/docker/distribution/registry/client"
 	perrors "github.com/pkg/errors"
 )
@@ -14,9 +20,98 @@ var (
 	// docker V1 registry.
 	ErrV1NotSupported = errors.New("can't talk to a V1 container registry")
 	// ErrTooManyRequests is returned when the status code returned is 429
+	// (wrapped in a TooManyRequestsError, use errors.Is to detect it).
 	ErrTooManyRequests = errors.New("too many requests to registry")
 )
 
+// RegistryError is an error response from a registry.
+// The more specific error types returned for registry responses (e.g. ErrManifestUnknown) wrap a RegistryError,
+// so the raw response is available using errors.As in all cases.
+type RegistryError struct {
+	// StatusCode is the HTTP status code of the response.
+	StatusCode int
+	// Payload is the (possibly truncated) body of the response, typically an OCI error payload
+	// ({"errors":[{"code":…,"message":…,"detail":…}]}); it may be empty.
+	Payload []byte
+	// Err is the error parsed from the response, typically an errcode.Errors; it may be nil if the body was not parsed.
+	Err error
+}
+
+func (e RegistryError) Error() string {
+	if e.Err == nil {
+		return fmt.Sprintf("invalid status code from registry %d (%s)", e.StatusCode, http.StatusText(e.StatusCode))
+	}
+	return e.Err.Error()
+}
+
+func (e RegistryError) Unwrap() error {
+	return e.Err
+}
+
+// ErrManifestUnknown is returned when the registry reports that a manifest does not exist.
+type ErrManifestUnknown struct {
+	RegistryError
+}
+
+func (e ErrManifestUnknown) Unwrap() error {
+	return e.RegistryError
+}
+
+// ErrBlobUnknown is returned when the registry reports that a blob does not exist.
+type ErrBlobUnknown struct {
+	RegistryError
+}
+
+func (e ErrBlobUnknown) Unwrap() error {
+	return e.RegistryError
+}
+
+// ErrDenied is returned when the registry denies access to the requested resource.
+type ErrDenied struct {
+	RegistryError
+}
+
+func (e ErrDenied) Unwrap() error {
+	return e.RegistryError
+}
+
+// ErrUnsupportedMediaType is returned when the status code returned is 415, e.g. when the registry does not accept
+// the media type of an uploaded manifest.
+type ErrUnsupportedMediaType struct {
+	RegistryError
+}
+
+func (e ErrUnsupportedMediaType) Unwrap() error {
+	return e.RegistryError
+}
+
+// TooManyRequestsError is returned when the status code returned is 429; errors.Is(err, ErrTooManyRequests) is true for it.
+type TooManyRequestsError struct {
+	RegistryError
+	// RetryAfter is the delay requested by the registry in a Retry-After header, or 0 if unknown.
+	RetryAfter time.Duration
+}
+
+func (e TooManyRequestsError) Error() string {
+	msg := ErrTooManyRequests.Error()
+	if e.RetryAfter > 0 {
+		msg += fmt.Sprintf(", retry after %s", e.RetryAfter)
+	}
+	if e.Err != nil {
+		msg += ": " + e.Err.Error()
+	}
+	return msg
+}
+
+func (e TooManyRequestsError) Unwrap() error {
+	return e.RegistryError
+}
+
+// Is returns true if target is ErrTooManyRequests.
+func (e TooManyRequestsError) Is(target error) bool {
+	return target == ErrTooManyRequests
+}
+
 // ErrUnauthorizedForCredentials is returned when the status code returned is 401
 type ErrUnauthorizedForCredentials struct { // We only use a struct to allow a type assertion, without limiting the contents of the error otherwise.
 	Err error
@@ -26,15 +121,100 @@ func (e ErrUnauthorizedForCredentials) Error() string {
 	return fmt.Sprintf("unable to retrieve auth token: invalid username/password: %s", e.Err.Error())
 }
 
+// ErrDeletionUnsupported is returned when the registry does not support, or does not allow, the requested deletion.
+type ErrDeletionUnsupported struct {
+	// Operation describes what was attempted, e.g. "deleting manifest sha256:… in example.com/repo".
+	Operation string
+	// Err is the error reported by the registry.
+	Err error
+}
+
+func (e ErrDeletionUnsupported) Error() string {
+	return fmt.Sprintf("%s: deletion is not supported by the registry: %v", e.Operation, e.Err)
+}
+
+// ErrRegistryMaintenance is returned when the status code returned is 503, and the registry indicates
+// that it is in a maintenance (typically read-only) window, as opposed to an unexpected outage.
+type ErrRegistryMaintenance struct {
+	// RetryAfter is the delay requested by the registry in a Retry-After header, or 0 if unknown.
+	RetryAfter time.Duration
+	// Message is a (possibly truncated) excerpt of the response body; it may be empty.
+	Message string
+}
+
+func (e ErrRegistryMaintenance) Error() string {
+	msg := "registry is unavailable due to maintenance"
+	if e.RetryAfter > 0 {
+		msg += fmt.Sprintf(", retry after %s", e.RetryAfter)
+	}
+	if e.Message != "" {
+		msg += ": " + e.Message
+	}
+	return msg
+}
+
+// maintenanceBodyHints are lower-case substrings of HTTP 503 response bodies which explicitly indicate a maintenance window.
+// A Retry-After header alone is not enough: registries and proxies send it with all kinds of overload and outage responses.
+var maintenanceBodyHints = []string{"maintenance", "read-only mode", "read only mode", "readonly mode"}
+
+// maintenanceBodyPeekSize is the maximum number of bytes of a HTTP 503 response body read by registryMaintenanceError.
+const maintenanceBodyPeekSize = 4096
+
+// peekedBody is a http.Response.Body which returns previously read data before reading the rest of the original body.
+type peekedBody struct {
+	io.Reader
+	io.Closer
+}
+
+// registryMaintenanceError returns an ErrRegistryMaintenance, and true, if res is a HTTP 503 response with
+// a body explicitly mentioning maintenance or a read-only mode; the Retry-After header, if any, is only used for the delay.
+// The full response body remains available to the caller in any case.
+func registryMaintenanceError(res *http.Response) (ErrRegistryMaintenance, bool) {
+	if res.StatusCode != http.StatusServiceUnavailable {
+		return ErrRegistryMaintenance{}, false
+	}
+	var peek []byte
+	if res.Body != nil {
+		// On a read error, just use whatever we got so far; the caller will presumably encounter the error again.
+		peek, _ = io.ReadAll(io.LimitReader(res.Body, maintenanceBodyPeekSize))
+		res.Body = peekedBody{Reader: io.MultiReader(bytes.NewReader(peek), res.Body), Closer: res.Body}
+	}
+	lowerBody := strings.ToLower(string(peek))
+	hasBodyHint := false
+	for _, hint := range maintenanceBodyHints {
+		if strings.Contains(lowerBody, hint) {
+			hasBodyHint = true
+			break
+		}
+	}
+	if !hasBodyHint {
+		return ErrRegistryMaintenance{}, false
+	}
+	message := strings.TrimSpace(string(peek))
+	if len(message) > 100 {
+		message = message[:100] + "..."
+	}
+	return ErrRegistryMaintenance{
+		RetryAfter: parseRetryAfter(res, 0),
+		Message:    message,
+	}, true
+}
+
 // httpResponseToError translates the https.Response into an error, possibly prefixing it with the supplied context. It returns
 // nil if the response is not considered an error.
 // NOTE: Almost all callers in this package should use registryHTTPResponseToError instead.
 func httpResponseToError(res *http.Response, context string) error {
+	if e, ok := registryMaintenanceError(res); ok {
+		return e
+	}
 	switch res.StatusCode {
 	case http.StatusOK:
 		return nil
 	case http.StatusTooManyRequests:
-		return ErrTooManyRequests
+		return TooManyRequestsError{
+			RegistryError: RegistryError{StatusCode: res.StatusCode},
+			RetryAfter:    parseRetryAfter(res, 0),
+		}
 	case http.StatusUnauthorized:
 		err := client.HandleErrorResponse(res)
 		return ErrUnauthorizedForCredentials{Err: err}
@@ -46,9 +226,66 @@ func httpResponseToError(res *http.Response, context string) error {
 	}
 }
 
+// registryErrorPayloadMaxSize is the maximum size of a registry error response body recorded in RegistryError.Payload.
+const registryErrorPayloadMaxSize = 64 * 1024
+
 // registryHTTPResponseToError creates a Go error from an HTTP error response of a docker/distribution
 // registry
 func registryHTTPResponseToError(res *http.Response) error {
+	if e, ok := registryMaintenanceError(res); ok {
+		return e
+	}
+	regErr := newRegistryError(res)
+	switch res.StatusCode {
+	case http.StatusTooManyRequests:
+		return TooManyRequestsError{RegistryError: regErr, RetryAfter: parseRetryAfter(res, 0)}
+	case http.StatusUnsupportedMediaType:
+		return ErrUnsupportedMediaType{RegistryError: regErr}
+	}
+	if code, ok := registryErrorCode(regErr.Err); ok {
+		switch code {
+		case v2.ErrorCodeManifestUnknown:
+			return ErrManifestUnknown{RegistryError: regErr}
+		case v2.ErrorCodeBlobUnknown:
+			return ErrBlobUnknown{RegistryError: regErr}
+		case errcode.ErrorCodeDenied:
+			return ErrDenied{RegistryError: regErr}
+		}
+	}
+	if res.StatusCode == http.StatusForbidden {
+		return ErrDenied{RegistryError: regErr}
+	}
+	return regErr
+}
+
+// manifestHTTPResponseToError is registryHTTPResponseToError for responses to requests for a single manifest:
+// it reports any HTTP 404 response, even without an OCI error payload, as ErrManifestUnknown.
+func manifestHTTPResponseToError(res *http.Response) error {
+	err := registryHTTPResponseToError(res)
+	if regErr, ok := err.(RegistryError); ok && res.StatusCode == http.StatusNotFound {
+		return ErrManifestUnknown{RegistryError: regErr}
+	}
+	return err
+}
+
+// blobHTTPResponseToError is registryHTTPResponseToError for responses to requests for a single blob:
+// it reports any HTTP 404 response, even without an OCI error payload, as ErrBlobUnknown.
+func blobHTTPResponseToError(res *http.Response) error {
+	err := registryHTTPResponseToError(res)
+	if regErr, ok := err.(RegistryError); ok && res.StatusCode == http.StatusNotFound {
+		return ErrBlobUnknown{RegistryError: regErr}
+	}
+	return err
+}
+
+// newRegistryError returns a RegistryError for res, parsing its body if possible.
+func newRegistryError(res *http.Response) RegistryError {
+	var payload []byte
+	if res.Body != nil {
+		// On a read error, just use whatever we got so far.
+		payload, _ = io.ReadAll(io.LimitReader(res.Body, registryErrorPayloadMaxSize))
+		res.Body = peekedBody{Reader: bytes.NewReader(payload), Closer: res.Body}
+	}
 	err := client.HandleErrorResponse(res)
 	if e, ok := err.(*client.UnexpectedHTTPResponseError); ok {
 		response := string(e.Response)
@@ -57,5 +294,24 @@ func registryHTTPResponseToError(res *http.Response) error {
 		}
 		err = fmt.Errorf("StatusCode: %d, %s", e.StatusCode, response)
 	}
-	return err
+	return RegistryError{
+		StatusCode: res.StatusCode,
+		Payload:    payload,
+		Err:        err,
+	}
+}
+
+// registryErrorCode returns the code of the first error in err, as returned by client.HandleErrorResponse, if any.
+func registryErrorCode(err error) (errcode.ErrorCode, bool) {
+	if errs, ok := err.(errcode.Errors); ok {
+		if len(errs) == 0 {
+			return 0, false
+		}
+		err = errs[0]
+	}
+	ec, ok := err.(errcode.ErrorCoder)
+	if !ok {
+		return 0, false
+	}
+	return ec.ErrorCode(), true
 }
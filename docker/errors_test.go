@@ -5,7 +5,9 @@ import (
 	"bytes"
 	"errors"
 	"net/http"
+	"strings"
 	"testing"
+	"time"
 
 	"github.com/docker/distribution/registry/api/errcode"
 	"github.com/docker/distribution/registry/client"
@@ -30,8 +32,9 @@ func TestRegistryHTTPResponseToError(t *testing.T) {
 				"Header1: Value1\r\n" +
 				"\r\n" +
 				"Body of the request\r\n",
-			errorString: "received unexpected HTTP status: 333 HTTP status out of range",
-			errorType:   &client.UnexpectedHTTPStatusError{},
+			errorString:       "received unexpected HTTP status: 333 HTTP status out of range",
+			errorType:         RegistryError{},
+			unwrappedErrorPtr: func() **client.UnexpectedHTTPStatusError { var e *client.UnexpectedHTTPStatusError; return &e }(),
 		},
 		{
 			name: "HTTP body not in expected format",
@@ -40,7 +43,7 @@ func TestRegistryHTTPResponseToError(t *testing.T) {
 				"\r\n" +
 				"<html><body>JSON? What JSON?</body></html>\r\n",
 			errorString:       "StatusCode: 400, <html><body>JSON? What JSON?</body></html>\r\n",
-			errorType:         nil,
+			errorType:         RegistryError{},
 			unwrappedErrorPtr: nil,
 		},
 		{
@@ -50,8 +53,8 @@ func TestRegistryHTTPResponseToError(t *testing.T) {
 				"\r\n" +
 				"<html><body>JSON? What JSON?</body></html>\r\n",
 			errorString:       "unauthorized: authentication required",
-			errorType:         errcode.Error{},
-			unwrappedErrorPtr: nil,
+			errorType:         RegistryError{},
+			unwrappedErrorPtr: &errcode.Error{},
 		},
 		{ // docker.io when an image is not found
 			name: "GET https://registry-1.docker.io/v2/library/this-does-not-exist/manifests/latest",
@@ -66,8 +69,8 @@ func TestRegistryHTTPResponseToError(t *testing.T) {
 				"\r\n" +
 				"{\"errors\":[{\"code\":\"UNAUTHORIZED\",\"message\":\"authentication required\",\"detail\":[{\"Type\":\"repository\",\"Class\":\"\",\"Name\":\"library/this-does-not-exist\",\"Action\":\"pull\"}]}]}\n",
 			errorString:       "errors:\ndenied: requested access to the resource is denied\nunauthorized: authentication required\n",
-			errorType:         errcode.Errors{},
-			unwrappedErrorPtr: nil,
+			errorType:         ErrDenied{},
+			unwrappedErrorPtr: &errcode.Errors{},
 		},
 		{ // docker.io when a tag is not found
 			name: "GET https://registry-1.docker.io/v2/library/busybox/manifests/this-does-not-exist",
@@ -83,8 +86,8 @@ func TestRegistryHTTPResponseToError(t *testing.T) {
 				"\r\n" +
 				"{\"errors\":[{\"code\":\"MANIFEST_UNKNOWN\",\"message\":\"manifest unknown\",\"detail\":{\"Tag\":\"this-does-not-exist\"}}]}\n",
 			errorString:       "manifest unknown: manifest unknown",
-			errorType:         errcode.Errors{},
-			unwrappedErrorPtr: nil,
+			errorType:         ErrManifestUnknown{},
+			unwrappedErrorPtr: &errcode.Errors{},
 		},
 		{ // public.ecr.aws does not implement tag list
 			name: "GET https://public.ecr.aws/v2/nginx/nginx/tags/list",
@@ -98,9 +101,86 @@ func TestRegistryHTTPResponseToError(t *testing.T) {
 				"\r\n" +
 				"404 page not found\n",
 			errorString:       "StatusCode: 404, 404 page not found\n",
-			errorType:         nil,
+			errorType:         RegistryError{},
+			unwrappedErrorPtr: nil,
+		},
+		{
+			name: "HTTP 503 during a maintenance window",
+			response: "HTTP/1.1 503 Service Unavailable\r\n" +
+				"Retry-After: 120\r\n" +
+				"\r\n" +
+				"Registry is in read-only mode\r\n",
+			errorString:       "registry is unavailable due to maintenance, retry after 2m0s: Registry is in read-only mode",
+			errorType:         ErrRegistryMaintenance{},
 			unwrappedErrorPtr: nil,
 		},
+		{
+			name: "HTTP 503 without maintenance hints",
+			response: "HTTP/1.1 503 Service Unavailable\r\n" +
+				"Header1: Value1\r\n" +
+				"\r\n" +
+				"Something went wrong\r\n",
+			errorString: "received unexpected HTTP status: 503 Service Unavailable",
+			errorType:   RegistryError{},
+		},
+		{
+			name: "HTTP 503 with Retry-After but without maintenance hints",
+			response: "HTTP/1.1 503 Service Unavailable\r\n" +
+				"Retry-After: 120\r\n" +
+				"Content-Type: application/json\r\n" +
+				"\r\n" +
+				"{\"errors\":[{\"code\":\"UNAVAILABLE\",\"message\":\"service unavailable\"}]}\n",
+			errorString:       "received unexpected HTTP status: 503 Service Unavailable",
+			errorType:         RegistryError{},
+			unwrappedErrorPtr: nil,
+		},
+		{
+			name: "HTTP 503 mentioning a read-only file system",
+			response: "HTTP/1.1 503 Service Unavailable\r\n" +
+				"\r\n" +
+				"storage backend error: read-only file system\r\n",
+			errorString: "received unexpected HTTP status: 503 Service Unavailable",
+			errorType:   RegistryError{},
+		},
+		{
+			name: "GET a missing blob",
+			response: "HTTP/1.1 404 Not Found\r\n" +
+				"Content-Type: application/json\r\n" +
+				"\r\n" +
+				"{\"errors\":[{\"code\":\"BLOB_UNKNOWN\",\"message\":\"blob unknown to registry\"}]}\n",
+			errorString:       "blob unknown",
+			errorType:         ErrBlobUnknown{},
+			unwrappedErrorPtr: &errcode.Errors{},
+		},
+		{
+			name: "HTTP 403 without an OCI error payload",
+			response: "HTTP/1.1 403 Forbidden\r\n" +
+				"\r\n" +
+				"Forbidden\r\n",
+			errorString: "StatusCode: 403, Forbidden\r\n",
+			errorType:   ErrDenied{},
+		},
+		{
+			name: "HTTP 415 rejecting a manifest",
+			response: "HTTP/1.1 415 Unsupported Media Type\r\n" +
+				"Content-Type: application/json\r\n" +
+				"\r\n" +
+				"{\"errors\":[{\"code\":\"MANIFEST_INVALID\",\"message\":\"manifest invalid\"}]}\n",
+			errorString:       "manifest invalid",
+			errorType:         ErrUnsupportedMediaType{},
+			unwrappedErrorPtr: &errcode.Errors{},
+		},
+		{
+			name: "HTTP 429 with Retry-After",
+			response: "HTTP/1.1 429 Too Many Requests\r\n" +
+				"Content-Type: application/json\r\n" +
+				"Retry-After: 30\r\n" +
+				"\r\n" +
+				"{\"errors\":[{\"code\":\"TOOMANYREQUESTS\",\"message\":\"pull rate limit exceeded\"}]}\n",
+			errorString:       "too many requests to registry, retry after 30s: toomanyrequests: pull rate limit exceeded",
+			errorType:         TooManyRequestsError{},
+			unwrappedErrorPtr: &errcode.Errors{},
+		},
 	} {
 		res, err := http.ReadResponse(bufio.NewReader(bytes.NewReader([]byte(c.response))), nil)
 		require.NoError(t, err, c.name)
@@ -114,5 +194,55 @@ func TestRegistryHTTPResponseToError(t *testing.T) {
 			found := errors.As(err, c.unwrappedErrorPtr)
 			assert.True(t, found, c.name)
 		}
+		assert.Equal(t, res.StatusCode == http.StatusTooManyRequests, errors.Is(err, ErrTooManyRequests), c.name)
+		// The raw response is always available.
+		if res.StatusCode != http.StatusServiceUnavailable || c.errorType != (ErrRegistryMaintenance{}) {
+			var regErr RegistryError
+			require.True(t, errors.As(err, &regErr), c.name)
+			assert.Equal(t, res.StatusCode, regErr.StatusCode, c.name)
+			assert.Equal(t, c.response[strings.Index(c.response, "\r\n\r\n")+4:], string(regErr.Payload), c.name)
+		}
+	}
+}
+
+func TestRegistryErrorTooManyRequestsRetryAfter(t *testing.T) {
+	res, err := http.ReadResponse(bufio.NewReader(bytes.NewReader([]byte("HTTP/1.1 429 Too Many Requests\r\n"+
+		"Retry-After: 120\r\n"+
+		"\r\n"))), nil)
+	require.NoError(t, err)
+	err = httpResponseToError(res, "")
+	assert.True(t, errors.Is(err, ErrTooManyRequests))
+	var tooManyRequests TooManyRequestsError
+	require.True(t, errors.As(err, &tooManyRequests))
+	assert.Equal(t, 2*time.Minute, tooManyRequests.RetryAfter)
+	assert.Equal(t, "too many requests to registry, retry after 2m0s", err.Error())
+}
+
+func TestResourceHTTPResponseToError(t *testing.T) {
+	const notFound = "HTTP/1.1 404 Not Found\r\n" +
+		"\r\n" +
+		"404 page not found\n"
+	const nameUnknown = "HTTP/1.1 404 Not Found\r\n" +
+		"Content-Type: application/json\r\n" +
+		"\r\n" +
+		"{\"errors\":[{\"code\":\"NAME_UNKNOWN\",\"message\":\"repository name not known to registry\"}]}\n"
+	const denied = "HTTP/1.1 403 Forbidden\r\n" +
+		"\r\n"
+	for _, c := range []struct {
+		response  string
+		convert   func(*http.Response) error
+		errorType interface{}
+	}{
+		{notFound, manifestHTTPResponseToError, ErrManifestUnknown{}},
+		{nameUnknown, manifestHTTPResponseToError, ErrManifestUnknown{}},
+		{denied, manifestHTTPResponseToError, ErrDenied{}},
+		{notFound, blobHTTPResponseToError, ErrBlobUnknown{}},
+		{nameUnknown, blobHTTPResponseToError, ErrBlobUnknown{}},
+		{denied, blobHTTPResponseToError, ErrDenied{}},
+	} {
+		res, err := http.ReadResponse(bufio.NewReader(bytes.NewReader([]byte(c.response))), nil)
+		require.NoError(t, err)
+		err = c.convert(res)
+		assert.IsType(t, c.errorType, err, c.response)
 	}
 }
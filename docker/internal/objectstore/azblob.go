@@ -0,0 +1,145 @@
+package objectstore
+
+import (
+	"context"
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/base64"
+	"fmt"
+	"net/http"
+	"net/url"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/pkg/errors"
+)
+
+// azureAPIVersion is the version of the Blob service REST API we use.
+const azureAPIVersion = "2020-10-02"
+
+// azureBackend implements backend for Azure Blob Storage.
+type azureBackend struct {
+	account      string
+	containerURL *url.URL
+	sharedKey    []byte // nil if not using Shared Key authorization
+	sasToken     string // "" if not using a shared access signature
+}
+
+// newAzureBackend returns a backend for an azblob:// URL u.
+func newAzureBackend(u *url.URL, getenv func(string) string) (*azureBackend, error) {
+	account := u.Query().Get("account")
+	if account == "" {
+		account = getenv("AZURE_STORAGE_ACCOUNT")
+	}
+	if account == "" {
+		return nil, errors.Errorf("no Azure storage account specified for %s, use the account option or AZURE_STORAGE_ACCOUNT", u.Redacted())
+	}
+
+	endpoint, err := endpointOption(u)
+	if err != nil {
+		return nil, err
+	}
+	var containerURL *url.URL
+	if endpoint != nil { // e.g. Azurite uses http://127.0.0.1:10000/account as an endpoint.
+		containerURL = joinURLPath(endpoint, u.Host, "")
+	} else {
+		containerURL = &url.URL{Scheme: "https", Host: account + ".blob.core.windows.net", Path: "/" + u.Host + "/"}
+	}
+
+	res := &azureBackend{
+		account:      account,
+		containerURL: containerURL,
+	}
+	if key := getenv("AZURE_STORAGE_KEY"); key != "" {
+		res.sharedKey, err = base64.StdEncoding.DecodeString(key)
+		if err != nil {
+			return nil, errors.Wrap(err, "invalid AZURE_STORAGE_KEY value")
+		}
+	} else if sas := getenv("AZURE_STORAGE_SAS_TOKEN"); sas != "" {
+		sas = strings.TrimPrefix(sas, "?")
+		if _, err := url.ParseQuery(sas); err != nil {
+			return nil, errors.Wrap(err, "invalid AZURE_STORAGE_SAS_TOKEN value")
+		}
+		res.sasToken = sas
+	}
+	return res, nil
+}
+
+func (b *azureBackend) objectURL(key string) *url.URL {
+	res := joinURLPath(b.containerURL, key)
+	res.RawQuery = b.sasToken
+	return res
+}
+
+func (b *azureBackend) prepareRequest(ctx context.Context, req *http.Request, payload []byte) error {
+	req.Header.Set("x-ms-version", azureAPIVersion)
+	req.Header.Set("x-ms-date", time.Now().UTC().Format(http.TimeFormat))
+	if req.Method == http.MethodPut {
+		req.Header.Set("x-ms-blob-type", "BlockBlob")
+	}
+	if b.sharedKey != nil {
+		signAzureRequest(req, int64(len(payload)), b.account, b.sharedKey)
+	}
+	return nil
+}
+
+func (b *azureBackend) deleteReportsMissing() bool {
+	return true
+}
+
+// signAzureRequest adds a Shared Key Authorization header to req, which sends a payload of contentLength bytes,
+// for account using key.
+func signAzureRequest(req *http.Request, contentLength int64, account string, key []byte) {
+	length := ""
+	if contentLength != 0 {
+		length = strconv.FormatInt(contentLength, 10)
+	}
+
+	msHeaders := []string{}
+	for name := range req.Header {
+		lower := strings.ToLower(name)
+		if strings.HasPrefix(lower, "x-ms-") {
+			msHeaders = append(msHeaders, lower)
+		}
+	}
+	sort.Strings(msHeaders)
+	canonicalHeaders := strings.Builder{}
+	for _, name := range msHeaders {
+		canonicalHeaders.WriteString(name + ":" + strings.TrimSpace(req.Header.Get(name)) + "\n")
+	}
+
+	canonicalResource := strings.Builder{}
+	canonicalResource.WriteString("/" + account + req.URL.EscapedPath())
+	query := req.URL.Query()
+	queryNames := make([]string, 0, len(query))
+	for name := range query {
+		queryNames = append(queryNames, name)
+	}
+	sort.Strings(queryNames)
+	for _, name := range queryNames {
+		values := append([]string{}, query[name]...)
+		sort.Strings(values)
+		canonicalResource.WriteString("\n" + strings.ToLower(name) + ":" + strings.Join(values, ","))
+	}
+
+	stringToSign := strings.Join([]string{
+		req.Method,
+		req.Header.Get("Content-Encoding"),
+		req.Header.Get("Content-Language"),
+		length,
+		req.Header.Get("Content-MD5"),
+		req.Header.Get("Content-Type"),
+		"", // Date, x-ms-date is used instead
+		req.Header.Get("If-Modified-Since"),
+		req.Header.Get("If-Match"),
+		req.Header.Get("If-None-Match"),
+		req.Header.Get("If-Unmodified-Since"),
+		req.Header.Get("Range"),
+	}, "\n") + "\n" + canonicalHeaders.String() + canonicalResource.String()
+
+	mac := hmac.New(sha256.New, key)
+	mac.Write([]byte(stringToSign))
+	req.Header.Set("Authorization", fmt.Sprintf("SharedKey %s:%s", account, base64.StdEncoding.EncodeToString(mac.Sum(nil))))
+}
@@ -0,0 +1,92 @@
+package objectstore
+
+import (
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/base64"
+	"net/http"
+	"net/url"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestNewAzureBackend(t *testing.T) {
+	for _, c := range []struct {
+		url, expected string
+		env           map[string]string
+	}{
+		{"azblob://container/a/b?account=acct", "https://acct.blob.core.windows.net/container/a/b", nil},
+		{"azblob://container/a/b", "https://acct.blob.core.windows.net/container/a/b",
+			map[string]string{"AZURE_STORAGE_ACCOUNT": "acct"}},
+		{"azblob://container/a/b?account=devstoreaccount1&endpoint=http://127.0.0.1:10000/devstoreaccount1",
+			"http://127.0.0.1:10000/devstoreaccount1/container/a/b", nil},
+		{"azblob://container/a/b?account=acct", "https://acct.blob.core.windows.net/container/a/b?sv=2020-10-02&sig=abc",
+			map[string]string{"AZURE_STORAGE_SAS_TOKEN": "?sv=2020-10-02&sig=abc"}},
+	} {
+		u, err := url.Parse(c.url)
+		require.NoError(t, err, c.url)
+		b, err := newAzureBackend(u, environment(c.env))
+		require.NoError(t, err, c.url)
+		assert.Equal(t, c.expected, b.objectURL(Key(u)).String(), c.url)
+		assert.Nil(t, b.sharedKey, c.url)
+	}
+
+	u, err := url.Parse("azblob://container/a/b?account=acct")
+	require.NoError(t, err)
+	b, err := newAzureBackend(u, environment(map[string]string{"AZURE_STORAGE_KEY": base64.StdEncoding.EncodeToString([]byte("key"))}))
+	require.NoError(t, err)
+	assert.Equal(t, []byte("key"), b.sharedKey)
+	for _, env := range []map[string]string{
+		{"AZURE_STORAGE_KEY": "not base64!"},
+		{"AZURE_STORAGE_SAS_TOKEN": "sig=%zz"},
+	} {
+		_, err := newAzureBackend(u, environment(env))
+		assert.Error(t, err, env)
+	}
+}
+
+func TestAzurePrepareRequest(t *testing.T) {
+	u, err := url.Parse("azblob://container/a/b?account=acct")
+	require.NoError(t, err)
+	key := []byte("key")
+	b, err := newAzureBackend(u, environment(map[string]string{"AZURE_STORAGE_KEY": base64.StdEncoding.EncodeToString(key)}))
+	require.NoError(t, err)
+
+	for _, c := range []struct {
+		method          string
+		payload         []byte
+		stringToSignFmt string // With %s for the x-ms-date value
+	}{
+		{http.MethodGet, nil,
+			"GET\n\n\n\n\n\n\n\n\n\n\n\nx-ms-date:%s\nx-ms-version:2020-10-02\n/acct/container/a/b"},
+		{http.MethodPut, []byte("data"),
+			"PUT\n\n\n4\n\n\n\n\n\n\n\n\nx-ms-blob-type:BlockBlob\nx-ms-date:%s\nx-ms-version:2020-10-02\n/acct/container/a/b"},
+	} {
+		req, err := http.NewRequest(c.method, b.objectURL(Key(u)).String(), nil)
+		require.NoError(t, err)
+		err = b.prepareRequest(req.Context(), req, c.payload)
+		require.NoError(t, err)
+
+		date := req.Header.Get("x-ms-date")
+		assert.NotEmpty(t, date)
+		mac := hmac.New(sha256.New, key)
+		mac.Write([]byte(strings.ReplaceAll(c.stringToSignFmt, "%s", date)))
+		assert.Equal(t, "SharedKey acct:"+base64.StdEncoding.EncodeToString(mac.Sum(nil)), req.Header.Get("Authorization"), c.method)
+	}
+}
+
+func TestSignAzureRequestQuery(t *testing.T) {
+	key := []byte("key")
+	req, err := http.NewRequest(http.MethodGet, "https://acct.blob.core.windows.net/container/a%20b?comp=metadata&B=2&B=1", nil)
+	require.NoError(t, err)
+	req.Header.Set("x-ms-version", azureAPIVersion)
+	req.Header.Set("Range", "bytes=0-9")
+	signAzureRequest(req, 0, "acct", key)
+
+	mac := hmac.New(sha256.New, key)
+	mac.Write([]byte("GET\n\n\n\n\n\n\n\n\n\n\nbytes=0-9\nx-ms-version:2020-10-02\n/acct/container/a%20b\nb:1,2\ncomp:metadata"))
+	assert.Equal(t, "SharedKey acct:"+base64.StdEncoding.EncodeToString(mac.Sum(nil)), req.Header.Get("Authorization"))
+}
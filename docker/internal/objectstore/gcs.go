@@ -0,0 +1,272 @@
+package objectstore
+
+import (
+	"context"
+	"crypto"
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/sha256"
+	"crypto/x509"
+	"encoding/base64"
+	"encoding/json"
+	"encoding/pem"
+	"net/http"
+	"net/url"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/containers/image/v5/internal/iolimits"
+	"github.com/pkg/errors"
+)
+
+const (
+	// gcsDefaultEndpoint is the endpoint of the Cloud Storage XML API.
+	gcsDefaultEndpoint = "https://storage.googleapis.com"
+	// gcsScope is the OAuth 2.0 scope requested for access tokens.
+	gcsScope = "https://www.googleapis.com/auth/devstorage.read_write"
+	// googleTokenURL is the default OAuth 2.0 token endpoint.
+	googleTokenURL = "https://oauth2.googleapis.com/token"
+	// gcsTokenExpiryMargin is how long before the expiry of an access token it is considered expired.
+	gcsTokenExpiryMargin = time.Minute
+)
+
+// gcsBackend implements backend for Google Cloud Storage, using the XML API.
+type gcsBackend struct {
+	bucketURL *url.URL
+	tokens    gcsTokenSource // nil for anonymous access
+}
+
+// gcsTokenSource provides OAuth 2.0 access tokens.
+type gcsTokenSource interface {
+	// token returns a currently valid access token.
+	token(ctx context.Context) (string, error)
+}
+
+// newGCSBackend returns a backend for a gs:// URL u, using client to obtain access tokens.
+func newGCSBackend(u *url.URL, client *http.Client, getenv func(string) string) (*gcsBackend, error) {
+	endpoint, err := endpointOption(u)
+	if err != nil {
+		return nil, err
+	}
+	if endpoint == nil {
+		endpoint, err = url.Parse(gcsDefaultEndpoint)
+		if err != nil {
+			return nil, err // Coverage: This should never happen.
+		}
+	}
+	tokens, err := newGCSTokenSource(client, getenv)
+	if err != nil {
+		return nil, err
+	}
+	return &gcsBackend{
+		bucketURL: joinURLPath(endpoint, u.Host, ""),
+		tokens:    tokens,
+	}, nil
+}
+
+// newGCSTokenSource returns a gcsTokenSource using credentials from the environment, or nil if there are no credentials.
+func newGCSTokenSource(client *http.Client, getenv func(string) string) (gcsTokenSource, error) {
+	if token := getenv("GOOGLE_OAUTH_ACCESS_TOKEN"); token != "" {
+		return staticToken(token), nil
+	}
+	path := getenv("GOOGLE_APPLICATION_CREDENTIALS")
+	if path == "" {
+		home := getenv("HOME")
+		if home == "" {
+			return nil, nil
+		}
+		path = filepath.Join(home, ".config", "gcloud", "application_default_credentials.json")
+		if _, err := os.Stat(path); err != nil {
+			if os.IsNotExist(err) {
+				return nil, nil
+			}
+			return nil, err
+		}
+	}
+	contents, err := os.ReadFile(path)
+	if err != nil {
+		return nil, errors.Wrap(err, "reading Google application credentials")
+	}
+	var credentials struct {
+		Type         string `json:"type"`
+		ClientEmail  string `json:"client_email"`
+		PrivateKey   string `json:"private_key"`
+		TokenURI     string `json:"token_uri"`
+		ClientID     string `json:"client_id"`
+		ClientSecret string `json:"client_secret"`
+		RefreshToken string `json:"refresh_token"`
+	}
+	if err := json.Unmarshal(contents, &credentials); err != nil {
+		return nil, errors.Wrapf(err, "parsing Google application credentials %s", path)
+	}
+	tokenURL := credentials.TokenURI
+	if tokenURL == "" {
+		tokenURL = googleTokenURL
+	}
+	switch credentials.Type {
+	case "service_account":
+		block, _ := pem.Decode([]byte(credentials.PrivateKey))
+		if block == nil {
+			return nil, errors.Errorf("no private key found in Google application credentials %s", path)
+		}
+		key, err := parseRSAPrivateKey(block.Bytes)
+		if err != nil {
+			return nil, errors.Wrapf(err, "parsing private key in Google application credentials %s", path)
+		}
+		return &oauthTokenSource{
+			client:   client,
+			tokenURL: tokenURL,
+			form: func(now time.Time) (url.Values, error) {
+				assertion, err := serviceAccountAssertion(credentials.ClientEmail, key, tokenURL, now)
+				if err != nil {
+					return nil, err
+				}
+				return url.Values{
+					"grant_type": {"urn:ietf:params:oauth:grant-type:jwt-bearer"},
+					"assertion":  {assertion},
+				}, nil
+			},
+		}, nil
+	case "authorized_user":
+		return &oauthTokenSource{
+			client:   client,
+			tokenURL: tokenURL,
+			form: func(now time.Time) (url.Values, error) {
+				return url.Values{
+					"grant_type":    {"refresh_token"},
+					"client_id":     {credentials.ClientID},
+					"client_secret": {credentials.ClientSecret},
+					"refresh_token": {credentials.RefreshToken},
+				}, nil
+			},
+		}, nil
+	default:
+		return nil, errors.Errorf("unsupported type %q of Google application credentials %s", credentials.Type, path)
+	}
+}
+
+// parseRSAPrivateKey parses a PKCS #8 or PKCS #1 DER-encoded RSA private key.
+func parseRSAPrivateKey(der []byte) (*rsa.PrivateKey, error) {
+	if key, err := x509.ParsePKCS1PrivateKey(der); err == nil {
+		return key, nil
+	}
+	key, err := x509.ParsePKCS8PrivateKey(der)
+	if err != nil {
+		return nil, err
+	}
+	rsaKey, ok := key.(*rsa.PrivateKey)
+	if !ok {
+		return nil, errors.Errorf("unexpected private key type %T", key)
+	}
+	return rsaKey, nil
+}
+
+// serviceAccountAssertion returns a JWT signed using key, asserting the identity of a service account with email,
+// for use with an OAuth 2.0 token endpoint at tokenURL at time now.
+func serviceAccountAssertion(email string, key *rsa.PrivateKey, tokenURL string, now time.Time) (string, error) {
+	header, err := json.Marshal(map[string]string{"alg": "RS256", "typ": "JWT"})
+	if err != nil {
+		return "", err
+	}
+	claims, err := json.Marshal(map[string]interface{}{
+		"iss":   email,
+		"scope": gcsScope,
+		"aud":   tokenURL,
+		"iat":   now.Unix(),
+		"exp":   now.Add(time.Hour).Unix(),
+	})
+	if err != nil {
+		return "", err
+	}
+	signed := base64.RawURLEncoding.EncodeToString(header) + "." + base64.RawURLEncoding.EncodeToString(claims)
+	digest := sha256.Sum256([]byte(signed))
+	signature, err := rsa.SignPKCS1v15(rand.Reader, key, crypto.SHA256, digest[:])
+	if err != nil {
+		return "", err
+	}
+	return signed + "." + base64.RawURLEncoding.EncodeToString(signature), nil
+}
+
+// staticToken is a gcsTokenSource which always returns the same token.
+type staticToken string
+
+func (t staticToken) token(ctx context.Context) (string, error) {
+	return string(t), nil
+}
+
+// oauthTokenSource is a gcsTokenSource which obtains tokens from an OAuth 2.0 token endpoint.
+type oauthTokenSource struct {
+	client   *http.Client
+	tokenURL string
+	form     func(now time.Time) (url.Values, error) // Returns the parameters of a token request at now.
+
+	lock        sync.Mutex
+	cachedToken string
+	expiry      time.Time // Valid if cachedToken != ""
+}
+
+func (s *oauthTokenSource) token(ctx context.Context) (string, error) {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+
+	now := time.Now()
+	if s.cachedToken != "" && now.Before(s.expiry) {
+		return s.cachedToken, nil
+	}
+	form, err := s.form(now)
+	if err != nil {
+		return "", err
+	}
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, s.tokenURL, strings.NewReader(form.Encode()))
+	if err != nil {
+		return "", err
+	}
+	req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+	res, err := s.client.Do(req)
+	if err != nil {
+		return "", errors.Wrap(err, "obtaining an access token")
+	}
+	defer res.Body.Close()
+	body, err := iolimits.ReadAtMost(res.Body, iolimits.MaxAuthTokenBodySize)
+	if err != nil {
+		return "", errors.Wrap(err, "obtaining an access token")
+	}
+	if res.StatusCode != http.StatusOK {
+		return "", errors.Errorf("obtaining an access token: status %d (%s): %s", res.StatusCode, http.StatusText(res.StatusCode), strings.TrimSpace(string(body)))
+	}
+	var response struct {
+		AccessToken string `json:"access_token"`
+		ExpiresIn   int64  `json:"expires_in"`
+	}
+	if err := json.Unmarshal(body, &response); err != nil {
+		return "", errors.Wrap(err, "parsing access token response")
+	}
+	if response.AccessToken == "" {
+		return "", errors.New("access token response does not contain a token")
+	}
+	s.cachedToken = response.AccessToken
+	s.expiry = now.Add(time.Duration(response.ExpiresIn)*time.Second - gcsTokenExpiryMargin)
+	return s.cachedToken, nil
+}
+
+func (b *gcsBackend) objectURL(key string) *url.URL {
+	return joinURLPath(b.bucketURL, key)
+}
+
+func (b *gcsBackend) prepareRequest(ctx context.Context, req *http.Request, payload []byte) error {
+	if b.tokens != nil {
+		token, err := b.tokens.token(ctx)
+		if err != nil {
+			return err
+		}
+		req.Header.Set("Authorization", "Bearer "+token)
+	}
+	return nil
+}
+
+func (b *gcsBackend) deleteReportsMissing() bool {
+	return true
+}
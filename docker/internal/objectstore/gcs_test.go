@@ -0,0 +1,198 @@
+package objectstore
+
+import (
+	"context"
+	"crypto"
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/sha256"
+	"crypto/x509"
+	"encoding/base64"
+	"encoding/json"
+	"encoding/pem"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// writeGoogleCredentials writes credentials to a file in a new temporary directory, and returns its path.
+func writeGoogleCredentials(t *testing.T, credentials map[string]string) string {
+	contents, err := json.Marshal(credentials)
+	require.NoError(t, err)
+	path := filepath.Join(t.TempDir(), "credentials.json")
+	err = os.WriteFile(path, contents, 0600)
+	require.NoError(t, err)
+	return path
+}
+
+// fakeTokenServer returns a server issuing access tokens, using handleForm to validate token requests,
+// and a pointer to the number of tokens it has issued.
+func fakeTokenServer(t *testing.T, handleForm func(form url.Values)) (*httptest.Server, *int) {
+	issued := 0
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+		assert.Equal(t, http.MethodPost, req.Method)
+		err := req.ParseForm()
+		require.NoError(t, err)
+		handleForm(req.PostForm)
+		issued++
+		_, _ = w.Write([]byte(`{"access_token":"issued-token","expires_in":3600,"token_type":"Bearer"}`))
+	}))
+	t.Cleanup(server.Close)
+	return server, &issued
+}
+
+func TestNewGCSBackend(t *testing.T) {
+	for _, c := range []struct{ url, expected string }{
+		{"gs://bucket/a/b", "https://storage.googleapis.com/bucket/a/b"},
+		{"gs://bucket/a/b?endpoint=http://127.0.0.1:4443", "http://127.0.0.1:4443/bucket/a/b"},
+	} {
+		u, err := url.Parse(c.url)
+		require.NoError(t, err, c.url)
+		b, err := newGCSBackend(u, http.DefaultClient, noEnvironment)
+		require.NoError(t, err, c.url)
+		assert.Equal(t, c.expected, b.objectURL(Key(u)).String(), c.url)
+		assert.Nil(t, b.tokens, c.url)
+	}
+}
+
+func TestNewGCSTokenSource(t *testing.T) {
+	// No credentials
+	ts, err := newGCSTokenSource(http.DefaultClient, noEnvironment)
+	require.NoError(t, err)
+	assert.Nil(t, ts)
+	ts, err = newGCSTokenSource(http.DefaultClient, environment(map[string]string{"HOME": t.TempDir()}))
+	require.NoError(t, err)
+	assert.Nil(t, ts)
+
+	// A static token
+	ts, err = newGCSTokenSource(http.DefaultClient, environment(map[string]string{"GOOGLE_OAUTH_ACCESS_TOKEN": "static"}))
+	require.NoError(t, err)
+	token, err := ts.token(context.Background())
+	require.NoError(t, err)
+	assert.Equal(t, "static", token)
+
+	// Invalid credentials
+	for _, credentials := range []map[string]string{
+		{"type": "external_account"},
+		{"type": "service_account", "private_key": "not a key"},
+		{"type": "service_account", "private_key": string(pem.EncodeToMemory(&pem.Block{Type: "PRIVATE KEY", Bytes: []byte("invalid")}))},
+	} {
+		path := writeGoogleCredentials(t, credentials)
+		_, err := newGCSTokenSource(http.DefaultClient, environment(map[string]string{"GOOGLE_APPLICATION_CREDENTIALS": path}))
+		assert.Error(t, err, credentials)
+	}
+	_, err = newGCSTokenSource(http.DefaultClient, environment(map[string]string{"GOOGLE_APPLICATION_CREDENTIALS": "/this/does/not/exist"}))
+	assert.Error(t, err)
+}
+
+func TestGCSServiceAccountToken(t *testing.T) {
+	key, err := rsa.GenerateKey(rand.Reader, 2048)
+	require.NoError(t, err)
+	keyDER, err := x509.MarshalPKCS8PrivateKey(key)
+	require.NoError(t, err)
+
+	var tokenURL string
+	server, issued := fakeTokenServer(t, func(form url.Values) {
+		assert.Equal(t, "urn:ietf:params:oauth:grant-type:jwt-bearer", form.Get("grant_type"))
+		parts := strings.Split(form.Get("assertion"), ".")
+		require.Len(t, parts, 3)
+		signature, err := base64.RawURLEncoding.DecodeString(parts[2])
+		require.NoError(t, err)
+		digest := sha256.Sum256([]byte(parts[0] + "." + parts[1]))
+		err = rsa.VerifyPKCS1v15(&key.PublicKey, crypto.SHA256, digest[:], signature)
+		require.NoError(t, err)
+		claimsJSON, err := base64.RawURLEncoding.DecodeString(parts[1])
+		require.NoError(t, err)
+		var claims map[string]interface{}
+		err = json.Unmarshal(claimsJSON, &claims)
+		require.NoError(t, err)
+		assert.Equal(t, "sa@example.iam.gserviceaccount.com", claims["iss"])
+		assert.Equal(t, gcsScope, claims["scope"])
+		assert.Equal(t, tokenURL, claims["aud"])
+	})
+	tokenURL = server.URL + "/token"
+
+	path := writeGoogleCredentials(t, map[string]string{
+		"type":         "service_account",
+		"client_email": "sa@example.iam.gserviceaccount.com",
+		"private_key":  string(pem.EncodeToMemory(&pem.Block{Type: "PRIVATE KEY", Bytes: keyDER})),
+		"token_uri":    tokenURL,
+	})
+	ts, err := newGCSTokenSource(server.Client(), environment(map[string]string{"GOOGLE_APPLICATION_CREDENTIALS": path}))
+	require.NoError(t, err)
+	for i := 0; i < 2; i++ {
+		token, err := ts.token(context.Background())
+		require.NoError(t, err)
+		assert.Equal(t, "issued-token", token)
+	}
+	assert.Equal(t, 1, *issued) // The token is cached
+}
+
+func TestGCSAuthorizedUserToken(t *testing.T) {
+	server, issued := fakeTokenServer(t, func(form url.Values) {
+		assert.Equal(t, url.Values{
+			"grant_type":    {"refresh_token"},
+			"client_id":     {"client-id"},
+			"client_secret": {"client-secret"},
+			"refresh_token": {"refresh-token"},
+		}, form)
+	})
+
+	// The gcloud default location
+	home := t.TempDir()
+	path := filepath.Join(home, ".config", "gcloud", "application_default_credentials.json")
+	err := os.MkdirAll(filepath.Dir(path), 0755)
+	require.NoError(t, err)
+	err = os.Rename(writeGoogleCredentials(t, map[string]string{
+		"type":          "authorized_user",
+		"client_id":     "client-id",
+		"client_secret": "client-secret",
+		"refresh_token": "refresh-token",
+		"token_uri":     server.URL,
+	}), path)
+	require.NoError(t, err)
+
+	u, err := url.Parse("gs://bucket/key")
+	require.NoError(t, err)
+	b, err := newGCSBackend(u, server.Client(), environment(map[string]string{"HOME": home}))
+	require.NoError(t, err)
+	req, err := http.NewRequest(http.MethodGet, b.objectURL("key").String(), nil)
+	require.NoError(t, err)
+	err = b.prepareRequest(context.Background(), req, nil)
+	require.NoError(t, err)
+	assert.Equal(t, "Bearer issued-token", req.Header.Get("Authorization"))
+	assert.Equal(t, 1, *issued)
+}
+
+func TestGCSTokenErrors(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+		switch req.URL.Path {
+		case "/denied":
+			w.WriteHeader(http.StatusBadRequest)
+			_, _ = w.Write([]byte(`{"error":"invalid_grant"}`))
+		case "/invalid":
+			_, _ = w.Write([]byte(`not JSON`))
+		case "/empty":
+			_, _ = w.Write([]byte(`{}`))
+		}
+	}))
+	defer server.Close()
+
+	for _, path := range []string{"/denied", "/invalid", "/empty"} {
+		credentialsPath := writeGoogleCredentials(t, map[string]string{
+			"type":      "authorized_user",
+			"token_uri": server.URL + path,
+		})
+		ts, err := newGCSTokenSource(server.Client(), environment(map[string]string{"GOOGLE_APPLICATION_CREDENTIALS": credentialsPath}))
+		require.NoError(t, err, path)
+		_, err = ts.token(context.Background())
+		assert.Error(t, err, path)
+	}
+}
@@ -0,0 +1,205 @@
+// Package objectstore implements access to lookaside signature storage in cloud object stores
+// (Amazon S3 and compatible services, Google Cloud Storage, and Azure Blob Storage),
+// using credentials from the environment.
+package objectstore
+
+import (
+	"bytes"
+	"context"
+	"io"
+	"net/http"
+	"net/url"
+	"os"
+	"strings"
+
+	"github.com/containers/image/v5/internal/iolimits"
+	"github.com/pkg/errors"
+	"github.com/sirupsen/logrus"
+)
+
+// Store is a bucket (or, in Azure terms, a container) of an object store.
+type Store interface {
+	// Get returns the contents of the object with key, or missing = true if it does not exist.
+	// Objects larger than maxSize are rejected.
+	Get(ctx context.Context, key string, maxSize int) (data []byte, missing bool, err error)
+	// Put creates or replaces the object with key.
+	Put(ctx context.Context, key string, data []byte) error
+	// Delete deletes the object with key, or returns missing = true if it does not exist.
+	Delete(ctx context.Context, key string) (missing bool, err error)
+}
+
+// IsSupportedScheme returns true if scheme is an URL scheme supported by New.
+func IsSupportedScheme(scheme string) bool {
+	switch scheme {
+	case "s3", "gs", "azblob":
+		return true
+	default:
+		return false
+	}
+}
+
+// New returns a Store for the bucket specified in u, an URL in the scheme://bucket/path?options format,
+// using client for HTTP requests, and credentials from the environment.
+// NOTE: Keep this in sync with docs/containers-registries.d.5.md!
+func New(u *url.URL, client *http.Client) (Store, error) {
+	return newWithEnvironment(u, client, os.Getenv)
+}
+
+// newWithEnvironment is New, reading environment variables using getenv.
+func newWithEnvironment(u *url.URL, client *http.Client, getenv func(string) string) (Store, error) {
+	if u.Host == "" {
+		return nil, errors.Errorf("missing bucket name in %s", u.Redacted())
+	}
+	var b backend
+	var err error
+	switch u.Scheme {
+	case "s3":
+		b, err = newS3Backend(u, getenv)
+	case "gs":
+		b, err = newGCSBackend(u, client, getenv)
+	case "azblob":
+		b, err = newAzureBackend(u, getenv)
+	default:
+		return nil, errors.Errorf("unsupported object store URL scheme %q", u.Scheme)
+	}
+	if err != nil {
+		return nil, err
+	}
+	return &store{client: client, backend: b}, nil
+}
+
+// Key returns the key of the object specified in u, an URL in the format accepted by New.
+func Key(u *url.URL) string {
+	return strings.TrimPrefix(u.Path, "/")
+}
+
+// backend implements the protocol-specific parts of a Store.
+type backend interface {
+	// objectURL returns the URL of the object with key.
+	objectURL(key string) *url.URL
+	// prepareRequest adds protocol-specific headers, including authentication, to req, which sends payload.
+	prepareRequest(ctx context.Context, req *http.Request, payload []byte) error
+	// deleteReportsMissing returns true if deleting a missing object fails with http.StatusNotFound.
+	deleteReportsMissing() bool
+}
+
+// store implements Store using a backend.
+type store struct {
+	client  *http.Client
+	backend backend
+}
+
+// do performs a request with method for the object with key, sending payload, and returns the response.
+// The caller must close the response body.
+func (s *store) do(ctx context.Context, method, key string, payload []byte) (*http.Response, error) {
+	u := s.backend.objectURL(key)
+	logrus.Debugf("%s %s", method, u.Redacted())
+	var body io.Reader
+	if payload != nil {
+		body = bytes.NewReader(payload)
+	}
+	req, err := http.NewRequestWithContext(ctx, method, u.String(), body)
+	if err != nil {
+		return nil, err
+	}
+	if err := s.backend.prepareRequest(ctx, req, payload); err != nil {
+		return nil, err
+	}
+	return s.client.Do(req)
+}
+
+// Get implements Store.Get.
+func (s *store) Get(ctx context.Context, key string, maxSize int) ([]byte, bool, error) {
+	res, err := s.do(ctx, http.MethodGet, key, nil)
+	if err != nil {
+		return nil, false, err
+	}
+	defer res.Body.Close()
+	switch res.StatusCode {
+	case http.StatusOK:
+		data, err := iolimits.ReadAtMost(res.Body, maxSize)
+		if err != nil {
+			return nil, false, errors.Wrapf(err, "reading %s", key)
+		}
+		return data, false, nil
+	case http.StatusNotFound:
+		return nil, true, nil
+	default:
+		return nil, false, unexpectedResponseError("reading", key, res)
+	}
+}
+
+// Put implements Store.Put.
+func (s *store) Put(ctx context.Context, key string, data []byte) error {
+	res, err := s.do(ctx, http.MethodPut, key, data)
+	if err != nil {
+		return err
+	}
+	defer res.Body.Close()
+	if res.StatusCode != http.StatusOK && res.StatusCode != http.StatusCreated {
+		return unexpectedResponseError("writing", key, res)
+	}
+	return nil
+}
+
+// Delete implements Store.Delete.
+func (s *store) Delete(ctx context.Context, key string) (bool, error) {
+	if !s.backend.deleteReportsMissing() {
+		res, err := s.do(ctx, http.MethodHead, key, nil)
+		if err != nil {
+			return false, err
+		}
+		res.Body.Close()
+		switch res.StatusCode {
+		case http.StatusOK:
+		case http.StatusNotFound:
+			return true, nil
+		default:
+			return false, unexpectedResponseError("checking", key, res)
+		}
+	}
+	res, err := s.do(ctx, http.MethodDelete, key, nil)
+	if err != nil {
+		return false, err
+	}
+	defer res.Body.Close()
+	switch res.StatusCode {
+	case http.StatusOK, http.StatusAccepted, http.StatusNoContent:
+		return false, nil
+	case http.StatusNotFound:
+		return true, nil
+	default:
+		return false, unexpectedResponseError("deleting", key, res)
+	}
+}
+
+// unexpectedResponseError returns an error describing an unexpected response res to an operation on key.
+func unexpectedResponseError(operation, key string, res *http.Response) error {
+	body, _ := iolimits.ReadAtMost(res.Body, iolimits.MaxErrorBodySize)
+	return errors.Errorf("%s %s: status %d (%s): %s", operation, key, res.StatusCode, http.StatusText(res.StatusCode), strings.TrimSpace(string(body)))
+}
+
+// endpointOption returns the value of the "endpoint" query parameter of u, parsed, or nil if it is not present.
+func endpointOption(u *url.URL) (*url.URL, error) {
+	value := u.Query().Get("endpoint")
+	if value == "" {
+		return nil, nil
+	}
+	endpoint, err := url.Parse(value)
+	if err != nil {
+		return nil, errors.Wrapf(err, "invalid endpoint %q", value)
+	}
+	if (endpoint.Scheme != "http" && endpoint.Scheme != "https") || endpoint.Host == "" {
+		return nil, errors.Errorf("invalid endpoint %q, expected an http:// or https:// URL", value)
+	}
+	return endpoint, nil
+}
+
+// joinURLPath returns a copy of base, without a query, with path elements appended to its path.
+func joinURLPath(base *url.URL, elements ...string) *url.URL {
+	res := *base
+	res.RawQuery = ""
+	res.RawPath = ""
+	res.Path = strings.TrimSuffix(base.Path, "/") + "/" + strings.Join(elements, "/")
+	return &res
+}
@@ -0,0 +1,203 @@
+package objectstore
+
+import (
+	"context"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"sync"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// fakeObjectServer is an in-memory object store, keyed by request path.
+type fakeObjectServer struct {
+	t                    *testing.T
+	deleteReportsMissing bool                         // If false, deleting a missing object succeeds, as in S3.
+	authorize            func(req *http.Request) bool // If not nil, requests for which this returns false are rejected.
+
+	lock     sync.Mutex
+	objects  map[string][]byte
+	requests []*http.Request
+}
+
+func newFakeObjectServer(t *testing.T, deleteReportsMissing bool) *fakeObjectServer {
+	return &fakeObjectServer{
+		t:                    t,
+		deleteReportsMissing: deleteReportsMissing,
+		objects:              map[string][]byte{},
+	}
+}
+
+func (s *fakeObjectServer) ServeHTTP(w http.ResponseWriter, req *http.Request) {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+
+	s.requests = append(s.requests, req)
+	if s.authorize != nil && !s.authorize(req) {
+		w.WriteHeader(http.StatusForbidden)
+		return
+	}
+	data, exists := s.objects[req.URL.Path]
+	switch req.Method {
+	case http.MethodGet, http.MethodHead:
+		if !exists {
+			w.WriteHeader(http.StatusNotFound)
+			return
+		}
+		if req.Method == http.MethodGet {
+			_, _ = w.Write(data)
+		}
+	case http.MethodPut:
+		body, err := io.ReadAll(req.Body)
+		require.NoError(s.t, err)
+		s.objects[req.URL.Path] = body
+		w.WriteHeader(http.StatusCreated)
+	case http.MethodDelete:
+		if !exists && s.deleteReportsMissing {
+			w.WriteHeader(http.StatusNotFound)
+			return
+		}
+		delete(s.objects, req.URL.Path)
+		w.WriteHeader(http.StatusNoContent)
+	default:
+		w.WriteHeader(http.StatusMethodNotAllowed)
+	}
+}
+
+// lastRequest returns the most recent request received by s.
+func (s *fakeObjectServer) lastRequest() *http.Request {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+	require.NotEmpty(s.t, s.requests)
+	return s.requests[len(s.requests)-1]
+}
+
+// noEnvironment is a getenv function for an empty environment.
+func noEnvironment(string) string {
+	return ""
+}
+
+// environment returns a getenv function for env.
+func environment(env map[string]string) func(string) string {
+	return func(name string) string {
+		return env[name]
+	}
+}
+
+func TestIsSupportedScheme(t *testing.T) {
+	for _, scheme := range []string{"s3", "gs", "azblob"} {
+		assert.True(t, IsSupportedScheme(scheme), scheme)
+	}
+	for _, scheme := range []string{"", "file", "http", "https", "S3", "gcs"} {
+		assert.False(t, IsSupportedScheme(scheme), scheme)
+	}
+}
+
+func TestNewInvalid(t *testing.T) {
+	for _, s := range []string{
+		"s3:///no-bucket",
+		"ftp://bucket/path",
+		"s3://bucket/path?endpoint=ftp://example.com",
+		"gs://bucket/path?endpoint=not-an-url",
+		"azblob://container/path?endpoint=http://",
+		"azblob://container/no-account",
+	} {
+		u, err := url.Parse(s)
+		require.NoError(t, err, s)
+		_, err = newWithEnvironment(u, http.DefaultClient, noEnvironment)
+		assert.Error(t, err, s)
+	}
+}
+
+func TestKey(t *testing.T) {
+	for _, c := range []struct{ input, expected string }{
+		{"s3://bucket/a/b/c", "a/b/c"},
+		{"gs://bucket/a@sha256=0123/signature-1", "a@sha256=0123/signature-1"},
+		{"azblob://container/a/b?account=x", "a/b"},
+		{"s3://bucket", ""},
+	} {
+		u, err := url.Parse(c.input)
+		require.NoError(t, err, c.input)
+		assert.Equal(t, c.expected, Key(u), c.input)
+	}
+}
+
+func TestStoreOperations(t *testing.T) {
+	for _, c := range []struct {
+		scheme               string
+		deleteReportsMissing bool
+		env                  map[string]string
+	}{
+		{"s3", false, nil},
+		{"gs", true, map[string]string{"GOOGLE_OAUTH_ACCESS_TOKEN": "token"}},
+		{"azblob", true, map[string]string{"AZURE_STORAGE_ACCOUNT": "account"}},
+	} {
+		server := newFakeObjectServer(t, c.deleteReportsMissing)
+		httpServer := httptest.NewServer(server)
+		defer httpServer.Close()
+
+		u, err := url.Parse(c.scheme + "://bucket/prefix/object?endpoint=" + url.QueryEscape(httpServer.URL))
+		require.NoError(t, err)
+		store, err := newWithEnvironment(u, httpServer.Client(), environment(c.env))
+		require.NoError(t, err, c.scheme)
+		ctx := context.Background()
+		key := Key(u)
+
+		// Missing objects
+		_, missing, err := store.Get(ctx, key, 1024)
+		require.NoError(t, err, c.scheme)
+		assert.True(t, missing, c.scheme)
+		missing, err = store.Delete(ctx, key)
+		require.NoError(t, err, c.scheme)
+		assert.True(t, missing, c.scheme)
+
+		// Writing and reading
+		err = store.Put(ctx, key, []byte("contents"))
+		require.NoError(t, err, c.scheme)
+		assert.Equal(t, []byte("contents"), server.objects["/bucket/prefix/object"], c.scheme)
+		data, missing, err := store.Get(ctx, key, 1024)
+		require.NoError(t, err, c.scheme)
+		assert.False(t, missing, c.scheme)
+		assert.Equal(t, []byte("contents"), data, c.scheme)
+
+		// Objects over the size limit
+		_, _, err = store.Get(ctx, key, 3)
+		assert.Error(t, err, c.scheme)
+
+		// Deleting
+		missing, err = store.Delete(ctx, key)
+		require.NoError(t, err, c.scheme)
+		assert.False(t, missing, c.scheme)
+		assert.NotContains(t, server.objects, "/bucket/prefix/object", c.scheme)
+
+		// Errors
+		server.authorize = func(req *http.Request) bool { return false }
+		_, _, err = store.Get(ctx, key, 1024)
+		assert.Error(t, err, c.scheme)
+		err = store.Put(ctx, key, []byte("contents"))
+		assert.Error(t, err, c.scheme)
+		_, err = store.Delete(ctx, key)
+		assert.Error(t, err, c.scheme)
+	}
+}
+
+func TestJoinURLPath(t *testing.T) {
+	for _, c := range []struct {
+		base     string
+		elements []string
+		expected string
+	}{
+		{"https://example.com", []string{"bucket", ""}, "https://example.com/bucket/"},
+		{"https://example.com/", []string{"bucket", ""}, "https://example.com/bucket/"},
+		{"http://127.0.0.1:10000/account?query", []string{"container", ""}, "http://127.0.0.1:10000/account/container/"},
+		{"https://example.com/bucket/", []string{"a/b"}, "https://example.com/bucket/a/b"},
+	} {
+		base, err := url.Parse(c.base)
+		require.NoError(t, err)
+		assert.Equal(t, c.expected, joinURLPath(base, c.elements...).String(), c.base)
+	}
+}
@@ -0,0 +1,98 @@
+package objectstore
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"net/url"
+	"strings"
+	"time"
+
+	"github.com/containers/image/v5/internal/awssigv4"
+	"github.com/pkg/errors"
+)
+
+// s3Backend implements backend for Amazon S3 and compatible services.
+type s3Backend struct {
+	bucketURL   *url.URL // With a trailing /, to be followed by a key.
+	region      string
+	credentials *awssigv4.Credentials // nil for anonymous access
+}
+
+// newS3Backend returns a backend for a s3:// URL u.
+func newS3Backend(u *url.URL, getenv func(string) string) (*s3Backend, error) {
+	bucket := u.Host
+	region := u.Query().Get("region")
+	if region == "" {
+		region = getenv("AWS_REGION")
+	}
+	if region == "" {
+		region = getenv("AWS_DEFAULT_REGION")
+	}
+	if region == "" {
+		region = "us-east-1"
+	}
+
+	endpoint, err := endpointOption(u)
+	if err != nil {
+		return nil, err
+	}
+	if endpoint == nil {
+		for _, env := range []string{"AWS_ENDPOINT_URL_S3", "AWS_ENDPOINT_URL"} {
+			if value := getenv(env); value != "" {
+				endpoint, err = url.Parse(value)
+				if err != nil || (endpoint.Scheme != "http" && endpoint.Scheme != "https") || endpoint.Host == "" {
+					return nil, errors.Errorf("invalid %s value %q", env, value)
+				}
+				break
+			}
+		}
+	}
+	var bucketURL *url.URL
+	switch {
+	case endpoint != nil: // Services other than AWS frequently only support path-style requests.
+		bucketURL = joinURLPath(endpoint, bucket, "")
+	case strings.Contains(bucket, "."): // Virtual-hosted–style requests don't work with TLS for such buckets.
+		bucketURL = &url.URL{Scheme: "https", Host: fmt.Sprintf("s3.%s.amazonaws.com", region), Path: "/" + bucket + "/"}
+	default:
+		bucketURL = &url.URL{Scheme: "https", Host: fmt.Sprintf("%s.s3.%s.amazonaws.com", bucket, region), Path: "/"}
+	}
+
+	var credentials *awssigv4.Credentials
+	if accessKeyID := getenv("AWS_ACCESS_KEY_ID"); accessKeyID != "" {
+		secretAccessKey := getenv("AWS_SECRET_ACCESS_KEY")
+		if secretAccessKey == "" {
+			return nil, errors.New("AWS_ACCESS_KEY_ID is set, but AWS_SECRET_ACCESS_KEY is not")
+		}
+		credentials = &awssigv4.Credentials{
+			AccessKeyID:     accessKeyID,
+			SecretAccessKey: secretAccessKey,
+			SessionToken:    getenv("AWS_SESSION_TOKEN"),
+		}
+	}
+	return &s3Backend{
+		bucketURL:   bucketURL,
+		region:      region,
+		credentials: credentials,
+	}, nil
+}
+
+func (b *s3Backend) objectURL(key string) *url.URL {
+	res := *b.bucketURL
+	res.Path += key
+	res.RawPath = awssigv4.URIEncode(b.bucketURL.Path, false) + awssigv4.URIEncode(key, false)
+	return &res
+}
+
+func (b *s3Backend) prepareRequest(ctx context.Context, req *http.Request, payload []byte) error {
+	payloadHash := awssigv4.PayloadHash(payload)
+	req.Header.Set("x-amz-content-sha256", payloadHash)
+	if b.credentials != nil {
+		awssigv4.SignRequest(req, payloadHash, "s3", b.region, *b.credentials, time.Now())
+	}
+	return nil
+}
+
+func (b *s3Backend) deleteReportsMissing() bool {
+	return false // S3 reports success when deleting a missing object.
+}
@@ -0,0 +1,89 @@
+package objectstore
+
+import (
+	"net/http"
+	"net/url"
+	"strings"
+	"testing"
+
+	"github.com/containers/image/v5/internal/awssigv4"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestNewS3Backend(t *testing.T) {
+	for _, c := range []struct {
+		url, expectedURL, expectedRegion string
+		env                              map[string]string
+	}{
+		{"s3://bucket/a/b", "https://bucket.s3.us-east-1.amazonaws.com/a/b", "us-east-1", nil},
+		{"s3://bucket/a/b", "https://bucket.s3.eu-west-1.amazonaws.com/a/b", "eu-west-1",
+			map[string]string{"AWS_DEFAULT_REGION": "eu-west-1"}},
+		{"s3://bucket/a/b", "https://bucket.s3.eu-west-2.amazonaws.com/a/b", "eu-west-2",
+			map[string]string{"AWS_REGION": "eu-west-2", "AWS_DEFAULT_REGION": "eu-west-1"}},
+		{"s3://bucket/a/b?region=eu-west-3", "https://bucket.s3.eu-west-3.amazonaws.com/a/b", "eu-west-3",
+			map[string]string{"AWS_REGION": "eu-west-2"}},
+		{"s3://example.com/a/b", "https://s3.us-east-1.amazonaws.com/example.com/a/b", "us-east-1", nil},
+		{"s3://bucket/a/b?endpoint=http://127.0.0.1:9000", "http://127.0.0.1:9000/bucket/a/b", "us-east-1", nil},
+		{"s3://bucket/a/b", "https://minio.example.com/bucket/a/b", "us-east-1",
+			map[string]string{"AWS_ENDPOINT_URL": "https://minio.example.com"}},
+		{"s3://bucket/a/b", "https://s3.example.com/bucket/a/b", "us-east-1",
+			map[string]string{"AWS_ENDPOINT_URL_S3": "https://s3.example.com", "AWS_ENDPOINT_URL": "https://minio.example.com"}},
+		{"s3://bucket/a/b?endpoint=https://s3.example.com/", "https://s3.example.com/bucket/a/b", "us-east-1",
+			map[string]string{"AWS_ENDPOINT_URL": "https://minio.example.com"}},
+		{"s3://bucket/a b+c", "https://bucket.s3.us-east-1.amazonaws.com/a%20b%2Bc", "us-east-1", nil},
+	} {
+		u, err := url.Parse(c.url)
+		require.NoError(t, err, c.url)
+		b, err := newS3Backend(u, environment(c.env))
+		require.NoError(t, err, c.url)
+		assert.Equal(t, c.expectedURL, b.objectURL(Key(u)).String(), c.url)
+		assert.Equal(t, c.expectedRegion, b.region, c.url)
+		assert.Nil(t, b.credentials, c.url)
+	}
+
+	u, err := url.Parse("s3://bucket/a/b")
+	require.NoError(t, err)
+	// Credentials
+	b, err := newS3Backend(u, environment(map[string]string{
+		"AWS_ACCESS_KEY_ID":     "id",
+		"AWS_SECRET_ACCESS_KEY": "secret",
+		"AWS_SESSION_TOKEN":     "session",
+	}))
+	require.NoError(t, err)
+	assert.Equal(t, &awssigv4.Credentials{AccessKeyID: "id", SecretAccessKey: "secret", SessionToken: "session"}, b.credentials)
+	// Invalid configuration
+	for _, env := range []map[string]string{
+		{"AWS_ACCESS_KEY_ID": "id"},
+		{"AWS_ENDPOINT_URL": "ftp://example.com"},
+		{"AWS_ENDPOINT_URL_S3": "://"},
+	} {
+		_, err := newS3Backend(u, environment(env))
+		assert.Error(t, err, env)
+	}
+}
+
+func TestS3PrepareRequest(t *testing.T) {
+	u, err := url.Parse("s3://bucket/key")
+	require.NoError(t, err)
+
+	// Anonymous access
+	b, err := newS3Backend(u, noEnvironment)
+	require.NoError(t, err)
+	req, err := http.NewRequest(http.MethodPut, b.objectURL("key").String(), strings.NewReader("data"))
+	require.NoError(t, err)
+	err = b.prepareRequest(req.Context(), req, []byte("data"))
+	require.NoError(t, err)
+	assert.Equal(t, "3a6eb0790f39ac87c94f3856b2dd2c5d110e6811602261a9a923d3bb23adc8b7", req.Header.Get("x-amz-content-sha256"))
+	assert.Empty(t, req.Header.Get("Authorization"))
+
+	// With credentials
+	b, err = newS3Backend(u, environment(map[string]string{"AWS_ACCESS_KEY_ID": "id", "AWS_SECRET_ACCESS_KEY": "secret"}))
+	require.NoError(t, err)
+	req, err = http.NewRequest(http.MethodGet, b.objectURL("key").String(), nil)
+	require.NoError(t, err)
+	err = b.prepareRequest(req.Context(), req, nil)
+	require.NoError(t, err)
+	assert.Equal(t, "e3b0c44298fc1c149afbf4c8996fb92427ae41e4649b934ca495991b7852b855", req.Header.Get("x-amz-content-sha256"))
+	assert.True(t, strings.HasPrefix(req.Header.Get("Authorization"), "AWS4-HMAC-SHA256 Credential=id/"))
+}
@@ -2,14 +2,17 @@ package docker
 
 import (
 	"fmt"
+	"net/http"
 	"net/url"
 	"os"
 	"path"
 	"path/filepath"
 	"strings"
 
+	"github.com/containers/image/v5/docker/internal/objectstore"
 	"github.com/containers/image/v5/docker/reference"
 	"github.com/containers/image/v5/internal/rootless"
+	"github.com/containers/image/v5/pkg/tlsclientconfig"
 	"github.com/containers/image/v5/types"
 	"github.com/containers/storage/pkg/homedir"
 	"github.com/ghodss/yaml"
@@ -233,3 +236,19 @@ func signatureStorageURL(base signatureStorageBase, manifestDigest digest.Digest
 	url.Path = fmt.Sprintf("%s@%s=%s/signature-%d", url.Path, manifestDigest.Algorithm(), manifestDigest.Hex(), index+1)
 	return &url
 }
+
+// lookasideObjectStore returns an objectstore.Store for accessing signature URLs in an object store, like url,
+// which shares the bucket and options of c.signatureBase.
+func (c *dockerClient) lookasideObjectStore(url *url.URL) (objectstore.Store, error) {
+	c.objectStoreLock.Lock()
+	defer c.objectStoreLock.Unlock()
+	if c.objectStore == nil {
+		// Don’t use c.client: it is configured for the registry (e.g. certificates, insecure access), not for the object store.
+		store, err := objectstore.New(url, &http.Client{Transport: tlsclientconfig.NewTransport()})
+		if err != nil {
+			return nil, errors.Wrapf(err, "accessing signature storage %s", url.Redacted())
+		}
+		c.objectStore = store
+	}
+	return c.objectStore, nil
+}
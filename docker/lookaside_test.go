@@ -1,13 +1,18 @@
 package docker
 
 import (
+	"context"
 	"fmt"
+	"io"
+	"net/http"
+	"net/http/httptest"
 	"net/url"
 	"os"
 	"path/filepath"
 	"testing"
 
 	"github.com/containers/image/v5/types"
+	"github.com/opencontainers/go-digest"
 	"github.com/stretchr/testify/assert"
 	"github.com/stretchr/testify/require"
 )
@@ -286,6 +291,7 @@ func TestSignatureStorageBaseSignatureStorageURL(t *testing.T) {
 		{"https://localhost:5555/root", 1, "https://localhost:5555/root@" + mdMapped + "/signature-2"},
 		{"http://localhost:5555/root", 0, "http://localhost:5555/root@" + mdMapped + "/signature-1"},
 		{"http://localhost:5555/root", 1, "http://localhost:5555/root@" + mdMapped + "/signature-2"},
+		{"s3://bucket/root?region=eu-west-1", 0, "s3://bucket/root@" + mdMapped + "/signature-1?region=eu-west-1"},
 	} {
 		url, err := url.Parse(c.base)
 		require.NoError(t, err)
@@ -305,3 +311,62 @@ func TestBuiltinDefaultSignatureStorageDir(t *testing.T) {
 	assert.NotNil(t, base)
 	assert.Equal(t, "file://"+filepath.Join(os.Getenv("HOME"), defaultUserDockerDir), base.String())
 }
+
+func TestLookasideObjectStore(t *testing.T) {
+	// A minimal S3-like server
+	objects := map[string][]byte{}
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+		data, exists := objects[req.URL.Path]
+		switch req.Method {
+		case http.MethodGet, http.MethodHead:
+			if !exists {
+				w.WriteHeader(http.StatusNotFound)
+				return
+			}
+			_, _ = w.Write(data)
+		case http.MethodPut:
+			body, err := io.ReadAll(req.Body)
+			require.NoError(t, err)
+			objects[req.URL.Path] = body
+		case http.MethodDelete:
+			delete(objects, req.URL.Path)
+			w.WriteHeader(http.StatusNoContent)
+		}
+	}))
+	defer server.Close()
+
+	base, err := url.Parse("s3://bucket/sigstore/repo?endpoint=" + url.QueryEscape(server.URL))
+	require.NoError(t, err)
+	c := &dockerClient{signatureBase: base}
+	dest := &dockerImageDestination{c: c}
+	src := &dockerImageSource{c: c}
+	ctx := context.Background()
+	manifestDigest := digest.FromString("manifest")
+	signatureKey := func(index int) string {
+		return fmt.Sprintf("/bucket/sigstore/repo@sha256=%s/signature-%d", manifestDigest.Hex(), index)
+	}
+
+	err = dest.putSignaturesToLookaside(ctx, [][]byte{[]byte("sig1"), []byte("sig2")}, manifestDigest)
+	require.NoError(t, err)
+	assert.Equal(t, map[string][]byte{
+		signatureKey(1): []byte("sig1"),
+		signatureKey(2): []byte("sig2"),
+	}, objects)
+	sigs, err := src.getSignaturesFromLookaside(ctx, &manifestDigest)
+	require.NoError(t, err)
+	assert.Equal(t, [][]byte{[]byte("sig1"), []byte("sig2")}, sigs)
+
+	// Replacing signatures removes the extra ones
+	err = dest.putSignaturesToLookaside(ctx, [][]byte{[]byte("sig3")}, manifestDigest)
+	require.NoError(t, err)
+	assert.Equal(t, map[string][]byte{signatureKey(1): []byte("sig3")}, objects)
+	sigs, err = src.getSignaturesFromLookaside(ctx, &manifestDigest)
+	require.NoError(t, err)
+	assert.Equal(t, [][]byte{[]byte("sig3")}, sigs)
+
+	// Invalid configuration is reported
+	invalid, err := url.Parse("s3:///repo")
+	require.NoError(t, err)
+	_, err = (&dockerClient{signatureBase: invalid}).lookasideObjectStore(invalid)
+	assert.Error(t, err)
+}
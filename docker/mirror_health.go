@@ -0,0 +1,152 @@
+package docker
+
+import (
+	"context"
+	"errors"
+	"net"
+	"sort"
+	"sync"
+	"time"
+
+	"github.com/containers/image/v5/pkg/sysregistriesv2"
+	"github.com/containers/image/v5/types"
+)
+
+const (
+	// mirrorDemotionBase is the time for which a mirror is demoted after a single health failure; it doubles with
+	// every consecutive failure, up to mirrorDemotionMax.
+	mirrorDemotionBase = 30 * time.Second
+	// mirrorDemotionMax is the maximum time for which a mirror is demoted.
+	mirrorDemotionMax = 10 * time.Minute
+	// mirrorLatencyWeight is the weight of the latest measurement in the moving average of a mirror’s latency.
+	mirrorLatencyWeight = 0.3
+)
+
+// mirrorHealthTracker records the health of registry mirrors, as observed by attempts to pull images from them,
+// and uses it to order pull sources.
+type mirrorHealthTracker struct {
+	lock    sync.Mutex
+	mirrors map[string]*mirrorHealth // Keyed by sysregistriesv2.Endpoint.Location
+}
+
+// mirrorHealth is the observed health of a single mirror.
+type mirrorHealth struct {
+	consecutiveFailures int
+	demotedUntil        time.Time
+	latency             time.Duration // Moving average of the time to access an image, or 0 if unknown.
+}
+
+// sharedMirrorHealth is the mirror health tracker used by image sources which don’t use a Session,
+// if they explicitly enable health tracking.
+var sharedMirrorHealth = newMirrorHealthTracker()
+
+// newMirrorHealthTracker returns an empty mirrorHealthTracker.
+func newMirrorHealthTracker() *mirrorHealthTracker {
+	return &mirrorHealthTracker{mirrors: map[string]*mirrorHealth{}}
+}
+
+// mirrorHealthTrackerForSystemContext returns the mirrorHealthTracker to use with sys, or nil if health tracking is disabled.
+func mirrorHealthTrackerForSystemContext(sys *types.SystemContext) (*mirrorHealthTracker, error) {
+	if sys == nil {
+		return nil, nil
+	}
+	session, err := sessionFromSystemContext(sys)
+	if err != nil {
+		return nil, err
+	}
+	if session != nil {
+		if sys.DockerMirrorHealthTracking == types.OptionalBoolFalse {
+			return nil, nil
+		}
+		return session.mirrors, nil
+	}
+	if sys.DockerMirrorHealthTracking != types.OptionalBoolTrue {
+		return nil, nil
+	}
+	return sharedMirrorHealth, nil
+}
+
+// recordSuccess records that an image was accessed using mirror, which took latency.
+func (t *mirrorHealthTracker) recordSuccess(mirror string, latency time.Duration) {
+	t.lock.Lock()
+	defer t.lock.Unlock()
+	h := t.health(mirror)
+	h.consecutiveFailures = 0
+	h.demotedUntil = time.Time{}
+	if h.latency == 0 {
+		h.latency = latency
+	} else {
+		h.latency = time.Duration(mirrorLatencyWeight*float64(latency) + (1-mirrorLatencyWeight)*float64(h.latency))
+	}
+}
+
+// recordFailure records that accessing mirror failed at now, in a way which indicates that mirror is unhealthy,
+// and demotes it.
+func (t *mirrorHealthTracker) recordFailure(mirror string, now time.Time) {
+	t.lock.Lock()
+	defer t.lock.Unlock()
+	h := t.health(mirror)
+	h.consecutiveFailures++
+	demotion := mirrorDemotionBase
+	for i := 1; i < h.consecutiveFailures && demotion < mirrorDemotionMax; i++ {
+		demotion *= 2
+	}
+	if demotion > mirrorDemotionMax {
+		demotion = mirrorDemotionMax
+	}
+	h.demotedUntil = now.Add(demotion)
+}
+
+// health returns the record for mirror, creating it if necessary.
+// The caller must hold t.lock.
+func (t *mirrorHealthTracker) health(mirror string) *mirrorHealth {
+	h, ok := t.mirrors[mirror]
+	if !ok {
+		h = &mirrorHealth{}
+		t.mirrors[mirror] = h
+	}
+	return h
+}
+
+// orderPullSources returns sources, as returned by sysregistriesv2.Registry.PullSourcesFromReference (i.e. mirrors
+// followed by the primary location), in the order they should be tried at now: healthy mirrors, ordered by their
+// latency (mirrors with unknown latency first, in their configured order), then the primary location, then
+// mirrors demoted after recent failures, so that a dead mirror does not delay every pull.
+func (t *mirrorHealthTracker) orderPullSources(sources []sysregistriesv2.PullSource, now time.Time) []sysregistriesv2.PullSource {
+	if len(sources) < 2 {
+		return sources
+	}
+	mirrors, primary := sources[:len(sources)-1], sources[len(sources)-1]
+
+	t.lock.Lock()
+	defer t.lock.Unlock()
+	healthy := []sysregistriesv2.PullSource{}
+	latencies := map[string]time.Duration{}
+	demoted := []sysregistriesv2.PullSource{}
+	for _, mirror := range mirrors {
+		h, ok := t.mirrors[mirror.Endpoint.Location]
+		switch {
+		case !ok:
+			healthy = append(healthy, mirror)
+		case now.Before(h.demotedUntil):
+			demoted = append(demoted, mirror)
+		default:
+			healthy = append(healthy, mirror)
+			latencies[mirror.Endpoint.Location] = h.latency
+		}
+	}
+	sort.SliceStable(healthy, func(i, j int) bool {
+		return latencies[healthy[i].Endpoint.Location] < latencies[healthy[j].Endpoint.Location]
+	})
+	res := append(healthy, primary)
+	return append(res, demoted...)
+}
+
+// isMirrorHealthFailure returns true if err, returned when trying to access an image in a mirror, indicates that
+// the mirror is unhealthy (e.g. unreachable or overloaded), rather than e.g. not containing the image.
+func isMirrorHealthFailure(err error) bool {
+	var netErr net.Error
+	var maintenance ErrRegistryMaintenance
+	return errors.As(err, &netErr) || errors.As(err, &maintenance) || errors.Is(err, ErrTooManyRequests) ||
+		errors.Is(err, context.DeadlineExceeded)
+}
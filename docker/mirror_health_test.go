@@ -0,0 +1,177 @@
+package docker
+
+import (
+	"context"
+	"fmt"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/containers/image/v5/pkg/sysregistriesv2"
+	"github.com/containers/image/v5/types"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestMirrorHealthTrackerOrderPullSources(t *testing.T) {
+	source := func(location string) sysregistriesv2.PullSource {
+		return sysregistriesv2.PullSource{Endpoint: sysregistriesv2.Endpoint{Location: location}}
+	}
+	locations := func(sources []sysregistriesv2.PullSource) []string {
+		res := []string{}
+		for _, s := range sources {
+			res = append(res, s.Endpoint.Location)
+		}
+		return res
+	}
+	sources := []sysregistriesv2.PullSource{source("m1"), source("m2"), source("m3"), source("m4"), source("primary")}
+	now := time.Now()
+
+	tracker := newMirrorHealthTracker()
+	assert.Equal(t, []string{"m1", "m2", "m3", "m4", "primary"}, locations(tracker.orderPullSources(sources, now)))
+	assert.Equal(t, []string{"primary"}, locations(tracker.orderPullSources(sources[4:], now)))
+
+	tracker.recordSuccess("m1", 3*time.Second)
+	tracker.recordSuccess("m2", time.Second)
+	tracker.recordFailure("m3", now)
+	assert.Equal(t, []string{"m4", "m2", "m1", "primary", "m3"}, locations(tracker.orderPullSources(sources, now)))
+	// The demotion expires
+	assert.Equal(t, []string{"m3", "m4", "m2", "m1", "primary"}, locations(tracker.orderPullSources(sources, now.Add(mirrorDemotionBase))))
+	// A success ends the demotion
+	tracker.recordSuccess("m3", 2*time.Second)
+	assert.Equal(t, []string{"m4", "m2", "m3", "m1", "primary"}, locations(tracker.orderPullSources(sources, now)))
+	// Latency is a moving average
+	tracker.recordSuccess("m1", 0)
+	assert.Equal(t, []string{"m4", "m2", "m3", "m1", "primary"}, locations(tracker.orderPullSources(sources, now)))
+	tracker.recordSuccess("m1", 0)
+	assert.Equal(t, []string{"m4", "m2", "m1", "m3", "primary"}, locations(tracker.orderPullSources(sources, now)))
+}
+
+func TestMirrorHealthTrackerRecordFailure(t *testing.T) {
+	tracker := newMirrorHealthTracker()
+	now := time.Now()
+	for _, expected := range []time.Duration{
+		mirrorDemotionBase, 2 * mirrorDemotionBase, 4 * mirrorDemotionBase, 8 * mirrorDemotionBase, 16 * mirrorDemotionBase,
+		mirrorDemotionMax, mirrorDemotionMax,
+	} {
+		tracker.recordFailure("mirror", now)
+		assert.Equal(t, now.Add(expected), tracker.mirrors["mirror"].demotedUntil)
+	}
+	tracker.recordSuccess("mirror", time.Second)
+	assert.Equal(t, 0, tracker.mirrors["mirror"].consecutiveFailures)
+	assert.True(t, tracker.mirrors["mirror"].demotedUntil.IsZero())
+}
+
+func TestMirrorHealthTrackerForSystemContext(t *testing.T) {
+	session := NewSession()
+	defer session.Close()
+	for _, c := range []struct {
+		sys      *types.SystemContext
+		expected *mirrorHealthTracker
+	}{
+		{nil, nil},
+		{&types.SystemContext{}, nil},
+		{&types.SystemContext{DockerMirrorHealthTracking: types.OptionalBoolFalse}, nil},
+		{&types.SystemContext{DockerMirrorHealthTracking: types.OptionalBoolTrue}, sharedMirrorHealth},
+		{&types.SystemContext{DockerSession: session}, session.mirrors},
+		{&types.SystemContext{DockerSession: session, DockerMirrorHealthTracking: types.OptionalBoolTrue}, session.mirrors},
+		{&types.SystemContext{DockerSession: session, DockerMirrorHealthTracking: types.OptionalBoolFalse}, nil},
+	} {
+		res, err := mirrorHealthTrackerForSystemContext(c.sys)
+		require.NoError(t, err)
+		if c.expected == nil {
+			assert.Nil(t, res, "%#v", c.sys)
+		} else {
+			assert.Same(t, c.expected, res, "%#v", c.sys)
+		}
+	}
+}
+
+func TestIsMirrorHealthFailure(t *testing.T) {
+	for _, c := range []struct {
+		err      error
+		expected bool
+	}{
+		{&net.OpError{Op: "dial", Err: fmt.Errorf("connection refused")}, true},
+		{fmt.Errorf("pinging container registry: %w", &net.DNSError{Err: "no such host"}), true},
+		{TooManyRequestsError{}, true},
+		{ErrTooManyRequests, true},
+		{ErrRegistryMaintenance{}, true},
+		{RegistryError{StatusCode: http.StatusServiceUnavailable}, false}, // Only explicit maintenance windows demote mirrors
+		{context.DeadlineExceeded, true},
+		{ErrUnauthorizedForCredentials{}, false},
+		{fmt.Errorf("manifest unknown"), false},
+	} {
+		assert.Equal(t, c.expected, isMirrorHealthFailure(c.err), "%#v", c.err)
+	}
+}
+
+func TestNewImageSourceMirrorHealth(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(rw http.ResponseWriter, r *http.Request) {
+		switch {
+		case r.URL.Path == "/v2/":
+			rw.WriteHeader(http.StatusOK)
+		case strings.HasSuffix(r.URL.Path, "/manifests/latest"):
+			rw.WriteHeader(http.StatusOK)
+		default:
+			rw.WriteHeader(http.StatusNotFound)
+		}
+	}))
+	defer server.Close()
+	registry := strings.TrimPrefix(server.URL, "http://")
+	deadServer := httptest.NewServer(http.NotFoundHandler())
+	deadRegistry := strings.TrimPrefix(deadServer.URL, "http://")
+	deadServer.Close()
+
+	registriesConf := filepath.Join(t.TempDir(), "registries.conf")
+	err := os.WriteFile(registriesConf, []byte(fmt.Sprintf(`[[registry]]
+location = "health.example.com"
+
+[[registry.mirror]]
+location = "%s/dead"
+
+[[registry.mirror]]
+location = "%s/working"
+`, deadRegistry, registry)), 0600)
+	require.NoError(t, err)
+	ref, err := ParseReference("//health.example.com/busybox:latest")
+	require.NoError(t, err)
+
+	choices := []types.DockerPullSourceChoice{}
+	session := NewSession()
+	defer session.Close()
+	sys := &types.SystemContext{
+		RegistriesDirPath:           "/this/does/not/exist",
+		DockerPerHostCertDirPath:    "/this/does/not/exist",
+		SystemRegistriesConfPath:    registriesConf,
+		DockerInsecureSkipTLSVerify: types.OptionalBoolTrue,
+		DockerSession:               session,
+		DockerPullSourceCallback:    func(c types.DockerPullSourceChoice) { choices = append(choices, c) },
+	}
+	// The first pull tries the dead mirror, and demotes it; the second one does not try it at all.
+	for _, failed := range []int{1, 0} {
+		choices = []types.DockerPullSourceChoice{}
+		src, err := ref.NewImageSource(context.Background(), sys)
+		require.NoError(t, err)
+		require.Len(t, choices, 1)
+		assert.Equal(t, "health.example.com/busybox:latest", choices[0].Reference.String())
+		assert.Equal(t, registry+"/working/busybox:latest", choices[0].PullSource.String())
+		assert.Equal(t, registry+"/working", choices[0].Endpoint)
+		assert.True(t, choices[0].Mirror)
+		assert.Len(t, choices[0].FailedPullSources, failed)
+		assert.Equal(t, choices[0].FailedPullSources, src.(*dockerImageSource).FailedPullSources())
+		src.Close()
+	}
+
+	// Without health tracking, the configured order is always used.
+	sys.DockerMirrorHealthTracking = types.OptionalBoolFalse
+	src, err := ref.NewImageSource(context.Background(), sys)
+	require.NoError(t, err)
+	assert.Len(t, src.(*dockerImageSource).FailedPullSources(), 1)
+	src.Close()
+}
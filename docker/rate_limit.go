@@ -0,0 +1,126 @@
+package docker
+
+import (
+	"context"
+	"net/http"
+	"sync"
+
+	"github.com/containers/image/v5/pkg/sysregistriesv2"
+	"github.com/containers/image/v5/types"
+	"golang.org/x/time/rate"
+)
+
+// registryRateLimiters contains the rate limiters shared by all docker clients in the process, keyed by the
+// registry host[:port] requests are sent to.
+var registryRateLimiters = struct {
+	lock     sync.Mutex
+	limiters map[string]*rateLimiter
+}{limiters: map[string]*rateLimiter{}}
+
+// rateLimiter enforces a types.DockerRateLimit.
+type rateLimiter struct {
+	limits types.DockerRateLimit
+	rate   *rate.Limiter // nil if the rate is not limited
+	slots  chan struct{} // nil if the number of concurrent requests is not limited
+}
+
+// rateLimitForRegistry returns the limit on requests to registry (host[:port]) configured in sys or in registries.conf,
+// or nil if there is none.
+func rateLimitForRegistry(sys *types.SystemContext, registry string) (*types.DockerRateLimit, error) {
+	if sys != nil {
+		if limits, ok := sys.DockerRegistryRateLimits[registry]; ok {
+			return &limits, nil
+		}
+	}
+	rl, err := sysregistriesv2.RateLimitForHost(sys, registry)
+	if err != nil {
+		return nil, err
+	}
+	if rl == nil {
+		return nil, nil
+	}
+	return &types.DockerRateLimit{
+		RequestsPerSecond:     rl.RequestsPerSecond,
+		Burst:                 rl.Burst,
+		MaxConcurrentRequests: rl.MaxConcurrentRequests,
+	}, nil
+}
+
+// sharedRateLimiter returns the process-wide rate limiter for requests to host, enforcing limits, or nil if limits
+// don't limit anything.  If the limits for host have changed since the limiter was created, it is replaced;
+// requests already waiting for the previous limiter are not affected.
+func sharedRateLimiter(host string, limits *types.DockerRateLimit) *rateLimiter {
+	if limits == nil || (limits.RequestsPerSecond <= 0 && limits.MaxConcurrentRequests <= 0) {
+		return nil
+	}
+	registryRateLimiters.lock.Lock()
+	defer registryRateLimiters.lock.Unlock()
+	if l, ok := registryRateLimiters.limiters[host]; ok && l.limits == *limits {
+		return l
+	}
+	l := newRateLimiter(*limits)
+	registryRateLimiters.limiters[host] = l
+	return l
+}
+
+// newRateLimiter returns a new rateLimiter enforcing limits.
+func newRateLimiter(limits types.DockerRateLimit) *rateLimiter {
+	l := &rateLimiter{limits: limits}
+	if limits.RequestsPerSecond > 0 {
+		burst := limits.Burst
+		if burst < 1 {
+			burst = 1
+		}
+		l.rate = rate.NewLimiter(rate.Limit(limits.RequestsPerSecond), burst)
+	}
+	if limits.MaxConcurrentRequests > 0 {
+		l.slots = make(chan struct{}, limits.MaxConcurrentRequests)
+	}
+	return l
+}
+
+// acquire waits until a request can be sent, or ctx is done.  On success, the caller must call the returned
+// function when the request is no longer in flight.
+func (l *rateLimiter) acquire(ctx context.Context) (func(), error) {
+	release := func() {}
+	if l.slots != nil {
+		select {
+		case l.slots <- struct{}{}:
+		case <-ctx.Done():
+			return nil, ctx.Err()
+		}
+		release = func() { <-l.slots }
+	}
+	// Wait for the rate limit only after obtaining a slot, so that requests waiting for a slot don't use up the burst.
+	if l.rate != nil {
+		if err := l.rate.Wait(ctx); err != nil {
+			release()
+			return nil, err
+		}
+	}
+	return release, nil
+}
+
+// rateLimitedRoundTripper is a http.RoundTripper which applies limiter to requests sent to host.
+type rateLimitedRoundTripper struct {
+	host    string
+	limiter *rateLimiter
+	next    http.RoundTripper
+}
+
+// RoundTrip implements http.RoundTripper.
+func (rt *rateLimitedRoundTripper) RoundTrip(req *http.Request) (*http.Response, error) {
+	// Other hosts, e.g. authentication servers or blob storage the registry redirects to, are not limited.
+	if req.URL.Host != rt.host {
+		return rt.next.RoundTrip(req)
+	}
+	release, err := rt.limiter.acquire(req.Context())
+	if err != nil {
+		if req.Body != nil {
+			req.Body.Close()
+		}
+		return nil, err
+	}
+	defer release()
+	return rt.next.RoundTrip(req)
+}
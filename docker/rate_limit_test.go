@@ -0,0 +1,146 @@
+package docker
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/containers/image/v5/types"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestRateLimitForRegistry(t *testing.T) {
+	registriesConf := filepath.Join(t.TempDir(), "registries.conf")
+	err := os.WriteFile(registriesConf, []byte(`[[registry]]
+location = "limited.example.com"
+rate-limit = { requests-per-second = 10, burst = 2 }
+`), 0600)
+	require.NoError(t, err)
+	sys := &types.SystemContext{
+		SystemRegistriesConfPath:    registriesConf,
+		SystemRegistriesConfDirPath: "/this/does/not/exist",
+	}
+
+	limits, err := rateLimitForRegistry(sys, "limited.example.com")
+	require.NoError(t, err)
+	assert.Equal(t, &types.DockerRateLimit{RequestsPerSecond: 10, Burst: 2}, limits)
+	limits, err = rateLimitForRegistry(sys, "other.example.com")
+	require.NoError(t, err)
+	assert.Nil(t, limits)
+
+	// SystemContext overrides registries.conf
+	sys.DockerRegistryRateLimits = map[string]types.DockerRateLimit{
+		"limited.example.com": {MaxConcurrentRequests: 1},
+		"other.example.com":   {RequestsPerSecond: 1},
+	}
+	limits, err = rateLimitForRegistry(sys, "limited.example.com")
+	require.NoError(t, err)
+	assert.Equal(t, &types.DockerRateLimit{MaxConcurrentRequests: 1}, limits)
+	limits, err = rateLimitForRegistry(sys, "other.example.com")
+	require.NoError(t, err)
+	assert.Equal(t, &types.DockerRateLimit{RequestsPerSecond: 1}, limits)
+}
+
+func TestSharedRateLimiter(t *testing.T) {
+	const host = "shared-rate-limiter.example.com"
+	assert.Nil(t, sharedRateLimiter(host, nil))
+	assert.Nil(t, sharedRateLimiter(host, &types.DockerRateLimit{Burst: 10}))
+
+	l1 := sharedRateLimiter(host, &types.DockerRateLimit{RequestsPerSecond: 5})
+	require.NotNil(t, l1)
+	assert.NotNil(t, l1.rate)
+	assert.Nil(t, l1.slots)
+	assert.Same(t, l1, sharedRateLimiter(host, &types.DockerRateLimit{RequestsPerSecond: 5}))
+	assert.NotSame(t, l1, sharedRateLimiter("other-"+host, &types.DockerRateLimit{RequestsPerSecond: 5}))
+
+	l2 := sharedRateLimiter(host, &types.DockerRateLimit{MaxConcurrentRequests: 2})
+	require.NotNil(t, l2)
+	assert.NotSame(t, l1, l2)
+	assert.Nil(t, l2.rate)
+	assert.Equal(t, 2, cap(l2.slots))
+}
+
+func TestRateLimiterAcquire(t *testing.T) {
+	timeoutContext := func() (context.Context, context.CancelFunc) {
+		return context.WithTimeout(context.Background(), 50*time.Millisecond)
+	}
+
+	// Concurrency
+	l := newRateLimiter(types.DockerRateLimit{MaxConcurrentRequests: 1})
+	release, err := l.acquire(context.Background())
+	require.NoError(t, err)
+	ctx, cancel := timeoutContext()
+	defer cancel()
+	_, err = l.acquire(ctx)
+	assert.ErrorIs(t, err, context.DeadlineExceeded)
+	release()
+	release, err = l.acquire(context.Background())
+	require.NoError(t, err)
+	release()
+
+	// Rate
+	l = newRateLimiter(types.DockerRateLimit{RequestsPerSecond: 0.1, Burst: 2})
+	for i := 0; i < 2; i++ {
+		release, err := l.acquire(context.Background())
+		require.NoError(t, err)
+		release()
+	}
+	ctx, cancel = timeoutContext()
+	defer cancel()
+	_, err = l.acquire(ctx)
+	assert.Error(t, err)
+}
+
+func TestDockerClientRateLimit(t *testing.T) {
+	var lock sync.Mutex
+	inFlight, maxInFlight, requests := 0, 0, 0
+	server := httptest.NewServer(http.HandlerFunc(func(rw http.ResponseWriter, r *http.Request) {
+		lock.Lock()
+		inFlight++
+		requests++
+		if inFlight > maxInFlight {
+			maxInFlight = inFlight
+		}
+		lock.Unlock()
+		time.Sleep(20 * time.Millisecond)
+		lock.Lock()
+		inFlight--
+		lock.Unlock()
+		rw.WriteHeader(http.StatusOK)
+	}))
+	defer server.Close()
+	registry := strings.TrimPrefix(server.URL, "http://")
+
+	registriesConf := filepath.Join(t.TempDir(), "registries.conf")
+	err := os.WriteFile(registriesConf, []byte{}, 0600)
+	require.NoError(t, err)
+	sys := &types.SystemContext{
+		SystemRegistriesConfPath:    registriesConf,
+		SystemRegistriesConfDirPath: "/this/does/not/exist",
+		DockerPerHostCertDirPath:    "/this/does/not/exist",
+		DockerInsecureSkipTLSVerify: types.OptionalBoolTrue,
+		DockerRegistryRateLimits: map[string]types.DockerRateLimit{
+			registry: {MaxConcurrentRequests: 1},
+		},
+	}
+	// The limit is shared by separate operations.
+	var wg sync.WaitGroup
+	for i := 0; i < 4; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			err := CheckAuth(context.Background(), sys, "", "", registry)
+			assert.NoError(t, err)
+		}()
+	}
+	wg.Wait()
+	assert.Greater(t, requests, 4)
+	assert.Equal(t, 1, maxInFlight)
+}
@@ -0,0 +1,67 @@
+package reference
+
+import "strings"
+
+// Docker Hub is known under several names.  These constants and helpers allow callers
+// to canonicalize user input in the same way the rest of the library does.
+const (
+	// DockerHubDomain is the domain of Docker Hub used in normalized references, e.g. "docker.io/library/busybox".
+	DockerHubDomain = "docker.io"
+	// DockerHubLegacyDomain is the domain used by older tools, and as the canonical key for Docker Hub in auth files.
+	DockerHubLegacyDomain = "index.docker.io"
+	// DockerHubRegistry is the host which actually serves the registry API of Docker Hub.
+	DockerHubRegistry = "registry-1.docker.io"
+	// DockerHubOfficialNamespace is the namespace implied for single-component repository paths on Docker Hub.
+	DockerHubOfficialNamespace = "library"
+)
+
+// IsDockerHubDomain returns true if domain (a host[:port] value) is one of the names of Docker Hub.
+func IsDockerHubDomain(domain string) bool {
+	switch domain {
+	case DockerHubDomain, DockerHubLegacyDomain, DockerHubRegistry:
+		return true
+	}
+	return false
+}
+
+// CanonicalDomain returns DockerHubDomain if domain is one of the names of Docker Hub,
+// and domain unchanged otherwise.
+func CanonicalDomain(domain string) string {
+	if IsDockerHubDomain(domain) {
+		return DockerHubDomain
+	}
+	return domain
+}
+
+// CanonicalName canonicalizes a repository name or namespace, with or without an explicit domain:
+// names on Docker Hub (using any of its names, or no domain at all) are returned with DockerHubDomain,
+// and single-component Docker Hub paths get the DockerHubOfficialNamespace prefix.
+// For example, "busybox", "index.docker.io/busybox" and "registry-1.docker.io/library/busybox"
+// all become "docker.io/library/busybox".
+// A bare domain (e.g. "index.docker.io") is returned as a canonical domain.
+// The input is not otherwise validated.
+func CanonicalName(name string) string {
+	if IsDockerHubDomain(name) {
+		return DockerHubDomain
+	}
+	domain, remainder := splitDockerDomain(name)
+	if IsDockerHubDomain(domain) {
+		domain = DockerHubDomain
+		if !strings.ContainsRune(remainder, '/') {
+			remainder = DockerHubOfficialNamespace + "/" + remainder
+		}
+	}
+	return domain + "/" + remainder
+}
+
+// FamiliarRepositoryPath returns path (a repository path within domain, as returned by Path())
+// in the shortest form accepted by Docker-compatible user interfaces: on Docker Hub, the
+// DockerHubOfficialNamespace prefix is removed.
+func FamiliarRepositoryPath(domain, path string) string {
+	if IsDockerHubDomain(domain) {
+		if split := strings.Split(path, "/"); len(split) == 2 && split[0] == DockerHubOfficialNamespace {
+			return split[1]
+		}
+	}
+	return path
+}
@@ -0,0 +1,61 @@
+package reference
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestIsDockerHubDomain(t *testing.T) {
+	for _, c := range []struct {
+		input    string
+		expected bool
+	}{
+		{"docker.io", true},
+		{"index.docker.io", true},
+		{"registry-1.docker.io", true},
+		{"docker.io:443", false},
+		{"quay.io", false},
+		{"", false},
+	} {
+		assert.Equal(t, c.expected, IsDockerHubDomain(c.input), c.input)
+	}
+	assert.Equal(t, "docker.io", CanonicalDomain("registry-1.docker.io"))
+	assert.Equal(t, "quay.io", CanonicalDomain("quay.io"))
+}
+
+func TestCanonicalName(t *testing.T) {
+	for _, c := range []struct {
+		input, expected string
+	}{
+		{"busybox", "docker.io/library/busybox"},
+		{"library/busybox", "docker.io/library/busybox"},
+		{"docker.io/busybox", "docker.io/library/busybox"},
+		{"index.docker.io/busybox", "docker.io/library/busybox"},
+		{"registry-1.docker.io/busybox", "docker.io/library/busybox"},
+		{"registry-1.docker.io/library/busybox", "docker.io/library/busybox"},
+		{"vendor/product", "docker.io/vendor/product"},
+		{"index.docker.io/vendor/product", "docker.io/vendor/product"},
+		{"index.docker.io", "docker.io"},
+		{"registry-1.docker.io", "docker.io"},
+		{"quay.io/busybox", "quay.io/busybox"},
+		{"localhost/busybox", "localhost/busybox"},
+		{"localhost:5000/ns/busybox", "localhost:5000/ns/busybox"},
+	} {
+		assert.Equal(t, c.expected, CanonicalName(c.input), c.input)
+	}
+}
+
+func TestFamiliarRepositoryPath(t *testing.T) {
+	for _, c := range []struct {
+		domain, path, expected string
+	}{
+		{"docker.io", "library/busybox", "busybox"},
+		{"index.docker.io", "library/busybox", "busybox"},
+		{"docker.io", "vendor/product", "vendor/product"},
+		{"docker.io", "library/ns/busybox", "library/ns/busybox"},
+		{"quay.io", "library/busybox", "library/busybox"},
+	} {
+		assert.Equal(t, c.expected, FamiliarRepositoryPath(c.domain, c.path), c.domain+"/"+c.path)
+	}
+}
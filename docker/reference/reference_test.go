@@ -65,6 +65,21 @@ func TestReferenceParse(t *testing.T) {
 			repository: "test:5000/repo",
 			digest:     "sha256:ffffffffffffffffffffffffffffffffffffffffffffffffffffffffffffffff",
 		},
+		{
+			input:      "[2001:db8::1]:5000/repo:tag",
+			domain:     "[2001:db8::1]:5000",
+			repository: "[2001:db8::1]:5000/repo",
+			tag:        "tag",
+		},
+		{
+			input:      "[fe80::1%eth0]/repo",
+			domain:     "[fe80::1%eth0]",
+			repository: "[fe80::1%eth0]/repo",
+		},
+		{
+			input: "[fe80::1%eth0/repo",
+			err:   ErrReferenceInvalidFormat,
+		},
 		{
 			input:      "test:5000/repo:tag@sha256:ffffffffffffffffffffffffffffffffffffffffffffffffffffffffffffffff",
 			domain:     "test:5000",
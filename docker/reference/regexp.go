@@ -1,6 +1,9 @@
 package reference
 
-import "regexp"
+import (
+	"regexp"
+	"strings"
+)
 
 var (
 	// alphaNumericRegexp defines the alpha numeric atom, typically a
@@ -24,13 +27,22 @@ var (
 	// and followed by an optional port.
 	domainComponentRegexp = match(`(?:[a-zA-Z0-9]|[a-zA-Z0-9][a-zA-Z0-9-]*[a-zA-Z0-9])`)
 
+	// ipv6AddressRegexp matches an IPv6 address literal enclosed in square
+	// brackets, optionally followed by a zone identifier (RFC 6874), e.g.
+	// "[fe80::1%eth0]". Only addresses in the compressed or uncompressed
+	// hexadecimal format are allowed.
+	ipv6AddressRegexp = match(`\[[a-fA-F0-9:]+(?:%[a-zA-Z0-9._~-]+)?\]`)
+
 	// DomainRegexp defines the structure of potential domain components
 	// that may be part of image names. This is purposely a subset of what is
 	// allowed by DNS to ensure backwards compatibility with Docker image
 	// names.
 	DomainRegexp = expression(
-		domainComponentRegexp,
-		optional(repeated(literal(`.`), domainComponentRegexp)),
+		group(alternation(
+			expression(
+				domainComponentRegexp,
+				optional(repeated(literal(`.`), domainComponentRegexp))),
+			ipv6AddressRegexp)),
 		optional(literal(`:`), match(`[0-9]+`)))
 
 	// TagRegexp matches valid tag names. From docker/docker:graph/tags.go.
@@ -132,6 +144,16 @@ func group(res ...*regexp.Regexp) *regexp.Regexp {
 	return match(`(?:` + expression(res...).String() + `)`)
 }
 
+// alternation matches any one of the regular expressions, each wrapped in a
+// non-capturing group.
+func alternation(res ...*regexp.Regexp) *regexp.Regexp {
+	alternatives := make([]string, 0, len(res))
+	for _, re := range res {
+		alternatives = append(alternatives, group(re).String())
+	}
+	return match(strings.Join(alternatives, `|`))
+}
+
 // capture wraps the expression in a capturing group.
 func capture(res ...*regexp.Regexp) *regexp.Regexp {
 	return match(`(` + expression(res...).String() + `)`)
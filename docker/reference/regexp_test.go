@@ -115,6 +115,38 @@ func TestDomainRegexp(t *testing.T) {
 			input: "Asdf.com", // uppercase character
 			match: true,
 		},
+		{
+			input: "[::1]",
+			match: true,
+		},
+		{
+			input: "[::1]:5000",
+			match: true,
+		},
+		{
+			input: "[2001:db8::1]:5000",
+			match: true,
+		},
+		{
+			input: "[fe80::1%eth0]:5000", // zone identifier
+			match: true,
+		},
+		{
+			input: "[fe80::1%]:5000",
+			match: false,
+		},
+		{
+			input: "[::1",
+			match: false,
+		},
+		{
+			input: "[not.an.address]",
+			match: false,
+		},
+		{
+			input: "[::1]:http",
+			match: false,
+		},
 	}
 	r := regexp.MustCompile(`^` + DomainRegexp.String() + `$`)
 	for i := range hostcases {
@@ -0,0 +1,261 @@
+package docker
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"net/url"
+	"strings"
+
+	"github.com/containers/image/v5/docker/reference"
+	"github.com/containers/image/v5/internal/iolimits"
+	"github.com/containers/image/v5/internal/warnings"
+	"github.com/containers/image/v5/manifest"
+	"github.com/containers/image/v5/types"
+	"github.com/opencontainers/go-digest"
+	imgspecv1 "github.com/opencontainers/image-spec/specs-go/v1"
+	"github.com/pkg/errors"
+	"github.com/sirupsen/logrus"
+)
+
+// referrersPath is the OCI distribution-spec v1.1 referrers API endpoint.
+const referrersPath = "/v2/%s/referrers/%s"
+
+// ReferrersFallbackTag returns the tag used by the distribution-spec referrers tag schema, for registries which don’t
+// support the referrers API, to store the list of referrers of manifestDigest.
+func ReferrersFallbackTag(manifestDigest digest.Digest) string {
+	tag := manifestDigest.Algorithm().String() + "-" + manifestDigest.Encoded()
+	if len(tag) > 128 { // The maximum length of a tag
+		tag = tag[:128]
+	}
+	return tag
+}
+
+// GetReferrers returns the manifests in the repository of ref which refer to the manifest with manifestDigest
+// using the image-spec v1.1 subject field, optionally only those with artifactType (if not "").
+// The tag or digest provided inside the ImageReference will be ignored.
+// If the registry does not support the referrers API, the referrers tag schema is used.
+func GetReferrers(ctx context.Context, sys *types.SystemContext, ref types.ImageReference, manifestDigest digest.Digest, artifactType string) ([]manifest.OCI1Referrer, error) {
+	dr, ok := ref.(dockerReference)
+	if !ok {
+		return nil, errors.Errorf("ref must be a dockerReference")
+	}
+	client, err := newDockerClientFromRef(ctx, sys, dr, false, "pull")
+	if err != nil {
+		return nil, errors.Wrap(err, "failed to create client")
+	}
+	return client.getReferrers(ctx, dr.ref, manifestDigest, artifactType)
+}
+
+// GetReferrers returns the manifests which refer to the manifest with manifestDigest, optionally only those with
+// artifactType (if not "").
+func (s *dockerImageSource) GetReferrers(ctx context.Context, manifestDigest digest.Digest, artifactType string) ([]manifest.OCI1Referrer, error) {
+	return s.c.getReferrers(ctx, s.physicalRef.ref, manifestDigest, artifactType)
+}
+
+// GetManifestForTag returns the manifest tagged with tag in the same repository, and its MIME type if known,
+// or a nil manifest if the tag does not exist.
+func (s *dockerImageSource) GetManifestForTag(ctx context.Context, tag string) ([]byte, string, error) {
+	return s.c.getManifestForTag(ctx, s.physicalRef.ref, tag)
+}
+
+// GetManifestForTag returns the manifest tagged with tag in the same repository, and its MIME type if known,
+// or a nil manifest if the tag does not exist.
+func (d *dockerImageDestination) GetManifestForTag(ctx context.Context, tag string) ([]byte, string, error) {
+	return d.c.getManifestForTag(ctx, d.ref.ref, tag)
+}
+
+// getManifestForTag returns the manifest tagged with tag in repo, and its MIME type if known,
+// or a nil manifest if the tag does not exist.
+func (c *dockerClient) getManifestForTag(ctx context.Context, repo reference.Named, tag string) ([]byte, string, error) {
+	if _, err := reference.WithTag(reference.TrimNamed(repo), tag); err != nil {
+		return nil, "", err
+	}
+	path := fmt.Sprintf(manifestPath, reference.Path(repo), tag)
+	headers := map[string][]string{
+		"Accept": manifest.DefaultRequestedManifestMIMETypes,
+	}
+	res, err := c.makeRequest(ctx, http.MethodGet, path, headers, nil, v2Auth, nil)
+	if err != nil {
+		return nil, "", err
+	}
+	defer res.Body.Close()
+	switch res.StatusCode {
+	case http.StatusOK:
+	case http.StatusNotFound:
+		return nil, "", nil
+	default:
+		return nil, "", errors.Wrapf(manifestHTTPResponseToError(res), "reading manifest %s in %s", tag, repo.Name())
+	}
+	man, err := iolimits.ReadAtMost(res.Body, iolimits.MaxManifestBodySize)
+	if err != nil {
+		return nil, "", err
+	}
+	if err := c.checkManifestContentDigest(res, man, fmt.Sprintf("%s in %s", tag, repo.Name())); err != nil {
+		return nil, "", err
+	}
+	return man, simplifyContentType(res.Header.Get("Content-Type")), nil
+}
+
+// getReferrers returns the manifests in repo which refer to the manifest with manifestDigest, optionally only those with
+// artifactType (if not "").  It uses the referrers API if available, and the referrers tag schema otherwise.
+func (c *dockerClient) getReferrers(ctx context.Context, repo reference.Named, manifestDigest digest.Digest, artifactType string) ([]manifest.OCI1Referrer, error) {
+	path := fmt.Sprintf(referrersPath, reference.Path(repo), manifestDigest.String())
+	if artifactType != "" {
+		path += "?" + url.Values{"artifactType": {artifactType}}.Encode()
+	}
+	headers := map[string][]string{
+		"Accept": {imgspecv1.MediaTypeImageIndex},
+	}
+
+	referrers := []manifest.OCI1Referrer{}
+	firstPage := true
+	for {
+		res, err := c.makeRequest(ctx, http.MethodGet, path, headers, nil, v2Auth, nil)
+		if err != nil {
+			return nil, err
+		}
+		defer res.Body.Close()
+		switch {
+		case res.StatusCode == http.StatusOK:
+		case firstPage && (res.StatusCode == http.StatusNotFound || res.StatusCode == http.StatusMethodNotAllowed):
+			c.reportReferrersAPIUnsupported()
+			index, err := c.getReferrersFallbackIndex(ctx, repo, manifestDigest)
+			if err != nil {
+				return nil, err
+			}
+			return index.FilterByArtifactType(artifactType), nil
+		default:
+			return nil, errors.Wrapf(registryHTTPResponseToError(res), "listing referrers of %s in %s", manifestDigest, repo.Name())
+		}
+		firstPage = false
+
+		body, err := iolimits.ReadAtMost(res.Body, iolimits.MaxListPageBodySize)
+		if err != nil {
+			return nil, err
+		}
+		index, err := manifest.OCI1ReferrersIndexFromManifest(body)
+		if err != nil {
+			return nil, err
+		}
+		// Registries may ignore the artifactType filter; they indicate applying it using the OCI-Filters-Applied header.
+		if artifactType != "" && !strings.Contains(res.Header.Get("OCI-Filters-Applied"), "artifactType") {
+			referrers = append(referrers, index.FilterByArtifactType(artifactType)...)
+		} else {
+			referrers = append(referrers, index.Manifests...)
+		}
+
+		link := res.Header.Get("Link")
+		if link == "" {
+			break
+		}
+		linkURLStr := strings.Trim(strings.Split(link, ";")[0], "<>")
+		linkURL, err := url.Parse(linkURLStr)
+		if err != nil {
+			return referrers, err
+		}
+
+		// can be relative or absolute, but we only want the path (and I
+		// guess we're in trouble if it forwards to a new place...)
+		path = linkURL.Path
+		if linkURL.RawQuery != "" {
+			path += "?"
+			path += linkURL.RawQuery
+		}
+	}
+	return referrers, nil
+}
+
+// reportReferrersAPIUnsupported reports that c.registry does not support the referrers API.
+func (c *dockerClient) reportReferrersAPIUnsupported() {
+	c.referrersFallbackLock.Lock()
+	c.referrersFallback = true
+	c.referrersFallbackLock.Unlock()
+	warnings.Report(c.sys, types.Warning{
+		Kind:    types.WarningCapabilityGap,
+		Code:    types.WarningCodeReferrersAPIUnsupported,
+		Subject: c.registry,
+		Message: fmt.Sprintf("Referrers API not supported by %s, using the referrers tag schema", c.registry),
+	})
+}
+
+// usedReferrersFallback returns true if c has used the referrers tag schema because the registry does not support the referrers API.
+func (c *dockerClient) usedReferrersFallback() bool {
+	c.referrersFallbackLock.Lock()
+	defer c.referrersFallbackLock.Unlock()
+	return c.referrersFallback
+}
+
+// UsedReferrersFallback returns true if referrers were listed so far using the referrers tag schema,
+// because the registry does not support the referrers API.
+func (s *dockerImageSource) UsedReferrersFallback() bool {
+	return s.c.usedReferrersFallback()
+}
+
+// UsedReferrersFallback returns true if referrers were listed or stored so far using the referrers tag schema,
+// because the registry does not support the referrers API.
+func (d *dockerImageDestination) UsedReferrersFallback() bool {
+	return d.c.usedReferrersFallback()
+}
+
+// getReferrersFallbackIndex returns the list of referrers of manifestDigest in repo stored using the referrers tag schema,
+// or an empty list if there is none.
+func (c *dockerClient) getReferrersFallbackIndex(ctx context.Context, repo reference.Named, manifestDigest digest.Digest) (*manifest.OCI1ReferrersIndex, error) {
+	tag := ReferrersFallbackTag(manifestDigest)
+	path := fmt.Sprintf(manifestPath, reference.Path(repo), tag)
+	headers := map[string][]string{
+		"Accept": {imgspecv1.MediaTypeImageIndex},
+	}
+	res, err := c.makeRequest(ctx, http.MethodGet, path, headers, nil, v2Auth, nil)
+	if err != nil {
+		return nil, err
+	}
+	defer res.Body.Close()
+	switch res.StatusCode {
+	case http.StatusOK:
+	case http.StatusNotFound:
+		return manifest.NewOCI1ReferrersIndex(nil), nil
+	default:
+		return nil, errors.Wrapf(registryHTTPResponseToError(res), "reading referrers tag %s in %s", tag, repo.Name())
+	}
+	body, err := iolimits.ReadAtMost(res.Body, iolimits.MaxManifestBodySize)
+	if err != nil {
+		return nil, err
+	}
+	return manifest.OCI1ReferrersIndexFromManifest(body)
+}
+
+// updateReferrersFallback adds m, which has just been uploaded with a response containing responseHeader,
+// to the list of referrers of its subject stored using the referrers tag schema, if m has a subject and the registry
+// has not indicated that it supports the referrers API.
+func (d *dockerImageDestination) updateReferrersFallback(ctx context.Context, m []byte, responseHeader http.Header) error {
+	subject, referrer, err := manifest.OCI1SubjectReferrer(m)
+	if err != nil {
+		logrus.Debugf("Not updating referrers of the manifest: %v", err)
+		return nil
+	}
+	if subject == nil {
+		return nil
+	}
+	if responseHeader.Get("OCI-Subject") != "" {
+		return nil // The registry supports the referrers API, and has processed the subject itself.
+	}
+
+	d.c.reportReferrersAPIUnsupported()
+	logrus.Debugf("Updating referrers of %s using the referrers tag schema", subject.Digest)
+	index, err := d.c.getReferrersFallbackIndex(ctx, d.ref.ref, subject.Digest)
+	if err != nil {
+		return err
+	}
+	if !index.Add(referrer) {
+		return nil
+	}
+	indexBlob, err := index.Serialize()
+	if err != nil {
+		return err
+	}
+	if _, err := d.uploadManifest(ctx, indexBlob, ReferrersFallbackTag(subject.Digest)); err != nil {
+		return errors.Wrapf(err, "updating referrers of %s", subject.Digest)
+	}
+	return nil
+}
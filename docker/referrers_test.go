@@ -0,0 +1,208 @@
+package docker
+
+import (
+	"context"
+	"encoding/json"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/containers/image/v5/internal/private"
+	"github.com/containers/image/v5/manifest"
+	"github.com/containers/image/v5/types"
+	"github.com/opencontainers/go-digest"
+	imgspecv1 "github.com/opencontainers/image-spec/specs-go/v1"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestReferrersFallbackTag(t *testing.T) {
+	d := digest.Digest("sha256:0123456789abcdef0123456789abcdef0123456789abcdef0123456789abcdef")
+	assert.Equal(t, "sha256-0123456789abcdef0123456789abcdef0123456789abcdef0123456789abcdef", ReferrersFallbackTag(d))
+	d = digest.Digest("sha512:" + strings.Repeat("0", 128))
+	assert.Equal(t, "sha512-"+strings.Repeat("0", 121), ReferrersFallbackTag(d))
+}
+
+// referrersTestRegistry is a registry storing manifests, which optionally supports the referrers API.
+type referrersTestRegistry struct {
+	t            *testing.T
+	referrersAPI bool
+	manifests    map[string][]byte // tag or digest -> manifest
+}
+
+func (r *referrersTestRegistry) ServeHTTP(w http.ResponseWriter, req *http.Request) {
+	switch {
+	case req.URL.Path == "/v2/":
+		w.WriteHeader(http.StatusOK)
+	case strings.HasPrefix(req.URL.Path, "/v2/repo/manifests/"):
+		refTail := strings.TrimPrefix(req.URL.Path, "/v2/repo/manifests/")
+		switch req.Method {
+		case http.MethodPut:
+			body, err := io.ReadAll(req.Body)
+			require.NoError(r.t, err)
+			r.manifests[refTail] = body
+			r.manifests[digest.FromBytes(body).String()] = body
+			if r.referrersAPI && strings.Contains(string(body), `"subject"`) {
+				w.Header().Set("OCI-Subject", "sha256:...")
+			}
+			w.WriteHeader(http.StatusCreated)
+		case http.MethodGet:
+			m, ok := r.manifests[refTail]
+			if !ok {
+				w.WriteHeader(http.StatusNotFound)
+				return
+			}
+			w.Header().Set("Content-Type", manifest.GuessMIMEType(m))
+			_, _ = w.Write(m)
+		default:
+			w.WriteHeader(http.StatusMethodNotAllowed)
+		}
+	case r.referrersAPI && strings.HasPrefix(req.URL.Path, "/v2/repo/referrers/"):
+		subject := strings.TrimPrefix(req.URL.Path, "/v2/repo/referrers/")
+		index := manifest.NewOCI1ReferrersIndex(nil)
+		for d, m := range r.manifests {
+			if !strings.Contains(d, ":") {
+				continue
+			}
+			s, referrer, err := manifest.OCI1SubjectReferrer(m)
+			require.NoError(r.t, err)
+			if s != nil && s.Digest.String() == subject {
+				index.Add(referrer)
+			}
+		}
+		// Does not implement artifactType filtering, and does not set OCI-Filters-Applied.
+		body, err := index.Serialize()
+		require.NoError(r.t, err)
+		w.Header().Set("Content-Type", imgspecv1.MediaTypeImageIndex)
+		_, _ = w.Write(body)
+	default:
+		w.WriteHeader(http.StatusNotFound)
+	}
+}
+
+func TestReferrers(t *testing.T) {
+	image := []byte(`{"schemaVersion":2,"mediaType":"application/vnd.oci.image.manifest.v1+json",` +
+		`"config":{"mediaType":"application/vnd.oci.image.config.v1+json","digest":"sha256:0000000000000000000000000000000000000000000000000000000000000000","size":1},"layers":[]}`)
+	imageDigest := digest.FromBytes(image)
+	artifact := func(artifactType string) []byte {
+		res, err := json.Marshal(map[string]interface{}{
+			"schemaVersion": 2,
+			"mediaType":     imgspecv1.MediaTypeImageManifest,
+			"artifactType":  artifactType,
+			"config":        imgspecv1.Descriptor{MediaType: "application/vnd.oci.empty.v1+json", Digest: digest.FromString("{}"), Size: 2},
+			"layers":        []imgspecv1.Descriptor{},
+			"subject":       imgspecv1.Descriptor{MediaType: imgspecv1.MediaTypeImageManifest, Digest: imageDigest, Size: int64(len(image))},
+			"annotations":   map[string]string{"type": artifactType},
+		})
+		require.NoError(t, err)
+		return res
+	}
+	signature, sbom := artifact("application/vnd.example.signature"), artifact("application/vnd.example.sbom")
+
+	for _, referrersAPI := range []bool{true, false} {
+		r := &referrersTestRegistry{t: t, referrersAPI: referrersAPI, manifests: map[string][]byte{}}
+		s := httptest.NewServer(r)
+		defer s.Close()
+		warningCodes := map[string]int{}
+		sys := &types.SystemContext{
+			DockerInsecureSkipTLSVerify: types.OptionalBoolTrue,
+			WarningHandler:              func(w types.Warning) { warningCodes[w.Code]++ },
+		}
+
+		imageRef, err := ParseReference("//" + strings.TrimPrefix(s.URL, "http://") + "/repo:tag")
+		require.NoError(t, err)
+		dest, err := imageRef.NewImageDestination(context.Background(), sys)
+		require.NoError(t, err)
+		err = dest.PutManifest(context.Background(), image, nil)
+		require.NoError(t, err)
+		assert.False(t, dest.(private.ReferrersFallbackReporter).UsedReferrersFallback()) // The image has no subject
+		dest.Close()
+
+		referrers, err := GetReferrers(context.Background(), sys, imageRef, imageDigest, "")
+		require.NoError(t, err)
+		assert.Empty(t, referrers)
+
+		for _, m := range [][]byte{signature, sbom, signature} {
+			artifactRef, err := ParseReference("//" + strings.TrimPrefix(s.URL, "http://") + "/repo@" + digest.FromBytes(m).String())
+			require.NoError(t, err)
+			dest, err := artifactRef.NewImageDestination(context.Background(), sys)
+			require.NoError(t, err)
+			err = dest.PutManifest(context.Background(), m, nil)
+			require.NoError(t, err)
+			assert.Equal(t, !referrersAPI, dest.(private.ReferrersFallbackReporter).UsedReferrersFallback())
+			dest.Close()
+		}
+		_, fallbackUsed := r.manifests[ReferrersFallbackTag(imageDigest)]
+		assert.Equal(t, !referrersAPI, fallbackUsed)
+		if referrersAPI {
+			assert.Empty(t, warningCodes)
+		} else {
+			assert.Equal(t, map[string]int{types.WarningCodeReferrersAPIUnsupported: 4}, warningCodes) // One listing, and three uploads
+		}
+
+		referrers, err = GetReferrers(context.Background(), sys, imageRef, imageDigest, "")
+		require.NoError(t, err)
+		referrerDigests := []digest.Digest{}
+		for _, r := range referrers {
+			assert.Equal(t, imgspecv1.MediaTypeImageManifest, r.MediaType)
+			assert.Equal(t, r.ArtifactType, r.Annotations["type"])
+			referrerDigests = append(referrerDigests, r.Digest)
+		}
+		assert.ElementsMatch(t, []digest.Digest{digest.FromBytes(signature), digest.FromBytes(sbom)}, referrerDigests)
+
+		src, err := imageRef.NewImageSource(context.Background(), sys)
+		require.NoError(t, err)
+		lister, ok := src.(private.ReferrersLister)
+		require.True(t, ok)
+		assert.False(t, src.(private.ReferrersFallbackReporter).UsedReferrersFallback())
+		referrers, err = lister.GetReferrers(context.Background(), imageDigest, "application/vnd.example.sbom")
+		require.NoError(t, err)
+		assert.Equal(t, !referrersAPI, src.(private.ReferrersFallbackReporter).UsedReferrersFallback())
+		require.Len(t, referrers, 1)
+		assert.Equal(t, digest.FromBytes(sbom), referrers[0].Digest)
+		assert.Equal(t, int64(len(sbom)), referrers[0].Size)
+		src.Close()
+	}
+}
+
+func TestGetManifestForTag(t *testing.T) {
+	image := []byte(`{"schemaVersion":2,"mediaType":"application/vnd.oci.image.manifest.v1+json",` +
+		`"config":{"mediaType":"application/vnd.oci.image.config.v1+json","digest":"sha256:0000000000000000000000000000000000000000000000000000000000000000","size":1},"layers":[]}`)
+	imageDigest := digest.FromBytes(image)
+	signatureTag := ReferrersFallbackTag(imageDigest) + ".sig"
+	r := &referrersTestRegistry{t: t, manifests: map[string][]byte{"tag": image, signatureTag: image}}
+	s := httptest.NewServer(r)
+	defer s.Close()
+	sys := &types.SystemContext{DockerInsecureSkipTLSVerify: types.OptionalBoolTrue}
+
+	imageRef, err := ParseReference("//" + strings.TrimPrefix(s.URL, "http://") + "/repo:tag")
+	require.NoError(t, err)
+	src, err := imageRef.NewImageSource(context.Background(), sys)
+	require.NoError(t, err)
+	defer src.Close()
+	reader, ok := src.(private.TaggedManifestReader)
+	require.True(t, ok)
+
+	man, mimeType, err := reader.GetManifestForTag(context.Background(), signatureTag)
+	require.NoError(t, err)
+	assert.Equal(t, image, man)
+	assert.Equal(t, imgspecv1.MediaTypeImageManifest, mimeType)
+
+	man, _, err = reader.GetManifestForTag(context.Background(), ReferrersFallbackTag(imageDigest)+".att")
+	require.NoError(t, err)
+	assert.Nil(t, man)
+
+	_, _, err = reader.GetManifestForTag(context.Background(), "invalid:tag")
+	assert.Error(t, err)
+
+	dest, err := imageRef.NewImageDestination(context.Background(), sys)
+	require.NoError(t, err)
+	defer dest.Close()
+	reader, ok = dest.(private.TaggedManifestReader)
+	require.True(t, ok)
+	man, _, err = reader.GetManifestForTag(context.Background(), signatureTag)
+	require.NoError(t, err)
+	assert.Equal(t, image, man)
+}
@@ -0,0 +1,74 @@
+package docker
+
+import (
+	"context"
+	"time"
+
+	"github.com/containers/image/v5/pkg/docker/config"
+	"github.com/containers/image/v5/types"
+	"github.com/pkg/errors"
+	"github.com/sirupsen/logrus"
+)
+
+// AccessToken is a short-lived OAuth2 access token for a registry.
+type AccessToken struct {
+	Token string
+	// ExpiresAt is the time after which Token should no longer be used.
+	ExpiresAt time.Time
+}
+
+// RefreshAccessToken exchanges the OAuth2 refresh token stored for registry (a host[:port]), see config.StoreRefreshToken,
+// for a fresh access token for scopes (in the "repository:name:actions" format).
+// If scopes is empty, the scopes recorded with the refresh token, if any, are used.
+// If the token endpoint is not recorded with the refresh token, it is discovered from the registry’s authentication challenge.
+// If the registry rotates the refresh token, or the token endpoint was discovered, the stored refresh token is updated.
+func RefreshAccessToken(ctx context.Context, sys *types.SystemContext, registry string, scopes ...string) (AccessToken, error) {
+	refreshToken, err := config.GetRefreshToken(sys, registry)
+	if err != nil {
+		return AccessToken{}, errors.Wrapf(err, "getting refresh token")
+	}
+	if refreshToken.Token == "" {
+		return AccessToken{}, errors.Errorf("no refresh token stored for %s", registry)
+	}
+
+	client, err := newDockerClient(sys, registry, registry)
+	if err != nil {
+		return AccessToken{}, errors.Wrapf(err, "creating new docker client")
+	}
+	if err := client.detectProperties(ctx); err != nil {
+		return AccessToken{}, err
+	}
+
+	updated := refreshToken
+	if updated.Endpoint == "" {
+		for _, challenge := range client.challenges {
+			if challenge.Scheme == "bearer" && challenge.Parameters["realm"] != "" {
+				updated.Endpoint = challenge.Parameters["realm"]
+				updated.Service = challenge.Parameters["service"]
+				break
+			}
+		}
+		if updated.Endpoint == "" {
+			return AccessToken{}, errors.Errorf("registry %s does not provide a token endpoint", registry)
+		}
+	}
+	if len(scopes) == 0 {
+		scopes = updated.Scopes
+	}
+
+	token, err := client.getBearerTokenForRefreshToken(ctx, updated.Endpoint, updated.Service, scopes, updated.Token)
+	if err != nil {
+		return AccessToken{}, err
+	}
+
+	if token.RefreshToken != "" {
+		updated.Token = token.RefreshToken
+	}
+	if updated.Token != refreshToken.Token || updated.Endpoint != refreshToken.Endpoint {
+		if _, err := config.StoreRefreshToken(sys, registry, updated); err != nil {
+			return AccessToken{}, errors.Wrapf(err, "storing refresh token")
+		}
+		logrus.Debugf("Updated stored refresh token for %s", registry)
+	}
+	return AccessToken{Token: token.Token, ExpiresAt: token.expirationTime}, nil
+}
@@ -0,0 +1,90 @@
+package docker
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/containers/image/v5/pkg/docker/config"
+	"github.com/containers/image/v5/types"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestRefreshAccessToken(t *testing.T) {
+	var tokenRequests []string
+	var s *httptest.Server
+	s = httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		switch r.URL.Path {
+		case "/v2/":
+			w.Header().Set("WWW-Authenticate", fmt.Sprintf(`Bearer realm="%s/token",service="test-registry"`, s.URL))
+			w.WriteHeader(http.StatusUnauthorized)
+		case "/token":
+			if err := r.ParseForm(); err != nil || r.Method != http.MethodPost {
+				w.WriteHeader(http.StatusBadRequest)
+				return
+			}
+			tokenRequests = append(tokenRequests, fmt.Sprintf("%s %s %s %s", r.PostForm.Get("grant_type"),
+				r.PostForm.Get("service"), strings.Join(r.PostForm["scope"], ","), r.PostForm.Get("refresh_token")))
+			if r.PostForm.Get("refresh_token") == "revoked" {
+				w.WriteHeader(http.StatusUnauthorized)
+				return
+			}
+			fmt.Fprint(w, `{"access_token":"access-token","expires_in":300,"refresh_token":"rotated-token"}`)
+		default:
+			w.WriteHeader(http.StatusNotFound)
+		}
+	}))
+	defer s.Close()
+	registry := strings.TrimPrefix(s.URL, "http://")
+	// For this test against localhost, we don't care.
+	sys := &types.SystemContext{
+		DockerInsecureSkipTLSVerify: types.OptionalBoolTrue,
+		DockerDisableV1Ping:         true,
+		AuthFilePath:                filepath.Join(t.TempDir(), "auth.json"),
+		CredentialHelpers:           []string{"containers-auth.json"},
+	}
+
+	// No refresh token stored
+	_, err := RefreshAccessToken(context.Background(), sys, registry)
+	assert.Error(t, err)
+
+	_, err = config.StoreRefreshToken(sys, registry, config.RefreshToken{
+		Token:  "initial-token",
+		Scopes: []string{"repository:ns/repo:pull"},
+	})
+	require.NoError(t, err)
+	token, err := RefreshAccessToken(context.Background(), sys, registry)
+	require.NoError(t, err)
+	assert.Equal(t, "access-token", token.Token)
+	assert.False(t, token.ExpiresAt.IsZero())
+	assert.Equal(t, []string{"refresh_token test-registry repository:ns/repo:pull initial-token"}, tokenRequests)
+	// The rotated token and the discovered endpoint were stored
+	stored, err := config.GetRefreshToken(sys, registry)
+	require.NoError(t, err)
+	assert.Equal(t, config.RefreshToken{
+		Token:    "rotated-token",
+		Endpoint: s.URL + "/token",
+		Service:  "test-registry",
+		Scopes:   []string{"repository:ns/repo:pull"},
+	}, stored)
+
+	// Explicitly requested scopes
+	tokenRequests = nil
+	_, err = RefreshAccessToken(context.Background(), sys, registry, "repository:ns/repo:pull,push")
+	require.NoError(t, err)
+	assert.Equal(t, []string{"refresh_token test-registry repository:ns/repo:pull,push rotated-token"}, tokenRequests)
+
+	// Rejected refresh tokens are not modified
+	_, err = config.StoreRefreshToken(sys, registry, config.RefreshToken{Token: "revoked"})
+	require.NoError(t, err)
+	_, err = RefreshAccessToken(context.Background(), sys, registry)
+	assert.Error(t, err)
+	stored, err = config.GetRefreshToken(sys, registry)
+	require.NoError(t, err)
+	assert.Equal(t, config.RefreshToken{Token: "revoked"}, stored)
+}
@@ -0,0 +1,186 @@
+package docker
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/url"
+	"strings"
+
+	"github.com/containers/image/v5/internal/iolimits"
+	"github.com/containers/image/v5/pkg/docker/config"
+	"github.com/containers/image/v5/types"
+	"github.com/pkg/errors"
+)
+
+const (
+	// extensionsDiscoveryPath is the OCI distribution-spec endpoint listing the API extensions supported by a registry.
+	extensionsDiscoveryPath = "/v2/_oci/ext/discover"
+	// zotSearchPath is the GraphQL search endpoint of the "_zot" extension.
+	zotSearchPath = "/v2/_zot/ext/search"
+)
+
+// RegistryExtension describes an API extension supported by a registry, as listed by the
+// OCI distribution-spec extension discovery endpoint (/v2/_oci/ext/discover).
+type RegistryExtension struct {
+	// Name is the name of the extension, e.g. "_oci" or "_zot".
+	Name string `json:"name"`
+	// URL points to the documentation of the extension.
+	URL string `json:"url,omitempty"`
+	// Description is a human-readable description of the extension.
+	Description string `json:"description,omitempty"`
+	// Endpoints lists the API endpoints provided by the extension, e.g. "/v2/_zot/ext/search".
+	Endpoints []string `json:"endpoints,omitempty"`
+}
+
+// extensionsDiscoveryResponse is the response of extensionsDiscoveryPath.
+type extensionsDiscoveryResponse struct {
+	Extensions []RegistryExtension `json:"extensions"`
+}
+
+// DiscoverRegistryExtensions returns the API extensions supported by registry (a host[:port]).
+// Registries which don’t implement extension discovery return an empty list, not an error.
+func DiscoverRegistryExtensions(ctx context.Context, sys *types.SystemContext, registry string) ([]RegistryExtension, error) {
+	client, err := newRegistryClient(sys, registry, registry)
+	if err != nil {
+		return nil, err
+	}
+	return client.discoverExtensions(ctx)
+}
+
+// newRegistryClient returns a dockerClient for accessing hostname, authenticated using the credentials for registry,
+// for operations which are not specific to a single repository.
+func newRegistryClient(sys *types.SystemContext, hostname, registry string) (*dockerClient, error) {
+	// Get credentials from authfile for the underlying hostname
+	// We can't use GetCredentialsForRef here because we want to access the whole registry.
+	auth, err := config.GetCredentials(sys, registry)
+	if err != nil {
+		return nil, errors.Wrapf(err, "getting username and password")
+	}
+
+	client, err := newDockerClient(sys, hostname, registry)
+	if err != nil {
+		return nil, errors.Wrapf(err, "creating new docker client")
+	}
+	if err := client.setAuth(auth); err != nil {
+		return nil, err
+	}
+	if sys != nil {
+		client.registryToken = sys.DockerBearerRegistryToken
+	}
+	return client, nil
+}
+
+// discoverExtensions returns the API extensions supported by the registry, or an empty list if it does not support discovery.
+func (c *dockerClient) discoverExtensions(ctx context.Context) ([]RegistryExtension, error) {
+	res, err := c.makeRequest(ctx, http.MethodGet, extensionsDiscoveryPath, nil, nil, v2Auth, nil)
+	if err != nil {
+		return nil, err
+	}
+	defer res.Body.Close()
+	switch res.StatusCode {
+	case http.StatusOK:
+	case http.StatusNotFound, http.StatusMethodNotAllowed:
+		return []RegistryExtension{}, nil
+	default:
+		return nil, errors.Wrapf(registryHTTPResponseToError(res), "discovering API extensions of %s", c.registry)
+	}
+
+	body, err := iolimits.ReadAtMost(res.Body, iolimits.MaxRegistryExtensionBodySize)
+	if err != nil {
+		return nil, err
+	}
+	var parsed extensionsDiscoveryResponse
+	if err := json.Unmarshal(body, &parsed); err != nil {
+		return nil, errors.Wrapf(err, "decoding API extensions of %s", c.registry)
+	}
+	if parsed.Extensions == nil {
+		return []RegistryExtension{}, nil
+	}
+	return parsed.Extensions, nil
+}
+
+// searchUsingExtensions searches for repositories matching query using a registry API extension.
+// It returns ok == false if the registry does not support a search extension we know how to use.
+func (c *dockerClient) searchUsingExtensions(ctx context.Context, query string, limit int) (res []SearchResult, ok bool, err error) {
+	extensions, err := c.discoverExtensions(ctx)
+	if err != nil {
+		return nil, false, err
+	}
+	for _, ext := range extensions {
+		for _, endpoint := range ext.Endpoints {
+			if endpoint == zotSearchPath {
+				res, err := c.searchUsingZotExtension(ctx, query, limit)
+				return res, err == nil, err
+			}
+		}
+	}
+	return nil, false, nil
+}
+
+// zotSearchResponse is the subset of the GraphQL response of a zot GlobalSearch query we use.
+type zotSearchResponse struct {
+	Data struct {
+		GlobalSearch struct {
+			Repos []struct {
+				Name        string `json:"Name"`
+				StarCount   int    `json:"StarCount"`
+				NewestImage struct {
+					Description string `json:"Description"`
+				} `json:"NewestImage"`
+			} `json:"Repos"`
+		} `json:"GlobalSearch"`
+	} `json:"data"`
+	Errors []struct {
+		Message string `json:"message"`
+	} `json:"errors"`
+}
+
+// searchUsingZotExtension searches for repositories matching query using the GraphQL search API of the "_zot" extension.
+func (c *dockerClient) searchUsingZotExtension(ctx context.Context, query string, limit int) ([]SearchResult, error) {
+	quotedQuery, err := json.Marshal(query) // JSON string syntax is valid GraphQL string syntax.
+	if err != nil {
+		return nil, err
+	}
+	graphQL := fmt.Sprintf(`{GlobalSearch(query:%s, requestedPage:{limit:%d offset:0 sortBy:RELEVANCE})`+
+		`{Repos{Name StarCount NewestImage{Description}}}}`, quotedQuery, limit)
+	path := zotSearchPath + "?" + url.Values{"query": {graphQL}}.Encode()
+
+	res, err := c.makeRequest(ctx, http.MethodGet, path, nil, nil, v2Auth, nil)
+	if err != nil {
+		return nil, err
+	}
+	defer res.Body.Close()
+	if res.StatusCode != http.StatusOK {
+		return nil, errors.Wrapf(registryHTTPResponseToError(res), "searching %s", c.registry)
+	}
+	body, err := iolimits.ReadAtMost(res.Body, iolimits.MaxRegistryExtensionBodySize)
+	if err != nil {
+		return nil, err
+	}
+	var parsed zotSearchResponse
+	if err := json.Unmarshal(body, &parsed); err != nil {
+		return nil, errors.Wrapf(err, "decoding search results from %s", c.registry)
+	}
+	if len(parsed.Errors) != 0 {
+		messages := make([]string, 0, len(parsed.Errors))
+		for _, e := range parsed.Errors {
+			messages = append(messages, e.Message)
+		}
+		return nil, errors.Errorf("searching %s: %s", c.registry, strings.Join(messages, "; "))
+	}
+
+	searchRes := []SearchResult{}
+	for _, repo := range parsed.Data.GlobalSearch.Repos {
+		if len(searchRes) == limit {
+			break
+		}
+		searchRes = append(searchRes, SearchResult{
+			Name:        repo.Name,
+			Description: repo.NewestImage.Description,
+			StarCount:   repo.StarCount,
+		})
+	}
+	return searchRes, nil
+}
@@ -0,0 +1,78 @@
+package docker
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/containers/image/v5/types"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestRegistryExtensions(t *testing.T) {
+	withExtensions := true
+	var searchQuery string
+	s := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		switch {
+		case r.URL.Path == "/v2/":
+			w.WriteHeader(http.StatusOK)
+		case r.URL.Path == extensionsDiscoveryPath && withExtensions:
+			fmt.Fprint(w, `{"extensions":[{"name":"_zot","url":"https://example.com/_zot.md","description":"zot registry extensions",`+
+				`"endpoints":["/v2/_zot/ext/search","/v2/_zot/ext/userprefs"]}]}`)
+		case r.URL.Path == zotSearchPath && withExtensions:
+			searchQuery = r.URL.Query().Get("query")
+			fmt.Fprint(w, `{"data":{"GlobalSearch":{"Repos":[`+
+				`{"Name":"alpine","StarCount":3,"NewestImage":{"Description":"A minimal image"}},`+
+				`{"Name":"ns/alpine-extra","StarCount":0,"NewestImage":{"Description":""}}]}}}`)
+		case r.URL.Path == "/v2/_catalog":
+			fmt.Fprint(w, `{"repositories":["alpine","busybox"]}`)
+		default:
+			w.WriteHeader(http.StatusNotFound)
+		}
+	}))
+	defer s.Close()
+	registry := strings.TrimPrefix(s.URL, "http://")
+	// For this test against localhost, we don't care.
+	sys := &types.SystemContext{
+		DockerInsecureSkipTLSVerify: types.OptionalBoolTrue,
+		DockerDisableV1Ping:         true,
+		AuthFilePath:                filepath.Join(t.TempDir(), "auth.json"),
+	}
+
+	extensions, err := DiscoverRegistryExtensions(context.Background(), sys, registry)
+	require.NoError(t, err)
+	assert.Equal(t, []RegistryExtension{{
+		Name:        "_zot",
+		URL:         "https://example.com/_zot.md",
+		Description: "zot registry extensions",
+		Endpoints:   []string{"/v2/_zot/ext/search", "/v2/_zot/ext/userprefs"},
+	}}, extensions)
+
+	res, err := SearchRegistry(context.Background(), sys, registry, "alpine", 10)
+	require.NoError(t, err)
+	assert.Equal(t, []SearchResult{
+		{Name: "alpine", Description: "A minimal image", StarCount: 3},
+		{Name: "ns/alpine-extra"},
+	}, res)
+	assert.Contains(t, searchQuery, `GlobalSearch(query:"alpine"`)
+	assert.Contains(t, searchQuery, "limit:10")
+
+	res, err = SearchRegistry(context.Background(), sys, registry, "alpine", 1)
+	require.NoError(t, err)
+	assert.Len(t, res, 1)
+
+	// Registries without extension discovery
+	withExtensions = false
+	extensions, err = DiscoverRegistryExtensions(context.Background(), sys, registry)
+	require.NoError(t, err)
+	assert.Empty(t, extensions)
+
+	res, err = SearchRegistry(context.Background(), sys, registry, "alpine", 10)
+	require.NoError(t, err)
+	assert.Equal(t, []SearchResult{{Name: "alpine"}}, res)
+}
@@ -0,0 +1,113 @@
+package docker
+
+import (
+	"net/http"
+	"strings"
+
+	"github.com/containers/image/v5/internal/warnings"
+	"github.com/containers/image/v5/types"
+)
+
+// registryWarning is a single warning-value of a HTTP Warning header (RFC 7234 section 5.5).
+type registryWarning struct {
+	code  string // The three-digit warn-code, e.g. "299"
+	agent string // The warn-agent, "-" if unknown
+	text  string // The unquoted warn-text
+}
+
+// parseWarningHeaders returns the warnings contained in values of HTTP Warning headers.
+// Malformed values are ignored, from the first syntax error to the end of the header value.
+func parseWarningHeaders(values []string) []registryWarning {
+	res := []registryWarning{}
+	for _, v := range values {
+		for {
+			v = strings.TrimLeft(v, " \t,")
+			if v == "" {
+				break
+			}
+			w, rest, ok := parseWarningValue(v)
+			if !ok {
+				break
+			}
+			res = append(res, w)
+			v = rest
+		}
+	}
+	return res
+}
+
+// parseWarningValue parses a single warning-value at the start of v, and returns it and the rest of v.
+func parseWarningValue(v string) (registryWarning, string, bool) {
+	if len(v) < 4 || v[3] != ' ' {
+		return registryWarning{}, "", false
+	}
+	code := v[:3]
+	for _, c := range code {
+		if c < '0' || c > '9' {
+			return registryWarning{}, "", false
+		}
+	}
+	v = v[4:]
+	agentEnd := strings.IndexByte(v, ' ')
+	if agentEnd <= 0 {
+		return registryWarning{}, "", false
+	}
+	agent := v[:agentEnd]
+	text, rest, ok := parseQuotedString(v[agentEnd+1:])
+	if !ok {
+		return registryWarning{}, "", false
+	}
+	// Skip the optional warn-date.
+	if strings.HasPrefix(rest, " \"") {
+		if _, afterDate, ok := parseQuotedString(rest[1:]); ok {
+			rest = afterDate
+		}
+	}
+	if rest != "" && !strings.HasPrefix(strings.TrimLeft(rest, " \t"), ",") {
+		return registryWarning{}, "", false
+	}
+	return registryWarning{code: code, agent: agent, text: text}, rest, true
+}
+
+// parseQuotedString parses a HTTP quoted-string at the start of v, and returns its unquoted contents and the rest of v.
+func parseQuotedString(v string) (string, string, bool) {
+	if !strings.HasPrefix(v, "\"") {
+		return "", "", false
+	}
+	var sb strings.Builder
+	for i := 1; i < len(v); i++ {
+		switch v[i] {
+		case '"':
+			return sb.String(), v[i+1:], true
+		case '\\':
+			i++
+			if i == len(v) {
+				return "", "", false
+			}
+			sb.WriteByte(v[i])
+		default:
+			sb.WriteByte(v[i])
+		}
+	}
+	return "", "", false
+}
+
+// reportWarningHeaders reports the warnings in HTTP Warning headers of res, if any, through c.sys.WarningHandler.
+// Each distinct warning is only reported once per client.
+func (c *dockerClient) reportWarningHeaders(res *http.Response) {
+	values := res.Header.Values("Warning")
+	if len(values) == 0 {
+		return
+	}
+	for _, w := range parseWarningHeaders(values) {
+		if _, seen := c.reportedWarnings.LoadOrStore(w, struct{}{}); seen {
+			continue
+		}
+		warnings.Report(c.sys, types.Warning{
+			Kind:    types.WarningRegistry,
+			Code:    types.WarningCodeRegistryWarning,
+			Subject: c.registry,
+			Message: w.text,
+		})
+	}
+}
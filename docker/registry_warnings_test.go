@@ -0,0 +1,83 @@
+package docker
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/containers/image/v5/types"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestParseWarningHeaders(t *testing.T) {
+	for _, c := range []struct {
+		values   []string
+		expected []registryWarning
+	}{
+		{nil, []registryWarning{}},
+		{[]string{`299 - "Deprecated API"`}, []registryWarning{{"299", "-", "Deprecated API"}}},
+		{
+			[]string{`299 - "first, with a comma", 199 registry.example.com:5000 "second \"quoted\"" "Wed, 21 Oct 2015 07:28:00 GMT"`},
+			[]registryWarning{
+				{"299", "-", "first, with a comma"},
+				{"199", "registry.example.com:5000", `second "quoted"`},
+			},
+		},
+		{
+			[]string{`299 - "one"`, `  299 - "two" , 299 - "three"`},
+			[]registryWarning{{"299", "-", "one"}, {"299", "-", "two"}, {"299", "-", "three"}},
+		},
+		// Malformed values
+		{[]string{`Deprecated API`}, []registryWarning{}},
+		{[]string{`29 - "short code"`}, []registryWarning{}},
+		{[]string{`2x9 - "invalid code"`}, []registryWarning{}},
+		{[]string{`299 "no agent"`}, []registryWarning{}},
+		{[]string{`299 - unquoted`}, []registryWarning{}},
+		{[]string{`299 - "unterminated`}, []registryWarning{}},
+		{[]string{`299 - "trailing \`}, []registryWarning{}},
+		{[]string{`299 - "valid" garbage, 299 - "ignored"`}, []registryWarning{}},
+		{[]string{`299 - "valid", garbage, 299 - "ignored"`, `299 - "next"`}, []registryWarning{{"299", "-", "valid"}, {"299", "-", "next"}}},
+	} {
+		assert.Equal(t, c.expected, parseWarningHeaders(c.values), "%#v", c.values)
+	}
+}
+
+func TestDockerClientReportWarningHeaders(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(rw http.ResponseWriter, r *http.Request) {
+		rw.Header().Add("Warning", `299 - "This registry is deprecated"`)
+		if r.URL.Path != "/v2/" {
+			rw.Header().Add("Warning", `299 - "Rate limits will be enforced from next month"`)
+		}
+		rw.WriteHeader(http.StatusOK)
+	}))
+	defer server.Close()
+	registry := strings.TrimPrefix(server.URL, "http://")
+
+	registriesConf := filepath.Join(t.TempDir(), "registries.conf")
+	err := os.WriteFile(registriesConf, []byte{}, 0600)
+	require.NoError(t, err)
+	reported := []types.Warning{}
+	sys := &types.SystemContext{
+		SystemRegistriesConfPath:    registriesConf,
+		SystemRegistriesConfDirPath: "/this/does/not/exist",
+		DockerPerHostCertDirPath:    "/this/does/not/exist",
+		DockerInsecureSkipTLSVerify: types.OptionalBoolTrue,
+		WarningHandler:              func(w types.Warning) { reported = append(reported, w) },
+	}
+	client, err := newDockerClient(sys, registry, registry)
+	require.NoError(t, err)
+	for i := 0; i < 2; i++ {
+		res, err := client.makeRequest(context.Background(), http.MethodGet, "/v2/busybox/tags/list", nil, nil, v2Auth, nil)
+		require.NoError(t, err)
+		res.Body.Close()
+	}
+	assert.Equal(t, []types.Warning{
+		{Kind: types.WarningRegistry, Code: types.WarningCodeRegistryWarning, Subject: registry, Message: "This registry is deprecated"},
+		{Kind: types.WarningRegistry, Code: types.WarningCodeRegistryWarning, Subject: registry, Message: "Rate limits will be enforced from next month"},
+	}, reported)
+}
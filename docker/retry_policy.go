@@ -0,0 +1,164 @@
+package docker
+
+import (
+	"context"
+	"io"
+	"math/rand"
+	"net/http"
+	"time"
+
+	"github.com/containers/image/v5/types"
+	"github.com/sirupsen/logrus"
+)
+
+// defaultRetryableStatusCodes are retried if types.DockerRetryPolicy.RetryableStatusCodes is nil.
+var defaultRetryableStatusCodes = []int{
+	http.StatusTooManyRequests,
+	http.StatusBadGateway,
+	http.StatusServiceUnavailable,
+	http.StatusGatewayTimeout,
+}
+
+// retryPolicy is the effective form of types.DockerRetryPolicy.
+type retryPolicy struct {
+	maxAttempts     int
+	initialDelay    time.Duration
+	maxDelay        time.Duration
+	jitter          float64
+	statusCodes     map[int]struct{}
+	honorRetryAfter bool
+}
+
+// newRetryPolicy returns the effective retry policy for policy, which may be nil.
+func newRetryPolicy(policy *types.DockerRetryPolicy) retryPolicy {
+	res := retryPolicy{
+		maxAttempts:     backoffNumIterations,
+		initialDelay:    backoffInitialDelay,
+		maxDelay:        backoffMaxDelay,
+		statusCodes:     map[int]struct{}{http.StatusTooManyRequests: {}},
+		honorRetryAfter: true,
+	}
+	if policy == nil {
+		return res
+	}
+	if policy.MaxAttempts > 0 {
+		res.maxAttempts = policy.MaxAttempts
+	}
+	if policy.InitialDelay > 0 {
+		res.initialDelay = policy.InitialDelay
+	}
+	if policy.MaxDelay > 0 {
+		res.maxDelay = policy.MaxDelay
+	}
+	switch {
+	case policy.Jitter < 0:
+		res.jitter = 0
+	case policy.Jitter > 1:
+		res.jitter = 1
+	default:
+		res.jitter = policy.Jitter
+	}
+	statusCodes := policy.RetryableStatusCodes
+	if statusCodes == nil {
+		statusCodes = defaultRetryableStatusCodes
+	}
+	res.statusCodes = map[int]struct{}{}
+	for _, code := range statusCodes {
+		res.statusCodes[code] = struct{}{}
+	}
+	res.honorRetryAfter = !policy.IgnoreRetryAfter
+	return res
+}
+
+// retryPolicy returns the effective retry policy of c.
+func (c *dockerClient) retryPolicy() retryPolicy {
+	if c.sys == nil {
+		return newRetryPolicy(nil)
+	}
+	return newRetryPolicy(c.sys.DockerRetryPolicy)
+}
+
+// shouldRetry returns true if a request which received res, after attempts attempts, should be retried.
+func (p *retryPolicy) shouldRetry(res *http.Response, attempts int) bool {
+	if res == nil || attempts >= p.maxAttempts {
+		return false
+	}
+	_, ok := p.statusCodes[res.StatusCode]
+	return ok
+}
+
+// delay returns the delay before retrying a request which received res (or nil if no response was received),
+// using backoff as the exponential backoff delay.
+func (p *retryPolicy) delay(res *http.Response, backoff time.Duration) time.Duration {
+	if backoff > p.maxDelay {
+		backoff = p.maxDelay
+	}
+	if p.jitter > 0 {
+		backoff -= time.Duration(p.jitter * rand.Float64() * float64(backoff))
+	}
+	delay := backoff
+	if p.honorRetryAfter && res != nil {
+		delay = parseRetryAfter(res, backoff)
+	}
+	if delay > p.maxDelay {
+		delay = p.maxDelay
+	}
+	return delay
+}
+
+// rewindableStream returns a function which rewinds stream to its current position, if stream can be retried.
+// stream may be nil, in which case the returned function does nothing.
+func rewindableStream(stream io.Reader) (func() error, bool) {
+	if stream == nil {
+		return func() error { return nil }, true
+	}
+	seeker, ok := stream.(io.Seeker)
+	if !ok {
+		return nil, false
+	}
+	start, err := seeker.Seek(0, io.SeekCurrent)
+	if err != nil {
+		return nil, false
+	}
+	return func() error {
+		_, err := seeker.Seek(start, io.SeekStart)
+		return err
+	}, true
+}
+
+// sleepForRetry waits for delay, or until ctx is done.
+func sleepForRetry(ctx context.Context, delay time.Duration) error {
+	select {
+	case <-ctx.Done():
+		return ctx.Err()
+	case <-time.After(delay):
+		return nil
+	}
+}
+
+// doWithRetries executes requests created by newRequest, retrying according to c.retryPolicy().
+// It is used for requests which are not made using makeRequestToResolvedURL, e.g. to token endpoints.
+func (c *dockerClient) doWithRetries(ctx context.Context, newRequest func() (*http.Request, error)) (*http.Response, error) {
+	policy := c.retryPolicy()
+	backoff := policy.initialDelay
+	attempts := 0
+	for {
+		req, err := newRequest()
+		if err != nil {
+			return nil, err
+		}
+		logrus.Debugf("%s %s", req.Method, req.URL.Redacted())
+		res, err := c.client.Do(req)
+		attempts++
+		if err != nil || !policy.shouldRetry(res, attempts) {
+			return res, err
+		}
+		res.Body.Close()
+		delay := policy.delay(res, backoff)
+		logrus.Debugf("Request to %s failed with status %d: sleeping for %f seconds before next attempt", req.URL.Redacted(), res.StatusCode, delay.Seconds())
+		if err := sleepForRetry(ctx, delay); err != nil {
+			return nil, err
+		}
+		backoff *= 2
+	}
+}
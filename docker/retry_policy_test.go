@@ -0,0 +1,220 @@
+package docker
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/containers/image/v5/types"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestNewRetryPolicy(t *testing.T) {
+	p := newRetryPolicy(nil)
+	assert.Equal(t, retryPolicy{
+		maxAttempts:     backoffNumIterations,
+		initialDelay:    backoffInitialDelay,
+		maxDelay:        backoffMaxDelay,
+		statusCodes:     map[int]struct{}{http.StatusTooManyRequests: {}},
+		honorRetryAfter: true,
+	}, p)
+
+	p = newRetryPolicy(&types.DockerRetryPolicy{})
+	assert.Equal(t, backoffNumIterations, p.maxAttempts)
+	assert.Len(t, p.statusCodes, len(defaultRetryableStatusCodes))
+	assert.True(t, p.honorRetryAfter)
+
+	p = newRetryPolicy(&types.DockerRetryPolicy{
+		MaxAttempts:          2,
+		InitialDelay:         time.Millisecond,
+		MaxDelay:             time.Second,
+		Jitter:               2,
+		RetryableStatusCodes: []int{http.StatusInternalServerError},
+		IgnoreRetryAfter:     true,
+	})
+	assert.Equal(t, retryPolicy{
+		maxAttempts:     2,
+		initialDelay:    time.Millisecond,
+		maxDelay:        time.Second,
+		jitter:          1,
+		statusCodes:     map[int]struct{}{http.StatusInternalServerError: {}},
+		honorRetryAfter: false,
+	}, p)
+
+	p = newRetryPolicy(&types.DockerRetryPolicy{RetryableStatusCodes: []int{}})
+	assert.False(t, p.shouldRetry(&http.Response{StatusCode: http.StatusTooManyRequests}, 1))
+}
+
+func TestRetryPolicyShouldRetry(t *testing.T) {
+	p := newRetryPolicy(&types.DockerRetryPolicy{MaxAttempts: 3})
+	for _, c := range []struct {
+		status   int
+		attempts int
+		expected bool
+	}{
+		{http.StatusOK, 1, false},
+		{http.StatusNotFound, 1, false},
+		{http.StatusInternalServerError, 1, false},
+		{http.StatusBadGateway, 1, true},
+		{http.StatusServiceUnavailable, 2, true},
+		{http.StatusServiceUnavailable, 3, false},
+	} {
+		res := p.shouldRetry(&http.Response{StatusCode: c.status}, c.attempts)
+		assert.Equal(t, c.expected, res, fmt.Sprintf("%d after %d attempts", c.status, c.attempts))
+	}
+	assert.False(t, p.shouldRetry(nil, 1))
+}
+
+func TestRetryPolicyDelay(t *testing.T) {
+	withRetryAfter := &http.Response{Header: http.Header{"Retry-After": {"5"}}}
+	withoutRetryAfter := &http.Response{Header: http.Header{}}
+
+	p := newRetryPolicy(&types.DockerRetryPolicy{MaxDelay: 10 * time.Second})
+	assert.Equal(t, 2*time.Second, p.delay(withoutRetryAfter, 2*time.Second))
+	assert.Equal(t, 10*time.Second, p.delay(withoutRetryAfter, 20*time.Second))
+	assert.Equal(t, 5*time.Second, p.delay(withRetryAfter, 2*time.Second))
+
+	p = newRetryPolicy(&types.DockerRetryPolicy{MaxDelay: 3 * time.Second})
+	assert.Equal(t, 3*time.Second, p.delay(withRetryAfter, 2*time.Second))
+
+	p = newRetryPolicy(&types.DockerRetryPolicy{IgnoreRetryAfter: true})
+	assert.Equal(t, 2*time.Second, p.delay(withRetryAfter, 2*time.Second))
+
+	p = newRetryPolicy(&types.DockerRetryPolicy{Jitter: 0.5})
+	for i := 0; i < 100; i++ {
+		delay := p.delay(withoutRetryAfter, 2*time.Second)
+		assert.True(t, delay >= time.Second && delay <= 2*time.Second, delay)
+	}
+}
+
+func TestRewindableStream(t *testing.T) {
+	rewind, ok := rewindableStream(nil)
+	require.True(t, ok)
+	assert.NoError(t, rewind())
+
+	_, ok = rewindableStream(io.MultiReader(strings.NewReader("contents")))
+	assert.False(t, ok)
+
+	stream := bytes.NewReader([]byte("prefix:contents"))
+	_, err := stream.Seek(int64(len("prefix:")), io.SeekStart)
+	require.NoError(t, err)
+	rewind, ok = rewindableStream(stream)
+	require.True(t, ok)
+	data, err := io.ReadAll(stream)
+	require.NoError(t, err)
+	assert.Equal(t, "contents", string(data))
+	err = rewind()
+	require.NoError(t, err)
+	data, err = io.ReadAll(stream)
+	require.NoError(t, err)
+	assert.Equal(t, "contents", string(data))
+}
+
+func TestMakeRequestRetryPolicy(t *testing.T) {
+	var failures, requests int
+	var lastBody string
+	var s *httptest.Server
+	s = httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		switch r.URL.Path {
+		case "/v2/":
+			w.Header().Set("WWW-Authenticate", fmt.Sprintf(`Bearer realm="%s/token",service="test-registry"`, s.URL))
+			w.WriteHeader(http.StatusUnauthorized)
+			return
+		case "/token":
+			requests++
+			if failures > 0 {
+				failures--
+				w.WriteHeader(http.StatusBadGateway)
+				return
+			}
+			fmt.Fprint(w, `{"token":"token","expires_in":300}`)
+			return
+		}
+		requests++
+		body, err := io.ReadAll(r.Body)
+		require.NoError(t, err)
+		lastBody = string(body)
+		if failures > 0 {
+			failures--
+			w.WriteHeader(http.StatusServiceUnavailable)
+			return
+		}
+		w.WriteHeader(http.StatusCreated)
+	}))
+	defer s.Close()
+	registry := strings.TrimPrefix(s.URL, "http://")
+
+	newClient := func(policy *types.DockerRetryPolicy) *dockerClient {
+		// For this test against localhost, we don't care.
+		sys := &types.SystemContext{
+			DockerInsecureSkipTLSVerify: types.OptionalBoolTrue,
+			DockerDisableV1Ping:         true,
+			DockerSharedTokenCache:      types.OptionalBoolFalse,
+			DockerRetryPolicy:           policy,
+		}
+		c, err := newDockerClient(sys, registry, "")
+		require.NoError(t, err)
+		return c
+	}
+	policy := &types.DockerRetryPolicy{MaxAttempts: 3, InitialDelay: time.Millisecond}
+
+	for _, c := range []struct {
+		policy           *types.DockerRetryPolicy
+		stream           func() io.Reader
+		failures         int
+		expectedCode     int
+		expectedRequests int
+	}{
+		// 503 is not retried by default
+		{nil, func() io.Reader { return nil }, 1, http.StatusServiceUnavailable, 1},
+		{policy, func() io.Reader { return nil }, 1, http.StatusCreated, 2},
+		{policy, func() io.Reader { return nil }, 3, http.StatusServiceUnavailable, 3},
+		// Rewindable streams are retried
+		{policy, func() io.Reader { return bytes.NewReader([]byte("manifest")) }, 2, http.StatusCreated, 3},
+		// Other streams are not
+		{policy, func() io.Reader { return io.MultiReader(strings.NewReader("manifest")) }, 1, http.StatusServiceUnavailable, 1},
+	} {
+		failures, requests, lastBody = c.failures, 0, ""
+		client := newClient(c.policy)
+		stream := c.stream()
+		res, err := client.makeRequest(context.Background(), http.MethodPut, "/v2/repo/manifests/tag", nil, stream, noAuth, nil)
+		require.NoError(t, err)
+		res.Body.Close()
+		assert.Equal(t, c.expectedCode, res.StatusCode)
+		assert.Equal(t, c.expectedRequests, requests)
+		if stream != nil {
+			assert.Equal(t, "manifest", lastBody)
+		}
+	}
+
+	// Token fetches
+	for _, c := range []struct {
+		policy           *types.DockerRetryPolicy
+		expectedSuccess  bool
+		expectedRequests int
+	}{
+		{nil, false, 1},
+		{policy, true, 2},
+	} {
+		failures, requests = 1, 0
+		client := newClient(c.policy)
+		client.scope = authScope{remoteName: "repo", actions: "pull"}
+		res, err := client.makeRequest(context.Background(), http.MethodGet, "/v2/repo/tags/list", nil, nil, v2Auth, nil)
+		if c.expectedSuccess {
+			require.NoError(t, err)
+			res.Body.Close()
+			assert.Equal(t, http.StatusCreated, res.StatusCode)
+			assert.Equal(t, c.expectedRequests+1, requests) // Including the request to /v2/repo/tags/list
+		} else {
+			assert.Error(t, err)
+			assert.Equal(t, c.expectedRequests, requests)
+		}
+	}
+}
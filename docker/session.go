@@ -0,0 +1,137 @@
+package docker
+
+import (
+	"crypto/tls"
+	"net/http"
+	"sync"
+	"time"
+
+	"github.com/containers/image/v5/pkg/tlsclientconfig"
+	"github.com/containers/image/v5/types"
+	"github.com/pkg/errors"
+)
+
+const (
+	// sessionMaxIdleConnsPerHost is the number of idle connections to a single registry kept open by a Session.
+	sessionMaxIdleConnsPerHost = 16
+	// sessionIdleConnTimeout is the time after which an idle connection kept open by a Session is closed.
+	sessionIdleConnTimeout = 90 * time.Second
+)
+
+// Session holds state shared by docker transport operations (image sources, destinations, and other registry
+// accesses) which use it via types.SystemContext.DockerSession, instead of constructing it for every operation:
+//   - HTTP transports, which keep connections to registries alive and reuse them,
+//   - the properties of registries detected by pinging them,
+//   - the observed health of registry mirrors,
+//   - bearer tokens.
+//
+// A Session is safe for concurrent use.  It is intended for long-running processes performing many operations;
+// it should be closed using Close when it is no longer needed.
+type Session struct {
+	tokens *tokenCache
+
+	lock       sync.Mutex
+	closed     bool
+	transports map[sessionTransportKey]*http.Transport
+	registries map[sessionRegistryKey]registryProperties
+	mirrors    *mirrorHealthTracker
+}
+
+// sessionTransportKey identifies the inputs used to configure a HTTP transport for a registry.
+type sessionTransportKey struct {
+	registry           string
+	insecureSkipVerify bool
+	certDir            string
+	clientCertPath     string
+	clientKeyPath      string
+	srvLookupHost      string // The host to resolve using SRV records, or "" if SRV records are not used.
+}
+
+// sessionRegistryKey identifies the inputs used to detect registry properties.
+type sessionRegistryKey struct {
+	registry           string
+	insecureSkipVerify bool
+}
+
+// registryProperties are the properties of a registry detected by dockerClient.detectProperties.
+type registryProperties struct {
+	scheme             string
+	challenges         []challenge
+	supportsSignatures bool
+}
+
+// NewSession returns a new Session, to be used in types.SystemContext.DockerSession.
+func NewSession() *Session {
+	return &Session{
+		tokens:     &tokenCache{tokens: map[string]bearerToken{}},
+		transports: map[sessionTransportKey]*http.Transport{},
+		registries: map[sessionRegistryKey]registryProperties{},
+		mirrors:    newMirrorHealthTracker(),
+	}
+}
+
+// Close releases resources held by the session, i.e. closes idle connections.
+// The session must not be used for new operations after calling Close.
+func (s *Session) Close() error {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+	s.closed = true
+	for _, tr := range s.transports {
+		tr.CloseIdleConnections()
+	}
+	s.transports = map[sessionTransportKey]*http.Transport{}
+	s.registries = map[sessionRegistryKey]registryProperties{}
+	s.tokens.clear()
+	return nil
+}
+
+// sessionFromSystemContext returns the Session configured in sys, if any.
+func sessionFromSystemContext(sys *types.SystemContext) (*Session, error) {
+	if sys == nil || sys.DockerSession == nil {
+		return nil, nil
+	}
+	s, ok := sys.DockerSession.(*Session)
+	if !ok {
+		return nil, errors.Errorf("SystemContext.DockerSession of type %T was not created by docker.NewSession", sys.DockerSession)
+	}
+	return s, nil
+}
+
+// transport returns a HTTP transport for key, creating it using tlsClientConfig if it does not exist yet.
+func (s *Session) transport(key sessionTransportKey, tlsClientConfig *tls.Config) (*http.Transport, error) {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+	if s.closed {
+		return nil, errors.New("the docker transport session has been closed")
+	}
+	if tr, ok := s.transports[key]; ok {
+		return tr, nil
+	}
+	tr := tlsclientconfig.NewTransport()
+	tr.TLSClientConfig = tlsClientConfig
+	tr.DisableKeepAlives = false
+	tr.MaxIdleConnsPerHost = sessionMaxIdleConnsPerHost
+	tr.IdleConnTimeout = sessionIdleConnTimeout
+	if key.srvLookupHost != "" {
+		useSRVLookup(tr, key.srvLookupHost)
+	}
+	s.transports[key] = tr
+	return tr, nil
+}
+
+// registryProperties returns the properties of a registry identified by key, if they have been detected.
+func (s *Session) registryProperties(key sessionRegistryKey) (registryProperties, bool) {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+	props, ok := s.registries[key]
+	return props, ok
+}
+
+// setRegistryProperties records the properties of a registry identified by key.
+func (s *Session) setRegistryProperties(key sessionRegistryKey, props registryProperties) {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+	if !s.closed {
+		s.registries[key] = props
+	}
+}
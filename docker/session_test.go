@@ -0,0 +1,95 @@
+package docker
+
+import (
+	"context"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync"
+	"testing"
+
+	"github.com/containers/image/v5/types"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// fakeDockerSession is a types.DockerSession not created by NewSession.
+type fakeDockerSession struct{}
+
+func (fakeDockerSession) Close() error {
+	return nil
+}
+
+func TestSession(t *testing.T) {
+	var (
+		lock        sync.Mutex
+		pings       int
+		connections int
+	)
+	s := httptest.NewUnstartedServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		switch r.URL.Path {
+		case "/v2/":
+			lock.Lock()
+			pings++
+			lock.Unlock()
+			w.WriteHeader(http.StatusOK)
+		case "/v2/repo/tags/list":
+			_, _ = w.Write([]byte(`{"name":"repo","tags":["tag"]}`))
+		default:
+			w.WriteHeader(http.StatusNotFound)
+		}
+	}))
+	s.Config.ConnState = func(_ net.Conn, state http.ConnState) {
+		if state == http.StateNew {
+			lock.Lock()
+			connections++
+			lock.Unlock()
+		}
+	}
+	s.Start()
+	defer s.Close()
+	ref, err := ParseReference("//" + strings.TrimPrefix(s.URL, "http://") + "/repo:tag")
+	require.NoError(t, err)
+
+	listTags := func(sys *types.SystemContext, count int) {
+		for i := 0; i < count; i++ {
+			tags, err := GetRepositoryTags(context.Background(), sys, ref)
+			require.NoError(t, err)
+			assert.Equal(t, []string{"tag"}, tags)
+		}
+	}
+
+	// Without a session, every operation pings the registry, using new connections.
+	sys := &types.SystemContext{DockerInsecureSkipTLSVerify: types.OptionalBoolTrue}
+	listTags(sys, 3)
+	assert.Equal(t, 3, pings)
+	assert.Equal(t, 9, connections) // A failed HTTPS attempt, the ping, and the request, for every operation
+
+	// With a session, the registry is pinged once, and the connection is reused.
+	session := NewSession()
+	pings, connections = 0, 0
+	sys = &types.SystemContext{DockerInsecureSkipTLSVerify: types.OptionalBoolTrue, DockerSession: session}
+	listTags(sys, 3)
+	assert.Equal(t, 1, pings)
+	assert.Equal(t, 2, connections)
+
+	// A different TLS configuration uses a different transport, but registry properties are shared.
+	client, err := newDockerClientFromRef(context.Background(), &types.SystemContext{DockerInsecureSkipTLSVerify: types.OptionalBoolTrue, DockerSession: session,
+		DockerCertPath: t.TempDir()}, ref.(dockerReference), false, "pull")
+	require.NoError(t, err)
+	err = client.detectProperties(context.Background())
+	require.NoError(t, err)
+	assert.Equal(t, 1, pings)
+	assert.Len(t, session.transports, 2)
+
+	// A closed session can't be used.
+	err = session.Close()
+	require.NoError(t, err)
+	_, err = GetRepositoryTags(context.Background(), sys, ref)
+	assert.Error(t, err)
+
+	// Sessions must be created by NewSession.
+	_, err = GetRepositoryTags(context.Background(), &types.SystemContext{DockerSession: fakeDockerSession{}}, ref)
+	assert.Error(t, err)
+}
@@ -0,0 +1,154 @@
+package docker
+
+import (
+	"context"
+	"errors"
+	"math/rand"
+	"net"
+	"net/http"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+
+	"github.com/containers/image/v5/types"
+	"github.com/sirupsen/logrus"
+)
+
+// srvService and srvProto identify the DNS SRV records used to resolve registry endpoints, i.e. _oci._tcp.<host>.
+const (
+	srvService = "oci"
+	srvProto   = "tcp"
+)
+
+// lookupSRV is net.DefaultResolver.LookupSRV; it is a variable so that tests can replace it.
+var lookupSRV = net.DefaultResolver.LookupSRV
+
+// srvLookupHost returns the host name to look up SRV records for when accessing registry (a host[:port] value)
+// using sys, or "" if SRV records should not be used.
+func srvLookupHost(sys *types.SystemContext, registry string) string {
+	if sys == nil || sys.DockerRegistrySRVLookup != types.OptionalBoolTrue {
+		return ""
+	}
+	// An explicit port, or an IP address literal, fully determines the endpoint.
+	if strings.ContainsAny(registry, ":[") || net.ParseIP(registry) != nil {
+		return ""
+	}
+	return registry
+}
+
+// useSRVLookup modifies tr to connect to host using the targets of its SRV records, if any.
+func useSRVLookup(tr *http.Transport, host string) {
+	dial := tr.DialContext
+	if dial == nil {
+		dial = (&net.Dialer{}).DialContext
+	}
+	d := &srvDialer{host: host, dial: dial}
+	tr.DialContext = d.DialContext
+}
+
+// srvDialer connects to host using the targets of its SRV records, if any.
+type srvDialer struct {
+	host string
+	dial func(ctx context.Context, network, addr string) (net.Conn, error)
+
+	lock     sync.Mutex
+	lookedUp bool       // true if records contains the result of a lookup
+	records  []*net.SRV // Usable records of host; empty if there are none
+}
+
+// DialContext connects to addr, using the SRV records of d.host if addr refers to d.host.
+func (d *srvDialer) DialContext(ctx context.Context, network, addr string) (net.Conn, error) {
+	host, _, err := net.SplitHostPort(addr)
+	if err != nil || host != d.host { // e.g. a proxy
+		return d.dial(ctx, network, addr)
+	}
+	records := d.lookup(ctx)
+	if len(records) == 0 {
+		return d.dial(ctx, network, addr)
+	}
+	var lastErr error
+	for _, record := range orderSRVRecords(records, rand.Intn) {
+		target := net.JoinHostPort(strings.TrimSuffix(record.Target, "."), strconv.Itoa(int(record.Port)))
+		conn, err := d.dial(ctx, network, target)
+		if err == nil {
+			logrus.Debugf("Connected to %s using SRV target %s", d.host, target)
+			return conn, nil
+		}
+		logrus.Debugf("Error connecting to SRV target %s of %s: %v", target, d.host, err)
+		lastErr = err
+		if ctx.Err() != nil {
+			break
+		}
+	}
+	return nil, lastErr
+}
+
+// lookup returns the usable SRV records of d.host, looking them up if necessary.
+func (d *srvDialer) lookup(ctx context.Context) []*net.SRV {
+	d.lock.Lock()
+	defer d.lock.Unlock()
+	if d.lookedUp {
+		return d.records
+	}
+	_, records, err := lookupSRV(ctx, srvService, srvProto, d.host)
+	if err != nil {
+		var dnsErr *net.DNSError
+		if !errors.As(err, &dnsErr) || !dnsErr.IsNotFound {
+			// Don’t cache transient failures; connect directly this time, and try again next time.
+			logrus.Debugf("Error looking up SRV records of %s: %v", d.host, err)
+			return nil
+		}
+		records = nil
+	}
+	usable := []*net.SRV{}
+	for _, record := range records {
+		if record.Target != "." { // "." means that the service is not available at this target, per RFC 2782.
+			usable = append(usable, record)
+		}
+	}
+	logrus.Debugf("Found %d SRV records for %s", len(usable), d.host)
+	d.records = usable
+	d.lookedUp = true
+	return usable
+}
+
+// orderSRVRecords returns a copy of records in the order in which their targets should be tried, as specified
+// by RFC 2782: by priority, and randomly by weight within the same priority, using intn (e.g. rand.Intn).
+func orderSRVRecords(records []*net.SRV, intn func(n int) int) []*net.SRV {
+	res := make([]*net.SRV, len(records))
+	copy(res, records)
+	sort.SliceStable(res, func(i, j int) bool {
+		return res[i].Priority < res[j].Priority
+	})
+	for start := 0; start < len(res); {
+		end := start + 1
+		for end < len(res) && res[end].Priority == res[start].Priority {
+			end++
+		}
+		shuffleSRVRecordsByWeight(res[start:end], intn)
+		start = end
+	}
+	return res
+}
+
+// shuffleSRVRecordsByWeight reorders records, which all have the same priority, by weighted random selection using intn.
+func shuffleSRVRecordsByWeight(records []*net.SRV, intn func(n int) int) {
+	sum := 0
+	for _, record := range records {
+		sum += int(record.Weight)
+	}
+	for sum > 0 && len(records) > 1 {
+		n := intn(sum)
+		running := 0
+		for i := range records {
+			running += int(records[i].Weight)
+			if running > n {
+				records[0], records[i] = records[i], records[0]
+				break
+			}
+		}
+		sum -= int(records[0].Weight)
+		records = records[1:]
+	}
+}
@@ -0,0 +1,147 @@
+package docker
+
+import (
+	"context"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"os"
+	"path/filepath"
+	"strconv"
+	"testing"
+
+	"github.com/containers/image/v5/types"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestSRVLookupHost(t *testing.T) {
+	enabled := &types.SystemContext{DockerRegistrySRVLookup: types.OptionalBoolTrue}
+	for _, c := range []struct {
+		sys      *types.SystemContext
+		registry string
+		expected string
+	}{
+		{nil, "registry.example.com", ""},
+		{&types.SystemContext{}, "registry.example.com", ""},
+		{&types.SystemContext{DockerRegistrySRVLookup: types.OptionalBoolFalse}, "registry.example.com", ""},
+		{enabled, "registry.example.com", "registry.example.com"},
+		{enabled, "registry.example.com:5000", ""},
+		{enabled, "192.0.2.1", ""},
+		{enabled, "[2001:db8::1]", ""},
+		{enabled, "[fe80::1%eth0]:5000", ""},
+	} {
+		assert.Equal(t, c.expected, srvLookupHost(c.sys, c.registry), c.registry)
+	}
+}
+
+func TestOrderSRVRecords(t *testing.T) {
+	records := []*net.SRV{
+		{Target: "c.", Priority: 20, Weight: 0},
+		{Target: "a1.", Priority: 10, Weight: 10},
+		{Target: "a2.", Priority: 10, Weight: 30},
+		{Target: "b.", Priority: 15, Weight: 5},
+		{Target: "a3.", Priority: 10, Weight: 0},
+	}
+	targets := func(records []*net.SRV) []string {
+		res := []string{}
+		for _, r := range records {
+			res = append(res, r.Target)
+		}
+		return res
+	}
+
+	// Always choosing the first record with a positive cumulative weight
+	res := orderSRVRecords(records, func(n int) int { return 0 })
+	assert.Equal(t, []string{"a1.", "a2.", "a3.", "b.", "c."}, targets(res))
+	// Always choosing the last record with a positive cumulative weight
+	res = orderSRVRecords(records, func(n int) int { return n - 1 })
+	assert.Equal(t, []string{"a2.", "a1.", "a3.", "b.", "c."}, targets(res))
+	// The input is not modified
+	assert.Equal(t, []string{"c.", "a1.", "a2.", "b.", "a3."}, targets(records))
+}
+
+func TestSRVDialer(t *testing.T) {
+	origLookupSRV := lookupSRV
+	defer func() { lookupSRV = origLookupSRV }()
+
+	server := httptest.NewTLSServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusOK)
+	}))
+	defer server.Close()
+	serverURL, err := url.Parse(server.URL)
+	require.NoError(t, err)
+	serverPort, err := strconv.Atoi(serverURL.Port())
+	require.NoError(t, err)
+	// A port nothing is listening on
+	l, err := net.Listen("tcp", "127.0.0.1:0")
+	require.NoError(t, err)
+	closedPort := l.Addr().(*net.TCPAddr).Port
+	l.Close()
+
+	lookups := 0
+	lookupSRV = func(ctx context.Context, service, proto, name string) (string, []*net.SRV, error) {
+		lookups++
+		assert.Equal(t, "oci", service)
+		assert.Equal(t, "tcp", proto)
+		assert.Equal(t, "registry.example", name)
+		return "_oci._tcp.registry.example.", []*net.SRV{
+			{Target: ".", Port: 1, Priority: 0},
+			{Target: "127.0.0.1.", Port: uint16(closedPort), Priority: 1},
+			{Target: "127.0.0.1.", Port: uint16(serverPort), Priority: 2},
+		}, nil
+	}
+
+	registriesConf := filepath.Join(t.TempDir(), "registries.conf")
+	err = os.WriteFile(registriesConf, []byte{}, 0600)
+	require.NoError(t, err)
+	sys := &types.SystemContext{
+		SystemRegistriesConfPath:    registriesConf,
+		DockerInsecureSkipTLSVerify: types.OptionalBoolTrue,
+		DockerDisableV1Ping:         true,
+		DockerRegistrySRVLookup:     types.OptionalBoolTrue,
+	}
+	for i := 0; i < 2; i++ {
+		c, err := newDockerClient(sys, "registry.example", "registry.example")
+		require.NoError(t, err)
+		res, err := c.makeRequest(context.Background(), http.MethodGet, "/v2/", nil, nil, noAuth, nil)
+		require.NoError(t, err)
+		res.Body.Close()
+		assert.Equal(t, http.StatusOK, res.StatusCode)
+		assert.Equal(t, "registry.example", res.Request.URL.Host)
+	}
+	assert.Equal(t, 2, lookups) // Once per dockerClient
+
+	// Without SRV lookup, the host name is resolved directly
+	sys.DockerRegistrySRVLookup = types.OptionalBoolUndefined
+	c, err := newDockerClient(sys, "registry.example", "registry.example")
+	require.NoError(t, err)
+	_, err = c.makeRequest(context.Background(), http.MethodGet, "/v2/", nil, nil, noAuth, nil)
+	assert.Error(t, err)
+	assert.Equal(t, 2, lookups)
+}
+
+func TestSRVDialerNotFound(t *testing.T) {
+	origLookupSRV := lookupSRV
+	defer func() { lookupSRV = origLookupSRV }()
+
+	lookups := 0
+	lookupSRV = func(ctx context.Context, service, proto, name string) (string, []*net.SRV, error) {
+		lookups++
+		return "", nil, &net.DNSError{Err: "no such host", Name: name, IsNotFound: true}
+	}
+	dialed := []string{}
+	d := &srvDialer{host: "registry.example", dial: func(ctx context.Context, network, addr string) (net.Conn, error) {
+		dialed = append(dialed, addr)
+		return nil, &net.OpError{Op: "dial"}
+	}}
+	for i := 0; i < 2; i++ {
+		_, err := d.DialContext(context.Background(), "tcp", "registry.example:443")
+		assert.Error(t, err)
+	}
+	_, err := d.DialContext(context.Background(), "tcp", "proxy.example:3128")
+	assert.Error(t, err)
+	assert.Equal(t, []string{"registry.example:443", "registry.example:443", "proxy.example:3128"}, dialed)
+	assert.Equal(t, 1, lookups) // The negative result is cached
+}
@@ -0,0 +1,195 @@
+package docker
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/url"
+	"regexp"
+	"strconv"
+	"strings"
+	"sync"
+
+	"github.com/containers/image/v5/docker/reference"
+	"github.com/containers/image/v5/internal/iolimits"
+	"github.com/containers/image/v5/types"
+	"github.com/opencontainers/go-digest"
+	"github.com/pkg/errors"
+)
+
+// tagDigestResolutionConcurrency is the maximum number of concurrent requests made by ListRepositoryTags
+// to resolve tags to digests.
+const tagDigestResolutionConcurrency = 6
+
+// ListTagsOptions controls ListRepositoryTags.
+type ListTagsOptions struct {
+	// PageSize, if positive, is the number of tags requested from the registry in a single request
+	// (the "n" parameter of the tags list API); registries may return fewer tags, or ignore the value.
+	PageSize int
+	// Last, if not "", only lists tags which follow Last in the registry’s order, using the "last" parameter of
+	// the tags list API.  Use RepositoryTagList.Next to continue an incremental listing.
+	Last string
+	// Limit, if positive, is the maximum number of tags returned; if more tags are available, RepositoryTagList.Next
+	// is set.
+	Limit int
+	// Filter, if not nil, restricts the returned tags to those matching it.
+	// The filter is applied locally; all tags are still read from the registry.
+	Filter *regexp.Regexp
+	// ResolveDigests, if true, also resolves every returned tag to the digest of its manifest, using HEAD requests.
+	ResolveDigests bool
+}
+
+// RepositoryTag is a tag returned by ListRepositoryTags.
+type RepositoryTag struct {
+	Name string
+	// Digest is the digest of the manifest the tag refers to; only set if ListTagsOptions.ResolveDigests.
+	Digest digest.Digest
+}
+
+// RepositoryTagList is the result of ListRepositoryTags.
+type RepositoryTagList struct {
+	Tags []RepositoryTag
+	// Next, if not "", indicates that ListTagsOptions.Limit was reached and more tags may be available;
+	// set ListTagsOptions.Last to this value to continue the listing.
+	Next string
+}
+
+// ListRepositoryTags lists tags available in the repository, as controlled by options (which may be nil).
+// The tag provided inside the ImageReference will be ignored.
+func ListRepositoryTags(ctx context.Context, sys *types.SystemContext, ref types.ImageReference, options *ListTagsOptions) (*RepositoryTagList, error) {
+	dr, ok := ref.(dockerReference)
+	if !ok {
+		return nil, errors.Errorf("ref must be a dockerReference")
+	}
+	if options == nil {
+		options = &ListTagsOptions{}
+	}
+
+	client, err := newDockerClientFromRef(ctx, sys, dr, false, "pull")
+	if err != nil {
+		return nil, errors.Wrap(err, "failed to create client")
+	}
+
+	list, err := client.listTags(ctx, dr.ref, options)
+	if err != nil {
+		return nil, err
+	}
+	if options.ResolveDigests {
+		if err := client.resolveTagDigests(ctx, dr.ref, list.Tags); err != nil {
+			return nil, err
+		}
+	}
+	return list, nil
+}
+
+// listTags implements ListRepositoryTags, except for options.ResolveDigests.
+func (c *dockerClient) listTags(ctx context.Context, repo reference.Named, options *ListTagsOptions) (*RepositoryTagList, error) {
+	path := fmt.Sprintf(tagsPath, reference.Path(repo))
+	query := url.Values{}
+	if options.PageSize > 0 {
+		query.Set("n", strconv.Itoa(options.PageSize))
+	}
+	if options.Last != "" {
+		query.Set("last", options.Last)
+	}
+	if len(query) != 0 {
+		path += "?" + query.Encode()
+	}
+
+	list := &RepositoryTagList{Tags: []RepositoryTag{}}
+	for {
+		res, err := c.makeRequest(ctx, http.MethodGet, path, nil, nil, v2Auth, nil)
+		if err != nil {
+			return nil, err
+		}
+		defer res.Body.Close()
+		if err := httpResponseToError(res, "fetching tags list"); err != nil {
+			return nil, err
+		}
+
+		body, err := iolimits.ReadAtMost(res.Body, iolimits.MaxListPageBodySize)
+		if err != nil {
+			return nil, err
+		}
+		var tagsHolder struct {
+			Tags []string
+		}
+		if err = json.Unmarshal(body, &tagsHolder); err != nil {
+			return nil, err
+		}
+		link := res.Header.Get("Link")
+
+		for i, tag := range tagsHolder.Tags {
+			if options.Filter != nil && !options.Filter.MatchString(tag) {
+				continue
+			}
+			list.Tags = append(list.Tags, RepositoryTag{Name: tag})
+			if options.Limit > 0 && len(list.Tags) == options.Limit {
+				if i != len(tagsHolder.Tags)-1 || link != "" {
+					list.Next = tag
+				}
+				return list, nil
+			}
+		}
+
+		if link == "" {
+			break
+		}
+		linkURLStr := strings.Trim(strings.Split(link, ";")[0], "<>")
+		linkURL, err := url.Parse(linkURLStr)
+		if err != nil {
+			return nil, err
+		}
+
+		// can be relative or absolute, but we only want the path (and I
+		// guess we're in trouble if it forwards to a new place...)
+		path = linkURL.Path
+		if linkURL.RawQuery != "" {
+			path += "?"
+			path += linkURL.RawQuery
+		}
+	}
+	return list, nil
+}
+
+// resolveTagDigests sets the Digest field of tags in repo.
+func (c *dockerClient) resolveTagDigests(ctx context.Context, repo reference.Named, tags []RepositoryTag) error {
+	ctx, cancel := context.WithCancel(ctx)
+	defer cancel()
+
+	var (
+		wg       sync.WaitGroup
+		errLock  sync.Mutex
+		firstErr error
+	)
+	sem := make(chan struct{}, tagDigestResolutionConcurrency)
+	for i := range tags {
+		sem <- struct{}{}
+		errLock.Lock()
+		failed := firstErr != nil
+		errLock.Unlock()
+		if failed {
+			<-sem
+			break
+		}
+		wg.Add(1)
+		go func(tag *RepositoryTag) {
+			defer wg.Done()
+			defer func() { <-sem }()
+			d, err := c.getManifestDigest(ctx, repo, tag.Name)
+			if err != nil {
+				errLock.Lock()
+				if firstErr == nil {
+					firstErr = errors.Wrapf(err, "resolving tag %s", tag.Name)
+					cancel()
+				}
+				errLock.Unlock()
+				return
+			}
+			tag.Digest = d
+		}(&tags[i])
+	}
+	wg.Wait()
+	return firstErr
+}
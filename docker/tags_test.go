@@ -0,0 +1,172 @@
+package docker
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"regexp"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"testing"
+
+	"github.com/containers/image/v5/types"
+	"github.com/opencontainers/go-digest"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// tagsTestRegistry serves a paginated tags list and manifest digests for tags.
+type tagsTestRegistry struct {
+	tags        []string // Sorted
+	defaultSize int
+	lock        sync.Mutex
+	listPages   int
+	heads       int
+}
+
+func (r *tagsTestRegistry) ServeHTTP(w http.ResponseWriter, req *http.Request) {
+	switch {
+	case req.URL.Path == "/v2/":
+		w.WriteHeader(http.StatusOK)
+	case req.URL.Path == "/v2/repo/tags/list":
+		r.lock.Lock()
+		r.listPages++
+		r.lock.Unlock()
+		n := r.defaultSize
+		if v := req.URL.Query().Get("n"); v != "" {
+			var err error
+			if n, err = strconv.Atoi(v); err != nil {
+				w.WriteHeader(http.StatusBadRequest)
+				return
+			}
+		}
+		start := 0
+		if last := req.URL.Query().Get("last"); last != "" {
+			start = sort.SearchStrings(r.tags, last)
+			if start < len(r.tags) && r.tags[start] == last {
+				start++
+			}
+		}
+		end := start + n
+		if end >= len(r.tags) {
+			end = len(r.tags)
+		} else {
+			w.Header().Set("Link", fmt.Sprintf(`</v2/repo/tags/list?%s>; rel="next"`,
+				url.Values{"n": {strconv.Itoa(n)}, "last": {r.tags[end-1]}}.Encode()))
+		}
+		body, _ := json.Marshal(map[string]interface{}{"name": "repo", "tags": r.tags[start:end]})
+		_, _ = w.Write(body)
+	case req.Method == http.MethodHead && strings.HasPrefix(req.URL.Path, "/v2/repo/manifests/"):
+		r.lock.Lock()
+		r.heads++
+		r.lock.Unlock()
+		tag := strings.TrimPrefix(req.URL.Path, "/v2/repo/manifests/")
+		i := sort.SearchStrings(r.tags, tag)
+		if i == len(r.tags) || r.tags[i] != tag {
+			w.WriteHeader(http.StatusNotFound)
+			return
+		}
+		w.Header().Set("Docker-Content-Digest", digest.FromString(tag).String())
+		w.WriteHeader(http.StatusOK)
+	default:
+		w.WriteHeader(http.StatusNotFound)
+	}
+}
+
+func TestListRepositoryTags(t *testing.T) {
+	r := &tagsTestRegistry{defaultSize: 3}
+	for i := 0; i < 10; i++ {
+		r.tags = append(r.tags, fmt.Sprintf("v%d", i))
+	}
+	r.tags = append(r.tags, "latest")
+	sort.Strings(r.tags) // latest, v0, …, v9
+	s := httptest.NewServer(r)
+	defer s.Close()
+	ref, err := ParseReference("//" + strings.TrimPrefix(s.URL, "http://") + "/repo:tag")
+	require.NoError(t, err)
+	sys := &types.SystemContext{DockerInsecureSkipTLSVerify: types.OptionalBoolTrue}
+
+	names := func(list *RepositoryTagList) []string {
+		res := []string{}
+		for _, tag := range list.Tags {
+			res = append(res, tag.Name)
+		}
+		return res
+	}
+
+	for _, c := range []struct {
+		options       *ListTagsOptions
+		expected      []string
+		expectedNext  string
+		expectedPages int
+	}{
+		{nil, r.tags, "", 4},
+		{&ListTagsOptions{PageSize: 5}, r.tags, "", 3},
+		{&ListTagsOptions{PageSize: 100}, r.tags, "", 1},
+		{&ListTagsOptions{Last: "v5"}, []string{"v6", "v7", "v8", "v9"}, "", 2},
+		{&ListTagsOptions{Limit: 4}, []string{"latest", "v0", "v1", "v2"}, "v2", 2},
+		{&ListTagsOptions{Limit: 3}, []string{"latest", "v0", "v1"}, "v1", 1},
+		{&ListTagsOptions{Last: "v1", Limit: 4}, []string{"v2", "v3", "v4", "v5"}, "v5", 2},
+		{&ListTagsOptions{Last: "v7", Limit: 2}, []string{"v8", "v9"}, "", 1},
+		{&ListTagsOptions{Filter: regexp.MustCompile(`^v[2468]$`)}, []string{"v2", "v4", "v6", "v8"}, "", 4},
+		{&ListTagsOptions{Filter: regexp.MustCompile(`^v[2468]$`), Limit: 2}, []string{"v2", "v4"}, "v4", 2},
+	} {
+		r.listPages = 0
+		list, err := ListRepositoryTags(context.Background(), sys, ref, c.options)
+		require.NoError(t, err, "%#v", c.options)
+		assert.Equal(t, c.expected, names(list), "%#v", c.options)
+		assert.Equal(t, c.expectedNext, list.Next, "%#v", c.options)
+		assert.Equal(t, c.expectedPages, r.listPages, "%#v", c.options)
+		for _, tag := range list.Tags {
+			assert.Equal(t, digest.Digest(""), tag.Digest)
+		}
+	}
+
+	// Incremental listing
+	all := []string{}
+	options := &ListTagsOptions{Limit: 4}
+	for {
+		list, err := ListRepositoryTags(context.Background(), sys, ref, options)
+		require.NoError(t, err)
+		all = append(all, names(list)...)
+		if list.Next == "" {
+			break
+		}
+		options.Last = list.Next
+	}
+	assert.Equal(t, r.tags, all)
+
+	// Digest resolution
+	r.heads = 0
+	list, err := ListRepositoryTags(context.Background(), sys, ref, &ListTagsOptions{ResolveDigests: true})
+	require.NoError(t, err)
+	assert.Equal(t, len(r.tags), r.heads)
+	require.Len(t, list.Tags, len(r.tags))
+	for _, tag := range list.Tags {
+		assert.Equal(t, digest.FromString(tag.Name), tag.Digest, tag.Name)
+	}
+
+	// GetRepositoryTags
+	tags, err := GetRepositoryTags(context.Background(), sys, ref)
+	require.NoError(t, err)
+	assert.Equal(t, r.tags, tags)
+}
+
+func TestResolveTagDigestsError(t *testing.T) {
+	r := &tagsTestRegistry{defaultSize: 100, tags: []string{"a", "b", "c"}}
+	s := httptest.NewServer(r)
+	defer s.Close()
+	ref, err := ParseReference("//" + strings.TrimPrefix(s.URL, "http://") + "/repo:tag")
+	require.NoError(t, err)
+	client, err := newDockerClientFromRef(context.Background(), &types.SystemContext{DockerInsecureSkipTLSVerify: types.OptionalBoolTrue}, ref.(dockerReference), false, "pull")
+	require.NoError(t, err)
+
+	tags := []RepositoryTag{{Name: "a"}, {Name: "missing"}, {Name: "c"}}
+	err = client.resolveTagDigests(context.Background(), ref.(dockerReference).ref, tags)
+	assert.ErrorContains(t, err, "resolving tag missing")
+}
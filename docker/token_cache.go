@@ -0,0 +1,363 @@
+package docker
+
+import (
+	"context"
+	"crypto/hmac"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/containers/image/v5/docker/reference"
+	"github.com/containers/image/v5/internal/tracing"
+	"github.com/containers/image/v5/internal/userdirs"
+	"github.com/containers/image/v5/types"
+	"github.com/containers/storage/pkg/ioutils"
+	"github.com/pkg/errors"
+	"github.com/sirupsen/logrus"
+	"go.opentelemetry.io/otel/attribute"
+)
+
+// tokenExpirationMargin is subtracted from the expiration time of tokens in the shared cache, so that
+// a token obtained from the cache does not expire while it is being used.
+const tokenExpirationMargin = 10 * time.Second
+
+// tokenCacheSecretSize is the size of the secrets used to derive cache keys from credentials.
+const tokenCacheSecretSize = 32
+
+// tokenCacheDirSecretFile is the name of a file in DockerTokenCacheDir containing a random secret, used to derive
+// the names of files containing cached tokens from credentials without allowing offline guessing of the credentials.
+const tokenCacheDirSecretFile = "secret"
+
+// tokenCacheRuntimeSubdir is the subdirectory of the runtime directory in which tokens are cached
+// if types.SystemContext.DockerTokenCacheInRuntimeDir.
+var tokenCacheRuntimeSubdir = filepath.FromSlash("containers/tokens")
+
+// sharedTokens is the process-wide cache of bearer tokens, shared by all dockerClient instances which opt in.
+var sharedTokens = &tokenCache{tokens: map[string]bearerToken{}}
+
+var (
+	processTokenCacheSecretOnce sync.Once
+	processTokenCacheSecretData []byte // nil if a secret could not be generated
+)
+
+// processTokenCacheSecret returns a random secret used to derive keys of the in-memory token caches from credentials,
+// or nil if it could not be generated.
+func processTokenCacheSecret() []byte {
+	processTokenCacheSecretOnce.Do(func() {
+		secret := make([]byte, tokenCacheSecretSize)
+		if _, err := rand.Read(secret); err != nil {
+			logrus.Debugf("Error generating a token cache secret, not sharing tokens: %v", err)
+			return
+		}
+		processTokenCacheSecretData = secret
+	})
+	return processTokenCacheSecretData
+}
+
+// tokenCache is a cache of bearer tokens, keyed by the output of sharedTokenCacheKey.
+type tokenCache struct {
+	mutex  sync.Mutex
+	tokens map[string]bearerToken
+}
+
+// get returns a token for key which does not expire before now, if one is cached.
+func (tc *tokenCache) get(key string, now time.Time) (bearerToken, bool) {
+	tc.mutex.Lock()
+	defer tc.mutex.Unlock()
+	token, ok := tc.tokens[key]
+	if !ok {
+		return bearerToken{}, false
+	}
+	if !now.Before(token.expirationTime.Add(-tokenExpirationMargin)) {
+		delete(tc.tokens, key)
+		return bearerToken{}, false
+	}
+	return token, true
+}
+
+// put stores token for key, and drops expired tokens.
+func (tc *tokenCache) put(key string, token bearerToken, now time.Time) {
+	tc.mutex.Lock()
+	defer tc.mutex.Unlock()
+	for k, t := range tc.tokens {
+		if !now.Before(t.expirationTime.Add(-tokenExpirationMargin)) {
+			delete(tc.tokens, k)
+		}
+	}
+	tc.tokens[key] = token
+}
+
+// clear removes all tokens.
+func (tc *tokenCache) clear() {
+	tc.mutex.Lock()
+	defer tc.mutex.Unlock()
+	tc.tokens = map[string]bearerToken{}
+}
+
+// ClearTokenCache removes all bearer tokens from the process-wide cache shared by docker transport clients.
+// It does not affect tokens cached on disk in types.SystemContext.DockerTokenCacheDir.
+func ClearTokenCache() {
+	sharedTokens.clear()
+}
+
+// useSharedTokenCache returns true if c should use a shared token cache: the one of c.session, unless disabled,
+// or, only if explicitly enabled, the process-wide one.
+func (c *dockerClient) useSharedTokenCache() bool {
+	if c.sys == nil {
+		return false
+	}
+	if c.session != nil {
+		return c.sys.DockerSharedTokenCache != types.OptionalBoolFalse
+	}
+	return c.sys.DockerSharedTokenCache == types.OptionalBoolTrue
+}
+
+// sharedTokenCache returns the token cache c shares with other clients: the one of c.session, if any,
+// or the process-wide one.
+func (c *dockerClient) sharedTokenCache() *tokenCache {
+	if c.session != nil {
+		return c.session.tokens
+	}
+	return sharedTokens
+}
+
+// sharedTokenCacheKey returns a key identifying a token for scopes obtained from the token endpoint
+// in challenge using c’s credentials, for use in a token cache; the credentials are represented using a HMAC with secret.
+func (c *dockerClient) sharedTokenCacheKey(challenge challenge, scopes []authScope, secret []byte) string {
+	scopeStrings := []string{}
+	for _, scope := range scopes {
+		if scope.remoteName != "" && scope.actions != "" {
+			scopeStrings = append(scopeStrings, scope.remoteName+":"+scope.actions)
+		}
+	}
+	sort.Strings(scopeStrings)
+	// Tokens must never be shared between different credentials, but the cache must not contain the credentials
+	// themselves, nor a value which allows guessing them offline (keys are used to name files in DockerTokenCacheDir).
+	mac := hmac.New(sha256.New, secret)
+	mac.Write([]byte(strings.Join([]string{c.auth.Username, c.auth.Password, c.auth.IdentityToken,
+		c.auth.ClientCertPath, c.auth.ClientKeyPath}, "\x00")))
+	return strings.Join([]string{c.registry, challenge.Parameters["realm"], challenge.Parameters["service"],
+		hex.EncodeToString(mac.Sum(nil)), strings.Join(scopeStrings, " ")}, "\x00")
+}
+
+// tokenCacheDirSecret returns the secret used to derive keys of tokens cached in dir, creating it if necessary.
+func tokenCacheDirSecret(dir string) ([]byte, error) {
+	path := filepath.Join(dir, tokenCacheDirSecretFile)
+	secret, err := os.ReadFile(path)
+	if err != nil {
+		if !os.IsNotExist(err) {
+			return nil, err
+		}
+		secret = make([]byte, tokenCacheSecretSize)
+		if _, err := rand.Read(secret); err != nil {
+			return nil, err
+		}
+		if err := os.MkdirAll(dir, 0700); err != nil {
+			return nil, err
+		}
+		if err := ioutils.AtomicWriteFile(path, secret, 0600); err != nil {
+			return nil, err
+		}
+		// Another process might have created a different secret concurrently; use the one which was stored.
+		if secret, err = os.ReadFile(path); err != nil {
+			return nil, err
+		}
+	}
+	if len(secret) != tokenCacheSecretSize {
+		return nil, errors.Errorf("invalid token cache secret %s", path)
+	}
+	return secret, nil
+}
+
+// cachedBearerToken is the on-disk representation of a bearer token in DockerTokenCacheDir.
+type cachedBearerToken struct {
+	Token   string    `json:"token"`
+	Expires time.Time `json:"expires"`
+}
+
+// tokenCacheFilePath returns the path to a file in DockerTokenCacheDir for a token for scopes obtained from the token
+// endpoint in challenge, or "" if tokens should not be cached on disk.
+func (c *dockerClient) tokenCacheFilePath(challenge challenge, scopes []authScope) string {
+	dir := TokenCacheLocation(c.sys).Path
+	if dir == "" {
+		return ""
+	}
+	secret, err := tokenCacheDirSecret(dir)
+	if err != nil {
+		logrus.Debugf("Error reading the token cache secret, not caching tokens on disk: %v", err)
+		return ""
+	}
+	digest := sha256.Sum256([]byte(c.sharedTokenCacheKey(challenge, scopes, secret)))
+	return filepath.Join(dir, hex.EncodeToString(digest[:])+".json")
+}
+
+// TokenCacheLocation returns the directory in which bearer tokens are cached on disk for sys, as configured by
+// types.SystemContext.DockerTokenCacheDir or DockerTokenCacheInRuntimeDir; the Path is "" if tokens are not cached on disk.
+func TokenCacheLocation(sys *types.SystemContext) types.ResolvedLocation {
+	if sys == nil {
+		return types.ResolvedLocation{}
+	}
+	if sys.DockerTokenCacheDir != "" {
+		return types.ResolvedLocation{Path: sys.DockerTokenCacheDir}
+	}
+	if !sys.DockerTokenCacheInRuntimeDir {
+		return types.ResolvedLocation{}
+	}
+	runtimeDir, fallbackReason := userdirs.RuntimeDir(sys.RuntimeDirFallback)
+	if runtimeDir == "" {
+		return types.ResolvedLocation{}
+	}
+	if fallbackReason != "" {
+		logrus.Debugf("Using %q instead of $XDG_RUNTIME_DIR for the token cache: %s", runtimeDir, fallbackReason)
+	}
+	return types.ResolvedLocation{
+		Path:           filepath.Join(runtimeDir, tokenCacheRuntimeSubdir),
+		Fallback:       fallbackReason != "",
+		FallbackReason: fallbackReason,
+	}
+}
+
+// readCachedToken returns a token from path in DockerTokenCacheDir, if it contains a token which does not expire before now.
+func readCachedToken(path string, now time.Time) (bearerToken, bool) {
+	if path == "" {
+		return bearerToken{}, false
+	}
+	data, err := os.ReadFile(path)
+	if err != nil {
+		if !os.IsNotExist(err) {
+			logrus.Debugf("Error reading cached token %s: %v", path, err)
+		}
+		return bearerToken{}, false
+	}
+	var cached cachedBearerToken
+	if err := json.Unmarshal(data, &cached); err != nil {
+		logrus.Debugf("Error parsing cached token %s: %v", path, err)
+		return bearerToken{}, false
+	}
+	if cached.Token == "" || !now.Before(cached.Expires.Add(-tokenExpirationMargin)) {
+		if err := os.Remove(path); err != nil && !os.IsNotExist(err) {
+			logrus.Debugf("Error removing expired cached token %s: %v", path, err)
+		}
+		return bearerToken{}, false
+	}
+	return bearerToken{Token: cached.Token, expirationTime: cached.Expires}, true
+}
+
+// writeCachedToken stores token at path in DockerTokenCacheDir, if not "".  Failures are only logged.
+func writeCachedToken(path string, token bearerToken) {
+	if path == "" {
+		return
+	}
+	data, err := json.Marshal(cachedBearerToken{Token: token.Token, Expires: token.expirationTime})
+	if err != nil {
+		logrus.Debugf("Error marshaling token for the cache: %v", err)
+		return
+	}
+	if err := os.MkdirAll(filepath.Dir(path), 0700); err != nil {
+		logrus.Debugf("Error creating token cache directory: %v", err)
+		return
+	}
+	if err := ioutils.AtomicWriteFile(path, data, 0600); err != nil {
+		logrus.Debugf("Error writing cached token %s: %v", path, err)
+	}
+}
+
+// obtainBearerToken returns a token for scopes from the token endpoint in challenge, using the shared token caches if enabled.
+func (c *dockerClient) obtainBearerToken(ctx context.Context, challenge challenge, scopes []authScope) (bearerToken, error) {
+	var key, path string
+	secret := processTokenCacheSecret()
+	useShared := c.useSharedTokenCache() && secret != nil
+	if useShared {
+		key = c.sharedTokenCacheKey(challenge, scopes, secret)
+		now := time.Now()
+		if token, ok := c.sharedTokenCache().get(key, now); ok {
+			return token, nil
+		}
+		path = c.tokenCacheFilePath(challenge, scopes)
+		if token, ok := readCachedToken(path, now); ok {
+			c.sharedTokenCache().put(key, token, now)
+			return token, nil
+		}
+	}
+
+	var (
+		t   *bearerToken
+		err error
+	)
+	scopeStrings := []string{}
+	for _, scope := range scopes {
+		if scope.remoteName != "" && scope.actions != "" {
+			scopeStrings = append(scopeStrings, scope.String())
+		}
+	}
+	logrus.Debugf("Obtaining a bearer token from %s for scopes %v", challenge.Parameters["realm"], scopeStrings)
+	ctx, span := tracing.Start(ctx, tracing.Provider(ctx), "registry token fetch",
+		attribute.String("registry", c.registry), attribute.String("auth.realm", challenge.Parameters["realm"]),
+		attribute.StringSlice("auth.scopes", scopeStrings))
+	if c.auth.IdentityToken != "" {
+		t, err = c.getBearerTokenOAuth2(ctx, challenge, scopes)
+	} else {
+		t, err = c.getBearerToken(ctx, challenge, scopes)
+	}
+	tracing.End(span, err)
+	if err != nil {
+		return bearerToken{}, err
+	}
+	if useShared {
+		c.sharedTokenCache().put(key, *t, time.Now())
+		writeCachedToken(path, *t)
+	}
+	return *t, nil
+}
+
+// PrefetchBearerToken obtains a bearer token for pulling from (and, if push, pushing to) the repository of ref,
+// and for types.SystemContext.DockerAdditionalTokenScopes,
+// and stores it in the shared token caches (see types.SystemContext.DockerSharedTokenCache, which must enable
+// a shared cache), so that image sources
+// and destinations created later, e.g. by parallel copies, don’t need to contact the token endpoint.
+// It does nothing if the registry does not use bearer tokens.
+func PrefetchBearerToken(ctx context.Context, sys *types.SystemContext, ref types.ImageReference, push bool) error {
+	dr, ok := ref.(dockerReference)
+	if !ok {
+		return errors.Errorf("ref must be a dockerReference")
+	}
+	actions := "pull"
+	if push {
+		actions = "pull,push"
+	}
+	c, err := newDockerClientFromRef(ctx, sys, dr, push, actions)
+	if err != nil {
+		return errors.Wrapf(err, "creating a client for %s", reference.FamiliarString(dr.ref))
+	}
+	if !c.useSharedTokenCache() {
+		return errors.New("prefetching bearer tokens requires a shared token cache (DockerSharedTokenCache or DockerSession)")
+	}
+	if err := c.detectProperties(ctx); err != nil {
+		return err
+	}
+	if c.registryToken != "" {
+		return nil
+	}
+	// Use the same challenge as setupRequestAuth.
+	for _, challenge := range c.challenges {
+		switch challenge.Scheme {
+		case "basic":
+			return nil
+		case "bearer":
+			scopes, _ := c.tokenScopes(nil)
+			if _, err := c.obtainBearerToken(ctx, challenge, scopes); err != nil {
+				return err
+			}
+			c.recordTokenScopes(scopes)
+			return nil
+		}
+	}
+	return nil
+}
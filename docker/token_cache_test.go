@@ -0,0 +1,223 @@
+package docker
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/containers/image/v5/types"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestTokenCache(t *testing.T) {
+	tc := &tokenCache{tokens: map[string]bearerToken{}}
+	now := time.Now()
+	tc.put("valid", bearerToken{Token: "valid", expirationTime: now.Add(time.Hour)}, now)
+	tc.put("expiring", bearerToken{Token: "expiring", expirationTime: now.Add(tokenExpirationMargin / 2)}, now)
+
+	token, ok := tc.get("valid", now)
+	assert.True(t, ok)
+	assert.Equal(t, "valid", token.Token)
+	_, ok = tc.get("expiring", now)
+	assert.False(t, ok)
+	_, ok = tc.get("missing", now)
+	assert.False(t, ok)
+	_, ok = tc.get("valid", now.Add(2*time.Hour))
+	assert.False(t, ok)
+
+	tc.put("valid", bearerToken{Token: "valid", expirationTime: now.Add(time.Hour)}, now)
+	tc.clear()
+	_, ok = tc.get("valid", now)
+	assert.False(t, ok)
+}
+
+func TestSharedTokenCache(t *testing.T) {
+	defer ClearTokenCache()
+
+	var mutex sync.Mutex
+	tokenRequests := 0
+	var s *httptest.Server
+	s = httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		switch {
+		case r.URL.Path == "/token":
+			mutex.Lock()
+			tokenRequests++
+			n := tokenRequests
+			mutex.Unlock()
+			fmt.Fprintf(w, `{"token":"token-%d","expires_in":300}`, n)
+		case strings.HasPrefix(r.Header.Get("Authorization"), "Bearer token-"):
+			w.WriteHeader(http.StatusOK)
+		default:
+			w.Header().Set("WWW-Authenticate", fmt.Sprintf(`Bearer realm="%s/token",service="test-registry"`, s.URL))
+			w.WriteHeader(http.StatusUnauthorized)
+		}
+	}))
+	defer s.Close()
+	registry := strings.TrimPrefix(s.URL, "http://")
+	authFile := filepath.Join(t.TempDir(), "auth.json")
+	err := os.WriteFile(authFile, []byte(`{"auths":{}}`), 0600)
+	require.NoError(t, err)
+
+	request := func(sys *types.SystemContext, auth types.DockerAuthConfig) {
+		c, err := newDockerClient(sys, registry, registry)
+		require.NoError(t, err)
+		c.auth = auth
+		c.scope = authScope{remoteName: "ns/repo", actions: "pull"}
+		res, err := c.makeRequest(context.Background(), http.MethodGet, "/v2/ns/repo/tags/list", nil, nil, v2Auth, nil)
+		require.NoError(t, err)
+		defer res.Body.Close()
+		assert.Equal(t, http.StatusOK, res.StatusCode)
+	}
+	// For this test against localhost, we don't care.
+	sys := &types.SystemContext{
+		DockerInsecureSkipTLSVerify: types.OptionalBoolTrue,
+		DockerDisableV1Ping:         true,
+		AuthFilePath:                authFile,
+		DockerSharedTokenCache:      types.OptionalBoolTrue,
+	}
+	user1 := types.DockerAuthConfig{Username: "user", Password: "pass1"}
+	user2 := types.DockerAuthConfig{Username: "user", Password: "pass2"}
+
+	// Clients with the same credentials share a token
+	request(sys, user1)
+	request(sys, user1)
+	assert.Equal(t, 1, tokenRequests)
+	// … but not with different credentials
+	request(sys, user2)
+	assert.Equal(t, 2, tokenRequests)
+
+	// The shared cache is not used unless enabled
+	noSharing := *sys
+	noSharing.DockerSharedTokenCache = types.OptionalBoolFalse
+	request(&noSharing, user1)
+	assert.Equal(t, 3, tokenRequests)
+	defaultSharing := *sys
+	defaultSharing.DockerSharedTokenCache = types.OptionalBoolUndefined
+	request(&defaultSharing, user1)
+	assert.Equal(t, 4, tokenRequests)
+	request(&defaultSharing, user1)
+	assert.Equal(t, 5, tokenRequests)
+
+	// On-disk cache
+	cacheDir := filepath.Join(t.TempDir(), "tokens")
+	onDisk := *sys
+	onDisk.DockerTokenCacheDir = cacheDir
+	ClearTokenCache()
+	request(&onDisk, user1)
+	assert.Equal(t, 6, tokenRequests)
+	entries, err := os.ReadDir(cacheDir)
+	require.NoError(t, err)
+	assert.Len(t, entries, 2) // The token and the secret
+	ClearTokenCache()
+	request(&onDisk, user1)
+	assert.Equal(t, 6, tokenRequests)
+	request(sys, user1) // From the process-wide cache, populated from disk
+	assert.Equal(t, 6, tokenRequests)
+
+	// Prefetching
+	ClearTokenCache()
+	ref, err := ParseReference("//" + registry + "/ns/repo:latest")
+	require.NoError(t, err)
+	sys.DockerAuthConfig = &user1
+	err = PrefetchBearerToken(context.Background(), sys, ref, false)
+	require.NoError(t, err)
+	assert.Equal(t, 7, tokenRequests)
+	request(sys, user1)
+	assert.Equal(t, 7, tokenRequests)
+	err = PrefetchBearerToken(context.Background(), &noSharing, ref, false)
+	assert.Error(t, err)
+	err = PrefetchBearerToken(context.Background(), &defaultSharing, ref, false)
+	assert.Error(t, err)
+}
+
+func TestSharedTokenCacheKey(t *testing.T) {
+	ch := challenge{Scheme: "bearer", Parameters: map[string]string{"realm": "https://example.com/token", "service": "example.com"}}
+	scopes := []authScope{{remoteName: "ns/repo", actions: "pull"}}
+	secret1 := []byte("0123456789abcdef0123456789abcdef")
+	secret2 := []byte("fedcba9876543210fedcba9876543210")
+	key := func(auth types.DockerAuthConfig, secret []byte) string {
+		c := &dockerClient{registry: "example.com", auth: auth}
+		return c.sharedTokenCacheKey(ch, scopes, secret)
+	}
+	user := types.DockerAuthConfig{Username: "user", Password: "pass"}
+
+	assert.Equal(t, key(user, secret1), key(user, secret1))
+	// The key depends on the secret, so it does not allow guessing the credentials offline
+	assert.NotEqual(t, key(user, secret1), key(user, secret2))
+	assert.NotContains(t, key(user, secret1), "pass")
+	// The key depends on all of the credentials
+	for _, other := range []types.DockerAuthConfig{
+		{Username: "user", Password: "other"},
+		{Username: "user", Password: "pass", IdentityToken: "token"},
+		{Username: "user", Password: "pass", ClientCertPath: "/cert", ClientKeyPath: "/key"},
+		{Username: "user", Password: "pass", ClientCertPath: "/other-cert", ClientKeyPath: "/key"},
+	} {
+		assert.NotEqual(t, key(user, secret1), key(other, secret1), "%#v", other)
+	}
+}
+
+func TestTokenCacheDirSecret(t *testing.T) {
+	dir := filepath.Join(t.TempDir(), "tokens")
+	secret, err := tokenCacheDirSecret(dir)
+	require.NoError(t, err)
+	assert.Len(t, secret, tokenCacheSecretSize)
+	fi, err := os.Stat(filepath.Join(dir, tokenCacheDirSecretFile))
+	require.NoError(t, err)
+	assert.Equal(t, os.FileMode(0600), fi.Mode().Perm())
+	secret2, err := tokenCacheDirSecret(dir)
+	require.NoError(t, err)
+	assert.Equal(t, secret, secret2)
+
+	err = os.WriteFile(filepath.Join(dir, tokenCacheDirSecretFile), []byte("short"), 0600)
+	require.NoError(t, err)
+	_, err = tokenCacheDirSecret(dir)
+	assert.Error(t, err)
+}
+
+func TestTokenCacheLocation(t *testing.T) {
+	tmpDir := t.TempDir()
+
+	oldXRD, hasXRD := os.LookupEnv("XDG_RUNTIME_DIR")
+	defer func() {
+		if hasXRD {
+			os.Setenv("XDG_RUNTIME_DIR", oldXRD)
+		} else {
+			os.Unsetenv("XDG_RUNTIME_DIR")
+		}
+	}()
+
+	for _, c := range []struct {
+		xrd      string
+		sys      *types.SystemContext
+		expected types.ResolvedLocation
+	}{
+		{tmpDir, nil, types.ResolvedLocation{}},
+		{tmpDir, &types.SystemContext{}, types.ResolvedLocation{}},
+		{tmpDir, &types.SystemContext{DockerTokenCacheDir: "/explicit"}, types.ResolvedLocation{Path: "/explicit"}},
+		{tmpDir, &types.SystemContext{DockerTokenCacheDir: "/explicit", DockerTokenCacheInRuntimeDir: true},
+			types.ResolvedLocation{Path: "/explicit"}},
+		{tmpDir, &types.SystemContext{DockerTokenCacheInRuntimeDir: true},
+			types.ResolvedLocation{Path: filepath.Join(tmpDir, "containers", "tokens")}},
+		{"", &types.SystemContext{DockerTokenCacheInRuntimeDir: true}, types.ResolvedLocation{}},
+		{tmpDir + "/thisdoesnotexist", &types.SystemContext{DockerTokenCacheInRuntimeDir: true, RuntimeDirFallback: "/fallback"},
+			types.ResolvedLocation{Path: filepath.Join("/fallback", "containers", "tokens"), Fallback: true}},
+	} {
+		if c.xrd != "" {
+			os.Setenv("XDG_RUNTIME_DIR", c.xrd)
+		} else {
+			os.Unsetenv("XDG_RUNTIME_DIR")
+		}
+		res := TokenCacheLocation(c.sys)
+		assert.Equal(t, c.expected.Path, res.Path, "%#v", c.sys)
+		assert.Equal(t, c.expected.Fallback, res.Fallback, "%#v", c.sys)
+		assert.Equal(t, c.expected.Fallback, res.FallbackReason != "", "%#v", c.sys)
+	}
+}
@@ -0,0 +1,101 @@
+package docker
+
+import (
+	"sort"
+	"strings"
+
+	"github.com/pkg/errors"
+)
+
+// String returns scope in the "repository:name:actions" format used in token requests.
+func (scope authScope) String() string {
+	return "repository:" + scope.remoteName + ":" + scope.actions
+}
+
+// parseTokenScope parses a scope in the "repository:name:actions" format.
+func parseTokenScope(s string) (authScope, error) {
+	rest := strings.TrimPrefix(s, "repository:")
+	if rest == s {
+		return authScope{}, errors.Errorf("invalid token scope %q: only repository scopes are supported", s)
+	}
+	i := strings.LastIndex(rest, ":")
+	if i <= 0 || i == len(rest)-1 {
+		return authScope{}, errors.Errorf("invalid token scope %q: expected repository:name:actions", s)
+	}
+	return authScope{remoteName: rest[:i], actions: rest[i+1:]}, nil
+}
+
+// parseTokenScopes parses scopes in the "repository:name:actions" format.
+func parseTokenScopes(scopes []string) ([]authScope, error) {
+	res := make([]authScope, 0, len(scopes))
+	for _, s := range scopes {
+		scope, err := parseTokenScope(s)
+		if err != nil {
+			return nil, err
+		}
+		res = append(res, scope)
+	}
+	return res, nil
+}
+
+// scopeCovers returns true if a token for scopes allows everything needed for scope.
+func scopeCovers(scopes []authScope, scope authScope) bool {
+	for _, action := range strings.Split(scope.actions, ",") {
+		covered := false
+		for _, s := range scopes {
+			if s.remoteName != scope.remoteName {
+				continue
+			}
+			for _, a := range strings.Split(s.actions, ",") {
+				if a == action || a == "*" {
+					covered = true
+					break
+				}
+			}
+			if covered {
+				break
+			}
+		}
+		if !covered {
+			return false
+		}
+	}
+	return true
+}
+
+// tokenScopes returns the scopes to request in a bearer token for a request which needs extraScope (if not nil)
+// in addition to c.scope, and a key identifying the token in c.tokenCache.
+func (c *dockerClient) tokenScopes(extraScope *authScope) ([]authScope, string) {
+	scopes := append([]authScope{c.scope}, c.additionalScopes...)
+	if extraScope == nil || scopeCovers(scopes, *extraScope) {
+		return scopes, ""
+	}
+	// Using ':' as a separator here is unambiguous because getBearerToken below uses the same separator when formatting a remote request (and because repository names can't contain colons).
+	return append(scopes, *extraScope), extraScope.remoteName + ":" + extraScope.actions
+}
+
+// recordTokenScopes records that a bearer token was obtained for scopes, for BearerTokenScopes.
+func (c *dockerClient) recordTokenScopes(scopes []authScope) {
+	c.tokenScopesLock.Lock()
+	defer c.tokenScopesLock.Unlock()
+	if c.requestedTokenScopes == nil {
+		c.requestedTokenScopes = map[string]struct{}{}
+	}
+	for _, scope := range scopes {
+		if scope.remoteName != "" && scope.actions != "" {
+			c.requestedTokenScopes[scope.String()] = struct{}{}
+		}
+	}
+}
+
+// bearerTokenScopes returns the scopes requested in bearer tokens used by c so far, sorted.
+func (c *dockerClient) bearerTokenScopes() []string {
+	c.tokenScopesLock.Lock()
+	defer c.tokenScopesLock.Unlock()
+	res := make([]string, 0, len(c.requestedTokenScopes))
+	for s := range c.requestedTokenScopes {
+		res = append(res, s)
+	}
+	sort.Strings(res)
+	return res
+}
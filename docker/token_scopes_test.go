@@ -0,0 +1,133 @@
+package docker
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/containers/image/v5/types"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestParseTokenScope(t *testing.T) {
+	for _, c := range []struct {
+		input    string
+		expected authScope
+	}{
+		{"repository:ns/repo:pull", authScope{remoteName: "ns/repo", actions: "pull"}},
+		{"repository:repo:pull,push", authScope{remoteName: "repo", actions: "pull,push"}},
+		{"repository:repo:*", authScope{remoteName: "repo", actions: "*"}},
+	} {
+		scope, err := parseTokenScope(c.input)
+		require.NoError(t, err, c.input)
+		assert.Equal(t, c.expected, scope, c.input)
+		assert.Equal(t, c.input, scope.String())
+	}
+	for _, input := range []string{
+		"", "ns/repo:pull", "registry:catalog:*", "repository:", "repository:repo", "repository:repo:", "repository::pull",
+	} {
+		_, err := parseTokenScope(input)
+		assert.Error(t, err, input)
+	}
+
+	scopes, err := parseTokenScopes([]string{"repository:a:pull", "repository:b:push"})
+	require.NoError(t, err)
+	assert.Equal(t, []authScope{{"a", "pull"}, {"b", "push"}}, scopes)
+	_, err = parseTokenScopes([]string{"repository:a:pull", "invalid"})
+	assert.Error(t, err)
+}
+
+func TestScopeCovers(t *testing.T) {
+	scopes := []authScope{{"a", "pull"}, {"b", "pull,push"}, {"c", "*"}, {"d", "pull"}, {"d", "push"}}
+	for _, c := range []struct {
+		scope    authScope
+		expected bool
+	}{
+		{authScope{"a", "pull"}, true},
+		{authScope{"a", "push"}, false},
+		{authScope{"a", "pull,push"}, false},
+		{authScope{"b", "push"}, true},
+		{authScope{"b", "pull,push"}, true},
+		{authScope{"c", "pull,push,delete"}, true},
+		{authScope{"d", "pull,push"}, true},
+		{authScope{"e", "pull"}, false},
+	} {
+		assert.Equal(t, c.expected, scopeCovers(scopes, c.scope), "%#v", c.scope)
+	}
+}
+
+func TestAdditionalTokenScopes(t *testing.T) {
+	defer ClearTokenCache()
+
+	tokenRequests := [][]string{}
+	var s *httptest.Server
+	s = httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		switch {
+		case r.URL.Path == "/token":
+			tokenRequests = append(tokenRequests, r.URL.Query()["scope"])
+			fmt.Fprintf(w, `{"token":"token-%d","expires_in":300}`, len(tokenRequests))
+		case strings.HasPrefix(r.Header.Get("Authorization"), "Bearer token-"):
+			w.WriteHeader(http.StatusOK)
+		default:
+			w.Header().Set("WWW-Authenticate", fmt.Sprintf(`Bearer realm="%s/token",service="test-registry"`, s.URL))
+			w.WriteHeader(http.StatusUnauthorized)
+		}
+	}))
+	defer s.Close()
+	registry := strings.TrimPrefix(s.URL, "http://")
+	authFile := filepath.Join(t.TempDir(), "auth.json")
+	err := os.WriteFile(authFile, []byte(`{"auths":{}}`), 0600)
+	require.NoError(t, err)
+
+	mount := func(sys *types.SystemContext) *dockerClient {
+		c, err := newDockerClient(sys, registry, registry)
+		require.NoError(t, err)
+		c.scope = authScope{remoteName: "dest/repo", actions: "pull,push"}
+		for _, extraScope := range []*authScope{nil, {remoteName: "source/repo", actions: "pull"}} {
+			res, err := c.makeRequest(context.Background(), http.MethodPost, "/v2/dest/repo/blobs/uploads/", nil, nil, v2Auth, extraScope)
+			require.NoError(t, err)
+			res.Body.Close()
+			assert.Equal(t, http.StatusOK, res.StatusCode)
+		}
+		return c
+	}
+	sys := &types.SystemContext{
+		DockerInsecureSkipTLSVerify: types.OptionalBoolTrue,
+		DockerDisableV1Ping:         true,
+		AuthFilePath:                authFile,
+		DockerSharedTokenCache:      types.OptionalBoolFalse,
+	}
+
+	// By default, a token for the source repository is obtained when it is needed
+	c := mount(sys)
+	assert.Equal(t, [][]string{
+		{"repository:dest/repo:pull,push"},
+		{"repository:dest/repo:pull,push", "repository:source/repo:pull"},
+	}, tokenRequests)
+	assert.Equal(t, []string{"repository:dest/repo:pull,push", "repository:source/repo:pull"}, c.bearerTokenScopes())
+
+	// With additional scopes, a single token is obtained up front, and used for all requests
+	tokenRequests = [][]string{}
+	withScopes := *sys
+	withScopes.DockerAdditionalTokenScopes = []string{"repository:source/repo:pull"}
+	c = mount(&withScopes)
+	assert.Equal(t, [][]string{{"repository:dest/repo:pull,push", "repository:source/repo:pull"}}, tokenRequests)
+	scopes, cacheKey := c.tokenScopes(&authScope{remoteName: "source/repo", actions: "pull"})
+	assert.Equal(t, []authScope{{"dest/repo", "pull,push"}, {"source/repo", "pull"}}, scopes)
+	assert.Equal(t, "", cacheKey)
+	scopes, cacheKey = c.tokenScopes(&authScope{remoteName: "other/repo", actions: "pull"})
+	assert.Equal(t, []authScope{{"dest/repo", "pull,push"}, {"source/repo", "pull"}, {"other/repo", "pull"}}, scopes)
+	assert.Equal(t, "other/repo:pull", cacheKey)
+
+	// Invalid scopes are rejected
+	invalid := *sys
+	invalid.DockerAdditionalTokenScopes = []string{"invalid"}
+	_, err = newDockerClient(&invalid, registry, registry)
+	assert.Error(t, err)
+}
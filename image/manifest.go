@@ -5,7 +5,9 @@ import (
 	"fmt"
 
 	"github.com/containers/image/v5/docker/reference"
+	"github.com/containers/image/v5/internal/warnings"
 	"github.com/containers/image/v5/manifest"
+	"github.com/containers/image/v5/transports"
 	"github.com/containers/image/v5/types"
 	imgspecv1 "github.com/opencontainers/image-spec/specs-go/v1"
 	"github.com/pkg/errors"
@@ -58,6 +60,16 @@ type genericManifest interface {
 func manifestInstanceFromBlob(ctx context.Context, sys *types.SystemContext, src types.ImageSource, manblob []byte, mt string) (genericManifest, error) {
 	switch manifest.NormalizedMIMEType(mt) {
 	case manifest.DockerV2Schema1MediaType, manifest.DockerV2Schema1SignedMediaType:
+		imageName := transports.ImageName(src.Reference())
+		if sys != nil && sys.RejectSchema1Manifests {
+			return nil, manifest.Schema1RejectedError{Image: imageName}
+		}
+		warnings.Report(sys, types.Warning{
+			Kind:    types.WarningDeprecation,
+			Code:    types.WarningCodeSchema1Manifest,
+			Subject: imageName,
+			Message: fmt.Sprintf("Image %s uses the deprecated Docker schema1 manifest format", imageName),
+		})
 		return manifestSchema1FromManifest(manblob)
 	case imgspecv1.MediaTypeImageManifest:
 		return manifestOCI1FromManifest(src, manblob)
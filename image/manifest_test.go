@@ -1,11 +1,19 @@
 package image
 
 import (
+	"context"
+	"errors"
+	"os"
+	"path/filepath"
 	"testing"
 
+	"github.com/containers/image/v5/docker/reference"
+	"github.com/containers/image/v5/internal/testing/mocks"
 	"github.com/containers/image/v5/manifest"
 	"github.com/containers/image/v5/types"
+	imgspecv1 "github.com/opencontainers/image-spec/specs-go/v1"
 	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
 )
 
 func TestManifestLayerInfosToBlobInfos(t *testing.T) {
@@ -69,3 +77,82 @@ func TestManifestLayerInfosToBlobInfos(t *testing.T) {
 		},
 	}, blobs)
 }
+
+// namedImageReferenceMock is a mock of types.ImageReference which only supports transports.ImageName.
+type namedImageReferenceMock struct{ refImageReferenceMock }
+
+func (ref namedImageReferenceMock) Transport() types.ImageTransport {
+	return mocks.NameImageTransport("mock")
+}
+func (ref namedImageReferenceMock) StringWithinTransport() string {
+	return ref.String()
+}
+
+// namedImageSource is an ImageSource which only supports Reference.
+type namedImageSource struct {
+	unusedImageSource
+	ref types.ImageReference
+}
+
+func (s namedImageSource) Reference() types.ImageReference {
+	return s.ref
+}
+
+func TestManifestInstanceFromBlobWarnings(t *testing.T) {
+	named, err := reference.ParseNormalizedNamed("example.com/repo:tag")
+	require.NoError(t, err)
+	src := namedImageSource{ref: namedImageReferenceMock{refImageReferenceMock{named}}}
+	warnings := []types.Warning{}
+	sys := &types.SystemContext{WarningHandler: func(w types.Warning) { warnings = append(warnings, w) }}
+
+	for _, c := range []struct {
+		fixture, mimeType string
+		warned            bool
+	}{
+		{"schema1.json", manifest.DockerV2Schema1SignedMediaType, true},
+		{"schema2.json", manifest.DockerV2Schema2MediaType, false},
+		{"oci1.json", imgspecv1.MediaTypeImageManifest, false},
+	} {
+		warnings = []types.Warning{}
+		manifestBlob, err := os.ReadFile(filepath.Join("fixtures", c.fixture))
+		require.NoError(t, err)
+		_, err = manifestInstanceFromBlob(context.Background(), sys, src, manifestBlob, c.mimeType)
+		require.NoError(t, err, c.fixture)
+		if c.warned {
+			require.Len(t, warnings, 1, c.fixture)
+			assert.Equal(t, types.WarningDeprecation, warnings[0].Kind)
+			assert.Equal(t, types.WarningCodeSchema1Manifest, warnings[0].Code)
+			assert.Equal(t, "mock:example.com/repo:tag", warnings[0].Subject)
+		} else {
+			assert.Empty(t, warnings, c.fixture)
+		}
+	}
+}
+
+func TestManifestInstanceFromBlobRejectSchema1(t *testing.T) {
+	named, err := reference.ParseNormalizedNamed("example.com/repo:tag")
+	require.NoError(t, err)
+	src := namedImageSource{ref: namedImageReferenceMock{refImageReferenceMock{named}}}
+	sys := &types.SystemContext{RejectSchema1Manifests: true}
+
+	for _, c := range []struct {
+		fixture, mimeType string
+		rejected          bool
+	}{
+		{"schema1.json", manifest.DockerV2Schema1SignedMediaType, true},
+		{"schema1.json", manifest.DockerV2Schema1MediaType, true},
+		{"schema2.json", manifest.DockerV2Schema2MediaType, false},
+		{"oci1.json", imgspecv1.MediaTypeImageManifest, false},
+	} {
+		manifestBlob, err := os.ReadFile(filepath.Join("fixtures", c.fixture))
+		require.NoError(t, err)
+		_, err = manifestInstanceFromBlob(context.Background(), sys, src, manifestBlob, c.mimeType)
+		if c.rejected {
+			var rejected manifest.Schema1RejectedError
+			require.True(t, errors.As(err, &rejected), c.fixture)
+			assert.Equal(t, "mock:example.com/repo:tag", rejected.Image)
+		} else {
+			assert.NoError(t, err, c.fixture)
+		}
+	}
+}
@@ -83,7 +83,11 @@ func (m *manifestOCI1) ConfigBlob(ctx context.Context) ([]byte, error) {
 // OCIConfig returns the image configuration as per OCI v1 image-spec. Information about
 // layers in the resulting configuration isn't guaranteed to be returned to due how
 // old image manifests work (docker v2s1 especially).
+// It returns manifest.NonImageArtifactError if the manifest does not describe a container image.
 func (m *manifestOCI1) OCIConfig(ctx context.Context) (*imgspecv1.Image, error) {
+	if !m.m.IsImage() {
+		return nil, manifest.NonImageArtifactError{ConfigMediaType: m.m.Config.MediaType}
+	}
 	cb, err := m.ConfigBlob(ctx)
 	if err != nil {
 		return nil, err
@@ -194,6 +198,16 @@ func (m *manifestOCI1) convertToManifestSchema2Generic(ctx context.Context, opti
 // value.
 // This does not change the state of the original manifestOCI1 object.
 func (m *manifestOCI1) convertToManifestSchema2(_ context.Context, _ *types.ManifestUpdateOptions) (*manifestSchema2, error) {
+	if !m.m.IsImage() {
+		return nil, manifest.NonImageArtifactError{ConfigMediaType: m.m.Config.MediaType}
+	}
+	if m.m.Subject != nil {
+		return nil, fmt.Errorf("Error during manifest conversion: the OCI subject field (referring to %s) can not be represented in docker images", m.m.Subject.Digest)
+	}
+	if m.m.ArtifactType != "" {
+		return nil, fmt.Errorf("Error during manifest conversion: the OCI artifact type %q can not be represented in docker images", m.m.ArtifactType)
+	}
+
 	// Create a copy of the descriptor.
 	config := schema2DescriptorFromOCI1Descriptor(m.m.Config)
 
@@ -388,6 +388,34 @@ func TestManifestOCI1UpdatedImage(t *testing.T) {
 	assert.Equal(t, *typedM2, *typedOriginal)
 }
 
+func TestManifestOCI1UpdatedImageSubject(t *testing.T) {
+	originalSrc := newOCI1ImageSource(t, "httpd-copy:latest")
+	original := manifestOCI1FromFixture(t, originalSrc, "oci1-subject.json")
+
+	// Unrelated updates preserve the subject and artifactType fields
+	layerInfos := append(original.LayerInfos()[1:], original.LayerInfos()[0])
+	res, err := original.UpdatedImage(context.Background(), types.ManifestUpdateOptions{
+		LayerInfos: layerInfos,
+	})
+	require.NoError(t, err)
+	serialized, _, err := res.Manifest(context.Background())
+	require.NoError(t, err)
+	m, err := manifest.OCI1FromManifest(serialized)
+	require.NoError(t, err)
+	assert.Equal(t, "application/vnd.example.artifact.v1", m.ArtifactType)
+	require.NotNil(t, m.Subject)
+	assert.Equal(t, digest.Digest("sha256:e692418e4cbaf90ca69d05a66403747baa33ee08806650b51fab815ad7fc331f"), m.Subject.Digest)
+
+	// Conversion to a format which can't represent the subject fails
+	_, err = original.UpdatedImage(context.Background(), types.ManifestUpdateOptions{
+		ManifestMIMEType: manifest.DockerV2Schema2MediaType,
+		InformationOnly: types.ManifestUpdateInformation{
+			Destination: &memoryImageDest{ref: originalSrc.ref},
+		},
+	})
+	assert.Error(t, err)
+}
+
 func TestManifestOCI1ConvertToManifestSchema1(t *testing.T) {
 	originalSrc := newOCI1ImageSource(t, "httpd-copy:latest")
 	original := manifestOCI1FromFixture(t, originalSrc, "oci1.json")
@@ -497,3 +525,35 @@ func TestConvertToV2S2WithInvalidMIMEType(t *testing.T) {
 	_, err = manifestOCI1FromManifest(originalSrc, manifest)
 	require.NoError(t, err)
 }
+
+func TestManifestOCI1NonImageArtifact(t *testing.T) {
+	originalSrc := newOCI1ImageSource(t, "httpd-copy:latest")
+	original := manifestOCI1FromFixture(t, originalSrc, "oci1-artifact.json")
+	expectedErr := manifest.NonImageArtifactError{ConfigMediaType: "application/vnd.cncf.helm.config.v1+json"}
+
+	_, err := original.OCIConfig(context.Background())
+	assert.Equal(t, expectedErr, err)
+	_, err = original.Inspect(context.Background())
+	assert.Equal(t, expectedErr, err)
+
+	for _, mt := range []string{manifest.DockerV2Schema2MediaType, manifest.DockerV2Schema1SignedMediaType} {
+		_, err = original.UpdatedImage(context.Background(), types.ManifestUpdateOptions{
+			ManifestMIMEType: mt,
+			InformationOnly: types.ManifestUpdateInformation{
+				Destination: &memoryImageDest{ref: originalSrc.ref},
+			},
+		})
+		assert.Equal(t, expectedErr, errors.Cause(err), mt)
+	}
+
+	// Updates which do not modify the layers are allowed
+	res, err := original.UpdatedImage(context.Background(), types.ManifestUpdateOptions{
+		LayerInfos: original.LayerInfos(),
+	})
+	require.NoError(t, err)
+	serialized, _, err := res.Manifest(context.Background())
+	require.NoError(t, err)
+	m, err := manifest.OCI1FromManifest(serialized)
+	require.NoError(t, err)
+	assert.Equal(t, "application/vnd.cncf.helm.chart.content.v1.tar+gzip", m.Layers[0].MediaType)
+}
@@ -2,11 +2,17 @@ package image
 
 import (
 	"context"
+	"fmt"
 
 	"github.com/containers/image/v5/docker/reference"
+	"github.com/containers/image/v5/internal/iolimits"
+	"github.com/containers/image/v5/internal/private"
 	"github.com/containers/image/v5/manifest"
+	"github.com/containers/image/v5/pkg/blobinfocache/none"
+	"github.com/containers/image/v5/signature/sigstore"
 	"github.com/containers/image/v5/types"
 	"github.com/opencontainers/go-digest"
+	imgspecv1 "github.com/opencontainers/image-spec/specs-go/v1"
 	"github.com/pkg/errors"
 )
 
@@ -20,6 +26,11 @@ type UnparsedImage struct {
 	// Valid iff cachedManifest is not nil.
 	cachedManifestMIMEType string
 	cachedSignatures       [][]byte // A private cache for Signatures(); nil if not yet known.
+	// A private cache for UntrustedSigstoreSignatures(); nil if not yet known.
+	cachedSigstoreSignatures []sigstore.Signature
+	// A private cache for UntrustedPlatform(); valid iff cachedPlatformKnown.
+	cachedPlatform      *imgspecv1.Platform
+	cachedPlatformKnown bool
 }
 
 // UnparsedInstance returns a types.UnparsedImage implementation for (source, instanceDigest).
@@ -93,3 +104,177 @@ func (i *UnparsedImage) Signatures(ctx context.Context) ([][]byte, error) {
 	}
 	return i.cachedSignatures, nil
 }
+
+// UntrustedSigstoreSignatures implements private.SigstoreSignaturesReader.
+// It reads the signatures stored using the cosign tag scheme; the result is cached.
+func (i *UnparsedImage) UntrustedSigstoreSignatures(ctx context.Context) ([]sigstore.Signature, error) {
+	if i.cachedSigstoreSignatures == nil {
+		reader, ok := i.src.(private.TaggedManifestReader)
+		if !ok {
+			return nil, errors.Errorf("reading sigstore signatures is not supported by the %q transport", i.src.Reference().Transport().Name())
+		}
+		m, _, err := i.Manifest(ctx)
+		if err != nil {
+			return nil, err
+		}
+		manifestDigest, err := manifest.Digest(m)
+		if err != nil {
+			return nil, err
+		}
+		tag := fmt.Sprintf("%s-%s.%s", manifestDigest.Algorithm(), manifestDigest.Encoded(), sigstore.SignatureTagSuffix)
+		sigManifest, mimeType, err := reader.GetManifestForTag(ctx, tag)
+		if err != nil {
+			return nil, errors.Wrapf(err, "reading sigstore signatures %s", tag)
+		}
+		sigs := []sigstore.Signature{}
+		if sigManifest != nil {
+			if mimeType == "" {
+				mimeType = manifest.GuessMIMEType(sigManifest)
+			}
+			if manifest.NormalizedMIMEType(mimeType) != imgspecv1.MediaTypeImageManifest {
+				return nil, errors.Errorf("sigstore signatures %s have unexpected type %s", tag, mimeType)
+			}
+			parsed, err := manifest.OCI1FromManifest(sigManifest)
+			if err != nil {
+				return nil, errors.Wrapf(err, "parsing sigstore signatures %s", tag)
+			}
+			for _, layer := range parsed.Layers {
+				if layer.MediaType != sigstore.SignatureMIMEType {
+					continue
+				}
+				payload, err := i.readSigstorePayload(ctx, layer.Digest, layer.Size)
+				if err != nil {
+					return nil, err
+				}
+				sigs = append(sigs, sigstore.Signature{Payload: payload, Annotations: layer.Annotations})
+			}
+		}
+		i.cachedSigstoreSignatures = sigs
+	}
+	return i.cachedSigstoreSignatures, nil
+}
+
+// readSigstorePayload returns the contents of a sigstore signature payload blob with blobDigest and size.
+func (i *UnparsedImage) readSigstorePayload(ctx context.Context, blobDigest digest.Digest, size int64) ([]byte, error) {
+	if err := blobDigest.Validate(); err != nil {
+		return nil, errors.Wrap(err, "invalid sigstore signature payload digest")
+	}
+	if size > iolimits.MaxSignatureBodySize {
+		return nil, errors.Errorf("sigstore signature payload %s is too large (%d bytes)", blobDigest, size)
+	}
+	stream, _, err := i.src.GetBlob(ctx, types.BlobInfo{Digest: blobDigest, Size: size}, none.NoCache)
+	if err != nil {
+		return nil, errors.Wrapf(err, "reading sigstore signature payload %s", blobDigest)
+	}
+	defer stream.Close()
+	payload, err := iolimits.ReadAtMost(stream, iolimits.MaxSignatureBodySize)
+	if err != nil {
+		return nil, errors.Wrapf(err, "reading sigstore signature payload %s", blobDigest)
+	}
+	if blobDigest.Algorithm().FromBytes(payload) != blobDigest {
+		return nil, errors.Errorf("sigstore signature payload does not match digest %s", blobDigest)
+	}
+	return payload, nil
+}
+
+// UntrustedPlatform implements private.PlatformReader.
+// It returns the platform recorded for the image in the top-level manifest list, or nil if the image was not chosen
+// from a manifest list (i.e. it is the top-level image, or the top-level manifest list itself), or if the list does
+// not record an unambiguous platform for it; the result is cached.
+// The platform recorded in the list must be consistent with the image config.
+func (i *UnparsedImage) UntrustedPlatform(ctx context.Context) (*imgspecv1.Platform, error) {
+	if !i.cachedPlatformKnown {
+		var platform *imgspecv1.Platform
+		if i.instanceDigest != nil {
+			listPlatform, err := i.listPlatform(ctx, *i.instanceDigest)
+			if err != nil {
+				return nil, err
+			}
+			if listPlatform != nil {
+				if err := i.checkPlatformMatchesConfig(ctx, listPlatform); err != nil {
+					return nil, err
+				}
+				platform = listPlatform
+			}
+		}
+		i.cachedPlatform = platform
+		i.cachedPlatformKnown = true
+	}
+	return i.cachedPlatform, nil
+}
+
+// listPlatform returns the platform the top-level manifest list records for instanceDigest, or nil if the list
+// does not record a platform for it, or if it records several different ones.
+func (i *UnparsedImage) listPlatform(ctx context.Context, instanceDigest digest.Digest) (*imgspecv1.Platform, error) {
+	listBlob, listMIMEType, err := i.src.GetManifest(ctx, nil)
+	if err != nil {
+		return nil, err
+	}
+	if listMIMEType == "" {
+		listMIMEType = manifest.GuessMIMEType(listBlob)
+	}
+	if !manifest.MIMETypeIsMultiImage(listMIMEType) {
+		return nil, nil
+	}
+	list, err := manifest.ListFromBlob(listBlob, listMIMEType)
+	if err != nil {
+		return nil, errors.Wrap(err, "parsing manifest list")
+	}
+	var listPlatforms []imgspecv1.Platform
+	switch l := list.(type) {
+	case *manifest.Schema2List:
+		for _, m := range l.Manifests {
+			if m.Digest == instanceDigest {
+				listPlatforms = append(listPlatforms, imgspecv1.Platform{OS: m.Platform.OS, Architecture: m.Platform.Architecture, Variant: m.Platform.Variant})
+			}
+		}
+	case *manifest.OCI1Index:
+		for _, m := range l.Manifests {
+			if m.Digest == instanceDigest {
+				if m.Platform == nil {
+					return nil, nil
+				}
+				listPlatforms = append(listPlatforms, *m.Platform)
+			}
+		}
+	}
+	if len(listPlatforms) == 0 {
+		return nil, nil
+	}
+	res := listPlatforms[0]
+	for _, lp := range listPlatforms[1:] {
+		if lp.OS != res.OS || lp.Architecture != res.Architecture || lp.Variant != res.Variant {
+			return nil, nil
+		}
+	}
+	if res.OS == "" || res.Architecture == "" {
+		return nil, nil
+	}
+	return &imgspecv1.Platform{OS: res.OS, Architecture: res.Architecture, Variant: res.Variant}, nil
+}
+
+// checkPlatformMatchesConfig returns an error if the image config is inconsistent with listPlatform.
+func (i *UnparsedImage) checkPlatformMatchesConfig(ctx context.Context, listPlatform *imgspecv1.Platform) error {
+	_, mimeType, err := i.Manifest(ctx)
+	if err != nil {
+		return err
+	}
+	if manifest.MIMETypeIsMultiImage(mimeType) {
+		return errors.Errorf("manifest list entry for %s claims platform %s/%s, but it is a manifest list",
+			*i.instanceDigest, listPlatform.OS, listPlatform.Architecture)
+	}
+	img, err := FromUnparsedImage(ctx, nil, i)
+	if err != nil {
+		return err
+	}
+	info, err := img.Inspect(ctx)
+	if err != nil {
+		return errors.Wrap(err, "determining the platform of the image")
+	}
+	if listPlatform.OS != info.Os || listPlatform.Architecture != info.Architecture ||
+		(listPlatform.Variant != "" && info.Variant != "" && listPlatform.Variant != info.Variant) {
+		return errors.Errorf("manifest list entry for %s claims platform %s/%s/%s, but the image config specifies %s/%s/%s",
+			*i.instanceDigest, listPlatform.OS, listPlatform.Architecture, listPlatform.Variant, info.Os, info.Architecture, info.Variant)
+	}
+	return nil
+}
@@ -0,0 +1,289 @@
+package image
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"io"
+	"testing"
+
+	"github.com/containers/image/v5/docker/reference"
+	"github.com/containers/image/v5/internal/private"
+	"github.com/containers/image/v5/manifest"
+	"github.com/containers/image/v5/signature/sigstore"
+	"github.com/containers/image/v5/types"
+	"github.com/opencontainers/go-digest"
+	imgspecv1 "github.com/opencontainers/image-spec/specs-go/v1"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// sigstoreImageSource is an image source containing an image and artifacts stored using the cosign tag scheme.
+type sigstoreImageSource struct {
+	unusedImageSource // We inherit almost all of the methods, which just panic()
+	ref               reference.Named
+	manifest          []byte
+	tags              map[string][]byte
+	blobs             map[digest.Digest][]byte
+}
+
+func (s *sigstoreImageSource) Reference() types.ImageReference {
+	return refImageReferenceMock{s.ref}
+}
+
+func (s *sigstoreImageSource) GetManifest(ctx context.Context, instanceDigest *digest.Digest) ([]byte, string, error) {
+	return s.manifest, imgspecv1.MediaTypeImageManifest, nil
+}
+
+func (s *sigstoreImageSource) GetManifestForTag(ctx context.Context, tag string) ([]byte, string, error) {
+	return s.tags[tag], "", nil
+}
+
+func (s *sigstoreImageSource) GetBlob(ctx context.Context, info types.BlobInfo, cache types.BlobInfoCache) (io.ReadCloser, int64, error) {
+	blob, ok := s.blobs[info.Digest]
+	if !ok {
+		return nil, -1, fmt.Errorf("blob %s not found", info.Digest)
+	}
+	return io.NopCloser(bytes.NewReader(blob)), int64(len(blob)), nil
+}
+
+func TestUnparsedImageUntrustedSigstoreSignatures(t *testing.T) {
+	ref, err := reference.ParseNormalizedNamed("example.com/repo:tag")
+	require.NoError(t, err)
+	src := &sigstoreImageSource{
+		ref:      ref,
+		manifest: []byte(`{"schemaVersion":2,"mediaType":"application/vnd.oci.image.manifest.v1+json"}`),
+		tags:     map[string][]byte{},
+		blobs:    map[digest.Digest][]byte{},
+	}
+	manifestDigest := digest.FromBytes(src.manifest)
+	tag := fmt.Sprintf("sha256-%s.sig", manifestDigest.Encoded())
+
+	// No signatures
+	_, ok := interface{}(UnparsedInstance(src, nil)).(private.SigstoreSignaturesReader)
+	assert.True(t, ok)
+	sigs, err := UnparsedInstance(src, nil).UntrustedSigstoreSignatures(context.Background())
+	require.NoError(t, err)
+	assert.Empty(t, sigs)
+
+	payloads := [][]byte{[]byte("payload 1"), []byte("payload 2")}
+	layers := []imgspecv1.Descriptor{}
+	for i, p := range payloads {
+		d := digest.FromBytes(p)
+		src.blobs[d] = p
+		layers = append(layers, imgspecv1.Descriptor{
+			MediaType:   sigstore.SignatureMIMEType,
+			Digest:      d,
+			Size:        int64(len(p)),
+			Annotations: map[string]string{sigstore.SignatureAnnotationKey: fmt.Sprintf("signature %d", i)},
+		})
+	}
+	// Other layers are ignored
+	layers = append(layers, imgspecv1.Descriptor{MediaType: "application/vnd.example.other", Digest: digest.FromString("other"), Size: 5})
+	sigManifest, err := manifest.OCI1FromComponents(imgspecv1.Descriptor{
+		MediaType: imgspecv1.MediaTypeImageConfig,
+		Digest:    digest.FromString("{}"),
+		Size:      2,
+	}, layers).Serialize()
+	require.NoError(t, err)
+	src.tags[tag] = sigManifest
+
+	sigs, err = UnparsedInstance(src, nil).UntrustedSigstoreSignatures(context.Background())
+	require.NoError(t, err)
+	assert.Equal(t, []sigstore.Signature{
+		{Payload: payloads[0], Annotations: map[string]string{sigstore.SignatureAnnotationKey: "signature 0"}},
+		{Payload: payloads[1], Annotations: map[string]string{sigstore.SignatureAnnotationKey: "signature 1"}},
+	}, sigs)
+
+	// A payload which does not match the digest
+	src.blobs[layers[1].Digest] = []byte("modified")
+	_, err = UnparsedInstance(src, nil).UntrustedSigstoreSignatures(context.Background())
+	assert.Error(t, err)
+
+	// A signature artifact which is not an OCI manifest
+	src.tags[tag] = []byte(`{"schemaVersion":2,"mediaType":"application/vnd.docker.distribution.manifest.v2+json"}`)
+	_, err = UnparsedInstance(src, nil).UntrustedSigstoreSignatures(context.Background())
+	assert.Error(t, err)
+}
+
+// platformImageSource is an image source containing a manifest list and its instances.
+type platformImageSource struct {
+	unusedImageSource // We inherit almost all of the methods, which just panic()
+	toplevel          []byte
+	toplevelMIMEType  string
+	instances         map[digest.Digest][]byte
+	blobs             map[digest.Digest][]byte
+}
+
+func (s *platformImageSource) Reference() types.ImageReference {
+	ref, err := reference.ParseNormalizedNamed("example.com/repo:tag")
+	if err != nil {
+		panic(err)
+	}
+	return refImageReferenceMock{ref}
+}
+
+func (s *platformImageSource) GetManifest(ctx context.Context, instanceDigest *digest.Digest) ([]byte, string, error) {
+	if instanceDigest == nil {
+		return s.toplevel, s.toplevelMIMEType, nil
+	}
+	m, ok := s.instances[*instanceDigest]
+	if !ok {
+		return nil, "", fmt.Errorf("manifest %s not found", *instanceDigest)
+	}
+	return m, imgspecv1.MediaTypeImageManifest, nil
+}
+
+func (s *platformImageSource) GetBlob(ctx context.Context, info types.BlobInfo, cache types.BlobInfoCache) (io.ReadCloser, int64, error) {
+	blob, ok := s.blobs[info.Digest]
+	if !ok {
+		return nil, -1, fmt.Errorf("blob %s not found", info.Digest)
+	}
+	return io.NopCloser(bytes.NewReader(blob)), int64(len(blob)), nil
+}
+
+// addInstance adds an OCI image with a config specifying platform to s, and returns its manifest and digest.
+func (s *platformImageSource) addInstance(t *testing.T, platform imgspecv1.Platform) ([]byte, digest.Digest) {
+	config := []byte(fmt.Sprintf(`{"os":%q,"architecture":%q,"variant":%q,"rootfs":{"type":"layers","diff_ids":[]}}`,
+		platform.OS, platform.Architecture, platform.Variant))
+	configDigest := digest.FromBytes(config)
+	s.blobs[configDigest] = config
+	m, err := manifest.OCI1FromComponents(imgspecv1.Descriptor{
+		MediaType: imgspecv1.MediaTypeImageConfig,
+		Digest:    configDigest,
+		Size:      int64(len(config)),
+	}, []imgspecv1.Descriptor{}).Serialize()
+	require.NoError(t, err)
+	d := digest.FromBytes(m)
+	s.instances[d] = m
+	return m, d
+}
+
+func TestUnparsedImageUntrustedPlatform(t *testing.T) {
+	ctx := context.Background()
+	src := &platformImageSource{instances: map[digest.Digest][]byte{}, blobs: map[digest.Digest][]byte{}}
+	amd64, amd64Digest := src.addInstance(t, imgspecv1.Platform{OS: "linux", Architecture: "amd64"})
+	_, arm64Digest := src.addInstance(t, imgspecv1.Platform{OS: "linux", Architecture: "arm64", Variant: "v8"})
+
+	// A single image
+	src.toplevel, src.toplevelMIMEType = amd64, imgspecv1.MediaTypeImageManifest
+	unparsed := UnparsedInstance(src, nil)
+	_, ok := interface{}(unparsed).(private.PlatformReader)
+	assert.True(t, ok)
+	platform, err := unparsed.UntrustedPlatform(ctx)
+	require.NoError(t, err)
+	assert.Nil(t, platform) // Not chosen from a manifest list
+	// A single image relabeled in its config is still not chosen for any platform
+	relabeled, _ := src.addInstance(t, imgspecv1.Platform{OS: "linux", Architecture: "riscv64"})
+	src.toplevel = relabeled
+	platform, err = UnparsedInstance(src, nil).UntrustedPlatform(ctx)
+	require.NoError(t, err)
+	assert.Nil(t, platform)
+
+	for _, c := range []struct {
+		name          string
+		list          manifest.List
+		arm64Err      bool
+		amd64Err      bool
+		arm64Platform *imgspecv1.Platform
+		amd64Platform *imgspecv1.Platform
+	}{
+		{
+			name: "consistent OCI index",
+			list: manifest.OCI1IndexFromComponents([]imgspecv1.Descriptor{
+				{MediaType: imgspecv1.MediaTypeImageManifest, Digest: amd64Digest, Size: 1, Platform: &imgspecv1.Platform{OS: "linux", Architecture: "amd64"}},
+				{MediaType: imgspecv1.MediaTypeImageManifest, Digest: arm64Digest, Size: 1, Platform: &imgspecv1.Platform{OS: "linux", Architecture: "arm64"}},
+			}, nil),
+			amd64Platform: &imgspecv1.Platform{OS: "linux", Architecture: "amd64"},
+			arm64Platform: &imgspecv1.Platform{OS: "linux", Architecture: "arm64"},
+		},
+		{
+			name: "OCI index without platforms",
+			list: manifest.OCI1IndexFromComponents([]imgspecv1.Descriptor{
+				{MediaType: imgspecv1.MediaTypeImageManifest, Digest: amd64Digest, Size: 1},
+				{MediaType: imgspecv1.MediaTypeImageManifest, Digest: arm64Digest, Size: 1},
+			}, nil),
+		},
+		{
+			name: "inconsistent OCI index",
+			list: manifest.OCI1IndexFromComponents([]imgspecv1.Descriptor{
+				{MediaType: imgspecv1.MediaTypeImageManifest, Digest: amd64Digest, Size: 1, Platform: &imgspecv1.Platform{OS: "linux", Architecture: "amd64"}},
+				{MediaType: imgspecv1.MediaTypeImageManifest, Digest: arm64Digest, Size: 1, Platform: &imgspecv1.Platform{OS: "linux", Architecture: "riscv64"}},
+			}, nil),
+			amd64Platform: &imgspecv1.Platform{OS: "linux", Architecture: "amd64"},
+			arm64Err:      true,
+		},
+		{
+			name: "inconsistent variant in a Docker list",
+			list: manifest.Schema2ListFromComponents([]manifest.Schema2ManifestDescriptor{
+				{Schema2Descriptor: manifest.Schema2Descriptor{MediaType: imgspecv1.MediaTypeImageManifest, Digest: amd64Digest, Size: 1},
+					Platform: manifest.Schema2PlatformSpec{OS: "linux", Architecture: "amd64"}},
+				{Schema2Descriptor: manifest.Schema2Descriptor{MediaType: imgspecv1.MediaTypeImageManifest, Digest: arm64Digest, Size: 1},
+					Platform: manifest.Schema2PlatformSpec{OS: "linux", Architecture: "arm64", Variant: "v7"}},
+			}),
+			amd64Platform: &imgspecv1.Platform{OS: "linux", Architecture: "amd64"},
+			arm64Err:      true,
+		},
+		{
+			name: "Docker list with variants",
+			list: manifest.Schema2ListFromComponents([]manifest.Schema2ManifestDescriptor{
+				{Schema2Descriptor: manifest.Schema2Descriptor{MediaType: imgspecv1.MediaTypeImageManifest, Digest: amd64Digest, Size: 1},
+					Platform: manifest.Schema2PlatformSpec{OS: "linux", Architecture: "amd64"}},
+				{Schema2Descriptor: manifest.Schema2Descriptor{MediaType: imgspecv1.MediaTypeImageManifest, Digest: arm64Digest, Size: 1},
+					Platform: manifest.Schema2PlatformSpec{OS: "linux", Architecture: "arm64", Variant: "v8"}},
+			}),
+			amd64Platform: &imgspecv1.Platform{OS: "linux", Architecture: "amd64"},
+			arm64Platform: &imgspecv1.Platform{OS: "linux", Architecture: "arm64", Variant: "v8"},
+		},
+		{
+			name: "ambiguous Docker list",
+			list: manifest.Schema2ListFromComponents([]manifest.Schema2ManifestDescriptor{
+				{Schema2Descriptor: manifest.Schema2Descriptor{MediaType: imgspecv1.MediaTypeImageManifest, Digest: arm64Digest, Size: 1},
+					Platform: manifest.Schema2PlatformSpec{OS: "linux", Architecture: "arm64", Variant: "v8"}},
+				{Schema2Descriptor: manifest.Schema2Descriptor{MediaType: imgspecv1.MediaTypeImageManifest, Digest: arm64Digest, Size: 1},
+					Platform: manifest.Schema2PlatformSpec{OS: "linux", Architecture: "arm64"}},
+			}),
+		},
+		{
+			name: "inconsistent Docker list",
+			list: manifest.Schema2ListFromComponents([]manifest.Schema2ManifestDescriptor{
+				{Schema2Descriptor: manifest.Schema2Descriptor{MediaType: imgspecv1.MediaTypeImageManifest, Digest: amd64Digest, Size: 1},
+					Platform: manifest.Schema2PlatformSpec{OS: "windows", Architecture: "amd64"}},
+			}),
+			amd64Err: true,
+		},
+	} {
+		src.toplevel, err = c.list.Serialize()
+		require.NoError(t, err, c.name)
+		src.toplevelMIMEType = c.list.MIMEType()
+
+		platform, err := UnparsedInstance(src, nil).UntrustedPlatform(ctx)
+		require.NoError(t, err, c.name)
+		assert.Nil(t, platform, c.name)
+
+		platform, err = UnparsedInstance(src, &amd64Digest).UntrustedPlatform(ctx)
+		if c.amd64Err {
+			assert.Error(t, err, c.name)
+		} else {
+			require.NoError(t, err, c.name)
+			assert.Equal(t, c.amd64Platform, platform, c.name)
+		}
+		platform, err = UnparsedInstance(src, &arm64Digest).UntrustedPlatform(ctx)
+		if c.arm64Err {
+			assert.Error(t, err, c.name)
+		} else {
+			require.NoError(t, err, c.name)
+			assert.Equal(t, c.arm64Platform, platform, c.name)
+		}
+	}
+
+	// The config can't be read
+	src.toplevel, err = manifest.OCI1IndexFromComponents([]imgspecv1.Descriptor{
+		{MediaType: imgspecv1.MediaTypeImageManifest, Digest: amd64Digest, Size: 1, Platform: &imgspecv1.Platform{OS: "linux", Architecture: "amd64"}},
+	}, nil).Serialize()
+	require.NoError(t, err)
+	src.toplevelMIMEType = imgspecv1.MediaTypeImageIndex
+	src.blobs = map[digest.Digest][]byte{}
+	_, err = UnparsedInstance(src, &amd64Digest).UntrustedPlatform(ctx)
+	assert.Error(t, err)
+}
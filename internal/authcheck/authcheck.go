@@ -0,0 +1,34 @@
+// Package authcheck allows pkg/docker/config to verify credentials using the docker transport’s
+// registry client, which can’t be imported there directly because the docker transport depends on pkg/docker/config.
+package authcheck
+
+import (
+	"context"
+	"sync"
+
+	"github.com/containers/image/v5/types"
+)
+
+// Func verifies that username and password are accepted by registry (a host[:port]).
+// It should return a *config.CheckAuthError on failure.
+type Func func(ctx context.Context, sys *types.SystemContext, registry, username, password string) error
+
+var (
+	mu      sync.Mutex
+	checker Func
+)
+
+// Register records f as the implementation used by config.CheckAuth.
+// It is expected to be called from an init() function of the docker transport.
+func Register(f Func) {
+	mu.Lock()
+	defer mu.Unlock()
+	checker = f
+}
+
+// Get returns the registered implementation, or nil if none has been registered.
+func Get() Func {
+	mu.Lock()
+	defer mu.Unlock()
+	return checker
+}
@@ -0,0 +1,124 @@
+// Package awssigv4 signs HTTP requests to AWS services using AWS Signature Version 4.
+package awssigv4
+
+import (
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"net/http"
+	"sort"
+	"strings"
+	"time"
+)
+
+// Credentials are AWS credentials.
+type Credentials struct {
+	AccessKeyID     string
+	SecretAccessKey string
+	SessionToken    string // May be ""
+}
+
+// PayloadHash returns the hex-encoded SHA-256 digest of payload, as expected by SignRequest.
+func PayloadHash(payload []byte) string {
+	digest := sha256.Sum256(payload)
+	return hex.EncodeToString(digest[:])
+}
+
+// SignRequest adds an AWS Signature Version 4 Authorization header for service in region, using credentials
+// at time now, and the headers it depends on, to req, which sends a payload with payloadHash (see PayloadHash).
+// All headers set in req at this point are signed.
+func SignRequest(req *http.Request, payloadHash, service, region string, credentials Credentials, now time.Time) {
+	amzDate := now.UTC().Format("20060102T150405Z")
+	req.Header.Set("X-Amz-Date", amzDate)
+	if credentials.SessionToken != "" {
+		req.Header.Set("X-Amz-Security-Token", credentials.SessionToken)
+	}
+
+	host := req.Host
+	if host == "" {
+		host = req.URL.Host
+	}
+	headers := map[string]string{"host": host}
+	for name, values := range req.Header {
+		headers[strings.ToLower(name)] = strings.TrimSpace(strings.Join(values, ","))
+	}
+	headerNames := make([]string, 0, len(headers))
+	for name := range headers {
+		headerNames = append(headerNames, name)
+	}
+	sort.Strings(headerNames)
+	canonicalHeaders := strings.Builder{}
+	for _, name := range headerNames {
+		canonicalHeaders.WriteString(name + ":" + headers[name] + "\n")
+	}
+	signedHeaders := strings.Join(headerNames, ";")
+
+	path := req.URL.EscapedPath()
+	if path == "" {
+		path = "/"
+	}
+	canonicalRequest := strings.Join([]string{
+		req.Method,
+		path,
+		canonicalQuery(req),
+		canonicalHeaders.String(),
+		signedHeaders,
+		payloadHash,
+	}, "\n")
+	canonicalRequestHash := sha256.Sum256([]byte(canonicalRequest))
+	scope := fmt.Sprintf("%s/%s/%s/aws4_request", amzDate[:8], region, service)
+	stringToSign := strings.Join([]string{"AWS4-HMAC-SHA256", amzDate, scope, hex.EncodeToString(canonicalRequestHash[:])}, "\n")
+
+	key := []byte("AWS4" + credentials.SecretAccessKey)
+	for _, data := range []string{amzDate[:8], region, service, "aws4_request"} {
+		key = hmacSHA256(key, data)
+	}
+	signature := hex.EncodeToString(hmacSHA256(key, stringToSign))
+	req.Header.Set("Authorization", fmt.Sprintf("AWS4-HMAC-SHA256 Credential=%s/%s, SignedHeaders=%s, Signature=%s",
+		credentials.AccessKeyID, scope, signedHeaders, signature))
+}
+
+// canonicalQuery returns the query of req in the canonical form: URI-encoded parameters, sorted by name and then value.
+func canonicalQuery(req *http.Request) string {
+	type param struct{ name, value string }
+	params := []param{}
+	for name, values := range req.URL.Query() {
+		for _, value := range values {
+			params = append(params, param{name: URIEncode(name, true), value: URIEncode(value, true)})
+		}
+	}
+	sort.Slice(params, func(i, j int) bool {
+		if params[i].name != params[j].name {
+			return params[i].name < params[j].name
+		}
+		return params[i].value < params[j].value
+	})
+	res := make([]string, 0, len(params))
+	for _, p := range params {
+		res = append(res, p.name+"="+p.value)
+	}
+	return strings.Join(res, "&")
+}
+
+// hmacSHA256 returns the HMAC-SHA256 of data using key.
+func hmacSHA256(key []byte, data string) []byte {
+	mac := hmac.New(sha256.New, key)
+	mac.Write([]byte(data))
+	return mac.Sum(nil)
+}
+
+// URIEncode returns s encoded as required for AWS Signature Version 4, i.e. with everything except unreserved
+// characters percent-encoded; "/" is encoded only if encodeSlash.
+func URIEncode(s string, encodeSlash bool) string {
+	res := strings.Builder{}
+	for _, c := range []byte(s) {
+		if ('A' <= c && c <= 'Z') || ('a' <= c && c <= 'z') || ('0' <= c && c <= '9') ||
+			c == '-' || c == '_' || c == '.' || c == '~' || (c == '/' && !encodeSlash) {
+			res.WriteByte(c)
+		} else {
+			fmt.Fprintf(&res, "%%%02X", c)
+		}
+	}
+	return res.String()
+}
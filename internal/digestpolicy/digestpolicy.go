@@ -0,0 +1,49 @@
+// Package digestpolicy enforces types.DigestPolicy.
+package digestpolicy
+
+import (
+	"github.com/containers/image/v5/types"
+	digest "github.com/opencontainers/go-digest"
+	"github.com/sirupsen/logrus"
+)
+
+// FromSystemContext returns the digest policy configured in sys, or nil if there is none.
+func FromSystemContext(sys *types.SystemContext) *types.DigestPolicy {
+	if sys == nil {
+		return nil
+	}
+	return sys.DigestPolicy
+}
+
+// CheckAlgorithm returns a types.DigestAlgorithmNotAllowedError if policy (which may be nil) does not allow
+// the algorithm of d.
+func CheckAlgorithm(policy *types.DigestPolicy, d digest.Digest) error {
+	if policy == nil || len(policy.AllowedAlgorithms) == 0 {
+		return nil
+	}
+	for _, a := range policy.AllowedAlgorithms {
+		if d.Algorithm() == a {
+			return nil
+		}
+	}
+	return types.DigestAlgorithmNotAllowedError{Digest: d, Allowed: policy.AllowedAlgorithms}
+}
+
+// ContentDigestMismatch handles a Docker-Content-Digest header which does not match the contents it was returned with,
+// described by err: it returns err if policy (which may be nil) requires a match, and only logs it otherwise.
+func ContentDigestMismatch(policy *types.DigestPolicy, err error) error {
+	if policy != nil && policy.RequireContentDigestMatch {
+		return err
+	}
+	logrus.Warnf("%v", err)
+	return nil
+}
+
+// ReportAccepted reports that contents of kind, in image (as returned by transports.ImageName), have been verified
+// against d, to policy.DigestAcceptedHandler, if any.
+func ReportAccepted(policy *types.DigestPolicy, image string, kind types.DigestKind, d digest.Digest) {
+	if policy == nil || policy.DigestAcceptedHandler == nil {
+		return
+	}
+	policy.DigestAcceptedHandler(types.DigestAcceptance{Image: image, Kind: kind, Digest: d})
+}
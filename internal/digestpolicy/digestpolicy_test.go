@@ -0,0 +1,58 @@
+package digestpolicy
+
+import (
+	"errors"
+	"testing"
+
+	"github.com/containers/image/v5/types"
+	digest "github.com/opencontainers/go-digest"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestFromSystemContext(t *testing.T) {
+	assert.Nil(t, FromSystemContext(nil))
+	assert.Nil(t, FromSystemContext(&types.SystemContext{}))
+	policy := &types.DigestPolicy{}
+	assert.Same(t, policy, FromSystemContext(&types.SystemContext{DigestPolicy: policy}))
+}
+
+func TestCheckAlgorithm(t *testing.T) {
+	sha256Digest := digest.Digest("sha256:0123456789abcdef0123456789abcdef0123456789abcdef0123456789abcdef")
+	sha512Digest := digest.SHA512.FromString("")
+	unknownDigest := digest.Digest("unknown:0123")
+
+	for _, policy := range []*types.DigestPolicy{nil, {}} {
+		for _, d := range []digest.Digest{sha256Digest, sha512Digest, unknownDigest} {
+			assert.NoError(t, CheckAlgorithm(policy, d))
+		}
+	}
+
+	policy := &types.DigestPolicy{AllowedAlgorithms: []digest.Algorithm{digest.SHA512}}
+	assert.NoError(t, CheckAlgorithm(policy, sha512Digest))
+	for _, d := range []digest.Digest{sha256Digest, unknownDigest} {
+		err := CheckAlgorithm(policy, d)
+		var notAllowed types.DigestAlgorithmNotAllowedError
+		assert.ErrorAs(t, err, &notAllowed)
+		assert.Equal(t, d, notAllowed.Digest)
+		assert.Equal(t, []digest.Algorithm{digest.SHA512}, notAllowed.Allowed)
+	}
+	assert.EqualError(t, CheckAlgorithm(policy, unknownDigest), `digest unknown:0123 uses algorithm "unknown", which is not allowed (allowed: sha512)`)
+}
+
+func TestContentDigestMismatch(t *testing.T) {
+	mismatch := errors.New("mismatch")
+	assert.NoError(t, ContentDigestMismatch(nil, mismatch))
+	assert.NoError(t, ContentDigestMismatch(&types.DigestPolicy{}, mismatch))
+	assert.Equal(t, mismatch, ContentDigestMismatch(&types.DigestPolicy{RequireContentDigestMatch: true}, mismatch))
+}
+
+func TestReportAccepted(t *testing.T) {
+	d := digest.FromString("")
+	ReportAccepted(nil, "docker://busybox", types.DigestKindBlob, d) // Does not crash
+	ReportAccepted(&types.DigestPolicy{}, "docker://busybox", types.DigestKindBlob, d)
+
+	reported := []types.DigestAcceptance{}
+	policy := &types.DigestPolicy{DigestAcceptedHandler: func(a types.DigestAcceptance) { reported = append(reported, a) }}
+	ReportAccepted(policy, "docker://busybox", types.DigestKindManifest, d)
+	assert.Equal(t, []types.DigestAcceptance{{Image: "docker://busybox", Kind: types.DigestKindManifest, Digest: d}}, reported)
+}
@@ -0,0 +1,50 @@
+// Package distributionclient allows pkg/docker/distribution to use the docker transport’s registry client,
+// which is not exported from the docker package.
+package distributionclient
+
+import (
+	"context"
+	"io"
+	"net/http"
+	"sync"
+
+	"github.com/containers/image/v5/types"
+)
+
+// Scope is an authorization scope for a single request, in addition to the scope of the client (if any).
+type Scope struct {
+	Repository string // The repository name, without the registry host name.
+	Actions    string // Comma-separated, e.g. "pull" or "pull,push".
+}
+
+// Client is a registry client for a single registry.
+type Client interface {
+	// Do performs an authenticated request for path (which usually starts with /v2/) on the registry,
+	// automatically detecting the URL scheme and performing the authentication handshake.
+	Do(ctx context.Context, method, path string, headers map[string][]string, body io.Reader, scope *Scope) (*http.Response, error)
+	// ResponseToError returns an error describing res, prefixed with context, if res is unsuccessful; nil otherwise.
+	ResponseToError(res *http.Response, context string) error
+}
+
+// NewFunc returns a Client for registry (a host[:port]), using credentials and options from sys.
+type NewFunc func(sys *types.SystemContext, registry string) (Client, error)
+
+var (
+	mu          sync.Mutex
+	constructor NewFunc
+)
+
+// Register records f as the implementation used by pkg/docker/distribution.
+// It is expected to be called from an init() function of the docker transport.
+func Register(f NewFunc) {
+	mu.Lock()
+	defer mu.Unlock()
+	constructor = f
+}
+
+// Get returns the registered implementation, or nil if none has been registered.
+func Get() NewFunc {
+	mu.Lock()
+	defer mu.Unlock()
+	return constructor
+}
@@ -40,6 +40,21 @@ const (
 	// MaxTarFileManifestSize is the maximum allowed size of a (docker save)-like manifest (which may contain multiple images)
 	// The limit of 1 MB is considered to be greatly sufficient.
 	MaxTarFileManifestSize = megaByte
+	// MaxRegistryExtensionBodySize is the maximum allowed size of a response of a registry API extension
+	// (e.g. extension discovery or search results).
+	// The limit of 4 MB is considered to be greatly sufficient.
+	MaxRegistryExtensionBodySize = 4 * megaByte
+	// MaxListPageBodySize is the maximum allowed size of a single page of a paginated registry list
+	// (e.g. tags or repositories).
+	// The limit of 4 MB is considered to be greatly sufficient.
+	MaxListPageBodySize = 4 * megaByte
+	// MaxPolicyBodySize is the maximum allowed size of a signature policy loaded from a remote source.
+	// The limit of 4 MB is considered to be greatly sufficient.
+	MaxPolicyBodySize = 4 * megaByte
+	// MaxTUFMetadataBodySize is the maximum allowed size of TUF metadata fetched from a Notary server.
+	//
+	// Large repositories can have many signed tags.
+	MaxTUFMetadataBodySize = 16 * megaByte
 )
 
 // ReadAtMost reads from reader and errors out if the specified limit (in bytes) is exceeded.
@@ -0,0 +1,116 @@
+package platform
+
+import (
+	"bufio"
+	"bytes"
+	"os"
+	"path/filepath"
+	"runtime"
+	"sort"
+	"strings"
+)
+
+// binfmtMiscDir is the directory where the Linux kernel exposes binfmt_misc registrations.
+const binfmtMiscDir = "/proc/sys/fs/binfmt_misc"
+
+// qemuArchitectures maps QEMU user-mode emulator targets (as used in the names of qemu-user binaries,
+// and of their binfmt_misc registrations) to GOARCH values.
+var qemuArchitectures = map[string]string{
+	"aarch64":     "arm64",
+	"arm":         "arm",
+	"i386":        "386",
+	"loongarch64": "loong64",
+	"mips":        "mips",
+	"mips64":      "mips64",
+	"mips64el":    "mips64le",
+	"mipsel":      "mipsle",
+	"ppc64":       "ppc64",
+	"ppc64le":     "ppc64le",
+	"riscv64":     "riscv64",
+	"s390x":       "s390x",
+	"x86_64":      "amd64",
+}
+
+// EmulatedArchitectures returns the architectures (GOARCH values) for which a QEMU user-mode emulator is registered,
+// and enabled, in binfmt_misc, sorted.  It returns an empty list on operating systems other than Linux,
+// or if binfmt_misc is not available.
+func EmulatedArchitectures() ([]string, error) {
+	if runtime.GOOS != "linux" {
+		return []string{}, nil
+	}
+	return emulatedArchitecturesInDir(binfmtMiscDir)
+}
+
+// emulatedArchitecturesInDir implements EmulatedArchitectures for a binfmt_misc filesystem mounted at dir.
+func emulatedArchitecturesInDir(dir string) ([]string, error) {
+	res := []string{}
+	status, err := os.ReadFile(filepath.Join(dir, "status"))
+	if err != nil {
+		if os.IsNotExist(err) {
+			return res, nil
+		}
+		return nil, err
+	}
+	if strings.TrimSpace(string(status)) != "enabled" {
+		return res, nil
+	}
+
+	entries, err := os.ReadDir(dir)
+	if err != nil {
+		return nil, err
+	}
+	seen := map[string]struct{}{}
+	for _, entry := range entries {
+		if entry.Name() == "status" || entry.Name() == "register" {
+			continue
+		}
+		contents, err := os.ReadFile(filepath.Join(dir, entry.Name()))
+		if err != nil {
+			return nil, err
+		}
+		arch, ok := binfmtEntryArchitecture(entry.Name(), contents)
+		if !ok {
+			continue
+		}
+		if _, ok := seen[arch]; !ok {
+			seen[arch] = struct{}{}
+			res = append(res, arch)
+		}
+	}
+	sort.Strings(res)
+	return res, nil
+}
+
+// binfmtEntryArchitecture returns the architecture emulated by an enabled binfmt_misc registration with name and contents,
+// if it is a QEMU user-mode emulator.
+func binfmtEntryArchitecture(name string, contents []byte) (string, bool) {
+	enabled := false
+	interpreter := ""
+	scanner := bufio.NewScanner(bytes.NewReader(contents))
+	for scanner.Scan() {
+		line := strings.TrimSpace(scanner.Text())
+		switch {
+		case line == "enabled":
+			enabled = true
+		case strings.HasPrefix(line, "interpreter "):
+			interpreter = strings.TrimSpace(strings.TrimPrefix(line, "interpreter "))
+		}
+	}
+	if !enabled {
+		return "", false
+	}
+	// Prefer the interpreter name, the registration name is arbitrary; but the interpreter may be a wrapper.
+	for _, candidate := range []string{filepath.Base(interpreter), name} {
+		target := strings.TrimPrefix(candidate, "qemu-")
+		if target == candidate {
+			continue
+		}
+		for _, suffix := range []string{"-static", "-binfmt"} {
+			target = strings.TrimSuffix(target, suffix)
+		}
+		if arch, ok := qemuArchitectures[target]; ok {
+			return arch, true
+		}
+	}
+	return "", false
+}
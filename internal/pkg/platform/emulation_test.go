@@ -0,0 +1,48 @@
+package platform
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestEmulatedArchitecturesInDir(t *testing.T) {
+	writeEntries := func(t *testing.T, entries map[string]string) string {
+		dir := t.TempDir()
+		for name, contents := range entries {
+			err := os.WriteFile(filepath.Join(dir, name), []byte(contents), 0600)
+			require.NoError(t, err)
+		}
+		return dir
+	}
+
+	// binfmt_misc not mounted
+	res, err := emulatedArchitecturesInDir(filepath.Join(t.TempDir(), "thisdoesnotexist"))
+	require.NoError(t, err)
+	assert.Empty(t, res)
+
+	entries := map[string]string{
+		"status":   "enabled\n",
+		"register": "",
+		"qemu-aarch64": "enabled\ninterpreter /usr/bin/qemu-aarch64-static\nflags: F\noffset 0\n" +
+			"magic 7f454c460201010000000000000000000200b700\n",
+		"qemu-arm":        "enabled\ninterpreter /usr/bin/qemu-arm\nflags: F\n",
+		"qemu-s390x":      "disabled\ninterpreter /usr/bin/qemu-s390x-static\nflags: F\n",
+		"custom-ppc64le":  "enabled\ninterpreter /usr/libexec/qemu-binfmt/ppc64le-binfmt-P\nflags: POCF\n",
+		"qemu-riscv64":    "enabled\ninterpreter /usr/libexec/qemu-binfmt/riscv64-binfmt-P\nflags: POCF\n",
+		"qemu-arm-static": "enabled\ninterpreter /usr/bin/qemu-arm-static\nflags: F\n",
+		"python3.10":      "enabled\ninterpreter /usr/bin/python3.10\nflags: \n",
+		"qemu-unknown":    "enabled\ninterpreter /usr/bin/qemu-unknown\nflags: F\n",
+	}
+	res, err = emulatedArchitecturesInDir(writeEntries(t, entries))
+	require.NoError(t, err)
+	assert.Equal(t, []string{"arm", "arm64", "riscv64"}, res)
+
+	entries["status"] = "disabled\n"
+	res, err = emulatedArchitecturesInDir(writeEntries(t, entries))
+	require.NoError(t, err)
+	assert.Empty(t, res)
+}
@@ -148,6 +148,17 @@ func WantedPlatforms(ctx *types.SystemContext) ([]imgspecv1.Platform, error) {
 		wantedOS = ctx.OSChoice
 	}
 
+	return CompatiblePlatforms(wantedOS, wantedArch, wantedVariant), nil
+}
+
+// HostVariant returns the CPU variant of the current host, or "" if it is unknown or the architecture has no variants.
+func HostVariant() string {
+	return getCPUVariant(runtime.GOOS, runtime.GOARCH)
+}
+
+// CompatiblePlatforms returns all platforms compatible with wantedOS, wantedArch and wantedVariant
+// (which may be "" if unknown), the most compatible platform first.
+func CompatiblePlatforms(wantedOS, wantedArch, wantedVariant string) []imgspecv1.Platform {
 	var variants []string = nil
 	if wantedVariant != "" {
 		// If the user requested a specific variant, we'll walk down
@@ -184,7 +195,7 @@ func WantedPlatforms(ctx *types.SystemContext) ([]imgspecv1.Platform, error) {
 			Variant:      v,
 		})
 	}
-	return res, nil
+	return res
 }
 
 // MatchesPlatform returns true if a platform descriptor from a multi-arch image matches
@@ -3,9 +3,14 @@ package private
 import (
 	"context"
 	"io"
+	"time"
 
 	"github.com/containers/image/v5/docker/reference"
+	"github.com/containers/image/v5/manifest"
+	"github.com/containers/image/v5/signature/sigstore"
 	"github.com/containers/image/v5/types"
+	"github.com/opencontainers/go-digest"
+	imgspecv1 "github.com/opencontainers/image-spec/specs-go/v1"
 )
 
 // ImageSource is an internal extension to the types.ImageSource interface.
@@ -18,6 +23,71 @@ type ImageSource interface {
 	BlobChunkAccessor
 }
 
+// MirrorFailoverReporter is an optional interface of image sources which can access an image via several
+// locations (e.g. registry mirrors), and fall back to later ones if earlier ones fail.
+type MirrorFailoverReporter interface {
+	// FailedPullSources returns human-readable descriptions of the locations which were tried, and failed,
+	// before the image source was successfully opened, in the order they were tried; nil if the first location was used.
+	FailedPullSources() []string
+}
+
+// ReferrersFallbackReporter is an optional interface of image sources and destinations which access referrers
+// using the OCI distribution-spec referrers API if possible, and fall back to the referrers tag schema otherwise.
+type ReferrersFallbackReporter interface {
+	// UsedReferrersFallback returns true if referrers were listed or stored so far using the referrers tag schema,
+	// because the registry does not support the referrers API.
+	UsedReferrersFallback() bool
+}
+
+// AuthenticationDurationReporter is an optional interface of image sources and destinations which authenticate
+// to a remote server, e.g. a registry.
+type AuthenticationDurationReporter interface {
+	// AuthenticationDuration returns the total time spent so far looking up credentials and authenticating,
+	// e.g. obtaining bearer tokens.
+	AuthenticationDuration() time.Duration
+}
+
+// ManifestTagger is an optional interface of image destinations which can make an already written manifest
+// available under additional tags, e.g. in the same registry repository.
+type ManifestTagger interface {
+	// PutManifestTag writes manifest, which has already been written using PutManifest (typically with a nil instanceDigest,
+	// or with an instanceDigest for artifacts such as cosign signatures), under tag.
+	PutManifestTag(ctx context.Context, manifest []byte, tag string) error
+}
+
+// ReferrersLister is an optional interface of image sources which can list manifests referring to a manifest
+// using the image-spec v1.1 subject field, e.g. signatures, SBOMs and attestations.
+type ReferrersLister interface {
+	// GetReferrers returns the manifests which refer to the manifest with manifestDigest, optionally only those with
+	// artifactType (if not "").
+	GetReferrers(ctx context.Context, manifestDigest digest.Digest, artifactType string) ([]manifest.OCI1Referrer, error)
+}
+
+// TaggedManifestReader is an optional interface of image sources and destinations which can read other manifests
+// in the same repository by tag, e.g. artifacts stored by cosign under tags derived from the digest of the image they refer to.
+type TaggedManifestReader interface {
+	// GetManifestForTag returns the manifest tagged with tag in the same repository, and its MIME type if known,
+	// or a nil manifest if the tag does not exist.
+	GetManifestForTag(ctx context.Context, tag string) ([]byte, string, error)
+}
+
+// SigstoreSignaturesReader is an optional interface of types.UnparsedImage implementations which can read
+// sigstore signatures of the image, stored using the cosign tag scheme.
+type SigstoreSignaturesReader interface {
+	// UntrustedSigstoreSignatures returns the sigstore signatures of the image, or an empty list if there are none.
+	// The signatures are not verified in any way.
+	UntrustedSigstoreSignatures(ctx context.Context) ([]sigstore.Signature, error)
+}
+
+// PlatformReader is an optional interface of types.UnparsedImage implementations which can determine
+// the platform an instance of a manifest list was chosen for.
+type PlatformReader interface {
+	// UntrustedPlatform returns the platform recorded for the image in the manifest list it is an instance of,
+	// or nil if the image was not chosen from a manifest list, or if the platform is not known.
+	// The value is only as trustworthy as the manifest list: it is NOT verified by any signature.
+	UntrustedPlatform(ctx context.Context) (*imgspecv1.Platform, error)
+}
+
 // ImageDestination is an internal extension to the types.ImageDestination
 // interface.
 type ImageDestination interface {
@@ -0,0 +1,69 @@
+package secret
+
+import (
+	"fmt"
+	"io"
+)
+
+// redacted is the text used instead of non-empty secret values.
+const redacted = "<redacted>"
+
+// Secret is a sensitive value, e.g. a password or an identity token.
+// Formatting a Secret using fmt (with any verb, including %#v), or as JSON, never includes the value; use Reveal to obtain it.
+// The value is stored behind a pointer, so that even formatting a struct which contains a Secret in an
+// unexported field (where fmt does not use the methods of Secret) does not include the value.
+// The zero value is an empty secret.
+type Secret struct {
+	value *string // nil for an empty secret
+}
+
+// New returns a Secret with value.
+func New(value string) Secret {
+	if value == "" {
+		return Secret{}
+	}
+	return Secret{value: &value}
+}
+
+// Reveal returns the value of s.
+func (s Secret) Reveal() string {
+	if s.value == nil {
+		return ""
+	}
+	return *s.value
+}
+
+// IsEmpty returns true if the value of s is "".
+func (s Secret) IsEmpty() bool {
+	return s.value == nil
+}
+
+// String implements fmt.Stringer; it never returns the value of s.
+func (s Secret) String() string {
+	if s.IsEmpty() {
+		return ""
+	}
+	return redacted
+}
+
+// GoString implements fmt.GoStringer; it never returns the value of s.
+func (s Secret) GoString() string {
+	return fmt.Sprintf("secret.Secret(%q)", s.String())
+}
+
+// Format implements fmt.Formatter; it never includes the value of s.
+func (s Secret) Format(f fmt.State, verb rune) {
+	switch {
+	case verb == 'v' && f.Flag('#'):
+		_, _ = io.WriteString(f, s.GoString())
+	case verb == 'q':
+		_, _ = fmt.Fprintf(f, "%q", s.String())
+	default:
+		_, _ = io.WriteString(f, s.String())
+	}
+}
+
+// MarshalText implements encoding.TextMarshaler; it never returns the value of s.
+func (s Secret) MarshalText() ([]byte, error) {
+	return []byte(s.String()), nil
+}
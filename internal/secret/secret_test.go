@@ -0,0 +1,45 @@
+package secret
+
+import (
+	"encoding/json"
+	"fmt"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestSecret(t *testing.T) {
+	const value = "hunter2"
+	s := New(value)
+	assert.Equal(t, value, s.Reveal())
+	assert.False(t, s.IsEmpty())
+
+	type withSecrets struct {
+		Exported   Secret
+		unexported Secret
+	}
+	for _, formatted := range []string{
+		s.String(),
+		s.GoString(),
+		fmt.Sprint(s),
+		fmt.Sprintf("%v %+v %#v %s %q %x %d", s, s, s, s, s, s, s),
+		fmt.Sprintf("%v %+v %#v", withSecrets{s, s}, withSecrets{s, s}, withSecrets{s, s}),
+		fmt.Sprintf("%v %#v", &s, []Secret{s}),
+	} {
+		assert.NotContains(t, formatted, value)
+	}
+	assert.Equal(t, "<redacted>", fmt.Sprintf("%v", s))
+	assert.Equal(t, `"<redacted>"`, fmt.Sprintf("%q", s))
+
+	data, err := json.Marshal(withSecrets{Exported: s})
+	require.NoError(t, err)
+	assert.JSONEq(t, `{"Exported":"<redacted>"}`, string(data))
+
+	// Empty values
+	for _, empty := range []Secret{{}, New("")} {
+		assert.True(t, empty.IsEmpty())
+		assert.Equal(t, "", empty.Reveal())
+		assert.Equal(t, "", fmt.Sprint(empty))
+	}
+}
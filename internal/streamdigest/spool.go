@@ -0,0 +1,144 @@
+package streamdigest
+
+import (
+	"bytes"
+	"fmt"
+	"io"
+	"os"
+	"sync"
+
+	"github.com/containers/image/v5/internal/tmpdir"
+	"github.com/containers/image/v5/types"
+	"github.com/sirupsen/logrus"
+)
+
+// defaultSpoolMemoryLimit is the default value of types.SystemContext.BlobSpoolMemoryLimit.
+const defaultSpoolMemoryLimit = int64(256 * 1024 * 1024)
+
+// memoryAccounting tracks the total size of in-memory spool buffers in the process.
+type memoryAccounting struct {
+	mutex sync.Mutex
+	used  int64
+}
+
+// spoolMemory is the accounting of all spools in the process.
+var spoolMemory memoryAccounting
+
+// reserve records n more bytes of memory in use and returns true, if that keeps the total within limit;
+// otherwise it returns false and records nothing.
+func (a *memoryAccounting) reserve(n, limit int64) bool {
+	a.mutex.Lock()
+	defer a.mutex.Unlock()
+	if a.used+n > limit {
+		return false
+	}
+	a.used += n
+	return true
+}
+
+// release records that n bytes of memory, previously reserved, are no longer in use.
+func (a *memoryAccounting) release(n int64) {
+	a.mutex.Lock()
+	defer a.mutex.Unlock()
+	a.used -= n
+}
+
+// inUse returns the total size of memory currently reserved.
+func (a *memoryAccounting) inUse() int64 {
+	a.mutex.Lock()
+	defer a.mutex.Unlock()
+	return a.used
+}
+
+// spool is an io.Writer which stores data in memory, up to the limits configured in a types.SystemContext,
+// and then moves it to a temporary file.
+type spool struct {
+	sys             *types.SystemContext
+	memoryThreshold int64
+	memoryLimit     int64
+	accounting      *memoryAccounting
+
+	memory   bytes.Buffer // Used as long as file is nil
+	reserved int64        // Memory reserved in accounting for memory
+	file     *os.File     // Set after spilling to disk
+}
+
+// newSpool returns a spool configured by sys, using accounting.
+// The caller must call spool.close when it is no longer needed.
+func newSpool(sys *types.SystemContext, accounting *memoryAccounting) *spool {
+	s := &spool{
+		sys:         sys,
+		memoryLimit: defaultSpoolMemoryLimit,
+		accounting:  accounting,
+	}
+	if sys != nil {
+		s.memoryThreshold = sys.BlobSpoolMemoryThreshold
+		if sys.BlobSpoolMemoryLimit > 0 {
+			s.memoryLimit = sys.BlobSpoolMemoryLimit
+		}
+	}
+	return s
+}
+
+// Write implements io.Writer.
+func (s *spool) Write(p []byte) (int, error) {
+	if s.file == nil {
+		n := int64(len(p))
+		if int64(s.memory.Len())+n <= s.memoryThreshold && s.accounting.reserve(n, s.memoryLimit) {
+			s.reserved += n
+			return s.memory.Write(p)
+		}
+		if err := s.spillToDisk(); err != nil {
+			return 0, err
+		}
+	}
+	return s.file.Write(p)
+}
+
+// spillToDisk moves the data buffered in memory to a temporary file, which is used for all further data.
+func (s *spool) spillToDisk() error {
+	file, err := os.CreateTemp(tmpdir.TemporaryDirectoryForBigFiles(s.sys), "stream-blob")
+	if err != nil {
+		return fmt.Errorf("creating temporary on-disk layer: %w", err)
+	}
+	if s.memory.Len() != 0 {
+		logrus.Debugf("Spooled blob exceeds %d bytes of memory, moving it to %s", s.memory.Len(), file.Name())
+	}
+	if _, err := file.Write(s.memory.Bytes()); err != nil {
+		file.Close()
+		os.Remove(file.Name())
+		return fmt.Errorf("writing to temporary on-disk layer: %w", err)
+	}
+	s.file = file
+	s.releaseMemory()
+	return nil
+}
+
+// releaseMemory drops the in-memory buffer.
+func (s *spool) releaseMemory() {
+	s.memory = bytes.Buffer{}
+	s.accounting.release(s.reserved)
+	s.reserved = 0
+}
+
+// reader returns an io.Reader for all data written to s.
+// s must not be written to after calling this.
+func (s *spool) reader() (io.Reader, error) {
+	if s.file == nil {
+		return bytes.NewReader(s.memory.Bytes()), nil
+	}
+	if _, err := s.file.Seek(0, io.SeekStart); err != nil {
+		return nil, fmt.Errorf("rewinding temporary on-disk layer: %w", err)
+	}
+	return s.file, nil
+}
+
+// close releases all resources used by s, including the data returned by reader().
+func (s *spool) close() {
+	if s.file != nil {
+		s.file.Close()
+		os.Remove(s.file.Name())
+		s.file = nil
+	}
+	s.releaseMemory()
+}
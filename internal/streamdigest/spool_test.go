@@ -0,0 +1,100 @@
+package streamdigest
+
+import (
+	"bytes"
+	"io"
+	"os"
+	"testing"
+
+	"github.com/containers/image/v5/types"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// writeToSpool writes data to a new spool for sys, using accounting, in chunks of chunkSize.
+func writeToSpool(t *testing.T, sys *types.SystemContext, accounting *memoryAccounting, data []byte, chunkSize int) *spool {
+	s := newSpool(sys, accounting)
+	for len(data) > 0 {
+		n := chunkSize
+		if n > len(data) {
+			n = len(data)
+		}
+		written, err := s.Write(data[:n])
+		require.NoError(t, err)
+		require.Equal(t, n, written)
+		data = data[n:]
+	}
+	return s
+}
+
+// assertSpoolContents verifies that s contains data.
+func assertSpoolContents(t *testing.T, s *spool, data []byte) {
+	reader, err := s.reader()
+	require.NoError(t, err)
+	contents, err := io.ReadAll(reader)
+	require.NoError(t, err)
+	assert.Equal(t, data, contents)
+}
+
+func TestSpool(t *testing.T) {
+	data := bytes.Repeat([]byte("0123456789"), 10)
+
+	// No memory threshold: always on disk
+	accounting := memoryAccounting{}
+	tmpDir := t.TempDir()
+	s := writeToSpool(t, &types.SystemContext{BigFilesTemporaryDir: tmpDir}, &accounting, data, 7)
+	require.NotNil(t, s.file)
+	assert.Equal(t, int64(0), accounting.inUse())
+	assertSpoolContents(t, s, data)
+	fileName := s.file.Name()
+	s.close()
+	_, err := os.Stat(fileName)
+	assert.True(t, os.IsNotExist(err))
+
+	// Small enough to stay in memory
+	sys := &types.SystemContext{BigFilesTemporaryDir: tmpDir, BlobSpoolMemoryThreshold: 100}
+	s = writeToSpool(t, sys, &accounting, data, 7)
+	assert.Nil(t, s.file)
+	assert.Equal(t, int64(100), accounting.inUse())
+	assertSpoolContents(t, s, data)
+	s.close()
+	assert.Equal(t, int64(0), accounting.inUse())
+
+	// Exceeding the threshold moves the data to disk, and releases the memory
+	sys.BlobSpoolMemoryThreshold = 50
+	s = writeToSpool(t, sys, &accounting, data, 7)
+	require.NotNil(t, s.file)
+	assert.Equal(t, int64(0), accounting.inUse())
+	assertSpoolContents(t, s, data)
+	s.close()
+
+	// Memory in use by other spools counts towards the limit
+	sys.BlobSpoolMemoryThreshold = 100
+	sys.BlobSpoolMemoryLimit = 150
+	s1 := writeToSpool(t, sys, &accounting, data, 7)
+	defer s1.close()
+	assert.Nil(t, s1.file)
+	s2 := writeToSpool(t, sys, &accounting, data, 7)
+	defer s2.close()
+	require.NotNil(t, s2.file)
+	assert.Equal(t, int64(100), accounting.inUse())
+	assertSpoolContents(t, s1, data)
+	assertSpoolContents(t, s2, data)
+	s1.close()
+	assert.Equal(t, int64(0), accounting.inUse())
+}
+
+func TestComputeBlobInfoInMemory(t *testing.T) {
+	data := []byte("Hello")
+	inputInfo := types.BlobInfo{Digest: "", Size: -1}
+	sys := &types.SystemContext{BlobSpoolMemoryThreshold: 1024}
+	streamCopy, cleanup, err := ComputeBlobInfo(sys, bytes.NewReader(data), &inputInfo)
+	require.NoError(t, err)
+	assert.Equal(t, types.BlobInfo{Digest: "sha256:185f8db32271fe25f561a6fc938b2e264306ec304eda518007d1764826381969", Size: 5}, inputInfo)
+	assert.Equal(t, int64(5), spoolMemory.inUse())
+	b, err := io.ReadAll(streamCopy)
+	require.NoError(t, err)
+	assert.Equal(t, data, b)
+	cleanup()
+	assert.Equal(t, int64(0), spoolMemory.inUse())
+}
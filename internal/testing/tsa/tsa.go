@@ -0,0 +1,290 @@
+// Package tsa is a TESTING-ONLY fake RFC 3161 timestamp authority.
+//
+// It encodes timestamps independently of signature/sigstore, so that tests of that package don't just verify
+// that its parser matches its own encoder.
+//
+// NEVER use this in non-testing subpackages!
+package tsa
+
+import (
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/sha256"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/asn1"
+	"encoding/pem"
+	"io"
+	"math/big"
+	"net/http"
+	"time"
+)
+
+var (
+	oidSignedData             = asn1.ObjectIdentifier{1, 2, 840, 113549, 1, 7, 2}
+	oidTSTInfo                = asn1.ObjectIdentifier{1, 2, 840, 113549, 1, 9, 16, 1, 4}
+	oidAttributeContentType   = asn1.ObjectIdentifier{1, 2, 840, 113549, 1, 9, 3}
+	oidAttributeMessageDigest = asn1.ObjectIdentifier{1, 2, 840, 113549, 1, 9, 4}
+	oidSHA256                 = asn1.ObjectIdentifier{2, 16, 840, 1, 101, 3, 4, 2, 1}
+	oidECDSAWithSHA256        = asn1.ObjectIdentifier{1, 2, 840, 10045, 4, 3, 2}
+	oidTestPolicy             = asn1.ObjectIdentifier{1, 3, 6, 1, 4, 1, 99999, 1}
+)
+
+type messageImprint struct {
+	HashAlgorithm pkix.AlgorithmIdentifier
+	HashedMessage []byte
+}
+
+type request struct {
+	Version        int
+	MessageImprint messageImprint
+	ReqPolicy      asn1.ObjectIdentifier `asn1:"optional"`
+	Nonce          *big.Int              `asn1:"optional"`
+	CertReq        bool                  `asn1:"optional,default:false"`
+}
+
+type statusInfo struct {
+	Status int
+}
+
+type response struct {
+	Status         statusInfo
+	TimeStampToken asn1.RawValue `asn1:"optional"`
+}
+
+type tstInfo struct {
+	Version        int
+	Policy         asn1.ObjectIdentifier
+	MessageImprint messageImprint
+	SerialNumber   *big.Int
+	GenTime        time.Time `asn1:"generalized"`
+	Nonce          *big.Int  `asn1:"optional"`
+}
+
+type contentInfo struct {
+	ContentType asn1.ObjectIdentifier
+	Content     asn1.RawValue // [0] EXPLICIT; encoding/asn1 ignores the tag parameters of RawValue fields when marshaling
+}
+
+type signedData struct {
+	Version          int
+	DigestAlgorithms []pkix.AlgorithmIdentifier `asn1:"set"`
+	EncapContentInfo encapsulatedContentInfo
+	Certificates     asn1.RawValue `asn1:"optional,tag:0"`
+	SignerInfos      []signerInfo  `asn1:"set"`
+}
+
+type encapsulatedContentInfo struct {
+	EContentType asn1.ObjectIdentifier
+	EContent     []byte `asn1:"explicit,tag:0"`
+}
+
+type signerInfo struct {
+	Version            int
+	SID                asn1.RawValue
+	DigestAlgorithm    pkix.AlgorithmIdentifier
+	SignedAttrs        asn1.RawValue
+	SignatureAlgorithm pkix.AlgorithmIdentifier
+	Signature          []byte
+}
+
+type issuerAndSerialNumber struct {
+	Issuer       asn1.RawValue
+	SerialNumber *big.Int
+}
+
+type attribute struct {
+	Type   asn1.ObjectIdentifier
+	Values []asn1.RawValue `asn1:"set"`
+}
+
+// Authority is a fake timestamp authority, with a self-signed CA certificate, and a timestamping certificate issued by the CA.
+// Both certificates are valid from a day before the time passed to New until a day after.
+type Authority struct {
+	CACertificate *x509.Certificate
+	CAPEM         []byte            // The PEM encoding of CACertificate
+	Certificate   *x509.Certificate // The timestamping certificate
+	key           *ecdsa.PrivateKey
+	// Time is the time of timestamps.
+	Time time.Time
+	// Options modify the responses of ServeHTTP.
+	Options TokenOptions
+}
+
+// TokenOptions modify timestamps, to test handling of invalid timestamps.
+type TokenOptions struct {
+	OmitCertificates bool // Don't include the timestamping certificate in the timestamp
+	ModifyContent    bool // Modify the timestamped data after signing it
+	WrongNonce       bool // ServeHTTP: Use a nonce different from the one in the request
+	Reject           bool // ServeHTTP: Reject requests, with a valid response
+}
+
+// New returns a new Authority, issuing timestamps at now, with certificate extended key usages extKeyUsage
+// (normally only x509.ExtKeyUsageTimeStamping).
+func New(now time.Time, extKeyUsage ...x509.ExtKeyUsage) (*Authority, error) {
+	caKey, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		return nil, err
+	}
+	caTemplate := &x509.Certificate{
+		SerialNumber:          big.NewInt(1),
+		Subject:               pkix.Name{CommonName: "Test TSA CA"},
+		NotBefore:             now.Add(-24 * time.Hour),
+		NotAfter:              now.Add(24 * time.Hour),
+		IsCA:                  true,
+		BasicConstraintsValid: true,
+		KeyUsage:              x509.KeyUsageCertSign,
+	}
+	caDER, err := x509.CreateCertificate(rand.Reader, caTemplate, caTemplate, &caKey.PublicKey, caKey)
+	if err != nil {
+		return nil, err
+	}
+	ca, err := x509.ParseCertificate(caDER)
+	if err != nil {
+		return nil, err
+	}
+
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		return nil, err
+	}
+	der, err := x509.CreateCertificate(rand.Reader, &x509.Certificate{
+		SerialNumber: big.NewInt(2),
+		Subject:      pkix.Name{CommonName: "Test TSA"},
+		NotBefore:    now.Add(-24 * time.Hour),
+		NotAfter:     now.Add(24 * time.Hour),
+		KeyUsage:     x509.KeyUsageDigitalSignature,
+		ExtKeyUsage:  extKeyUsage,
+	}, ca, &key.PublicKey, caKey)
+	if err != nil {
+		return nil, err
+	}
+	cert, err := x509.ParseCertificate(der)
+	if err != nil {
+		return nil, err
+	}
+	return &Authority{
+		CACertificate: ca,
+		CAPEM:         pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: caDER}),
+		Certificate:   cert,
+		key:           key,
+		Time:          now,
+	}, nil
+}
+
+// Token returns a DER-encoded TimeStampToken of data.
+func (a *Authority) Token(data []byte, options TokenOptions) ([]byte, error) {
+	digest := sha256.Sum256(data)
+	return a.token(messageImprint{
+		HashAlgorithm: pkix.AlgorithmIdentifier{Algorithm: oidSHA256, Parameters: asn1.NullRawValue},
+		HashedMessage: digest[:],
+	}, nil, options)
+}
+
+// token returns a DER-encoded TimeStampToken of imprint, with nonce.
+func (a *Authority) token(imprint messageImprint, nonce *big.Int, options TokenOptions) ([]byte, error) {
+	content, err := asn1.Marshal(tstInfo{
+		Version:        1,
+		Policy:         oidTestPolicy,
+		MessageImprint: imprint,
+		SerialNumber:   big.NewInt(a.Time.UnixNano()),
+		GenTime:        a.Time.UTC().Truncate(time.Second),
+		Nonce:          nonce,
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	contentDigest := sha256.Sum256(content)
+	contentTypeValue, err := asn1.Marshal(oidTSTInfo)
+	if err != nil {
+		return nil, err
+	}
+	messageDigestValue, err := asn1.Marshal(contentDigest[:])
+	if err != nil {
+		return nil, err
+	}
+	attrs, err := asn1.MarshalWithParams([]attribute{
+		{Type: oidAttributeContentType, Values: []asn1.RawValue{{FullBytes: contentTypeValue}}},
+		{Type: oidAttributeMessageDigest, Values: []asn1.RawValue{{FullBytes: messageDigestValue}}},
+	}, "set")
+	if err != nil {
+		return nil, err
+	}
+	attrsDigest := sha256.Sum256(attrs)
+	signature, err := ecdsa.SignASN1(rand.Reader, a.key, attrsDigest[:])
+	if err != nil {
+		return nil, err
+	}
+	signedAttrs := append([]byte{}, attrs...)
+	signedAttrs[0] = 0xA0 // [0] IMPLICIT, constructed
+
+	sid, err := asn1.Marshal(issuerAndSerialNumber{
+		Issuer:       asn1.RawValue{FullBytes: a.Certificate.RawIssuer},
+		SerialNumber: a.Certificate.SerialNumber,
+	})
+	if err != nil {
+		return nil, err
+	}
+	if options.ModifyContent {
+		content[len(content)-1] ^= 1
+	}
+	sd := signedData{
+		Version:          3,
+		DigestAlgorithms: []pkix.AlgorithmIdentifier{{Algorithm: oidSHA256}},
+		EncapContentInfo: encapsulatedContentInfo{EContentType: oidTSTInfo, EContent: content},
+		SignerInfos: []signerInfo{{
+			Version:            1,
+			SID:                asn1.RawValue{FullBytes: sid},
+			DigestAlgorithm:    pkix.AlgorithmIdentifier{Algorithm: oidSHA256},
+			SignedAttrs:        asn1.RawValue{FullBytes: signedAttrs},
+			SignatureAlgorithm: pkix.AlgorithmIdentifier{Algorithm: oidECDSAWithSHA256},
+			Signature:          signature,
+		}},
+	}
+	if !options.OmitCertificates {
+		sd.Certificates = asn1.RawValue{Class: asn1.ClassContextSpecific, Tag: 0, IsCompound: true, Bytes: a.Certificate.Raw}
+	}
+	sdDER, err := asn1.Marshal(sd)
+	if err != nil {
+		return nil, err
+	}
+	return asn1.Marshal(contentInfo{ContentType: oidSignedData, Content: asn1.RawValue{Class: asn1.ClassContextSpecific, Tag: 0, IsCompound: true, Bytes: sdDER}})
+}
+
+// ServeHTTP responds to timestamp requests, as modified by a.Options.
+func (a *Authority) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	body, err := io.ReadAll(r.Body)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	var req request
+	if rest, err := asn1.Unmarshal(body, &req); err != nil || len(rest) != 0 || r.Header.Get("Content-Type") != "application/timestamp-query" {
+		http.Error(w, "invalid request", http.StatusBadRequest)
+		return
+	}
+	var res response
+	if a.Options.Reject {
+		res.Status.Status = 2 // rejection
+	} else {
+		nonce := req.Nonce
+		if a.Options.WrongNonce {
+			nonce = new(big.Int).Add(nonce, big.NewInt(1))
+		}
+		token, err := a.token(req.MessageImprint, nonce, a.Options)
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusInternalServerError)
+			return
+		}
+		res.TimeStampToken = asn1.RawValue{FullBytes: token}
+	}
+	resDER, err := asn1.Marshal(res)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	w.Header().Set("Content-Type", "application/timestamp-reply")
+	_, _ = w.Write(resDER)
+}
@@ -0,0 +1,66 @@
+// Package tracing wraps the OpenTelemetry tracing API for use within c/image.
+// Tracing is disabled unless the caller provides a trace.TracerProvider, e.g. in copy.Options.TracerProvider.
+package tracing
+
+import (
+	"context"
+	"time"
+
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/codes"
+	"go.opentelemetry.io/otel/trace"
+)
+
+// instrumentationName identifies the tracer used for spans created by c/image.
+const instrumentationName = "github.com/containers/image/v5"
+
+// noopSpan is a span which records nothing.
+var noopSpan = trace.SpanFromContext(context.Background())
+
+// providerKey is the context.Context key used by WithProvider.
+type providerKey struct{}
+
+// WithProvider returns a context in which operations which are not given a trace.TracerProvider directly
+// (e.g. registry requests and lookups of registry credentials) are traced using tp.
+// If tp is nil, ctx is returned unmodified.
+func WithProvider(ctx context.Context, tp trace.TracerProvider) context.Context {
+	if tp == nil {
+		return ctx
+	}
+	return context.WithValue(ctx, providerKey{}, tp)
+}
+
+// Provider returns the trace.TracerProvider set in ctx by WithProvider, or nil if tracing is disabled.
+func Provider(ctx context.Context) trace.TracerProvider {
+	tp, _ := ctx.Value(providerKey{}).(trace.TracerProvider)
+	return tp
+}
+
+// Start starts a span with name and attrs as a child of the span in ctx (if any), using tp, and returns
+// a context containing the new span.
+// If tp is nil, tracing is disabled: Start returns ctx unmodified and a span which records nothing.
+func Start(ctx context.Context, tp trace.TracerProvider, name string, attrs ...attribute.KeyValue) (context.Context, trace.Span) {
+	if tp == nil {
+		return ctx, noopSpan
+	}
+	return tp.Tracer(instrumentationName).Start(ctx, name, trace.WithAttributes(attrs...))
+}
+
+// Record records a span with name and attrs as a child of the span in ctx (if any), using tp, for an operation which
+// started at start, ended now, and failed with err (if not nil).  It does nothing if tp is nil.
+func Record(ctx context.Context, tp trace.TracerProvider, name string, start time.Time, err error, attrs ...attribute.KeyValue) {
+	if tp == nil {
+		return
+	}
+	_, span := tp.Tracer(instrumentationName).Start(ctx, name, trace.WithTimestamp(start), trace.WithAttributes(attrs...))
+	End(span, err)
+}
+
+// End ends span, marking it as failed if err is not nil.
+func End(span trace.Span, err error) {
+	if err != nil {
+		span.RecordError(err)
+		span.SetStatus(codes.Error, err.Error())
+	}
+	span.End()
+}
@@ -0,0 +1,55 @@
+package tracing
+
+import (
+	"context"
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/codes"
+	sdktrace "go.opentelemetry.io/otel/sdk/trace"
+	"go.opentelemetry.io/otel/sdk/trace/tracetest"
+)
+
+func TestProvider(t *testing.T) {
+	ctx := context.Background()
+	assert.Nil(t, Provider(ctx))
+	assert.Equal(t, ctx, WithProvider(ctx, nil))
+	tp := sdktrace.NewTracerProvider()
+	assert.Equal(t, tp, Provider(WithProvider(ctx, tp)))
+}
+
+func TestStart(t *testing.T) {
+	recorder := tracetest.NewSpanRecorder()
+	tp := sdktrace.NewTracerProvider(sdktrace.WithSpanProcessor(recorder))
+	ctx, parent := tp.Tracer("test").Start(context.Background(), "parent")
+
+	// Tracing disabled: the parent span is not modified.
+	spanCtx, span := Start(ctx, nil, "disabled")
+	assert.Equal(t, ctx, spanCtx)
+	assert.False(t, span.IsRecording())
+	End(span, errors.New("ignored"))
+	assert.Empty(t, recorder.Ended())
+	Record(ctx, nil, "disabled", time.Now(), nil)
+	assert.Empty(t, recorder.Ended())
+
+	_, span = Start(ctx, tp, "child", attribute.String("key", "value"))
+	End(span, errors.New("failed"))
+	start := time.Now().Add(-time.Minute)
+	Record(ctx, tp, "recorded", start, nil)
+	parent.End()
+	spans := recorder.Ended()
+	require.Len(t, spans, 3)
+	assert.Equal(t, "child", spans[0].Name())
+	assert.Equal(t, parent.SpanContext().SpanID(), spans[0].Parent().SpanID())
+	assert.Equal(t, []attribute.KeyValue{attribute.String("key", "value")}, spans[0].Attributes())
+	assert.Equal(t, codes.Error, spans[0].Status().Code)
+	assert.Equal(t, "failed", spans[0].Status().Description)
+	assert.Equal(t, "recorded", spans[1].Name())
+	assert.Equal(t, parent.SpanContext().SpanID(), spans[1].Parent().SpanID())
+	assert.True(t, spans[1].StartTime().Equal(start))
+	assert.Equal(t, codes.Unset, spans[1].Status().Code)
+}
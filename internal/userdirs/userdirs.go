@@ -0,0 +1,71 @@
+// Package userdirs chooses per-user directories for state stored by the library,
+// falling back to caller-configured alternatives when the conventional directories are unusable.
+package userdirs
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+
+	"github.com/containers/storage/pkg/homedir"
+	"github.com/pkg/errors"
+)
+
+// RuntimeDir returns the runtime directory of the current user: $XDG_RUNTIME_DIR, if it is set.
+// If fallback is not "", and $XDG_RUNTIME_DIR is unset, does not exist, or is not writable (as is common
+// in containers and systemd user services), RuntimeDir returns fallback and a non-empty description of
+// why $XDG_RUNTIME_DIR was not used.
+// If fallback is "", the value of $XDG_RUNTIME_DIR (possibly "") is returned without any checks.
+func RuntimeDir(fallback string) (string, string) {
+	runtimeDir := os.Getenv("XDG_RUNTIME_DIR")
+	if fallback == "" {
+		return runtimeDir, ""
+	}
+	if runtimeDir == "" {
+		return fallback, "$XDG_RUNTIME_DIR is not set"
+	}
+	if err := checkWritableDir(runtimeDir, false); err != nil {
+		return fallback, fmt.Sprintf("$XDG_RUNTIME_DIR is not usable: %v", err)
+	}
+	return runtimeDir, ""
+}
+
+// CacheDir returns the cache directory of the current user ($XDG_CACHE_HOME, or ~/.cache).
+// If fallback is not "", and the cache directory can't be determined or is not writable, CacheDir returns
+// fallback and a non-empty description of why the cache directory was not used.
+// The cache directory is not required to exist, as long as it could be created.
+func CacheDir(fallback string) (string, string, error) {
+	cacheHome, err := homedir.GetCacheHome()
+	if fallback == "" {
+		return cacheHome, "", err
+	}
+	if err != nil {
+		return fallback, fmt.Sprintf("determining the cache directory: %v", err), nil
+	}
+	if err := checkWritableDir(cacheHome, true); err != nil {
+		return fallback, fmt.Sprintf("the cache directory is not usable: %v", err), nil
+	}
+	return cacheHome, "", nil
+}
+
+// checkWritableDir returns nil if dir is a directory in which the current process can create files.
+// If allowMissing, dir does not need to exist, as long as its closest existing parent is writable.
+func checkWritableDir(dir string, allowMissing bool) error {
+	for {
+		fi, err := os.Stat(dir)
+		if err != nil {
+			parent := filepath.Dir(dir)
+			if !allowMissing || !os.IsNotExist(err) || parent == dir {
+				return err
+			}
+			dir = parent
+			continue
+		}
+		if !fi.IsDir() {
+			return errors.Errorf("%q is not a directory", dir)
+		}
+		// Permission bits are not sufficient to determine this (e.g. read-only mounts, ACLs, or running as root),
+		// so ask the kernel, without modifying anything.
+		return checkDirAccess(dir)
+	}
+}
@@ -0,0 +1,94 @@
+package userdirs
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestRuntimeDir(t *testing.T) {
+	tmpDir := t.TempDir()
+	notADir := filepath.Join(tmpDir, "file")
+	err := os.WriteFile(notADir, []byte{}, 0600)
+	require.NoError(t, err)
+
+	oldXRD, hasXRD := os.LookupEnv("XDG_RUNTIME_DIR")
+	defer func() {
+		if hasXRD {
+			os.Setenv("XDG_RUNTIME_DIR", oldXRD)
+		} else {
+			os.Unsetenv("XDG_RUNTIME_DIR")
+		}
+	}()
+
+	for _, c := range []struct {
+		xrd, fallback, expected string
+		usesFallback            bool
+	}{
+		{"", "", "", false},
+		{tmpDir, "", tmpDir, false},
+		{tmpDir + "/thisdoesnotexist", "", tmpDir + "/thisdoesnotexist", false},
+		{"", "/fallback", "/fallback", true},
+		{tmpDir, "/fallback", tmpDir, false},
+		{tmpDir + "/thisdoesnotexist", "/fallback", "/fallback", true},
+		{notADir, "/fallback", "/fallback", true},
+	} {
+		if c.xrd != "" {
+			os.Setenv("XDG_RUNTIME_DIR", c.xrd)
+		} else {
+			os.Unsetenv("XDG_RUNTIME_DIR")
+		}
+		dir, reason := RuntimeDir(c.fallback)
+		assert.Equal(t, c.expected, dir, c.xrd)
+		if c.usesFallback {
+			assert.NotEmpty(t, reason, c.xrd)
+		} else {
+			assert.Empty(t, reason, c.xrd)
+		}
+	}
+}
+
+func TestCacheDir(t *testing.T) {
+	tmpDir := t.TempDir()
+	notADir := filepath.Join(tmpDir, "file")
+	err := os.WriteFile(notADir, []byte{}, 0600)
+	require.NoError(t, err)
+
+	oldXCH, hasXCH := os.LookupEnv("XDG_CACHE_HOME")
+	defer func() {
+		if hasXCH {
+			os.Setenv("XDG_CACHE_HOME", oldXCH)
+		} else {
+			os.Unsetenv("XDG_CACHE_HOME")
+		}
+	}()
+
+	for _, c := range []struct {
+		xch, fallback, expected string
+		usesFallback            bool
+	}{
+		{tmpDir, "", tmpDir, false},
+		{notADir, "", notADir, false},
+		{tmpDir, "/fallback", tmpDir, false},
+		{tmpDir + "/does/not/exist/yet", "/fallback", tmpDir + "/does/not/exist/yet", false},
+		{notADir, "/fallback", "/fallback", true},
+		{notADir + "/subdir", "/fallback", "/fallback", true},
+	} {
+		os.Setenv("XDG_CACHE_HOME", c.xch)
+		dir, reason, err := CacheDir(c.fallback)
+		require.NoError(t, err, c.xch)
+		assert.Equal(t, c.expected, dir, c.xch)
+		if c.usesFallback {
+			assert.NotEmpty(t, reason, c.xch)
+		} else {
+			assert.Empty(t, reason, c.xch)
+		}
+	}
+	// The probe files are cleaned up.
+	entries, err := os.ReadDir(tmpDir)
+	require.NoError(t, err)
+	assert.Len(t, entries, 1)
+}
@@ -0,0 +1,18 @@
+//go:build !windows
+// +build !windows
+
+package userdirs
+
+import (
+	"os"
+
+	"golang.org/x/sys/unix"
+)
+
+// checkDirAccess returns nil if the current process can create files in dir, which must be an existing directory.
+func checkDirAccess(dir string) error {
+	if err := unix.Access(dir, unix.W_OK|unix.X_OK); err != nil {
+		return &os.PathError{Op: "access", Path: dir, Err: err}
+	}
+	return nil
+}
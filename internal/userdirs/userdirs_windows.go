@@ -0,0 +1,7 @@
+package userdirs
+
+// checkDirAccess returns nil if the current process can create files in dir, which must be an existing directory.
+// Windows does not provide an equivalent of access(2); directories are assumed to be writable.
+func checkDirAccess(dir string) error {
+	return nil
+}
@@ -0,0 +1,15 @@
+// Package warnings reports structured warnings to types.SystemContext.WarningHandler.
+package warnings
+
+import (
+	"github.com/containers/image/v5/types"
+	"github.com/sirupsen/logrus"
+)
+
+// Report logs warning.Message, and reports warning to sys.WarningHandler, if any.
+func Report(sys *types.SystemContext, warning types.Warning) {
+	logrus.Debugf("%s (%s)", warning.Message, warning.Code)
+	if sys != nil && sys.WarningHandler != nil {
+		sys.WarningHandler(warning)
+	}
+}
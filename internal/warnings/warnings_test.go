@@ -0,0 +1,20 @@
+package warnings
+
+import (
+	"testing"
+
+	"github.com/containers/image/v5/types"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestReport(t *testing.T) {
+	warning := types.Warning{Kind: types.WarningDeprecation, Code: "code", Subject: "subject", Message: "message"}
+
+	// No handler
+	Report(nil, warning)
+	Report(&types.SystemContext{}, warning)
+
+	reported := []types.Warning{}
+	Report(&types.SystemContext{WarningHandler: func(w types.Warning) { reported = append(reported, w) }}, warning)
+	assert.Equal(t, []types.Warning{warning}, reported)
+}
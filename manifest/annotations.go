@@ -0,0 +1,471 @@
+package manifest
+
+import (
+	"bytes"
+	"encoding/json"
+	"sort"
+
+	"github.com/opencontainers/go-digest"
+	imgspecv1 "github.com/opencontainers/image-spec/specs-go/v1"
+	"github.com/pkg/errors"
+)
+
+// AnnotationUpdate describes changes to a set of annotations.
+type AnnotationUpdate struct {
+	Set    map[string]string // Annotations to add, or to change the values of
+	Delete []string          // Keys of annotations to remove; deleting a key which does not exist is not an error
+}
+
+// ManifestAnnotations returns the annotations of manifest, an OCI manifest or index of mimeType.
+// The result is nil if the manifest has no annotations.
+func ManifestAnnotations(manifest []byte, mimeType string) (map[string]string, error) {
+	if err := checkAnnotationsSupported(mimeType); err != nil {
+		return nil, err
+	}
+	if !json.Valid(manifest) {
+		return nil, errors.New("invalid JSON in manifest")
+	}
+	members, _, err := jsonObjectMembersAt(manifest, 0)
+	if err != nil {
+		return nil, err
+	}
+	return annotationsOfMembers(manifest, members)
+}
+
+// InstanceAnnotations returns the annotations of the entry for instanceDigest in list, an OCI index of mimeType.
+// The result is nil if the entry has no annotations.
+func InstanceAnnotations(list []byte, mimeType string, instanceDigest digest.Digest) (map[string]string, error) {
+	entryStart, err := indexEntryStart(list, mimeType, instanceDigest)
+	if err != nil {
+		return nil, err
+	}
+	members, _, err := jsonObjectMembersAt(list, entryStart)
+	if err != nil {
+		return nil, err
+	}
+	return annotationsOfMembers(list, members)
+}
+
+// UpdateManifestAnnotations applies update to the annotations of manifest, an OCI manifest or index of mimeType,
+// and returns the updated manifest and its digest.
+//
+// Only the annotations are re-serialized; the rest of the manifest, including its formatting and fields unknown
+// to this package, is preserved byte-for-byte.
+func UpdateManifestAnnotations(manifest []byte, mimeType string, update AnnotationUpdate) ([]byte, digest.Digest, error) {
+	if err := checkAnnotationsSupported(mimeType); err != nil {
+		return nil, "", err
+	}
+	if !json.Valid(manifest) {
+		return nil, "", errors.New("invalid JSON in manifest")
+	}
+	res, err := updateObjectAnnotations(manifest, 0, update)
+	if err != nil {
+		return nil, "", err
+	}
+	return validateUpdatedAnnotations(res, mimeType)
+}
+
+// UpdateInstanceAnnotations applies update to the annotations of the entry for instanceDigest in list, an OCI index
+// of mimeType, and returns the updated index and its digest.
+//
+// Only the annotations of the entry are re-serialized; the rest of the index, including its formatting and fields unknown
+// to this package, is preserved byte-for-byte.
+func UpdateInstanceAnnotations(list []byte, mimeType string, instanceDigest digest.Digest, update AnnotationUpdate) ([]byte, digest.Digest, error) {
+	entryStart, err := indexEntryStart(list, mimeType, instanceDigest)
+	if err != nil {
+		return nil, "", err
+	}
+	res, err := updateObjectAnnotations(list, entryStart, update)
+	if err != nil {
+		return nil, "", err
+	}
+	return validateUpdatedAnnotations(res, mimeType)
+}
+
+// checkAnnotationsSupported returns an error if manifests of mimeType can't contain annotations.
+func checkAnnotationsSupported(mimeType string) error {
+	switch NormalizedMIMEType(mimeType) {
+	case imgspecv1.MediaTypeImageManifest, imgspecv1.MediaTypeImageIndex:
+		return nil
+	default:
+		return errors.Errorf("annotations are not supported in manifests of type %s", mimeType)
+	}
+}
+
+// indexEntryStart returns the offset of the entry for instanceDigest in list, an OCI index of mimeType.
+func indexEntryStart(list []byte, mimeType string, instanceDigest digest.Digest) (int, error) {
+	if NormalizedMIMEType(mimeType) != imgspecv1.MediaTypeImageIndex {
+		return -1, errors.Errorf("annotations of instances are not supported in manifests of type %s", mimeType)
+	}
+	if !json.Valid(list) {
+		return -1, errors.New("invalid JSON in manifest list")
+	}
+	members, _, err := jsonObjectMembersAt(list, 0)
+	if err != nil {
+		return -1, err
+	}
+	for _, m := range members {
+		if m.key != "manifests" {
+			continue
+		}
+		entries, err := jsonArrayElementsAt(list, m.valueStart)
+		if err != nil {
+			return -1, err
+		}
+		for _, entry := range entries {
+			entryMembers, _, err := jsonObjectMembersAt(list, entry.start)
+			if err != nil {
+				return -1, err
+			}
+			for _, em := range entryMembers {
+				if em.key != "digest" {
+					continue
+				}
+				var d digest.Digest
+				if err := json.Unmarshal(list[em.valueStart:em.valueEnd], &d); err != nil {
+					return -1, errors.Wrap(err, "parsing index entry digest")
+				}
+				if d == instanceDigest {
+					return entry.start, nil
+				}
+			}
+		}
+	}
+	return -1, errors.Errorf("unable to find instance %s in OCI1Index", instanceDigest)
+}
+
+// annotationsOfMembers returns the value of the "annotations" member of members, parsed from data, or nil.
+func annotationsOfMembers(data []byte, members []jsonMember) (map[string]string, error) {
+	for _, m := range members {
+		if m.key == "annotations" {
+			var res map[string]string
+			if err := json.Unmarshal(data[m.valueStart:m.valueEnd], &res); err != nil {
+				return nil, errors.Wrap(err, "parsing annotations")
+			}
+			if len(res) == 0 {
+				return nil, nil
+			}
+			return res, nil
+		}
+	}
+	return nil, nil
+}
+
+// updateObjectAnnotations returns data with update applied to the "annotations" member of the object at objectStart.
+func updateObjectAnnotations(data []byte, objectStart int, update AnnotationUpdate) ([]byte, error) {
+	members, objectEnd, err := jsonObjectMembersAt(data, objectStart)
+	if err != nil {
+		return nil, err
+	}
+	annotationsIndex := -1
+	for i, m := range members {
+		if m.key == "annotations" {
+			annotationsIndex = i
+			break
+		}
+	}
+
+	// Keep the existing annotations in their original order, and add new ones in sorted order, so that the result is deterministic.
+	keys := []string{}
+	values := map[string]string{}
+	if annotationsIndex != -1 && string(data[members[annotationsIndex].valueStart:members[annotationsIndex].valueEnd]) != "null" {
+		m := members[annotationsIndex]
+		annotationMembers, _, err := jsonObjectMembersAt(data, m.valueStart)
+		if err != nil {
+			return nil, errors.Wrap(err, "parsing annotations")
+		}
+		for _, am := range annotationMembers {
+			var value string
+			if err := json.Unmarshal(data[am.valueStart:am.valueEnd], &value); err != nil {
+				return nil, errors.Wrapf(err, "parsing annotation %q", am.key)
+			}
+			if _, ok := values[am.key]; !ok {
+				keys = append(keys, am.key)
+			}
+			values[am.key] = value
+		}
+	}
+	changed := false
+	newKeys := []string{}
+	for k, v := range update.Set {
+		oldValue, ok := values[k]
+		if !ok {
+			newKeys = append(newKeys, k)
+		}
+		if !ok || oldValue != v {
+			changed = true
+		}
+	}
+	sort.Strings(newKeys)
+	keys = append(keys, newKeys...)
+	for k, v := range update.Set {
+		values[k] = v
+	}
+	for _, k := range update.Delete {
+		if _, ok := update.Set[k]; ok {
+			return nil, errors.Errorf("annotation %q is both set and deleted", k)
+		}
+		if _, ok := values[k]; ok {
+			changed = true
+		}
+		delete(values, k)
+	}
+	if !changed {
+		return data, nil // Don't modify the formatting of the annotations unnecessarily.
+	}
+
+	var annotations bytes.Buffer
+	annotations.WriteByte('{')
+	first := true
+	for _, k := range keys {
+		v, ok := values[k]
+		if !ok {
+			continue
+		}
+		if !first {
+			annotations.WriteByte(',')
+		}
+		first = false
+		if err := writeJSONMember(&annotations, k, v); err != nil {
+			return nil, err
+		}
+	}
+	annotations.WriteByte('}')
+	empty := first
+
+	var res bytes.Buffer
+	switch {
+	case annotationsIndex != -1 && !empty: // Replace the value
+		m := members[annotationsIndex]
+		res.Write(data[:m.valueStart])
+		res.Write(annotations.Bytes())
+		res.Write(data[m.valueEnd:])
+	case annotationsIndex != -1 && empty: // Remove the member, with one of the adjacent commas
+		m := members[annotationsIndex]
+		switch {
+		case annotationsIndex > 0:
+			res.Write(data[:members[annotationsIndex-1].valueEnd])
+			res.Write(data[m.valueEnd:])
+		case len(members) > 1:
+			res.Write(data[:m.keyStart])
+			res.Write(data[members[1].keyStart:])
+		default:
+			res.Write(data[:m.keyStart])
+			res.Write(data[m.valueEnd:])
+		}
+	case annotationsIndex == -1 && !empty: // Add the member at the end of the object
+		var member bytes.Buffer
+		member.WriteString(`"annotations":`)
+		member.Write(annotations.Bytes())
+		if len(members) > 0 {
+			insertAt := members[len(members)-1].valueEnd
+			res.Write(data[:insertAt])
+			res.WriteByte(',')
+			res.Write(member.Bytes())
+			res.Write(data[insertAt:])
+		} else {
+			insertAt := objectEnd - 1 // Just before the closing brace
+			res.Write(data[:insertAt])
+			res.Write(member.Bytes())
+			res.Write(data[insertAt:])
+		}
+	default: // No annotations before or after
+		return data, nil
+	}
+	return res.Bytes(), nil
+}
+
+// writeJSONMember writes an object member with key and value, without any surrounding whitespace or separators, to buf.
+func writeJSONMember(buf *bytes.Buffer, key string, value interface{}) error {
+	k, err := json.Marshal(key)
+	if err != nil {
+		return err
+	}
+	v, err := json.Marshal(value)
+	if err != nil {
+		return err
+	}
+	buf.Write(k)
+	buf.WriteByte(':')
+	buf.Write(v)
+	return nil
+}
+
+// validateUpdatedAnnotations checks that manifest, of mimeType, is still valid after changing annotations,
+// and returns it with its digest.
+func validateUpdatedAnnotations(manifest []byte, mimeType string) ([]byte, digest.Digest, error) {
+	var err error
+	if NormalizedMIMEType(mimeType) == imgspecv1.MediaTypeImageIndex {
+		_, err = ListFromBlob(manifest, mimeType)
+	} else {
+		_, err = FromBlob(manifest, mimeType)
+	}
+	if err != nil {
+		return nil, "", errors.Wrap(err, "validating manifest with updated annotations")
+	}
+	return manifest, digest.FromBytes(manifest), nil
+}
+
+// jsonMember is a member of a JSON object, identified by offsets into the document.
+type jsonMember struct {
+	key        string
+	keyStart   int // Offset of the opening quote of the key
+	valueStart int
+	valueEnd   int // Offset just after the value
+}
+
+// jsonElement is an element of a JSON array, identified by offsets into the document.
+type jsonElement struct {
+	start, end int
+}
+
+// jsonObjectMembersAt returns the members of the JSON object at offset start (possibly preceded by whitespace) in data,
+// and the offset just after the object.
+func jsonObjectMembersAt(data []byte, start int) ([]jsonMember, int, error) {
+	pos := skipJSONWhitespace(data, start)
+	if pos >= len(data) || data[pos] != '{' {
+		return nil, -1, errors.Errorf("expected a JSON object at offset %d", pos)
+	}
+	pos++
+	res := []jsonMember{}
+	for {
+		pos = skipJSONWhitespace(data, pos)
+		if pos < len(data) && data[pos] == '}' && len(res) == 0 {
+			return res, pos + 1, nil
+		}
+		keyStart := pos
+		keyEnd, err := skipJSONValue(data, pos)
+		if err != nil {
+			return nil, -1, err
+		}
+		if data[keyStart] != '"' {
+			return nil, -1, errors.Errorf("expected an object key at offset %d", keyStart)
+		}
+		var key string
+		if err := json.Unmarshal(data[keyStart:keyEnd], &key); err != nil {
+			return nil, -1, errors.Wrapf(err, "parsing object key at offset %d", keyStart)
+		}
+		pos = skipJSONWhitespace(data, keyEnd)
+		if pos >= len(data) || data[pos] != ':' {
+			return nil, -1, errors.Errorf("expected ':' at offset %d", pos)
+		}
+		valueStart := skipJSONWhitespace(data, pos+1)
+		valueEnd, err := skipJSONValue(data, valueStart)
+		if err != nil {
+			return nil, -1, err
+		}
+		res = append(res, jsonMember{key: key, keyStart: keyStart, valueStart: valueStart, valueEnd: valueEnd})
+		pos = skipJSONWhitespace(data, valueEnd)
+		if pos >= len(data) {
+			return nil, -1, errors.New("unexpected end of JSON object")
+		}
+		switch data[pos] {
+		case ',':
+			pos++
+		case '}':
+			return res, pos + 1, nil
+		default:
+			return nil, -1, errors.Errorf("unexpected %q at offset %d in JSON object", data[pos], pos)
+		}
+	}
+}
+
+// jsonArrayElementsAt returns the elements of the JSON array at offset start (possibly preceded by whitespace) in data.
+func jsonArrayElementsAt(data []byte, start int) ([]jsonElement, error) {
+	pos := skipJSONWhitespace(data, start)
+	if pos >= len(data) || data[pos] != '[' {
+		return nil, errors.Errorf("expected a JSON array at offset %d", pos)
+	}
+	pos++
+	res := []jsonElement{}
+	for {
+		pos = skipJSONWhitespace(data, pos)
+		if pos < len(data) && data[pos] == ']' && len(res) == 0 {
+			return res, nil
+		}
+		end, err := skipJSONValue(data, pos)
+		if err != nil {
+			return nil, err
+		}
+		res = append(res, jsonElement{start: pos, end: end})
+		pos = skipJSONWhitespace(data, end)
+		if pos >= len(data) {
+			return nil, errors.New("unexpected end of JSON array")
+		}
+		switch data[pos] {
+		case ',':
+			pos++
+		case ']':
+			return res, nil
+		default:
+			return nil, errors.Errorf("unexpected %q at offset %d in JSON array", data[pos], pos)
+		}
+	}
+}
+
+// skipJSONWhitespace returns the offset of the first non-whitespace byte at or after pos in data.
+func skipJSONWhitespace(data []byte, pos int) int {
+	for pos < len(data) {
+		switch data[pos] {
+		case ' ', '\t', '\n', '\r':
+			pos++
+		default:
+			return pos
+		}
+	}
+	return pos
+}
+
+// skipJSONValue returns the offset just after the JSON value starting at pos in data.
+// It only checks the structure to the extent necessary to find the end of the value; callers are expected to
+// validate the document separately.
+func skipJSONValue(data []byte, pos int) (int, error) {
+	if pos >= len(data) {
+		return -1, errors.New("unexpected end of JSON data")
+	}
+	switch data[pos] {
+	case '"':
+		for i := pos + 1; i < len(data); i++ {
+			switch data[i] {
+			case '\\':
+				i++ // Skip the escaped character
+			case '"':
+				return i + 1, nil
+			}
+		}
+		return -1, errors.New("unterminated JSON string")
+	case '{', '[':
+		depth := 0
+		for i := pos; i < len(data); i++ {
+			switch data[i] {
+			case '"':
+				end, err := skipJSONValue(data, i)
+				if err != nil {
+					return -1, err
+				}
+				i = end - 1
+			case '{', '[':
+				depth++
+			case '}', ']':
+				depth--
+				if depth == 0 {
+					return i + 1, nil
+				}
+			}
+		}
+		return -1, errors.New("unterminated JSON object or array")
+	default: // A number, true, false or null
+		i := pos
+		for i < len(data) {
+			switch data[i] {
+			case ',', '}', ']', ' ', '\t', '\n', '\r':
+				if i == pos {
+					return -1, errors.Errorf("unexpected %q at offset %d", data[i], i)
+				}
+				return i, nil
+			}
+			i++
+		}
+		return i, nil
+	}
+}
@@ -0,0 +1,159 @@
+package manifest
+
+import (
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/opencontainers/go-digest"
+	imgspecv1 "github.com/opencontainers/image-spec/specs-go/v1"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestManifestAnnotations(t *testing.T) {
+	for _, c := range []struct {
+		path, mimeType string
+		expected       map[string]string
+	}{
+		{"ociv1.manifest.json", imgspecv1.MediaTypeImageManifest, map[string]string{"com.example.key1": "value1", "com.example.key2": "value2"}},
+		{"ociv1.image.index.json", imgspecv1.MediaTypeImageIndex, map[string]string{"com.example.key1": "value1", "com.example.key2": "value2"}},
+		{"ociv1.artifact.json", imgspecv1.MediaTypeImageManifest, nil},
+	} {
+		manifest, err := os.ReadFile(filepath.Join("fixtures", c.path))
+		require.NoError(t, err)
+		res, err := ManifestAnnotations(manifest, c.mimeType)
+		require.NoError(t, err, c.path)
+		assert.Equal(t, c.expected, res, c.path)
+	}
+
+	manifest, err := os.ReadFile(filepath.Join("fixtures", "v2s2.manifest.json"))
+	require.NoError(t, err)
+	_, err = ManifestAnnotations(manifest, DockerV2Schema2MediaType)
+	assert.Error(t, err)
+	_, err = ManifestAnnotations([]byte(`{"annotations":`), imgspecv1.MediaTypeImageManifest)
+	assert.Error(t, err)
+}
+
+func TestUpdateManifestAnnotations(t *testing.T) {
+	manifest, err := os.ReadFile(filepath.Join("fixtures", "ociv1.manifest.json"))
+	require.NoError(t, err)
+	annotationsStart := strings.Index(string(manifest), `"annotations"`)
+	require.NotEqual(t, -1, annotationsStart)
+
+	res, d, err := UpdateManifestAnnotations(manifest, imgspecv1.MediaTypeImageManifest, AnnotationUpdate{
+		Set:    map[string]string{"org.opencontainers.image.version": "1.0", "com.example.key1": "changed"},
+		Delete: []string{"com.example.key2", "com.example.missing"},
+	})
+	require.NoError(t, err)
+	assert.Equal(t, digest.FromBytes(res), d)
+	// Everything before the annotations is preserved byte-for-byte
+	assert.Equal(t, string(manifest[:annotationsStart]), string(res[:annotationsStart]))
+	assert.Contains(t, string(res), `"annotations": {"com.example.key1":"changed","org.opencontainers.image.version":"1.0"}`)
+	m, err := OCI1FromManifest(res)
+	require.NoError(t, err)
+	assert.Equal(t, map[string]string{"com.example.key1": "changed", "org.opencontainers.image.version": "1.0"}, m.Annotations)
+
+	// Removing all annotations removes the field
+	res, _, err = UpdateManifestAnnotations(manifest, imgspecv1.MediaTypeImageManifest, AnnotationUpdate{
+		Delete: []string{"com.example.key1", "com.example.key2"},
+	})
+	require.NoError(t, err)
+	assert.NotContains(t, string(res), "annotations")
+	assert.Equal(t, strings.TrimRight(string(manifest[:annotationsStart]), " \n,"), strings.TrimRight(string(res), " \n}"))
+	m, err = OCI1FromManifest(res)
+	require.NoError(t, err)
+	assert.Empty(t, m.Annotations)
+
+	// An update which does not change any values preserves the manifest
+	for _, update := range []AnnotationUpdate{
+		{},
+		{Set: map[string]string{"com.example.key1": "value1"}, Delete: []string{"com.example.missing"}},
+	} {
+		res, d, err = UpdateManifestAnnotations(manifest, imgspecv1.MediaTypeImageManifest, update)
+		require.NoError(t, err)
+		assert.Equal(t, manifest, res)
+		assert.Equal(t, digest.FromBytes(manifest), d)
+	}
+
+	// Conflicting updates and unsupported formats are rejected
+	_, _, err = UpdateManifestAnnotations(manifest, imgspecv1.MediaTypeImageManifest, AnnotationUpdate{
+		Set:    map[string]string{"a": "b"},
+		Delete: []string{"a"},
+	})
+	assert.Error(t, err)
+	s2, err := os.ReadFile(filepath.Join("fixtures", "v2s2.manifest.json"))
+	require.NoError(t, err)
+	_, _, err = UpdateManifestAnnotations(s2, DockerV2Schema2MediaType, AnnotationUpdate{Set: map[string]string{"a": "b"}})
+	assert.Error(t, err)
+}
+
+func TestUpdateManifestAnnotationsEdgeCases(t *testing.T) {
+	for _, c := range []struct {
+		input, expected string
+		update          AnnotationUpdate
+	}{
+		{ // Adding annotations to a manifest without them, preserving formatting
+			`{ "schemaVersion" : 2, "config": {"mediaType":"application/vnd.oci.image.config.v1+json","digest":"sha256:b5b2b2c507a0944348e0303114d8d93aaaa081732b86451d9bce1f432a537bc7","size":7023}, "layers" : [ ] }`,
+			`{ "schemaVersion" : 2, "config": {"mediaType":"application/vnd.oci.image.config.v1+json","digest":"sha256:b5b2b2c507a0944348e0303114d8d93aaaa081732b86451d9bce1f432a537bc7","size":7023}, "layers" : [ ],"annotations":{"a":"1","b":"\u003c2\u003e"} }`,
+			AnnotationUpdate{Set: map[string]string{"b": "<2>", "a": "1"}},
+		},
+		{ // Removing annotations which are the first member
+			`{"annotations": {"a": "1"}, "schemaVersion": 2, "config": {"mediaType":"application/vnd.oci.image.config.v1+json","digest":"sha256:b5b2b2c507a0944348e0303114d8d93aaaa081732b86451d9bce1f432a537bc7","size":7023}, "layers": []}`,
+			`{"schemaVersion": 2, "config": {"mediaType":"application/vnd.oci.image.config.v1+json","digest":"sha256:b5b2b2c507a0944348e0303114d8d93aaaa081732b86451d9bce1f432a537bc7","size":7023}, "layers": []}`,
+			AnnotationUpdate{Delete: []string{"a"}},
+		},
+		{ // Replacing null annotations
+			`{"schemaVersion": 2, "config": {"mediaType":"application/vnd.oci.image.config.v1+json","digest":"sha256:b5b2b2c507a0944348e0303114d8d93aaaa081732b86451d9bce1f432a537bc7","size":7023}, "layers": [], "annotations": null}`,
+			`{"schemaVersion": 2, "config": {"mediaType":"application/vnd.oci.image.config.v1+json","digest":"sha256:b5b2b2c507a0944348e0303114d8d93aaaa081732b86451d9bce1f432a537bc7","size":7023}, "layers": [], "annotations": {"a\"}":"{\\\""}}`,
+			AnnotationUpdate{Set: map[string]string{`a"}`: `{\"`}},
+		},
+	} {
+		res, _, err := UpdateManifestAnnotations([]byte(c.input), imgspecv1.MediaTypeImageManifest, c.update)
+		require.NoError(t, err, c.input)
+		assert.Equal(t, c.expected, string(res))
+	}
+}
+
+func TestInstanceAnnotations(t *testing.T) {
+	index, err := os.ReadFile(filepath.Join("fixtures", "ociv1.unknown-fields.image.index.json"))
+	require.NoError(t, err)
+	const (
+		ppc64le     = digest.Digest("sha256:e692418e4cbaf90ca69d05a66403747baa33ee08806650b51fab815ad7fc331f")
+		attestation = digest.Digest("sha256:8f7f0b6b9e4ab4dbcf2ac6dc4c5a4ac7bd6cd5e4f7a6e2d0c7b2c85c71b8ff80")
+		missing     = digest.Digest("sha256:1bbf5d58d24c47512e234a5623474acf65ae00d4d1414272a893204f44cc680c")
+	)
+
+	res, err := InstanceAnnotations(index, imgspecv1.MediaTypeImageIndex, ppc64le)
+	require.NoError(t, err)
+	assert.Nil(t, res)
+	res, err = InstanceAnnotations(index, imgspecv1.MediaTypeImageIndex, attestation)
+	require.NoError(t, err)
+	assert.Equal(t, AttestationManifestReferenceType, res[AttestationReferenceTypeAnnotation])
+	_, err = InstanceAnnotations(index, imgspecv1.MediaTypeImageIndex, missing)
+	assert.Error(t, err)
+	_, err = InstanceAnnotations(index, imgspecv1.MediaTypeImageManifest, ppc64le)
+	assert.Error(t, err)
+
+	updated, d, err := UpdateInstanceAnnotations(index, imgspecv1.MediaTypeImageIndex, ppc64le, AnnotationUpdate{
+		Set: map[string]string{"org.opencontainers.image.source": "https://example.com"},
+	})
+	require.NoError(t, err)
+	assert.Equal(t, digest.FromBytes(updated), d)
+	res, err = InstanceAnnotations(updated, imgspecv1.MediaTypeImageIndex, ppc64le)
+	require.NoError(t, err)
+	assert.Equal(t, map[string]string{"org.opencontainers.image.source": "https://example.com"}, res)
+	// Other entries, and unknown fields, are not affected
+	res, err = InstanceAnnotations(updated, imgspecv1.MediaTypeImageIndex, attestation)
+	require.NoError(t, err)
+	assert.Len(t, res, 2)
+	assert.Contains(t, string(updated), `"com.example.entry": true`)
+	assert.Contains(t, string(updated), `"com.example.top-level": {"key": "value"}`)
+	top, err := ManifestAnnotations(updated, imgspecv1.MediaTypeImageIndex)
+	require.NoError(t, err)
+	assert.Nil(t, top)
+
+	_, _, err = UpdateInstanceAnnotations(index, imgspecv1.MediaTypeImageIndex, missing, AnnotationUpdate{Set: map[string]string{"a": "b"}})
+	assert.Error(t, err)
+}
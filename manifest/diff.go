@@ -0,0 +1,317 @@
+package manifest
+
+import (
+	"fmt"
+	"sort"
+	"strings"
+
+	"github.com/containers/image/v5/types"
+	"github.com/pkg/errors"
+)
+
+// DiffKind is the kind of a difference reported by Diff.
+type DiffKind int
+
+const (
+	// DiffAdded means that the item exists only in the second manifest.
+	DiffAdded DiffKind = iota
+	// DiffRemoved means that the item exists only in the first manifest.
+	DiffRemoved
+	// DiffChanged means that the item exists in both manifests, with different values.
+	DiffChanged
+	// DiffRecompressed means that a layer was replaced by a layer with the same media type except for compression.
+	// Note that the manifests don’t allow verifying that the uncompressed contents are the same.
+	DiffRecompressed
+)
+
+// String returns a human-readable description of k.
+func (k DiffKind) String() string {
+	switch k {
+	case DiffAdded:
+		return "added"
+	case DiffRemoved:
+		return "removed"
+	case DiffChanged:
+		return "changed"
+	case DiffRecompressed:
+		return "recompressed"
+	default:
+		return fmt.Sprintf("DiffKind(%d)", int(k))
+	}
+}
+
+// BlobDiff is a difference in the config or a layer of a manifest.
+type BlobDiff struct {
+	Kind DiffKind
+	Old  *types.BlobInfo // nil for DiffAdded
+	New  *types.BlobInfo // nil for DiffRemoved
+}
+
+// AnnotationDiff is a difference in an annotation of a manifest or a manifest list.
+type AnnotationDiff struct {
+	Kind     DiffKind
+	Key      string
+	Old, New string // Only meaningful if the annotation exists in the corresponding manifest, see Kind
+}
+
+// InstanceDiff is a difference in an instance of a manifest list.
+//
+// Instances with a platform are matched by their platform; instances without a platform (e.g. artifacts), and
+// attestation manifests, are matched by their digest, so they are only ever added or removed.
+type InstanceDiff struct {
+	Kind DiffKind
+	Old  *ListEntry // nil for DiffAdded
+	New  *ListEntry // nil for DiffRemoved
+}
+
+// ManifestDiff is a structured comparison of two manifests, or two manifest lists, returned by Diff.
+type ManifestDiff struct {
+	OldMIMEType string
+	NewMIMEType string
+
+	// Config is the difference in the config, if any (nil if the configs are the same).
+	// Always nil for manifest lists.
+	Config *BlobDiff
+	// Layers are the differences in layers. Layers are matched by digest (DiffChanged means a change of the media type),
+	// so reordering layers is not reported.
+	// Always empty for manifest lists.
+	Layers []BlobDiff
+	// Annotations are the differences in the manifest (or manifest list) annotations, sorted by key.
+	Annotations []AnnotationDiff
+	// Instances are the differences in instances of manifest lists, in the order of the old list followed by additions in
+	// the order of the new list.
+	// Always empty for single-image manifests.
+	Instances []InstanceDiff
+}
+
+// Empty returns true if d does not contain any differences, i.e. the manifests are equivalent (but not necessarily byte-for-byte identical).
+func (d *ManifestDiff) Empty() bool {
+	return d.OldMIMEType == d.NewMIMEType && d.Config == nil && len(d.Layers) == 0 && len(d.Annotations) == 0 && len(d.Instances) == 0
+}
+
+// Diff compares manifests a and b, which must be both single-image manifests or both manifest lists, of any supported
+// (and possibly different) MIME types, and returns a structured description of the differences.
+func Diff(a, b []byte) (*ManifestDiff, error) {
+	aMIMEType := NormalizedMIMEType(GuessMIMEType(a))
+	bMIMEType := NormalizedMIMEType(GuessMIMEType(b))
+	res := &ManifestDiff{
+		OldMIMEType: aMIMEType,
+		NewMIMEType: bMIMEType,
+	}
+	switch aIsList, bIsList := MIMETypeIsMultiImage(aMIMEType), MIMETypeIsMultiImage(bMIMEType); {
+	case aIsList && bIsList:
+		if err := res.diffLists(a, aMIMEType, b, bMIMEType); err != nil {
+			return nil, err
+		}
+	case !aIsList && !bIsList:
+		if err := res.diffManifests(a, aMIMEType, b, bMIMEType); err != nil {
+			return nil, err
+		}
+	default:
+		return nil, errors.Errorf("can not compare a %s manifest with a %s manifest", aMIMEType, bMIMEType)
+	}
+	return res, nil
+}
+
+// diffManifests records differences between single-image manifests a and b into d.
+func (d *ManifestDiff) diffManifests(a []byte, aMIMEType string, b []byte, bMIMEType string) error {
+	aManifest, err := FromBlob(a, aMIMEType)
+	if err != nil {
+		return errors.Wrap(err, "parsing the first manifest")
+	}
+	bManifest, err := FromBlob(b, bMIMEType)
+	if err != nil {
+		return errors.Wrap(err, "parsing the second manifest")
+	}
+
+	aConfig, bConfig := aManifest.ConfigInfo(), bManifest.ConfigInfo()
+	switch {
+	case aConfig.Digest == "" && bConfig.Digest != "":
+		d.Config = &BlobDiff{Kind: DiffAdded, New: &bConfig}
+	case aConfig.Digest != "" && bConfig.Digest == "":
+		d.Config = &BlobDiff{Kind: DiffRemoved, Old: &aConfig}
+	case aConfig.Digest != bConfig.Digest || aConfig.MediaType != bConfig.MediaType:
+		d.Config = &BlobDiff{Kind: DiffChanged, Old: &aConfig, New: &bConfig}
+	}
+
+	d.diffLayers(aManifest.LayerInfos(), bManifest.LayerInfos())
+	d.diffAnnotations(manifestAnnotations(aManifest), manifestAnnotations(bManifest))
+	return nil
+}
+
+// diffLayers records differences between layers a and b into d.
+func (d *ManifestDiff) diffLayers(a, b []LayerInfo) {
+	// Match layers by digest, in order, so that repeated layers are handled correctly.
+	bByDigest := map[string][]int{}
+	for j, l := range b {
+		bByDigest[l.Digest.String()] = append(bByDigest[l.Digest.String()], j)
+	}
+	bMatched := make([]bool, len(b))
+	removed := []types.BlobInfo{}
+	for i := range a {
+		old := a[i].BlobInfo
+		candidates := bByDigest[old.Digest.String()]
+		if len(candidates) == 0 {
+			removed = append(removed, old)
+			continue
+		}
+		j := candidates[0]
+		bByDigest[old.Digest.String()] = candidates[1:]
+		bMatched[j] = true
+		if old.MediaType != b[j].MediaType {
+			d.Layers = append(d.Layers, BlobDiff{Kind: DiffChanged, Old: &old, New: &b[j].BlobInfo})
+		}
+	}
+	added := []types.BlobInfo{}
+	for j := range b {
+		if !bMatched[j] {
+			added = append(added, b[j].BlobInfo)
+		}
+	}
+
+	// Pair removed and added layers with the same media type except for compression, in order.
+	recompressed := make([]bool, len(added))
+	for i := range removed {
+		old := removed[i]
+		matched := false
+		for j := range added {
+			if !recompressed[j] && old.MediaType != added[j].MediaType &&
+				layerMediaTypeWithoutCompression(old.MediaType) == layerMediaTypeWithoutCompression(added[j].MediaType) {
+				recompressed[j] = true
+				d.Layers = append(d.Layers, BlobDiff{Kind: DiffRecompressed, Old: &old, New: &added[j]})
+				matched = true
+				break
+			}
+		}
+		if !matched {
+			d.Layers = append(d.Layers, BlobDiff{Kind: DiffRemoved, Old: &old})
+		}
+	}
+	for j := range added {
+		if !recompressed[j] {
+			d.Layers = append(d.Layers, BlobDiff{Kind: DiffAdded, New: &added[j]})
+		}
+	}
+}
+
+// layerMediaTypeWithoutCompression returns the media type of an uncompressed layer corresponding to mediaType.
+func layerMediaTypeWithoutCompression(mediaType string) string {
+	for _, suffix := range []string{"+gzip", "+zstd", ".gzip", ".zstd"} {
+		if strings.HasSuffix(mediaType, suffix) {
+			return strings.TrimSuffix(mediaType, suffix)
+		}
+	}
+	return mediaType
+}
+
+// manifestAnnotations returns the annotations of m, if its format supports them.
+func manifestAnnotations(m Manifest) map[string]string {
+	if oci, ok := m.(*OCI1); ok {
+		return oci.Annotations
+	}
+	return nil
+}
+
+// diffAnnotations records differences between annotations a and b into d.
+func (d *ManifestDiff) diffAnnotations(a, b map[string]string) {
+	for k, aValue := range a {
+		bValue, ok := b[k]
+		switch {
+		case !ok:
+			d.Annotations = append(d.Annotations, AnnotationDiff{Kind: DiffRemoved, Key: k, Old: aValue})
+		case aValue != bValue:
+			d.Annotations = append(d.Annotations, AnnotationDiff{Kind: DiffChanged, Key: k, Old: aValue, New: bValue})
+		}
+	}
+	for k, bValue := range b {
+		if _, ok := a[k]; !ok {
+			d.Annotations = append(d.Annotations, AnnotationDiff{Kind: DiffAdded, Key: k, New: bValue})
+		}
+	}
+	sort.Slice(d.Annotations, func(i, j int) bool {
+		return d.Annotations[i].Key < d.Annotations[j].Key
+	})
+}
+
+// diffLists records differences between manifest lists a and b into d.
+func (d *ManifestDiff) diffLists(a []byte, aMIMEType string, b []byte, bMIMEType string) error {
+	aList, err := ListFromBlob(a, aMIMEType)
+	if err != nil {
+		return errors.Wrap(err, "parsing the first manifest list")
+	}
+	bList, err := ListFromBlob(b, bMIMEType)
+	if err != nil {
+		return errors.Wrap(err, "parsing the second manifest list")
+	}
+	aEditor, err := NewListEditor(a, aMIMEType)
+	if err != nil {
+		return errors.Wrap(err, "parsing the first manifest list")
+	}
+	bEditor, err := NewListEditor(b, bMIMEType)
+	if err != nil {
+		return errors.Wrap(err, "parsing the second manifest list")
+	}
+
+	// Match entries by key, in order, so that duplicate keys are handled reasonably.
+	bEntries := bEditor.Entries()
+	bByKey := map[string][]int{}
+	for j, entry := range bEntries {
+		key := instanceDiffKey(entry)
+		bByKey[key] = append(bByKey[key], j)
+	}
+	bMatched := make([]bool, len(bEntries))
+	for _, entry := range aEditor.Entries() {
+		old := entry
+		key := instanceDiffKey(old)
+		candidates := bByKey[key]
+		if len(candidates) == 0 {
+			d.Instances = append(d.Instances, InstanceDiff{Kind: DiffRemoved, Old: &old})
+			continue
+		}
+		j := candidates[0]
+		bByKey[key] = candidates[1:]
+		bMatched[j] = true
+		if !listEntriesEqual(old, bEntries[j]) {
+			d.Instances = append(d.Instances, InstanceDiff{Kind: DiffChanged, Old: &old, New: &bEntries[j]})
+		}
+	}
+	for j := range bEntries {
+		if !bMatched[j] {
+			d.Instances = append(d.Instances, InstanceDiff{Kind: DiffAdded, New: &bEntries[j]})
+		}
+	}
+
+	d.diffAnnotations(listAnnotations(aList), listAnnotations(bList))
+	return nil
+}
+
+// instanceDiffKey returns a key used to match instances of two manifest lists.
+func instanceDiffKey(entry ListEntry) string {
+	if entry.Platform == nil || entry.IsAttestation() {
+		return "digest:" + entry.Digest.String()
+	}
+	p := entry.Platform
+	return fmt.Sprintf("platform:%s/%s/%s/%s", p.OS, p.Architecture, p.Variant, p.OSVersion)
+}
+
+// listEntriesEqual returns true if a and b describe the same instance, in the same way.
+func listEntriesEqual(a, b ListEntry) bool {
+	if a.Digest != b.Digest || a.Size != b.Size || a.MediaType != b.MediaType || a.ArtifactType != b.ArtifactType ||
+		len(a.Annotations) != len(b.Annotations) {
+		return false
+	}
+	for k, v := range a.Annotations {
+		if bValue, ok := b.Annotations[k]; !ok || bValue != v {
+			return false
+		}
+	}
+	return true
+}
+
+// listAnnotations returns the annotations of list, if its format supports them.
+func listAnnotations(list List) map[string]string {
+	if index, ok := list.(*OCI1Index); ok {
+		return index.Annotations
+	}
+	return nil
+}
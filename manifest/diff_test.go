@@ -0,0 +1,206 @@
+package manifest
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/opencontainers/go-digest"
+	imgspecv1 "github.com/opencontainers/image-spec/specs-go/v1"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestDiffKindString(t *testing.T) {
+	for k, expected := range map[DiffKind]string{
+		DiffAdded:        "added",
+		DiffRemoved:      "removed",
+		DiffChanged:      "changed",
+		DiffRecompressed: "recompressed",
+		DiffKind(42):     "DiffKind(42)",
+	} {
+		assert.Equal(t, expected, k.String())
+	}
+}
+
+func TestDiffIdentical(t *testing.T) {
+	for _, fixture := range []string{
+		"ociv1.manifest.json",
+		"v2s2.manifest.json",
+		"v2s1.manifest.json",
+		"ociv1.image.index.json",
+		"v2list.manifest.json",
+	} {
+		manifest, err := os.ReadFile(filepath.Join("fixtures", fixture))
+		require.NoError(t, err)
+		res, err := Diff(manifest, manifest)
+		require.NoError(t, err, fixture)
+		assert.True(t, res.Empty(), fixture)
+	}
+}
+
+func TestDiffManifests(t *testing.T) {
+	layer := func(mediaType string, d digest.Digest) imgspecv1.Descriptor {
+		return imgspecv1.Descriptor{MediaType: mediaType, Digest: d, Size: 100}
+	}
+	const (
+		d1 = digest.Digest("sha256:e692418e4cbaf90ca69d05a66403747baa33ee08806650b51fab815ad7fc331f")
+		d2 = digest.Digest("sha256:3c3a4604a545cdc127456d94e421cd355bca5b528f4a9c1905b15da2eb4a4c6b")
+		d3 = digest.Digest("sha256:ec4b8955958665577945c89419d1af06b5f7636b4ac3da7f12184802ad867736")
+		d4 = digest.Digest("sha256:1bbf5d58d24c47512e234a5623474acf65ae00d4d1414272a893204f44cc680c")
+		d5 = digest.Digest("sha256:6a5a5368e0c2d3e5909184fa28ddfd56072e7ff3ee9a945876f7eee5896ef5bb")
+	)
+	config := imgspecv1.Descriptor{MediaType: imgspecv1.MediaTypeImageConfig, Digest: d5, Size: 10}
+	a := OCI1FromComponents(config, []imgspecv1.Descriptor{
+		layer(imgspecv1.MediaTypeImageLayerGzip, d1),
+		layer(imgspecv1.MediaTypeImageLayerGzip, d2),
+		layer(imgspecv1.MediaTypeImageLayerGzip, d3),
+	})
+	a.Annotations = map[string]string{"removed": "1", "changed": "old", "same": "1"}
+	aBlob, err := a.Serialize()
+	require.NoError(t, err)
+
+	newConfig := config
+	newConfig.Size = 11
+	newConfig.Digest = digest.FromString("new config")
+	b := OCI1FromComponents(newConfig, []imgspecv1.Descriptor{
+		layer(imgspecv1.MediaTypeImageLayerZstd, d1),
+		layer(imgspecv1.MediaTypeImageLayerZstd, d4),
+		layer(imgspecv1.MediaTypeImageLayerNonDistributableGzip, d5),
+	})
+	b.Annotations = map[string]string{"added": "1", "changed": "new", "same": "1"}
+	bBlob, err := b.Serialize()
+	require.NoError(t, err)
+
+	res, err := Diff(aBlob, bBlob)
+	require.NoError(t, err)
+	assert.False(t, res.Empty())
+	assert.Equal(t, imgspecv1.MediaTypeImageManifest, res.OldMIMEType)
+	assert.Equal(t, imgspecv1.MediaTypeImageManifest, res.NewMIMEType)
+	require.NotNil(t, res.Config)
+	assert.Equal(t, DiffChanged, res.Config.Kind)
+	assert.Equal(t, d5, res.Config.Old.Digest)
+	assert.Equal(t, newConfig.Digest, res.Config.New.Digest)
+
+	type layerDiff struct {
+		kind     DiffKind
+		old, new digest.Digest
+	}
+	layers := []layerDiff{}
+	for _, l := range res.Layers {
+		ld := layerDiff{kind: l.Kind}
+		if l.Old != nil {
+			ld.old = l.Old.Digest
+		}
+		if l.New != nil {
+			ld.new = l.New.Digest
+		}
+		layers = append(layers, ld)
+	}
+	assert.Equal(t, []layerDiff{
+		{kind: DiffChanged, old: d1, new: d1},      // Same blob, different media type
+		{kind: DiffRecompressed, old: d2, new: d4}, // Same media type except for compression
+		{kind: DiffRemoved, old: d3},               // Non-distributable layers are not a recompressed version
+		{kind: DiffAdded, new: d5},
+	}, layers)
+
+	assert.Equal(t, []AnnotationDiff{
+		{Kind: DiffAdded, Key: "added", New: "1"},
+		{Kind: DiffChanged, Key: "changed", Old: "old", New: "new"},
+		{Kind: DiffRemoved, Key: "removed", Old: "1"},
+	}, res.Annotations)
+	assert.Empty(t, res.Instances)
+}
+
+func TestDiffManifestFormats(t *testing.T) {
+	s2, err := os.ReadFile(filepath.Join("fixtures", "v2s2.manifest.json"))
+	require.NoError(t, err)
+	s1, err := os.ReadFile(filepath.Join("fixtures", "v2s1.manifest.json"))
+	require.NoError(t, err)
+	res, err := Diff(s1, s2)
+	require.NoError(t, err)
+	assert.False(t, res.Empty())
+	assert.Equal(t, DockerV2Schema1SignedMediaType, res.OldMIMEType)
+	assert.Equal(t, DockerV2Schema2MediaType, res.NewMIMEType)
+	require.NotNil(t, res.Config)
+	assert.Equal(t, DiffAdded, res.Config.Kind)
+	assert.Nil(t, res.Config.Old)
+
+	// Manifests can't be compared with lists
+	list, err := os.ReadFile(filepath.Join("fixtures", "v2list.manifest.json"))
+	require.NoError(t, err)
+	_, err = Diff(s2, list)
+	assert.Error(t, err)
+	_, err = Diff(list, s2)
+	assert.Error(t, err)
+	_, err = Diff([]byte("{"), s2)
+	assert.Error(t, err)
+}
+
+func TestDiffLists(t *testing.T) {
+	a, err := os.ReadFile(filepath.Join("fixtures", "ociv1.unknown-fields.image.index.json"))
+	require.NoError(t, err)
+	editor, err := NewListEditor(a, imgspecv1.MediaTypeImageIndex)
+	require.NoError(t, err)
+	ppc64le, err := editor.EntryForPlatform(imgspecv1.Platform{OS: "linux", Architecture: "ppc64le"})
+	require.NoError(t, err)
+	err = editor.RemovePlatform(imgspecv1.Platform{OS: "linux", Architecture: "amd64"}) // Also removes the attestation
+	require.NoError(t, err)
+	err = editor.Add(ListEntry{
+		MediaType: imgspecv1.MediaTypeImageManifest,
+		Digest:    "sha256:1bbf5d58d24c47512e234a5623474acf65ae00d4d1414272a893204f44cc680c",
+		Size:      150,
+		Platform:  &imgspecv1.Platform{OS: "linux", Architecture: "arm64"},
+	})
+	require.NoError(t, err)
+	updated := ppc64le
+	updated.Digest = "sha256:6a5a5368e0c2d3e5909184fa28ddfd56072e7ff3ee9a945876f7eee5896ef5bb"
+	err = editor.Replace(ppc64le.Digest, updated)
+	require.NoError(t, err)
+	b, _, err := editor.Serialize()
+	require.NoError(t, err)
+
+	res, err := Diff(a, b)
+	require.NoError(t, err)
+	assert.False(t, res.Empty())
+	assert.Nil(t, res.Config)
+	assert.Empty(t, res.Layers)
+	assert.Empty(t, res.Annotations)
+
+	type instanceDiff struct {
+		kind     DiffKind
+		old, new digest.Digest
+	}
+	instances := []instanceDiff{}
+	for _, i := range res.Instances {
+		id := instanceDiff{kind: i.Kind}
+		if i.Old != nil {
+			id.old = i.Old.Digest
+		}
+		if i.New != nil {
+			id.new = i.New.Digest
+		}
+		instances = append(instances, id)
+	}
+	assert.Equal(t, []instanceDiff{
+		{kind: DiffChanged, old: ppc64le.Digest, new: updated.Digest},
+		{kind: DiffRemoved, old: "sha256:5b0bcabd1ed22e9fb1310cf6c2dec7cdef19f0ad69efa1f392e94a4333501270"},
+		{kind: DiffRemoved, old: "sha256:8f7f0b6b9e4ab4dbcf2ac6dc4c5a4ac7bd6cd5e4f7a6e2d0c7b2c85c71b8ff80"},
+		{kind: DiffAdded, new: "sha256:1bbf5d58d24c47512e234a5623474acf65ae00d4d1414272a893204f44cc680c"},
+	}, instances)
+	assert.Equal(t, "arm64", res.Instances[3].New.Platform.Architecture)
+
+	// Different list formats can be compared; Docker lists have no annotations.
+	oci, err := os.ReadFile(filepath.Join("fixtures", "ociv1.image.index.json"))
+	require.NoError(t, err)
+	docker, err := os.ReadFile(filepath.Join("fixtures", "v2list.manifest.json"))
+	require.NoError(t, err)
+	res, err = Diff(oci, docker)
+	require.NoError(t, err)
+	assert.Equal(t, imgspecv1.MediaTypeImageIndex, res.OldMIMEType)
+	assert.Equal(t, DockerV2ListMediaType, res.NewMIMEType)
+	assert.Len(t, res.Annotations, 2)
+	for _, a := range res.Annotations {
+		assert.Equal(t, DiffRemoved, a.Kind)
+	}
+}
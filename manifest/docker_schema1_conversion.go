@@ -0,0 +1,95 @@
+package manifest
+
+import (
+	"encoding/json"
+	"fmt"
+
+	"github.com/opencontainers/go-digest"
+	imgspecv1 "github.com/opencontainers/image-spec/specs-go/v1"
+	"github.com/pkg/errors"
+)
+
+// Schema1RejectedError is returned when reading an image with a Docker schema1 manifest,
+// if types.SystemContext.RejectSchema1Manifests is set.
+type Schema1RejectedError struct {
+	Image string // A user-readable name of the image
+}
+
+func (e Schema1RejectedError) Error() string {
+	return fmt.Sprintf("image %s uses the deprecated Docker schema1 manifest format, which is rejected by configuration", e.Image)
+}
+
+// Schema1LayerConversionInfo contains information about a layer of a schema1 image which is not recorded in the
+// schema1 manifest, and which is necessary to convert the image to other formats.
+type Schema1LayerConversionInfo struct {
+	Size   int64         // The size of the (compressed) layer blob
+	DiffID digest.Digest // The digest of the uncompressed layer
+}
+
+// Schema1ToOCI1 converts s1 to an OCI manifest, and returns the manifest and the corresponding OCI config blob.
+// The layers referenced by the converted manifest are the same blobs as the layers of s1; the config is synthesized
+// from the v1Compatibility data of s1, including the image history.
+//
+// layers must contain an entry for each element of s1.LayerInfos(), in the same order; the entries corresponding
+// to empty layers are ignored.  Because schema1 manifests don’t record layer sizes or uncompressed digests, callers
+// must compute them from the layer blobs.
+func Schema1ToOCI1(s1 *Schema1, layers []Schema1LayerConversionInfo) (*OCI1, []byte, error) {
+	if len(s1.ExtractedV1Compatibility) == 0 {
+		return nil, nil, errors.Errorf("Cannot convert an image with 0 history entries to %s", imgspecv1.MediaTypeImageManifest)
+	}
+	if len(s1.ExtractedV1Compatibility) != len(s1.FSLayers) {
+		return nil, nil, errors.Errorf("Inconsistent schema 1 manifest: %d history entries, %d fsLayers entries", len(s1.ExtractedV1Compatibility), len(s1.FSLayers))
+	}
+	layerInfos := s1.LayerInfos()
+	if len(layers) != len(layerInfos) {
+		return nil, nil, errors.Errorf("converting a schema1 manifest with %d layers, but conversion information for %d layers was provided", len(layerInfos), len(layers))
+	}
+
+	descriptors := []imgspecv1.Descriptor{}
+	diffIDs := []digest.Digest{}
+	for i, info := range layerInfos {
+		if info.EmptyLayer {
+			continue
+		}
+		if layers[i].Size < 0 {
+			return nil, nil, errors.Errorf("unknown size of layer %s", info.Digest)
+		}
+		if err := layers[i].DiffID.Validate(); err != nil {
+			return nil, nil, errors.Wrapf(err, "invalid DiffID of layer %s", info.Digest)
+		}
+		descriptors = append(descriptors, imgspecv1.Descriptor{
+			MediaType: imgspecv1.MediaTypeImageLayerGzip,
+			Digest:    info.Digest,
+			Size:      layers[i].Size,
+		})
+		diffIDs = append(diffIDs, layers[i].DiffID)
+	}
+
+	schema2Config, err := s1.ToSchema2Config(diffIDs)
+	if err != nil {
+		return nil, nil, err
+	}
+	// Docker and OCI configs are mostly compatible; this unmarshal drops the fields which OCI does not define.
+	config := imgspecv1.Image{}
+	if err := json.Unmarshal(schema2Config, &config); err != nil {
+		return nil, nil, errors.Wrap(err, "parsing converted config")
+	}
+	// Very old images may not record the platform in v1Compatibility; it is required by OCI.
+	if config.Architecture == "" {
+		config.Architecture = s1.Architecture
+	}
+	if config.OS == "" {
+		config.OS = "linux"
+	}
+	configBlob, err := json.Marshal(config)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	m := OCI1FromComponents(imgspecv1.Descriptor{
+		MediaType: imgspecv1.MediaTypeImageConfig,
+		Digest:    digest.FromBytes(configBlob),
+		Size:      int64(len(configBlob)),
+	}, descriptors)
+	return m, configBlob, nil
+}
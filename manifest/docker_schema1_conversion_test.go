@@ -0,0 +1,96 @@
+package manifest
+
+import (
+	"encoding/json"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/opencontainers/go-digest"
+	imgspecv1 "github.com/opencontainers/image-spec/specs-go/v1"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestSchema1RejectedError(t *testing.T) {
+	err := Schema1RejectedError{Image: "docker://example.com/repo:tag"}
+	assert.Contains(t, err.Error(), "docker://example.com/repo:tag")
+}
+
+func TestSchema1ToOCI1(t *testing.T) {
+	manifest, err := os.ReadFile(filepath.Join("fixtures", "v2s1.manifest.json"))
+	require.NoError(t, err)
+	s1, err := Schema1FromManifest(manifest)
+	require.NoError(t, err)
+	layers := []Schema1LayerConversionInfo{
+		{Size: 10, DiffID: "sha256:e692418e4cbaf90ca69d05a66403747baa33ee08806650b51fab815ad7fc331f"},
+		{Size: 20, DiffID: "sha256:3c3a4604a545cdc127456d94e421cd355bca5b528f4a9c1905b15da2eb4a4c6b"},
+		{Size: 30, DiffID: "sha256:ec4b8955958665577945c89419d1af06b5f7636b4ac3da7f12184802ad867736"},
+	}
+	m, configBlob, err := Schema1ToOCI1(s1, layers)
+	require.NoError(t, err)
+
+	assert.Equal(t, imgspecv1.MediaTypeImageConfig, m.Config.MediaType)
+	assert.Equal(t, digest.FromBytes(configBlob), m.Config.Digest)
+	assert.Equal(t, int64(len(configBlob)), m.Config.Size)
+	require.Len(t, m.Layers, 3)
+	for i, l := range s1.LayerInfos() {
+		assert.Equal(t, imgspecv1.MediaTypeImageLayerGzip, m.Layers[i].MediaType)
+		assert.Equal(t, l.Digest, m.Layers[i].Digest)
+		assert.Equal(t, layers[i].Size, m.Layers[i].Size)
+	}
+	serialized, err := m.Serialize()
+	require.NoError(t, err)
+	assert.NoError(t, ValidateSchema(serialized, imgspecv1.MediaTypeImageManifest))
+	assert.NoError(t, ValidateSchema(configBlob, imgspecv1.MediaTypeImageConfig))
+
+	config := imgspecv1.Image{}
+	err = json.Unmarshal(configBlob, &config)
+	require.NoError(t, err)
+	assert.Equal(t, "amd64", config.Architecture)
+	assert.Equal(t, "linux", config.OS)
+	assert.Equal(t, []digest.Digest{layers[0].DiffID, layers[1].DiffID, layers[2].DiffID}, config.RootFS.DiffIDs)
+	require.Len(t, config.History, 3)
+	assert.Equal(t, `/bin/sh -c #(nop) MAINTAINER "William Temple <wtemple at redhat dot com>"`, config.History[0].CreatedBy)
+
+	// Invalid conversion information
+	_, _, err = Schema1ToOCI1(s1, layers[:2])
+	assert.Error(t, err)
+	_, _, err = Schema1ToOCI1(s1, []Schema1LayerConversionInfo{layers[0], {Size: -1, DiffID: layers[1].DiffID}, layers[2]})
+	assert.Error(t, err)
+	_, _, err = Schema1ToOCI1(s1, []Schema1LayerConversionInfo{layers[0], {Size: 20}, layers[2]})
+	assert.Error(t, err)
+}
+
+func TestSchema1ToOCI1EmptyLayersAndSynthesizedPlatform(t *testing.T) {
+	const (
+		layerDigest = digest.Digest("sha256:e692418e4cbaf90ca69d05a66403747baa33ee08806650b51fab815ad7fc331f")
+		emptyDigest = digest.Digest("sha256:a3ed95caeb02ffe68cdd9fd84406680ae93d633cb16422d00e8a7c22955b46d4")
+	)
+	// Schema1 lists the most recent layer first; the top layer is empty and the config does not record the platform.
+	s1, err := Schema1FromComponents(nil, []Schema1FSLayers{
+		{BlobSum: emptyDigest},
+		{BlobSum: layerDigest},
+	}, []Schema1History{
+		{V1Compatibility: `{"id":"2","parent":"1","created":"2016-03-03T11:29:44Z","container_config":{"Cmd":["/bin/sh","-c","#(nop) CMD [\"sh\"]"]},"throwaway":true}`},
+		{V1Compatibility: `{"id":"1","created":"2016-03-03T11:29:38Z","container_config":{"Cmd":["/bin/sh","-c","#(nop) ADD file"]}}`},
+	}, "arm64")
+	require.NoError(t, err)
+	m, configBlob, err := Schema1ToOCI1(s1, []Schema1LayerConversionInfo{
+		{Size: 100, DiffID: "sha256:3c3a4604a545cdc127456d94e421cd355bca5b528f4a9c1905b15da2eb4a4c6b"},
+		{Size: -1}, // Ignored for empty layers
+	})
+	require.NoError(t, err)
+	assert.Equal(t, []imgspecv1.Descriptor{{MediaType: imgspecv1.MediaTypeImageLayerGzip, Digest: layerDigest, Size: 100}}, m.Layers)
+
+	config := imgspecv1.Image{}
+	err = json.Unmarshal(configBlob, &config)
+	require.NoError(t, err)
+	assert.Equal(t, "arm64", config.Architecture)
+	assert.Equal(t, "linux", config.OS)
+	require.Len(t, config.History, 2)
+	assert.False(t, config.History[0].EmptyLayer)
+	assert.True(t, config.History[1].EmptyLayer)
+	assert.Equal(t, `/bin/sh -c #(nop) CMD ["sh"]`, config.History[1].CreatedBy)
+	assert.NoError(t, ValidateSchema(configBlob, imgspecv1.MediaTypeImageConfig))
+}
@@ -4,7 +4,7 @@ import (
 	"encoding/json"
 	"fmt"
 
-	platform "github.com/containers/image/v5/internal/pkg/platform"
+	platformpolicy "github.com/containers/image/v5/pkg/platform"
 	"github.com/containers/image/v5/types"
 	"github.com/opencontainers/go-digest"
 	imgspecv1 "github.com/opencontainers/image-spec/specs-go/v1"
@@ -89,25 +89,24 @@ func (list *Schema2List) UpdateInstances(updates []ListUpdate) error {
 // ChooseInstance parses blob as a schema2 manifest list, and returns the digest
 // of the image which is appropriate for the current environment.
 func (list *Schema2List) ChooseInstance(ctx *types.SystemContext) (digest.Digest, error) {
-	wantedPlatforms, err := platform.WantedPlatforms(ctx)
+	candidates := make([]imgspecv1.Platform, 0, len(list.Manifests))
+	for _, d := range list.Manifests {
+		candidates = append(candidates, imgspecv1.Platform{
+			Architecture: d.Platform.Architecture,
+			OS:           d.Platform.OS,
+			OSVersion:    d.Platform.OSVersion,
+			OSFeatures:   dupStringSlice(d.Platform.OSFeatures),
+			Variant:      d.Platform.Variant,
+		})
+	}
+	chosen, err := platformpolicy.Choose(ctx, candidates)
 	if err != nil {
-		return "", errors.Wrapf(err, "getting platform information %#v", ctx)
+		return "", err
 	}
-	for _, wantedPlatform := range wantedPlatforms {
-		for _, d := range list.Manifests {
-			imagePlatform := imgspecv1.Platform{
-				Architecture: d.Platform.Architecture,
-				OS:           d.Platform.OS,
-				OSVersion:    d.Platform.OSVersion,
-				OSFeatures:   dupStringSlice(d.Platform.OSFeatures),
-				Variant:      d.Platform.Variant,
-			}
-			if platform.MatchesPlatform(imagePlatform, wantedPlatform) {
-				return d.Digest, nil
-			}
-		}
+	if chosen == -1 {
+		return "", noMatchingInstanceError("manifest list", ctx)
 	}
-	return "", fmt.Errorf("no image found in manifest list for architecture %s, variant %q, OS %s", wantedPlatforms[0].Architecture, wantedPlatforms[0].Variant, wantedPlatforms[0].OS)
+	return list.Manifests[chosen].Digest, nil
 }
 
 // Serialize returns the list in a blob format.
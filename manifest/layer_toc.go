@@ -0,0 +1,144 @@
+package manifest
+
+import (
+	"fmt"
+	"strconv"
+
+	"github.com/opencontainers/go-digest"
+	"github.com/pkg/errors"
+)
+
+const (
+	// ZstdChunkedManifestChecksumAnnotation is the layer annotation containing the digest of the compressed
+	// table of contents of a zstd:chunked layer.
+	ZstdChunkedManifestChecksumAnnotation = "io.containers.zstd-chunked.manifest-checksum"
+	// ZstdChunkedManifestPositionAnnotation is the layer annotation containing the position of the table of contents
+	// of a zstd:chunked layer, as "offset:length:uncompressedLength:type".
+	ZstdChunkedManifestPositionAnnotation = "io.containers.zstd-chunked.manifest-position"
+	// EstargzTOCDigestAnnotation is the layer annotation containing the digest of the (uncompressed) table of contents
+	// of an eStargz layer.
+	EstargzTOCDigestAnnotation = "containerd.io/snapshot/stargz/toc.digest"
+	// EstargzUncompressedSizeAnnotation is the layer annotation containing the size of the uncompressed eStargz layer.
+	EstargzUncompressedSizeAnnotation = "io.containers.estargz.uncompressed-size"
+)
+
+// LayerTOCFormat is a format of layers which include a table of contents, allowing partial pulls.
+type LayerTOCFormat int
+
+const (
+	// LayerTOCFormatNone means that the layer does not declare a table of contents.
+	LayerTOCFormatNone LayerTOCFormat = iota
+	// LayerTOCFormatZstdChunked is the zstd:chunked format.
+	LayerTOCFormatZstdChunked
+	// LayerTOCFormatEstargz is the eStargz format.
+	LayerTOCFormatEstargz
+)
+
+// String returns a user-readable name of f.
+func (f LayerTOCFormat) String() string {
+	switch f {
+	case LayerTOCFormatNone:
+		return "none"
+	case LayerTOCFormatZstdChunked:
+		return "zstd:chunked"
+	case LayerTOCFormatEstargz:
+		return "estargz"
+	default:
+		return fmt.Sprintf("LayerTOCFormat(%d)", int(f))
+	}
+}
+
+// ZstdChunkedTOCPosition is the position of the table of contents within a zstd:chunked layer blob.
+type ZstdChunkedTOCPosition struct {
+	Offset             uint64 // Of the compressed table of contents
+	Length             uint64 // Of the compressed table of contents
+	UncompressedLength uint64
+	ManifestType       uint64 // 1 for the only currently defined format, compatible with CRFS
+}
+
+// LayerTOC describes the table of contents of a layer, as declared by the annotations of the layer descriptor.
+type LayerTOC struct {
+	Format LayerTOCFormat
+	// Digest is the digest of the table of contents: for zstd:chunked, of the compressed data; for eStargz, of the
+	// uncompressed JSON.
+	Digest digest.Digest
+	// Position is the position of the table of contents, if recorded in the annotations (only for zstd:chunked).
+	// If nil, the position must be read from the footer of the blob.
+	Position *ZstdChunkedTOCPosition
+	// UncompressedSize is the size of the uncompressed layer, or -1 if unknown (only recorded for eStargz).
+	UncompressedSize int64
+}
+
+// LayerTOCFromAnnotations returns the table of contents declared by annotations of a layer descriptor
+// (e.g. types.BlobInfo.Annotations), with Format == LayerTOCFormatNone if the layer does not declare one.
+func LayerTOCFromAnnotations(annotations map[string]string) (LayerTOC, error) {
+	res := LayerTOC{Format: LayerTOCFormatNone, UncompressedSize: -1}
+	zstdChecksum, isZstd := annotations[ZstdChunkedManifestChecksumAnnotation]
+	estargzDigest, isEstargz := annotations[EstargzTOCDigestAnnotation]
+	switch {
+	case isZstd && isEstargz:
+		return LayerTOC{}, errors.Errorf("layer annotations declare both a zstd:chunked and an eStargz table of contents")
+	case isZstd:
+		d, err := digest.Parse(zstdChecksum)
+		if err != nil {
+			return LayerTOC{}, errors.Wrapf(err, "invalid %s annotation", ZstdChunkedManifestChecksumAnnotation)
+		}
+		res.Format = LayerTOCFormatZstdChunked
+		res.Digest = d
+		if position, ok := annotations[ZstdChunkedManifestPositionAnnotation]; ok {
+			p, err := parseZstdChunkedTOCPosition(position)
+			if err != nil {
+				return LayerTOC{}, err
+			}
+			res.Position = &p
+		}
+	case isEstargz:
+		d, err := digest.Parse(estargzDigest)
+		if err != nil {
+			return LayerTOC{}, errors.Wrapf(err, "invalid %s annotation", EstargzTOCDigestAnnotation)
+		}
+		res.Format = LayerTOCFormatEstargz
+		res.Digest = d
+		if size, ok := annotations[EstargzUncompressedSizeAnnotation]; ok {
+			s, err := strconv.ParseInt(size, 10, 64)
+			if err != nil || s < 0 {
+				return LayerTOC{}, errors.Errorf("invalid %s annotation %q", EstargzUncompressedSizeAnnotation, size)
+			}
+			res.UncompressedSize = s
+		}
+	}
+	return res, nil
+}
+
+// parseZstdChunkedTOCPosition parses the value of a ZstdChunkedManifestPositionAnnotation annotation.
+func parseZstdChunkedTOCPosition(value string) (ZstdChunkedTOCPosition, error) {
+	var res ZstdChunkedTOCPosition
+	var rest string
+	n, _ := fmt.Sscanf(value+" ", "%d:%d:%d:%d%s", &res.Offset, &res.Length, &res.UncompressedLength, &res.ManifestType, &rest)
+	if n != 4 || rest != "" {
+		return ZstdChunkedTOCPosition{}, errors.Errorf("invalid %s annotation %q", ZstdChunkedManifestPositionAnnotation, value)
+	}
+	return res, nil
+}
+
+// Annotations returns the layer annotations declaring toc; they can be merged into the annotations of a layer descriptor.
+// It returns nil for LayerTOCFormatNone.
+func (toc LayerTOC) Annotations() map[string]string {
+	switch toc.Format {
+	case LayerTOCFormatZstdChunked:
+		res := map[string]string{ZstdChunkedManifestChecksumAnnotation: toc.Digest.String()}
+		if toc.Position != nil {
+			res[ZstdChunkedManifestPositionAnnotation] = fmt.Sprintf("%d:%d:%d:%d", toc.Position.Offset, toc.Position.Length,
+				toc.Position.UncompressedLength, toc.Position.ManifestType)
+		}
+		return res
+	case LayerTOCFormatEstargz:
+		res := map[string]string{EstargzTOCDigestAnnotation: toc.Digest.String()}
+		if toc.UncompressedSize >= 0 {
+			res[EstargzUncompressedSizeAnnotation] = strconv.FormatInt(toc.UncompressedSize, 10)
+		}
+		return res
+	default:
+		return nil
+	}
+}
@@ -0,0 +1,77 @@
+package manifest
+
+import (
+	"testing"
+
+	"github.com/opencontainers/go-digest"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestLayerTOCFromAnnotations(t *testing.T) {
+	d := digest.FromString("toc")
+
+	for _, c := range []struct {
+		annotations map[string]string
+		expected    LayerTOC
+	}{
+		{nil, LayerTOC{Format: LayerTOCFormatNone, UncompressedSize: -1}},
+		{map[string]string{"unrelated": "value"}, LayerTOC{Format: LayerTOCFormatNone, UncompressedSize: -1}},
+		{
+			map[string]string{ZstdChunkedManifestChecksumAnnotation: d.String()},
+			LayerTOC{Format: LayerTOCFormatZstdChunked, Digest: d, UncompressedSize: -1},
+		},
+		{
+			map[string]string{
+				ZstdChunkedManifestChecksumAnnotation: d.String(),
+				ZstdChunkedManifestPositionAnnotation: "1234:56:789:1",
+			},
+			LayerTOC{
+				Format:           LayerTOCFormatZstdChunked,
+				Digest:           d,
+				Position:         &ZstdChunkedTOCPosition{Offset: 1234, Length: 56, UncompressedLength: 789, ManifestType: 1},
+				UncompressedSize: -1,
+			},
+		},
+		{
+			map[string]string{EstargzTOCDigestAnnotation: d.String()},
+			LayerTOC{Format: LayerTOCFormatEstargz, Digest: d, UncompressedSize: -1},
+		},
+		{
+			map[string]string{EstargzTOCDigestAnnotation: d.String(), EstargzUncompressedSizeAnnotation: "4096"},
+			LayerTOC{Format: LayerTOCFormatEstargz, Digest: d, UncompressedSize: 4096},
+		},
+	} {
+		res, err := LayerTOCFromAnnotations(c.annotations)
+		require.NoError(t, err, c.annotations)
+		assert.Equal(t, c.expected, res, c.annotations)
+		if c.expected.Format != LayerTOCFormatNone {
+			assert.Equal(t, c.annotations, res.Annotations(), c.annotations)
+		} else {
+			assert.Nil(t, res.Annotations())
+		}
+	}
+
+	for _, annotations := range []map[string]string{
+		{ZstdChunkedManifestChecksumAnnotation: "invalid"},
+		{ZstdChunkedManifestChecksumAnnotation: d.String(), ZstdChunkedManifestPositionAnnotation: ""},
+		{ZstdChunkedManifestChecksumAnnotation: d.String(), ZstdChunkedManifestPositionAnnotation: "1:2:3"},
+		{ZstdChunkedManifestChecksumAnnotation: d.String(), ZstdChunkedManifestPositionAnnotation: "1:2:3:4:5"},
+		{ZstdChunkedManifestChecksumAnnotation: d.String(), ZstdChunkedManifestPositionAnnotation: "1:2:3:4x"},
+		{ZstdChunkedManifestChecksumAnnotation: d.String(), ZstdChunkedManifestPositionAnnotation: "-1:2:3:4"},
+		{EstargzTOCDigestAnnotation: "invalid"},
+		{EstargzTOCDigestAnnotation: d.String(), EstargzUncompressedSizeAnnotation: "-1"},
+		{EstargzTOCDigestAnnotation: d.String(), EstargzUncompressedSizeAnnotation: "x"},
+		{ZstdChunkedManifestChecksumAnnotation: d.String(), EstargzTOCDigestAnnotation: d.String()},
+	} {
+		_, err := LayerTOCFromAnnotations(annotations)
+		assert.Error(t, err, annotations)
+	}
+}
+
+func TestLayerTOCFormatString(t *testing.T) {
+	assert.Equal(t, "none", LayerTOCFormatNone.String())
+	assert.Equal(t, "zstd:chunked", LayerTOCFormatZstdChunked.String())
+	assert.Equal(t, "estargz", LayerTOCFormatEstargz.String())
+	assert.Equal(t, "LayerTOCFormat(42)", LayerTOCFormat(42).String())
+}
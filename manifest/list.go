@@ -3,6 +3,7 @@ package manifest
 import (
 	"fmt"
 
+	"github.com/containers/image/v5/internal/pkg/platform"
 	"github.com/containers/image/v5/types"
 	digest "github.com/opencontainers/go-digest"
 	imgspecv1 "github.com/opencontainers/image-spec/specs-go/v1"
@@ -78,3 +79,17 @@ func ListFromBlob(manifest []byte, manifestMIMEType string) (List, error) {
 func ConvertListToMIMEType(list List, manifestMIMEType string) (List, error) {
 	return list.ConvertToMIMEType(manifestMIMEType)
 }
+
+// noMatchingInstanceError returns an error reporting that no instance of a listKind (e.g. "manifest list") is
+// appropriate for the platform described by ctx.
+func noMatchingInstanceError(listKind string, ctx *types.SystemContext) error {
+	wantedPlatforms, err := platform.WantedPlatforms(ctx)
+	if err != nil || len(wantedPlatforms) == 0 {
+		return fmt.Errorf("no image found in %s for the current platform", listKind)
+	}
+	if ctx != nil && ctx.PlatformMatcher != nil {
+		return fmt.Errorf("no image found in %s for architecture %s, variant %q, OS %s acceptable to the platform matching policy",
+			listKind, wantedPlatforms[0].Architecture, wantedPlatforms[0].Variant, wantedPlatforms[0].OS)
+	}
+	return fmt.Errorf("no image found in %s for architecture %s, variant %q, OS %s", listKind, wantedPlatforms[0].Architecture, wantedPlatforms[0].Variant, wantedPlatforms[0].OS)
+}
@@ -0,0 +1,452 @@
+package manifest
+
+import (
+	"encoding/json"
+	"fmt"
+
+	"github.com/opencontainers/go-digest"
+	imgspecv1 "github.com/opencontainers/image-spec/specs-go/v1"
+	"github.com/pkg/errors"
+)
+
+const (
+	// AttestationReferenceTypeAnnotation is the annotation which marks an entry of a manifest list as an attestation
+	// manifest (using the AttestationManifestReferenceType value), as created by BuildKit.
+	AttestationReferenceTypeAnnotation = "vnd.docker.reference.type"
+	// AttestationReferenceDigestAnnotation is the annotation which records the digest of the manifest described
+	// by an attestation manifest entry.
+	AttestationReferenceDigestAnnotation = "vnd.docker.reference.digest"
+	// AttestationManifestReferenceType is the value of AttestationReferenceTypeAnnotation for attestation manifests.
+	AttestationManifestReferenceType = "attestation-manifest"
+)
+
+// ListEntry is an entry of a manifest list edited using ListEditor.
+//
+// Entries returned by ListEditor also carry the fields of the original entry which are not known to this package;
+// they are preserved if the entry is passed back to ListEditor.
+type ListEntry struct {
+	MediaType   string
+	Digest      digest.Digest
+	Size        int64
+	URLs        []string
+	Annotations map[string]string // Not supported in Docker manifest lists.
+	// Platform is the platform of the manifest; it may only be nil for non-platform-specific entries (e.g. artifacts)
+	// in OCI indexes.
+	Platform *imgspecv1.Platform
+	// ArtifactType is the type of an artifact referenced by the entry (image-spec v1.1);
+	// not supported in Docker manifest lists.
+	ArtifactType string
+
+	unknownFields         map[string]json.RawMessage // Fields of the entry not represented above.
+	unknownPlatformFields map[string]json.RawMessage // Fields of the entry’s platform not represented in Platform.
+}
+
+// IsAttestation returns true if entry is an attestation manifest.
+func (entry ListEntry) IsAttestation() bool {
+	return entry.Annotations[AttestationReferenceTypeAnnotation] == AttestationManifestReferenceType
+}
+
+// clone returns a deep copy of entry.
+func (entry ListEntry) clone() ListEntry {
+	res := entry
+	res.URLs = dupStringSlice(entry.URLs)
+	res.Annotations = dupStringStringMap(entry.Annotations)
+	if entry.Platform != nil {
+		p := *entry.Platform
+		p.OSFeatures = dupStringSlice(entry.Platform.OSFeatures)
+		res.Platform = &p
+	}
+	res.unknownFields = dupRawMessageMap(entry.unknownFields)
+	res.unknownPlatformFields = dupRawMessageMap(entry.unknownPlatformFields)
+	return res
+}
+
+// ListEditor edits a manifest list (an OCI index or a Docker manifest list), by adding, removing, replacing and annotating
+// its entries.
+//
+// Unlike the List implementations, ListEditor preserves the fields of the list, and of its entries, which are not known
+// to this package. Note that any edit changes the digest of the list.
+type ListEditor struct {
+	mimeType string
+	fields   map[string]json.RawMessage // All top-level fields except for "manifests"
+	entries  []ListEntry
+}
+
+// Known fields of list entries, and of their platforms, represented in ListEntry.
+var (
+	listEntryKnownFields         = []string{"mediaType", "digest", "size", "urls", "annotations", "platform", "artifactType"}
+	listEntryPlatformKnownFields = []string{"architecture", "os", "os.version", "os.features", "variant"}
+)
+
+// NewListEditor returns a ListEditor for manifest, which must be a manifest list of type mimeType.
+func NewListEditor(manifest []byte, mimeType string) (*ListEditor, error) {
+	normalized := NormalizedMIMEType(mimeType)
+	switch normalized {
+	case DockerV2ListMediaType, imgspecv1.MediaTypeImageIndex:
+	default:
+		return nil, fmt.Errorf("Unimplemented manifest list MIME type %s (normalized as %s)", mimeType, normalized)
+	}
+	// Validate the manifest using the usual parsers, so that ListEditor accepts exactly the lists which ListFromBlob does.
+	if _, err := ListFromBlob(manifest, normalized); err != nil {
+		return nil, err
+	}
+
+	fields := map[string]json.RawMessage{}
+	if err := json.Unmarshal(manifest, &fields); err != nil {
+		return nil, errors.Wrap(err, "parsing manifest list")
+	}
+	rawEntries := []map[string]json.RawMessage{}
+	if rawManifests, ok := fields["manifests"]; ok {
+		if err := json.Unmarshal(rawManifests, &rawEntries); err != nil {
+			return nil, errors.Wrap(err, "parsing manifest list entries")
+		}
+	}
+	delete(fields, "manifests")
+
+	editor := &ListEditor{
+		mimeType: normalized,
+		fields:   fields,
+		entries:  make([]ListEntry, 0, len(rawEntries)),
+	}
+	for i, rawEntry := range rawEntries {
+		entry, err := parseListEntry(rawEntry)
+		if err != nil {
+			return nil, errors.Wrapf(err, "parsing manifest list entry %d", i)
+		}
+		editor.entries = append(editor.entries, entry)
+	}
+	return editor, nil
+}
+
+// parseListEntry parses a manifest list entry, preserving fields not known to this package.
+func parseListEntry(rawEntry map[string]json.RawMessage) (ListEntry, error) {
+	entry := struct {
+		MediaType    string            `json:"mediaType"`
+		Digest       digest.Digest     `json:"digest"`
+		Size         int64             `json:"size"`
+		URLs         []string          `json:"urls"`
+		Annotations  map[string]string `json:"annotations"`
+		ArtifactType string            `json:"artifactType"`
+	}{}
+	// This can't fail for data already validated by ListFromBlob, but let's be careful.
+	raw, err := json.Marshal(rawEntry)
+	if err != nil {
+		return ListEntry{}, err
+	}
+	if err := json.Unmarshal(raw, &entry); err != nil {
+		return ListEntry{}, err
+	}
+	res := ListEntry{
+		MediaType:    entry.MediaType,
+		Digest:       entry.Digest,
+		Size:         entry.Size,
+		URLs:         entry.URLs,
+		Annotations:  entry.Annotations,
+		ArtifactType: entry.ArtifactType,
+	}
+	if rawPlatform, ok := rawEntry["platform"]; ok && string(rawPlatform) != "null" {
+		p := imgspecv1.Platform{}
+		if err := json.Unmarshal(rawPlatform, &p); err != nil {
+			return ListEntry{}, err
+		}
+		res.Platform = &p
+		platformFields := map[string]json.RawMessage{}
+		if err := json.Unmarshal(rawPlatform, &platformFields); err != nil {
+			return ListEntry{}, err
+		}
+		res.unknownPlatformFields = unknownRawFields(platformFields, listEntryPlatformKnownFields)
+	}
+	res.unknownFields = unknownRawFields(rawEntry, listEntryKnownFields)
+	return res, nil
+}
+
+// MIMEType returns the (normalized) MIME type of the edited list.
+func (e *ListEditor) MIMEType() string {
+	return e.mimeType
+}
+
+// Entries returns a copy of the entries of the list, in order.
+func (e *ListEditor) Entries() []ListEntry {
+	res := make([]ListEntry, len(e.entries))
+	for i, entry := range e.entries {
+		res[i] = entry.clone()
+	}
+	return res
+}
+
+// Attestations returns the attestation manifest entries which describe the manifest with instanceDigest.
+func (e *ListEditor) Attestations(instanceDigest digest.Digest) []ListEntry {
+	res := []ListEntry{}
+	for _, entry := range e.entries {
+		if entry.IsAttestation() && entry.Annotations[AttestationReferenceDigestAnnotation] == instanceDigest.String() {
+			res = append(res, entry.clone())
+		}
+	}
+	return res
+}
+
+// Add appends entry to the list.
+func (e *ListEditor) Add(entry ListEntry) error {
+	if err := e.validateEntry(entry); err != nil {
+		return err
+	}
+	e.entries = append(e.entries, entry.clone())
+	return nil
+}
+
+// AddAttestation appends attestation, an attestation manifest describing the manifest with instanceDigest, to the list.
+// The annotations and platform which mark it as an attestation manifest are set automatically.
+// This is only supported for OCI indexes.
+func (e *ListEditor) AddAttestation(instanceDigest digest.Digest, attestation ListEntry) error {
+	if _, err := e.index(instanceDigest); err != nil {
+		return err
+	}
+	attestation = attestation.clone()
+	if attestation.Annotations == nil {
+		attestation.Annotations = map[string]string{}
+	}
+	attestation.Annotations[AttestationReferenceTypeAnnotation] = AttestationManifestReferenceType
+	attestation.Annotations[AttestationReferenceDigestAnnotation] = instanceDigest.String()
+	if attestation.Platform == nil {
+		// This is what BuildKit uses, so that clients which don’t know about attestations never choose them.
+		attestation.Platform = &imgspecv1.Platform{Architecture: "unknown", OS: "unknown"}
+	}
+	return e.Add(attestation)
+}
+
+// Remove removes the entry with instanceDigest, and all attestation manifests describing it, from the list.
+func (e *ListEditor) Remove(instanceDigest digest.Digest) error {
+	if _, err := e.index(instanceDigest); err != nil {
+		return err
+	}
+	res := make([]ListEntry, 0, len(e.entries))
+	for _, entry := range e.entries {
+		if entry.Digest == instanceDigest ||
+			(entry.IsAttestation() && entry.Annotations[AttestationReferenceDigestAnnotation] == instanceDigest.String()) {
+			continue
+		}
+		res = append(res, entry)
+	}
+	e.entries = res
+	return nil
+}
+
+// RemovePlatform removes the entries for platform p (see EntryForPlatform), and all attestation manifests describing them,
+// from the list.
+func (e *ListEditor) RemovePlatform(p imgspecv1.Platform) error {
+	entry, err := e.EntryForPlatform(p)
+	if err != nil {
+		return err
+	}
+	return e.Remove(entry.Digest)
+}
+
+// Replace replaces the entry with instanceDigest with entry, in the same position in the list.
+// Attestation manifests describing the original entry are updated to describe the new one.
+func (e *ListEditor) Replace(instanceDigest digest.Digest, entry ListEntry) error {
+	i, err := e.index(instanceDigest)
+	if err != nil {
+		return err
+	}
+	if err := e.validateEntry(entry); err != nil {
+		return err
+	}
+	e.entries[i] = entry.clone()
+	if entry.Digest != instanceDigest {
+		for j := range e.entries {
+			if e.entries[j].IsAttestation() && e.entries[j].Annotations[AttestationReferenceDigestAnnotation] == instanceDigest.String() {
+				e.entries[j].Annotations[AttestationReferenceDigestAnnotation] = entry.Digest.String()
+			}
+		}
+	}
+	return nil
+}
+
+// EntryForPlatform returns the entry for platform p, i.e. with the same OS, architecture, variant and OS version.
+// Attestation manifests and entries without a platform are ignored.
+func (e *ListEditor) EntryForPlatform(p imgspecv1.Platform) (ListEntry, error) {
+	for _, entry := range e.entries {
+		if entry.Platform == nil || entry.IsAttestation() {
+			continue
+		}
+		if entry.Platform.OS == p.OS && entry.Platform.Architecture == p.Architecture &&
+			entry.Platform.Variant == p.Variant && entry.Platform.OSVersion == p.OSVersion {
+			return entry.clone(), nil
+		}
+	}
+	return ListEntry{}, errors.Errorf("no entry for platform %s/%s%s in manifest list", p.OS, p.Architecture, variantSuffix(p.Variant))
+}
+
+// SetAnnotation sets annotation key of the entry with instanceDigest to value.
+// This is only supported for OCI indexes.
+func (e *ListEditor) SetAnnotation(instanceDigest digest.Digest, key, value string) error {
+	if e.mimeType != imgspecv1.MediaTypeImageIndex {
+		return errors.Errorf("annotations are not supported in manifest lists of type %s", e.mimeType)
+	}
+	i, err := e.index(instanceDigest)
+	if err != nil {
+		return err
+	}
+	if e.entries[i].Annotations == nil {
+		e.entries[i].Annotations = map[string]string{}
+	}
+	e.entries[i].Annotations[key] = value
+	return nil
+}
+
+// DeleteAnnotation removes annotation key, if any, from the entry with instanceDigest.
+func (e *ListEditor) DeleteAnnotation(instanceDigest digest.Digest, key string) error {
+	i, err := e.index(instanceDigest)
+	if err != nil {
+		return err
+	}
+	delete(e.entries[i].Annotations, key)
+	return nil
+}
+
+// Serialize returns the edited list, and its digest.
+func (e *ListEditor) Serialize() ([]byte, digest.Digest, error) {
+	rawEntries := make([]map[string]json.RawMessage, 0, len(e.entries))
+	for _, entry := range e.entries {
+		rawEntry, err := serializeListEntry(entry)
+		if err != nil {
+			return nil, "", errors.Wrapf(err, "serializing manifest list entry %s", entry.Digest)
+		}
+		rawEntries = append(rawEntries, rawEntry)
+	}
+	fields := dupRawMessageMap(e.fields)
+	if fields == nil {
+		fields = map[string]json.RawMessage{}
+	}
+	if err := setRawField(fields, "manifests", rawEntries); err != nil {
+		return nil, "", err
+	}
+	res, err := json.Marshal(fields)
+	if err != nil {
+		return nil, "", err
+	}
+	// Make sure the result is still a valid list, as a last line of defense.
+	if _, err := ListFromBlob(res, e.mimeType); err != nil {
+		return nil, "", errors.Wrap(err, "validating edited manifest list")
+	}
+	return res, digest.FromBytes(res), nil
+}
+
+// serializeListEntry returns entry as raw JSON fields.
+func serializeListEntry(entry ListEntry) (map[string]json.RawMessage, error) {
+	fields := dupRawMessageMap(entry.unknownFields)
+	if fields == nil {
+		fields = map[string]json.RawMessage{}
+	}
+	values := map[string]interface{}{
+		"mediaType": entry.MediaType,
+		"digest":    entry.Digest,
+		"size":      entry.Size,
+	}
+	if len(entry.URLs) != 0 {
+		values["urls"] = entry.URLs
+	}
+	if len(entry.Annotations) != 0 {
+		values["annotations"] = entry.Annotations
+	}
+	if entry.ArtifactType != "" {
+		values["artifactType"] = entry.ArtifactType
+	}
+	if entry.Platform != nil {
+		platformFields := dupRawMessageMap(entry.unknownPlatformFields)
+		if platformFields == nil {
+			platformFields = map[string]json.RawMessage{}
+		}
+		rawPlatform, err := json.Marshal(entry.Platform)
+		if err != nil {
+			return nil, err
+		}
+		if err := json.Unmarshal(rawPlatform, &platformFields); err != nil {
+			return nil, err
+		}
+		values["platform"] = platformFields
+	}
+	for key, value := range values {
+		if err := setRawField(fields, key, value); err != nil {
+			return nil, err
+		}
+	}
+	return fields, nil
+}
+
+// validateEntry returns an error if entry can't be added to the list.
+func (e *ListEditor) validateEntry(entry ListEntry) error {
+	if err := entry.Digest.Validate(); err != nil {
+		return errors.Wrapf(err, "invalid manifest list entry digest %q", entry.Digest)
+	}
+	if entry.MediaType == "" {
+		return errors.Errorf("manifest list entry %s has no media type", entry.Digest)
+	}
+	if entry.Size < 0 {
+		return errors.Errorf("manifest list entry %s has invalid size %d", entry.Digest, entry.Size)
+	}
+	if e.mimeType == DockerV2ListMediaType {
+		if entry.Platform == nil {
+			return errors.Errorf("manifest list entry %s has no platform, which is required in manifest lists of type %s", entry.Digest, e.mimeType)
+		}
+		if len(entry.Annotations) != 0 {
+			return errors.Errorf("manifest list entry %s has annotations, which are not supported in manifest lists of type %s", entry.Digest, e.mimeType)
+		}
+		if entry.ArtifactType != "" {
+			return errors.Errorf("manifest list entry %s has an artifact type, which is not supported in manifest lists of type %s", entry.Digest, e.mimeType)
+		}
+	}
+	return nil
+}
+
+// index returns the index of the entry with instanceDigest.
+func (e *ListEditor) index(instanceDigest digest.Digest) (int, error) {
+	for i, entry := range e.entries {
+		if entry.Digest == instanceDigest {
+			return i, nil
+		}
+	}
+	return -1, errors.Errorf("unable to find instance %s in manifest list", instanceDigest)
+}
+
+// variantSuffix returns variant formatted for use after an OS/architecture pair.
+func variantSuffix(variant string) string {
+	if variant == "" {
+		return ""
+	}
+	return "/" + variant
+}
+
+// unknownRawFields returns the fields of raw not included in known, or nil if there are none.
+func unknownRawFields(raw map[string]json.RawMessage, known []string) map[string]json.RawMessage {
+	res := dupRawMessageMap(raw)
+	for _, key := range known {
+		delete(res, key)
+	}
+	if len(res) == 0 {
+		return nil
+	}
+	return res
+}
+
+// dupRawMessageMap returns a copy of m, or nil if m has no keys.
+func dupRawMessageMap(m map[string]json.RawMessage) map[string]json.RawMessage {
+	if len(m) == 0 {
+		return nil
+	}
+	res := make(map[string]json.RawMessage, len(m))
+	for k, v := range m {
+		res[k] = v
+	}
+	return res
+}
+
+// setRawField sets fields[key] to the JSON representation of value.
+func setRawField(fields map[string]json.RawMessage, key string, value interface{}) error {
+	raw, err := json.Marshal(value)
+	if err != nil {
+		return err
+	}
+	fields[key] = raw
+	return nil
+}
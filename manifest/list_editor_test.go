@@ -0,0 +1,240 @@
+package manifest
+
+import (
+	"encoding/json"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/opencontainers/go-digest"
+	imgspecv1 "github.com/opencontainers/image-spec/specs-go/v1"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+const (
+	listEditorPPC64Digest    = digest.Digest("sha256:e692418e4cbaf90ca69d05a66403747baa33ee08806650b51fab815ad7fc331f")
+	listEditorAMD64Digest    = digest.Digest("sha256:5b0bcabd1ed22e9fb1310cf6c2dec7cdef19f0ad69efa1f392e94a4333501270")
+	listEditorNewDigest      = digest.Digest("sha256:1bbf5d58d24c47512e234a5623474acf65ae00d4d1414272a893204f44cc680c")
+	listEditorArtifactDigest = digest.Digest("sha256:2de6d3b5a3b1d6a2ad7d8e8d5a8a3f8cf0d27fbb0b3a7ce4d3e5c6f3b9d2e1a0")
+)
+
+func newTestListEditor(t *testing.T, fixture, mimeType string) *ListEditor {
+	manifest, err := os.ReadFile(filepath.Join("fixtures", fixture))
+	require.NoError(t, err)
+	editor, err := NewListEditor(manifest, mimeType)
+	require.NoError(t, err)
+	return editor
+}
+
+// serializeRawFields serializes editor, and returns the top-level fields and the fields of entries.
+func serializeRawFields(t *testing.T, editor *ListEditor) (map[string]json.RawMessage, []map[string]json.RawMessage) {
+	serialized, d, err := editor.Serialize()
+	require.NoError(t, err)
+	assert.Equal(t, digest.FromBytes(serialized), d)
+	_, err = ListFromBlob(serialized, editor.MIMEType())
+	require.NoError(t, err)
+	fields := map[string]json.RawMessage{}
+	err = json.Unmarshal(serialized, &fields)
+	require.NoError(t, err)
+	entries := []map[string]json.RawMessage{}
+	err = json.Unmarshal(fields["manifests"], &entries)
+	require.NoError(t, err)
+	return fields, entries
+}
+
+func TestNewListEditor(t *testing.T) {
+	for _, c := range []struct{ fixture, mimeType string }{
+		{"ociv1.image.index.json", imgspecv1.MediaTypeImageIndex},
+		{"v2list.manifest.json", DockerV2ListMediaType},
+	} {
+		manifest, err := os.ReadFile(filepath.Join("fixtures", c.fixture))
+		require.NoError(t, err)
+		editor, err := NewListEditor(manifest, c.mimeType)
+		require.NoError(t, err, c.fixture)
+		assert.Equal(t, c.mimeType, editor.MIMEType())
+		list, err := ListFromBlob(manifest, c.mimeType)
+		require.NoError(t, err)
+		entries := editor.Entries()
+		require.Len(t, entries, len(list.Instances()))
+		for i, d := range list.Instances() {
+			assert.Equal(t, d, entries[i].Digest)
+		}
+
+		// An unedited list is semantically unchanged
+		serialized, _, err := editor.Serialize()
+		require.NoError(t, err)
+		assert.JSONEq(t, string(manifest), string(serialized), c.fixture)
+	}
+
+	// Not a list, or invalid data
+	manifest, err := os.ReadFile(filepath.Join("fixtures", "ociv1.manifest.json"))
+	require.NoError(t, err)
+	_, err = NewListEditor(manifest, imgspecv1.MediaTypeImageManifest)
+	assert.Error(t, err)
+	_, err = NewListEditor(manifest, imgspecv1.MediaTypeImageIndex)
+	assert.Error(t, err)
+	_, err = NewListEditor([]byte("{"), imgspecv1.MediaTypeImageIndex)
+	assert.Error(t, err)
+}
+
+func TestListEditorPreservesUnknownFields(t *testing.T) {
+	editor := newTestListEditor(t, "ociv1.unknown-fields.image.index.json", imgspecv1.MediaTypeImageIndex)
+	err := editor.SetAnnotation(listEditorPPC64Digest, "com.example.edited", "yes")
+	require.NoError(t, err)
+	err = editor.Add(ListEntry{
+		MediaType: imgspecv1.MediaTypeImageManifest,
+		Digest:    listEditorNewDigest,
+		Size:      150,
+		Platform:  &imgspecv1.Platform{Architecture: "arm64", OS: "linux"},
+	})
+	require.NoError(t, err)
+
+	fields, entries := serializeRawFields(t, editor)
+	assert.JSONEq(t, `{"key":"value"}`, string(fields["com.example.top-level"]))
+	require.Len(t, entries, 4)
+	assert.JSONEq(t, `true`, string(entries[0]["com.example.entry"]))
+	assert.JSONEq(t, `{"architecture":"ppc64le","os":"linux","com.example.platform":"ppc"}`, string(entries[0]["platform"]))
+	assert.JSONEq(t, `{"com.example.edited":"yes"}`, string(entries[0]["annotations"]))
+	assert.JSONEq(t, `{"architecture":"arm64","os":"linux"}`, string(entries[3]["platform"]))
+
+	// Docker-specific platform fields are preserved as well
+	editor = newTestListEditor(t, "v2list.manifest.json", DockerV2ListMediaType)
+	_, entries = serializeRawFields(t, editor)
+	assert.JSONEq(t, `{"architecture":"amd64","os":"linux","features":["sse"]}`, string(entries[1]["platform"]))
+}
+
+func TestListEditorAddRemoveReplace(t *testing.T) {
+	editor := newTestListEditor(t, "ociv1.unknown-fields.image.index.json", imgspecv1.MediaTypeImageIndex)
+
+	// Artifact entries without a platform
+	artifact := ListEntry{
+		MediaType:    imgspecv1.MediaTypeImageManifest,
+		Digest:       listEditorArtifactDigest,
+		Size:         500,
+		ArtifactType: "application/vnd.example.sbom.v1",
+	}
+	err := editor.Add(artifact)
+	require.NoError(t, err)
+	_, entries := serializeRawFields(t, editor)
+	require.Len(t, entries, 4)
+	assert.JSONEq(t, `"application/vnd.example.sbom.v1"`, string(entries[3]["artifactType"]))
+	_, ok := entries[3]["platform"]
+	assert.False(t, ok)
+
+	// Invalid entries are rejected
+	for _, e := range []ListEntry{
+		{MediaType: imgspecv1.MediaTypeImageManifest, Digest: "invalid", Size: 1},
+		{Digest: listEditorNewDigest, Size: 1},
+		{MediaType: imgspecv1.MediaTypeImageManifest, Digest: listEditorNewDigest, Size: -1},
+	} {
+		err := editor.Add(e)
+		assert.Error(t, err)
+	}
+
+	// Platform lookup ignores attestations
+	entry, err := editor.EntryForPlatform(imgspecv1.Platform{Architecture: "amd64", OS: "linux"})
+	require.NoError(t, err)
+	assert.Equal(t, listEditorAMD64Digest, entry.Digest)
+	_, err = editor.EntryForPlatform(imgspecv1.Platform{Architecture: "unknown", OS: "unknown"})
+	assert.Error(t, err)
+	_, err = editor.EntryForPlatform(imgspecv1.Platform{Architecture: "amd64", OS: "linux", Variant: "v3"})
+	assert.Error(t, err)
+
+	// Replacing an entry keeps its position, and updates attestations
+	require.Len(t, editor.Attestations(listEditorAMD64Digest), 1)
+	entry.Digest = listEditorNewDigest
+	entry.Size = 150
+	err = editor.Replace(listEditorAMD64Digest, entry)
+	require.NoError(t, err)
+	assert.Equal(t, listEditorNewDigest, editor.Entries()[1].Digest)
+	assert.Empty(t, editor.Attestations(listEditorAMD64Digest))
+	require.Len(t, editor.Attestations(listEditorNewDigest), 1)
+	err = editor.Replace(listEditorAMD64Digest, entry)
+	assert.Error(t, err)
+
+	// Removing an entry removes its attestations
+	err = editor.RemovePlatform(imgspecv1.Platform{Architecture: "amd64", OS: "linux"})
+	require.NoError(t, err)
+	entries2 := editor.Entries()
+	require.Len(t, entries2, 2)
+	assert.Equal(t, listEditorPPC64Digest, entries2[0].Digest)
+	assert.Equal(t, listEditorArtifactDigest, entries2[1].Digest)
+	err = editor.Remove(listEditorNewDigest)
+	assert.Error(t, err)
+	err = editor.Remove(listEditorArtifactDigest)
+	require.NoError(t, err)
+	_, entries = serializeRawFields(t, editor)
+	require.Len(t, entries, 1)
+	assert.JSONEq(t, `true`, string(entries[0]["com.example.entry"]))
+}
+
+func TestListEditorAttestations(t *testing.T) {
+	editor := newTestListEditor(t, "ociv1.image.index.json", imgspecv1.MediaTypeImageIndex)
+	attestation := ListEntry{
+		MediaType: imgspecv1.MediaTypeImageManifest,
+		Digest:    listEditorNewDigest,
+		Size:      839,
+	}
+	err := editor.AddAttestation(listEditorArtifactDigest, attestation)
+	assert.Error(t, err)
+	err = editor.AddAttestation(listEditorPPC64Digest, attestation)
+	require.NoError(t, err)
+	res := editor.Attestations(listEditorPPC64Digest)
+	require.Len(t, res, 1)
+	assert.True(t, res[0].IsAttestation())
+	assert.Equal(t, map[string]string{
+		AttestationReferenceTypeAnnotation:   AttestationManifestReferenceType,
+		AttestationReferenceDigestAnnotation: listEditorPPC64Digest.String(),
+	}, res[0].Annotations)
+	assert.Equal(t, &imgspecv1.Platform{Architecture: "unknown", OS: "unknown"}, res[0].Platform)
+	assert.Nil(t, attestation.Annotations) // The caller’s value is not modified
+
+	// Values returned by Entries don’t alias the editor’s state
+	res[0].Annotations["com.example.key"] = "value"
+	assert.NotContains(t, editor.Attestations(listEditorPPC64Digest)[0].Annotations, "com.example.key")
+
+	// Annotations can be removed
+	err = editor.DeleteAnnotation(listEditorNewDigest, AttestationReferenceTypeAnnotation)
+	require.NoError(t, err)
+	assert.Empty(t, editor.Attestations(listEditorPPC64Digest))
+	err = editor.DeleteAnnotation(listEditorArtifactDigest, "com.example.key")
+	assert.Error(t, err)
+}
+
+func TestListEditorDockerList(t *testing.T) {
+	editor := newTestListEditor(t, "v2list.manifest.json", DockerV2ListMediaType)
+	instance := editor.Entries()[0].Digest
+
+	err := editor.SetAnnotation(instance, "com.example.key", "value")
+	assert.Error(t, err)
+	err = editor.AddAttestation(instance, ListEntry{
+		MediaType: imgspecv1.MediaTypeImageManifest,
+		Digest:    listEditorNewDigest,
+		Size:      839,
+	})
+	assert.Error(t, err)
+	for _, e := range []ListEntry{
+		{MediaType: DockerV2Schema2MediaType, Digest: listEditorNewDigest, Size: 1},
+		{MediaType: DockerV2Schema2MediaType, Digest: listEditorNewDigest, Size: 1, ArtifactType: "application/vnd.example",
+			Platform: &imgspecv1.Platform{Architecture: "arm64", OS: "linux"}},
+	} {
+		err := editor.Add(e)
+		assert.Error(t, err)
+	}
+
+	err = editor.Add(ListEntry{
+		MediaType: DockerV2Schema2MediaType,
+		Digest:    listEditorNewDigest,
+		Size:      1,
+		Platform:  &imgspecv1.Platform{Architecture: "arm64", OS: "linux", Variant: "v8"},
+	})
+	require.NoError(t, err)
+	serialized, _, err := editor.Serialize()
+	require.NoError(t, err)
+	list, err := Schema2ListFromManifest(serialized)
+	require.NoError(t, err)
+	last := list.Manifests[len(list.Manifests)-1]
+	assert.Equal(t, listEditorNewDigest, last.Digest)
+	assert.Equal(t, Schema2PlatformSpec{Architecture: "arm64", OS: "linux", Variant: "v8"}, last.Platform)
+}
@@ -6,6 +6,7 @@ import (
 	"path/filepath"
 	"testing"
 
+	platformpolicy "github.com/containers/image/v5/pkg/platform"
 	"github.com/containers/image/v5/types"
 	digest "github.com/opencontainers/go-digest"
 	imgspecv1 "github.com/opencontainers/image-spec/specs-go/v1"
@@ -138,3 +139,44 @@ func TestChooseInstance(t *testing.T) {
 		}
 	}
 }
+
+// lastPlatformMatcher is a types.PlatformMatcher which chooses the last candidate.
+type lastPlatformMatcher struct{}
+
+func (lastPlatformMatcher) ChoosePlatform(sys *types.SystemContext, candidates []imgspecv1.Platform) (int, error) {
+	return len(candidates) - 1, nil
+}
+
+func TestChooseInstancePlatformMatcher(t *testing.T) {
+	rawManifest, err := os.ReadFile(filepath.Join("..", "image", "fixtures", "schema2list-variants.json"))
+	require.NoError(t, err)
+	list, err := ListFromBlob(rawManifest, GuessMIMEType(rawManifest))
+	require.NoError(t, err)
+
+	// By default, an image without a variant is preferred for an unspecified variant
+	sys := &types.SystemContext{ArchitectureChoice: "arm", OSChoice: "linux"}
+	d, err := list.ChooseInstance(sys)
+	require.NoError(t, err)
+	assert.Equal(t, digest.Digest("sha256:c84b0a3a07b628bc4d62e5047d0f8dff80f7c00979e1e28a821a033ecda8fe53"), d)
+	// … which can be changed by a policy
+	sys.PlatformMatcher = &platformpolicy.Policy{RequireVariant: true}
+	d, err = list.ChooseInstance(sys)
+	require.NoError(t, err)
+	assert.Equal(t, digest.Digest("sha256:f365626a556e58189fc21d099fc64603db0f440bff07f77c740989515c544a39"), d)
+	sys.PlatformMatcher = &platformpolicy.Policy{VariantPreferences: map[string][]string{"arm": {"v7"}}}
+	_, err = list.ChooseInstance(sys)
+	assert.Error(t, err)
+
+	// Custom matchers are used, also for OCI indexes
+	sys.PlatformMatcher = lastPlatformMatcher{}
+	d, err = list.ChooseInstance(sys)
+	require.NoError(t, err)
+	assert.Equal(t, digest.Digest("sha256:c84b0a3a07b628bc4d62e5047d0f8dff80f7c00979e1e28a821a033ecda8fe53"), d)
+	rawManifest, err = os.ReadFile(filepath.Join("..", "image", "fixtures", "oci1index.json"))
+	require.NoError(t, err)
+	list, err = ListFromBlob(rawManifest, GuessMIMEType(rawManifest))
+	require.NoError(t, err)
+	d, err = list.ChooseInstance(sys)
+	require.NoError(t, err)
+	assert.Equal(t, digest.Digest("sha256:5b0bcabd1ed22e9fb1310cf6c2dec7cdef19f0ad69efa1f392e94a4333501270"), d)
+}
@@ -3,6 +3,7 @@ package manifest
 import (
 	"encoding/json"
 	"fmt"
+	"regexp"
 	"strings"
 
 	compressiontypes "github.com/containers/image/v5/pkg/compression/types"
@@ -29,6 +30,10 @@ func BlobInfoFromOCI1Descriptor(desc imgspecv1.Descriptor) types.BlobInfo {
 // The underlying data from imgspecv1.Manifest is also available.
 type OCI1 struct {
 	imgspecv1.Manifest
+	// ArtifactType is the type of the artifact described by the manifest, if any (image-spec v1.1).
+	ArtifactType string `json:"artifactType,omitempty"`
+	// Subject refers to the manifest this manifest is associated with, e.g. as a signature or an SBOM, if any (image-spec v1.1).
+	Subject *imgspecv1.Descriptor `json:"subject,omitempty"`
 }
 
 // SupportedOCI1MediaType checks if the specified string is a supported OCI1
@@ -58,13 +63,16 @@ func OCI1FromManifest(manifest []byte) (*OCI1, error) {
 		allowedFieldConfig|allowedFieldLayers); err != nil {
 		return nil, err
 	}
+	if err := validateOCI1ArtifactFields(oci1.ArtifactType, oci1.Subject); err != nil {
+		return nil, err
+	}
 	return &oci1, nil
 }
 
 // OCI1FromComponents creates an OCI1 manifest instance from the supplied data.
 func OCI1FromComponents(config imgspecv1.Descriptor, layers []imgspecv1.Descriptor) *OCI1 {
 	return &OCI1{
-		imgspecv1.Manifest{
+		Manifest: imgspecv1.Manifest{
 			Versioned: specs.Versioned{SchemaVersion: 2},
 			MediaType: imgspecv1.MediaTypeImageManifest,
 			Config:    config,
@@ -73,11 +81,136 @@ func OCI1FromComponents(config imgspecv1.Descriptor, layers []imgspecv1.Descript
 	}
 }
 
+// OCI1ArtifactFromComponents creates an OCI1 manifest instance describing an artifact (image-spec v1.1) from the supplied data.
+// If config is nil, the empty descriptor (see OCI1EmptyDescriptor) is used, and artifactType must be set; if layers is empty,
+// a single empty descriptor is used as the layer. subject and annotations are optional.
+func OCI1ArtifactFromComponents(artifactType string, config *imgspecv1.Descriptor, layers []imgspecv1.Descriptor,
+	subject *imgspecv1.Descriptor, annotations map[string]string) (*OCI1, error) {
+	configDesc := OCI1EmptyDescriptor()
+	if config != nil {
+		configDesc = *config
+	}
+	if artifactType == "" && configDesc.MediaType == MediaTypeOCI1Empty {
+		return nil, errors.New("an artifact type must be set for artifacts with an empty config")
+	}
+	if len(layers) == 0 {
+		layers = []imgspecv1.Descriptor{OCI1EmptyDescriptor()}
+	}
+	if err := validateOCI1ArtifactFields(artifactType, subject); err != nil {
+		return nil, err
+	}
+	res := OCI1FromComponents(configDesc, layers)
+	res.Annotations = annotations
+	res.ArtifactType = artifactType
+	res.Subject = subject
+	return res, nil
+}
+
 // OCI1Clone creates a copy of the supplied OCI1 manifest.
 func OCI1Clone(src *OCI1) *OCI1 {
 	return &OCI1{
-		Manifest: src.Manifest,
+		Manifest:     src.Manifest,
+		ArtifactType: src.ArtifactType,
+		Subject:      ociDescriptorClone(src.Subject),
+	}
+}
+
+// ociDescriptorClone returns a deep copy of d, or nil if d is nil.
+func ociDescriptorClone(d *imgspecv1.Descriptor) *imgspecv1.Descriptor {
+	if d == nil {
+		return nil
+	}
+	res := *d
+	res.URLs = dupStringSlice(d.URLs)
+	res.Annotations = dupStringStringMap(d.Annotations)
+	if d.Platform != nil {
+		platform := *d.Platform
+		platform.OSFeatures = dupStringSlice(d.Platform.OSFeatures)
+		res.Platform = &platform
+	}
+	return &res
+}
+
+// NonImageArtifactError is returned by image-specific operations on a manifest which does not describe a container image,
+// but some other kind of OCI artifact (e.g. a Helm chart or a WASM module), as indicated by the media type of its config.
+type NonImageArtifactError struct {
+	// ConfigMediaType is the media type of the config of the artifact.
+	ConfigMediaType string
+}
+
+func (e NonImageArtifactError) Error() string {
+	return fmt.Sprintf("unsupported image-specific operation on an artifact with config type %q", e.ConfigMediaType)
+}
+
+// IsImage returns true if m describes a container image, i.e. its config is an OCI image config;
+// false if it describes some other kind of artifact, e.g. one using the image-spec v1.1 empty config descriptor.
+func (m *OCI1) IsImage() bool {
+	return m.Config.MediaType == imgspecv1.MediaTypeImageConfig
+}
+
+// EffectiveArtifactType returns the type of the artifact described by m, i.e. its artifactType if set, or the media type of its config
+// otherwise; this is the value image-spec v1.1 uses in lists of referrers.
+func (m *OCI1) EffectiveArtifactType() string {
+	if m.ArtifactType != "" {
+		return m.ArtifactType
 	}
+	return m.Config.MediaType
+}
+
+// MediaTypeOCI1Empty is the media type of the empty descriptor (image-spec v1.1), used e.g. as the config of artifacts which
+// have no meaningful config, or as the only layer of artifacts which have no content.
+const MediaTypeOCI1Empty = "application/vnd.oci.empty.v1+json"
+
+// OCI1EmptyJSON is the contents of the blob referenced by the empty descriptor.
+var OCI1EmptyJSON = []byte("{}")
+
+// OCI1EmptyDescriptor returns the empty descriptor (image-spec v1.1).
+// Note that the blob it references, OCI1EmptyJSON, must be stored like any other blob.
+func OCI1EmptyDescriptor() imgspecv1.Descriptor {
+	return imgspecv1.Descriptor{
+		MediaType: MediaTypeOCI1Empty,
+		Digest:    digest.FromBytes(OCI1EmptyJSON),
+		Size:      int64(len(OCI1EmptyJSON)),
+	}
+}
+
+// IsOCI1EmptyDescriptor returns true if d is the empty descriptor (image-spec v1.1).
+func IsOCI1EmptyDescriptor(d imgspecv1.Descriptor) bool {
+	return d.MediaType == MediaTypeOCI1Empty && d.Digest == digest.FromBytes(OCI1EmptyJSON) && d.Size == int64(len(OCI1EmptyJSON))
+}
+
+// ociMediaTypeRegexp matches values valid as media types in OCI manifests, per RFC 6838 (as used by image-spec).
+var ociMediaTypeRegexp = regexp.MustCompile(`^[A-Za-z0-9][A-Za-z0-9!#$&^_.+-]{0,126}/[A-Za-z0-9][A-Za-z0-9!#$&^_.+-]{0,126}$`)
+
+// validateOCI1ArtifactFields returns an error if artifactType or subject (image-spec v1.1 fields) of a manifest or an index are invalid.
+func validateOCI1ArtifactFields(artifactType string, subject *imgspecv1.Descriptor) error {
+	if artifactType != "" && !ociMediaTypeRegexp.MatchString(artifactType) {
+		return errors.Errorf("invalid OCI artifact type %q", artifactType)
+	}
+	if subject != nil {
+		if !ociMediaTypeRegexp.MatchString(subject.MediaType) {
+			return errors.Errorf("invalid media type %q of OCI subject", subject.MediaType)
+		}
+		if err := subject.Digest.Validate(); err != nil {
+			return errors.Wrapf(err, "invalid digest %q of OCI subject", subject.Digest)
+		}
+		if subject.Size < 0 {
+			return errors.Errorf("invalid size %d of OCI subject", subject.Size)
+		}
+	}
+	return nil
+}
+
+// unrepresentableOCIFieldsError returns an error if a manifest or an index with artifactType and subject (image-spec v1.1 fields)
+// would lose them by conversion to mimeType, which can't represent them; nil otherwise.
+func unrepresentableOCIFieldsError(artifactType string, subject *imgspecv1.Descriptor, mimeType string) error {
+	if subject != nil {
+		return errors.Errorf("the OCI subject field (referring to %s) can not be represented in %s", subject.Digest, mimeType)
+	}
+	if artifactType != "" {
+		return errors.Errorf("the OCI artifact type %q can not be represented in %s", artifactType, mimeType)
+	}
+	return nil
 }
 
 // ConfigInfo returns a complete BlobInfo for the separate config object, or a BlobInfo{Digest:""} if there isn't a separate object.
@@ -123,6 +256,10 @@ func (m *OCI1) UpdateLayerInfos(layerInfos []types.BlobInfo) error {
 	m.Layers = make([]imgspecv1.Descriptor, len(layerInfos))
 	for i, info := range layerInfos {
 		mimeType := original[i].MediaType
+		if !m.IsImage() && (info.CompressionOperation != types.PreserveOriginal || info.CompressionAlgorithm != nil ||
+			info.CryptoOperation != types.PreserveOriginalCrypto) {
+			return NonImageArtifactError{ConfigMediaType: m.Config.MediaType}
+		}
 		if info.CryptoOperation == types.Decrypt {
 			decMimeType, err := getDecryptedMediaType(mimeType)
 			if err != nil {
@@ -158,7 +295,11 @@ func (m *OCI1) Serialize() ([]byte, error) {
 }
 
 // Inspect returns various information for (skopeo inspect) parsed from the manifest and configuration.
+// It returns NonImageArtifactError if m does not describe a container image.
 func (m *OCI1) Inspect(configGetter func(types.BlobInfo) ([]byte, error)) (*types.ImageInspectInfo, error) {
+	if !m.IsImage() {
+		return nil, NonImageArtifactError{ConfigMediaType: m.Config.MediaType}
+	}
 	config, err := configGetter(m.ConfigInfo())
 	if err != nil {
 		return nil, err
@@ -177,6 +318,7 @@ func (m *OCI1) Inspect(configGetter func(types.BlobInfo) ([]byte, error)) (*type
 		DockerVersion: d1.DockerVersion,
 		Labels:        v1.Config.Labels,
 		Architecture:  v1.Architecture,
+		Variant:       v1.Variant,
 		Os:            v1.OS,
 		Layers:        layerInfosToStrings(m.LayerInfos()),
 		Env:           v1.Config.Env,
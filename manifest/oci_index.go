@@ -5,7 +5,7 @@ import (
 	"fmt"
 	"runtime"
 
-	platform "github.com/containers/image/v5/internal/pkg/platform"
+	platformpolicy "github.com/containers/image/v5/pkg/platform"
 	"github.com/containers/image/v5/types"
 	"github.com/opencontainers/go-digest"
 	imgspec "github.com/opencontainers/image-spec/specs-go"
@@ -17,6 +17,10 @@ import (
 // provide methods for.
 type OCI1Index struct {
 	imgspecv1.Index
+	// ArtifactType is the type of the artifact described by the index, if any (image-spec v1.1).
+	ArtifactType string `json:"artifactType,omitempty"`
+	// Subject refers to the manifest this index is associated with, if any (image-spec v1.1).
+	Subject *imgspecv1.Descriptor `json:"subject,omitempty"`
 }
 
 // MIMEType returns the MIME type of this particular manifest index.
@@ -72,27 +76,29 @@ func (index *OCI1Index) UpdateInstances(updates []ListUpdate) error {
 
 // ChooseInstance parses blob as an oci v1 manifest index, and returns the digest
 // of the image which is appropriate for the current environment.
+// Instances which don’t specify a platform are used only if no instance matches the wanted platform.
 func (index *OCI1Index) ChooseInstance(ctx *types.SystemContext) (digest.Digest, error) {
-	wantedPlatforms, err := platform.WantedPlatforms(ctx)
+	candidates := []imgspecv1.Platform{}
+	candidateDigests := []digest.Digest{}
+	for _, d := range index.Manifests {
+		if d.Platform == nil {
+			continue
+		}
+		candidates = append(candidates, imgspecv1.Platform{
+			Architecture: d.Platform.Architecture,
+			OS:           d.Platform.OS,
+			OSVersion:    d.Platform.OSVersion,
+			OSFeatures:   dupStringSlice(d.Platform.OSFeatures),
+			Variant:      d.Platform.Variant,
+		})
+		candidateDigests = append(candidateDigests, d.Digest)
+	}
+	chosen, err := platformpolicy.Choose(ctx, candidates)
 	if err != nil {
-		return "", errors.Wrapf(err, "getting platform information %#v", ctx)
+		return "", err
 	}
-	for _, wantedPlatform := range wantedPlatforms {
-		for _, d := range index.Manifests {
-			if d.Platform == nil {
-				continue
-			}
-			imagePlatform := imgspecv1.Platform{
-				Architecture: d.Platform.Architecture,
-				OS:           d.Platform.OS,
-				OSVersion:    d.Platform.OSVersion,
-				OSFeatures:   dupStringSlice(d.Platform.OSFeatures),
-				Variant:      d.Platform.Variant,
-			}
-			if platform.MatchesPlatform(imagePlatform, wantedPlatform) {
-				return d.Digest, nil
-			}
-		}
+	if chosen != -1 {
+		return candidateDigests[chosen], nil
 	}
 
 	for _, d := range index.Manifests {
@@ -100,7 +106,7 @@ func (index *OCI1Index) ChooseInstance(ctx *types.SystemContext) (digest.Digest,
 			return d.Digest, nil
 		}
 	}
-	return "", fmt.Errorf("no image found in image index for architecture %s, variant %q, OS %s", wantedPlatforms[0].Architecture, wantedPlatforms[0].Variant, wantedPlatforms[0].OS)
+	return "", noMatchingInstanceError("image index", ctx)
 }
 
 // Serialize returns the index in a blob format.
@@ -117,7 +123,7 @@ func (index *OCI1Index) Serialize() ([]byte, error) {
 // supplied data.
 func OCI1IndexFromComponents(components []imgspecv1.Descriptor, annotations map[string]string) *OCI1Index {
 	index := OCI1Index{
-		imgspecv1.Index{
+		Index: imgspecv1.Index{
 			Versioned:   imgspec.Versioned{SchemaVersion: 2},
 			MediaType:   imgspecv1.MediaTypeImageIndex,
 			Manifests:   make([]imgspecv1.Descriptor, len(components)),
@@ -150,7 +156,10 @@ func OCI1IndexFromComponents(components []imgspecv1.Descriptor, annotations map[
 
 // OCI1IndexClone creates a deep copy of the passed-in index.
 func OCI1IndexClone(index *OCI1Index) *OCI1Index {
-	return OCI1IndexFromComponents(index.Manifests, index.Annotations)
+	res := OCI1IndexFromComponents(index.Manifests, index.Annotations)
+	res.ArtifactType = index.ArtifactType
+	res.Subject = ociDescriptorClone(index.Subject)
+	return res
 }
 
 // ToOCI1Index returns the index encoded as an OCI1 index.
@@ -159,7 +168,11 @@ func (index *OCI1Index) ToOCI1Index() (*OCI1Index, error) {
 }
 
 // ToSchema2List returns the index encoded as a Schema2 list.
+// It fails if the index uses image-spec v1.1 fields which can't be represented in a Schema2 list.
 func (index *OCI1Index) ToSchema2List() (*Schema2List, error) {
+	if err := unrepresentableOCIFieldsError(index.ArtifactType, index.Subject, DockerV2ListMediaType); err != nil {
+		return nil, err
+	}
 	components := make([]Schema2ManifestDescriptor, 0, len(index.Manifests))
 	for _, manifest := range index.Manifests {
 		platform := manifest.Platform
@@ -208,6 +221,9 @@ func OCI1IndexFromManifest(manifest []byte) (*OCI1Index, error) {
 		allowedFieldManifests); err != nil {
 		return nil, err
 	}
+	if err := validateOCI1ArtifactFields(index.ArtifactType, index.Subject); err != nil {
+		return nil, err
+	}
 	return &index, nil
 }
 
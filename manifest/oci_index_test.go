@@ -3,8 +3,11 @@ package manifest
 import (
 	"os"
 	"path/filepath"
+	"strings"
 	"testing"
 
+	imgspecv1 "github.com/opencontainers/image-spec/specs-go/v1"
+	"github.com/stretchr/testify/assert"
 	"github.com/stretchr/testify/require"
 )
 
@@ -26,3 +29,69 @@ func TestOCI1IndexFromManifest(t *testing.T) {
 	// Extra fields are rejected
 	testValidManifestWithExtraFieldsIsRejected(t, parser, validManifest, []string{"config", "fsLayers", "history", "layers"})
 }
+
+func TestOCI1IndexSubjectAndArtifactType(t *testing.T) {
+	manifest, err := os.ReadFile(filepath.Join("fixtures", "ociv1.subject.image.index.json"))
+	require.NoError(t, err)
+	index, err := OCI1IndexFromManifest(manifest)
+	require.NoError(t, err)
+	assert.Equal(t, "application/vnd.example.signatures.v1", index.ArtifactType)
+	require.NotNil(t, index.Subject)
+	assert.Equal(t, imgspecv1.Descriptor{
+		MediaType: imgspecv1.MediaTypeImageManifest,
+		Size:      7682,
+		Digest:    "sha256:5b0bcabd1ed22e9fb1310cf6c2dec7cdef19f0ad69efa1f392e94a4333501270",
+	}, *index.Subject)
+
+	// The fields survive cloning, editing and serialization
+	for _, list := range []List{index.Clone(), mustConvertToMIMEType(t, index, imgspecv1.MediaTypeImageIndex)} {
+		err = list.UpdateInstances([]ListUpdate{{
+			Digest:    "sha256:1bbf5d58d24c47512e234a5623474acf65ae00d4d1414272a893204f44cc680c",
+			Size:      150,
+			MediaType: imgspecv1.MediaTypeImageManifest,
+		}})
+		require.NoError(t, err)
+		serialized, err := list.Serialize()
+		require.NoError(t, err)
+		res, err := OCI1IndexFromManifest(serialized)
+		require.NoError(t, err)
+		assert.Equal(t, index.ArtifactType, res.ArtifactType)
+		assert.Equal(t, index.Subject, res.Subject)
+	}
+	clone := OCI1IndexClone(index)
+	clone.Subject.Size = 1
+	assert.Equal(t, int64(7682), index.Subject.Size)
+
+	// The fields can't be represented in schema2 lists
+	_, err = index.ToSchema2List()
+	assert.Error(t, err)
+	_, err = index.ConvertToMIMEType(DockerV2ListMediaType)
+	assert.Error(t, err)
+	index.Subject = nil
+	_, err = index.ToSchema2List()
+	assert.Error(t, err)
+	index.ArtifactType = ""
+	_, err = index.ToSchema2List()
+	assert.NoError(t, err)
+}
+
+func mustConvertToMIMEType(t *testing.T, list List, mimeType string) List {
+	res, err := list.ConvertToMIMEType(mimeType)
+	require.NoError(t, err)
+	return res
+}
+
+func TestOCI1IndexFromManifestInvalidArtifactFields(t *testing.T) {
+	validManifest, err := os.ReadFile(filepath.Join("fixtures", "ociv1.subject.image.index.json"))
+	require.NoError(t, err)
+	_, err = OCI1IndexFromManifest(validManifest)
+	require.NoError(t, err)
+	for _, invalid := range []string{
+		strings.Replace(string(validManifest), `"application/vnd.example.signatures.v1"`, `"invalid"`, 1),
+		strings.Replace(string(validManifest), `"sha256:5b0bcabd1ed22e9fb1310cf6c2dec7cdef19f0ad69efa1f392e94a4333501270"`, `"invalid"`, -1),
+	} {
+		require.NotEqual(t, string(validManifest), invalid)
+		_, err := OCI1IndexFromManifest([]byte(invalid))
+		assert.Error(t, err)
+	}
+}
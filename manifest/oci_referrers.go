@@ -0,0 +1,121 @@
+package manifest
+
+import (
+	"encoding/json"
+
+	"github.com/opencontainers/go-digest"
+	imgspec "github.com/opencontainers/image-spec/specs-go"
+	imgspecv1 "github.com/opencontainers/image-spec/specs-go/v1"
+	"github.com/pkg/errors"
+)
+
+// OCI1Referrer is a descriptor of a manifest which refers to another manifest using its subject field (image-spec v1.1),
+// as returned by the OCI referrers API.
+type OCI1Referrer struct {
+	imgspecv1.Descriptor
+	// ArtifactType is the artifactType of the referring manifest, or the media type of its config if it has no artifactType.
+	ArtifactType string `json:"artifactType,omitempty"`
+}
+
+// OCI1ReferrersIndex is an OCI image index listing referrers of a manifest, as returned by the OCI referrers API,
+// or stored under the referrers tag schema fallback tag.
+type OCI1ReferrersIndex struct {
+	imgspec.Versioned
+	MediaType string         `json:"mediaType,omitempty"`
+	Manifests []OCI1Referrer `json:"manifests"`
+}
+
+// NewOCI1ReferrersIndex returns an OCI1ReferrersIndex listing referrers.
+func NewOCI1ReferrersIndex(referrers []OCI1Referrer) *OCI1ReferrersIndex {
+	if referrers == nil {
+		referrers = []OCI1Referrer{}
+	}
+	return &OCI1ReferrersIndex{
+		Versioned: imgspec.Versioned{SchemaVersion: 2},
+		MediaType: imgspecv1.MediaTypeImageIndex,
+		Manifests: referrers,
+	}
+}
+
+// OCI1ReferrersIndexFromManifest creates an OCI1ReferrersIndex from a manifest blob.
+func OCI1ReferrersIndexFromManifest(manifest []byte) (*OCI1ReferrersIndex, error) {
+	index := OCI1ReferrersIndex{}
+	if err := json.Unmarshal(manifest, &index); err != nil {
+		return nil, errors.Wrapf(err, "unmarshaling OCI1ReferrersIndex %q", string(manifest))
+	}
+	if index.Manifests == nil {
+		index.Manifests = []OCI1Referrer{}
+	}
+	return &index, nil
+}
+
+// Serialize returns the index in a blob format.
+func (index *OCI1ReferrersIndex) Serialize() ([]byte, error) {
+	buf, err := json.Marshal(index)
+	if err != nil {
+		return nil, errors.Wrapf(err, "marshaling OCI1ReferrersIndex %#v", index)
+	}
+	return buf, nil
+}
+
+// FilterByArtifactType returns the referrers in index with artifactType, or all referrers if artifactType is "".
+func (index *OCI1ReferrersIndex) FilterByArtifactType(artifactType string) []OCI1Referrer {
+	res := []OCI1Referrer{}
+	for _, r := range index.Manifests {
+		if artifactType == "" || r.ArtifactType == artifactType {
+			res = append(res, r)
+		}
+	}
+	return res
+}
+
+// Add adds referrer to index, unless it is already listed; it returns true if index was modified.
+func (index *OCI1ReferrersIndex) Add(referrer OCI1Referrer) bool {
+	for _, r := range index.Manifests {
+		if r.Digest == referrer.Digest {
+			return false
+		}
+	}
+	index.Manifests = append(index.Manifests, referrer)
+	return true
+}
+
+// OCI1SubjectReferrer parses manifest, an OCI image manifest or index, and returns its subject, if any (nil otherwise),
+// and a descriptor of manifest suitable for listing it as a referrer of that subject.
+func OCI1SubjectReferrer(manifest []byte) (*imgspecv1.Descriptor, OCI1Referrer, error) {
+	var parsed struct {
+		MediaType    string                 `json:"mediaType,omitempty"`
+		ArtifactType string                 `json:"artifactType,omitempty"`
+		Config       *imgspecv1.Descriptor  `json:"config,omitempty"`
+		Manifests    []imgspecv1.Descriptor `json:"manifests,omitempty"`
+		Subject      *imgspecv1.Descriptor  `json:"subject,omitempty"`
+		Annotations  map[string]string      `json:"annotations,omitempty"`
+	}
+	if err := json.Unmarshal(manifest, &parsed); err != nil {
+		return nil, OCI1Referrer{}, errors.Wrap(err, "parsing manifest subject")
+	}
+	mimeType := parsed.MediaType
+	if mimeType == "" { // The mediaType field is only recommended by image-spec; the subject field is only defined for OCI types.
+		if parsed.Manifests != nil {
+			mimeType = imgspecv1.MediaTypeImageIndex
+		} else {
+			mimeType = imgspecv1.MediaTypeImageManifest
+		}
+	}
+	if (mimeType != imgspecv1.MediaTypeImageManifest && mimeType != imgspecv1.MediaTypeImageIndex) || parsed.Subject == nil {
+		return nil, OCI1Referrer{}, nil
+	}
+	artifactType := parsed.ArtifactType
+	if artifactType == "" && parsed.Config != nil {
+		artifactType = parsed.Config.MediaType
+	}
+	return parsed.Subject, OCI1Referrer{
+		Descriptor: imgspecv1.Descriptor{
+			MediaType:   mimeType,
+			Digest:      digest.FromBytes(manifest),
+			Size:        int64(len(manifest)),
+			Annotations: parsed.Annotations,
+		},
+		ArtifactType: artifactType,
+	}, nil
+}
@@ -0,0 +1,87 @@
+package manifest
+
+import (
+	"testing"
+
+	"github.com/opencontainers/go-digest"
+	imgspecv1 "github.com/opencontainers/image-spec/specs-go/v1"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestOCI1SubjectReferrer(t *testing.T) {
+	const subjectDigest = "sha256:0123456789abcdef0123456789abcdef0123456789abcdef0123456789abcdef"
+	for _, c := range []struct {
+		manifest             string
+		mimeType             string
+		expectedArtifactType string
+	}{
+		{ // Artifact type from the artifactType field
+			`{"schemaVersion":2,"mediaType":"application/vnd.oci.image.manifest.v1+json","artifactType":"application/vnd.example",` +
+				`"config":{"mediaType":"application/vnd.oci.empty.v1+json","digest":"sha256:44136fa355b3678a1146ad16f7e8649e94fb4fc21fe77e8310c060f61caaff8a","size":2},` +
+				`"layers":[],"subject":{"mediaType":"application/vnd.oci.image.manifest.v1+json","digest":"` + subjectDigest + `","size":1},` +
+				`"annotations":{"a":"b"}}`,
+			imgspecv1.MediaTypeImageManifest, "application/vnd.example",
+		},
+		{ // Artifact type from the config, no mediaType
+			`{"schemaVersion":2,"config":{"mediaType":"application/vnd.example.config","digest":"sha256:44136fa355b3678a1146ad16f7e8649e94fb4fc21fe77e8310c060f61caaff8a","size":2},` +
+				`"layers":[],"subject":{"digest":"` + subjectDigest + `","size":1},"annotations":{"a":"b"}}`,
+			imgspecv1.MediaTypeImageManifest, "application/vnd.example.config",
+		},
+		{ // An index
+			`{"schemaVersion":2,"artifactType":"application/vnd.example","manifests":[],` +
+				`"subject":{"digest":"` + subjectDigest + `","size":1},"annotations":{"a":"b"}}`,
+			imgspecv1.MediaTypeImageIndex, "application/vnd.example",
+		},
+	} {
+		subject, referrer, err := OCI1SubjectReferrer([]byte(c.manifest))
+		require.NoError(t, err, c.manifest)
+		require.NotNil(t, subject, c.manifest)
+		assert.Equal(t, digest.Digest(subjectDigest), subject.Digest)
+		assert.Equal(t, OCI1Referrer{
+			Descriptor: imgspecv1.Descriptor{
+				MediaType:   c.mimeType,
+				Digest:      digest.FromString(c.manifest),
+				Size:        int64(len(c.manifest)),
+				Annotations: map[string]string{"a": "b"},
+			},
+			ArtifactType: c.expectedArtifactType,
+		}, referrer)
+	}
+
+	// No subject
+	for _, m := range []string{
+		`{"schemaVersion":2,"mediaType":"application/vnd.oci.image.manifest.v1+json","config":{},"layers":[]}`,
+		`{"schemaVersion":2,"mediaType":"application/vnd.docker.distribution.manifest.v2+json","subject":{"digest":"` + subjectDigest + `","size":1}}`,
+	} {
+		subject, _, err := OCI1SubjectReferrer([]byte(m))
+		require.NoError(t, err, m)
+		assert.Nil(t, subject, m)
+	}
+
+	_, _, err := OCI1SubjectReferrer([]byte("invalid"))
+	assert.Error(t, err)
+}
+
+func TestOCI1ReferrersIndex(t *testing.T) {
+	r1 := OCI1Referrer{Descriptor: imgspecv1.Descriptor{Digest: digest.FromString("1")}, ArtifactType: "a"}
+	r2 := OCI1Referrer{Descriptor: imgspecv1.Descriptor{Digest: digest.FromString("2")}, ArtifactType: "b"}
+	index := NewOCI1ReferrersIndex(nil)
+	assert.True(t, index.Add(r1))
+	assert.True(t, index.Add(r2))
+	assert.False(t, index.Add(r1))
+
+	blob, err := index.Serialize()
+	require.NoError(t, err)
+	assert.Equal(t, imgspecv1.MediaTypeImageIndex, GuessMIMEType(blob))
+	parsed, err := OCI1ReferrersIndexFromManifest(blob)
+	require.NoError(t, err)
+	assert.Equal(t, index, parsed)
+	assert.Equal(t, []OCI1Referrer{r1, r2}, parsed.FilterByArtifactType(""))
+	assert.Equal(t, []OCI1Referrer{r2}, parsed.FilterByArtifactType("b"))
+	assert.Equal(t, []OCI1Referrer{}, parsed.FilterByArtifactType("c"))
+
+	parsed, err = OCI1ReferrersIndexFromManifest([]byte(`{"schemaVersion":2}`))
+	require.NoError(t, err)
+	assert.Equal(t, []OCI1Referrer{}, parsed.Manifests)
+}
@@ -1,12 +1,14 @@
 package manifest
 
 import (
+	"encoding/json"
 	"os"
 	"path/filepath"
 	"testing"
 
 	"github.com/containers/image/v5/pkg/compression"
 	"github.com/containers/image/v5/types"
+	"github.com/opencontainers/go-digest"
 	imgspecv1 "github.com/opencontainers/image-spec/specs-go/v1"
 	"github.com/stretchr/testify/assert"
 	"github.com/stretchr/testify/require"
@@ -438,3 +440,171 @@ func TestUpdateLayerInfosOCINondistributableGzipToUncompressed(t *testing.T) {
 
 	assert.Equal(t, string(expectedManifestBytes), string(updatedManifestBytes))
 }
+
+func TestOCI1SubjectAndArtifactType(t *testing.T) {
+	manifest, err := os.ReadFile(filepath.Join("fixtures", "ociv1.subject.manifest.json"))
+	require.NoError(t, err)
+	m, err := OCI1FromManifest(manifest)
+	require.NoError(t, err)
+	assert.Equal(t, "application/vnd.example.sbom.v1", m.ArtifactType)
+	require.NotNil(t, m.Subject)
+	assert.Equal(t, imgspecv1.Descriptor{
+		MediaType: imgspecv1.MediaTypeImageManifest,
+		Size:      7143,
+		Digest:    "sha256:e692418e4cbaf90ca69d05a66403747baa33ee08806650b51fab815ad7fc331f",
+	}, *m.Subject)
+
+	// The fields survive serialization, including after edits
+	err = m.UpdateLayerInfos([]types.BlobInfo{{
+		Digest:    "sha256:1bbf5d58d24c47512e234a5623474acf65ae00d4d1414272a893204f44cc680c",
+		Size:      150,
+		MediaType: "application/vnd.example.sbom.v1+json",
+	}})
+	require.NoError(t, err)
+	serialized, err := m.Serialize()
+	require.NoError(t, err)
+	var original, res map[string]interface{}
+	err = json.Unmarshal(manifest, &original)
+	require.NoError(t, err)
+	err = json.Unmarshal(serialized, &res)
+	require.NoError(t, err)
+	assert.Equal(t, original["artifactType"], res["artifactType"])
+	assert.Equal(t, original["subject"], res["subject"])
+
+	// Clones are deep copies
+	m.Subject.Annotations = map[string]string{"a": "b"}
+	clone := OCI1Clone(m)
+	assert.Equal(t, m.ArtifactType, clone.ArtifactType)
+	assert.Equal(t, m.Subject, clone.Subject)
+	clone.Subject.Annotations["a"] = "c"
+	clone.Subject.Digest = "sha256:5b0bcabd1ed22e9fb1310cf6c2dec7cdef19f0ad69efa1f392e94a4333501270"
+	assert.Equal(t, "b", m.Subject.Annotations["a"])
+	assert.Equal(t, digest.Digest("sha256:e692418e4cbaf90ca69d05a66403747baa33ee08806650b51fab815ad7fc331f"), m.Subject.Digest)
+
+	// Manifests without the fields don't gain them
+	manifest, err = os.ReadFile(filepath.Join("fixtures", "ociv1.manifest.json"))
+	require.NoError(t, err)
+	m, err = OCI1FromManifest(manifest)
+	require.NoError(t, err)
+	serialized, err = m.Serialize()
+	require.NoError(t, err)
+	assert.NotContains(t, string(serialized), "artifactType")
+	assert.NotContains(t, string(serialized), "subject")
+}
+
+func TestOCI1NonImageArtifact(t *testing.T) {
+	manifest, err := os.ReadFile(filepath.Join("fixtures", "ociv1.manifest.json"))
+	require.NoError(t, err)
+	m, err := OCI1FromManifest(manifest)
+	require.NoError(t, err)
+	assert.True(t, m.IsImage())
+
+	manifest, err = os.ReadFile(filepath.Join("fixtures", "ociv1.subject.manifest.json"))
+	require.NoError(t, err)
+	m, err = OCI1FromManifest(manifest)
+	require.NoError(t, err)
+	assert.False(t, m.IsImage())
+
+	// Image-specific operations fail
+	_, err = m.Inspect(func(types.BlobInfo) ([]byte, error) { return []byte("{}"), nil })
+	assert.Equal(t, NonImageArtifactError{ConfigMediaType: "application/vnd.oci.empty.v1+json"}, err)
+	for _, info := range []types.BlobInfo{
+		{CompressionOperation: types.Compress, CompressionAlgorithm: &compression.Gzip},
+		{CompressionOperation: types.PreserveOriginal, CompressionAlgorithm: &compression.Gzip},
+		{CryptoOperation: types.Encrypt},
+	} {
+		info.Digest = "sha256:6a5a5368e0c2d3e5909184fa28ddfd56072e7ff3ee9a945876f7eee5896ef5bb"
+		info.Size = 1024
+		err = m.UpdateLayerInfos([]types.BlobInfo{info})
+		assert.Equal(t, NonImageArtifactError{ConfigMediaType: "application/vnd.oci.empty.v1+json"}, err)
+	}
+}
+
+func TestOCI1EmptyDescriptor(t *testing.T) {
+	d := OCI1EmptyDescriptor()
+	assert.Equal(t, imgspecv1.Descriptor{
+		MediaType: "application/vnd.oci.empty.v1+json",
+		Digest:    "sha256:44136fa355b3678a1146ad16f7e8649e94fb4fc21fe77e8310c060f61caaff8a",
+		Size:      2,
+	}, d)
+	assert.True(t, IsOCI1EmptyDescriptor(d))
+	for _, c := range []imgspecv1.Descriptor{
+		{MediaType: imgspecv1.MediaTypeImageConfig, Digest: d.Digest, Size: d.Size},
+		{MediaType: d.MediaType, Digest: digest.FromString("[]"), Size: d.Size},
+		{MediaType: d.MediaType, Digest: d.Digest, Size: 3},
+	} {
+		assert.False(t, IsOCI1EmptyDescriptor(c), c)
+	}
+}
+
+func TestOCI1ArtifactFromComponents(t *testing.T) {
+	subject := &imgspecv1.Descriptor{
+		MediaType: imgspecv1.MediaTypeImageManifest,
+		Digest:    "sha256:5b0bcabd1ed22e9fb1310cf6c2dec7cdef19f0ad69efa1f392e94a4333501270",
+		Size:      7682,
+	}
+	layer := imgspecv1.Descriptor{
+		MediaType: "application/vnd.example.sbom.v1+json",
+		Digest:    "sha256:6a5a5368e0c2d3e5909184fa28ddfd56072e7ff3ee9a945876f7eee5896ef5bb",
+		Size:      1024,
+	}
+
+	// Defaults
+	m, err := OCI1ArtifactFromComponents("application/vnd.example.sbom.v1", nil, nil, subject, map[string]string{"k": "v"})
+	require.NoError(t, err)
+	assert.Equal(t, OCI1EmptyDescriptor(), m.Config)
+	assert.Equal(t, []imgspecv1.Descriptor{OCI1EmptyDescriptor()}, m.Layers)
+	assert.Equal(t, "application/vnd.example.sbom.v1", m.EffectiveArtifactType())
+	assert.False(t, m.IsImage())
+	serialized, err := m.Serialize()
+	require.NoError(t, err)
+	assert.Equal(t, imgspecv1.MediaTypeImageManifest, GuessMIMEType(serialized))
+	parsed, err := OCI1FromManifest(serialized)
+	require.NoError(t, err)
+	assert.Equal(t, m, parsed)
+
+	// A custom config
+	config := &imgspecv1.Descriptor{
+		MediaType: "application/vnd.example.config.v1+json",
+		Digest:    "sha256:1bbf5d58d24c47512e234a5623474acf65ae00d4d1414272a893204f44cc680c",
+		Size:      150,
+	}
+	m, err = OCI1ArtifactFromComponents("", config, []imgspecv1.Descriptor{layer}, nil, nil)
+	require.NoError(t, err)
+	assert.Equal(t, *config, m.Config)
+	assert.Equal(t, []imgspecv1.Descriptor{layer}, m.Layers)
+	assert.Nil(t, m.Subject)
+	assert.Equal(t, "application/vnd.example.config.v1+json", m.EffectiveArtifactType())
+
+	// Invalid data
+	_, err = OCI1ArtifactFromComponents("", nil, []imgspecv1.Descriptor{layer}, subject, nil)
+	assert.Error(t, err)
+	_, err = OCI1ArtifactFromComponents("not a media type", nil, nil, subject, nil)
+	assert.Error(t, err)
+	_, err = OCI1ArtifactFromComponents("application/vnd.example.sbom.v1", nil, nil, &imgspecv1.Descriptor{
+		MediaType: imgspecv1.MediaTypeImageManifest,
+		Digest:    "sha256:invalid",
+		Size:      7682,
+	}, nil)
+	assert.Error(t, err)
+}
+
+func TestOCI1FromManifestInvalidArtifactFields(t *testing.T) {
+	validManifest, err := os.ReadFile(filepath.Join("fixtures", "ociv1.subject.manifest.json"))
+	require.NoError(t, err)
+	for _, c := range []struct{ field, value string }{
+		{"artifactType", `"invalid"`},
+		{"subject", `{"mediaType":"","digest":"sha256:5b0bcabd1ed22e9fb1310cf6c2dec7cdef19f0ad69efa1f392e94a4333501270","size":7682}`},
+		{"subject", `{"mediaType":"application/vnd.oci.image.manifest.v1+json","digest":"invalid","size":7682}`},
+		{"subject", `{"mediaType":"application/vnd.oci.image.manifest.v1+json","digest":"sha256:5b0bcabd1ed22e9fb1310cf6c2dec7cdef19f0ad69efa1f392e94a4333501270","size":-1}`},
+	} {
+		parsed := map[string]json.RawMessage{}
+		err := json.Unmarshal(validManifest, &parsed)
+		require.NoError(t, err)
+		parsed[c.field] = json.RawMessage(c.value)
+		manifest, err := json.Marshal(parsed)
+		require.NoError(t, err)
+		_, err = OCI1FromManifest(manifest)
+		assert.Error(t, err, c.value)
+	}
+}
@@ -0,0 +1,139 @@
+package manifest
+
+import (
+	"fmt"
+	"runtime"
+
+	"github.com/containers/image/v5/internal/pkg/platform"
+	digest "github.com/opencontainers/go-digest"
+	imgspecv1 "github.com/opencontainers/image-spec/specs-go/v1"
+	"github.com/pkg/errors"
+)
+
+// PlatformSupport describes whether a host can run an image for a specific platform.
+type PlatformSupport int
+
+const (
+	// PlatformSupportUnknown means that the image does not specify its platform.
+	PlatformSupportUnknown PlatformSupport = iota
+	// PlatformUnusable means that the host can't run the image.
+	PlatformUnusable
+	// PlatformEmulated means that the host can run the image only using an emulator, usually much more slowly.
+	PlatformEmulated
+	// PlatformNative means that the host can run the image natively.
+	PlatformNative
+)
+
+// String returns a user-readable description of s.
+func (s PlatformSupport) String() string {
+	switch s {
+	case PlatformSupportUnknown:
+		return "unknown"
+	case PlatformUnusable:
+		return "unusable"
+	case PlatformEmulated:
+		return "emulated"
+	case PlatformNative:
+		return "native"
+	default:
+		return fmt.Sprintf("PlatformSupport(%d)", int(s))
+	}
+}
+
+// Host describes the platforms a host can run images for.
+type Host struct {
+	OS           string // A GOOS value
+	Architecture string // A GOARCH value
+	Variant      string // The CPU variant, or "" if unknown or the architecture has no variants
+	// EmulatedArchitectures are the architectures (GOARCH values) which the host can run using emulation, for OS.
+	EmulatedArchitectures []string
+}
+
+// DetectHost returns a Host describing the current host, including QEMU user-mode emulators registered in binfmt_misc.
+func DetectHost() (Host, error) {
+	emulated, err := platform.EmulatedArchitectures()
+	if err != nil {
+		return Host{}, errors.Wrap(err, "detecting emulators")
+	}
+	return Host{
+		OS:                    runtime.GOOS,
+		Architecture:          runtime.GOARCH,
+		Variant:               platform.HostVariant(),
+		EmulatedArchitectures: emulated,
+	}, nil
+}
+
+// Support returns whether host can run images for p.
+func (host Host) Support(p imgspecv1.Platform) PlatformSupport {
+	if p.OS == "" && p.Architecture == "" {
+		return PlatformSupportUnknown
+	}
+	if p.OS != host.OS {
+		return PlatformUnusable
+	}
+	for _, compatible := range platform.CompatiblePlatforms(host.OS, host.Architecture, host.Variant) {
+		if platform.MatchesPlatform(p, compatible) {
+			return PlatformNative
+		}
+	}
+	for _, arch := range host.EmulatedArchitectures {
+		// Emulators usually support all variants of an architecture, so don't restrict them.
+		if p.Architecture == arch {
+			return PlatformEmulated
+		}
+	}
+	return PlatformUnusable
+}
+
+// InstanceSupport describes whether a host can run an instance of a manifest list.
+type InstanceSupport struct {
+	Digest   digest.Digest
+	Platform *imgspecv1.Platform // nil if the instance does not specify a platform
+	Support  PlatformSupport
+}
+
+// ListPlatformSupport returns, for each instance of list, in order, whether host can run it.
+func ListPlatformSupport(list List, host Host) ([]InstanceSupport, error) {
+	var platforms []*imgspecv1.Platform
+	var digests []digest.Digest
+	switch l := list.(type) {
+	case *Schema2List:
+		for _, d := range l.Manifests {
+			platforms = append(platforms, &imgspecv1.Platform{
+				Architecture: d.Platform.Architecture,
+				OS:           d.Platform.OS,
+				OSVersion:    d.Platform.OSVersion,
+				OSFeatures:   dupStringSlice(d.Platform.OSFeatures),
+				Variant:      d.Platform.Variant,
+			})
+			digests = append(digests, d.Digest)
+		}
+	case *OCI1Index:
+		for _, d := range l.Manifests {
+			var p *imgspecv1.Platform
+			if d.Platform != nil {
+				p = &imgspecv1.Platform{
+					Architecture: d.Platform.Architecture,
+					OS:           d.Platform.OS,
+					OSVersion:    d.Platform.OSVersion,
+					OSFeatures:   dupStringSlice(d.Platform.OSFeatures),
+					Variant:      d.Platform.Variant,
+				}
+			}
+			platforms = append(platforms, p)
+			digests = append(digests, d.Digest)
+		}
+	default:
+		return nil, errors.Errorf("determining platform support for manifest list type %T is not supported", list)
+	}
+
+	res := make([]InstanceSupport, 0, len(digests))
+	for i, d := range digests {
+		support := PlatformSupportUnknown
+		if platforms[i] != nil {
+			support = host.Support(*platforms[i])
+		}
+		res = append(res, InstanceSupport{Digest: d, Platform: platforms[i], Support: support})
+	}
+	return res, nil
+}
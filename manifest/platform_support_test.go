@@ -0,0 +1,68 @@
+package manifest
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+
+	imgspecv1 "github.com/opencontainers/image-spec/specs-go/v1"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestHostSupport(t *testing.T) {
+	amd64Host := Host{OS: "linux", Architecture: "amd64", EmulatedArchitectures: []string{"arm", "arm64"}}
+	armHost := Host{OS: "linux", Architecture: "arm", Variant: "v7"}
+	for _, c := range []struct {
+		host     Host
+		platform imgspecv1.Platform
+		expected PlatformSupport
+	}{
+		{amd64Host, imgspecv1.Platform{OS: "linux", Architecture: "amd64"}, PlatformNative},
+		{amd64Host, imgspecv1.Platform{OS: "windows", Architecture: "amd64"}, PlatformUnusable},
+		{amd64Host, imgspecv1.Platform{OS: "linux", Architecture: "arm64", Variant: "v8"}, PlatformEmulated},
+		{amd64Host, imgspecv1.Platform{OS: "linux", Architecture: "arm", Variant: "v5"}, PlatformEmulated},
+		{amd64Host, imgspecv1.Platform{OS: "linux", Architecture: "s390x"}, PlatformUnusable},
+		{amd64Host, imgspecv1.Platform{}, PlatformSupportUnknown},
+		{armHost, imgspecv1.Platform{OS: "linux", Architecture: "arm", Variant: "v6"}, PlatformNative},
+		{armHost, imgspecv1.Platform{OS: "linux", Architecture: "arm"}, PlatformNative},
+		{armHost, imgspecv1.Platform{OS: "linux", Architecture: "arm", Variant: "v8"}, PlatformUnusable},
+		{armHost, imgspecv1.Platform{OS: "linux", Architecture: "amd64"}, PlatformUnusable},
+	} {
+		assert.Equal(t, c.expected, c.host.Support(c.platform), "%#v on %#v", c.platform, c.host)
+	}
+}
+
+func TestListPlatformSupport(t *testing.T) {
+	host := Host{OS: "linux", Architecture: "amd64", EmulatedArchitectures: []string{"ppc64le"}}
+	for _, c := range []struct {
+		path     string
+		mimeType string
+		expected []PlatformSupport
+	}{
+		{"ociv1.image.index.json", imgspecv1.MediaTypeImageIndex, []PlatformSupport{PlatformEmulated, PlatformNative}},
+		{"v2list.manifest.json", DockerV2ListMediaType,
+			[]PlatformSupport{PlatformEmulated, PlatformNative, PlatformUnusable, PlatformUnusable, PlatformUnusable}},
+	} {
+		manifest, err := os.ReadFile(filepath.Join("fixtures", c.path))
+		require.NoError(t, err)
+		list, err := ListFromBlob(manifest, c.mimeType)
+		require.NoError(t, err)
+		res, err := ListPlatformSupport(list, host)
+		require.NoError(t, err)
+		require.Len(t, res, len(c.expected))
+		for i, instance := range res {
+			assert.Equal(t, list.Instances()[i], instance.Digest)
+			require.NotNil(t, instance.Platform)
+			assert.Equal(t, c.expected[i], instance.Support, "%s instance %d", c.path, i)
+		}
+	}
+
+	// Instances without a platform
+	index := OCI1IndexFromComponents([]imgspecv1.Descriptor{{MediaType: imgspecv1.MediaTypeImageManifest, Digest: "sha256:0000000000000000000000000000000000000000000000000000000000000000"}}, nil)
+	res, err := ListPlatformSupport(index, host)
+	require.NoError(t, err)
+	require.Len(t, res, 1)
+	assert.Nil(t, res[0].Platform)
+	assert.Equal(t, PlatformSupportUnknown, res[0].Support)
+}
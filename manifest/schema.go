@@ -0,0 +1,185 @@
+package manifest
+
+import (
+	"bytes"
+	_ "embed" // Required for go:embed
+	"encoding/json"
+	"fmt"
+	"strings"
+	"sync"
+
+	imgspecv1 "github.com/opencontainers/image-spec/specs-go/v1"
+	"github.com/pkg/errors"
+	"github.com/xeipuuv/gojsonschema"
+)
+
+// schemaJSON contains the JSON schemas used by ValidateSchema, as definitions referenced by schemaDefinitions.
+//
+//go:embed schema.json
+var schemaJSON []byte
+
+// schemaDefinitions maps MIME types to the names of their definitions in schemaJSON.
+var schemaDefinitions = map[string]string{
+	imgspecv1.MediaTypeImageManifest: "ociManifest",
+	imgspecv1.MediaTypeImageIndex:    "ociIndex",
+	imgspecv1.MediaTypeImageConfig:   "ociConfig",
+	DockerV2Schema2MediaType:         "dockerManifest",
+	DockerV2ListMediaType:            "dockerList",
+	DockerV2Schema2ConfigMediaType:   "dockerConfig",
+}
+
+var (
+	compiledSchemasOnce sync.Once
+	compiledSchemas     map[string]*gojsonschema.Schema // Keyed by MIME type
+	compiledSchemasErr  error
+)
+
+// SchemaViolation is a single violation of a JSON schema, reported in SchemaValidationError.
+type SchemaViolation struct {
+	// Location is a JSON pointer (RFC 6901) to the invalid value, e.g. "/layers/0/digest"; "" refers to the whole document.
+	Location    string
+	Description string
+}
+
+func (v SchemaViolation) String() string {
+	if v.Location == "" {
+		return v.Description
+	}
+	return fmt.Sprintf("%s: %s", v.Location, v.Description)
+}
+
+// SchemaValidationError is returned by ValidateSchema if a document does not conform to the JSON schema of its MIME type.
+type SchemaValidationError struct {
+	MIMEType   string
+	Violations []SchemaViolation // At least one
+}
+
+func (e SchemaValidationError) Error() string {
+	violations := make([]string, 0, len(e.Violations))
+	for _, v := range e.Violations {
+		violations = append(violations, v.String())
+	}
+	return fmt.Sprintf("invalid %s: %s", e.MIMEType, strings.Join(violations, "; "))
+}
+
+// SchemaValidationSupported returns true if ValidateSchema can validate documents of mimeType.
+func SchemaValidationSupported(mimeType string) bool {
+	_, ok := schemaDefinitions[schemaMIMEType(mimeType)]
+	return ok
+}
+
+// schemaMIMEType returns the key of schemaDefinitions corresponding to mimeType, if any.
+func schemaMIMEType(mimeType string) string {
+	if _, ok := schemaDefinitions[mimeType]; ok { // Config types are not normalized by NormalizedMIMEType.
+		return mimeType
+	}
+	return NormalizedMIMEType(mimeType)
+}
+
+// ValidateSchema checks that blob, a manifest, manifest list or image config of mimeType, conforms to the JSON schema
+// of its format, as defined by the OCI image-spec (including image-spec v1.1 fields) or the Docker image specifications.
+// If it does not, it returns a SchemaValidationError which identifies the invalid values.
+//
+// This is stricter than the parsers in this package, which are lenient for compatibility with existing images.
+// Use SchemaValidationSupported to check which MIME types are supported; the others, e.g. Docker schema1, are rejected.
+func ValidateSchema(blob []byte, mimeType string) error {
+	normalized := schemaMIMEType(mimeType)
+	if _, ok := schemaDefinitions[normalized]; !ok {
+		return errors.Errorf("schema validation of %s is not supported", mimeType)
+	}
+	compiledSchemasOnce.Do(func() {
+		compiledSchemas, compiledSchemasErr = compileSchemas()
+	})
+	if compiledSchemasErr != nil {
+		return compiledSchemasErr
+	}
+
+	// gojsonschema does not report the location of syntax errors, so check the syntax first.
+	var parsed interface{}
+	decoder := json.NewDecoder(bytes.NewReader(blob))
+	decoder.UseNumber()
+	if err := decoder.Decode(&parsed); err != nil {
+		return SchemaValidationError{MIMEType: normalized, Violations: []SchemaViolation{{Description: jsonSyntaxErrorDescription(blob, err)}}}
+	}
+
+	result, err := compiledSchemas[normalized].Validate(gojsonschema.NewBytesLoader(blob))
+	if err != nil {
+		return errors.Wrapf(err, "validating %s", normalized)
+	}
+	if result.Valid() {
+		return nil
+	}
+	res := SchemaValidationError{MIMEType: normalized}
+	for _, e := range result.Errors() {
+		if e.Type() == "number_all_of" {
+			continue // Only a summary of the other errors, caused by the way schemaJSON is structured.
+		}
+		res.Violations = append(res.Violations, SchemaViolation{
+			Location:    schemaErrorLocation(e),
+			Description: e.Description(),
+		})
+	}
+	return res
+}
+
+// compileSchemas returns the compiled schemas for all of schemaDefinitions.
+func compileSchemas() (map[string]*gojsonschema.Schema, error) {
+	res := map[string]*gojsonschema.Schema{}
+	for mimeType, definition := range schemaDefinitions {
+		var schema map[string]interface{}
+		if err := json.Unmarshal(schemaJSON, &schema); err != nil {
+			return nil, errors.Wrap(err, "parsing JSON schemas")
+		}
+		schema["allOf"] = []interface{}{
+			map[string]interface{}{"$ref": "#/definitions/" + definition},
+		}
+		compiled, err := gojsonschema.NewSchema(gojsonschema.NewGoLoader(schema))
+		if err != nil {
+			return nil, errors.Wrapf(err, "compiling JSON schema of %s", mimeType)
+		}
+		res[mimeType] = compiled
+	}
+	return res, nil
+}
+
+// schemaErrorLocation returns a JSON pointer to the value which caused e.
+func schemaErrorLocation(e gojsonschema.ResultError) string {
+	// The context is "(root)", followed by property names or array indices.
+	elements := strings.Split(e.Context().String("\x00"), "\x00")[1:]
+	// A missing property is reported at the location of its parent object; point at the property instead.
+	if e.Type() == "required" {
+		if property, ok := e.Details()["property"].(string); ok {
+			elements = append(elements, property)
+		}
+	}
+	var res strings.Builder
+	for _, element := range elements {
+		res.WriteString("/")
+		res.WriteString(strings.ReplaceAll(strings.ReplaceAll(element, "~", "~0"), "/", "~1"))
+	}
+	return res.String()
+}
+
+// jsonSyntaxErrorDescription returns a description of err, a JSON parsing error of blob, including its position if known.
+func jsonSyntaxErrorDescription(blob []byte, err error) string {
+	var offset int64
+	switch e := err.(type) {
+	case *json.SyntaxError:
+		offset = e.Offset
+	case *json.UnmarshalTypeError:
+		offset = e.Offset
+	default:
+		return fmt.Sprintf("invalid JSON: %v", err)
+	}
+	// offset is the number of bytes read, including the byte which caused the error.
+	pos := offset - 1
+	if pos < 0 {
+		pos = 0
+	}
+	if pos > int64(len(blob)) {
+		pos = int64(len(blob))
+	}
+	line := 1 + bytes.Count(blob[:pos], []byte("\n"))
+	column := pos - int64(bytes.LastIndexByte(blob[:pos], '\n'))
+	return fmt.Sprintf("invalid JSON at line %d, column %d: %v", line, column, err)
+}
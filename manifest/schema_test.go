@@ -0,0 +1,108 @@
+package manifest
+
+import (
+	"encoding/json"
+	"os"
+	"path/filepath"
+	"testing"
+
+	imgspecv1 "github.com/opencontainers/image-spec/specs-go/v1"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestSchemaValidationSupported(t *testing.T) {
+	for _, mimeType := range []string{
+		imgspecv1.MediaTypeImageManifest, imgspecv1.MediaTypeImageIndex, imgspecv1.MediaTypeImageConfig,
+		DockerV2Schema2MediaType, DockerV2ListMediaType, DockerV2Schema2ConfigMediaType,
+	} {
+		assert.True(t, SchemaValidationSupported(mimeType), mimeType)
+	}
+	for _, mimeType := range []string{
+		DockerV2Schema1MediaType, DockerV2Schema1SignedMediaType, "application/vnd.oci.empty.v1+json", "text/plain",
+	} {
+		assert.False(t, SchemaValidationSupported(mimeType), mimeType)
+		err := ValidateSchema([]byte("{}"), mimeType)
+		assert.Error(t, err, mimeType)
+	}
+}
+
+func TestValidateSchemaValid(t *testing.T) {
+	for _, c := range []struct{ path, mimeType string }{
+		{"fixtures/ociv1.manifest.json", imgspecv1.MediaTypeImageManifest},
+		{"fixtures/ociv1.subject.manifest.json", imgspecv1.MediaTypeImageManifest},
+		{"fixtures/ociv1.zstd.manifest.json", imgspecv1.MediaTypeImageManifest},
+		{"fixtures/ociv1nomime.manifest.json", imgspecv1.MediaTypeImageManifest},
+		{"fixtures/ociv1.image.index.json", imgspecv1.MediaTypeImageIndex},
+		{"fixtures/ociv1.subject.image.index.json", imgspecv1.MediaTypeImageIndex},
+		{"fixtures/ociv1.unknown-fields.image.index.json", imgspecv1.MediaTypeImageIndex},
+		{"fixtures/v2s2.manifest.json", DockerV2Schema2MediaType},
+		{"fixtures/v2list.manifest.json", DockerV2ListMediaType},
+		{"../image/fixtures/oci1-config.json", imgspecv1.MediaTypeImageConfig},
+		{"../image/fixtures/schema2-config.json", DockerV2Schema2ConfigMediaType},
+	} {
+		blob, err := os.ReadFile(filepath.FromSlash(c.path))
+		require.NoError(t, err)
+		err = ValidateSchema(blob, c.mimeType)
+		assert.NoError(t, err, c.path)
+	}
+}
+
+func TestValidateSchemaInvalid(t *testing.T) {
+	validManifest, err := os.ReadFile(filepath.Join("fixtures", "ociv1.manifest.json"))
+	require.NoError(t, err)
+	validList, err := os.ReadFile(filepath.Join("fixtures", "v2list.manifest.json"))
+	require.NoError(t, err)
+
+	for _, c := range []struct {
+		blob      []byte
+		mimeType  string
+		field     string
+		value     string
+		locations []string
+	}{
+		{validManifest, imgspecv1.MediaTypeImageManifest, "layers", "null", []string{"/layers"}},
+		{validManifest, imgspecv1.MediaTypeImageManifest, "config",
+			`{"mediaType":"application/vnd.oci.image.config.v1+json","digest":"invalid","size":-1}`,
+			[]string{"/config/digest", "/config/size"}},
+		{validManifest, imgspecv1.MediaTypeImageManifest, "config", `{"mediaType":"application/vnd.oci.image.config.v1+json","size":1}`,
+			[]string{"/config/digest"}},
+		{validManifest, imgspecv1.MediaTypeImageManifest, "schemaVersion", "1", []string{"/schemaVersion"}},
+		{validManifest, imgspecv1.MediaTypeImageManifest, "mediaType", `"application/vnd.oci.image.index.v1+json"`, []string{"/mediaType"}},
+		{validManifest, imgspecv1.MediaTypeImageManifest, "artifactType", `"invalid"`, []string{"/artifactType"}},
+		{validManifest, imgspecv1.MediaTypeImageManifest, "annotations", `{"a/b":1}`, []string{"/annotations/a~1b"}},
+		{validList, DockerV2ListMediaType, "manifests",
+			`[{"mediaType":"application/vnd.docker.distribution.manifest.v2+json","digest":"sha256:e692418e4cbaf90ca69d05a66403747baa33ee08806650b51fab815ad7fc331f","size":1}]`,
+			[]string{"/manifests/0/platform"}},
+	} {
+		parsed := map[string]json.RawMessage{}
+		err := json.Unmarshal(c.blob, &parsed)
+		require.NoError(t, err)
+		parsed[c.field] = json.RawMessage(c.value)
+		blob, err := json.Marshal(parsed)
+		require.NoError(t, err)
+
+		err = ValidateSchema(blob, c.mimeType)
+		require.Error(t, err, c.value)
+		schemaErr, ok := err.(SchemaValidationError)
+		require.True(t, ok, c.value)
+		assert.Equal(t, c.mimeType, schemaErr.MIMEType)
+		locations := []string{}
+		for _, v := range schemaErr.Violations {
+			locations = append(locations, v.Location)
+			assert.NotEmpty(t, v.Description)
+		}
+		assert.ElementsMatch(t, c.locations, locations, c.value)
+	}
+}
+
+func TestValidateSchemaSyntaxError(t *testing.T) {
+	err := ValidateSchema([]byte("{\n  \"schemaVersion\": 2,\n  \"config\": }"), imgspecv1.MediaTypeImageManifest)
+	require.Error(t, err)
+	schemaErr, ok := err.(SchemaValidationError)
+	require.True(t, ok)
+	require.Len(t, schemaErr.Violations, 1)
+	assert.Equal(t, "", schemaErr.Violations[0].Location)
+	assert.Contains(t, schemaErr.Violations[0].Description, "line 3, column 13")
+	assert.Contains(t, err.Error(), "line 3, column 13")
+}
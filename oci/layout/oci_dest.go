@@ -22,6 +22,7 @@ type ociImageDestination struct {
 	index                    imgspecv1.Index
 	sharedBlobDir            string
 	acceptUncompressedLayers bool
+	primaryManifestDigest    digest.Digest // The digest of the manifest written by PutManifest with instanceDigest == nil, if any
 }
 
 // newImageDestination returns an ImageDestination for writing to an existing directory.
@@ -81,7 +82,7 @@ func (d *ociImageDestination) SupportedManifestMIMETypes() []string {
 // SupportsSignatures returns an error (to be displayed to the user) if the destination certainly can't store signatures.
 // Note: It is still possible for PutSignatures to fail if SupportsSignatures returns nil.
 func (d *ociImageDestination) SupportsSignatures(ctx context.Context) error {
-	return errors.Errorf("Pushing signatures for OCI images is not supported")
+	return nil
 }
 
 func (d *ociImageDestination) DesiredLayerCompression() types.LayerCompression {
@@ -244,6 +245,7 @@ func (d *ociImageDestination) PutManifest(ctx context.Context, m []byte, instanc
 	if instanceDigest != nil {
 		return nil
 	}
+	d.primaryManifestDigest = digest
 
 	// If we had platform information, we'd build an imgspecv1.Platform structure here.
 
@@ -290,14 +292,61 @@ func (d *ociImageDestination) addManifest(desc *imgspecv1.Descriptor) {
 	d.index.Manifests = append(d.index.Manifests, *desc)
 }
 
-// PutSignatures would add the given signatures to the oci layout (currently not supported).
+// PutSignatures writes the given signatures to the oci layout, as a referrer artifact of type SignatureArtifactType,
+// replacing any signatures previously stored for the same manifest.
 // If instanceDigest is not nil, it contains a digest of the specific manifest instance to write or overwrite the signatures for
 // (when the primary manifest is a manifest list); this should always be nil if the primary manifest is not a manifest list.
+// MUST be called after PutManifest (signatures may reference manifest contents).
 func (d *ociImageDestination) PutSignatures(ctx context.Context, signatures [][]byte, instanceDigest *digest.Digest) error {
-	if len(signatures) != 0 {
-		return errors.Errorf("Pushing signatures for OCI images is not supported")
+	var subject digest.Digest
+	if instanceDigest != nil {
+		subject = *instanceDigest
+	} else {
+		if d.primaryManifestDigest == "" {
+			if len(signatures) == 0 {
+				return nil
+			}
+			return errors.New("Unknown manifest digest, can't add signatures")
+		}
+		subject = d.primaryManifestDigest
 	}
-	return nil
+
+	existing, err := d.ref.listReferrers(&d.index, d.sharedBlobDir, subject, SignatureArtifactType)
+	if err != nil {
+		return err
+	}
+	if len(existing) != 0 {
+		manifests := make([]imgspecv1.Descriptor, 0, len(d.index.Manifests))
+		for _, md := range d.index.Manifests {
+			if !containsDescriptorDigest(existing, md.Digest) {
+				manifests = append(manifests, md)
+			}
+		}
+		d.index.Manifests = manifests
+	}
+	if len(signatures) == 0 {
+		return nil
+	}
+
+	blobs := make([]ReferrerBlob, 0, len(signatures))
+	for _, sig := range signatures {
+		blobs = append(blobs, ReferrerBlob{MediaType: SignatureArtifactType, Data: sig})
+	}
+	_, err = d.ref.putReferrer(&d.index, d.sharedBlobDir, subject, ReferrerArtifact{
+		ArtifactType: SignatureArtifactType,
+		Blobs:        blobs,
+	})
+	return err
+}
+
+// containsDescriptorDigest returns true if descs contains a descriptor with digest.
+func containsDescriptorDigest(descs []imgspecv1.Descriptor, digest digest.Digest) bool {
+	for _, desc := range descs {
+		if desc.Digest == digest {
+			return true
+		}
+	}
+	return false
 }
 
 // Commit marks the process of storing the image as successful and asks for the image to be persisted.
@@ -0,0 +1,288 @@
+package layout
+
+import (
+	"context"
+	"encoding/json"
+	"os"
+
+	"github.com/containers/image/v5/manifest"
+	"github.com/containers/image/v5/types"
+	digest "github.com/opencontainers/go-digest"
+	imgspecv1 "github.com/opencontainers/image-spec/specs-go/v1"
+	"github.com/pkg/errors"
+)
+
+const (
+	// AnnotationReferrerSubject is set on index.json entries of referrer artifacts to the digest of the manifest
+	// they refer to, so that referrers can be found without reading every manifest in the layout.
+	AnnotationReferrerSubject = "io.containers.image.referrer.subject"
+	// AnnotationReferrerArtifactType is set on index.json entries of referrer artifacts to their artifact type.
+	AnnotationReferrerArtifactType = "io.containers.image.referrer.artifact-type"
+
+	// SignatureArtifactType is the artifact type of referrer artifacts used to store image signatures.
+	// Each signature is stored as a separate blob, with this media type.
+	SignatureArtifactType = "application/vnd.containers.image.signature.v1"
+)
+
+// ReferrerBlob is a single blob of a ReferrerArtifact.
+type ReferrerBlob struct {
+	MediaType   string
+	Data        []byte
+	Annotations map[string]string
+}
+
+// ReferrerArtifact is an artifact referring to another manifest in the layout, e.g. a signature or an SBOM.
+type ReferrerArtifact struct {
+	ArtifactType string
+	Blobs        []ReferrerBlob
+	Annotations  map[string]string
+}
+
+// PutReferrer stores artifact in the OCI layout of ref, as referring to the manifest with digest subject,
+// which must already exist in the layout (possibly as an instance of an index).
+// It returns the descriptor of the artifact’s manifest, as recorded in index.json.
+func PutReferrer(ctx context.Context, sys *types.SystemContext, ref types.ImageReference, subject digest.Digest, artifact ReferrerArtifact) (imgspecv1.Descriptor, error) {
+	ociRef, ok := ref.(ociReference)
+	if !ok {
+		return imgspecv1.Descriptor{}, errors.Errorf("error typecasting, need type ociRef")
+	}
+	sharedBlobDir := sharedBlobDirForSystemContext(sys)
+	index, err := ociRef.getIndex()
+	if err != nil {
+		return imgspecv1.Descriptor{}, err
+	}
+	desc, err := ociRef.putReferrer(index, sharedBlobDir, subject, artifact)
+	if err != nil {
+		return imgspecv1.Descriptor{}, err
+	}
+	if err := ociRef.writeIndex(index); err != nil {
+		return imgspecv1.Descriptor{}, err
+	}
+	return desc, nil
+}
+
+// ListReferrers returns descriptors of the artifacts in the OCI layout of ref which refer to the manifest with digest subject.
+// If artifactType is not "", only artifacts of that type are returned.
+// Artifacts written by other tools are found as well, even if they lack the annotations set by PutReferrer.
+func ListReferrers(ctx context.Context, sys *types.SystemContext, ref types.ImageReference, subject digest.Digest, artifactType string) ([]imgspecv1.Descriptor, error) {
+	ociRef, ok := ref.(ociReference)
+	if !ok {
+		return nil, errors.Errorf("error typecasting, need type ociRef")
+	}
+	index, err := ociRef.getIndex()
+	if err != nil {
+		return nil, err
+	}
+	return ociRef.listReferrers(index, sharedBlobDirForSystemContext(sys), subject, artifactType)
+}
+
+// GetReferrer returns the artifact described by desc, a value returned by ListReferrers, from the OCI layout of ref.
+func GetReferrer(ctx context.Context, sys *types.SystemContext, ref types.ImageReference, desc imgspecv1.Descriptor) (ReferrerArtifact, error) {
+	ociRef, ok := ref.(ociReference)
+	if !ok {
+		return ReferrerArtifact{}, errors.Errorf("error typecasting, need type ociRef")
+	}
+	return ociRef.getReferrer(sharedBlobDirForSystemContext(sys), desc)
+}
+
+// sharedBlobDirForSystemContext returns the shared blob directory configured in sys, if any.
+func sharedBlobDirForSystemContext(sys *types.SystemContext) string {
+	if sys == nil {
+		return ""
+	}
+	return sys.OCISharedBlobDirPath
+}
+
+// putReferrer writes artifact, referring to subject, into the layout, and records it in index.
+func (ref ociReference) putReferrer(index *imgspecv1.Index, sharedBlobDir string, subject digest.Digest, artifact ReferrerArtifact) (imgspecv1.Descriptor, error) {
+	if artifact.ArtifactType == "" {
+		return imgspecv1.Descriptor{}, errors.New("referrer artifacts must have an artifact type")
+	}
+	subjectDesc, err := ref.subjectDescriptor(index, sharedBlobDir, subject)
+	if err != nil {
+		return imgspecv1.Descriptor{}, err
+	}
+
+	// The empty blob is used as the config, and as the only layer if there are no blobs.
+	if _, err := ref.writeBlob(sharedBlobDir, manifest.OCI1EmptyJSON); err != nil {
+		return imgspecv1.Descriptor{}, err
+	}
+	layers := []imgspecv1.Descriptor{}
+	for _, blob := range artifact.Blobs {
+		blobDigest, err := ref.writeBlob(sharedBlobDir, blob.Data)
+		if err != nil {
+			return imgspecv1.Descriptor{}, err
+		}
+		layers = append(layers, imgspecv1.Descriptor{
+			MediaType:   blob.MediaType,
+			Digest:      blobDigest,
+			Size:        int64(len(blob.Data)),
+			Annotations: blob.Annotations,
+		})
+	}
+	m, err := manifest.OCI1ArtifactFromComponents(artifact.ArtifactType, nil, layers, &subjectDesc, artifact.Annotations)
+	if err != nil {
+		return imgspecv1.Descriptor{}, err
+	}
+	manifestBlob, err := m.Serialize()
+	if err != nil {
+		return imgspecv1.Descriptor{}, err
+	}
+	manifestDigest, err := ref.writeBlob(sharedBlobDir, manifestBlob)
+	if err != nil {
+		return imgspecv1.Descriptor{}, err
+	}
+
+	desc := imgspecv1.Descriptor{
+		MediaType: imgspecv1.MediaTypeImageManifest,
+		Digest:    manifestDigest,
+		Size:      int64(len(manifestBlob)),
+		Annotations: map[string]string{
+			AnnotationReferrerSubject:      subject.String(),
+			AnnotationReferrerArtifactType: artifact.ArtifactType,
+		},
+	}
+	for i := range index.Manifests {
+		if index.Manifests[i].Digest == manifestDigest {
+			index.Manifests[i] = desc
+			return desc, nil
+		}
+	}
+	index.Manifests = append(index.Manifests, desc)
+	return desc, nil
+}
+
+// subjectDescriptor returns a descriptor of the manifest with digest subject in the layout.
+func (ref ociReference) subjectDescriptor(index *imgspecv1.Index, sharedBlobDir string, subject digest.Digest) (imgspecv1.Descriptor, error) {
+	for _, md := range index.Manifests {
+		if md.Digest == subject {
+			return imgspecv1.Descriptor{MediaType: md.MediaType, Digest: md.Digest, Size: md.Size}, nil
+		}
+	}
+	// The subject may be an instance of an index, which is not listed in index.json.
+	blobPath, err := ref.blobPath(subject, sharedBlobDir)
+	if err != nil {
+		return imgspecv1.Descriptor{}, err
+	}
+	m, err := os.ReadFile(blobPath)
+	if err != nil {
+		if os.IsNotExist(err) {
+			return imgspecv1.Descriptor{}, errors.Errorf("subject manifest %s not found in the OCI layout", subject)
+		}
+		return imgspecv1.Descriptor{}, err
+	}
+	return imgspecv1.Descriptor{MediaType: manifest.GuessMIMEType(m), Digest: subject, Size: int64(len(m))}, nil
+}
+
+// listReferrers returns descriptors of artifacts in index which refer to subject, and are of artifactType if it is not "".
+func (ref ociReference) listReferrers(index *imgspecv1.Index, sharedBlobDir string, subject digest.Digest, artifactType string) ([]imgspecv1.Descriptor, error) {
+	res := []imgspecv1.Descriptor{}
+	for _, md := range index.Manifests {
+		if md.MediaType != imgspecv1.MediaTypeImageManifest {
+			continue
+		}
+		mdSubject, ok := md.Annotations[AnnotationReferrerSubject]
+		mdArtifactType := md.Annotations[AnnotationReferrerArtifactType]
+		if !ok {
+			// Not written by us; the manifest itself is authoritative.
+			m, err := ref.readReferrerManifest(sharedBlobDir, md.Digest)
+			if err != nil {
+				if os.IsNotExist(err) {
+					continue // Not our business; it certainly does not refer to anything we can use.
+				}
+				return nil, err
+			}
+			if m.Subject == nil {
+				continue
+			}
+			mdSubject = m.Subject.Digest.String()
+			mdArtifactType = m.EffectiveArtifactType()
+		}
+		if mdSubject == subject.String() && (artifactType == "" || mdArtifactType == artifactType) {
+			res = append(res, md)
+		}
+	}
+	return res, nil
+}
+
+// getReferrer reads the artifact described by desc.
+func (ref ociReference) getReferrer(sharedBlobDir string, desc imgspecv1.Descriptor) (ReferrerArtifact, error) {
+	m, err := ref.readReferrerManifest(sharedBlobDir, desc.Digest)
+	if err != nil {
+		return ReferrerArtifact{}, err
+	}
+	res := ReferrerArtifact{
+		ArtifactType: m.EffectiveArtifactType(),
+		Annotations:  m.Annotations,
+	}
+	for _, layer := range m.Layers {
+		if manifest.IsOCI1EmptyDescriptor(layer) {
+			continue // Used only because artifacts should have at least one layer.
+		}
+		blobPath, err := ref.blobPath(layer.Digest, sharedBlobDir)
+		if err != nil {
+			return ReferrerArtifact{}, err
+		}
+		data, err := os.ReadFile(blobPath)
+		if err != nil {
+			return ReferrerArtifact{}, errors.Wrapf(err, "reading blob %s of referrer %s", layer.Digest, desc.Digest)
+		}
+		if layer.Digest.Algorithm().FromBytes(data) != layer.Digest {
+			return ReferrerArtifact{}, errors.Errorf("blob %s of referrer %s does not match its digest", layer.Digest, desc.Digest)
+		}
+		res.Blobs = append(res.Blobs, ReferrerBlob{
+			MediaType:   layer.MediaType,
+			Data:        data,
+			Annotations: layer.Annotations,
+		})
+	}
+	return res, nil
+}
+
+// readReferrerManifest reads and parses the manifest with manifestDigest.
+func (ref ociReference) readReferrerManifest(sharedBlobDir string, manifestDigest digest.Digest) (*manifest.OCI1, error) {
+	blobPath, err := ref.blobPath(manifestDigest, sharedBlobDir)
+	if err != nil {
+		return nil, err
+	}
+	data, err := os.ReadFile(blobPath)
+	if err != nil {
+		return nil, err
+	}
+	m := manifest.OCI1{}
+	if err := json.Unmarshal(data, &m); err != nil {
+		return nil, errors.Wrapf(err, "parsing manifest %s", manifestDigest)
+	}
+	return &m, nil
+}
+
+// writeBlob writes data as a blob into the layout, and returns its digest.
+func (ref ociReference) writeBlob(sharedBlobDir string, data []byte) (digest.Digest, error) {
+	blobDigest := digest.FromBytes(data)
+	blobPath, err := ref.blobPath(blobDigest, sharedBlobDir)
+	if err != nil {
+		return "", err
+	}
+	if err := ensureParentDirectoryExists(blobPath); err != nil {
+		return "", err
+	}
+	if err := os.WriteFile(blobPath, data, 0644); err != nil {
+		return "", err
+	}
+	return blobDigest, nil
+}
+
+// writeIndex writes index as the index.json of the layout.
+func (ref ociReference) writeIndex(index *imgspecv1.Index) error {
+	indexJSON, err := json.Marshal(index)
+	if err != nil {
+		return err
+	}
+	return os.WriteFile(ref.indexPath(), indexJSON, 0644)
+}
+
+// isReferrer returns true if desc, an entry of index.json, is a referrer artifact written by this package.
+func isReferrer(desc imgspecv1.Descriptor) bool {
+	_, ok := desc.Annotations[AnnotationReferrerSubject]
+	return ok
+}
@@ -0,0 +1,131 @@
+package layout
+
+import (
+	"bytes"
+	"context"
+	"os"
+	"testing"
+
+	"github.com/containers/image/v5/manifest"
+	"github.com/containers/image/v5/pkg/blobinfocache/memory"
+	"github.com/containers/image/v5/types"
+	digest "github.com/opencontainers/go-digest"
+	imgspecv1 "github.com/opencontainers/image-spec/specs-go/v1"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// putTestImageWithSignatures writes an image with signatures into ref, and returns the digest of its manifest.
+func putTestImageWithSignatures(t *testing.T, ref types.ImageReference, signatures [][]byte) digest.Digest {
+	config, err := os.ReadFile("../../image/fixtures/oci1-config.json")
+	require.NoError(t, err)
+	man, err := os.ReadFile("../../image/fixtures/oci1.json")
+	require.NoError(t, err)
+
+	dest, err := ref.NewImageDestination(context.Background(), nil)
+	require.NoError(t, err)
+	defer dest.Close()
+	require.NoError(t, dest.SupportsSignatures(context.Background()))
+	_, err = dest.PutBlob(context.Background(), bytes.NewReader(config), types.BlobInfo{Size: int64(len(config)), Digest: digest.FromBytes(config)}, memory.New(), true)
+	require.NoError(t, err)
+	err = dest.PutManifest(context.Background(), man, nil)
+	require.NoError(t, err)
+	err = dest.PutSignatures(context.Background(), signatures, nil)
+	require.NoError(t, err)
+	err = dest.Commit(context.Background(), nil) // nil unparsedToplevel is invalid, we don’t currently use the value
+	require.NoError(t, err)
+
+	manifestDigest, err := manifest.Digest(man)
+	require.NoError(t, err)
+	return manifestDigest
+}
+
+func TestSignaturesRoundTrip(t *testing.T) {
+	tmpDir := t.TempDir()
+	ref, err := NewReference(tmpDir, "img")
+	require.NoError(t, err)
+
+	sigs := [][]byte{[]byte("signature 1"), []byte("signature 2")}
+	manifestDigest := putTestImageWithSignatures(t, ref, sigs)
+
+	src, err := ref.NewImageSource(context.Background(), nil)
+	require.NoError(t, err)
+	defer src.Close()
+	res, err := src.GetSignatures(context.Background(), nil)
+	require.NoError(t, err)
+	assert.Equal(t, sigs, res)
+
+	// The signature artifact is not considered an image when looking for the only image in the layout.
+	unnamedRef, err := NewReference(tmpDir, "")
+	require.NoError(t, err)
+	desc, err := unnamedRef.(ociReference).getManifestDescriptor()
+	require.NoError(t, err)
+	assert.Equal(t, manifestDigest, desc.Digest)
+
+	// Signatures are replaced, not accumulated.
+	sigs = [][]byte{[]byte("signature 3")}
+	putTestImageWithSignatures(t, ref, sigs)
+	referrers, err := ListReferrers(context.Background(), nil, ref, manifestDigest, SignatureArtifactType)
+	require.NoError(t, err)
+	assert.Len(t, referrers, 1)
+	src2, err := ref.NewImageSource(context.Background(), nil)
+	require.NoError(t, err)
+	defer src2.Close()
+	res, err = src2.GetSignatures(context.Background(), nil)
+	require.NoError(t, err)
+	assert.Equal(t, sigs, res)
+}
+
+func TestReferrersRoundTrip(t *testing.T) {
+	tmpDir := t.TempDir()
+	ref, err := NewReference(tmpDir, "img")
+	require.NoError(t, err)
+	manifestDigest := putTestImageWithSignatures(t, ref, [][]byte{[]byte("signature")})
+
+	sbom := ReferrerArtifact{
+		ArtifactType: "application/spdx+json",
+		Blobs: []ReferrerBlob{
+			{MediaType: "application/spdx+json", Data: []byte(`{"spdxVersion":"SPDX-2.3"}`), Annotations: map[string]string{"a": "b"}},
+		},
+		Annotations: map[string]string{"org.opencontainers.image.created": "2022-01-01T00:00:00Z"},
+	}
+	sbomDesc, err := PutReferrer(context.Background(), nil, ref, manifestDigest, sbom)
+	require.NoError(t, err)
+	assert.Equal(t, imgspecv1.MediaTypeImageManifest, sbomDesc.MediaType)
+	assert.Equal(t, manifestDigest.String(), sbomDesc.Annotations[AnnotationReferrerSubject])
+
+	referrers, err := ListReferrers(context.Background(), nil, ref, manifestDigest, "application/spdx+json")
+	require.NoError(t, err)
+	assert.Equal(t, []imgspecv1.Descriptor{sbomDesc}, referrers)
+	artifact, err := GetReferrer(context.Background(), nil, ref, referrers[0])
+	require.NoError(t, err)
+	assert.Equal(t, sbom, artifact)
+
+	referrers, err = ListReferrers(context.Background(), nil, ref, manifestDigest, "")
+	require.NoError(t, err)
+	assert.Len(t, referrers, 2)
+
+	referrers, err = ListReferrers(context.Background(), nil, ref, digest.FromString("unrelated"), "")
+	require.NoError(t, err)
+	assert.Empty(t, referrers)
+
+	// Missing subjects are rejected.
+	_, err = PutReferrer(context.Background(), nil, ref, digest.FromString("missing"), sbom)
+	assert.Error(t, err)
+
+	// Referrers written by other tools, without our annotations, are found by reading the manifest.
+	ociRef := ref.(ociReference)
+	foreign := []byte(`{"schemaVersion":2,"mediaType":"application/vnd.oci.image.manifest.v1+json","artifactType":"application/example",` +
+		`"config":{"mediaType":"application/vnd.oci.empty.v1+json","digest":"sha256:44136fa355b3678a1146ad16f7e8649e94fb4fc21fe77e8310c060f61caaff8a","size":2},` +
+		`"layers":[],"subject":{"mediaType":"application/vnd.oci.image.manifest.v1+json","digest":"` + manifestDigest.String() + `","size":1}}`)
+	foreignDigest, err := ociRef.writeBlob("", foreign)
+	require.NoError(t, err)
+	index, err := ociRef.getIndex()
+	require.NoError(t, err)
+	index.Manifests = append(index.Manifests, imgspecv1.Descriptor{MediaType: imgspecv1.MediaTypeImageManifest, Digest: foreignDigest, Size: int64(len(foreign))})
+	require.NoError(t, ociRef.writeIndex(index))
+	referrers, err = ListReferrers(context.Background(), nil, ref, manifestDigest, "application/example")
+	require.NoError(t, err)
+	require.Len(t, referrers, 1)
+	assert.Equal(t, foreignDigest, referrers[0].Digest)
+}
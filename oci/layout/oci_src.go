@@ -141,8 +141,27 @@ func (s *ociImageSource) GetBlob(ctx context.Context, info types.BlobInfo, cache
 // If instanceDigest is not nil, it contains a digest of the specific manifest instance to retrieve signatures for
 // (when the primary manifest is a manifest list); this never happens if the primary manifest is not a manifest list
 // (e.g. if the source never returns manifest lists).
+// Signatures are stored as referrer artifacts of type SignatureArtifactType.
 func (s *ociImageSource) GetSignatures(ctx context.Context, instanceDigest *digest.Digest) ([][]byte, error) {
-	return [][]byte{}, nil
+	subject := s.descriptor.Digest
+	if instanceDigest != nil {
+		subject = *instanceDigest
+	}
+	referrers, err := s.ref.listReferrers(s.index, s.sharedBlobDir, subject, SignatureArtifactType)
+	if err != nil {
+		return nil, err
+	}
+	signatures := [][]byte{}
+	for _, desc := range referrers {
+		artifact, err := s.ref.getReferrer(s.sharedBlobDir, desc)
+		if err != nil {
+			return nil, err
+		}
+		for _, blob := range artifact.Blobs {
+			signatures = append(signatures, blob.Data)
+		}
+	}
+	return signatures, nil
 }
 
 // getExternalBlob returns the reader of the first available blob URL from urls, which must not be empty.
@@ -185,12 +185,17 @@ func (ref ociReference) getManifestDescriptor() (imgspecv1.Descriptor, error) {
 
 	var d *imgspecv1.Descriptor
 	if ref.image == "" {
-		// return manifest if only one image is in the oci directory
-		if len(index.Manifests) == 1 {
-			d = &index.Manifests[0]
-		} else {
-			// ask user to choose image when more than one image in the oci directory
-			return imgspecv1.Descriptor{}, ErrMoreThanOneImage
+		// return manifest if only one image is in the oci directory;
+		// referrer artifacts (e.g. signatures) stored alongside it are not images.
+		for i := range index.Manifests {
+			if isReferrer(index.Manifests[i]) {
+				continue
+			}
+			if d != nil {
+				// ask user to choose image when more than one image in the oci directory
+				return imgspecv1.Descriptor{}, ErrMoreThanOneImage
+			}
+			d = &index.Manifests[i]
 		}
 	} else {
 		// if image specified, look through all manifests for a match
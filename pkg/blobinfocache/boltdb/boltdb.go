@@ -2,14 +2,13 @@
 package boltdb
 
 import (
-	"fmt"
 	"os"
-	"sync"
 	"time"
 
 	"github.com/containers/image/v5/internal/blobinfocache"
 	"github.com/containers/image/v5/pkg/blobinfocache/internal/prioritize"
 	"github.com/containers/image/v5/types"
+	"github.com/containers/storage/pkg/lockfile"
 	"github.com/opencontainers/go-digest"
 	"github.com/sirupsen/logrus"
 	bolt "go.etcd.io/bbolt"
@@ -36,61 +35,22 @@ var (
 
 // Concurrency:
 // See https://www.sqlite.org/src/artifact/c230a7a24?ln=994-1081 for all the issues with locks, which make it extremely
-// difficult to use a single BoltDB file from multiple threads/goroutines inside a process.  So, we punt and only allow one at a time.
+// difficult to use a single BoltDB file from multiple threads/goroutines inside a process, or from several processes.
+// So, we don’t rely on the locking done by BoltDB itself; every access is protected by a separate lock file (see lockPath),
+// shared by all goroutines and processes using the database: readers hold it shared, writers hold it exclusively,
+// for the whole time the database is open.
 
-// pathLock contains a lock for a specific BoltDB database path.
-type pathLock struct {
-	refCount int64      // Number of threads/goroutines owning or waiting on this lock.  Protected by global pathLocksMutex, NOT by the mutex field below!
-	mutex    sync.Mutex // Owned by the thread/goroutine allowed to access the BoltDB database.
-}
-
-var (
-	// pathLocks contains a lock for each currently open file.
-	// This must be global so that independently created instances of boltDBCache exclude each other.
-	// The map is protected by pathLocksMutex.
-	// FIXME? Should this be based on device:inode numbers instead of paths instead?
-	pathLocks      = map[string]*pathLock{}
-	pathLocksMutex = sync.Mutex{}
-)
-
-// lockPath obtains the pathLock for path.
-// The caller must call unlockPath eventually.
-func lockPath(path string) {
-	pl := func() *pathLock { // A scope for defer
-		pathLocksMutex.Lock()
-		defer pathLocksMutex.Unlock()
-		pl, ok := pathLocks[path]
-		if ok {
-			pl.refCount++
-		} else {
-			pl = &pathLock{refCount: 1, mutex: sync.Mutex{}}
-			pathLocks[path] = pl
-		}
-		return pl
-	}()
-	pl.mutex.Lock()
-}
-
-// unlockPath releases the pathLock for path.
-func unlockPath(path string) {
-	pathLocksMutex.Lock()
-	defer pathLocksMutex.Unlock()
-	pl, ok := pathLocks[path]
-	if !ok {
-		// Should this return an error instead? BlobInfoCache ultimately ignores errors…
-		panic(fmt.Sprintf("Internal error: unlocking nonexistent lock for path %s", path))
-	}
-	pl.mutex.Unlock()
-	pl.refCount--
-	if pl.refCount == 0 {
-		delete(pathLocks, path)
-	}
+// lockPath returns the path of the lock file protecting the database at path.
+func lockPath(path string) string {
+	return path + ".lock"
 }
 
 // cache is a BlobInfoCache implementation which uses a BoltDB file at the specified path.
 //
 // Note that we don’t keep the database open across operations, because that would lock the file and block any other
 // users; instead, we need to open/close it for every single write or lookup.
+//
+// The cache is safe for concurrent use by multiple goroutines, and by multiple processes using the same path.
 type cache struct {
 	path string
 }
@@ -110,14 +70,26 @@ func (bdc *cache) view(fn func(tx *bolt.Tx) error) (retErr error) {
 	// bolt.Open(bdc.path, 0600, &bolt.Options{ReadOnly: true}) will, if the file does not exist,
 	// nevertheless create it, but with an O_RDONLY file descriptor, try to initialize it, and fail — while holding
 	// a read lock, blocking any future writes.
-	// Hence this preliminary check, which is RACY: Another process could remove the file
-	// between the Lstat call and opening the database.
+	// Hence this check, repeated while holding the lock, which excludes writers that could be creating the file.
+	// (The first check only avoids creating the lock file for a database which does not exist.)
+	// An empty file can only be left behind by a writer which was interrupted before initializing it; treat it as missing.
 	if _, err := os.Lstat(bdc.path); err != nil && os.IsNotExist(err) {
 		return err
 	}
+	lock, err := lockfile.GetLockfile(lockPath(bdc.path))
+	if err != nil {
+		return err
+	}
+	lock.RLock()
+	defer lock.Unlock()
+	fi, err := os.Lstat(bdc.path)
+	if err != nil {
+		return err
+	}
+	if fi.Size() == 0 {
+		return os.ErrNotExist
+	}
 
-	lockPath(bdc.path)
-	defer unlockPath(bdc.path)
 	db, err := bolt.Open(bdc.path, 0600, &bolt.Options{ReadOnly: true})
 	if err != nil {
 		return err
@@ -133,8 +105,12 @@ func (bdc *cache) view(fn func(tx *bolt.Tx) error) (retErr error) {
 
 // update returns runs the specified fn within a read-write transaction on the database.
 func (bdc *cache) update(fn func(tx *bolt.Tx) error) (retErr error) {
-	lockPath(bdc.path)
-	defer unlockPath(bdc.path)
+	lock, err := lockfile.GetLockfile(lockPath(bdc.path))
+	if err != nil {
+		return err
+	}
+	lock.Lock()
+	defer lock.Unlock()
 	db, err := bolt.Open(bdc.path, 0600, nil)
 	if err != nil {
 		return err
@@ -49,6 +49,8 @@ func blobInfoCacheDir(sys *types.SystemContext, euid int) (string, error) {
 }
 
 // DefaultCache returns the default BlobInfoCache implementation appropriate for sys.
+// The returned cache is safe for concurrent use by multiple goroutines and processes; processes running many
+// concurrent copies can share it more efficiently using pkg/blobinfocache/shared.
 func DefaultCache(sys *types.SystemContext) types.BlobInfoCache {
 	dir, err := blobInfoCacheDir(sys, rootless.GetRootlessEUID())
 	if err != nil {
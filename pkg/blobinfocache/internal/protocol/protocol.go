@@ -0,0 +1,105 @@
+// Package protocol defines the net/rpc protocol used to share a BlobInfoCache between processes.
+package protocol
+
+import (
+	"github.com/containers/image/v5/internal/blobinfocache"
+	"github.com/containers/image/v5/types"
+	digest "github.com/opencontainers/go-digest"
+	"github.com/pkg/errors"
+)
+
+// ServiceName is the name of the net/rpc service implemented by Service.
+const ServiceName = "BlobInfoCache"
+
+// Empty is the reply of calls which don’t return any data.
+type Empty struct{}
+
+// DigestPair are the arguments of Service.RecordDigestUncompressedPair.
+type DigestPair struct {
+	AnyDigest    digest.Digest
+	Uncompressed digest.Digest
+}
+
+// DigestCompressorName are the arguments of Service.RecordDigestCompressorName.
+type DigestCompressorName struct {
+	AnyDigest      digest.Digest
+	CompressorName string
+}
+
+// KnownLocation are the arguments of Service.RecordKnownLocation.
+type KnownLocation struct {
+	Transport  string // The Name() of the transport
+	Scope      types.BICTransportScope
+	BlobDigest digest.Digest
+	Location   types.BICLocationReference
+}
+
+// CandidateLocationsArgs are the arguments of Service.CandidateLocations and Service.CandidateLocations2.
+type CandidateLocationsArgs struct {
+	Transport     string // The Name() of the transport
+	Scope         types.BICTransportScope
+	PrimaryDigest digest.Digest
+	CanSubstitute bool
+}
+
+// Service serves a BlobInfoCache using net/rpc.
+// The methods never fail; an error returned by a call always indicates a communication failure.
+type Service struct {
+	Cache blobinfocache.BlobInfoCache2
+}
+
+// UncompressedDigest calls BlobInfoCache.UncompressedDigest.
+func (s *Service) UncompressedDigest(anyDigest digest.Digest, reply *digest.Digest) error {
+	*reply = s.Cache.UncompressedDigest(anyDigest)
+	return nil
+}
+
+// RecordDigestUncompressedPair calls BlobInfoCache.RecordDigestUncompressedPair.
+func (s *Service) RecordDigestUncompressedPair(args DigestPair, _ *Empty) error {
+	s.Cache.RecordDigestUncompressedPair(args.AnyDigest, args.Uncompressed)
+	return nil
+}
+
+// RecordDigestCompressorName calls BlobInfoCache2.RecordDigestCompressorName.
+func (s *Service) RecordDigestCompressorName(args DigestCompressorName, _ *Empty) error {
+	s.Cache.RecordDigestCompressorName(args.AnyDigest, args.CompressorName)
+	return nil
+}
+
+// RecordKnownLocation calls BlobInfoCache.RecordKnownLocation.
+func (s *Service) RecordKnownLocation(args KnownLocation, _ *Empty) error {
+	s.Cache.RecordKnownLocation(NamedTransport(args.Transport), args.Scope, args.BlobDigest, args.Location)
+	return nil
+}
+
+// CandidateLocations calls BlobInfoCache.CandidateLocations.
+func (s *Service) CandidateLocations(args CandidateLocationsArgs, reply *[]types.BICReplacementCandidate) error {
+	*reply = s.Cache.CandidateLocations(NamedTransport(args.Transport), args.Scope, args.PrimaryDigest, args.CanSubstitute)
+	return nil
+}
+
+// CandidateLocations2 calls BlobInfoCache2.CandidateLocations2.
+func (s *Service) CandidateLocations2(args CandidateLocationsArgs, reply *[]blobinfocache.BICReplacementCandidate2) error {
+	*reply = s.Cache.CandidateLocations2(NamedTransport(args.Transport), args.Scope, args.PrimaryDigest, args.CanSubstitute)
+	return nil
+}
+
+// NamedTransport is a types.ImageTransport which only provides a name.
+// BlobInfoCache implementations only use the Name() of transports, so this allows passing transports over the protocol.
+type NamedTransport string
+
+// Name returns the name of the transport, which must be unique among other transports.
+func (name NamedTransport) Name() string {
+	return string(name)
+}
+
+// ParseReference converts a string, which should not start with the ImageTransport.Name prefix, into an ImageReference.
+func (name NamedTransport) ParseReference(reference string) (types.ImageReference, error) {
+	return nil, errors.Errorf("parsing references is not supported by the blob info cache transport %q", string(name))
+}
+
+// ValidatePolicyConfigurationScope checks that scope is a valid name for a signature.PolicyTransportScopes keys
+// (i.e. a valid PolicyConfigurationIdentity() or PolicyConfigurationNamespaces() return value).
+func (name NamedTransport) ValidatePolicyConfigurationScope(scope string) error {
+	return errors.Errorf("policy configuration scopes are not supported by the blob info cache transport %q", string(name))
+}
@@ -2,6 +2,8 @@
 package test
 
 import (
+	"fmt"
+	"sync"
 	"testing"
 
 	"github.com/containers/image/v5/internal/blobinfocache"
@@ -35,6 +37,7 @@ func GenericCache(t *testing.T, newTestCache func(t *testing.T) blobinfocache.Bl
 		{"RecordKnownLocations", testGenericRecordKnownLocations},
 		{"CandidateLocations", testGenericCandidateLocations},
 		{"CandidateLocations2", testGenericCandidateLocations2},
+		{"ConcurrentAccess", testGenericConcurrentAccess},
 	} {
 		t.Run(s.name, func(t *testing.T) {
 			cache := newTestCache(t)
@@ -350,3 +353,32 @@ func testGenericCandidateLocations2(t *testing.T, cache blobinfocache.BlobInfoCa
 		}, cache.CandidateLocations2(transport, scope, digestCompressedUnrelated, true))
 	}
 }
+
+func testGenericConcurrentAccess(t *testing.T, cache blobinfocache.BlobInfoCache2) {
+	transport := mocks.NameImageTransport("==BlobInfocache transport mock")
+	scope := types.BICTransportScope{Opaque: "A"}
+	const goroutines = 20
+	wg := sync.WaitGroup{}
+	for i := 0; i < goroutines; i++ {
+		wg.Add(1)
+		go func(i int) {
+			defer wg.Done()
+			compressed := digest.FromString(fmt.Sprintf("compressed %d", i))
+			uncompressed := digest.FromString(fmt.Sprintf("uncompressed %d", i))
+			cache.RecordDigestUncompressedPair(compressed, uncompressed)
+			cache.RecordDigestCompressorName(compressed, compressorNameA)
+			cache.RecordKnownLocation(transport, scope, compressed, types.BICLocationReference{Opaque: fmt.Sprintf("%d", i)})
+			// Interleave lookups with the writes of other goroutines.
+			assert.Equal(t, uncompressed, cache.UncompressedDigest(compressed))
+		}(i)
+	}
+	wg.Wait()
+
+	for i := 0; i < goroutines; i++ {
+		compressed := digest.FromString(fmt.Sprintf("compressed %d", i))
+		assert.Equal(t, digest.FromString(fmt.Sprintf("uncompressed %d", i)), cache.UncompressedDigest(compressed))
+		assert.Equal(t, []blobinfocache.BICReplacementCandidate2{
+			{Digest: compressed, CompressorName: compressorNameA, Location: types.BICLocationReference{Opaque: fmt.Sprintf("%d", i)}},
+		}, cache.CandidateLocations2(transport, scope, compressed, false))
+	}
+}
@@ -37,6 +37,8 @@ type cache struct {
 // blobinfocache.DefaultCache. instead of calling this directly.
 // Manual users of types.{ImageSource,ImageDestination} might also use
 // this instead of a persistent cache.
+//
+// The cache is safe for concurrent use by multiple goroutines.
 func New() types.BlobInfoCache {
 	return new2()
 }
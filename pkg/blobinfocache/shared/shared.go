@@ -0,0 +1,235 @@
+// Package shared implements a BlobInfoCache shared by many processes through a Unix domain socket, without
+// running a separate daemon: one of the processes serves the cache to the others.
+//
+// This is intended for heavy parallel workloads, e.g. many concurrent mirroring processes, where the per-operation
+// locking and reopening of a persistent cache (see pkg/blobinfocache/boltdb) would be a bottleneck.
+package shared
+
+import (
+	"net"
+	"net/rpc"
+	"os"
+	"sync"
+
+	"github.com/containers/image/v5/internal/blobinfocache"
+	"github.com/containers/image/v5/pkg/blobinfocache/internal/protocol"
+	"github.com/containers/image/v5/types"
+	"github.com/containers/storage/pkg/lockfile"
+	digest "github.com/opencontainers/go-digest"
+	"github.com/pkg/errors"
+	"github.com/sirupsen/logrus"
+)
+
+// Serve serves cache to clients connecting to l (typically using New), until l is closed; at that point, it also
+// closes all connections, so that the clients stop using it.
+// The cache must be safe for concurrent use; all implementations in pkg/blobinfocache are.
+func Serve(l net.Listener, cache types.BlobInfoCache) error {
+	server := rpc.NewServer()
+	if err := server.RegisterName(protocol.ServiceName, &protocol.Service{Cache: blobinfocache.FromBlobInfoCache(cache)}); err != nil {
+		return err
+	}
+	connsMutex := sync.Mutex{}
+	conns := map[net.Conn]struct{}{}
+	defer func() {
+		connsMutex.Lock()
+		defer connsMutex.Unlock()
+		for conn := range conns {
+			conn.Close()
+		}
+	}()
+	for {
+		conn, err := l.Accept()
+		if err != nil {
+			if errors.Is(err, net.ErrClosed) {
+				return nil
+			}
+			return err
+		}
+		connsMutex.Lock()
+		conns[conn] = struct{}{}
+		connsMutex.Unlock()
+		go func() {
+			server.ServeConn(conn) // Closes conn
+			connsMutex.Lock()
+			delete(conns, conn)
+			connsMutex.Unlock()
+		}()
+	}
+}
+
+// Cache is a BlobInfoCache shared through a Unix domain socket; see New.
+// It is safe for concurrent use.
+type Cache struct {
+	socketPath string
+	backend    blobinfocache.BlobInfoCache2
+	listener   net.Listener // Set if this process serves backend to others.
+
+	mutex  sync.Mutex  // Protects client
+	client *rpc.Client // Set while this process uses a cache served by another process.
+}
+
+// New returns a BlobInfoCache shared by all processes which call New with the same socketPath.
+// The first such process serves backend to the others (using Serve), which access it through the socket;
+// all processes should use the same backend, e.g. a boltdb cache with the same path.
+//
+// If the serving process exits, the others use backend directly, and the next call to New serves it again.
+// The caller must call Close when the cache is no longer needed; a serving process stops serving at that point.
+func New(socketPath string, backend types.BlobInfoCache) (*Cache, error) {
+	// The lock ensures that processes starting concurrently agree on a single server.
+	lock, err := lockfile.GetLockfile(socketPath + ".lock")
+	if err != nil {
+		return nil, err
+	}
+	lock.Lock()
+	defer lock.Unlock()
+
+	c := &Cache{
+		socketPath: socketPath,
+		backend:    blobinfocache.FromBlobInfoCache(backend),
+	}
+	conn, err := net.Dial("unix", socketPath)
+	if err == nil {
+		logrus.Debugf("Using blob info cache served at %s", socketPath)
+		c.client = rpc.NewClient(conn)
+		return c, nil
+	}
+	logrus.Debugf("Error connecting to blob info cache at %s, serving it: %v", socketPath, err)
+
+	// The socket may have been left behind by a process which has exited.
+	if err := os.Remove(socketPath); err != nil && !os.IsNotExist(err) {
+		return nil, err
+	}
+	l, err := net.Listen("unix", socketPath)
+	if err != nil {
+		return nil, errors.Wrapf(err, "serving blob info cache at %s", socketPath)
+	}
+	c.listener = l
+	go func() {
+		if err := Serve(l, backend); err != nil {
+			logrus.Warnf("Error serving blob info cache at %s: %v", socketPath, err)
+		}
+	}()
+	return c, nil
+}
+
+// Close stops using the shared cache, and stops serving it if this process serves it.
+func (c *Cache) Close() error {
+	c.mutex.Lock()
+	client := c.client
+	c.client = nil
+	c.mutex.Unlock()
+	if client != nil {
+		return client.Close()
+	}
+	if c.listener != nil {
+		return c.listener.Close()
+	}
+	return nil
+}
+
+// call calls method with args and reply using the cache served by another process, if any.
+// It returns false if the call was not made; the caller should use c.backend instead.
+func (c *Cache) call(method string, args interface{}, reply interface{}) bool {
+	c.mutex.Lock()
+	client := c.client
+	c.mutex.Unlock()
+	if client == nil {
+		return false
+	}
+	if err := client.Call(protocol.ServiceName+"."+method, args, reply); err != nil {
+		// The service never fails, so this is a communication failure, most likely because the serving process has exited.
+		logrus.Debugf("Error using blob info cache served at %s, using the cache directly: %v", c.socketPath, err)
+		c.mutex.Lock()
+		if c.client == client {
+			c.client = nil
+			_ = client.Close()
+		}
+		c.mutex.Unlock()
+		return false
+	}
+	return true
+}
+
+// UncompressedDigest returns an uncompressed digest corresponding to anyDigest.
+// May return anyDigest if it is known to be uncompressed.
+// Returns "" if nothing is known about the digest (it may be compressed or uncompressed).
+func (c *Cache) UncompressedDigest(anyDigest digest.Digest) digest.Digest {
+	var res digest.Digest
+	if c.call("UncompressedDigest", anyDigest, &res) {
+		return res
+	}
+	return c.backend.UncompressedDigest(anyDigest)
+}
+
+// RecordDigestUncompressedPair records that the uncompressed version of anyDigest is uncompressed.
+// It’s allowed for anyDigest == uncompressed.
+// WARNING: Only call this for LOCALLY VERIFIED data; don’t record a digest pair just because some remote author claims so (e.g.
+// because a manifest/config pair exists); otherwise the cache could be poisoned and allow substituting unexpected blobs.
+// (Eventually, the DiffIDs in image config could detect the substitution, but that may be too late, and not all image formats contain that data.)
+func (c *Cache) RecordDigestUncompressedPair(anyDigest digest.Digest, uncompressed digest.Digest) {
+	if !c.call("RecordDigestUncompressedPair", protocol.DigestPair{AnyDigest: anyDigest, Uncompressed: uncompressed}, &protocol.Empty{}) {
+		c.backend.RecordDigestUncompressedPair(anyDigest, uncompressed)
+	}
+}
+
+// RecordDigestCompressorName records that the blob with digest anyDigest was compressed with the specified
+// compressor, or is blobinfocache.Uncompressed.
+// WARNING: Only call this for LOCALLY VERIFIED data; don’t record a digest pair just because some remote author claims so (e.g.
+// because a manifest/config pair exists); otherwise the cache could be poisoned and allow substituting unexpected blobs.
+// (Eventually, the DiffIDs in image config could detect the substitution, but that may be too late, and not all image formats contain that data.)
+func (c *Cache) RecordDigestCompressorName(anyDigest digest.Digest, compressorName string) {
+	if !c.call("RecordDigestCompressorName", protocol.DigestCompressorName{AnyDigest: anyDigest, CompressorName: compressorName}, &protocol.Empty{}) {
+		c.backend.RecordDigestCompressorName(anyDigest, compressorName)
+	}
+}
+
+// RecordKnownLocation records that a blob with the specified digest exists within the specified (transport, scope) scope,
+// and can be reused given the opaque location data.
+func (c *Cache) RecordKnownLocation(transport types.ImageTransport, scope types.BICTransportScope, blobDigest digest.Digest, location types.BICLocationReference) {
+	if !c.call("RecordKnownLocation", protocol.KnownLocation{
+		Transport:  transport.Name(),
+		Scope:      scope,
+		BlobDigest: blobDigest,
+		Location:   location,
+	}, &protocol.Empty{}) {
+		c.backend.RecordKnownLocation(transport, scope, blobDigest, location)
+	}
+}
+
+// CandidateLocations returns a prioritized, limited, number of blobs and their locations that could possibly be reused
+// within the specified (transport scope) (if they still exist, which is not guaranteed).
+//
+// If !canSubstitute, the returned cadidates will match the submitted digest exactly; if canSubstitute,
+// data from previous RecordDigestUncompressedPair calls is used to also look up variants of the blob which have the same
+// uncompressed digest.
+func (c *Cache) CandidateLocations(transport types.ImageTransport, scope types.BICTransportScope, primaryDigest digest.Digest, canSubstitute bool) []types.BICReplacementCandidate {
+	res := []types.BICReplacementCandidate{}
+	if c.call("CandidateLocations", candidateLocationsArgs(transport, scope, primaryDigest, canSubstitute), &res) {
+		return res
+	}
+	return c.backend.CandidateLocations(transport, scope, primaryDigest, canSubstitute)
+}
+
+// CandidateLocations2 returns a prioritized, limited, number of blobs and their locations that could possibly be reused
+// within the specified (transport scope) (if they still exist, which is not guaranteed).
+//
+// If !canSubstitute, the returned candidates will match the submitted digest exactly; if canSubstitute,
+// data from previous RecordDigestUncompressedPair calls is used to also look up variants of the blob which have the same
+// uncompressed digest.
+func (c *Cache) CandidateLocations2(transport types.ImageTransport, scope types.BICTransportScope, primaryDigest digest.Digest, canSubstitute bool) []blobinfocache.BICReplacementCandidate2 {
+	res := []blobinfocache.BICReplacementCandidate2{}
+	if c.call("CandidateLocations2", candidateLocationsArgs(transport, scope, primaryDigest, canSubstitute), &res) {
+		return res
+	}
+	return c.backend.CandidateLocations2(transport, scope, primaryDigest, canSubstitute)
+}
+
+// candidateLocationsArgs returns the protocol arguments of a CandidateLocations or CandidateLocations2 call.
+func candidateLocationsArgs(transport types.ImageTransport, scope types.BICTransportScope, primaryDigest digest.Digest, canSubstitute bool) protocol.CandidateLocationsArgs {
+	return protocol.CandidateLocationsArgs{
+		Transport:     transport.Name(),
+		Scope:         scope,
+		PrimaryDigest: primaryDigest,
+		CanSubstitute: canSubstitute,
+	}
+}
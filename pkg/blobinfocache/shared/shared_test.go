@@ -0,0 +1,73 @@
+package shared
+
+import (
+	"path/filepath"
+	"testing"
+
+	"github.com/containers/image/v5/internal/blobinfocache"
+	"github.com/containers/image/v5/internal/testing/mocks"
+	"github.com/containers/image/v5/pkg/blobinfocache/internal/test"
+	"github.com/containers/image/v5/pkg/blobinfocache/memory"
+	"github.com/containers/image/v5/types"
+	digest "github.com/opencontainers/go-digest"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+var _ blobinfocache.BlobInfoCache2 = &Cache{}
+
+func newTestCache(t *testing.T) blobinfocache.BlobInfoCache2 {
+	socketPath := filepath.Join(t.TempDir(), "socket")
+	server, err := New(socketPath, memory.New())
+	require.NoError(t, err)
+	t.Cleanup(func() { _ = server.Close() })
+	require.NotNil(t, server.listener)
+
+	// The test uses a client, with a different backend, to make sure all operations go through the server.
+	client, err := New(socketPath, memory.New())
+	require.NoError(t, err)
+	t.Cleanup(func() { _ = client.Close() })
+	require.NotNil(t, client.client)
+	return client
+}
+
+func TestNew(t *testing.T) {
+	test.GenericCache(t, newTestCache)
+}
+
+func TestCacheServerExit(t *testing.T) {
+	const digestCompressed = digest.Digest("sha256:3333333333333333333333333333333333333333333333333333333333333333")
+	const digestUncompressed = digest.Digest("sha256:2222222222222222222222222222222222222222222222222222222222222222")
+	transport := mocks.NameImageTransport("==BlobInfocache transport mock")
+	scope := types.BICTransportScope{Opaque: "A"}
+	socketPath := filepath.Join(t.TempDir(), "socket")
+
+	server, err := New(socketPath, memory.New())
+	require.NoError(t, err)
+	backend := memory.New()
+	client, err := New(socketPath, backend)
+	require.NoError(t, err)
+	defer client.Close()
+
+	client.RecordDigestUncompressedPair(digestCompressed, digestUncompressed)
+	client.RecordKnownLocation(transport, scope, digestCompressed, types.BICLocationReference{Opaque: "1"})
+	assert.Equal(t, digestUncompressed, server.UncompressedDigest(digestCompressed))
+	assert.Equal(t, digest.Digest(""), backend.UncompressedDigest(digestCompressed))
+
+	// After the server exits, the client uses its backend
+	require.NoError(t, server.Close())
+	assert.Equal(t, digest.Digest(""), client.UncompressedDigest(digestCompressed))
+	client.RecordDigestUncompressedPair(digestCompressed, digestUncompressed)
+	assert.Equal(t, digestUncompressed, backend.UncompressedDigest(digestCompressed))
+	assert.Nil(t, client.client)
+
+	// A new cache takes over serving, even if the socket was left behind
+	server, err = New(socketPath, backend)
+	require.NoError(t, err)
+	defer server.Close()
+	require.NotNil(t, server.listener)
+	client2, err := New(socketPath, memory.New())
+	require.NoError(t, err)
+	defer client2.Close()
+	assert.Equal(t, digestUncompressed, client2.UncompressedDigest(digestCompressed))
+}
@@ -0,0 +1,103 @@
+package contenttrust
+
+import (
+	"crypto"
+	"crypto/ecdsa"
+	"crypto/ed25519"
+	"crypto/sha256"
+	"crypto/x509"
+	"encoding/hex"
+	"encoding/json"
+	"encoding/pem"
+	"math/big"
+
+	"github.com/pkg/errors"
+)
+
+// PublicKey is a public key trusted to sign TUF metadata.
+type PublicKey struct {
+	// ID is the TUF key ID, the hex-encoded SHA-256 digest of the canonical JSON representation of the key.
+	ID  string
+	key crypto.PublicKey
+}
+
+// tufKey is the JSON representation of a key in TUF metadata, as used by Notary.
+type tufKey struct {
+	KeyType string `json:"keytype"`
+	KeyVal  struct {
+		Public []byte `json:"public"` // base64-encoded in JSON
+	} `json:"keyval"`
+}
+
+// ParsePublicKey parses a key in the JSON format used in TUF metadata by Notary,
+// e.g. {"keytype":"ecdsa","keyval":{"private":null,"public":"…"}}.
+// Supported key types are "ed25519", "ecdsa" and "ecdsa-x509".
+func ParsePublicKey(data []byte) (PublicKey, error) {
+	var k tufKey
+	if err := json.Unmarshal(data, &k); err != nil {
+		return PublicKey{}, errors.Wrap(err, "parsing TUF key")
+	}
+	canonical, err := canonicalJSON(data)
+	if err != nil {
+		return PublicKey{}, err
+	}
+	id := sha256.Sum256(canonical)
+
+	var key crypto.PublicKey
+	switch k.KeyType {
+	case "ed25519":
+		if len(k.KeyVal.Public) != ed25519.PublicKeySize {
+			return PublicKey{}, errors.Errorf("invalid ed25519 public key length %d", len(k.KeyVal.Public))
+		}
+		key = ed25519.PublicKey(k.KeyVal.Public)
+	case "ecdsa":
+		key, err = x509.ParsePKIXPublicKey(k.KeyVal.Public)
+		if err != nil {
+			return PublicKey{}, errors.Wrap(err, "parsing ecdsa public key")
+		}
+	case "ecdsa-x509":
+		block, _ := pem.Decode(k.KeyVal.Public)
+		if block == nil {
+			return PublicKey{}, errors.New("ecdsa-x509 key does not contain a PEM-encoded certificate")
+		}
+		cert, err := x509.ParseCertificate(block.Bytes)
+		if err != nil {
+			return PublicKey{}, errors.Wrap(err, "parsing ecdsa-x509 certificate")
+		}
+		key = cert.PublicKey
+	default:
+		return PublicKey{}, errors.Errorf("unsupported TUF key type %q", k.KeyType)
+	}
+	if _, ok := key.(ed25519.PublicKey); !ok {
+		if _, ok := key.(*ecdsa.PublicKey); !ok {
+			return PublicKey{}, errors.Errorf("key of type %q is a %T, not an ECDSA key", k.KeyType, key)
+		}
+	}
+	return PublicKey{ID: hex.EncodeToString(id[:]), key: key}, nil
+}
+
+// verify returns nil if sig is a valid signature of data by k.
+func (k PublicKey) verify(data, sig []byte) error {
+	switch key := k.key.(type) {
+	case ed25519.PublicKey:
+		if !ed25519.Verify(key, data, sig) {
+			return errors.Errorf("invalid signature by key %s", k.ID)
+		}
+		return nil
+	case *ecdsa.PublicKey:
+		// Notary uses raw r||s signatures, not ASN.1.
+		size := (key.Params().BitSize + 7) / 8
+		if len(sig) != 2*size {
+			return errors.Errorf("invalid signature length %d for key %s", len(sig), k.ID)
+		}
+		r := new(big.Int).SetBytes(sig[:size])
+		s := new(big.Int).SetBytes(sig[size:])
+		digest := sha256.Sum256(data)
+		if !ecdsa.Verify(key, digest[:], r, s) {
+			return errors.Errorf("invalid signature by key %s", k.ID)
+		}
+		return nil
+	default:
+		return errors.Errorf("Internal error: unexpected key type %T", k.key)
+	}
+}
@@ -0,0 +1,143 @@
+// Package contenttrust maps image tags to trusted digests using TUF targets metadata, as published
+// by Notary v1 servers for Docker Content Trust, for use as types.SystemContext.DockerTagResolver.
+//
+// Only the targets metadata (of the top-level "targets" role, or of a delegated role) is verified, against keys and a
+// signature threshold configured by the caller; the root, snapshot and timestamp roles are not consulted.
+// Freshness is enforced using the expiration time of the targets metadata and, optionally, a minimum version.
+package contenttrust
+
+import (
+	"bytes"
+	"encoding/hex"
+	"encoding/json"
+	"time"
+
+	"github.com/opencontainers/go-digest"
+	"github.com/pkg/errors"
+)
+
+// signedMetadata is the envelope of signed TUF metadata.
+type signedMetadata struct {
+	Signed     json.RawMessage `json:"signed"`
+	Signatures []struct {
+		KeyID     string `json:"keyid"`
+		Method    string `json:"method"`
+		Signature []byte `json:"sig"` // base64-encoded in JSON
+	} `json:"signatures"`
+}
+
+// targetsMetadata is the signed contents of TUF targets metadata.
+type targetsMetadata struct {
+	Type    string                `json:"_type"`
+	Expires time.Time             `json:"expires"`
+	Version int                   `json:"version"`
+	Targets map[string]targetFile `json:"targets"`
+}
+
+// targetFile describes a single target, in Notary’s usage a tag.
+type targetFile struct {
+	Length int64             `json:"length"`
+	Hashes map[string][]byte `json:"hashes"` // base64-encoded in JSON
+}
+
+// TrustedKeys are the keys trusted to sign targets metadata of a repository, and freshness requirements.
+type TrustedKeys struct {
+	Keys []PublicKey
+	// Threshold is the number of distinct keys from Keys which must sign the metadata; values < 1 mean 1.
+	Threshold int
+	// MinVersion, if > 0, is the minimum accepted version of the metadata, to prevent rollback attacks.
+	MinVersion int
+}
+
+// Targets is verified TUF targets metadata.
+type Targets struct {
+	Version int
+	Expires time.Time
+	targets map[string]targetFile
+}
+
+// VerifyTargets parses targets metadata in raw, verifies that it is signed by keys.Threshold of keys.Keys
+// and satisfies keys.MinVersion, and that it does not expire before now.
+func VerifyTargets(raw []byte, keys TrustedKeys, now time.Time) (*Targets, error) {
+	var envelope signedMetadata
+	if err := json.Unmarshal(raw, &envelope); err != nil {
+		return nil, errors.Wrap(err, "parsing TUF metadata")
+	}
+	if len(envelope.Signed) == 0 {
+		return nil, errors.New("TUF metadata does not contain signed contents")
+	}
+	canonical, err := canonicalJSON(envelope.Signed)
+	if err != nil {
+		return nil, err
+	}
+
+	threshold := keys.Threshold
+	if threshold < 1 {
+		threshold = 1
+	}
+	validKeys := map[string]struct{}{}
+	for _, sig := range envelope.Signatures {
+		for _, key := range keys.Keys {
+			if key.ID != sig.KeyID {
+				continue
+			}
+			if err := key.verify(canonical, sig.Signature); err == nil {
+				validKeys[key.ID] = struct{}{}
+			}
+		}
+	}
+	if len(validKeys) < threshold {
+		return nil, errors.Errorf("TUF metadata has %d valid signatures by trusted keys, %d required", len(validKeys), threshold)
+	}
+
+	// Only parse the contents after verifying signatures.
+	var targets targetsMetadata
+	if err := json.Unmarshal(envelope.Signed, &targets); err != nil {
+		return nil, errors.Wrap(err, "parsing TUF targets metadata")
+	}
+	if targets.Type != "Targets" && targets.Type != "targets" {
+		return nil, errors.Errorf("unexpected TUF metadata type %q, expected targets", targets.Type)
+	}
+	if !now.Before(targets.Expires) {
+		return nil, errors.Errorf("TUF targets metadata expired at %s", targets.Expires.Format(time.RFC3339))
+	}
+	if keys.MinVersion > 0 && targets.Version < keys.MinVersion {
+		return nil, errors.Errorf("TUF targets metadata version %d is older than the required version %d", targets.Version, keys.MinVersion)
+	}
+	return &Targets{Version: targets.Version, Expires: targets.Expires, targets: targets.Targets}, nil
+}
+
+// Digest returns the trusted digest of the manifest for tag, or "" if t does not contain tag.
+func (t *Targets) Digest(tag string) (digest.Digest, error) {
+	target, ok := t.targets[tag]
+	if !ok {
+		return "", nil
+	}
+	hash, ok := target.Hashes["sha256"]
+	if !ok {
+		return "", errors.Errorf("TUF target %q does not have a sha256 hash", tag)
+	}
+	d := digest.NewDigestFromEncoded(digest.SHA256, hex.EncodeToString(hash))
+	if err := d.Validate(); err != nil {
+		return "", errors.Wrapf(err, "invalid sha256 hash of TUF target %q", tag)
+	}
+	return d, nil
+}
+
+// canonicalJSON returns the canonical JSON representation of raw, which is signed in TUF metadata:
+// object keys are sorted, there is no insignificant whitespace, and numbers are not reformatted.
+func canonicalJSON(raw []byte) ([]byte, error) {
+	decoder := json.NewDecoder(bytes.NewReader(raw))
+	decoder.UseNumber()
+	var value interface{}
+	if err := decoder.Decode(&value); err != nil {
+		return nil, errors.Wrap(err, "parsing JSON")
+	}
+	buf := bytes.Buffer{}
+	encoder := json.NewEncoder(&buf)
+	encoder.SetEscapeHTML(false)
+	if err := encoder.Encode(value); err != nil {
+		return nil, errors.Wrap(err, "encoding canonical JSON")
+	}
+	return bytes.TrimSuffix(buf.Bytes(), []byte("\n")), nil
+}
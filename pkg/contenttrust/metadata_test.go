@@ -0,0 +1,181 @@
+package contenttrust
+
+import (
+	"crypto"
+	"crypto/ecdsa"
+	"crypto/ed25519"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/sha256"
+	"crypto/x509"
+	"encoding/hex"
+	"encoding/json"
+	"testing"
+	"time"
+
+	"github.com/opencontainers/go-digest"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// testKey is a private key used to sign test metadata, and its public counterpart.
+type testKey struct {
+	public  PublicKey
+	private crypto.Signer
+}
+
+func newTestKey(t *testing.T, keyType string) testKey {
+	var private crypto.Signer
+	var public []byte
+	switch keyType {
+	case "ed25519":
+		pub, priv, err := ed25519.GenerateKey(rand.Reader)
+		require.NoError(t, err)
+		private, public = priv, pub
+	case "ecdsa":
+		priv, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+		require.NoError(t, err)
+		public, err = x509.MarshalPKIXPublicKey(&priv.PublicKey)
+		require.NoError(t, err)
+		private = priv
+	default:
+		t.Fatalf("unknown key type %q", keyType)
+	}
+	keyJSON, err := json.Marshal(map[string]interface{}{
+		"keytype": keyType,
+		"keyval":  map[string]interface{}{"private": nil, "public": public},
+	})
+	require.NoError(t, err)
+	pk, err := ParsePublicKey(keyJSON)
+	require.NoError(t, err)
+	return testKey{public: pk, private: private}
+}
+
+func (k testKey) sign(t *testing.T, data []byte) []byte {
+	switch priv := k.private.(type) {
+	case ed25519.PrivateKey:
+		return ed25519.Sign(priv, data)
+	case *ecdsa.PrivateKey:
+		d := sha256.Sum256(data)
+		r, s, err := ecdsa.Sign(rand.Reader, priv, d[:])
+		require.NoError(t, err)
+		sig := make([]byte, 64)
+		r.FillBytes(sig[:32])
+		s.FillBytes(sig[32:])
+		return sig
+	default:
+		t.Fatalf("unexpected key type %T", k.private)
+		return nil
+	}
+}
+
+// signedTargets returns targets metadata with targets and the specified properties, signed by keys.
+func signedTargets(t *testing.T, targets map[string]digest.Digest, version int, expires time.Time, keys ...testKey) []byte {
+	files := map[string]interface{}{}
+	for tag, d := range targets {
+		hash, err := digestHash(d)
+		require.NoError(t, err)
+		files[tag] = map[string]interface{}{"length": 1234, "hashes": map[string]interface{}{"sha256": hash}}
+	}
+	signed, err := json.Marshal(map[string]interface{}{
+		"_type":   "Targets",
+		"expires": expires,
+		"version": version,
+		"targets": files,
+	})
+	require.NoError(t, err)
+	canonical, err := canonicalJSON(signed)
+	require.NoError(t, err)
+	signatures := []interface{}{}
+	for _, k := range keys {
+		signatures = append(signatures, map[string]interface{}{"keyid": k.public.ID, "method": "test", "sig": k.sign(t, canonical)})
+	}
+	res, err := json.Marshal(map[string]interface{}{"signed": json.RawMessage(signed), "signatures": signatures})
+	require.NoError(t, err)
+	return res
+}
+
+func digestHash(d digest.Digest) ([]byte, error) {
+	return hex.DecodeString(d.Encoded())
+}
+
+func TestParsePublicKey(t *testing.T) {
+	k1 := newTestKey(t, "ed25519")
+	k2 := newTestKey(t, "ecdsa")
+	assert.Len(t, k1.public.ID, 64)
+	assert.NotEqual(t, k1.public.ID, k2.public.ID)
+
+	for _, data := range []string{
+		`not JSON`,
+		`{"keytype":"rsa","keyval":{"public":""}}`,
+		`{"keytype":"ed25519","keyval":{"public":"AAAA"}}`,
+		`{"keytype":"ecdsa","keyval":{"public":"AAAA"}}`,
+		`{"keytype":"ecdsa-x509","keyval":{"public":"AAAA"}}`,
+	} {
+		_, err := ParsePublicKey([]byte(data))
+		assert.Error(t, err, data)
+	}
+}
+
+func TestVerifyTargets(t *testing.T) {
+	k1 := newTestKey(t, "ed25519")
+	k2 := newTestKey(t, "ecdsa")
+	untrusted := newTestKey(t, "ed25519")
+	now := time.Date(2020, 1, 1, 0, 0, 0, 0, time.UTC)
+	expires := now.Add(time.Hour)
+	d := digest.FromString("manifest")
+	tags := map[string]digest.Digest{"latest": d}
+
+	// Valid metadata
+	for _, c := range []struct {
+		keys   TrustedKeys
+		signed []byte
+	}{
+		{TrustedKeys{Keys: []PublicKey{k1.public}}, signedTargets(t, tags, 1, expires, k1)},
+		{TrustedKeys{Keys: []PublicKey{k2.public}}, signedTargets(t, tags, 1, expires, k2)},
+		{TrustedKeys{Keys: []PublicKey{k1.public, k2.public}, Threshold: 2}, signedTargets(t, tags, 1, expires, untrusted, k1, k2)},
+		{TrustedKeys{Keys: []PublicKey{k1.public}, MinVersion: 3}, signedTargets(t, tags, 3, expires, k1)},
+	} {
+		targets, err := VerifyTargets(c.signed, c.keys, now)
+		require.NoError(t, err)
+		res, err := targets.Digest("latest")
+		require.NoError(t, err)
+		assert.Equal(t, d, res)
+		res, err = targets.Digest("missing")
+		require.NoError(t, err)
+		assert.Equal(t, digest.Digest(""), res)
+	}
+
+	// Invalid metadata
+	tampered := signedTargets(t, tags, 1, expires, k1)
+	var envelope map[string]json.RawMessage
+	err := json.Unmarshal(tampered, &envelope)
+	require.NoError(t, err)
+	envelope["signed"] = json.RawMessage(`{"_type":"Targets","expires":"2030-01-01T00:00:00Z","version":1,"targets":{}}`)
+	tampered, err = json.Marshal(envelope)
+	require.NoError(t, err)
+	for _, c := range []struct {
+		keys   TrustedKeys
+		signed []byte
+	}{
+		{TrustedKeys{Keys: []PublicKey{k1.public}}, []byte("not JSON")},
+		{TrustedKeys{Keys: []PublicKey{k1.public}}, []byte(`{"signatures":[]}`)},
+		{TrustedKeys{Keys: []PublicKey{k1.public}}, signedTargets(t, tags, 1, expires)},
+		{TrustedKeys{Keys: []PublicKey{k1.public}}, signedTargets(t, tags, 1, expires, untrusted)},
+		{TrustedKeys{Keys: []PublicKey{k1.public}}, tampered},
+		{TrustedKeys{Keys: []PublicKey{k1.public, k2.public}, Threshold: 2}, signedTargets(t, tags, 1, expires, k1, k1)},
+		{TrustedKeys{Keys: []PublicKey{k1.public}}, signedTargets(t, tags, 1, now, k1)},
+		{TrustedKeys{Keys: []PublicKey{k1.public}, MinVersion: 3}, signedTargets(t, tags, 2, expires, k1)},
+	} {
+		_, err := VerifyTargets(c.signed, c.keys, now)
+		assert.Error(t, err)
+	}
+}
+
+func TestCanonicalJSON(t *testing.T) {
+	res, err := canonicalJSON([]byte(`{ "b": 1.50, "a": ["<&>", {"d": null, "c": true}] }`))
+	require.NoError(t, err)
+	assert.Equal(t, `{"a":["<&>",{"c":true,"d":null}],"b":1.50}`, string(res))
+	_, err = canonicalJSON([]byte(`{`))
+	assert.Error(t, err)
+}
@@ -0,0 +1,101 @@
+package contenttrust
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"strings"
+	"time"
+
+	"github.com/containers/image/v5/docker/reference"
+	"github.com/containers/image/v5/internal/iolimits"
+	"github.com/opencontainers/go-digest"
+	"github.com/pkg/errors"
+)
+
+// Resolver maps tags to trusted digests using TUF targets metadata.
+// Its ResolveTag method can be used as types.SystemContext.DockerTagResolver.
+type Resolver struct {
+	// ServerURL is the base URL of a Notary server, e.g. "https://notary.example.com".
+	// It is used to fetch metadata unless Fetch is set.
+	ServerURL string
+	// HTTPClient is used to access ServerURL; if nil, http.DefaultClient is used.
+	HTTPClient *http.Client
+	// Fetch, if not nil, returns the metadata of role for repository (the name of a reference, e.g. "docker.io/library/busybox"),
+	// e.g. from a TUF repository which is not served by Notary.  ServerURL and HTTPClient are not used.
+	Fetch func(ctx context.Context, repository, role string) ([]byte, error)
+	// Role is the targets role whose metadata is used, e.g. "targets/releases"; if "", the top-level "targets" role is used.
+	Role string
+	// TrustedKeys returns the keys trusted to sign the metadata of repository (the name of a reference),
+	// or an error if repository is not trusted.
+	TrustedKeys func(repository string) (TrustedKeys, error)
+	// Now returns the current time, used to check expiration of metadata; if nil, time.Now is used.
+	Now func() time.Time
+	// AllowMissingTags, if true, allows resolving tags which are not present in the metadata; the tag is then used without
+	// verification.  Otherwise, resolving such tags fails.
+	AllowMissingTags bool
+}
+
+// ResolveTag returns the trusted digest of the image ref refers to.
+func (r *Resolver) ResolveTag(ctx context.Context, ref reference.NamedTagged) (digest.Digest, error) {
+	if r.TrustedKeys == nil {
+		return "", errors.New("no trusted keys configured for content trust")
+	}
+	repository := ref.Name()
+	keys, err := r.TrustedKeys(repository)
+	if err != nil {
+		return "", err
+	}
+	role := r.Role
+	if role == "" {
+		role = "targets"
+	}
+	raw, err := r.fetch(ctx, repository, role)
+	if err != nil {
+		return "", errors.Wrapf(err, "fetching trust metadata for %s", repository)
+	}
+	now := time.Now
+	if r.Now != nil {
+		now = r.Now
+	}
+	targets, err := VerifyTargets(raw, keys, now())
+	if err != nil {
+		return "", errors.Wrapf(err, "verifying trust metadata for %s", repository)
+	}
+	d, err := targets.Digest(ref.Tag())
+	if err != nil {
+		return "", err
+	}
+	if d == "" && !r.AllowMissingTags {
+		return "", errors.Errorf("no trust data for %s", reference.FamiliarString(ref))
+	}
+	return d, nil
+}
+
+// fetch returns the metadata of role for repository.
+func (r *Resolver) fetch(ctx context.Context, repository, role string) ([]byte, error) {
+	if r.Fetch != nil {
+		return r.Fetch(ctx, repository, role)
+	}
+	if r.ServerURL == "" {
+		return nil, errors.New("no Notary server configured")
+	}
+	url := fmt.Sprintf("%s/v2/%s/_trust/tuf/%s.json", strings.TrimSuffix(r.ServerURL, "/"), repository, role)
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, url, nil)
+	if err != nil {
+		return nil, err
+	}
+	client := r.HTTPClient
+	if client == nil {
+		client = http.DefaultClient
+	}
+	res, err := client.Do(req)
+	if err != nil {
+		return nil, err
+	}
+	defer res.Body.Close()
+	if res.StatusCode != http.StatusOK {
+		return nil, errors.Errorf("reading %s: status %d (%s)", url, res.StatusCode, http.StatusText(res.StatusCode))
+	}
+	return iolimits.ReadAtMost(res.Body, iolimits.MaxTUFMetadataBodySize)
+}
@@ -0,0 +1,92 @@
+package contenttrust
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/containers/image/v5/docker/reference"
+	"github.com/opencontainers/go-digest"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestResolverResolveTag(t *testing.T) {
+	key := newTestKey(t, "ecdsa")
+	now := time.Date(2020, 1, 1, 0, 0, 0, 0, time.UTC)
+	d := digest.FromString("manifest")
+	metadata := signedTargets(t, map[string]digest.Digest{"latest": d}, 1, now.Add(time.Hour), key)
+	releases := signedTargets(t, map[string]digest.Digest{"stable": d}, 1, now.Add(time.Hour), key)
+
+	s := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		switch r.URL.Path {
+		case "/v2/registry.example.com/ns/repo/_trust/tuf/targets.json":
+			_, _ = w.Write(metadata)
+		case "/v2/registry.example.com/ns/repo/_trust/tuf/targets/releases.json":
+			_, _ = w.Write(releases)
+		default:
+			w.WriteHeader(http.StatusNotFound)
+		}
+	}))
+	defer s.Close()
+
+	trustedKeys := func(repository string) (TrustedKeys, error) {
+		if repository != "registry.example.com/ns/repo" {
+			return TrustedKeys{}, errors.New("untrusted repository")
+		}
+		return TrustedKeys{Keys: []PublicKey{key.public}}, nil
+	}
+	r := &Resolver{ServerURL: s.URL + "/", TrustedKeys: trustedKeys, Now: func() time.Time { return now }}
+	resolve := func(r *Resolver, ref string) (digest.Digest, error) {
+		named, err := reference.ParseNormalizedNamed(ref)
+		require.NoError(t, err)
+		tagged, ok := named.(reference.NamedTagged)
+		require.True(t, ok)
+		return r.ResolveTag(context.Background(), tagged)
+	}
+
+	res, err := resolve(r, "registry.example.com/ns/repo:latest")
+	require.NoError(t, err)
+	assert.Equal(t, d, res)
+	_, err = resolve(r, "registry.example.com/ns/repo:missing")
+	assert.Error(t, err)
+	_, err = resolve(r, "registry.example.com/other:latest")
+	assert.Error(t, err)
+
+	withMissing := *r
+	withMissing.AllowMissingTags = true
+	res, err = resolve(&withMissing, "registry.example.com/ns/repo:missing")
+	require.NoError(t, err)
+	assert.Equal(t, digest.Digest(""), res)
+
+	delegated := *r
+	delegated.Role = "targets/releases"
+	res, err = resolve(&delegated, "registry.example.com/ns/repo:stable")
+	require.NoError(t, err)
+	assert.Equal(t, d, res)
+
+	expired := *r
+	expired.Now = func() time.Time { return now.Add(2 * time.Hour) }
+	_, err = resolve(&expired, "registry.example.com/ns/repo:latest")
+	assert.Error(t, err)
+
+	fetched := &Resolver{
+		Fetch: func(ctx context.Context, repository, role string) ([]byte, error) {
+			assert.Equal(t, "targets", role)
+			return metadata, nil
+		},
+		TrustedKeys: trustedKeys,
+		Now:         func() time.Time { return now },
+	}
+	res, err = resolve(fetched, "registry.example.com/ns/repo:latest")
+	require.NoError(t, err)
+	assert.Equal(t, d, res)
+
+	_, err = resolve(&Resolver{TrustedKeys: trustedKeys}, "registry.example.com/ns/repo:latest")
+	assert.Error(t, err)
+	_, err = resolve(&Resolver{ServerURL: s.URL}, "registry.example.com/ns/repo:latest")
+	assert.Error(t, err)
+}
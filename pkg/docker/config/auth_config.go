@@ -0,0 +1,69 @@
+package config
+
+import (
+	"github.com/containers/image/v5/internal/secret"
+	"github.com/containers/image/v5/types"
+)
+
+// authConfig is the internal representation of credentials, equivalent to types.DockerAuthConfig.
+// The password and identity token are secret.Secret values, so that they are never accidentally included
+// in log messages or error text; they are only converted to types.DockerAuthConfig when returned to callers.
+type authConfig struct {
+	username      string
+	password      secret.Secret
+	identityToken secret.Secret
+	// How identityToken can be exchanged for access tokens, if known; only available from auth files.
+	tokenEndpoint string
+	tokenService  string
+	tokenScopes   []string
+	// Paths to a TLS client certificate and its private key.
+	clientCertPath string
+	clientKeyPath  string
+}
+
+// newAuthConfig returns an authConfig equivalent to conf.
+func newAuthConfig(conf types.DockerAuthConfig) authConfig {
+	return authConfig{
+		username:       conf.Username,
+		password:       secret.New(conf.Password),
+		identityToken:  secret.New(conf.IdentityToken),
+		clientCertPath: conf.ClientCertPath,
+		clientKeyPath:  conf.ClientKeyPath,
+	}
+}
+
+// dockerAuthConfig returns a types.DockerAuthConfig equivalent to a.
+func (a authConfig) dockerAuthConfig() types.DockerAuthConfig {
+	return types.DockerAuthConfig{
+		Username:       a.username,
+		Password:       a.password.Reveal(),
+		IdentityToken:  a.identityToken.Reveal(),
+		ClientCertPath: a.clientCertPath,
+		ClientKeyPath:  a.clientKeyPath,
+	}
+}
+
+// isEmpty returns true if a contains no credentials.
+func (a authConfig) isEmpty() bool {
+	return a.username == "" && a.password.IsEmpty() && a.identityToken.IsEmpty() && a.clientCertPath == ""
+}
+
+// mergedWith returns a, with each kind of credentials which a does not contain (a username and password,
+// an identity token, or a TLS client certificate) taken from other.
+func (a authConfig) mergedWith(other authConfig) authConfig {
+	if a.username == "" && a.password.IsEmpty() {
+		a.username = other.username
+		a.password = other.password
+	}
+	if a.identityToken.IsEmpty() {
+		a.identityToken = other.identityToken
+		a.tokenEndpoint = other.tokenEndpoint
+		a.tokenService = other.tokenService
+		a.tokenScopes = other.tokenScopes
+	}
+	if a.clientCertPath == "" && a.clientKeyPath == "" {
+		a.clientCertPath = other.clientCertPath
+		a.clientKeyPath = other.clientKeyPath
+	}
+	return a
+}
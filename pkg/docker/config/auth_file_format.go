@@ -0,0 +1,76 @@
+package config
+
+import (
+	"bytes"
+	"encoding/json"
+	"path/filepath"
+	"strings"
+
+	"github.com/BurntSushi/toml"
+	"github.com/ghodss/yaml"
+)
+
+// authFileFormat is a serialization format of auth files.
+// All formats represent the same data as the JSON format, i.e. they are converted to and from JSON,
+// and then processed using the JSON representation of dockerConfigFile.
+type authFileFormat struct {
+	name     string
+	toJSON   func(raw []byte) ([]byte, error)  // Converts the contents of a file to JSON.
+	fromJSON func(data []byte) ([]byte, error) // Converts JSON to the contents of a file.
+}
+
+var (
+	jsonAuthFileFormat = authFileFormat{
+		name:     "JSON",
+		toJSON:   func(raw []byte) ([]byte, error) { return raw, nil },
+		fromJSON: func(data []byte) ([]byte, error) { return data, nil },
+	}
+	yamlAuthFileFormat = authFileFormat{
+		name:     "YAML",
+		toJSON:   yaml.YAMLToJSON,
+		fromJSON: yaml.JSONToYAML,
+	}
+	tomlAuthFileFormat = authFileFormat{
+		name:     "TOML",
+		toJSON:   tomlToJSON,
+		fromJSON: jsonToTOML,
+	}
+
+	// authFileFormatsByExtension maps file name extensions to auth file formats other than JSON.
+	authFileFormatsByExtension = map[string]authFileFormat{
+		".yaml": yamlAuthFileFormat,
+		".yml":  yamlAuthFileFormat,
+		".toml": tomlAuthFileFormat,
+	}
+)
+
+// authFileFormatForPath returns the format of the auth file at path, based on its extension.
+// Files with unrecognized extensions use the JSON format.
+func authFileFormatForPath(path string) authFileFormat {
+	if format, ok := authFileFormatsByExtension[strings.ToLower(filepath.Ext(path))]; ok {
+		return format
+	}
+	return jsonAuthFileFormat
+}
+
+// tomlToJSON converts a TOML document to JSON.
+func tomlToJSON(raw []byte) ([]byte, error) {
+	var data map[string]interface{}
+	if err := toml.Unmarshal(raw, &data); err != nil {
+		return nil, err
+	}
+	return json.Marshal(data)
+}
+
+// jsonToTOML converts a JSON object to a TOML document.
+func jsonToTOML(data []byte) ([]byte, error) {
+	var value map[string]interface{}
+	if err := json.Unmarshal(data, &value); err != nil {
+		return nil, err
+	}
+	buf := bytes.Buffer{}
+	if err := toml.NewEncoder(&buf).Encode(value); err != nil {
+		return nil, err
+	}
+	return buf.Bytes(), nil
+}
@@ -0,0 +1,114 @@
+package config
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/containers/image/v5/types"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestAuthFileFormatForPath(t *testing.T) {
+	for path, expected := range map[string]string{
+		"/auth.json":        "JSON",
+		"/.dockercfg":       "JSON",
+		"/config":           "JSON",
+		"/auth.yaml":        "YAML",
+		"/auth.yml":         "YAML",
+		"/AUTH.YAML":        "YAML",
+		"/auth.toml":        "TOML",
+		"/dir.toml/auth.js": "JSON",
+	} {
+		assert.Equal(t, expected, authFileFormatForPath(path).name, path)
+	}
+}
+
+func TestReadAuthFileFormats(t *testing.T) {
+	tmpDir := t.TempDir()
+	expected := dockerConfigFile{
+		AuthConfigs: map[string]dockerAuthConfig{
+			"example.com":       {Auth: "dXNlcjpwYXNz"},
+			"quay.io/namespace": {IdentityToken: "token", TokenScopes: []string{"repository:namespace/repo:pull"}},
+		},
+		CredHelpers: map[string]string{"registry.example.com": "helper"},
+	}
+	for name, contents := range map[string]string{
+		"auth.json": `{"auths":{"example.com":{"auth":"dXNlcjpwYXNz"},` +
+			`"quay.io/namespace":{"identitytoken":"token","tokenscopes":["repository:namespace/repo:pull"]}},` +
+			`"credHelpers":{"registry.example.com":"helper"}}`,
+		"auth.yaml": "auths:\n" +
+			"  example.com:\n" +
+			"    auth: dXNlcjpwYXNz\n" +
+			"  quay.io/namespace:\n" +
+			"    identitytoken: token\n" +
+			"    tokenscopes:\n" +
+			"    - repository:namespace/repo:pull\n" +
+			"credHelpers:\n" +
+			"  registry.example.com: helper\n",
+		"auth.toml": "[auths.\"example.com\"]\n" +
+			"auth = \"dXNlcjpwYXNz\"\n" +
+			"[auths.\"quay.io/namespace\"]\n" +
+			"identitytoken = \"token\"\n" +
+			"tokenscopes = [\"repository:namespace/repo:pull\"]\n" +
+			"[credHelpers]\n" +
+			"\"registry.example.com\" = \"helper\"\n",
+	} {
+		path := filepath.Join(tmpDir, name)
+		err := os.WriteFile(path, []byte(contents), 0600)
+		require.NoError(t, err)
+		auths, err := readJSONFile(path, false)
+		require.NoError(t, err, name)
+		assert.Equal(t, expected, auths, name)
+	}
+
+	for name, contents := range map[string]string{
+		"invalid.yaml": "auths: [",
+		"invalid.toml": "[auths",
+	} {
+		path := filepath.Join(tmpDir, name)
+		err := os.WriteFile(path, []byte(contents), 0600)
+		require.NoError(t, err)
+		_, err = readJSONFile(path, false)
+		assert.Error(t, err, name)
+	}
+}
+
+func TestWriteAuthFileFormats(t *testing.T) {
+	for _, name := range []string{"auth.yaml", "auth.toml"} {
+		authFilePath := filepath.Join(t.TempDir(), name)
+		sys := &types.SystemContext{
+			SystemRegistriesConfPath:    filepath.Join("testdata", "cred-helper-with-auth-files.conf"),
+			SystemRegistriesConfDirPath: filepath.Join("testdata", "IdoNotExist"),
+			AuthFilePath:                authFilePath,
+			CredentialHelpers:           []string{"containers-auth.json"},
+		}
+
+		_, err := StoreCredentials(sys, "example.com", "user", "pass")
+		require.NoError(t, err, name)
+		_, err = StoreCredentials(sys, "quay.io", "other", "secret")
+		require.NoError(t, err, name)
+		auth, err := getCredentialsWithHomeDir(sys, "example.com", t.TempDir())
+		require.NoError(t, err, name)
+		assert.Equal(t, types.DockerAuthConfig{Username: "user", Password: "pass"}, auth, name)
+
+		// The file is written in the format matching its name, so that other tools can read it
+		auths, err := readJSONFile(authFilePath, false)
+		require.NoError(t, err, name)
+		assert.Len(t, auths.AuthConfigs, 2, name)
+		raw, err := os.ReadFile(authFilePath)
+		require.NoError(t, err, name)
+		assert.NotContains(t, string(raw), "{", name)
+
+		err = RemoveAuthentication(sys, "example.com")
+		require.NoError(t, err, name)
+		auth, err = getCredentialsWithHomeDir(sys, "example.com", t.TempDir())
+		require.NoError(t, err, name)
+		assert.Equal(t, types.DockerAuthConfig{}, auth, name)
+
+		findings, err := ValidateAuthFile(authFilePath)
+		require.NoError(t, err, name)
+		assert.Empty(t, findings, name)
+	}
+}
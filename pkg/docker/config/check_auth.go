@@ -0,0 +1,76 @@
+package config
+
+import (
+	"context"
+	"fmt"
+	"strings"
+
+	"github.com/containers/image/v5/internal/authcheck"
+	"github.com/containers/image/v5/types"
+	"github.com/pkg/errors"
+)
+
+// CheckAuthErrorReason classifies a failure of CheckAuth.
+type CheckAuthErrorReason int
+
+const (
+	// CheckAuthInvalidCredentials means the registry, or its token server, rejected the credentials.
+	CheckAuthInvalidCredentials CheckAuthErrorReason = iota
+	// CheckAuthRegistryUnreachable means the registry could not be contacted, or did not respond as a registry.
+	CheckAuthRegistryUnreachable
+	// CheckAuthUnsupportedScheme means the registry only offers authentication schemes we don’t support.
+	CheckAuthUnsupportedScheme
+)
+
+func (r CheckAuthErrorReason) String() string {
+	switch r {
+	case CheckAuthInvalidCredentials:
+		return "invalid credentials"
+	case CheckAuthRegistryUnreachable:
+		return "registry unreachable"
+	case CheckAuthUnsupportedScheme:
+		return "unsupported authentication scheme"
+	default:
+		return fmt.Sprintf("CheckAuthErrorReason(%d)", int(r))
+	}
+}
+
+// CheckAuthError is returned by CheckAuth if the credentials could not be verified.
+type CheckAuthError struct {
+	Reason   CheckAuthErrorReason
+	Registry string
+	Err      error // The underlying error, may be nil
+}
+
+func (e *CheckAuthError) Error() string {
+	if e.Err == nil {
+		return fmt.Sprintf("checking credentials for %s: %s", e.Registry, e.Reason)
+	}
+	return fmt.Sprintf("checking credentials for %s: %s: %v", e.Registry, e.Reason, e.Err)
+}
+
+// Unwrap returns the underlying error.
+func (e *CheckAuthError) Unwrap() error {
+	return e.Err
+}
+
+// CheckAuth verifies that username and password are accepted by the registry referenced by key,
+// by pinging the registry and, if it requires it, obtaining a token, without storing the credentials anywhere.
+// A valid key is a repository, a namespace within a registry, or a registry hostname, as for SetCredentials;
+// only the registry part is used.
+// Returns nil if the credentials were accepted, or if the registry does not require authentication;
+// a *CheckAuthError if the credentials were rejected or could not be verified; other errors for invalid input.
+//
+// CheckAuth uses the registry client of the docker transport, so the calling program must (possibly indirectly) import
+// github.com/containers/image/v5/docker; otherwise this fails with ErrNotSupported.
+func CheckAuth(ctx context.Context, sys *types.SystemContext, key, username, password string) error {
+	if _, err := validateKey(key); err != nil {
+		return err
+	}
+	check := authcheck.Get()
+	if check == nil {
+		return errors.Wrap(ErrNotSupported, "checking credentials requires the docker transport")
+	}
+	registry := strings.SplitN(key, "/", 2)[0]
+	return check(ctx, sys, registry, username, password)
+}
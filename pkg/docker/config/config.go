@@ -1,16 +1,25 @@
 package config
 
 import (
+	"context"
 	"encoding/base64"
 	"encoding/json"
 	"fmt"
 	"os"
 	"os/exec"
+	"os/user"
+	"path"
 	"path/filepath"
 	"runtime"
+	"sort"
+	"strconv"
 	"strings"
+	"time"
 
 	"github.com/containers/image/v5/docker/reference"
+	"github.com/containers/image/v5/internal/secret"
+	"github.com/containers/image/v5/internal/userdirs"
+	"github.com/containers/image/v5/internal/warnings"
 	"github.com/containers/image/v5/pkg/sysregistriesv2"
 	"github.com/containers/image/v5/types"
 	"github.com/containers/storage/pkg/homedir"
@@ -25,11 +34,22 @@ import (
 type dockerAuthConfig struct {
 	Auth          string `json:"auth,omitempty"`
 	IdentityToken string `json:"identitytoken,omitempty"`
+	// The following fields describe how IdentityToken, an OAuth2 refresh token, is exchanged for access tokens; see RefreshToken.
+	TokenEndpoint string   `json:"tokenendpoint,omitempty"`
+	TokenService  string   `json:"tokenservice,omitempty"`
+	TokenScopes   []string `json:"tokenscopes,omitempty"`
+	// Paths to a PEM-encoded TLS client certificate and its private key; relative paths are relative to the auth file.
+	ClientCert string `json:"clientcert,omitempty"`
+	ClientKey  string `json:"clientkey,omitempty"`
 }
 
 type dockerConfigFile struct {
 	AuthConfigs map[string]dockerAuthConfig `json:"auths"`
 	CredHelpers map[string]string           `json:"credHelpers,omitempty"`
+	// PushAuthConfigs and PushCredHelpers, if they contain a matching entry, are used instead of AuthConfigs and CredHelpers
+	// of the same file for operations which write to a registry.
+	PushAuthConfigs map[string]dockerAuthConfig `json:"pushAuths,omitempty"`
+	PushCredHelpers map[string]string           `json:"pushCredHelpers,omitempty"`
 }
 
 type authPath struct {
@@ -39,6 +59,9 @@ type authPath struct {
 
 var (
 	defaultPerUIDPathFormat = filepath.FromSlash("/run/containers/%d/auth.json")
+	runUserDirFormat        = filepath.FromSlash("/run/user/%d")
+	freeBSDPerUIDPathFormat = filepath.FromSlash("/var/run/containers/%d/auth.json")
+	freeBSDRunUserDirFormat = filepath.FromSlash("/var/run/user/%d")
 	xdgConfigHomePath       = filepath.FromSlash("containers/auth.json")
 	xdgRuntimeDirPath       = filepath.FromSlash("containers/auth.json")
 	dockerHomePath          = filepath.FromSlash(".docker/config.json")
@@ -50,8 +73,59 @@ var (
 	ErrNotLoggedIn = errors.New("not logged in")
 	// ErrNotSupported is returned for unsupported methods
 	ErrNotSupported = errors.New("not supported")
+	// ErrNoWritableAuthFile is returned when storing credentials, if all files in
+	// SystemContext.AuthFilePaths are read-only
+	ErrNoWritableAuthFile = errors.New("no writable authentication file in SystemContext.AuthFilePaths")
 )
 
+// ErrPlaintextCredentialsForbidden is returned when storing credentials would write them
+// in plain text to an auth file, but require-credential-helper is enabled.
+type ErrPlaintextCredentialsForbidden struct {
+	Key  string // The key credentials were being stored for
+	Path string // The auth file which would have been written
+}
+
+func (e ErrPlaintextCredentialsForbidden) Error() string {
+	return fmt.Sprintf("refusing to store credentials for %s in plain text: a credential helper is required", e.Key)
+}
+
+// CredentialBackend identifies the kind of storage credentials were written to or read from.
+type CredentialBackend string
+
+const (
+	// CredentialBackendAuthFile means credentials were written into an auth file (containers-auth.json(5)).
+	CredentialBackendAuthFile CredentialBackend = "auth-file"
+	// CredentialBackendHelper means credentials were stored using an external credential helper.
+	CredentialBackendHelper CredentialBackend = "credential-helper"
+	// CredentialBackendSystemContext means credentials were provided by the application in types.SystemContext.
+	// It is never used for writes.
+	CredentialBackendSystemContext CredentialBackend = "system-context"
+)
+
+// CredentialSource describes where credentials were found.
+type CredentialSource struct {
+	Backend CredentialBackend `json:"backend"`
+	// Path is the auth file which contained the credentials, or a credHelpers entry routing the lookup to a helper;
+	// "" if an auth file was not involved.
+	Path string `json:"path,omitempty"`
+	// Helper is the name of the external credential helper (without the docker-credential- prefix) which
+	// returned the credentials; "" if not known or not applicable.
+	Helper string `json:"helper,omitempty"`
+}
+
+// CredentialWriteResult describes where StoreCredentials has stored credentials.
+type CredentialWriteResult struct {
+	Backend CredentialBackend
+	// Path is the auth file which was updated, or which contained the credHelpers entry
+	// that routed the credentials to Helper; "" if an auth file was not involved.
+	Path string
+	// Helper is the name of the credential helper (without the docker-credential- prefix) which stored the
+	// credentials; "" if Backend is CredentialBackendAuthFile.
+	Helper string
+	// Key is the key the credentials were stored for.
+	Key string
+}
+
 // SetCredentials stores the username and password in a location
 // appropriate for sys and the users’ configuration.
 // A valid key is a repository, a namespace within a registry, or a registry hostname;
@@ -59,35 +133,86 @@ var (
 // Returns a human-redable description of the location that was updated.
 // NOTE: The return value is only intended to be read by humans; its form is not an API,
 // it may change (or new forms can be added) any time.
+// Use StoreCredentials to get a structured description instead.
 func SetCredentials(sys *types.SystemContext, key, username, password string) (string, error) {
-	isNamespaced, err := validateKey(key)
+	res, err := StoreCredentials(sys, key, username, password)
 	if err != nil {
 		return "", err
 	}
+	if res.Path != "" {
+		return res.Path, nil
+	}
+	return fmt.Sprintf("credential helper: %s", res.Helper), nil
+}
+
+// StoreCredentials stores the username and password in a location
+// appropriate for sys and the users’ configuration, like SetCredentials,
+// and returns a description of where the credentials were stored.
+// If the credential-helper-failover mode stores credentials in several helpers,
+// the result describes the first one.
+func StoreCredentials(sys *types.SystemContext, key, username, password string) (CredentialWriteResult, error) {
+	creds := base64.StdEncoding.EncodeToString([]byte(username + ":" + password))
+	return storeCredentials(sys, key, username, password, dockerAuthConfig{Auth: creds})
+}
+
+// storeCredentials implements StoreCredentials and StoreRefreshToken:
+// it stores username and password in credential helpers, and authFileEntry in auth files.
+func storeCredentials(sys *types.SystemContext, key, username, password string, authFileEntry dockerAuthConfig) (CredentialWriteResult, error) {
+	isNamespaced, err := validateKey(key)
+	if err != nil {
+		return CredentialWriteResult{}, err
+	}
+
+	if helper, ok := credHelperOverrideForRegistry(sys, registryOfKey(key)); ok {
+		if isNamespaced {
+			return CredentialWriteResult{}, unsupportedNamespaceErr(helper)
+		}
+		if err := setAuthToCredHelper(sys, helper, key, username, password); err != nil {
+			return CredentialWriteResult{}, err
+		}
+		logrus.Debugf("Stored credentials for %s in credential helper %s (CredentialHelperOverrides)", key, helper)
+		return CredentialWriteResult{Backend: CredentialBackendHelper, Helper: helper, Key: key}, nil
+	}
 
 	helpers, err := sysregistriesv2.CredentialHelpers(sys)
 	if err != nil {
-		return "", err
+		return CredentialWriteResult{}, err
+	}
+	failoverMode, err := sysregistriesv2.GetCredentialHelperFailoverMode(sys)
+	if err != nil {
+		return CredentialWriteResult{}, err
+	}
+	helperRequired, err := sysregistriesv2.CredentialHelperRequired(sys)
+	if err != nil {
+		return CredentialWriteResult{}, err
 	}
 
 	// Make sure to collect all errors.
 	var multiErr error
+	// Refusing to store plain text credentials only skips the auth file; it is not a failure unless no helper stores the credentials.
+	var plaintextErr error
+	var firstResult *CredentialWriteResult
 	for _, helper := range helpers {
-		var desc string
+		var res CredentialWriteResult
 		var err error
 		switch helper {
 		// Special-case the built-in helpers for auth files.
 		case sysregistriesv2.AuthenticationFileHelper:
-			desc, err = modifyJSON(sys, func(auths *dockerConfigFile) (bool, error) {
+			res = CredentialWriteResult{Backend: CredentialBackendAuthFile, Key: key}
+			res.Path, err = modifyJSON(sys, func(auths *dockerConfigFile) (bool, error) {
 				if ch, exists := auths.CredHelpers[key]; exists {
 					if isNamespaced {
 						return false, unsupportedNamespaceErr(ch)
 					}
-					return false, setAuthToCredHelper(ch, key, username, password)
+					res.Backend = CredentialBackendHelper
+					res.Helper = ch
+					return false, setAuthToCredHelper(sys, ch, key, username, password)
+				}
+				if helperRequired {
+					path, _, _ := getPathToAuth(sys) // modifyJSON has already succeeded calling this.
+					return false, ErrPlaintextCredentialsForbidden{Key: key, Path: path}
 				}
-				creds := base64.StdEncoding.EncodeToString([]byte(username + ":" + password))
-				newCreds := dockerAuthConfig{Auth: creds}
-				auths.AuthConfigs[key] = newCreds
+				auths.AuthConfigs[key] = authFileEntry
 				return true, nil
 			})
 		// External helpers.
@@ -95,19 +220,45 @@ func SetCredentials(sys *types.SystemContext, key, username, password string) (s
 			if isNamespaced {
 				err = unsupportedNamespaceErr(helper)
 			} else {
-				desc = fmt.Sprintf("credential helper: %s", helper)
-				err = setAuthToCredHelper(helper, key, username, password)
+				res = CredentialWriteResult{Backend: CredentialBackendHelper, Helper: helper, Key: key}
+				err = setAuthToCredHelper(sys, helper, key, username, password)
 			}
 		}
+		var forbidden ErrPlaintextCredentialsForbidden
+		if errors.As(err, &forbidden) {
+			logrus.Debugf("Not storing credentials for %s in credential helper %s: %v", key, helper, err)
+			plaintextErr = err
+			continue
+		}
 		if err != nil {
-			multiErr = multierror.Append(multiErr, err)
 			logrus.Debugf("Error storing credentials for %s in credential helper %s: %v", key, helper, err)
+			if failoverMode == types.CredentialHelperFailoverFailFast {
+				return CredentialWriteResult{}, err
+			}
+			multiErr = multierror.Append(multiErr, err)
 			continue
 		}
 		logrus.Debugf("Stored credentials for %s in credential helper %s", key, helper)
-		return desc, nil
+		if failoverMode != types.CredentialHelperFailoverTryAll {
+			return res, nil
+		}
+		if firstResult == nil {
+			firstResult = &res
+		}
+	}
+	if firstResult == nil {
+		if plaintextErr != nil {
+			if multiErr == nil {
+				return CredentialWriteResult{}, plaintextErr
+			}
+			multiErr = multierror.Append(multiErr, plaintextErr)
+		}
+		return CredentialWriteResult{}, multiErr
+	}
+	if failoverMode == types.CredentialHelperFailoverTryAll && multiErr != nil {
+		return CredentialWriteResult{}, multiErr
 	}
-	return "", multiErr
+	return *firstResult, nil
 }
 
 func unsupportedNamespaceErr(helper string) error {
@@ -137,10 +288,49 @@ func GetAllCredentials(sys *types.SystemContext) (map[string]types.DockerAuthCon
 	// While we're at it, we’ll also canonicalize docker.io to the standard format.
 	normalizedDockerIORegistry := normalizeRegistry("docker.io")
 
+	if sys != nil {
+		for key := range sys.DockerPerRegistryAuthConfigs {
+			addKey(key)
+		}
+		for key := range sys.CredentialHelperOverrides {
+			addKey(key)
+		}
+	}
+
 	helpers, err := sysregistriesv2.CredentialHelpers(sys)
 	if err != nil {
 		return nil, err
 	}
+	failoverMode, err := sysregistriesv2.GetCredentialHelperFailoverMode(sys)
+	if err != nil {
+		return nil, err
+	}
+	// Credentials from helpers which support the batch protocol, so that
+	// we don't have to run the helper once for every registry.
+	batch := credHelperBatch{}
+	prefetch := func(helper string) (bool, error) {
+		if _, ok := batch[helper]; ok {
+			return true, nil
+		}
+		all, ok, err := getAllAuthsFromCredHelper(context.Background(), sys, helper)
+		if ok && err == nil {
+			batch[helper] = all
+		}
+		return ok, err
+	}
+	// Slow external helpers can take seconds to list their contents, so query all of them concurrently up front.
+	externalHelpers := []string{}
+	for _, helper := range helpers {
+		if helper != sysregistriesv2.AuthenticationFileHelper {
+			externalHelpers = append(externalHelpers, helper)
+		}
+	}
+	listings := listCredHelpers(sys, externalHelpers)
+	for helper, listing := range listings {
+		if listing.batch != nil {
+			batch[helper] = listing.batch
+		}
+	}
 	for _, helper := range helpers {
 		switch helper {
 		// Special-case the built-in helper for auth files.
@@ -154,8 +344,17 @@ func GetAllCredentials(sys *types.SystemContext) (map[string]types.DockerAuthCon
 				// Credential helpers in the auth file have a
 				// direct mapping to a registry, so we can just
 				// walk the map.
-				for registry := range auths.CredHelpers {
+				for registry, helper := range auths.CredHelpers {
+					// Wildcard keys don't name a specific registry,
+					// so there is nothing to ask the helper for.
+					if isWildcardKey(registry) {
+						continue
+					}
 					addKey(registry)
+					if _, err := prefetch(helper); err != nil {
+						// Not fatal, GetCredentials will try the helper again.
+						logrus.Debugf("Error prefetching credentials from credential helper %s: %v", helper, err)
+					}
 				}
 				for key := range auths.AuthConfigs {
 					key := normalizeAuthFileKey(key, path.legacyFormat)
@@ -167,18 +366,22 @@ func GetAllCredentials(sys *types.SystemContext) (map[string]types.DockerAuthCon
 			}
 		// External helpers.
 		default:
-			creds, err := listAuthsFromCredHelper(helper)
+			listing := listings[helper]
+			err := listing.err
 			if err != nil {
 				logrus.Debugf("Error listing credentials stored in credential helper %s: %v", helper, err)
 			}
 			switch errors.Cause(err) {
 			case nil:
-				for registry := range creds {
+				for _, registry := range listing.registries {
 					addKey(registry)
 				}
 			case exec.ErrNotFound:
 				// It's okay if the helper doesn't exist.
 			default:
+				if failoverMode == types.CredentialHelperFailoverIgnoreErrors {
+					continue
+				}
 				return nil, err
 			}
 		}
@@ -188,7 +391,7 @@ func GetAllCredentials(sys *types.SystemContext) (map[string]types.DockerAuthCon
 	// previously listed registry.
 	authConfigs := make(map[string]types.DockerAuthConfig)
 	for key := range allKeys {
-		authConf, err := GetCredentials(sys, key)
+		authConf, err := getCredentialsWithBatch(context.Background(), sys, key, homedir.Get(), batch)
 		if err != nil {
 			// Note: we rely on the logging in `GetCredentials`.
 			return nil, err
@@ -207,21 +410,44 @@ func GetAllCredentials(sys *types.SystemContext) (map[string]types.DockerAuthCon
 // by tests.
 func getAuthFilePaths(sys *types.SystemContext, homeDir string) []authPath {
 	paths := []authPath{}
-	pathToAuth, lf, err := getPathToAuth(sys)
-	if err == nil {
-		paths = append(paths, authPath{path: pathToAuth, legacyFormat: lf})
+	if sys != nil && len(sys.AuthFilePaths) != 0 {
+		for _, f := range sys.AuthFilePaths {
+			paths = append(paths, authPath{path: f.Path, legacyFormat: false})
+		}
 	} else {
-		// Error means that the path set for XDG_RUNTIME_DIR does not exist
-		// but we don't want to completely fail in the case that the user is pulling a public image
-		// Logging the error as a warning instead and moving on to pulling the image
-		logrus.Warnf("%v: Trying to pull image in the event that it is a public image.", err)
+		pathToAuth, lf, err := getPathToAuth(sys)
+		if err == nil {
+			paths = append(paths, authPath{path: pathToAuth, legacyFormat: lf})
+		} else {
+			// Error means that the path set for XDG_RUNTIME_DIR does not exist
+			// but we don't want to completely fail in the case that the user is pulling a public image
+			// Logging the error as a warning instead and moving on to pulling the image
+			logrus.Warnf("%v: Trying to pull image in the event that it is a public image.", err)
+		}
+	}
+	if sys != nil && sys.AuthFilePathResolver != nil {
+		return append(paths, authFileResolverFallbackPaths(sys, runtime.GOOS)...)
+	}
+	// The environment of the calling process is irrelevant for other users.
+	useEnvironment := true
+	if uid, ok := otherUserUID(sys); ok {
+		u, err := user.LookupId(strconv.Itoa(uid))
+		if err != nil {
+			logrus.Debugf("Not using auth files in the home directory of UID %d: %v", uid, err)
+			return paths
+		}
+		homeDir = u.HomeDir
+		useEnvironment = false
+	}
+	xdgCfgHome := ""
+	if useEnvironment {
+		xdgCfgHome = os.Getenv("XDG_CONFIG_HOME")
 	}
-	xdgCfgHome := os.Getenv("XDG_CONFIG_HOME")
 	if xdgCfgHome == "" {
 		xdgCfgHome = filepath.Join(homeDir, ".config")
 	}
 	paths = append(paths, authPath{path: filepath.Join(xdgCfgHome, xdgConfigHomePath), legacyFormat: false})
-	if dockerConfig := os.Getenv("DOCKER_CONFIG"); dockerConfig != "" {
+	if dockerConfig := os.Getenv("DOCKER_CONFIG"); useEnvironment && dockerConfig != "" {
 		paths = append(paths,
 			authPath{path: filepath.Join(dockerConfig, "config.json"), legacyFormat: false},
 		)
@@ -236,6 +462,20 @@ func getAuthFilePaths(sys *types.SystemContext, homeDir string) []authPath {
 	return paths
 }
 
+// authFileResolverFallbackPaths returns the fallback auth file paths returned by sys.AuthFilePathResolver for goOS.
+func authFileResolverFallbackPaths(sys *types.SystemContext, goOS string) []authPath {
+	locations, err := sys.AuthFilePathResolver(goOS)
+	if err != nil {
+		// getPathToAuth has already failed, and we have logged a warning, in this case.
+		return nil
+	}
+	paths := []authPath{}
+	for _, f := range locations.Fallbacks {
+		paths = append(paths, authPath{path: f.Path, legacyFormat: f.LegacyFormat})
+	}
+	return paths
+}
+
 // GetCredentials returns the registry credentials matching key, appropriate for
 // sys and the users’ configuration.
 // If an entry is not found, an empty struct is returned.
@@ -251,90 +491,204 @@ func GetCredentials(sys *types.SystemContext, key string) (types.DockerAuthConfi
 // appropriate for sys and the users’ configuration.
 // If an entry is not found, an empty struct is returned.
 func GetCredentialsForRef(sys *types.SystemContext, ref reference.Named) (types.DockerAuthConfig, error) {
-	return getCredentialsWithHomeDir(sys, ref.Name(), homedir.Get())
+	return GetCredentialsForRefWithContext(context.Background(), sys, ref)
+}
+
+// GetCredentialsForRefWithContext is GetCredentialsForRef, with ctx used as the parent of tracing spans
+// (if tracing is enabled, e.g. by copy.Options.TracerProvider).
+func GetCredentialsForRefWithContext(ctx context.Context, sys *types.SystemContext, ref reference.Named) (types.DockerAuthConfig, error) {
+	return getCredentialsWithBatch(ctx, sys, ref.Name(), homedir.Get(), nil)
+}
+
+// GetPushCredentialsForRef returns the registry credentials necessary for
+// writing to ref on the registry ref points to (e.g. pushing images or deleting them),
+// appropriate for sys and the users’ configuration.
+// Credentials configured specifically for writing (pushAuths or pushCredHelpers in an auth file)
+// are preferred over other credentials in the same auth file; otherwise, this returns the same value as GetCredentialsForRef.
+// If an entry is not found, an empty struct is returned.
+func GetPushCredentialsForRef(sys *types.SystemContext, ref reference.Named) (types.DockerAuthConfig, error) {
+	return GetPushCredentialsForRefWithContext(context.Background(), sys, ref)
+}
+
+// GetPushCredentialsForRefWithContext is GetPushCredentialsForRef, with ctx used as the parent of tracing spans
+// (if tracing is enabled, e.g. by copy.Options.TracerProvider).
+func GetPushCredentialsForRefWithContext(ctx context.Context, sys *types.SystemContext, ref reference.Named) (types.DockerAuthConfig, error) {
+	return getPushCredentialsWithHomeDir(ctx, sys, ref.Name(), homedir.Get())
+}
+
+// getPushCredentialsWithHomeDir is an internal implementation detail of
+// GetPushCredentialsForRef. It exists only to allow testing it
+// with an artificial home directory.
+func getPushCredentialsWithHomeDir(ctx context.Context, sys *types.SystemContext, key, homeDir string) (types.DockerAuthConfig, error) {
+	creds, _, err := getCredentialsAndSource(ctx, sys, key, homeDir, nil, true)
+	return creds.dockerAuthConfig(), err
 }
 
 // getCredentialsWithHomeDir is an internal implementation detail of
 // GetCredentialsForRef and GetCredentials. It exists only to allow testing it
 // with an artificial home directory.
 func getCredentialsWithHomeDir(sys *types.SystemContext, key, homeDir string) (types.DockerAuthConfig, error) {
+	return getCredentialsWithBatch(context.Background(), sys, key, homeDir, nil)
+}
+
+// getCredentialsWithBatch implements getCredentialsWithHomeDir, using credentials prefetched in batch
+// instead of invoking the relevant credential helpers, if available.
+func getCredentialsWithBatch(ctx context.Context, sys *types.SystemContext, key, homeDir string, batch credHelperBatch) (types.DockerAuthConfig, error) {
+	creds, _, err := getCredentialsAndSource(ctx, sys, key, homeDir, batch, false)
+	return creds.dockerAuthConfig(), err
+}
+
+// getCredentialsAndSource implements getCredentialsWithBatch and getPushCredentialsWithHomeDir (if push), and also returns
+// the source of the credentials.
+// The source is only meaningful if the returned credentials are not empty.
+func getCredentialsAndSource(ctx context.Context, sys *types.SystemContext, key, homeDir string, batch credHelperBatch, push bool) (authConfig, CredentialSource, error) {
 	_, err := validateKey(key)
 	if err != nil {
-		return types.DockerAuthConfig{}, err
+		return authConfig{}, CredentialSource{}, err
 	}
 
 	if sys != nil && sys.DockerAuthConfig != nil {
-		logrus.Debugf("Returning credentials for %s from DockerAuthConfig", key)
-		return *sys.DockerAuthConfig, nil
+		reportCredentialLookup(sys, types.CredentialLookupEvent{
+			Key:     key,
+			Source:  string(CredentialBackendSystemContext),
+			Found:   true,
+			Message: fmt.Sprintf("Returning credentials for %s from DockerAuthConfig", key),
+		})
+		return newAuthConfig(*sys.DockerAuthConfig), CredentialSource{Backend: CredentialBackendSystemContext}, nil
+	}
+	if perRegistryConfig, matchedKey, ok := findCredentialsInPerRegistryOverrides(sys, key); ok {
+		reportCredentialLookup(sys, types.CredentialLookupEvent{
+			Key:     key,
+			Source:  string(CredentialBackendSystemContext),
+			Found:   true,
+			Message: fmt.Sprintf("Returning credentials for %s from DockerPerRegistryAuthConfigs entry %s", key, matchedKey),
+		})
+		return newAuthConfig(perRegistryConfig), CredentialSource{Backend: CredentialBackendSystemContext}, nil
 	}
 
-	var registry string // We compute this once because it is used in several places.
-	if firstSlash := strings.IndexRune(key, '/'); firstSlash != -1 {
-		registry = key[:firstSlash]
-	} else {
-		registry = key
+	registry := registryOfKey(key) // We compute this once because it is used in several places.
+
+	if helper, ok := credHelperOverrideForRegistry(sys, registry); ok {
+		creds, err := getAuthFromCredHelperWithBatch(ctx, sys, batch, helper, registry)
+		if err != nil {
+			reportCredentialLookup(sys, types.CredentialLookupEvent{
+				Key:     registry,
+				Source:  string(CredentialBackendHelper),
+				Helper:  helper,
+				Err:     err,
+				Message: fmt.Sprintf("Error looking up credentials for %s in credential helper %s: %v", registry, helper, err),
+			})
+			return authConfig{}, CredentialSource{}, err
+		}
+		reportCredentialLookup(sys, types.CredentialLookupEvent{
+			Key:     registry,
+			Source:  string(CredentialBackendHelper),
+			Helper:  helper,
+			Found:   !creds.isEmpty(),
+			Message: fmt.Sprintf("Returning credentials for %s from credential helper %s (CredentialHelperOverrides)", registry, helper),
+		})
+		return creds, CredentialSource{Backend: CredentialBackendHelper, Helper: helper}, nil
 	}
 
 	// Anonymous function to query credentials from auth files.
-	getCredentialsFromAuthFiles := func() (types.DockerAuthConfig, string, error) {
+	getCredentialsFromAuthFiles := func() (authConfig, string, error) {
 		for _, path := range getAuthFilePaths(sys, homeDir) {
-			authConfig, err := findCredentialsInFile(key, registry, path.path, path.legacyFormat)
+			creds, err := findCredentialsInFile(ctx, sys, batch, key, registry, path.path, path.legacyFormat, push)
 			if err != nil {
-				return types.DockerAuthConfig{}, "", err
+				return authConfig{}, "", err
 			}
 
-			if authConfig != (types.DockerAuthConfig{}) {
-				return authConfig, path.path, nil
+			if !creds.isEmpty() {
+				return creds, path.path, nil
 			}
 		}
-		return types.DockerAuthConfig{}, "", nil
+		return authConfig{}, "", nil
 	}
 
 	helpers, err := sysregistriesv2.CredentialHelpers(sys)
 	if err != nil {
-		return types.DockerAuthConfig{}, err
+		return authConfig{}, CredentialSource{}, err
+	}
+	failoverMode, err := sysregistriesv2.GetCredentialHelperFailoverMode(sys)
+	if err != nil {
+		return authConfig{}, CredentialSource{}, err
 	}
 
 	var multiErr error
+	// With CredentialHelperFailoverTryAll, credentials from all helpers are merged.
+	var merged authConfig
+	var mergedSource CredentialSource
 	for _, helper := range helpers {
 		var (
-			creds          types.DockerAuthConfig
+			creds          authConfig
 			helperKey      string
 			credHelperPath string
 			err            error
 		)
+		event := types.CredentialLookupEvent{Helper: helper}
 		switch helper {
 		// Special-case the built-in helper for auth files.
 		case sysregistriesv2.AuthenticationFileHelper:
 			helperKey = key
+			event.Source = string(CredentialBackendAuthFile)
 			creds, credHelperPath, err = getCredentialsFromAuthFiles()
 		// External helpers.
 		default:
 			// This intentionally uses "registry", not "key"; we don't support namespaced
 			// credentials in helpers, but a "registry" is a valid parent of "key".
 			helperKey = registry
-			creds, err = getAuthFromCredHelper(helper, registry)
+			event.Source = string(CredentialBackendHelper)
+			creds, err = getAuthFromCredHelperWithBatch(ctx, sys, batch, helper, registry)
 		}
+		event.Key = helperKey
 		if err != nil {
-			logrus.Debugf("Error looking up credentials for %s in credential helper %s: %v", helperKey, helper, err)
-			multiErr = multierror.Append(multiErr, err)
+			event.Err = err
+			event.Message = fmt.Sprintf("Error looking up credentials for %s in credential helper %s: %v", helperKey, helper, err)
+			reportCredentialLookup(sys, event)
+			switch failoverMode {
+			case types.CredentialHelperFailoverFailFast:
+				return authConfig{}, CredentialSource{}, err
+			case types.CredentialHelperFailoverIgnoreErrors:
+				// Treat the error as missing credentials.
+			default:
+				multiErr = multierror.Append(multiErr, err)
+			}
 			continue
 		}
-		if creds != (types.DockerAuthConfig{}) {
-			msg := fmt.Sprintf("Found credentials for %s in credential helper %s", helperKey, helper)
+		if !creds.isEmpty() {
+			event.Found = true
+			event.Message = fmt.Sprintf("Found credentials for %s in credential helper %s", helperKey, helper)
+			source := CredentialSource{Backend: CredentialBackendHelper, Helper: helper}
 			if credHelperPath != "" {
-				msg = fmt.Sprintf("%s in file %s", msg, credHelperPath)
+				event.Path = credHelperPath
+				event.Message = fmt.Sprintf("%s in file %s", event.Message, credHelperPath)
+				source = CredentialSource{Backend: CredentialBackendAuthFile, Path: credHelperPath}
 			}
-			logrus.Debug(msg)
-			return creds, nil
+			reportCredentialLookup(sys, event)
+			if failoverMode != types.CredentialHelperFailoverTryAll {
+				return creds, source, nil
+			}
+			if merged.isEmpty() {
+				mergedSource = source
+			}
+			merged = merged.mergedWith(creds)
+			continue
 		}
+		event.Message = fmt.Sprintf("No credentials for %s found in credential helper %s", helperKey, helper)
+		reportCredentialLookup(sys, event)
+	}
+	if !merged.isEmpty() {
+		return merged, mergedSource, nil
 	}
 	if multiErr != nil {
-		return types.DockerAuthConfig{}, multiErr
+		return authConfig{}, CredentialSource{}, multiErr
 	}
 
-	logrus.Debugf("No credentials for %s found", key)
-	return types.DockerAuthConfig{}, nil
+	reportCredentialLookup(sys, types.CredentialLookupEvent{
+		Key:     key,
+		Message: fmt.Sprintf("No credentials for %s found", key),
+	})
+	return authConfig{}, CredentialSource{}, nil
 }
 
 // GetAuthentication returns the registry credentials matching key, appropriate for
@@ -363,41 +717,116 @@ func getAuthenticationWithHomeDir(sys *types.SystemContext, key, homeDir string)
 	return auth.Username, auth.Password, nil
 }
 
+// RemoveOptions are options for RemoveAuthenticationWithOptions and RemoveAllAuthenticationWithOptions.
+type RemoveOptions struct {
+	// If true, nothing is modified; the returned RemovalReport lists the entries which would be removed.
+	DryRun bool
+}
+
+// RemovedCredential describes a single credential entry removed by RemoveAuthenticationWithOptions
+// or RemoveAllAuthenticationWithOptions, or one which would be removed in dry-run mode.
+type RemovedCredential struct {
+	Backend CredentialBackend `json:"backend"`
+	// Path is the auth file which contains the entry, or the credHelpers entry which routes Key to Helper;
+	// "" if an auth file was not involved.
+	Path string `json:"path,omitempty"`
+	// Helper is the name of the credential helper (without the docker-credential- prefix) storing the credentials;
+	// "" if Backend is CredentialBackendAuthFile.
+	Helper string `json:"helper,omitempty"`
+	// Key is the auth file key, or the registry (server URL) as recorded by the credential helper.
+	Key string `json:"key"`
+}
+
+// RemovalReport is the result of RemoveAuthenticationWithOptions and RemoveAllAuthenticationWithOptions.
+type RemovalReport struct {
+	DryRun  bool                `json:"dryRun"`
+	Removed []RemovedCredential `json:"removed"`
+}
+
 // RemoveAuthentication removes credentials for `key` from all possible
 // sources such as credential helpers and auth files.
 // A valid key is a repository, a namespace within a registry, or a registry hostname;
 // using forms other than just a registry may fail depending on configuration.
 func RemoveAuthentication(sys *types.SystemContext, key string) error {
+	_, err := RemoveAuthenticationWithOptions(sys, key, nil)
+	return err
+}
+
+// RemoveAuthenticationWithOptions is RemoveAuthentication, and returns a description of the removed entries.
+// If options.DryRun, nothing is modified, and the report describes the entries which would be removed;
+// errors (including ErrNotLoggedIn) are reported as RemoveAuthentication would.
+// options may be nil.
+func RemoveAuthenticationWithOptions(sys *types.SystemContext, key string, options *RemoveOptions) (RemovalReport, error) {
+	dryRun := options != nil && options.DryRun
+	report := RemovalReport{DryRun: dryRun, Removed: []RemovedCredential{}}
 	isNamespaced, err := validateKey(key)
 	if err != nil {
-		return err
+		return RemovalReport{}, err
+	}
+
+	// removeFromCredHelper removes the credentials for key from helper (or, if dryRun, only checks they exist).
+	// It returns (false, nil) if there are no credentials for key in the helper.
+	removeFromCredHelper := func(helper string) (bool, error) {
+		if dryRun {
+			creds, err := getAuthFromCredHelper(context.Background(), sys, helper, key)
+			if err != nil {
+				return false, errors.Wrapf(err, "looking up credentials for %s in credential helper %s", key, helper)
+			}
+			return !creds.isEmpty(), nil
+		}
+		err := deleteAuthFromCredHelper(sys, helper, key)
+		if err != nil && credentials.IsErrCredentialsNotFoundMessage(err.Error()) {
+			logrus.Debugf("Not logged in to %s with credential helper %s", key, helper)
+			return false, nil
+		}
+		if err != nil {
+			return false, errors.Wrapf(err, "removing credentials for %s from credential helper %s", key, helper)
+		}
+		logrus.Debugf("Credentials for %q were deleted from credential helper %s", key, helper)
+		return true, nil
+	}
+
+	if helper, ok := credHelperOverrideForRegistry(sys, registryOfKey(key)); ok {
+		if isNamespaced {
+			return RemovalReport{}, unsupportedNamespaceErr(helper)
+		}
+		removed, err := removeFromCredHelper(helper)
+		if err != nil {
+			return RemovalReport{}, err
+		}
+		if !removed {
+			return RemovalReport{}, ErrNotLoggedIn
+		}
+		report.Removed = append(report.Removed, RemovedCredential{Backend: CredentialBackendHelper, Helper: helper, Key: key})
+		return report, nil
 	}
 
 	helpers, err := sysregistriesv2.CredentialHelpers(sys)
 	if err != nil {
-		return err
+		return RemovalReport{}, err
+	}
+	failoverMode, err := sysregistriesv2.GetCredentialHelperFailoverMode(sys)
+	if err != nil {
+		return RemovalReport{}, err
 	}
 
 	var multiErr error
 	isLoggedIn := false
 
-	removeFromCredHelper := func(helper string) {
+	tryRemovingFromCredHelper := func(helper, path string) {
 		if isNamespaced {
 			logrus.Debugf("Not removing credentials because namespaced keys are not supported for the credential helper: %s", helper)
 			return
-		} else {
-			err := deleteAuthFromCredHelper(helper, key)
-			if err == nil {
-				logrus.Debugf("Credentials for %q were deleted from credential helper %s", key, helper)
-				isLoggedIn = true
-				return
-			}
-			if credentials.IsErrCredentialsNotFoundMessage(err.Error()) {
-				logrus.Debugf("Not logged in to %s with credential helper %s", key, helper)
-				return
-			}
 		}
-		multiErr = multierror.Append(multiErr, errors.Wrapf(err, "removing credentials for %s from credential helper %s", key, helper))
+		removed, err := removeFromCredHelper(helper)
+		if err != nil {
+			multiErr = multierror.Append(multiErr, err)
+			return
+		}
+		if removed {
+			isLoggedIn = true
+			report.Removed = append(report.Removed, RemovedCredential{Backend: CredentialBackendHelper, Path: path, Helper: helper, Key: key})
+		}
 	}
 
 	for _, helper := range helpers {
@@ -405,12 +834,13 @@ func RemoveAuthentication(sys *types.SystemContext, key string) error {
 		switch helper {
 		// Special-case the built-in helper for auth files.
 		case sysregistriesv2.AuthenticationFileHelper:
-			_, err = modifyJSON(sys, func(auths *dockerConfigFile) (bool, error) {
+			_, err = modifyJSONUnlessDryRun(sys, dryRun, func(path string, auths *dockerConfigFile) (bool, error) {
 				if innerHelper, exists := auths.CredHelpers[key]; exists {
-					removeFromCredHelper(innerHelper)
+					tryRemovingFromCredHelper(innerHelper, path)
 				}
 				if _, ok := auths.AuthConfigs[key]; ok {
 					isLoggedIn = true
+					report.Removed = append(report.Removed, RemovedCredential{Backend: CredentialBackendAuthFile, Path: path, Key: key})
 					delete(auths.AuthConfigs, key)
 				}
 				return true, multiErr
@@ -420,26 +850,39 @@ func RemoveAuthentication(sys *types.SystemContext, key string) error {
 			}
 		// External helpers.
 		default:
-			removeFromCredHelper(helper)
+			tryRemovingFromCredHelper(helper, "")
+		}
+		if multiErr != nil && failoverMode == types.CredentialHelperFailoverFailFast {
+			return RemovalReport{}, multiErr
 		}
 	}
 
 	if multiErr != nil {
-		return multiErr
+		return RemovalReport{}, multiErr
 	}
 	if !isLoggedIn {
-		return ErrNotLoggedIn
+		return RemovalReport{}, ErrNotLoggedIn
 	}
 
-	return nil
+	return report, nil
 }
 
 // RemoveAllAuthentication deletes all the credentials stored in credential
 // helpers and auth files.
 func RemoveAllAuthentication(sys *types.SystemContext) error {
+	_, err := RemoveAllAuthenticationWithOptions(sys, nil)
+	return err
+}
+
+// RemoveAllAuthenticationWithOptions is RemoveAllAuthentication, and returns a description of the removed entries.
+// If options.DryRun, nothing is modified, and the report describes the entries which would be removed.
+// options may be nil.
+func RemoveAllAuthenticationWithOptions(sys *types.SystemContext, options *RemoveOptions) (RemovalReport, error) {
+	dryRun := options != nil && options.DryRun
+	report := RemovalReport{DryRun: dryRun, Removed: []RemovedCredential{}}
 	helpers, err := sysregistriesv2.CredentialHelpers(sys)
 	if err != nil {
-		return err
+		return RemovalReport{}, err
 	}
 
 	var multiErr error
@@ -448,14 +891,25 @@ func RemoveAllAuthentication(sys *types.SystemContext) error {
 		switch helper {
 		// Special-case the built-in helper for auth files.
 		case sysregistriesv2.AuthenticationFileHelper:
-			_, err = modifyJSON(sys, func(auths *dockerConfigFile) (bool, error) {
-				for registry, helper := range auths.CredHelpers {
+			_, err = modifyJSONUnlessDryRun(sys, dryRun, func(path string, auths *dockerConfigFile) (bool, error) {
+				registries := mapKeys(auths.CredHelpers)
+				sort.Strings(registries)
+				for _, registry := range registries {
+					helper := auths.CredHelpers[registry]
 					// Helpers in auth files are expected
 					// to exist, so no special treatment
 					// for them.
-					if err := deleteAuthFromCredHelper(helper, registry); err != nil {
-						return false, err
+					if !dryRun {
+						if err := deleteAuthFromCredHelper(sys, helper, registry); err != nil {
+							return false, err
+						}
 					}
+					report.Removed = append(report.Removed, RemovedCredential{Backend: CredentialBackendHelper, Path: path, Helper: helper, Key: registry})
+				}
+				keys := authConfigKeys(auths.AuthConfigs)
+				sort.Strings(keys)
+				for _, key := range keys {
+					report.Removed = append(report.Removed, RemovedCredential{Backend: CredentialBackendAuthFile, Path: path, Key: key})
 				}
 				auths.CredHelpers = make(map[string]string)
 				auths.AuthConfigs = make(map[string]dockerAuthConfig)
@@ -463,21 +917,33 @@ func RemoveAllAuthentication(sys *types.SystemContext) error {
 			})
 		// External helpers.
 		default:
-			var creds map[string]string
-			creds, err = listAuthsFromCredHelper(helper)
-			switch errors.Cause(err) {
-			case nil:
-				for registry := range creds {
-					err = deleteAuthFromCredHelper(helper, registry)
-					if err != nil {
-						break
+			var registries []string
+			// List the keys even if the helper can erase everything at once, so that the report is the same as in dry-run mode.
+			registries, err = listCredHelperKeys(sys, helper)
+			if err == nil && !dryRun {
+				var erased bool
+				erased, err = eraseAllAuthsFromCredHelper(context.Background(), sys, helper)
+				switch {
+				case erased && err != nil:
+					registries = nil
+				case !erased:
+					deleted := []string{}
+					for _, registry := range registries {
+						err = deleteAuthFromCredHelper(sys, helper, registry)
+						if err != nil {
+							break
+						}
+						deleted = append(deleted, registry)
 					}
+					registries = deleted
 				}
-			case exec.ErrNotFound:
+			}
+			if errors.Cause(err) == exec.ErrNotFound {
 				// It's okay if the helper doesn't exist.
 				continue
-			default:
-				// fall through
+			}
+			for _, registry := range registries {
+				report.Removed = append(report.Removed, RemovedCredential{Backend: CredentialBackendHelper, Helper: helper, Key: registry})
 			}
 		}
 		if err != nil {
@@ -488,13 +954,38 @@ func RemoveAllAuthentication(sys *types.SystemContext) error {
 		logrus.Debugf("All credentials removed from credential helper %s", helper)
 	}
 
-	return multiErr
+	return report, multiErr
+}
+
+// listCredHelperKeys returns the keys (server URLs) of all credentials stored in credHelper, sorted.
+func listCredHelperKeys(sys *types.SystemContext, credHelper string) ([]string, error) {
+	all, batched, err := getAllAuthsFromCredHelper(context.Background(), sys, credHelper)
+	if err != nil {
+		return nil, err
+	}
+	if batched {
+		res := make([]string, 0, len(all))
+		for serverURL := range all {
+			res = append(res, serverURL)
+		}
+		sort.Strings(res)
+		return res, nil
+	}
+	creds, err := listAuthsFromCredHelper(context.Background(), sys, credHelper)
+	if err != nil {
+		return nil, err
+	}
+	res := mapKeys(creds)
+	sort.Strings(res)
+	return res, nil
 }
 
-func listAuthsFromCredHelper(credHelper string) (map[string]string, error) {
-	helperName := fmt.Sprintf("docker-credential-%s", credHelper)
-	p := helperclient.NewShellProgramFunc(helperName)
-	return helperclient.List(p)
+func listAuthsFromCredHelper(ctx context.Context, sys *types.SystemContext, credHelper string) (map[string]string, error) {
+	p := credHelperProgramFuncWithContext(ctx, sys, credHelper)
+	start := time.Now()
+	res, err := helperclient.List(p)
+	observeCredHelperCall(ctx, sys, credHelper, credHelperActionList, start, err)
+	return res, err
 }
 
 // getPathToAuth gets the path of the auth.json file used for reading and writing credentials
@@ -506,22 +997,78 @@ func getPathToAuth(sys *types.SystemContext) (string, bool, error) {
 // getPathToAuthWithOS is an internal implementation detail of getPathToAuth,
 // it exists only to allow testing it with an artificial runtime.GOOS.
 func getPathToAuthWithOS(sys *types.SystemContext, goOS string) (string, bool, error) {
+	path, legacyFormat, _, err := resolvePathToAuthWithOS(sys, goOS)
+	return path, legacyFormat, err
+}
+
+// GetAuthFileLocation returns the location of the auth.json file used for reading and writing credentials
+// (unless sys.AuthFilePaths, or a credential helper, is used), and whether it is in the sys.RuntimeDirFallback directory.
+func GetAuthFileLocation(sys *types.SystemContext) (types.ResolvedLocation, error) {
+	path, _, fallbackReason, err := resolvePathToAuthWithOS(sys, runtime.GOOS)
+	if err != nil {
+		return types.ResolvedLocation{}, err
+	}
+	return types.ResolvedLocation{Path: path, Fallback: fallbackReason != "", FallbackReason: fallbackReason}, nil
+}
+
+// resolvePathToAuthWithOS implements getPathToAuthWithOS and GetAuthFileLocation.
+// In addition to the path and legacy format flag, it returns a description of why sys.RuntimeDirFallback
+// was used instead of $XDG_RUNTIME_DIR, or "" if it was not used.
+func resolvePathToAuthWithOS(sys *types.SystemContext, goOS string) (string, bool, string, error) {
 	if sys != nil {
+		if len(sys.AuthFilePaths) != 0 {
+			for _, f := range sys.AuthFilePaths {
+				if !f.ReadOnly {
+					return f.Path, false, "", nil
+				}
+			}
+			return "", false, "", ErrNoWritableAuthFile
+		}
 		if sys.AuthFilePath != "" {
-			return sys.AuthFilePath, false, nil
+			return sys.AuthFilePath, false, "", nil
 		}
 		if sys.LegacyFormatAuthFilePath != "" {
-			return sys.LegacyFormatAuthFilePath, true, nil
+			return sys.LegacyFormatAuthFilePath, true, "", nil
+		}
+		if sys.AuthFilePathResolver != nil {
+			locations, err := sys.AuthFilePathResolver(goOS)
+			if err != nil {
+				return "", false, "", errors.Wrap(err, "resolving the default auth file path")
+			}
+			if locations.Default == "" {
+				return "", false, "", ErrNoWritableAuthFile
+			}
+			return locations.Default, false, "", nil
 		}
 		if sys.RootForImplicitAbsolutePaths != "" {
-			return filepath.Join(sys.RootForImplicitAbsolutePaths, fmt.Sprintf(defaultPerUIDPathFormat, os.Getuid())), false, nil
+			return filepath.Join(sys.RootForImplicitAbsolutePaths, fmt.Sprintf(perUIDPathFormat(goOS), authFileUID(sys))), false, "", nil
 		}
 	}
 	if goOS == "windows" || goOS == "darwin" {
-		return filepath.Join(homedir.Get(), nonLinuxAuthFilePath), false, nil
+		return filepath.Join(homedir.Get(), nonLinuxAuthFilePath), false, "", nil
 	}
 
-	runtimeDir := os.Getenv("XDG_RUNTIME_DIR")
+	if sys != nil && sys.AuthFileRuntimeDir != "" {
+		return filepath.Join(sys.AuthFileRuntimeDir, xdgRuntimeDirPath), false, "", nil
+	}
+	if uid, ok := otherUserUID(sys); ok {
+		// $XDG_RUNTIME_DIR belongs to the calling process; use the conventional runtime directory of the other user, if it exists.
+		runtimeDir := fmt.Sprintf(runUserDirFormatForOS(goOS), uid)
+		if _, err := os.Stat(runtimeDir); err == nil {
+			return filepath.Join(runtimeDir, xdgRuntimeDirPath), false, "", nil
+		}
+		return fmt.Sprintf(perUIDPathFormat(goOS), uid), false, "", nil
+	}
+
+	fallback := ""
+	if sys != nil {
+		fallback = sys.RuntimeDirFallback
+	}
+	runtimeDir, fallbackReason := userdirs.RuntimeDir(fallback)
+	if fallbackReason != "" {
+		logrus.Debugf("Using %q instead of $XDG_RUNTIME_DIR for the auth file: %s", runtimeDir, fallbackReason)
+		return filepath.Join(runtimeDir, xdgRuntimeDirPath), false, fallbackReason, nil
+	}
 	if runtimeDir != "" {
 		// This function does not in general need to separately check that the returned path exists; that’s racy, and callers will fail accessing the file anyway.
 		// We are checking for os.IsNotExist here only to give the user better guidance what to do in this special case.
@@ -530,16 +1077,49 @@ func getPathToAuthWithOS(sys *types.SystemContext, goOS string) (string, bool, e
 			// This means the user set the XDG_RUNTIME_DIR variable and either forgot to create the directory
 			// or made a typo while setting the environment variable,
 			// so return an error referring to $XDG_RUNTIME_DIR instead of xdgRuntimeDirPath inside.
-			return "", false, errors.Wrapf(err, "%q directory set by $XDG_RUNTIME_DIR does not exist. Either create the directory or unset $XDG_RUNTIME_DIR.", runtimeDir)
+			return "", false, "", errors.Wrapf(err, "%q directory set by $XDG_RUNTIME_DIR does not exist. Either create the directory, unset $XDG_RUNTIME_DIR, or configure a fallback runtime directory.", runtimeDir)
 		} // else ignore err and let the caller fail accessing xdgRuntimeDirPath.
-		return filepath.Join(runtimeDir, xdgRuntimeDirPath), false, nil
+		return filepath.Join(runtimeDir, xdgRuntimeDirPath), false, "", nil
+	}
+	return fmt.Sprintf(perUIDPathFormat(goOS), os.Getuid()), false, "", nil
+}
+
+// perUIDPathFormat returns the format of the default per-UID auth file path on goOS, for use with fmt.Sprintf and a UID.
+func perUIDPathFormat(goOS string) string {
+	if goOS == "freebsd" {
+		return freeBSDPerUIDPathFormat
+	}
+	return defaultPerUIDPathFormat
+}
+
+// runUserDirFormatForOS returns the format of the conventional per-UID runtime directory on goOS, for use with fmt.Sprintf and a UID.
+func runUserDirFormatForOS(goOS string) string {
+	if goOS == "freebsd" {
+		return freeBSDRunUserDirFormat
+	}
+	return runUserDirFormat
+}
+
+// authFileUID returns the UID for which default auth file paths should be computed.
+func authFileUID(sys *types.SystemContext) int {
+	if sys != nil && sys.AuthFileUID != nil {
+		return *sys.AuthFileUID
+	}
+	return os.Getuid()
+}
+
+// otherUserUID returns sys.AuthFileUID, and true, if it is set and differs from the UID of the calling process.
+func otherUserUID(sys *types.SystemContext) (int, bool) {
+	if sys == nil || sys.AuthFileUID == nil || *sys.AuthFileUID == os.Getuid() {
+		return 0, false
 	}
-	return fmt.Sprintf(defaultPerUIDPathFormat, os.Getuid()), false, nil
+	return *sys.AuthFileUID, true
 }
 
 // readJSONFile unmarshals the authentications stored in the auth.json file and returns it
 // or returns an empty dockerConfigFile data structure if auth.json does not exist
 // if the file exists and is empty, readJSONFile returns an error
+// The file may use any format supported by authFileFormatForPath, depending on its extension.
 func readJSONFile(path string, legacyFormat bool) (dockerConfigFile, error) {
 	var auths dockerConfigFile
 
@@ -552,15 +1132,21 @@ func readJSONFile(path string, legacyFormat bool) (dockerConfigFile, error) {
 		return dockerConfigFile{}, err
 	}
 
+	format := authFileFormatForPath(path)
+	raw, err = format.toJSON(raw)
+	if err != nil {
+		return dockerConfigFile{}, errors.Wrapf(err, "unmarshaling %s at %q", format.name, path)
+	}
+
 	if legacyFormat {
 		if err = json.Unmarshal(raw, &auths.AuthConfigs); err != nil {
-			return dockerConfigFile{}, errors.Wrapf(err, "unmarshaling JSON at %q", path)
+			return dockerConfigFile{}, errors.Wrapf(err, "unmarshaling %s at %q", format.name, path)
 		}
 		return auths, nil
 	}
 
 	if err = json.Unmarshal(raw, &auths); err != nil {
-		return dockerConfigFile{}, errors.Wrapf(err, "unmarshaling JSON at %q", path)
+		return dockerConfigFile{}, errors.Wrapf(err, "unmarshaling %s at %q", format.name, path)
 	}
 
 	if auths.AuthConfigs == nil {
@@ -577,6 +1163,14 @@ func readJSONFile(path string, legacyFormat bool) (dockerConfigFile, error) {
 // writes it back if editor returns true.
 // Returns a human-redable description of the file, to be returned by SetCredentials.
 func modifyJSON(sys *types.SystemContext, editor func(auths *dockerConfigFile) (bool, error)) (string, error) {
+	return modifyJSONUnlessDryRun(sys, false, func(_ string, auths *dockerConfigFile) (bool, error) {
+		return editor(auths)
+	})
+}
+
+// modifyJSONUnlessDryRun is modifyJSON, except that it passes the path of the file to editor,
+// and if dryRun, it never creates or writes the file, regardless of what editor returns.
+func modifyJSONUnlessDryRun(sys *types.SystemContext, dryRun bool, editor func(path string, auths *dockerConfigFile) (bool, error)) (string, error) {
 	path, legacyFormat, err := getPathToAuth(sys)
 	if err != nil {
 		return "", err
@@ -585,9 +1179,11 @@ func modifyJSON(sys *types.SystemContext, editor func(auths *dockerConfigFile) (
 		return "", fmt.Errorf("writes to %s using legacy format are not supported", path)
 	}
 
-	dir := filepath.Dir(path)
-	if err = os.MkdirAll(dir, 0700); err != nil {
-		return "", err
+	if !dryRun {
+		dir := filepath.Dir(path)
+		if err = os.MkdirAll(dir, 0700); err != nil {
+			return "", err
+		}
 	}
 
 	auths, err := readJSONFile(path, false)
@@ -595,14 +1191,18 @@ func modifyJSON(sys *types.SystemContext, editor func(auths *dockerConfigFile) (
 		return "", errors.Wrapf(err, "reading JSON file %q", path)
 	}
 
-	updated, err := editor(&auths)
+	updated, err := editor(path, &auths)
 	if err != nil {
 		return "", errors.Wrapf(err, "updating %q", path)
 	}
-	if updated {
+	if updated && !dryRun {
+		format := authFileFormatForPath(path)
 		newData, err := json.MarshalIndent(auths, "", "\t")
+		if err == nil {
+			newData, err = format.fromJSON(newData)
+		}
 		if err != nil {
-			return "", errors.Wrapf(err, "marshaling JSON %q", path)
+			return "", errors.Wrapf(err, "marshaling %s %q", format.name, path)
 		}
 
 		if err = ioutils.AtomicWriteFile(path, newData, 0600); err != nil {
@@ -613,62 +1213,119 @@ func modifyJSON(sys *types.SystemContext, editor func(auths *dockerConfigFile) (
 	return path, nil
 }
 
-func getAuthFromCredHelper(credHelper, registry string) (types.DockerAuthConfig, error) {
-	helperName := fmt.Sprintf("docker-credential-%s", credHelper)
-	p := helperclient.NewShellProgramFunc(helperName)
+func getAuthFromCredHelper(ctx context.Context, sys *types.SystemContext, credHelper, registry string) (authConfig, error) {
+	p := credHelperProgramFunc(sys, credHelper)
+	start := time.Now()
 	creds, err := helperclient.Get(p, registry)
 	if err != nil {
 		if credentials.IsErrCredentialsNotFoundMessage(err.Error()) {
 			logrus.Debugf("Not logged in to %s with credential helper %s", registry, credHelper)
 			err = nil
 		}
-		return types.DockerAuthConfig{}, err
+		observeCredHelperCall(ctx, sys, credHelper, credHelperActionGet, start, err)
+		return authConfig{}, err
 	}
+	observeCredHelperCall(ctx, sys, credHelper, credHelperActionGet, start, nil)
 
-	switch creds.Username {
+	return credHelperCredentialsToAuthConfig(creds.Username, creds.Secret), nil
+}
+
+// getAuthFromCredHelperWithBatch is getAuthFromCredHelper, using data prefetched in batch if available.
+func getAuthFromCredHelperWithBatch(ctx context.Context, sys *types.SystemContext, batch credHelperBatch, credHelper, registry string) (authConfig, error) {
+	if batch != nil {
+		creds, ok := batch.lookup(credHelper, registry)
+		observeCredHelperCacheLookup(sys, credHelper, ok)
+		if ok {
+			return creds, nil
+		}
+	}
+	return getAuthFromCredHelper(ctx, sys, credHelper, registry)
+}
+
+// credHelperCredentialsToAuthConfig converts username and secret returned by a credential helper
+// into an authConfig.
+func credHelperCredentialsToAuthConfig(username, value string) authConfig {
+	switch username {
 	case "<token>":
-		return types.DockerAuthConfig{
-			IdentityToken: creds.Secret,
-		}, nil
+		return authConfig{
+			identityToken: secret.New(value),
+		}
 	default:
-		return types.DockerAuthConfig{
-			Username: creds.Username,
-			Password: creds.Secret,
-		}, nil
+		return authConfig{
+			username: username,
+			password: secret.New(value),
+		}
 	}
 }
 
-func setAuthToCredHelper(credHelper, registry, username, password string) error {
-	helperName := fmt.Sprintf("docker-credential-%s", credHelper)
-	p := helperclient.NewShellProgramFunc(helperName)
+func setAuthToCredHelper(sys *types.SystemContext, credHelper, registry, username, password string) error {
+	p := credHelperProgramFunc(sys, credHelper)
 	creds := &credentials.Credentials{
 		ServerURL: registry,
 		Username:  username,
 		Secret:    password,
 	}
-	return helperclient.Store(p, creds)
+	start := time.Now()
+	err := helperclient.Store(p, creds)
+	observeCredHelperCall(context.Background(), sys, credHelper, credHelperActionStore, start, err)
+	return err
 }
 
-func deleteAuthFromCredHelper(credHelper, registry string) error {
-	helperName := fmt.Sprintf("docker-credential-%s", credHelper)
-	p := helperclient.NewShellProgramFunc(helperName)
-	return helperclient.Erase(p, registry)
+func deleteAuthFromCredHelper(sys *types.SystemContext, credHelper, registry string) error {
+	p := credHelperProgramFunc(sys, credHelper)
+	start := time.Now()
+	err := helperclient.Erase(p, registry)
+	observeCredHelperCall(context.Background(), sys, credHelper, credHelperActionErase, start, err)
+	return err
 }
 
 // findCredentialsInFile looks for credentials matching "key"
 // (which is "registry" or a namespace in "registry") in "path".
-func findCredentialsInFile(key, registry, path string, legacyFormat bool) (types.DockerAuthConfig, error) {
+// If push, push-specific credentials in "path" are preferred.
+func findCredentialsInFile(ctx context.Context, sys *types.SystemContext, batch credHelperBatch, key, registry, path string, legacyFormat, push bool) (authConfig, error) {
 	auths, err := readJSONFile(path, legacyFormat)
 	if err != nil {
-		return types.DockerAuthConfig{}, errors.Wrapf(err, "reading JSON file %q", path)
+		return authConfig{}, errors.Wrapf(err, "reading JSON file %q", path)
+	}
+	if push && !legacyFormat {
+		creds, err := findCredentialsInMaps(ctx, sys, batch, key, registry, path, legacyFormat, auths.PushAuthConfigs, auths.PushCredHelpers)
+		if err != nil {
+			return authConfig{}, err
+		}
+		if !creds.isEmpty() {
+			logrus.Debugf("Using push credentials for %s from %s", key, path)
+			return creds, nil
+		}
+	}
+	creds, err := findCredentialsInMaps(ctx, sys, batch, key, registry, path, legacyFormat, auths.AuthConfigs, auths.CredHelpers)
+	if err != nil {
+		return authConfig{}, err
+	}
+	if creds.isEmpty() {
+		// Only log this if we found nothing; getCredentialsWithHomeDir logs the
+		// source of found data.
+		logrus.Debugf("No credentials matching %s found in %s", key, path)
+	} else if legacyFormat {
+		warnings.Report(sys, types.Warning{
+			Kind:    types.WarningDeprecation,
+			Code:    types.WarningCodeLegacyAuthFile,
+			Subject: path,
+			Message: fmt.Sprintf("Using credentials for %s from legacy-format auth file %s", key, path),
+		})
 	}
+	return creds, nil
+}
 
+// findCredentialsInMaps looks for credentials matching "key"
+// (which is "registry" or a namespace in "registry") in authConfigs and credHelpers, read from "path".
+func findCredentialsInMaps(ctx context.Context, sys *types.SystemContext, batch credHelperBatch, key, registry, path string, legacyFormat bool,
+	authConfigs map[string]dockerAuthConfig, credHelpers map[string]string) (authConfig, error) {
 	// First try cred helpers. They should always be normalized.
 	// This intentionally uses "registry", not "key"; we don't support namespaced
 	// credentials in helpers.
-	if ch, exists := auths.CredHelpers[registry]; exists {
+	if ch, exists := credHelpers[registry]; exists {
 		logrus.Debugf("Looking up in credential helper %s based on credHelpers entry in %s", ch, path)
-		return getAuthFromCredHelper(ch, registry)
+		return getAuthFromCredHelperWithBatch(ctx, sys, batch, ch, registry)
 	}
 
 	// Support sub-registry namespaces in auth.
@@ -684,8 +1341,8 @@ func findCredentialsInFile(key, registry, path string, legacyFormat bool) (types
 	// Repo or namespace keys are only supported as exact matches. For registry
 	// keys we prefer exact matches as well.
 	for _, key := range keys {
-		if val, exists := auths.AuthConfigs[key]; exists {
-			return decodeDockerAuth(val)
+		if val, exists := authConfigs[key]; exists {
+			return decodeDockerAuthInFile(val, path)
 		}
 	}
 
@@ -697,17 +1354,128 @@ func findCredentialsInFile(key, registry, path string, legacyFormat bool) (types
 	// those entries even in non-legacyFormat ~/.docker/config.json.
 	// The docker.io registry still uses the /v1/ key with a special host name,
 	// so account for that as well.
-	registry = normalizeRegistry(registry)
-	for k, v := range auths.AuthConfigs {
-		if normalizeAuthFileKey(k, legacyFormat) == registry {
-			return decodeDockerAuth(v)
+	unnormalizedRegistry := registry
+	registry = normalizeRegistryForLookup(sys, registry)
+	for k, v := range authConfigs {
+		if normalizeAuthFileKeyForLookup(sys, k, legacyFormat) == registry {
+			if !legacyFormat && strings.Contains(k, "://") {
+				warnings.Report(sys, types.Warning{
+					Kind:    types.WarningDeprecation,
+					Code:    types.WarningCodeLegacyAuthKey,
+					Subject: path,
+					Message: fmt.Sprintf("Using credentials for %s from entry %s in %s, which is an URL instead of a host name", registry, k, path),
+				})
+			}
+			return decodeDockerAuthInFile(v, path)
+		}
+	}
+
+	// Finally, try glob-style registry keys like "*.example.com" or "registry.example.com:*".
+	// As above, cred helpers take precedence.
+	if !legacyFormat {
+		if pattern, ok := bestWildcardMatch(mapKeys(credHelpers), unnormalizedRegistry); ok {
+			ch := credHelpers[pattern]
+			logrus.Debugf("Looking up in credential helper %s based on credHelpers entry %s in %s", ch, pattern, path)
+			return getAuthFromCredHelperWithBatch(ctx, sys, batch, ch, unnormalizedRegistry)
+		}
+		if pattern, ok := bestWildcardMatch(authConfigKeys(authConfigs), unnormalizedRegistry); ok {
+			logrus.Debugf("Using credentials from auths entry %s in %s", pattern, path)
+			return decodeDockerAuthInFile(authConfigs[pattern], path)
+		}
+	}
+
+	return authConfig{}, nil
+}
+
+// findCredentialsInPerRegistryOverrides looks for credentials matching "key"
+// in sys.DockerPerRegistryAuthConfigs, preferring the most specific entry.
+// It returns the credentials, the matching map key, and whether a match was found.
+func findCredentialsInPerRegistryOverrides(sys *types.SystemContext, key string) (types.DockerAuthConfig, string, bool) {
+	if sys == nil || len(sys.DockerPerRegistryAuthConfigs) == 0 {
+		return types.DockerAuthConfig{}, "", false
+	}
+	for _, k := range authKeysForKey(key) {
+		if authConfig, exists := sys.DockerPerRegistryAuthConfigs[k]; exists {
+			return authConfig, k, true
+		}
+	}
+	// Accept docker.io aliases, the same way auth files do.
+	registry := normalizeRegistryForLookup(sys, strings.SplitN(key, "/", 2)[0])
+	for k, authConfig := range sys.DockerPerRegistryAuthConfigs {
+		if !strings.ContainsRune(k, '/') && normalizeRegistryForLookup(sys, k) == registry {
+			return authConfig, k, true
+		}
+	}
+	return types.DockerAuthConfig{}, "", false
+}
+
+// registryOfKey returns the registry (host[:port]) part of key.
+func registryOfKey(key string) string {
+	return strings.SplitN(key, "/", 2)[0]
+}
+
+// credHelperOverrideForRegistry returns the credential helper configured for registry
+// in sys.CredentialHelperOverrides, if any.
+func credHelperOverrideForRegistry(sys *types.SystemContext, registry string) (string, bool) {
+	if sys == nil || len(sys.CredentialHelperOverrides) == 0 {
+		return "", false
+	}
+	if helper, ok := sys.CredentialHelperOverrides[registry]; ok {
+		return helper, true
+	}
+	// Accept docker.io aliases, the same way auth files do.
+	normalized := normalizeRegistryForLookup(sys, registry)
+	for k, helper := range sys.CredentialHelperOverrides {
+		if normalizeRegistryForLookup(sys, k) == normalized {
+			return helper, true
+		}
+	}
+	return "", false
+}
+
+// isWildcardKey returns true if key is a glob-style registry pattern,
+// e.g. "*.example.com" or "registry.example.com:*".
+// Patterns are only supported for registries, not for namespaces or repositories.
+func isWildcardKey(key string) bool {
+	return !strings.ContainsRune(key, '/') && strings.ContainsAny(key, "*?[")
+}
+
+// bestWildcardMatch returns the most specific (i.e. longest) wildcard key
+// among keys which matches registry.
+func bestWildcardMatch(keys []string, registry string) (string, bool) {
+	best := ""
+	for _, k := range keys {
+		if !isWildcardKey(k) {
+			continue
+		}
+		matched, err := path.Match(k, registry)
+		if err != nil {
+			logrus.Debugf("Ignoring invalid wildcard key %q: %v", k, err)
+			continue
+		}
+		if matched && (len(k) > len(best) || (len(k) == len(best) && k < best)) {
+			best = k
 		}
 	}
+	return best, best != ""
+}
 
-	// Only log this if we found nothing; getCredentialsWithHomeDir logs the
-	// source of found data.
-	logrus.Debugf("No credentials matching %s found in %s", key, path)
-	return types.DockerAuthConfig{}, nil
+// mapKeys returns the keys of m, in no particular order.
+func mapKeys(m map[string]string) []string {
+	res := make([]string, 0, len(m))
+	for k := range m {
+		res = append(res, k)
+	}
+	return res
+}
+
+// authConfigKeys returns the keys of m, in no particular order.
+func authConfigKeys(m map[string]dockerAuthConfig) []string {
+	res := make([]string, 0, len(m))
+	for k := range m {
+		res = append(res, k)
+	}
+	return res
 }
 
 // authKeysForKey returns the keys matching a provided auth file key, in order
@@ -733,50 +1501,94 @@ func authKeysForKey(key string) (res []string) {
 
 // decodeDockerAuth decodes the username and password, which is
 // encoded in base64.
-func decodeDockerAuth(conf dockerAuthConfig) (types.DockerAuthConfig, error) {
+func decodeDockerAuth(conf dockerAuthConfig) (authConfig, error) {
+	res := authConfig{
+		identityToken:  secret.New(conf.IdentityToken),
+		tokenEndpoint:  conf.TokenEndpoint,
+		tokenService:   conf.TokenService,
+		tokenScopes:    conf.TokenScopes,
+		clientCertPath: conf.ClientCert,
+		clientKeyPath:  conf.ClientKey,
+	}
+	if conf.Auth == "" {
+		// Only an identity token, as written by StoreRefreshToken, and/or a client certificate.
+		return res, nil
+	}
+
 	decoded, err := base64.StdEncoding.DecodeString(conf.Auth)
 	if err != nil {
-		return types.DockerAuthConfig{}, err
+		return authConfig{}, err
 	}
 
 	parts := strings.SplitN(string(decoded), ":", 2)
 	if len(parts) != 2 {
 		// if it's invalid just skip, as docker does
-		return types.DockerAuthConfig{}, nil
+		return authConfig{}, nil
 	}
 
-	user := parts[0]
-	password := strings.Trim(parts[1], "\x00")
-	return types.DockerAuthConfig{
-		Username:      user,
-		Password:      password,
-		IdentityToken: conf.IdentityToken,
-	}, nil
+	res.username = parts[0]
+	res.password = secret.New(strings.Trim(parts[1], "\x00"))
+	return res, nil
+}
+
+// decodeDockerAuthInFile is decodeDockerAuth for an entry read from the auth file at path:
+// it also resolves client certificate paths relative to the directory containing path.
+func decodeDockerAuthInFile(conf dockerAuthConfig, path string) (authConfig, error) {
+	res, err := decodeDockerAuth(conf)
+	if err != nil {
+		return authConfig{}, err
+	}
+	dir := filepath.Dir(path)
+	if res.clientCertPath != "" && !filepath.IsAbs(res.clientCertPath) {
+		res.clientCertPath = filepath.Join(dir, res.clientCertPath)
+	}
+	if res.clientKeyPath != "" && !filepath.IsAbs(res.clientKeyPath) {
+		res.clientKeyPath = filepath.Join(dir, res.clientKeyPath)
+	}
+	return res, nil
 }
 
 // normalizeAuthFileKey takes a key, converts it to a host name and normalizes
 // the resulting registry.
 func normalizeAuthFileKey(key string, legacyFormat bool) string {
+	return normalizeRegistry(authFileKeyRegistry(key, legacyFormat))
+}
+
+// normalizeAuthFileKeyForLookup is normalizeAuthFileKey, except that docker.io aliases are
+// not normalized if sys disables special-casing docker.io.
+func normalizeAuthFileKeyForLookup(sys *types.SystemContext, key string, legacyFormat bool) string {
+	return normalizeRegistryForLookup(sys, authFileKeyRegistry(key, legacyFormat))
+}
+
+// authFileKeyRegistry converts key to a host name.
+func authFileKeyRegistry(key string, legacyFormat bool) string {
 	stripped := strings.TrimPrefix(key, "http://")
 	stripped = strings.TrimPrefix(stripped, "https://")
 
 	if legacyFormat || stripped != key {
 		stripped = strings.SplitN(stripped, "/", 2)[0]
 	}
-
-	return normalizeRegistry(stripped)
+	return stripped
 }
 
 // normalizeRegistry converts the provided registry if a known docker.io host
 // is provided.
 func normalizeRegistry(registry string) string {
-	switch registry {
-	case "registry-1.docker.io", "docker.io":
-		return "index.docker.io"
+	if reference.IsDockerHubDomain(registry) {
+		return reference.DockerHubLegacyDomain
 	}
 	return registry
 }
 
+// normalizeRegistryForLookup is normalizeRegistry, except that it returns registry unchanged
+// if sys disables special-casing docker.io.
+func normalizeRegistryForLookup(sys *types.SystemContext, registry string) string {
+	if sys != nil && sys.DockerDisableHubNormalization {
+		return registry
+	}
+	return normalizeRegistry(registry)
+}
+
 // validateKey verifies that the input key does not have a prefix that is not
 // allowed and returns an indicator if the key is namespaced.
 func validateKey(key string) (bool, error) {
@@ -1,11 +1,16 @@
 package config
 
 import (
+	"context"
 	"encoding/json"
+	"errors"
 	"fmt"
 	"os"
+	"os/user"
 	"path/filepath"
 	"runtime"
+	"strconv"
+	"strings"
 	"testing"
 
 	"github.com/containers/image/v5/docker/reference"
@@ -17,11 +22,24 @@ import (
 func TestGetPathToAuth(t *testing.T) {
 	const linux = "linux"
 	const darwin = "darwin"
+	const freebsd = "freebsd"
 
 	uid := fmt.Sprintf("%d", os.Getuid())
+	selfUID := os.Getuid()
+	otherUID := os.Getuid() + 12345 // Assumed not to have a /run/user directory
 	// We don’t have to override the home directory for this because use of this path does not depend
 	// on any state of the filesystem.
 	darwinDefault := filepath.Join(os.Getenv("HOME"), ".config", "containers", "auth.json")
+	resolver := func(goOS string) (types.AuthFileLocations, error) {
+		switch goOS {
+		case linux:
+			return types.AuthFileLocations{Default: "/resolved/auth.json"}, nil
+		case darwin:
+			return types.AuthFileLocations{Fallbacks: []types.AuthFileFallback{{Path: "/resolved/read-only.json"}}}, nil
+		default:
+			return types.AuthFileLocations{}, errors.New("unsupported OS")
+		}
+	}
 
 	tmpDir := t.TempDir()
 
@@ -48,6 +66,7 @@ func TestGetPathToAuth(t *testing.T) {
 		{&types.SystemContext{}, darwin, "", darwinDefault, false},
 		{nil, linux, "", "/run/containers/" + uid + "/auth.json", false},
 		{nil, darwin, "", darwinDefault, false},
+		{nil, freebsd, "", "/var/run/containers/" + uid + "/auth.json", false},
 		// SystemContext overrides
 		{&types.SystemContext{AuthFilePath: "/absolute/path"}, linux, "", "/absolute/path", false},
 		{&types.SystemContext{AuthFilePath: "/absolute/path"}, darwin, "", "/absolute/path", false},
@@ -55,11 +74,38 @@ func TestGetPathToAuth(t *testing.T) {
 		{&types.SystemContext{LegacyFormatAuthFilePath: "/absolute/path"}, darwin, "", "/absolute/path", true},
 		{&types.SystemContext{RootForImplicitAbsolutePaths: "/prefix"}, linux, "", "/prefix/run/containers/" + uid + "/auth.json", false},
 		{&types.SystemContext{RootForImplicitAbsolutePaths: "/prefix"}, darwin, "", "/prefix/run/containers/" + uid + "/auth.json", false},
+		{&types.SystemContext{RootForImplicitAbsolutePaths: "/prefix"}, freebsd, "", "/prefix/var/run/containers/" + uid + "/auth.json", false},
+		{&types.SystemContext{AuthFilePaths: []types.AuthFile{{Path: "/read-only", ReadOnly: true}, {Path: "/writable"}}, AuthFilePath: "/absolute/path"},
+			linux, "", "/writable", false},
+		{&types.SystemContext{AuthFilePaths: []types.AuthFile{{Path: "/read-only", ReadOnly: true}}}, linux, "", "", false},
 		// XDG_RUNTIME_DIR defined
 		{nil, linux, tmpDir, tmpDir + "/containers/auth.json", false},
 		{nil, darwin, tmpDir, darwinDefault, false},
 		{nil, linux, tmpDir + "/thisdoesnotexist", "", false},
 		{nil, darwin, tmpDir + "/thisdoesnotexist", darwinDefault, false},
+		{nil, freebsd, tmpDir, tmpDir + "/containers/auth.json", false},
+		// AuthFilePathResolver
+		{&types.SystemContext{AuthFilePathResolver: resolver}, linux, tmpDir, "/resolved/auth.json", false},
+		{&types.SystemContext{AuthFilePathResolver: resolver, RootForImplicitAbsolutePaths: "/prefix"}, linux, "", "/resolved/auth.json", false},
+		{&types.SystemContext{AuthFilePathResolver: resolver, AuthFilePath: "/absolute/path"}, linux, "", "/absolute/path", false},
+		{&types.SystemContext{AuthFilePathResolver: resolver}, darwin, "", "", false},
+		{&types.SystemContext{AuthFilePathResolver: resolver}, freebsd, "", "", false},
+		// Other users and runtime directories
+		{&types.SystemContext{AuthFileRuntimeDir: "/custom"}, linux, tmpDir, "/custom/containers/auth.json", false},
+		{&types.SystemContext{AuthFileRuntimeDir: "/custom"}, darwin, tmpDir, darwinDefault, false},
+		{&types.SystemContext{AuthFileUID: &selfUID}, linux, tmpDir, tmpDir + "/containers/auth.json", false},
+		{&types.SystemContext{AuthFileUID: &otherUID}, linux, tmpDir, fmt.Sprintf("/run/containers/%d/auth.json", otherUID), false},
+		{&types.SystemContext{AuthFileUID: &otherUID}, freebsd, tmpDir, fmt.Sprintf("/var/run/containers/%d/auth.json", otherUID), false},
+		{&types.SystemContext{AuthFileUID: &otherUID, AuthFileRuntimeDir: "/custom"}, linux, tmpDir, "/custom/containers/auth.json", false},
+		{&types.SystemContext{AuthFileUID: &otherUID, RootForImplicitAbsolutePaths: "/prefix"}, linux, "",
+			fmt.Sprintf("/prefix/run/containers/%d/auth.json", otherUID), false},
+		// RuntimeDirFallback
+		{&types.SystemContext{RuntimeDirFallback: "/fallback"}, linux, "", "/fallback/containers/auth.json", false},
+		{&types.SystemContext{RuntimeDirFallback: "/fallback"}, linux, tmpDir, tmpDir + "/containers/auth.json", false},
+		{&types.SystemContext{RuntimeDirFallback: "/fallback"}, linux, tmpDir + "/thisdoesnotexist", "/fallback/containers/auth.json", false},
+		{&types.SystemContext{RuntimeDirFallback: "/fallback"}, freebsd, "", "/fallback/containers/auth.json", false},
+		{&types.SystemContext{RuntimeDirFallback: "/fallback"}, darwin, "", darwinDefault, false},
+		{&types.SystemContext{RuntimeDirFallback: "/fallback", AuthFileRuntimeDir: "/custom"}, linux, "", "/custom/containers/auth.json", false},
 	} {
 		if c.xrd != "" {
 			os.Setenv("XDG_RUNTIME_DIR", c.xrd)
@@ -77,6 +123,119 @@ func TestGetPathToAuth(t *testing.T) {
 	}
 }
 
+func TestGetAuthFileLocation(t *testing.T) {
+	tmpDir := t.TempDir()
+	oldXRD, hasXRD := os.LookupEnv("XDG_RUNTIME_DIR")
+	defer func() {
+		if hasXRD {
+			os.Setenv("XDG_RUNTIME_DIR", oldXRD)
+		} else {
+			os.Unsetenv("XDG_RUNTIME_DIR")
+		}
+	}()
+
+	os.Setenv("XDG_RUNTIME_DIR", tmpDir+"/thisdoesnotexist")
+	location, err := GetAuthFileLocation(&types.SystemContext{AuthFilePath: "/absolute/path"})
+	require.NoError(t, err)
+	assert.Equal(t, types.ResolvedLocation{Path: "/absolute/path"}, location)
+	if runtime.GOOS != "linux" && runtime.GOOS != "freebsd" {
+		t.Skip("runtime directories are only used on Linux and FreeBSD")
+	}
+	_, err = GetAuthFileLocation(&types.SystemContext{})
+	assert.Error(t, err)
+	location, err = GetAuthFileLocation(&types.SystemContext{RuntimeDirFallback: "/fallback"})
+	require.NoError(t, err)
+	assert.Equal(t, "/fallback/containers/auth.json", location.Path)
+	assert.True(t, location.Fallback)
+	assert.Contains(t, location.FallbackReason, "XDG_RUNTIME_DIR")
+
+	os.Setenv("XDG_RUNTIME_DIR", tmpDir)
+	location, err = GetAuthFileLocation(&types.SystemContext{RuntimeDirFallback: "/fallback"})
+	require.NoError(t, err)
+	assert.Equal(t, types.ResolvedLocation{Path: tmpDir + "/containers/auth.json"}, location)
+}
+
+func TestGetAuthFilePathsForOtherUser(t *testing.T) {
+	var otherUser *user.User
+	for _, candidate := range []int{0, 65534, 1} {
+		if candidate == os.Getuid() {
+			continue
+		}
+		if u, err := user.LookupId(strconv.Itoa(candidate)); err == nil {
+			otherUser = u
+			break
+		}
+	}
+	if otherUser == nil {
+		t.Skip("no other user found")
+	}
+	uid, err := strconv.Atoi(otherUser.Uid)
+	require.NoError(t, err)
+
+	// The environment of the calling process is ignored.
+	for name, value := range map[string]string{"XDG_CONFIG_HOME": "/xdg-config-home", "DOCKER_CONFIG": "/docker-config"} {
+		oldValue, hasValue := os.LookupEnv(name)
+		os.Setenv(name, value)
+		defer func(name string) {
+			if hasValue {
+				os.Setenv(name, oldValue)
+			} else {
+				os.Unsetenv(name)
+			}
+		}(name)
+	}
+	sys := &types.SystemContext{AuthFileUID: &uid, AuthFileRuntimeDir: "/custom"}
+	paths := getAuthFilePaths(sys, "/caller-home")
+	assert.Equal(t, []authPath{
+		{path: "/custom/containers/auth.json"},
+		{path: filepath.Join(otherUser.HomeDir, ".config/containers/auth.json")},
+		{path: filepath.Join(otherUser.HomeDir, ".docker/config.json")},
+		{path: filepath.Join(otherUser.HomeDir, ".dockercfg"), legacyFormat: true},
+	}, paths)
+}
+
+func TestGetAuthFilePathsWithResolver(t *testing.T) {
+	var resolvedOS string
+	sys := &types.SystemContext{
+		AuthFilePathResolver: func(goOS string) (types.AuthFileLocations, error) {
+			resolvedOS = goOS
+			return types.AuthFileLocations{
+				Default: "/resolved/auth.json",
+				Fallbacks: []types.AuthFileFallback{
+					{Path: "/resolved/read-only.json"},
+					{Path: "/resolved/.dockercfg", LegacyFormat: true},
+				},
+			}, nil
+		},
+	}
+	paths := getAuthFilePaths(sys, "/caller-home")
+	assert.Equal(t, runtime.GOOS, resolvedOS)
+	assert.Equal(t, []authPath{
+		{path: "/resolved/auth.json"},
+		{path: "/resolved/read-only.json"},
+		{path: "/resolved/.dockercfg", legacyFormat: true},
+	}, paths)
+
+	// Explicitly set paths are used instead of the default
+	sys.AuthFilePaths = []types.AuthFile{{Path: "/explicit/read-only.json", ReadOnly: true}, {Path: "/explicit/auth.json"}}
+	paths = getAuthFilePaths(sys, "/caller-home")
+	assert.Equal(t, []authPath{
+		{path: "/explicit/read-only.json"},
+		{path: "/explicit/auth.json"},
+		{path: "/resolved/read-only.json"},
+		{path: "/resolved/.dockercfg", legacyFormat: true},
+	}, paths)
+
+	// Resolver failures
+	sys = &types.SystemContext{
+		AuthFilePathResolver: func(goOS string) (types.AuthFileLocations, error) {
+			return types.AuthFileLocations{}, errors.New("resolver failed")
+		},
+	}
+	paths = getAuthFilePaths(sys, "/caller-home")
+	assert.Empty(t, paths)
+}
+
 func TestGetAuth(t *testing.T) {
 	origXDG := os.Getenv("XDG_RUNTIME_DIR")
 	tmpXDGRuntimeDir := t.TempDir()
@@ -316,6 +475,55 @@ func TestGetAuth(t *testing.T) {
 	}
 }
 
+func TestAuthFilePaths(t *testing.T) {
+	tmpDir := t.TempDir()
+	clusterPath := filepath.Join(tmpDir, "cluster.json")
+	userPath := filepath.Join(tmpDir, "user.json")
+	clusterContents := []byte(`{"auths":{"example.org":{"auth":"Y2x1c3RlcjpwYXNz"},"quay.io":{"auth":"Y2x1c3RlcjpwYXNz"}}}`)
+	err := os.WriteFile(clusterPath, clusterContents, 0600)
+	require.NoError(t, err)
+	err = os.WriteFile(userPath, []byte(`{"auths":{"quay.io":{"auth":"dXNlcjpwYXNz"}}}`), 0600)
+	require.NoError(t, err)
+	sys := &types.SystemContext{
+		AuthFilePaths: []types.AuthFile{
+			{Path: userPath},
+			{Path: clusterPath, ReadOnly: true},
+		},
+	}
+
+	// Files are consulted in order.
+	auth, err := getCredentialsWithHomeDir(sys, "quay.io", tmpDir)
+	require.NoError(t, err)
+	assert.Equal(t, types.DockerAuthConfig{Username: "user", Password: "pass"}, auth)
+	auth, err = getCredentialsWithHomeDir(sys, "example.org", tmpDir)
+	require.NoError(t, err)
+	assert.Equal(t, types.DockerAuthConfig{Username: "cluster", Password: "pass"}, auth)
+
+	// Writes go to the first writable file.
+	sys.AuthFilePaths = []types.AuthFile{
+		{Path: clusterPath, ReadOnly: true},
+		{Path: userPath},
+	}
+	desc, err := SetCredentials(sys, "example.org", "new", "pass")
+	require.NoError(t, err)
+	assert.Equal(t, userPath, desc)
+	auths, err := readJSONFile(userPath, false)
+	require.NoError(t, err)
+	assert.Contains(t, auths.AuthConfigs, "example.org")
+	contents, err := os.ReadFile(clusterPath)
+	require.NoError(t, err)
+	assert.Equal(t, clusterContents, contents)
+	// The read-only file still takes precedence for reads, because it is earlier in the list.
+	auth, err = getCredentialsWithHomeDir(sys, "example.org", tmpDir)
+	require.NoError(t, err)
+	assert.Equal(t, "cluster", auth.Username)
+
+	// Writes fail if no file is writable.
+	sys.AuthFilePaths = []types.AuthFile{{Path: clusterPath, ReadOnly: true}}
+	_, err = SetCredentials(sys, "example.org", "new", "pass")
+	assert.ErrorIs(t, err, ErrNoWritableAuthFile)
+}
+
 func TestGetAuthFromLegacyFile(t *testing.T) {
 	tmpDir := t.TempDir()
 	t.Logf("using temporary home directory: %q", tmpDir)
@@ -353,9 +561,17 @@ func TestGetAuthFromLegacyFile(t *testing.T) {
 				t.Fatal(err)
 			}
 
-			auth, err := getCredentialsWithHomeDir(nil, tc.hostname, tmpDir)
+			warnings := []types.Warning{}
+			sys := &types.SystemContext{WarningHandler: func(w types.Warning) { warnings = append(warnings, w) }}
+			auth, err := getCredentialsWithHomeDir(sys, tc.hostname, tmpDir)
 			require.NoError(t, err)
 			assert.Equal(t, tc.expected, auth)
+			assert.Equal(t, []types.Warning{{
+				Kind:    types.WarningDeprecation,
+				Code:    types.WarningCodeLegacyAuthFile,
+				Subject: configPath,
+				Message: fmt.Sprintf("Using credentials for %s from legacy-format auth file %s", tc.hostname, configPath),
+			}}, warnings)
 
 			// Testing for previous APIs
 			username, password, err := getAuthenticationWithHomeDir(nil, tc.hostname, tmpDir)
@@ -454,6 +670,264 @@ func TestGetAuthFailsOnBadInput(t *testing.T) {
 	assert.ErrorContains(t, err, "unmarshaling JSON")
 }
 
+func TestGetCredentialsClientCertificate(t *testing.T) {
+	tmpDir := t.TempDir()
+	authFilePath := filepath.Join(tmpDir, "auth.json")
+	err := os.WriteFile(authFilePath, []byte(`{"auths": {
+		"relative.example.com": {"clientcert": "certs/client.cert", "clientkey": "certs/client.key"},
+		"absolute.example.com": {"auth": "dXNlcjpwYXNz", "clientcert": "/abs/client.cert", "clientkey": "/abs/client.key"}
+	}}`), 0600)
+	require.NoError(t, err)
+	sys := &types.SystemContext{
+		AuthFilePath:                authFilePath,
+		SystemRegistriesConfPath:    filepath.Join("testdata", "cred-helper-with-auth-files.conf"),
+		SystemRegistriesConfDirPath: filepath.Join("testdata", "IdoNotExist"),
+	}
+
+	auth, err := getCredentialsWithHomeDir(sys, "relative.example.com", tmpDir)
+	require.NoError(t, err)
+	assert.Equal(t, types.DockerAuthConfig{
+		ClientCertPath: filepath.Join(tmpDir, "certs", "client.cert"),
+		ClientKeyPath:  filepath.Join(tmpDir, "certs", "client.key"),
+	}, auth)
+
+	auth, err = getCredentialsWithHomeDir(sys, "absolute.example.com/ns/repo", tmpDir)
+	require.NoError(t, err)
+	assert.Equal(t, types.DockerAuthConfig{
+		Username:       "user",
+		Password:       "pass",
+		ClientCertPath: "/abs/client.cert",
+		ClientKeyPath:  "/abs/client.key",
+	}, auth)
+
+	// Values from the SystemContext are returned unchanged
+	auth, err = getCredentialsWithHomeDir(&types.SystemContext{DockerAuthConfig: &types.DockerAuthConfig{ClientCertPath: "c", ClientKeyPath: "k"}},
+		"relative.example.com", tmpDir)
+	require.NoError(t, err)
+	assert.Equal(t, types.DockerAuthConfig{ClientCertPath: "c", ClientKeyPath: "k"}, auth)
+}
+
+func TestGetCredentialsPerRegistryOverrides(t *testing.T) {
+	tmpDir := t.TempDir()
+	sys := &types.SystemContext{
+		AuthFilePath: filepath.Join(tmpDir, "auth.json"),
+		DockerPerRegistryAuthConfigs: map[string]types.DockerAuthConfig{
+			"example.org":          {Username: "registry", Password: "pass"},
+			"example.org/ns":       {Username: "namespace", Password: "pass"},
+			"docker.io":            {Username: "hub", Password: "pass"},
+			"other.example.org/ns": {IdentityToken: "token"},
+		},
+	}
+
+	for _, c := range []struct {
+		key      string
+		expected types.DockerAuthConfig
+	}{
+		{"example.org", types.DockerAuthConfig{Username: "registry", Password: "pass"}},
+		{"example.org/other", types.DockerAuthConfig{Username: "registry", Password: "pass"}},
+		{"example.org/ns/repo", types.DockerAuthConfig{Username: "namespace", Password: "pass"}},
+		{"index.docker.io/library/busybox", types.DockerAuthConfig{Username: "hub", Password: "pass"}},
+		{"other.example.org/ns/repo", types.DockerAuthConfig{IdentityToken: "token"}},
+		{"other.example.org/repo", types.DockerAuthConfig{}},
+		{"unrelated.example.com", types.DockerAuthConfig{}},
+	} {
+		auth, err := getCredentialsWithHomeDir(sys, c.key, tmpDir)
+		require.NoError(t, err, c.key)
+		assert.Equal(t, c.expected, auth, c.key)
+	}
+
+	// DockerAuthConfig takes precedence over the per-registry map.
+	sys.DockerAuthConfig = &types.DockerAuthConfig{Username: "global", Password: "pass"}
+	auth, err := getCredentialsWithHomeDir(sys, "example.org", tmpDir)
+	require.NoError(t, err)
+	assert.Equal(t, "global", auth.Username)
+}
+
+func TestGetPushCredentials(t *testing.T) {
+	tmpDir := t.TempDir()
+	authFilePath := filepath.Join(tmpDir, "auth.json")
+	err := os.WriteFile(authFilePath, []byte(`{
+		"auths": {
+			"example.org": {"auth": "cHVsbGVyOnBhc3M="},
+			"pull-only.example.org": {"auth": "cHVsbGVyOnBhc3M="}
+		},
+		"pushAuths": {
+			"example.org": {"auth": "cHVzaGVyOnBhc3M="},
+			"example.org/ns": {"auth": "bnMtcHVzaGVyOnBhc3M="}
+		}
+	}`), 0600)
+	require.NoError(t, err)
+	sys := &types.SystemContext{
+		AuthFilePath:                authFilePath,
+		SystemRegistriesConfPath:    filepath.Join("testdata", "auth-files-only.conf"),
+		SystemRegistriesConfDirPath: filepath.Join("testdata", "IdoNotExist"),
+	}
+
+	for _, c := range []struct {
+		key                  string
+		expectedPull, expect string
+	}{
+		{"example.org/repo", "puller", "pusher"},
+		{"example.org/ns/repo", "puller", "ns-pusher"},
+		{"pull-only.example.org/repo", "puller", "puller"},
+		{"unrelated.example.org/repo", "", ""},
+	} {
+		auth, err := getCredentialsWithHomeDir(sys, c.key, tmpDir)
+		require.NoError(t, err, c.key)
+		assert.Equal(t, c.expectedPull, auth.Username, c.key)
+		auth, err = getPushCredentialsWithHomeDir(context.Background(), sys, c.key, tmpDir)
+		require.NoError(t, err, c.key)
+		assert.Equal(t, c.expect, auth.Username, c.key)
+	}
+
+	// Explicitly provided credentials are used for all operations.
+	sys.DockerPerRegistryAuthConfigs = map[string]types.DockerAuthConfig{"example.org": {Username: "override", Password: "pass"}}
+	auth, err := getPushCredentialsWithHomeDir(context.Background(), sys, "example.org/repo", tmpDir)
+	require.NoError(t, err)
+	assert.Equal(t, "override", auth.Username)
+	sys.DockerAuthConfig = &types.DockerAuthConfig{Username: "global", Password: "pass"}
+	auth, err = getPushCredentialsWithHomeDir(context.Background(), sys, "example.org/repo", tmpDir)
+	require.NoError(t, err)
+	assert.Equal(t, "global", auth.Username)
+}
+
+func TestGetPushCredentialsMultipleFiles(t *testing.T) {
+	tmpDir := t.TempDir()
+	primaryPath := filepath.Join(tmpDir, "primary.json")
+	err := os.WriteFile(primaryPath, []byte(`{
+		"auths": {
+			"example.org": {"auth": "cHJpbWFyeTpwYXNz"}
+		},
+		"pushAuths": {
+			"push.example.org": {"auth": "cHVzaGVyOnBhc3M="}
+		}
+	}`), 0600)
+	require.NoError(t, err)
+	secondaryPath := filepath.Join(tmpDir, "secondary.json")
+	err = os.WriteFile(secondaryPath, []byte(`{
+		"auths": {
+			"push.example.org": {"auth": "c2Vjb25kYXJ5OnBhc3M="}
+		},
+		"pushAuths": {
+			"example.org": {"auth": "c2Vjb25kYXJ5LXB1c2hlcjpwYXNz"},
+			"secondary.example.org": {"auth": "c2Vjb25kYXJ5LXB1c2hlcjpwYXNz"}
+		}
+	}`), 0600)
+	require.NoError(t, err)
+	sys := &types.SystemContext{
+		AuthFilePaths:               []types.AuthFile{{Path: primaryPath}, {Path: secondaryPath}},
+		SystemRegistriesConfPath:    filepath.Join("testdata", "auth-files-only.conf"),
+		SystemRegistriesConfDirPath: filepath.Join("testdata", "IdoNotExist"),
+	}
+
+	for _, c := range []struct {
+		key, expected string
+	}{
+		{"example.org/repo", "primary"},                    // Regular credentials in an earlier file are preferred
+		{"push.example.org/repo", "pusher"},                // Push credentials in an earlier file are preferred
+		{"secondary.example.org/repo", "secondary-pusher"}, // Push credentials in a later file are used
+		{"unrelated.example.org/repo", ""},
+	} {
+		auth, err := getPushCredentialsWithHomeDir(context.Background(), sys, c.key, tmpDir)
+		require.NoError(t, err, c.key)
+		assert.Equal(t, c.expected, auth.Username, c.key)
+	}
+}
+
+func TestGetCredentialsDisableHubNormalization(t *testing.T) {
+	tmpDir := t.TempDir()
+	authFilePath := filepath.Join(tmpDir, "auth.json")
+	err := os.WriteFile(authFilePath, []byte(`{
+		"auths": {
+			"https://index.docker.io/v1/": {"auth": "aHViOnBhc3M="},
+			"https://internal.example.org/v1/": {"auth": "aW50ZXJuYWw6cGFzcw=="}
+		}
+	}`), 0600)
+	require.NoError(t, err)
+	sys := &types.SystemContext{
+		AuthFilePath:                authFilePath,
+		SystemRegistriesConfPath:    filepath.Join("testdata", "auth-files-only.conf"),
+		SystemRegistriesConfDirPath: filepath.Join("testdata", "IdoNotExist"),
+	}
+
+	for _, c := range []struct {
+		disable  bool
+		key      string
+		expected string
+	}{
+		{false, "docker.io/library/busybox", "hub"},
+		{true, "docker.io/library/busybox", ""},
+		{false, "internal.example.org/repo", "internal"},
+		{true, "internal.example.org/repo", "internal"},
+	} {
+		sys.DockerDisableHubNormalization = c.disable
+		auth, err := getCredentialsWithHomeDir(sys, c.key, tmpDir)
+		require.NoError(t, err, c.key)
+		assert.Equal(t, c.expected, auth.Username, "%s %v", c.key, c.disable)
+	}
+
+	// Per-registry overrides are not matched using Docker Hub aliases either.
+	sys.DockerPerRegistryAuthConfigs = map[string]types.DockerAuthConfig{"index.docker.io": {Username: "override", Password: "pass"}}
+	sys.DockerDisableHubNormalization = false
+	auth, err := getCredentialsWithHomeDir(sys, "docker.io/library/busybox", tmpDir)
+	require.NoError(t, err)
+	assert.Equal(t, "override", auth.Username)
+	sys.DockerDisableHubNormalization = true
+	auth, err = getCredentialsWithHomeDir(sys, "docker.io/library/busybox", tmpDir)
+	require.NoError(t, err)
+	assert.Equal(t, "", auth.Username)
+}
+
+func TestCredentialHelperOverrides(t *testing.T) {
+	// override PATH for executing credHelper
+	curDir, err := os.Getwd()
+	require.NoError(t, err)
+	origPath := os.Getenv("PATH")
+	os.Setenv("PATH", fmt.Sprintf("%s:%s", filepath.Join(curDir, "testdata"), origPath))
+	defer os.Setenv("PATH", origPath)
+
+	tmpDir := t.TempDir()
+	authFilePath := filepath.Join(tmpDir, "auth.json")
+	err = os.WriteFile(authFilePath, []byte(`{"auths":{"registry-a.com":{"auth":"ZmlsZTpjcmVkcw=="},"example.org":{"auth":"ZmlsZTpjcmVkcw=="}}}`), 0600)
+	require.NoError(t, err)
+	sys := &types.SystemContext{
+		AuthFilePath: authFilePath,
+		CredentialHelperOverrides: map[string]string{
+			"registry-a.com": "helper-registry",
+		},
+	}
+
+	// The override takes precedence over the auth file, also for repositories within the registry.
+	for _, key := range []string{"registry-a.com", "registry-a.com/ns/repo"} {
+		auth, err := getCredentialsWithHomeDir(sys, key, tmpDir)
+		require.NoError(t, err, key)
+		assert.Equal(t, types.DockerAuthConfig{Username: "foo", Password: "bar"}, auth, key)
+	}
+	auth, err := getCredentialsWithHomeDir(sys, "example.org", tmpDir)
+	require.NoError(t, err)
+	assert.Equal(t, types.DockerAuthConfig{Username: "file", Password: "creds"}, auth)
+
+	// Credentials are stored in the helper, not in the auth file.
+	res, err := StoreCredentials(sys, "registry-a.com", "user", "pass")
+	require.NoError(t, err)
+	assert.Equal(t, CredentialWriteResult{Backend: CredentialBackendHelper, Helper: "helper-registry", Key: "registry-a.com"}, res)
+	auths, err := readJSONFile(authFilePath, false)
+	require.NoError(t, err)
+	assert.Equal(t, "ZmlsZTpjcmVkcw==", auths.AuthConfigs["registry-a.com"].Auth)
+
+	// Namespaced keys are not supported by helpers.
+	_, err = StoreCredentials(sys, "registry-a.com/ns", "user", "pass")
+	assert.Error(t, err)
+	err = RemoveAuthentication(sys, "registry-a.com/ns")
+	assert.Error(t, err)
+
+	// docker.io aliases are accepted.
+	sys.CredentialHelperOverrides = map[string]string{"docker.io": "helper-registry"}
+	res, err = StoreCredentials(sys, "index.docker.io", "user", "pass")
+	require.NoError(t, err)
+	assert.Equal(t, CredentialBackendHelper, res.Backend)
+}
+
 func TestGetAllCredentials(t *testing.T) {
 	// Create a temporary authentication file.
 	tmpFile, err := os.CreateTemp("", "auth.json.")
@@ -566,6 +1040,177 @@ func TestGetAllCredentials(t *testing.T) {
 	}
 }
 
+func TestGetCredentialsFailoverMode(t *testing.T) {
+	// override PATH for executing credHelper
+	path, err := os.Getwd()
+	require.NoError(t, err)
+	origPath := os.Getenv("PATH")
+	newPath := fmt.Sprintf("%s:%s", filepath.Join(path, "testdata"), origPath)
+	os.Setenv("PATH", newPath)
+	defer os.Setenv("PATH", origPath)
+
+	for _, c := range []struct {
+		mode                         types.CredentialHelperFailoverMode
+		foundErr, foundUser, missErr bool
+	}{
+		{types.CredentialHelperFailoverFirstSuccess, false, true, true},
+		{types.CredentialHelperFailoverTryAll, false, true, true},
+		{types.CredentialHelperFailoverFailFast, true, false, true},
+		{types.CredentialHelperFailoverIgnoreErrors, false, true, false},
+	} {
+		mode := c.mode
+		sys := &types.SystemContext{
+			SystemRegistriesConfPath:     filepath.Join("testdata", "cred-helper.conf"),
+			SystemRegistriesConfDirPath:  filepath.Join("testdata", "IdoNotExist"),
+			CredentialHelpers:            []string{"does-not-exist", "helper-registry"},
+			CredentialHelperFailoverMode: &mode,
+		}
+
+		auth, err := GetCredentials(sys, "registry-a.com")
+		if c.foundErr {
+			assert.Error(t, err, "%v", mode)
+		} else {
+			require.NoError(t, err, "%v", mode)
+		}
+		if c.foundUser {
+			assert.Equal(t, "foo", auth.Username, "%v", mode)
+		}
+
+		auth, err = GetCredentials(sys, "registry-c.com")
+		if c.missErr {
+			assert.Error(t, err, "%v", mode)
+		} else {
+			require.NoError(t, err, "%v", mode)
+			assert.Equal(t, types.DockerAuthConfig{}, auth)
+		}
+	}
+}
+
+func TestGetCredentialsFailoverModeTryAll(t *testing.T) {
+	// override PATH for executing credHelper
+	path, err := os.Getwd()
+	require.NoError(t, err)
+	origPath := os.Getenv("PATH")
+	newPath := fmt.Sprintf("%s:%s", filepath.Join(path, "testdata"), origPath)
+	os.Setenv("PATH", newPath)
+	defer os.Setenv("PATH", origPath)
+
+	// The auth file contains only a client certificate, the helper only a username and password.
+	authDir := t.TempDir()
+	authFilePath := filepath.Join(authDir, "auth.json")
+	err = os.WriteFile(authFilePath, []byte(`{
+		"auths": {
+			"registry-a.com": {"clientcert": "client.cert", "clientkey": "client.key"}
+		}
+	}`), 0600)
+	require.NoError(t, err)
+
+	firstSuccess := types.CredentialHelperFailoverFirstSuccess
+	tryAll := types.CredentialHelperFailoverTryAll
+	for _, c := range []struct {
+		mode     *types.CredentialHelperFailoverMode
+		helpers  []string
+		expected types.DockerAuthConfig
+	}{
+		{&firstSuccess, []string{"containers-auth.json", "helper-registry"}, types.DockerAuthConfig{
+			ClientCertPath: filepath.Join(authDir, "client.cert"), ClientKeyPath: filepath.Join(authDir, "client.key"),
+		}},
+		{&tryAll, []string{"containers-auth.json", "helper-registry"}, types.DockerAuthConfig{
+			Username: "foo", Password: "bar",
+			ClientCertPath: filepath.Join(authDir, "client.cert"), ClientKeyPath: filepath.Join(authDir, "client.key"),
+		}},
+		{&tryAll, []string{"helper-registry", "containers-auth.json"}, types.DockerAuthConfig{
+			Username: "foo", Password: "bar",
+			ClientCertPath: filepath.Join(authDir, "client.cert"), ClientKeyPath: filepath.Join(authDir, "client.key"),
+		}},
+		// Errors of individual helpers are ignored if others contain credentials.
+		{&tryAll, []string{"does-not-exist", "containers-auth.json"}, types.DockerAuthConfig{
+			ClientCertPath: filepath.Join(authDir, "client.cert"), ClientKeyPath: filepath.Join(authDir, "client.key"),
+		}},
+	} {
+		sys := &types.SystemContext{
+			AuthFilePath:                 authFilePath,
+			SystemRegistriesConfPath:     filepath.Join("testdata", "cred-helper.conf"),
+			SystemRegistriesConfDirPath:  filepath.Join("testdata", "IdoNotExist"),
+			CredentialHelpers:            c.helpers,
+			CredentialHelperFailoverMode: c.mode,
+		}
+		auth, err := getCredentialsWithHomeDir(sys, "registry-a.com", t.TempDir())
+		require.NoError(t, err, "%v %v", *c.mode, c.helpers)
+		assert.Equal(t, c.expected, auth, "%v %v", *c.mode, c.helpers)
+	}
+}
+
+func TestSetCredentialsFailoverMode(t *testing.T) {
+	authFilePath := filepath.Join(t.TempDir(), "auth.json")
+
+	firstSuccess := types.CredentialHelperFailoverFirstSuccess
+	tryAll := types.CredentialHelperFailoverTryAll
+	failFast := types.CredentialHelperFailoverFailFast
+	for _, c := range []struct {
+		mode        *types.CredentialHelperFailoverMode
+		helpers     []string
+		expectError bool
+	}{
+		{nil, []string{"does-not-exist", "containers-auth.json"}, false},
+		{&firstSuccess, []string{"containers-auth.json", "does-not-exist"}, false},
+		{&firstSuccess, []string{"does-not-exist", "containers-auth.json"}, false},
+		{&tryAll, []string{"containers-auth.json", "does-not-exist"}, true},
+		{&tryAll, []string{"containers-auth.json"}, false},
+		{&failFast, []string{"containers-auth.json", "does-not-exist"}, false},
+		{&failFast, []string{"does-not-exist", "containers-auth.json"}, true},
+	} {
+		require.NoError(t, os.WriteFile(authFilePath, []byte(`{}`), 0600))
+		sys := &types.SystemContext{
+			SystemRegistriesConfPath:     filepath.Join("testdata", "cred-helper-with-auth-files.conf"),
+			SystemRegistriesConfDirPath:  filepath.Join("testdata", "IdoNotExist"),
+			AuthFilePath:                 authFilePath,
+			CredentialHelpers:            c.helpers,
+			CredentialHelperFailoverMode: c.mode,
+		}
+		desc, err := SetCredentials(sys, "example.org", "user", "pass")
+		if c.expectError {
+			assert.Error(t, err, "%v", c.helpers)
+			continue
+		}
+		require.NoError(t, err, "%v", c.helpers)
+		assert.Equal(t, authFilePath, desc)
+		auth, err := GetCredentials(&types.SystemContext{AuthFilePath: authFilePath, CredentialHelpers: []string{}}, "example.org")
+		require.NoError(t, err)
+		assert.Equal(t, "user", auth.Username)
+	}
+}
+
+func TestFindCredentialsInFileWildcard(t *testing.T) {
+	authFilePath := filepath.Join(t.TempDir(), "auth.json")
+	err := os.WriteFile(authFilePath, []byte(`{"auths": {
+		"*.example.com": {"auth": "d2lsZDpjYXJk"},
+		"*.team.example.com": {"auth": "dGVhbTpwYXNz"},
+		"registry.example.org:*": {"auth": "cG9ydDpwYXNz"},
+		"exact.example.com": {"auth": "ZXhhY3Q6cGFzcw=="}
+	}}`), 0600)
+	require.NoError(t, err)
+
+	for _, c := range []struct {
+		key, username string
+	}{
+		{"exact.example.com", "exact"},
+		{"exact.example.com/repo", "exact"},
+		{"a.example.com", "wild"},
+		{"a.example.com/ns/repo", "wild"},
+		{"a.team.example.com/repo", "team"},
+		{"registry.example.org:5000/repo", "port"},
+		{"registry.example.org", ""},
+		{"example.com", ""},
+		{"a.example.com:5000", ""},
+	} {
+		registry := strings.SplitN(c.key, "/", 2)[0]
+		auth, err := findCredentialsInFile(context.Background(), nil, nil, c.key, registry, authFilePath, false, false)
+		require.NoError(t, err, c.key)
+		assert.Equal(t, c.username, auth.username, c.key)
+	}
+}
+
 func TestAuthKeysForKey(t *testing.T) {
 	for _, tc := range []struct {
 		name, input string
@@ -701,6 +1346,126 @@ func TestSetCredentials(t *testing.T) {
 	}
 }
 
+func TestStoreCredentials(t *testing.T) {
+	// override PATH for executing credHelper
+	path, err := os.Getwd()
+	require.NoError(t, err)
+	origPath := os.Getenv("PATH")
+	newPath := fmt.Sprintf("%s:%s", filepath.Join(path, "testdata"), origPath)
+	os.Setenv("PATH", newPath)
+	defer os.Setenv("PATH", origPath)
+
+	authFilePath := filepath.Join(t.TempDir(), "auth.json")
+	err = os.WriteFile(authFilePath, []byte(`{"credHelpers": {"routed.example.com": "helper-registry"}}`), 0600)
+	require.NoError(t, err)
+
+	for _, c := range []struct {
+		helpers  []string
+		key      string
+		expected CredentialWriteResult
+	}{
+		{
+			[]string{"containers-auth.json"}, "example.com/ns",
+			CredentialWriteResult{Backend: CredentialBackendAuthFile, Path: authFilePath, Key: "example.com/ns"},
+		},
+		{
+			[]string{"containers-auth.json"}, "routed.example.com",
+			CredentialWriteResult{Backend: CredentialBackendHelper, Path: authFilePath, Helper: "helper-registry", Key: "routed.example.com"},
+		},
+		{
+			[]string{"helper-registry", "containers-auth.json"}, "example.com",
+			CredentialWriteResult{Backend: CredentialBackendHelper, Helper: "helper-registry", Key: "example.com"},
+		},
+	} {
+		sys := &types.SystemContext{
+			SystemRegistriesConfPath:    filepath.Join("testdata", "cred-helper-with-auth-files.conf"),
+			SystemRegistriesConfDirPath: filepath.Join("testdata", "IdoNotExist"),
+			AuthFilePath:                authFilePath,
+			CredentialHelpers:           c.helpers,
+		}
+		res, err := StoreCredentials(sys, c.key, "user", "pass")
+		require.NoError(t, err, c.key)
+		assert.Equal(t, c.expected, res, c.key)
+
+		desc, err := SetCredentials(sys, c.key, "user", "pass")
+		require.NoError(t, err, c.key)
+		if c.expected.Path != "" {
+			assert.Equal(t, c.expected.Path, desc)
+		} else {
+			assert.Equal(t, "credential helper: "+c.expected.Helper, desc)
+		}
+	}
+}
+
+func TestStoreCredentialsRequireCredentialHelper(t *testing.T) {
+	// override PATH for executing credHelper
+	path, err := os.Getwd()
+	require.NoError(t, err)
+	origPath := os.Getenv("PATH")
+	newPath := fmt.Sprintf("%s:%s", filepath.Join(path, "testdata"), origPath)
+	os.Setenv("PATH", newPath)
+	defer os.Setenv("PATH", origPath)
+
+	authFilePath := filepath.Join(t.TempDir(), "auth.json")
+	err = os.WriteFile(authFilePath, []byte(`{"credHelpers": {"routed.example.com": "helper-registry"}}`), 0600)
+	require.NoError(t, err)
+	sys := &types.SystemContext{
+		SystemRegistriesConfPath:    filepath.Join("testdata", "cred-helper-with-auth-files.conf"),
+		SystemRegistriesConfDirPath: filepath.Join("testdata", "IdoNotExist"),
+		AuthFilePath:                authFilePath,
+		CredentialHelpers:           []string{"containers-auth.json"},
+		RequireCredentialHelper:     types.OptionalBoolTrue,
+	}
+
+	_, err = StoreCredentials(sys, "example.com", "user", "pass")
+	var forbidden ErrPlaintextCredentialsForbidden
+	require.True(t, errors.As(err, &forbidden), "%v", err)
+	assert.Equal(t, "example.com", forbidden.Key)
+	assert.Equal(t, authFilePath, forbidden.Path)
+	auths, err := readJSONFile(authFilePath, false)
+	require.NoError(t, err)
+	assert.Empty(t, auths.AuthConfigs)
+
+	// Routing via a credHelpers entry is allowed.
+	res, err := StoreCredentials(sys, "routed.example.com", "user", "pass")
+	require.NoError(t, err)
+	assert.Equal(t, CredentialBackendHelper, res.Backend)
+
+	// So is falling back to an external helper.
+	sys.CredentialHelpers = []string{"containers-auth.json", "helper-registry"}
+	res, err = StoreCredentials(sys, "example.com", "user", "pass")
+	require.NoError(t, err)
+	assert.Equal(t, CredentialBackendHelper, res.Backend)
+	assert.Equal(t, "helper-registry", res.Helper)
+
+	// Skipping the auth file is not a helper failure in the fail-fast and try-all modes.
+	for _, mode := range []types.CredentialHelperFailoverMode{types.CredentialHelperFailoverFailFast, types.CredentialHelperFailoverTryAll} {
+		mode := mode
+		sys.CredentialHelperFailoverMode = &mode
+		sys.CredentialHelpers = []string{"containers-auth.json", "helper-registry"}
+		res, err = StoreCredentials(sys, "example.com", "user", "pass")
+		require.NoError(t, err, mode)
+		assert.Equal(t, CredentialBackendHelper, res.Backend, mode)
+		assert.Equal(t, "helper-registry", res.Helper, mode)
+
+		// … but it is reported if no helper has stored the credentials.
+		sys.CredentialHelpers = []string{"containers-auth.json"}
+		_, err = StoreCredentials(sys, "example.com", "user", "pass")
+		require.True(t, errors.As(err, &forbidden), "%s: %v", mode, err)
+	}
+	sys.CredentialHelperFailoverMode = nil
+	auths, err = readJSONFile(authFilePath, false)
+	require.NoError(t, err)
+	assert.Empty(t, auths.AuthConfigs)
+
+	// Explicitly disabled.
+	sys.CredentialHelpers = []string{"containers-auth.json"}
+	sys.RequireCredentialHelper = types.OptionalBoolFalse
+	res, err = StoreCredentials(sys, "example.com", "user", "pass")
+	require.NoError(t, err)
+	assert.Equal(t, CredentialBackendAuthFile, res.Backend)
+}
+
 func TestRemoveAuthentication(t *testing.T) {
 	testAuth := dockerAuthConfig{Auth: "ZXhhbXBsZTpvcmc="}
 	for _, tc := range []struct {
@@ -797,6 +1562,78 @@ func TestRemoveAuthentication(t *testing.T) {
 	}
 }
 
+func TestRemoveAuthenticationDryRun(t *testing.T) {
+	// override PATH for executing credHelper
+	path, err := os.Getwd()
+	require.NoError(t, err)
+	origPath := os.Getenv("PATH")
+	newPath := fmt.Sprintf("%s:%s", filepath.Join(path, "testdata"), origPath)
+	os.Setenv("PATH", newPath)
+	defer os.Setenv("PATH", origPath)
+
+	tmpDir := t.TempDir()
+	authFilePath := filepath.Join(tmpDir, "auth.json")
+	contents := []byte(`{"auths":{"quay.io":{"auth":"ZXhhbXBsZTpvcmc="},"example.org":{"auth":"ZXhhbXBsZTpvcmc="}},` +
+		`"credHelpers":{"registry-a.com":"helper-registry"}}`)
+	err = os.WriteFile(authFilePath, contents, 0600)
+	require.NoError(t, err)
+	sys := &types.SystemContext{AuthFilePath: authFilePath, CredentialHelpers: []string{"containers-auth.json"}}
+	dryRun := &RemoveOptions{DryRun: true}
+	assertUnmodified := func() {
+		data, err := os.ReadFile(authFilePath)
+		require.NoError(t, err)
+		assert.Equal(t, contents, data)
+	}
+
+	report, err := RemoveAuthenticationWithOptions(sys, "quay.io", dryRun)
+	require.NoError(t, err)
+	assert.Equal(t, RemovalReport{DryRun: true, Removed: []RemovedCredential{
+		{Backend: CredentialBackendAuthFile, Path: authFilePath, Key: "quay.io"},
+	}}, report)
+	assertUnmodified()
+
+	report, err = RemoveAuthenticationWithOptions(sys, "registry-a.com", dryRun)
+	require.NoError(t, err)
+	assert.Equal(t, RemovalReport{DryRun: true, Removed: []RemovedCredential{
+		{Backend: CredentialBackendHelper, Path: authFilePath, Helper: "helper-registry", Key: "registry-a.com"},
+	}}, report)
+	assertUnmodified()
+
+	_, err = RemoveAuthenticationWithOptions(sys, "not-logged-in.example.org", dryRun)
+	assert.ErrorIs(t, err, ErrNotLoggedIn)
+
+	sys.CredentialHelpers = []string{"containers-auth.json", "helper-registry"}
+	report, err = RemoveAllAuthenticationWithOptions(sys, dryRun)
+	require.NoError(t, err)
+	assert.Equal(t, RemovalReport{DryRun: true, Removed: []RemovedCredential{
+		{Backend: CredentialBackendHelper, Path: authFilePath, Helper: "helper-registry", Key: "registry-a.com"},
+		{Backend: CredentialBackendAuthFile, Path: authFilePath, Key: "example.org"},
+		{Backend: CredentialBackendAuthFile, Path: authFilePath, Key: "quay.io"},
+		{Backend: CredentialBackendHelper, Helper: "helper-registry", Key: "registry-a.com"},
+	}}, report)
+	assertUnmodified()
+
+	// A dry run does not create a missing auth file.
+	missingPath := filepath.Join(tmpDir, "missing", "auth.json")
+	report, err = RemoveAllAuthenticationWithOptions(&types.SystemContext{AuthFilePath: missingPath, CredentialHelpers: []string{"containers-auth.json"}}, dryRun)
+	require.NoError(t, err)
+	assert.Equal(t, RemovalReport{DryRun: true, Removed: []RemovedCredential{}}, report)
+	_, err = os.Stat(filepath.Dir(missingPath))
+	assert.True(t, os.IsNotExist(err))
+
+	// Without DryRun, the report describes the entries which were removed.
+	sys.CredentialHelpers = []string{"containers-auth.json"}
+	report, err = RemoveAuthenticationWithOptions(sys, "quay.io", nil)
+	require.NoError(t, err)
+	assert.Equal(t, RemovalReport{Removed: []RemovedCredential{
+		{Backend: CredentialBackendAuthFile, Path: authFilePath, Key: "quay.io"},
+	}}, report)
+	auths, err := readJSONFile(authFilePath, false)
+	require.NoError(t, err)
+	assert.NotContains(t, auths.AuthConfigs, "quay.io")
+	assert.Contains(t, auths.AuthConfigs, "example.org")
+}
+
 func TestValidateKey(t *testing.T) {
 	// Invalid keys
 	for _, key := range []string{
@@ -907,3 +1744,35 @@ func TestSetGetCredentials(t *testing.T) {
 		}
 	}
 }
+
+func TestGetCredentialsLegacyAuthKeyWarning(t *testing.T) {
+	tmpDir := t.TempDir()
+	configPath := filepath.Join(tmpDir, "auth.json")
+	err := os.WriteFile(configPath, []byte(`{"auths":{"https://url.example.org/v1/":{"auth":"dXJsOnBhc3M="},"host.example.org":{"auth":"aG9zdDpwYXNz"}}}`), 0600)
+	require.NoError(t, err)
+
+	for _, c := range []struct {
+		registry string
+		user     string
+		warned   bool
+	}{
+		{"url.example.org", "url", true},
+		{"host.example.org", "host", false},
+	} {
+		warnings := []types.Warning{}
+		sys := &types.SystemContext{
+			AuthFilePath:   configPath,
+			WarningHandler: func(w types.Warning) { warnings = append(warnings, w) },
+		}
+		auth, err := getCredentialsWithHomeDir(sys, c.registry, tmpDir)
+		require.NoError(t, err)
+		assert.Equal(t, c.user, auth.Username)
+		if c.warned {
+			require.Len(t, warnings, 1)
+			assert.Equal(t, types.WarningCodeLegacyAuthKey, warnings[0].Code)
+			assert.Equal(t, configPath, warnings[0].Subject)
+		} else {
+			assert.Empty(t, warnings)
+		}
+	}
+}
@@ -0,0 +1,15 @@
+package config
+
+import (
+	"github.com/containers/image/v5/types"
+	"github.com/sirupsen/logrus"
+)
+
+// reportCredentialLookup logs event.Message, and reports event to sys.CredentialLookupEventHandler, if any.
+// event must not contain any secrets.
+func reportCredentialLookup(sys *types.SystemContext, event types.CredentialLookupEvent) {
+	logrus.Debug(event.Message)
+	if sys != nil && sys.CredentialLookupEventHandler != nil {
+		sys.CredentialLookupEventHandler(event)
+	}
+}
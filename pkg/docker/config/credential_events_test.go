@@ -0,0 +1,91 @@
+package config
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/containers/image/v5/internal/secret"
+	"github.com/containers/image/v5/types"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestCredentialLookupEvents(t *testing.T) {
+	curDir, err := os.Getwd()
+	require.NoError(t, err)
+	origPath := os.Getenv("PATH")
+	os.Setenv("PATH", fmt.Sprintf("%s:%s", filepath.Join(curDir, "testdata"), origPath))
+	defer os.Setenv("PATH", origPath)
+
+	var events []types.CredentialLookupEvent
+	sys := &types.SystemContext{
+		AuthFilePath:                 filepath.Join("testdata", "example.json"),
+		CredentialHelpers:            []string{"helper-registry", "containers-auth.json"},
+		CredentialLookupEventHandler: func(e types.CredentialLookupEvent) { events = append(events, e) },
+	}
+
+	auth, err := GetCredentials(sys, "example.org")
+	require.NoError(t, err)
+	assert.Equal(t, types.DockerAuthConfig{Username: "example", Password: "org"}, auth)
+	require.Len(t, events, 2)
+	assert.Equal(t, types.CredentialLookupEvent{
+		Key:     "example.org",
+		Source:  "credential-helper",
+		Helper:  "helper-registry",
+		Message: "No credentials for example.org found in credential helper helper-registry",
+	}, events[0])
+	assert.Equal(t, types.CredentialLookupEvent{
+		Key:     "example.org",
+		Source:  "auth-file",
+		Path:    filepath.Join("testdata", "example.json"),
+		Helper:  "containers-auth.json",
+		Found:   true,
+		Message: "Found credentials for example.org in credential helper containers-auth.json in file testdata/example.json",
+	}, events[1])
+
+	events = nil
+	_, err = GetCredentials(sys, "registry-a.com")
+	require.NoError(t, err)
+	require.Len(t, events, 1)
+	assert.True(t, events[0].Found)
+	assert.Equal(t, "helper-registry", events[0].Helper)
+	assert.NotContains(t, fmt.Sprintf("%#v", events[0]), "bar")
+
+	events = nil
+	_, err = GetCredentials(sys, "unknown.example.com")
+	require.NoError(t, err)
+	require.Len(t, events, 3)
+	assert.Equal(t, types.CredentialLookupEvent{Key: "unknown.example.com", Message: "No credentials for unknown.example.com found"}, events[2])
+
+	events = nil
+	sys.DockerAuthConfig = &types.DockerAuthConfig{Username: "user", Password: "password"}
+	_, err = GetCredentials(sys, "example.org")
+	require.NoError(t, err)
+	assert.Equal(t, []types.CredentialLookupEvent{{
+		Key:     "example.org",
+		Source:  "system-context",
+		Found:   true,
+		Message: "Returning credentials for example.org from DockerAuthConfig",
+	}}, events)
+}
+
+func TestAuthConfigRedaction(t *testing.T) {
+	creds, err := decodeDockerAuth(dockerAuthConfig{Auth: "dXNlcjpodW50ZXIy", IdentityToken: "token-value"})
+	require.NoError(t, err)
+	assert.Equal(t, types.DockerAuthConfig{Username: "user", Password: "hunter2", IdentityToken: "token-value"}, creds.dockerAuthConfig())
+	for _, formatted := range []string{
+		fmt.Sprintf("%v", creds),
+		fmt.Sprintf("%+v", creds),
+		fmt.Sprintf("%#v", creds),
+		fmt.Sprintf("%v", credHelperBatch{"helper": {"registry.example.com": creds}}),
+	} {
+		assert.NotContains(t, formatted, "hunter2")
+		assert.NotContains(t, formatted, "token-value")
+	}
+
+	assert.True(t, authConfig{}.isEmpty())
+	assert.True(t, newAuthConfig(types.DockerAuthConfig{}).isEmpty())
+	assert.False(t, authConfig{password: secret.New("p")}.isEmpty())
+}
@@ -0,0 +1,151 @@
+package config
+
+import (
+	"context"
+	"encoding/json"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/containers/image/v5/types"
+	"github.com/docker/docker-credential-helpers/credentials"
+	"github.com/pkg/errors"
+	"github.com/sirupsen/logrus"
+)
+
+// The batch credential helper protocol is an optional extension of the docker-credential-helpers protocol,
+// which allows reading or erasing all credentials stored in a helper using a single process.
+//
+// A helper advertises support by implementing the "containers-protocol" action: given any input,
+// it prints a JSON object {"version": 1, "actions": [...]}, where actions may contain:
+//   - "get-all": given any input, prints a JSON object mapping server URLs to objects in the format returned by "get",
+//     i.e. {"Username": ..., "Secret": ...}.
+//   - "erase-all": given any input, erases all credentials stored by the helper.
+//
+// Helpers which don’t implement "containers-protocol" (i.e. fail when it is invoked) are used via
+// the standard protocol, one process per registry.
+const (
+	credHelperProtocolAction  = "containers-protocol"
+	credHelperProtocolVersion = 1
+	credHelperActionGetAll    = "get-all"
+	credHelperActionEraseAll  = "erase-all"
+)
+
+// credHelperCapabilities is the output of the "containers-protocol" action.
+type credHelperCapabilities struct {
+	Version int      `json:"version"`
+	Actions []string `json:"actions"`
+}
+
+// supports returns true if c advertises action.
+func (c credHelperCapabilities) supports(action string) bool {
+	if c.Version < credHelperProtocolVersion {
+		return false
+	}
+	for _, a := range c.Actions {
+		if a == action {
+			return true
+		}
+	}
+	return false
+}
+
+// credHelperCapabilitiesCache caches the results of probing credential helpers.
+// Keys are helper names, values are credHelperCapabilities.
+var credHelperCapabilitiesCache sync.Map
+
+// probeCredHelperCapabilities returns the batch protocol capabilities of credHelper;
+// helpers which don’t support the protocol, or fail, return an empty value.
+func probeCredHelperCapabilities(ctx context.Context, sys *types.SystemContext, credHelper string) credHelperCapabilities {
+	if c, ok := credHelperCapabilitiesCache.Load(credHelper); ok {
+		return c.(credHelperCapabilities)
+	}
+	res := credHelperCapabilities{}
+	out, err := runCredHelperAction(ctx, sys, credHelper, credHelperProtocolAction)
+	if err != nil && ctx.Err() != nil {
+		// The helper was killed, so we don’t know whether it supports the protocol; don’t cache that.
+		logrus.Debugf("Probing credential helper %s for the batch protocol was canceled: %v", credHelper, err)
+		return res
+	}
+	if err != nil {
+		logrus.Debugf("Credential helper %s does not support the batch protocol: %v", credHelper, err)
+	} else if err := json.Unmarshal(out, &res); err != nil {
+		logrus.Debugf("Ignoring invalid %s output of credential helper %s: %v", credHelperProtocolAction, credHelper, err)
+		res = credHelperCapabilities{}
+	}
+	credHelperCapabilitiesCache.Store(credHelper, res)
+	return res
+}
+
+// runCredHelperAction runs credHelper with action, and returns its output.
+// The helper is killed if ctx is canceled before it exits.
+func runCredHelperAction(ctx context.Context, sys *types.SystemContext, credHelper, action string) ([]byte, error) {
+	p := credHelperProgramFuncWithContext(ctx, sys, credHelper)(action)
+	p.Input(strings.NewReader("unused"))
+	start := time.Now()
+	out, err := p.Output()
+	if err != nil {
+		err = errors.Wrapf(err, "running credential helper %s %s: %s", credHelper, action, strings.TrimSpace(string(out)))
+	}
+	observeCredHelperCall(ctx, sys, credHelper, action, start, err)
+	if err != nil {
+		return nil, err
+	}
+	return out, nil
+}
+
+// getAllAuthsFromCredHelper returns all credentials stored in credHelper, keyed by server URL, using a single process.
+// It returns ok == false if the helper does not support the "get-all" action, and the caller should fall back to
+// the standard protocol.
+func getAllAuthsFromCredHelper(ctx context.Context, sys *types.SystemContext, credHelper string) (map[string]authConfig, bool, error) {
+	if !probeCredHelperCapabilities(ctx, sys, credHelper).supports(credHelperActionGetAll) {
+		return nil, false, nil
+	}
+	out, err := runCredHelperAction(ctx, sys, credHelper, credHelperActionGetAll)
+	if err != nil {
+		return nil, true, err
+	}
+	var all map[string]credentials.Credentials
+	if err := json.Unmarshal(out, &all); err != nil {
+		return nil, true, errors.Wrapf(err, "parsing %s output of credential helper %s", credHelperActionGetAll, credHelper)
+	}
+	res := make(map[string]authConfig, len(all))
+	for serverURL, creds := range all {
+		res[serverURL] = credHelperCredentialsToAuthConfig(creds.Username, creds.Secret)
+	}
+	return res, true, nil
+}
+
+// eraseAllAuthsFromCredHelper erases all credentials stored in credHelper using a single process.
+// It returns ok == false if the helper does not support the "erase-all" action, and the caller should fall back to
+// the standard protocol.
+func eraseAllAuthsFromCredHelper(ctx context.Context, sys *types.SystemContext, credHelper string) (bool, error) {
+	if !probeCredHelperCapabilities(ctx, sys, credHelper).supports(credHelperActionEraseAll) {
+		return false, nil
+	}
+	_, err := runCredHelperAction(ctx, sys, credHelper, credHelperActionEraseAll)
+	return true, err
+}
+
+// credHelperBatch holds credentials prefetched from credential helpers using getAllAuthsFromCredHelper,
+// keyed by helper name and then by server URL.  A nil value is valid and contains no data.
+type credHelperBatch map[string]map[string]authConfig
+
+// lookup returns credentials for registry in credHelper, if the helper’s data was prefetched into b.
+func (b credHelperBatch) lookup(credHelper, registry string) (authConfig, bool) {
+	all, ok := b[credHelper]
+	if !ok {
+		return authConfig{}, false
+	}
+	if creds, ok := all[registry]; ok {
+		return creds, true
+	}
+	// Helpers may have recorded the credentials using URLs or alternative docker.io names.
+	normalized := normalizeRegistry(registry)
+	for serverURL, creds := range all {
+		if normalizeAuthFileKey(serverURL, false) == normalized {
+			return creds, true
+		}
+	}
+	return authConfig{}, true
+}
@@ -0,0 +1,120 @@
+package config
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/containers/image/v5/types"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// batchCredHelperScript implements the batch protocol, and records all invoked actions in $LOG.
+const batchCredHelperScript = `#!/bin/sh
+echo "$1" >> "%s"
+read UNUSED
+case "$1" in
+    containers-protocol) echo '{"version":1,"actions":["get-all","erase-all"]}' ;;
+    get-all) echo '{"registry-a.com":{"Username":"foo","Secret":"bar"},"https://registry-b.com":{"Username":"<token>","Secret":"fizzbuzz"}}' ;;
+    erase-all) ;;
+    *) echo "not implemented"; exit 1 ;;
+esac
+`
+
+func resetCredHelperCapabilitiesCache() {
+	credHelperCapabilitiesCache.Range(func(k, _ interface{}) bool {
+		credHelperCapabilitiesCache.Delete(k)
+		return true
+	})
+}
+
+func TestCredHelperBatchProtocol(t *testing.T) {
+	tmpDir := t.TempDir()
+	logPath := filepath.Join(tmpDir, "log")
+	err := os.WriteFile(filepath.Join(tmpDir, "docker-credential-batch"), []byte(fmt.Sprintf(batchCredHelperScript, logPath)), 0755)
+	require.NoError(t, err)
+	origPath := os.Getenv("PATH")
+	os.Setenv("PATH", fmt.Sprintf("%s:%s", tmpDir, origPath))
+	defer os.Setenv("PATH", origPath)
+	resetCredHelperCapabilitiesCache()
+	defer resetCredHelperCapabilitiesCache()
+
+	readLog := func() []string {
+		data, err := os.ReadFile(logPath)
+		require.NoError(t, err)
+		require.NoError(t, os.Remove(logPath))
+		return strings.Fields(string(data))
+	}
+
+	sys := &types.SystemContext{CredentialHelpers: []string{"batch"}}
+	auths, err := GetAllCredentials(sys)
+	require.NoError(t, err)
+	assert.Equal(t, map[string]types.DockerAuthConfig{
+		"registry-a.com": {Username: "foo", Password: "bar"},
+		"registry-b.com": {IdentityToken: "fizzbuzz"},
+	}, auths)
+	// A single probe and a single get-all, no per-registry "get" calls.
+	assert.Equal(t, []string{"containers-protocol", "get-all"}, readLog())
+
+	dryRunReport, err := RemoveAllAuthenticationWithOptions(sys, &RemoveOptions{DryRun: true})
+	require.NoError(t, err)
+	// The probe result is cached.
+	assert.Equal(t, []string{"get-all"}, readLog())
+	report, err := RemoveAllAuthenticationWithOptions(sys, nil)
+	require.NoError(t, err)
+	// The keys are listed before erasing all credentials at once.
+	assert.Equal(t, []string{"get-all", "erase-all"}, readLog())
+	expected := []RemovedCredential{
+		{Backend: CredentialBackendHelper, Helper: "batch", Key: "https://registry-b.com"},
+		{Backend: CredentialBackendHelper, Helper: "batch", Key: "registry-a.com"},
+	}
+	assert.Equal(t, RemovalReport{DryRun: true, Removed: expected}, dryRunReport)
+	assert.Equal(t, RemovalReport{Removed: expected}, report)
+}
+
+func TestCredHelperBatchProtocolFallback(t *testing.T) {
+	curDir, err := os.Getwd()
+	require.NoError(t, err)
+	origPath := os.Getenv("PATH")
+	os.Setenv("PATH", fmt.Sprintf("%s:%s", filepath.Join(curDir, "testdata"), origPath))
+	defer os.Setenv("PATH", origPath)
+	resetCredHelperCapabilitiesCache()
+	defer resetCredHelperCapabilitiesCache()
+
+	// docker-credential-helper-registry does not implement the batch protocol, so "list" and "get" are used.
+	sys := &types.SystemContext{CredentialHelpers: []string{"helper-registry"}}
+	auths, err := GetAllCredentials(sys)
+	require.NoError(t, err)
+	assert.Equal(t, map[string]types.DockerAuthConfig{
+		"registry-a.com": {Username: "foo", Password: "bar"},
+	}, auths)
+}
+
+func TestCredHelperBatchLookup(t *testing.T) {
+	batch := credHelperBatch{
+		"batch": {
+			"registry-a.com":              newAuthConfig(types.DockerAuthConfig{Username: "foo", Password: "bar"}),
+			"https://index.docker.io/v1/": newAuthConfig(types.DockerAuthConfig{Username: "hub", Password: "secret"}),
+		},
+	}
+	for _, c := range []struct {
+		helper, registry string
+		expected         types.DockerAuthConfig
+		expectedOK       bool
+	}{
+		{"batch", "registry-a.com", types.DockerAuthConfig{Username: "foo", Password: "bar"}, true},
+		{"batch", "docker.io", types.DockerAuthConfig{Username: "hub", Password: "secret"}, true},
+		{"batch", "registry-b.com", types.DockerAuthConfig{}, true},
+		{"other", "registry-a.com", types.DockerAuthConfig{}, false},
+	} {
+		creds, ok := batch.lookup(c.helper, c.registry)
+		assert.Equal(t, c.expectedOK, ok, c.registry)
+		assert.Equal(t, c.expected, creds.dockerAuthConfig(), c.registry)
+	}
+	creds, ok := credHelperBatch(nil).lookup("batch", "registry-a.com")
+	assert.False(t, ok)
+	assert.True(t, creds.isEmpty())
+}
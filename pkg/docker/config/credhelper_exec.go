@@ -0,0 +1,86 @@
+package config
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"io"
+	"os"
+	"strings"
+
+	"github.com/containers/image/v5/types"
+	helperclient "github.com/docker/docker-credential-helpers/client"
+	exec "golang.org/x/sys/execabs"
+)
+
+// credHelperProgram is a helperclient.Program which runs a credential helper
+// according to types.CredentialHelperExecOptions.
+type credHelperProgram struct {
+	cmd             *exec.Cmd
+	noNewPrivileges bool
+	seccomp         bool
+}
+
+// Output runs the helper and returns its standard output.
+func (p *credHelperProgram) Output() ([]byte, error) {
+	var stdout bytes.Buffer
+	p.cmd.Stdout = &stdout
+	if err := startCredHelper(p.cmd, p.noNewPrivileges, p.seccomp); err != nil {
+		return nil, err
+	}
+	err := p.cmd.Wait()
+	return stdout.Bytes(), err
+}
+
+// Input sets the standard input of the helper.
+func (p *credHelperProgram) Input(in io.Reader) {
+	p.cmd.Stdin = in
+}
+
+// credHelperProgramFunc returns a helperclient.ProgramFunc for credHelper,
+// restricted according to sys.CredentialHelperExecOptions.
+func credHelperProgramFunc(sys *types.SystemContext, credHelper string) helperclient.ProgramFunc {
+	if sys == nil || sys.CredentialHelperExecOptions == nil {
+		return helperclient.NewShellProgramFunc(fmt.Sprintf("docker-credential-%s", credHelper))
+	}
+	return credHelperProgramFuncWithContext(context.Background(), sys, credHelper)
+}
+
+// credHelperProgramFuncWithContext is credHelperProgramFunc, except that the helper processes are killed
+// if ctx is canceled before they exit.
+func credHelperProgramFuncWithContext(ctx context.Context, sys *types.SystemContext, credHelper string) helperclient.ProgramFunc {
+	helperName := fmt.Sprintf("docker-credential-%s", credHelper)
+	options := types.CredentialHelperExecOptions{}
+	if sys != nil && sys.CredentialHelperExecOptions != nil {
+		options = *sys.CredentialHelperExecOptions
+	}
+	return func(args ...string) helperclient.Program {
+		cmd := exec.CommandContext(ctx, helperName, args...)
+		cmd.Env = credHelperEnvironment(os.Environ(), options.EnvironmentAllowlist)
+		cmd.Dir = options.WorkingDirectory
+		if !options.DiscardStderr {
+			cmd.Stderr = os.Stderr
+		}
+		return &credHelperProgram{cmd: cmd, noNewPrivileges: options.NoNewPrivileges, seccomp: options.Seccomp}
+	}
+}
+
+// credHelperEnvironment returns the subset of environ allowed by allowlist;
+// a nil allowlist allows everything.
+func credHelperEnvironment(environ []string, allowlist []string) []string {
+	if allowlist == nil {
+		return environ
+	}
+	allowed := make(map[string]struct{}, len(allowlist))
+	for _, name := range allowlist {
+		allowed[name] = struct{}{}
+	}
+	res := []string{}
+	for _, kv := range environ {
+		name := strings.SplitN(kv, "=", 2)[0]
+		if _, ok := allowed[name]; ok {
+			res = append(res, kv)
+		}
+	}
+	return res
+}
@@ -0,0 +1,35 @@
+package config
+
+import (
+	"runtime"
+
+	exec "golang.org/x/sys/execabs"
+	"golang.org/x/sys/unix"
+)
+
+// startCredHelper starts cmd, optionally with the no_new_privs flag set, and optionally with a seccomp filter
+// (which implies no_new_privs).
+func startCredHelper(cmd *exec.Cmd, noNewPrivileges, seccomp bool) error {
+	if !noNewPrivileges && !seccomp {
+		return cmd.Start()
+	}
+	// no_new_privs and seccomp filters are per-thread attributes inherited by child processes, and they can’t be unset.
+	// So, set them on a dedicated OS thread, start the helper from that thread, and never unlock it:
+	// the Go runtime then never reuses the thread after the goroutine exits (usually, it terminates the thread).
+	errCh := make(chan error, 1)
+	go func() {
+		runtime.LockOSThread()
+		if err := unix.Prctl(unix.PR_SET_NO_NEW_PRIVS, 1, 0, 0, 0); err != nil {
+			errCh <- err
+			return
+		}
+		if seccomp {
+			if err := installCredHelperSeccompFilter(); err != nil {
+				errCh <- err
+				return
+			}
+		}
+		errCh <- cmd.Start()
+	}()
+	return <-errCh
+}
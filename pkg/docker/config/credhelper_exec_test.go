@@ -0,0 +1,51 @@
+package config
+
+import (
+	"context"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/containers/image/v5/types"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestCredHelperEnvironment(t *testing.T) {
+	environ := []string{"PATH=/bin", "HOME=/home/user", "SECRET=value", "EMPTY="}
+	assert.Equal(t, environ, credHelperEnvironment(environ, nil))
+	assert.Equal(t, []string{}, credHelperEnvironment(environ, []string{}))
+	assert.Equal(t, []string{"PATH=/bin", "EMPTY="}, credHelperEnvironment(environ, []string{"EMPTY", "PATH", "UNSET"}))
+}
+
+func TestCredHelperExecOptions(t *testing.T) {
+	helperDir := t.TempDir()
+	err := os.WriteFile(filepath.Join(helperDir, "docker-credential-exectest"), []byte(`#!/bin/sh
+read UNUSED
+echo "{\"ServerURL\":\"$UNUSED\",\"Username\":\"$(pwd)\",\"Secret\":\"${CREDHELPER_EXEC_TEST_SECRET}\"}"
+`), 0755)
+	require.NoError(t, err)
+	origPath := os.Getenv("PATH")
+	os.Setenv("PATH", helperDir+string(os.PathListSeparator)+origPath)
+	defer os.Setenv("PATH", origPath)
+	os.Setenv("CREDHELPER_EXEC_TEST_SECRET", "leaked")
+	defer os.Unsetenv("CREDHELPER_EXEC_TEST_SECRET")
+
+	workDir, err := filepath.EvalSymlinks(t.TempDir())
+	require.NoError(t, err)
+
+	// By default, the helper inherits everything.
+	auth, err := getAuthFromCredHelper(context.Background(), nil, "exectest", "registry.example.com")
+	require.NoError(t, err)
+	assert.Equal(t, "leaked", auth.password.Reveal())
+
+	sys := &types.SystemContext{CredentialHelperExecOptions: &types.CredentialHelperExecOptions{
+		EnvironmentAllowlist: []string{"PATH"},
+		WorkingDirectory:     workDir,
+		DiscardStderr:        true,
+	}}
+	auth, err = getAuthFromCredHelper(context.Background(), sys, "exectest", "registry.example.com")
+	require.NoError(t, err)
+	assert.Equal(t, workDir, auth.username)
+	assert.True(t, auth.password.IsEmpty())
+}
@@ -0,0 +1,20 @@
+//go:build !linux
+// +build !linux
+
+package config
+
+import (
+	"github.com/pkg/errors"
+	exec "golang.org/x/sys/execabs"
+)
+
+// startCredHelper starts cmd, optionally with the no_new_privs flag set, and optionally with a seccomp filter.
+func startCredHelper(cmd *exec.Cmd, noNewPrivileges, seccomp bool) error {
+	if noNewPrivileges {
+		return errors.Wrap(ErrNotSupported, "running credential helpers with no_new_privs")
+	}
+	if seccomp {
+		return errors.Wrap(ErrNotSupported, "running credential helpers with a seccomp filter")
+	}
+	return cmd.Start()
+}
@@ -0,0 +1,104 @@
+package config
+
+import (
+	"context"
+	"sync"
+	"time"
+
+	"github.com/containers/image/v5/types"
+	"github.com/pkg/errors"
+)
+
+const (
+	// defaultCredHelperListParallelism is the default for types.SystemContext.CredentialHelperListParallelism.
+	defaultCredHelperListParallelism = 4
+	// defaultCredHelperListTimeout is the default for types.SystemContext.CredentialHelperListTimeout.
+	defaultCredHelperListTimeout = 30 * time.Second
+)
+
+// credHelperListing is the result of listing credentials stored in a single external credential helper.
+type credHelperListing struct {
+	registries []string              // Registries with credentials stored in the helper, normalized
+	batch      map[string]authConfig // All credentials, if the helper supports the batch protocol; otherwise nil
+	err        error
+}
+
+// listCredHelpers lists credentials stored in the external credential helpers in credHelpers,
+// running up to sys.CredentialHelperListParallelism helpers concurrently, each limited to sys.CredentialHelperListTimeout.
+// Errors of individual helpers are returned in the respective credHelperListing.
+func listCredHelpers(sys *types.SystemContext, credHelpers []string) map[string]credHelperListing {
+	parallelism := defaultCredHelperListParallelism
+	timeout := defaultCredHelperListTimeout
+	if sys != nil {
+		if sys.CredentialHelperListParallelism > 0 {
+			parallelism = sys.CredentialHelperListParallelism
+		}
+		if sys.CredentialHelperListTimeout > 0 {
+			timeout = sys.CredentialHelperListTimeout
+		}
+	}
+
+	unique := []string{}
+	seen := map[string]struct{}{}
+	for _, helper := range credHelpers {
+		if _, ok := seen[helper]; !ok {
+			seen[helper] = struct{}{}
+			unique = append(unique, helper)
+		}
+	}
+	listings := make([]credHelperListing, len(unique)) // Each worker writes only its own element
+	var wg sync.WaitGroup
+	slots := make(chan struct{}, parallelism)
+	for i, helper := range unique {
+		wg.Add(1)
+		slots <- struct{}{}
+		go func(i int, helper string) {
+			defer func() {
+				<-slots
+				wg.Done()
+			}()
+			listings[i] = listCredHelperWithTimeout(sys, helper, timeout)
+		}(i, helper)
+	}
+	wg.Wait()
+
+	res := make(map[string]credHelperListing, len(unique))
+	for i, helper := range unique {
+		res[helper] = listings[i]
+	}
+	return res
+}
+
+// listCredHelperWithTimeout lists credentials stored in credHelper, killing the helper if that takes longer than timeout.
+func listCredHelperWithTimeout(sys *types.SystemContext, credHelper string, timeout time.Duration) credHelperListing {
+	ctx, cancel := context.WithTimeout(context.Background(), timeout)
+	defer cancel()
+
+	res := credHelperListing{}
+	all, batched, err := getAllAuthsFromCredHelper(ctx, sys, credHelper)
+	if batched {
+		if err == nil {
+			res.batch = all
+			// To use GetCredentials, we must at least convert the URL forms into host names.
+			normalizedDockerIORegistry := normalizeRegistry("docker.io")
+			for serverURL := range all {
+				registry := normalizeAuthFileKey(serverURL, false)
+				if registry == normalizedDockerIORegistry {
+					registry = "docker.io"
+				}
+				res.registries = append(res.registries, registry)
+			}
+		}
+	} else {
+		var creds map[string]string
+		creds, err = listAuthsFromCredHelper(ctx, sys, credHelper)
+		for registry := range creds {
+			res.registries = append(res.registries, registry)
+		}
+	}
+	if err != nil && ctx.Err() == context.DeadlineExceeded {
+		err = errors.Wrapf(err, "credential helper %s did not list credentials within %s", credHelper, timeout)
+	}
+	res.err = err
+	return res
+}
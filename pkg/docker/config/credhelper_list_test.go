@@ -0,0 +1,83 @@
+package config
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"sort"
+	"testing"
+	"time"
+
+	"github.com/containers/image/v5/types"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestListCredHelpers(t *testing.T) {
+	tmpDir := t.TempDir()
+	for name, script := range map[string]string{
+		"list-fast": `#!/bin/sh
+read UNUSED
+case "$1" in
+    list) echo '{"registry-a.com":"foo","registry-b.com":"bar"}' ;;
+    get) echo '{"Username":"foo","Secret":"bar"}' ;;
+    *) echo "not implemented"; exit 1 ;;
+esac
+`,
+		// exec, so that killing the helper doesn't leave a child holding its standard output open.
+		"list-slow": `#!/bin/sh
+read UNUSED
+case "$1" in
+    get) echo "credentials not found in native keychain"; exit 1 ;;
+    *) exec sleep 60 ;;
+esac
+`,
+	} {
+		err := os.WriteFile(filepath.Join(tmpDir, "docker-credential-"+name), []byte(script), 0755)
+		require.NoError(t, err)
+	}
+	origPath := os.Getenv("PATH")
+	os.Setenv("PATH", fmt.Sprintf("%s:%s", tmpDir, origPath))
+	defer os.Setenv("PATH", origPath)
+	resetCredHelperCapabilitiesCache()
+	defer resetCredHelperCapabilitiesCache()
+
+	sys := &types.SystemContext{
+		CredentialHelperListParallelism: 2,
+		CredentialHelperListTimeout:     500 * time.Millisecond,
+	}
+	start := time.Now()
+	listings := listCredHelpers(sys, []string{"list-slow", "list-fast", "list-slow", "list-missing"})
+	assert.Less(t, time.Since(start), 10*time.Second)
+	require.Len(t, listings, 3)
+
+	fast := listings["list-fast"]
+	require.NoError(t, fast.err)
+	sort.Strings(fast.registries)
+	assert.Equal(t, []string{"registry-a.com", "registry-b.com"}, fast.registries)
+	assert.Nil(t, fast.batch)
+
+	slow := listings["list-slow"]
+	require.Error(t, slow.err)
+	assert.Contains(t, slow.err.Error(), "did not list credentials within 500ms")
+	assert.Empty(t, slow.registries)
+	// The probe of the batch protocol timed out, so its result must not be cached.
+	_, cached := credHelperCapabilitiesCache.Load("list-slow")
+	assert.False(t, cached)
+
+	assert.Error(t, listings["list-missing"].err)
+
+	// GetAllCredentials is not blocked by the slow helper if its errors are ignored.
+	mode := types.CredentialHelperFailoverIgnoreErrors
+	sys.CredentialHelpers = []string{"list-slow", "list-fast"}
+	sys.CredentialHelperFailoverMode = &mode
+	sys.AuthFilePath = filepath.Join(tmpDir, "auth.json")
+	start = time.Now()
+	auths, err := GetAllCredentials(sys)
+	assert.Less(t, time.Since(start), 10*time.Second)
+	require.NoError(t, err)
+	assert.Equal(t, map[string]types.DockerAuthConfig{
+		"registry-a.com": {Username: "foo", Password: "bar"},
+		"registry-b.com": {Username: "foo", Password: "bar"},
+	}, auths)
+}
@@ -0,0 +1,37 @@
+package config
+
+import (
+	"context"
+	"time"
+
+	"github.com/containers/image/v5/internal/tracing"
+	"github.com/containers/image/v5/types"
+	"go.opentelemetry.io/otel/attribute"
+)
+
+// Actions of the standard docker-credential-helpers protocol, as reported to types.CredentialHelperMetrics.
+const (
+	credHelperActionGet   = "get"
+	credHelperActionStore = "store"
+	credHelperActionErase = "erase"
+	credHelperActionList  = "list"
+)
+
+// observeCredHelperCall reports an execution of credHelper with action, started at start and failing with err (if not nil),
+// to sys.CredentialHelperMetrics, if any, and, if tracing is enabled in ctx, as a tracing span which is a child of the span in ctx.
+func observeCredHelperCall(ctx context.Context, sys *types.SystemContext, credHelper, action string, start time.Time, err error) {
+	tracing.Record(ctx, tracing.Provider(ctx), "credential helper "+action, start, err,
+		attribute.String("credential_helper.name", credHelper), attribute.String("credential_helper.action", action))
+	if sys == nil || sys.CredentialHelperMetrics == nil {
+		return
+	}
+	sys.CredentialHelperMetrics.ObserveCredentialHelperCall(credHelper, action, time.Since(start), err)
+}
+
+// observeCredHelperCacheLookup reports a lookup of credentials prefetched from credHelper to sys.CredentialHelperMetrics, if any.
+func observeCredHelperCacheLookup(sys *types.SystemContext, credHelper string, hit bool) {
+	if sys == nil || sys.CredentialHelperMetrics == nil {
+		return
+	}
+	sys.CredentialHelperMetrics.ObserveCredentialHelperCacheLookup(credHelper, hit)
+}
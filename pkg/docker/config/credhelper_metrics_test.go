@@ -0,0 +1,143 @@
+package config
+
+import (
+	"context"
+	"fmt"
+	"os"
+	"path/filepath"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/containers/image/v5/docker/reference"
+	"github.com/containers/image/v5/internal/tracing"
+	"github.com/containers/image/v5/types"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"go.opentelemetry.io/otel/attribute"
+	sdktrace "go.opentelemetry.io/otel/sdk/trace"
+	"go.opentelemetry.io/otel/sdk/trace/tracetest"
+)
+
+// recordingCredHelperMetrics is a types.CredentialHelperMetrics which records all events.
+type recordingCredHelperMetrics struct {
+	mutex        sync.Mutex
+	calls        []string // "helper action ok/error"
+	cacheLookups []string // "helper hit/miss"
+}
+
+func (m *recordingCredHelperMetrics) ObserveCredentialHelperCall(credHelper, action string, duration time.Duration, err error) {
+	m.mutex.Lock()
+	defer m.mutex.Unlock()
+	result := "ok"
+	if err != nil {
+		result = "error"
+	}
+	m.calls = append(m.calls, fmt.Sprintf("%s %s %s", credHelper, action, result))
+}
+
+func (m *recordingCredHelperMetrics) ObserveCredentialHelperCacheLookup(credHelper string, hit bool) {
+	m.mutex.Lock()
+	defer m.mutex.Unlock()
+	result := "miss"
+	if hit {
+		result = "hit"
+	}
+	m.cacheLookups = append(m.cacheLookups, fmt.Sprintf("%s %s", credHelper, result))
+}
+
+// reset returns the recorded events, and clears them.
+func (m *recordingCredHelperMetrics) reset() (calls, cacheLookups []string) {
+	m.mutex.Lock()
+	defer m.mutex.Unlock()
+	calls, cacheLookups = m.calls, m.cacheLookups
+	m.calls, m.cacheLookups = nil, nil
+	return calls, cacheLookups
+}
+
+func TestCredHelperMetrics(t *testing.T) {
+	curDir, err := os.Getwd()
+	require.NoError(t, err)
+	tmpDir := t.TempDir()
+	err = os.WriteFile(filepath.Join(tmpDir, "docker-credential-batch"), []byte(fmt.Sprintf(batchCredHelperScript, filepath.Join(tmpDir, "log"))), 0755)
+	require.NoError(t, err)
+	origPath := os.Getenv("PATH")
+	os.Setenv("PATH", fmt.Sprintf("%s:%s:%s", tmpDir, filepath.Join(curDir, "testdata"), origPath))
+	defer os.Setenv("PATH", origPath)
+	resetCredHelperCapabilitiesCache()
+	defer resetCredHelperCapabilitiesCache()
+
+	metrics := &recordingCredHelperMetrics{}
+	sys := &types.SystemContext{
+		CredentialHelpers:       []string{"helper-registry"},
+		CredentialHelperMetrics: metrics,
+	}
+
+	// The standard protocol
+	_, err = GetCredentials(sys, "registry-a.com")
+	require.NoError(t, err)
+	_, err = GetCredentials(sys, "registry-no-creds.com")
+	require.NoError(t, err)
+	_, err = SetCredentials(sys, "registry-a.com", "foo", "bar")
+	require.NoError(t, err)
+	err = RemoveAuthentication(sys, "registry-a.com")
+	assert.Error(t, err)
+	calls, cacheLookups := metrics.reset()
+	assert.Equal(t, []string{
+		"helper-registry get ok",
+		"helper-registry get ok", // Missing credentials are not an error
+		"helper-registry store ok",
+		"helper-registry erase error",
+	}, calls)
+	assert.Empty(t, cacheLookups)
+
+	_, err = GetAllCredentials(sys)
+	require.NoError(t, err)
+	calls, _ = metrics.reset()
+	assert.Equal(t, []string{
+		"helper-registry containers-protocol error",
+		"helper-registry list ok",
+		"helper-registry get ok",
+	}, calls)
+
+	// The batch protocol, with prefetched credentials
+	sys.CredentialHelpers = []string{"batch"}
+	_, err = GetAllCredentials(sys)
+	require.NoError(t, err)
+	calls, cacheLookups = metrics.reset()
+	assert.Equal(t, []string{"batch containers-protocol ok", "batch get-all ok"}, calls)
+	assert.Equal(t, []string{"batch hit", "batch hit"}, cacheLookups)
+}
+
+func TestCredHelperTracing(t *testing.T) {
+	curDir, err := os.Getwd()
+	require.NoError(t, err)
+	origPath := os.Getenv("PATH")
+	os.Setenv("PATH", fmt.Sprintf("%s:%s", filepath.Join(curDir, "testdata"), origPath))
+	defer os.Setenv("PATH", origPath)
+
+	recorder := tracetest.NewSpanRecorder()
+	tp := sdktrace.NewTracerProvider(sdktrace.WithSpanProcessor(recorder))
+	sys := &types.SystemContext{
+		CredentialHelpers: []string{"helper-registry"},
+	}
+	ref, err := reference.ParseNormalizedNamed("registry-a.com/repo")
+	require.NoError(t, err)
+	ctx, parent := tp.Tracer("test").Start(tracing.WithProvider(context.Background(), tp), "parent")
+	auth, err := GetCredentialsForRefWithContext(ctx, sys, ref)
+	require.NoError(t, err)
+	assert.Equal(t, "foo", auth.Username)
+	parent.End()
+
+	spans := recorder.Ended()
+	require.Len(t, spans, 2)
+	assert.Equal(t, "credential helper get", spans[0].Name())
+	assert.Equal(t, parent.SpanContext().SpanID(), spans[0].Parent().SpanID())
+	assert.Contains(t, spans[0].Attributes(), attribute.String("credential_helper.name", "helper-registry"))
+	assert.False(t, spans[0].StartTime().After(spans[0].EndTime()))
+
+	// Without a context, tracing is disabled.
+	_, err = SetCredentials(sys, "registry-a.com", "foo", "bar")
+	require.NoError(t, err)
+	assert.Len(t, recorder.Ended(), 2)
+}
@@ -0,0 +1,76 @@
+//go:build linux && (amd64 || arm64)
+// +build linux
+// +build amd64 arm64
+
+package config
+
+import (
+	"unsafe"
+
+	"github.com/pkg/errors"
+	"golang.org/x/sys/unix"
+)
+
+// Values from linux/seccomp.h which are not defined in golang.org/x/sys/unix.
+const (
+	seccompRetKillThread = 0x00000000
+	seccompRetErrno      = 0x00050000
+	seccompRetAllow      = 0x7fff0000
+	// Offsets of the nr and arch fields of struct seccomp_data.
+	seccompDataNrOffset   = 0
+	seccompDataArchOffset = 4
+	// seccompX32SyscallBit is set in system call numbers of the x32 ABI; such system calls are denied.
+	seccompX32SyscallBit = 0x40000000
+)
+
+// credHelperDeniedSyscalls are the system calls denied to credential helpers by the seccomp filter:
+// operations on other processes, the kernel, namespaces and mounts, and system-wide settings,
+// none of which is necessary for storing and looking up credentials.
+var credHelperDeniedSyscalls = []uint32{
+	unix.SYS_PTRACE, unix.SYS_PROCESS_VM_READV, unix.SYS_PROCESS_VM_WRITEV, unix.SYS_KCMP,
+	unix.SYS_KEXEC_LOAD, unix.SYS_KEXEC_FILE_LOAD, unix.SYS_INIT_MODULE, unix.SYS_FINIT_MODULE, unix.SYS_DELETE_MODULE,
+	unix.SYS_BPF, unix.SYS_PERF_EVENT_OPEN, unix.SYS_USERFAULTFD, unix.SYS_LOOKUP_DCOOKIE,
+	unix.SYS_UNSHARE, unix.SYS_SETNS, unix.SYS_CHROOT, unix.SYS_PIVOT_ROOT, unix.SYS_MOUNT, unix.SYS_UMOUNT2,
+	unix.SYS_OPEN_TREE, unix.SYS_MOVE_MOUNT, unix.SYS_FSOPEN, unix.SYS_FSCONFIG, unix.SYS_FSMOUNT, unix.SYS_FSPICK,
+	unix.SYS_OPEN_BY_HANDLE_AT, unix.SYS_QUOTACTL, unix.SYS_SWAPON, unix.SYS_SWAPOFF, unix.SYS_ACCT, unix.SYS_REBOOT,
+	unix.SYS_SETTIMEOFDAY, unix.SYS_CLOCK_SETTIME, unix.SYS_ADJTIMEX, unix.SYS_CLOCK_ADJTIME,
+	unix.SYS_SETHOSTNAME, unix.SYS_SETDOMAINNAME,
+}
+
+// credHelperSeccompFilter returns a seccomp BPF program which fails credHelperDeniedSyscalls with EPERM,
+// kills threads using system calls of a foreign architecture, and allows everything else.
+func credHelperSeccompFilter() []unix.SockFilter {
+	stmt := func(code uint16, k uint32) unix.SockFilter {
+		return unix.SockFilter{Code: code, K: k}
+	}
+	// Jumps are relative to the following instruction; the last two instructions are “allow” and “deny”.
+	filterLen := 5 + len(credHelperDeniedSyscalls) + 2
+	toDeny := func(i int) uint8 {
+		return uint8(filterLen - 1 - (i + 1))
+	}
+	filter := []unix.SockFilter{
+		stmt(unix.BPF_LD|unix.BPF_W|unix.BPF_ABS, seccompDataArchOffset),
+		{Code: unix.BPF_JMP | unix.BPF_JEQ | unix.BPF_K, Jt: 1, Jf: 0, K: seccompAuditArch},
+		stmt(unix.BPF_RET|unix.BPF_K, seccompRetKillThread),
+		stmt(unix.BPF_LD|unix.BPF_W|unix.BPF_ABS, seccompDataNrOffset),
+	}
+	filter = append(filter, unix.SockFilter{Code: unix.BPF_JMP | unix.BPF_JGE | unix.BPF_K, Jt: toDeny(len(filter)), K: seccompX32SyscallBit})
+	for _, nr := range credHelperDeniedSyscalls {
+		filter = append(filter, unix.SockFilter{Code: unix.BPF_JMP | unix.BPF_JEQ | unix.BPF_K, Jt: toDeny(len(filter)), K: nr})
+	}
+	filter = append(filter,
+		stmt(unix.BPF_RET|unix.BPF_K, seccompRetAllow),
+		stmt(unix.BPF_RET|unix.BPF_K, seccompRetErrno|uint32(unix.EPERM)))
+	return filter
+}
+
+// installCredHelperSeccompFilter installs credHelperSeccompFilter for the current thread, which must already
+// have the no_new_privs flag set.
+func installCredHelperSeccompFilter() error {
+	filter := credHelperSeccompFilter()
+	prog := unix.SockFprog{Len: uint16(len(filter)), Filter: &filter[0]}
+	if err := unix.Prctl(unix.PR_SET_SECCOMP, unix.SECCOMP_MODE_FILTER, uintptr(unsafe.Pointer(&prog)), 0, 0); err != nil {
+		return errors.Wrap(err, "installing a seccomp filter for a credential helper")
+	}
+	return nil
+}
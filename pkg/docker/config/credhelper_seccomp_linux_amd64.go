@@ -0,0 +1,4 @@
+package config
+
+// seccompAuditArch is AUDIT_ARCH_X86_64 from linux/audit.h.
+const seccompAuditArch = 0xc000003e
@@ -0,0 +1,4 @@
+package config
+
+// seccompAuditArch is AUDIT_ARCH_AARCH64 from linux/audit.h.
+const seccompAuditArch = 0xc00000b7
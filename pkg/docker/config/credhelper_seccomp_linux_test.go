@@ -0,0 +1,49 @@
+//go:build linux && (amd64 || arm64)
+// +build linux
+// +build amd64 arm64
+
+package config
+
+import (
+	"context"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/containers/image/v5/types"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestCredHelperSeccomp(t *testing.T) {
+	helperDir := t.TempDir()
+	// The helper reports its NoNewPrivs and Seccomp status as the user name and the password.
+	err := os.WriteFile(filepath.Join(helperDir, "docker-credential-seccomptest"), []byte(`#!/bin/sh
+read UNUSED
+NNP=$(sed -n 's/^NoNewPrivs:[[:space:]]*//p' /proc/$$/status)
+SECCOMP=$(sed -n 's/^Seccomp:[[:space:]]*//p' /proc/$$/status)
+echo "{\"ServerURL\":\"$UNUSED\",\"Username\":\"$NNP\",\"Secret\":\"$SECCOMP\"}"
+`), 0755)
+	require.NoError(t, err)
+	origPath := os.Getenv("PATH")
+	os.Setenv("PATH", helperDir+string(os.PathListSeparator)+origPath)
+	defer os.Setenv("PATH", origPath)
+
+	for _, c := range []struct {
+		options         types.CredentialHelperExecOptions
+		nnp, seccompVal string
+	}{
+		{types.CredentialHelperExecOptions{}, "0", "0"},
+		{types.CredentialHelperExecOptions{NoNewPrivileges: true}, "1", "0"},
+		{types.CredentialHelperExecOptions{Seccomp: true}, "1", "2"}, // 2 = SECCOMP_MODE_FILTER
+		// The thread which started the restricted helpers is not reused for starting other helpers.
+		{types.CredentialHelperExecOptions{}, "0", "0"},
+	} {
+		options := c.options
+		sys := &types.SystemContext{CredentialHelperExecOptions: &options}
+		auth, err := getAuthFromCredHelper(context.Background(), sys, "seccomptest", "registry.example.com")
+		require.NoError(t, err, "%#v", c.options)
+		assert.Equal(t, c.nnp, auth.username, "%#v", c.options)
+		assert.Equal(t, c.seccompVal, auth.password.Reveal(), "%#v", c.options)
+	}
+}
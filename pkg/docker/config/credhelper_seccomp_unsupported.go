@@ -0,0 +1,13 @@
+//go:build linux && !amd64 && !arm64
+// +build linux,!amd64,!arm64
+
+package config
+
+import (
+	"github.com/pkg/errors"
+)
+
+// installCredHelperSeccompFilter fails, because seccomp filters for credential helpers are not supported on this architecture.
+func installCredHelperSeccompFilter() error {
+	return errors.Wrap(ErrNotSupported, "running credential helpers with a seccomp filter on this architecture")
+}
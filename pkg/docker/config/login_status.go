@@ -0,0 +1,89 @@
+package config
+
+import (
+	"context"
+	"errors"
+
+	"github.com/containers/image/v5/docker/reference"
+	"github.com/containers/image/v5/types"
+	"github.com/containers/storage/pkg/homedir"
+)
+
+// LoginStatus describes the credentials available for a registry, as returned by GetLoginStatus.
+// It never contains secrets; it is suitable for machine-readable output, e.g. as JSON.
+type LoginStatus struct {
+	// Key is the key the status was computed for.
+	Key string `json:"key"`
+	// LoggedIn is true if credentials were found.
+	LoggedIn bool `json:"loggedIn"`
+	// Source describes where the credentials were found; only set if LoggedIn.
+	Source *CredentialSource `json:"source,omitempty"`
+	// Username is the user name of the credentials; "" if the credentials consist of an identity token.
+	Username string `json:"username,omitempty"`
+	// IdentityToken is true if the credentials consist of an identity token.
+	IdentityToken bool `json:"identityToken,omitempty"`
+	// Verification contains the result of asking the registry to validate the credentials;
+	// nil if that was not requested, or not possible.
+	Verification *LoginVerification `json:"verification,omitempty"`
+}
+
+// LoginVerification is the result of validating credentials with a registry, a part of LoginStatus.
+type LoginVerification struct {
+	// Valid is true if the registry accepted the credentials.
+	Valid bool `json:"valid"`
+	// Reason describes why the credentials could not be verified; only meaningful if !Valid.
+	Reason string `json:"reason,omitempty"`
+	// Error is the text of the error returned by CheckAuth, if any.
+	Error string `json:"error,omitempty"`
+}
+
+// GetLoginStatus reports whether credentials for key exist, appropriate for sys and the users’ configuration,
+// where they come from, and the user name, without exposing the password.
+// A valid key is a repository, a namespace within a registry, or a registry hostname, as for GetCredentials;
+// use reference.Named.Name() to get the status for a reference.
+// If verify is true, and the credentials are a user name and password, they are validated using CheckAuth;
+// failures to validate them are reported in LoginStatus.Verification, not as an error.
+func GetLoginStatus(ctx context.Context, sys *types.SystemContext, key string, verify bool) (LoginStatus, error) {
+	return getLoginStatusWithHomeDir(ctx, sys, key, homedir.Get(), verify)
+}
+
+// GetLoginStatusForRef is GetLoginStatus for the registry ref points to.
+func GetLoginStatusForRef(ctx context.Context, sys *types.SystemContext, ref reference.Named, verify bool) (LoginStatus, error) {
+	return getLoginStatusWithHomeDir(ctx, sys, ref.Name(), homedir.Get(), verify)
+}
+
+// getLoginStatusWithHomeDir is an internal implementation detail of GetLoginStatus and GetLoginStatusForRef,
+// it exists only to allow testing it with an artificial home directory.
+func getLoginStatusWithHomeDir(ctx context.Context, sys *types.SystemContext, key, homeDir string, verify bool) (LoginStatus, error) {
+	creds, source, err := getCredentialsAndSource(ctx, sys, key, homeDir, nil, false)
+	if err != nil {
+		return LoginStatus{}, err
+	}
+	res := LoginStatus{Key: key}
+	if creds.isEmpty() {
+		return res, nil
+	}
+	res.LoggedIn = true
+	res.Source = &source
+	if !creds.identityToken.IsEmpty() {
+		res.IdentityToken = true
+		// CheckAuth does not support identity tokens, so there is no verification.
+		return res, nil
+	}
+	res.Username = creds.username
+	if verify {
+		err := CheckAuth(ctx, sys, key, creds.username, creds.password.Reveal())
+		var checkErr *CheckAuthError
+		switch {
+		case err == nil:
+			res.Verification = &LoginVerification{Valid: true}
+		case errors.As(err, &checkErr):
+			res.Verification = &LoginVerification{Valid: false, Reason: checkErr.Reason.String(), Error: err.Error()}
+		case errors.Is(err, ErrNotSupported):
+			// The docker transport is not linked in, so verification is not possible.
+		default:
+			res.Verification = &LoginVerification{Valid: false, Error: err.Error()}
+		}
+	}
+	return res, nil
+}
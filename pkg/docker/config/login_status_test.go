@@ -0,0 +1,80 @@
+package config
+
+import (
+	"context"
+	"encoding/json"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/containers/image/v5/internal/authcheck"
+	"github.com/containers/image/v5/types"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestGetLoginStatus(t *testing.T) {
+	tmpDir := t.TempDir()
+	authFilePath := filepath.Join(tmpDir, "auth.json")
+	err := os.WriteFile(authFilePath, []byte(`{"auths":{"example.org":{"auth":"dXNlcjpwYXNz"},"token.example.org":{"auth":"PHRva2VuPjo=","identitytoken":"token"}}}`), 0600)
+	require.NoError(t, err)
+	sys := &types.SystemContext{AuthFilePath: authFilePath}
+	ctx := context.Background()
+
+	// Not logged in
+	status, err := getLoginStatusWithHomeDir(ctx, sys, "unknown.example.org", tmpDir, true)
+	require.NoError(t, err)
+	assert.Equal(t, LoginStatus{Key: "unknown.example.org"}, status)
+
+	// Credentials in an auth file; without a registered checker, no verification is possible.
+	authcheck.Register(nil)
+	status, err = getLoginStatusWithHomeDir(ctx, sys, "example.org/ns/repo", tmpDir, true)
+	require.NoError(t, err)
+	assert.Equal(t, LoginStatus{
+		Key:      "example.org/ns/repo",
+		LoggedIn: true,
+		Source:   &CredentialSource{Backend: CredentialBackendAuthFile, Path: authFilePath},
+		Username: "user",
+	}, status)
+	// The password is never included in the output.
+	out, err := json.Marshal(status)
+	require.NoError(t, err)
+	assert.NotContains(t, string(out), "pass")
+
+	// Identity tokens
+	status, err = getLoginStatusWithHomeDir(ctx, sys, "token.example.org", tmpDir, true)
+	require.NoError(t, err)
+	assert.True(t, status.LoggedIn)
+	assert.True(t, status.IdentityToken)
+	assert.Equal(t, "", status.Username)
+	assert.Nil(t, status.Verification)
+
+	// Credentials from SystemContext
+	status, err = getLoginStatusWithHomeDir(ctx, &types.SystemContext{DockerAuthConfig: &types.DockerAuthConfig{Username: "sys", Password: "pass"}},
+		"example.org", tmpDir, false)
+	require.NoError(t, err)
+	assert.Equal(t, &CredentialSource{Backend: CredentialBackendSystemContext}, status.Source)
+	assert.Equal(t, "sys", status.Username)
+	assert.Nil(t, status.Verification)
+
+	// Verification
+	defer authcheck.Register(nil)
+	var checkResult error
+	authcheck.Register(func(ctx context.Context, sys *types.SystemContext, registry, username, password string) error {
+		assert.Equal(t, "example.org", registry)
+		assert.Equal(t, "user", username)
+		assert.Equal(t, "pass", password)
+		return checkResult
+	})
+	checkResult = nil
+	status, err = getLoginStatusWithHomeDir(ctx, sys, "example.org", tmpDir, true)
+	require.NoError(t, err)
+	assert.Equal(t, &LoginVerification{Valid: true}, status.Verification)
+	checkResult = &CheckAuthError{Reason: CheckAuthInvalidCredentials, Registry: "example.org"}
+	status, err = getLoginStatusWithHomeDir(ctx, sys, "example.org", tmpDir, true)
+	require.NoError(t, err)
+	require.NotNil(t, status.Verification)
+	assert.False(t, status.Verification.Valid)
+	assert.Equal(t, "invalid credentials", status.Verification.Reason)
+	assert.NotEmpty(t, status.Verification.Error)
+}
@@ -0,0 +1,58 @@
+package config
+
+import (
+	"context"
+
+	"github.com/containers/image/v5/types"
+	"github.com/containers/storage/pkg/homedir"
+)
+
+// RefreshToken is an OAuth2 refresh token issued by a registry, which can be exchanged for access tokens.
+type RefreshToken struct {
+	// Token is the refresh token; it is also returned by GetCredentials as types.DockerAuthConfig.IdentityToken.
+	Token string
+	// Endpoint is the URL of the token endpoint which issued Token; "" if not known.
+	Endpoint string
+	// Service is the value of the "service" parameter used with Endpoint; "" if not known.
+	Service string
+	// Scopes are the scopes Token was issued for, if known.
+	Scopes []string
+}
+
+// StoreRefreshToken stores token for key, in a location appropriate for sys and the users’ configuration,
+// replacing any other credentials stored for key, and returns a description of where the token was stored.
+// Credential helpers can only store token.Token; token.Endpoint, token.Service and token.Scopes are only
+// recorded in auth files.
+func StoreRefreshToken(sys *types.SystemContext, key string, token RefreshToken) (CredentialWriteResult, error) {
+	return storeCredentials(sys, key, "<token>", token.Token, dockerAuthConfig{
+		IdentityToken: token.Token,
+		TokenEndpoint: token.Endpoint,
+		TokenService:  token.Service,
+		TokenScopes:   token.Scopes,
+	})
+}
+
+// GetRefreshToken returns the refresh token stored for key, appropriate for sys and the users’ configuration,
+// if any; the returned RefreshToken.Token is "" if no refresh token is stored.
+// A valid key is a repository, a namespace within a registry, or a registry hostname.
+func GetRefreshToken(sys *types.SystemContext, key string) (RefreshToken, error) {
+	return getRefreshTokenWithHomeDir(sys, key, homedir.Get())
+}
+
+// getRefreshTokenWithHomeDir is an internal implementation detail of GetRefreshToken,
+// it exists only to allow testing it with an artificial home directory.
+func getRefreshTokenWithHomeDir(sys *types.SystemContext, key, homeDir string) (RefreshToken, error) {
+	creds, _, err := getCredentialsAndSource(context.Background(), sys, key, homeDir, nil, false)
+	if err != nil {
+		return RefreshToken{}, err
+	}
+	if creds.identityToken.IsEmpty() {
+		return RefreshToken{}, nil
+	}
+	return RefreshToken{
+		Token:    creds.identityToken.Reveal(),
+		Endpoint: creds.tokenEndpoint,
+		Service:  creds.tokenService,
+		Scopes:   creds.tokenScopes,
+	}, nil
+}
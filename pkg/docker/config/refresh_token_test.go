@@ -0,0 +1,67 @@
+package config
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/containers/image/v5/types"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestRefreshToken(t *testing.T) {
+	tmpDir := t.TempDir()
+	authFilePath := filepath.Join(tmpDir, "auth.json")
+	sys := &types.SystemContext{
+		SystemRegistriesConfPath:    filepath.Join("testdata", "cred-helper-with-auth-files.conf"),
+		SystemRegistriesConfDirPath: filepath.Join("testdata", "IdoNotExist"),
+		AuthFilePath:                authFilePath,
+		CredentialHelpers:           []string{"containers-auth.json"},
+	}
+
+	// Nothing stored
+	token, err := getRefreshTokenWithHomeDir(sys, "example.com", tmpDir)
+	require.NoError(t, err)
+	assert.Equal(t, RefreshToken{}, token)
+
+	stored := RefreshToken{
+		Token:    "refresh-token",
+		Endpoint: "https://auth.example.com/token",
+		Service:  "example.com",
+		Scopes:   []string{"repository:ns/repo:pull"},
+	}
+	res, err := StoreRefreshToken(sys, "example.com", stored)
+	require.NoError(t, err)
+	assert.Equal(t, CredentialWriteResult{Backend: CredentialBackendAuthFile, Path: authFilePath, Key: "example.com"}, res)
+
+	token, err = getRefreshTokenWithHomeDir(sys, "example.com", tmpDir)
+	require.NoError(t, err)
+	assert.Equal(t, stored, token)
+	// The token is also visible as an identity token
+	creds, err := getCredentialsWithHomeDir(sys, "example.com", tmpDir)
+	require.NoError(t, err)
+	assert.Equal(t, types.DockerAuthConfig{IdentityToken: "refresh-token"}, creds)
+
+	// Replacing the token
+	stored = RefreshToken{Token: "rotated-token"}
+	_, err = StoreRefreshToken(sys, "example.com", stored)
+	require.NoError(t, err)
+	token, err = getRefreshTokenWithHomeDir(sys, "example.com", tmpDir)
+	require.NoError(t, err)
+	assert.Equal(t, stored, token)
+
+	// Username/password credentials are not refresh tokens
+	_, err = StoreCredentials(sys, "example.com", "user", "pass")
+	require.NoError(t, err)
+	token, err = getRefreshTokenWithHomeDir(sys, "example.com", tmpDir)
+	require.NoError(t, err)
+	assert.Equal(t, RefreshToken{}, token)
+
+	// Auth files written by other tools, containing both auth and identitytoken
+	err = os.WriteFile(authFilePath, []byte(`{"auths":{"example.com":{"auth":"dXNlcjpwYXNz","identitytoken":"other-token"}}}`), 0600)
+	require.NoError(t, err)
+	token, err = getRefreshTokenWithHomeDir(sys, "example.com", tmpDir)
+	require.NoError(t, err)
+	assert.Equal(t, RefreshToken{Token: "other-token"}, token)
+}
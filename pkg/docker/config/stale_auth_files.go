@@ -0,0 +1,121 @@
+package config
+
+import (
+	"os"
+	"os/user"
+	"path/filepath"
+	"runtime"
+	"sort"
+	"strconv"
+	"time"
+
+	"github.com/containers/image/v5/types"
+	"github.com/pkg/errors"
+	"github.com/sirupsen/logrus"
+)
+
+// StaleAuthFileReason describes why a per-UID runtime auth file is considered stale.
+type StaleAuthFileReason string
+
+const (
+	// StaleAuthFileUnknownUser means the UID owning the directory does not correspond to any known user.
+	StaleAuthFileUnknownUser StaleAuthFileReason = "unknown user"
+	// StaleAuthFileExpired means the file has not been modified for longer than StaleAuthFileOptions.MaxAge.
+	StaleAuthFileExpired StaleAuthFileReason = "expired"
+)
+
+// StaleAuthFile describes a per-UID runtime auth file (/run/containers/$UID/auth.json)
+// found by CleanupStaleAuthFiles.
+type StaleAuthFile struct {
+	Path    string
+	UID     int
+	ModTime time.Time
+	Reason  StaleAuthFileReason
+	Removed bool // true if the file was removed; always false in dry-run mode
+}
+
+// StaleAuthFileOptions parameterizes CleanupStaleAuthFiles.
+type StaleAuthFileOptions struct {
+	// If > 0, files not modified within MaxAge are considered stale, even if their owner still exists.
+	MaxAge time.Duration
+	// If true, stale files are only reported, not removed.
+	DryRun bool
+}
+
+// CleanupStaleAuthFiles locates per-UID runtime auth files (/run/containers/$UID/auth.json,
+// prefixed by sys.RootForImplicitAbsolutePaths if set), and removes those which are stale:
+// files belonging to UIDs which no longer correspond to a user, and, if options.MaxAge is set,
+// files which have not been modified for longer than options.MaxAge.
+// It returns the stale files, sorted by path; with options.DryRun, nothing is removed.
+//
+// Callers typically need to be privileged to access files of other users;
+// files which can't be accessed are skipped and logged.
+func CleanupStaleAuthFiles(sys *types.SystemContext, options StaleAuthFileOptions) ([]StaleAuthFile, error) {
+	return cleanupStaleAuthFilesAt(sys, options, time.Now())
+}
+
+// cleanupStaleAuthFilesAt is an internal implementation detail of CleanupStaleAuthFiles,
+// it exists only to allow testing it with an artificial current time.
+func cleanupStaleAuthFilesAt(sys *types.SystemContext, options StaleAuthFileOptions, now time.Time) ([]StaleAuthFile, error) {
+	pattern := perUIDAuthFileGlob(sys)
+	matches, err := filepath.Glob(pattern)
+	if err != nil {
+		return nil, errors.Wrapf(err, "listing %q", pattern)
+	}
+	sort.Strings(matches)
+
+	res := []StaleAuthFile{}
+	for _, path := range matches {
+		uid, err := strconv.Atoi(filepath.Base(filepath.Dir(path)))
+		if err != nil {
+			continue // Not a per-UID directory
+		}
+		fi, err := os.Lstat(path)
+		if err != nil {
+			logrus.Debugf("Skipping %s: %v", path, err)
+			continue
+		}
+		if !fi.Mode().IsRegular() {
+			continue
+		}
+
+		var reason StaleAuthFileReason
+		if _, err := user.LookupId(strconv.Itoa(uid)); err != nil {
+			if _, ok := err.(user.UnknownUserIdError); !ok {
+				logrus.Debugf("Skipping %s: looking up UID %d: %v", path, uid, err)
+				continue
+			}
+			reason = StaleAuthFileUnknownUser
+		} else if options.MaxAge > 0 && now.Sub(fi.ModTime()) > options.MaxAge {
+			reason = StaleAuthFileExpired
+		} else {
+			continue
+		}
+
+		stale := StaleAuthFile{
+			Path:    path,
+			UID:     uid,
+			ModTime: fi.ModTime(),
+			Reason:  reason,
+		}
+		if !options.DryRun {
+			if err := os.Remove(path); err != nil && !os.IsNotExist(err) {
+				return res, errors.Wrapf(err, "removing stale auth file %q", path)
+			}
+			stale.Removed = true
+			logrus.Debugf("Removed stale auth file %s (%s)", path, reason)
+		}
+		res = append(res, stale)
+	}
+	return res, nil
+}
+
+// perUIDAuthFileGlob returns a filepath.Glob pattern matching all per-UID runtime auth files.
+func perUIDAuthFileGlob(sys *types.SystemContext) string {
+	format := perUIDPathFormat(runtime.GOOS)
+	pattern := filepath.Join(filepath.Dir(filepath.Dir(format)), "*", filepath.Base(format))
+	if sys != nil && sys.RootForImplicitAbsolutePaths != "" {
+		pattern = filepath.Join(sys.RootForImplicitAbsolutePaths, pattern)
+	}
+	return pattern
+}
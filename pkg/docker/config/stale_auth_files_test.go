@@ -0,0 +1,69 @@
+package config
+
+import (
+	"os"
+	"path/filepath"
+	"strconv"
+	"testing"
+	"time"
+
+	"github.com/containers/image/v5/types"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestCleanupStaleAuthFiles(t *testing.T) {
+	root := t.TempDir()
+	sys := &types.SystemContext{RootForImplicitAbsolutePaths: root}
+	now := time.Now()
+
+	const unknownUID = 1999999999
+	writeAuthFile := func(uid int, age time.Duration) string {
+		path := filepath.Join(root, "run", "containers", strconv.Itoa(uid), "auth.json")
+		require.NoError(t, os.MkdirAll(filepath.Dir(path), 0700))
+		require.NoError(t, os.WriteFile(path, []byte(`{"auths":{}}`), 0600))
+		mtime := now.Add(-age)
+		require.NoError(t, os.Chtimes(path, mtime, mtime))
+		return path
+	}
+	ownPath := writeAuthFile(os.Getuid(), 2*time.Hour)
+	orphanPath := writeAuthFile(unknownUID, time.Minute)
+	// Non-UID directories are ignored.
+	require.NoError(t, os.MkdirAll(filepath.Join(root, "run", "containers", "storage"), 0700))
+	require.NoError(t, os.WriteFile(filepath.Join(root, "run", "containers", "storage", "auth.json"), []byte{}, 0600))
+
+	// Dry run without MaxAge: only the orphaned file is reported, nothing is removed.
+	stale, err := cleanupStaleAuthFilesAt(sys, StaleAuthFileOptions{DryRun: true}, now)
+	require.NoError(t, err)
+	require.Len(t, stale, 1)
+	assert.Equal(t, orphanPath, stale[0].Path)
+	assert.Equal(t, unknownUID, stale[0].UID)
+	assert.Equal(t, StaleAuthFileUnknownUser, stale[0].Reason)
+	assert.False(t, stale[0].Removed)
+	assert.FileExists(t, orphanPath)
+
+	// Dry run with MaxAge: the old file of an existing user is reported as well.
+	stale, err = cleanupStaleAuthFilesAt(sys, StaleAuthFileOptions{MaxAge: time.Hour, DryRun: true}, now)
+	require.NoError(t, err)
+	require.Len(t, stale, 2)
+	byPath := map[string]StaleAuthFile{}
+	for _, s := range stale {
+		byPath[s.Path] = s
+	}
+	assert.Equal(t, StaleAuthFileExpired, byPath[ownPath].Reason)
+	assert.Equal(t, StaleAuthFileUnknownUser, byPath[orphanPath].Reason)
+	assert.FileExists(t, ownPath)
+
+	// Actual removal.
+	stale, err = cleanupStaleAuthFilesAt(sys, StaleAuthFileOptions{MaxAge: 3 * time.Hour}, now)
+	require.NoError(t, err)
+	require.Len(t, stale, 1)
+	assert.True(t, stale[0].Removed)
+	assert.NoFileExists(t, orphanPath)
+	assert.FileExists(t, ownPath)
+
+	// No per-UID directory at all.
+	stale, err = cleanupStaleAuthFilesAt(&types.SystemContext{RootForImplicitAbsolutePaths: t.TempDir()}, StaleAuthFileOptions{}, now)
+	require.NoError(t, err)
+	assert.Empty(t, stale)
+}
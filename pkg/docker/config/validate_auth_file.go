@@ -0,0 +1,155 @@
+package config
+
+import (
+	"encoding/base64"
+	"encoding/json"
+	"fmt"
+	"os"
+	"sort"
+	"strings"
+
+	"github.com/pkg/errors"
+	exec "golang.org/x/sys/execabs"
+)
+
+// AuthFileFindingKind identifies the kind of a problem found by ValidateAuthFile.
+type AuthFileFindingKind string
+
+const (
+	// AuthFileMalformedAuth means the "auth" field of an entry is not a base64-encoded "username:password" pair.
+	AuthFileMalformedAuth AuthFileFindingKind = "malformed-auth"
+	// AuthFileKeyWithScheme means a key contains a http:// or https:// prefix.
+	AuthFileKeyWithScheme AuthFileFindingKind = "key-with-scheme"
+	// AuthFileDuplicateKey means several keys normalize to the same registry, so only one of them is used.
+	AuthFileDuplicateKey AuthFileFindingKind = "duplicate-key"
+	// AuthFileMissingCredentialHelper means a credential helper referenced in credHelpers is not installed.
+	AuthFileMissingCredentialHelper AuthFileFindingKind = "missing-credential-helper"
+	// AuthFileLegacyFormat means the file uses the legacy (~/.dockercfg) format, without an "auths" object.
+	AuthFileLegacyFormat AuthFileFindingKind = "legacy-format"
+	// AuthFileIncompleteClientCertificate means an entry specifies only one of "clientcert" and "clientkey".
+	AuthFileIncompleteClientCertificate AuthFileFindingKind = "incomplete-client-certificate"
+)
+
+// AuthFileFinding is a single problem found by ValidateAuthFile.
+type AuthFileFinding struct {
+	Kind AuthFileFindingKind
+	// Key is the affected key in "auths" or "credHelpers", or "" if the finding applies to the whole file.
+	Key     string
+	Message string
+}
+
+// ValidateAuthFile parses the auth file at path, in either the current or the legacy format,
+// and returns problems found in its contents, sorted by key.
+// It returns an error only if the file can't be read or is not a valid auth file at all.
+func ValidateAuthFile(path string) ([]AuthFileFinding, error) {
+	raw, err := os.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	format := authFileFormatForPath(path)
+	raw, err = format.toJSON(raw)
+	if err != nil {
+		return nil, errors.Wrapf(err, "unmarshaling %s at %q", format.name, path)
+	}
+	var topLevel map[string]json.RawMessage
+	if err := json.Unmarshal(raw, &topLevel); err != nil {
+		return nil, errors.Wrapf(err, "unmarshaling %s at %q", format.name, path)
+	}
+	legacyFormat := isLegacyAuthFile(topLevel)
+
+	auths, err := readJSONFile(path, legacyFormat)
+	if err != nil {
+		return nil, err
+	}
+
+	findings := []AuthFileFinding{}
+	if legacyFormat {
+		findings = append(findings, AuthFileFinding{
+			Kind:    AuthFileLegacyFormat,
+			Message: `the file uses the legacy format; entries should be moved into an "auths" object`,
+		})
+	}
+
+	registries := map[string][]string{} // normalized registry -> keys
+	for key, conf := range auths.AuthConfigs {
+		// Keys with a scheme are normal in the legacy format.
+		if !legacyFormat && (strings.HasPrefix(key, "http://") || strings.HasPrefix(key, "https://")) {
+			findings = append(findings, AuthFileFinding{
+				Kind:    AuthFileKeyWithScheme,
+				Key:     key,
+				Message: fmt.Sprintf("key %s contains a http[s]:// prefix", key),
+			})
+		}
+		if conf.Auth != "" {
+			if decoded, err := base64.StdEncoding.DecodeString(conf.Auth); err != nil {
+				findings = append(findings, AuthFileFinding{
+					Kind:    AuthFileMalformedAuth,
+					Key:     key,
+					Message: fmt.Sprintf("auth field of %s is not valid base64: %v", key, err),
+				})
+			} else if !strings.Contains(string(decoded), ":") {
+				findings = append(findings, AuthFileFinding{
+					Kind:    AuthFileMalformedAuth,
+					Key:     key,
+					Message: fmt.Sprintf("auth field of %s does not contain a username:password pair", key),
+				})
+			}
+		}
+		if (conf.ClientCert == "") != (conf.ClientKey == "") {
+			findings = append(findings, AuthFileFinding{
+				Kind:    AuthFileIncompleteClientCertificate,
+				Key:     key,
+				Message: fmt.Sprintf("%s specifies only one of clientcert and clientkey", key),
+			})
+		}
+		registry := normalizeAuthFileKey(key, legacyFormat)
+		registries[registry] = append(registries[registry], key)
+	}
+	for registry, keys := range registries {
+		if len(keys) < 2 {
+			continue
+		}
+		sort.Strings(keys)
+		findings = append(findings, AuthFileFinding{
+			Kind:    AuthFileDuplicateKey,
+			Key:     keys[0],
+			Message: fmt.Sprintf("keys %s all refer to %s, only one of them is used", strings.Join(keys, ", "), registry),
+		})
+	}
+
+	for key, helper := range auths.CredHelpers {
+		if _, err := exec.LookPath("docker-credential-" + helper); err != nil {
+			findings = append(findings, AuthFileFinding{
+				Kind:    AuthFileMissingCredentialHelper,
+				Key:     key,
+				Message: fmt.Sprintf("credential helper docker-credential-%s used for %s is not available: %v", helper, key, err),
+			})
+		}
+	}
+
+	sort.SliceStable(findings, func(i, j int) bool {
+		if findings[i].Key != findings[j].Key {
+			return findings[i].Key < findings[j].Key
+		}
+		return findings[i].Kind < findings[j].Kind
+	})
+	return findings, nil
+}
+
+// isLegacyAuthFile returns true if topLevel, the top-level object of an auth file, uses the legacy format,
+// i.e. it is not empty, and all its values are objects with an "auth" field.
+func isLegacyAuthFile(topLevel map[string]json.RawMessage) bool {
+	if len(topLevel) == 0 {
+		return false
+	}
+	for _, value := range topLevel {
+		var entry map[string]json.RawMessage
+		if err := json.Unmarshal(value, &entry); err != nil {
+			return false
+		}
+		if _, ok := entry["auth"]; !ok {
+			return false
+		}
+	}
+	return true
+}
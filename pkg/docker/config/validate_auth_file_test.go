@@ -0,0 +1,76 @@
+package config
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestValidateAuthFile(t *testing.T) {
+	// Valid files
+	for _, path := range []string{
+		filepath.Join("testdata", "example.json"),
+		filepath.Join("testdata", "refpath.json"),
+		filepath.Join("testdata", "empty.json"),
+	} {
+		findings, err := ValidateAuthFile(path)
+		require.NoError(t, err, path)
+		assert.Empty(t, findings, path)
+	}
+
+	// Legacy format
+	findings, err := ValidateAuthFile(filepath.Join("testdata", "legacy.json"))
+	require.NoError(t, err)
+	require.Len(t, findings, 1)
+	assert.Equal(t, AuthFileLegacyFormat, findings[0].Kind)
+	assert.Equal(t, "", findings[0].Key)
+
+	// Various problems
+	path := filepath.Join(t.TempDir(), "auth.json")
+	err = os.WriteFile(path, []byte(`{
+		"auths": {
+			"docker.io": {"auth": "dXNlcjpwYXNz"},
+			"index.docker.io": {"auth": "dXNlcjpwYXNz"},
+			"https://quay.io": {"auth": "dXNlcjpwYXNz"},
+			"bad-base64.com": {"auth": "!!!"},
+			"no-colon.com": {"auth": "dXNlcg=="},
+			"token.com": {"identitytoken": "some token"},
+			"cert.com": {"clientcert": "client.cert", "clientkey": "client.key"},
+			"cert-only.com": {"clientcert": "client.cert"}
+		},
+		"credHelpers": {
+			"helper.com": "this-helper-does-not-exist"
+		}
+	}`), 0600)
+	require.NoError(t, err)
+	findings, err = ValidateAuthFile(path)
+	require.NoError(t, err)
+	type kindAndKey struct {
+		kind AuthFileFindingKind
+		key  string
+	}
+	res := []kindAndKey{}
+	for _, f := range findings {
+		assert.NotEmpty(t, f.Message)
+		res = append(res, kindAndKey{f.Kind, f.Key})
+	}
+	assert.Equal(t, []kindAndKey{
+		{AuthFileMalformedAuth, "bad-base64.com"},
+		{AuthFileIncompleteClientCertificate, "cert-only.com"},
+		{AuthFileDuplicateKey, "docker.io"},
+		{AuthFileMissingCredentialHelper, "helper.com"},
+		{AuthFileKeyWithScheme, "https://quay.io"},
+		{AuthFileMalformedAuth, "no-colon.com"},
+	}, res)
+
+	// Files which can't be validated at all
+	_, err = ValidateAuthFile(filepath.Join("testdata", "this-does-not-exist.json"))
+	assert.Error(t, err)
+	err = os.WriteFile(path, []byte("not JSON"), 0600)
+	require.NoError(t, err)
+	_, err = ValidateAuthFile(path)
+	assert.Error(t, err)
+}
@@ -0,0 +1,238 @@
+// Package distribution is a low-level client for the registry HTTP API (the OCI distribution specification,
+// and the Docker Registry HTTP API V2).
+//
+// It uses the same HTTP client as the docker transport, including the TLS configuration, authentication
+// (credential lookup and the bearer token handshake) and retries on registry maintenance, but it does not
+// model images, and it does not use registry mirrors; it is intended for operations which the docker transport
+// does not provide.
+// Most users should use the docker transport instead.
+package distribution
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"mime"
+	"net/http"
+	"net/url"
+	"strings"
+
+	_ "github.com/containers/image/v5/docker" // Registers the implementation of distributionclient.Client
+	"github.com/containers/image/v5/internal/distributionclient"
+	"github.com/containers/image/v5/internal/iolimits"
+	"github.com/containers/image/v5/manifest"
+	"github.com/containers/image/v5/types"
+	"github.com/opencontainers/go-digest"
+	"github.com/pkg/errors"
+)
+
+// Scope is an authorization scope requested for a single request, in addition to those required by the client.
+type Scope struct {
+	// Repository is the repository name, without the registry host name, e.g. "library/busybox".
+	Repository string
+	// Actions are the requested actions, comma-separated, e.g. "pull" or "pull,push".
+	Actions string
+}
+
+// Client is a client for a single registry.
+// It is safe for concurrent use.
+type Client struct {
+	registry string
+	c        distributionclient.Client
+}
+
+// NewClient returns a Client for registry (a host[:port]), using credentials and options from sys.
+// No network requests are made until the client is used.
+func NewClient(sys *types.SystemContext, registry string) (*Client, error) {
+	newClient := distributionclient.Get()
+	if newClient == nil {
+		return nil, errors.New("Internal error: the docker transport registry client is not available")
+	}
+	c, err := newClient(sys, registry)
+	if err != nil {
+		return nil, err
+	}
+	return &Client{registry: registry, c: c}, nil
+}
+
+// Registry returns the registry the client accesses.
+func (c *Client) Registry() string {
+	return c.registry
+}
+
+// Do performs a request for path (which usually starts with /v2/, and may contain a query) on the registry.
+// It detects the URL scheme, and authenticates the request, obtaining a bearer token for scope (if not nil) if necessary.
+// The caller must close the body of the returned response; unsuccessful HTTP status codes are not reported as errors,
+// use CheckResponse for that.
+func (c *Client) Do(ctx context.Context, method, path string, headers http.Header, body io.Reader, scope *Scope) (*http.Response, error) {
+	var s *distributionclient.Scope
+	if scope != nil {
+		s = &distributionclient.Scope{Repository: scope.Repository, Actions: scope.Actions}
+	}
+	return c.c.Do(ctx, method, path, headers, body, s)
+}
+
+// CheckResponse returns an error describing res, prefixed with context, if the request was unsuccessful; nil otherwise.
+// It uses the error types of the docker transport, e.g. docker.ErrUnauthorizedForCredentials or docker.ErrRegistryMaintenance.
+func (c *Client) CheckResponse(res *http.Response, context string) error {
+	return c.c.ResponseToError(res, context)
+}
+
+// GetManifest returns the manifest tagOrDigest in repository, and its MIME type (which may be "" if it can't be determined).
+// acceptedMIMETypes are sent in the Accept header; if empty, all manifest types supported by this library are accepted.
+// If tagOrDigest is a digest, the returned manifest is verified to match it.
+func (c *Client) GetManifest(ctx context.Context, repository, tagOrDigest string, acceptedMIMETypes []string) ([]byte, string, error) {
+	if len(acceptedMIMETypes) == 0 {
+		acceptedMIMETypes = manifest.DefaultRequestedManifestMIMETypes
+	}
+	path := fmt.Sprintf("/v2/%s/manifests/%s", repository, tagOrDigest)
+	res, err := c.Do(ctx, http.MethodGet, path, http.Header{"Accept": acceptedMIMETypes}, nil, &Scope{Repository: repository, Actions: "pull"})
+	if err != nil {
+		return nil, "", err
+	}
+	defer res.Body.Close()
+	if err := c.CheckResponse(res, fmt.Sprintf("reading manifest %s in %s/%s", tagOrDigest, c.registry, repository)); err != nil {
+		return nil, "", err
+	}
+	manblob, err := iolimits.ReadAtMost(res.Body, iolimits.MaxManifestBodySize)
+	if err != nil {
+		return nil, "", err
+	}
+	if expected, err := digest.Parse(tagOrDigest); err == nil {
+		matches, err := manifest.MatchesDigest(manblob, expected)
+		if err != nil {
+			return nil, "", errors.Wrapf(err, "computing manifest digest")
+		}
+		if !matches {
+			return nil, "", errors.Errorf("Manifest %s in %s/%s does not match its digest", tagOrDigest, c.registry, repository)
+		}
+	}
+	mimeType, _, err := mime.ParseMediaType(res.Header.Get("Content-Type"))
+	if err != nil {
+		mimeType = ""
+	}
+	return manblob, mimeType, nil
+}
+
+// GetBlob returns a stream for reading the blob with digest d in repository, and its size (-1 if unknown).
+// The caller must close the stream.  The data is not verified against d; the caller is responsible for doing that.
+func (c *Client) GetBlob(ctx context.Context, repository string, d digest.Digest) (io.ReadCloser, int64, error) {
+	path := fmt.Sprintf("/v2/%s/blobs/%s", repository, d.String())
+	res, err := c.Do(ctx, http.MethodGet, path, nil, nil, &Scope{Repository: repository, Actions: "pull"})
+	if err != nil {
+		return nil, -1, err
+	}
+	if err := c.CheckResponse(res, fmt.Sprintf("fetching blob %s in %s/%s", d.String(), c.registry, repository)); err != nil {
+		res.Body.Close()
+		return nil, -1, err
+	}
+	return res.Body, res.ContentLength, nil
+}
+
+// HasBlob returns true, and the blob size (-1 if unknown), if a blob with digest d exists in repository.
+func (c *Client) HasBlob(ctx context.Context, repository string, d digest.Digest) (bool, int64, error) {
+	path := fmt.Sprintf("/v2/%s/blobs/%s", repository, d.String())
+	res, err := c.Do(ctx, http.MethodHead, path, nil, nil, &Scope{Repository: repository, Actions: "pull"})
+	if err != nil {
+		return false, -1, err
+	}
+	defer res.Body.Close()
+	if res.StatusCode == http.StatusNotFound {
+		return false, -1, nil
+	}
+	if err := c.CheckResponse(res, fmt.Sprintf("checking whether blob %s exists in %s/%s", d.String(), c.registry, repository)); err != nil {
+		return false, -1, err
+	}
+	return true, res.ContentLength, nil
+}
+
+// ListTags returns all tags in repository, following pagination.
+func (c *Client) ListTags(ctx context.Context, repository string) ([]string, error) {
+	tags := []string{}
+	err := c.getAllPages(ctx, fmt.Sprintf("/v2/%s/tags/list", repository), &Scope{Repository: repository, Actions: "pull"},
+		fmt.Sprintf("listing tags in %s/%s", c.registry, repository), func(body []byte) error {
+			var page struct {
+				Tags []string `json:"tags"`
+			}
+			if err := json.Unmarshal(body, &page); err != nil {
+				return err
+			}
+			tags = append(tags, page.Tags...)
+			return nil
+		})
+	if err != nil {
+		return nil, err
+	}
+	return tags, nil
+}
+
+// Catalog returns the names of all repositories in the registry, following pagination.
+// Many registries restrict, or do not implement, this operation.
+func (c *Client) Catalog(ctx context.Context) ([]string, error) {
+	repositories := []string{}
+	err := c.getAllPages(ctx, "/v2/_catalog", nil, fmt.Sprintf("listing repositories in %s", c.registry), func(body []byte) error {
+		var page struct {
+			Repositories []string `json:"repositories"`
+		}
+		if err := json.Unmarshal(body, &page); err != nil {
+			return err
+		}
+		repositories = append(repositories, page.Repositories...)
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+	return repositories, nil
+}
+
+// getAllPages reads path and all following pages, calling handlePage for each response body.
+func (c *Client) getAllPages(ctx context.Context, path string, scope *Scope, context string, handlePage func(body []byte) error) error {
+	for path != "" {
+		nextPath, err := func() (string, error) { // A scope for defer
+			res, err := c.Do(ctx, http.MethodGet, path, nil, nil, scope)
+			if err != nil {
+				return "", err
+			}
+			defer res.Body.Close()
+			if err := c.CheckResponse(res, context); err != nil {
+				return "", err
+			}
+			body, err := iolimits.ReadAtMost(res.Body, iolimits.MaxListPageBodySize)
+			if err != nil {
+				return "", err
+			}
+			if err := handlePage(body); err != nil {
+				return "", errors.Wrap(err, context)
+			}
+			return NextPagePath(res)
+		}()
+		if err != nil {
+			return err
+		}
+		path = nextPath
+	}
+	return nil
+}
+
+// NextPagePath returns the path (with a query) of the next page of a paginated response res,
+// as specified by its Link header, or "" if res is the last page.
+func NextPagePath(res *http.Response) (string, error) {
+	link := res.Header.Get("Link")
+	if link == "" {
+		return "", nil
+	}
+	linkURLStr := strings.Trim(strings.TrimSpace(strings.Split(link, ";")[0]), "<>")
+	linkURL, err := url.Parse(linkURLStr)
+	if err != nil {
+		return "", errors.Wrapf(err, "parsing Link header %q", link)
+	}
+	// The link can be relative or absolute, but we only want the path: the next page is
+	// expected to be on the same registry.
+	path := linkURL.Path
+	if linkURL.RawQuery != "" {
+		path += "?" + linkURL.RawQuery
+	}
+	return path, nil
+}
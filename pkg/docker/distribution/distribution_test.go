@@ -0,0 +1,140 @@
+package distribution
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/containers/image/v5/types"
+	"github.com/opencontainers/go-digest"
+	imgspecv1 "github.com/opencontainers/image-spec/specs-go/v1"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestClient(t *testing.T) {
+	manifestBlob := []byte(`{"schemaVersion":2,"mediaType":"application/vnd.oci.image.manifest.v1+json"}`)
+	manifestDigest := digest.FromBytes(manifestBlob)
+	blob := []byte("blob contents")
+	blobDigest := digest.FromBytes(blob)
+
+	var acceptHeader []string
+	s := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		switch {
+		case r.URL.Path == "/v2/":
+			w.WriteHeader(http.StatusOK)
+		case r.URL.Path == "/v2/ns/repo/manifests/latest" || r.URL.Path == "/v2/ns/repo/manifests/"+manifestDigest.String():
+			acceptHeader = r.Header["Accept"]
+			w.Header().Set("Content-Type", imgspecv1.MediaTypeImageManifest+"; charset=utf-8")
+			_, _ = w.Write(manifestBlob)
+		case r.URL.Path == "/v2/ns/repo/manifests/"+blobDigest.String():
+			_, _ = w.Write(manifestBlob) // Does not match the requested digest
+		case r.URL.Path == "/v2/ns/repo/blobs/"+blobDigest.String():
+			w.Header().Set("Content-Length", fmt.Sprintf("%d", len(blob)))
+			if r.Method == http.MethodGet {
+				_, _ = w.Write(blob)
+			}
+		case r.URL.Path == "/v2/ns/repo/tags/list":
+			if r.URL.Query().Get("last") == "" {
+				w.Header().Set("Link", `</v2/ns/repo/tags/list?last=b&n=2>; rel="next"`)
+				fmt.Fprint(w, `{"name":"ns/repo","tags":["a","b"]}`)
+			} else {
+				fmt.Fprint(w, `{"name":"ns/repo","tags":["c"]}`)
+			}
+		case r.URL.Path == "/v2/_catalog":
+			fmt.Fprint(w, `{"repositories":["ns/repo","other"]}`)
+		case r.URL.Path == "/v2/broken/tags/list":
+			w.WriteHeader(http.StatusInternalServerError)
+		default:
+			w.WriteHeader(http.StatusNotFound)
+		}
+	}))
+	defer s.Close()
+	registry := strings.TrimPrefix(s.URL, "http://")
+	// For this test against localhost, we don't care.
+	sys := &types.SystemContext{
+		DockerInsecureSkipTLSVerify: types.OptionalBoolTrue,
+		DockerDisableV1Ping:         true,
+		AuthFilePath:                filepath.Join(t.TempDir(), "auth.json"),
+	}
+	ctx := context.Background()
+
+	c, err := NewClient(sys, registry)
+	require.NoError(t, err)
+	assert.Equal(t, registry, c.Registry())
+
+	// GetManifest
+	man, mimeType, err := c.GetManifest(ctx, "ns/repo", "latest", nil)
+	require.NoError(t, err)
+	assert.Equal(t, manifestBlob, man)
+	assert.Equal(t, imgspecv1.MediaTypeImageManifest, mimeType)
+	assert.Contains(t, acceptHeader, imgspecv1.MediaTypeImageManifest)
+	_, _, err = c.GetManifest(ctx, "ns/repo", manifestDigest.String(), []string{imgspecv1.MediaTypeImageManifest})
+	require.NoError(t, err)
+	assert.Equal(t, []string{imgspecv1.MediaTypeImageManifest}, acceptHeader)
+	_, _, err = c.GetManifest(ctx, "ns/repo", blobDigest.String(), nil)
+	assert.Error(t, err)
+	_, _, err = c.GetManifest(ctx, "ns/repo", "missing", nil)
+	assert.Error(t, err)
+
+	// GetBlob, HasBlob
+	stream, size, err := c.GetBlob(ctx, "ns/repo", blobDigest)
+	require.NoError(t, err)
+	contents, err := io.ReadAll(stream)
+	stream.Close()
+	require.NoError(t, err)
+	assert.Equal(t, blob, contents)
+	assert.Equal(t, int64(len(blob)), size)
+	_, _, err = c.GetBlob(ctx, "ns/repo", manifestDigest)
+	assert.Error(t, err)
+	exists, size, err := c.HasBlob(ctx, "ns/repo", blobDigest)
+	require.NoError(t, err)
+	assert.True(t, exists)
+	assert.Equal(t, int64(len(blob)), size)
+	exists, _, err = c.HasBlob(ctx, "ns/repo", manifestDigest)
+	require.NoError(t, err)
+	assert.False(t, exists)
+
+	// ListTags, Catalog
+	tags, err := c.ListTags(ctx, "ns/repo")
+	require.NoError(t, err)
+	assert.Equal(t, []string{"a", "b", "c"}, tags)
+	_, err = c.ListTags(ctx, "broken")
+	assert.Error(t, err)
+	repos, err := c.Catalog(ctx)
+	require.NoError(t, err)
+	assert.Equal(t, []string{"ns/repo", "other"}, repos)
+
+	// Do, CheckResponse
+	res, err := c.Do(ctx, http.MethodGet, "/v2/broken/tags/list", nil, nil, &Scope{Repository: "broken", Actions: "pull"})
+	require.NoError(t, err)
+	defer res.Body.Close()
+	assert.Equal(t, http.StatusInternalServerError, res.StatusCode)
+	assert.Error(t, c.CheckResponse(res, "testing"))
+}
+
+func TestNextPagePath(t *testing.T) {
+	for _, c := range []struct{ link, expected string }{
+		{"", ""},
+		{`</v2/ns/repo/tags/list?last=b&n=2>; rel="next"`, "/v2/ns/repo/tags/list?last=b&n=2"},
+		{`<https://registry.example.com/v2/_catalog?last=x>; rel="next"`, "/v2/_catalog?last=x"},
+		{`</v2/_catalog>`, "/v2/_catalog"},
+	} {
+		res := &http.Response{Header: http.Header{}}
+		if c.link != "" {
+			res.Header.Set("Link", c.link)
+		}
+		path, err := NextPagePath(res)
+		require.NoError(t, err, c.link)
+		assert.Equal(t, c.expected, path, c.link)
+	}
+
+	res := &http.Response{Header: http.Header{"Link": []string{"<:invalid>; rel=\"next\""}}}
+	_, err := NextPagePath(res)
+	assert.Error(t, err)
+}
@@ -0,0 +1,379 @@
+// Package layertoc reads and verifies the tables of contents (TOCs) of zstd:chunked and eStargz layers, which allow
+// pulling individual files of a layer without reading the whole blob, e.g. for tools implementing lazy pulling.
+//
+// The position and digest of a TOC are declared in annotations of the layer descriptor, see manifest.LayerTOC.
+// Read locates the TOC in a blob and verifies that it matches the declared digest; VerifyChunks verifies that
+// the file contents referenced by the TOC match the digests recorded in the TOC.
+package layertoc
+
+import (
+	"archive/tar"
+	"bytes"
+	"compress/gzip"
+	"encoding/binary"
+	"encoding/json"
+	"fmt"
+	"io"
+	"strconv"
+	"time"
+
+	"github.com/containers/image/v5/manifest"
+	"github.com/klauspost/compress/zstd"
+	"github.com/opencontainers/go-digest"
+	"github.com/pkg/errors"
+)
+
+const (
+	// maxTOCSize is the maximum size of an (uncompressed) TOC we are willing to read.
+	maxTOCSize = 50 * 1024 * 1024
+
+	// zstdChunkedFooterSize is the size of the zstd:chunked footer data at the end of the blob.
+	zstdChunkedFooterSize = 40
+	// estargzFooterSize is the usual size of the gzip footer member of eStargz blobs (47 in the legacy format);
+	// estargzMinFooterSize is the smallest possible one (a gzip header with a 22-byte extra field, and a gzip trailer).
+	estargzFooterSize    = 51
+	estargzMinFooterSize = 10 + 2 + 22 + 8
+	// estargzTOCName is the name of the TOC in the tar stream of an eStargz blob.
+	estargzTOCName = "stargz.index.json"
+)
+
+var zstdChunkedFooterMagic = []byte{0x47, 0x6e, 0x55, 0x6c, 0x49, 0x6e, 0x55, 0x78}
+
+// Entry types used in TOCs.
+const (
+	TypeRegular  = "reg"
+	TypeChunk    = "chunk"
+	TypeHardlink = "hardlink"
+	TypeSymlink  = "symlink"
+	TypeDir      = "dir"
+	TypeChar     = "char"
+	TypeBlock    = "block"
+	TypeFifo     = "fifo"
+)
+
+// Entry is an entry of a TOC.  The JSON representation is shared by zstd:chunked and eStargz; some fields are only
+// used by one of the formats.
+type Entry struct {
+	Type     string `json:"type"`
+	Name     string `json:"name"`
+	Linkname string `json:"linkName,omitempty"`
+	Mode     int64  `json:"mode,omitempty"`
+	// Size is the size of the uncompressed file contents (for TypeRegular).
+	Size    int64      `json:"size,omitempty"`
+	UID     int        `json:"uid,omitempty"`
+	GID     int        `json:"gid,omitempty"`
+	ModTime *time.Time `json:"modtime,omitempty"`
+	// Digest is the digest of the uncompressed file contents (for TypeRegular).
+	Digest string `json:"digest,omitempty"`
+	// Offset is the offset in the blob of the compressed data containing the file contents (or, for chunked files, the chunk).
+	Offset int64 `json:"offset,omitempty"`
+	// EndOffset is the end offset in the blob of the compressed file contents (zstd:chunked only, and not always set).
+	EndOffset int64 `json:"endOffset,omitempty"`
+	// ChunkOffset is the offset of the chunk within the uncompressed file contents.
+	ChunkOffset int64 `json:"chunkOffset,omitempty"`
+	// ChunkSize is the size of the uncompressed chunk; 0 means the rest of the file.
+	ChunkSize   int64  `json:"chunkSize,omitempty"`
+	ChunkDigest string `json:"chunkDigest,omitempty"`
+	// ChunkType is "" for ordinary data, or "zeros" for chunks which only contain zeros (zstd:chunked only).
+	ChunkType string `json:"chunkType,omitempty"`
+}
+
+// TOC is the table of contents of a layer.
+type TOC struct {
+	Format  manifest.LayerTOCFormat `json:"-"`
+	Offset  int64                   `json:"-"` // The offset of the (compressed) TOC within the blob; all file contents precede it.
+	Version int                     `json:"version"`
+	Entries []Entry                 `json:"entries"`
+}
+
+// Chunk is a contiguous part of the contents of a regular file, stored as a separately-decompressible unit in the blob.
+type Chunk struct {
+	Name       string        // The file name
+	Offset     int64         // The offset of the compressed data in the blob
+	FileOffset int64         // The offset of the chunk within the uncompressed file
+	Size       int64         // The size of the uncompressed chunk
+	Digest     digest.Digest // The digest of the uncompressed chunk; "" if the TOC does not record it
+}
+
+// Chunks returns the chunks of all regular files in toc, in the order of the TOC.
+func (toc *TOC) Chunks() ([]Chunk, error) {
+	res := []Chunk{}
+	var fileSize int64
+	var fileName string
+	for i, e := range toc.Entries {
+		var c Chunk
+		switch e.Type {
+		case TypeRegular:
+			fileName = e.Name
+			fileSize = e.Size
+			if e.Size == 0 {
+				continue
+			}
+			c = Chunk{Name: e.Name, Offset: e.Offset, FileOffset: e.ChunkOffset, Size: e.ChunkSize}
+			switch {
+			case e.ChunkDigest != "":
+				c.Digest = digest.Digest(e.ChunkDigest)
+			case e.ChunkSize == 0 || e.ChunkSize == e.Size:
+				c.Digest = digest.Digest(e.Digest) // The only chunk
+			}
+		case TypeChunk:
+			if e.Name != fileName {
+				return nil, errors.Errorf("TOC entry %d: chunk of %q does not follow the file", i, e.Name)
+			}
+			c = Chunk{Name: e.Name, Offset: e.Offset, FileOffset: e.ChunkOffset, Size: e.ChunkSize, Digest: digest.Digest(e.ChunkDigest)}
+		default:
+			continue
+		}
+		if c.Size == 0 {
+			c.Size = fileSize - c.FileOffset
+		}
+		if c.FileOffset < 0 || c.Size <= 0 || c.FileOffset+c.Size > fileSize {
+			return nil, errors.Errorf("TOC entry %d: invalid chunk of %q at %d, size %d, in a file of size %d", i, e.Name, c.FileOffset, c.Size, fileSize)
+		}
+		if c.Offset <= 0 || c.Offset >= toc.Offset {
+			return nil, errors.Errorf("TOC entry %d: invalid blob offset %d of %q, the TOC starts at %d", i, c.Offset, e.Name, toc.Offset)
+		}
+		if c.Digest != "" {
+			if err := c.Digest.Validate(); err != nil {
+				return nil, errors.Wrapf(err, "TOC entry %d: invalid digest of %q", i, e.Name)
+			}
+		}
+		res = append(res, c)
+	}
+	return res, nil
+}
+
+// Read reads the TOC declared by declared (usually obtained from manifest.LayerTOCFromAnnotations) from blob,
+// which has blobSize bytes, and verifies it against the digest in declared.
+func Read(blob io.ReaderAt, blobSize int64, declared manifest.LayerTOC) (*TOC, error) {
+	var tocOffset int64
+	var tocJSON []byte
+	var err error
+	switch declared.Format {
+	case manifest.LayerTOCFormatZstdChunked:
+		tocOffset, tocJSON, err = readZstdChunkedTOC(blob, blobSize, declared)
+	case manifest.LayerTOCFormatEstargz:
+		tocOffset, tocJSON, err = readEstargzTOC(blob, blobSize, declared)
+	default:
+		return nil, errors.Errorf("layer does not declare a table of contents")
+	}
+	if err != nil {
+		return nil, err
+	}
+	res := TOC{}
+	if err := json.Unmarshal(tocJSON, &res); err != nil {
+		return nil, errors.Wrapf(err, "parsing %s table of contents", declared.Format)
+	}
+	res.Format = declared.Format
+	res.Offset = tocOffset
+	return &res, nil
+}
+
+// readZstdChunkedTOC returns the offset and the uncompressed contents of a zstd:chunked TOC declared by declared.
+func readZstdChunkedTOC(blob io.ReaderAt, blobSize int64, declared manifest.LayerTOC) (int64, []byte, error) {
+	position := declared.Position
+	if position == nil {
+		if blobSize < zstdChunkedFooterSize {
+			return -1, nil, errors.New("blob too small for a zstd:chunked footer")
+		}
+		footer := make([]byte, zstdChunkedFooterSize)
+		if _, err := blob.ReadAt(footer, blobSize-zstdChunkedFooterSize); err != nil {
+			return -1, nil, errors.Wrap(err, "reading zstd:chunked footer")
+		}
+		if !bytes.Equal(footer[32:], zstdChunkedFooterMagic) {
+			return -1, nil, errors.New("invalid zstd:chunked footer")
+		}
+		position = &manifest.ZstdChunkedTOCPosition{
+			Offset:             binary.LittleEndian.Uint64(footer[0:8]),
+			Length:             binary.LittleEndian.Uint64(footer[8:16]),
+			UncompressedLength: binary.LittleEndian.Uint64(footer[16:24]),
+			ManifestType:       binary.LittleEndian.Uint64(footer[24:32]),
+		}
+	}
+	if position.ManifestType != 1 {
+		return -1, nil, errors.Errorf("unsupported zstd:chunked table of contents type %d", position.ManifestType)
+	}
+	if position.Length > maxTOCSize || position.UncompressedLength > maxTOCSize {
+		return -1, nil, errors.New("zstd:chunked table of contents too large")
+	}
+	if position.Offset > uint64(blobSize) || position.Length > uint64(blobSize)-position.Offset {
+		return -1, nil, errors.Errorf("zstd:chunked table of contents at %d, length %d, is outside of the blob", position.Offset, position.Length)
+	}
+
+	compressed := make([]byte, position.Length)
+	if _, err := blob.ReadAt(compressed, int64(position.Offset)); err != nil {
+		return -1, nil, errors.Wrap(err, "reading zstd:chunked table of contents")
+	}
+	if err := verifyDigest(declared.Digest, compressed); err != nil {
+		return -1, nil, errors.Wrap(err, "verifying zstd:chunked table of contents")
+	}
+	decoder, err := zstd.NewReader(nil, zstd.WithDecoderMaxMemory(maxTOCSize))
+	if err != nil {
+		return -1, nil, err
+	}
+	defer decoder.Close()
+	res, err := decoder.DecodeAll(compressed, make([]byte, 0, position.UncompressedLength))
+	if err != nil {
+		return -1, nil, errors.Wrap(err, "decompressing zstd:chunked table of contents")
+	}
+	if uint64(len(res)) != position.UncompressedLength {
+		return -1, nil, errors.Errorf("zstd:chunked table of contents has %d bytes, expected %d", len(res), position.UncompressedLength)
+	}
+	return int64(position.Offset), res, nil
+}
+
+// readEstargzTOC returns the offset and the uncompressed contents of an eStargz TOC declared by declared.
+func readEstargzTOC(blob io.ReaderAt, blobSize int64, declared manifest.LayerTOC) (int64, []byte, error) {
+	tocOffset, footerSize, err := readEstargzFooter(blob, blobSize)
+	if err != nil {
+		return -1, nil, err
+	}
+	if tocOffset < 0 || tocOffset > blobSize-footerSize {
+		return -1, nil, errors.Errorf("eStargz table of contents offset %d is outside of the blob", tocOffset)
+	}
+	gz, err := gzip.NewReader(io.NewSectionReader(blob, tocOffset, blobSize-footerSize-tocOffset))
+	if err != nil {
+		return -1, nil, errors.Wrap(err, "decompressing eStargz table of contents")
+	}
+	defer gz.Close()
+	tr := tar.NewReader(gz)
+	hdr, err := tr.Next()
+	if err != nil {
+		return -1, nil, errors.Wrap(err, "reading eStargz table of contents")
+	}
+	if hdr.Name != estargzTOCName {
+		return -1, nil, errors.Errorf("unexpected %q instead of the eStargz table of contents", hdr.Name)
+	}
+	if hdr.Size > maxTOCSize {
+		return -1, nil, errors.New("eStargz table of contents too large")
+	}
+	res := make([]byte, hdr.Size)
+	if _, err := io.ReadFull(tr, res); err != nil {
+		return -1, nil, errors.Wrap(err, "reading eStargz table of contents")
+	}
+	if err := verifyDigest(declared.Digest, res); err != nil {
+		return -1, nil, errors.Wrap(err, "verifying eStargz table of contents")
+	}
+	return tocOffset, res, nil
+}
+
+// readEstargzFooter returns the TOC offset recorded in the footer of an eStargz blob, and the size of the footer.
+func readEstargzFooter(blob io.ReaderAt, blobSize int64) (int64, int64, error) {
+	// The footer is an empty gzip member with an extra field containing fmt.Sprintf("%016xSTARGZ", tocOffset);
+	// the current format uses a subfield with an ID, the legacy format does not.  The size of the empty compressed
+	// data depends on the compressor, so look for the start of the gzip member instead of relying on a fixed size.
+	bufSize := int64(estargzFooterSize)
+	if blobSize < bufSize {
+		bufSize = blobSize
+	}
+	buf := make([]byte, bufSize)
+	if _, err := blob.ReadAt(buf, blobSize-bufSize); err != nil {
+		return -1, -1, errors.Wrap(err, "reading eStargz footer")
+	}
+	for start := 0; start+estargzMinFooterSize <= len(buf); start++ {
+		footer := buf[start:]
+		if footer[0] != 0x1f || footer[1] != 0x8b || footer[2] != 8 || footer[3]&0x04 == 0 { // gzip, deflate, FEXTRA
+			continue
+		}
+		var field []byte
+		switch extra := footer[12:]; binary.LittleEndian.Uint16(footer[10:12]) {
+		case 4 + 22:
+			if extra[0] == 'S' && extra[1] == 'G' && binary.LittleEndian.Uint16(extra[2:4]) == 22 {
+				field = extra[4:26]
+			}
+		case 22:
+			field = extra[:22]
+		}
+		if field == nil || string(field[16:]) != "STARGZ" {
+			continue
+		}
+		tocOffset, err := strconv.ParseInt(string(field[:16]), 16, 64)
+		if err != nil {
+			return -1, -1, errors.Wrap(err, "parsing eStargz table of contents offset")
+		}
+		return tocOffset, int64(len(footer)), nil
+	}
+	return -1, -1, errors.New("invalid eStargz footer")
+}
+
+// verifyDigest returns an error if data does not match expected.
+func verifyDigest(expected digest.Digest, data []byte) error {
+	if err := expected.Validate(); err != nil {
+		return err
+	}
+	if actual := expected.Algorithm().FromBytes(data); actual != expected {
+		return errors.Errorf("digest mismatch: expected %s, got %s", expected, actual)
+	}
+	return nil
+}
+
+// VerifyChunks verifies that the file contents referenced by toc, which was read from blob (which has blobSize bytes)
+// using Read, match the digests recorded in toc.  Chunks which don’t have a recorded digest are only checked for
+// being decompressible.
+func VerifyChunks(blob io.ReaderAt, blobSize int64, toc *TOC) error {
+	chunks, err := toc.Chunks()
+	if err != nil {
+		return err
+	}
+	for _, c := range chunks {
+		if err := verifyChunk(blob, blobSize, toc.Format, c); err != nil {
+			return errors.Wrapf(err, "verifying chunk of %q at %d", c.Name, c.FileOffset)
+		}
+	}
+	return nil
+}
+
+// verifyChunk verifies a single chunk c in blob.
+func verifyChunk(blob io.ReaderAt, blobSize int64, format manifest.LayerTOCFormat, c Chunk) error {
+	compressed := io.NewSectionReader(blob, c.Offset, blobSize-c.Offset)
+	var uncompressed io.Reader
+	switch format {
+	case manifest.LayerTOCFormatZstdChunked:
+		decoder, err := zstd.NewReader(compressed)
+		if err != nil {
+			return err
+		}
+		defer decoder.Close()
+		uncompressed = decoder
+	case manifest.LayerTOCFormatEstargz:
+		gz, err := gzip.NewReader(compressed)
+		if err != nil {
+			return err
+		}
+		defer gz.Close()
+		uncompressed = gz
+	default:
+		return fmt.Errorf("unsupported table of contents format %s", format)
+	}
+
+	var digester digest.Digester
+	dest := io.Discard
+	if c.Digest != "" {
+		digester = c.Digest.Algorithm().Digester()
+		dest = digester.Hash()
+	}
+	if _, err := io.CopyN(dest, uncompressed, c.Size); err != nil {
+		return errors.Wrap(err, "decompressing")
+	}
+	if digester != nil && digester.Digest() != c.Digest {
+		return errors.Errorf("digest mismatch: expected %s, got %s", c.Digest, digester.Digest())
+	}
+	return nil
+}
+
+// Verify reads the TOC declared by annotations of a layer descriptor from blob, which has blobSize bytes, and verifies
+// the TOC and all file contents it references; it returns the TOC.
+func Verify(blob io.ReaderAt, blobSize int64, annotations map[string]string) (*TOC, error) {
+	declared, err := manifest.LayerTOCFromAnnotations(annotations)
+	if err != nil {
+		return nil, err
+	}
+	toc, err := Read(blob, blobSize, declared)
+	if err != nil {
+		return nil, err
+	}
+	if err := VerifyChunks(blob, blobSize, toc); err != nil {
+		return nil, err
+	}
+	return toc, nil
+}
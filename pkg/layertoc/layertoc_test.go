@@ -0,0 +1,267 @@
+package layertoc
+
+import (
+	"archive/tar"
+	"bytes"
+	"compress/gzip"
+	"encoding/json"
+	"fmt"
+	"math/rand"
+	"testing"
+
+	"github.com/containers/image/v5/manifest"
+	"github.com/containers/storage/pkg/chunked/compressor"
+	"github.com/opencontainers/go-digest"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+type testFile struct {
+	name     string
+	contents []byte
+}
+
+// testFiles returns the regular files used in test layers.
+func testFiles() []testFile {
+	random := make([]byte, 1024*1024)
+	rand.New(rand.NewSource(1)).Read(random)
+	return []testFile{
+		{"small", []byte("hello")},
+		{"empty", []byte{}},
+		{"random", random},
+		{"zeros", make([]byte, 256*1024)},
+	}
+}
+
+// testTar returns an uncompressed tar stream containing files, a directory and a symlink.
+func testTar(t *testing.T, files []testFile) []byte {
+	buf := bytes.Buffer{}
+	tw := tar.NewWriter(&buf)
+	err := tw.WriteHeader(&tar.Header{Typeflag: tar.TypeDir, Name: "dir/", Mode: 0755})
+	require.NoError(t, err)
+	for _, f := range files {
+		err := tw.WriteHeader(&tar.Header{Typeflag: tar.TypeReg, Name: "dir/" + f.name, Mode: 0644, Size: int64(len(f.contents))})
+		require.NoError(t, err)
+		_, err = tw.Write(f.contents)
+		require.NoError(t, err)
+	}
+	err = tw.WriteHeader(&tar.Header{Typeflag: tar.TypeSymlink, Name: "link", Linkname: "dir/small"})
+	require.NoError(t, err)
+	require.NoError(t, tw.Close())
+	return buf.Bytes()
+}
+
+// zstdChunkedLayer returns a zstd:chunked blob containing files, and its annotations.
+func zstdChunkedLayer(t *testing.T, files []testFile) ([]byte, map[string]string) {
+	buf := bytes.Buffer{}
+	annotations := map[string]string{}
+	level := 3
+	w, err := compressor.ZstdCompressor(&buf, annotations, &level)
+	require.NoError(t, err)
+	_, err = w.Write(testTar(t, files))
+	require.NoError(t, err)
+	require.NoError(t, w.Close())
+	return buf.Bytes(), annotations
+}
+
+// estargzLayer returns an eStargz blob containing files, and its annotations.
+// Files larger than chunkSize are split into multiple chunks.
+func estargzLayer(t *testing.T, files []testFile, chunkSize int) ([]byte, map[string]string) {
+	blob := bytes.Buffer{}
+	tarStream := bytes.Buffer{}
+	tw := tar.NewWriter(&tarStream)
+	segmentStart := 0
+	// endSegment compresses the tar stream written since the previous call as a separate gzip member,
+	// and returns the offset of the member in blob.
+	endSegment := func() int64 {
+		offset := int64(blob.Len())
+		gz := gzip.NewWriter(&blob)
+		_, err := gz.Write(tarStream.Bytes()[segmentStart:])
+		require.NoError(t, err)
+		require.NoError(t, gz.Close())
+		segmentStart = tarStream.Len()
+		return offset
+	}
+
+	toc := TOC{Version: 1}
+	require.NoError(t, tw.WriteHeader(&tar.Header{Typeflag: tar.TypeDir, Name: "dir/", Mode: 0755}))
+	toc.Entries = append(toc.Entries, Entry{Type: TypeDir, Name: "dir/", Mode: 0755})
+	for _, f := range files {
+		size := len(f.contents)
+		require.NoError(t, tw.WriteHeader(&tar.Header{Typeflag: tar.TypeReg, Name: "dir/" + f.name, Mode: 0644, Size: int64(size)}))
+		endSegment()
+		entryIndex := len(toc.Entries)
+		toc.Entries = append(toc.Entries, Entry{Type: TypeRegular, Name: "dir/" + f.name, Mode: 0644, Size: int64(size),
+			Digest: digest.FromBytes(f.contents).String()})
+		for chunkOffset := 0; chunkOffset < size; chunkOffset += chunkSize {
+			end := chunkOffset + chunkSize
+			if end > size {
+				end = size
+			}
+			data := f.contents[chunkOffset:end]
+			_, err := tw.Write(data)
+			require.NoError(t, err)
+			e := &toc.Entries[entryIndex]
+			if chunkOffset != 0 {
+				toc.Entries = append(toc.Entries, Entry{Type: TypeChunk, Name: "dir/" + f.name})
+				e = &toc.Entries[len(toc.Entries)-1]
+			}
+			e.Offset = endSegment()
+			e.ChunkOffset = int64(chunkOffset)
+			e.ChunkSize = int64(end - chunkOffset)
+			e.ChunkDigest = digest.FromBytes(data).String()
+		}
+	}
+	require.NoError(t, tw.WriteHeader(&tar.Header{Typeflag: tar.TypeSymlink, Name: "link", Linkname: "dir/small"}))
+	toc.Entries = append(toc.Entries, Entry{Type: TypeSymlink, Name: "link", Linkname: "dir/small"})
+	require.NoError(t, tw.Flush())
+	endSegment()
+
+	tocJSON, err := json.Marshal(toc)
+	require.NoError(t, err)
+	tw = tar.NewWriter(&tarStream)
+	require.NoError(t, tw.WriteHeader(&tar.Header{Typeflag: tar.TypeReg, Name: estargzTOCName, Mode: 0644, Size: int64(len(tocJSON))}))
+	_, err = tw.Write(tocJSON)
+	require.NoError(t, err)
+	require.NoError(t, tw.Close())
+	tocOffset := endSegment()
+
+	gz, err := gzip.NewWriterLevel(&blob, gzip.NoCompression)
+	require.NoError(t, err)
+	gz.Header.Extra = append([]byte{'S', 'G', 22, 0}, []byte(fmt.Sprintf("%016xSTARGZ", tocOffset))...)
+	require.NoError(t, gz.Close())
+
+	return blob.Bytes(), map[string]string{manifest.EstargzTOCDigestAnnotation: digest.FromBytes(tocJSON).String()}
+}
+
+func TestVerify(t *testing.T) {
+	files := testFiles()
+	zstdBlob, zstdAnnotations := zstdChunkedLayer(t, files)
+	require.Contains(t, zstdAnnotations, manifest.ZstdChunkedManifestPositionAnnotation)
+	zstdWithoutPosition := map[string]string{
+		manifest.ZstdChunkedManifestChecksumAnnotation: zstdAnnotations[manifest.ZstdChunkedManifestChecksumAnnotation],
+	}
+	estargzBlob, estargzAnnotations := estargzLayer(t, files, 100*1024)
+
+	for _, c := range []struct {
+		name        string
+		blob        []byte
+		annotations map[string]string
+		format      manifest.LayerTOCFormat
+	}{
+		{"zstd:chunked", zstdBlob, zstdAnnotations, manifest.LayerTOCFormatZstdChunked},
+		{"zstd:chunked, position from footer", zstdBlob, zstdWithoutPosition, manifest.LayerTOCFormatZstdChunked},
+		{"eStargz", estargzBlob, estargzAnnotations, manifest.LayerTOCFormatEstargz},
+	} {
+		toc, err := Verify(bytes.NewReader(c.blob), int64(len(c.blob)), c.annotations)
+		require.NoError(t, err, c.name)
+		assert.Equal(t, c.format, toc.Format, c.name)
+		assert.True(t, toc.Offset > 0 && toc.Offset < int64(len(c.blob)), c.name)
+
+		names := map[string]string{}
+		for _, e := range toc.Entries {
+			if e.Type != TypeChunk {
+				names[e.Name] = e.Type
+			}
+		}
+		assert.Equal(t, TypeDir, names["dir/"], c.name)
+		assert.Equal(t, TypeSymlink, names["link"], c.name)
+		for _, f := range files {
+			assert.Equal(t, TypeRegular, names["dir/"+f.name], c.name)
+		}
+
+		// The chunks cover all non-empty files exactly.
+		chunks, err := toc.Chunks()
+		require.NoError(t, err, c.name)
+		covered := map[string]int64{}
+		for _, chunk := range chunks {
+			assert.Equal(t, covered[chunk.Name], chunk.FileOffset, c.name)
+			covered[chunk.Name] += chunk.Size
+		}
+		for _, f := range files {
+			assert.Equal(t, int64(len(f.contents)), covered["dir/"+f.name], c.name, f.name)
+		}
+		if c.format == manifest.LayerTOCFormatEstargz {
+			assert.Greater(t, len(chunks), len(files), c.name) // "random" and "zeros" are split into multiple chunks
+		}
+
+		// Corrupting file contents is detected.
+		randomChunk := -1
+		for i, chunk := range chunks {
+			if chunk.Name == "dir/random" {
+				randomChunk = i
+				break
+			}
+		}
+		require.NotEqual(t, -1, randomChunk, c.name)
+		corrupted := append([]byte{}, c.blob...)
+		corruptedOffset := chunks[randomChunk].Offset + 100 // Well after the compression headers, within the data
+		corrupted[corruptedOffset] ^= 0xff
+		_, err = Read(bytes.NewReader(corrupted), int64(len(corrupted)), mustLayerTOC(t, c.annotations))
+		require.NoError(t, err, c.name)
+		_, err = Verify(bytes.NewReader(corrupted), int64(len(corrupted)), c.annotations)
+		assert.Error(t, err, c.name)
+
+		// Corrupting the TOC is detected.
+		corrupted = append([]byte{}, c.blob...)
+		corrupted[toc.Offset+20] ^= 0xff
+		_, err = Verify(bytes.NewReader(corrupted), int64(len(corrupted)), c.annotations)
+		assert.Error(t, err, c.name)
+	}
+}
+
+func mustLayerTOC(t *testing.T, annotations map[string]string) manifest.LayerTOC {
+	res, err := manifest.LayerTOCFromAnnotations(annotations)
+	require.NoError(t, err)
+	return res
+}
+
+func TestReadErrors(t *testing.T) {
+	files := testFiles()[:1]
+	zstdBlob, zstdAnnotations := zstdChunkedLayer(t, files)
+	estargzBlob, estargzAnnotations := estargzLayer(t, files, 1024)
+	otherDigest := digest.FromString("other").String()
+
+	for _, c := range []struct {
+		name        string
+		blob        []byte
+		annotations map[string]string
+	}{
+		{"no TOC", zstdBlob, map[string]string{}},
+		{"zstd:chunked digest mismatch", zstdBlob, map[string]string{manifest.ZstdChunkedManifestChecksumAnnotation: otherDigest}},
+		{"zstd:chunked position outside of the blob", zstdBlob, map[string]string{
+			manifest.ZstdChunkedManifestChecksumAnnotation: zstdAnnotations[manifest.ZstdChunkedManifestChecksumAnnotation],
+			manifest.ZstdChunkedManifestPositionAnnotation: fmt.Sprintf("%d:100:100:1", len(zstdBlob)),
+		}},
+		{"zstd:chunked unknown type", zstdBlob, map[string]string{
+			manifest.ZstdChunkedManifestChecksumAnnotation: zstdAnnotations[manifest.ZstdChunkedManifestChecksumAnnotation],
+			manifest.ZstdChunkedManifestPositionAnnotation: "0:100:100:2",
+		}},
+		{"zstd:chunked no footer", estargzBlob, map[string]string{
+			manifest.ZstdChunkedManifestChecksumAnnotation: zstdAnnotations[manifest.ZstdChunkedManifestChecksumAnnotation],
+		}},
+		{"eStargz digest mismatch", estargzBlob, map[string]string{manifest.EstargzTOCDigestAnnotation: otherDigest}},
+		{"eStargz no footer", zstdBlob, estargzAnnotations},
+		{"eStargz truncated", estargzBlob[:10], estargzAnnotations},
+	} {
+		_, err := Verify(bytes.NewReader(c.blob), int64(len(c.blob)), c.annotations)
+		assert.Error(t, err, c.name)
+	}
+}
+
+func TestChunksErrors(t *testing.T) {
+	d := digest.FromString("data").String()
+	for _, entries := range [][]Entry{
+		{{Type: TypeChunk, Name: "orphan", Offset: 10, ChunkOffset: 5, ChunkDigest: d}},                  // Chunk without a file
+		{{Type: TypeRegular, Name: "file", Size: 10, Offset: 10, ChunkSize: 20}},                         // Chunk beyond the file
+		{{Type: TypeRegular, Name: "file", Size: 10, Offset: 10, ChunkOffset: -1}},                       // Negative offset
+		{{Type: TypeRegular, Name: "file", Size: 10, Offset: 0, Digest: d}},                              // Offset before the start of the blob
+		{{Type: TypeRegular, Name: "file", Size: 10, Offset: 1000, Digest: d}},                           // Offset after the TOC
+		{{Type: TypeRegular, Name: "file", Size: 10, Offset: 10, Digest: "invalid"}},                     // Invalid digest
+		{{Type: TypeRegular, Name: "a", Size: 10, Offset: 10}, {Type: TypeChunk, Name: "b", Offset: 20}}, // Chunk of a different file
+	} {
+		toc := TOC{Offset: 100, Entries: entries}
+		_, err := toc.Chunks()
+		assert.Error(t, err, fmt.Sprintf("%#v", entries))
+	}
+}
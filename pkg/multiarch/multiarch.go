@@ -0,0 +1,295 @@
+// Package multiarch assembles a multi-platform OCI index or Docker manifest list from existing single-platform images,
+// e.g. images built separately on hosts of different architectures.
+//
+// Assemble reads the manifest and config of every image to determine its platform, and returns the combined list;
+// Push writes it to a destination.  The images themselves are not copied: the destination must already contain them,
+// typically because they were pushed by digest to the destination repository (see ImagesInRepository).
+package multiarch
+
+import (
+	"context"
+	"fmt"
+	"time"
+
+	"github.com/containers/image/v5/docker"
+	"github.com/containers/image/v5/docker/reference"
+	"github.com/containers/image/v5/image"
+	"github.com/containers/image/v5/manifest"
+	"github.com/containers/image/v5/transports"
+	"github.com/containers/image/v5/types"
+	"github.com/opencontainers/go-digest"
+	imgspecv1 "github.com/opencontainers/image-spec/specs-go/v1"
+	"github.com/pkg/errors"
+)
+
+// SourceReferenceAnnotation is the annotation recording the image reference an entry was created from,
+// if Options.ProvenanceAnnotations is set.
+const SourceReferenceAnnotation = "io.containers.image.source.reference"
+
+// Image is a single-platform image to include in the assembled list.
+type Image struct {
+	Ref types.ImageReference
+	// If not nil, Platform is used instead of the platform recorded in the image config.
+	Platform *imgspecv1.Platform
+	// Annotations are added to the entry of the image (only supported for OCI indexes).
+	Annotations map[string]string
+}
+
+// Options control Assemble.
+type Options struct {
+	// SourceCtx is used to read the images; may be nil.
+	SourceCtx *types.SystemContext
+	// MIMEType is the format of the assembled list, imgspecv1.MediaTypeImageIndex (the default if "")
+	// or manifest.DockerV2ListMediaType.
+	MIMEType string
+	// Annotations are set on the assembled list (only supported for OCI indexes).
+	Annotations map[string]string
+	// If true, each entry is annotated with the reference of its image (SourceReferenceAnnotation) and, if recorded
+	// in the image config, the creation time of the image (imgspecv1.AnnotationCreated).
+	// Only supported for OCI indexes.
+	ProvenanceAnnotations bool
+}
+
+// Instance describes an entry of the assembled list.
+type Instance struct {
+	Ref         types.ImageReference
+	Digest      digest.Digest
+	MIMEType    string
+	Size        int64
+	Platform    imgspecv1.Platform
+	Annotations map[string]string
+}
+
+// Result is an assembled manifest list.
+type Result struct {
+	Manifest  []byte
+	MIMEType  string
+	Digest    digest.Digest
+	Instances []Instance // In the order of the images passed to Assemble
+}
+
+// ImagesInRepository returns Images referring to digests in repo, a docker:// repository (without a tag or a digest),
+// e.g. per-platform images pushed by digest.
+func ImagesInRepository(repo reference.Named, digests []digest.Digest) ([]Image, error) {
+	if !reference.IsNameOnly(repo) {
+		return nil, errors.Errorf("repository %s must not include a tag or a digest", reference.FamiliarString(repo))
+	}
+	res := make([]Image, 0, len(digests))
+	for _, d := range digests {
+		named, err := reference.WithDigest(repo, d)
+		if err != nil {
+			return nil, err
+		}
+		ref, err := docker.NewReference(named)
+		if err != nil {
+			return nil, err
+		}
+		res = append(res, Image{Ref: ref})
+	}
+	return res, nil
+}
+
+// Assemble reads images, which must be single-platform images with distinct platforms, and returns a manifest list
+// referring to all of them, as specified by options (which may be nil).
+func Assemble(ctx context.Context, images []Image, options *Options) (*Result, error) {
+	opts := Options{}
+	if options != nil {
+		opts = *options
+	}
+	mimeType := opts.MIMEType
+	if mimeType == "" {
+		mimeType = imgspecv1.MediaTypeImageIndex
+	}
+	switch mimeType {
+	case imgspecv1.MediaTypeImageIndex:
+	case manifest.DockerV2ListMediaType:
+		if len(opts.Annotations) != 0 || opts.ProvenanceAnnotations {
+			return nil, errors.Errorf("annotations are not supported in %s", mimeType)
+		}
+	default:
+		return nil, errors.Errorf("assembling a manifest list of type %s is not supported", mimeType)
+	}
+	if len(images) == 0 {
+		return nil, errors.New("no images to assemble a manifest list from")
+	}
+
+	res := Result{Instances: make([]Instance, 0, len(images))}
+	seenPlatforms := map[string]types.ImageReference{}
+	for _, img := range images {
+		if mimeType != imgspecv1.MediaTypeImageIndex && len(img.Annotations) != 0 {
+			return nil, errors.Errorf("annotations are not supported in %s", mimeType)
+		}
+		instance, err := inspectImage(ctx, opts.SourceCtx, img, opts.ProvenanceAnnotations)
+		if err != nil {
+			return nil, errors.Wrapf(err, "inspecting %s", transports.ImageName(img.Ref))
+		}
+		key := platformKey(instance.Platform)
+		if other, ok := seenPlatforms[key]; ok {
+			return nil, errors.Errorf("images %s and %s both have platform %s", transports.ImageName(other), transports.ImageName(img.Ref), key)
+		}
+		seenPlatforms[key] = img.Ref
+		res.Instances = append(res.Instances, instance)
+	}
+
+	descriptors := make([]imgspecv1.Descriptor, 0, len(res.Instances))
+	for i := range res.Instances {
+		instance := &res.Instances[i]
+		platform := instance.Platform
+		descriptors = append(descriptors, imgspecv1.Descriptor{
+			MediaType:   instance.MIMEType,
+			Digest:      instance.Digest,
+			Size:        instance.Size,
+			Platform:    &platform,
+			Annotations: instance.Annotations,
+		})
+	}
+	var list manifest.List = manifest.OCI1IndexFromComponents(descriptors, opts.Annotations)
+	if mimeType == manifest.DockerV2ListMediaType {
+		l, err := list.ConvertToMIMEType(mimeType)
+		if err != nil {
+			return nil, err
+		}
+		list = l
+	}
+	blob, err := list.Serialize()
+	if err != nil {
+		return nil, err
+	}
+	res.Manifest = blob
+	res.MIMEType = mimeType
+	res.Digest = digest.FromBytes(blob)
+	return &res, nil
+}
+
+// inspectImage returns an Instance describing img.
+func inspectImage(ctx context.Context, sys *types.SystemContext, img Image, provenance bool) (Instance, error) {
+	src, err := img.Ref.NewImageSource(ctx, sys)
+	if err != nil {
+		return Instance{}, err
+	}
+	defer src.Close()
+
+	unparsed := image.UnparsedInstance(src, nil)
+	manifestBlob, manifestMIMEType, err := unparsed.Manifest(ctx)
+	if err != nil {
+		return Instance{}, err
+	}
+	manifestMIMEType = manifest.NormalizedMIMEType(manifestMIMEType)
+	if manifest.MIMETypeIsMultiImage(manifestMIMEType) {
+		return Instance{}, errors.Errorf("the image is a manifest list (%s), not a single-platform image", manifestMIMEType)
+	}
+	if manifestMIMEType == manifest.DockerV2Schema1MediaType || manifestMIMEType == manifest.DockerV2Schema1SignedMediaType {
+		return Instance{}, errors.Errorf("manifest lists can not refer to %s manifests", manifestMIMEType)
+	}
+	manifestDigest, err := manifest.Digest(manifestBlob)
+	if err != nil {
+		return Instance{}, err
+	}
+	if digested, ok := img.Ref.DockerReference().(reference.Digested); ok && digested.Digest() != manifestDigest {
+		return Instance{}, errors.Errorf("manifest digest %s does not match the reference digest %s", manifestDigest, digested.Digest())
+	}
+
+	res := Instance{
+		Ref:      img.Ref,
+		Digest:   manifestDigest,
+		MIMEType: manifestMIMEType,
+		Size:     int64(len(manifestBlob)),
+	}
+	var config *imgspecv1.Image
+	if img.Platform == nil || provenance {
+		parsed, err := image.FromUnparsedImage(ctx, sys, unparsed)
+		if err != nil {
+			return Instance{}, err
+		}
+		config, err = parsed.OCIConfig(ctx)
+		if err != nil {
+			return Instance{}, errors.Wrap(err, "reading the image config")
+		}
+	}
+	if img.Platform != nil {
+		res.Platform = *img.Platform
+		res.Platform.OSFeatures = append([]string(nil), img.Platform.OSFeatures...)
+	} else {
+		if config.OS == "" || config.Architecture == "" {
+			return Instance{}, errors.New("the image config does not specify the platform")
+		}
+		res.Platform = imgspecv1.Platform{
+			OS:           config.OS,
+			Architecture: config.Architecture,
+			Variant:      config.Variant,
+			OSVersion:    config.OSVersion,
+			OSFeatures:   append([]string(nil), config.OSFeatures...),
+		}
+	}
+
+	if len(img.Annotations) != 0 || provenance {
+		res.Annotations = map[string]string{}
+		if provenance {
+			res.Annotations[SourceReferenceAnnotation] = transports.ImageName(img.Ref)
+			if config.Created != nil {
+				res.Annotations[imgspecv1.AnnotationCreated] = config.Created.UTC().Format(time.RFC3339)
+			}
+		}
+		for k, v := range img.Annotations {
+			res.Annotations[k] = v
+		}
+	}
+	return res, nil
+}
+
+// platformKey returns a string identifying p, for detecting duplicates.
+func platformKey(p imgspecv1.Platform) string {
+	res := fmt.Sprintf("%s/%s", p.OS, p.Architecture)
+	if p.Variant != "" {
+		res += "/" + p.Variant
+	}
+	if p.OSVersion != "" {
+		res += " (" + p.OSVersion + ")"
+	}
+	return res
+}
+
+// Push writes the manifest list in assembled to dest, using sys (which may be nil).
+// The images referenced by the list must already exist in the destination.
+func Push(ctx context.Context, dest types.ImageReference, sys *types.SystemContext, assembled *Result) error {
+	d, err := dest.NewImageDestination(ctx, sys)
+	if err != nil {
+		return err
+	}
+	defer d.Close()
+
+	if supported := d.SupportedManifestMIMETypes(); len(supported) != 0 {
+		found := false
+		for _, t := range supported {
+			if t == assembled.MIMEType {
+				found = true
+				break
+			}
+		}
+		if !found {
+			return errors.Errorf("destination %s does not support manifests of type %s", transports.ImageName(dest), assembled.MIMEType)
+		}
+	}
+	if err := d.PutManifest(ctx, assembled.Manifest, nil); err != nil {
+		return errors.Wrap(err, "writing the manifest list")
+	}
+	return d.Commit(ctx, assembledImage{ref: dest, result: assembled})
+}
+
+// assembledImage is a types.UnparsedImage for an assembled manifest list.
+type assembledImage struct {
+	ref    types.ImageReference
+	result *Result
+}
+
+func (i assembledImage) Reference() types.ImageReference {
+	return i.ref
+}
+
+func (i assembledImage) Manifest(ctx context.Context) ([]byte, string, error) {
+	return i.result.Manifest, i.result.MIMEType, nil
+}
+
+func (i assembledImage) Signatures(ctx context.Context) ([][]byte, error) {
+	return nil, nil
+}
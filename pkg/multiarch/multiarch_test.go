@@ -0,0 +1,177 @@
+package multiarch
+
+import (
+	"context"
+	"encoding/json"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/containers/image/v5/directory"
+	"github.com/containers/image/v5/docker/reference"
+	"github.com/containers/image/v5/manifest"
+	"github.com/containers/image/v5/oci/layout"
+	"github.com/containers/image/v5/transports"
+	"github.com/containers/image/v5/types"
+	"github.com/opencontainers/go-digest"
+	imgspecv1 "github.com/opencontainers/image-spec/specs-go/v1"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// newTestImage creates a dir: image with a config for config, and returns its reference and manifest digest.
+func newTestImage(t *testing.T, config imgspecv1.Image) (types.ImageReference, digest.Digest) {
+	dir := t.TempDir()
+	configBlob, err := json.Marshal(config)
+	require.NoError(t, err)
+	configDigest := digest.FromBytes(configBlob)
+	err = os.WriteFile(filepath.Join(dir, configDigest.Hex()), configBlob, 0o644)
+	require.NoError(t, err)
+	m := manifest.OCI1FromComponents(imgspecv1.Descriptor{
+		MediaType: imgspecv1.MediaTypeImageConfig,
+		Digest:    configDigest,
+		Size:      int64(len(configBlob)),
+	}, []imgspecv1.Descriptor{})
+	manifestBlob, err := m.Serialize()
+	require.NoError(t, err)
+	err = os.WriteFile(filepath.Join(dir, "manifest.json"), manifestBlob, 0o644)
+	require.NoError(t, err)
+	ref, err := directory.NewReference(dir)
+	require.NoError(t, err)
+	return ref, digest.FromBytes(manifestBlob)
+}
+
+func TestAssemble(t *testing.T) {
+	created := time.Date(2022, 1, 2, 3, 4, 5, 0, time.UTC)
+	amd64, amd64Digest := newTestImage(t, imgspecv1.Image{OS: "linux", Architecture: "amd64", Created: &created})
+	arm64, arm64Digest := newTestImage(t, imgspecv1.Image{OS: "linux", Architecture: "arm64", Variant: "v8"})
+	windows, windowsDigest := newTestImage(t, imgspecv1.Image{OS: "windows", Architecture: "amd64", OSVersion: "10.0.17763.1"})
+
+	res, err := Assemble(context.Background(), []Image{
+		{Ref: amd64},
+		{Ref: arm64, Annotations: map[string]string{"com.example.key": "value"}},
+		{Ref: windows},
+	}, &Options{
+		Annotations:           map[string]string{"com.example.index": "1"},
+		ProvenanceAnnotations: true,
+	})
+	require.NoError(t, err)
+	assert.Equal(t, imgspecv1.MediaTypeImageIndex, res.MIMEType)
+	assert.Equal(t, digest.FromBytes(res.Manifest), res.Digest)
+	require.Len(t, res.Instances, 3)
+
+	index, err := manifest.OCI1IndexFromManifest(res.Manifest)
+	require.NoError(t, err)
+	assert.Equal(t, map[string]string{"com.example.index": "1"}, index.Annotations)
+	require.Len(t, index.Manifests, 3)
+	for i, c := range []struct {
+		ref         types.ImageReference
+		digest      digest.Digest
+		platform    imgspecv1.Platform
+		annotations map[string]string
+	}{
+		{amd64, amd64Digest, imgspecv1.Platform{OS: "linux", Architecture: "amd64"}, map[string]string{
+			SourceReferenceAnnotation:   transports.ImageName(amd64),
+			imgspecv1.AnnotationCreated: "2022-01-02T03:04:05Z",
+		}},
+		{arm64, arm64Digest, imgspecv1.Platform{OS: "linux", Architecture: "arm64", Variant: "v8"}, map[string]string{
+			SourceReferenceAnnotation: transports.ImageName(arm64),
+			"com.example.key":         "value",
+		}},
+		{windows, windowsDigest, imgspecv1.Platform{OS: "windows", Architecture: "amd64", OSVersion: "10.0.17763.1"}, map[string]string{
+			SourceReferenceAnnotation: transports.ImageName(windows),
+		}},
+	} {
+		d := index.Manifests[i]
+		assert.Equal(t, imgspecv1.MediaTypeImageManifest, d.MediaType)
+		assert.Equal(t, c.digest, d.Digest)
+		require.NotNil(t, d.Platform)
+		assert.Equal(t, c.platform.OS, d.Platform.OS)
+		assert.Equal(t, c.platform.Architecture, d.Platform.Architecture)
+		assert.Equal(t, c.platform.Variant, d.Platform.Variant)
+		assert.Equal(t, c.platform.OSVersion, d.Platform.OSVersion)
+		assert.Equal(t, c.annotations, d.Annotations)
+		assert.Equal(t, c.ref, res.Instances[i].Ref)
+		assert.Equal(t, c.digest, res.Instances[i].Digest)
+	}
+
+	// Docker manifest lists, with a platform override
+	res, err = Assemble(context.Background(), []Image{
+		{Ref: amd64},
+		{Ref: arm64, Platform: &imgspecv1.Platform{OS: "linux", Architecture: "arm64", Variant: "v8.2"}},
+	}, &Options{MIMEType: manifest.DockerV2ListMediaType})
+	require.NoError(t, err)
+	assert.Equal(t, manifest.DockerV2ListMediaType, res.MIMEType)
+	list, err := manifest.Schema2ListFromManifest(res.Manifest)
+	require.NoError(t, err)
+	require.Len(t, list.Manifests, 2)
+	assert.Equal(t, "v8.2", list.Manifests[1].Platform.Variant)
+	assert.Equal(t, arm64Digest, list.Manifests[1].Digest)
+}
+
+func TestAssembleErrors(t *testing.T) {
+	amd64, _ := newTestImage(t, imgspecv1.Image{OS: "linux", Architecture: "amd64"})
+	amd64Again, _ := newTestImage(t, imgspecv1.Image{OS: "linux", Architecture: "amd64", Author: "someone else"})
+	noPlatform, _ := newTestImage(t, imgspecv1.Image{})
+
+	for _, c := range []struct {
+		images  []Image
+		options *Options
+	}{
+		{[]Image{}, nil}, // No images
+		{[]Image{{Ref: amd64}, {Ref: amd64Again}}, nil},      // Duplicate platforms
+		{[]Image{{Ref: noPlatform}}, nil},                    // Unknown platform
+		{[]Image{{Ref: amd64}}, &Options{MIMEType: "bogus"}}, // Unsupported format
+		{[]Image{{Ref: amd64}}, &Options{MIMEType: manifest.DockerV2ListMediaType, ProvenanceAnnotations: true}},
+		{[]Image{{Ref: amd64}}, &Options{MIMEType: manifest.DockerV2ListMediaType, Annotations: map[string]string{"a": "b"}}},
+		{[]Image{{Ref: amd64, Annotations: map[string]string{"a": "b"}}}, &Options{MIMEType: manifest.DockerV2ListMediaType}},
+	} {
+		_, err := Assemble(context.Background(), c.images, c.options)
+		assert.Error(t, err)
+	}
+
+	// Explicit platforms can be used for images without a platform
+	_, err := Assemble(context.Background(), []Image{{Ref: noPlatform, Platform: &imgspecv1.Platform{OS: "linux", Architecture: "s390x"}}}, nil)
+	assert.NoError(t, err)
+}
+
+func TestImagesInRepository(t *testing.T) {
+	repo, err := reference.ParseNormalizedNamed("example.com/ns/repo")
+	require.NoError(t, err)
+	digests := []digest.Digest{
+		"sha256:e692418e4cbaf90ca69d05a66403747baa33ee08806650b51fab815ad7fc331f",
+		"sha256:5b0bcabd1ed22e9fb1310cf6c2dec7cdef19f0ad69efa1f392e94a4333501270",
+	}
+	images, err := ImagesInRepository(repo, digests)
+	require.NoError(t, err)
+	require.Len(t, images, 2)
+	for i, img := range images {
+		assert.Equal(t, "docker://example.com/ns/repo@"+digests[i].String(), transports.ImageName(img.Ref))
+	}
+
+	tagged, err := reference.ParseNormalizedNamed("example.com/ns/repo:tag")
+	require.NoError(t, err)
+	_, err = ImagesInRepository(tagged, digests)
+	assert.Error(t, err)
+}
+
+func TestPush(t *testing.T) {
+	amd64, _ := newTestImage(t, imgspecv1.Image{OS: "linux", Architecture: "amd64"})
+	arm64, _ := newTestImage(t, imgspecv1.Image{OS: "linux", Architecture: "arm64"})
+	res, err := Assemble(context.Background(), []Image{{Ref: amd64}, {Ref: arm64}}, nil)
+	require.NoError(t, err)
+
+	dest, err := layout.ParseReference(t.TempDir() + ":latest")
+	require.NoError(t, err)
+	err = Push(context.Background(), dest, nil, res)
+	require.NoError(t, err)
+
+	src, err := dest.NewImageSource(context.Background(), nil)
+	require.NoError(t, err)
+	defer src.Close()
+	manifestBlob, mimeType, err := src.GetManifest(context.Background(), nil)
+	require.NoError(t, err)
+	assert.Equal(t, res.Manifest, manifestBlob)
+	assert.Equal(t, imgspecv1.MediaTypeImageIndex, mimeType)
+}
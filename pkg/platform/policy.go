@@ -0,0 +1,187 @@
+// Package platform implements configurable policies for choosing an image from a manifest list based on the platforms
+// of its instances; see types.PlatformMatcher.
+package platform
+
+import (
+	"strconv"
+	"strings"
+
+	"github.com/containers/image/v5/internal/pkg/platform"
+	"github.com/containers/image/v5/types"
+	imgspecv1 "github.com/opencontainers/image-spec/specs-go/v1"
+	"github.com/pkg/errors"
+)
+
+// Policy is a configurable types.PlatformMatcher.
+//
+// The zero value implements the default heuristics used if types.SystemContext.PlatformMatcher is not set: the wanted
+// OS, architecture and variant are determined from types.SystemContext.OSChoice, ArchitectureChoice and VariantChoice,
+// or from the current host, and images with a compatible variant are accepted, preferring the most capable variant.
+// os.version and os.features are ignored.
+type Policy struct {
+	// VariantPreferences maps architectures (GOARCH values) to the variants acceptable for them, most preferred first;
+	// "" stands for images which don’t specify a variant.  E.g. {"arm64": {"v8.2", "v8.1", "v8", ""}} prefers v8.2 images
+	// over v8.0 ones.  Architectures which are not listed use the default compatibility rules.
+	VariantPreferences map[string][]string
+	// RequireVariant rejects images which don’t specify a variant, for architectures which have variants.
+	RequireVariant bool
+	// If not "", OSVersion restricts images which specify os.version to those which can run on this OS version.
+	// For Windows, the major, minor and build numbers (e.g. "10.0.17763") must match, and newer revisions are preferred;
+	// for other operating systems, os.version must be equal.  Images which don’t specify os.version are accepted,
+	// but images with a matching os.version are preferred.
+	OSVersion string
+	// If not nil, OSFeatures are the OS features supported by the host; images which require other features
+	// (in os.features) are rejected.
+	OSFeatures []string
+}
+
+var _ types.PlatformMatcher = (*Policy)(nil)
+
+// ChoosePlatform returns the index of the most appropriate platform in candidates for the environment described by sys,
+// or -1 if none of the candidates is acceptable.
+func (p *Policy) ChoosePlatform(sys *types.SystemContext, candidates []imgspecv1.Platform) (int, error) {
+	wanted, err := p.wantedPlatforms(sys)
+	if err != nil {
+		return -1, err
+	}
+	best := -1
+	bestRank := 0
+	for i, c := range candidates {
+		rank, ok := p.rank(wanted, c)
+		if !ok {
+			continue
+		}
+		if best == -1 || rank < bestRank || (rank == bestRank && p.preferOSVersion(c.OSVersion, candidates[best].OSVersion)) {
+			best = i
+			bestRank = rank
+		}
+	}
+	return best, nil
+}
+
+// wantedPlatforms returns the platforms acceptable for sys, most preferred first.
+func (p *Policy) wantedPlatforms(sys *types.SystemContext) ([]imgspecv1.Platform, error) {
+	res, err := platform.WantedPlatforms(sys)
+	if err != nil {
+		return nil, errors.Wrapf(err, "getting platform information %#v", sys)
+	}
+	if len(res) == 0 {
+		return nil, nil
+	}
+	if variants, ok := p.VariantPreferences[res[0].Architecture]; ok {
+		os, arch := res[0].OS, res[0].Architecture
+		res = make([]imgspecv1.Platform, 0, len(variants))
+		for _, v := range variants {
+			res = append(res, imgspecv1.Platform{OS: os, Architecture: arch, Variant: v})
+		}
+	}
+	if p.RequireVariant {
+		withVariant := make([]imgspecv1.Platform, 0, len(res))
+		for _, w := range res {
+			if w.Variant != "" {
+				withVariant = append(withVariant, w)
+			}
+		}
+		if len(withVariant) != 0 { // Otherwise the architecture has no variants.
+			res = withVariant
+		}
+	}
+	return res, nil
+}
+
+// rank returns whether candidate is acceptable, and if so, its rank; lower ranks are preferred.
+func (p *Policy) rank(wanted []imgspecv1.Platform, candidate imgspecv1.Platform) (int, bool) {
+	if !p.osVersionMatches(candidate) || !p.osFeaturesSupported(candidate) {
+		return 0, false
+	}
+	for i, w := range wanted {
+		if platform.MatchesPlatform(candidate, w) {
+			rank := 2 * i
+			if p.OSVersion != "" && candidate.OSVersion == "" {
+				rank++ // Prefer images which are known to match p.OSVersion
+			}
+			return rank, true
+		}
+	}
+	return 0, false
+}
+
+// osVersionMatches returns true if candidate can run on p.OSVersion.
+func (p *Policy) osVersionMatches(candidate imgspecv1.Platform) bool {
+	if p.OSVersion == "" || candidate.OSVersion == "" {
+		return true
+	}
+	if candidate.OS == "windows" {
+		return windowsBuild(candidate.OSVersion) == windowsBuild(p.OSVersion)
+	}
+	return candidate.OSVersion == p.OSVersion
+}
+
+// preferOSVersion returns true if an image with os.version a should be preferred over an image with os.version b,
+// when both are otherwise equally acceptable.
+func (p *Policy) preferOSVersion(a, b string) bool {
+	if p.OSVersion == "" || a == "" || b == "" {
+		return false
+	}
+	return windowsRevision(a) > windowsRevision(b)
+}
+
+// osFeaturesSupported returns true if all of the os.features of candidate are in p.OSFeatures.
+func (p *Policy) osFeaturesSupported(candidate imgspecv1.Platform) bool {
+	if p.OSFeatures == nil {
+		return true
+	}
+	for _, f := range candidate.OSFeatures {
+		supported := false
+		for _, s := range p.OSFeatures {
+			if f == s {
+				supported = true
+				break
+			}
+		}
+		if !supported {
+			return false
+		}
+	}
+	return true
+}
+
+// windowsBuild returns the major, minor and build numbers of a Windows os.version value.
+func windowsBuild(osVersion string) string {
+	parts := strings.SplitN(osVersion, ".", 4)
+	if len(parts) > 3 {
+		parts = parts[:3]
+	}
+	return strings.Join(parts, ".")
+}
+
+// windowsRevision returns the revision number of a Windows os.version value, or -1 if it is not available.
+func windowsRevision(osVersion string) int {
+	parts := strings.SplitN(osVersion, ".", 4)
+	if len(parts) < 4 {
+		return -1
+	}
+	res, err := strconv.Atoi(parts[3])
+	if err != nil {
+		return -1
+	}
+	return res
+}
+
+// Choose returns the index of the most appropriate platform in candidates for the environment described by sys
+// (which may be nil), using sys.PlatformMatcher if set, or the default heuristics otherwise; or -1 if none of
+// the candidates is acceptable.
+func Choose(sys *types.SystemContext, candidates []imgspecv1.Platform) (int, error) {
+	var matcher types.PlatformMatcher = &Policy{}
+	if sys != nil && sys.PlatformMatcher != nil {
+		matcher = sys.PlatformMatcher
+	}
+	res, err := matcher.ChoosePlatform(sys, candidates)
+	if err != nil {
+		return -1, err
+	}
+	if res < -1 || res >= len(candidates) {
+		return -1, errors.Errorf("internal error: platform matcher returned invalid index %d for %d candidates", res, len(candidates))
+	}
+	return res, nil
+}
@@ -0,0 +1,122 @@
+package platform
+
+import (
+	"testing"
+
+	"github.com/containers/image/v5/types"
+	imgspecv1 "github.com/opencontainers/image-spec/specs-go/v1"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestPolicyChoosePlatformVariants(t *testing.T) {
+	candidates := []imgspecv1.Platform{
+		{OS: "linux", Architecture: "amd64"},
+		{OS: "linux", Architecture: "arm64"},
+		{OS: "linux", Architecture: "arm64", Variant: "v8"},
+		{OS: "linux", Architecture: "arm64", Variant: "v8.2"},
+	}
+	for _, c := range []struct {
+		policy   Policy
+		arch     string
+		variant  string
+		expected int
+	}{
+		{Policy{}, "amd64", "", 0},
+		{Policy{}, "arm64", "", 1},
+		{Policy{}, "arm64", "v8", 2},
+		{Policy{}, "arm64", "v8.2", 3},
+		{Policy{}, "s390x", "", -1},
+		{Policy{RequireVariant: true}, "amd64", "", 0}, // amd64 has no variants
+		{Policy{RequireVariant: true}, "arm64", "", 2},
+		{Policy{VariantPreferences: map[string][]string{"arm64": {"v8.2", "v8", ""}}}, "arm64", "", 3},
+		{Policy{VariantPreferences: map[string][]string{"arm64": {"v8.1", ""}}}, "arm64", "v8.2", 1},
+		{Policy{VariantPreferences: map[string][]string{"arm64": {"v8.1", ""}}, RequireVariant: true}, "arm64", "", -1},
+	} {
+		sys := &types.SystemContext{OSChoice: "linux", ArchitectureChoice: c.arch, VariantChoice: c.variant}
+		res, err := c.policy.ChoosePlatform(sys, candidates)
+		require.NoError(t, err)
+		assert.Equal(t, c.expected, res, "%#v, %s/%s", c.policy, c.arch, c.variant)
+	}
+}
+
+func TestPolicyChoosePlatformOSVersion(t *testing.T) {
+	candidates := []imgspecv1.Platform{
+		{OS: "windows", Architecture: "amd64", OSVersion: "10.0.14393.4583"},
+		{OS: "windows", Architecture: "amd64"},
+		{OS: "windows", Architecture: "amd64", OSVersion: "10.0.17763.1000"},
+		{OS: "windows", Architecture: "amd64", OSVersion: "10.0.17763.2114"},
+		{OS: "windows", Architecture: "amd64", OSVersion: "10.0.17763.1999"},
+	}
+	sys := &types.SystemContext{OSChoice: "windows", ArchitectureChoice: "amd64"}
+	for _, c := range []struct {
+		osVersion string
+		expected  int
+	}{
+		{"", 0},
+		{"10.0.17763", 3},
+		{"10.0.17763.1", 3},
+		{"10.0.14393.1", 0},
+		{"10.0.20348.1", 1},
+	} {
+		res, err := (&Policy{OSVersion: c.osVersion}).ChoosePlatform(sys, candidates)
+		require.NoError(t, err)
+		assert.Equal(t, c.expected, res, c.osVersion)
+	}
+
+	// Other operating systems require an exact match
+	res, err := (&Policy{OSVersion: "1.2"}).ChoosePlatform(&types.SystemContext{OSChoice: "linux", ArchitectureChoice: "amd64"}, []imgspecv1.Platform{
+		{OS: "linux", Architecture: "amd64", OSVersion: "1.2.3"},
+		{OS: "linux", Architecture: "amd64", OSVersion: "1.2"},
+	})
+	require.NoError(t, err)
+	assert.Equal(t, 1, res)
+}
+
+func TestPolicyChoosePlatformOSFeatures(t *testing.T) {
+	candidates := []imgspecv1.Platform{
+		{OS: "windows", Architecture: "amd64", OSFeatures: []string{"win32k"}},
+		{OS: "windows", Architecture: "amd64"},
+	}
+	sys := &types.SystemContext{OSChoice: "windows", ArchitectureChoice: "amd64"}
+	for _, c := range []struct {
+		features []string
+		expected int
+	}{
+		{nil, 0},
+		{[]string{}, 1},
+		{[]string{"other"}, 1},
+		{[]string{"other", "win32k"}, 0},
+	} {
+		res, err := (&Policy{OSFeatures: c.features}).ChoosePlatform(sys, candidates)
+		require.NoError(t, err)
+		assert.Equal(t, c.expected, res, "%#v", c.features)
+	}
+}
+
+// fixedMatcher is a types.PlatformMatcher which always returns the same value.
+type fixedMatcher int
+
+func (m fixedMatcher) ChoosePlatform(sys *types.SystemContext, candidates []imgspecv1.Platform) (int, error) {
+	return int(m), nil
+}
+
+func TestChoose(t *testing.T) {
+	candidates := []imgspecv1.Platform{
+		{OS: "linux", Architecture: "amd64"},
+		{OS: "linux", Architecture: "arm64"},
+	}
+	res, err := Choose(&types.SystemContext{OSChoice: "linux", ArchitectureChoice: "arm64"}, candidates)
+	require.NoError(t, err)
+	assert.Equal(t, 1, res)
+
+	for _, m := range []fixedMatcher{-1, 0, 1} {
+		res, err := Choose(&types.SystemContext{OSChoice: "linux", ArchitectureChoice: "arm64", PlatformMatcher: m}, candidates)
+		require.NoError(t, err)
+		assert.Equal(t, int(m), res)
+	}
+	for _, m := range []fixedMatcher{-2, 2} {
+		_, err := Choose(&types.SystemContext{PlatformMatcher: m}, candidates)
+		assert.Error(t, err)
+	}
+}
@@ -0,0 +1,283 @@
+// Package prefetch keeps copies of frequently pulled images up to date, e.g. as a building block for node image
+// pre-pullers.
+//
+// A Warmer periodically checks each source reference for changes using conditional fetches (a HEAD request for
+// docker:// references, and a manifest fetch otherwise), and only copies the image to its destination (typically
+// containers-storage: or oci: layouts) if the source has changed, or if the destination no longer contains the
+// previously copied image. Copies reuse blobs already present in the destination, and use partial pulls where the
+// destination supports them.
+//
+// References which have not changed are checked exponentially less often, up to Options.MaxInterval; references which
+// changed are checked again after Options.Interval.
+package prefetch
+
+import (
+	"context"
+	"sync"
+	"time"
+
+	"github.com/containers/image/v5/copy"
+	"github.com/containers/image/v5/docker"
+	"github.com/containers/image/v5/manifest"
+	"github.com/containers/image/v5/signature"
+	"github.com/containers/image/v5/transports"
+	"github.com/containers/image/v5/types"
+	"github.com/opencontainers/go-digest"
+	"github.com/pkg/errors"
+	"github.com/sirupsen/logrus"
+)
+
+const (
+	// DefaultInterval is the default value of Options.Interval.
+	DefaultInterval = 5 * time.Minute
+	// DefaultMaxInterval is the default value of Options.MaxInterval.
+	DefaultMaxInterval = 2 * time.Hour
+)
+
+// Entry is an image to keep warm.
+type Entry struct {
+	Source      types.ImageReference
+	Destination types.ImageReference
+}
+
+// Options configure a Warmer.
+type Options struct {
+	// Interval is the delay before checking a reference again after it was copied (or after it was checked for the first time).
+	// If 0, DefaultInterval is used.
+	Interval time.Duration
+	// MaxInterval is the maximum delay between checks of a reference which does not change, or which repeatedly fails.
+	// If 0, DefaultMaxInterval is used.
+	MaxInterval time.Duration
+	// CopyOptions are used for every copy; CopyOptions.SourceCtx and CopyOptions.DestinationCtx are also used for the
+	// conditional fetches. May be nil.
+	CopyOptions *copy.Options
+	// ReportResult, if set, is called after every check of an entry.
+	ReportResult func(Result)
+}
+
+// Result describes a single check of an entry.
+type Result struct {
+	Entry Entry
+	// Digest is the manifest digest of the source, if it could be determined.
+	Digest digest.Digest
+	// Copied is true if the image was copied to the destination.
+	Copied bool
+	// Err is set if the check or the copy failed.
+	Err error
+	// NextCheck is the time when the entry will be checked again.
+	NextCheck time.Time
+}
+
+// entryState is the state of a single Entry in a Warmer.
+type entryState struct {
+	entry        Entry
+	sourceDigest digest.Digest // The source digest at the time of the last successful copy, or ""
+	destDigest   digest.Digest // The digest of the manifest written by the last successful copy, or ""
+	interval     time.Duration // The delay used when scheduling nextCheck
+	nextCheck    time.Time
+}
+
+// Warmer keeps a set of images warm. It is safe for concurrent use.
+type Warmer struct {
+	policyContext *signature.PolicyContext
+	options       Options
+
+	// Hooks for tests
+	now          func() time.Time
+	sourceDigest func(ctx context.Context, sys *types.SystemContext, ref types.ImageReference) (digest.Digest, error)
+	destDigest   func(ctx context.Context, sys *types.SystemContext, ref types.ImageReference) (digest.Digest, error)
+	copyImage    func(ctx context.Context, policyContext *signature.PolicyContext, destRef, srcRef types.ImageReference, options *copy.Options) ([]byte, error)
+
+	mutex   sync.Mutex // Protects entries
+	entries []*entryState
+}
+
+// NewWarmer returns a Warmer keeping entries warm, using policyContext to decide which images can be copied.
+// All entries are due to be checked immediately.
+func NewWarmer(policyContext *signature.PolicyContext, entries []Entry, options *Options) (*Warmer, error) {
+	w := &Warmer{
+		policyContext: policyContext,
+		now:           time.Now,
+		sourceDigest:  sourceManifestDigest,
+		destDigest:    manifestDigest,
+		copyImage:     copy.Image,
+	}
+	if options != nil {
+		w.options = *options
+	}
+	if w.options.Interval == 0 {
+		w.options.Interval = DefaultInterval
+	}
+	if w.options.MaxInterval == 0 {
+		w.options.MaxInterval = DefaultMaxInterval
+	}
+	if w.options.Interval < 0 || w.options.MaxInterval < w.options.Interval {
+		return nil, errors.Errorf("invalid prefetch intervals %s, %s", w.options.Interval, w.options.MaxInterval)
+	}
+	for _, e := range entries {
+		if err := w.Add(e); err != nil {
+			return nil, err
+		}
+	}
+	return w, nil
+}
+
+// Add adds e to the entries kept warm by w; it is due to be checked immediately.
+func (w *Warmer) Add(e Entry) error {
+	if e.Source == nil || e.Destination == nil {
+		return errors.New("prefetch entries must have a source and a destination")
+	}
+	w.mutex.Lock()
+	defer w.mutex.Unlock()
+	w.entries = append(w.entries, &entryState{entry: e})
+	return nil
+}
+
+// Run checks entries as they become due, until ctx is done; it then returns ctx.Err().
+func (w *Warmer) Run(ctx context.Context) error {
+	for {
+		w.WarmDue(ctx)
+		delay := time.Until(w.nextCheck())
+		if delay < 0 {
+			delay = 0
+		}
+		timer := time.NewTimer(delay)
+		select {
+		case <-ctx.Done():
+			timer.Stop()
+			return ctx.Err()
+		case <-timer.C:
+		}
+	}
+}
+
+// WarmDue checks, one at a time, all entries which are due to be checked, and returns the results.
+func (w *Warmer) WarmDue(ctx context.Context) []Result {
+	var results []Result
+	for _, s := range w.dueEntries() {
+		if ctx.Err() != nil {
+			break
+		}
+		res := w.warm(ctx, s)
+		if w.options.ReportResult != nil {
+			w.options.ReportResult(res)
+		}
+		results = append(results, res)
+	}
+	return results
+}
+
+// dueEntries returns the entries which are due to be checked.
+func (w *Warmer) dueEntries() []*entryState {
+	w.mutex.Lock()
+	defer w.mutex.Unlock()
+	now := w.now()
+	res := []*entryState{}
+	for _, s := range w.entries {
+		if !s.nextCheck.After(now) {
+			res = append(res, s)
+		}
+	}
+	return res
+}
+
+// nextCheck returns the time when the earliest entry is due, or a time after Options.MaxInterval if there are no entries.
+func (w *Warmer) nextCheck() time.Time {
+	w.mutex.Lock()
+	defer w.mutex.Unlock()
+	res := w.now().Add(w.options.MaxInterval)
+	for _, s := range w.entries {
+		if s.nextCheck.Before(res) {
+			res = s.nextCheck
+		}
+	}
+	return res
+}
+
+// warm checks s, copies it if necessary, and schedules the next check.
+func (w *Warmer) warm(ctx context.Context, s *entryState) Result {
+	res := Result{Entry: s.entry}
+	var sys *types.SystemContext
+	if w.options.CopyOptions != nil {
+		sys = w.options.CopyOptions.SourceCtx
+	}
+	res.Digest, res.Err = w.sourceDigest(ctx, sys, s.entry.Source)
+	if res.Err == nil {
+		res.Copied, res.Err = w.copyIfChanged(ctx, s, res.Digest)
+	}
+
+	w.mutex.Lock()
+	defer w.mutex.Unlock()
+	switch {
+	case res.Copied || s.interval == 0:
+		s.interval = w.options.Interval
+	default: // Unchanged, or failed
+		s.interval *= 2
+		if s.interval > w.options.MaxInterval {
+			s.interval = w.options.MaxInterval
+		}
+	}
+	s.nextCheck = w.now().Add(s.interval)
+	res.NextCheck = s.nextCheck
+	if res.Err != nil {
+		logrus.Debugf("Error prefetching %s: %v", transports.ImageName(s.entry.Source), res.Err)
+	}
+	return res
+}
+
+// copyIfChanged copies s if srcDigest differs from the last copy, or if the destination no longer contains the copied image.
+// It returns true if the image was copied.
+func (w *Warmer) copyIfChanged(ctx context.Context, s *entryState, srcDigest digest.Digest) (bool, error) {
+	w.mutex.Lock()
+	lastSourceDigest, lastDestDigest := s.sourceDigest, s.destDigest
+	w.mutex.Unlock()
+
+	if lastSourceDigest == srcDigest {
+		var sys *types.SystemContext
+		if w.options.CopyOptions != nil {
+			sys = w.options.CopyOptions.DestinationCtx
+		}
+		destDigest, err := w.destDigest(ctx, sys, s.entry.Destination)
+		if err == nil && destDigest == lastDestDigest {
+			logrus.Debugf("Image %s is unchanged", transports.ImageName(s.entry.Source))
+			return false, nil
+		}
+		logrus.Debugf("Destination %s no longer contains the copied image", transports.ImageName(s.entry.Destination))
+	}
+
+	logrus.Debugf("Copying %s to %s", transports.ImageName(s.entry.Source), transports.ImageName(s.entry.Destination))
+	man, err := w.copyImage(ctx, w.policyContext, s.entry.Destination, s.entry.Source, w.options.CopyOptions)
+	if err != nil {
+		return false, errors.Wrapf(err, "copying %s", transports.ImageName(s.entry.Source))
+	}
+	destDigest, err := manifest.Digest(man)
+	if err != nil {
+		return true, err
+	}
+	w.mutex.Lock()
+	s.sourceDigest, s.destDigest = srcDigest, destDigest
+	w.mutex.Unlock()
+	return true, nil
+}
+
+// sourceManifestDigest returns the manifest digest of ref, using a HEAD request if ref is a docker:// reference.
+func sourceManifestDigest(ctx context.Context, sys *types.SystemContext, ref types.ImageReference) (digest.Digest, error) {
+	if ref.Transport().Name() == docker.Transport.Name() {
+		return docker.GetDigest(ctx, sys, ref)
+	}
+	return manifestDigest(ctx, sys, ref)
+}
+
+// manifestDigest returns the digest of the manifest of ref.
+func manifestDigest(ctx context.Context, sys *types.SystemContext, ref types.ImageReference) (digest.Digest, error) {
+	src, err := ref.NewImageSource(ctx, sys)
+	if err != nil {
+		return "", err
+	}
+	defer src.Close()
+	man, _, err := src.GetManifest(ctx, nil)
+	if err != nil {
+		return "", err
+	}
+	return manifest.Digest(man)
+}
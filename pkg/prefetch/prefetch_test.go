@@ -0,0 +1,170 @@
+package prefetch
+
+import (
+	"context"
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/containers/image/v5/copy"
+	"github.com/containers/image/v5/directory"
+	"github.com/containers/image/v5/signature"
+	"github.com/containers/image/v5/types"
+	"github.com/opencontainers/go-digest"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// testRegistry simulates the sources and destinations of a Warmer.
+type testRegistry struct {
+	now          time.Time
+	sources      map[string]digest.Digest // Indexed by source path; a missing entry causes an error
+	destinations map[string]digest.Digest // Indexed by destination path
+	copies       int
+}
+
+func (r *testRegistry) install(w *Warmer) {
+	w.now = func() time.Time { return r.now }
+	w.sourceDigest = func(ctx context.Context, sys *types.SystemContext, ref types.ImageReference) (digest.Digest, error) {
+		d, ok := r.sources[ref.StringWithinTransport()]
+		if !ok {
+			return "", errors.New("source not found")
+		}
+		return d, nil
+	}
+	w.destDigest = func(ctx context.Context, sys *types.SystemContext, ref types.ImageReference) (digest.Digest, error) {
+		d, ok := r.destinations[ref.StringWithinTransport()]
+		if !ok {
+			return "", errors.New("destination not found")
+		}
+		return d, nil
+	}
+	w.copyImage = func(ctx context.Context, policyContext *signature.PolicyContext, destRef, srcRef types.ImageReference, options *copy.Options) ([]byte, error) {
+		r.copies++
+		man := []byte(r.sources[srcRef.StringWithinTransport()])
+		r.destinations[destRef.StringWithinTransport()] = digest.FromBytes(man)
+		return man, nil
+	}
+}
+
+func newTestEntry(t *testing.T, src, dest string) Entry {
+	srcRef, err := directory.NewReference(src)
+	require.NoError(t, err)
+	destRef, err := directory.NewReference(dest)
+	require.NoError(t, err)
+	return Entry{Source: srcRef, Destination: destRef}
+}
+
+func TestNewWarmer(t *testing.T) {
+	e := newTestEntry(t, "/src", "/dest")
+
+	w, err := NewWarmer(nil, []Entry{e}, nil)
+	require.NoError(t, err)
+	assert.Equal(t, DefaultInterval, w.options.Interval)
+	assert.Equal(t, DefaultMaxInterval, w.options.MaxInterval)
+	assert.Len(t, w.entries, 1)
+
+	for _, opts := range []Options{
+		{Interval: -time.Second},
+		{Interval: time.Hour, MaxInterval: time.Minute},
+	} {
+		_, err := NewWarmer(nil, nil, &opts)
+		assert.Error(t, err, opts)
+	}
+
+	_, err = NewWarmer(nil, []Entry{{Source: e.Source}}, nil)
+	assert.Error(t, err)
+}
+
+func TestWarmerWarmDue(t *testing.T) {
+	const interval, maxInterval = time.Minute, 5 * time.Minute
+	r := &testRegistry{
+		now: time.Unix(1000, 0),
+		sources: map[string]digest.Digest{
+			"/src1": "sha256:1",
+			"/src2": "sha256:2",
+		},
+		destinations: map[string]digest.Digest{},
+	}
+	e1, e2, e3 := newTestEntry(t, "/src1", "/dest1"), newTestEntry(t, "/src2", "/dest2"), newTestEntry(t, "/missing", "/dest3")
+	var reported []Result
+	w, err := NewWarmer(nil, []Entry{e1, e2, e3}, &Options{
+		Interval:     interval,
+		MaxInterval:  maxInterval,
+		ReportResult: func(res Result) { reported = append(reported, res) },
+	})
+	require.NoError(t, err)
+	r.install(w)
+
+	// Everything is due initially
+	res := w.WarmDue(context.Background())
+	require.Len(t, res, 3)
+	assert.Equal(t, res, reported)
+	assert.True(t, res[0].Copied)
+	assert.Equal(t, digest.Digest("sha256:1"), res[0].Digest)
+	assert.True(t, res[1].Copied)
+	assert.False(t, res[2].Copied)
+	assert.Error(t, res[2].Err)
+	for _, r2 := range res {
+		assert.Equal(t, r.now.Add(interval), r2.NextCheck)
+	}
+	assert.Equal(t, 2, r.copies)
+
+	// Nothing is due before the interval passes
+	r.now = r.now.Add(interval - time.Second)
+	assert.Empty(t, w.WarmDue(context.Background()))
+	assert.Equal(t, r.now.Add(time.Second), w.nextCheck())
+
+	// Unchanged and failing entries are backed off; changed entries are copied
+	r.now = r.now.Add(time.Second)
+	r.sources["/src2"] = "sha256:22"
+	res = w.WarmDue(context.Background())
+	require.Len(t, res, 3)
+	assert.False(t, res[0].Copied)
+	assert.NoError(t, res[0].Err)
+	assert.Equal(t, r.now.Add(2*interval), res[0].NextCheck)
+	assert.True(t, res[1].Copied)
+	assert.Equal(t, r.now.Add(interval), res[1].NextCheck)
+	assert.Error(t, res[2].Err)
+	assert.Equal(t, r.now.Add(2*interval), res[2].NextCheck)
+	assert.Equal(t, 3, r.copies)
+
+	// A destination which lost the image is copied again, even if the source did not change
+	r.now = r.now.Add(2 * interval)
+	delete(r.destinations, "/dest1")
+	res = w.WarmDue(context.Background())
+	require.Len(t, res, 3)
+	assert.True(t, res[0].Copied)
+	assert.False(t, res[1].Copied)
+	assert.Equal(t, 4, r.copies)
+
+	// Backoff is limited to maxInterval
+	for i := 0; i < 5; i++ {
+		r.now = r.now.Add(maxInterval)
+		w.WarmDue(context.Background())
+	}
+	assert.Equal(t, r.now.Add(maxInterval), w.nextCheck())
+
+	// Cancellation stops processing
+	r.now = r.now.Add(maxInterval)
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+	assert.Empty(t, w.WarmDue(ctx))
+}
+
+func TestWarmerRun(t *testing.T) {
+	r := &testRegistry{
+		now:          time.Now(),
+		sources:      map[string]digest.Digest{"/src": "sha256:1"},
+		destinations: map[string]digest.Digest{},
+	}
+	w, err := NewWarmer(nil, []Entry{newTestEntry(t, "/src", "/dest")}, nil)
+	require.NoError(t, err)
+	r.install(w)
+
+	ctx, cancel := context.WithCancel(context.Background())
+	w.options.ReportResult = func(Result) { cancel() }
+	err = w.Run(ctx)
+	assert.Equal(t, context.Canceled, err)
+	assert.Equal(t, 1, r.copies)
+}
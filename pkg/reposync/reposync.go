@@ -0,0 +1,208 @@
+// Package reposync copies all tags of a registry repository to another repository, e.g. as the core of a registry
+// mirroring tool.
+//
+// Copy lists the tags of the source repository, selects the tags to copy using include and exclude patterns,
+// and copies the image of every selected tag, one at a time, to the same tag in the destination repository.
+// All copies share a single blob info cache, and (unless the caller provides one) a single docker.Session, so that
+// connections and bearer tokens are reused instead of authenticating again for every tag.
+package reposync
+
+import (
+	"context"
+	"fmt"
+	"regexp"
+	"strings"
+
+	"github.com/containers/image/v5/copy"
+	"github.com/containers/image/v5/docker"
+	"github.com/containers/image/v5/docker/reference"
+	"github.com/containers/image/v5/pkg/blobinfocache"
+	"github.com/containers/image/v5/signature"
+	"github.com/containers/image/v5/types"
+	"github.com/pkg/errors"
+	"github.com/sirupsen/logrus"
+)
+
+// Options control Copy.
+type Options struct {
+	// CopyOptions are used for every copied image; CopyOptions.SourceCtx is also used to list the source tags.
+	// May be nil.  CopyOptions.AdditionalTags must be empty.
+	CopyOptions *copy.Options
+	// IncludeTags, if not empty, restricts the copied tags to those matching at least one of the regular expressions.
+	// Note that the expressions are not anchored; use e.g. ^v1\. to match a prefix.
+	IncludeTags []*regexp.Regexp
+	// ExcludeTags lists regular expressions matching tags which are not copied, even if they match IncludeTags.
+	ExcludeTags []*regexp.Regexp
+	// ReportResult, if set, is called after every tag is copied (or fails to be copied).
+	ReportResult func(TagResult)
+}
+
+// TagResult is the outcome of copying a single tag.
+type TagResult struct {
+	Tag    string
+	Result *copy.Result // nil if the copy failed
+	Err    error        // nil if the image was copied successfully
+}
+
+// Result describes the tags copied by Copy.
+type Result struct {
+	// Tags lists the outcome for every selected tag, in the order the registry listed them.
+	Tags []TagResult
+}
+
+// Error is returned by Copy if copying some of the tags failed; the other tags were copied.
+type Error struct {
+	Failed []TagResult // Only the failed tags
+}
+
+func (e *Error) Error() string {
+	failures := make([]string, 0, len(e.Failed))
+	for _, r := range e.Failed {
+		failures = append(failures, fmt.Sprintf("%s: %v", r.Tag, r.Err))
+	}
+	return fmt.Sprintf("copying %d tags failed: %s", len(e.Failed), strings.Join(failures, "; "))
+}
+
+// syncer implements Copy.
+type syncer struct {
+	policyContext *signature.PolicyContext
+	options       Options
+
+	// Hooks for tests
+	listTags  func(ctx context.Context, sys *types.SystemContext, ref types.ImageReference) ([]string, error)
+	copyImage func(ctx context.Context, policyContext *signature.PolicyContext, destRef, srcRef types.ImageReference, options *copy.Options) (*copy.Result, error)
+}
+
+// Copy copies the images of all tags of srcRepo (a docker:// repository, without a tag or a digest) selected by options
+// (which may be nil) to the same tags of destRepo, using policyContext to decide which images can be copied.
+// It returns the outcome for every tag; if copying some of the tags failed, it returns both the result and an *Error.
+// Copy stops early, returning ctx.Err(), if ctx is canceled.
+func Copy(ctx context.Context, policyContext *signature.PolicyContext, destRepo, srcRepo reference.Named, options *Options) (*Result, error) {
+	s := &syncer{
+		policyContext: policyContext,
+		listTags:      docker.GetRepositoryTags,
+		copyImage:     copy.ImageWithResult,
+	}
+	if options != nil {
+		s.options = *options
+	}
+	return s.copy(ctx, destRepo, srcRepo)
+}
+
+// copy implements Copy.
+func (s *syncer) copy(ctx context.Context, destRepo, srcRepo reference.Named) (*Result, error) {
+	if !reference.IsNameOnly(srcRepo) || !reference.IsNameOnly(destRepo) {
+		return nil, errors.Errorf("repositories to copy must not include a tag or a digest, got %s and %s",
+			reference.FamiliarString(srcRepo), reference.FamiliarString(destRepo))
+	}
+	copyOptions := copy.Options{}
+	if s.options.CopyOptions != nil {
+		copyOptions = *s.options.CopyOptions
+	}
+	if len(copyOptions.AdditionalTags) != 0 {
+		return nil, errors.New("copying a repository with additional tags is not supported")
+	}
+
+	sourceCtx, destCtx := copyOptions.SourceCtx, copyOptions.DestinationCtx
+	if (sourceCtx == nil || sourceCtx.DockerSession == nil) && (destCtx == nil || destCtx.DockerSession == nil) {
+		session := docker.NewSession()
+		defer session.Close()
+		copyOptions.SourceCtx = withSession(sourceCtx, session)
+		copyOptions.DestinationCtx = withSession(destCtx, session)
+	}
+	if copyOptions.BlobInfoCache == nil {
+		copyOptions.BlobInfoCache = blobinfocache.DefaultCache(copyOptions.DestinationCtx)
+	}
+
+	listRef, err := docker.NewReference(reference.TagNameOnly(srcRepo))
+	if err != nil {
+		return nil, err
+	}
+	tags, err := s.listTags(ctx, copyOptions.SourceCtx, listRef)
+	if err != nil {
+		return nil, errors.Wrapf(err, "listing tags of %s", reference.FamiliarString(srcRepo))
+	}
+
+	res := &Result{Tags: []TagResult{}}
+	var failed []TagResult
+	for _, tag := range tags {
+		if !s.selected(tag) {
+			logrus.Debugf("Skipping tag %s", tag)
+			continue
+		}
+		if err := ctx.Err(); err != nil {
+			return res, err
+		}
+		r := s.copyTag(ctx, destRepo, srcRepo, tag, &copyOptions)
+		if s.options.ReportResult != nil {
+			s.options.ReportResult(r)
+		}
+		res.Tags = append(res.Tags, r)
+		if r.Err != nil {
+			failed = append(failed, r)
+		}
+	}
+	if len(failed) != 0 {
+		return res, &Error{Failed: failed}
+	}
+	return res, nil
+}
+
+// selected returns true if tag should be copied.
+func (s *syncer) selected(tag string) bool {
+	for _, re := range s.options.ExcludeTags {
+		if re.MatchString(tag) {
+			return false
+		}
+	}
+	if len(s.options.IncludeTags) == 0 {
+		return true
+	}
+	for _, re := range s.options.IncludeTags {
+		if re.MatchString(tag) {
+			return true
+		}
+	}
+	return false
+}
+
+// copyTag copies tag from srcRepo to destRepo.
+func (s *syncer) copyTag(ctx context.Context, destRepo, srcRepo reference.Named, tag string, options *copy.Options) TagResult {
+	res := TagResult{Tag: tag}
+	srcRef, err := taggedReference(srcRepo, tag)
+	if err != nil {
+		res.Err = err
+		return res
+	}
+	destRef, err := taggedReference(destRepo, tag)
+	if err != nil {
+		res.Err = err
+		return res
+	}
+	logrus.Debugf("Copying tag %s of %s to %s", tag, reference.FamiliarString(srcRepo), reference.FamiliarString(destRepo))
+	res.Result, res.Err = s.copyImage(ctx, s.policyContext, destRef, srcRef, options)
+	if res.Err != nil {
+		res.Result = nil
+		logrus.Debugf("Error copying tag %s: %v", tag, res.Err)
+	}
+	return res
+}
+
+// taggedReference returns a docker:// reference to tag in repo.
+func taggedReference(repo reference.Named, tag string) (types.ImageReference, error) {
+	tagged, err := reference.WithTag(repo, tag)
+	if err != nil {
+		return nil, errors.Wrapf(err, "invalid tag %q", tag)
+	}
+	return docker.NewReference(tagged)
+}
+
+// withSession returns a copy of sys (which may be nil) using session.
+func withSession(sys *types.SystemContext, session *docker.Session) *types.SystemContext {
+	res := types.SystemContext{}
+	if sys != nil {
+		res = *sys
+	}
+	res.DockerSession = session
+	return &res
+}
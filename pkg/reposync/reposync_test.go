@@ -0,0 +1,160 @@
+package reposync
+
+import (
+	"context"
+	"errors"
+	"regexp"
+	"testing"
+
+	"github.com/containers/image/v5/copy"
+	"github.com/containers/image/v5/docker"
+	"github.com/containers/image/v5/docker/reference"
+	"github.com/containers/image/v5/pkg/blobinfocache/memory"
+	"github.com/containers/image/v5/signature"
+	"github.com/containers/image/v5/types"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// testRegistry simulates the source and destination repositories of a syncer.
+type testRegistry struct {
+	tags     []string
+	failing  map[string]bool // Tags which fail to be copied
+	listRefs []string
+	copies   []string // "src → dest" for every copy
+	options  []*copy.Options
+}
+
+func (r *testRegistry) install(s *syncer) {
+	s.listTags = func(ctx context.Context, sys *types.SystemContext, ref types.ImageReference) ([]string, error) {
+		r.listRefs = append(r.listRefs, ref.StringWithinTransport())
+		return r.tags, nil
+	}
+	s.copyImage = func(ctx context.Context, policyContext *signature.PolicyContext, destRef, srcRef types.ImageReference, options *copy.Options) (*copy.Result, error) {
+		r.copies = append(r.copies, srcRef.StringWithinTransport()+" → "+destRef.StringWithinTransport())
+		r.options = append(r.options, options)
+		if r.failing[srcRef.DockerReference().(reference.Tagged).Tag()] {
+			return &copy.Result{}, errors.New("copy failed")
+		}
+		return &copy.Result{Manifest: []byte("manifest")}, nil
+	}
+}
+
+// newTestSyncer returns a syncer using r, and options; if options.CopyOptions is nil, it uses a memory-only
+// blob info cache.
+func newTestSyncer(r *testRegistry, options Options) *syncer {
+	if options.CopyOptions == nil {
+		options.CopyOptions = &copy.Options{BlobInfoCache: memory.New()}
+	}
+	s := &syncer{options: options}
+	r.install(s)
+	return s
+}
+
+func TestSyncerCopy(t *testing.T) {
+	srcRepo, err := reference.ParseNormalizedNamed("src.example.com/ns/repo")
+	require.NoError(t, err)
+	destRepo, err := reference.ParseNormalizedNamed("dest.example.com/mirror/repo")
+	require.NoError(t, err)
+
+	// All tags
+	destCtx := &types.SystemContext{BlobInfoCacheDir: t.TempDir()}
+	r := &testRegistry{tags: []string{"latest", "v1.0", "v1.1"}}
+	res, err := newTestSyncer(r, Options{CopyOptions: &copy.Options{DestinationCtx: destCtx}}).copy(context.Background(), destRepo, srcRepo)
+	require.NoError(t, err)
+	assert.Equal(t, []string{"//src.example.com/ns/repo:latest"}, r.listRefs)
+	assert.Equal(t, []string{
+		"//src.example.com/ns/repo:latest → //dest.example.com/mirror/repo:latest",
+		"//src.example.com/ns/repo:v1.0 → //dest.example.com/mirror/repo:v1.0",
+		"//src.example.com/ns/repo:v1.1 → //dest.example.com/mirror/repo:v1.1",
+	}, r.copies)
+	require.Len(t, res.Tags, 3)
+	for i, tr := range res.Tags {
+		assert.Equal(t, r.tags[i], tr.Tag)
+		assert.NoError(t, tr.Err)
+		assert.Equal(t, []byte("manifest"), tr.Result.Manifest)
+	}
+	// All copies share a session and a blob info cache
+	require.Len(t, r.options, 3)
+	session := r.options[0].SourceCtx.DockerSession
+	assert.NotNil(t, session)
+	assert.NotNil(t, r.options[0].BlobInfoCache)
+	for _, o := range r.options {
+		assert.Equal(t, session, o.SourceCtx.DockerSession)
+		assert.Equal(t, session, o.DestinationCtx.DockerSession)
+		assert.Equal(t, r.options[0].BlobInfoCache, o.BlobInfoCache)
+		assert.Equal(t, destCtx.BlobInfoCacheDir, o.DestinationCtx.BlobInfoCacheDir)
+	}
+	assert.Nil(t, destCtx.DockerSession)
+
+	// A caller-provided session is used, and the caller’s options are not modified
+	callerSession := docker.NewSession()
+	defer callerSession.Close()
+	sourceCtx := &types.SystemContext{DockerSession: callerSession}
+	r = &testRegistry{tags: []string{"latest"}}
+	_, err = newTestSyncer(r, Options{CopyOptions: &copy.Options{SourceCtx: sourceCtx, BlobInfoCache: memory.New()}}).copy(context.Background(), destRepo, srcRepo)
+	require.NoError(t, err)
+	assert.Equal(t, sourceCtx, r.options[0].SourceCtx)
+	assert.Nil(t, r.options[0].DestinationCtx)
+
+	// Include and exclude patterns
+	r = &testRegistry{tags: []string{"latest", "v1.0", "v1.1", "v1.1-rc1", "v2.0"}}
+	res, err = newTestSyncer(r, Options{
+		IncludeTags: []*regexp.Regexp{regexp.MustCompile(`^v1\.`), regexp.MustCompile(`^latest$`)},
+		ExcludeTags: []*regexp.Regexp{regexp.MustCompile(`-rc`)},
+	}).copy(context.Background(), destRepo, srcRepo)
+	require.NoError(t, err)
+	tags := []string{}
+	for _, tr := range res.Tags {
+		tags = append(tags, tr.Tag)
+	}
+	assert.Equal(t, []string{"latest", "v1.0", "v1.1"}, tags)
+
+	// Failures are reported, and remaining tags are still copied
+	r = &testRegistry{tags: []string{"a", "b", "c"}, failing: map[string]bool{"b": true}}
+	reported := []string{}
+	res, err = newTestSyncer(r, Options{ReportResult: func(tr TagResult) { reported = append(reported, tr.Tag) }}).
+		copy(context.Background(), destRepo, srcRepo)
+	var syncErr *Error
+	require.True(t, errors.As(err, &syncErr))
+	require.Len(t, syncErr.Failed, 1)
+	assert.Equal(t, "b", syncErr.Failed[0].Tag)
+	assert.Equal(t, []string{"a", "b", "c"}, reported)
+	require.Len(t, res.Tags, 3)
+	assert.Nil(t, res.Tags[1].Result)
+	assert.Error(t, res.Tags[1].Err)
+	assert.NoError(t, res.Tags[2].Err)
+
+	// Cancellation stops the copy
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+	r = &testRegistry{tags: []string{"a"}}
+	_, err = newTestSyncer(r, Options{}).copy(ctx, destRepo, srcRepo)
+	assert.ErrorIs(t, err, context.Canceled)
+	assert.Empty(t, r.copies)
+
+	// Invalid inputs
+	tagged, err := reference.ParseNormalizedNamed("src.example.com/ns/repo:tag")
+	require.NoError(t, err)
+	for _, c := range []struct {
+		dest, src reference.Named
+		options   Options
+	}{
+		{destRepo, tagged, Options{}},
+		{tagged, srcRepo, Options{}},
+		{destRepo, srcRepo, Options{CopyOptions: &copy.Options{AdditionalTags: []string{"other"}}}},
+	} {
+		r = &testRegistry{tags: []string{"a"}}
+		_, err = newTestSyncer(r, c.options).copy(context.Background(), c.dest, c.src)
+		assert.Error(t, err)
+		assert.Empty(t, r.listRefs)
+	}
+}
+
+func TestErrorError(t *testing.T) {
+	err := &Error{Failed: []TagResult{
+		{Tag: "a", Err: errors.New("e1")},
+		{Tag: "b", Err: errors.New("e2")},
+	}}
+	assert.Equal(t, "copying 2 tags failed: a: e1; b: e2", err.Error())
+}
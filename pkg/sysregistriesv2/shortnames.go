@@ -9,8 +9,8 @@ import (
 	"github.com/BurntSushi/toml"
 	"github.com/containers/image/v5/docker/reference"
 	"github.com/containers/image/v5/internal/rootless"
+	"github.com/containers/image/v5/internal/userdirs"
 	"github.com/containers/image/v5/types"
-	"github.com/containers/storage/pkg/homedir"
 	"github.com/containers/storage/pkg/lockfile"
 	"github.com/pkg/errors"
 	"github.com/sirupsen/logrus"
@@ -26,22 +26,43 @@ var userShortNamesFile = filepath.FromSlash("containers/short-name-aliases.conf"
 // shortNameAliasesConfPath returns the path to the machine-generated
 // short-name-aliases.conf file.
 func shortNameAliasesConfPath(ctx *types.SystemContext) (string, error) {
+	location, err := GetShortNameAliasesConfLocation(ctx)
+	if err != nil {
+		return "", err
+	}
+	return location.Path, nil
+}
+
+// GetShortNameAliasesConfLocation returns the location of the machine-generated short-name-aliases.conf file,
+// and whether it is in the ctx.CacheDirFallback directory.
+func GetShortNameAliasesConfLocation(ctx *types.SystemContext) (types.ResolvedLocation, error) {
 	if ctx != nil && len(ctx.UserShortNameAliasConfPath) > 0 {
-		return ctx.UserShortNameAliasConfPath, nil
+		return types.ResolvedLocation{Path: ctx.UserShortNameAliasConfPath}, nil
 	}
 
 	if rootless.GetRootlessEUID() == 0 {
 		// Root user or in a non-conforming user NS
-		return filepath.Join("/var/cache", userShortNamesFile), nil
+		return types.ResolvedLocation{Path: filepath.Join("/var/cache", userShortNamesFile)}, nil
 	}
 
 	// Rootless user
-	cacheRoot, err := homedir.GetCacheHome()
+	fallback := ""
+	if ctx != nil {
+		fallback = ctx.CacheDirFallback
+	}
+	cacheRoot, fallbackReason, err := userdirs.CacheDir(fallback)
 	if err != nil {
-		return "", err
+		return types.ResolvedLocation{}, err
+	}
+	if fallbackReason != "" {
+		logrus.Debugf("Using %q instead of the cache directory for short-name aliases: %s", cacheRoot, fallbackReason)
 	}
 
-	return filepath.Join(cacheRoot, userShortNamesFile), nil
+	return types.ResolvedLocation{
+		Path:           filepath.Join(cacheRoot, userShortNamesFile),
+		Fallback:       fallbackReason != "",
+		FallbackReason: fallbackReason,
+	}, nil
 }
 
 // shortNameAliasConf is a subset of the `V2RegistriesConf` format.  It's used in the
@@ -2,6 +2,7 @@ package sysregistriesv2
 
 import (
 	"os"
+	"path/filepath"
 	"testing"
 
 	"github.com/containers/image/v5/types"
@@ -32,6 +33,46 @@ func TestShortNameAliasConfNonempty(t *testing.T) {
 	}
 }
 
+func TestGetShortNameAliasesConfLocation(t *testing.T) {
+	tmpDir := t.TempDir()
+	notADir := filepath.Join(tmpDir, "file")
+	err := os.WriteFile(notADir, []byte{}, 0600)
+	require.NoError(t, err)
+
+	// Environment is per-process, so this looks very unsafe; actually it seems fine because tests are not
+	// run in parallel unless they opt in by calling t.Parallel().  So don’t do that.
+	for _, env := range []string{"_CONTAINERS_ROOTLESS_UID", "XDG_CACHE_HOME"} {
+		old, hasOld := os.LookupEnv(env)
+		defer func(env string) {
+			if hasOld {
+				os.Setenv(env, old)
+			} else {
+				os.Unsetenv(env)
+			}
+		}(env)
+	}
+	os.Setenv("_CONTAINERS_ROOTLESS_UID", "1000")
+
+	location, err := GetShortNameAliasesConfLocation(&types.SystemContext{UserShortNameAliasConfPath: "/explicit.conf", CacheDirFallback: "/fallback"})
+	require.NoError(t, err)
+	assert.Equal(t, types.ResolvedLocation{Path: "/explicit.conf"}, location)
+
+	os.Setenv("XDG_CACHE_HOME", tmpDir)
+	location, err = GetShortNameAliasesConfLocation(&types.SystemContext{CacheDirFallback: "/fallback"})
+	require.NoError(t, err)
+	assert.Equal(t, types.ResolvedLocation{Path: filepath.Join(tmpDir, "containers", "short-name-aliases.conf")}, location)
+
+	os.Setenv("XDG_CACHE_HOME", notADir)
+	location, err = GetShortNameAliasesConfLocation(&types.SystemContext{})
+	require.NoError(t, err)
+	assert.Equal(t, types.ResolvedLocation{Path: filepath.Join(notADir, "containers", "short-name-aliases.conf")}, location)
+	location, err = GetShortNameAliasesConfLocation(&types.SystemContext{CacheDirFallback: "/fallback"})
+	require.NoError(t, err)
+	assert.Equal(t, filepath.Join("/fallback", "containers", "short-name-aliases.conf"), location.Path)
+	assert.True(t, location.Fallback)
+	assert.NotEmpty(t, location.FallbackReason)
+}
+
 func TestParseShortNameValue(t *testing.T) {
 	tests := []struct {
 		input string
@@ -64,6 +64,13 @@ type Endpoint struct {
 	// If true, certs verification will be skipped and HTTP (non-TLS)
 	// connections will be allowed.
 	Insecure bool `toml:"insecure,omitempty"`
+	// If true, certs verification will be skipped and HTTP (non-TLS)
+	// connections will be allowed, like with Insecure, but only when accessing
+	// references which contain a digest; the manifest and all blobs are then
+	// verified against digests, so their contents can't be modified in transit.
+	// No credentials are sent over such connections.
+	// Must not be set together with Insecure.
+	InsecureDigestOnly bool `toml:"insecure-digest-only,omitempty"`
 	// PullFromMirror is used for adding restrictions to image pull through the mirror.
 	// Set to "all", "digest-only", or "tag-only".
 	// If "digest-only"， mirrors will only be used for digest pulls. Pulling images by
@@ -76,6 +83,109 @@ type Endpoint struct {
 	// This can only be set in a registry's Mirror field, not in the registry's primary Endpoint.
 	// This per-mirror setting is allowed only when mirror-by-digest-only is not configured for the primary registry.
 	PullFromMirror string `toml:"pull-from-mirror,omitempty"`
+	// If not nil, a client-side limit on requests sent to the host of the endpoint's location,
+	// shared by all operations in the process.
+	// Please refer to RateLimitForHost instead of accessing/interpreting `RateLimit` directly.
+	RateLimit *RateLimit `toml:"rate-limit,omitempty"`
+	// Rules for computing the reference to use with this endpoint, for locations which can't be expressed by
+	// replacing the registry's prefix with Location (e.g. pull-through caches which restructure repository paths).
+	// The first rule matching a reference is used; if none matches, the reference is rewritten using Location.
+	Rewrite []RewriteRule `toml:"rewrite,omitempty"`
+}
+
+// RewriteRule maps repository names matching a regular expression to a different repository name.
+type RewriteRule struct {
+	// A regular expression (RE2 syntax), which must match the whole repository name (without a tag or digest)
+	// of the user-specified reference, e.g. "docker.io/library/(.*)".
+	Source string `toml:"source"`
+	// The repository name to use instead, which can refer to submatches of Source, e.g. "cache.corp/dockerhub/$1".
+	// See regexp.Regexp.Expand for the syntax; note that "${1}" must be used if a submatch is followed by
+	// characters allowed in submatch names.
+	Destination string `toml:"destination"`
+}
+
+// compile returns the regular expression to match repository names against.
+func (r *RewriteRule) compile() (*regexp.Regexp, error) {
+	return regexp.Compile("^(?:" + r.Source + ")$")
+}
+
+// validateRewriteRules returns an error if rules, used in an endpoint at location, are invalid.
+func validateRewriteRules(rules []RewriteRule, location string) error {
+	for _, rule := range rules {
+		if _, err := rule.compile(); err != nil {
+			return &InvalidRegistries{s: fmt.Sprintf("invalid rewrite source %q for %q: %v", rule.Source, location, err)}
+		}
+		if rule.Destination == "" {
+			return &InvalidRegistries{s: fmt.Sprintf("rewrite destination for source %q for %q is unset", rule.Source, location)}
+		}
+	}
+	return nil
+}
+
+// applyRewriteRules returns ref rewritten using the first of e.Rewrite which matches it, or nil if none does.
+func (e *Endpoint) applyRewriteRules(ref reference.Named) (reference.Named, error) {
+	name := ref.Name()
+	for _, rule := range e.Rewrite {
+		re, err := rule.compile()
+		if err != nil {
+			return nil, errors.Wrapf(err, "invalid rewrite source %q", rule.Source)
+		}
+		match := re.FindStringSubmatchIndex(name)
+		if match == nil {
+			continue
+		}
+		newName := string(re.ExpandString(nil, rule.Destination, name, match))
+		newRef, err := reference.ParseNamed(newName)
+		if err != nil {
+			return nil, errors.Wrapf(err, "rewriting reference %q using rewrite rule %q", ref.String(), rule.Source)
+		}
+		if !reference.IsNameOnly(newRef) {
+			return nil, errors.Errorf("rewriting reference %q using rewrite rule %q: %q is not a repository name", ref.String(), rule.Source, newName)
+		}
+		if tagged, ok := ref.(reference.NamedTagged); ok {
+			if newRef, err = reference.WithTag(newRef, tagged.Tag()); err != nil {
+				return nil, errors.Wrapf(err, "rewriting reference")
+			}
+		}
+		if digested, ok := ref.(reference.Canonical); ok {
+			if newRef, err = reference.WithDigest(newRef, digested.Digest()); err != nil {
+				return nil, errors.Wrapf(err, "rewriting reference")
+			}
+		}
+		return newRef, nil
+	}
+	return nil, nil
+}
+
+// RateLimit describes a client-side limit on requests sent to a registry host.
+type RateLimit struct {
+	// The sustained number of requests per second; if 0, the rate is not limited.
+	RequestsPerSecond float64 `toml:"requests-per-second,omitempty"`
+	// The number of requests which can be sent at once, above RequestsPerSecond; if 0, 1 is used.
+	Burst int `toml:"burst,omitempty"`
+	// The maximum number of requests in flight at the same time; if 0, the number is not limited.
+	MaxConcurrentRequests int `toml:"max-concurrent-requests,omitempty"`
+}
+
+// validate returns an error if r, used in an endpoint at location, is invalid.
+func (r *RateLimit) validate(location string) error {
+	if r == nil {
+		return nil
+	}
+	if r.RequestsPerSecond < 0 || r.Burst < 0 || r.MaxConcurrentRequests < 0 {
+		return &InvalidRegistries{s: fmt.Sprintf("invalid rate-limit for %q: values must not be negative", location)}
+	}
+	return nil
+}
+
+// InsecureFor returns true if certs verification may be skipped, and HTTP (non-TLS)
+// connections allowed, when accessing ref through the endpoint.
+func (e *Endpoint) InsecureFor(ref reference.Named) bool {
+	if e.Insecure {
+		return true
+	}
+	_, isDigested := ref.(reference.Canonical)
+	return e.InsecureDigestOnly && isDigested
 }
 
 // userRegistriesFile is the path to the per user registry configuration file.
@@ -85,9 +195,18 @@ var userRegistriesFile = filepath.FromSlash(".config/containers/registries.conf"
 var userRegistriesDir = filepath.FromSlash(".config/containers/registries.conf.d")
 
 // rewriteReference will substitute the provided reference `prefix` to the
-// endpoints `location` from the `ref` and creates a new named reference from it.
+// endpoints `location` from the `ref` and creates a new named reference from it,
+// unless one of the endpoint's rewrite rules applies.
 // The function errors if the newly created reference is not parsable.
 func (e *Endpoint) rewriteReference(ref reference.Named, prefix string) (reference.Named, error) {
+	rewritten, err := e.applyRewriteRules(ref)
+	if err != nil {
+		return nil, err
+	}
+	if rewritten != nil {
+		return rewritten, nil
+	}
+
 	refString := ref.String()
 	var newNamedRef string
 	// refMatchingPrefix returns the length of the match. Everything that
@@ -235,6 +354,22 @@ type V2RegistriesConf struct {
 	// If empty, CredentialHelpers defaults to  ["containers-auth.json"].
 	CredentialHelpers []string `toml:"credential-helpers"`
 
+	// CredentialHelperFailover defines how CredentialHelpers are consulted
+	// when more than one is configured.
+	//
+	// Valid modes are: * "first-success": use the first helper which
+	// succeeds, report errors only if none does * "try-all": merge
+	// credentials from all helpers, store credentials in all helpers * "fail-fast":
+	// abort on the first helper error * "ignore-errors": treat lookup
+	// errors as missing credentials
+	CredentialHelperFailover string `toml:"credential-helper-failover"`
+
+	// RequireCredentialHelper, if true, forbids storing credentials in
+	// plain text (base64-encoded) in auth files; credentials can then only
+	// be stored using credential helpers.  Once enabled by any
+	// configuration file, drop-in files can't disable it again.
+	RequireCredentialHelper bool `toml:"require-credential-helper"`
+
 	// ShortNameMode defines how short-name resolution should be handled by
 	// _consumers_ of this package.  Depending on the mode, the user should
 	// be prompted with a choice of using one of the unqualified-search
@@ -286,6 +421,10 @@ type parsedConfig struct {
 	// the full configuration in configCache / getConfig() always contains a valid value.
 	shortNameMode types.ShortNameMode
 	aliasCache    *shortNameAliasCache
+	// Result of parsing of partialV2.CredentialHelperFailover.
+	// NOTE: May be CredentialHelperFailoverInvalid to represent CredentialHelperFailover == "" in intermediate values;
+	// the full configuration in configCache / getConfig() always contains a valid value.
+	credentialHelperFailoverMode types.CredentialHelperFailoverMode
 }
 
 // InvalidRegistries represents an invalid registry configurations.  An example
@@ -413,6 +552,15 @@ func (config *V2RegistriesConf) postProcessRegistries() error {
 		if reg.PullFromMirror != "" {
 			return fmt.Errorf("pull-from-mirror must not be set for a non-mirror registry %q", reg.Prefix)
 		}
+		if reg.Insecure && reg.InsecureDigestOnly {
+			return &InvalidRegistries{s: fmt.Sprintf("cannot set insecure and insecure-digest-only for the registry %q at the same time", reg.Prefix)}
+		}
+		if err := reg.RateLimit.validate(reg.Prefix); err != nil {
+			return err
+		}
+		if err := validateRewriteRules(reg.Rewrite, reg.Prefix); err != nil {
+			return err
+		}
 		// make sure mirrors are valid
 		for _, mir := range reg.Mirrors {
 			mir.Location, err = parseLocation(mir.Location)
@@ -427,6 +575,15 @@ func (config *V2RegistriesConf) postProcessRegistries() error {
 				return &InvalidRegistries{s: "invalid condition: mirror location is unset"}
 			}
 
+			if mir.Insecure && mir.InsecureDigestOnly {
+				return &InvalidRegistries{s: fmt.Sprintf("cannot set insecure and insecure-digest-only for the mirror %q at the same time", mir.Location)}
+			}
+			if err := mir.RateLimit.validate(mir.Location); err != nil {
+				return err
+			}
+			if err := validateRewriteRules(mir.Rewrite, mir.Location); err != nil {
+				return err
+			}
 			if reg.MirrorByDigestOnly && mir.PullFromMirror != "" {
 				return &InvalidRegistries{s: fmt.Sprintf("cannot set mirror usage mirror-by-digest-only for the registry (%q) and pull-from-mirror for per-mirror (%q) at the same time", reg.Prefix, mir.Location)}
 			}
@@ -465,6 +622,11 @@ func (config *V2RegistriesConf) postProcessRegistries() error {
 				return &InvalidRegistries{s: msg}
 			}
 
+			if reg.InsecureDigestOnly != other.InsecureDigestOnly {
+				msg := fmt.Sprintf("registry '%s' is defined multiple times with conflicting 'insecure-digest-only' setting", reg.Location)
+				return &InvalidRegistries{s: msg}
+			}
+
 			if reg.Blocked != other.Blocked {
 				msg := fmt.Sprintf("registry '%s' is defined multiple times with conflicting 'blocked' setting", reg.Location)
 				return &InvalidRegistries{s: msg}
@@ -742,6 +904,10 @@ func tryUpdatingCache(ctx *types.SystemContext, wrapper configWrapper) (*parsedC
 		config.partialV2.CredentialHelpers = []string{AuthenticationFileHelper}
 	}
 
+	if config.credentialHelperFailoverMode == types.CredentialHelperFailoverInvalid {
+		config.credentialHelperFailoverMode = types.CredentialHelperFailoverFirstSuccess
+	}
+
 	// populate the cache
 	configCache[wrapper] = config
 	return config, nil
@@ -808,6 +974,12 @@ func GetShortNameMode(ctx *types.SystemContext) (types.ShortNameMode, error) {
 
 // CredentialHelpers returns the global top-level credential helpers.
 func CredentialHelpers(sys *types.SystemContext) ([]string, error) {
+	if sys != nil && sys.CredentialHelpers != nil {
+		if len(sys.CredentialHelpers) == 0 {
+			return []string{AuthenticationFileHelper}, nil
+		}
+		return sys.CredentialHelpers, nil
+	}
 	config, err := getConfig(sys)
 	if err != nil {
 		return nil, err
@@ -815,6 +987,48 @@ func CredentialHelpers(sys *types.SystemContext) ([]string, error) {
 	return config.partialV2.CredentialHelpers, nil
 }
 
+// CredentialHelperRequired returns true if credentials must not be stored
+// in plain text in auth files.
+func CredentialHelperRequired(sys *types.SystemContext) (bool, error) {
+	if sys != nil && sys.RequireCredentialHelper != types.OptionalBoolUndefined {
+		return sys.RequireCredentialHelper == types.OptionalBoolTrue, nil
+	}
+	config, err := getConfig(sys)
+	if err != nil {
+		return false, err
+	}
+	return config.partialV2.RequireCredentialHelper, nil
+}
+
+// parseCredentialHelperFailoverMode translates the string into well-typed
+// types.CredentialHelperFailoverMode.
+func parseCredentialHelperFailoverMode(mode string) (types.CredentialHelperFailoverMode, error) {
+	switch mode {
+	case "first-success":
+		return types.CredentialHelperFailoverFirstSuccess, nil
+	case "try-all":
+		return types.CredentialHelperFailoverTryAll, nil
+	case "fail-fast":
+		return types.CredentialHelperFailoverFailFast, nil
+	case "ignore-errors":
+		return types.CredentialHelperFailoverIgnoreErrors, nil
+	default:
+		return types.CredentialHelperFailoverInvalid, errors.Errorf("invalid credential-helper-failover mode: %q", mode)
+	}
+}
+
+// GetCredentialHelperFailoverMode returns the configured types.CredentialHelperFailoverMode.
+func GetCredentialHelperFailoverMode(sys *types.SystemContext) (types.CredentialHelperFailoverMode, error) {
+	if sys != nil && sys.CredentialHelperFailoverMode != nil {
+		return *sys.CredentialHelperFailoverMode, nil
+	}
+	config, err := getConfig(sys)
+	if err != nil {
+		return types.CredentialHelperFailoverInvalid, err
+	}
+	return config.credentialHelperFailoverMode, nil
+}
+
 // refMatchingSubdomainPrefix returns the length of ref
 // iff ref, which is a registry, repository namespace, repository or image reference (as formatted by
 // reference.Domain(), reference.Named.Name() or reference.Reference.String()
@@ -911,6 +1125,39 @@ func findRegistryWithParsedConfig(config *parsedConfig, ref string) (*Registry,
 	return nil, nil
 }
 
+// RateLimitForHost returns the client-side limit on requests sent to host (host[:port]) configured in registries.conf,
+// or nil if there is none.  Limits are configured per endpoint, but apply to the whole host: if several
+// registries or mirrors on the same host configure a limit, the first one, in the order of the configuration,
+// is used.
+func RateLimitForHost(ctx *types.SystemContext, host string) (*RateLimit, error) {
+	config, err := getConfig(ctx)
+	if err != nil {
+		return nil, err
+	}
+
+	for _, reg := range config.partialV2.Registries {
+		endpoints := append([]Endpoint{reg.Endpoint}, reg.Mirrors...)
+		for _, e := range endpoints {
+			if e.RateLimit == nil || e.Location == "" {
+				continue
+			}
+			if endpointHost(e.Location) == host {
+				rl := *e.RateLimit
+				return &rl, nil
+			}
+		}
+	}
+	return nil, nil
+}
+
+// endpointHost returns the host[:port] part of an endpoint location.
+func endpointHost(location string) string {
+	if i := strings.IndexRune(location, '/'); i != -1 {
+		return location[:i]
+	}
+	return location
+}
+
 // loadConfigFile loads and unmarshals a single config file.
 // Use forceV2 if the config must in the v2 format.
 func loadConfigFile(path string, forceV2 bool) (*parsedConfig, error) {
@@ -969,6 +1216,16 @@ func loadConfigFile(path string, forceV2 bool) (*parsedConfig, error) {
 		res.shortNameMode = types.ShortNameModeInvalid
 	}
 
+	if len(res.partialV2.CredentialHelperFailover) > 0 {
+		mode, err := parseCredentialHelperFailoverMode(res.partialV2.CredentialHelperFailover)
+		if err != nil {
+			return nil, err
+		}
+		res.credentialHelperFailoverMode = mode
+	} else {
+		res.credentialHelperFailoverMode = types.CredentialHelperFailoverInvalid
+	}
+
 	// Valid wildcarded prefixes must be in the format: *.example.com
 	// FIXME: Move to postProcessRegistries
 	// https://github.com/containers/image/pull/1191#discussion_r610623829
@@ -1038,6 +1295,18 @@ func (c *parsedConfig) updateWithConfigurationFrom(updates *parsedConfig) {
 		c.partialV2.CredentialHelpers = updates.partialV2.CredentialHelpers
 	}
 
+	// == Merge RequireCredentialHelper:
+	// This is a security policy, so it can only be enabled, not disabled again.
+	if updates.partialV2.RequireCredentialHelper {
+		c.partialV2.RequireCredentialHelper = true
+	}
+
+	// == Merge credentialHelperFailoverMode:
+	// We don’t maintain c.partialV2.CredentialHelperFailover.
+	if updates.credentialHelperFailoverMode != types.CredentialHelperFailoverInvalid {
+		c.credentialHelperFailoverMode = updates.credentialHelperFailoverMode
+	}
+
 	// == Merge shortNameMode:
 	// We don’t maintain c.partialV2.ShortNameMode.
 	if updates.shortNameMode != types.ShortNameModeInvalid {
@@ -132,6 +132,28 @@ func TestMirrors(t *testing.T) {
 	assert.True(t, reg.Mirrors[1].Insecure)
 }
 
+func TestEndpointInsecureFor(t *testing.T) {
+	tagged, err := reference.ParseNamed("registry.com/image:tag")
+	require.NoError(t, err)
+	digested, err := reference.ParseNamed("registry.com/image@sha256:aaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaa")
+	require.NoError(t, err)
+	taggedAndDigested, err := reference.ParseNamed("registry.com/image:tag@sha256:aaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaa")
+	require.NoError(t, err)
+
+	for _, c := range []struct {
+		endpoint                 Endpoint
+		tagged, digested, tagDig bool
+	}{
+		{Endpoint{}, false, false, false},
+		{Endpoint{Insecure: true}, true, true, true},
+		{Endpoint{InsecureDigestOnly: true}, false, true, true},
+	} {
+		assert.Equal(t, c.tagged, c.endpoint.InsecureFor(tagged), "%#v", c.endpoint)
+		assert.Equal(t, c.digested, c.endpoint.InsecureFor(digested), "%#v", c.endpoint)
+		assert.Equal(t, c.tagDig, c.endpoint.InsecureFor(taggedAndDigested), "%#v", c.endpoint)
+	}
+}
+
 func TestRefMatchingSubdomainPrefix(t *testing.T) {
 	for _, c := range []struct {
 		ref, prefix string
@@ -423,7 +445,12 @@ func TestInvalidV2Configs(t *testing.T) {
 	for _, c := range []struct{ path, errorSubstring string }{
 		{"testdata/insecure-conflicts.conf", "registry 'registry.com' is defined multiple times with conflicting 'insecure' setting"},
 		{"testdata/blocked-conflicts.conf", "registry 'registry.com' is defined multiple times with conflicting 'blocked' setting"},
+		{"testdata/insecure-digest-only-conflicts.conf", `cannot set insecure and insecure-digest-only for the registry "registry.com" at the same time`},
+		{"testdata/insecure-digest-only-mirror-conflicts.conf", `cannot set insecure and insecure-digest-only for the mirror "mirror.registry.com" at the same time`},
 		{"testdata/missing-mirror-location.conf", "invalid condition: mirror location is unset"},
+		{"testdata/invalid-rate-limit.conf", `invalid rate-limit for "registry.com": values must not be negative`},
+		{"testdata/invalid-rewrite-source.conf", `invalid rewrite source "registry.com/(" for "mirror.registry.com"`},
+		{"testdata/missing-rewrite-destination.conf", `rewrite destination for source "registry.com/.*" for "registry.com" is unset`},
 		{"testdata/invalid-prefix.conf", "invalid location"},
 		{"testdata/this-does-not-exist.conf", "no such file or directory"},
 	} {
@@ -435,6 +462,27 @@ func TestInvalidV2Configs(t *testing.T) {
 	}
 }
 
+func TestRateLimitForHost(t *testing.T) {
+	sys := &types.SystemContext{
+		SystemRegistriesConfPath:    "testdata/rate-limit.conf",
+		SystemRegistriesConfDirPath: "testdata/this-does-not-exist",
+	}
+	for _, c := range []struct {
+		host     string
+		expected *RateLimit
+	}{
+		{"registry.com", &RateLimit{RequestsPerSecond: 2.5, Burst: 5, MaxConcurrentRequests: 3}},
+		{"mirror.registry.com:5000", &RateLimit{MaxConcurrentRequests: 1}},
+		{"mirror.registry.com", nil},
+		{"unlimited.com", nil},
+		{"unknown.com", nil},
+	} {
+		rl, err := RateLimitForHost(sys, c.host)
+		require.NoError(t, err, c.host)
+		assert.Equal(t, c.expected, rl, c.host)
+	}
+}
+
 func TestUnmarshalConfig(t *testing.T) {
 	registries, err := GetRegistries(&types.SystemContext{
 		SystemRegistriesConfPath:    "testdata/unmarshal.conf",
@@ -670,6 +718,48 @@ func TestPullSourcesFromReference(t *testing.T) {
 	assert.Equal(t, 1, len(pullSources))
 }
 
+func TestPullSourcesRewriteRules(t *testing.T) {
+	sys := &types.SystemContext{
+		SystemRegistriesConfPath:    "testdata/rewrite.conf",
+		SystemRegistriesConfDirPath: "testdata/this-does-not-exist",
+	}
+	const digestSuffix = "@sha256:aaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaa"
+
+	for _, c := range []struct {
+		ref      string
+		expected []string
+	}{
+		// Rewrite rules of a mirror
+		{"docker.io/library/busybox:latest", []string{"cache.corp/dockerhub/busybox:latest", "docker.io/library/busybox:latest"}},
+		{"docker.io/library/busybox" + digestSuffix, []string{"cache.corp/dockerhub/busybox" + digestSuffix, "docker.io/library/busybox" + digestSuffix}},
+		{"docker.io/library/busybox:latest" + digestSuffix, []string{"cache.corp/dockerhub/busybox:latest" + digestSuffix, "docker.io/library/busybox:latest" + digestSuffix}},
+		{"docker.io/corp-org/repo/image:1", []string{"cache.corp/dockerhub-orgs/corp-org_repo/image:1", "docker.io/corp-org/repo/image:1"}},
+		// No rule matches, the location is used
+		{"docker.io/other/image:latest", []string{"cache.corp/dockerhub/other/image:latest", "docker.io/other/image:latest"}},
+		// Rewrite rules of the primary location
+		{"quay.io/org/image:tag", []string{"cache.corp/quay/image/org:tag"}},
+		{"quay.io/org/nested/image:tag", []string{"quay.io/org/nested/image:tag"}},
+	} {
+		ref := toNamedRef(t, c.ref)
+		registry, err := FindRegistry(sys, c.ref)
+		require.NoError(t, err, c.ref)
+		require.NotNil(t, registry, c.ref)
+		pullSources, err := registry.PullSourcesFromReference(ref)
+		require.NoError(t, err, c.ref)
+		res := []string{}
+		for _, ps := range pullSources {
+			res = append(res, ps.Reference.String())
+		}
+		assert.Equal(t, c.expected, res, c.ref)
+	}
+
+	registry, err := FindRegistry(sys, "invalid-destination.com/image:latest")
+	require.NoError(t, err)
+	require.NotNil(t, registry)
+	_, err = registry.PullSourcesFromReference(toNamedRef(t, "invalid-destination.com/image:latest"))
+	assert.Error(t, err)
+}
+
 func TestPullSourcesMirrorFromReference(t *testing.T) {
 	sys := &types.SystemContext{
 		SystemRegistriesConfPath:    "testdata/pull-sources-mirror-reference.conf",
@@ -953,3 +1043,98 @@ func TestCredentialHelpers(t *testing.T) {
 		require.Equal(t, test.helpers, helpers, "%v", test)
 	}
 }
+
+func TestCredentialHelpersSystemContextOverride(t *testing.T) {
+	ctx := &types.SystemContext{
+		SystemRegistriesConfPath:    "testdata/cred-helper.conf",
+		SystemRegistriesConfDirPath: "testdata/this-does-not-exist",
+		CredentialHelpers:           []string{"override-2", "override-1"},
+	}
+	helpers, err := CredentialHelpers(ctx)
+	require.NoError(t, err)
+	assert.Equal(t, []string{"override-2", "override-1"}, helpers)
+
+	ctx.CredentialHelpers = []string{}
+	helpers, err = CredentialHelpers(ctx)
+	require.NoError(t, err)
+	assert.Equal(t, []string{"containers-auth.json"}, helpers)
+}
+
+func TestParseCredentialHelperFailoverMode(t *testing.T) {
+	for _, test := range []struct {
+		input    string
+		result   types.CredentialHelperFailoverMode
+		mustFail bool
+	}{
+		{"first-success", types.CredentialHelperFailoverFirstSuccess, false},
+		{"try-all", types.CredentialHelperFailoverTryAll, false},
+		{"fail-fast", types.CredentialHelperFailoverFailFast, false},
+		{"ignore-errors", types.CredentialHelperFailoverIgnoreErrors, false},
+		{"", types.CredentialHelperFailoverInvalid, true},
+		{"sometimes", types.CredentialHelperFailoverInvalid, true},
+	} {
+		mode, err := parseCredentialHelperFailoverMode(test.input)
+		if test.mustFail {
+			assert.Error(t, err, test.input)
+			continue
+		}
+		require.NoError(t, err, test.input)
+		assert.Equal(t, test.result, mode, test.input)
+	}
+}
+
+func TestGetCredentialHelperFailoverMode(t *testing.T) {
+	for _, test := range []struct {
+		path     string
+		mode     types.CredentialHelperFailoverMode
+		mustFail bool
+	}{
+		{"testdata/cred-helper-failover.conf", types.CredentialHelperFailoverFailFast, false},
+		{"testdata/empty.conf", types.CredentialHelperFailoverFirstSuccess, false}, // empty -> default to first-success
+		{"testdata/invalid-credential-helper-failover.conf", types.CredentialHelperFailoverInvalid, true},
+	} {
+		sys := &types.SystemContext{
+			SystemRegistriesConfPath:    test.path,
+			SystemRegistriesConfDirPath: "testdata/this-does-not-exist",
+		}
+		mode, err := GetCredentialHelperFailoverMode(sys)
+		if test.mustFail {
+			assert.Error(t, err, test.path)
+			continue
+		}
+		require.NoError(t, err, test.path)
+		assert.Equal(t, test.mode, mode, test.path)
+	}
+
+	override := types.CredentialHelperFailoverIgnoreErrors
+	mode, err := GetCredentialHelperFailoverMode(&types.SystemContext{
+		SystemRegistriesConfPath:     "testdata/cred-helper-failover.conf",
+		SystemRegistriesConfDirPath:  "testdata/this-does-not-exist",
+		CredentialHelperFailoverMode: &override,
+	})
+	require.NoError(t, err)
+	assert.Equal(t, types.CredentialHelperFailoverIgnoreErrors, mode)
+}
+
+func TestCredentialHelperRequired(t *testing.T) {
+	for _, test := range []struct {
+		confPath, confDirPath string
+		override              types.OptionalBool
+		expected              bool
+	}{
+		{"testdata/cred-helper-required.conf", "testdata/this-does-not-exist", types.OptionalBoolUndefined, true},
+		{"testdata/empty.conf", "testdata/this-does-not-exist", types.OptionalBoolUndefined, false},
+		// Drop-in files can't disable the requirement.
+		{"testdata/cred-helper-required.conf", "testdata/registries.conf.d", types.OptionalBoolUndefined, true},
+		{"testdata/cred-helper-required.conf", "testdata/this-does-not-exist", types.OptionalBoolFalse, false},
+		{"testdata/empty.conf", "testdata/this-does-not-exist", types.OptionalBoolTrue, true},
+	} {
+		required, err := CredentialHelperRequired(&types.SystemContext{
+			SystemRegistriesConfPath:    test.confPath,
+			SystemRegistriesConfDirPath: test.confDirPath,
+			RequireCredentialHelper:     test.override,
+		})
+		require.NoError(t, err)
+		assert.Equal(t, test.expected, required, "%v", test)
+	}
+}
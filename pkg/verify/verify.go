@@ -0,0 +1,239 @@
+// Package verify compares an image in a destination with its source, without transferring image data, e.g. as a cheap
+// reconciliation pass of synchronization tools.
+//
+// Image reads only the manifests of both images (and, for manifest lists, of their instances); it compares the manifest
+// digests and MIME types, and, if the digests differ, the config and the digests, sizes and MIME types of each layer,
+// returning a structured list of the differences.  Because blobs are content-addressed, matching manifests imply
+// matching configs and layers; note that Image does not check that all blobs referenced by the destination manifest
+// are actually present in the destination.
+package verify
+
+import (
+	"context"
+	"fmt"
+
+	"github.com/containers/image/v5/manifest"
+	"github.com/containers/image/v5/transports"
+	"github.com/containers/image/v5/types"
+	"github.com/opencontainers/go-digest"
+	"github.com/pkg/errors"
+)
+
+// DifferenceKind identifies a kind of Difference.
+type DifferenceKind string
+
+const (
+	// DifferenceManifestDigest: the manifests have different digests.  This is reported together with more specific
+	// differences, if any can be identified.
+	DifferenceManifestDigest DifferenceKind = "manifest-digest"
+	// DifferenceManifestMIMEType: the manifests have different MIME types, e.g. because the destination was converted.
+	DifferenceManifestMIMEType DifferenceKind = "manifest-mime-type"
+	// DifferenceConfig: the images have different configs.
+	DifferenceConfig DifferenceKind = "config"
+	// DifferenceLayerCount: the images have a different number of layers.
+	DifferenceLayerCount DifferenceKind = "layer-count"
+	// DifferenceLayer: the layer with LayerIndex differs in digest, size or MIME type.
+	DifferenceLayer DifferenceKind = "layer"
+	// DifferenceInstanceCount: the manifest lists have a different number of instances.
+	DifferenceInstanceCount DifferenceKind = "instance-count"
+)
+
+// Difference is a single difference between the source and the destination.
+type Difference struct {
+	Kind DifferenceKind
+	// Instance is the digest of the source manifest list instance which differs, or "" for the top-level manifest.
+	Instance digest.Digest
+	// LayerIndex is the index of the layer which differs, for DifferenceLayer; -1 otherwise.
+	LayerIndex int
+	// Source and Destination are human-readable descriptions of the differing values.
+	Source      string
+	Destination string
+}
+
+func (d Difference) String() string {
+	subject := string(d.Kind)
+	if d.Kind == DifferenceLayer {
+		subject = fmt.Sprintf("layer %d", d.LayerIndex)
+	}
+	if d.Instance != "" {
+		subject = fmt.Sprintf("instance %s: %s", d.Instance, subject)
+	}
+	return fmt.Sprintf("%s: source %s, destination %s", subject, d.Source, d.Destination)
+}
+
+// Result is the outcome of Image.
+type Result struct {
+	SourceDigest      digest.Digest
+	DestinationDigest digest.Digest
+	// Differences lists the differences found, in the order of the manifests; it is empty if the destination matches the source.
+	Differences []Difference
+}
+
+// Matches returns true if the destination matches the source.
+func (r *Result) Matches() bool {
+	return len(r.Differences) == 0
+}
+
+// Options control Image.
+type Options struct {
+	SourceCtx      *types.SystemContext
+	DestinationCtx *types.SystemContext
+}
+
+// verifier implements Image.
+type verifier struct {
+	src  types.ImageSource
+	dest types.ImageSource
+	res  *Result
+}
+
+// Image compares the image (or manifest list) at destRef with the one at srcRef, using options (which may be nil),
+// and returns the differences.  An error is returned only if the images can't be read, not if they differ.
+func Image(ctx context.Context, destRef, srcRef types.ImageReference, options *Options) (*Result, error) {
+	if options == nil {
+		options = &Options{}
+	}
+	src, err := srcRef.NewImageSource(ctx, options.SourceCtx)
+	if err != nil {
+		return nil, errors.Wrapf(err, "initializing source %s", transports.ImageName(srcRef))
+	}
+	defer src.Close()
+	dest, err := destRef.NewImageSource(ctx, options.DestinationCtx)
+	if err != nil {
+		return nil, errors.Wrapf(err, "initializing destination %s", transports.ImageName(destRef))
+	}
+	defer dest.Close()
+
+	v := &verifier{src: src, dest: dest, res: &Result{Differences: []Difference{}}}
+	srcDigest, destDigest, err := v.compareManifests(ctx, nil, nil, "")
+	if err != nil {
+		return nil, err
+	}
+	v.res.SourceDigest = srcDigest
+	v.res.DestinationDigest = destDigest
+	return v.res, nil
+}
+
+// readManifest returns the manifest of instanceDigest (or the top-level manifest if nil) of src, its MIME type and digest.
+func readManifest(ctx context.Context, src types.ImageSource, instanceDigest *digest.Digest) ([]byte, string, digest.Digest, error) {
+	man, mimeType, err := src.GetManifest(ctx, instanceDigest)
+	if err != nil {
+		return nil, "", "", errors.Wrapf(err, "reading manifest from %s", transports.ImageName(src.Reference()))
+	}
+	if mimeType == "" {
+		mimeType = manifest.GuessMIMEType(man)
+	}
+	d, err := manifest.Digest(man)
+	if err != nil {
+		return nil, "", "", errors.Wrap(err, "computing manifest digest")
+	}
+	return man, manifest.NormalizedMIMEType(mimeType), d, nil
+}
+
+// compareManifests compares the manifest of srcInstance in v.src with the manifest of destInstance in v.dest (or the
+// top-level manifests, if nil), recording differences for instance, and returns their digests.
+func (v *verifier) compareManifests(ctx context.Context, srcInstance, destInstance *digest.Digest, instance digest.Digest) (digest.Digest, digest.Digest, error) {
+	srcManifest, srcMIMEType, srcDigest, err := readManifest(ctx, v.src, srcInstance)
+	if err != nil {
+		return "", "", err
+	}
+	destManifest, destMIMEType, destDigest, err := readManifest(ctx, v.dest, destInstance)
+	if err != nil {
+		return "", "", err
+	}
+	if srcDigest == destDigest {
+		return srcDigest, destDigest, nil
+	}
+
+	v.record(instance, DifferenceManifestDigest, -1, srcDigest.String(), destDigest.String())
+	if srcMIMEType != destMIMEType {
+		v.record(instance, DifferenceManifestMIMEType, -1, srcMIMEType, destMIMEType)
+	}
+	srcIsList, destIsList := manifest.MIMETypeIsMultiImage(srcMIMEType), manifest.MIMETypeIsMultiImage(destMIMEType)
+	switch {
+	case srcIsList && destIsList:
+		err = v.compareLists(ctx, srcManifest, srcMIMEType, destManifest, destMIMEType)
+	case !srcIsList && !destIsList:
+		err = v.compareImages(srcManifest, srcMIMEType, destManifest, destMIMEType, instance)
+	default:
+		// A list and a single image can't be compared in more detail; the MIME type difference has been recorded.
+	}
+	if err != nil {
+		return "", "", err
+	}
+	return srcDigest, destDigest, nil
+}
+
+// compareLists compares the instances of srcManifest and destManifest, manifest lists with srcMIMEType and destMIMEType.
+// Instances are matched by their position in the lists.
+func (v *verifier) compareLists(ctx context.Context, srcManifest []byte, srcMIMEType string, destManifest []byte, destMIMEType string) error {
+	srcList, err := manifest.ListFromBlob(srcManifest, srcMIMEType)
+	if err != nil {
+		return errors.Wrap(err, "parsing source manifest list")
+	}
+	destList, err := manifest.ListFromBlob(destManifest, destMIMEType)
+	if err != nil {
+		return errors.Wrap(err, "parsing destination manifest list")
+	}
+	srcInstances, destInstances := srcList.Instances(), destList.Instances()
+	if len(srcInstances) != len(destInstances) {
+		v.record("", DifferenceInstanceCount, -1, fmt.Sprint(len(srcInstances)), fmt.Sprint(len(destInstances)))
+	}
+	for i := 0; i < len(srcInstances) && i < len(destInstances); i++ {
+		srcInstance, destInstance := srcInstances[i], destInstances[i]
+		if _, _, err := v.compareManifests(ctx, &srcInstance, &destInstance, srcInstance); err != nil {
+			return errors.Wrapf(err, "comparing instance %s", srcInstance)
+		}
+	}
+	return nil
+}
+
+// compareImages compares srcManifest and destManifest, single-image manifests with srcMIMEType and destMIMEType,
+// recording differences for instance.
+func (v *verifier) compareImages(srcManifest []byte, srcMIMEType string, destManifest []byte, destMIMEType string, instance digest.Digest) error {
+	src, err := manifest.FromBlob(srcManifest, srcMIMEType)
+	if err != nil {
+		return errors.Wrap(err, "parsing source manifest")
+	}
+	dest, err := manifest.FromBlob(destManifest, destMIMEType)
+	if err != nil {
+		return errors.Wrap(err, "parsing destination manifest")
+	}
+
+	// Schema1 manifests have no separate config; their digests are then "" and equal.
+	srcConfig, destConfig := src.ConfigInfo(), dest.ConfigInfo()
+	if srcConfig.Digest != destConfig.Digest || srcConfig.Size != destConfig.Size {
+		v.record(instance, DifferenceConfig, -1, describeBlob(srcConfig), describeBlob(destConfig))
+	}
+
+	srcLayers, destLayers := src.LayerInfos(), dest.LayerInfos()
+	if len(srcLayers) != len(destLayers) {
+		v.record(instance, DifferenceLayerCount, -1, fmt.Sprint(len(srcLayers)), fmt.Sprint(len(destLayers)))
+	}
+	for i := 0; i < len(srcLayers) && i < len(destLayers); i++ {
+		srcLayer, destLayer := srcLayers[i].BlobInfo, destLayers[i].BlobInfo
+		if srcLayer.Digest != destLayer.Digest || srcLayer.Size != destLayer.Size || srcLayer.MediaType != destLayer.MediaType {
+			v.record(instance, DifferenceLayer, i, describeBlob(srcLayer), describeBlob(destLayer))
+		}
+	}
+	return nil
+}
+
+// describeBlob returns a human-readable description of info.
+func describeBlob(info types.BlobInfo) string {
+	if info.MediaType == "" {
+		return fmt.Sprintf("%s (%d bytes)", info.Digest, info.Size)
+	}
+	return fmt.Sprintf("%s (%d bytes, %s)", info.Digest, info.Size, info.MediaType)
+}
+
+// record records a difference.
+func (v *verifier) record(instance digest.Digest, kind DifferenceKind, layerIndex int, src, dest string) {
+	v.res.Differences = append(v.res.Differences, Difference{
+		Kind:        kind,
+		Instance:    instance,
+		LayerIndex:  layerIndex,
+		Source:      src,
+		Destination: dest,
+	})
+}
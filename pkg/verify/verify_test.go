@@ -0,0 +1,156 @@
+package verify
+
+import (
+	"context"
+	"encoding/json"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/containers/image/v5/manifest"
+	"github.com/containers/image/v5/oci/layout"
+	"github.com/containers/image/v5/types"
+	"github.com/opencontainers/go-digest"
+	imgspec "github.com/opencontainers/image-spec/specs-go"
+	imgspecv1 "github.com/opencontainers/image-spec/specs-go/v1"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// writeLayout writes an OCI layout to a new directory, containing blobs, with top, a manifest with mimeType, tagged "tag",
+// and returns a reference to it.
+func writeLayout(t *testing.T, top []byte, mimeType string, blobs ...[]byte) types.ImageReference {
+	dir := t.TempDir()
+	for _, b := range append(blobs, top) {
+		d := digest.FromBytes(b)
+		blobDir := filepath.Join(dir, "blobs", d.Algorithm().String())
+		require.NoError(t, os.MkdirAll(blobDir, 0755))
+		require.NoError(t, os.WriteFile(filepath.Join(blobDir, d.Hex()), b, 0644))
+	}
+	require.NoError(t, os.WriteFile(filepath.Join(dir, imgspecv1.ImageLayoutFile), []byte(`{"imageLayoutVersion":"1.0.0"}`), 0644))
+	index, err := json.Marshal(imgspecv1.Index{
+		Versioned: imgspec.Versioned{SchemaVersion: 2},
+		Manifests: []imgspecv1.Descriptor{{
+			MediaType:   mimeType,
+			Digest:      digest.FromBytes(top),
+			Size:        int64(len(top)),
+			Annotations: map[string]string{imgspecv1.AnnotationRefName: "tag"},
+		}},
+	})
+	require.NoError(t, err)
+	require.NoError(t, os.WriteFile(filepath.Join(dir, "index.json"), index, 0644))
+	ref, err := layout.NewReference(dir, "tag")
+	require.NoError(t, err)
+	return ref
+}
+
+// newImage returns an OCI manifest with config and layers, which are gzip-compressed unless mediaType is set.
+func newImage(t *testing.T, config string, mediaType string, layers ...string) []byte {
+	if mediaType == "" {
+		mediaType = imgspecv1.MediaTypeImageLayerGzip
+	}
+	descriptors := []imgspecv1.Descriptor{}
+	for _, l := range layers {
+		descriptors = append(descriptors, imgspecv1.Descriptor{MediaType: mediaType, Digest: digest.FromString(l), Size: int64(len(l))})
+	}
+	m := manifest.OCI1FromComponents(imgspecv1.Descriptor{
+		MediaType: imgspecv1.MediaTypeImageConfig,
+		Digest:    digest.FromString(config),
+		Size:      int64(len(config)),
+	}, descriptors)
+	res, err := m.Serialize()
+	require.NoError(t, err)
+	return res
+}
+
+// newIndex returns an OCI index of instances.
+func newIndex(t *testing.T, instances ...[]byte) []byte {
+	index := manifest.OCI1IndexFromComponents(nil, nil)
+	for _, i := range instances {
+		index.Manifests = append(index.Manifests, imgspecv1.Descriptor{
+			MediaType: imgspecv1.MediaTypeImageManifest,
+			Digest:    digest.FromBytes(i),
+			Size:      int64(len(i)),
+		})
+	}
+	res, err := index.Serialize()
+	require.NoError(t, err)
+	return res
+}
+
+func TestImage(t *testing.T) {
+	image := newImage(t, "config", "", "layer1", "layer2")
+	srcRef := writeLayout(t, image, imgspecv1.MediaTypeImageManifest)
+
+	// Identical images
+	res, err := Image(context.Background(), writeLayout(t, image, imgspecv1.MediaTypeImageManifest), srcRef, nil)
+	require.NoError(t, err)
+	assert.True(t, res.Matches())
+	assert.Equal(t, digest.FromBytes(image), res.SourceDigest)
+	assert.Equal(t, digest.FromBytes(image), res.DestinationDigest)
+
+	// Different images
+	other := newImage(t, "other config", imgspecv1.MediaTypeImageLayerZstd, "layer1", "layer3", "layer4")
+	res, err = Image(context.Background(), writeLayout(t, other, imgspecv1.MediaTypeImageManifest), srcRef, &Options{})
+	require.NoError(t, err)
+	assert.False(t, res.Matches())
+	assert.Equal(t, digest.FromBytes(other), res.DestinationDigest)
+	kinds := []DifferenceKind{}
+	for _, d := range res.Differences {
+		kinds = append(kinds, d.Kind)
+	}
+	assert.Equal(t, []DifferenceKind{DifferenceManifestDigest, DifferenceConfig, DifferenceLayerCount, DifferenceLayer, DifferenceLayer}, kinds)
+	assert.Equal(t, Difference{
+		Kind:        DifferenceLayer,
+		LayerIndex:  1,
+		Source:      describeBlob(types.BlobInfo{Digest: digest.FromString("layer2"), Size: 6, MediaType: imgspecv1.MediaTypeImageLayerGzip}),
+		Destination: describeBlob(types.BlobInfo{Digest: digest.FromString("layer3"), Size: 6, MediaType: imgspecv1.MediaTypeImageLayerZstd}),
+	}, res.Differences[4])
+	assert.Equal(t, -1, res.Differences[2].LayerIndex)
+
+	// Manifest lists
+	instance1, instance2 := newImage(t, "config1", "", "layer1"), newImage(t, "config2", "", "layer2")
+	index := newIndex(t, instance1, instance2)
+	srcRef = writeLayout(t, index, imgspecv1.MediaTypeImageIndex, instance1, instance2)
+	res, err = Image(context.Background(), writeLayout(t, index, imgspecv1.MediaTypeImageIndex, instance1, instance2), srcRef, nil)
+	require.NoError(t, err)
+	assert.True(t, res.Matches())
+
+	changed := newImage(t, "config2", imgspecv1.MediaTypeImageLayer, "layer2")
+	destIndex := newIndex(t, instance1, changed)
+	res, err = Image(context.Background(), writeLayout(t, destIndex, imgspecv1.MediaTypeImageIndex, instance1, changed), srcRef, nil)
+	require.NoError(t, err)
+	require.Len(t, res.Differences, 3)
+	assert.Equal(t, DifferenceManifestDigest, res.Differences[0].Kind)
+	assert.Equal(t, digest.Digest(""), res.Differences[0].Instance)
+	assert.Equal(t, DifferenceManifestDigest, res.Differences[1].Kind)
+	assert.Equal(t, digest.FromBytes(instance2), res.Differences[1].Instance)
+	assert.Equal(t, DifferenceLayer, res.Differences[2].Kind)
+	assert.Equal(t, digest.FromBytes(instance2), res.Differences[2].Instance)
+
+	destIndex = newIndex(t, instance1)
+	res, err = Image(context.Background(), writeLayout(t, destIndex, imgspecv1.MediaTypeImageIndex, instance1), srcRef, nil)
+	require.NoError(t, err)
+	require.Len(t, res.Differences, 2)
+	assert.Equal(t, Difference{Kind: DifferenceInstanceCount, LayerIndex: -1, Source: "2", Destination: "1"}, res.Differences[1])
+
+	// A list and a single image
+	res, err = Image(context.Background(), writeLayout(t, instance1, imgspecv1.MediaTypeImageManifest), srcRef, nil)
+	require.NoError(t, err)
+	require.Len(t, res.Differences, 2)
+	assert.Equal(t, Difference{Kind: DifferenceManifestMIMEType, LayerIndex: -1,
+		Source: imgspecv1.MediaTypeImageIndex, Destination: imgspecv1.MediaTypeImageManifest}, res.Differences[1])
+
+	// A missing destination
+	destRef, err := layout.NewReference(t.TempDir(), "tag")
+	require.NoError(t, err)
+	_, err = Image(context.Background(), destRef, srcRef, nil)
+	assert.Error(t, err)
+}
+
+func TestDifferenceString(t *testing.T) {
+	d := Difference{Kind: DifferenceConfig, LayerIndex: -1, Source: "a", Destination: "b"}
+	assert.Equal(t, "config: source a, destination b", d.String())
+	d = Difference{Kind: DifferenceLayer, Instance: digest.FromString("i"), LayerIndex: 2, Source: "a", Destination: "b"}
+	assert.Equal(t, "instance "+digest.FromString("i").String()+": layer 2: source a, destination b", d.String())
+}
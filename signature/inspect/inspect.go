@@ -0,0 +1,443 @@
+// Package inspect enumerates the signatures and attestations attached to an image, and describes them,
+// without verifying them or evaluating a signature policy.
+//
+// WARNING: NONE of the values returned by this package are verified in any way. Do not use them for ANY security decisions
+// (use signature.PolicyContext for that), and be VERY CAREFUL about showing them to humans in any way which suggests
+// that they “are probably” reliable. There is NO REASON to expect the values to be correct, or not intentionally misleading.
+package inspect
+
+import (
+	"context"
+	"crypto/x509"
+	"encoding/json"
+	"time"
+
+	"github.com/containers/image/v5/image"
+	"github.com/containers/image/v5/internal/iolimits"
+	"github.com/containers/image/v5/internal/private"
+	"github.com/containers/image/v5/manifest"
+	"github.com/containers/image/v5/pkg/blobinfocache/none"
+	"github.com/containers/image/v5/signature"
+	"github.com/containers/image/v5/signature/sigstore"
+	"github.com/containers/image/v5/transports"
+	"github.com/containers/image/v5/types"
+	"github.com/opencontainers/go-digest"
+	imgspecv1 "github.com/opencontainers/image-spec/specs-go/v1"
+	"github.com/pkg/errors"
+)
+
+// Format identifies the format of a signature.
+type Format string
+
+const (
+	// FormatSimpleSigning is a simple signing signature, as created by signature.SignDockerManifest.
+	FormatSimpleSigning Format = "simpleSigning"
+	// FormatSigstore is a sigstore (cosign) signature.
+	FormatSigstore Format = "sigstore"
+	// FormatSigstoreAttestation is a sigstore (cosign) attestation: a DSSE envelope of an in-toto statement.
+	FormatSigstoreAttestation Format = "sigstoreAttestation"
+)
+
+// Location identifies where a signature was found.
+type Location string
+
+const (
+	// LocationImageSource is the signature storage of the transport, as returned by types.ImageSource.GetSignatures
+	// (e.g. lookaside storage, or the registry signature API extension, for docker:// ).
+	LocationImageSource Location = "imageSource"
+	// LocationCosignTag is an artifact stored using the cosign tag scheme, sha256-<hex>.sig or sha256-<hex>.att .
+	LocationCosignTag Location = "cosignTag"
+	// LocationReferrers is an artifact referring to the image using the image-spec v1.1 subject field.
+	LocationReferrers Location = "referrers"
+)
+
+// TimestampSource identifies who claims that a signature existed at a time.
+type TimestampSource string
+
+const (
+	// TimestampSourceSigner is a time claimed by the signer in the signed payload.
+	TimestampSourceSigner TimestampSource = "signer"
+	// TimestampSourceRekor is the time a signature was recorded in a Rekor log, as claimed by a Rekor bundle.
+	TimestampSourceRekor TimestampSource = "rekor"
+	// TimestampSourceRFC3161 is the time of an RFC 3161 timestamp of the signature.
+	TimestampSourceRFC3161 TimestampSource = "rfc3161"
+)
+
+// Timestamp is an UNVERIFIED time at which a signature is claimed to exist.
+type Timestamp struct {
+	Source TimestampSource
+	Time   time.Time
+}
+
+// Signer describes the UNVERIFIED identity of the creator of a signature. Values which are not known are empty.
+type Signer struct {
+	// KeyIdentifier is the short key identifier of an OpenPGP key for simple signing signatures,
+	// or the key ID recorded in a DSSE envelope for attestations (which is usually empty).
+	KeyIdentifier string
+	// Certificate is the certificate of the signing key recorded in sigstore signatures, e.g. for keyless signatures.
+	Certificate *x509.Certificate
+	// CertificateIdentity is the identity in Certificate, if it was issued by Fulcio.
+	CertificateIdentity *sigstore.CertificateIdentity
+}
+
+// Signature describes a signature or an attestation of an image.
+// ALL VALUES ARE UNVERIFIED; see the package documentation.
+type Signature struct {
+	Format   Format
+	Location Location
+	// ManifestDigest is the digest of the manifest the signature is attached to; for manifest lists,
+	// this is either the list or one of its instances.
+	ManifestDigest digest.Digest
+	// ArtifactDigest is the digest of the artifact manifest containing the signature, for sigstore signatures and attestations.
+	ArtifactDigest digest.Digest
+
+	// PayloadDigest is the manifest digest claimed by the signature (for attestations, by its first subject).
+	PayloadDigest digest.Digest
+	// PayloadDigestMatches is true if PayloadDigest is a digest of the manifest with ManifestDigest.
+	PayloadDigestMatches bool
+	DockerReference      string // The image identity claimed by the signature, if any
+	Creator              string // The software which claims to have created the signature, if recorded
+	Signer               Signer
+	Timestamps           []Timestamp
+	// PredicateType and Predicate are the claims of an attestation.
+	PredicateType string
+	Predicate     json.RawMessage
+
+	// Payload is the signature as stored: the whole signature for simple signing, the signed payload for sigstore signatures,
+	// and the DSSE envelope for attestations.
+	Payload []byte
+	// Annotations are the annotations of the artifact layer containing a sigstore signature or attestation.
+	Annotations map[string]string
+	// ParseError is set if the signature could not be fully parsed; other values may be missing.
+	ParseError error
+}
+
+// Options configure Signatures and SignaturesFromSource.
+type Options struct {
+	// AllInstances, if set and the image is a manifest list, also enumerates the signatures of each instance of the list.
+	AllInstances bool
+}
+
+// Signatures returns all signatures and attestations attached to the image at ref, read using sys,
+// in the transport's signature storage, using the cosign tag scheme, and as referrers (as supported by the transport).
+// The signatures are NOT verified; see the package documentation.
+func Signatures(ctx context.Context, sys *types.SystemContext, ref types.ImageReference, options *Options) ([]Signature, error) {
+	src, err := ref.NewImageSource(ctx, sys)
+	if err != nil {
+		return nil, errors.Wrapf(err, "initializing source %s", transports.ImageName(ref))
+	}
+	defer src.Close()
+	return SignaturesFromSource(ctx, src, options)
+}
+
+// SignaturesFromSource is Signatures, reading the image from src.
+func SignaturesFromSource(ctx context.Context, src types.ImageSource, options *Options) ([]Signature, error) {
+	if options == nil {
+		options = &Options{}
+	}
+	topManifest, mimeType, err := image.UnparsedInstance(src, nil).Manifest(ctx)
+	if err != nil {
+		return nil, err
+	}
+	if mimeType == "" {
+		mimeType = manifest.GuessMIMEType(topManifest)
+	}
+	mimeType = manifest.NormalizedMIMEType(mimeType)
+	res, err := signaturesOfInstance(ctx, src, nil, topManifest)
+	if err != nil {
+		return nil, err
+	}
+	if options.AllInstances && manifest.MIMETypeIsMultiImage(mimeType) {
+		list, err := manifest.ListFromBlob(topManifest, mimeType)
+		if err != nil {
+			return nil, errors.Wrap(err, "parsing manifest list")
+		}
+		for _, instanceDigest := range list.Instances() {
+			instanceDigest := instanceDigest
+			m, _, err := image.UnparsedInstance(src, &instanceDigest).Manifest(ctx)
+			if err != nil {
+				return nil, errors.Wrapf(err, "reading manifest %s", instanceDigest)
+			}
+			sigs, err := signaturesOfInstance(ctx, src, &instanceDigest, m)
+			if err != nil {
+				return nil, err
+			}
+			res = append(res, sigs...)
+		}
+	}
+	return res, nil
+}
+
+// signaturesOfInstance returns the signatures attached to m, the manifest of instanceDigest (or the top-level manifest if nil) in src.
+func signaturesOfInstance(ctx context.Context, src types.ImageSource, instanceDigest *digest.Digest, m []byte) ([]Signature, error) {
+	manifestDigest, err := manifest.Digest(m)
+	if err != nil {
+		return nil, err
+	}
+	res := []Signature{}
+
+	sigs, err := src.GetSignatures(ctx, instanceDigest)
+	if err != nil {
+		return nil, errors.Wrapf(err, "reading signatures of %s", manifestDigest)
+	}
+	for _, sig := range sigs {
+		res = append(res, simpleSigningSignature(manifestDigest, sig))
+	}
+
+	if reader, ok := src.(private.TaggedManifestReader); ok {
+		for _, suffix := range []string{sigstore.SignatureTagSuffix, sigstore.AttestationTagSuffix} {
+			tag := manifestDigest.Algorithm().String() + "-" + manifestDigest.Encoded() + "." + suffix
+			artifact, mimeType, err := reader.GetManifestForTag(ctx, tag)
+			if err != nil {
+				return nil, errors.Wrapf(err, "reading cosign artifact %s", tag)
+			}
+			if artifact == nil {
+				continue
+			}
+			sigs, err := artifactSignatures(ctx, src, manifestDigest, LocationCosignTag, artifact, mimeType)
+			if err != nil {
+				return nil, errors.Wrapf(err, "reading cosign artifact %s", tag)
+			}
+			res = append(res, sigs...)
+		}
+	}
+
+	if lister, ok := src.(private.ReferrersLister); ok {
+		referrers, err := lister.GetReferrers(ctx, manifestDigest, "")
+		if err != nil {
+			return nil, errors.Wrapf(err, "listing referrers of %s", manifestDigest)
+		}
+		for _, referrer := range referrers {
+			referrerDigest := referrer.Digest
+			artifact, mimeType, err := src.GetManifest(ctx, &referrerDigest)
+			if err != nil {
+				return nil, errors.Wrapf(err, "reading referrer %s", referrerDigest)
+			}
+			matches, err := manifest.MatchesDigest(artifact, referrerDigest)
+			if err != nil {
+				return nil, errors.Wrapf(err, "computing digest of referrer %s", referrerDigest)
+			}
+			if !matches {
+				return nil, errors.Errorf("referrer does not match digest %s", referrerDigest)
+			}
+			sigs, err := artifactSignatures(ctx, src, manifestDigest, LocationReferrers, artifact, mimeType)
+			if err != nil {
+				return nil, errors.Wrapf(err, "reading referrer %s", referrerDigest)
+			}
+			res = append(res, sigs...)
+		}
+	}
+
+	for i := range res {
+		if res[i].PayloadDigest != "" {
+			// MatchesDigest only fails if the digest is invalid, which just means that it does not match.
+			matches, _ := manifest.MatchesDigest(m, res[i].PayloadDigest)
+			res[i].PayloadDigestMatches = matches
+		}
+	}
+	return res, nil
+}
+
+// simpleSigningSignature returns a description of sig, a simple signing signature attached to the manifest with manifestDigest.
+func simpleSigningSignature(manifestDigest digest.Digest, sig []byte) Signature {
+	res := Signature{
+		Format:         FormatSimpleSigning,
+		Location:       LocationImageSource,
+		ManifestDigest: manifestDigest,
+		Payload:        sig,
+	}
+	info, err := signature.GetUntrustedSignatureInformationWithoutVerifying(sig)
+	if err != nil {
+		res.ParseError = err
+		return res
+	}
+	res.PayloadDigest = info.UntrustedDockerManifestDigest
+	res.DockerReference = info.UntrustedDockerReference
+	if info.UntrustedCreatorID != nil {
+		res.Creator = *info.UntrustedCreatorID
+	}
+	res.Signer.KeyIdentifier = info.UntrustedShortKeyIdentifier
+	if info.UntrustedTimestamp != nil {
+		res.Timestamps = append(res.Timestamps, Timestamp{Source: TimestampSourceSigner, Time: *info.UntrustedTimestamp})
+	}
+	return res
+}
+
+// artifactSignatures returns the sigstore signatures and attestations in artifact, an artifact manifest with mimeType,
+// found at location, which refers to the manifest with manifestDigest.
+// Artifacts which are not OCI manifests, and layers which are not signatures or attestations (e.g. SBOMs), are ignored.
+func artifactSignatures(ctx context.Context, src types.ImageSource, manifestDigest digest.Digest, location Location,
+	artifact []byte, mimeType string) ([]Signature, error) {
+	if mimeType == "" {
+		mimeType = manifest.GuessMIMEType(artifact)
+	}
+	if manifest.NormalizedMIMEType(mimeType) != imgspecv1.MediaTypeImageManifest {
+		return nil, nil
+	}
+	parsed, err := manifest.OCI1FromManifest(artifact)
+	if err != nil {
+		return nil, err
+	}
+	res := []Signature{}
+	for _, layer := range parsed.Layers {
+		var format Format
+		switch layer.MediaType {
+		case sigstore.SignatureMIMEType:
+			format = FormatSigstore
+		case sigstore.AttestationMIMEType:
+			format = FormatSigstoreAttestation
+		default:
+			continue
+		}
+		payload, err := readLayer(ctx, src, layer)
+		if err != nil {
+			return nil, err
+		}
+		sig := Signature{
+			Format:         format,
+			Location:       location,
+			ManifestDigest: manifestDigest,
+			ArtifactDigest: digest.FromBytes(artifact),
+			Payload:        payload,
+			Annotations:    layer.Annotations,
+		}
+		if format == FormatSigstore {
+			sig.ParseError = sig.parseSigstorePayload()
+		} else {
+			sig.ParseError = sig.parseAttestationPayload()
+		}
+		if sig.ParseError == nil {
+			sig.ParseError = sig.parseSigstoreAnnotations()
+		}
+		res = append(res, sig)
+	}
+	return res, nil
+}
+
+// readLayer returns the contents of layer, a layer of a signature artifact, in src.
+func readLayer(ctx context.Context, src types.ImageSource, layer imgspecv1.Descriptor) ([]byte, error) {
+	if err := layer.Digest.Validate(); err != nil {
+		return nil, errors.Wrap(err, "invalid signature layer digest")
+	}
+	if layer.Size > iolimits.MaxSignatureBodySize {
+		return nil, errors.Errorf("signature layer %s is too large (%d bytes)", layer.Digest, layer.Size)
+	}
+	stream, _, err := src.GetBlob(ctx, types.BlobInfo{Digest: layer.Digest, Size: layer.Size}, none.NoCache)
+	if err != nil {
+		return nil, errors.Wrapf(err, "reading signature layer %s", layer.Digest)
+	}
+	defer stream.Close()
+	contents, err := iolimits.ReadAtMost(stream, iolimits.MaxSignatureBodySize)
+	if err != nil {
+		return nil, errors.Wrapf(err, "reading signature layer %s", layer.Digest)
+	}
+	if layer.Digest.Algorithm().FromBytes(contents) != layer.Digest {
+		return nil, errors.Errorf("signature layer does not match digest %s", layer.Digest)
+	}
+	return contents, nil
+}
+
+// parseSigstorePayload sets the fields of s based on s.Payload, a sigstore signature payload.
+func (s *Signature) parseSigstorePayload() error {
+	p, err := sigstore.ParsePayload(s.Payload)
+	if err != nil {
+		return err
+	}
+	s.PayloadDigest = p.DockerManifestDigest
+	s.DockerReference = p.DockerReference
+	s.Creator = p.Creator
+	if p.Timestamp != nil {
+		s.Timestamps = append(s.Timestamps, Timestamp{Source: TimestampSourceSigner, Time: time.Unix(*p.Timestamp, 0)})
+	}
+	return nil
+}
+
+// dsseEnvelope is a DSSE envelope, as stored in sigstore attestation layers.
+type dsseEnvelope struct {
+	PayloadType string `json:"payloadType"`
+	Payload     []byte `json:"payload"` // base64-encoded in JSON
+	Signatures  []struct {
+		KeyID string `json:"keyid"`
+	} `json:"signatures"`
+}
+
+// inTotoStatement is an in-toto statement, the payload of sigstore attestations.
+type inTotoStatement struct {
+	Subject []struct {
+		Name   string            `json:"name"`
+		Digest map[string]string `json:"digest"`
+	} `json:"subject"`
+	PredicateType string          `json:"predicateType"`
+	Predicate     json.RawMessage `json:"predicate"`
+}
+
+// inTotoPayloadType is the DSSE payload type of in-toto statements.
+const inTotoPayloadType = "application/vnd.in-toto+json"
+
+// parseAttestationPayload sets the fields of s based on s.Payload, a DSSE envelope of an in-toto statement.
+func (s *Signature) parseAttestationPayload() error {
+	var envelope dsseEnvelope
+	if err := json.Unmarshal(s.Payload, &envelope); err != nil {
+		return errors.Wrap(err, "parsing DSSE envelope")
+	}
+	if envelope.PayloadType != inTotoPayloadType {
+		return errors.Errorf("unrecognized DSSE payload type %q", envelope.PayloadType)
+	}
+	if len(envelope.Signatures) != 0 {
+		s.Signer.KeyIdentifier = envelope.Signatures[0].KeyID
+	}
+	var statement inTotoStatement
+	if err := json.Unmarshal(envelope.Payload, &statement); err != nil {
+		return errors.Wrap(err, "parsing in-toto statement")
+	}
+	s.PredicateType = statement.PredicateType
+	s.Predicate = statement.Predicate
+	if len(statement.Subject) != 0 {
+		subject := statement.Subject[0]
+		for _, algorithm := range []digest.Algorithm{digest.SHA256, digest.SHA512} {
+			if value, ok := subject.Digest[algorithm.String()]; ok {
+				d := digest.NewDigestFromEncoded(algorithm, value)
+				if err := d.Validate(); err != nil {
+					return errors.Wrap(err, "invalid in-toto subject digest")
+				}
+				s.PayloadDigest = d
+				break
+			}
+		}
+	}
+	return nil
+}
+
+// parseSigstoreAnnotations sets the fields of s based on s.Annotations, the annotations of a sigstore signature or attestation layer.
+func (s *Signature) parseSigstoreAnnotations() error {
+	if certPEM, ok := s.Annotations[sigstore.CertificateAnnotationKey]; ok {
+		certs, err := sigstore.ParseCertificates([]byte(certPEM))
+		if err != nil || len(certs) != 1 {
+			return errors.New("invalid certificate annotation")
+		}
+		s.Signer.Certificate = certs[0]
+		// Certificates which were not issued by Fulcio are not an error; they just don’t contain an identity.
+		if identity, err := sigstore.CertificateIdentityFromCertificate(certs[0]); err == nil && identity.Issuer != "" {
+			s.Signer.CertificateIdentity = identity
+		}
+	}
+	if bundleJSON, ok := s.Annotations[sigstore.BundleAnnotationKey]; ok {
+		var bundle sigstore.Bundle
+		if err := json.Unmarshal([]byte(bundleJSON), &bundle); err != nil {
+			return errors.Wrap(err, "invalid Rekor bundle annotation")
+		}
+		s.Timestamps = append(s.Timestamps, Timestamp{Source: TimestampSourceRekor, Time: time.Unix(bundle.Payload.IntegratedTime, 0)})
+	}
+	if timestampJSON, ok := s.Annotations[sigstore.TimestampAnnotationKey]; ok {
+		var timestamp sigstore.RFC3161Timestamp
+		if err := json.Unmarshal([]byte(timestampJSON), &timestamp); err != nil {
+			return errors.Wrap(err, "invalid timestamp annotation")
+		}
+		t, err := sigstore.UntrustedRFC3161TimestampTime(timestamp.SignedRFC3161Timestamp)
+		if err != nil {
+			return err
+		}
+		s.Timestamps = append(s.Timestamps, Timestamp{Source: TimestampSourceRFC3161, Time: t})
+	}
+	return nil
+}
@@ -0,0 +1,342 @@
+package inspect
+
+import (
+	"bytes"
+	"context"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/asn1"
+	"encoding/json"
+	"encoding/pem"
+	"io"
+	"math/big"
+	"net/http/httptest"
+	"os"
+	"testing"
+	"time"
+
+	"github.com/containers/image/v5/directory"
+	"github.com/containers/image/v5/docker/reference"
+	"github.com/containers/image/v5/internal/testing/tsa"
+	"github.com/containers/image/v5/manifest"
+	"github.com/containers/image/v5/signature/sigstore"
+	"github.com/containers/image/v5/types"
+	"github.com/opencontainers/go-digest"
+	imgspec "github.com/opencontainers/image-spec/specs-go"
+	imgspecv1 "github.com/opencontainers/image-spec/specs-go/v1"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+const (
+	// testImageManifestDigest is the digest of ../fixtures/image.manifest.json, signed by ../fixtures/image.signature
+	testImageManifestDigest = digest.Digest("sha256:20bf21ed457b390829cdbeec8795a7bea1626991fda603e0d01b4e7f60427e55")
+	// testKeyShortID is the short ID of the key which created ../fixtures/image.signature
+	testKeyShortID = "DB72F2188BB46CC8"
+)
+
+// refMock is a types.ImageReference which only implements DockerReference.
+type refMock struct {
+	types.ImageReference
+}
+
+func (ref refMock) DockerReference() reference.Named {
+	return nil
+}
+
+// sourceMock is a types.ImageSource which also implements private.TaggedManifestReader and private.ReferrersLister.
+type sourceMock struct {
+	types.ImageSource
+	top        digest.Digest
+	manifests  map[digest.Digest][]byte
+	blobs      map[digest.Digest][]byte
+	signatures map[digest.Digest][][]byte
+	tags       map[string]digest.Digest
+	referrers  map[digest.Digest][]manifest.OCI1Referrer
+}
+
+func newSourceMock() *sourceMock {
+	return &sourceMock{
+		manifests:  map[digest.Digest][]byte{},
+		blobs:      map[digest.Digest][]byte{},
+		signatures: map[digest.Digest][][]byte{},
+		tags:       map[string]digest.Digest{},
+		referrers:  map[digest.Digest][]manifest.OCI1Referrer{},
+	}
+}
+
+func (s *sourceMock) Reference() types.ImageReference {
+	return refMock{}
+}
+
+func (s *sourceMock) GetManifest(ctx context.Context, instanceDigest *digest.Digest) ([]byte, string, error) {
+	d := s.top
+	if instanceDigest != nil {
+		d = *instanceDigest
+	}
+	return s.manifests[d], "", nil
+}
+
+func (s *sourceMock) GetBlob(ctx context.Context, info types.BlobInfo, cache types.BlobInfoCache) (io.ReadCloser, int64, error) {
+	blob := s.blobs[info.Digest]
+	return io.NopCloser(bytes.NewReader(blob)), int64(len(blob)), nil
+}
+
+func (s *sourceMock) GetSignatures(ctx context.Context, instanceDigest *digest.Digest) ([][]byte, error) {
+	d := s.top
+	if instanceDigest != nil {
+		d = *instanceDigest
+	}
+	return s.signatures[d], nil
+}
+
+func (s *sourceMock) GetManifestForTag(ctx context.Context, tag string) ([]byte, string, error) {
+	d, ok := s.tags[tag]
+	if !ok {
+		return nil, "", nil
+	}
+	return s.manifests[d], imgspecv1.MediaTypeImageManifest, nil
+}
+
+func (s *sourceMock) GetReferrers(ctx context.Context, manifestDigest digest.Digest, artifactType string) ([]manifest.OCI1Referrer, error) {
+	return s.referrers[manifestDigest], nil
+}
+
+// addManifest adds m to s, and returns its digest.
+func (s *sourceMock) addManifest(t *testing.T, m []byte) digest.Digest {
+	d, err := manifest.Digest(m)
+	require.NoError(t, err)
+	s.manifests[d] = m
+	return d
+}
+
+// addArtifact adds an artifact manifest with layers to s, and returns its digest.
+func (s *sourceMock) addArtifact(t *testing.T, layers ...imgspecv1.Descriptor) digest.Digest {
+	config := []byte("{}")
+	s.blobs[digest.FromBytes(config)] = config
+	m, err := json.Marshal(imgspecv1.Manifest{
+		Versioned: imgspec.Versioned{SchemaVersion: 2},
+		MediaType: imgspecv1.MediaTypeImageManifest,
+		Config:    imgspecv1.Descriptor{MediaType: imgspecv1.MediaTypeImageConfig, Digest: digest.FromBytes(config), Size: int64(len(config))},
+		Layers:    layers,
+	})
+	require.NoError(t, err)
+	return s.addManifest(t, m)
+}
+
+// addLayer adds contents to s, and returns a layer descriptor of it with mimeType and annotations.
+func (s *sourceMock) addLayer(contents []byte, mimeType string, annotations map[string]string) imgspecv1.Descriptor {
+	d := digest.FromBytes(contents)
+	s.blobs[d] = contents
+	return imgspecv1.Descriptor{MediaType: mimeType, Digest: d, Size: int64(len(contents)), Annotations: annotations}
+}
+
+// testFulcioCertificatePEM returns a PEM-encoded self-signed certificate for user@example.com, with a Fulcio OIDC issuer extension.
+func testFulcioCertificatePEM(t *testing.T) []byte {
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	require.NoError(t, err)
+	template := &x509.Certificate{
+		SerialNumber:    big.NewInt(1),
+		NotBefore:       time.Unix(1600000000, 0),
+		NotAfter:        time.Unix(1600000600, 0),
+		EmailAddresses:  []string{"user@example.com"},
+		ExtraExtensions: []pkix.Extension{{Id: asn1.ObjectIdentifier{1, 3, 6, 1, 4, 1, 57264, 1, 1}, Value: []byte("https://issuer.example.com")}},
+	}
+	der, err := x509.CreateCertificate(rand.Reader, template, template, &key.PublicKey, key)
+	require.NoError(t, err)
+	return pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: der})
+}
+
+// testSigstoreSigner returns a sigstore signer using a new key, which timestamps signatures using authority.
+func testSigstoreSigner(t *testing.T, authority *tsa.Authority) *sigstore.Signer {
+	server := httptest.NewServer(authority)
+	t.Cleanup(server.Close)
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	require.NoError(t, err)
+	der, err := x509.MarshalPKCS8PrivateKey(key)
+	require.NoError(t, err)
+	signer, err := sigstore.NewSignerFromPrivateKey(pem.EncodeToMemory(&pem.Block{Type: "PRIVATE KEY", Bytes: der}), nil)
+	require.NoError(t, err)
+	err = signer.UseTimestampAuthority(sigstore.TimestampAuthorityOptions{URL: server.URL})
+	require.NoError(t, err)
+	return signer
+}
+
+func TestSignaturesFromSource(t *testing.T) {
+	ctx := context.Background()
+	imageManifest, err := os.ReadFile("../fixtures/image.manifest.json")
+	require.NoError(t, err)
+	simpleSignature, err := os.ReadFile("../fixtures/image.signature")
+	require.NoError(t, err)
+	authority, err := tsa.New(time.Unix(1600000100, 0), x509.ExtKeyUsageTimeStamping)
+	require.NoError(t, err)
+	signer := testSigstoreSigner(t, authority)
+	certPEM := testFulcioCertificatePEM(t)
+
+	src := newSourceMock()
+	src.top = src.addManifest(t, imageManifest)
+	require.Equal(t, testImageManifestDigest, src.top)
+	src.signatures[src.top] = [][]byte{simpleSignature, []byte("invalid signature")}
+
+	// A keyless-like signature using the cosign tag scheme
+	sig, err := signer.SignImage(ctx, imageManifest, "example.com/repo:tag")
+	require.NoError(t, err)
+	sig.Annotations[sigstore.CertificateAnnotationKey] = string(certPEM)
+	bundleJSON, err := json.Marshal(sigstore.Bundle{Payload: sigstore.BundlePayload{IntegratedTime: 1600000050}})
+	require.NoError(t, err)
+	sig.Annotations[sigstore.BundleAnnotationKey] = string(bundleJSON)
+	sigArtifact := src.addArtifact(t, src.addLayer(sig.Payload, sigstore.SignatureMIMEType, sig.Annotations))
+	src.tags["sha256-"+src.top.Encoded()+".sig"] = sigArtifact
+
+	// An attestation using the cosign tag scheme
+	statement, err := json.Marshal(map[string]interface{}{
+		"_type":         "https://in-toto.io/Statement/v0.1",
+		"subject":       []interface{}{map[string]interface{}{"name": "example.com/repo", "digest": map[string]string{"sha256": src.top.Encoded()}}},
+		"predicateType": "https://slsa.dev/provenance/v0.2",
+		"predicate":     map[string]string{"builder": "test"},
+	})
+	require.NoError(t, err)
+	envelope, err := json.Marshal(map[string]interface{}{
+		"payloadType": "application/vnd.in-toto+json",
+		"payload":     statement,
+		"signatures":  []interface{}{map[string]string{"keyid": "test-key", "sig": "c2lnbmF0dXJl"}},
+	})
+	require.NoError(t, err)
+	attArtifact := src.addArtifact(t, src.addLayer(envelope, sigstore.AttestationMIMEType, map[string]string{}))
+	src.tags["sha256-"+src.top.Encoded()+".att"] = attArtifact
+
+	// A referrer with a signature and an SBOM, and a referrer with an invalid signature
+	otherSig, err := signer.SignImage(ctx, []byte(`{"schemaVersion":2,"other":true}`), "example.com/repo:other")
+	require.NoError(t, err)
+	referrer := src.addArtifact(t, src.addLayer(otherSig.Payload, sigstore.SignatureMIMEType, otherSig.Annotations),
+		src.addLayer([]byte(`{"spdxVersion":"SPDX-2.3"}`), "application/spdx+json", nil))
+	invalidReferrer := src.addArtifact(t, src.addLayer([]byte("invalid payload"), sigstore.SignatureMIMEType, nil))
+	src.referrers[src.top] = []manifest.OCI1Referrer{
+		{Descriptor: imgspecv1.Descriptor{Digest: referrer}, ArtifactType: "application/vnd.dev.cosign.artifact.sig.v1+json"},
+		{Descriptor: imgspecv1.Descriptor{Digest: invalidReferrer}, ArtifactType: "application/vnd.dev.cosign.artifact.sig.v1+json"},
+	}
+
+	res, err := SignaturesFromSource(ctx, src, nil)
+	require.NoError(t, err)
+	require.Len(t, res, 6)
+
+	assert.Equal(t, Signature{
+		Format:               FormatSimpleSigning,
+		Location:             LocationImageSource,
+		ManifestDigest:       src.top,
+		PayloadDigest:        testImageManifestDigest,
+		PayloadDigestMatches: true,
+		DockerReference:      "testing/manifest",
+		Creator:              "atomic ",
+		Signer:               Signer{KeyIdentifier: testKeyShortID},
+		Timestamps:           []Timestamp{{Source: TimestampSourceSigner, Time: time.Unix(1458239713, 0)}},
+		Payload:              simpleSignature,
+	}, res[0])
+	assert.Equal(t, FormatSimpleSigning, res[1].Format)
+	assert.Error(t, res[1].ParseError)
+
+	assert.Equal(t, FormatSigstore, res[2].Format)
+	assert.Equal(t, LocationCosignTag, res[2].Location)
+	assert.Equal(t, sigArtifact, res[2].ArtifactDigest)
+	assert.NoError(t, res[2].ParseError)
+	assert.Equal(t, src.top, res[2].PayloadDigest)
+	assert.True(t, res[2].PayloadDigestMatches)
+	assert.Equal(t, "example.com/repo:tag", res[2].DockerReference)
+	require.NotNil(t, res[2].Signer.Certificate)
+	assert.Equal(t, &sigstore.CertificateIdentity{Subject: "user@example.com", Issuer: "https://issuer.example.com"}, res[2].Signer.CertificateIdentity)
+	require.Len(t, res[2].Timestamps, 3)
+	assert.Equal(t, TimestampSourceSigner, res[2].Timestamps[0].Source) // Set by SignImage to the current time
+	assert.Equal(t, []Timestamp{
+		{Source: TimestampSourceRekor, Time: time.Unix(1600000050, 0)},
+		{Source: TimestampSourceRFC3161, Time: time.Unix(1600000100, 0).UTC()},
+	}, res[2].Timestamps[1:])
+	assert.Equal(t, sig.Payload, res[2].Payload)
+	assert.Equal(t, sig.Annotations, res[2].Annotations)
+
+	assert.Equal(t, FormatSigstoreAttestation, res[3].Format)
+	assert.Equal(t, LocationCosignTag, res[3].Location)
+	assert.Equal(t, attArtifact, res[3].ArtifactDigest)
+	assert.NoError(t, res[3].ParseError)
+	assert.Equal(t, src.top, res[3].PayloadDigest)
+	assert.True(t, res[3].PayloadDigestMatches)
+	assert.Equal(t, "test-key", res[3].Signer.KeyIdentifier)
+	assert.Equal(t, "https://slsa.dev/provenance/v0.2", res[3].PredicateType)
+	assert.JSONEq(t, `{"builder":"test"}`, string(res[3].Predicate))
+
+	assert.Equal(t, FormatSigstore, res[4].Format)
+	assert.Equal(t, LocationReferrers, res[4].Location)
+	assert.Equal(t, referrer, res[4].ArtifactDigest)
+	assert.NoError(t, res[4].ParseError)
+	assert.Equal(t, "example.com/repo:other", res[4].DockerReference)
+	assert.False(t, res[4].PayloadDigestMatches)
+	assert.Nil(t, res[4].Signer.Certificate)
+
+	assert.Equal(t, LocationReferrers, res[5].Location)
+	assert.Error(t, res[5].ParseError)
+
+	// Invalid annotations are reported for the individual signature
+	for _, annotation := range []string{sigstore.CertificateAnnotationKey, sigstore.BundleAnnotationKey, sigstore.TimestampAnnotationKey} {
+		annotations := map[string]string{}
+		for k, v := range sig.Annotations {
+			annotations[k] = v
+		}
+		annotations[annotation] = "this is invalid"
+		src.tags["sha256-"+src.top.Encoded()+".sig"] = src.addArtifact(t, src.addLayer(sig.Payload, sigstore.SignatureMIMEType, annotations))
+		res, err := SignaturesFromSource(ctx, src, nil)
+		require.NoError(t, err, annotation)
+		assert.Error(t, res[2].ParseError, annotation)
+	}
+
+	// A blob which does not match its digest fails the whole operation
+	src.blobs[digest.FromBytes(otherSig.Payload)] = []byte("modified")
+	_, err = SignaturesFromSource(ctx, src, nil)
+	assert.Error(t, err)
+}
+
+func TestSignaturesFromSourceAllInstances(t *testing.T) {
+	ctx := context.Background()
+	imageManifest, err := os.ReadFile("../fixtures/image.manifest.json")
+	require.NoError(t, err)
+	simpleSignature, err := os.ReadFile("../fixtures/image.signature")
+	require.NoError(t, err)
+
+	src := newSourceMock()
+	instance := src.addManifest(t, imageManifest)
+	list, err := json.Marshal(imgspecv1.Index{
+		Versioned: imgspec.Versioned{SchemaVersion: 2},
+		MediaType: imgspecv1.MediaTypeImageIndex,
+		Manifests: []imgspecv1.Descriptor{{MediaType: manifest.DockerV2Schema2MediaType, Digest: instance, Size: int64(len(imageManifest))}},
+	})
+	require.NoError(t, err)
+	src.top = src.addManifest(t, list)
+	src.signatures[instance] = [][]byte{simpleSignature}
+
+	res, err := SignaturesFromSource(ctx, src, nil)
+	require.NoError(t, err)
+	assert.Empty(t, res)
+
+	res, err = SignaturesFromSource(ctx, src, &Options{AllInstances: true})
+	require.NoError(t, err)
+	require.Len(t, res, 1)
+	assert.Equal(t, instance, res[0].ManifestDigest)
+	assert.True(t, res[0].PayloadDigestMatches)
+}
+
+func TestSignatures(t *testing.T) {
+	ref, err := directory.NewReference("../fixtures/dir-img-valid")
+	require.NoError(t, err)
+	res, err := Signatures(context.Background(), nil, ref, nil)
+	require.NoError(t, err)
+	require.Len(t, res, 1)
+	assert.Equal(t, FormatSimpleSigning, res[0].Format)
+	assert.Equal(t, testKeyShortID, res[0].Signer.KeyIdentifier)
+	assert.True(t, res[0].PayloadDigestMatches)
+
+	ref, err = directory.NewReference("../fixtures/dir-img-no-manifest")
+	require.NoError(t, err)
+	_, err = Signatures(context.Background(), nil, ref, nil)
+	assert.Error(t, err)
+}
@@ -7,6 +7,7 @@ import (
 	"errors"
 	"fmt"
 	"io"
+	"os"
 	"strings"
 
 	// This code is used only to parse the data in an explicitly-untrusted
@@ -53,6 +54,40 @@ func (err SigningNotSupportedError) Error() string {
 	return string(err)
 }
 
+// GPGBackend identifies an implementation of GPG/OpenPGP signing mechanisms.
+type GPGBackend string
+
+const (
+	// GPGBackendDefault is the backend named by $CONTAINERS_IMAGE_GPG_BACKEND, if set; otherwise GPGBackendGPGME,
+	// or GPGBackendOpenPGP if built with the containers_image_openpgp build tag.
+	GPGBackendDefault GPGBackend = ""
+	// GPGBackendGPGME uses gpgme, i.e. the host’s GnuPG installation and its keyrings, out of process.
+	// It requires cgo, and is not available if built with the containers_image_openpgp build tag.
+	GPGBackendGPGME GPGBackend = "gpgme"
+	// GPGBackendOpenPGP uses a pure-Go OpenPGP implementation, reading keys from pubring.gpg and secring.gpg
+	// (not the keybox format used by GnuPG ≥ 2.1 by default, use (gpg --export-secret-keys) to create secring.gpg).
+	GPGBackendOpenPGP GPGBackend = "openpgp"
+)
+
+// gpgBackendEnvironmentVariable is the environment variable which selects GPGBackendDefault at runtime.
+const gpgBackendEnvironmentVariable = "CONTAINERS_IMAGE_GPG_BACKEND"
+
+// resolveGPGBackend returns the backend to use for backend, resolving GPGBackendDefault.
+func resolveGPGBackend(backend GPGBackend) (GPGBackend, error) {
+	if backend == GPGBackendDefault {
+		backend = GPGBackend(os.Getenv(gpgBackendEnvironmentVariable))
+		if backend == GPGBackendDefault {
+			backend = defaultGPGBackend
+		}
+	}
+	switch backend {
+	case GPGBackendGPGME, GPGBackendOpenPGP:
+		return backend, nil
+	default:
+		return "", fmt.Errorf("unknown GPG backend %q", backend)
+	}
+}
+
 // NewGPGSigningMechanism returns a new GPG/OpenPGP signing mechanism for the user’s default
 // GPG configuration ($GNUPGHOME / ~/.gnupg)
 // The caller must call .Close() on the returned SigningMechanism.
@@ -60,12 +95,61 @@ func NewGPGSigningMechanism() (SigningMechanism, error) {
 	return newGPGSigningMechanismInDirectory("")
 }
 
+// NewGPGSigningMechanismWithBackend returns a new GPG/OpenPGP signing mechanism implemented by backend,
+// for the user’s default GPG configuration ($GNUPGHOME / ~/.gnupg)
+// The caller must call .Close() on the returned SigningMechanism.
+func NewGPGSigningMechanismWithBackend(backend GPGBackend) (SigningMechanism, error) {
+	return newGPGSigningMechanismWithBackendInDirectory(backend, "")
+}
+
+// newGPGSigningMechanismInDirectory returns a new GPG/OpenPGP signing mechanism, using optionalDir if not empty.
+// The caller must call .Close() on the returned SigningMechanism.
+func newGPGSigningMechanismInDirectory(optionalDir string) (signingMechanismWithPassphrase, error) {
+	return newGPGSigningMechanismWithBackendInDirectory(GPGBackendDefault, optionalDir)
+}
+
+// newGPGSigningMechanismWithBackendInDirectory returns a new GPG/OpenPGP signing mechanism implemented by backend,
+// using optionalDir if not empty.
+// The caller must call .Close() on the returned SigningMechanism.
+func newGPGSigningMechanismWithBackendInDirectory(backend GPGBackend, optionalDir string) (signingMechanismWithPassphrase, error) {
+	backend, err := resolveGPGBackend(backend)
+	if err != nil {
+		return nil, err
+	}
+	if backend == GPGBackendOpenPGP {
+		return newOpenPGPSigningMechanismInDirectory(optionalDir)
+	}
+	return newGPGMESigningMechanismInDirectory(optionalDir)
+}
+
 // NewEphemeralGPGSigningMechanism returns a new GPG/OpenPGP signing mechanism which
 // recognizes _only_ public keys from the supplied blob, and returns the identities
 // of these keys.
 // The caller must call .Close() on the returned SigningMechanism.
 func NewEphemeralGPGSigningMechanism(blob []byte) (SigningMechanism, []string, error) {
-	return newEphemeralGPGSigningMechanism(blob)
+	return newEphemeralGPGSigningMechanism(GPGBackendDefault, blob)
+}
+
+// NewEphemeralGPGSigningMechanismWithBackend returns a new GPG/OpenPGP signing mechanism implemented by backend,
+// which recognizes _only_ keys from the supplied blob, and returns the identities of these keys.
+// With GPGBackendOpenPGP, private keys in blob (if any) can be used for signing, so that no keyring on the host is necessary.
+// The caller must call .Close() on the returned SigningMechanism.
+func NewEphemeralGPGSigningMechanismWithBackend(backend GPGBackend, blob []byte) (SigningMechanism, []string, error) {
+	return newEphemeralGPGSigningMechanism(backend, blob)
+}
+
+// newEphemeralGPGSigningMechanism returns a new GPG/OpenPGP signing mechanism implemented by backend,
+// which recognizes _only_ keys from the supplied blob, and returns the identities of these keys.
+// The caller must call .Close() on the returned SigningMechanism.
+func newEphemeralGPGSigningMechanism(backend GPGBackend, blob []byte) (signingMechanismWithPassphrase, []string, error) {
+	backend, err := resolveGPGBackend(backend)
+	if err != nil {
+		return nil, nil, err
+	}
+	if backend == GPGBackendOpenPGP {
+		return newEphemeralOpenPGPSigningMechanism(blob)
+	}
+	return newEphemeralGPGMESigningMechanism(blob)
 }
 
 // gpgUntrustedSignatureContents returns UNTRUSTED contents of the signature WITHOUT ANY VERIFICATION,
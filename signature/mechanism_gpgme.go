@@ -18,9 +18,13 @@ type gpgmeSigningMechanism struct {
 	ephemeralDir string // If not "", a directory to be removed on Close()
 }
 
-// newGPGSigningMechanismInDirectory returns a new GPG/OpenPGP signing mechanism, using optionalDir if not empty.
+// defaultGPGBackend is the backend used by GPGBackendDefault if not overridden at runtime.
+const defaultGPGBackend = GPGBackendGPGME
+
+// newGPGMESigningMechanismInDirectory returns a new GPG/OpenPGP signing mechanism implemented using gpgme,
+// using optionalDir if not empty.
 // The caller must call .Close() on the returned SigningMechanism.
-func newGPGSigningMechanismInDirectory(optionalDir string) (signingMechanismWithPassphrase, error) {
+func newGPGMESigningMechanismInDirectory(optionalDir string) (signingMechanismWithPassphrase, error) {
 	ctx, err := newGPGMEContext(optionalDir)
 	if err != nil {
 		return nil, err
@@ -31,11 +35,11 @@ func newGPGSigningMechanismInDirectory(optionalDir string) (signingMechanismWith
 	}, nil
 }
 
-// newEphemeralGPGSigningMechanism returns a new GPG/OpenPGP signing mechanism which
+// newEphemeralGPGMESigningMechanism returns a new GPG/OpenPGP signing mechanism implemented using gpgme, which
 // recognizes _only_ public keys from the supplied blob, and returns the identities
 // of these keys.
 // The caller must call .Close() on the returned SigningMechanism.
-func newEphemeralGPGSigningMechanism(blob []byte) (signingMechanismWithPassphrase, []string, error) {
+func newEphemeralGPGMESigningMechanism(blob []byte) (signingMechanismWithPassphrase, []string, error) {
 	dir, err := os.MkdirTemp("", "containers-ephemeral-gpg-")
 	if err != nil {
 		return nil, nil, err
@@ -93,7 +97,7 @@ func (m *gpgmeSigningMechanism) Close() error {
 // importKeysFromBytes imports public keys from the supplied blob and returns their identities.
 // The blob is assumed to have an appropriate format (the caller is expected to know which one).
 // NOTE: This may modify long-term state (e.g. key storage in a directory underlying the mechanism);
-// but we do not make this public, it can only be used through newEphemeralGPGSigningMechanism.
+// but we do not make this public, it can only be used through newEphemeralGPGMESigningMechanism.
 func (m *gpgmeSigningMechanism) importKeysFromBytes(blob []byte) ([]string, error) {
 	inputData, err := gpgme.NewDataBytes(blob)
 	if err != nil {
@@ -0,0 +1,22 @@
+//go:build containers_image_openpgp
+// +build containers_image_openpgp
+
+package signature
+
+import "errors"
+
+// defaultGPGBackend is the backend used by GPGBackendDefault if not overridden at runtime.
+const defaultGPGBackend = GPGBackendOpenPGP
+
+// errGPGMENotSupported is returned when GPGBackendGPGME is requested in a build without gpgme.
+var errGPGMENotSupported = errors.New("the gpgme GPG backend is not supported in github.com/containers/image built with the containers_image_openpgp build tag")
+
+// newGPGMESigningMechanismInDirectory fails with errGPGMENotSupported.
+func newGPGMESigningMechanismInDirectory(optionalDir string) (signingMechanismWithPassphrase, error) {
+	return nil, errGPGMENotSupported
+}
+
+// newEphemeralGPGMESigningMechanism fails with errGPGMENotSupported.
+func newEphemeralGPGMESigningMechanism(blob []byte) (signingMechanismWithPassphrase, []string, error) {
+	return nil, nil, errGPGMENotSupported
+}
@@ -0,0 +1,17 @@
+//go:build containers_image_openpgp
+// +build containers_image_openpgp
+
+package signature
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestGPGMEBackendNotSupported(t *testing.T) {
+	_, err := NewGPGSigningMechanismWithBackend(GPGBackendGPGME)
+	assert.Equal(t, errGPGMENotSupported, err)
+	_, _, err = NewEphemeralGPGSigningMechanismWithBackend(GPGBackendGPGME, []byte{})
+	assert.Equal(t, errGPGMENotSupported, err)
+}
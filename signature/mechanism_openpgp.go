@@ -1,10 +1,8 @@
-//go:build containers_image_openpgp
-// +build containers_image_openpgp
-
 package signature
 
 import (
 	"bytes"
+	"crypto"
 	"errors"
 	"fmt"
 	"io"
@@ -14,24 +12,26 @@ import (
 	"time"
 
 	"github.com/containers/storage/pkg/homedir"
-	// This is a fallback code; the primary recommendation is to use the gpgme mechanism
-	// implementation, which is out-of-process and more appropriate for handling long-term private key material
-	// than any Go implementation.
-	// For this verify-only fallback, we haven't reviewed any of the
-	// existing alternatives to choose; so, for now, continue to
+	// The primary recommendation is to use the gpgme mechanism implementation, which is out-of-process
+	// and more appropriate for handling long-term private key material than any Go implementation;
+	// this implementation is an alternative for builds and platforms without cgo or a GnuPG installation.
+	// We haven't reviewed any of the existing alternatives to choose; so, for now, continue to
 	// use this frozen deprecated implementation.
 	//lint:ignore SA1019 See above
 	"golang.org/x/crypto/openpgp" //nolint:staticcheck
+	//lint:ignore SA1019 See above
+	"golang.org/x/crypto/openpgp/packet" //nolint:staticcheck
 )
 
 // A GPG/OpenPGP signing mechanism, implemented using x/crypto/openpgp.
 type openpgpSigningMechanism struct {
-	keyring openpgp.EntityList
+	keyring openpgp.EntityList // Includes entities with private keys, if any
 }
 
-// newGPGSigningMechanismInDirectory returns a new GPG/OpenPGP signing mechanism, using optionalDir if not empty.
+// newOpenPGPSigningMechanismInDirectory returns a new GPG/OpenPGP signing mechanism implemented using x/crypto/openpgp,
+// using optionalDir if not empty.
 // The caller must call .Close() on the returned SigningMechanism.
-func newGPGSigningMechanismInDirectory(optionalDir string) (signingMechanismWithPassphrase, error) {
+func newOpenPGPSigningMechanismInDirectory(optionalDir string) (signingMechanismWithPassphrase, error) {
 	m := &openpgpSigningMechanism{
 		keyring: openpgp.EntityList{},
 	}
@@ -44,25 +44,27 @@ func newGPGSigningMechanismInDirectory(optionalDir string) (signingMechanismWith
 		}
 	}
 
-	pubring, err := os.ReadFile(path.Join(gpgHome, "pubring.gpg"))
-	if err != nil {
-		if !os.IsNotExist(err) {
-			return nil, err
-		}
-	} else {
-		_, err := m.importKeysFromBytes(pubring)
+	for _, keyring := range []string{"pubring.gpg", "secring.gpg"} {
+		contents, err := os.ReadFile(path.Join(gpgHome, keyring))
 		if err != nil {
-			return nil, err
+			if !os.IsNotExist(err) {
+				return nil, err
+			}
+		} else {
+			_, err := m.importKeysFromBytes(contents)
+			if err != nil {
+				return nil, err
+			}
 		}
 	}
 	return m, nil
 }
 
-// newEphemeralGPGSigningMechanism returns a new GPG/OpenPGP signing mechanism which
-// recognizes _only_ public keys from the supplied blob, and returns the identities
-// of these keys.
+// newEphemeralOpenPGPSigningMechanism returns a new GPG/OpenPGP signing mechanism implemented using x/crypto/openpgp,
+// which recognizes _only_ keys from the supplied blob, and returns the identities of these keys.
+// Private keys in blob, if any, can be used for signing.
 // The caller must call .Close() on the returned SigningMechanism.
-func newEphemeralGPGSigningMechanism(blob []byte) (signingMechanismWithPassphrase, []string, error) {
+func newEphemeralOpenPGPSigningMechanism(blob []byte) (signingMechanismWithPassphrase, []string, error) {
 	m := &openpgpSigningMechanism{
 		keyring: openpgp.EntityList{},
 	}
@@ -106,13 +108,28 @@ func (m *openpgpSigningMechanism) importKeysFromBytes(blob []byte) ([]string, er
 
 // SupportsSigning returns nil if the mechanism supports signing, or a SigningNotSupportedError.
 func (m *openpgpSigningMechanism) SupportsSigning() error {
-	return SigningNotSupportedError("signing is not supported in github.com/containers/image built with the containers_image_openpgp build tag")
+	return nil
 }
 
 // Sign creates a (non-detached) signature of input using keyIdentity.
 // Fails with a SigningNotSupportedError if the mechanism does not support signing.
 func (m *openpgpSigningMechanism) SignWithPassphrase(input []byte, keyIdentity string, passphrase string) ([]byte, error) {
-	return nil, SigningNotSupportedError("signing is not supported in github.com/containers/image built with the containers_image_openpgp build tag")
+	signer, err := m.signingEntity(keyIdentity, passphrase)
+	if err != nil {
+		return nil, err
+	}
+	var sig bytes.Buffer
+	w, err := openpgp.Sign(&sig, signer, nil, &packet.Config{DefaultHash: crypto.SHA256})
+	if err != nil {
+		return nil, err
+	}
+	if _, err := w.Write(input); err != nil {
+		return nil, err
+	}
+	if err := w.Close(); err != nil {
+		return nil, err
+	}
+	return sig.Bytes(), nil
 }
 
 // Sign creates a (non-detached) signature of input using keyIdentity.
@@ -121,6 +138,39 @@ func (m *openpgpSigningMechanism) Sign(input []byte, keyIdentity string) ([]byte
 	return m.SignWithPassphrase(input, keyIdentity, "")
 }
 
+// signingEntity returns an entity with a decrypted private key identified by keyIdentity
+// (a fingerprint or a 64-bit key ID), decrypting it using passphrase if necessary.
+func (m *openpgpSigningMechanism) signingEntity(keyIdentity string, passphrase string) (*openpgp.Entity, error) {
+	wanted := strings.ToUpper(strings.TrimPrefix(strings.TrimPrefix(keyIdentity, "0x"), "0X"))
+	for _, entity := range m.keyring {
+		if entity.PrivateKey == nil {
+			continue
+		}
+		fingerprint := strings.ToUpper(fmt.Sprintf("%x", entity.PrimaryKey.Fingerprint))
+		if wanted != fingerprint && (len(wanted) != 16 || wanted != entity.PrimaryKey.KeyIdString()) {
+			continue
+		}
+		privateKeys := []*packet.PrivateKey{entity.PrivateKey}
+		for _, subkey := range entity.Subkeys {
+			if subkey.PrivateKey != nil {
+				privateKeys = append(privateKeys, subkey.PrivateKey)
+			}
+		}
+		for _, privateKey := range privateKeys {
+			if privateKey.Encrypted {
+				if passphrase == "" {
+					return nil, fmt.Errorf("private key %s is protected by a passphrase", fingerprint)
+				}
+				if err := privateKey.Decrypt([]byte(passphrase)); err != nil {
+					return nil, fmt.Errorf("decrypting private key %s: %w", fingerprint, err)
+				}
+			}
+		}
+		return entity, nil
+	}
+	return nil, fmt.Errorf("private key %s not found", keyIdentity)
+}
+
 // Verify parses unverifiedSignature and returns the content and the signer's identity
 func (m *openpgpSigningMechanism) Verify(unverifiedSignature []byte) (contents []byte, keyIdentity string, err error) {
 	md, err := openpgp.ReadMessage(bytes.NewReader(unverifiedSignature), m.keyring, nil, nil)
@@ -1,9 +1,7 @@
-//go:build containers_image_openpgp
-// +build containers_image_openpgp
-
 package signature
 
 import (
+	"os"
 	"testing"
 
 	"github.com/stretchr/testify/assert"
@@ -11,19 +9,71 @@ import (
 )
 
 func TestOpenpgpSigningMechanismSupportsSigning(t *testing.T) {
-	mech, _, err := NewEphemeralGPGSigningMechanism([]byte{})
+	mech, _, err := NewEphemeralGPGSigningMechanismWithBackend(GPGBackendOpenPGP, []byte{})
 	require.NoError(t, err)
 	defer mech.Close()
 	err = mech.SupportsSigning()
-	assert.Error(t, err)
-	assert.IsType(t, SigningNotSupportedError(""), err)
+	assert.NoError(t, err)
 }
 
 func TestOpenpgpSigningMechanismSign(t *testing.T) {
-	mech, _, err := NewEphemeralGPGSigningMechanism([]byte{})
+	mech, err := newOpenPGPSigningMechanismInDirectory(testGPGHomeDirectory)
 	require.NoError(t, err)
 	defer mech.Close()
-	_, err = mech.Sign([]byte{}, TestKeyFingerprint)
+	// Signatures are verifiable by the default mechanism as well.
+	defaultMech, err := newGPGSigningMechanismInDirectory(testGPGHomeDirectory)
+	require.NoError(t, err)
+	defer defaultMech.Close()
+
+	content := []byte("content")
+	for _, keyIdentity := range []string{TestKeyFingerprint, "0x" + TestKeyFingerprint, TestKeyShortID} {
+		signature, err := mech.Sign(content, keyIdentity)
+		require.NoError(t, err, keyIdentity)
+		for _, m := range []SigningMechanism{mech, defaultMech} {
+			signedContent, signingFingerprint, err := m.Verify(signature)
+			require.NoError(t, err, keyIdentity)
+			assert.Equal(t, content, signedContent, keyIdentity)
+			assert.Equal(t, TestKeyFingerprint, signingFingerprint, keyIdentity)
+		}
+	}
+
+	// A key protected by a passphrase
+	_, err = mech.Sign(content, TestKeyFingerprintWithPassphrase)
+	assert.Error(t, err)
+	_, err = mech.SignWithPassphrase(content, TestKeyFingerprintWithPassphrase, "wrong passphrase")
+	assert.Error(t, err)
+	signature, err := mech.SignWithPassphrase(content, TestKeyFingerprintWithPassphrase, TestPassphrase)
+	require.NoError(t, err)
+	signedContent, signingFingerprint, err := mech.Verify(signature)
+	require.NoError(t, err)
+	assert.Equal(t, content, signedContent)
+	assert.Equal(t, TestKeyFingerprintWithPassphrase, signingFingerprint)
+
+	// Unknown keys
+	for _, keyIdentity := range []string{"this fingerprint doesn't exist", "", TestKeyFingerprint[:8]} {
+		_, err = mech.Sign(content, keyIdentity)
+		assert.Error(t, err, keyIdentity)
+	}
+
+	// Private keys supplied to an ephemeral mechanism
+	secring, err := os.ReadFile("./fixtures/secring.gpg")
+	require.NoError(t, err)
+	ephemeralMech, keyIdentities, err := NewEphemeralGPGSigningMechanismWithBackend(GPGBackendOpenPGP, secring)
+	require.NoError(t, err)
+	defer ephemeralMech.Close()
+	assert.Equal(t, []string{TestKeyFingerprint, TestKeyFingerprintWithPassphrase}, keyIdentities)
+	signature, err = ephemeralMech.Sign(content, TestKeyFingerprint)
+	require.NoError(t, err)
+	_, signingFingerprint, err = defaultMech.Verify(signature)
+	require.NoError(t, err)
+	assert.Equal(t, TestKeyFingerprint, signingFingerprint)
+
+	// Only public keys are available
+	publicKey, err := os.ReadFile("./fixtures/public-key.gpg")
+	require.NoError(t, err)
+	ephemeralMech, _, err = NewEphemeralGPGSigningMechanismWithBackend(GPGBackendOpenPGP, publicKey)
+	require.NoError(t, err)
+	defer ephemeralMech.Close()
+	_, err = ephemeralMech.Sign(content, TestKeyFingerprint)
 	assert.Error(t, err)
-	assert.IsType(t, SigningNotSupportedError(""), err)
 }
@@ -35,11 +35,68 @@ func TestSigningNotSupportedError(t *testing.T) {
 	assert.Equal(t, s, err.Error())
 }
 
+func TestResolveGPGBackend(t *testing.T) {
+	origBackend, hadBackend := os.LookupEnv(gpgBackendEnvironmentVariable)
+	defer func() {
+		if hadBackend {
+			os.Setenv(gpgBackendEnvironmentVariable, origBackend)
+		} else {
+			os.Unsetenv(gpgBackendEnvironmentVariable)
+		}
+	}()
+
+	for _, c := range []struct {
+		env      string
+		backend  GPGBackend
+		expected GPGBackend
+	}{
+		{"", GPGBackendDefault, defaultGPGBackend},
+		{"", GPGBackendGPGME, GPGBackendGPGME},
+		{"", GPGBackendOpenPGP, GPGBackendOpenPGP},
+		{"openpgp", GPGBackendDefault, GPGBackendOpenPGP},
+		{"gpgme", GPGBackendDefault, GPGBackendGPGME},
+		{"gpgme", GPGBackendOpenPGP, GPGBackendOpenPGP},
+		{"openpgp", GPGBackendGPGME, GPGBackendGPGME},
+	} {
+		os.Setenv(gpgBackendEnvironmentVariable, c.env)
+		res, err := resolveGPGBackend(c.backend)
+		require.NoError(t, err, c)
+		assert.Equal(t, c.expected, res, c)
+	}
+
+	os.Setenv(gpgBackendEnvironmentVariable, "")
+	_, err := resolveGPGBackend("unknown")
+	assert.Error(t, err)
+	_, err = NewGPGSigningMechanismWithBackend("unknown")
+	assert.Error(t, err)
+	_, _, err = NewEphemeralGPGSigningMechanismWithBackend("unknown", []byte{})
+	assert.Error(t, err)
+	os.Setenv(gpgBackendEnvironmentVariable, "unknown")
+	_, err = NewGPGSigningMechanism()
+	assert.Error(t, err)
+	_, _, err = NewEphemeralGPGSigningMechanism([]byte{})
+	assert.Error(t, err)
+
+	// The environment variable selects the default backend at runtime.
+	os.Setenv(gpgBackendEnvironmentVariable, "openpgp")
+	mech, err := NewGPGSigningMechanism()
+	require.NoError(t, err)
+	defer mech.Close()
+	assert.IsType(t, &openpgpSigningMechanism{}, mech)
+	mech, _, err = NewEphemeralGPGSigningMechanism([]byte{})
+	require.NoError(t, err)
+	defer mech.Close()
+	assert.IsType(t, &openpgpSigningMechanism{}, mech)
+}
+
 func TestNewGPGSigningMechanism(t *testing.T) {
 	// A dumb test just for code coverage. We test more with newGPGSigningMechanismInDirectory().
 	mech, err := NewGPGSigningMechanism()
 	assert.NoError(t, err)
 	mech.Close()
+	mech, err = NewGPGSigningMechanismWithBackend(GPGBackendOpenPGP)
+	assert.NoError(t, err)
+	mech.Close()
 }
 
 func TestNewGPGSigningMechanismInDirectory(t *testing.T) {
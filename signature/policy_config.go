@@ -14,11 +14,13 @@
 package signature
 
 import (
+	"context"
 	"encoding/json"
 	"fmt"
 	"os"
 	"path/filepath"
 	"regexp"
+	"strings"
 
 	"github.com/containers/image/v5/docker/reference"
 	"github.com/containers/image/v5/transports"
@@ -36,9 +38,24 @@ var systemDefaultPolicyPath = builtinDefaultPolicyPath
 // DO NOT change this, instead see systemDefaultPolicyPath above.
 const builtinDefaultPolicyPath = "/etc/containers/policy.json"
 
+// systemDefaultPolicyDirPath is the drop-in policy directory used for DefaultPolicy().
+// You can override this at build time with
+// -ldflags '-X github.com/containers/image/v5/signature.systemDefaultPolicyDirPath=$your_path'
+var systemDefaultPolicyDirPath = builtinDefaultPolicyDirPath
+
+// builtinDefaultPolicyDirPath is the drop-in policy directory used for DefaultPolicy().
+// DO NOT change this, instead see systemDefaultPolicyDirPath above.
+const builtinDefaultPolicyDirPath = "/etc/containers/policy.d"
+
 // userPolicyFile is the path to the per user policy path.
 var userPolicyFile = filepath.FromSlash(".config/containers/policy.json")
 
+// userPolicyDir is the path to the per user drop-in policy directory.
+var userPolicyDir = filepath.FromSlash(".config/containers/policy.d")
+
+// dropInPolicySuffix is the suffix of drop-in policy files in a policy.d directory.
+const dropInPolicySuffix = ".json"
+
 // InvalidPolicyFormatError is returned when parsing an invalid policy configuration.
 type InvalidPolicyFormatError string
 
@@ -50,10 +67,33 @@ func (err InvalidPolicyFormatError) Error() string {
 // Most applications should be using this method to get the policy configured
 // by the system administrator.
 // sys should usually be nil, can be set to override the default.
+// By default, the policy is read from the per-user policy.json, if it exists, otherwise from the system policy.json;
+// if sys.SignaturePolicyMergeDropIns is set, or sys.SignaturePolicyDirPath is not "", the policy is instead merged
+// from policy files and drop-in directories, see NewPolicyFromFiles.
 // NOTE: When this function returns an error, report it to the user and abort.
 // DO NOT hard-code fallback policies in your application.
 func DefaultPolicy(sys *types.SystemContext) (*Policy, error) {
-	return NewPolicyFromFile(defaultPolicyPath(sys))
+	if sys != nil && sys.SignaturePolicyRemote != nil {
+		if sys.SignaturePolicyPath != "" || sys.SignaturePolicyDirPath != "" {
+			return nil, errors.New("SignaturePolicyPath and SignaturePolicyDirPath can't be used together with SignaturePolicyRemote")
+		}
+		return NewPolicyFromRemoteSource(context.Background(), sys, sys.SignaturePolicyRemote)
+	}
+	if !mergeDropInPolicies(sys) {
+		return NewPolicyFromFile(defaultPolicyPath(sys))
+	}
+	if sys.SignaturePolicyPath != "" {
+		// An explicitly specified policy must exist, even if drop-in files could provide a complete policy.
+		if _, err := os.Stat(sys.SignaturePolicyPath); err != nil {
+			return nil, err
+		}
+	}
+	return NewPolicyFromFiles(defaultPolicySources(sys)...)
+}
+
+// mergeDropInPolicies returns true if DefaultPolicy should merge the policy from policy files and drop-in directories.
+func mergeDropInPolicies(sys *types.SystemContext) bool {
+	return sys != nil && (sys.SignaturePolicyMergeDropIns || sys.SignaturePolicyDirPath != "")
 }
 
 // defaultPolicyPath returns a path to the default policy of the system.
@@ -77,6 +117,36 @@ func defaultPolicyPathWithHomeDir(sys *types.SystemContext, homeDir string) stri
 	return systemDefaultPolicyPath
 }
 
+// defaultPolicySources returns paths to the default policy files and drop-in directories of the system,
+// in increasing order of precedence, for use if mergeDropInPolicies(sys).
+func defaultPolicySources(sys *types.SystemContext) []string {
+	return defaultPolicySourcesWithHomeDir(sys, homedir.Get())
+}
+
+// defaultPolicySourcesWithHomeDir is an internal implementation detail of defaultPolicySources,
+// it exists only to allow testing it with an artificial home directory.
+func defaultPolicySourcesWithHomeDir(sys *types.SystemContext, homeDir string) []string {
+	if sys != nil && sys.SignaturePolicyPath != "" {
+		res := []string{sys.SignaturePolicyPath}
+		if sys.SignaturePolicyDirPath != "" {
+			res = append(res, sys.SignaturePolicyDirPath)
+		}
+		return res
+	}
+
+	systemPolicyPath, systemPolicyDirPath := systemDefaultPolicyPath, systemDefaultPolicyDirPath
+	if sys != nil && sys.RootForImplicitAbsolutePaths != "" {
+		systemPolicyPath = filepath.Join(sys.RootForImplicitAbsolutePaths, systemDefaultPolicyPath)
+		systemPolicyDirPath = filepath.Join(sys.RootForImplicitAbsolutePaths, systemDefaultPolicyDirPath)
+	}
+	userPolicyFilePath := filepath.Join(homeDir, userPolicyFile)
+	if sys != nil && sys.SignaturePolicyDirPath != "" {
+		// Directory explicitly chosen: it replaces the system drop-in directory, and the per-user one is not used.
+		return []string{systemPolicyPath, sys.SignaturePolicyDirPath, userPolicyFilePath}
+	}
+	return []string{systemPolicyPath, systemPolicyDirPath, userPolicyFilePath, filepath.Join(homeDir, userPolicyDir)}
+}
+
 // NewPolicyFromFile returns a policy configured in the specified file.
 func NewPolicyFromFile(fileName string) (*Policy, error) {
 	contents, err := os.ReadFile(fileName)
@@ -90,6 +160,126 @@ func NewPolicyFromFile(fileName string) (*Policy, error) {
 	return policy, nil
 }
 
+// NewPolicyFromFiles returns a policy merged from paths, in increasing order of precedence.
+// Each of paths is either a policy file, or a drop-in directory containing policy files named *.json;
+// paths that don't exist are ignored, but at least one policy file must exist.
+//
+// The individual files use the same format as a policy file, except that they don't have to specify "default".
+// A "default" value, or the requirements for a transport scope, specified in one of paths replace the value
+// specified in paths with a lower precedence; the merged policy must specify "default".
+// Files within a single drop-in directory must not specify the same "default" or transport scope,
+// because it would not be clear which one should take effect.
+func NewPolicyFromFiles(paths ...string) (*Policy, error) {
+	res := &Policy{Transports: map[string]PolicyTransportScopes{}}
+	found := false
+	for _, path := range paths {
+		fi, err := os.Stat(path)
+		if err != nil {
+			if os.IsNotExist(err) {
+				continue
+			}
+			return nil, err
+		}
+		var layer *Policy
+		if fi.IsDir() {
+			var foundInDir bool
+			layer, foundInDir, err = newPolicyFromDropInDir(path)
+			if err != nil {
+				return nil, err
+			}
+			found = found || foundInDir
+		} else {
+			layer, err = newPolicyFragmentFromFile(path)
+			if err != nil {
+				return nil, err
+			}
+			found = true
+		}
+		res.mergeFrom(layer)
+	}
+	if !found {
+		return nil, errors.Errorf("no policy files found in %s", strings.Join(paths, ", "))
+	}
+	if res.Default == nil {
+		return nil, InvalidPolicyFormatError(fmt.Sprintf("Default policy is missing in %s", strings.Join(paths, ", ")))
+	}
+	return res, nil
+}
+
+// newPolicyFromDropInDir returns a policy merged from the drop-in files in dir, and whether any drop-in files exist.
+func newPolicyFromDropInDir(dir string) (*Policy, bool, error) {
+	entries, err := os.ReadDir(dir)
+	if err != nil {
+		return nil, false, err
+	}
+	res := &Policy{Transports: map[string]PolicyTransportScopes{}}
+	found := false
+	origins := map[string]string{} // What is specified -> file which specified it
+	// recordOrigin fails if something other than path already specified what.
+	recordOrigin := func(what, path string) error {
+		if other, ok := origins[what]; ok {
+			return errors.Errorf("conflicting drop-in policy files %q and %q: both specify %s", other, path, what)
+		}
+		origins[what] = path
+		return nil
+	}
+	for _, e := range entries { // os.ReadDir sorts entries by name, so the error messages are deterministic.
+		if e.IsDir() || !strings.HasSuffix(e.Name(), dropInPolicySuffix) {
+			continue
+		}
+		path := filepath.Join(dir, e.Name())
+		fragment, err := newPolicyFragmentFromFile(path)
+		if err != nil {
+			return nil, false, err
+		}
+		found = true
+		if fragment.Default != nil {
+			if err := recordOrigin(`"default"`, path); err != nil {
+				return nil, false, err
+			}
+		}
+		for transport, scopes := range fragment.Transports {
+			for scope := range scopes {
+				if err := recordOrigin(fmt.Sprintf("transport %q scope %q", transport, scope), path); err != nil {
+					return nil, false, err
+				}
+			}
+		}
+		res.mergeFrom(fragment)
+	}
+	return res, found, nil
+}
+
+// newPolicyFragmentFromFile returns a policy configured in the specified file, which does not have to specify "default".
+func newPolicyFragmentFromFile(fileName string) (*Policy, error) {
+	contents, err := os.ReadFile(fileName)
+	if err != nil {
+		return nil, err
+	}
+	var fragment policyFragment
+	if err := json.Unmarshal(contents, &fragment); err != nil {
+		return nil, errors.Wrapf(InvalidPolicyFormatError(err.Error()), "invalid policy in %q", fileName)
+	}
+	return (*Policy)(&fragment), nil
+}
+
+// mergeFrom updates p with the values specified in other, which has a higher precedence.
+func (p *Policy) mergeFrom(other *Policy) {
+	if other.Default != nil {
+		p.Default = other.Default
+	}
+	for transport, scopes := range other.Transports {
+		dest, ok := p.Transports[transport]
+		if !ok {
+			dest = PolicyTransportScopes{}
+			p.Transports[transport] = dest
+		}
+		for scope, reqs := range scopes {
+			dest[scope] = reqs
+		}
+	}
+}
+
 // NewPolicyFromBytes returns a policy parsed from the specified blob.
 // Use this function instead of calling json.Unmarshal directly.
 func NewPolicyFromBytes(data []byte) (*Policy, error) {
@@ -105,7 +295,24 @@ var _ json.Unmarshaler = (*Policy)(nil)
 
 // UnmarshalJSON implements the json.Unmarshaler interface.
 func (p *Policy) UnmarshalJSON(data []byte) error {
-	*p = Policy{}
+	if err := (*policyFragment)(p).UnmarshalJSON(data); err != nil {
+		return err
+	}
+	if p.Default == nil {
+		return InvalidPolicyFormatError("Default policy is missing")
+	}
+	return nil
+}
+
+// policyFragment is a Policy which does not have to specify "default", i.e. the contents of one of the files merged by NewPolicyFromFiles.
+type policyFragment Policy
+
+// Compile-time check that policyFragment implements json.Unmarshaler.
+var _ json.Unmarshaler = (*policyFragment)(nil)
+
+// UnmarshalJSON implements the json.Unmarshaler interface.
+func (p *policyFragment) UnmarshalJSON(data []byte) error {
+	*p = policyFragment{}
 	transports := policyTransportsMap{}
 	if err := paranoidUnmarshalJSONObject(data, func(key string) interface{} {
 		switch key {
@@ -119,10 +326,6 @@ func (p *Policy) UnmarshalJSON(data []byte) error {
 	}); err != nil {
 		return err
 	}
-
-	if p.Default == nil {
-		return InvalidPolicyFormatError("Default policy is missing")
-	}
 	p.Transports = map[string]PolicyTransportScopes(transports)
 	return nil
 }
@@ -246,6 +449,10 @@ func newPolicyRequirementFromJSON(data []byte) (PolicyRequirement, error) {
 		res = &prSignedBy{}
 	case prTypeSignedBaseLayer:
 		res = &prSignedBaseLayer{}
+	case prTypeSigstoreSigned:
+		res = &prSigstoreSigned{}
+	case prTypePlatformSpecific:
+		res = &prPlatformSpecific{}
 	default:
 		return nil, InvalidPolicyFormatError(fmt.Sprintf("Unknown policy requirement type \"%s\"", typeField.Type))
 	}
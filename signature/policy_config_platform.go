@@ -0,0 +1,119 @@
+package signature
+
+import (
+	"encoding/json"
+	"fmt"
+	"strings"
+)
+
+// newPRPlatformSpecific is NewPRPlatformSpecific, except it returns the private type.
+func newPRPlatformSpecific(platforms map[string]PolicyRequirements, defaultRequirements PolicyRequirements) (*prPlatformSpecific, error) {
+	if len(platforms) == 0 {
+		return nil, InvalidPolicyFormatError("platforms not specified")
+	}
+	for platform, reqs := range platforms {
+		if err := validatePolicyPlatform(platform); err != nil {
+			return nil, err
+		}
+		if len(reqs) == 0 {
+			return nil, InvalidPolicyFormatError(fmt.Sprintf("List of verification policy requirements for platform %q must not be empty", platform))
+		}
+	}
+	if len(defaultRequirements) == 0 {
+		defaultRequirements = nil
+	}
+	return &prPlatformSpecific{
+		prCommon:  prCommon{Type: prTypePlatformSpecific},
+		Platforms: platforms,
+		Default:   defaultRequirements,
+	}, nil
+}
+
+// NewPRPlatformSpecific returns a new "platformSpecific" PolicyRequirement.
+// platforms maps platforms, in the "os/architecture" or "os/architecture/variant" format, to requirements for instances
+// of manifest lists chosen for that platform; defaultRequirements, if not empty, apply to instances chosen for other platforms.
+// Images not chosen from a manifest list for a known platform must satisfy all of the requirements.
+func NewPRPlatformSpecific(platforms map[string]PolicyRequirements, defaultRequirements PolicyRequirements) (PolicyRequirement, error) {
+	return newPRPlatformSpecific(platforms, defaultRequirements)
+}
+
+// validatePolicyPlatform returns an error if platform is not a valid key of prPlatformSpecific.Platforms.
+func validatePolicyPlatform(platform string) error {
+	parts := strings.Split(platform, "/")
+	if len(parts) != 2 && len(parts) != 3 {
+		return InvalidPolicyFormatError(fmt.Sprintf(`Invalid platform %q, expected "os/architecture" or "os/architecture/variant"`, platform))
+	}
+	for _, part := range parts {
+		if part == "" {
+			return InvalidPolicyFormatError(fmt.Sprintf(`Invalid platform %q, expected "os/architecture" or "os/architecture/variant"`, platform))
+		}
+	}
+	return nil
+}
+
+// Compile-time check that prPlatformSpecific implements json.Unmarshaler.
+var _ json.Unmarshaler = (*prPlatformSpecific)(nil)
+
+// UnmarshalJSON implements the json.Unmarshaler interface.
+func (pr *prPlatformSpecific) UnmarshalJSON(data []byte) error {
+	*pr = prPlatformSpecific{}
+	var tmp prPlatformSpecific
+	gotPlatforms := false
+	platforms := policyPlatformsMap{}
+	if err := paranoidUnmarshalJSONObject(data, func(key string) interface{} {
+		switch key {
+		case "type":
+			return &tmp.Type
+		case "platforms":
+			gotPlatforms = true
+			return &platforms
+		case "default":
+			return &tmp.Default
+		default:
+			return nil
+		}
+	}); err != nil {
+		return err
+	}
+
+	if tmp.Type != prTypePlatformSpecific {
+		return InvalidPolicyFormatError(fmt.Sprintf("Unexpected policy requirement type \"%s\"", tmp.Type))
+	}
+	if !gotPlatforms {
+		return InvalidPolicyFormatError("platforms not specified")
+	}
+	res, err := newPRPlatformSpecific(platforms, tmp.Default)
+	if err != nil {
+		return err
+	}
+	*pr = *res
+	return nil
+}
+
+// policyPlatformsMap is a specialization of this map type for the strict JSON parsing semantics appropriate for the prPlatformSpecific.Platforms member.
+type policyPlatformsMap map[string]PolicyRequirements
+
+// Compile-time check that policyPlatformsMap implements json.Unmarshaler.
+var _ json.Unmarshaler = (*policyPlatformsMap)(nil)
+
+// UnmarshalJSON implements the json.Unmarshaler interface.
+func (m *policyPlatformsMap) UnmarshalJSON(data []byte) error {
+	// We can't unmarshal directly into map values because it is not possible to take an address of a map value.
+	// So, use a temporary map of pointers-to-slices and convert.
+	tmpMap := map[string]*PolicyRequirements{}
+	if err := paranoidUnmarshalJSONObject(data, func(key string) interface{} {
+		// paranoidUnmarshalJSONObject detects key duplication for us, check just to be safe.
+		if _, ok := tmpMap[key]; ok {
+			return nil
+		}
+		ptr := &PolicyRequirements{} // This allocates a new instance on each call.
+		tmpMap[key] = ptr
+		return ptr
+	}); err != nil {
+		return err
+	}
+	for key, ptr := range tmpMap {
+		(*m)[key] = *ptr
+	}
+	return nil
+}
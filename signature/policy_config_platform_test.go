@@ -0,0 +1,112 @@
+package signature
+
+import (
+	"encoding/json"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// xNewPRPlatformSpecific is like NewPRPlatformSpecific, except it must not fail.
+func xNewPRPlatformSpecific(platforms map[string]PolicyRequirements, defaultRequirements PolicyRequirements) PolicyRequirement {
+	pr, err := NewPRPlatformSpecific(platforms, defaultRequirements)
+	if err != nil {
+		panic("xNewPRPlatformSpecific failed")
+	}
+	return pr
+}
+
+func TestNewPRPlatformSpecific(t *testing.T) {
+	platforms := map[string]PolicyRequirements{
+		"linux/amd64":    {NewPRReject()},
+		"linux/arm64/v8": {NewPRInsecureAcceptAnything()},
+	}
+	defaultRequirements := PolicyRequirements{NewPRReject()}
+
+	// Success
+	_pr, err := NewPRPlatformSpecific(platforms, defaultRequirements)
+	require.NoError(t, err)
+	pr, ok := _pr.(*prPlatformSpecific)
+	require.True(t, ok)
+	assert.Equal(t, &prPlatformSpecific{
+		prCommon:  prCommon{prTypePlatformSpecific},
+		Platforms: platforms,
+		Default:   defaultRequirements,
+	}, pr)
+
+	// Default requirements are optional
+	for _, d := range []PolicyRequirements{nil, {}} {
+		_pr, err = NewPRPlatformSpecific(platforms, d)
+		require.NoError(t, err)
+		pr, ok = _pr.(*prPlatformSpecific)
+		require.True(t, ok)
+		assert.Nil(t, pr.Default)
+	}
+
+	// Invalid platforms
+	for _, invalid := range []map[string]PolicyRequirements{
+		nil,
+		{},
+		{"linux/amd64": {}},
+		{"linux/amd64": nil},
+		{"linux": {NewPRReject()}},
+		{"linux/arm64/v8/extra": {NewPRReject()}},
+		{"/amd64": {NewPRReject()}},
+		{"linux/": {NewPRReject()}},
+		{"linux/arm64/": {NewPRReject()}},
+		{"": {NewPRReject()}},
+	} {
+		_, err = NewPRPlatformSpecific(invalid, nil)
+		assert.Error(t, err, invalid)
+	}
+}
+
+func TestPRPlatformSpecificUnmarshalJSON(t *testing.T) {
+	policyJSONUmarshallerTests{
+		newDest: func() json.Unmarshaler { return &prPlatformSpecific{} },
+		newValidObject: func() (interface{}, error) {
+			return NewPRPlatformSpecific(map[string]PolicyRequirements{
+				"linux/amd64":    {xNewPRSignedByKeyPath(SBKeyTypeGPGKeys, "/keys/RH-key-signing-key-gpg-keys", NewPRMMatchRepoDigestOrExact())},
+				"linux/arm64/v8": {NewPRInsecureAcceptAnything()},
+			}, PolicyRequirements{NewPRReject()})
+		},
+		otherJSONParser: func(validJSON []byte) (interface{}, error) {
+			return newPolicyRequirementFromJSON(validJSON)
+		},
+		breakFns: []func(mSI){
+			// The "type" field is missing
+			func(v mSI) { delete(v, "type") },
+			// Wrong "type" field
+			func(v mSI) { v["type"] = 1 },
+			func(v mSI) { v["type"] = "this is invalid" },
+			// Extra top-level sub-object
+			func(v mSI) { v["unexpected"] = 1 },
+			// The "platforms" field is missing
+			func(v mSI) { delete(v, "platforms") },
+			// Invalid "platforms" field
+			func(v mSI) { v["platforms"] = 1 },
+			func(v mSI) { v["platforms"] = nil },
+			func(v mSI) { v["platforms"] = mSI{} },
+			func(v mSI) { x(v, "platforms")["linux"] = []interface{}{mSI{"type": "reject"}} },
+			func(v mSI) { x(v, "platforms")["linux/s390x"] = []interface{}{} },
+			func(v mSI) { x(v, "platforms")["linux/s390x"] = []interface{}{mSI{"type": "this is invalid"}} },
+			// Invalid "default" field
+			func(v mSI) { v["default"] = 1 },
+			func(v mSI) { v["default"] = nil },
+			func(v mSI) { v["default"] = []interface{}{} },
+			func(v mSI) { v["default"] = []interface{}{mSI{"type": "this is invalid"}} },
+		},
+		duplicateFields: []string{"type", "platforms", "default"},
+	}.run(t)
+
+	// "default" is optional
+	var pr prPlatformSpecific
+	err := json.Unmarshal([]byte(`{"type":"platformSpecific","platforms":{"linux/amd64":[{"type":"reject"}]}}`), &pr)
+	require.NoError(t, err)
+	assert.Equal(t, xNewPRPlatformSpecific(map[string]PolicyRequirements{"linux/amd64": {NewPRReject()}}, nil), &pr)
+
+	// Duplicate platforms
+	err = json.Unmarshal([]byte(`{"type":"platformSpecific","platforms":{"linux/amd64":[{"type":"reject"}],"linux/amd64":[{"type":"reject"}]}}`), &pr)
+	assert.Error(t, err)
+}
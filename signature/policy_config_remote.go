@@ -0,0 +1,422 @@
+// Loading of policies from remote sources, see types.RemoteSignaturePolicy.
+
+package signature
+
+import (
+	"bytes"
+	"context"
+	"crypto"
+	"crypto/sha256"
+	"crypto/tls"
+	"encoding/base64"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/url"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+
+	"github.com/containers/image/v5/image"
+	"github.com/containers/image/v5/internal/iolimits"
+	"github.com/containers/image/v5/manifest"
+	"github.com/containers/image/v5/pkg/blobinfocache/none"
+	"github.com/containers/image/v5/pkg/tlsclientconfig"
+	"github.com/containers/image/v5/signature/sigstore"
+	"github.com/containers/image/v5/transports"
+	"github.com/containers/image/v5/types"
+	"github.com/containers/storage/pkg/homedir"
+	"github.com/containers/storage/pkg/ioutils"
+	imgspecv1 "github.com/opencontainers/image-spec/specs-go/v1"
+	"github.com/pkg/errors"
+	"github.com/sirupsen/logrus"
+)
+
+// remotePolicySignatureSuffix is appended to the URL of a policy at an https:// URL to locate its signature.
+const remotePolicySignatureSuffix = ".sig"
+
+// perHostCertDirs are the default directories containing host[:port] subdirectories with TLS certificates and keys,
+// in the order they are searched; keep this in sync with the docker transport.
+var perHostCertDirs = []string{"/etc/containers/certs.d", "/etc/docker/certs.d"}
+
+// userCertDir is the per-user directory containing host[:port] subdirectories with TLS certificates and keys.
+var userCertDir = filepath.FromSlash(".config/containers/certs.d")
+
+// cachedRemotePolicy is the format of a remote policy cached in types.RemoteSignaturePolicy.CacheDir.
+// The cache directory is not trusted: the cache contains the data necessary to verify the signature of the policy
+// again, and it is verified every time the cached policy is used.
+type cachedRemotePolicy struct {
+	Policy  []byte    `json:"policy"`
+	Expires time.Time `json:"expires"`
+	// Signature is the signature of Policy, for policies at https:// URLs.
+	Signature []byte `json:"signature,omitempty"`
+	// Manifest, ManifestMIMEType and SigstoreSignatures describe the OCI artifact containing Policy, for policies in images.
+	Manifest           []byte               `json:"manifest,omitempty"`
+	ManifestMIMEType   string               `json:"manifestMIMEType,omitempty"`
+	SigstoreSignatures []sigstore.Signature `json:"sigstoreSignatures,omitempty"`
+}
+
+// NewPolicyFromRemoteSource returns a policy loaded from the remote source configured by remote, after verifying its signature,
+// and using a cached copy if configured.  sys, which may be nil, is used to access images.
+// NOTE: When this function returns an error, report it to the user and abort.
+// DO NOT hard-code fallback policies in your application.
+func NewPolicyFromRemoteSource(ctx context.Context, sys *types.SystemContext, remote *types.RemoteSignaturePolicy) (*Policy, error) {
+	return newPolicyFromRemoteSource(ctx, sys, remote, nil, time.Now())
+}
+
+// newPolicyFromRemoteSource is NewPolicyFromRemoteSource, using httpClient, if not nil, for https:// sources, at time now.
+func newPolicyFromRemoteSource(ctx context.Context, sys *types.SystemContext, remote *types.RemoteSignaturePolicy,
+	httpClient *http.Client, now time.Time) (*Policy, error) {
+	if remote.Source == "" {
+		return nil, errors.New("remote policy source not specified")
+	}
+	if (remote.PublicKeyPath == "") == (remote.PublicKeyData == nil) {
+		return nil, errors.New("exactly one of PublicKeyPath and PublicKeyData must be specified for a remote policy")
+	}
+	keyData, err := loadKeyData(remote.PublicKeyPath, remote.PublicKeyData)
+	if err != nil {
+		return nil, errors.Wrap(err, "reading remote policy public keys")
+	}
+	publicKeys, err := sigstore.ParsePublicKeys(keyData)
+	if err != nil {
+		return nil, errors.Wrap(err, "parsing remote policy public keys")
+	}
+
+	u, err := url.Parse(remote.Source)
+	isURL := err == nil && (u.Scheme == "https" || u.Scheme == "http")
+	if isURL && u.Scheme != "https" {
+		return nil, errors.Errorf("remote policy source %q does not use HTTPS", remote.Source)
+	}
+
+	cachePath := remotePolicyCachePath(remote, keyData)
+	if cachePath != "" {
+		if cached, ok := readCachedRemotePolicy(cachePath, now); ok {
+			var err error
+			if isURL {
+				err = verifyRemotePolicySignature(cached.Policy, cached.Signature, publicKeys)
+			} else {
+				err = verifyCachedRemotePolicyImage(ctx, remote.Source, cached, keyData)
+			}
+			if err == nil {
+				policy, err := NewPolicyFromBytes(cached.Policy)
+				if err == nil {
+					return policy, nil
+				}
+				logrus.Debugf("Ignoring invalid cached policy %s: %v", cachePath, err)
+			} else {
+				logrus.Debugf("Ignoring cached policy %s which can't be verified: %v", cachePath, err)
+			}
+		}
+	}
+
+	var verified *cachedRemotePolicy
+	if isURL {
+		if httpClient == nil {
+			httpClient, err = newRemotePolicyHTTPClient(sys, u.Host)
+			if err != nil {
+				return nil, err
+			}
+		}
+		verified, err = fetchRemotePolicyFromURL(ctx, httpClient, u, publicKeys)
+		if err != nil {
+			return nil, errors.Wrapf(err, "loading policy from %s", remote.Source)
+		}
+	} else {
+		verified, err = fetchRemotePolicyFromImage(ctx, sys, remote.Source, keyData)
+		if err != nil {
+			return nil, errors.Wrapf(err, "loading policy from %s", remote.Source)
+		}
+	}
+	policy, err := NewPolicyFromBytes(verified.Policy)
+	if err != nil {
+		return nil, errors.Wrapf(err, "invalid policy in %s", remote.Source)
+	}
+	if cachePath != "" {
+		verified.Expires = now.Add(remote.CacheTTL)
+		writeCachedRemotePolicy(cachePath, verified)
+	}
+	return policy, nil
+}
+
+// newRemotePolicyHTTPClient returns a HTTP client for accessing remote policies at hostPort, using the TLS configuration
+// for that host configured in sys or the default certs.d directories, and proxies configured in the environment.
+func newRemotePolicyHTTPClient(sys *types.SystemContext, hostPort string) (*http.Client, error) {
+	tlsClientConfig := &tls.Config{MinVersion: tls.VersionTLS12}
+	if err := tlsclientconfig.SetupCertificates(remotePolicyCertDir(sys, hostPort), tlsClientConfig); err != nil {
+		return nil, err
+	}
+	tr := tlsclientconfig.NewTransport()
+	tr.TLSClientConfig = tlsClientConfig
+	return &http.Client{Transport: tr}, nil
+}
+
+// remotePolicyCertDir returns a path to a directory to be consumed by tlsclientconfig.SetupCertificates() for hostPort,
+// using the same configuration as the docker transport.
+func remotePolicyCertDir(sys *types.SystemContext, hostPort string) string {
+	if sys != nil && sys.DockerCertPath != "" {
+		return sys.DockerCertPath
+	}
+	if sys != nil && sys.DockerPerHostCertDirPath != "" {
+		return filepath.Join(sys.DockerPerHostCertDirPath, hostPort)
+	}
+	candidates := []string{filepath.Join(homedir.Get(), userCertDir, hostPort)}
+	for _, dir := range perHostCertDirs {
+		if sys != nil && sys.RootForImplicitAbsolutePaths != "" {
+			dir = filepath.Join(sys.RootForImplicitAbsolutePaths, dir)
+		}
+		candidates = append(candidates, filepath.Join(dir, hostPort))
+	}
+	for _, dir := range candidates {
+		if _, err := os.Stat(dir); err == nil {
+			return dir
+		}
+	}
+	return candidates[len(candidates)-1]
+}
+
+// fetchRemotePolicyFromURL returns the contents of the policy at u, and its signature at u + remotePolicySignatureSuffix,
+// after verifying that the signature was made by one of publicKeys.
+func fetchRemotePolicyFromURL(ctx context.Context, httpClient *http.Client, u *url.URL, publicKeys []crypto.PublicKey) (*cachedRemotePolicy, error) {
+	data, err := httpGetRemotePolicyFile(ctx, httpClient, u.String())
+	if err != nil {
+		return nil, err
+	}
+	sigURL := *u
+	sigURL.Path += remotePolicySignatureSuffix
+	sigURL.RawPath = ""
+	b64Sig, err := httpGetRemotePolicyFile(ctx, httpClient, sigURL.String())
+	if err != nil {
+		return nil, errors.Wrap(err, "reading the policy signature")
+	}
+	sig, err := base64.StdEncoding.DecodeString(string(bytes.TrimSpace(b64Sig)))
+	if err != nil {
+		return nil, errors.Wrap(err, "decoding the policy signature")
+	}
+	if err := verifyRemotePolicySignature(data, sig, publicKeys); err != nil {
+		return nil, err
+	}
+	return &cachedRemotePolicy{Policy: data, Signature: sig}, nil
+}
+
+// verifyRemotePolicySignature returns an error unless sig is a signature of data made by one of publicKeys.
+func verifyRemotePolicySignature(data, sig []byte, publicKeys []crypto.PublicKey) error {
+	for _, key := range publicKeys {
+		if sigstore.VerifySignature(key, data, sig) == nil {
+			return nil
+		}
+	}
+	return PolicyRequirementError("The policy is not signed by any of the trusted keys")
+}
+
+// httpGetRemotePolicyFile returns the contents of rawURL.
+func httpGetRemotePolicyFile(ctx context.Context, httpClient *http.Client, rawURL string) ([]byte, error) {
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, rawURL, nil)
+	if err != nil {
+		return nil, err
+	}
+	res, err := httpClient.Do(req)
+	if err != nil {
+		return nil, err
+	}
+	defer res.Body.Close()
+	if res.StatusCode != http.StatusOK {
+		return nil, errors.Errorf("reading %s: status %d (%s)", rawURL, res.StatusCode, http.StatusText(res.StatusCode))
+	}
+	return iolimits.ReadAtMost(res.Body, iolimits.MaxPolicyBodySize)
+}
+
+// fetchRemotePolicyFromImage returns the contents of the policy in the only layer of the OCI artifact at imageName,
+// with the manifest and sigstore signatures of the artifact, after verifying that the artifact has a sigstore signature
+// made by one of the keys in keyData.
+func fetchRemotePolicyFromImage(ctx context.Context, sys *types.SystemContext, imageName string, keyData []byte) (*cachedRemotePolicy, error) {
+	ref, err := parseRemotePolicyImageName(imageName)
+	if err != nil {
+		return nil, err
+	}
+	src, err := ref.NewImageSource(ctx, sys)
+	if err != nil {
+		return nil, err
+	}
+	defer src.Close()
+	unparsed := image.UnparsedInstance(src, nil)
+
+	layer, err := verifyRemotePolicyImage(ctx, unparsed, keyData)
+	if err != nil {
+		return nil, err
+	}
+	stream, _, err := src.GetBlob(ctx, types.BlobInfo{Digest: layer.Digest, Size: layer.Size}, none.NoCache)
+	if err != nil {
+		return nil, err
+	}
+	defer stream.Close()
+	data, err := iolimits.ReadAtMost(stream, iolimits.MaxPolicyBodySize)
+	if err != nil {
+		return nil, err
+	}
+	if err := verifyRemotePolicyLayer(layer, data); err != nil {
+		return nil, err
+	}
+	manifestBlob, mimeType, err := unparsed.Manifest(ctx)
+	if err != nil { // Coverage: This can't fail, verifyRemotePolicyImage has read the manifest already.
+		return nil, err
+	}
+	sigs, err := unparsed.UntrustedSigstoreSignatures(ctx)
+	if err != nil { // Coverage: This can't fail, verifyRemotePolicyImage has read the signatures already.
+		return nil, err
+	}
+	return &cachedRemotePolicy{Policy: data, Manifest: manifestBlob, ManifestMIMEType: mimeType, SigstoreSignatures: sigs}, nil
+}
+
+// parseRemotePolicyImageName returns a reference for imageName, in the transport:reference format.
+func parseRemotePolicyImageName(imageName string) (types.ImageReference, error) {
+	// Keep this in sync with alltransports.ParseImageName; importing it would link all transports into this package.
+	parts := strings.SplitN(imageName, ":", 2)
+	if len(parts) != 2 {
+		return nil, errors.Errorf(`Invalid image name "%s", expected colon-separated transport:reference`, imageName)
+	}
+	transport := transports.Get(parts[0])
+	if transport == nil {
+		return nil, errors.Errorf(`Invalid image name "%s", unknown transport "%s"`, imageName, parts[0])
+	}
+	return transport.ParseReference(parts[1])
+}
+
+// verifyRemotePolicyImage verifies that unparsed has a sigstore signature made by one of the keys in keyData,
+// and returns the descriptor of the policy, the only layer of the OCI artifact.
+func verifyRemotePolicyImage(ctx context.Context, unparsed types.UnparsedImage, keyData []byte) (imgspecv1.Descriptor, error) {
+	requirement, err := newPRSigstoreSigned(PRSigstoreSignedWithKeyData(keyData), PRSigstoreSignedWithSignedIdentity(NewPRMMatchRepository()))
+	if err != nil {
+		return imgspecv1.Descriptor{}, err
+	}
+	allowed, err := requirement.isRunningImageAllowed(ctx, unparsed)
+	if err != nil {
+		return imgspecv1.Descriptor{}, errors.Wrap(err, "verifying the policy signature")
+	}
+	if !allowed { // Coverage: isRunningImageAllowed always returns an error when it rejects.
+		return imgspecv1.Descriptor{}, PolicyRequirementError("The policy is not signed by any of the trusted keys")
+	}
+
+	manifestBlob, mimeType, err := unparsed.Manifest(ctx)
+	if err != nil {
+		return imgspecv1.Descriptor{}, err
+	}
+	if manifest.NormalizedMIMEType(mimeType) != imgspecv1.MediaTypeImageManifest {
+		return imgspecv1.Descriptor{}, errors.Errorf("unexpected manifest type %s, expected an OCI artifact", mimeType)
+	}
+	m, err := manifest.OCI1FromManifest(manifestBlob)
+	if err != nil {
+		return imgspecv1.Descriptor{}, err
+	}
+	if len(m.Layers) != 1 {
+		return imgspecv1.Descriptor{}, errors.Errorf("expected an OCI artifact with one layer, found %d layers", len(m.Layers))
+	}
+	layer := m.Layers[0]
+	if layer.Size > iolimits.MaxPolicyBodySize {
+		return imgspecv1.Descriptor{}, errors.Errorf("policy layer size %d exceeds the maximum of %d bytes", layer.Size, iolimits.MaxPolicyBodySize)
+	}
+	return layer, nil
+}
+
+// verifyRemotePolicyLayer returns an error if data does not match layer.
+func verifyRemotePolicyLayer(layer imgspecv1.Descriptor, data []byte) error {
+	if err := layer.Digest.Validate(); err != nil {
+		return err
+	}
+	if layer.Digest.Algorithm().FromBytes(data) != layer.Digest {
+		return errors.Errorf("policy layer does not match digest %s", layer.Digest)
+	}
+	return nil
+}
+
+// verifyCachedRemotePolicyImage verifies cached, a policy from the OCI artifact at imageName, in the same way as
+// fetchRemotePolicyFromImage, using the manifest and sigstore signatures recorded in cached.
+func verifyCachedRemotePolicyImage(ctx context.Context, imageName string, cached *cachedRemotePolicy, keyData []byte) error {
+	ref, err := parseRemotePolicyImageName(imageName)
+	if err != nil {
+		return err
+	}
+	layer, err := verifyRemotePolicyImage(ctx, &cachedRemotePolicyImage{ref: ref, cached: cached}, keyData)
+	if err != nil {
+		return err
+	}
+	return verifyRemotePolicyLayer(layer, cached.Policy)
+}
+
+// cachedRemotePolicyImage is a types.UnparsedImage which provides the manifest and sigstore signatures of
+// an OCI artifact recorded in a cachedRemotePolicy.
+type cachedRemotePolicyImage struct {
+	ref    types.ImageReference
+	cached *cachedRemotePolicy
+}
+
+func (i *cachedRemotePolicyImage) Reference() types.ImageReference {
+	return i.ref
+}
+
+func (i *cachedRemotePolicyImage) Manifest(ctx context.Context) ([]byte, string, error) {
+	if i.cached.Manifest == nil {
+		return nil, "", errors.New("the cached policy does not include a manifest")
+	}
+	return i.cached.Manifest, i.cached.ManifestMIMEType, nil
+}
+
+func (i *cachedRemotePolicyImage) Signatures(ctx context.Context) ([][]byte, error) {
+	return nil, nil
+}
+
+// UntrustedSigstoreSignatures implements private.SigstoreSignaturesReader.
+func (i *cachedRemotePolicyImage) UntrustedSigstoreSignatures(ctx context.Context) ([]sigstore.Signature, error) {
+	return i.cached.SigstoreSignatures, nil
+}
+
+// remotePolicyCachePath returns the path used to cache the policy configured by remote, verified using keyData,
+// or "" if caching is not enabled.
+func remotePolicyCachePath(remote *types.RemoteSignaturePolicy, keyData []byte) string {
+	if remote.CacheDir == "" || remote.CacheTTL <= 0 {
+		return ""
+	}
+	// Include the keys, so that changing the trusted keys does not use a policy verified by other keys.
+	h := sha256.New()
+	fmt.Fprintf(h, "%d:%s", len(remote.Source), remote.Source)
+	h.Write(keyData)
+	return filepath.Join(remote.CacheDir, hex.EncodeToString(h.Sum(nil))+".json")
+}
+
+// readCachedRemotePolicy returns a policy cached at path, if it exists and does not expire before now.
+// The returned policy has not been verified.
+func readCachedRemotePolicy(path string, now time.Time) (*cachedRemotePolicy, bool) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		if !os.IsNotExist(err) {
+			logrus.Debugf("Error reading cached policy %s: %v", path, err)
+		}
+		return nil, false
+	}
+	var cached cachedRemotePolicy
+	if err := json.Unmarshal(data, &cached); err != nil {
+		logrus.Debugf("Error parsing cached policy %s: %v", path, err)
+		return nil, false
+	}
+	if !now.Before(cached.Expires) {
+		return nil, false
+	}
+	return &cached, true
+}
+
+// writeCachedRemotePolicy stores cached at path.  Failures are only logged.
+func writeCachedRemotePolicy(path string, cached *cachedRemotePolicy) {
+	data, err := json.Marshal(cached)
+	if err != nil {
+		logrus.Debugf("Error marshaling policy for the cache: %v", err)
+		return
+	}
+	if err := os.MkdirAll(filepath.Dir(path), 0700); err != nil {
+		logrus.Debugf("Error creating policy cache directory: %v", err)
+		return
+	}
+	if err := ioutils.AtomicWriteFile(path, data, 0600); err != nil {
+		logrus.Debugf("Error writing cached policy %s: %v", path, err)
+	}
+}
@@ -0,0 +1,449 @@
+package signature
+
+import (
+	"context"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/sha256"
+	"crypto/x509"
+	"encoding/base64"
+	"encoding/json"
+	"encoding/pem"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	_ "github.com/containers/image/v5/docker" // Register the docker: transport
+	"github.com/containers/image/v5/manifest"
+	"github.com/containers/image/v5/signature/sigstore"
+	"github.com/containers/image/v5/types"
+	digest "github.com/opencontainers/go-digest"
+	imgspecv1 "github.com/opencontainers/image-spec/specs-go/v1"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+const testRemotePolicy = `{"default":[{"type":"insecureAcceptAnything"}]}`
+
+// testRemotePolicyKey returns a new private key for signing remote policies, and the PEM encoding of its public key.
+func testRemotePolicyKey(t *testing.T) (*ecdsa.PrivateKey, []byte) {
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	require.NoError(t, err)
+	der, err := x509.MarshalPKIXPublicKey(&key.PublicKey)
+	require.NoError(t, err)
+	return key, pem.EncodeToMemory(&pem.Block{Type: "PUBLIC KEY", Bytes: der})
+}
+
+// testRemotePolicySignature returns a base64-encoded signature of policy by key, as created by cosign sign-blob.
+func testRemotePolicySignature(t *testing.T, key *ecdsa.PrivateKey, policy string) []byte {
+	d := sha256.Sum256([]byte(policy))
+	sig, err := ecdsa.SignASN1(rand.Reader, key, d[:])
+	require.NoError(t, err)
+	return []byte(base64.StdEncoding.EncodeToString(sig) + "\n")
+}
+
+// testFileServer serves files from a map, and counts requests.
+type testFileServer struct {
+	mutex    sync.Mutex
+	files    map[string][]byte // Path -> contents
+	requests int
+}
+
+func (s *testFileServer) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	s.requests++
+	contents, ok := s.files[r.URL.Path]
+	if !ok {
+		w.WriteHeader(http.StatusNotFound)
+		return
+	}
+	_, _ = w.Write(contents)
+}
+
+func TestNewPolicyFromRemoteSourceURL(t *testing.T) {
+	ctx := context.Background()
+	key, keyPEM := testRemotePolicyKey(t)
+	_, otherKeyPEM := testRemotePolicyKey(t)
+	files := &testFileServer{files: map[string][]byte{
+		"/policy.json":                 []byte(testRemotePolicy),
+		"/policy.json.sig":             testRemotePolicySignature(t, key, testRemotePolicy),
+		"/unsigned.json":               []byte(testRemotePolicy),
+		"/invalid-signature.json":      []byte(testRemotePolicy),
+		"/invalid-signature.json.sig":  []byte("this is not base64"),
+		"/modified.json":               []byte(`{"default":[{"type":"reject"}]}`),
+		"/modified.json.sig":           testRemotePolicySignature(t, key, testRemotePolicy),
+		"/invalid-policy.json":         []byte("this is not a policy"),
+		"/invalid-policy.json.sig":     testRemotePolicySignature(t, key, "this is not a policy"),
+		"/nested/path/policy.json":     []byte(testRemotePolicy),
+		"/nested/path/policy.json.sig": testRemotePolicySignature(t, key, testRemotePolicy),
+	}}
+	server := httptest.NewTLSServer(files)
+	defer server.Close()
+	now := time.Now()
+
+	for _, c := range []struct {
+		path string
+		keys []byte
+	}{
+		{"/policy.json", keyPEM},
+		{"/policy.json?query=1", keyPEM},
+		{"/nested/path/policy.json", keyPEM},
+		{"/policy.json", append(append([]byte{}, otherKeyPEM...), keyPEM...)},
+	} {
+		policy, err := newPolicyFromRemoteSource(ctx, nil, &types.RemoteSignaturePolicy{
+			Source:        server.URL + c.path,
+			PublicKeyData: c.keys,
+		}, server.Client(), now)
+		require.NoError(t, err, c.path)
+		assert.Equal(t, &Policy{Default: PolicyRequirements{NewPRInsecureAcceptAnything()}, Transports: map[string]PolicyTransportScopes{}}, policy, c.path)
+	}
+
+	// Keys from a file
+	keyFile := filepath.Join(t.TempDir(), "key.pub")
+	require.NoError(t, os.WriteFile(keyFile, keyPEM, 0600))
+	_, err := newPolicyFromRemoteSource(ctx, nil, &types.RemoteSignaturePolicy{
+		Source:        server.URL + "/policy.json",
+		PublicKeyPath: keyFile,
+	}, server.Client(), now)
+	assert.NoError(t, err)
+
+	// Failures
+	for _, c := range []struct {
+		remote *types.RemoteSignaturePolicy
+		errMsg string
+	}{
+		{&types.RemoteSignaturePolicy{PublicKeyData: keyPEM}, "remote policy source not specified"},
+		{&types.RemoteSignaturePolicy{Source: server.URL + "/policy.json"}, "exactly one of"},
+		{&types.RemoteSignaturePolicy{Source: server.URL + "/policy.json", PublicKeyPath: keyFile, PublicKeyData: keyPEM}, "exactly one of"},
+		{&types.RemoteSignaturePolicy{Source: server.URL + "/policy.json", PublicKeyPath: "/this/does/not/exist"}, "reading remote policy public keys"},
+		{&types.RemoteSignaturePolicy{Source: server.URL + "/policy.json", PublicKeyData: []byte("not a key")}, "parsing remote policy public keys"},
+		{&types.RemoteSignaturePolicy{Source: "http://" + strings.TrimPrefix(server.URL, "https://") + "/policy.json", PublicKeyData: keyPEM}, "does not use HTTPS"},
+		{&types.RemoteSignaturePolicy{Source: server.URL + "/policy.json", PublicKeyData: otherKeyPEM}, "not signed by any of the trusted keys"},
+		{&types.RemoteSignaturePolicy{Source: server.URL + "/missing.json", PublicKeyData: keyPEM}, "status 404"},
+		{&types.RemoteSignaturePolicy{Source: server.URL + "/unsigned.json", PublicKeyData: keyPEM}, "reading the policy signature"},
+		{&types.RemoteSignaturePolicy{Source: server.URL + "/invalid-signature.json", PublicKeyData: keyPEM}, "decoding the policy signature"},
+		{&types.RemoteSignaturePolicy{Source: server.URL + "/modified.json", PublicKeyData: keyPEM}, "not signed by any of the trusted keys"},
+		{&types.RemoteSignaturePolicy{Source: server.URL + "/invalid-policy.json", PublicKeyData: keyPEM}, "invalid policy in"},
+	} {
+		_, err := newPolicyFromRemoteSource(ctx, nil, c.remote, server.Client(), now)
+		assert.ErrorContains(t, err, c.errMsg, c.remote.Source)
+	}
+	// The server’s certificate is verified
+	_, err = newPolicyFromRemoteSource(ctx, nil, &types.RemoteSignaturePolicy{
+		Source:        server.URL + "/policy.json",
+		PublicKeyData: keyPEM,
+	}, http.DefaultClient, now)
+	assert.Error(t, err)
+	// … using the CA certificates configured in SystemContext
+	certDir := t.TempDir()
+	require.NoError(t, os.WriteFile(filepath.Join(certDir, "ca.crt"),
+		pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: server.Certificate().Raw}), 0600))
+	for _, sys := range []*types.SystemContext{
+		{DockerCertPath: certDir},
+		{DockerPerHostCertDirPath: filepath.Dir(certDir)},
+	} {
+		if sys.DockerPerHostCertDirPath != "" {
+			hostDir := filepath.Join(sys.DockerPerHostCertDirPath, strings.TrimPrefix(server.URL, "https://"))
+			require.NoError(t, os.MkdirAll(hostDir, 0700))
+			require.NoError(t, os.Link(filepath.Join(certDir, "ca.crt"), filepath.Join(hostDir, "ca.crt")))
+		}
+		policy, err := NewPolicyFromRemoteSource(ctx, sys, &types.RemoteSignaturePolicy{
+			Source:        server.URL + "/policy.json",
+			PublicKeyData: keyPEM,
+		})
+		require.NoError(t, err)
+		assert.Equal(t, &Policy{Default: PolicyRequirements{NewPRInsecureAcceptAnything()}, Transports: map[string]PolicyTransportScopes{}}, policy)
+	}
+	_, err = NewPolicyFromRemoteSource(ctx, &types.SystemContext{DockerCertPath: t.TempDir()}, &types.RemoteSignaturePolicy{
+		Source:        server.URL + "/policy.json",
+		PublicKeyData: keyPEM,
+	})
+	assert.Error(t, err)
+}
+
+func TestNewPolicyFromRemoteSourceCache(t *testing.T) {
+	ctx := context.Background()
+	key, keyPEM := testRemotePolicyKey(t)
+	_, otherKeyPEM := testRemotePolicyKey(t)
+	files := &testFileServer{files: map[string][]byte{
+		"/policy.json":     []byte(testRemotePolicy),
+		"/policy.json.sig": testRemotePolicySignature(t, key, testRemotePolicy),
+	}}
+	server := httptest.NewTLSServer(files)
+	defer server.Close()
+	cacheDir := filepath.Join(t.TempDir(), "cache")
+	remote := &types.RemoteSignaturePolicy{
+		Source:        server.URL + "/policy.json",
+		PublicKeyData: keyPEM,
+		CacheDir:      cacheDir,
+		CacheTTL:      time.Hour,
+	}
+	now := time.Now()
+	expected := &Policy{Default: PolicyRequirements{NewPRInsecureAcceptAnything()}, Transports: map[string]PolicyTransportScopes{}}
+
+	policy, err := newPolicyFromRemoteSource(ctx, nil, remote, server.Client(), now)
+	require.NoError(t, err)
+	assert.Equal(t, expected, policy)
+	assert.Equal(t, 2, files.requests)
+	entries, err := os.ReadDir(cacheDir)
+	require.NoError(t, err)
+	require.Len(t, entries, 1)
+	cacheFile := filepath.Join(cacheDir, entries[0].Name())
+
+	// The cached policy is used until it expires
+	policy, err = newPolicyFromRemoteSource(ctx, nil, remote, server.Client(), now.Add(59*time.Minute))
+	require.NoError(t, err)
+	assert.Equal(t, expected, policy)
+	assert.Equal(t, 2, files.requests)
+	policy, err = newPolicyFromRemoteSource(ctx, nil, remote, server.Client(), now.Add(61*time.Minute))
+	require.NoError(t, err)
+	assert.Equal(t, expected, policy)
+	assert.Equal(t, 4, files.requests)
+
+	// Different keys, or disabled caching, don’t use the cache.
+	otherKeys := *remote
+	otherKeys.PublicKeyData = otherKeyPEM
+	_, err = newPolicyFromRemoteSource(ctx, nil, &otherKeys, server.Client(), now)
+	assert.Error(t, err)
+	assert.Equal(t, 6, files.requests)
+	for _, noCache := range []types.RemoteSignaturePolicy{
+		{Source: remote.Source, PublicKeyData: keyPEM, CacheDir: cacheDir},
+		{Source: remote.Source, PublicKeyData: keyPEM, CacheTTL: time.Hour},
+	} {
+		requests := files.requests
+		_, err = newPolicyFromRemoteSource(ctx, nil, &noCache, server.Client(), now)
+		require.NoError(t, err)
+		assert.Equal(t, requests+2, files.requests)
+	}
+
+	// Once the cached policy expires, it is not used if the source can’t be reached.
+	server.Close()
+	_, err = newPolicyFromRemoteSource(ctx, nil, remote, server.Client(), now.Add(time.Hour))
+	require.NoError(t, err)
+	_, err = newPolicyFromRemoteSource(ctx, nil, remote, server.Client(), now.Add(3*time.Hour))
+	assert.Error(t, err)
+
+	// Invalid cache contents are ignored
+	for _, contents := range []string{
+		"this is not JSON",
+		`{"policy":"` + base64.StdEncoding.EncodeToString([]byte("this is not a policy")) + `","expires":"` + now.Add(time.Hour).Format(time.RFC3339) + `"}`,
+	} {
+		require.NoError(t, os.WriteFile(cacheFile, []byte(contents), 0600))
+		_, err = newPolicyFromRemoteSource(ctx, nil, remote, server.Client(), now)
+		assert.Error(t, err)
+	}
+}
+
+func TestNewPolicyFromRemoteSourceCacheVerification(t *testing.T) {
+	ctx := context.Background()
+	key, keyPEM := testRemotePolicyKey(t)
+	otherKey, _ := testRemotePolicyKey(t)
+	files := &testFileServer{files: map[string][]byte{
+		"/policy.json":     []byte(testRemotePolicy),
+		"/policy.json.sig": testRemotePolicySignature(t, key, testRemotePolicy),
+	}}
+	server := httptest.NewTLSServer(files)
+	defer server.Close()
+	cacheDir := t.TempDir()
+	remote := &types.RemoteSignaturePolicy{
+		Source:        server.URL + "/policy.json",
+		PublicKeyData: keyPEM,
+		CacheDir:      cacheDir,
+		CacheTTL:      time.Hour,
+	}
+	now := time.Now()
+	_, err := newPolicyFromRemoteSource(ctx, nil, remote, server.Client(), now)
+	require.NoError(t, err)
+	entries, err := os.ReadDir(cacheDir)
+	require.NoError(t, err)
+	require.Len(t, entries, 1)
+	cacheFile := filepath.Join(cacheDir, entries[0].Name())
+	validCache, err := os.ReadFile(cacheFile)
+	require.NoError(t, err)
+	var cached cachedRemotePolicy
+	require.NoError(t, json.Unmarshal(validCache, &cached))
+	server.Close()
+
+	// The cached policy is verified again when it is used
+	policy, err := newPolicyFromRemoteSource(ctx, nil, remote, server.Client(), now)
+	require.NoError(t, err)
+	assert.Equal(t, &Policy{Default: PolicyRequirements{NewPRInsecureAcceptAnything()}, Transports: map[string]PolicyTransportScopes{}}, policy)
+
+	// A modified cache is not used
+	const modifiedPolicy = `{"default":[{"type":"reject"}]}`
+	otherSig, err := base64.StdEncoding.DecodeString(strings.TrimSpace(string(testRemotePolicySignature(t, otherKey, modifiedPolicy))))
+	require.NoError(t, err)
+	for _, modified := range []cachedRemotePolicy{
+		{Policy: []byte(modifiedPolicy), Expires: cached.Expires, Signature: cached.Signature}, // Policy replaced
+		{Policy: []byte(modifiedPolicy), Expires: cached.Expires},                              // Signature missing
+		{Policy: []byte(modifiedPolicy), Expires: cached.Expires, Signature: otherSig},         // Signed by an untrusted key
+	} {
+		data, err := json.Marshal(modified)
+		require.NoError(t, err)
+		require.NoError(t, os.WriteFile(cacheFile, data, 0600))
+		_, err = newPolicyFromRemoteSource(ctx, nil, remote, server.Client(), now)
+		assert.Error(t, err) // The server is not reachable, so no policy is found
+	}
+}
+
+// testPolicyRegistry is a registry serving a single repository, "policies".
+type testPolicyRegistry struct {
+	manifests map[string][]byte // Tag or digest -> manifest
+	blobs     map[digest.Digest][]byte
+}
+
+func (r *testPolicyRegistry) ServeHTTP(w http.ResponseWriter, req *http.Request) {
+	switch {
+	case req.URL.Path == "/v2/":
+		w.WriteHeader(http.StatusOK)
+	case strings.HasPrefix(req.URL.Path, "/v2/policies/manifests/"):
+		m, ok := r.manifests[strings.TrimPrefix(req.URL.Path, "/v2/policies/manifests/")]
+		if !ok {
+			w.WriteHeader(http.StatusNotFound)
+			return
+		}
+		w.Header().Set("Content-Type", manifest.GuessMIMEType(m))
+		_, _ = w.Write(m)
+	case strings.HasPrefix(req.URL.Path, "/v2/policies/blobs/"):
+		blob, ok := r.blobs[digest.Digest(strings.TrimPrefix(req.URL.Path, "/v2/policies/blobs/"))]
+		if !ok {
+			w.WriteHeader(http.StatusNotFound)
+			return
+		}
+		_, _ = w.Write(blob)
+	default:
+		w.WriteHeader(http.StatusNotFound)
+	}
+}
+
+// addBlob stores blob in r, and returns its descriptor with mediaType.
+func (r *testPolicyRegistry) addBlob(mediaType string, blob []byte) imgspecv1.Descriptor {
+	d := digest.FromBytes(blob)
+	r.blobs[d] = blob
+	return imgspecv1.Descriptor{MediaType: mediaType, Digest: d, Size: int64(len(blob))}
+}
+
+// addManifest stores an OCI manifest with layers in r as tag, and returns the manifest.
+func (r *testPolicyRegistry) addManifest(t *testing.T, tag string, layers ...imgspecv1.Descriptor) []byte {
+	m, err := json.Marshal(map[string]interface{}{
+		"schemaVersion": 2,
+		"mediaType":     imgspecv1.MediaTypeImageManifest,
+		"config":        r.addBlob("application/vnd.oci.empty.v1+json", []byte("{}")),
+		"layers":        layers,
+		"annotations":   map[string]string{"tag": tag}, // Make manifests for different tags distinct
+	})
+	require.NoError(t, err)
+	r.manifests[tag] = m
+	r.manifests[digest.FromBytes(m).String()] = m
+	return m
+}
+
+func TestNewPolicyFromRemoteSourceImage(t *testing.T) {
+	ctx := context.Background()
+	key, keyPEM := testRemotePolicyKey(t)
+	_, otherKeyPEM := testRemotePolicyKey(t)
+	der, err := x509.MarshalPKCS8PrivateKey(key)
+	require.NoError(t, err)
+	signer, err := sigstore.NewSignerFromPrivateKey(pem.EncodeToMemory(&pem.Block{Type: "PRIVATE KEY", Bytes: der}), nil)
+	require.NoError(t, err)
+
+	r := &testPolicyRegistry{manifests: map[string][]byte{}, blobs: map[digest.Digest][]byte{}}
+	server := httptest.NewServer(r)
+	defer server.Close()
+	host := strings.TrimPrefix(server.URL, "http://")
+	sys := &types.SystemContext{DockerInsecureSkipTLSVerify: types.OptionalBoolTrue}
+	// sign adds a sigstore signature of m, claiming identity.
+	sign := func(m []byte, identity string) {
+		sig, err := signer.SignImage(ctx, m, identity)
+		require.NoError(t, err)
+		layer := r.addBlob(sigstore.SignatureMIMEType, sig.Payload)
+		layer.Annotations = sig.Annotations
+		d := digest.FromBytes(m)
+		r.addManifest(t, d.Algorithm().String()+"-"+d.Encoded()+".sig", layer)
+	}
+
+	policyLayer := r.addBlob("application/vnd.containers.policy.v1+json", []byte(testRemotePolicy))
+	sign(r.addManifest(t, "valid", policyLayer), host+"/policies:latest")
+	r.addManifest(t, "unsigned", policyLayer)
+	sign(r.addManifest(t, "other-identity", policyLayer), host+"/other:latest")
+	sign(r.addManifest(t, "two-layers", policyLayer, policyLayer), host+"/policies")
+	sign(r.addManifest(t, "invalid-policy", r.addBlob("application/json", []byte("this is not a policy"))), host+"/policies")
+	sign(r.addManifest(t, "missing-blob", imgspecv1.Descriptor{MediaType: "application/json", Digest: digest.FromString("missing"), Size: 7}), host+"/policies")
+	modifiedLayer := policyLayer
+	modifiedLayer.Digest = digest.FromString("modified")
+	r.blobs[modifiedLayer.Digest] = []byte(testRemotePolicy)
+	sign(r.addManifest(t, "modified-blob", modifiedLayer), host+"/policies")
+
+	policy, err := NewPolicyFromRemoteSource(ctx, sys, &types.RemoteSignaturePolicy{
+		Source:        "docker://" + host + "/policies:valid",
+		PublicKeyData: keyPEM,
+	})
+	require.NoError(t, err)
+	assert.Equal(t, &Policy{Default: PolicyRequirements{NewPRInsecureAcceptAnything()}, Transports: map[string]PolicyTransportScopes{}}, policy)
+
+	// A cached policy is verified using the cached manifest and signatures
+	cacheDir := t.TempDir()
+	cachedRemote := &types.RemoteSignaturePolicy{
+		Source:        "docker://" + host + "/policies:valid",
+		PublicKeyData: keyPEM,
+		CacheDir:      cacheDir,
+		CacheTTL:      time.Hour,
+	}
+	_, err = NewPolicyFromRemoteSource(ctx, sys, cachedRemote)
+	require.NoError(t, err)
+	entries, err := os.ReadDir(cacheDir)
+	require.NoError(t, err)
+	require.Len(t, entries, 1)
+	cacheFile := filepath.Join(cacheDir, entries[0].Name())
+	validCache, err := os.ReadFile(cacheFile)
+	require.NoError(t, err)
+	var cached cachedRemotePolicy
+	require.NoError(t, json.Unmarshal(validCache, &cached))
+	validManifest := r.manifests["valid"]
+	delete(r.manifests, "valid") // Make sure the registry is not used
+	policy, err = NewPolicyFromRemoteSource(ctx, sys, cachedRemote)
+	require.NoError(t, err)
+	assert.Equal(t, &Policy{Default: PolicyRequirements{NewPRInsecureAcceptAnything()}, Transports: map[string]PolicyTransportScopes{}}, policy)
+	for _, modify := range []func(c *cachedRemotePolicy){
+		func(c *cachedRemotePolicy) { c.Policy = []byte(`{"default":[{"type":"reject"}]}`) },
+		func(c *cachedRemotePolicy) { c.Manifest = r.manifests["unsigned"] },
+		func(c *cachedRemotePolicy) { c.Manifest = nil },
+		func(c *cachedRemotePolicy) { c.SigstoreSignatures = nil },
+	} {
+		modified := cached
+		modify(&modified)
+		data, err := json.Marshal(modified)
+		require.NoError(t, err)
+		require.NoError(t, os.WriteFile(cacheFile, data, 0600))
+		_, err = NewPolicyFromRemoteSource(ctx, sys, cachedRemote)
+		assert.Error(t, err)
+	}
+	r.manifests["valid"] = validManifest
+
+	for _, c := range []struct {
+		source string
+		keys   []byte
+	}{
+		{"docker://" + host + "/policies:valid", otherKeyPEM},
+		{"docker://" + host + "/policies:unsigned", keyPEM},
+		{"docker://" + host + "/policies:other-identity", keyPEM},
+		{"docker://" + host + "/policies:two-layers", keyPEM},
+		{"docker://" + host + "/policies:invalid-policy", keyPEM},
+		{"docker://" + host + "/policies:missing-blob", keyPEM},
+		{"docker://" + host + "/policies:modified-blob", keyPEM},
+		{"docker://" + host + "/policies:missing", keyPEM},
+		{"this is not an image name", keyPEM},
+		{"unknown-transport:" + host + "/policies:valid", keyPEM},
+		{"docker:invalid reference", keyPEM},
+	} {
+		_, err := NewPolicyFromRemoteSource(ctx, sys, &types.RemoteSignaturePolicy{Source: c.source, PublicKeyData: c.keys})
+		assert.Error(t, err, c.source)
+	}
+}
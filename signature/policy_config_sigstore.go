@@ -0,0 +1,431 @@
+package signature
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/url"
+	"regexp"
+
+	"github.com/pkg/errors"
+)
+
+// PRSigstoreSignedOption is a way to pass values to NewPRSigstoreSigned
+type PRSigstoreSignedOption func(*prSigstoreSigned) error
+
+// PRSigstoreSignedWithKeyPath specifies a value for the "keyPath" field when calling NewPRSigstoreSigned.
+func PRSigstoreSignedWithKeyPath(keyPath string) PRSigstoreSignedOption {
+	return func(pr *prSigstoreSigned) error {
+		if pr.KeyPath != "" {
+			return errors.New(`"keyPath" already specified`)
+		}
+		pr.KeyPath = keyPath
+		return nil
+	}
+}
+
+// PRSigstoreSignedWithKeyData specifies a value for the "keyData" field when calling NewPRSigstoreSigned.
+func PRSigstoreSignedWithKeyData(keyData []byte) PRSigstoreSignedOption {
+	return func(pr *prSigstoreSigned) error {
+		if pr.KeyData != nil {
+			return errors.New(`"keyData" already specified`)
+		}
+		pr.KeyData = keyData
+		return nil
+	}
+}
+
+// PRSigstoreSignedWithFulcio specifies a value for the "fulcio" field when calling NewPRSigstoreSigned.
+func PRSigstoreSignedWithFulcio(fulcio PRSigstoreSignedFulcio) PRSigstoreSignedOption {
+	return func(pr *prSigstoreSigned) error {
+		if pr.Fulcio != nil {
+			return errors.New(`"fulcio" already specified`)
+		}
+		pr.Fulcio = fulcio
+		return nil
+	}
+}
+
+// PRSigstoreSignedWithRekorPublicKeyPath specifies a value for the "rekorPublicKeyPath" field when calling NewPRSigstoreSigned.
+func PRSigstoreSignedWithRekorPublicKeyPath(rekorPublicKeyPath string) PRSigstoreSignedOption {
+	return func(pr *prSigstoreSigned) error {
+		if pr.RekorPublicKeyPath != "" {
+			return errors.New(`"rekorPublicKeyPath" already specified`)
+		}
+		pr.RekorPublicKeyPath = rekorPublicKeyPath
+		return nil
+	}
+}
+
+// PRSigstoreSignedWithRekorPublicKeyData specifies a value for the "rekorPublicKeyData" field when calling NewPRSigstoreSigned.
+func PRSigstoreSignedWithRekorPublicKeyData(rekorPublicKeyData []byte) PRSigstoreSignedOption {
+	return func(pr *prSigstoreSigned) error {
+		if pr.RekorPublicKeyData != nil {
+			return errors.New(`"rekorPublicKeyData" already specified`)
+		}
+		pr.RekorPublicKeyData = rekorPublicKeyData
+		return nil
+	}
+}
+
+// PRSigstoreSignedWithRekorURL specifies a value for the "rekorURL" field when calling NewPRSigstoreSigned.
+func PRSigstoreSignedWithRekorURL(rekorURL string) PRSigstoreSignedOption {
+	return func(pr *prSigstoreSigned) error {
+		if pr.RekorURL != "" {
+			return errors.New(`"rekorURL" already specified`)
+		}
+		pr.RekorURL = rekorURL
+		return nil
+	}
+}
+
+// PRSigstoreSignedWithTimestampAuthorityCAPath specifies a value for the "timestampAuthorityCAPath" field when calling NewPRSigstoreSigned.
+func PRSigstoreSignedWithTimestampAuthorityCAPath(timestampAuthorityCAPath string) PRSigstoreSignedOption {
+	return func(pr *prSigstoreSigned) error {
+		if pr.TimestampAuthorityCAPath != "" {
+			return errors.New(`"timestampAuthorityCAPath" already specified`)
+		}
+		pr.TimestampAuthorityCAPath = timestampAuthorityCAPath
+		return nil
+	}
+}
+
+// PRSigstoreSignedWithTimestampAuthorityCAData specifies a value for the "timestampAuthorityCAData" field when calling NewPRSigstoreSigned.
+func PRSigstoreSignedWithTimestampAuthorityCAData(timestampAuthorityCAData []byte) PRSigstoreSignedOption {
+	return func(pr *prSigstoreSigned) error {
+		if pr.TimestampAuthorityCAData != nil {
+			return errors.New(`"timestampAuthorityCAData" already specified`)
+		}
+		pr.TimestampAuthorityCAData = timestampAuthorityCAData
+		return nil
+	}
+}
+
+// PRSigstoreSignedWithSignedIdentity specifies a value for the "signedIdentity" field when calling NewPRSigstoreSigned.
+func PRSigstoreSignedWithSignedIdentity(signedIdentity PolicyReferenceMatch) PRSigstoreSignedOption {
+	return func(pr *prSigstoreSigned) error {
+		if pr.SignedIdentity != nil {
+			return errors.New(`"signedIdentity" already specified`)
+		}
+		pr.SignedIdentity = signedIdentity
+		return nil
+	}
+}
+
+// newPRSigstoreSigned is NewPRSigstoreSigned, except it returns the private type.
+func newPRSigstoreSigned(options ...PRSigstoreSignedOption) (*prSigstoreSigned, error) {
+	res := prSigstoreSigned{
+		prCommon: prCommon{Type: prTypeSigstoreSigned},
+	}
+	for _, o := range options {
+		if err := o(&res); err != nil {
+			return nil, err
+		}
+	}
+	keySources := 0
+	if res.KeyPath != "" {
+		keySources++
+	}
+	if res.KeyData != nil {
+		keySources++
+	}
+	if res.Fulcio != nil {
+		keySources++
+	}
+	if keySources != 1 {
+		return nil, InvalidPolicyFormatError("exactly one of keyPath, keyData and fulcio must be specified")
+	}
+	if res.RekorPublicKeyPath != "" && res.RekorPublicKeyData != nil {
+		return nil, InvalidPolicyFormatError("rekorPublicKeyPath and rekorPublicKeyData cannot be used simultaneously")
+	}
+	if res.TimestampAuthorityCAPath != "" && res.TimestampAuthorityCAData != nil {
+		return nil, InvalidPolicyFormatError("timestampAuthorityCAPath and timestampAuthorityCAData cannot be used simultaneously")
+	}
+	if res.Fulcio != nil && res.RekorPublicKeyPath == "" && res.RekorPublicKeyData == nil &&
+		res.TimestampAuthorityCAPath == "" && res.TimestampAuthorityCAData == nil {
+		// Fulcio certificates are only valid for a few minutes; the Rekor entry or the timestamp provides a trusted time of signing.
+		return nil, InvalidPolicyFormatError("fulcio requires rekorPublicKeyPath, rekorPublicKeyData, timestampAuthorityCAPath or timestampAuthorityCAData")
+	}
+	if res.RekorURL != "" {
+		if res.RekorPublicKeyPath == "" && res.RekorPublicKeyData == nil {
+			return nil, InvalidPolicyFormatError("rekorURL requires rekorPublicKeyPath or rekorPublicKeyData")
+		}
+		u, err := url.Parse(res.RekorURL)
+		if err != nil || (u.Scheme != "https" && u.Scheme != "http") {
+			return nil, InvalidPolicyFormatError(fmt.Sprintf("Invalid rekorURL \"%s\"", res.RekorURL))
+		}
+	}
+	if res.SignedIdentity == nil {
+		return nil, InvalidPolicyFormatError("signedIdentity not specified")
+	}
+	return &res, nil
+}
+
+// NewPRSigstoreSigned returns a new "sigstoreSigned" PolicyRequirement based on options.
+func NewPRSigstoreSigned(options ...PRSigstoreSignedOption) (PolicyRequirement, error) {
+	return newPRSigstoreSigned(options...)
+}
+
+// Compile-time check that prSigstoreSigned implements json.Unmarshaler.
+var _ json.Unmarshaler = (*prSigstoreSigned)(nil)
+
+// UnmarshalJSON implements the json.Unmarshaler interface.
+func (pr *prSigstoreSigned) UnmarshalJSON(data []byte) error {
+	*pr = prSigstoreSigned{}
+	var tmp prSigstoreSigned
+	var gotKeyPath, gotKeyData, gotFulcio, gotRekorPublicKeyPath, gotRekorPublicKeyData, gotRekorURL = false, false, false, false, false, false
+	var gotTimestampAuthorityCAPath, gotTimestampAuthorityCAData = false, false
+	var fulcio prSigstoreSignedFulcio
+	var signedIdentity json.RawMessage
+	if err := paranoidUnmarshalJSONObject(data, func(key string) interface{} {
+		switch key {
+		case "type":
+			return &tmp.Type
+		case "keyPath":
+			gotKeyPath = true
+			return &tmp.KeyPath
+		case "keyData":
+			gotKeyData = true
+			return &tmp.KeyData
+		case "fulcio":
+			gotFulcio = true
+			return &fulcio
+		case "rekorPublicKeyPath":
+			gotRekorPublicKeyPath = true
+			return &tmp.RekorPublicKeyPath
+		case "rekorPublicKeyData":
+			gotRekorPublicKeyData = true
+			return &tmp.RekorPublicKeyData
+		case "rekorURL":
+			gotRekorURL = true
+			return &tmp.RekorURL
+		case "timestampAuthorityCAPath":
+			gotTimestampAuthorityCAPath = true
+			return &tmp.TimestampAuthorityCAPath
+		case "timestampAuthorityCAData":
+			gotTimestampAuthorityCAData = true
+			return &tmp.TimestampAuthorityCAData
+		case "signedIdentity":
+			return &signedIdentity
+		default:
+			return nil
+		}
+	}); err != nil {
+		return err
+	}
+
+	if tmp.Type != prTypeSigstoreSigned {
+		return InvalidPolicyFormatError(fmt.Sprintf("Unexpected policy requirement type \"%s\"", tmp.Type))
+	}
+	if signedIdentity == nil {
+		tmp.SignedIdentity = NewPRMMatchRepoDigestOrExact()
+	} else {
+		si, err := newPolicyReferenceMatchFromJSON(signedIdentity)
+		if err != nil {
+			return err
+		}
+		tmp.SignedIdentity = si
+	}
+
+	var opts []PRSigstoreSignedOption
+	if gotKeyPath {
+		opts = append(opts, PRSigstoreSignedWithKeyPath(tmp.KeyPath))
+	}
+	if gotKeyData {
+		opts = append(opts, PRSigstoreSignedWithKeyData(tmp.KeyData))
+	}
+	if gotFulcio {
+		opts = append(opts, PRSigstoreSignedWithFulcio(&fulcio))
+	}
+	if gotRekorPublicKeyPath {
+		opts = append(opts, PRSigstoreSignedWithRekorPublicKeyPath(tmp.RekorPublicKeyPath))
+	}
+	if gotRekorPublicKeyData {
+		opts = append(opts, PRSigstoreSignedWithRekorPublicKeyData(tmp.RekorPublicKeyData))
+	}
+	if gotRekorURL {
+		opts = append(opts, PRSigstoreSignedWithRekorURL(tmp.RekorURL))
+	}
+	if gotTimestampAuthorityCAPath {
+		opts = append(opts, PRSigstoreSignedWithTimestampAuthorityCAPath(tmp.TimestampAuthorityCAPath))
+	}
+	if gotTimestampAuthorityCAData {
+		opts = append(opts, PRSigstoreSignedWithTimestampAuthorityCAData(tmp.TimestampAuthorityCAData))
+	}
+	opts = append(opts, PRSigstoreSignedWithSignedIdentity(tmp.SignedIdentity))
+
+	res, err := newPRSigstoreSigned(opts...)
+	if err != nil {
+		return err
+	}
+	*pr = *res
+	return nil
+}
+
+// PRSigstoreSignedFulcioOption is a way to pass values to NewPRSigstoreSignedFulcio
+type PRSigstoreSignedFulcioOption func(*prSigstoreSignedFulcio) error
+
+// prSigstoreSignedFulcioStringOption returns a PRSigstoreSignedFulcioOption which sets the string field returned by field
+// (named name in JSON) to value.
+func prSigstoreSignedFulcioStringOption(name string, field func(*prSigstoreSignedFulcio) *string, value string) PRSigstoreSignedFulcioOption {
+	return func(f *prSigstoreSignedFulcio) error {
+		dest := field(f)
+		if *dest != "" {
+			return errors.Errorf(`"%s" already specified`, name)
+		}
+		*dest = value
+		return nil
+	}
+}
+
+// PRSigstoreSignedFulcioWithCAPath specifies a value for the "caPath" field when calling NewPRSigstoreSignedFulcio.
+func PRSigstoreSignedFulcioWithCAPath(caPath string) PRSigstoreSignedFulcioOption {
+	return prSigstoreSignedFulcioStringOption("caPath", func(f *prSigstoreSignedFulcio) *string { return &f.CAPath }, caPath)
+}
+
+// PRSigstoreSignedFulcioWithCAData specifies a value for the "caData" field when calling NewPRSigstoreSignedFulcio.
+func PRSigstoreSignedFulcioWithCAData(caData []byte) PRSigstoreSignedFulcioOption {
+	return func(f *prSigstoreSignedFulcio) error {
+		if f.CAData != nil {
+			return errors.New(`"caData" already specified`)
+		}
+		f.CAData = caData
+		return nil
+	}
+}
+
+// PRSigstoreSignedFulcioWithOIDCIssuer specifies a value for the "oidcIssuer" field when calling NewPRSigstoreSignedFulcio.
+func PRSigstoreSignedFulcioWithOIDCIssuer(oidcIssuer string) PRSigstoreSignedFulcioOption {
+	return prSigstoreSignedFulcioStringOption("oidcIssuer", func(f *prSigstoreSignedFulcio) *string { return &f.OIDCIssuer }, oidcIssuer)
+}
+
+// PRSigstoreSignedFulcioWithOIDCIssuerRegexp specifies a value for the "oidcIssuerRegexp" field when calling NewPRSigstoreSignedFulcio.
+func PRSigstoreSignedFulcioWithOIDCIssuerRegexp(oidcIssuerRegexp string) PRSigstoreSignedFulcioOption {
+	return prSigstoreSignedFulcioStringOption("oidcIssuerRegexp", func(f *prSigstoreSignedFulcio) *string { return &f.OIDCIssuerRegexp }, oidcIssuerRegexp)
+}
+
+// PRSigstoreSignedFulcioWithSubject specifies a value for the "subject" field when calling NewPRSigstoreSignedFulcio.
+func PRSigstoreSignedFulcioWithSubject(subject string) PRSigstoreSignedFulcioOption {
+	return prSigstoreSignedFulcioStringOption("subject", func(f *prSigstoreSignedFulcio) *string { return &f.Subject }, subject)
+}
+
+// PRSigstoreSignedFulcioWithSubjectRegexp specifies a value for the "subjectRegexp" field when calling NewPRSigstoreSignedFulcio.
+func PRSigstoreSignedFulcioWithSubjectRegexp(subjectRegexp string) PRSigstoreSignedFulcioOption {
+	return prSigstoreSignedFulcioStringOption("subjectRegexp", func(f *prSigstoreSignedFulcio) *string { return &f.SubjectRegexp }, subjectRegexp)
+}
+
+// PRSigstoreSignedFulcioWithGitHubWorkflowTrigger specifies a value for the "githubWorkflowTrigger" field when calling NewPRSigstoreSignedFulcio.
+func PRSigstoreSignedFulcioWithGitHubWorkflowTrigger(trigger string) PRSigstoreSignedFulcioOption {
+	return prSigstoreSignedFulcioStringOption("githubWorkflowTrigger", func(f *prSigstoreSignedFulcio) *string { return &f.GitHubWorkflowTrigger }, trigger)
+}
+
+// PRSigstoreSignedFulcioWithGitHubWorkflowSHA specifies a value for the "githubWorkflowSHA" field when calling NewPRSigstoreSignedFulcio.
+func PRSigstoreSignedFulcioWithGitHubWorkflowSHA(sha string) PRSigstoreSignedFulcioOption {
+	return prSigstoreSignedFulcioStringOption("githubWorkflowSHA", func(f *prSigstoreSignedFulcio) *string { return &f.GitHubWorkflowSHA }, sha)
+}
+
+// PRSigstoreSignedFulcioWithGitHubWorkflowName specifies a value for the "githubWorkflowName" field when calling NewPRSigstoreSignedFulcio.
+func PRSigstoreSignedFulcioWithGitHubWorkflowName(name string) PRSigstoreSignedFulcioOption {
+	return prSigstoreSignedFulcioStringOption("githubWorkflowName", func(f *prSigstoreSignedFulcio) *string { return &f.GitHubWorkflowName }, name)
+}
+
+// PRSigstoreSignedFulcioWithGitHubWorkflowRepository specifies a value for the "githubWorkflowRepository" field when calling NewPRSigstoreSignedFulcio.
+func PRSigstoreSignedFulcioWithGitHubWorkflowRepository(repository string) PRSigstoreSignedFulcioOption {
+	return prSigstoreSignedFulcioStringOption("githubWorkflowRepository", func(f *prSigstoreSignedFulcio) *string { return &f.GitHubWorkflowRepository }, repository)
+}
+
+// PRSigstoreSignedFulcioWithGitHubWorkflowRef specifies a value for the "githubWorkflowRef" field when calling NewPRSigstoreSignedFulcio.
+func PRSigstoreSignedFulcioWithGitHubWorkflowRef(ref string) PRSigstoreSignedFulcioOption {
+	return prSigstoreSignedFulcioStringOption("githubWorkflowRef", func(f *prSigstoreSignedFulcio) *string { return &f.GitHubWorkflowRef }, ref)
+}
+
+// compileFullMatchRegexp compiles expr into a regular expression which must match the whole input.
+func compileFullMatchRegexp(expr string) (*regexp.Regexp, error) {
+	return regexp.Compile("^(?:" + expr + ")$")
+}
+
+// newPRSigstoreSignedFulcio is NewPRSigstoreSignedFulcio, except it returns the private type.
+func newPRSigstoreSignedFulcio(options ...PRSigstoreSignedFulcioOption) (*prSigstoreSignedFulcio, error) {
+	res := prSigstoreSignedFulcio{}
+	for _, o := range options {
+		if err := o(&res); err != nil {
+			return nil, err
+		}
+	}
+	if res.CAPath != "" && res.CAData != nil {
+		return nil, InvalidPolicyFormatError("caPath and caData cannot be used simultaneously")
+	}
+	if res.CAPath == "" && res.CAData == nil {
+		return nil, InvalidPolicyFormatError("At least one of caPath and caData must be specified")
+	}
+	if (res.OIDCIssuer == "") == (res.OIDCIssuerRegexp == "") {
+		return nil, InvalidPolicyFormatError("exactly one of oidcIssuer and oidcIssuerRegexp must be specified")
+	}
+	if (res.Subject == "") == (res.SubjectRegexp == "") {
+		return nil, InvalidPolicyFormatError("exactly one of subject and subjectRegexp must be specified")
+	}
+	for name, expr := range map[string]string{"oidcIssuerRegexp": res.OIDCIssuerRegexp, "subjectRegexp": res.SubjectRegexp} {
+		if expr == "" {
+			continue
+		}
+		if _, err := compileFullMatchRegexp(expr); err != nil {
+			return nil, InvalidPolicyFormatError(fmt.Sprintf("Invalid %s \"%s\": %v", name, expr, err))
+		}
+	}
+	return &res, nil
+}
+
+// NewPRSigstoreSignedFulcio returns a PRSigstoreSignedFulcio based on options.
+func NewPRSigstoreSignedFulcio(options ...PRSigstoreSignedFulcioOption) (PRSigstoreSignedFulcio, error) {
+	return newPRSigstoreSignedFulcio(options...)
+}
+
+// Compile-time check that prSigstoreSignedFulcio implements json.Unmarshaler.
+var _ json.Unmarshaler = (*prSigstoreSignedFulcio)(nil)
+
+// UnmarshalJSON implements the json.Unmarshaler interface.
+func (f *prSigstoreSignedFulcio) UnmarshalJSON(data []byte) error {
+	*f = prSigstoreSignedFulcio{}
+	stringOptions := map[string]func(string) PRSigstoreSignedFulcioOption{
+		"caPath":                   PRSigstoreSignedFulcioWithCAPath,
+		"oidcIssuer":               PRSigstoreSignedFulcioWithOIDCIssuer,
+		"oidcIssuerRegexp":         PRSigstoreSignedFulcioWithOIDCIssuerRegexp,
+		"subject":                  PRSigstoreSignedFulcioWithSubject,
+		"subjectRegexp":            PRSigstoreSignedFulcioWithSubjectRegexp,
+		"githubWorkflowTrigger":    PRSigstoreSignedFulcioWithGitHubWorkflowTrigger,
+		"githubWorkflowSHA":        PRSigstoreSignedFulcioWithGitHubWorkflowSHA,
+		"githubWorkflowName":       PRSigstoreSignedFulcioWithGitHubWorkflowName,
+		"githubWorkflowRepository": PRSigstoreSignedFulcioWithGitHubWorkflowRepository,
+		"githubWorkflowRef":        PRSigstoreSignedFulcioWithGitHubWorkflowRef,
+	}
+	var caData []byte
+	gotCAData := false
+	stringValues := map[string]*string{}
+	if err := paranoidUnmarshalJSONObject(data, func(key string) interface{} {
+		if key == "caData" {
+			gotCAData = true
+			return &caData
+		}
+		if _, ok := stringOptions[key]; ok {
+			v := new(string)
+			stringValues[key] = v
+			return v
+		}
+		return nil
+	}); err != nil {
+		return err
+	}
+
+	var opts []PRSigstoreSignedFulcioOption
+	if gotCAData {
+		opts = append(opts, PRSigstoreSignedFulcioWithCAData(caData))
+	}
+	for key, value := range stringValues {
+		opts = append(opts, stringOptions[key](*value))
+	}
+	res, err := newPRSigstoreSignedFulcio(opts...)
+	if err != nil {
+		return err
+	}
+	*f = *res
+	return nil
+}
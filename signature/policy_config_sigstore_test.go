@@ -0,0 +1,404 @@
+package signature
+
+import (
+	"encoding/json"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// xNewPRSigstoreSigned is like NewPRSigstoreSigned, except it must not fail.
+func xNewPRSigstoreSigned(options ...PRSigstoreSignedOption) PolicyRequirement {
+	pr, err := NewPRSigstoreSigned(options...)
+	if err != nil {
+		panic("xNewPRSigstoreSigned failed")
+	}
+	return pr
+}
+
+// xNewPRSigstoreSignedFulcio is like NewPRSigstoreSignedFulcio, except it must not fail.
+func xNewPRSigstoreSignedFulcio(options ...PRSigstoreSignedFulcioOption) PRSigstoreSignedFulcio {
+	f, err := NewPRSigstoreSignedFulcio(options...)
+	if err != nil {
+		panic("xNewPRSigstoreSignedFulcio failed")
+	}
+	return f
+}
+
+func TestNewPRSigstoreSigned(t *testing.T) {
+	const testPath = "/foo/bar"
+	testData := []byte("abc")
+	testIdentity := NewPRMMatchRepoDigestOrExact()
+	testFulcio := xNewPRSigstoreSignedFulcio(PRSigstoreSignedFulcioWithCAData(testData),
+		PRSigstoreSignedFulcioWithOIDCIssuer("https://issuer.example.com"), PRSigstoreSignedFulcioWithSubject("user@example.com"))
+
+	// Success
+	pr, err := newPRSigstoreSigned(PRSigstoreSignedWithKeyPath(testPath), PRSigstoreSignedWithSignedIdentity(testIdentity))
+	require.NoError(t, err)
+	assert.Equal(t, &prSigstoreSigned{
+		prCommon:       prCommon{prTypeSigstoreSigned},
+		KeyPath:        testPath,
+		SignedIdentity: testIdentity,
+	}, pr)
+	pr, err = newPRSigstoreSigned(PRSigstoreSignedWithKeyData(testData), PRSigstoreSignedWithRekorPublicKeyData(testData),
+		PRSigstoreSignedWithRekorURL("https://rekor.example.com"), PRSigstoreSignedWithSignedIdentity(testIdentity))
+	require.NoError(t, err)
+	assert.Equal(t, &prSigstoreSigned{
+		prCommon:           prCommon{prTypeSigstoreSigned},
+		KeyData:            testData,
+		RekorPublicKeyData: testData,
+		RekorURL:           "https://rekor.example.com",
+		SignedIdentity:     testIdentity,
+	}, pr)
+	pr, err = newPRSigstoreSigned(PRSigstoreSignedWithKeyData(testData), PRSigstoreSignedWithRekorPublicKeyPath(testPath),
+		PRSigstoreSignedWithSignedIdentity(testIdentity))
+	require.NoError(t, err)
+	assert.Equal(t, testPath, pr.RekorPublicKeyPath)
+	pr, err = newPRSigstoreSigned(PRSigstoreSignedWithFulcio(testFulcio), PRSigstoreSignedWithRekorPublicKeyData(testData),
+		PRSigstoreSignedWithSignedIdentity(testIdentity))
+	require.NoError(t, err)
+	assert.Equal(t, &prSigstoreSigned{
+		prCommon:           prCommon{prTypeSigstoreSigned},
+		Fulcio:             testFulcio,
+		RekorPublicKeyData: testData,
+		SignedIdentity:     testIdentity,
+	}, pr)
+	pr, err = newPRSigstoreSigned(PRSigstoreSignedWithFulcio(testFulcio), PRSigstoreSignedWithTimestampAuthorityCAData(testData),
+		PRSigstoreSignedWithSignedIdentity(testIdentity))
+	require.NoError(t, err)
+	assert.Equal(t, &prSigstoreSigned{
+		prCommon:                 prCommon{prTypeSigstoreSigned},
+		Fulcio:                   testFulcio,
+		TimestampAuthorityCAData: testData,
+		SignedIdentity:           testIdentity,
+	}, pr)
+	pr, err = newPRSigstoreSigned(PRSigstoreSignedWithKeyData(testData), PRSigstoreSignedWithTimestampAuthorityCAPath(testPath),
+		PRSigstoreSignedWithSignedIdentity(testIdentity))
+	require.NoError(t, err)
+	assert.Equal(t, testPath, pr.TimestampAuthorityCAPath)
+
+	for _, c := range [][]PRSigstoreSignedOption{
+		{}, // No options at all
+		// Key missing
+		{PRSigstoreSignedWithSignedIdentity(testIdentity)},
+		// Both keyPath and keyData
+		{PRSigstoreSignedWithKeyPath(testPath), PRSigstoreSignedWithKeyData(testData), PRSigstoreSignedWithSignedIdentity(testIdentity)},
+		// Both keyData and fulcio
+		{PRSigstoreSignedWithKeyData(testData), PRSigstoreSignedWithFulcio(testFulcio), PRSigstoreSignedWithRekorPublicKeyData(testData),
+			PRSigstoreSignedWithSignedIdentity(testIdentity)},
+		// Both keyPath and fulcio
+		{PRSigstoreSignedWithKeyPath(testPath), PRSigstoreSignedWithFulcio(testFulcio), PRSigstoreSignedWithRekorPublicKeyData(testData),
+			PRSigstoreSignedWithSignedIdentity(testIdentity)},
+		// fulcio without a Rekor key or a timestamp authority
+		{PRSigstoreSignedWithFulcio(testFulcio), PRSigstoreSignedWithSignedIdentity(testIdentity)},
+		// Duplicate options
+		{PRSigstoreSignedWithFulcio(testFulcio), PRSigstoreSignedWithFulcio(testFulcio), PRSigstoreSignedWithRekorPublicKeyData(testData),
+			PRSigstoreSignedWithSignedIdentity(testIdentity)},
+		{PRSigstoreSignedWithKeyPath(testPath), PRSigstoreSignedWithKeyPath(testPath + "1"), PRSigstoreSignedWithSignedIdentity(testIdentity)},
+		{PRSigstoreSignedWithKeyData(testData), PRSigstoreSignedWithKeyData(testData), PRSigstoreSignedWithSignedIdentity(testIdentity)},
+		{PRSigstoreSignedWithKeyData(testData), PRSigstoreSignedWithSignedIdentity(testIdentity), PRSigstoreSignedWithSignedIdentity(testIdentity)},
+		{PRSigstoreSignedWithKeyData(testData), PRSigstoreSignedWithRekorPublicKeyPath(testPath),
+			PRSigstoreSignedWithRekorPublicKeyPath(testPath), PRSigstoreSignedWithSignedIdentity(testIdentity)},
+		{PRSigstoreSignedWithKeyData(testData), PRSigstoreSignedWithRekorPublicKeyData(testData),
+			PRSigstoreSignedWithRekorPublicKeyData(testData), PRSigstoreSignedWithSignedIdentity(testIdentity)},
+		{PRSigstoreSignedWithKeyData(testData), PRSigstoreSignedWithRekorPublicKeyData(testData), PRSigstoreSignedWithRekorURL("https://rekor.example.com"),
+			PRSigstoreSignedWithRekorURL("https://rekor.example.com"), PRSigstoreSignedWithSignedIdentity(testIdentity)},
+		{PRSigstoreSignedWithKeyData(testData), PRSigstoreSignedWithTimestampAuthorityCAPath(testPath),
+			PRSigstoreSignedWithTimestampAuthorityCAPath(testPath), PRSigstoreSignedWithSignedIdentity(testIdentity)},
+		{PRSigstoreSignedWithKeyData(testData), PRSigstoreSignedWithTimestampAuthorityCAData(testData),
+			PRSigstoreSignedWithTimestampAuthorityCAData(testData), PRSigstoreSignedWithSignedIdentity(testIdentity)},
+		// Both rekorPublicKeyPath and rekorPublicKeyData
+		{PRSigstoreSignedWithKeyData(testData), PRSigstoreSignedWithRekorPublicKeyPath(testPath),
+			PRSigstoreSignedWithRekorPublicKeyData(testData), PRSigstoreSignedWithSignedIdentity(testIdentity)},
+		// rekorURL without a Rekor key
+		{PRSigstoreSignedWithKeyData(testData), PRSigstoreSignedWithRekorURL("https://rekor.example.com"), PRSigstoreSignedWithSignedIdentity(testIdentity)},
+		// Invalid rekorURL
+		{PRSigstoreSignedWithKeyData(testData), PRSigstoreSignedWithRekorPublicKeyData(testData), PRSigstoreSignedWithRekorURL("ftp://rekor.example.com"),
+			PRSigstoreSignedWithSignedIdentity(testIdentity)},
+		// Both timestampAuthorityCAPath and timestampAuthorityCAData
+		{PRSigstoreSignedWithKeyData(testData), PRSigstoreSignedWithTimestampAuthorityCAPath(testPath),
+			PRSigstoreSignedWithTimestampAuthorityCAData(testData), PRSigstoreSignedWithSignedIdentity(testIdentity)},
+		// signedIdentity missing
+		{PRSigstoreSignedWithKeyData(testData)},
+	} {
+		_, err = NewPRSigstoreSigned(c...)
+		assert.Error(t, err)
+	}
+}
+
+// Return the result of modifying validJSON with fn and unmarshaling it into *pr
+func tryUnmarshalModifiedSigstoreSigned(t *testing.T, pr *prSigstoreSigned, validJSON []byte, modifyFn func(mSI)) error {
+	var tmp mSI
+	err := json.Unmarshal(validJSON, &tmp)
+	require.NoError(t, err)
+
+	modifyFn(tmp)
+
+	*pr = prSigstoreSigned{}
+	return jsonUnmarshalFromObject(t, tmp, &pr)
+}
+
+func TestPRSigstoreSignedUnmarshalJSON(t *testing.T) {
+	keyDataTests := policyJSONUmarshallerTests{
+		newDest: func() json.Unmarshaler { return &prSigstoreSigned{} },
+		newValidObject: func() (interface{}, error) {
+			return NewPRSigstoreSigned(PRSigstoreSignedWithKeyData([]byte("abc")), PRSigstoreSignedWithRekorPublicKeyData([]byte("def")),
+				PRSigstoreSignedWithRekorURL("https://rekor.example.com"), PRSigstoreSignedWithSignedIdentity(NewPRMMatchRepoDigestOrExact()))
+		},
+		otherJSONParser: func(validJSON []byte) (interface{}, error) {
+			return newPolicyRequirementFromJSON(validJSON)
+		},
+		breakFns: []func(mSI){
+			// The "type" field is missing
+			func(v mSI) { delete(v, "type") },
+			// Wrong "type" field
+			func(v mSI) { v["type"] = 1 },
+			func(v mSI) { v["type"] = "this is invalid" },
+			// Extra top-level sub-object
+			func(v mSI) { v["unexpected"] = 1 },
+			// Both "keyPath" and "keyData" is missing
+			func(v mSI) { delete(v, "keyData") },
+			// Both "keyPath" and "keyData" is present
+			func(v mSI) { v["keyPath"] = "/foo/bar" },
+			// Invalid "keyPath" field
+			func(v mSI) { delete(v, "keyData"); v["keyPath"] = 1 },
+			// Invalid "keyData" field
+			func(v mSI) { v["keyData"] = 1 },
+			func(v mSI) { v["keyData"] = "this is invalid base64" },
+			// Both "rekorPublicKeyPath" and "rekorPublicKeyData" is present
+			func(v mSI) { v["rekorPublicKeyPath"] = "/foo/baz" },
+			// Invalid "rekorPublicKeyPath" field
+			func(v mSI) { delete(v, "rekorPublicKeyData"); v["rekorPublicKeyPath"] = 1 },
+			// Invalid "rekorPublicKeyData" field
+			func(v mSI) { v["rekorPublicKeyData"] = 1 },
+			func(v mSI) { v["rekorPublicKeyData"] = "this is invalid base64" },
+			// "rekorURL" without a Rekor public key
+			func(v mSI) { delete(v, "rekorPublicKeyData") },
+			// Invalid "rekorURL" field
+			func(v mSI) { v["rekorURL"] = 1 },
+			func(v mSI) { v["rekorURL"] = "this is invalid" },
+			// Invalid "signedIdentity" field
+			func(v mSI) { v["signedIdentity"] = "this is invalid" },
+			// "signedIdentity" an explicit nil
+			func(v mSI) { v["signedIdentity"] = nil },
+		},
+		duplicateFields: []string{"type", "keyData", "rekorPublicKeyData", "rekorURL", "signedIdentity"},
+	}
+	keyDataTests.run(t)
+	// Test the keyPath-specific aspects
+	policyJSONUmarshallerTests{
+		newDest: func() json.Unmarshaler { return &prSigstoreSigned{} },
+		newValidObject: func() (interface{}, error) {
+			return NewPRSigstoreSigned(PRSigstoreSignedWithKeyPath("/foo/bar"), PRSigstoreSignedWithRekorPublicKeyPath("/foo/baz"),
+				PRSigstoreSignedWithSignedIdentity(NewPRMMatchRepoDigestOrExact()))
+		},
+		otherJSONParser: func(validJSON []byte) (interface{}, error) {
+			return newPolicyRequirementFromJSON(validJSON)
+		},
+		duplicateFields: []string{"type", "keyPath", "rekorPublicKeyPath", "signedIdentity"},
+	}.run(t)
+
+	// Test the fulcio-specific aspects
+	policyJSONUmarshallerTests{
+		newDest: func() json.Unmarshaler { return &prSigstoreSigned{} },
+		newValidObject: func() (interface{}, error) {
+			return NewPRSigstoreSigned(
+				PRSigstoreSignedWithFulcio(xNewPRSigstoreSignedFulcio(PRSigstoreSignedFulcioWithCAData([]byte("abc")),
+					PRSigstoreSignedFulcioWithOIDCIssuer("https://issuer.example.com"), PRSigstoreSignedFulcioWithSubject("user@example.com"))),
+				PRSigstoreSignedWithRekorPublicKeyData([]byte("def")), PRSigstoreSignedWithSignedIdentity(NewPRMMatchRepoDigestOrExact()))
+		},
+		otherJSONParser: func(validJSON []byte) (interface{}, error) {
+			return newPolicyRequirementFromJSON(validJSON)
+		},
+		breakFns: []func(mSI){
+			// Both "fulcio" and "keyData" is present
+			func(v mSI) { v["keyData"] = "" },
+			// "fulcio" without a Rekor public key
+			func(v mSI) { delete(v, "rekorPublicKeyData") },
+			// Invalid "fulcio" field
+			func(v mSI) { v["fulcio"] = 1 },
+			func(v mSI) { v["fulcio"] = mSI{} },
+		},
+		duplicateFields: []string{"type", "fulcio", "rekorPublicKeyData", "signedIdentity"},
+	}.run(t)
+
+	// Test the timestamp authority-specific aspects
+	policyJSONUmarshallerTests{
+		newDest: func() json.Unmarshaler { return &prSigstoreSigned{} },
+		newValidObject: func() (interface{}, error) {
+			return NewPRSigstoreSigned(
+				PRSigstoreSignedWithFulcio(xNewPRSigstoreSignedFulcio(PRSigstoreSignedFulcioWithCAData([]byte("abc")),
+					PRSigstoreSignedFulcioWithOIDCIssuer("https://issuer.example.com"), PRSigstoreSignedFulcioWithSubject("user@example.com"))),
+				PRSigstoreSignedWithTimestampAuthorityCAData([]byte("def")), PRSigstoreSignedWithSignedIdentity(NewPRMMatchRepoDigestOrExact()))
+		},
+		otherJSONParser: func(validJSON []byte) (interface{}, error) {
+			return newPolicyRequirementFromJSON(validJSON)
+		},
+		breakFns: []func(mSI){
+			// Both "timestampAuthorityCAPath" and "timestampAuthorityCAData" is present
+			func(v mSI) { v["timestampAuthorityCAPath"] = "/foo/baz" },
+			// "fulcio" without a timestamp authority
+			func(v mSI) { delete(v, "timestampAuthorityCAData") },
+			// Invalid "timestampAuthorityCAPath" field
+			func(v mSI) { delete(v, "timestampAuthorityCAData"); v["timestampAuthorityCAPath"] = 1 },
+			// Invalid "timestampAuthorityCAData" field
+			func(v mSI) { v["timestampAuthorityCAData"] = 1 },
+			func(v mSI) { v["timestampAuthorityCAData"] = "this is invalid base64" },
+		},
+		duplicateFields: []string{"type", "fulcio", "timestampAuthorityCAData", "signedIdentity"},
+	}.run(t)
+
+	var pr prSigstoreSigned
+
+	// Start with a valid JSON.
+	_, validJSON := keyDataTests.validObjectAndJSON(t)
+
+	// Various allowed modifications to the requirement
+	allowedModificationFns := []func(mSI){
+		// Delete the rekorURL field
+		func(v mSI) { delete(v, "rekorURL") },
+		// Delete all Rekor fields
+		func(v mSI) { delete(v, "rekorURL"); delete(v, "rekorPublicKeyData") },
+	}
+	for _, fn := range allowedModificationFns {
+		err := tryUnmarshalModifiedSigstoreSigned(t, &pr, validJSON, fn)
+		require.NoError(t, err)
+	}
+
+	// Various ways to set signedIdentity to the default value
+	signedIdentityDefaultFns := []func(mSI){
+		// Set signedIdentity to the default explicitly
+		func(v mSI) { v["signedIdentity"] = NewPRMMatchRepoDigestOrExact() },
+		// Delete the signedIdentity field
+		func(v mSI) { delete(v, "signedIdentity") },
+	}
+	for _, fn := range signedIdentityDefaultFns {
+		err := tryUnmarshalModifiedSigstoreSigned(t, &pr, validJSON, fn)
+		require.NoError(t, err)
+		assert.Equal(t, NewPRMMatchRepoDigestOrExact(), pr.SignedIdentity)
+	}
+}
+
+func TestNewPRSigstoreSignedFulcio(t *testing.T) {
+	const testPath = "/foo/bar"
+	testData := []byte("abc")
+	const testIssuer = "https://issuer.example.com"
+	const testSubject = "user@example.com"
+
+	// Success
+	f, err := newPRSigstoreSignedFulcio(PRSigstoreSignedFulcioWithCAPath(testPath), PRSigstoreSignedFulcioWithOIDCIssuer(testIssuer),
+		PRSigstoreSignedFulcioWithSubject(testSubject))
+	require.NoError(t, err)
+	assert.Equal(t, &prSigstoreSignedFulcio{CAPath: testPath, OIDCIssuer: testIssuer, Subject: testSubject}, f)
+	f, err = newPRSigstoreSignedFulcio(PRSigstoreSignedFulcioWithCAData(testData),
+		PRSigstoreSignedFulcioWithOIDCIssuerRegexp(`https://token\.actions\.githubusercontent\.com`),
+		PRSigstoreSignedFulcioWithSubjectRegexp(`https://github\.com/org/.*`),
+		PRSigstoreSignedFulcioWithGitHubWorkflowTrigger("push"), PRSigstoreSignedFulcioWithGitHubWorkflowSHA("0123456789abcdef"),
+		PRSigstoreSignedFulcioWithGitHubWorkflowName("Release"), PRSigstoreSignedFulcioWithGitHubWorkflowRepository("org/repo"),
+		PRSigstoreSignedFulcioWithGitHubWorkflowRef("refs/heads/main"))
+	require.NoError(t, err)
+	assert.Equal(t, &prSigstoreSignedFulcio{
+		CAData:                   testData,
+		OIDCIssuerRegexp:         `https://token\.actions\.githubusercontent\.com`,
+		SubjectRegexp:            `https://github\.com/org/.*`,
+		GitHubWorkflowTrigger:    "push",
+		GitHubWorkflowSHA:        "0123456789abcdef",
+		GitHubWorkflowName:       "Release",
+		GitHubWorkflowRepository: "org/repo",
+		GitHubWorkflowRef:        "refs/heads/main",
+	}, f)
+
+	for _, c := range [][]PRSigstoreSignedFulcioOption{
+		{}, // No options at all
+		// CA missing
+		{PRSigstoreSignedFulcioWithOIDCIssuer(testIssuer), PRSigstoreSignedFulcioWithSubject(testSubject)},
+		// Both caPath and caData
+		{PRSigstoreSignedFulcioWithCAPath(testPath), PRSigstoreSignedFulcioWithCAData(testData), PRSigstoreSignedFulcioWithOIDCIssuer(testIssuer),
+			PRSigstoreSignedFulcioWithSubject(testSubject)},
+		// Issuer missing
+		{PRSigstoreSignedFulcioWithCAData(testData), PRSigstoreSignedFulcioWithSubject(testSubject)},
+		// Both oidcIssuer and oidcIssuerRegexp
+		{PRSigstoreSignedFulcioWithCAData(testData), PRSigstoreSignedFulcioWithOIDCIssuer(testIssuer), PRSigstoreSignedFulcioWithOIDCIssuerRegexp(".*"),
+			PRSigstoreSignedFulcioWithSubject(testSubject)},
+		// Subject missing
+		{PRSigstoreSignedFulcioWithCAData(testData), PRSigstoreSignedFulcioWithOIDCIssuer(testIssuer)},
+		// Both subject and subjectRegexp
+		{PRSigstoreSignedFulcioWithCAData(testData), PRSigstoreSignedFulcioWithOIDCIssuer(testIssuer), PRSigstoreSignedFulcioWithSubject(testSubject),
+			PRSigstoreSignedFulcioWithSubjectRegexp(".*")},
+		// Invalid regexps
+		{PRSigstoreSignedFulcioWithCAData(testData), PRSigstoreSignedFulcioWithOIDCIssuerRegexp("("), PRSigstoreSignedFulcioWithSubject(testSubject)},
+		{PRSigstoreSignedFulcioWithCAData(testData), PRSigstoreSignedFulcioWithOIDCIssuer(testIssuer), PRSigstoreSignedFulcioWithSubjectRegexp("(")},
+		// Duplicate options
+		{PRSigstoreSignedFulcioWithCAPath(testPath), PRSigstoreSignedFulcioWithCAPath(testPath + "1"), PRSigstoreSignedFulcioWithOIDCIssuer(testIssuer),
+			PRSigstoreSignedFulcioWithSubject(testSubject)},
+		{PRSigstoreSignedFulcioWithCAData(testData), PRSigstoreSignedFulcioWithCAData(testData), PRSigstoreSignedFulcioWithOIDCIssuer(testIssuer),
+			PRSigstoreSignedFulcioWithSubject(testSubject)},
+		{PRSigstoreSignedFulcioWithCAData(testData), PRSigstoreSignedFulcioWithOIDCIssuer(testIssuer), PRSigstoreSignedFulcioWithOIDCIssuer(testIssuer),
+			PRSigstoreSignedFulcioWithSubject(testSubject)},
+		{PRSigstoreSignedFulcioWithCAData(testData), PRSigstoreSignedFulcioWithOIDCIssuer(testIssuer), PRSigstoreSignedFulcioWithSubject(testSubject),
+			PRSigstoreSignedFulcioWithGitHubWorkflowRepository("org/repo"), PRSigstoreSignedFulcioWithGitHubWorkflowRepository("org/other")},
+	} {
+		_, err = NewPRSigstoreSignedFulcio(c...)
+		assert.Error(t, err)
+	}
+}
+
+func TestPRSigstoreSignedFulcioUnmarshalJSON(t *testing.T) {
+	policyJSONUmarshallerTests{
+		newDest: func() json.Unmarshaler { return &prSigstoreSignedFulcio{} },
+		newValidObject: func() (interface{}, error) {
+			return NewPRSigstoreSignedFulcio(PRSigstoreSignedFulcioWithCAData([]byte("abc")),
+				PRSigstoreSignedFulcioWithOIDCIssuer("https://token.actions.githubusercontent.com"),
+				PRSigstoreSignedFulcioWithSubjectRegexp(`https://github\.com/org/repo/.*`),
+				PRSigstoreSignedFulcioWithGitHubWorkflowTrigger("push"), PRSigstoreSignedFulcioWithGitHubWorkflowSHA("0123456789abcdef"),
+				PRSigstoreSignedFulcioWithGitHubWorkflowName("Release"), PRSigstoreSignedFulcioWithGitHubWorkflowRepository("org/repo"),
+				PRSigstoreSignedFulcioWithGitHubWorkflowRef("refs/heads/main"))
+		},
+		otherJSONParser: nil,
+		breakFns: []func(mSI){
+			// Extra top-level sub-object
+			func(v mSI) { v["unexpected"] = 1 },
+			// Both "caPath" and "caData" is missing
+			func(v mSI) { delete(v, "caData") },
+			// Both "caPath" and "caData" is present
+			func(v mSI) { v["caPath"] = "/foo/bar" },
+			// Invalid "caPath" field
+			func(v mSI) { delete(v, "caData"); v["caPath"] = 1 },
+			// Invalid "caData" field
+			func(v mSI) { v["caData"] = 1 },
+			func(v mSI) { v["caData"] = "this is invalid base64" },
+			// Both "oidcIssuer" and "oidcIssuerRegexp" is missing
+			func(v mSI) { delete(v, "oidcIssuer") },
+			// Both "oidcIssuer" and "oidcIssuerRegexp" is present
+			func(v mSI) { v["oidcIssuerRegexp"] = ".*" },
+			// Invalid "oidcIssuer" field
+			func(v mSI) { v["oidcIssuer"] = 1 },
+			// Both "subject" and "subjectRegexp" is missing
+			func(v mSI) { delete(v, "subjectRegexp") },
+			// Both "subject" and "subjectRegexp" is present
+			func(v mSI) { v["subject"] = "user@example.com" },
+			// Invalid "subjectRegexp" field
+			func(v mSI) { v["subjectRegexp"] = 1 },
+			func(v mSI) { v["subjectRegexp"] = "(" },
+			// Invalid GitHub workflow fields
+			func(v mSI) { v["githubWorkflowTrigger"] = 1 },
+			func(v mSI) { v["githubWorkflowSHA"] = 1 },
+			func(v mSI) { v["githubWorkflowName"] = 1 },
+			func(v mSI) { v["githubWorkflowRepository"] = 1 },
+			func(v mSI) { v["githubWorkflowRef"] = 1 },
+		},
+		duplicateFields: []string{"caData", "oidcIssuer", "subjectRegexp", "githubWorkflowTrigger", "githubWorkflowSHA",
+			"githubWorkflowName", "githubWorkflowRepository", "githubWorkflowRef"},
+	}.run(t)
+	// Test the caPath-specific aspects
+	policyJSONUmarshallerTests{
+		newDest: func() json.Unmarshaler { return &prSigstoreSignedFulcio{} },
+		newValidObject: func() (interface{}, error) {
+			return NewPRSigstoreSignedFulcio(PRSigstoreSignedFulcioWithCAPath("/foo/bar"),
+				PRSigstoreSignedFulcioWithOIDCIssuerRegexp(".*"), PRSigstoreSignedFulcioWithSubject("user@example.com"))
+		},
+		otherJSONParser: nil,
+		duplicateFields: []string{"caPath", "oidcIssuerRegexp", "subject"},
+	}.run(t)
+}
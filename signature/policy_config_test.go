@@ -97,7 +97,7 @@ func TestInvalidPolicyFormatError(t *testing.T) {
 
 func TestDefaultPolicy(t *testing.T) {
 	// We can't test the actual systemDefaultPolicyPath, so override.
-	// TestDefaultPolicyPath below tests that we handle the overrides and defaults
+	// TestDefaultPolicyPath and TestDefaultPolicySources below test that we handle the overrides and defaults
 	// correctly.
 
 	// Success
@@ -113,6 +113,44 @@ func TestDefaultPolicy(t *testing.T) {
 		assert.Error(t, err)
 		assert.Nil(t, policy)
 	}
+
+	// Merging explicitly requested; it is tested in TestNewPolicyFromFiles.
+	policy, err = DefaultPolicy(&types.SystemContext{SignaturePolicyPath: "./fixtures/policy.json", SignaturePolicyMergeDropIns: true})
+	require.NoError(t, err)
+	assert.Equal(t, policyFixtureContents, policy)
+
+	// Drop-in directories
+	dir := t.TempDir()
+	writePolicyFiles(t, dir, map[string]string{
+		"policy.d/a.json": `{"default":[{"type":"insecureAcceptAnything"}]}`,
+	})
+	policy, err = DefaultPolicy(&types.SystemContext{
+		SignaturePolicyPath:    "./fixtures/policy.json",
+		SignaturePolicyDirPath: filepath.Join(dir, "policy.d"),
+	})
+	require.NoError(t, err)
+	assert.Equal(t, PolicyRequirements{NewPRInsecureAcceptAnything()}, policy.Default)
+	assert.Equal(t, policyFixtureContents.Transports, policy.Transports)
+	// An explicitly specified policy file must exist
+	policy, err = DefaultPolicy(&types.SystemContext{
+		SignaturePolicyPath:    "/this/does/not/exist",
+		SignaturePolicyDirPath: filepath.Join(dir, "policy.d"),
+	})
+	assert.Error(t, err)
+	assert.Nil(t, policy)
+
+	// Remote policies; loading them is tested in TestNewPolicyFromRemoteSource*.
+	for _, sys := range []*types.SystemContext{
+		{SignaturePolicyRemote: &types.RemoteSignaturePolicy{}},
+		{SignaturePolicyRemote: &types.RemoteSignaturePolicy{Source: "https://example.com/policy.json", PublicKeyData: []byte{}},
+			SignaturePolicyPath: "./fixtures/policy.json"},
+		{SignaturePolicyRemote: &types.RemoteSignaturePolicy{Source: "https://example.com/policy.json", PublicKeyData: []byte{}},
+			SignaturePolicyDirPath: dir},
+	} {
+		policy, err := DefaultPolicy(sys)
+		assert.Error(t, err)
+		assert.Nil(t, policy)
+	}
 }
 
 func TestDefaultPolicyPath(t *testing.T) {
@@ -194,6 +232,154 @@ func TestDefaultPolicyPath(t *testing.T) {
 	}
 }
 
+func TestDefaultPolicySources(t *testing.T) {
+	const nondefaultPath = "/this/is/not/the/default/path.json"
+	const nondefaultDirPath = "/this/is/not/the/default/policy.d"
+	const variableReference = "$HOME"
+	const rootPrefix = "/root/prefix"
+	tempHome := t.TempDir()
+	userDefaultPolicyPath := filepath.Join(tempHome, userPolicyFile)
+	userDefaultPolicyDirPath := filepath.Join(tempHome, userPolicyDir)
+
+	for _, c := range []struct {
+		sys      *types.SystemContext
+		expected []string
+	}{
+		// The common case
+		{nil, []string{systemDefaultPolicyPath, systemDefaultPolicyDirPath, userDefaultPolicyPath, userDefaultPolicyDirPath}},
+		// There is a context, but it does not override the path.
+		{&types.SystemContext{}, []string{systemDefaultPolicyPath, systemDefaultPolicyDirPath, userDefaultPolicyPath, userDefaultPolicyDirPath}},
+		// Path overridden
+		{&types.SystemContext{SignaturePolicyPath: nondefaultPath}, []string{nondefaultPath}},
+		// Path overridden, merging explicitly requested
+		{&types.SystemContext{SignaturePolicyPath: nondefaultPath, SignaturePolicyMergeDropIns: true}, []string{nondefaultPath}},
+		// Directory overridden
+		{&types.SystemContext{SignaturePolicyDirPath: nondefaultDirPath}, []string{systemDefaultPolicyPath, nondefaultDirPath, userDefaultPolicyPath}},
+		// Path and directory overridden
+		{
+			&types.SystemContext{SignaturePolicyPath: nondefaultPath, SignaturePolicyDirPath: nondefaultDirPath},
+			[]string{nondefaultPath, nondefaultDirPath},
+		},
+		// Root overridden
+		{
+			&types.SystemContext{RootForImplicitAbsolutePaths: rootPrefix},
+			[]string{
+				filepath.Join(rootPrefix, systemDefaultPolicyPath), filepath.Join(rootPrefix, systemDefaultPolicyDirPath),
+				userDefaultPolicyPath, userDefaultPolicyDirPath,
+			},
+		},
+		// Root and directory overrides present simultaneously
+		{
+			&types.SystemContext{RootForImplicitAbsolutePaths: rootPrefix, SignaturePolicyDirPath: nondefaultDirPath},
+			[]string{filepath.Join(rootPrefix, systemDefaultPolicyPath), nondefaultDirPath, userDefaultPolicyPath},
+		},
+		// Root and path overrides present simultaneously,
+		{
+			&types.SystemContext{
+				RootForImplicitAbsolutePaths: rootPrefix,
+				SignaturePolicyPath:          nondefaultPath,
+			},
+			[]string{nondefaultPath},
+		},
+		// No environment expansion happens in the overridden paths
+		{&types.SystemContext{SignaturePolicyPath: variableReference}, []string{variableReference}},
+	} {
+		paths := defaultPolicySourcesWithHomeDir(c.sys, tempHome)
+		assert.Equal(t, c.expected, paths)
+	}
+}
+
+// writePolicyFiles creates files, a map from paths relative to dir to their contents, in dir.
+func writePolicyFiles(t *testing.T, dir string, files map[string]string) {
+	for path, contents := range files {
+		path = filepath.Join(dir, path)
+		err := os.MkdirAll(filepath.Dir(path), 0755)
+		require.NoError(t, err)
+		err = os.WriteFile(path, []byte(contents), 0644)
+		require.NoError(t, err)
+	}
+}
+
+func TestNewPolicyFromFiles(t *testing.T) {
+	dir := t.TempDir()
+	writePolicyFiles(t, dir, map[string]string{
+		"policy.json":                   `{"default":[{"type":"reject"}],"transports":{"docker":{"example.com":[{"type":"reject"}],"example.com/a":[{"type":"reject"}]}}}`,
+		"policy.d/10-docker.json":       `{"transports":{"docker":{"example.com":[{"type":"insecureAcceptAnything"}],"example.com/b":[{"type":"reject"}]}}}`,
+		"policy.d/20-atomic.json":       `{"transports":{"atomic":{"example.com":[{"type":"reject"}]}}}`,
+		"policy.d/not-a-drop-in.conf":   `this is ignored`,
+		"policy.d/subdirectory/x.json":  `this is ignored`,
+		"user/policy.json":              `{"default":[{"type":"insecureAcceptAnything"}]}`,
+		"user/policy.d/docker.json":     `{"transports":{"docker":{"example.com/b":[{"type":"insecureAcceptAnything"}]}}}`,
+		"no-default/policy.json":        `{"transports":{"docker":{"example.com":[{"type":"reject"}]}}}`,
+		"conflict/default/a.json":       `{"default":[{"type":"reject"}]}`,
+		"conflict/default/b.json":       `{"default":[{"type":"reject"}]}`,
+		"conflict/scope/a.json":         `{"transports":{"docker":{"example.com":[{"type":"reject"}]}}}`,
+		"conflict/scope/b.json":         `{"transports":{"docker":{"example.com":[{"type":"reject"}]}}}`,
+		"no-conflict/a.json":            `{"transports":{"docker":{"example.com":[{"type":"reject"}]}}}`,
+		"no-conflict/b.json":            `{"transports":{"atomic":{"example.com":[{"type":"reject"}]}}}`,
+		"invalid/a.json":                `{"transports":{"docker":{"example.com":[{"type":"this is invalid"}]}}}`,
+		"invalid-json/policy.json":      `{"default":[{"type":"reject"}],"unknown":true}`,
+		"empty-dir/not-a-drop-in.conf":  `this is ignored`,
+		"only-drop-ins/policy.d/a.json": `{"default":[{"type":"reject"}]}`,
+	})
+	path := func(p string) string {
+		return filepath.Join(dir, filepath.FromSlash(p))
+	}
+
+	// Only one file
+	policy, err := NewPolicyFromFiles("./fixtures/policy.json")
+	require.NoError(t, err)
+	assert.Equal(t, policyFixtureContents, policy)
+
+	// Values from later paths take precedence
+	policy, err = NewPolicyFromFiles(path("policy.json"), path("policy.d"), path("user/policy.json"), path("user/policy.d"),
+		path("this/does/not/exist"))
+	require.NoError(t, err)
+	assert.Equal(t, &Policy{
+		Default: PolicyRequirements{NewPRInsecureAcceptAnything()},
+		Transports: map[string]PolicyTransportScopes{
+			"docker": {
+				"example.com":   PolicyRequirements{NewPRInsecureAcceptAnything()},
+				"example.com/a": PolicyRequirements{NewPRReject()},
+				"example.com/b": PolicyRequirements{NewPRInsecureAcceptAnything()},
+			},
+			"atomic": {
+				"example.com": PolicyRequirements{NewPRReject()},
+			},
+		},
+	}, policy)
+	// … including drop-in directories with a lower precedence than a policy file
+	policy, err = NewPolicyFromFiles(path("policy.d"), path("policy.json"))
+	require.NoError(t, err)
+	assert.Equal(t, PolicyRequirements{NewPRReject()}, policy.Transports["docker"]["example.com"])
+	assert.Equal(t, PolicyRequirements{NewPRReject()}, policy.Transports["docker"]["example.com/b"])
+
+	// Drop-in directories alone
+	policy, err = NewPolicyFromFiles(path("only-drop-ins/policy.json"), path("only-drop-ins/policy.d"))
+	require.NoError(t, err)
+	assert.Equal(t, &Policy{Default: PolicyRequirements{NewPRReject()}, Transports: map[string]PolicyTransportScopes{}}, policy)
+	// Drop-in files specifying different scopes
+	policy, err = NewPolicyFromFiles(path("policy.json"), path("no-conflict"))
+	require.NoError(t, err)
+	assert.Equal(t, PolicyRequirements{NewPRReject()}, policy.Transports["atomic"]["example.com"])
+
+	for _, paths := range [][]string{
+		{},                               // No paths
+		{path("this/does/not/exist")},    // No files
+		{path("empty-dir")},              // No drop-in files
+		{path("no-default/policy.json")}, // No default
+		{path("policy.d")},               // No default
+		{path("policy.json"), path("conflict/default")}, // Drop-in files specify the same default
+		{path("policy.json"), path("conflict/scope")},   // Drop-in files specify the same scope
+		{path("policy.json"), path("invalid")},          // Invalid requirement
+		{path("invalid-json/policy.json")},              // Unknown field
+		{"/dev/null"},                                   // Not JSON
+	} {
+		_, err := NewPolicyFromFiles(paths...)
+		assert.Error(t, err, paths)
+	}
+}
+
 func TestNewPolicyFromFile(t *testing.T) {
 	// Success
 	policy, err := NewPolicyFromFile("./fixtures/policy.json")
@@ -0,0 +1,146 @@
+// Policy evaluation for prPlatformSpecific.
+
+package signature
+
+import (
+	"context"
+	"fmt"
+	"sort"
+	"strings"
+
+	"github.com/containers/image/v5/internal/private"
+	"github.com/containers/image/v5/types"
+	imgspecv1 "github.com/opencontainers/image-spec/specs-go/v1"
+	"github.com/pkg/errors"
+	"github.com/sirupsen/logrus"
+)
+
+// requirementsForImage returns the requirements which apply to image, and a description of the image platform for
+// error messages. It returns an empty list if no requirements apply, i.e. if the image should be rejected.
+// Only the platform recorded for an instance in a manifest list is used to choose requirements, because that is
+// the platform the instance is chosen for; images with any other (or an unknown) platform might run on any platform,
+// so they must satisfy the requirements of all platforms.
+// WARNING: The platform is determined from the (unverified) manifest list, and it can only be used to choose requirements.
+func (pr *prPlatformSpecific) requirementsForImage(ctx context.Context, image types.UnparsedImage) (PolicyRequirements, string, error) {
+	var platform *imgspecv1.Platform
+	if reader, ok := image.(private.PlatformReader); ok {
+		p, err := reader.UntrustedPlatform(ctx)
+		if err != nil {
+			return nil, "", errors.Wrap(err, "determining the platform of the image")
+		}
+		platform = p
+	}
+	if platform == nil {
+		logrus.Debugf(" Image was not chosen for a known platform, using requirements for all platforms")
+		return pr.allRequirements(), "(unknown)", nil
+	}
+
+	osArch := platform.OS + "/" + platform.Architecture
+	if platform.Variant != "" {
+		description := osArch + "/" + platform.Variant
+		if reqs, ok := pr.Platforms[description]; ok {
+			logrus.Debugf(" Using requirements for platform %s", description)
+			return reqs, description, nil
+		}
+		if reqs, ok := pr.Platforms[osArch]; ok {
+			logrus.Debugf(" Using requirements for platform %s", osArch)
+			return reqs, description, nil
+		}
+		logrus.Debugf(" No requirements for platform %s, using default requirements", description)
+		return pr.Default, description, nil
+	}
+
+	// The instance may be chosen for any variant of osArch, so it must satisfy the requirements for all of them.
+	var reqs PolicyRequirements
+	if archReqs, ok := pr.Platforms[osArch]; ok {
+		reqs = append(reqs, archReqs...)
+	} else {
+		if len(pr.Default) == 0 {
+			logrus.Debugf(" No requirements for platform %s", osArch)
+			return nil, osArch, nil
+		}
+		reqs = append(reqs, pr.Default...)
+	}
+	for _, key := range sortedPlatformKeys(pr.Platforms) {
+		if strings.HasPrefix(key, osArch+"/") {
+			reqs = append(reqs, pr.Platforms[key]...)
+		}
+	}
+	logrus.Debugf(" Using requirements for all variants of platform %s", osArch)
+	return reqs, osArch, nil
+}
+
+// allRequirements returns the requirements of all platforms in pr, including the default ones, or an empty list
+// if there are no default requirements (i.e. images of some platforms must be rejected).
+func (pr *prPlatformSpecific) allRequirements() PolicyRequirements {
+	if len(pr.Default) == 0 {
+		return nil
+	}
+	reqs := append(PolicyRequirements{}, pr.Default...)
+	for _, key := range sortedPlatformKeys(pr.Platforms) {
+		reqs = append(reqs, pr.Platforms[key]...)
+	}
+	return reqs
+}
+
+// sortedPlatformKeys returns the keys of platforms, sorted, so that requirements are evaluated in a deterministic order.
+func sortedPlatformKeys(platforms map[string]PolicyRequirements) []string {
+	keys := make([]string, 0, len(platforms))
+	for key := range platforms {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+	return keys
+}
+
+func (pr *prPlatformSpecific) isSignatureAuthorAccepted(ctx context.Context, image types.UnparsedImage, sig []byte) (signatureAcceptanceResult, *Signature, error) {
+	reqs, platform, err := pr.requirementsForImage(ctx, image)
+	if err != nil {
+		return sarRejected, nil, err
+	}
+	if len(reqs) == 0 {
+		return sarRejected, nil, PolicyRequirementError(fmt.Sprintf("No policy requirements for platform %s", platform))
+	}
+	var acceptedSig *Signature
+	for _, req := range reqs {
+		switch res, as, err := req.isSignatureAuthorAccepted(ctx, image, sig); res {
+		case sarAccepted:
+			if as == nil { // Coverage: this should never happen
+				return sarRejected, nil, errors.New("internal inconsistency: sarAccepted but no parsed contents")
+			}
+			if acceptedSig != nil && *as != *acceptedSig { // Coverage: this should never happen
+				return sarRejected, nil, errors.New("internal inconsistency: sarAccepted but different parsed contents")
+			}
+			acceptedSig = as
+		case sarRejected:
+			return sarRejected, nil, err
+		case sarUnknown:
+			if err != nil { // Coverage: this should never happen
+				return sarRejected, nil, errors.Wrap(err, "internal inconsistency: sarUnknown but an error")
+			}
+		default: // Coverage: this should never happen
+			return sarRejected, nil, errors.Errorf("internal inconsistency: unknown result %#v", string(res))
+		}
+	}
+	if acceptedSig != nil {
+		return sarAccepted, acceptedSig, nil
+	}
+	return sarUnknown, nil, nil
+}
+
+func (pr *prPlatformSpecific) isRunningImageAllowed(ctx context.Context, image types.UnparsedImage) (bool, error) {
+	reqs, platform, err := pr.requirementsForImage(ctx, image)
+	if err != nil {
+		return false, err
+	}
+	if len(reqs) == 0 {
+		return false, PolicyRequirementError(fmt.Sprintf("No policy requirements for platform %s", platform))
+	}
+	for _, req := range reqs {
+		allowed, err := req.isRunningImageAllowed(ctx, image)
+		if !allowed {
+			return false, err
+		}
+	}
+	return true, nil
+}
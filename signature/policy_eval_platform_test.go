@@ -0,0 +1,268 @@
+package signature
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/containers/image/v5/manifest"
+	"github.com/containers/image/v5/types"
+	"github.com/opencontainers/go-digest"
+	imgspecv1 "github.com/opencontainers/image-spec/specs-go/v1"
+	"github.com/stretchr/testify/require"
+)
+
+// platformImageMock is a types.UnparsedImage which implements private.PlatformReader.
+type platformImageMock struct {
+	types.UnparsedImage
+	platform *imgspecv1.Platform
+	err      error
+}
+
+func (i platformImageMock) UntrustedPlatform(ctx context.Context) (*imgspecv1.Platform, error) {
+	return i.platform, i.err
+}
+
+// newPlatformImageMock returns a platformImageMock for a directory, claiming a specified dockerReference,
+// and a platform os/architecture/variant.
+func newPlatformImageMock(t *testing.T, dir, dockerReference, os, architecture, variant string) platformImageMock {
+	return platformImageMock{
+		UnparsedImage: pcImageMock(t, dir, dockerReference),
+		platform:      &imgspecv1.Platform{OS: os, Architecture: architecture, Variant: variant},
+	}
+}
+
+// testPlatformSpecific returns a prPlatformSpecific for use in tests, with defaultRequirements.
+func testPlatformSpecific(t *testing.T, defaultRequirements PolicyRequirements) PolicyRequirement {
+	signedBy, err := NewPRSignedByKeyPath(SBKeyTypeGPGKeys, "fixtures/public-key.gpg", NewPRMMatchExact())
+	require.NoError(t, err)
+	pr, err := NewPRPlatformSpecific(map[string]PolicyRequirements{
+		"linux/amd64":    {signedBy},
+		"linux/riscv64":  {NewPRInsecureAcceptAnything()},
+		"linux/arm64":    {NewPRInsecureAcceptAnything()},
+		"linux/arm64/v8": {NewPRReject()},
+	}, defaultRequirements)
+	require.NoError(t, err)
+	return pr
+}
+
+func TestPRPlatformSpecificIsSignatureAuthorAccepted(t *testing.T) {
+	ctx := context.Background()
+	testImageSig, err := os.ReadFile("fixtures/dir-img-valid/signature-1")
+	require.NoError(t, err)
+	expectedSig := Signature{
+		DockerManifestDigest: TestImageManifestDigest,
+		DockerReference:      "testing/manifest:latest",
+	}
+	signedBy, err := NewPRSignedByKeyPath(SBKeyTypeGPGKeys, "fixtures/public-key.gpg", NewPRMMatchExact())
+	require.NoError(t, err)
+	pr := testPlatformSpecific(t, nil)
+
+	// Requirements for the platform of the image are used
+	image := newPlatformImageMock(t, "fixtures/dir-img-valid", "testing/manifest:latest", "linux", "amd64", "")
+	sar, parsedSig, err := pr.isSignatureAuthorAccepted(ctx, image, testImageSig)
+	assertSARAccepted(t, sar, parsedSig, err, expectedSig)
+	image = newPlatformImageMock(t, "fixtures/dir-img-valid", "testing/manifest:notlatest", "linux", "amd64", "")
+	sar, parsedSig, err = pr.isSignatureAuthorAccepted(ctx, image, testImageSig)
+	assertSARRejected(t, sar, parsedSig, err)
+	image = newPlatformImageMock(t, "fixtures/dir-img-valid", "testing/manifest:latest", "linux", "riscv64", "")
+	sar, parsedSig, err = pr.isSignatureAuthorAccepted(ctx, image, testImageSig)
+	assertSARUnknown(t, sar, parsedSig, err)
+	image = newPlatformImageMock(t, "fixtures/dir-img-valid", "testing/manifest:latest", "linux", "arm64", "v8")
+	sar, parsedSig, err = pr.isSignatureAuthorAccepted(ctx, image, testImageSig)
+	assertSARRejectedPolicyRequirement(t, sar, parsedSig, err)
+
+	// No requirements for the platform
+	image = newPlatformImageMock(t, "fixtures/dir-img-valid", "testing/manifest:latest", "linux", "s390x", "")
+	sar, parsedSig, err = pr.isSignatureAuthorAccepted(ctx, image, testImageSig)
+	assertSARRejectedPolicyRequirement(t, sar, parsedSig, err)
+	// … but default requirements exist
+	prWithDefault := testPlatformSpecific(t, PolicyRequirements{signedBy})
+	sar, parsedSig, err = prWithDefault.isSignatureAuthorAccepted(ctx, image, testImageSig)
+	assertSARAccepted(t, sar, parsedSig, err, expectedSig)
+
+	// Error determining the platform
+	image = platformImageMock{
+		UnparsedImage: pcImageMock(t, "fixtures/dir-img-valid", "testing/manifest:latest"),
+		err:           errors.New("platform error"),
+	}
+	sar, parsedSig, err = prWithDefault.isSignatureAuthorAccepted(ctx, image, testImageSig)
+	assertSARRejected(t, sar, parsedSig, err)
+}
+
+func TestPRPlatformSpecificIsRunningImageAllowed(t *testing.T) {
+	ctx := context.Background()
+	pr := testPlatformSpecific(t, nil)
+	prWithDefault := testPlatformSpecific(t, PolicyRequirements{NewPRInsecureAcceptAnything()})
+
+	for _, c := range []struct {
+		dir, os, arch, variant string
+		allowed                bool
+	}{
+		{"fixtures/dir-img-valid", "linux", "amd64", "", true},
+		{"fixtures/dir-img-unsigned", "linux", "amd64", "", false},
+		{"fixtures/dir-img-unsigned", "linux", "riscv64", "", true},
+		{"fixtures/dir-img-unsigned", "linux", "arm64", "", false}, // Must satisfy the requirements for linux/arm64/v8 as well
+		{"fixtures/dir-img-unsigned", "linux", "arm64", "v7", true},
+		{"fixtures/dir-img-unsigned", "linux", "arm64", "v8", false},
+		{"fixtures/dir-img-unsigned", "linux", "s390x", "", false},
+		{"fixtures/dir-img-unsigned", "windows", "amd64", "", false},
+	} {
+		image := newPlatformImageMock(t, c.dir, "testing/manifest:latest", c.os, c.arch, c.variant)
+		allowed, err := pr.isRunningImageAllowed(ctx, image)
+		if c.allowed {
+			assertRunningAllowed(t, allowed, err)
+		} else {
+			assertRunningRejectedPolicyRequirement(t, allowed, err)
+		}
+	}
+
+	// Platforms without specific requirements use the default ones
+	image := newPlatformImageMock(t, "fixtures/dir-img-unsigned", "testing/manifest:latest", "linux", "s390x", "")
+	allowed, err := prWithDefault.isRunningImageAllowed(ctx, image)
+	assertRunningAllowed(t, allowed, err)
+	image = newPlatformImageMock(t, "fixtures/dir-img-unsigned", "testing/manifest:latest", "linux", "amd64", "")
+	allowed, err = prWithDefault.isRunningImageAllowed(ctx, image)
+	assertRunningRejectedPolicyRequirement(t, allowed, err)
+
+	// Images without a platform, or which don't support reading it, must satisfy the requirements of all platforms
+	signedBy, err := NewPRSignedByKeyPath(SBKeyTypeGPGKeys, "fixtures/public-key.gpg", NewPRMMatchExact())
+	require.NoError(t, err)
+	prSignedAMD64, err := NewPRPlatformSpecific(map[string]PolicyRequirements{
+		"linux/amd64":   {signedBy},
+		"linux/riscv64": {NewPRInsecureAcceptAnything()},
+	}, PolicyRequirements{NewPRInsecureAcceptAnything()})
+	require.NoError(t, err)
+	for _, c := range []struct {
+		image   types.UnparsedImage
+		signed  bool
+		allowed bool
+	}{
+		{platformImageMock{UnparsedImage: pcImageMock(t, "fixtures/dir-img-unsigned", "testing/manifest:latest")}, false, false},
+		{platformImageMock{UnparsedImage: pcImageMock(t, "fixtures/dir-img-valid", "testing/manifest:latest")}, true, true},
+		{pcImageMock(t, "fixtures/dir-img-unsigned", "testing/manifest:latest"), false, false},
+		{pcImageMock(t, "fixtures/dir-img-valid", "testing/manifest:latest"), true, true},
+	} {
+		allowed, err := pr.isRunningImageAllowed(ctx, c.image)
+		assertRunningRejectedPolicyRequirement(t, allowed, err)
+		allowed, err = prWithDefault.isRunningImageAllowed(ctx, c.image)
+		if c.signed {
+			// Signed, but linux/arm64/v8 requires a rejection
+			assertRunningRejectedPolicyRequirement(t, allowed, err)
+		} else {
+			assertRunningRejected(t, allowed, err)
+		}
+		allowed, err = prSignedAMD64.isRunningImageAllowed(ctx, c.image)
+		if c.allowed {
+			assertRunningAllowed(t, allowed, err)
+		} else {
+			assertRunningRejected(t, allowed, err)
+		}
+	}
+	// Without default requirements, images which don't support reading the platform are rejected without inspecting them
+	allowed, err = pr.isRunningImageAllowed(ctx, nameOnlyImageMock{})
+	assertRunningRejectedPolicyRequirement(t, allowed, err)
+
+	// Error determining the platform
+	image = platformImageMock{
+		UnparsedImage: pcImageMock(t, "fixtures/dir-img-unsigned", "testing/manifest:latest"),
+		err:           errors.New("platform error"),
+	}
+	allowed, err = prWithDefault.isRunningImageAllowed(ctx, image)
+	assertRunningRejected(t, allowed, err)
+}
+
+func TestPolicyContextIsRunningImageAllowedPlatformSpecific(t *testing.T) {
+	policy, err := NewPolicyFromBytes([]byte(`{
+		"default": [{"type": "reject"}],
+		"transports": {
+			"docker": {
+				"docker.io/testing/manifest": [
+					{
+						"type": "platformSpecific",
+						"platforms": {
+							"linux/amd64": [{"type": "signedBy", "keyType": "GPGKeys", "keyPath": "fixtures/public-key.gpg"}],
+							"linux/riscv64": [{"type": "insecureAcceptAnything"}]
+						}
+					}
+				]
+			}
+		}
+	}`))
+	require.NoError(t, err)
+	pc, err := NewPolicyContext(policy)
+	require.NoError(t, err)
+	defer func() {
+		err := pc.Destroy()
+		require.NoError(t, err)
+	}()
+
+	image := platformImageMock{
+		UnparsedImage: pcImageMock(t, "fixtures/dir-img-unsigned", "testing/manifest:latest"),
+		platform:      &imgspecv1.Platform{OS: "linux", Architecture: "riscv64"},
+	}
+	allowed, err := pc.IsRunningImageAllowed(context.Background(), image)
+	assertRunningAllowed(t, allowed, err)
+	image.platform = &imgspecv1.Platform{OS: "linux", Architecture: "amd64"}
+	allowed, err = pc.IsRunningImageAllowed(context.Background(), image)
+	assertRunningRejectedPolicyRequirement(t, allowed, err)
+	image.UnparsedImage = pcImageMock(t, "fixtures/dir-img-valid", "testing/manifest:latest")
+	allowed, err = pc.IsRunningImageAllowed(context.Background(), image)
+	assertRunningAllowed(t, allowed, err)
+}
+
+// writeRelabeledImage writes an unsigned single-platform image with a config claiming osName/architecture to a new directory,
+// and returns the path of the directory.
+func writeRelabeledImage(t *testing.T, osName, architecture string) string {
+	dir := t.TempDir()
+	config := []byte(fmt.Sprintf(`{"os":%q,"architecture":%q,"rootfs":{"type":"layers","diff_ids":[]}}`, osName, architecture))
+	configDigest := digest.FromBytes(config)
+	m, err := manifest.OCI1FromComponents(imgspecv1.Descriptor{
+		MediaType: imgspecv1.MediaTypeImageConfig,
+		Digest:    configDigest,
+		Size:      int64(len(config)),
+	}, []imgspecv1.Descriptor{}).Serialize()
+	require.NoError(t, err)
+	err = os.WriteFile(filepath.Join(dir, configDigest.Encoded()), config, 0o644)
+	require.NoError(t, err)
+	err = os.WriteFile(filepath.Join(dir, "manifest.json"), m, 0o644)
+	require.NoError(t, err)
+	return dir
+}
+
+func TestPolicyContextIsRunningImageAllowedPlatformSpecificRelabeled(t *testing.T) {
+	policy, err := NewPolicyFromBytes([]byte(`{
+		"default": [{"type": "reject"}],
+		"transports": {
+			"docker": {
+				"docker.io/testing/manifest": [
+					{
+						"type": "platformSpecific",
+						"platforms": {
+							"linux/amd64": [{"type": "signedBy", "keyType": "GPGKeys", "keyPath": "fixtures/public-key.gpg"}],
+							"linux/riscv64": [{"type": "insecureAcceptAnything"}]
+						},
+						"default": [{"type": "insecureAcceptAnything"}]
+					}
+				]
+			}
+		}
+	}`))
+	require.NoError(t, err)
+	pc, err := NewPolicyContext(policy)
+	require.NoError(t, err)
+	defer func() {
+		err := pc.Destroy()
+		require.NoError(t, err)
+	}()
+
+	// A top-level image is not chosen for any platform, so the platform in its config must not relax the requirements.
+	for _, arch := range []string{"riscv64", "amd64", "s390x"} {
+		image := pcImageMock(t, writeRelabeledImage(t, "linux", arch), "testing/manifest:latest")
+		allowed, err := pc.IsRunningImageAllowed(context.Background(), image)
+		assertRunningRejected(t, allowed, err)
+	}
+}
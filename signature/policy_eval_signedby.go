@@ -112,19 +112,23 @@ func (pr *prSignedBy) isRunningImageAllowed(ctx context.Context, image types.Unp
 		}
 		rejections = append(rejections, reason)
 	}
-	var summary error
+	return false, summarizeSignatureRejections(rejections)
+}
+
+// summarizeSignatureRejections returns an error describing why none of the signatures of an image were accepted,
+// given rejections, the reasons for rejecting the individual signatures.
+func summarizeSignatureRejections(rejections []error) error {
 	switch len(rejections) {
 	case 0:
-		summary = PolicyRequirementError("A signature was required, but no signature exists")
+		return PolicyRequirementError("A signature was required, but no signature exists")
 	case 1:
-		summary = rejections[0]
+		return rejections[0]
 	default:
 		var msgs []string
 		for _, e := range rejections {
 			msgs = append(msgs, e.Error())
 		}
-		summary = PolicyRequirementError(fmt.Sprintf("None of the signatures were accepted, reasons: %s",
+		return PolicyRequirementError(fmt.Sprintf("None of the signatures were accepted, reasons: %s",
 			strings.Join(msgs, "; ")))
 	}
-	return false, summary
 }
@@ -0,0 +1,316 @@
+// Policy evaluation for prSigstoreSigned.
+
+package signature
+
+import (
+	"context"
+	"crypto"
+	"crypto/x509"
+	"encoding/base64"
+	"encoding/json"
+	"fmt"
+	"os"
+	"time"
+
+	"github.com/containers/image/v5/internal/private"
+	"github.com/containers/image/v5/manifest"
+	"github.com/containers/image/v5/signature/sigstore"
+	"github.com/containers/image/v5/types"
+	"github.com/pkg/errors"
+)
+
+// PRSigstoreSignedFulcio contains Fulcio configuration options for a "sigstoreSigned" PolicyRequirement.
+// The type is public, but its definition is private.
+type PRSigstoreSignedFulcio interface {
+	// verifyCertificate verifies that certPEM, with untrusted intermediate certificates in chainPEM, was issued by
+	// a trusted Fulcio CA, was valid at signingTime, and certifies an accepted identity.
+	verifyCertificate(certPEM, chainPEM []byte, signingTime time.Time) error
+}
+
+// loadKeyData returns data if it is not nil, or the contents of path otherwise.
+func loadKeyData(path string, data []byte) ([]byte, error) {
+	if data != nil {
+		return data, nil
+	}
+	return os.ReadFile(path)
+}
+
+// publicKeys returns the trusted public keys of signers, or nil if signers are certified by Fulcio instead.
+func (pr *prSigstoreSigned) publicKeys() ([]crypto.PublicKey, error) {
+	if pr.KeyPath != "" && pr.KeyData != nil {
+		return nil, errors.New(`Internal inconsistency: both "keyPath" and "keyData" specified`)
+	}
+	if pr.Fulcio != nil {
+		if pr.KeyPath != "" || pr.KeyData != nil {
+			return nil, errors.New(`Internal inconsistency: both "fulcio" and a public key specified`)
+		}
+		return nil, nil
+	}
+	// FIXME: move this to per-context initialization
+	data, err := loadKeyData(pr.KeyPath, pr.KeyData)
+	if err != nil {
+		return nil, err
+	}
+	return sigstore.ParsePublicKeys(data)
+}
+
+// rekorOptions returns options for verifying Rekor entries of signatures, or nil if the signatures are not required
+// to be recorded in a Rekor log.
+func (pr *prSigstoreSigned) rekorOptions() (*sigstore.RekorVerificationOptions, error) {
+	if pr.RekorPublicKeyPath == "" && pr.RekorPublicKeyData == nil {
+		return nil, nil
+	}
+	if pr.RekorPublicKeyPath != "" && pr.RekorPublicKeyData != nil {
+		return nil, errors.New(`Internal inconsistency: both "rekorPublicKeyPath" and "rekorPublicKeyData" specified`)
+	}
+	// FIXME: move this to per-context initialization
+	data, err := loadKeyData(pr.RekorPublicKeyPath, pr.RekorPublicKeyData)
+	if err != nil {
+		return nil, err
+	}
+	keys, err := sigstore.ParsePublicKeys(data)
+	if err != nil {
+		return nil, errors.Wrap(err, "parsing Rekor public keys")
+	}
+	return &sigstore.RekorVerificationOptions{PublicKeys: keys, URL: pr.RekorURL}, nil
+}
+
+// timestampAuthorityCertificates returns the trusted CA certificates of timestamp authorities, or nil if the signatures
+// are not required to be timestamped.
+func (pr *prSigstoreSigned) timestampAuthorityCertificates() ([]*x509.Certificate, error) {
+	if pr.TimestampAuthorityCAPath == "" && pr.TimestampAuthorityCAData == nil {
+		return nil, nil
+	}
+	if pr.TimestampAuthorityCAPath != "" && pr.TimestampAuthorityCAData != nil {
+		return nil, errors.New(`Internal inconsistency: both "timestampAuthorityCAPath" and "timestampAuthorityCAData" specified`)
+	}
+	// FIXME: move this to per-context initialization
+	data, err := loadKeyData(pr.TimestampAuthorityCAPath, pr.TimestampAuthorityCAData)
+	if err != nil {
+		return nil, err
+	}
+	certs, err := sigstore.ParseCertificates(data)
+	if err != nil {
+		return nil, errors.Wrap(err, "parsing timestamp authority CA certificates")
+	}
+	if len(certs) == 0 {
+		return nil, errors.New("no timestamp authority CA certificates found")
+	}
+	return certs, nil
+}
+
+func (pr *prSigstoreSigned) isSignatureAuthorAccepted(ctx context.Context, image types.UnparsedImage, sig []byte) (signatureAcceptanceResult, *Signature, error) {
+	// The signatures passed here are simple signing signatures; sigstore signatures are only verified by isRunningImageAllowed.
+	return sarRejected, nil, PolicyRequirementError("sigstoreSigned requirements only accept sigstore signatures")
+}
+
+func (pr *prSigstoreSigned) isRunningImageAllowed(ctx context.Context, image types.UnparsedImage) (bool, error) {
+	reader, ok := image.(private.SigstoreSignaturesReader)
+	if !ok {
+		return false, PolicyRequirementError("A sigstore signature was required, but reading sigstore signatures of the image is not supported")
+	}
+	publicKeys, err := pr.publicKeys()
+	if err != nil {
+		return false, err
+	}
+	rekorOptions, err := pr.rekorOptions()
+	if err != nil {
+		return false, err
+	}
+	tsaCertificates, err := pr.timestampAuthorityCertificates()
+	if err != nil {
+		return false, err
+	}
+	sigs, err := reader.UntrustedSigstoreSignatures(ctx)
+	if err != nil {
+		return false, err
+	}
+	var rejections []error
+	for _, s := range sigs {
+		if err := pr.verifySigstoreSignature(ctx, image, publicKeys, rekorOptions, tsaCertificates, s); err != nil {
+			rejections = append(rejections, err)
+			continue
+		}
+		// One accepted signature is enough.
+		return true, nil
+	}
+	return false, summarizeSignatureRejections(rejections)
+}
+
+// verifySigstoreSignature verifies that sig is a valid signature of image made by one of publicKeys,
+// recorded in the Rekor log described by rekorOptions if it is not nil, timestamped by an authority certified by
+// tsaCertificates if they are not nil, and accepted by pr.SignedIdentity.
+func (pr *prSigstoreSigned) verifySigstoreSignature(ctx context.Context, image types.UnparsedImage, publicKeys []crypto.PublicKey,
+	rekorOptions *sigstore.RekorVerificationOptions, tsaCertificates []*x509.Certificate, sig sigstore.Signature) error {
+	b64Sig, ok := sig.Annotations[sigstore.SignatureAnnotationKey]
+	if !ok {
+		return PolicyRequirementError(fmt.Sprintf("Signature annotation %s not found", sigstore.SignatureAnnotationKey))
+	}
+	rawSig, err := base64.StdEncoding.DecodeString(b64Sig)
+	if err != nil {
+		return PolicyRequirementError(fmt.Sprintf("Invalid signature annotation: %v", err))
+	}
+	var signerKey crypto.PublicKey
+	var untrustedCertificate *x509.Certificate
+	if pr.Fulcio != nil {
+		// The certificate is only trusted after verifying it against the Fulcio CA at the times of signing recorded
+		// in Rekor and in the timestamp, below; until then, it only provides a candidate signing key.
+		certPEM, ok := sig.Annotations[sigstore.CertificateAnnotationKey]
+		if !ok {
+			return PolicyRequirementError(fmt.Sprintf("Certificate annotation %s not found", sigstore.CertificateAnnotationKey))
+		}
+		certs, err := sigstore.ParseCertificates([]byte(certPEM))
+		if err != nil || len(certs) != 1 {
+			return PolicyRequirementError("Invalid certificate annotation")
+		}
+		untrustedCertificate = certs[0]
+		if err := sigstore.VerifySignature(untrustedCertificate.PublicKey, sig.Payload, rawSig); err != nil {
+			return PolicyRequirementError("Signature is not made by the key in its certificate")
+		}
+		signerKey = untrustedCertificate.PublicKey
+	} else {
+		for _, key := range publicKeys {
+			if sigstore.VerifySignature(key, sig.Payload, rawSig) == nil {
+				signerKey = key
+				break
+			}
+		}
+		if signerKey == nil {
+			return PolicyRequirementError("Signature is not made by a trusted key")
+		}
+	}
+
+	// Trusted times at which the signature already existed; the Fulcio certificate must be valid at all of them.
+	var signingTimes []time.Time
+	if rekorOptions != nil {
+		var bundle *sigstore.Bundle
+		if bundleJSON, ok := sig.Annotations[sigstore.BundleAnnotationKey]; ok {
+			bundle = &sigstore.Bundle{}
+			if err := json.Unmarshal([]byte(bundleJSON), bundle); err != nil {
+				return PolicyRequirementError(fmt.Sprintf("Invalid Rekor bundle annotation: %v", err))
+			}
+		}
+		signingTime, err := sigstore.VerifyRekorEntry(ctx, *rekorOptions, bundle, sig.Payload, rawSig, signerKey)
+		if err != nil {
+			return errors.Wrap(err, "verifying the Rekor entry of the signature")
+		}
+		signingTimes = append(signingTimes, signingTime)
+	}
+	if tsaCertificates != nil {
+		timestampJSON, ok := sig.Annotations[sigstore.TimestampAnnotationKey]
+		if !ok {
+			return PolicyRequirementError(fmt.Sprintf("Timestamp annotation %s not found", sigstore.TimestampAnnotationKey))
+		}
+		var timestamp sigstore.RFC3161Timestamp
+		if err := json.Unmarshal([]byte(timestampJSON), &timestamp); err != nil {
+			return PolicyRequirementError(fmt.Sprintf("Invalid timestamp annotation: %v", err))
+		}
+		signingTime, err := sigstore.VerifyRFC3161Timestamp(tsaCertificates, timestamp.SignedRFC3161Timestamp, rawSig)
+		if err != nil {
+			return PolicyRequirementError(fmt.Sprintf("Verifying the timestamp of the signature: %v", err))
+		}
+		signingTimes = append(signingTimes, signingTime)
+	}
+	if pr.Fulcio != nil {
+		if len(signingTimes) == 0 {
+			return errors.New(`Internal inconsistency: "fulcio" specified without a Rekor public key or a timestamp authority`)
+		}
+		for _, signingTime := range signingTimes {
+			// This parses the same certificate annotation as untrustedCertificate above, so a successful verification
+			// applies to signerKey.
+			if err := pr.Fulcio.verifyCertificate([]byte(sig.Annotations[sigstore.CertificateAnnotationKey]),
+				[]byte(sig.Annotations[sigstore.ChainAnnotationKey]), signingTime); err != nil {
+				return err
+			}
+		}
+	}
+
+	payload, err := sigstore.ParsePayload(sig.Payload)
+	if err != nil {
+		return PolicyRequirementError(err.Error())
+	}
+	m, _, err := image.Manifest(ctx)
+	if err != nil {
+		return err
+	}
+	digestMatches, err := manifest.MatchesDigest(m, payload.DockerManifestDigest)
+	if err != nil {
+		return err
+	}
+	if !digestMatches {
+		return PolicyRequirementError(fmt.Sprintf("Signature for digest %s does not match", payload.DockerManifestDigest))
+	}
+	if !pr.SignedIdentity.matchesDockerReference(image, payload.DockerReference) {
+		return PolicyRequirementError(fmt.Sprintf("Signature for identity %s is not accepted", payload.DockerReference))
+	}
+	return nil
+}
+
+// verifyCertificate verifies that certPEM, with untrusted intermediate certificates in chainPEM, was issued by
+// a trusted Fulcio CA, was valid at signingTime, and certifies an accepted identity.
+func (f *prSigstoreSignedFulcio) verifyCertificate(certPEM, chainPEM []byte, signingTime time.Time) error {
+	if f.CAPath != "" && f.CAData != nil {
+		return errors.New(`Internal inconsistency: both "caPath" and "caData" specified`)
+	}
+	// FIXME: move this to per-context initialization
+	caPEM, err := loadKeyData(f.CAPath, f.CAData)
+	if err != nil {
+		return err
+	}
+	caCertificates, err := sigstore.ParseCertificates(caPEM)
+	if err != nil {
+		return errors.Wrap(err, "parsing Fulcio CA certificates")
+	}
+	if len(caCertificates) == 0 {
+		return errors.New("no Fulcio CA certificates found")
+	}
+
+	cert, err := sigstore.VerifyFulcioCertificate(caCertificates, certPEM, chainPEM, signingTime)
+	if err != nil {
+		return PolicyRequirementError(err.Error())
+	}
+	identity, err := sigstore.CertificateIdentityFromCertificate(cert)
+	if err != nil {
+		return PolicyRequirementError(err.Error())
+	}
+	if err := matchCertificateValue("OIDC issuer", identity.Issuer, f.OIDCIssuer, f.OIDCIssuerRegexp); err != nil {
+		return err
+	}
+	if err := matchCertificateValue("subject", identity.Subject, f.Subject, f.SubjectRegexp); err != nil {
+		return err
+	}
+	for _, c := range []struct{ name, actual, expected string }{
+		{"GitHub workflow trigger", identity.GitHubWorkflowTrigger, f.GitHubWorkflowTrigger},
+		{"GitHub workflow SHA", identity.GitHubWorkflowSHA, f.GitHubWorkflowSHA},
+		{"GitHub workflow name", identity.GitHubWorkflowName, f.GitHubWorkflowName},
+		{"GitHub workflow repository", identity.GitHubWorkflowRepository, f.GitHubWorkflowRepository},
+		{"GitHub workflow ref", identity.GitHubWorkflowRef, f.GitHubWorkflowRef},
+	} {
+		if c.expected == "" {
+			continue
+		}
+		if err := matchCertificateValue(c.name, c.actual, c.expected, ""); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// matchCertificateValue verifies that value, a value named name in a certificate, is equal to expected if it is not "",
+// or matches the whole of expectedRegexp otherwise.
+func matchCertificateValue(name, value, expected, expectedRegexp string) error {
+	if expected != "" {
+		if value != expected {
+			return PolicyRequirementError(fmt.Sprintf("Certificate %s %q does not match the expected %q", name, value, expected))
+		}
+		return nil
+	}
+	re, err := compileFullMatchRegexp(expectedRegexp)
+	if err != nil { // This should have been rejected when creating the requirement
+		return errors.Wrapf(err, "compiling the %s regexp", name)
+	}
+	if !re.MatchString(value) {
+		return PolicyRequirementError(fmt.Sprintf("Certificate %s %q does not match %q", name, value, expectedRegexp))
+	}
+	return nil
+}
@@ -0,0 +1,571 @@
+package signature
+
+import (
+	"context"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/sha256"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/asn1"
+	"encoding/base64"
+	"encoding/hex"
+	"encoding/json"
+	"encoding/pem"
+	"math/big"
+	"net/url"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/containers/image/v5/docker/reference"
+	"github.com/containers/image/v5/internal/testing/tsa"
+	"github.com/containers/image/v5/signature/sigstore"
+	"github.com/containers/image/v5/types"
+	"github.com/opencontainers/go-digest"
+	"github.com/stretchr/testify/require"
+)
+
+// sigstoreImageMock is a types.UnparsedImage with a manifest and sigstore signatures.
+type sigstoreImageMock struct {
+	ref      types.ImageReference
+	manifest []byte
+	sigs     []sigstore.Signature
+}
+
+func (i *sigstoreImageMock) Reference() types.ImageReference {
+	return i.ref
+}
+func (i *sigstoreImageMock) Manifest(ctx context.Context) ([]byte, string, error) {
+	return i.manifest, "", nil
+}
+func (i *sigstoreImageMock) Signatures(ctx context.Context) ([][]byte, error) {
+	return nil, nil
+}
+func (i *sigstoreImageMock) UntrustedSigstoreSignatures(ctx context.Context) ([]sigstore.Signature, error) {
+	return i.sigs, nil
+}
+
+// newSigstoreImageMock returns a sigstoreImageMock claiming dockerReference, with sigs.
+func newSigstoreImageMock(t *testing.T, dockerReference string, manifest []byte, sigs ...sigstore.Signature) *sigstoreImageMock {
+	ref, err := reference.ParseNormalizedNamed(dockerReference)
+	require.NoError(t, err)
+	return &sigstoreImageMock{ref: pcImageReferenceMock{"docker", ref}, manifest: manifest, sigs: sigs}
+}
+
+// testSigstoreKey returns a new private key and its PEM-encoded public key.
+func testSigstoreKey(t *testing.T) (*ecdsa.PrivateKey, []byte) {
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	require.NoError(t, err)
+	der, err := x509.MarshalPKIXPublicKey(&key.PublicKey)
+	require.NoError(t, err)
+	return key, pem.EncodeToMemory(&pem.Block{Type: "PUBLIC KEY", Bytes: der})
+}
+
+// testSigstoreSignature returns a sigstore signature of manifest claiming dockerReference, made by key.
+func testSigstoreSignature(t *testing.T, key *ecdsa.PrivateKey, manifest []byte, dockerReference string) sigstore.Signature {
+	der, err := x509.MarshalPKCS8PrivateKey(key)
+	require.NoError(t, err)
+	signer, err := sigstore.NewSignerFromPrivateKey(pem.EncodeToMemory(&pem.Block{Type: "PRIVATE KEY", Bytes: der}), nil)
+	require.NoError(t, err)
+	sig, err := signer.SignImage(context.Background(), manifest, dockerReference)
+	require.NoError(t, err)
+	return *sig
+}
+
+// withTestRekorBundle returns sig, with a Rekor bundle signed by rekorKey.
+func withTestRekorBundle(t *testing.T, sig sigstore.Signature, signerPublicKeyPEM []byte, rekorKey *ecdsa.PrivateKey) sigstore.Signature {
+	rawSig, err := base64.StdEncoding.DecodeString(sig.Annotations[sigstore.SignatureAnnotationKey])
+	require.NoError(t, err)
+	payloadDigest := sha256.Sum256(sig.Payload)
+	body, err := json.Marshal(map[string]interface{}{
+		"apiVersion": "0.0.1",
+		"kind":       "hashedrekord",
+		"spec": map[string]interface{}{
+			"data":      map[string]interface{}{"hash": map[string]string{"algorithm": "sha256", "value": hex.EncodeToString(payloadDigest[:])}},
+			"signature": map[string]interface{}{"content": rawSig, "publicKey": map[string][]byte{"content": signerPublicKeyPEM}},
+		},
+	})
+	require.NoError(t, err)
+	rekorKeyDER, err := x509.MarshalPKIXPublicKey(&rekorKey.PublicKey)
+	require.NoError(t, err)
+	logID := sha256.Sum256(rekorKeyDER)
+	bundle := sigstore.Bundle{Payload: sigstore.BundlePayload{
+		Body:           base64.StdEncoding.EncodeToString(body),
+		IntegratedTime: 1600000000,
+		LogIndex:       42,
+		LogID:          hex.EncodeToString(logID[:]),
+	}}
+	signed, err := json.Marshal(map[string]interface{}{
+		"body": bundle.Payload.Body, "integratedTime": bundle.Payload.IntegratedTime,
+		"logID": bundle.Payload.LogID, "logIndex": bundle.Payload.LogIndex,
+	})
+	require.NoError(t, err)
+	signedDigest := sha256.Sum256(signed)
+	bundle.SignedEntryTimestamp, err = ecdsa.SignASN1(rand.Reader, rekorKey, signedDigest[:])
+	require.NoError(t, err)
+	bundleJSON, err := json.Marshal(bundle)
+	require.NoError(t, err)
+
+	res := sigstore.Signature{Payload: sig.Payload, Annotations: map[string]string{}}
+	for k, v := range sig.Annotations {
+		res.Annotations[k] = v
+	}
+	res.Annotations[sigstore.BundleAnnotationKey] = string(bundleJSON)
+	return res
+}
+
+func TestPRSigstoreSignedIsSignatureAuthorAccepted(t *testing.T) {
+	// Simple signing signatures are never accepted.
+	_, keyPEM := testSigstoreKey(t)
+	pr := xNewPRSigstoreSigned(PRSigstoreSignedWithKeyData(keyPEM), PRSigstoreSignedWithSignedIdentity(NewPRMMatchRepoDigestOrExact()))
+	testImage := dirImageMock(t, "fixtures/dir-img-valid", "testing/manifest:latest")
+	testImageSig, err := os.ReadFile("fixtures/dir-img-valid/signature-1")
+	require.NoError(t, err)
+	sar, parsedSig, err := pr.isSignatureAuthorAccepted(context.Background(), testImage, testImageSig)
+	assertSARRejectedPolicyRequirement(t, sar, parsedSig, err)
+}
+
+func TestPRSigstoreSignedIsRunningImageAllowed(t *testing.T) {
+	ctx := context.Background()
+	manifest := []byte(`{"schemaVersion":2,"mediaType":"application/vnd.oci.image.manifest.v1+json"}`)
+	const dockerReference = "example.com/repo:tag"
+	key, keyPEM := testSigstoreKey(t)
+	otherKey, otherKeyPEM := testSigstoreKey(t)
+	prm := NewPRMMatchRepoDigestOrExact()
+	sig := testSigstoreSignature(t, key, manifest, dockerReference)
+
+	// Successful verification, with KeyData and KeyPath
+	pr := xNewPRSigstoreSigned(PRSigstoreSignedWithKeyData(keyPEM), PRSigstoreSignedWithSignedIdentity(prm))
+	allowed, err := pr.isRunningImageAllowed(ctx, newSigstoreImageMock(t, dockerReference, manifest, sig))
+	assertRunningAllowed(t, allowed, err)
+	keyPath := filepath.Join(t.TempDir(), "cosign.pub")
+	require.NoError(t, os.WriteFile(keyPath, keyPEM, 0644))
+	pr = xNewPRSigstoreSigned(PRSigstoreSignedWithKeyPath(keyPath), PRSigstoreSignedWithSignedIdentity(prm))
+	allowed, err = pr.isRunningImageAllowed(ctx, newSigstoreImageMock(t, dockerReference, manifest, sig))
+	assertRunningAllowed(t, allowed, err)
+
+	// Multiple trusted keys
+	pr = xNewPRSigstoreSigned(PRSigstoreSignedWithKeyData(append(otherKeyPEM, keyPEM...)), PRSigstoreSignedWithSignedIdentity(prm))
+	allowed, err = pr.isRunningImageAllowed(ctx, newSigstoreImageMock(t, dockerReference, manifest, sig))
+	assertRunningAllowed(t, allowed, err)
+
+	// One of multiple signatures is valid
+	pr = xNewPRSigstoreSigned(PRSigstoreSignedWithKeyData(keyPEM), PRSigstoreSignedWithSignedIdentity(prm))
+	otherSig := testSigstoreSignature(t, otherKey, manifest, dockerReference)
+	allowed, err = pr.isRunningImageAllowed(ctx, newSigstoreImageMock(t, dockerReference, manifest, otherSig, sig))
+	assertRunningAllowed(t, allowed, err)
+
+	// Various rejections
+	noSignatureAnnotation := sigstore.Signature{Payload: sig.Payload, Annotations: map[string]string{}}
+	invalidSignatureAnnotation := sigstore.Signature{Payload: sig.Payload, Annotations: map[string]string{sigstore.SignatureAnnotationKey: "&"}}
+	for _, c := range []struct {
+		name     string
+		manifest []byte
+		ref      string
+		sigs     []sigstore.Signature
+	}{
+		{"no signatures", manifest, dockerReference, nil},
+		{"untrusted key", manifest, dockerReference, []sigstore.Signature{otherSig}},
+		{"no signature annotation", manifest, dockerReference, []sigstore.Signature{noSignatureAnnotation}},
+		{"invalid signature annotation", manifest, dockerReference, []sigstore.Signature{invalidSignatureAnnotation}},
+		{"different manifest", []byte(`{"schemaVersion":2}`), dockerReference, []sigstore.Signature{sig}},
+		{"different identity", manifest, "example.com/repo:other", []sigstore.Signature{sig}},
+		{"multiple invalid signatures", manifest, dockerReference, []sigstore.Signature{otherSig, noSignatureAnnotation}},
+	} {
+		allowed, err := pr.isRunningImageAllowed(ctx, newSigstoreImageMock(t, c.ref, c.manifest, c.sigs...))
+		assertRunningRejectedPolicyRequirement(t, allowed, err)
+	}
+
+	// Key loading failures
+	for _, invalidPR := range []PolicyRequirement{
+		xNewPRSigstoreSigned(PRSigstoreSignedWithKeyPath(filepath.Join(t.TempDir(), "missing")), PRSigstoreSignedWithSignedIdentity(prm)),
+		xNewPRSigstoreSigned(PRSigstoreSignedWithKeyData([]byte("not a key")), PRSigstoreSignedWithSignedIdentity(prm)),
+		xNewPRSigstoreSigned(PRSigstoreSignedWithKeyData(keyPEM), PRSigstoreSignedWithRekorPublicKeyData([]byte("not a key")),
+			PRSigstoreSignedWithSignedIdentity(prm)),
+	} {
+		allowed, err := invalidPR.isRunningImageAllowed(ctx, newSigstoreImageMock(t, dockerReference, manifest, sig))
+		assertRunningRejected(t, allowed, err)
+	}
+
+	// Images which can't contain sigstore signatures
+	ref, err := reference.ParseNormalizedNamed(dockerReference)
+	require.NoError(t, err)
+	allowed, err = pr.isRunningImageAllowed(ctx, refImageMock{ref})
+	assertRunningRejectedPolicyRequirement(t, allowed, err)
+}
+
+func TestPRSigstoreSignedIsRunningImageAllowedRekor(t *testing.T) {
+	ctx := context.Background()
+	manifest := []byte(`{"schemaVersion":2,"mediaType":"application/vnd.oci.image.manifest.v1+json"}`)
+	const dockerReference = "example.com/repo:tag"
+	key, keyPEM := testSigstoreKey(t)
+	rekorKey, rekorKeyPEM := testSigstoreKey(t)
+	otherRekorKey, otherRekorKeyPEM := testSigstoreKey(t)
+	prm := NewPRMMatchRepoDigestOrExact()
+	sig := testSigstoreSignature(t, key, manifest, dockerReference)
+	sigWithBundle := withTestRekorBundle(t, sig, keyPEM, rekorKey)
+
+	// Offline verification of the bundle
+	pr := xNewPRSigstoreSigned(PRSigstoreSignedWithKeyData(keyPEM), PRSigstoreSignedWithRekorPublicKeyData(rekorKeyPEM),
+		PRSigstoreSignedWithSignedIdentity(prm))
+	allowed, err := pr.isRunningImageAllowed(ctx, newSigstoreImageMock(t, dockerReference, manifest, sigWithBundle))
+	assertRunningAllowed(t, allowed, err)
+	// Multiple trusted Rekor keys
+	rekorKeyPath := filepath.Join(t.TempDir(), "rekor.pub")
+	require.NoError(t, os.WriteFile(rekorKeyPath, append(otherRekorKeyPEM, rekorKeyPEM...), 0644))
+	pr2 := xNewPRSigstoreSigned(PRSigstoreSignedWithKeyData(keyPEM), PRSigstoreSignedWithRekorPublicKeyPath(rekorKeyPath),
+		PRSigstoreSignedWithSignedIdentity(prm))
+	allowed, err = pr2.isRunningImageAllowed(ctx, newSigstoreImageMock(t, dockerReference, manifest, sigWithBundle))
+	assertRunningAllowed(t, allowed, err)
+
+	invalidBundle := withTestRekorBundle(t, sig, keyPEM, rekorKey)
+	invalidBundle.Annotations[sigstore.BundleAnnotationKey] = "this is invalid"
+	for _, c := range []struct {
+		name string
+		sig  sigstore.Signature
+	}{
+		{"no bundle", sig},
+		{"invalid bundle", invalidBundle},
+		{"untrusted log", withTestRekorBundle(t, sig, keyPEM, otherRekorKey)},
+		{"different signing key", withTestRekorBundle(t, sig, otherRekorKeyPEM, rekorKey)},
+	} {
+		allowed, err := pr.isRunningImageAllowed(ctx, newSigstoreImageMock(t, dockerReference, manifest, c.sig))
+		assertRunningRejected(t, allowed, err)
+	}
+
+	// The bundle does not change the other checks
+	allowed, err = pr.isRunningImageAllowed(ctx, newSigstoreImageMock(t, "example.com/repo:other", manifest, sigWithBundle))
+	assertRunningRejectedPolicyRequirement(t, allowed, err)
+	_, otherKeyPEM := testSigstoreKey(t)
+	pr = xNewPRSigstoreSigned(PRSigstoreSignedWithKeyData(otherKeyPEM), PRSigstoreSignedWithRekorPublicKeyData(rekorKeyPEM),
+		PRSigstoreSignedWithSignedIdentity(prm))
+	allowed, err = pr.isRunningImageAllowed(ctx, newSigstoreImageMock(t, dockerReference, manifest, sigWithBundle))
+	assertRunningRejectedPolicyRequirement(t, allowed, err)
+}
+
+// testFulcioCA returns a new Fulcio-like root CA certificate and its private key.
+func testFulcioCA(t *testing.T) (*x509.Certificate, *ecdsa.PrivateKey) {
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	require.NoError(t, err)
+	template := &x509.Certificate{
+		SerialNumber:          big.NewInt(1),
+		Subject:               pkix.Name{CommonName: "test Fulcio root"},
+		NotBefore:             time.Unix(1500000000, 0),
+		NotAfter:              time.Unix(1700000000, 0),
+		IsCA:                  true,
+		BasicConstraintsValid: true,
+		KeyUsage:              x509.KeyUsageCertSign,
+	}
+	der, err := x509.CreateCertificate(rand.Reader, template, template, &key.PublicKey, key)
+	require.NoError(t, err)
+	cert, err := x509.ParseCertificate(der)
+	require.NoError(t, err)
+	return cert, key
+}
+
+// testFulcioSignature returns a sigstore signature of manifest claiming dockerReference, made by a key certified by ca
+// using template (which defaults to a certificate for user@example.com issued by https://issuer.example.com if nil),
+// with a Rekor bundle signed by rekorKey.
+func testFulcioSignature(t *testing.T, ca *x509.Certificate, caKey *ecdsa.PrivateKey, template *x509.Certificate,
+	rekorKey *ecdsa.PrivateKey, manifest []byte, dockerReference string) sigstore.Signature {
+	if template == nil {
+		template = testFulcioLeafTemplate(pkix.Extension{Id: asn1.ObjectIdentifier{1, 3, 6, 1, 4, 1, 57264, 1, 1}, Value: []byte("https://issuer.example.com")})
+	}
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	require.NoError(t, err)
+	der, err := x509.CreateCertificate(rand.Reader, template, ca, &key.PublicKey, caKey)
+	require.NoError(t, err)
+	certPEM := pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: der})
+
+	sig := testSigstoreSignature(t, key, manifest, dockerReference)
+	sig.Annotations[sigstore.CertificateAnnotationKey] = string(certPEM)
+	sig.Annotations[sigstore.ChainAnnotationKey] = string(pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: ca.Raw}))
+	return withTestRekorBundle(t, sig, certPEM, rekorKey)
+}
+
+// testFulcioLeafTemplate returns a template for a certificate for user@example.com, valid at the time recorded by withTestRekorBundle,
+// with extensions.
+func testFulcioLeafTemplate(extensions ...pkix.Extension) *x509.Certificate {
+	return &x509.Certificate{
+		SerialNumber:    big.NewInt(2),
+		NotBefore:       time.Unix(1600000000-60, 0),
+		NotAfter:        time.Unix(1600000000+540, 0),
+		KeyUsage:        x509.KeyUsageDigitalSignature,
+		ExtKeyUsage:     []x509.ExtKeyUsage{x509.ExtKeyUsageCodeSigning},
+		EmailAddresses:  []string{"user@example.com"},
+		ExtraExtensions: extensions,
+	}
+}
+
+func TestPRSigstoreSignedIsRunningImageAllowedFulcio(t *testing.T) {
+	ctx := context.Background()
+	manifest := []byte(`{"schemaVersion":2,"mediaType":"application/vnd.oci.image.manifest.v1+json"}`)
+	const dockerReference = "example.com/repo:tag"
+	ca, caKey := testFulcioCA(t)
+	caPEM := pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: ca.Raw})
+	otherCA, otherCAKey := testFulcioCA(t)
+	rekorKey, rekorKeyPEM := testSigstoreKey(t)
+	prm := NewPRMMatchRepoDigestOrExact()
+	newPR := func(fulcioOptions ...PRSigstoreSignedFulcioOption) PolicyRequirement {
+		return xNewPRSigstoreSigned(PRSigstoreSignedWithFulcio(xNewPRSigstoreSignedFulcio(fulcioOptions...)),
+			PRSigstoreSignedWithRekorPublicKeyData(rekorKeyPEM), PRSigstoreSignedWithSignedIdentity(prm))
+	}
+
+	sig := testFulcioSignature(t, ca, caKey, nil, rekorKey, manifest, dockerReference)
+	workflowURL, err := url.Parse("https://github.com/org/repo/.github/workflows/release.yml@refs/heads/main")
+	require.NoError(t, err)
+	githubTemplate := testFulcioLeafTemplate(
+		pkix.Extension{Id: asn1.ObjectIdentifier{1, 3, 6, 1, 4, 1, 57264, 1, 1}, Value: []byte("https://token.actions.githubusercontent.com")},
+		pkix.Extension{Id: asn1.ObjectIdentifier{1, 3, 6, 1, 4, 1, 57264, 1, 2}, Value: []byte("push")},
+		pkix.Extension{Id: asn1.ObjectIdentifier{1, 3, 6, 1, 4, 1, 57264, 1, 5}, Value: []byte("org/repo")},
+		pkix.Extension{Id: asn1.ObjectIdentifier{1, 3, 6, 1, 4, 1, 57264, 1, 6}, Value: []byte("refs/heads/main")},
+	)
+	githubTemplate.EmailAddresses = nil
+	githubTemplate.URIs = []*url.URL{workflowURL}
+	githubSig := testFulcioSignature(t, ca, caKey, githubTemplate, rekorKey, manifest, dockerReference)
+
+	// Successful verification, with caData and caPath
+	pr := newPR(PRSigstoreSignedFulcioWithCAData(caPEM), PRSigstoreSignedFulcioWithOIDCIssuer("https://issuer.example.com"),
+		PRSigstoreSignedFulcioWithSubject("user@example.com"))
+	allowed, err := pr.isRunningImageAllowed(ctx, newSigstoreImageMock(t, dockerReference, manifest, sig))
+	assertRunningAllowed(t, allowed, err)
+	caPath := filepath.Join(t.TempDir(), "fulcio.pem")
+	require.NoError(t, os.WriteFile(caPath, caPEM, 0644))
+	allowed, err = newPR(PRSigstoreSignedFulcioWithCAPath(caPath), PRSigstoreSignedFulcioWithOIDCIssuerRegexp(`https://issuer\.example\.com`),
+		PRSigstoreSignedFulcioWithSubjectRegexp(`.*@example\.com`)).isRunningImageAllowed(ctx, newSigstoreImageMock(t, dockerReference, manifest, sig))
+	assertRunningAllowed(t, allowed, err)
+	// Multiple trusted CAs
+	allowed, err = newPR(PRSigstoreSignedFulcioWithCAData(append(pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: otherCA.Raw}), caPEM...)),
+		PRSigstoreSignedFulcioWithOIDCIssuer("https://issuer.example.com"), PRSigstoreSignedFulcioWithSubject("user@example.com")).
+		isRunningImageAllowed(ctx, newSigstoreImageMock(t, dockerReference, manifest, sig))
+	assertRunningAllowed(t, allowed, err)
+	// A specific GitHub Actions workflow
+	githubPR := newPR(PRSigstoreSignedFulcioWithCAData(caPEM), PRSigstoreSignedFulcioWithOIDCIssuer("https://token.actions.githubusercontent.com"),
+		PRSigstoreSignedFulcioWithSubjectRegexp(`https://github\.com/org/repo/\.github/workflows/release\.yml@.*`),
+		PRSigstoreSignedFulcioWithGitHubWorkflowTrigger("push"), PRSigstoreSignedFulcioWithGitHubWorkflowRepository("org/repo"),
+		PRSigstoreSignedFulcioWithGitHubWorkflowRef("refs/heads/main"))
+	allowed, err = githubPR.isRunningImageAllowed(ctx, newSigstoreImageMock(t, dockerReference, manifest, githubSig))
+	assertRunningAllowed(t, allowed, err)
+
+	// Identity mismatches
+	for _, c := range []struct {
+		name string
+		pr   PolicyRequirement
+		sig  sigstore.Signature
+	}{
+		{"different subject", newPR(PRSigstoreSignedFulcioWithCAData(caPEM), PRSigstoreSignedFulcioWithOIDCIssuer("https://issuer.example.com"),
+			PRSigstoreSignedFulcioWithSubject("other@example.com")), sig},
+		{"different issuer", newPR(PRSigstoreSignedFulcioWithCAData(caPEM), PRSigstoreSignedFulcioWithOIDCIssuer("https://other.example.com"),
+			PRSigstoreSignedFulcioWithSubject("user@example.com")), sig},
+		{"subject regexp matches only a prefix", newPR(PRSigstoreSignedFulcioWithCAData(caPEM), PRSigstoreSignedFulcioWithOIDCIssuer("https://issuer.example.com"),
+			PRSigstoreSignedFulcioWithSubjectRegexp("user")), sig},
+		{"issuer regexp mismatch", newPR(PRSigstoreSignedFulcioWithCAData(caPEM), PRSigstoreSignedFulcioWithOIDCIssuerRegexp(`https://.*\.githubusercontent\.com`),
+			PRSigstoreSignedFulcioWithSubject("user@example.com")), sig},
+		{"GitHub claims not present", newPR(PRSigstoreSignedFulcioWithCAData(caPEM), PRSigstoreSignedFulcioWithOIDCIssuer("https://issuer.example.com"),
+			PRSigstoreSignedFulcioWithSubject("user@example.com"), PRSigstoreSignedFulcioWithGitHubWorkflowRepository("org/repo")), sig},
+		{"different GitHub repository", newPR(PRSigstoreSignedFulcioWithCAData(caPEM),
+			PRSigstoreSignedFulcioWithOIDCIssuer("https://token.actions.githubusercontent.com"), PRSigstoreSignedFulcioWithSubjectRegexp(".*"),
+			PRSigstoreSignedFulcioWithGitHubWorkflowRepository("org/other")), githubSig},
+		{"different GitHub ref", newPR(PRSigstoreSignedFulcioWithCAData(caPEM),
+			PRSigstoreSignedFulcioWithOIDCIssuer("https://token.actions.githubusercontent.com"), PRSigstoreSignedFulcioWithSubjectRegexp(".*"),
+			PRSigstoreSignedFulcioWithGitHubWorkflowRef("refs/tags/v1.0")), githubSig},
+		{"different GitHub trigger", newPR(PRSigstoreSignedFulcioWithCAData(caPEM),
+			PRSigstoreSignedFulcioWithOIDCIssuer("https://token.actions.githubusercontent.com"), PRSigstoreSignedFulcioWithSubjectRegexp(".*"),
+			PRSigstoreSignedFulcioWithGitHubWorkflowTrigger("pull_request")), githubSig},
+	} {
+		allowed, err := c.pr.isRunningImageAllowed(ctx, newSigstoreImageMock(t, dockerReference, manifest, c.sig))
+		assertRunningRejectedPolicyRequirement(t, allowed, err)
+	}
+
+	// Invalid signatures
+	noCertificate := sigstore.Signature{Payload: sig.Payload, Annotations: map[string]string{}}
+	invalidCertificate := sigstore.Signature{Payload: sig.Payload, Annotations: map[string]string{}}
+	noBundle := sigstore.Signature{Payload: sig.Payload, Annotations: map[string]string{}}
+	for k, v := range sig.Annotations {
+		noCertificate.Annotations[k] = v
+		invalidCertificate.Annotations[k] = v
+		noBundle.Annotations[k] = v
+	}
+	delete(noCertificate.Annotations, sigstore.CertificateAnnotationKey)
+	invalidCertificate.Annotations[sigstore.CertificateAnnotationKey] = "this is invalid"
+	delete(noBundle.Annotations, sigstore.BundleAnnotationKey)
+	// A signature made by a different key than the certified one
+	otherKey, _ := testSigstoreKey(t)
+	wrongKey := testSigstoreSignature(t, otherKey, manifest, dockerReference)
+	wrongKey.Annotations[sigstore.CertificateAnnotationKey] = sig.Annotations[sigstore.CertificateAnnotationKey]
+	expiredTemplate := testFulcioLeafTemplate(pkix.Extension{Id: asn1.ObjectIdentifier{1, 3, 6, 1, 4, 1, 57264, 1, 1}, Value: []byte("https://issuer.example.com")})
+	expiredTemplate.NotBefore = time.Unix(1600000000-1200, 0)
+	expiredTemplate.NotAfter = time.Unix(1600000000-600, 0)
+	for _, c := range []struct {
+		name string
+		sig  sigstore.Signature
+	}{
+		{"untrusted CA", testFulcioSignature(t, otherCA, otherCAKey, nil, rekorKey, manifest, dockerReference)},
+		{"expired at the time of signing", testFulcioSignature(t, ca, caKey, expiredTemplate, rekorKey, manifest, dockerReference)},
+		{"no certificate", noCertificate},
+		{"invalid certificate", invalidCertificate},
+		{"signed by a different key", wrongKey},
+	} {
+		allowed, err := pr.isRunningImageAllowed(ctx, newSigstoreImageMock(t, dockerReference, manifest, c.sig))
+		assertRunningRejectedPolicyRequirement(t, allowed, err)
+	}
+	for _, c := range []struct {
+		name string
+		sig  sigstore.Signature
+	}{
+		{"no Rekor bundle", noBundle},
+		{"untrusted Rekor log", testFulcioSignature(t, ca, caKey, nil, otherCAKey, manifest, dockerReference)},
+	} {
+		allowed, err := pr.isRunningImageAllowed(ctx, newSigstoreImageMock(t, dockerReference, manifest, c.sig))
+		assertRunningRejected(t, allowed, err)
+	}
+
+	// The certificate does not change the other checks
+	allowed, err = pr.isRunningImageAllowed(ctx, newSigstoreImageMock(t, "example.com/repo:other", manifest, sig))
+	assertRunningRejectedPolicyRequirement(t, allowed, err)
+
+	// CA loading failures
+	for _, invalidPR := range []PolicyRequirement{
+		newPR(PRSigstoreSignedFulcioWithCAPath(filepath.Join(t.TempDir(), "missing")), PRSigstoreSignedFulcioWithOIDCIssuer("https://issuer.example.com"),
+			PRSigstoreSignedFulcioWithSubject("user@example.com")),
+		newPR(PRSigstoreSignedFulcioWithCAData(rekorKeyPEM), PRSigstoreSignedFulcioWithOIDCIssuer("https://issuer.example.com"),
+			PRSigstoreSignedFulcioWithSubject("user@example.com")),
+		newPR(PRSigstoreSignedFulcioWithCAData([]byte("no certificates")), PRSigstoreSignedFulcioWithOIDCIssuer("https://issuer.example.com"),
+			PRSigstoreSignedFulcioWithSubject("user@example.com")),
+	} {
+		allowed, err := invalidPR.isRunningImageAllowed(ctx, newSigstoreImageMock(t, dockerReference, manifest, sig))
+		assertRunningRejected(t, allowed, err)
+	}
+}
+
+// withTestTimestamp returns sig, with a timestamp issued by authority.
+func withTestTimestamp(t *testing.T, sig sigstore.Signature, authority *tsa.Authority) sigstore.Signature {
+	rawSig, err := base64.StdEncoding.DecodeString(sig.Annotations[sigstore.SignatureAnnotationKey])
+	require.NoError(t, err)
+	token, err := authority.Token(rawSig, tsa.TokenOptions{})
+	require.NoError(t, err)
+	timestampJSON, err := json.Marshal(sigstore.RFC3161Timestamp{SignedRFC3161Timestamp: token})
+	require.NoError(t, err)
+
+	res := sigstore.Signature{Payload: sig.Payload, Annotations: map[string]string{}}
+	for k, v := range sig.Annotations {
+		res.Annotations[k] = v
+	}
+	res.Annotations[sigstore.TimestampAnnotationKey] = string(timestampJSON)
+	return res
+}
+
+func TestPRSigstoreSignedIsRunningImageAllowedTimestamp(t *testing.T) {
+	ctx := context.Background()
+	manifest := []byte(`{"schemaVersion":2,"mediaType":"application/vnd.oci.image.manifest.v1+json"}`)
+	const dockerReference = "example.com/repo:tag"
+	ca, caKey := testFulcioCA(t)
+	caPEM := pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: ca.Raw})
+	rekorKey, rekorKeyPEM := testSigstoreKey(t)
+	// The time recorded by withTestRekorBundle, long after the CA certificate of the timestamp authority would
+	// have expired if it were created for the current time.
+	authority, err := tsa.New(time.Unix(1600000000, 0), x509.ExtKeyUsageTimeStamping)
+	require.NoError(t, err)
+	otherAuthority, err := tsa.New(time.Unix(1600000000, 0), x509.ExtKeyUsageTimeStamping)
+	require.NoError(t, err)
+	prm := NewPRMMatchRepoDigestOrExact()
+	fulcio := xNewPRSigstoreSignedFulcio(PRSigstoreSignedFulcioWithCAData(caPEM),
+		PRSigstoreSignedFulcioWithOIDCIssuer("https://issuer.example.com"), PRSigstoreSignedFulcioWithSubject("user@example.com"))
+
+	sig := withTestTimestamp(t, testFulcioSignature(t, ca, caKey, nil, rekorKey, manifest, dockerReference), authority)
+
+	// Fulcio, with a timestamp instead of a Rekor entry, with timestampAuthorityCAData and timestampAuthorityCAPath
+	pr := xNewPRSigstoreSigned(PRSigstoreSignedWithFulcio(fulcio), PRSigstoreSignedWithTimestampAuthorityCAData(authority.CAPEM),
+		PRSigstoreSignedWithSignedIdentity(prm))
+	allowed, err := pr.isRunningImageAllowed(ctx, newSigstoreImageMock(t, dockerReference, manifest, sig))
+	assertRunningAllowed(t, allowed, err)
+	tsaCAPath := filepath.Join(t.TempDir(), "tsa.pem")
+	require.NoError(t, os.WriteFile(tsaCAPath, authority.CAPEM, 0644))
+	allowed, err = xNewPRSigstoreSigned(PRSigstoreSignedWithFulcio(fulcio), PRSigstoreSignedWithTimestampAuthorityCAPath(tsaCAPath),
+		PRSigstoreSignedWithSignedIdentity(prm)).isRunningImageAllowed(ctx, newSigstoreImageMock(t, dockerReference, manifest, sig))
+	assertRunningAllowed(t, allowed, err)
+	// Both a Rekor entry and a timestamp
+	allowed, err = xNewPRSigstoreSigned(PRSigstoreSignedWithFulcio(fulcio), PRSigstoreSignedWithRekorPublicKeyData(rekorKeyPEM),
+		PRSigstoreSignedWithTimestampAuthorityCAData(authority.CAPEM), PRSigstoreSignedWithSignedIdentity(prm)).
+		isRunningImageAllowed(ctx, newSigstoreImageMock(t, dockerReference, manifest, sig))
+	assertRunningAllowed(t, allowed, err)
+	// A timestamp of a signature made by a public key
+	key, keyPEM := testSigstoreKey(t)
+	keySig := testSigstoreSignature(t, key, manifest, dockerReference)
+	keyPR := xNewPRSigstoreSigned(PRSigstoreSignedWithKeyData(keyPEM), PRSigstoreSignedWithTimestampAuthorityCAData(authority.CAPEM),
+		PRSigstoreSignedWithSignedIdentity(prm))
+	allowed, err = keyPR.isRunningImageAllowed(ctx, newSigstoreImageMock(t, dockerReference, manifest, withTestTimestamp(t, keySig, authority)))
+	assertRunningAllowed(t, allowed, err)
+	allowed, err = keyPR.isRunningImageAllowed(ctx, newSigstoreImageMock(t, dockerReference, manifest, keySig))
+	assertRunningRejectedPolicyRequirement(t, allowed, err)
+
+	// Invalid timestamps
+	noTimestamp := sigstore.Signature{Payload: sig.Payload, Annotations: map[string]string{}}
+	invalidTimestamp := sigstore.Signature{Payload: sig.Payload, Annotations: map[string]string{}}
+	for k, v := range sig.Annotations {
+		noTimestamp.Annotations[k] = v
+		invalidTimestamp.Annotations[k] = v
+	}
+	delete(noTimestamp.Annotations, sigstore.TimestampAnnotationKey)
+	invalidTimestamp.Annotations[sigstore.TimestampAnnotationKey] = "this is invalid"
+	// A timestamp of a different signature
+	otherSig := testFulcioSignature(t, ca, caKey, nil, rekorKey, manifest, dockerReference)
+	otherSig.Annotations[sigstore.TimestampAnnotationKey] = sig.Annotations[sigstore.TimestampAnnotationKey]
+	for _, c := range []struct {
+		name string
+		sig  sigstore.Signature
+	}{
+		{"no timestamp", noTimestamp},
+		{"invalid timestamp", invalidTimestamp},
+		{"timestamp of a different signature", otherSig},
+		{"untrusted timestamp authority", withTestTimestamp(t, sig, otherAuthority)},
+	} {
+		allowed, err := pr.isRunningImageAllowed(ctx, newSigstoreImageMock(t, dockerReference, manifest, c.sig))
+		assertRunningRejectedPolicyRequirement(t, allowed, err)
+	}
+
+	// The certificate must be valid at the time of the timestamp
+	authority.Time = time.Unix(1600000000+3600, 0)
+	expiredSig := withTestTimestamp(t, testFulcioSignature(t, ca, caKey, nil, rekorKey, manifest, dockerReference), authority)
+	allowed, err = pr.isRunningImageAllowed(ctx, newSigstoreImageMock(t, dockerReference, manifest, expiredSig))
+	assertRunningRejectedPolicyRequirement(t, allowed, err)
+
+	// CA loading failures
+	for _, invalidPR := range []PolicyRequirement{
+		xNewPRSigstoreSigned(PRSigstoreSignedWithFulcio(fulcio), PRSigstoreSignedWithTimestampAuthorityCAPath(filepath.Join(t.TempDir(), "missing")),
+			PRSigstoreSignedWithSignedIdentity(prm)),
+		xNewPRSigstoreSigned(PRSigstoreSignedWithFulcio(fulcio), PRSigstoreSignedWithTimestampAuthorityCAData(rekorKeyPEM),
+			PRSigstoreSignedWithSignedIdentity(prm)),
+		xNewPRSigstoreSigned(PRSigstoreSignedWithFulcio(fulcio), PRSigstoreSignedWithTimestampAuthorityCAData([]byte("no certificates")),
+			PRSigstoreSignedWithSignedIdentity(prm)),
+	} {
+		allowed, err := invalidPR.isRunningImageAllowed(ctx, newSigstoreImageMock(t, dockerReference, manifest, sig))
+		assertRunningRejected(t, allowed, err)
+	}
+}
+
+func TestPolicyContextIsRunningImageAllowedSigstore(t *testing.T) {
+	manifest := []byte(`{"schemaVersion":2,"mediaType":"application/vnd.oci.image.manifest.v1+json"}`)
+	key, keyPEM := testSigstoreKey(t)
+	policy, err := NewPolicyFromBytes([]byte(`{"default":[{"type":"sigstoreSigned","keyData":"` +
+		base64.StdEncoding.EncodeToString(keyPEM) + `"}]}`))
+	require.NoError(t, err)
+	pc, err := NewPolicyContext(policy)
+	require.NoError(t, err)
+	defer func() {
+		err := pc.Destroy()
+		require.NoError(t, err)
+	}()
+
+	sig := testSigstoreSignature(t, key, manifest, "example.com/repo:tag")
+	allowed, err := pc.IsRunningImageAllowed(context.Background(), newSigstoreImageMock(t, "example.com/repo:tag", manifest, sig))
+	assertRunningAllowed(t, allowed, err)
+	canonical := "example.com/repo@" + digest.FromBytes(manifest).String()
+	allowed, err = pc.IsRunningImageAllowed(context.Background(), newSigstoreImageMock(t, canonical, manifest, sig))
+	assertRunningAllowed(t, allowed, err)
+	allowed, err = pc.IsRunningImageAllowed(context.Background(), newSigstoreImageMock(t, "example.com/other:tag", manifest, sig))
+	assertRunningRejectedPolicyRequirement(t, allowed, err)
+}
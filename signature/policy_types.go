@@ -46,6 +46,8 @@ const (
 	prTypeReject                 prTypeIdentifier = "reject"
 	prTypeSignedBy               prTypeIdentifier = "signedBy"
 	prTypeSignedBaseLayer        prTypeIdentifier = "signedBaseLayer"
+	prTypeSigstoreSigned         prTypeIdentifier = "sigstoreSigned"
+	prTypePlatformSpecific       prTypeIdentifier = "platformSpecific"
 )
 
 // prInsecureAcceptAnything is a PolicyRequirement with type = prTypeInsecureAcceptAnything:
@@ -104,6 +106,100 @@ type prSignedBaseLayer struct {
 	BaseLayerIdentity PolicyReferenceMatch `json:"baseLayerIdentity"`
 }
 
+// prSigstoreSigned is a PolicyRequirement with type = prTypeSigstoreSigned: the image is signed by trusted keys for a specified identity,
+// using sigstore signatures, optionally recorded in a trusted Rekor transparency log.
+type prSigstoreSigned struct {
+	prCommon
+
+	// KeyPath is a pathname to a local file containing the trusted key(s).
+	// Exactly one of KeyPath, KeyData and Fulcio must be specified.
+	KeyPath string `json:"keyPath,omitempty"`
+	// KeyData contains the trusted key(s), base64-encoded.
+	// Exactly one of KeyPath, KeyData and Fulcio must be specified.
+	KeyData []byte `json:"keyData,omitempty"`
+	// Fulcio specifies that signatures must be made by ephemeral keys certified by a trusted Fulcio CA,
+	// for an identity accepted by Fulcio. Requires RekorPublicKeyPath, RekorPublicKeyData, TimestampAuthorityCAPath
+	// or TimestampAuthorityCAData, which provide a trusted time of signing.
+	// Exactly one of KeyPath, KeyData and Fulcio must be specified.
+	Fulcio PRSigstoreSignedFulcio `json:"fulcio,omitempty"`
+
+	// RekorPublicKeyPath is a pathname to a local file containing the trusted public key(s) of a Rekor log.
+	// At most one of RekorPublicKeyPath and RekorPublicKeyData may be specified; if either is, signatures
+	// must be recorded in that log.
+	RekorPublicKeyPath string `json:"rekorPublicKeyPath,omitempty"`
+	// RekorPublicKeyData contains the trusted public key(s) of a Rekor log, base64-encoded.
+	// At most one of RekorPublicKeyPath and RekorPublicKeyData may be specified.
+	RekorPublicKeyData []byte `json:"rekorPublicKeyData,omitempty"`
+	// RekorURL, if not "", is the URL of the Rekor log, which is contacted to verify an inclusion proof of the signature.
+	// If "", signatures must include a Rekor bundle, which is verified offline.
+	// Requires RekorPublicKeyPath or RekorPublicKeyData.
+	RekorURL string `json:"rekorURL,omitempty"`
+
+	// TimestampAuthorityCAPath is a path to a file containing the trusted CA certificate(s) of RFC 3161 timestamp authorities,
+	// PEM-encoded. At most one of TimestampAuthorityCAPath and TimestampAuthorityCAData may be specified; if either is,
+	// signatures must include a timestamp issued by such an authority.
+	TimestampAuthorityCAPath string `json:"timestampAuthorityCAPath,omitempty"`
+	// TimestampAuthorityCAData contains the trusted CA certificate(s) of RFC 3161 timestamp authorities, PEM-encoded
+	// and base64-encoded. At most one of TimestampAuthorityCAPath and TimestampAuthorityCAData may be specified.
+	TimestampAuthorityCAData []byte `json:"timestampAuthorityCAData,omitempty"`
+
+	// SignedIdentity specifies what image identity the signature must be claiming about the image.
+	// Defaults to "matchRepoDigestOrExact" if not specified.
+	SignedIdentity PolicyReferenceMatch `json:"signedIdentity"`
+}
+
+// PRSigstoreSignedFulcio contains Fulcio configuration options for a "sigstoreSigned" PolicyRequirement.
+// The type is public, but its definition is private.
+
+// prSigstoreSignedFulcio collects Fulcio configuration options for prSigstoreSigned.
+// All of the specified identity matchers must match the certificate.
+type prSigstoreSignedFulcio struct {
+	// CAPath is a path to a file containing the trusted Fulcio CA certificate(s), PEM-encoded.
+	// Exactly one of CAPath and CAData must be specified.
+	CAPath string `json:"caPath,omitempty"`
+	// CAData contains the trusted Fulcio CA certificate(s), PEM-encoded and base64-encoded.
+	// Exactly one of CAPath and CAData must be specified.
+	CAData []byte `json:"caData,omitempty"`
+	// OIDCIssuer is the required issuer of the OIDC identity token used to obtain the certificate.
+	// Exactly one of OIDCIssuer and OIDCIssuerRegexp must be specified.
+	OIDCIssuer string `json:"oidcIssuer,omitempty"`
+	// OIDCIssuerRegexp is a regular expression which must match the whole OIDC issuer.
+	// Exactly one of OIDCIssuer and OIDCIssuerRegexp must be specified.
+	OIDCIssuerRegexp string `json:"oidcIssuerRegexp,omitempty"`
+	// Subject is the required subject alternative name (an email address or URI) of the certificate.
+	// Exactly one of Subject and SubjectRegexp must be specified.
+	Subject string `json:"subject,omitempty"`
+	// SubjectRegexp is a regular expression which must match the whole subject alternative name of the certificate.
+	// Exactly one of Subject and SubjectRegexp must be specified.
+	SubjectRegexp string `json:"subjectRegexp,omitempty"`
+	// GitHubWorkflowTrigger, if not "", is the required event that triggered the GitHub Actions workflow.
+	GitHubWorkflowTrigger string `json:"githubWorkflowTrigger,omitempty"`
+	// GitHubWorkflowSHA, if not "", is the required commit SHA of the GitHub Actions workflow run.
+	GitHubWorkflowSHA string `json:"githubWorkflowSHA,omitempty"`
+	// GitHubWorkflowName, if not "", is the required name of the GitHub Actions workflow.
+	GitHubWorkflowName string `json:"githubWorkflowName,omitempty"`
+	// GitHubWorkflowRepository, if not "", is the required repository ("org/repo") of the GitHub Actions workflow.
+	GitHubWorkflowRepository string `json:"githubWorkflowRepository,omitempty"`
+	// GitHubWorkflowRef, if not "", is the required git ref (e.g. "refs/heads/main") of the GitHub Actions workflow run.
+	GitHubWorkflowRef string `json:"githubWorkflowRef,omitempty"`
+}
+
+// prPlatformSpecific is a PolicyRequirement with type = prTypePlatformSpecific: the image must satisfy the requirements
+// for the platform it is intended for, e.g. to allow different requirements for the instances of a multi-platform image.
+type prPlatformSpecific struct {
+	prCommon
+
+	// Platforms maps platforms, in the "os/architecture" or "os/architecture/variant" format, to requirements
+	// for images intended for that platform. An "os/architecture/variant" entry takes precedence over an
+	// "os/architecture" entry, which applies to all variants.
+	// Must not be empty.
+	Platforms map[string]PolicyRequirements `json:"platforms"`
+	// Default applies to instances of manifest lists chosen for platforms which don't match any entry in Platforms.
+	// Images not chosen from a manifest list for a known platform must satisfy Default and all entries of Platforms.
+	// If empty, all such images are rejected.
+	Default PolicyRequirements `json:"default,omitempty"`
+}
+
 // PolicyReferenceMatch specifies a set of image identities accepted in PolicyRequirement.
 // The type is public, but its implementation is private.
 
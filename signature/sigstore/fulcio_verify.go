@@ -0,0 +1,144 @@
+package sigstore
+
+import (
+	"crypto/x509"
+	"encoding/asn1"
+	"encoding/pem"
+	"time"
+
+	"github.com/pkg/errors"
+)
+
+// Object identifiers of certificate extensions used by Fulcio to record claims of the OIDC identity token.
+var (
+	oidFulcioIssuer                   = asn1.ObjectIdentifier{1, 3, 6, 1, 4, 1, 57264, 1, 1} // Deprecated, raw string
+	oidFulcioGitHubWorkflowTrigger    = asn1.ObjectIdentifier{1, 3, 6, 1, 4, 1, 57264, 1, 2}
+	oidFulcioGitHubWorkflowSHA        = asn1.ObjectIdentifier{1, 3, 6, 1, 4, 1, 57264, 1, 3}
+	oidFulcioGitHubWorkflowName       = asn1.ObjectIdentifier{1, 3, 6, 1, 4, 1, 57264, 1, 4}
+	oidFulcioGitHubWorkflowRepository = asn1.ObjectIdentifier{1, 3, 6, 1, 4, 1, 57264, 1, 5}
+	oidFulcioGitHubWorkflowRef        = asn1.ObjectIdentifier{1, 3, 6, 1, 4, 1, 57264, 1, 6}
+	oidFulcioIssuerV2                 = asn1.ObjectIdentifier{1, 3, 6, 1, 4, 1, 57264, 1, 8} // DER-encoded UTF8String
+)
+
+// ParseCertificates returns the certificates in pemData, which contains zero or more PEM-encoded certificates.
+func ParseCertificates(pemData []byte) ([]*x509.Certificate, error) {
+	var res []*x509.Certificate
+	rest := pemData
+	for {
+		var block *pem.Block
+		block, rest = pem.Decode(rest)
+		if block == nil {
+			break
+		}
+		if block.Type != "CERTIFICATE" {
+			return nil, errors.Errorf("unexpected PEM block type %q, expected a certificate", block.Type)
+		}
+		cert, err := x509.ParseCertificate(block.Bytes)
+		if err != nil {
+			return nil, errors.Wrap(err, "parsing certificate")
+		}
+		res = append(res, cert)
+	}
+	return res, nil
+}
+
+// VerifyFulcioCertificate verifies that the single PEM-encoded certificate in certPEM was issued for code signing by one
+// of the certificate authorities in caCertificates (which may include intermediate certificates), possibly via intermediate
+// certificates in chainPEM (which is not trusted), and that it was valid at signingTime; and returns the certificate.
+// signingTime should be a trusted time, typically the time the signature was recorded in Rekor; certificates issued by Fulcio
+// are only valid for a few minutes.
+func VerifyFulcioCertificate(caCertificates []*x509.Certificate, certPEM, chainPEM []byte, signingTime time.Time) (*x509.Certificate, error) {
+	certs, err := ParseCertificates(certPEM)
+	if err != nil {
+		return nil, err
+	}
+	if len(certs) != 1 {
+		return nil, errors.Errorf("expected a single signing certificate, got %d", len(certs))
+	}
+	chain, err := ParseCertificates(chainPEM)
+	if err != nil {
+		return nil, errors.Wrap(err, "parsing the certificate chain")
+	}
+
+	roots := x509.NewCertPool()
+	intermediates := x509.NewCertPool()
+	for _, ca := range caCertificates {
+		if isSelfSigned(ca) {
+			roots.AddCert(ca)
+		} else {
+			intermediates.AddCert(ca)
+		}
+	}
+	for _, c := range chain {
+		intermediates.AddCert(c)
+	}
+	if _, err := certs[0].Verify(x509.VerifyOptions{
+		Roots:         roots,
+		Intermediates: intermediates,
+		CurrentTime:   signingTime,
+		KeyUsages:     []x509.ExtKeyUsage{x509.ExtKeyUsageCodeSigning},
+	}); err != nil {
+		return nil, errors.Wrap(err, "verifying the signing certificate")
+	}
+	return certs[0], nil
+}
+
+// isSelfSigned returns true if cert is a self-signed certificate.
+func isSelfSigned(cert *x509.Certificate) bool {
+	return cert.CheckSignatureFrom(cert) == nil
+}
+
+// CertificateIdentity is the identity certified by a Fulcio certificate, based on an OIDC identity token.
+type CertificateIdentity struct {
+	Subject string // The email address or URI in the subject alternative name
+	Issuer  string // The issuer of the OIDC identity token
+	// Claims of GitHub Actions identity tokens; "" if not present.
+	GitHubWorkflowTrigger    string
+	GitHubWorkflowSHA        string
+	GitHubWorkflowName       string
+	GitHubWorkflowRepository string
+	GitHubWorkflowRef        string
+}
+
+// CertificateIdentityFromCertificate returns the identity certified by cert, a certificate issued by Fulcio.
+// The caller is responsible for verifying the certificate first, e.g. using VerifyFulcioCertificate.
+func CertificateIdentityFromCertificate(cert *x509.Certificate) (*CertificateIdentity, error) {
+	var res CertificateIdentity
+	switch {
+	case len(cert.EmailAddresses) != 0:
+		res.Subject = cert.EmailAddresses[0]
+	case len(cert.URIs) != 0:
+		res.Subject = cert.URIs[0].String()
+	default:
+		return nil, errors.New("the certificate does not contain an email address or URI subject alternative name")
+	}
+	for _, ext := range cert.Extensions {
+		switch {
+		case ext.Id.Equal(oidFulcioIssuerV2):
+			var issuer string
+			rest, err := asn1.UnmarshalWithParams(ext.Value, &issuer, "utf8")
+			if err != nil || len(rest) != 0 {
+				return nil, errors.New("invalid OIDC issuer extension in the certificate")
+			}
+			res.Issuer = issuer
+		case ext.Id.Equal(oidFulcioIssuer):
+			if res.Issuer == "" { // The V2 extension takes precedence
+				res.Issuer = string(ext.Value)
+			}
+		case ext.Id.Equal(oidFulcioGitHubWorkflowTrigger):
+			res.GitHubWorkflowTrigger = string(ext.Value)
+		case ext.Id.Equal(oidFulcioGitHubWorkflowSHA):
+			res.GitHubWorkflowSHA = string(ext.Value)
+		case ext.Id.Equal(oidFulcioGitHubWorkflowName):
+			res.GitHubWorkflowName = string(ext.Value)
+		case ext.Id.Equal(oidFulcioGitHubWorkflowRepository):
+			res.GitHubWorkflowRepository = string(ext.Value)
+		case ext.Id.Equal(oidFulcioGitHubWorkflowRef):
+			res.GitHubWorkflowRef = string(ext.Value)
+		}
+	}
+	if res.Issuer == "" {
+		return nil, errors.New("the certificate does not specify an OIDC issuer")
+	}
+	return &res, nil
+}
@@ -0,0 +1,181 @@
+package sigstore
+
+import (
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/asn1"
+	"encoding/pem"
+	"math/big"
+	"net/url"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// testCertificate creates a certificate based on template, signed by parent using parentKey (or self-signed if parent is nil),
+// and returns the certificate, its PEM encoding, and its private key.
+func testCertificate(t *testing.T, template, parent *x509.Certificate, parentKey *ecdsa.PrivateKey) (*x509.Certificate, []byte, *ecdsa.PrivateKey) {
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	require.NoError(t, err)
+	if parent == nil {
+		parent = template
+		parentKey = key
+	}
+	der, err := x509.CreateCertificate(rand.Reader, template, parent, &key.PublicKey, parentKey)
+	require.NoError(t, err)
+	cert, err := x509.ParseCertificate(der)
+	require.NoError(t, err)
+	return cert, pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: der}), key
+}
+
+// testCATemplate returns a template for a CA certificate valid around now.
+func testCATemplate(serial int64, name string) *x509.Certificate {
+	return &x509.Certificate{
+		SerialNumber:          big.NewInt(serial),
+		Subject:               pkix.Name{CommonName: name},
+		NotBefore:             time.Now().Add(-time.Hour),
+		NotAfter:              time.Now().Add(time.Hour),
+		IsCA:                  true,
+		BasicConstraintsValid: true,
+		KeyUsage:              x509.KeyUsageCertSign,
+	}
+}
+
+// testLeafTemplate returns a template for a Fulcio-like code signing certificate valid for 10 minutes from notBefore.
+func testLeafTemplate(notBefore time.Time, extensions ...pkix.Extension) *x509.Certificate {
+	return &x509.Certificate{
+		SerialNumber:    big.NewInt(100),
+		NotBefore:       notBefore,
+		NotAfter:        notBefore.Add(10 * time.Minute),
+		KeyUsage:        x509.KeyUsageDigitalSignature,
+		ExtKeyUsage:     []x509.ExtKeyUsage{x509.ExtKeyUsageCodeSigning},
+		EmailAddresses:  []string{"user@example.com"},
+		ExtraExtensions: extensions,
+	}
+}
+
+func TestParseCertificates(t *testing.T) {
+	cert1, cert1PEM, _ := testCertificate(t, testCATemplate(1, "one"), nil, nil)
+	cert2, cert2PEM, _ := testCertificate(t, testCATemplate(2, "two"), nil, nil)
+
+	certs, err := ParseCertificates(nil)
+	require.NoError(t, err)
+	assert.Empty(t, certs)
+	certs, err = ParseCertificates(append(append([]byte{}, cert1PEM...), cert2PEM...))
+	require.NoError(t, err)
+	assert.Equal(t, []*x509.Certificate{cert1, cert2}, certs)
+
+	for _, c := range [][]byte{
+		pem.EncodeToMemory(&pem.Block{Type: "PUBLIC KEY", Bytes: cert1.RawSubjectPublicKeyInfo}),
+		pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: []byte("not DER")}),
+	} {
+		_, err := ParseCertificates(c)
+		assert.Error(t, err)
+	}
+}
+
+func TestVerifyFulcioCertificate(t *testing.T) {
+	root, _, rootKey := testCertificate(t, testCATemplate(1, "root"), nil, nil)
+	intermediate, intermediatePEM, intermediateKey := testCertificate(t, testCATemplate(2, "intermediate"), root, rootKey)
+	otherRoot, _, _ := testCertificate(t, testCATemplate(3, "other root"), nil, nil)
+	signingTime := time.Now().Add(-30 * time.Minute)
+	leaf, leafPEM, _ := testCertificate(t, testLeafTemplate(signingTime.Add(-time.Minute)), intermediate, intermediateKey)
+
+	// Success, with the intermediate in the untrusted chain or among the CA certificates
+	cert, err := VerifyFulcioCertificate([]*x509.Certificate{root}, leafPEM, intermediatePEM, signingTime)
+	require.NoError(t, err)
+	assert.Equal(t, leaf, cert)
+	cert, err = VerifyFulcioCertificate([]*x509.Certificate{otherRoot, root, intermediate}, leafPEM, nil, signingTime)
+	require.NoError(t, err)
+	assert.Equal(t, leaf, cert)
+	// Trusting only the intermediate is not enough
+	_, err = VerifyFulcioCertificate([]*x509.Certificate{intermediate}, leafPEM, nil, signingTime)
+	assert.Error(t, err)
+
+	serverTemplate := testLeafTemplate(signingTime.Add(-time.Minute))
+	serverTemplate.ExtKeyUsage = []x509.ExtKeyUsage{x509.ExtKeyUsageServerAuth}
+	_, serverPEM, _ := testCertificate(t, serverTemplate, intermediate, intermediateKey)
+	for _, c := range []struct {
+		name        string
+		ca          []*x509.Certificate
+		cert, chain []byte
+		signingTime time.Time
+	}{
+		{"missing intermediate", []*x509.Certificate{root}, leafPEM, nil, signingTime},
+		{"untrusted root", []*x509.Certificate{otherRoot}, leafPEM, intermediatePEM, signingTime},
+		{"signed after expiry", []*x509.Certificate{root}, leafPEM, intermediatePEM, signingTime.Add(20 * time.Minute)},
+		{"signed before issuance", []*x509.Certificate{root}, leafPEM, intermediatePEM, signingTime.Add(-2 * time.Minute)},
+		{"not for code signing", []*x509.Certificate{root}, serverPEM, intermediatePEM, signingTime},
+		{"no certificate", []*x509.Certificate{root}, nil, intermediatePEM, signingTime},
+		{"multiple certificates", []*x509.Certificate{root}, append(append([]byte{}, leafPEM...), leafPEM...), intermediatePEM, signingTime},
+		{"invalid certificate", []*x509.Certificate{root}, []byte("-----BEGIN CERTIFICATE-----\nAAAA\n-----END CERTIFICATE-----\n"), intermediatePEM, signingTime},
+		{"invalid chain", []*x509.Certificate{root}, leafPEM, []byte("-----BEGIN PUBLIC KEY-----\nAAAA\n-----END PUBLIC KEY-----\n"), signingTime},
+	} {
+		_, err := VerifyFulcioCertificate(c.ca, c.cert, c.chain, c.signingTime)
+		assert.Error(t, err, c.name)
+	}
+}
+
+func TestCertificateIdentityFromCertificate(t *testing.T) {
+	root, _, rootKey := testCertificate(t, testCATemplate(1, "root"), nil, nil)
+	issuerV2, err := asn1.MarshalWithParams("https://issuer.example.com", "utf8")
+	require.NoError(t, err)
+	workflowURL, err := url.Parse("https://github.com/org/repo/.github/workflows/release.yml@refs/tags/v1.0")
+	require.NoError(t, err)
+
+	emailTemplate := testLeafTemplate(time.Now(), pkix.Extension{Id: oidFulcioIssuerV2, Value: issuerV2})
+	uriTemplate := testLeafTemplate(time.Now(),
+		pkix.Extension{Id: oidFulcioIssuer, Value: []byte("https://token.actions.githubusercontent.com")},
+		pkix.Extension{Id: oidFulcioGitHubWorkflowTrigger, Value: []byte("push")},
+		pkix.Extension{Id: oidFulcioGitHubWorkflowSHA, Value: []byte("0123456789abcdef0123456789abcdef01234567")},
+		pkix.Extension{Id: oidFulcioGitHubWorkflowName, Value: []byte("Release")},
+		pkix.Extension{Id: oidFulcioGitHubWorkflowRepository, Value: []byte("org/repo")},
+		pkix.Extension{Id: oidFulcioGitHubWorkflowRef, Value: []byte("refs/tags/v1.0")},
+	)
+	uriTemplate.EmailAddresses = nil
+	uriTemplate.URIs = []*url.URL{workflowURL}
+	// The V2 issuer extension takes precedence over the deprecated one, regardless of order.
+	bothIssuersTemplate := testLeafTemplate(time.Now(),
+		pkix.Extension{Id: oidFulcioIssuerV2, Value: issuerV2},
+		pkix.Extension{Id: oidFulcioIssuer, Value: []byte("https://v1.example.com")},
+	)
+	for _, c := range []struct {
+		template *x509.Certificate
+		expected CertificateIdentity
+	}{
+		{emailTemplate, CertificateIdentity{Subject: "user@example.com", Issuer: "https://issuer.example.com"}},
+		{uriTemplate, CertificateIdentity{
+			Subject:                  workflowURL.String(),
+			Issuer:                   "https://token.actions.githubusercontent.com",
+			GitHubWorkflowTrigger:    "push",
+			GitHubWorkflowSHA:        "0123456789abcdef0123456789abcdef01234567",
+			GitHubWorkflowName:       "Release",
+			GitHubWorkflowRepository: "org/repo",
+			GitHubWorkflowRef:        "refs/tags/v1.0",
+		}},
+		{bothIssuersTemplate, CertificateIdentity{Subject: "user@example.com", Issuer: "https://issuer.example.com"}},
+	} {
+		cert, _, _ := testCertificate(t, c.template, root, rootKey)
+		identity, err := CertificateIdentityFromCertificate(cert)
+		require.NoError(t, err)
+		assert.Equal(t, &c.expected, identity)
+	}
+
+	noSubjectTemplate := testLeafTemplate(time.Now(), pkix.Extension{Id: oidFulcioIssuerV2, Value: issuerV2})
+	noSubjectTemplate.EmailAddresses = nil
+	noSubjectTemplate.DNSNames = []string{"example.com"}
+	for _, template := range []*x509.Certificate{
+		noSubjectTemplate,
+		testLeafTemplate(time.Now()), // No issuer
+		testLeafTemplate(time.Now(), pkix.Extension{Id: oidFulcioIssuerV2, Value: []byte("not DER")}),
+	} {
+		cert, _, _ := testCertificate(t, template, root, rootKey)
+		_, err := CertificateIdentityFromCertificate(cert)
+		assert.Error(t, err)
+	}
+}
@@ -0,0 +1,197 @@
+package sigstore
+
+import (
+	"bytes"
+	"context"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/sha256"
+	"crypto/x509"
+	"encoding/json"
+	"encoding/pem"
+	"io"
+	"net/http"
+	"net/url"
+
+	"github.com/pkg/errors"
+)
+
+// DefaultFulcioURL is the URL of the public Fulcio instance operated by the sigstore project.
+const DefaultFulcioURL = "https://fulcio.sigstore.dev"
+
+// KeylessOptions configure NewKeylessSigner.
+type KeylessOptions struct {
+	// IdentityToken provides the OIDC identity which is certified by Fulcio, and recorded in the signatures.
+	// E.g. InteractiveIdentityToken for users, or AmbientIdentityToken() for workloads.
+	IdentityToken IdentityTokenProvider
+	FulcioURL     string       // If "", DefaultFulcioURL is used
+	RekorURL      string       // If "", DefaultRekorURL is used
+	HTTPClient    *http.Client // Used to contact Fulcio and Rekor; if nil, http.DefaultClient is used
+}
+
+// NewKeylessSigner returns a Signer using an ephemeral private key, certified by Fulcio for the identity provided by
+// options.IdentityToken; signatures created by it are recorded in Rekor, so that they can be verified after the
+// short-lived certificate expires.  The signatures are compatible with "cosign sign" without a key.
+//
+// The certificate is only valid for a few minutes, so the Signer should be created immediately before signing.
+func NewKeylessSigner(ctx context.Context, options KeylessOptions) (*Signer, error) {
+	if options.IdentityToken == nil {
+		return nil, errors.New("keyless signing requires an identity token provider")
+	}
+	fulcioURL, rekorURL := options.FulcioURL, options.RekorURL
+	if fulcioURL == "" {
+		fulcioURL = DefaultFulcioURL
+	}
+	if rekorURL == "" {
+		rekorURL = DefaultRekorURL
+	}
+	fulcio, err := newFulcioClient(fulcioURL, options.HTTPClient)
+	if err != nil {
+		return nil, err
+	}
+	rekor, err := newRekorClient(rekorURL, options.HTTPClient)
+	if err != nil {
+		return nil, err
+	}
+
+	token, err := options.IdentityToken.IdentityToken(ctx)
+	if err != nil {
+		return nil, errors.Wrap(err, "obtaining an identity token")
+	}
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		return nil, errors.Wrap(err, "generating an ephemeral key")
+	}
+	certificate, chain, err := fulcio.signingCertificate(ctx, token, key)
+	if err != nil {
+		return nil, errors.Wrap(err, "obtaining a signing certificate from Fulcio")
+	}
+	return &Signer{key: key, certificate: certificate, chain: chain, rekor: rekor}, nil
+}
+
+// fulcioClient obtains certificates from a Fulcio certificate authority.
+type fulcioClient struct {
+	url        *url.URL
+	httpClient *http.Client
+}
+
+// newFulcioClient returns a fulcioClient for the Fulcio instance at fulcioURL.
+func newFulcioClient(fulcioURL string, httpClient *http.Client) (*fulcioClient, error) {
+	u, err := url.Parse(fulcioURL)
+	if err != nil {
+		return nil, errors.Wrapf(err, "parsing Fulcio URL %q", fulcioURL)
+	}
+	if u.Scheme != "https" && u.Scheme != "http" {
+		return nil, errors.Errorf("unsupported Fulcio URL %q", fulcioURL)
+	}
+	if httpClient == nil {
+		httpClient = http.DefaultClient
+	}
+	return &fulcioClient{url: u, httpClient: httpClient}, nil
+}
+
+// fulcioSigningCertificateRequest is a request of the Fulcio v2 signingCert API.
+type fulcioSigningCertificateRequest struct {
+	Credentials struct {
+		OIDCIdentityToken string `json:"oidcIdentityToken"`
+	} `json:"credentials"`
+	PublicKeyRequest struct {
+		PublicKey struct {
+			Algorithm string `json:"algorithm"`
+			Content   string `json:"content"` // PEM-encoded
+		} `json:"publicKey"`
+		ProofOfPossession []byte `json:"proofOfPossession"`
+	} `json:"publicKeyRequest"`
+}
+
+// fulcioCertificateChain is a certificate chain in responses of the Fulcio v2 signingCert API.
+type fulcioCertificateChain struct {
+	Chain struct {
+		Certificates []string `json:"certificates"` // PEM-encoded, the leaf certificate first
+	} `json:"chain"`
+}
+
+// fulcioSigningCertificateResponse is a response of the Fulcio v2 signingCert API.
+type fulcioSigningCertificateResponse struct {
+	SignedCertificateEmbeddedSct *fulcioCertificateChain `json:"signedCertificateEmbeddedSct"`
+	SignedCertificateDetachedSct *fulcioCertificateChain `json:"signedCertificateDetachedSct"`
+}
+
+// signingCertificate returns a PEM-encoded certificate of the public key of key for the identity in token,
+// and the PEM-encoded rest of the certificate chain.
+func (c *fulcioClient) signingCertificate(ctx context.Context, token string, key *ecdsa.PrivateKey) ([]byte, []byte, error) {
+	claims, err := parseIdentityToken(token)
+	if err != nil {
+		return nil, nil, err
+	}
+	subject, err := claims.subject()
+	if err != nil {
+		return nil, nil, err
+	}
+	subjectDigest := sha256.Sum256([]byte(subject))
+	proof, err := ecdsa.SignASN1(rand.Reader, key, subjectDigest[:])
+	if err != nil {
+		return nil, nil, errors.Wrap(err, "signing the proof of possession")
+	}
+	publicKeyDER, err := x509.MarshalPKIXPublicKey(&key.PublicKey)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	var request fulcioSigningCertificateRequest
+	request.Credentials.OIDCIdentityToken = token
+	request.PublicKeyRequest.PublicKey.Algorithm = "ECDSA"
+	request.PublicKeyRequest.PublicKey.Content = string(pem.EncodeToMemory(&pem.Block{Type: "PUBLIC KEY", Bytes: publicKeyDER}))
+	request.PublicKeyRequest.ProofOfPossession = proof
+	body, err := json.Marshal(request)
+	if err != nil {
+		return nil, nil, err
+	}
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, c.url.ResolveReference(&url.URL{Path: "/api/v2/signingCert"}).String(), bytes.NewReader(body))
+	if err != nil {
+		return nil, nil, err
+	}
+	req.Header.Set("Content-Type", "application/json")
+	req.Header.Set("Accept", "application/json")
+	res, err := c.httpClient.Do(req)
+	if err != nil {
+		return nil, nil, err
+	}
+	defer res.Body.Close()
+	if res.StatusCode != http.StatusOK && res.StatusCode != http.StatusCreated {
+		return nil, nil, serviceError("Fulcio", res)
+	}
+	var response fulcioSigningCertificateResponse
+	if err := json.NewDecoder(io.LimitReader(res.Body, maxResponseSize)).Decode(&response); err != nil {
+		return nil, nil, errors.Wrap(err, "parsing Fulcio response")
+	}
+	chain := response.SignedCertificateEmbeddedSct
+	if chain == nil {
+		chain = response.SignedCertificateDetachedSct
+	}
+	if chain == nil || len(chain.Chain.Certificates) == 0 {
+		return nil, nil, errors.New("Fulcio response does not include a certificate")
+	}
+
+	certificate := []byte(chain.Chain.Certificates[0])
+	block, _ := pem.Decode(certificate)
+	if block == nil || block.Type != "CERTIFICATE" {
+		return nil, nil, errors.New("Fulcio returned an invalid certificate")
+	}
+	parsed, err := x509.ParseCertificate(block.Bytes)
+	if err != nil {
+		return nil, nil, errors.Wrap(err, "parsing the certificate returned by Fulcio")
+	}
+	if certKey, ok := parsed.PublicKey.(*ecdsa.PublicKey); !ok || !certKey.Equal(&key.PublicKey) {
+		return nil, nil, errors.New("the certificate returned by Fulcio does not match the ephemeral key")
+	}
+	rest := bytes.Buffer{}
+	for _, c := range chain.Chain.Certificates[1:] {
+		rest.WriteString(c)
+		if len(c) != 0 && c[len(c)-1] != '\n' {
+			rest.WriteByte('\n')
+		}
+	}
+	return certificate, rest.Bytes(), nil
+}
@@ -0,0 +1,489 @@
+package sigstore
+
+import (
+	"context"
+	"crypto"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/sha256"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/asn1"
+	"encoding/base64"
+	"encoding/hex"
+	"encoding/json"
+	"encoding/pem"
+	"fmt"
+	"math/big"
+	"net/http"
+	"net/http/httptest"
+	"strconv"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// testIdentityToken returns an (unsigned) JWT with claims.
+func testIdentityToken(t *testing.T, claims map[string]interface{}) string {
+	header := base64.RawURLEncoding.EncodeToString([]byte(`{"alg":"RS256","typ":"JWT"}`))
+	payload, err := json.Marshal(claims)
+	require.NoError(t, err)
+	return header + "." + base64.RawURLEncoding.EncodeToString(payload) + ".c2lnbmF0dXJl"
+}
+
+// testFulcio is a fake Fulcio server.
+type testFulcio struct {
+	*httptest.Server
+	caKey  *ecdsa.PrivateKey
+	caCert *x509.Certificate
+	caPEM  []byte
+	mutex  sync.Mutex
+	// If not nil, wrongKey is certified instead of the requested key.
+	wrongKey *ecdsa.PrivateKey
+}
+
+func newTestFulcio(t *testing.T) *testFulcio {
+	caKey, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	require.NoError(t, err)
+	template := &x509.Certificate{
+		SerialNumber:          big.NewInt(1),
+		Subject:               pkix.Name{CommonName: "test Fulcio root"},
+		NotBefore:             time.Now().Add(-time.Hour),
+		NotAfter:              time.Now().Add(time.Hour),
+		IsCA:                  true,
+		BasicConstraintsValid: true,
+		KeyUsage:              x509.KeyUsageCertSign,
+	}
+	der, err := x509.CreateCertificate(rand.Reader, template, template, &caKey.PublicKey, caKey)
+	require.NoError(t, err)
+	caCert, err := x509.ParseCertificate(der)
+	require.NoError(t, err)
+	f := &testFulcio{
+		caKey:  caKey,
+		caCert: caCert,
+		caPEM:  pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: der}),
+	}
+	f.Server = httptest.NewServer(http.HandlerFunc(f.serveHTTP))
+	t.Cleanup(f.Close)
+	return f
+}
+
+func (f *testFulcio) serveHTTP(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost || r.URL.Path != "/api/v2/signingCert" {
+		http.NotFound(w, r)
+		return
+	}
+	var req fulcioSigningCertificateRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	claims, err := parseIdentityToken(req.Credentials.OIDCIdentityToken)
+	if err != nil || claims.Issuer != "https://issuer.example.com" {
+		w.WriteHeader(http.StatusUnauthorized)
+		fmt.Fprint(w, `{"code":16,"message":"invalid identity token"}`)
+		return
+	}
+	subject, err := claims.subject()
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	block, _ := pem.Decode([]byte(req.PublicKeyRequest.PublicKey.Content))
+	if block == nil {
+		http.Error(w, "invalid public key", http.StatusBadRequest)
+		return
+	}
+	publicKey, err := x509.ParsePKIXPublicKey(block.Bytes)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	subjectDigest := sha256.Sum256([]byte(subject))
+	if !ecdsa.VerifyASN1(publicKey.(*ecdsa.PublicKey), subjectDigest[:], req.PublicKeyRequest.ProofOfPossession) {
+		http.Error(w, "invalid proof of possession", http.StatusBadRequest)
+		return
+	}
+	f.mutex.Lock()
+	if f.wrongKey != nil {
+		publicKey = &f.wrongKey.PublicKey
+	}
+	f.mutex.Unlock()
+	issuerExtension, err := asn1.MarshalWithParams(claims.Issuer, "utf8")
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	template := &x509.Certificate{
+		SerialNumber:    big.NewInt(2),
+		NotBefore:       time.Now().Add(-time.Minute),
+		NotAfter:        time.Now().Add(10 * time.Minute),
+		KeyUsage:        x509.KeyUsageDigitalSignature,
+		ExtKeyUsage:     []x509.ExtKeyUsage{x509.ExtKeyUsageCodeSigning},
+		EmailAddresses:  []string{subject},
+		ExtraExtensions: []pkix.Extension{{Id: oidFulcioIssuerV2, Value: issuerExtension}},
+	}
+	der, err := x509.CreateCertificate(rand.Reader, template, f.caCert, publicKey, f.caKey)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	var res fulcioSigningCertificateResponse
+	res.SignedCertificateEmbeddedSct = &fulcioCertificateChain{}
+	res.SignedCertificateEmbeddedSct.Chain.Certificates = []string{
+		string(pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: der})),
+		string(f.caPEM),
+	}
+	w.WriteHeader(http.StatusCreated)
+	_ = json.NewEncoder(w).Encode(res)
+}
+
+// testRekor is a fake Rekor server.
+type testRekor struct {
+	*httptest.Server
+	key   *ecdsa.PrivateKey
+	logID string
+	mutex sync.Mutex
+	// entries are keyed by UUID; they don't include inclusion proofs, which are created on demand.
+	entries map[string]rekorLogEntry
+	uuids   []string // Ordered by log index
+	fail    bool
+	// If not nil, checkpoints are signed by checkpointKey instead of key.
+	checkpointKey *ecdsa.PrivateKey
+}
+
+func newTestRekor(t *testing.T) *testRekor {
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	require.NoError(t, err)
+	logID, err := rekorLogID(&key.PublicKey)
+	require.NoError(t, err)
+	r := &testRekor{key: key, logID: logID, entries: map[string]rekorLogEntry{}}
+	r.Server = httptest.NewServer(http.HandlerFunc(r.serveHTTP))
+	t.Cleanup(r.Close)
+	return r
+}
+
+// publicKeyPEM returns the PEM-encoded public key of the log.
+func (rk *testRekor) publicKeyPEM(t *testing.T) []byte {
+	der, err := x509.MarshalPKIXPublicKey(&rk.key.PublicKey)
+	require.NoError(t, err)
+	return pem.EncodeToMemory(&pem.Block{Type: "PUBLIC KEY", Bytes: der})
+}
+
+// signedEntryTimestamp returns a signed entry timestamp for e.
+func (rk *testRekor) signedEntryTimestamp(e rekorLogEntry) ([]byte, error) {
+	canonical, err := json.Marshal(map[string]interface{}{
+		"body": e.Body, "integratedTime": e.IntegratedTime, "logID": e.LogID, "logIndex": e.LogIndex,
+	})
+	if err != nil {
+		return nil, err
+	}
+	digest := sha256.Sum256(canonical)
+	return ecdsa.SignASN1(rand.Reader, rk.key, digest[:])
+}
+
+// testMerkleRoot returns the RFC 6962 root hash of a tree with leafHashes.
+func testMerkleRoot(leafHashes [][]byte) []byte {
+	if len(leafHashes) == 1 {
+		return leafHashes[0]
+	}
+	k := testMerkleSplit(len(leafHashes))
+	return merkleNodeHash(testMerkleRoot(leafHashes[:k]), testMerkleRoot(leafHashes[k:]))
+}
+
+// testMerklePath returns the RFC 6962 inclusion proof of leaf index in a tree with leafHashes.
+func testMerklePath(index int, leafHashes [][]byte) [][]byte {
+	if len(leafHashes) == 1 {
+		return nil
+	}
+	k := testMerkleSplit(len(leafHashes))
+	if index < k {
+		return append(testMerklePath(index, leafHashes[:k]), testMerkleRoot(leafHashes[k:]))
+	}
+	return append(testMerklePath(index-k, leafHashes[k:]), testMerkleRoot(leafHashes[:k]))
+}
+
+// testMerkleSplit returns the largest power of 2 smaller than n.
+func testMerkleSplit(n int) int {
+	k := 1
+	for k*2 < n {
+		k *= 2
+	}
+	return k
+}
+
+// entryWithProof returns the entry with uuid, including an inclusion proof in the current tree.
+func (rk *testRekor) entryWithProof(uuid string) (rekorLogEntry, error) {
+	e := rk.entries[uuid]
+	leafHashes := [][]byte{}
+	for _, u := range rk.uuids {
+		body, err := base64.StdEncoding.DecodeString(rk.entries[u].Body)
+		if err != nil {
+			return rekorLogEntry{}, err
+		}
+		leafHashes = append(leafHashes, merkleLeafHash(body))
+	}
+	root := testMerkleRoot(leafHashes)
+	proof := rekorInclusionProof{
+		LogIndex: e.LogIndex,
+		RootHash: hex.EncodeToString(root),
+		TreeSize: int64(len(leafHashes)),
+	}
+	for _, h := range testMerklePath(int(e.LogIndex), leafHashes) {
+		proof.Hashes = append(proof.Hashes, hex.EncodeToString(h))
+	}
+	text := fmt.Sprintf("rekor.test - 1234\n%d\n%s\n", len(leafHashes), base64.StdEncoding.EncodeToString(root))
+	checkpointKey := rk.key
+	if rk.checkpointKey != nil {
+		checkpointKey = rk.checkpointKey
+	}
+	digest := sha256.Sum256([]byte(text))
+	sig, err := ecdsa.SignASN1(rand.Reader, checkpointKey, digest[:])
+	if err != nil {
+		return rekorLogEntry{}, err
+	}
+	proof.Checkpoint = text + "\n\u2014 rekor.test " + base64.StdEncoding.EncodeToString(append([]byte{1, 2, 3, 4}, sig...)) + "\n"
+	e.Verification.InclusionProof = &proof
+	return e, nil
+}
+
+// writeEntry writes the entry with uuid, including an inclusion proof, as a response.
+func (rk *testRekor) writeEntry(w http.ResponseWriter, uuid string) {
+	e, err := rk.entryWithProof(uuid)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	_ = json.NewEncoder(w).Encode(map[string]rekorLogEntry{uuid: e})
+}
+
+func (rk *testRekor) serveHTTP(w http.ResponseWriter, r *http.Request) {
+	rk.mutex.Lock()
+	defer rk.mutex.Unlock()
+	if rk.fail {
+		w.WriteHeader(http.StatusInternalServerError)
+		fmt.Fprint(w, `{"code":500,"message":"log unavailable"}`)
+		return
+	}
+	switch {
+	case r.Method == http.MethodPost && r.URL.Path == "/api/v1/log/entries":
+		var entry rekorHashedRekord
+		if err := json.NewDecoder(r.Body).Decode(&entry); err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+		block, _ := pem.Decode(entry.Spec.Signature.PublicKey.Content)
+		if block == nil {
+			http.Error(w, "invalid public key", http.StatusBadRequest)
+			return
+		}
+		var publicKey interface{}
+		var err error
+		if block.Type == "CERTIFICATE" {
+			var cert *x509.Certificate
+			cert, err = x509.ParseCertificate(block.Bytes)
+			if err == nil {
+				publicKey = cert.PublicKey
+			}
+		} else {
+			publicKey, err = x509.ParsePKIXPublicKey(block.Bytes)
+		}
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+		ecdsaKey, ok := publicKey.(*ecdsa.PublicKey)
+		hash, err := hex.DecodeString(entry.Spec.Data.Hash.Value)
+		if err != nil || !ok || entry.Kind != "hashedrekord" || entry.Spec.Data.Hash.Algorithm != "sha256" ||
+			!ecdsa.VerifyASN1(ecdsaKey, hash, entry.Spec.Signature.Content) {
+			http.Error(w, "invalid entry", http.StatusBadRequest)
+			return
+		}
+		body, err := json.Marshal(entry)
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusInternalServerError)
+			return
+		}
+		bodyDigest := sha256.Sum256(body)
+		uuid := hex.EncodeToString(bodyDigest[:])
+		if _, ok := rk.entries[uuid]; ok {
+			w.Header().Set("Location", "/api/v1/log/entries/"+uuid)
+			w.WriteHeader(http.StatusConflict)
+			return
+		}
+		e := rekorLogEntry{
+			Body:           base64.StdEncoding.EncodeToString(body),
+			IntegratedTime: time.Now().Unix(),
+			LogID:          rk.logID,
+			LogIndex:       int64(len(rk.uuids)),
+		}
+		e.Verification.SignedEntryTimestamp, err = rk.signedEntryTimestamp(e)
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusInternalServerError)
+			return
+		}
+		rk.entries[uuid] = e
+		rk.uuids = append(rk.uuids, uuid)
+		w.WriteHeader(http.StatusCreated)
+		_ = json.NewEncoder(w).Encode(map[string]rekorLogEntry{uuid: e})
+	case r.Method == http.MethodGet && r.URL.Path == "/api/v1/log/entries":
+		index, err := strconv.Atoi(r.URL.Query().Get("logIndex"))
+		if err != nil || index < 0 || index >= len(rk.uuids) {
+			http.NotFound(w, r)
+			return
+		}
+		rk.writeEntry(w, rk.uuids[index])
+	case r.Method == http.MethodGet && strings.HasPrefix(r.URL.Path, "/api/v1/log/entries/"):
+		uuid := strings.TrimPrefix(r.URL.Path, "/api/v1/log/entries/")
+		if _, ok := rk.entries[uuid]; !ok {
+			http.NotFound(w, r)
+			return
+		}
+		rk.writeEntry(w, uuid)
+	case r.Method == http.MethodPost && r.URL.Path == "/api/v1/index/retrieve":
+		var query struct {
+			Hash string `json:"hash"`
+		}
+		if err := json.NewDecoder(r.Body).Decode(&query); err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+		uuids := []string{}
+		for _, uuid := range rk.uuids {
+			body, _ := base64.StdEncoding.DecodeString(rk.entries[uuid].Body)
+			var entry rekorHashedRekord
+			if json.Unmarshal(body, &entry) == nil && "sha256:"+entry.Spec.Data.Hash.Value == query.Hash {
+				uuids = append(uuids, uuid)
+			}
+		}
+		_ = json.NewEncoder(w).Encode(uuids)
+	default:
+		http.NotFound(w, r)
+	}
+}
+
+func TestNewKeylessSigner(t *testing.T) {
+	ctx := context.Background()
+	fulcio := newTestFulcio(t)
+	rekor := newTestRekor(t)
+	token := testIdentityToken(t, map[string]interface{}{
+		"iss": "https://issuer.example.com", "sub": "1234", "email": "user@example.com", "email_verified": true,
+	})
+
+	signer, err := NewKeylessSigner(ctx, KeylessOptions{
+		IdentityToken: StaticIdentityToken(token),
+		FulcioURL:     fulcio.URL,
+		RekorURL:      rekor.URL,
+	})
+	require.NoError(t, err)
+
+	m := []byte(`{"schemaVersion":2}`)
+	sig, err := signer.SignImage(ctx, m, "example.com/repo:tag")
+	require.NoError(t, err)
+	assert.Contains(t, string(sig.Payload), "example.com/repo:tag")
+
+	// The signature is made by the certified key.
+	block, _ := pem.Decode([]byte(sig.Annotations[CertificateAnnotationKey]))
+	require.NotNil(t, block)
+	cert, err := x509.ParseCertificate(block.Bytes)
+	require.NoError(t, err)
+	assert.Equal(t, []string{"user@example.com"}, cert.EmailAddresses)
+	rawSig, err := base64.StdEncoding.DecodeString(sig.Annotations[SignatureAnnotationKey])
+	require.NoError(t, err)
+	payloadDigest := sha256.Sum256(sig.Payload)
+	assert.True(t, ecdsa.VerifyASN1(cert.PublicKey.(*ecdsa.PublicKey), payloadDigest[:], rawSig))
+	// The chain leads to the CA.
+	assert.Equal(t, string(fulcio.caPEM), sig.Annotations[ChainAnnotationKey])
+	require.NoError(t, cert.CheckSignatureFrom(fulcio.caCert))
+	verifiedCert, err := VerifyFulcioCertificate([]*x509.Certificate{fulcio.caCert}, []byte(sig.Annotations[CertificateAnnotationKey]),
+		[]byte(sig.Annotations[ChainAnnotationKey]), time.Now())
+	require.NoError(t, err)
+	identity, err := CertificateIdentityFromCertificate(verifiedCert)
+	require.NoError(t, err)
+	assert.Equal(t, &CertificateIdentity{Subject: "user@example.com", Issuer: "https://issuer.example.com"}, identity)
+
+	// The signature is recorded in Rekor.
+	var bundle Bundle
+	require.NoError(t, json.Unmarshal([]byte(sig.Annotations[BundleAnnotationKey]), &bundle))
+	require.NoError(t, verifySignedEntryTimestamp([]crypto.PublicKey{&rekor.key.PublicKey}, &bundle))
+	assert.Equal(t, int64(0), bundle.Payload.LogIndex)
+	body, err := base64.StdEncoding.DecodeString(bundle.Payload.Body)
+	require.NoError(t, err)
+	var entry rekorHashedRekord
+	require.NoError(t, json.Unmarshal(body, &entry))
+	assert.Equal(t, hex.EncodeToString(payloadDigest[:]), entry.Spec.Data.Hash.Value)
+	assert.Equal(t, sig.Annotations[CertificateAnnotationKey], string(entry.Spec.Signature.PublicKey.Content))
+
+	// Keyless signatures can't be created without the extra annotations.
+	_, _, err = signer.SignDockerManifest(m, "example.com/repo:tag")
+	assert.Error(t, err)
+
+	// Rekor failures are reported.
+	rekor.mutex.Lock()
+	rekor.fail = true
+	rekor.mutex.Unlock()
+	_, err = signer.SignImage(ctx, m, "example.com/repo:tag")
+	assert.ErrorContains(t, err, "log unavailable")
+}
+
+func TestNewKeylessSignerErrors(t *testing.T) {
+	ctx := context.Background()
+	fulcio := newTestFulcio(t)
+	rekor := newTestRekor(t)
+	validToken := testIdentityToken(t, map[string]interface{}{"iss": "https://issuer.example.com", "sub": "repo:example/repo"})
+
+	// No identity token provider
+	_, err := NewKeylessSigner(ctx, KeylessOptions{FulcioURL: fulcio.URL, RekorURL: rekor.URL})
+	assert.Error(t, err)
+
+	// Invalid URLs
+	_, err = NewKeylessSigner(ctx, KeylessOptions{IdentityToken: StaticIdentityToken(validToken), FulcioURL: "ftp://fulcio", RekorURL: rekor.URL})
+	assert.Error(t, err)
+	_, err = NewKeylessSigner(ctx, KeylessOptions{IdentityToken: StaticIdentityToken(validToken), FulcioURL: fulcio.URL, RekorURL: ":"})
+	assert.Error(t, err)
+
+	for _, token := range []string{
+		"not a JWT",
+		// Unverified email
+		testIdentityToken(t, map[string]interface{}{"iss": "https://issuer.example.com", "sub": "1", "email": "user@example.com"}),
+		// No subject
+		testIdentityToken(t, map[string]interface{}{"iss": "https://issuer.example.com"}),
+		// Rejected by Fulcio
+		testIdentityToken(t, map[string]interface{}{"iss": "https://other.example.com", "sub": "1"}),
+	} {
+		_, err := NewKeylessSigner(ctx, KeylessOptions{IdentityToken: StaticIdentityToken(token), FulcioURL: fulcio.URL, RekorURL: rekor.URL})
+		assert.Error(t, err, token)
+	}
+
+	// Fulcio certifies a different key
+	wrongKey, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	require.NoError(t, err)
+	fulcio.mutex.Lock()
+	fulcio.wrongKey = wrongKey
+	fulcio.mutex.Unlock()
+	_, err = NewKeylessSigner(ctx, KeylessOptions{IdentityToken: StaticIdentityToken(validToken), FulcioURL: fulcio.URL, RekorURL: rekor.URL})
+	assert.ErrorContains(t, err, "does not match")
+}
+
+func TestRekorUploadExistingEntry(t *testing.T) {
+	ctx := context.Background()
+	fulcio := newTestFulcio(t)
+	rekor := newTestRekor(t)
+	token := testIdentityToken(t, map[string]interface{}{"iss": "https://issuer.example.com", "sub": "repo:example/repo"})
+	signer, err := NewKeylessSigner(ctx, KeylessOptions{IdentityToken: StaticIdentityToken(token), FulcioURL: fulcio.URL, RekorURL: rekor.URL})
+	require.NoError(t, err)
+
+	payload := []byte("payload")
+	sig, err := signer.sign(context.Background(), payload)
+	require.NoError(t, err)
+	first, err := signer.rekor.uploadHashedRekordEntry(ctx, payload, sig, signer.certificate)
+	require.NoError(t, err)
+	second, err := signer.rekor.uploadHashedRekordEntry(ctx, payload, sig, signer.certificate)
+	require.NoError(t, err)
+	assert.Equal(t, first, second)
+}
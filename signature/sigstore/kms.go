@@ -0,0 +1,75 @@
+package sigstore
+
+import (
+	"context"
+	"crypto"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rsa"
+	"crypto/sha256"
+	"crypto/x509"
+	"encoding/pem"
+
+	"github.com/pkg/errors"
+)
+
+// KMSKey is a signing key held by a key management service, which creates signatures without ever exporting the private key.
+// Implementations for common services are provided by the signature/sigstore/kms package.
+type KMSKey interface {
+	// PublicKey returns the public key of the key; an ECDSA P-256 or RSA key.
+	PublicKey(ctx context.Context) (crypto.PublicKey, error)
+	// SignDigest returns a signature of a SHA-256 digest: an ASN.1 DER-encoded signature for ECDSA keys,
+	// or a PKCS #1 v1.5 signature for RSA keys.
+	SignDigest(ctx context.Context, digest []byte) ([]byte, error)
+}
+
+// NewSignerFromKMSKey returns a Signer using key, held by a key management service.
+func NewSignerFromKMSKey(ctx context.Context, key KMSKey) (*Signer, error) {
+	publicKey, err := key.PublicKey(ctx)
+	if err != nil {
+		return nil, errors.Wrap(err, "reading the public key")
+	}
+	switch k := publicKey.(type) {
+	case *ecdsa.PublicKey:
+		if k.Curve != elliptic.P256() {
+			return nil, errors.Errorf("unsupported ECDSA curve %s, only P-256 is supported", k.Curve.Params().Name)
+		}
+	case *rsa.PublicKey:
+	default:
+		return nil, errors.Errorf("unsupported public key type %T", publicKey)
+	}
+	return &Signer{kmsKey: key, kmsPublicKey: publicKey}, nil
+}
+
+// signUsingKMS returns a signature of payload, created using s.kmsKey.
+func (s *Signer) signUsingKMS(ctx context.Context, payload []byte) ([]byte, error) {
+	digest := sha256.Sum256(payload)
+	sig, err := s.kmsKey.SignDigest(ctx, digest[:])
+	if err != nil {
+		return nil, err
+	}
+	// Catch misconfigured or misbehaving services before the signature is published.
+	if err := VerifySignature(s.kmsPublicKey, payload, sig); err != nil {
+		return nil, errors.Wrap(err, "the key management service returned an invalid signature")
+	}
+	return sig, nil
+}
+
+// PublicKeyPEM returns the PEM-encoded public key of s, e.g. for use as "keyData" in a "sigstoreSigned" policy requirement.
+// It can't be used with keyless signers, which use an ephemeral key.
+func (s *Signer) PublicKeyPEM() ([]byte, error) {
+	if s.certificate != nil {
+		return nil, errors.New("keyless signers do not have a stable public key")
+	}
+	var publicKey crypto.PublicKey
+	if s.kmsKey != nil {
+		publicKey = s.kmsPublicKey
+	} else {
+		publicKey = s.key.Public()
+	}
+	der, err := x509.MarshalPKIXPublicKey(publicKey)
+	if err != nil {
+		return nil, err
+	}
+	return pem.EncodeToMemory(&pem.Block{Type: "PUBLIC KEY", Bytes: der}), nil
+}
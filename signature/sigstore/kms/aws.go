@@ -0,0 +1,180 @@
+package kms
+
+import (
+	"context"
+	"crypto"
+	"crypto/x509"
+	"encoding/json"
+	"net/http"
+	"net/url"
+	"os"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/containers/image/v5/internal/awssigv4"
+	"github.com/containers/image/v5/signature/sigstore"
+	"github.com/pkg/errors"
+)
+
+// AWSOptions configure NewAWSKey.
+type AWSOptions struct {
+	// Region is the AWS region of the key. If "", it is taken from the key ARN, or from $AWS_REGION or $AWS_DEFAULT_REGION.
+	Region string
+	// Endpoint is the URL of the KMS service. If "", the public endpoint of the region is used.
+	Endpoint string
+	// Credentials; if AccessKeyID is "", they are read from $AWS_ACCESS_KEY_ID, $AWS_SECRET_ACCESS_KEY and $AWS_SESSION_TOKEN.
+	AccessKeyID     string
+	SecretAccessKey string
+	SessionToken    string
+	HTTPClient      *http.Client // If nil, http.DefaultClient is used
+}
+
+// awsKey is a sigstore.KMSKey held by AWS KMS.
+type awsKey struct {
+	keyID           string
+	region          string
+	endpoint        string
+	accessKeyID     string
+	secretAccessKey string
+	sessionToken    string
+	httpClient      *http.Client
+	now             func() time.Time // Used to sign requests; can be replaced in tests
+
+	mutex            sync.Mutex
+	signingAlgorithm string // Set by PublicKey
+}
+
+// NewAWSKey returns a sigstore.KMSKey for keyID (a key ID, a key ARN, an alias name "alias/NAME", or an alias ARN) in AWS KMS.
+// The key must have the SIGN_VERIFY usage, and an ECC_NIST_P256 or RSA_* key spec.
+func NewAWSKey(keyID string, options AWSOptions) (sigstore.KMSKey, error) {
+	if keyID == "" {
+		return nil, errors.New("AWS KMS key ID not specified")
+	}
+	region := options.Region
+	if region == "" && strings.HasPrefix(keyID, "arn:") {
+		if parts := strings.Split(keyID, ":"); len(parts) >= 6 {
+			region = parts[3]
+		}
+	}
+	if region == "" {
+		region = os.Getenv("AWS_REGION")
+	}
+	if region == "" {
+		region = os.Getenv("AWS_DEFAULT_REGION")
+	}
+	if region == "" {
+		return nil, errors.New("AWS region not specified")
+	}
+	endpoint := options.Endpoint
+	if endpoint == "" {
+		endpoint = "https://kms." + region + ".amazonaws.com"
+	}
+	if _, err := url.Parse(endpoint); err != nil {
+		return nil, errors.Wrapf(err, "invalid AWS KMS endpoint %q", endpoint)
+	}
+	accessKeyID, secretAccessKey, sessionToken := options.AccessKeyID, options.SecretAccessKey, options.SessionToken
+	if accessKeyID == "" {
+		accessKeyID = os.Getenv("AWS_ACCESS_KEY_ID")
+		secretAccessKey = os.Getenv("AWS_SECRET_ACCESS_KEY")
+		sessionToken = os.Getenv("AWS_SESSION_TOKEN")
+	}
+	if accessKeyID == "" || secretAccessKey == "" {
+		return nil, errors.New("AWS credentials not specified")
+	}
+	return &awsKey{
+		keyID:           keyID,
+		region:          region,
+		endpoint:        strings.TrimSuffix(endpoint, "/") + "/",
+		accessKeyID:     accessKeyID,
+		secretAccessKey: secretAccessKey,
+		sessionToken:    sessionToken,
+		httpClient:      httpClientOrDefault(options.HTTPClient),
+		now:             time.Now,
+	}, nil
+}
+
+// PublicKey implements sigstore.KMSKey.
+func (k *awsKey) PublicKey(ctx context.Context) (crypto.PublicKey, error) {
+	var res struct {
+		PublicKey         []byte   `json:"PublicKey"` // DER, base64-encoded in JSON
+		KeyUsage          string   `json:"KeyUsage"`
+		SigningAlgorithms []string `json:"SigningAlgorithms"`
+	}
+	if err := k.call(ctx, "GetPublicKey", map[string]string{"KeyId": k.keyID}, &res); err != nil {
+		return nil, err
+	}
+	if res.KeyUsage != "SIGN_VERIFY" {
+		return nil, errors.Errorf("AWS KMS key %s can't be used for signing, usage is %q", k.keyID, res.KeyUsage)
+	}
+	algorithm := ""
+	for _, a := range res.SigningAlgorithms {
+		if a == "ECDSA_SHA_256" || a == "RSASSA_PKCS1_V1_5_SHA_256" {
+			algorithm = a
+			break
+		}
+	}
+	if algorithm == "" {
+		return nil, unsupportedAlgorithmError(strings.Join(res.SigningAlgorithms, ", "))
+	}
+	publicKey, err := x509.ParsePKIXPublicKey(res.PublicKey)
+	if err != nil {
+		return nil, errors.Wrap(err, "parsing the AWS KMS public key")
+	}
+	k.mutex.Lock()
+	k.signingAlgorithm = algorithm
+	k.mutex.Unlock()
+	return publicKey, nil
+}
+
+// SignDigest implements sigstore.KMSKey.
+func (k *awsKey) SignDigest(ctx context.Context, digest []byte) ([]byte, error) {
+	k.mutex.Lock()
+	algorithm := k.signingAlgorithm
+	k.mutex.Unlock()
+	if algorithm == "" {
+		if _, err := k.PublicKey(ctx); err != nil {
+			return nil, err
+		}
+		k.mutex.Lock()
+		algorithm = k.signingAlgorithm
+		k.mutex.Unlock()
+	}
+	var res struct {
+		Signature []byte `json:"Signature"` // base64-encoded in JSON; ASN.1 DER for ECDSA
+	}
+	if err := k.call(ctx, "Sign", map[string]interface{}{
+		"KeyId":            k.keyID,
+		"Message":          digest,
+		"MessageType":      "DIGEST",
+		"SigningAlgorithm": algorithm,
+	}, &res); err != nil {
+		return nil, err
+	}
+	return res.Signature, nil
+}
+
+// call invokes the KMS API operation with body, and decodes the response into res.
+func (k *awsKey) call(ctx context.Context, operation string, body interface{}, res interface{}) error {
+	req, bodyBytes, err := newJSONRequest(ctx, http.MethodPost, k.endpoint, body)
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Content-Type", "application/x-amz-json-1.1")
+	req.Header.Set("X-Amz-Target", "TrentService."+operation)
+	awssigv4.SignRequest(req, awssigv4.PayloadHash(bodyBytes), "kms", k.region, awssigv4.Credentials{
+		AccessKeyID:     k.accessKeyID,
+		SecretAccessKey: k.secretAccessKey,
+		SessionToken:    k.sessionToken,
+	}, k.now())
+	return doJSON(k.httpClient, req, "AWS KMS", res, func(body []byte) string {
+		var e struct {
+			Type    string `json:"__type"`
+			Message string `json:"message"`
+		}
+		if json.Unmarshal(body, &e) != nil {
+			return ""
+		}
+		return strings.TrimSpace(e.Type + " " + e.Message)
+	})
+}
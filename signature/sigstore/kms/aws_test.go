@@ -0,0 +1,185 @@
+package kms
+
+import (
+	"context"
+	"crypto"
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/x509"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// testAWSKMS is a fake AWS KMS server.
+type testAWSKMS struct {
+	*httptest.Server
+	key crypto.Signer
+
+	mutex             sync.Mutex
+	keyUsage          string
+	signingAlgorithms []string
+}
+
+func newTestAWSKMS(t *testing.T, key crypto.Signer, signingAlgorithms ...string) *testAWSKMS {
+	s := &testAWSKMS{key: key, keyUsage: "SIGN_VERIFY", signingAlgorithms: signingAlgorithms}
+	s.Server = httptest.NewServer(http.HandlerFunc(s.serveHTTP))
+	t.Cleanup(s.Close)
+	return s
+}
+
+func (s *testAWSKMS) serveHTTP(w http.ResponseWriter, r *http.Request) {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	target := r.Header.Get("X-Amz-Target")
+	writeError := func(status int, errorType, message string) {
+		w.WriteHeader(status)
+		_ = json.NewEncoder(w).Encode(map[string]string{"__type": errorType, "message": message})
+	}
+	if r.Method != http.MethodPost || r.Header.Get("Content-Type") != "application/x-amz-json-1.1" ||
+		!strings.HasPrefix(r.Header.Get("Authorization"), "AWS4-HMAC-SHA256 Credential=AKIDEXAMPLE/") ||
+		!strings.Contains(r.Header.Get("Authorization"), "/us-west-2/kms/aws4_request, SignedHeaders=content-type;host;x-amz-date;x-amz-target,") {
+		writeError(http.StatusBadRequest, "InvalidSignatureException", "invalid request")
+		return
+	}
+	var req struct {
+		KeyID            string `json:"KeyId"`
+		Message          []byte `json:"Message"`
+		MessageType      string `json:"MessageType"`
+		SigningAlgorithm string `json:"SigningAlgorithm"`
+	}
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil || req.KeyID != "alias/signing" {
+		writeError(http.StatusBadRequest, "NotFoundException", "key not found")
+		return
+	}
+	switch target {
+	case "TrentService.GetPublicKey":
+		der, err := x509.MarshalPKIXPublicKey(s.key.Public())
+		if err != nil {
+			writeError(http.StatusInternalServerError, "KMSInternalException", err.Error())
+			return
+		}
+		_ = json.NewEncoder(w).Encode(map[string]interface{}{
+			"KeyId": req.KeyID, "PublicKey": der, "KeyUsage": s.keyUsage, "SigningAlgorithms": s.signingAlgorithms,
+		})
+	case "TrentService.Sign":
+		if req.MessageType != "DIGEST" || len(req.Message) != 32 {
+			writeError(http.StatusBadRequest, "ValidationException", "invalid message")
+			return
+		}
+		if req.SigningAlgorithm != s.signingAlgorithms[0] {
+			writeError(http.StatusBadRequest, "InvalidKeyUsageException", "unsupported algorithm "+req.SigningAlgorithm)
+			return
+		}
+		sig, err := s.key.Sign(rand.Reader, req.Message, crypto.SHA256)
+		if err != nil {
+			writeError(http.StatusInternalServerError, "KMSInternalException", err.Error())
+			return
+		}
+		_ = json.NewEncoder(w).Encode(map[string]interface{}{"KeyId": req.KeyID, "Signature": sig, "SigningAlgorithm": req.SigningAlgorithm})
+	default:
+		writeError(http.StatusBadRequest, "UnknownOperationException", "")
+	}
+}
+
+func TestAWSKey(t *testing.T) {
+	ctx := context.Background()
+	ecdsaKey := newTestECDSAKey(t)
+	rsaKey, err := rsa.GenerateKey(rand.Reader, 2048)
+	require.NoError(t, err)
+	options := func(server *testAWSKMS) AWSOptions {
+		return AWSOptions{Region: "us-west-2", Endpoint: server.URL, AccessKeyID: "AKIDEXAMPLE", SecretAccessKey: "secret"}
+	}
+
+	for _, c := range []struct {
+		key        crypto.Signer
+		algorithms []string
+	}{
+		{ecdsaKey, []string{"ECDSA_SHA_256"}},
+		{rsaKey, []string{"RSASSA_PKCS1_V1_5_SHA_256", "RSASSA_PSS_SHA_256"}},
+	} {
+		server := newTestAWSKMS(t, c.key, c.algorithms...)
+		key, err := NewAWSKey("alias/signing", options(server))
+		require.NoError(t, err)
+		assertSignsImages(t, key, c.key.Public())
+
+		// SignDigest reads the public key if necessary.
+		key, err = NewAWSKey("alias/signing", options(server))
+		require.NoError(t, err)
+		_, err = key.SignDigest(ctx, make([]byte, 32))
+		require.NoError(t, err)
+	}
+
+	// Unsupported keys
+	for _, c := range []struct {
+		usage      string
+		algorithms []string
+	}{
+		{"ENCRYPT_DECRYPT", nil},
+		{"SIGN_VERIFY", []string{"ECDSA_SHA_384"}},
+		{"SIGN_VERIFY", []string{"RSASSA_PSS_SHA_256"}},
+	} {
+		server := newTestAWSKMS(t, ecdsaKey, c.algorithms...)
+		server.keyUsage = c.usage
+		key, err := NewAWSKey("alias/signing", options(server))
+		require.NoError(t, err)
+		_, err = key.PublicKey(ctx)
+		assert.Error(t, err)
+		_, err = key.SignDigest(ctx, make([]byte, 32))
+		assert.Error(t, err)
+	}
+
+	// Errors are reported
+	server := newTestAWSKMS(t, ecdsaKey, "ECDSA_SHA_256")
+	key, err := NewAWSKey("alias/other", options(server))
+	require.NoError(t, err)
+	_, err = key.PublicKey(ctx)
+	assert.ErrorContains(t, err, "NotFoundException key not found")
+	wrongCredentials := options(server)
+	wrongCredentials.AccessKeyID = "other"
+	key, err = NewAWSKey("alias/signing", wrongCredentials)
+	require.NoError(t, err)
+	_, err = key.PublicKey(ctx)
+	assert.ErrorContains(t, err, "InvalidSignatureException")
+}
+
+func TestNewAWSKey(t *testing.T) {
+	setenv(t, map[string]string{
+		"AWS_REGION": "", "AWS_DEFAULT_REGION": "eu-central-1",
+		"AWS_ACCESS_KEY_ID": "env-id", "AWS_SECRET_ACCESS_KEY": "env-secret", "AWS_SESSION_TOKEN": "env-token",
+	})
+
+	// Values from the environment
+	key, err := NewAWSKey("alias/signing", AWSOptions{})
+	require.NoError(t, err)
+	k := key.(*awsKey)
+	assert.Equal(t, "eu-central-1", k.region)
+	assert.Equal(t, "env-id", k.accessKeyID)
+	assert.Equal(t, "env-secret", k.secretAccessKey)
+	assert.Equal(t, "env-token", k.sessionToken)
+	// Explicit values take precedence
+	key, err = NewAWSKey("arn:aws:kms:us-east-2:111122223333:alias/signing", AWSOptions{
+		AccessKeyID: "id", SecretAccessKey: "secret", Endpoint: "https://kms.example.com/",
+	})
+	require.NoError(t, err)
+	k = key.(*awsKey)
+	assert.Equal(t, "us-east-2", k.region)
+	assert.Equal(t, "https://kms.example.com/", k.endpoint)
+	assert.Equal(t, "id", k.accessKeyID)
+	assert.Equal(t, "", k.sessionToken)
+
+	_, err = NewAWSKey("", AWSOptions{})
+	assert.Error(t, err)
+	setenv(t, map[string]string{"AWS_DEFAULT_REGION": ""})
+	_, err = NewAWSKey("alias/signing", AWSOptions{})
+	assert.Error(t, err)
+	setenv(t, map[string]string{"AWS_ACCESS_KEY_ID": ""})
+	_, err = NewAWSKey("alias/signing", AWSOptions{Region: "us-east-1"})
+	assert.Error(t, err)
+}
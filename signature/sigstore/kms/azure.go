@@ -0,0 +1,290 @@
+package kms
+
+import (
+	"context"
+	"crypto"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rsa"
+	"encoding/asn1"
+	"encoding/base64"
+	"encoding/json"
+	"math/big"
+	"net/http"
+	"net/url"
+	"os"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/containers/image/v5/signature/sigstore"
+	"github.com/pkg/errors"
+)
+
+const (
+	// azureAPIVersion is the version of the Key Vault REST API used.
+	azureAPIVersion = "7.4"
+	// defaultAzureAuthorityHost is the URL of the Microsoft identity platform.
+	defaultAzureAuthorityHost = "https://login.microsoftonline.com"
+	// azureKeyVaultScope is the OAuth 2.0 scope of access tokens for Key Vault.
+	azureKeyVaultScope = "https://vault.azure.net/.default"
+)
+
+// AzureOptions configure NewAzureKey.
+type AzureOptions struct {
+	// AccessToken is an OAuth 2.0 access token for Key Vault used to authenticate.  If "", a token is obtained
+	// using the client credentials flow, using TenantID, ClientID and ClientSecret.
+	AccessToken string
+	// Client credentials of a service principal; if TenantID is "", they are read from $AZURE_TENANT_ID, $AZURE_CLIENT_ID
+	// and $AZURE_CLIENT_SECRET.
+	TenantID     string
+	ClientID     string
+	ClientSecret string
+	// AuthorityHost is the URL of the identity provider.  If "", $AZURE_AUTHORITY_HOST or https://login.microsoftonline.com is used.
+	AuthorityHost string
+	HTTPClient    *http.Client // If nil, http.DefaultClient is used
+}
+
+// azureKey is a sigstore.KMSKey held by Azure Key Vault.
+type azureKey struct {
+	vaultURL      string
+	name          string
+	authorityHost string
+	tenantID      string
+	clientID      string
+	clientSecret  string
+	httpClient    *http.Client
+
+	mutex       sync.Mutex
+	version     string // "" until determined by PublicKey, if not specified
+	algorithm   string // Set by PublicKey
+	accessToken string
+	tokenExpiry time.Time // Zero if accessToken does not expire
+}
+
+// NewAzureKey returns a sigstore.KMSKey for the key name in the Azure Key Vault at vaultURL (e.g. https://VAULT.vault.azure.net),
+// using a specific version, or the current version if version is "".
+// The key must allow the sign operation, and be a P-256 EC key or a RSA key.
+func NewAzureKey(vaultURL, name, version string, options AzureOptions) (sigstore.KMSKey, error) {
+	u, err := url.Parse(vaultURL)
+	if err != nil || u.Scheme == "" || u.Host == "" {
+		return nil, errors.Errorf("invalid Azure Key Vault URL %q", vaultURL)
+	}
+	if name == "" || strings.Contains(name, "/") || strings.Contains(version, "/") {
+		return nil, errors.Errorf("invalid Azure Key Vault key name %q, version %q", name, version)
+	}
+	k := &azureKey{
+		vaultURL:      strings.TrimSuffix(vaultURL, "/"),
+		name:          name,
+		version:       version,
+		authorityHost: options.AuthorityHost,
+		tenantID:      options.TenantID,
+		clientID:      options.ClientID,
+		clientSecret:  options.ClientSecret,
+		httpClient:    httpClientOrDefault(options.HTTPClient),
+		accessToken:   options.AccessToken,
+	}
+	if k.accessToken == "" {
+		if k.tenantID == "" {
+			k.tenantID = os.Getenv("AZURE_TENANT_ID")
+			k.clientID = os.Getenv("AZURE_CLIENT_ID")
+			k.clientSecret = os.Getenv("AZURE_CLIENT_SECRET")
+		}
+		if k.tenantID == "" || k.clientID == "" || k.clientSecret == "" {
+			return nil, errors.New("Azure credentials not specified")
+		}
+		if k.authorityHost == "" {
+			k.authorityHost = os.Getenv("AZURE_AUTHORITY_HOST")
+		}
+		if k.authorityHost == "" {
+			k.authorityHost = defaultAzureAuthorityHost
+		}
+		k.authorityHost = strings.TrimSuffix(k.authorityHost, "/")
+	}
+	return k, nil
+}
+
+// PublicKey implements sigstore.KMSKey.
+func (k *azureKey) PublicKey(ctx context.Context) (crypto.PublicKey, error) {
+	k.mutex.Lock()
+	version := k.version
+	k.mutex.Unlock()
+	path := "/keys/" + url.PathEscape(k.name)
+	if version != "" {
+		path += "/" + url.PathEscape(version)
+	}
+	var res struct {
+		Key struct {
+			KID string `json:"kid"`
+			KTY string `json:"kty"`
+			CRV string `json:"crv"`
+			X   string `json:"x"`
+			Y   string `json:"y"`
+			N   string `json:"n"`
+			E   string `json:"e"`
+		} `json:"key"`
+	}
+	if err := k.call(ctx, http.MethodGet, path, nil, &res); err != nil {
+		return nil, err
+	}
+
+	var publicKey crypto.PublicKey
+	var algorithm string
+	switch res.Key.KTY {
+	case "EC", "EC-HSM":
+		if res.Key.CRV != "P-256" {
+			return nil, unsupportedAlgorithmError(res.Key.KTY + " " + res.Key.CRV)
+		}
+		x, err1 := decodeBase64URLInt(res.Key.X)
+		y, err2 := decodeBase64URLInt(res.Key.Y)
+		if err1 != nil || err2 != nil || !elliptic.P256().IsOnCurve(x, y) {
+			return nil, errors.New("invalid EC public key in Azure Key Vault response")
+		}
+		publicKey = &ecdsa.PublicKey{Curve: elliptic.P256(), X: x, Y: y}
+		algorithm = "ES256"
+	case "RSA", "RSA-HSM":
+		n, err1 := decodeBase64URLInt(res.Key.N)
+		e, err2 := decodeBase64URLInt(res.Key.E)
+		if err1 != nil || err2 != nil || !e.IsInt64() || e.Int64() > 1<<31-1 {
+			return nil, errors.New("invalid RSA public key in Azure Key Vault response")
+		}
+		publicKey = &rsa.PublicKey{N: n, E: int(e.Int64())}
+		algorithm = "RS256"
+	default:
+		return nil, unsupportedAlgorithmError(res.Key.KTY)
+	}
+	if version == "" {
+		// The key ID is …/keys/NAME/VERSION; use the same version for signing.
+		i := strings.LastIndexByte(res.Key.KID, '/')
+		if i == -1 || i == len(res.Key.KID)-1 {
+			return nil, errors.Errorf("invalid key ID %q in Azure Key Vault response", res.Key.KID)
+		}
+		version = res.Key.KID[i+1:]
+	}
+	k.mutex.Lock()
+	k.version = version
+	k.algorithm = algorithm
+	k.mutex.Unlock()
+	return publicKey, nil
+}
+
+// SignDigest implements sigstore.KMSKey.
+func (k *azureKey) SignDigest(ctx context.Context, digest []byte) ([]byte, error) {
+	k.mutex.Lock()
+	algorithm := k.algorithm
+	k.mutex.Unlock()
+	if algorithm == "" {
+		if _, err := k.PublicKey(ctx); err != nil {
+			return nil, err
+		}
+	}
+	k.mutex.Lock()
+	version, algorithm := k.version, k.algorithm
+	k.mutex.Unlock()
+
+	var res struct {
+		Value string `json:"value"` // base64url-encoded
+	}
+	if err := k.call(ctx, http.MethodPost, "/keys/"+url.PathEscape(k.name)+"/"+url.PathEscape(version)+"/sign", map[string]string{
+		"alg":   algorithm,
+		"value": base64.RawURLEncoding.EncodeToString(digest),
+	}, &res); err != nil {
+		return nil, err
+	}
+	sig, err := base64.RawURLEncoding.DecodeString(strings.TrimRight(res.Value, "="))
+	if err != nil {
+		return nil, errors.Wrap(err, "decoding the Azure Key Vault signature")
+	}
+	if algorithm != "ES256" {
+		return sig, nil
+	}
+	// Key Vault returns ECDSA signatures as R || S; sigstore uses ASN.1 DER.
+	if len(sig) != 64 {
+		return nil, errors.Errorf("unexpected ECDSA signature length %d in Azure Key Vault response", len(sig))
+	}
+	return asn1.Marshal(struct{ R, S *big.Int }{new(big.Int).SetBytes(sig[:32]), new(big.Int).SetBytes(sig[32:])})
+}
+
+// call sends a method request to path in the vault, with body encoded as JSON if it is not nil, and decodes the response into res.
+func (k *azureKey) call(ctx context.Context, method, path string, body interface{}, res interface{}) error {
+	token, err := k.token(ctx)
+	if err != nil {
+		return err
+	}
+	req, _, err := newJSONRequest(ctx, method, k.vaultURL+path+"?api-version="+azureAPIVersion, body)
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Authorization", "Bearer "+token)
+	return doJSON(k.httpClient, req, "Azure Key Vault", res, azureErrorMessage)
+}
+
+// token returns an access token to authenticate to Key Vault.
+func (k *azureKey) token(ctx context.Context) (string, error) {
+	k.mutex.Lock()
+	defer k.mutex.Unlock()
+	if k.accessToken != "" && (k.tokenExpiry.IsZero() || time.Now().Before(k.tokenExpiry)) {
+		return k.accessToken, nil
+	}
+
+	form := url.Values{
+		"grant_type":    {"client_credentials"},
+		"client_id":     {k.clientID},
+		"client_secret": {k.clientSecret},
+		"scope":         {azureKeyVaultScope},
+	}
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, k.authorityHost+"/"+url.PathEscape(k.tenantID)+"/oauth2/v2.0/token",
+		strings.NewReader(form.Encode()))
+	if err != nil {
+		return "", err
+	}
+	req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+	var res struct {
+		AccessToken string `json:"access_token"`
+		ExpiresIn   int64  `json:"expires_in"`
+	}
+	if err := doJSON(k.httpClient, req, "the Microsoft identity platform", &res, func(body []byte) string {
+		var e struct {
+			Description string `json:"error_description"`
+		}
+		if json.Unmarshal(body, &e) != nil {
+			return ""
+		}
+		return e.Description
+	}); err != nil {
+		return "", errors.Wrap(err, "obtaining an Azure access token")
+	}
+	if res.AccessToken == "" {
+		return "", errors.New("the Microsoft identity platform did not return an access token")
+	}
+	k.accessToken = res.AccessToken
+	// Refresh the token a minute before it expires.
+	k.tokenExpiry = time.Now().Add(time.Duration(res.ExpiresIn)*time.Second - time.Minute)
+	return k.accessToken, nil
+}
+
+// azureErrorMessage returns the message of an Azure Key Vault error response body.
+func azureErrorMessage(body []byte) string {
+	var e struct {
+		Error struct {
+			Code    string `json:"code"`
+			Message string `json:"message"`
+		} `json:"error"`
+	}
+	if json.Unmarshal(body, &e) != nil {
+		return ""
+	}
+	return strings.TrimSpace(e.Error.Code + " " + e.Error.Message)
+}
+
+// decodeBase64URLInt decodes a base64url-encoded big-endian unsigned integer, as used in JSON Web Keys.
+func decodeBase64URLInt(s string) (*big.Int, error) {
+	b, err := base64.RawURLEncoding.DecodeString(strings.TrimRight(s, "="))
+	if err != nil {
+		return nil, err
+	}
+	if len(b) == 0 {
+		return nil, errors.New("empty integer")
+	}
+	return new(big.Int).SetBytes(b), nil
+}
@@ -0,0 +1,195 @@
+package kms
+
+import (
+	"context"
+	"crypto"
+	"crypto/ecdsa"
+	"crypto/rand"
+	"crypto/rsa"
+	"encoding/base64"
+	"encoding/json"
+	"math/big"
+	"net/http"
+	"net/http/httptest"
+	"sync"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// testAzureKeyVault is a fake Azure Key Vault server which also acts as the Microsoft identity platform.
+type testAzureKeyVault struct {
+	*httptest.Server
+	key crypto.Signer
+
+	mutex         sync.Mutex
+	tokenRequests int
+}
+
+func newTestAzureKeyVault(t *testing.T, key crypto.Signer) *testAzureKeyVault {
+	s := &testAzureKeyVault{key: key}
+	s.Server = httptest.NewServer(http.HandlerFunc(s.serveHTTP))
+	t.Cleanup(s.Close)
+	return s
+}
+
+func (s *testAzureKeyVault) serveHTTP(w http.ResponseWriter, r *http.Request) {
+	writeError := func(status int, code, message string) {
+		w.WriteHeader(status)
+		_ = json.NewEncoder(w).Encode(map[string]interface{}{"error": map[string]string{"code": code, "message": message}})
+	}
+	b64 := func(b []byte) string {
+		return base64.RawURLEncoding.EncodeToString(b)
+	}
+
+	if r.URL.Path == "/tenant/oauth2/v2.0/token" {
+		if r.FormValue("grant_type") != "client_credentials" || r.FormValue("client_id") != "client" ||
+			r.FormValue("client_secret") != "client-secret" || r.FormValue("scope") != azureKeyVaultScope {
+			w.WriteHeader(http.StatusUnauthorized)
+			_ = json.NewEncoder(w).Encode(map[string]string{"error": "invalid_client", "error_description": "invalid client secret"})
+			return
+		}
+		s.mutex.Lock()
+		s.tokenRequests++
+		s.mutex.Unlock()
+		_ = json.NewEncoder(w).Encode(map[string]interface{}{"access_token": "azure-token", "expires_in": 3600, "token_type": "Bearer"})
+		return
+	}
+	if r.Header.Get("Authorization") != "Bearer azure-token" {
+		writeError(http.StatusUnauthorized, "Unauthorized", "invalid token")
+		return
+	}
+	if r.URL.Query().Get("api-version") != azureAPIVersion {
+		writeError(http.StatusBadRequest, "BadParameter", "invalid API version")
+		return
+	}
+	switch {
+	case r.Method == http.MethodGet && (r.URL.Path == "/keys/signing" || r.URL.Path == "/keys/signing/v1"):
+		jwk := map[string]string{"kid": s.URL + "/keys/signing/v1"}
+		switch k := s.key.Public().(type) {
+		case *ecdsa.PublicKey:
+			jwk["kty"], jwk["crv"], jwk["x"], jwk["y"] = "EC-HSM", "P-256", b64(k.X.Bytes()), b64(k.Y.Bytes())
+		case *rsa.PublicKey:
+			jwk["kty"], jwk["n"], jwk["e"] = "RSA", b64(k.N.Bytes()), b64(big.NewInt(int64(k.E)).Bytes())
+		}
+		_ = json.NewEncoder(w).Encode(map[string]interface{}{"key": jwk})
+	case r.Method == http.MethodPost && r.URL.Path == "/keys/signing/v1/sign":
+		var req struct {
+			Algorithm string `json:"alg"`
+			Value     string `json:"value"`
+		}
+		if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+			writeError(http.StatusBadRequest, "BadParameter", err.Error())
+			return
+		}
+		digest, err := base64.RawURLEncoding.DecodeString(req.Value)
+		if err != nil || len(digest) != 32 {
+			writeError(http.StatusBadRequest, "BadParameter", "invalid digest")
+			return
+		}
+		var sig []byte
+		switch k := s.key.(type) {
+		case *ecdsa.PrivateKey:
+			if req.Algorithm != "ES256" {
+				writeError(http.StatusBadRequest, "BadParameter", "invalid algorithm")
+				return
+			}
+			r, s, err := ecdsa.Sign(rand.Reader, k, digest)
+			if err != nil {
+				writeError(http.StatusInternalServerError, "InternalError", err.Error())
+				return
+			}
+			sig = make([]byte, 64)
+			r.FillBytes(sig[:32])
+			s.FillBytes(sig[32:])
+		case *rsa.PrivateKey:
+			if req.Algorithm != "RS256" {
+				writeError(http.StatusBadRequest, "BadParameter", "invalid algorithm")
+				return
+			}
+			sig, err = rsa.SignPKCS1v15(rand.Reader, k, crypto.SHA256, digest)
+			if err != nil {
+				writeError(http.StatusInternalServerError, "InternalError", err.Error())
+				return
+			}
+		}
+		_ = json.NewEncoder(w).Encode(map[string]string{"kid": s.URL + "/keys/signing/v1", "value": b64(sig)})
+	default:
+		writeError(http.StatusNotFound, "KeyNotFound", "key not found")
+	}
+}
+
+func TestAzureKey(t *testing.T) {
+	ctx := context.Background()
+	ecdsaKey := newTestECDSAKey(t)
+	rsaKey, err := rsa.GenerateKey(rand.Reader, 2048)
+	require.NoError(t, err)
+
+	for _, c := range []struct {
+		key     crypto.Signer
+		version string
+	}{
+		{ecdsaKey, ""},
+		{ecdsaKey, "v1"},
+		{rsaKey, ""},
+	} {
+		server := newTestAzureKeyVault(t, c.key)
+		key, err := NewAzureKey(server.URL, "signing", c.version, AzureOptions{AccessToken: "azure-token"})
+		require.NoError(t, err)
+		assertSignsImages(t, key, c.key.Public())
+	}
+
+	// Client credentials
+	server := newTestAzureKeyVault(t, ecdsaKey)
+	key, err := NewAzureKey(server.URL, "signing", "", AzureOptions{
+		TenantID: "tenant", ClientID: "client", ClientSecret: "client-secret", AuthorityHost: server.URL,
+	})
+	require.NoError(t, err)
+	assertSignsImages(t, key, ecdsaKey.Public())
+	assert.Equal(t, 1, server.tokenRequests) // The token is reused
+
+	// Errors are reported
+	key, err = NewAzureKey(server.URL, "signing", "", AzureOptions{
+		TenantID: "tenant", ClientID: "client", ClientSecret: "wrong", AuthorityHost: server.URL,
+	})
+	require.NoError(t, err)
+	_, err = key.PublicKey(ctx)
+	assert.ErrorContains(t, err, "invalid client secret")
+	key, err = NewAzureKey(server.URL, "other", "", AzureOptions{AccessToken: "azure-token"})
+	require.NoError(t, err)
+	_, err = key.PublicKey(ctx)
+	assert.ErrorContains(t, err, "KeyNotFound key not found")
+	_, err = key.SignDigest(ctx, make([]byte, 32))
+	assert.ErrorContains(t, err, "KeyNotFound key not found")
+}
+
+func TestNewAzureKey(t *testing.T) {
+	setenv(t, map[string]string{
+		"AZURE_TENANT_ID": "env-tenant", "AZURE_CLIENT_ID": "env-client", "AZURE_CLIENT_SECRET": "env-secret",
+		"AZURE_AUTHORITY_HOST": "https://login.example.com/",
+	})
+
+	key, err := NewAzureKey("https://vault.example.com/", "signing", "", AzureOptions{})
+	require.NoError(t, err)
+	k := key.(*azureKey)
+	assert.Equal(t, "https://vault.example.com", k.vaultURL)
+	assert.Equal(t, "env-tenant", k.tenantID)
+	assert.Equal(t, "env-client", k.clientID)
+	assert.Equal(t, "env-secret", k.clientSecret)
+	assert.Equal(t, "https://login.example.com", k.authorityHost)
+
+	for _, c := range []struct{ vaultURL, name, version string }{
+		{"", "signing", ""},
+		{"vault.example.com", "signing", ""},
+		{"https://vault.example.com", "", ""},
+		{"https://vault.example.com", "a/b", ""},
+		{"https://vault.example.com", "signing", "a/b"},
+	} {
+		_, err := NewAzureKey(c.vaultURL, c.name, c.version, AzureOptions{})
+		assert.Error(t, err, c)
+	}
+	setenv(t, map[string]string{"AZURE_CLIENT_SECRET": ""})
+	_, err = NewAzureKey("https://vault.example.com", "signing", "", AzureOptions{})
+	assert.Error(t, err)
+}
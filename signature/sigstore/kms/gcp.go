@@ -0,0 +1,157 @@
+package kms
+
+import (
+	"context"
+	"crypto"
+	"encoding/json"
+	"net/http"
+	"os"
+	"regexp"
+	"strings"
+
+	"github.com/containers/image/v5/signature/sigstore"
+	"github.com/pkg/errors"
+)
+
+const (
+	// defaultGCPEndpoint is the URL of the Google Cloud KMS API.
+	defaultGCPEndpoint = "https://cloudkms.googleapis.com"
+	// defaultGCPMetadataHost is the host of the GCE metadata server, which provides access tokens to workloads running on Google Cloud.
+	defaultGCPMetadataHost = "metadata.google.internal"
+)
+
+// gcpKeyVersionNameRegexp matches names of Google Cloud KMS key versions.
+var gcpKeyVersionNameRegexp = regexp.MustCompile(`^projects/[^/]+/locations/[^/]+/keyRings/[^/]+/cryptoKeys/[^/]+/cryptoKeyVersions/[^/]+$`)
+
+// GCPOptions configure NewGCPKey.
+type GCPOptions struct {
+	// Endpoint is the URL of the Cloud KMS API. If "", the public endpoint is used.
+	Endpoint string
+	// AccessToken is an OAuth 2.0 access token used to authenticate. If "", $GOOGLE_OAUTH_ACCESS_TOKEN is used if set,
+	// otherwise a token of the default service account is obtained from the GCE metadata server
+	// (at $GCE_METADATA_HOST, or metadata.google.internal).
+	AccessToken string
+	HTTPClient  *http.Client // If nil, http.DefaultClient is used
+}
+
+// gcpKey is a sigstore.KMSKey held by Google Cloud KMS.
+type gcpKey struct {
+	name         string
+	endpoint     string
+	accessToken  string // or "" if it should be obtained from metadataHost
+	metadataHost string
+	httpClient   *http.Client
+}
+
+// NewGCPKey returns a sigstore.KMSKey for a Google Cloud KMS key version named
+// projects/PROJECT/locations/LOCATION/keyRings/RING/cryptoKeys/KEY/cryptoKeyVersions/VERSION.
+// The key must have the ASYMMETRIC_SIGN purpose, and an EC_SIGN_P256_SHA256 or RSA_SIGN_PKCS1_*_SHA256 algorithm.
+func NewGCPKey(name string, options GCPOptions) (sigstore.KMSKey, error) {
+	if !gcpKeyVersionNameRegexp.MatchString(name) {
+		return nil, errors.Errorf("invalid Google Cloud KMS key version name %q", name)
+	}
+	endpoint := options.Endpoint
+	if endpoint == "" {
+		endpoint = defaultGCPEndpoint
+	}
+	accessToken := options.AccessToken
+	if accessToken == "" {
+		accessToken = os.Getenv("GOOGLE_OAUTH_ACCESS_TOKEN")
+	}
+	metadataHost := os.Getenv("GCE_METADATA_HOST")
+	if metadataHost == "" {
+		metadataHost = defaultGCPMetadataHost
+	}
+	return &gcpKey{
+		name:         name,
+		endpoint:     strings.TrimSuffix(endpoint, "/"),
+		accessToken:  accessToken,
+		metadataHost: metadataHost,
+		httpClient:   httpClientOrDefault(options.HTTPClient),
+	}, nil
+}
+
+// PublicKey implements sigstore.KMSKey.
+func (k *gcpKey) PublicKey(ctx context.Context) (crypto.PublicKey, error) {
+	var res struct {
+		PEM       string `json:"pem"`
+		Algorithm string `json:"algorithm"`
+	}
+	if err := k.call(ctx, http.MethodGet, "/v1/"+k.name+"/publicKey", nil, &res); err != nil {
+		return nil, err
+	}
+	switch res.Algorithm {
+	case "EC_SIGN_P256_SHA256", "RSA_SIGN_PKCS1_2048_SHA256", "RSA_SIGN_PKCS1_3072_SHA256", "RSA_SIGN_PKCS1_4096_SHA256":
+	default:
+		return nil, unsupportedAlgorithmError(res.Algorithm)
+	}
+	publicKey, err := parsePublicKeyPEM([]byte(res.PEM))
+	if err != nil {
+		return nil, errors.Wrap(err, "parsing the Google Cloud KMS public key")
+	}
+	return publicKey, nil
+}
+
+// SignDigest implements sigstore.KMSKey.
+func (k *gcpKey) SignDigest(ctx context.Context, digest []byte) ([]byte, error) {
+	var res struct {
+		Signature []byte `json:"signature"` // base64-encoded in JSON; ASN.1 DER for ECDSA
+	}
+	if err := k.call(ctx, http.MethodPost, "/v1/"+k.name+":asymmetricSign", map[string]interface{}{
+		"digest": map[string][]byte{"sha256": digest},
+	}, &res); err != nil {
+		return nil, err
+	}
+	return res.Signature, nil
+}
+
+// call sends a method request to path, with body encoded as JSON if it is not nil, and decodes the response into res.
+func (k *gcpKey) call(ctx context.Context, method, path string, body interface{}, res interface{}) error {
+	token, err := k.token(ctx)
+	if err != nil {
+		return err
+	}
+	req, _, err := newJSONRequest(ctx, method, k.endpoint+path, body)
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Authorization", "Bearer "+token)
+	return doJSON(k.httpClient, req, "Google Cloud KMS", res, gcpErrorMessage)
+}
+
+// token returns an access token to authenticate to the Cloud KMS API.
+func (k *gcpKey) token(ctx context.Context) (string, error) {
+	if k.accessToken != "" {
+		return k.accessToken, nil
+	}
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet,
+		"http://"+k.metadataHost+"/computeMetadata/v1/instance/service-accounts/default/token", nil)
+	if err != nil {
+		return "", err
+	}
+	req.Header.Set("Metadata-Flavor", "Google")
+	var res struct {
+		AccessToken string `json:"access_token"`
+	}
+	if err := doJSON(k.httpClient, req, "the GCE metadata server", &res, nil); err != nil {
+		return "", errors.Wrap(err, "obtaining a Google Cloud access token")
+	}
+	if res.AccessToken == "" {
+		return "", errors.New("the GCE metadata server did not return an access token")
+	}
+	return res.AccessToken, nil
+}
+
+// gcpErrorMessage returns the message of a Google Cloud API error response body.
+func gcpErrorMessage(body []byte) string {
+	var e struct {
+		Error struct {
+			Status  string `json:"status"`
+			Message string `json:"message"`
+		} `json:"error"`
+	}
+	if json.Unmarshal(body, &e) != nil {
+		return ""
+	}
+	return strings.TrimSpace(e.Error.Status + " " + e.Error.Message)
+}
@@ -0,0 +1,122 @@
+package kms
+
+import (
+	"context"
+	"crypto"
+	"crypto/rand"
+	"crypto/rsa"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+const testGCPKeyName = "projects/p/locations/global/keyRings/r/cryptoKeys/k/cryptoKeyVersions/1"
+
+// newTestGCPKMS returns a fake Google Cloud KMS server, and a GCE metadata server, for key using algorithm.
+func newTestGCPKMS(t *testing.T, key crypto.Signer, algorithm string) (kms *httptest.Server, metadata *httptest.Server) {
+	kms = httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		writeError := func(status int, statusName, message string) {
+			w.WriteHeader(status)
+			_ = json.NewEncoder(w).Encode(map[string]interface{}{
+				"error": map[string]interface{}{"code": status, "status": statusName, "message": message},
+			})
+		}
+		if r.Header.Get("Authorization") != "Bearer gcp-token" {
+			writeError(http.StatusUnauthorized, "UNAUTHENTICATED", "invalid credentials")
+			return
+		}
+		switch {
+		case r.Method == http.MethodGet && r.URL.Path == "/v1/"+testGCPKeyName+"/publicKey":
+			_ = json.NewEncoder(w).Encode(map[string]string{
+				"pem": string(testPublicKeyPEM(t, key.Public())), "algorithm": algorithm,
+			})
+		case r.Method == http.MethodPost && r.URL.Path == "/v1/"+testGCPKeyName+":asymmetricSign":
+			var req struct {
+				Digest struct {
+					SHA256 []byte `json:"sha256"`
+				} `json:"digest"`
+			}
+			if err := json.NewDecoder(r.Body).Decode(&req); err != nil || len(req.Digest.SHA256) != 32 {
+				writeError(http.StatusBadRequest, "INVALID_ARGUMENT", "invalid digest")
+				return
+			}
+			sig, err := key.Sign(rand.Reader, req.Digest.SHA256, crypto.SHA256)
+			if err != nil {
+				writeError(http.StatusInternalServerError, "INTERNAL", err.Error())
+				return
+			}
+			_ = json.NewEncoder(w).Encode(map[string]interface{}{"signature": sig, "name": testGCPKeyName})
+		default:
+			writeError(http.StatusNotFound, "NOT_FOUND", "key not found")
+		}
+	}))
+	t.Cleanup(kms.Close)
+	metadata = httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.Header.Get("Metadata-Flavor") != "Google" || r.URL.Path != "/computeMetadata/v1/instance/service-accounts/default/token" {
+			w.WriteHeader(http.StatusForbidden)
+			return
+		}
+		_ = json.NewEncoder(w).Encode(map[string]interface{}{"access_token": "gcp-token", "expires_in": 3600, "token_type": "Bearer"})
+	}))
+	t.Cleanup(metadata.Close)
+	return kms, metadata
+}
+
+func TestGCPKey(t *testing.T) {
+	ctx := context.Background()
+	ecdsaKey := newTestECDSAKey(t)
+	rsaKey, err := rsa.GenerateKey(rand.Reader, 2048)
+	require.NoError(t, err)
+
+	for _, c := range []struct {
+		key       crypto.Signer
+		algorithm string
+	}{
+		{ecdsaKey, "EC_SIGN_P256_SHA256"},
+		{rsaKey, "RSA_SIGN_PKCS1_2048_SHA256"},
+	} {
+		server, _ := newTestGCPKMS(t, c.key, c.algorithm)
+		key, err := NewGCPKey(testGCPKeyName, GCPOptions{Endpoint: server.URL, AccessToken: "gcp-token"})
+		require.NoError(t, err)
+		assertSignsImages(t, key, c.key.Public())
+	}
+
+	// Access tokens from the metadata server
+	server, metadata := newTestGCPKMS(t, ecdsaKey, "EC_SIGN_P256_SHA256")
+	metadataURL, err := url.Parse(metadata.URL)
+	require.NoError(t, err)
+	setenv(t, map[string]string{"GOOGLE_OAUTH_ACCESS_TOKEN": "", "GCE_METADATA_HOST": metadataURL.Host})
+	key, err := NewGCPKey(testGCPKeyName, GCPOptions{Endpoint: server.URL})
+	require.NoError(t, err)
+	assertSignsImages(t, key, ecdsaKey.Public())
+
+	// Unsupported keys
+	for _, algorithm := range []string{"EC_SIGN_P384_SHA384", "RSA_SIGN_PSS_2048_SHA256", "GOOGLE_SYMMETRIC_ENCRYPTION"} {
+		server, _ := newTestGCPKMS(t, ecdsaKey, algorithm)
+		key, err := NewGCPKey(testGCPKeyName, GCPOptions{Endpoint: server.URL, AccessToken: "gcp-token"})
+		require.NoError(t, err)
+		_, err = key.PublicKey(ctx)
+		assert.Error(t, err, algorithm)
+	}
+
+	// Errors are reported
+	key, err = NewGCPKey(testGCPKeyName, GCPOptions{Endpoint: server.URL, AccessToken: "other"})
+	require.NoError(t, err)
+	_, err = key.PublicKey(ctx)
+	assert.ErrorContains(t, err, "UNAUTHENTICATED invalid credentials")
+	key, err = NewGCPKey("projects/p/locations/global/keyRings/r/cryptoKeys/other/cryptoKeyVersions/1",
+		GCPOptions{Endpoint: server.URL, AccessToken: "gcp-token"})
+	require.NoError(t, err)
+	_, err = key.SignDigest(ctx, make([]byte, 32))
+	assert.ErrorContains(t, err, "NOT_FOUND key not found")
+	setenv(t, map[string]string{"GCE_METADATA_HOST": metadataURL.Host + "/forbidden"})
+	key, err = NewGCPKey(testGCPKeyName, GCPOptions{Endpoint: server.URL})
+	require.NoError(t, err)
+	_, err = key.PublicKey(ctx)
+	assert.ErrorContains(t, err, "obtaining a Google Cloud access token")
+}
@@ -0,0 +1,151 @@
+// Package kms provides sigstore.KMSKey implementations for keys held by cloud key management services and HashiCorp Vault,
+// so that sigstore signatures can be created without ever exporting the private key.
+//
+// Keys can be created directly, using New*Key, or from URIs compatible with cosign (see NewKeyFromURI).
+// Credentials are read from the options, or from the environment variables conventionally used by each service.
+//
+// Note: Consider the API unstable until the code supports at least three different image formats or transports.
+package kms
+
+import (
+	"bytes"
+	"context"
+	"crypto"
+	"crypto/x509"
+	"encoding/json"
+	"encoding/pem"
+	"io"
+	"net/http"
+	"strings"
+
+	"github.com/containers/image/v5/internal/iolimits"
+	"github.com/containers/image/v5/signature/sigstore"
+	"github.com/pkg/errors"
+)
+
+// maxResponseSize is the maximum size of responses read from key management services, which are all small.
+const maxResponseSize = 1 << 20
+
+// URI schemes of keys accepted by NewKeyFromURI.
+const (
+	AWSKMSScheme     = "awskms"
+	GCPKMSScheme     = "gcpkms"
+	AzureKMSScheme   = "azurekms"
+	HashiVaultScheme = "hashivault"
+)
+
+// NewKeyFromURI returns a sigstore.KMSKey identified by keyURI, in one of the formats used by cosign:
+//   - awskms://[ENDPOINT]/KEY, where KEY is a key ID, a key ARN, an alias name (alias/NAME), or an alias ARN (see NewAWSKey)
+//   - gcpkms://projects/PROJECT/locations/LOCATION/keyRings/RING/cryptoKeys/KEY/cryptoKeyVersions/VERSION (see NewGCPKey)
+//   - azurekms://VAULT.vault.azure.net/KEY[/VERSION] (see NewAzureKey)
+//   - hashivault://KEY (see NewVaultKey)
+//
+// Other options are read from the environment; httpClient, if not nil, is used to contact the service.
+func NewKeyFromURI(keyURI string, httpClient *http.Client) (sigstore.KMSKey, error) {
+	parts := strings.SplitN(keyURI, "://", 2)
+	if len(parts) != 2 {
+		return nil, errors.Errorf("invalid key URI %q", keyURI)
+	}
+	scheme, rest := parts[0], parts[1]
+	switch scheme {
+	case AWSKMSScheme:
+		i := strings.IndexByte(rest, '/')
+		if i == -1 || i == len(rest)-1 {
+			return nil, errors.Errorf("invalid AWS KMS key URI %q", keyURI)
+		}
+		endpoint, keyID := rest[:i], rest[i+1:]
+		options := AWSOptions{HTTPClient: httpClient}
+		if endpoint != "" {
+			options.Endpoint = "https://" + endpoint
+		}
+		return NewAWSKey(keyID, options)
+	case GCPKMSScheme:
+		return NewGCPKey(rest, GCPOptions{HTTPClient: httpClient})
+	case AzureKMSScheme:
+		parts = strings.Split(rest, "/")
+		if len(parts) < 2 || len(parts) > 3 || parts[0] == "" || parts[1] == "" {
+			return nil, errors.Errorf("invalid Azure Key Vault key URI %q", keyURI)
+		}
+		version := ""
+		if len(parts) == 3 {
+			version = parts[2]
+		}
+		return NewAzureKey("https://"+parts[0], parts[1], version, AzureOptions{HTTPClient: httpClient})
+	case HashiVaultScheme:
+		return NewVaultKey(rest, VaultOptions{HTTPClient: httpClient})
+	default:
+		return nil, errors.Errorf("unsupported key URI scheme %q", scheme)
+	}
+}
+
+// httpClientOrDefault returns client if it is not nil, or http.DefaultClient.
+func httpClientOrDefault(client *http.Client) *http.Client {
+	if client == nil {
+		return http.DefaultClient
+	}
+	return client
+}
+
+// newJSONRequest returns a request for method and url, with body encoded as JSON if it is not nil.
+func newJSONRequest(ctx context.Context, method, url string, body interface{}) (*http.Request, []byte, error) {
+	var bodyBytes []byte
+	var bodyReader io.Reader
+	if body != nil {
+		b, err := json.Marshal(body)
+		if err != nil {
+			return nil, nil, err
+		}
+		bodyBytes = b
+		bodyReader = bytes.NewReader(b)
+	}
+	req, err := http.NewRequestWithContext(ctx, method, url, bodyReader)
+	if err != nil {
+		return nil, nil, err
+	}
+	if body != nil {
+		req.Header.Set("Content-Type", "application/json")
+	}
+	return req, bodyBytes, nil
+}
+
+// doJSON sends req using client, and decodes the JSON response into res.
+// If the response is unsuccessful, errorMessage is used to extract a message from the response body.
+func doJSON(client *http.Client, req *http.Request, serviceName string, res interface{}, errorMessage func(body []byte) string) error {
+	resp, err := client.Do(req)
+	if err != nil {
+		return errors.Wrapf(err, "contacting %s", serviceName)
+	}
+	defer resp.Body.Close()
+	body, err := iolimits.ReadAtMost(resp.Body, maxResponseSize)
+	if err != nil {
+		return errors.Wrapf(err, "reading the %s response", serviceName)
+	}
+	if resp.StatusCode < 200 || resp.StatusCode > 299 {
+		msg := ""
+		if errorMessage != nil {
+			msg = errorMessage(body)
+		}
+		if msg == "" {
+			msg = http.StatusText(resp.StatusCode)
+		}
+		return errors.Errorf("%s request failed with status %d: %s", serviceName, resp.StatusCode, msg)
+	}
+	if err := json.Unmarshal(body, res); err != nil {
+		return errors.Wrapf(err, "decoding the %s response", serviceName)
+	}
+	return nil
+}
+
+// parsePublicKeyPEM returns the single public key in pemData.
+func parsePublicKeyPEM(pemData []byte) (crypto.PublicKey, error) {
+	block, rest := pem.Decode(pemData)
+	if block == nil || block.Type != "PUBLIC KEY" || len(bytes.TrimSpace(rest)) != 0 {
+		return nil, errors.New("invalid PEM-encoded public key")
+	}
+	return x509.ParsePKIXPublicKey(block.Bytes)
+}
+
+// unsupportedAlgorithmError returns an error for an unsupported key algorithm.
+func unsupportedAlgorithmError(algorithm string) error {
+	return errors.Errorf("unsupported key algorithm %q, only ECDSA P-256 with SHA-256 and RSA PKCS #1 v1.5 with SHA-256 are supported", algorithm)
+}
@@ -0,0 +1,174 @@
+package kms
+
+import (
+	"context"
+	"crypto"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/x509"
+	"encoding/base64"
+	"encoding/pem"
+	"net/http"
+	"os"
+	"testing"
+
+	"github.com/containers/image/v5/signature/sigstore"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// setenv sets environment variables for the duration of a test.
+func setenv(t *testing.T, values map[string]string) {
+	for name, value := range values {
+		name := name
+		old, hadOld := os.LookupEnv(name)
+		require.NoError(t, os.Setenv(name, value))
+		t.Cleanup(func() {
+			if hadOld {
+				_ = os.Setenv(name, old)
+			} else {
+				_ = os.Unsetenv(name)
+			}
+		})
+	}
+}
+
+// testPublicKeyPEM returns the PEM encoding of publicKey.
+func testPublicKeyPEM(t *testing.T, publicKey crypto.PublicKey) []byte {
+	der, err := x509.MarshalPKIXPublicKey(publicKey)
+	require.NoError(t, err)
+	return pem.EncodeToMemory(&pem.Block{Type: "PUBLIC KEY", Bytes: der})
+}
+
+// newTestECDSAKey returns a new P-256 private key.
+func newTestECDSAKey(t *testing.T) *ecdsa.PrivateKey {
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	require.NoError(t, err)
+	return key
+}
+
+// assertSignsImages verifies that sigstore signatures created using key are valid signatures by publicKey.
+func assertSignsImages(t *testing.T, key sigstore.KMSKey, publicKey crypto.PublicKey) {
+	ctx := context.Background()
+	signer, err := sigstore.NewSignerFromKMSKey(ctx, key)
+	require.NoError(t, err)
+	pemData, err := signer.PublicKeyPEM()
+	require.NoError(t, err)
+	assert.Equal(t, testPublicKeyPEM(t, publicKey), pemData)
+	sig, err := signer.SignImage(ctx, []byte(`{"schemaVersion":2}`), "example.com/repo:tag")
+	require.NoError(t, err)
+	rawSig, err := base64.StdEncoding.DecodeString(sig.Annotations[sigstore.SignatureAnnotationKey])
+	require.NoError(t, err)
+	assert.NoError(t, sigstore.VerifySignature(publicKey, sig.Payload, rawSig))
+}
+
+func TestNewKeyFromURI(t *testing.T) {
+	setenv(t, map[string]string{
+		"AWS_REGION":                 "us-east-1",
+		"AWS_ACCESS_KEY_ID":          "AKIDEXAMPLE",
+		"AWS_SECRET_ACCESS_KEY":      "secret",
+		"AWS_SESSION_TOKEN":          "",
+		"GOOGLE_OAUTH_ACCESS_TOKEN":  "gcp-token",
+		"AZURE_TENANT_ID":            "tenant",
+		"AZURE_CLIENT_ID":            "client",
+		"AZURE_CLIENT_SECRET":        "client-secret",
+		"AZURE_AUTHORITY_HOST":       "",
+		"VAULT_ADDR":                 "https://vault.example.com:8200",
+		"VAULT_TOKEN":                "vault-token",
+		"VAULT_NAMESPACE":            "",
+		"TRANSIT_SECRET_ENGINE_PATH": "",
+	})
+	httpClient := &http.Client{}
+
+	key, err := NewKeyFromURI("awskms:///alias/signing", httpClient)
+	require.NoError(t, err)
+	aws, ok := key.(*awsKey)
+	require.True(t, ok)
+	assert.Equal(t, "alias/signing", aws.keyID)
+	assert.Equal(t, "us-east-1", aws.region)
+	assert.Equal(t, "https://kms.us-east-1.amazonaws.com/", aws.endpoint)
+	assert.Equal(t, httpClient, aws.httpClient)
+	key, err = NewKeyFromURI("awskms://localhost:4566/arn:aws:kms:eu-west-1:111122223333:key/1234abcd-12ab-34cd-56ef-1234567890ab", nil)
+	require.NoError(t, err)
+	aws, ok = key.(*awsKey)
+	require.True(t, ok)
+	assert.Equal(t, "arn:aws:kms:eu-west-1:111122223333:key/1234abcd-12ab-34cd-56ef-1234567890ab", aws.keyID)
+	assert.Equal(t, "eu-west-1", aws.region)
+	assert.Equal(t, "https://localhost:4566/", aws.endpoint)
+	assert.Equal(t, http.DefaultClient, aws.httpClient)
+
+	key, err = NewKeyFromURI("gcpkms://projects/p/locations/global/keyRings/r/cryptoKeys/k/cryptoKeyVersions/1", nil)
+	require.NoError(t, err)
+	gcp, ok := key.(*gcpKey)
+	require.True(t, ok)
+	assert.Equal(t, "projects/p/locations/global/keyRings/r/cryptoKeys/k/cryptoKeyVersions/1", gcp.name)
+	assert.Equal(t, defaultGCPEndpoint, gcp.endpoint)
+	assert.Equal(t, "gcp-token", gcp.accessToken)
+
+	key, err = NewKeyFromURI("azurekms://myvault.vault.azure.net/signing", nil)
+	require.NoError(t, err)
+	azure, ok := key.(*azureKey)
+	require.True(t, ok)
+	assert.Equal(t, "https://myvault.vault.azure.net", azure.vaultURL)
+	assert.Equal(t, "signing", azure.name)
+	assert.Equal(t, "", azure.version)
+	assert.Equal(t, "tenant", azure.tenantID)
+	assert.Equal(t, defaultAzureAuthorityHost, azure.authorityHost)
+	key, err = NewKeyFromURI("azurekms://myvault.vault.azure.net/signing/0123456789abcdef", nil)
+	require.NoError(t, err)
+	azure, ok = key.(*azureKey)
+	require.True(t, ok)
+	assert.Equal(t, "0123456789abcdef", azure.version)
+
+	key, err = NewKeyFromURI("hashivault://signing", nil)
+	require.NoError(t, err)
+	vault, ok := key.(*vaultKey)
+	require.True(t, ok)
+	assert.Equal(t, "signing", vault.name)
+	assert.Equal(t, "https://vault.example.com:8200", vault.address)
+	assert.Equal(t, "vault-token", vault.token)
+	assert.Equal(t, defaultVaultTransitPath, vault.transitPath)
+
+	for _, uri := range []string{
+		"",
+		"no scheme",
+		"unknown://key",
+		"awskms://",
+		"awskms://localhost",
+		"awskms://localhost/",
+		"gcpkms://projects/p/locations/global/keyRings/r/cryptoKeys/k", // No version
+		"azurekms://myvault.vault.azure.net",
+		"azurekms://myvault.vault.azure.net/",
+		"azurekms:///signing",
+		"azurekms://myvault.vault.azure.net/signing/version/extra",
+		"hashivault://",
+		"hashivault://a/b",
+	} {
+		_, err := NewKeyFromURI(uri, nil)
+		assert.Error(t, err, uri)
+	}
+}
+
+func TestParsePublicKeyPEM(t *testing.T) {
+	key := newTestECDSAKey(t)
+	keyPEM := testPublicKeyPEM(t, &key.PublicKey)
+
+	publicKey, err := parsePublicKeyPEM(keyPEM)
+	require.NoError(t, err)
+	assert.Equal(t, &key.PublicKey, publicKey)
+	publicKey, err = parsePublicKeyPEM(append(append([]byte{}, keyPEM...), '\n'))
+	require.NoError(t, err)
+	assert.Equal(t, &key.PublicKey, publicKey)
+
+	for _, c := range [][]byte{
+		nil,
+		[]byte("not PEM"),
+		append(append([]byte{}, keyPEM...), keyPEM...),
+		pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: []byte("abc")}),
+		pem.EncodeToMemory(&pem.Block{Type: "PUBLIC KEY", Bytes: []byte("abc")}),
+	} {
+		_, err := parsePublicKeyPEM(c)
+		assert.Error(t, err)
+	}
+}
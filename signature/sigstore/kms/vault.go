@@ -0,0 +1,189 @@
+package kms
+
+import (
+	"context"
+	"crypto"
+	"encoding/base64"
+	"encoding/json"
+	"net/http"
+	"net/url"
+	"os"
+	"strconv"
+	"strings"
+	"sync"
+
+	"github.com/containers/image/v5/signature/sigstore"
+	"github.com/pkg/errors"
+)
+
+// defaultVaultTransitPath is the default mount path of the Vault transit secrets engine.
+const defaultVaultTransitPath = "transit"
+
+// VaultOptions configure NewVaultKey.
+type VaultOptions struct {
+	// Address is the URL of the Vault server. If "", $VAULT_ADDR is used.
+	Address string
+	// Token is the Vault token used to authenticate. If "", $VAULT_TOKEN is used.
+	Token string
+	// Namespace is the Vault Enterprise namespace, if any. If "", $VAULT_NAMESPACE is used.
+	Namespace string
+	// TransitPath is the mount path of the transit secrets engine. If "", $TRANSIT_SECRET_ENGINE_PATH or "transit" is used.
+	TransitPath string
+	HTTPClient  *http.Client // If nil, http.DefaultClient is used
+}
+
+// vaultKey is a sigstore.KMSKey held by the HashiCorp Vault transit secrets engine.
+type vaultKey struct {
+	name        string
+	address     string
+	token       string
+	namespace   string
+	transitPath string
+	httpClient  *http.Client
+
+	mutex   sync.Mutex
+	keyType string // Set by PublicKey
+	version int    // Set by PublicKey
+}
+
+// NewVaultKey returns a sigstore.KMSKey for the key name in the HashiCorp Vault transit secrets engine.
+// The key must be of the ecdsa-p256 or rsa-* type.
+func NewVaultKey(name string, options VaultOptions) (sigstore.KMSKey, error) {
+	if name == "" || strings.Contains(name, "/") {
+		return nil, errors.Errorf("invalid Vault key name %q", name)
+	}
+	k := &vaultKey{
+		name:        name,
+		address:     options.Address,
+		token:       options.Token,
+		namespace:   options.Namespace,
+		transitPath: options.TransitPath,
+		httpClient:  httpClientOrDefault(options.HTTPClient),
+	}
+	if k.address == "" {
+		k.address = os.Getenv("VAULT_ADDR")
+	}
+	if k.address == "" {
+		return nil, errors.New("Vault address not specified")
+	}
+	if u, err := url.Parse(k.address); err != nil || (u.Scheme != "https" && u.Scheme != "http") {
+		return nil, errors.Errorf("invalid Vault address %q", k.address)
+	}
+	k.address = strings.TrimSuffix(k.address, "/")
+	if k.token == "" {
+		k.token = os.Getenv("VAULT_TOKEN")
+	}
+	if k.token == "" {
+		return nil, errors.New("Vault token not specified")
+	}
+	if k.namespace == "" {
+		k.namespace = os.Getenv("VAULT_NAMESPACE")
+	}
+	if k.transitPath == "" {
+		k.transitPath = os.Getenv("TRANSIT_SECRET_ENGINE_PATH")
+	}
+	if k.transitPath == "" {
+		k.transitPath = defaultVaultTransitPath
+	}
+	k.transitPath = strings.Trim(k.transitPath, "/")
+	return k, nil
+}
+
+// PublicKey implements sigstore.KMSKey.
+func (k *vaultKey) PublicKey(ctx context.Context) (crypto.PublicKey, error) {
+	var res struct {
+		Data struct {
+			Type          string `json:"type"`
+			LatestVersion int    `json:"latest_version"`
+			Keys          map[string]struct {
+				PublicKey string `json:"public_key"`
+			} `json:"keys"`
+		} `json:"data"`
+	}
+	if err := k.call(ctx, http.MethodGet, "/keys/"+url.PathEscape(k.name), nil, &res); err != nil {
+		return nil, err
+	}
+	switch res.Data.Type {
+	case "ecdsa-p256", "rsa-2048", "rsa-3072", "rsa-4096":
+	default:
+		return nil, unsupportedAlgorithmError(res.Data.Type)
+	}
+	version, ok := res.Data.Keys[strconv.Itoa(res.Data.LatestVersion)]
+	if !ok {
+		return nil, errors.Errorf("Vault did not return version %d of key %s", res.Data.LatestVersion, k.name)
+	}
+	publicKey, err := parsePublicKeyPEM([]byte(version.PublicKey))
+	if err != nil {
+		return nil, errors.Wrap(err, "parsing the Vault public key")
+	}
+	k.mutex.Lock()
+	k.keyType = res.Data.Type
+	k.version = res.Data.LatestVersion
+	k.mutex.Unlock()
+	return publicKey, nil
+}
+
+// SignDigest implements sigstore.KMSKey.
+func (k *vaultKey) SignDigest(ctx context.Context, digest []byte) ([]byte, error) {
+	k.mutex.Lock()
+	keyType := k.keyType
+	k.mutex.Unlock()
+	if keyType == "" {
+		if _, err := k.PublicKey(ctx); err != nil {
+			return nil, err
+		}
+	}
+	k.mutex.Lock()
+	keyType, version := k.keyType, k.version
+	k.mutex.Unlock()
+
+	body := map[string]interface{}{
+		"input":       base64.StdEncoding.EncodeToString(digest),
+		"prehashed":   true,
+		"key_version": version, // The version of the public key returned by PublicKey
+	}
+	if strings.HasPrefix(keyType, "rsa-") {
+		body["signature_algorithm"] = "pkcs1v15"
+	} else {
+		body["marshaling_algorithm"] = "asn1"
+	}
+	var res struct {
+		Data struct {
+			Signature string `json:"signature"` // vault:vVERSION:BASE64
+		} `json:"data"`
+	}
+	if err := k.call(ctx, http.MethodPost, "/sign/"+url.PathEscape(k.name)+"/sha2-256", body, &res); err != nil {
+		return nil, err
+	}
+	parts := strings.SplitN(res.Data.Signature, ":", 3)
+	if len(parts) != 3 || parts[0] != "vault" {
+		return nil, errors.Errorf("unexpected signature format %q in Vault response", res.Data.Signature)
+	}
+	sig, err := base64.StdEncoding.DecodeString(parts[2])
+	if err != nil {
+		return nil, errors.Wrap(err, "decoding the Vault signature")
+	}
+	return sig, nil
+}
+
+// call sends a method request to path in the transit secrets engine, with body encoded as JSON if it is not nil,
+// and decodes the response into res.
+func (k *vaultKey) call(ctx context.Context, method, path string, body interface{}, res interface{}) error {
+	req, _, err := newJSONRequest(ctx, method, k.address+"/v1/"+k.transitPath+path, body)
+	if err != nil {
+		return err
+	}
+	req.Header.Set("X-Vault-Token", k.token)
+	if k.namespace != "" {
+		req.Header.Set("X-Vault-Namespace", k.namespace)
+	}
+	return doJSON(k.httpClient, req, "Vault", res, func(body []byte) string {
+		var e struct {
+			Errors []string `json:"errors"`
+		}
+		if json.Unmarshal(body, &e) != nil {
+			return ""
+		}
+		return strings.Join(e.Errors, "; ")
+	})
+}
@@ -0,0 +1,163 @@
+package kms
+
+import (
+	"context"
+	"crypto"
+	"crypto/ecdsa"
+	"crypto/rand"
+	"crypto/rsa"
+	"encoding/base64"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// newTestVault returns a fake HashiCorp Vault server with a transit secrets engine at /v1/custom-transit.
+func newTestVault(t *testing.T, key crypto.Signer, keyType string) *httptest.Server {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		writeError := func(status int, message string) {
+			w.WriteHeader(status)
+			_ = json.NewEncoder(w).Encode(map[string][]string{"errors": {message}})
+		}
+		if r.Header.Get("X-Vault-Token") != "vault-token" || r.Header.Get("X-Vault-Namespace") != "ns" {
+			writeError(http.StatusForbidden, "permission denied")
+			return
+		}
+		switch {
+		case r.Method == http.MethodGet && r.URL.Path == "/v1/custom-transit/keys/signing":
+			_ = json.NewEncoder(w).Encode(map[string]interface{}{"data": map[string]interface{}{
+				"type":           keyType,
+				"latest_version": 2,
+				"keys": map[string]interface{}{
+					"1": map[string]string{"public_key": "invalid"},
+					"2": map[string]string{"public_key": string(testPublicKeyPEM(t, key.Public()))},
+				},
+			}})
+		case r.Method == http.MethodPost && r.URL.Path == "/v1/custom-transit/sign/signing/sha2-256":
+			var req struct {
+				Input               string `json:"input"`
+				Prehashed           bool   `json:"prehashed"`
+				KeyVersion          int    `json:"key_version"`
+				SignatureAlgorithm  string `json:"signature_algorithm"`
+				MarshalingAlgorithm string `json:"marshaling_algorithm"`
+			}
+			if err := json.NewDecoder(r.Body).Decode(&req); err != nil || !req.Prehashed || req.KeyVersion != 2 {
+				writeError(http.StatusBadRequest, "invalid request")
+				return
+			}
+			digest, err := base64.StdEncoding.DecodeString(req.Input)
+			if err != nil || len(digest) != 32 {
+				writeError(http.StatusBadRequest, "invalid input")
+				return
+			}
+			switch key.(type) {
+			case *ecdsa.PrivateKey:
+				if req.MarshalingAlgorithm != "asn1" {
+					writeError(http.StatusBadRequest, "invalid marshaling algorithm")
+					return
+				}
+			case *rsa.PrivateKey:
+				if req.SignatureAlgorithm != "pkcs1v15" {
+					writeError(http.StatusBadRequest, "invalid signature algorithm")
+					return
+				}
+			}
+			sig, err := key.Sign(rand.Reader, digest, crypto.SHA256)
+			if err != nil {
+				writeError(http.StatusInternalServerError, err.Error())
+				return
+			}
+			_ = json.NewEncoder(w).Encode(map[string]interface{}{"data": map[string]interface{}{
+				"signature":   "vault:v2:" + base64.StdEncoding.EncodeToString(sig),
+				"key_version": 2,
+			}})
+		default:
+			writeError(http.StatusNotFound, "no handler for route")
+		}
+	}))
+	t.Cleanup(server.Close)
+	return server
+}
+
+func TestVaultKey(t *testing.T) {
+	ctx := context.Background()
+	ecdsaKey := newTestECDSAKey(t)
+	rsaKey, err := rsa.GenerateKey(rand.Reader, 2048)
+	require.NoError(t, err)
+	options := func(server *httptest.Server) VaultOptions {
+		return VaultOptions{Address: server.URL, Token: "vault-token", Namespace: "ns", TransitPath: "/custom-transit/"}
+	}
+
+	for _, c := range []struct {
+		key     crypto.Signer
+		keyType string
+	}{
+		{ecdsaKey, "ecdsa-p256"},
+		{rsaKey, "rsa-2048"},
+	} {
+		server := newTestVault(t, c.key, c.keyType)
+		key, err := NewVaultKey("signing", options(server))
+		require.NoError(t, err)
+		assertSignsImages(t, key, c.key.Public())
+
+		// SignDigest reads the public key if necessary.
+		key, err = NewVaultKey("signing", options(server))
+		require.NoError(t, err)
+		_, err = key.SignDigest(ctx, make([]byte, 32))
+		require.NoError(t, err)
+	}
+
+	// Unsupported keys
+	for _, keyType := range []string{"aes256-gcm96", "ecdsa-p384", "ed25519"} {
+		server := newTestVault(t, ecdsaKey, keyType)
+		key, err := NewVaultKey("signing", options(server))
+		require.NoError(t, err)
+		_, err = key.PublicKey(ctx)
+		assert.Error(t, err, keyType)
+	}
+
+	// Errors are reported
+	server := newTestVault(t, ecdsaKey, "ecdsa-p256")
+	key, err := NewVaultKey("other", options(server))
+	require.NoError(t, err)
+	_, err = key.PublicKey(ctx)
+	assert.ErrorContains(t, err, "no handler for route")
+	wrongToken := options(server)
+	wrongToken.Token = "other"
+	key, err = NewVaultKey("signing", wrongToken)
+	require.NoError(t, err)
+	_, err = key.SignDigest(ctx, make([]byte, 32))
+	assert.ErrorContains(t, err, "permission denied")
+}
+
+func TestNewVaultKey(t *testing.T) {
+	setenv(t, map[string]string{
+		"VAULT_ADDR": "https://vault.example.com:8200/", "VAULT_TOKEN": "env-token",
+		"VAULT_NAMESPACE": "env-ns", "TRANSIT_SECRET_ENGINE_PATH": "env-transit",
+	})
+
+	key, err := NewVaultKey("signing", VaultOptions{})
+	require.NoError(t, err)
+	k := key.(*vaultKey)
+	assert.Equal(t, "https://vault.example.com:8200", k.address)
+	assert.Equal(t, "env-token", k.token)
+	assert.Equal(t, "env-ns", k.namespace)
+	assert.Equal(t, "env-transit", k.transitPath)
+
+	for _, name := range []string{"", "a/b"} {
+		_, err := NewVaultKey(name, VaultOptions{})
+		assert.Error(t, err, name)
+	}
+	_, err = NewVaultKey("signing", VaultOptions{Address: "vault.example.com"})
+	assert.Error(t, err)
+	setenv(t, map[string]string{"VAULT_TOKEN": ""})
+	_, err = NewVaultKey("signing", VaultOptions{})
+	assert.Error(t, err)
+	setenv(t, map[string]string{"VAULT_ADDR": ""})
+	_, err = NewVaultKey("signing", VaultOptions{Token: "token"})
+	assert.Error(t, err)
+}
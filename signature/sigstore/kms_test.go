@@ -0,0 +1,108 @@
+package sigstore
+
+import (
+	"context"
+	"crypto"
+	"crypto/ecdsa"
+	"crypto/ed25519"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/rsa"
+	"encoding/base64"
+	"errors"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// testKMSKey is a KMSKey using a local private key.
+type testKMSKey struct {
+	key          crypto.Signer
+	publicKeyErr error
+	// If not nil, signDigest is used instead of key
+	signDigest func(digest []byte) ([]byte, error)
+}
+
+func (k *testKMSKey) PublicKey(ctx context.Context) (crypto.PublicKey, error) {
+	if k.publicKeyErr != nil {
+		return nil, k.publicKeyErr
+	}
+	return k.key.Public(), nil
+}
+
+func (k *testKMSKey) SignDigest(ctx context.Context, digest []byte) ([]byte, error) {
+	if k.signDigest != nil {
+		return k.signDigest(digest)
+	}
+	return k.key.Sign(rand.Reader, digest, crypto.SHA256)
+}
+
+func TestNewSignerFromKMSKey(t *testing.T) {
+	ctx := context.Background()
+	ecdsaKey, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	require.NoError(t, err)
+	rsaKey, err := rsa.GenerateKey(rand.Reader, 2048)
+	require.NoError(t, err)
+	m := []byte(`{"schemaVersion":2}`)
+
+	for _, key := range []crypto.Signer{ecdsaKey, rsaKey} {
+		signer, err := NewSignerFromKMSKey(ctx, &testKMSKey{key: key})
+		require.NoError(t, err)
+		sig, err := signer.SignImage(ctx, m, "example.com/repo:tag")
+		require.NoError(t, err)
+		rawSig, err := base64.StdEncoding.DecodeString(sig.Annotations[SignatureAnnotationKey])
+		require.NoError(t, err)
+		assert.NoError(t, VerifySignature(key.Public(), sig.Payload, rawSig))
+		payload, b64Sig, err := signer.SignDockerManifest(m, "example.com/repo:tag")
+		require.NoError(t, err)
+		rawSig, err = base64.StdEncoding.DecodeString(b64Sig)
+		require.NoError(t, err)
+		assert.NoError(t, VerifySignature(key.Public(), payload, rawSig))
+	}
+
+	// Unsupported keys
+	p384Key, err := ecdsa.GenerateKey(elliptic.P384(), rand.Reader)
+	require.NoError(t, err)
+	_, ed25519Key, err := ed25519.GenerateKey(rand.Reader)
+	require.NoError(t, err)
+	for _, key := range []crypto.Signer{p384Key, ed25519Key} {
+		_, err := NewSignerFromKMSKey(ctx, &testKMSKey{key: key})
+		assert.Error(t, err)
+	}
+	// Failure reading the public key
+	_, err = NewSignerFromKMSKey(ctx, &testKMSKey{key: ecdsaKey, publicKeyErr: errors.New("access denied")})
+	assert.ErrorContains(t, err, "access denied")
+
+	// Signing failures, and invalid signatures, are reported.
+	for _, signDigest := range []func(digest []byte) ([]byte, error){
+		func(digest []byte) ([]byte, error) { return nil, errors.New("access denied") },
+		func(digest []byte) ([]byte, error) { return rsaKey.Sign(rand.Reader, digest, crypto.SHA256) },
+	} {
+		signer, err := NewSignerFromKMSKey(ctx, &testKMSKey{key: ecdsaKey, signDigest: signDigest})
+		require.NoError(t, err)
+		_, err = signer.SignImage(ctx, m, "example.com/repo:tag")
+		assert.Error(t, err)
+	}
+}
+
+func TestSignerPublicKeyPEM(t *testing.T) {
+	ecdsaKey, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	require.NoError(t, err)
+	expected := publicKeyPEM(t, &ecdsaKey.PublicKey)
+
+	for _, signer := range []*Signer{
+		{key: ecdsaKey},
+		{kmsKey: &testKMSKey{key: ecdsaKey}, kmsPublicKey: &ecdsaKey.PublicKey},
+	} {
+		pemData, err := signer.PublicKeyPEM()
+		require.NoError(t, err)
+		assert.Equal(t, expected, pemData)
+		keys, err := ParsePublicKeys(pemData)
+		require.NoError(t, err)
+		assert.Len(t, keys, 1)
+	}
+
+	_, err = (&Signer{key: ecdsaKey, certificate: []byte("certificate")}).PublicKeyPEM()
+	assert.Error(t, err)
+}
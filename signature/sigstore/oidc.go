@@ -0,0 +1,369 @@
+package sigstore
+
+import (
+	"context"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/json"
+	"fmt"
+	"html"
+	"io"
+	"net"
+	"net/http"
+	"net/url"
+	"os"
+	"strings"
+
+	"github.com/pkg/errors"
+	"github.com/sirupsen/logrus"
+)
+
+const (
+	// DefaultOIDCIssuer is the OIDC issuer operated by the sigstore project, federating several identity providers.
+	DefaultOIDCIssuer = "https://oauth2.sigstore.dev/auth"
+	// DefaultOIDCClientID is the client ID used with DefaultOIDCIssuer.
+	DefaultOIDCClientID = "sigstore"
+	// identityTokenAudience is the audience of identity tokens accepted by Fulcio.
+	identityTokenAudience = "sigstore"
+	// identityTokenEnvVar is an environment variable which may contain an identity token, as used by cosign.
+	identityTokenEnvVar = "SIGSTORE_ID_TOKEN"
+)
+
+// IdentityTokenProvider obtains OIDC identity tokens, which Fulcio exchanges for short-lived signing certificates.
+type IdentityTokenProvider interface {
+	// IdentityToken returns a raw (JWT-encoded) identity token with the "sigstore" audience.
+	IdentityToken(ctx context.Context) (string, error)
+}
+
+// StaticIdentityToken is an IdentityTokenProvider which returns a token obtained by the caller.
+type StaticIdentityToken string
+
+// IdentityToken implements IdentityTokenProvider.
+func (t StaticIdentityToken) IdentityToken(ctx context.Context) (string, error) {
+	return string(t), nil
+}
+
+// IdentityTokenFile is an IdentityTokenProvider which reads a token from a file, e.g. a projected Kubernetes
+// service account token (which is periodically refreshed, so the file is read every time a token is needed).
+type IdentityTokenFile string
+
+// IdentityToken implements IdentityTokenProvider.
+func (path IdentityTokenFile) IdentityToken(ctx context.Context) (string, error) {
+	data, err := os.ReadFile(string(path))
+	if err != nil {
+		return "", errors.Wrap(err, "reading identity token")
+	}
+	return strings.TrimSpace(string(data)), nil
+}
+
+// GitHubActionsIdentityToken is an IdentityTokenProvider which obtains a token from the OIDC provider of GitHub Actions;
+// the workflow must have the "id-token: write" permission.
+type GitHubActionsIdentityToken struct {
+	HTTPClient *http.Client // If nil, http.DefaultClient is used
+}
+
+// IdentityToken implements IdentityTokenProvider.
+func (p GitHubActionsIdentityToken) IdentityToken(ctx context.Context) (string, error) {
+	requestURL, requestToken := os.Getenv("ACTIONS_ID_TOKEN_REQUEST_URL"), os.Getenv("ACTIONS_ID_TOKEN_REQUEST_TOKEN")
+	if requestURL == "" || requestToken == "" {
+		return "", errors.New("GitHub Actions identity tokens are not available (does the workflow have the id-token: write permission?)")
+	}
+	u, err := url.Parse(requestURL)
+	if err != nil {
+		return "", errors.Wrap(err, "parsing ACTIONS_ID_TOKEN_REQUEST_URL")
+	}
+	q := u.Query()
+	q.Set("audience", identityTokenAudience)
+	u.RawQuery = q.Encode()
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, u.String(), nil)
+	if err != nil {
+		return "", err
+	}
+	req.Header.Set("Authorization", "Bearer "+requestToken)
+	req.Header.Set("Accept", "application/json")
+	client := p.HTTPClient
+	if client == nil {
+		client = http.DefaultClient
+	}
+	res, err := client.Do(req)
+	if err != nil {
+		return "", errors.Wrap(err, "requesting GitHub Actions identity token")
+	}
+	defer res.Body.Close()
+	if res.StatusCode != http.StatusOK {
+		return "", serviceError("GitHub Actions", res)
+	}
+	var body struct {
+		Value string `json:"value"`
+	}
+	if err := json.NewDecoder(io.LimitReader(res.Body, maxResponseSize)).Decode(&body); err != nil {
+		return "", errors.Wrap(err, "parsing GitHub Actions identity token response")
+	}
+	if body.Value == "" {
+		return "", errors.New("GitHub Actions did not return an identity token")
+	}
+	return body.Value, nil
+}
+
+// AmbientIdentityToken returns an IdentityTokenProvider for a workload identity available in the current environment:
+// a token in the SIGSTORE_ID_TOKEN environment variable, or the OIDC provider of GitHub Actions.
+// It returns nil if no workload identity is available.
+func AmbientIdentityToken() IdentityTokenProvider {
+	if token := os.Getenv(identityTokenEnvVar); token != "" {
+		return StaticIdentityToken(token)
+	}
+	if os.Getenv("ACTIONS_ID_TOKEN_REQUEST_URL") != "" && os.Getenv("ACTIONS_ID_TOKEN_REQUEST_TOKEN") != "" {
+		return GitHubActionsIdentityToken{}
+	}
+	return nil
+}
+
+// InteractiveIdentityToken is an IdentityTokenProvider which obtains a token using the OIDC authorization code flow
+// (with PKCE), by asking the user to log in using a web browser; the browser is redirected to a temporary HTTP server
+// on the loopback interface.
+type InteractiveIdentityToken struct {
+	Issuer       string // If "", DefaultOIDCIssuer is used
+	ClientID     string // If "", DefaultOIDCClientID is used
+	ClientSecret string
+	// OpenURL is called with the URL the user must open in a web browser to log in, e.g. to start a browser.
+	// If nil, the URL is printed to os.Stderr.
+	OpenURL    func(url string) error
+	HTTPClient *http.Client // If nil, http.DefaultClient is used
+}
+
+// oidcProviderConfiguration is the subset of the OIDC discovery document used by InteractiveIdentityToken.
+type oidcProviderConfiguration struct {
+	AuthorizationEndpoint string `json:"authorization_endpoint"`
+	TokenEndpoint         string `json:"token_endpoint"`
+}
+
+// IdentityToken implements IdentityTokenProvider.
+func (p InteractiveIdentityToken) IdentityToken(ctx context.Context) (string, error) {
+	issuer, clientID := p.Issuer, p.ClientID
+	if issuer == "" {
+		issuer = DefaultOIDCIssuer
+	}
+	if clientID == "" {
+		clientID = DefaultOIDCClientID
+	}
+	client := p.HTTPClient
+	if client == nil {
+		client = http.DefaultClient
+	}
+	config, err := discoverOIDCProvider(ctx, client, issuer)
+	if err != nil {
+		return "", err
+	}
+
+	state, err := randomString()
+	if err != nil {
+		return "", err
+	}
+	nonce, err := randomString()
+	if err != nil {
+		return "", err
+	}
+	codeVerifier, err := randomString()
+	if err != nil {
+		return "", err
+	}
+	codeChallenge := sha256.Sum256([]byte(codeVerifier))
+
+	listener, err := net.Listen("tcp", "localhost:0")
+	if err != nil {
+		return "", errors.Wrap(err, "listening for the OIDC redirect")
+	}
+	redirectURL := fmt.Sprintf("http://localhost:%d/auth/callback", listener.Addr().(*net.TCPAddr).Port)
+	type callbackResult struct {
+		code string
+		err  error
+	}
+	results := make(chan callbackResult, 1)
+	mux := http.NewServeMux()
+	mux.HandleFunc("/auth/callback", func(w http.ResponseWriter, r *http.Request) {
+		q := r.URL.Query()
+		var res callbackResult
+		switch {
+		case q.Get("state") != state:
+			res.err = errors.New("OIDC redirect with unexpected state")
+		case q.Get("error") != "":
+			res.err = errors.Errorf("OIDC authentication failed: %s %s", q.Get("error"), q.Get("error_description"))
+		case q.Get("code") == "":
+			res.err = errors.New("OIDC redirect without an authorization code")
+		default:
+			res.code = q.Get("code")
+		}
+		if res.err != nil {
+			http.Error(w, html.EscapeString(res.err.Error()), http.StatusBadRequest)
+		} else {
+			fmt.Fprint(w, "<html><body>Authentication successful, you can close this page.</body></html>")
+		}
+		select {
+		case results <- res:
+		default: // Only the first redirect counts
+		}
+	})
+	server := &http.Server{Handler: mux}
+	go func() {
+		if err := server.Serve(listener); err != nil && err != http.ErrServerClosed {
+			logrus.Debugf("Error serving the OIDC redirect: %v", err)
+		}
+	}()
+	defer server.Close()
+
+	authURL, err := url.Parse(config.AuthorizationEndpoint)
+	if err != nil {
+		return "", errors.Wrap(err, "parsing OIDC authorization endpoint")
+	}
+	q := authURL.Query()
+	q.Set("response_type", "code")
+	q.Set("client_id", clientID)
+	q.Set("redirect_uri", redirectURL)
+	q.Set("scope", "openid email")
+	q.Set("state", state)
+	q.Set("nonce", nonce)
+	q.Set("code_challenge", base64.RawURLEncoding.EncodeToString(codeChallenge[:]))
+	q.Set("code_challenge_method", "S256")
+	authURL.RawQuery = q.Encode()
+	if p.OpenURL != nil {
+		if err := p.OpenURL(authURL.String()); err != nil {
+			return "", errors.Wrap(err, "opening the OIDC login page")
+		}
+	} else {
+		fmt.Fprintf(os.Stderr, "Open this URL in a web browser to log in:\n%s\n", authURL.String())
+	}
+
+	var code string
+	select {
+	case res := <-results:
+		if res.err != nil {
+			return "", res.err
+		}
+		code = res.code
+	case <-ctx.Done():
+		return "", errors.Wrap(ctx.Err(), "waiting for OIDC authentication")
+	}
+
+	token, err := exchangeAuthorizationCode(ctx, client, config.TokenEndpoint, url.Values{
+		"grant_type":    {"authorization_code"},
+		"code":          {code},
+		"redirect_uri":  {redirectURL},
+		"client_id":     {clientID},
+		"client_secret": {p.ClientSecret},
+		"code_verifier": {codeVerifier},
+	})
+	if err != nil {
+		return "", err
+	}
+	claims, err := parseIdentityToken(token)
+	if err != nil {
+		return "", err
+	}
+	if claims.Nonce != nonce {
+		return "", errors.New("OIDC identity token has an unexpected nonce")
+	}
+	return token, nil
+}
+
+// discoverOIDCProvider returns the configuration of the OIDC provider at issuer.
+func discoverOIDCProvider(ctx context.Context, client *http.Client, issuer string) (*oidcProviderConfiguration, error) {
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, strings.TrimSuffix(issuer, "/")+"/.well-known/openid-configuration", nil)
+	if err != nil {
+		return nil, err
+	}
+	res, err := client.Do(req)
+	if err != nil {
+		return nil, errors.Wrapf(err, "reading OIDC configuration of %s", issuer)
+	}
+	defer res.Body.Close()
+	if res.StatusCode != http.StatusOK {
+		return nil, serviceError("OIDC provider", res)
+	}
+	var config oidcProviderConfiguration
+	if err := json.NewDecoder(io.LimitReader(res.Body, maxResponseSize)).Decode(&config); err != nil {
+		return nil, errors.Wrapf(err, "parsing OIDC configuration of %s", issuer)
+	}
+	if config.AuthorizationEndpoint == "" || config.TokenEndpoint == "" {
+		return nil, errors.Errorf("OIDC configuration of %s does not specify the authorization and token endpoints", issuer)
+	}
+	return &config, nil
+}
+
+// exchangeAuthorizationCode sends form to tokenEndpoint, and returns the identity token in the response.
+func exchangeAuthorizationCode(ctx context.Context, client *http.Client, tokenEndpoint string, form url.Values) (string, error) {
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, tokenEndpoint, strings.NewReader(form.Encode()))
+	if err != nil {
+		return "", err
+	}
+	req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+	req.Header.Set("Accept", "application/json")
+	res, err := client.Do(req)
+	if err != nil {
+		return "", errors.Wrap(err, "exchanging the OIDC authorization code")
+	}
+	defer res.Body.Close()
+	if res.StatusCode != http.StatusOK {
+		return "", serviceError("OIDC provider", res)
+	}
+	var body struct {
+		IDToken string `json:"id_token"`
+	}
+	if err := json.NewDecoder(io.LimitReader(res.Body, maxResponseSize)).Decode(&body); err != nil {
+		return "", errors.Wrap(err, "parsing OIDC token response")
+	}
+	if body.IDToken == "" {
+		return "", errors.New("OIDC token response does not include an identity token")
+	}
+	return body.IDToken, nil
+}
+
+// identityTokenClaims are the claims of an identity token relevant to us.
+type identityTokenClaims struct {
+	Issuer        string `json:"iss"`
+	Subject       string `json:"sub"`
+	Email         string `json:"email"`
+	EmailVerified bool   `json:"email_verified"`
+	Nonce         string `json:"nonce"`
+}
+
+// parseIdentityToken returns the claims of token, a JWT.
+// The signature of the token is NOT verified; Fulcio verifies it before issuing a certificate.
+func parseIdentityToken(token string) (*identityTokenClaims, error) {
+	parts := strings.Split(token, ".")
+	if len(parts) != 3 {
+		return nil, errors.New("identity token is not a JWT")
+	}
+	payload, err := base64.RawURLEncoding.DecodeString(strings.TrimRight(parts[1], "="))
+	if err != nil {
+		return nil, errors.Wrap(err, "decoding identity token")
+	}
+	var claims identityTokenClaims
+	if err := json.Unmarshal(payload, &claims); err != nil {
+		return nil, errors.Wrap(err, "parsing identity token claims")
+	}
+	return &claims, nil
+}
+
+// subject returns the identity Fulcio certifies for c, which must be signed to prove possession of the private key.
+func (c *identityTokenClaims) subject() (string, error) {
+	if c.Email != "" {
+		if !c.EmailVerified {
+			return "", errors.Errorf("email %s in the identity token is not verified", c.Email)
+		}
+		return c.Email, nil
+	}
+	if c.Subject == "" {
+		return "", errors.New("identity token does not specify a subject")
+	}
+	return c.Subject, nil
+}
+
+// randomString returns a random string suitable for OIDC state, nonce and PKCE code verifier values.
+func randomString() (string, error) {
+	b := make([]byte, 32)
+	if _, err := rand.Read(b); err != nil {
+		return "", err
+	}
+	return base64.RawURLEncoding.EncodeToString(b), nil
+}
@@ -0,0 +1,206 @@
+package sigstore
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"os"
+	"path/filepath"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestStaticAndFileIdentityToken(t *testing.T) {
+	ctx := context.Background()
+	token, err := StaticIdentityToken("static").IdentityToken(ctx)
+	require.NoError(t, err)
+	assert.Equal(t, "static", token)
+
+	path := filepath.Join(t.TempDir(), "token")
+	require.NoError(t, os.WriteFile(path, []byte("from-file\n"), 0600))
+	token, err = IdentityTokenFile(path).IdentityToken(ctx)
+	require.NoError(t, err)
+	assert.Equal(t, "from-file", token)
+	_, err = IdentityTokenFile(filepath.Join(t.TempDir(), "missing")).IdentityToken(ctx)
+	assert.Error(t, err)
+}
+
+// setEnv sets the environment variables in vars ("" to unset) for the duration of the test.
+func setEnv(t *testing.T, vars map[string]string) {
+	for k, v := range vars {
+		old, wasSet := os.LookupEnv(k)
+		if v == "" {
+			os.Unsetenv(k)
+		} else {
+			os.Setenv(k, v)
+		}
+		k := k
+		t.Cleanup(func() {
+			if wasSet {
+				os.Setenv(k, old)
+			} else {
+				os.Unsetenv(k)
+			}
+		})
+	}
+}
+
+func TestGitHubActionsIdentityToken(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.Header.Get("Authorization") != "Bearer request-token" || r.URL.Query().Get("audience") != "sigstore" ||
+			r.URL.Query().Get("api-version") != "2.0" {
+			w.WriteHeader(http.StatusForbidden)
+			return
+		}
+		fmt.Fprint(w, `{"value":"github-token"}`)
+	}))
+	defer server.Close()
+
+	setEnv(t, map[string]string{
+		identityTokenEnvVar:              "",
+		"ACTIONS_ID_TOKEN_REQUEST_URL":   server.URL + "/token?api-version=2.0",
+		"ACTIONS_ID_TOKEN_REQUEST_TOKEN": "request-token",
+	})
+	provider := AmbientIdentityToken()
+	require.IsType(t, GitHubActionsIdentityToken{}, provider)
+	token, err := provider.IdentityToken(context.Background())
+	require.NoError(t, err)
+	assert.Equal(t, "github-token", token)
+
+	setEnv(t, map[string]string{"ACTIONS_ID_TOKEN_REQUEST_TOKEN": "wrong"})
+	_, err = provider.IdentityToken(context.Background())
+	assert.Error(t, err)
+
+	// SIGSTORE_ID_TOKEN takes precedence.
+	setEnv(t, map[string]string{identityTokenEnvVar: "env-token"})
+	assert.Equal(t, StaticIdentityToken("env-token"), AmbientIdentityToken())
+
+	setEnv(t, map[string]string{identityTokenEnvVar: "", "ACTIONS_ID_TOKEN_REQUEST_URL": ""})
+	assert.Nil(t, AmbientIdentityToken())
+	_, err = GitHubActionsIdentityToken{}.IdentityToken(context.Background())
+	assert.Error(t, err)
+}
+
+// testOIDCProvider is a fake OIDC provider, which immediately redirects authorization requests back to the client.
+type testOIDCProvider struct {
+	*httptest.Server
+	t     *testing.T
+	mutex sync.Mutex
+	// Set when an authorization request is received
+	nonce, codeChallenge, redirectURI string
+	// If not "", the authorization request fails with this error.
+	authError string
+	// If true, the returned token contains a different nonce.
+	wrongNonce bool
+}
+
+func newTestOIDCProvider(t *testing.T) *testOIDCProvider {
+	p := &testOIDCProvider{t: t}
+	mux := http.NewServeMux()
+	mux.HandleFunc("/.well-known/openid-configuration", func(w http.ResponseWriter, r *http.Request) {
+		_ = json.NewEncoder(w).Encode(oidcProviderConfiguration{
+			AuthorizationEndpoint: p.URL + "/authorize",
+			TokenEndpoint:         p.URL + "/token",
+		})
+	})
+	mux.HandleFunc("/authorize", func(w http.ResponseWriter, r *http.Request) {
+		q := r.URL.Query()
+		p.mutex.Lock()
+		defer p.mutex.Unlock()
+		p.nonce, p.codeChallenge, p.redirectURI = q.Get("nonce"), q.Get("code_challenge"), q.Get("redirect_uri")
+		if q.Get("client_id") != "test-client" || q.Get("code_challenge_method") != "S256" || q.Get("response_type") != "code" {
+			http.Error(w, "invalid request", http.StatusBadRequest)
+			return
+		}
+		redirect := url.Values{"state": {q.Get("state")}}
+		if p.authError != "" {
+			redirect.Set("error", p.authError)
+		} else {
+			redirect.Set("code", "auth-code")
+		}
+		http.Redirect(w, r, p.redirectURI+"?"+redirect.Encode(), http.StatusFound)
+	})
+	mux.HandleFunc("/token", func(w http.ResponseWriter, r *http.Request) {
+		p.mutex.Lock()
+		defer p.mutex.Unlock()
+		verifierDigest := sha256.Sum256([]byte(r.FormValue("code_verifier")))
+		if r.FormValue("code") != "auth-code" || r.FormValue("grant_type") != "authorization_code" ||
+			r.FormValue("redirect_uri") != p.redirectURI || r.FormValue("client_id") != "test-client" ||
+			base64.RawURLEncoding.EncodeToString(verifierDigest[:]) != p.codeChallenge {
+			http.Error(w, "invalid token request", http.StatusBadRequest)
+			return
+		}
+		nonce := p.nonce
+		if p.wrongNonce {
+			nonce = "wrong"
+		}
+		_ = json.NewEncoder(w).Encode(map[string]string{
+			"id_token": testIdentityToken(p.t, map[string]interface{}{"iss": p.URL, "sub": "user", "nonce": nonce}),
+		})
+	})
+	p.Server = httptest.NewServer(mux)
+	t.Cleanup(p.Close)
+	return p
+}
+
+func TestInteractiveIdentityToken(t *testing.T) {
+	provider := newTestOIDCProvider(t)
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+	var openedURL string
+	interactive := InteractiveIdentityToken{
+		Issuer:   provider.URL,
+		ClientID: "test-client",
+		OpenURL: func(u string) error {
+			openedURL = u
+			// Simulate the browser: follow the redirect to the local server.
+			go func() {
+				res, err := http.Get(u)
+				if err == nil {
+					res.Body.Close()
+				}
+			}()
+			return nil
+		},
+	}
+
+	token, err := interactive.IdentityToken(ctx)
+	require.NoError(t, err)
+	claims, err := parseIdentityToken(token)
+	require.NoError(t, err)
+	assert.Equal(t, "user", claims.Subject)
+	assert.Contains(t, openedURL, provider.URL+"/authorize?")
+
+	provider.mutex.Lock()
+	provider.wrongNonce = true
+	provider.mutex.Unlock()
+	_, err = interactive.IdentityToken(ctx)
+	assert.ErrorContains(t, err, "nonce")
+
+	provider.mutex.Lock()
+	provider.authError = "access_denied"
+	provider.mutex.Unlock()
+	_, err = interactive.IdentityToken(ctx)
+	assert.ErrorContains(t, err, "access_denied")
+
+	// Waiting for the user is canceled by the context.
+	interactive.OpenURL = func(u string) error { return nil }
+	shortCtx, shortCancel := context.WithTimeout(ctx, 100*time.Millisecond)
+	defer shortCancel()
+	_, err = interactive.IdentityToken(shortCtx)
+	assert.Error(t, err)
+
+	// Discovery failures are reported.
+	interactive.Issuer = provider.URL + "/nonexistent"
+	_, err = interactive.IdentityToken(ctx)
+	assert.Error(t, err)
+}
@@ -0,0 +1,265 @@
+package sigstore
+
+import (
+	"bytes"
+	"context"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/hex"
+	"encoding/json"
+	"io"
+	"net/http"
+	"net/url"
+	"strings"
+
+	"github.com/pkg/errors"
+)
+
+// DefaultRekorURL is the URL of the public Rekor instance operated by the sigstore project.
+const DefaultRekorURL = "https://rekor.sigstore.dev"
+
+// Bundle is the contents of BundleAnnotationKey: a Rekor log entry, and the promise of the log to include it
+// (a signed entry timestamp), which allows verifying the entry without contacting Rekor.
+type Bundle struct {
+	SignedEntryTimestamp []byte        `json:"SignedEntryTimestamp"`
+	Payload              BundlePayload `json:"Payload"`
+}
+
+// BundlePayload is the log entry in a Bundle.
+type BundlePayload struct {
+	Body           string `json:"body"` // The base64-encoded canonical log entry
+	IntegratedTime int64  `json:"integratedTime"`
+	LogIndex       int64  `json:"logIndex"`
+	LogID          string `json:"logID"` // The hex-encoded SHA-256 digest of the public key of the log
+}
+
+// rekorClient uploads and reads entries of a Rekor transparency log.
+type rekorClient struct {
+	url        *url.URL
+	httpClient *http.Client
+}
+
+// newRekorClient returns a rekorClient for the Rekor instance at rekorURL.
+func newRekorClient(rekorURL string, httpClient *http.Client) (*rekorClient, error) {
+	u, err := url.Parse(rekorURL)
+	if err != nil {
+		return nil, errors.Wrapf(err, "parsing Rekor URL %q", rekorURL)
+	}
+	if u.Scheme != "https" && u.Scheme != "http" {
+		return nil, errors.Errorf("unsupported Rekor URL %q", rekorURL)
+	}
+	if httpClient == nil {
+		httpClient = http.DefaultClient
+	}
+	return &rekorClient{url: u, httpClient: httpClient}, nil
+}
+
+// rekorHashedRekord is a Rekor entry of kind "hashedrekord", version 0.0.1.
+type rekorHashedRekord struct {
+	APIVersion string `json:"apiVersion"`
+	Kind       string `json:"kind"`
+	Spec       struct {
+		Data struct {
+			Hash struct {
+				Algorithm string `json:"algorithm"`
+				Value     string `json:"value"`
+			} `json:"hash"`
+		} `json:"data"`
+		Signature struct {
+			Content   []byte `json:"content"`
+			PublicKey struct {
+				Content []byte `json:"content"` // PEM-encoded public key or certificate
+			} `json:"publicKey"`
+		} `json:"signature"`
+	} `json:"spec"`
+}
+
+// rekorLogEntry is a log entry as returned by the Rekor API.
+type rekorLogEntry struct {
+	Body           string `json:"body"`
+	IntegratedTime int64  `json:"integratedTime"`
+	LogID          string `json:"logID"`
+	LogIndex       int64  `json:"logIndex"`
+	Verification   struct {
+		InclusionProof       *rekorInclusionProof `json:"inclusionProof,omitempty"`
+		SignedEntryTimestamp []byte               `json:"signedEntryTimestamp"`
+	} `json:"verification"`
+}
+
+// rekorInclusionProof is a proof of inclusion of a log entry in the Merkle tree of the log, as returned by the Rekor API.
+type rekorInclusionProof struct {
+	Checkpoint string   `json:"checkpoint"` // A signed note containing the tree size and root hash
+	Hashes     []string `json:"hashes"`     // Hex-encoded
+	LogIndex   int64    `json:"logIndex"`   // The index of the entry in the tree (which differs from the entry's LogIndex in sharded logs)
+	RootHash   string   `json:"rootHash"`   // Hex-encoded
+	TreeSize   int64    `json:"treeSize"`
+}
+
+// uploadHashedRekordEntry records sig, a signature of payload made by the key in publicKeyPEM (a PEM-encoded
+// public key or certificate), in the log, and returns a bundle describing the log entry.
+// If the log already contains the entry, the existing entry is returned.
+func (c *rekorClient) uploadHashedRekordEntry(ctx context.Context, payload, sig, publicKeyPEM []byte) (*Bundle, error) {
+	entry := rekorHashedRekord{APIVersion: "0.0.1", Kind: "hashedrekord"}
+	payloadDigest := sha256.Sum256(payload)
+	entry.Spec.Data.Hash.Algorithm = "sha256"
+	entry.Spec.Data.Hash.Value = hex.EncodeToString(payloadDigest[:])
+	entry.Spec.Signature.Content = sig
+	entry.Spec.Signature.PublicKey.Content = publicKeyPEM
+	body, err := json.Marshal(entry)
+	if err != nil {
+		return nil, err
+	}
+
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, c.url.ResolveReference(&url.URL{Path: "/api/v1/log/entries"}).String(), bytes.NewReader(body))
+	if err != nil {
+		return nil, err
+	}
+	req.Header.Set("Content-Type", "application/json")
+	req.Header.Set("Accept", "application/json")
+	res, err := c.httpClient.Do(req)
+	if err != nil {
+		return nil, err
+	}
+	defer res.Body.Close()
+	switch res.StatusCode {
+	case http.StatusCreated:
+		entries, err := decodeLogEntries(res.Body)
+		if err != nil {
+			return nil, err
+		}
+		return bundleFromLogEntries(entries)
+	case http.StatusConflict:
+		location := res.Header.Get("Location")
+		if location == "" {
+			return nil, errors.New("Rekor reported an existing entry without a location")
+		}
+		return c.getLogEntry(ctx, location)
+	default:
+		return nil, serviceError("Rekor", res)
+	}
+}
+
+// getLogEntry returns a bundle describing the log entry at location.
+func (c *rekorClient) getLogEntry(ctx context.Context, location string) (*Bundle, error) {
+	u, err := url.Parse(location)
+	if err != nil {
+		return nil, errors.Wrapf(err, "parsing Rekor entry location %q", location)
+	}
+	entries, err := c.getLogEntries(ctx, u)
+	if err != nil {
+		return nil, err
+	}
+	return bundleFromLogEntries(entries)
+}
+
+// getLogEntries returns the log entries returned by Rekor at ref, resolved relative to the Rekor URL.
+func (c *rekorClient) getLogEntries(ctx context.Context, ref *url.URL) (map[string]rekorLogEntry, error) {
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, c.url.ResolveReference(ref).String(), nil)
+	if err != nil {
+		return nil, err
+	}
+	req.Header.Set("Accept", "application/json")
+	res, err := c.httpClient.Do(req)
+	if err != nil {
+		return nil, err
+	}
+	defer res.Body.Close()
+	if res.StatusCode != http.StatusOK {
+		return nil, serviceError("Rekor", res)
+	}
+	return decodeLogEntries(res.Body)
+}
+
+// searchLogEntries returns the log entries recording signatures of payload.
+func (c *rekorClient) searchLogEntries(ctx context.Context, payload []byte) (map[string]rekorLogEntry, error) {
+	payloadDigest := sha256.Sum256(payload)
+	body, err := json.Marshal(map[string]string{"hash": "sha256:" + hex.EncodeToString(payloadDigest[:])})
+	if err != nil {
+		return nil, err
+	}
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, c.url.ResolveReference(&url.URL{Path: "/api/v1/index/retrieve"}).String(), bytes.NewReader(body))
+	if err != nil {
+		return nil, err
+	}
+	req.Header.Set("Content-Type", "application/json")
+	req.Header.Set("Accept", "application/json")
+	res, err := c.httpClient.Do(req)
+	if err != nil {
+		return nil, err
+	}
+	defer res.Body.Close()
+	if res.StatusCode != http.StatusOK {
+		return nil, serviceError("Rekor", res)
+	}
+	var uuids []string
+	if err := json.NewDecoder(io.LimitReader(res.Body, maxResponseSize)).Decode(&uuids); err != nil {
+		return nil, errors.Wrap(err, "parsing Rekor response")
+	}
+	entries := map[string]rekorLogEntry{}
+	for _, uuid := range uuids {
+		e, err := c.getLogEntries(ctx, &url.URL{Path: "/api/v1/log/entries/" + url.PathEscape(uuid)})
+		if err != nil {
+			return nil, err
+		}
+		for k, v := range e {
+			entries[k] = v
+		}
+	}
+	return entries, nil
+}
+
+// decodeLogEntries parses a Rekor API response containing log entries.
+func decodeLogEntries(body io.Reader) (map[string]rekorLogEntry, error) {
+	var entries map[string]rekorLogEntry
+	if err := json.NewDecoder(io.LimitReader(body, maxResponseSize)).Decode(&entries); err != nil {
+		return nil, errors.Wrap(err, "parsing Rekor response")
+	}
+	return entries, nil
+}
+
+// bundleFromLogEntries returns a bundle describing the single log entry in entries, as returned by the Rekor API.
+func bundleFromLogEntries(entries map[string]rekorLogEntry) (*Bundle, error) {
+	if len(entries) != 1 {
+		return nil, errors.Errorf("Rekor returned %d entries, expected 1", len(entries))
+	}
+	var e rekorLogEntry
+	for _, v := range entries {
+		e = v
+	}
+	if _, err := base64.StdEncoding.DecodeString(e.Body); err != nil {
+		return nil, errors.Wrap(err, "invalid Rekor entry body")
+	}
+	if len(e.Verification.SignedEntryTimestamp) == 0 {
+		return nil, errors.New("Rekor entry does not include a signed entry timestamp")
+	}
+	return &Bundle{
+		SignedEntryTimestamp: e.Verification.SignedEntryTimestamp,
+		Payload: BundlePayload{
+			Body:           e.Body,
+			IntegratedTime: e.IntegratedTime,
+			LogIndex:       e.LogIndex,
+			LogID:          e.LogID,
+		},
+	}, nil
+}
+
+// maxResponseSize is the maximum size of responses of sigstore services we are willing to read.
+const maxResponseSize = 4 * 1024 * 1024
+
+// serviceError returns an error describing an unexpected response res of the sigstore service with name.
+func serviceError(name string, res *http.Response) error {
+	var body struct {
+		Message string `json:"message"`
+	}
+	data, _ := io.ReadAll(io.LimitReader(res.Body, 64*1024))
+	msg := ""
+	if err := json.Unmarshal(data, &body); err == nil && body.Message != "" {
+		msg = body.Message
+	} else {
+		msg = strings.TrimSpace(string(data))
+	}
+	if msg != "" {
+		return errors.Errorf("%s returned %s: %s", name, res.Status, msg)
+	}
+	return errors.Errorf("%s returned %s", name, res.Status)
+}
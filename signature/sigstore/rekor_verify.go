@@ -0,0 +1,321 @@
+package sigstore
+
+import (
+	"bytes"
+	"context"
+	"crypto"
+	"crypto/sha256"
+	"crypto/x509"
+	"encoding/base64"
+	"encoding/hex"
+	"encoding/json"
+	"encoding/pem"
+	"math/bits"
+	"net/http"
+	"net/url"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/pkg/errors"
+)
+
+// RekorVerificationOptions configure VerifyRekorEntry.
+type RekorVerificationOptions struct {
+	// PublicKeys are the trusted public keys of the log; at least one must be specified.
+	PublicKeys []crypto.PublicKey
+	// URL, if not "", is the URL of a Rekor instance, which is contacted to obtain an inclusion proof of the entry,
+	// and to find the entry if the signature does not include a bundle.
+	// If "", the signature must include a bundle, and only its signed entry timestamp is verified ("offline" verification).
+	URL        string
+	HTTPClient *http.Client // Used to contact Rekor; if nil, http.DefaultClient is used
+}
+
+// VerifyRekorEntry verifies that the Rekor log described by options contains an entry recording sig, a signature of payload
+// made by signerKey, and returns the time the entry was integrated into the log.
+// bundle is the Bundle included in the signature (in BundleAnnotationKey), or nil if the signature does not include one.
+func VerifyRekorEntry(ctx context.Context, options RekorVerificationOptions, bundle *Bundle, payload, sig []byte, signerKey crypto.PublicKey) (time.Time, error) {
+	if len(options.PublicKeys) == 0 {
+		return time.Time{}, errors.New("no trusted Rekor public keys specified")
+	}
+	if bundle != nil {
+		if err := verifySignedEntryTimestamp(options.PublicKeys, bundle); err != nil {
+			return time.Time{}, err
+		}
+		if err := verifyHashedRekordBody(bundle.Payload.Body, payload, sig, signerKey); err != nil {
+			return time.Time{}, err
+		}
+	}
+	if options.URL == "" {
+		if bundle == nil {
+			return time.Time{}, errors.New("the signature does not include a Rekor bundle, and no Rekor URL is configured")
+		}
+		return time.Unix(bundle.Payload.IntegratedTime, 0), nil
+	}
+
+	client, err := newRekorClient(options.URL, options.HTTPClient)
+	if err != nil {
+		return time.Time{}, err
+	}
+	var entries map[string]rekorLogEntry
+	if bundle != nil {
+		entries, err = client.getLogEntries(ctx, &url.URL{Path: "/api/v1/log/entries", RawQuery: url.Values{
+			"logIndex": {strconv.FormatInt(bundle.Payload.LogIndex, 10)},
+		}.Encode()})
+	} else {
+		entries, err = client.searchLogEntries(ctx, payload)
+	}
+	if err != nil {
+		return time.Time{}, errors.Wrap(err, "looking up the Rekor entry")
+	}
+	var rejections []string
+	for uuid, e := range entries {
+		if bundle != nil && e.Body != bundle.Payload.Body {
+			rejections = append(rejections, errors.Errorf("Rekor entry %s does not match the bundle", uuid).Error())
+			continue
+		}
+		if err := verifyLogEntry(options.PublicKeys, e, payload, sig, signerKey); err != nil {
+			rejections = append(rejections, errors.Wrapf(err, "Rekor entry %s", uuid).Error())
+			continue
+		}
+		return time.Unix(e.IntegratedTime, 0), nil
+	}
+	if len(rejections) == 0 {
+		return time.Time{}, errors.New("the signature is not recorded in Rekor")
+	}
+	return time.Time{}, errors.Errorf("no valid Rekor entry for the signature: %s", strings.Join(rejections, "; "))
+}
+
+// verifyLogEntry verifies e, as returned by the Rekor API, including its inclusion proof, and that it records
+// sig, a signature of payload made by signerKey.
+func verifyLogEntry(publicKeys []crypto.PublicKey, e rekorLogEntry, payload, sig []byte, signerKey crypto.PublicKey) error {
+	if err := verifySignedEntryTimestamp(publicKeys, &Bundle{
+		SignedEntryTimestamp: e.Verification.SignedEntryTimestamp,
+		Payload: BundlePayload{
+			Body:           e.Body,
+			IntegratedTime: e.IntegratedTime,
+			LogIndex:       e.LogIndex,
+			LogID:          e.LogID,
+		},
+	}); err != nil {
+		return err
+	}
+	if err := verifyHashedRekordBody(e.Body, payload, sig, signerKey); err != nil {
+		return err
+	}
+	return verifyInclusionProof(publicKeys, e)
+}
+
+// rekorLogID returns the log ID corresponding to a Rekor public key.
+func rekorLogID(publicKey crypto.PublicKey) (string, error) {
+	der, err := x509.MarshalPKIXPublicKey(publicKey)
+	if err != nil {
+		return "", err
+	}
+	digest := sha256.Sum256(der)
+	return hex.EncodeToString(digest[:]), nil
+}
+
+// verifySignedEntryTimestamp verifies that bundle contains a valid signed entry timestamp, made by one of publicKeys.
+func verifySignedEntryTimestamp(publicKeys []crypto.PublicKey, bundle *Bundle) error {
+	// The signed entry timestamp signs the canonical JSON form of the payload; fields of this struct are sorted
+	// by name, and none of them contain characters that encoding/json escapes differently from canonical JSON.
+	signed, err := json.Marshal(struct {
+		Body           string `json:"body"`
+		IntegratedTime int64  `json:"integratedTime"`
+		LogID          string `json:"logID"`
+		LogIndex       int64  `json:"logIndex"`
+	}{
+		Body:           bundle.Payload.Body,
+		IntegratedTime: bundle.Payload.IntegratedTime,
+		LogID:          bundle.Payload.LogID,
+		LogIndex:       bundle.Payload.LogIndex,
+	})
+	if err != nil {
+		return err
+	}
+	for _, key := range publicKeys {
+		logID, err := rekorLogID(key)
+		if err != nil {
+			return err
+		}
+		if logID != bundle.Payload.LogID {
+			continue
+		}
+		if err := VerifySignature(key, signed, bundle.SignedEntryTimestamp); err != nil {
+			return errors.Wrap(err, "verifying the Rekor signed entry timestamp")
+		}
+		return nil
+	}
+	return errors.Errorf("the Rekor entry was made by an untrusted log %q", bundle.Payload.LogID)
+}
+
+// verifyHashedRekordBody verifies that body, a base64-encoded Rekor entry, records sig, a signature of payload made by signerKey.
+func verifyHashedRekordBody(body string, payload, sig []byte, signerKey crypto.PublicKey) error {
+	decoded, err := base64.StdEncoding.DecodeString(body)
+	if err != nil {
+		return errors.Wrap(err, "decoding the Rekor entry body")
+	}
+	var entry rekorHashedRekord
+	if err := json.Unmarshal(decoded, &entry); err != nil {
+		return errors.Wrap(err, "parsing the Rekor entry body")
+	}
+	if entry.Kind != "hashedrekord" {
+		return errors.Errorf("unsupported Rekor entry kind %q", entry.Kind)
+	}
+	payloadDigest := sha256.Sum256(payload)
+	if entry.Spec.Data.Hash.Algorithm != "sha256" || entry.Spec.Data.Hash.Value != hex.EncodeToString(payloadDigest[:]) {
+		return errors.New("the Rekor entry does not match the signed payload")
+	}
+	if !bytes.Equal(entry.Spec.Signature.Content, sig) {
+		return errors.New("the Rekor entry does not match the signature")
+	}
+	block, _ := pem.Decode(entry.Spec.Signature.PublicKey.Content)
+	if block == nil {
+		return errors.New("the Rekor entry does not contain a valid public key")
+	}
+	var entryKey crypto.PublicKey
+	switch block.Type {
+	case "PUBLIC KEY":
+		entryKey, err = x509.ParsePKIXPublicKey(block.Bytes)
+	case "CERTIFICATE":
+		var cert *x509.Certificate
+		cert, err = x509.ParseCertificate(block.Bytes)
+		if err == nil {
+			entryKey = cert.PublicKey
+		}
+	default:
+		err = errors.Errorf("unexpected PEM block type %q", block.Type)
+	}
+	if err != nil {
+		return errors.Wrap(err, "parsing the public key in the Rekor entry")
+	}
+	if !publicKeysEqual(entryKey, signerKey) {
+		return errors.New("the Rekor entry does not match the signing key")
+	}
+	return nil
+}
+
+// verifyInclusionProof verifies that e, as returned by the Rekor API, includes a valid inclusion proof
+// in a tree whose checkpoint is signed by one of publicKeys.
+func verifyInclusionProof(publicKeys []crypto.PublicKey, e rekorLogEntry) error {
+	proof := e.Verification.InclusionProof
+	if proof == nil {
+		return errors.New("the Rekor entry does not include an inclusion proof")
+	}
+	body, err := base64.StdEncoding.DecodeString(e.Body)
+	if err != nil {
+		return errors.Wrap(err, "decoding the Rekor entry body")
+	}
+	rootHash, err := hex.DecodeString(proof.RootHash)
+	if err != nil {
+		return errors.Wrap(err, "decoding the inclusion proof root hash")
+	}
+	hashes := make([][]byte, 0, len(proof.Hashes))
+	for _, h := range proof.Hashes {
+		decoded, err := hex.DecodeString(h)
+		if err != nil {
+			return errors.Wrap(err, "decoding the inclusion proof")
+		}
+		hashes = append(hashes, decoded)
+	}
+	if proof.LogIndex < 0 || proof.TreeSize < 0 {
+		return errors.New("invalid inclusion proof")
+	}
+	if err := verifyMerkleInclusion(uint64(proof.LogIndex), uint64(proof.TreeSize), merkleLeafHash(body), hashes, rootHash); err != nil {
+		return err
+	}
+	return verifyCheckpoint(publicKeys, proof.Checkpoint, proof.TreeSize, rootHash)
+}
+
+// merkleLeafHash returns the RFC 6962 hash of a Merkle tree leaf containing data.
+func merkleLeafHash(data []byte) []byte {
+	h := sha256.New()
+	h.Write([]byte{0})
+	h.Write(data)
+	return h.Sum(nil)
+}
+
+// merkleNodeHash returns the RFC 6962 hash of a Merkle tree node with children left and right.
+func merkleNodeHash(left, right []byte) []byte {
+	h := sha256.New()
+	h.Write([]byte{1})
+	h.Write(left)
+	h.Write(right)
+	return h.Sum(nil)
+}
+
+// verifyMerkleInclusion verifies an RFC 9162 inclusion proof of a leaf with leafHash at index in a tree with size and rootHash.
+func verifyMerkleInclusion(index, size uint64, leafHash []byte, proof [][]byte, rootHash []byte) error {
+	if index >= size {
+		return errors.Errorf("invalid inclusion proof: index %d is outside of a tree of size %d", index, size)
+	}
+	// The proof consists of siblings within the perfect subtree containing the leaf, followed by roots of
+	// the subtrees to the left of it along the right border of the tree.
+	inner := bits.Len64(index ^ (size - 1))
+	border := bits.OnesCount64(index >> uint(inner))
+	if len(proof) != inner+border {
+		return errors.Errorf("invalid inclusion proof: %d hashes, expected %d", len(proof), inner+border)
+	}
+	res := leafHash
+	for i, h := range proof[:inner] {
+		if (index>>uint(i))&1 == 0 {
+			res = merkleNodeHash(res, h)
+		} else {
+			res = merkleNodeHash(h, res)
+		}
+	}
+	for _, h := range proof[inner:] {
+		res = merkleNodeHash(h, res)
+	}
+	if !bytes.Equal(res, rootHash) {
+		return errors.New("the inclusion proof does not match the root hash")
+	}
+	return nil
+}
+
+// verifyCheckpoint verifies that checkpoint, a signed note describing a state of the log, is signed by one of publicKeys
+// and describes a tree with treeSize and rootHash.
+func verifyCheckpoint(publicKeys []crypto.PublicKey, checkpoint string, treeSize int64, rootHash []byte) error {
+	if checkpoint == "" {
+		return errors.New("the inclusion proof does not include a checkpoint")
+	}
+	sep := strings.Index(checkpoint, "\n\n")
+	if sep < 0 {
+		return errors.New("invalid checkpoint format")
+	}
+	text := checkpoint[:sep+1]
+	lines := strings.Split(strings.TrimSuffix(text, "\n"), "\n")
+	if len(lines) < 3 {
+		return errors.New("invalid checkpoint format")
+	}
+	size, err := strconv.ParseInt(lines[1], 10, 64)
+	if err != nil {
+		return errors.Wrap(err, "invalid checkpoint tree size")
+	}
+	root, err := base64.StdEncoding.DecodeString(lines[2])
+	if err != nil {
+		return errors.Wrap(err, "invalid checkpoint root hash")
+	}
+	if size != treeSize || !bytes.Equal(root, rootHash) {
+		return errors.New("the checkpoint does not match the inclusion proof")
+	}
+
+	// Signature lines are "— name base64(key hint || signature)"; the key hint is 4 bytes.
+	for _, line := range strings.Split(checkpoint[sep+2:], "\n") {
+		fields := strings.Fields(line)
+		if len(fields) != 3 || fields[0] != "—" {
+			continue
+		}
+		sig, err := base64.StdEncoding.DecodeString(fields[2])
+		if err != nil || len(sig) <= 4 {
+			continue
+		}
+		for _, key := range publicKeys {
+			if VerifySignature(key, []byte(text), sig[4:]) == nil {
+				return nil
+			}
+		}
+	}
+	return errors.New("the checkpoint is not signed by a trusted Rekor key")
+}
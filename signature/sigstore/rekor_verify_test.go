@@ -0,0 +1,179 @@
+package sigstore
+
+import (
+	"context"
+	"crypto"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/sha256"
+	"fmt"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestVerifyMerkleInclusion(t *testing.T) {
+	for size := 1; size <= 17; size++ {
+		leafHashes := [][]byte{}
+		for i := 0; i < size; i++ {
+			leafHashes = append(leafHashes, merkleLeafHash([]byte(fmt.Sprintf("leaf %d", i))))
+		}
+		root := testMerkleRoot(leafHashes)
+		for index := 0; index < size; index++ {
+			proof := testMerklePath(index, leafHashes)
+			assert.NoError(t, verifyMerkleInclusion(uint64(index), uint64(size), leafHashes[index], proof, root), "%d/%d", index, size)
+			// A different leaf
+			assert.Error(t, verifyMerkleInclusion(uint64(index), uint64(size), merkleLeafHash([]byte("other")), proof, root), "%d/%d", index, size)
+			// A different index
+			if size > 1 {
+				assert.Error(t, verifyMerkleInclusion(uint64((index+1)%size), uint64(size), leafHashes[index], proof, root), "%d/%d", index, size)
+			}
+			// A truncated proof
+			if len(proof) > 0 {
+				assert.Error(t, verifyMerkleInclusion(uint64(index), uint64(size), leafHashes[index], proof[:len(proof)-1], root), "%d/%d", index, size)
+			}
+		}
+		assert.Error(t, verifyMerkleInclusion(uint64(size), uint64(size), leafHashes[0], nil, root))
+	}
+}
+
+// testRekorSignature records a signature of payload in rekor, and returns the signature, the signing key and the bundle.
+func testRekorSignature(t *testing.T, rekor *testRekor, payload []byte) ([]byte, *ecdsa.PrivateKey, *Bundle) {
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	require.NoError(t, err)
+	sig, err := (&Signer{key: key}).sign(context.Background(), payload)
+	require.NoError(t, err)
+	client, err := newRekorClient(rekor.URL, nil)
+	require.NoError(t, err)
+	bundle, err := client.uploadHashedRekordEntry(context.Background(), payload, sig, publicKeyPEM(t, &key.PublicKey))
+	require.NoError(t, err)
+	return sig, key, bundle
+}
+
+func TestVerifyRekorEntry(t *testing.T) {
+	ctx := context.Background()
+	rekor := newTestRekor(t)
+	rekorKeys, err := ParsePublicKeys(rekor.publicKeyPEM(t))
+	require.NoError(t, err)
+	otherKey, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	require.NoError(t, err)
+
+	payload := []byte("payload")
+	sig, key, bundle := testRekorSignature(t, rekor, payload)
+	// Make the tree a bit more interesting
+	for i := 0; i < 4; i++ {
+		testRekorSignature(t, rekor, []byte(fmt.Sprintf("other payload %d", i)))
+	}
+
+	offline := RekorVerificationOptions{PublicKeys: rekorKeys}
+	online := RekorVerificationOptions{PublicKeys: rekorKeys, URL: rekor.URL}
+	for _, options := range []RekorVerificationOptions{offline, online} {
+		integratedTime, err := VerifyRekorEntry(ctx, options, bundle, payload, sig, &key.PublicKey)
+		require.NoError(t, err, options.URL)
+		assert.Equal(t, bundle.Payload.IntegratedTime, integratedTime.Unix())
+
+		// The signature or the key don't match the entry
+		_, err = VerifyRekorEntry(ctx, options, bundle, []byte("other payload 0"), sig, &key.PublicKey)
+		assert.Error(t, err, options.URL)
+		otherSig, err := (&Signer{key: key}).sign(context.Background(), payload)
+		require.NoError(t, err)
+		_, err = VerifyRekorEntry(ctx, options, bundle, payload, otherSig, &key.PublicKey)
+		assert.Error(t, err, options.URL)
+		_, err = VerifyRekorEntry(ctx, options, bundle, payload, sig, &otherKey.PublicKey)
+		assert.Error(t, err, options.URL)
+
+		// The bundle is not signed by a trusted log
+		_, err = VerifyRekorEntry(ctx, RekorVerificationOptions{PublicKeys: []crypto.PublicKey{&otherKey.PublicKey}, URL: options.URL},
+			bundle, payload, sig, &key.PublicKey)
+		assert.Error(t, err, options.URL)
+		_, err = VerifyRekorEntry(ctx, RekorVerificationOptions{URL: options.URL}, bundle, payload, sig, &key.PublicKey)
+		assert.Error(t, err, options.URL)
+
+		// The bundle was modified
+		modified := *bundle
+		modified.Payload.IntegratedTime++
+		_, err = VerifyRekorEntry(ctx, options, &modified, payload, sig, &key.PublicKey)
+		assert.Error(t, err, options.URL)
+	}
+
+	// Without a bundle, the entry is looked up online.
+	_, err = VerifyRekorEntry(ctx, offline, nil, payload, sig, &key.PublicKey)
+	assert.Error(t, err)
+	integratedTime, err := VerifyRekorEntry(ctx, online, nil, payload, sig, &key.PublicKey)
+	require.NoError(t, err)
+	assert.Equal(t, bundle.Payload.IntegratedTime, integratedTime.Unix())
+	_, err = VerifyRekorEntry(ctx, online, nil, []byte("unrecorded payload"), sig, &key.PublicKey)
+	assert.ErrorContains(t, err, "not recorded")
+
+	// The bundle refers to a different entry
+	_, _, otherBundle := testRekorSignature(t, rekor, payload)
+	otherBundle.Payload.Body = bundle.Payload.Body
+	otherBundle.SignedEntryTimestamp, err = rekor.signedEntryTimestamp(rekorLogEntry{
+		Body: otherBundle.Payload.Body, IntegratedTime: otherBundle.Payload.IntegratedTime,
+		LogID: otherBundle.Payload.LogID, LogIndex: otherBundle.Payload.LogIndex,
+	})
+	require.NoError(t, err)
+	_, err = VerifyRekorEntry(ctx, offline, otherBundle, payload, sig, &key.PublicKey)
+	require.NoError(t, err)
+	_, err = VerifyRekorEntry(ctx, online, otherBundle, payload, sig, &key.PublicKey)
+	assert.ErrorContains(t, err, "does not match the bundle")
+
+	// The checkpoint is not signed by the log
+	rekor.mutex.Lock()
+	rekor.checkpointKey = otherKey
+	rekor.mutex.Unlock()
+	_, err = VerifyRekorEntry(ctx, online, bundle, payload, sig, &key.PublicKey)
+	assert.ErrorContains(t, err, "checkpoint")
+
+	// Rekor failures are reported
+	rekor.mutex.Lock()
+	rekor.fail = true
+	rekor.mutex.Unlock()
+	_, err = VerifyRekorEntry(ctx, online, bundle, payload, sig, &key.PublicKey)
+	assert.ErrorContains(t, err, "log unavailable")
+}
+
+func TestVerifyInclusionProofErrors(t *testing.T) {
+	rekor := newTestRekor(t)
+	rekorKeys, err := ParsePublicKeys(rekor.publicKeyPEM(t))
+	require.NoError(t, err)
+	payload := []byte("payload")
+	testRekorSignature(t, rekor, payload)
+	testRekorSignature(t, rekor, []byte("other payload"))
+
+	rekor.mutex.Lock()
+	e, err := rekor.entryWithProof(rekor.uuids[0])
+	rekor.mutex.Unlock()
+	require.NoError(t, err)
+	require.NoError(t, verifyInclusionProof(rekorKeys, e))
+
+	for _, c := range []struct {
+		name   string
+		modify func(e *rekorLogEntry)
+	}{
+		{"no proof", func(e *rekorLogEntry) { e.Verification.InclusionProof = nil }},
+		{"no checkpoint", func(e *rekorLogEntry) { e.Verification.InclusionProof.Checkpoint = "" }},
+		{"invalid hash", func(e *rekorLogEntry) { e.Verification.InclusionProof.Hashes[0] = "zz" }},
+		{"different root", func(e *rekorLogEntry) {
+			root := sha256.Sum256([]byte("root"))
+			e.Verification.InclusionProof.RootHash = fmt.Sprintf("%x", root)
+		}},
+		{"checkpoint of a different tree", func(e *rekorLogEntry) {
+			p := e.Verification.InclusionProof
+			p.Checkpoint = p.Checkpoint[:len("rekor.test - 1234\n")] + "3" + p.Checkpoint[len("rekor.test - 1234\n")+1:]
+		}},
+		{"unsigned checkpoint", func(e *rekorLogEntry) {
+			p := e.Verification.InclusionProof
+			p.Checkpoint = p.Checkpoint[:len(p.Checkpoint)-len("\n")-20] + "AAAAAAAAAAAAAAAAAAAA\n"
+		}},
+	} {
+		modified := e
+		proof := *e.Verification.InclusionProof
+		proof.Hashes = append([]string{}, proof.Hashes...)
+		modified.Verification.InclusionProof = &proof
+		c.modify(&modified)
+		assert.Error(t, verifyInclusionProof(rekorKeys, modified), c.name)
+	}
+}
@@ -0,0 +1,266 @@
+// Package sigstore creates signatures of container images compatible with sigstore (cosign), using a private key,
+// a key held in a key management service (see the kms subpackage),
+// or using an ephemeral key certified by Fulcio based on an OIDC identity ("keyless" signing), recorded in Rekor;
+// and provides the primitives to verify them, including verification of their Rekor entries.
+//
+// Note: Consider the API unstable until the code supports at least three different image formats or transports.
+package sigstore
+
+import (
+	"context"
+	"crypto"
+	"crypto/ecdsa"
+	"crypto/ed25519"
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/sha256"
+	"crypto/x509"
+	"encoding/base64"
+	"encoding/json"
+	"encoding/pem"
+	"time"
+
+	"github.com/containers/image/v5/manifest"
+	"github.com/containers/image/v5/version"
+	"github.com/pkg/errors"
+	"golang.org/x/crypto/nacl/secretbox"
+	"golang.org/x/crypto/scrypt"
+)
+
+const (
+	// SignatureMIMEType is the MIME type of the layers of a sigstore signature artifact, each containing a signed payload.
+	SignatureMIMEType = "application/vnd.dev.cosign.simplesigning.v1+json"
+	// SignatureAnnotationKey is the annotation of a sigstore signature artifact layer containing the base64-encoded
+	// signature of the layer's payload.
+	SignatureAnnotationKey = "dev.cosignproject.cosign/signature"
+	// CertificateAnnotationKey is the annotation of a sigstore signature artifact layer containing the PEM-encoded
+	// certificate of the signing key, for keyless signatures.
+	CertificateAnnotationKey = "dev.sigstore.cosign/certificate"
+	// ChainAnnotationKey is the annotation of a sigstore signature artifact layer containing the PEM-encoded
+	// certificate chain (intermediates and root) of CertificateAnnotationKey.
+	ChainAnnotationKey = "dev.sigstore.cosign/chain"
+	// BundleAnnotationKey is the annotation of a sigstore signature artifact layer containing the Rekor entry
+	// of the signature, as a JSON Bundle.
+	BundleAnnotationKey = "dev.sigstore.cosign/bundle"
+	// SignatureTagSuffix is the suffix of the tag, derived from the digest of the signed manifest, which the cosign
+	// tag scheme uses to store sigstore signatures.
+	SignatureTagSuffix = "sig"
+	// AttestationMIMEType is the MIME type of the layers of a sigstore attestation artifact, each containing a DSSE envelope
+	// of an in-toto statement.
+	AttestationMIMEType = "application/vnd.dsse.envelope.v1+json"
+	// AttestationTagSuffix is the suffix of the tag, derived from the digest of the attested manifest, which the cosign
+	// tag scheme uses to store sigstore attestations.
+	AttestationTagSuffix = "att"
+
+	// payloadType is the value of critical.type in sigstore signature payloads.
+	payloadType = "cosign container image signature"
+	// PEM block types of private keys created by cosign; the older one is still accepted for compatibility.
+	encryptedPrivateKeyType       = "ENCRYPTED SIGSTORE PRIVATE KEY"
+	legacyEncryptedPrivateKeyType = "ENCRYPTED COSIGN PRIVATE KEY"
+)
+
+// Signer creates sigstore signatures using a private key.
+type Signer struct {
+	key crypto.Signer // or nil if kmsKey is set
+	// For signers using a key held by a key management service: the key, and its public key.
+	kmsKey       KMSKey
+	kmsPublicKey crypto.PublicKey
+	// For keyless signers: the PEM-encoded certificate of key, and the rest of its certificate chain.
+	certificate []byte
+	chain       []byte
+	rekor       *rekorClient     // or nil if signatures are not uploaded to Rekor
+	timestamps  *timestampClient // or nil if signatures are not timestamped
+}
+
+// Signature is a sigstore signature, to be stored as a layer of a signature artifact.
+type Signature struct {
+	Payload     []byte            // The contents of the layer, with MIME type SignatureMIMEType
+	Annotations map[string]string // The annotations of the layer: SignatureAnnotationKey, and optionally other *AnnotationKey values
+}
+
+// NewSignerFromPrivateKey returns a Signer using the private key in keyPEM, which is either an encrypted private key
+// created by cosign (e.g. by "cosign generate-key-pair"), decrypted using passphrase, or an unencrypted PKCS#8 private key.
+// ECDSA, RSA and Ed25519 keys are supported.
+func NewSignerFromPrivateKey(keyPEM []byte, passphrase []byte) (*Signer, error) {
+	block, _ := pem.Decode(keyPEM)
+	if block == nil {
+		return nil, errors.New("private key is not in PEM format")
+	}
+	var der []byte
+	switch block.Type {
+	case encryptedPrivateKeyType, legacyEncryptedPrivateKeyType:
+		d, err := decryptPrivateKey(block.Bytes, passphrase)
+		if err != nil {
+			return nil, err
+		}
+		der = d
+	case "PRIVATE KEY":
+		der = block.Bytes
+	default:
+		return nil, errors.Errorf("unsupported private key type %q", block.Type)
+	}
+	key, err := x509.ParsePKCS8PrivateKey(der)
+	if err != nil {
+		return nil, errors.Wrap(err, "parsing private key")
+	}
+	switch k := key.(type) {
+	case *ecdsa.PrivateKey:
+		return &Signer{key: k}, nil
+	case *rsa.PrivateKey:
+		return &Signer{key: k}, nil
+	case ed25519.PrivateKey:
+		return &Signer{key: k}, nil
+	default:
+		return nil, errors.Errorf("unsupported private key type %T", key)
+	}
+}
+
+// encryptedPrivateKey is the format of the contents of encrypted private keys created by cosign.
+type encryptedPrivateKey struct {
+	KDF struct {
+		Name   string `json:"name"`
+		Params struct {
+			N int `json:"N"`
+			R int `json:"r"`
+			P int `json:"p"`
+		} `json:"params"`
+		Salt []byte `json:"salt"`
+	} `json:"kdf"`
+	Cipher struct {
+		Name  string `json:"name"`
+		Nonce []byte `json:"nonce"`
+	} `json:"cipher"`
+	Ciphertext []byte `json:"ciphertext"`
+}
+
+// decryptPrivateKey returns the DER-encoded private key in data, an encrypted private key created by cosign,
+// decrypted using passphrase.
+func decryptPrivateKey(data []byte, passphrase []byte) ([]byte, error) {
+	var k encryptedPrivateKey
+	if err := json.Unmarshal(data, &k); err != nil {
+		return nil, errors.Wrap(err, "parsing encrypted private key")
+	}
+	if k.KDF.Name != "scrypt" {
+		return nil, errors.Errorf("unsupported key derivation function %q", k.KDF.Name)
+	}
+	if k.Cipher.Name != "nacl/secretbox" {
+		return nil, errors.Errorf("unsupported cipher %q", k.Cipher.Name)
+	}
+	var nonce [24]byte
+	if len(k.Cipher.Nonce) != len(nonce) {
+		return nil, errors.Errorf("invalid nonce length %d", len(k.Cipher.Nonce))
+	}
+	copy(nonce[:], k.Cipher.Nonce)
+	derived, err := scrypt.Key(passphrase, k.KDF.Salt, k.KDF.Params.N, k.KDF.Params.R, k.KDF.Params.P, 32)
+	if err != nil {
+		return nil, errors.Wrap(err, "deriving key")
+	}
+	var secretKey [32]byte
+	copy(secretKey[:], derived)
+	res, ok := secretbox.Open(nil, k.Ciphertext, &nonce, &secretKey)
+	if !ok {
+		return nil, errors.New("decrypting private key: invalid passphrase")
+	}
+	return res, nil
+}
+
+// SignDockerManifest returns a sigstore signature payload for m, a manifest, as the specified dockerReference,
+// and the base64-encoded signature of the payload, to be stored as SignatureAnnotationKey.
+// It can't be used with keyless signers, or signers using a timestamp authority, which need more annotations; use SignImage instead.
+func (s *Signer) SignDockerManifest(m []byte, dockerReference string) ([]byte, string, error) {
+	if s.certificate != nil || s.rekor != nil {
+		return nil, "", errors.New("keyless signatures must be created using SignImage")
+	}
+	if s.timestamps != nil {
+		return nil, "", errors.New("timestamped signatures must be created using SignImage")
+	}
+	payload, sig, err := s.signDockerManifest(context.Background(), m, dockerReference)
+	if err != nil {
+		return nil, "", err
+	}
+	return payload, base64.StdEncoding.EncodeToString(sig), nil
+}
+
+// SignImage returns a sigstore signature of m, a manifest, as the specified dockerReference.
+// For keyless signers, the signature is uploaded to Rekor, and the returned annotations include
+// the signing certificate and the Rekor bundle.  If a timestamp authority is configured (see UseTimestampAuthority),
+// the annotations include a timestamp of the signature.
+func (s *Signer) SignImage(ctx context.Context, m []byte, dockerReference string) (*Signature, error) {
+	payload, sig, err := s.signDockerManifest(ctx, m, dockerReference)
+	if err != nil {
+		return nil, err
+	}
+	res := Signature{
+		Payload:     payload,
+		Annotations: map[string]string{SignatureAnnotationKey: base64.StdEncoding.EncodeToString(sig)},
+	}
+	if s.certificate != nil {
+		res.Annotations[CertificateAnnotationKey] = string(s.certificate)
+		if len(s.chain) != 0 {
+			res.Annotations[ChainAnnotationKey] = string(s.chain)
+		}
+	}
+	if s.timestamps != nil {
+		token, err := s.timestamps.timestamp(ctx, sig)
+		if err != nil {
+			return nil, errors.Wrap(err, "obtaining a timestamp of the signature")
+		}
+		timestampJSON, err := json.Marshal(RFC3161Timestamp{SignedRFC3161Timestamp: token})
+		if err != nil {
+			return nil, err
+		}
+		res.Annotations[TimestampAnnotationKey] = string(timestampJSON)
+	}
+	if s.rekor != nil {
+		bundle, err := s.rekor.uploadHashedRekordEntry(ctx, payload, sig, s.certificate)
+		if err != nil {
+			return nil, errors.Wrap(err, "uploading the signature to Rekor")
+		}
+		bundleJSON, err := json.Marshal(bundle)
+		if err != nil {
+			return nil, err
+		}
+		res.Annotations[BundleAnnotationKey] = string(bundleJSON)
+	}
+	return &res, nil
+}
+
+// signDockerManifest returns a sigstore signature payload for m, a manifest, as the specified dockerReference,
+// and the raw signature of the payload.
+func (s *Signer) signDockerManifest(ctx context.Context, m []byte, dockerReference string) ([]byte, []byte, error) {
+	manifestDigest, err := manifest.Digest(m)
+	if err != nil {
+		return nil, nil, err
+	}
+	payload, err := json.Marshal(map[string]interface{}{
+		"critical": map[string]interface{}{
+			"type":     payloadType,
+			"image":    map[string]string{"docker-manifest-digest": manifestDigest.String()},
+			"identity": map[string]string{"docker-reference": dockerReference},
+		},
+		"optional": map[string]interface{}{
+			"creator":   "containers/image " + version.Version,
+			"timestamp": time.Now().Unix(),
+		},
+	})
+	if err != nil {
+		return nil, nil, err
+	}
+	sig, err := s.sign(ctx, payload)
+	if err != nil {
+		return nil, nil, errors.Wrap(err, "signing payload")
+	}
+	return payload, sig, nil
+}
+
+// sign returns a signature of payload.
+func (s *Signer) sign(ctx context.Context, payload []byte) ([]byte, error) {
+	if s.kmsKey != nil {
+		return s.signUsingKMS(ctx, payload)
+	}
+	if _, ok := s.key.(ed25519.PrivateKey); ok { // Ed25519 signs the message itself
+		return s.key.Sign(rand.Reader, payload, crypto.Hash(0))
+	}
+	digest := sha256.Sum256(payload)
+	return s.key.Sign(rand.Reader, digest[:], crypto.SHA256)
+}
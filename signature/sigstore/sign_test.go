@@ -0,0 +1,102 @@
+package sigstore
+
+import (
+	"crypto/ecdsa"
+	"crypto/ed25519"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/sha256"
+	"crypto/x509"
+	"encoding/base64"
+	"encoding/json"
+	"encoding/pem"
+	"testing"
+
+	"github.com/opencontainers/go-digest"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"golang.org/x/crypto/nacl/secretbox"
+	"golang.org/x/crypto/scrypt"
+)
+
+// encryptPrivateKey returns der encrypted using passphrase, in the format used by cosign.
+func encryptPrivateKey(t *testing.T, der []byte, passphrase []byte) []byte {
+	var k encryptedPrivateKey
+	k.KDF.Name = "scrypt"
+	k.KDF.Params.N, k.KDF.Params.R, k.KDF.Params.P = 1024, 8, 1 // Much weaker than cosign, to keep the test fast
+	k.KDF.Salt = []byte("0123456789abcdef0123456789abcdef")
+	k.Cipher.Name = "nacl/secretbox"
+	k.Cipher.Nonce = []byte("0123456789abcdef01234567")
+	derived, err := scrypt.Key(passphrase, k.KDF.Salt, k.KDF.Params.N, k.KDF.Params.R, k.KDF.Params.P, 32)
+	require.NoError(t, err)
+	var nonce [24]byte
+	copy(nonce[:], k.Cipher.Nonce)
+	var secretKey [32]byte
+	copy(secretKey[:], derived)
+	k.Ciphertext = secretbox.Seal(nil, der, &nonce, &secretKey)
+	data, err := json.Marshal(k)
+	require.NoError(t, err)
+	return pem.EncodeToMemory(&pem.Block{Type: encryptedPrivateKeyType, Bytes: data})
+}
+
+func TestSignDockerManifest(t *testing.T) {
+	m := []byte(`{"schemaVersion":2}`)
+	ecdsaKey, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	require.NoError(t, err)
+	ecdsaDER, err := x509.MarshalPKCS8PrivateKey(ecdsaKey)
+	require.NoError(t, err)
+	ed25519Public, ed25519Key, err := ed25519.GenerateKey(rand.Reader)
+	require.NoError(t, err)
+	ed25519DER, err := x509.MarshalPKCS8PrivateKey(ed25519Key)
+	require.NoError(t, err)
+
+	for _, c := range []struct {
+		name   string
+		key    []byte
+		verify func(payload, sig []byte) bool
+	}{
+		{"encrypted ECDSA", encryptPrivateKey(t, ecdsaDER, []byte("passphrase")), func(payload, sig []byte) bool {
+			d := sha256.Sum256(payload)
+			return ecdsa.VerifyASN1(&ecdsaKey.PublicKey, d[:], sig)
+		}},
+		{"unencrypted Ed25519", pem.EncodeToMemory(&pem.Block{Type: "PRIVATE KEY", Bytes: ed25519DER}), func(payload, sig []byte) bool {
+			return ed25519.Verify(ed25519Public, payload, sig)
+		}},
+	} {
+		signer, err := NewSignerFromPrivateKey(c.key, []byte("passphrase"))
+		require.NoError(t, err, c.name)
+		payload, sig, err := signer.SignDockerManifest(m, "example.com/repo:tag")
+		require.NoError(t, err, c.name)
+		rawSig, err := base64.StdEncoding.DecodeString(sig)
+		require.NoError(t, err, c.name)
+		assert.True(t, c.verify(payload, rawSig), c.name)
+
+		var parsed struct {
+			Critical struct {
+				Type     string
+				Image    map[string]string
+				Identity map[string]string
+			}
+		}
+		require.NoError(t, json.Unmarshal(payload, &parsed), c.name)
+		assert.Equal(t, payloadType, parsed.Critical.Type, c.name)
+		assert.Equal(t, map[string]string{"docker-manifest-digest": digest.FromBytes(m).String()}, parsed.Critical.Image, c.name)
+		assert.Equal(t, map[string]string{"docker-reference": "example.com/repo:tag"}, parsed.Critical.Identity, c.name)
+	}
+}
+
+func TestNewSignerFromPrivateKey(t *testing.T) {
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	require.NoError(t, err)
+	der, err := x509.MarshalPKCS8PrivateKey(key)
+	require.NoError(t, err)
+
+	_, err = NewSignerFromPrivateKey(encryptPrivateKey(t, der, []byte("passphrase")), []byte("wrong"))
+	assert.Error(t, err)
+	_, err = NewSignerFromPrivateKey([]byte("not PEM"), nil)
+	assert.Error(t, err)
+	_, err = NewSignerFromPrivateKey(pem.EncodeToMemory(&pem.Block{Type: "PUBLIC KEY", Bytes: der}), nil)
+	assert.Error(t, err)
+	_, err = NewSignerFromPrivateKey(pem.EncodeToMemory(&pem.Block{Type: "PRIVATE KEY", Bytes: []byte("garbage")}), nil)
+	assert.Error(t, err)
+}
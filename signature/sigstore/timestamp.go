@@ -0,0 +1,438 @@
+package sigstore
+
+import (
+	"bytes"
+	"context"
+	"crypto"
+	"crypto/ecdsa"
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/asn1"
+	"io"
+	"math/big"
+	"net/http"
+	"net/url"
+	"time"
+
+	"github.com/pkg/errors"
+)
+
+// TimestampAnnotationKey is the annotation of a sigstore signature artifact layer containing an RFC 3161 timestamp
+// of the signature, as a JSON RFC3161Timestamp.
+const TimestampAnnotationKey = "dev.sigstore.cosign/rfc3161timestamp"
+
+// maxTimestampResponseSize is the maximum size of a response from a timestamp authority we are willing to read.
+const maxTimestampResponseSize = 1024 * 1024
+
+// RFC3161Timestamp is the contents of TimestampAnnotationKey.
+type RFC3161Timestamp struct {
+	// SignedRFC3161Timestamp is a DER-encoded RFC 3161 TimeStampToken of the raw signature (not of its base64 encoding).
+	SignedRFC3161Timestamp []byte `json:"SignedRFC3161Timestamp"`
+}
+
+// Object identifiers used in RFC 3161 timestamps, and in the CMS SignedData structure (RFC 5652) containing them.
+var (
+	oidSignedData             = asn1.ObjectIdentifier{1, 2, 840, 113549, 1, 7, 2}
+	oidTSTInfo                = asn1.ObjectIdentifier{1, 2, 840, 113549, 1, 9, 16, 1, 4}
+	oidAttributeContentType   = asn1.ObjectIdentifier{1, 2, 840, 113549, 1, 9, 3}
+	oidAttributeMessageDigest = asn1.ObjectIdentifier{1, 2, 840, 113549, 1, 9, 4}
+	oidSHA256                 = asn1.ObjectIdentifier{2, 16, 840, 1, 101, 3, 4, 2, 1}
+	oidSHA384                 = asn1.ObjectIdentifier{2, 16, 840, 1, 101, 3, 4, 2, 2}
+	oidSHA512                 = asn1.ObjectIdentifier{2, 16, 840, 1, 101, 3, 4, 2, 3}
+)
+
+// tsMessageImprint is a MessageImprint (RFC 3161): the digest of the timestamped data.
+type tsMessageImprint struct {
+	HashAlgorithm pkix.AlgorithmIdentifier
+	HashedMessage []byte
+}
+
+// tsRequest is a TimeStampReq (RFC 3161), without extensions.
+type tsRequest struct {
+	Version        int
+	MessageImprint tsMessageImprint
+	ReqPolicy      asn1.ObjectIdentifier `asn1:"optional"`
+	Nonce          *big.Int              `asn1:"optional"`
+	CertReq        bool                  `asn1:"optional,default:false"`
+}
+
+// tsResponse is a TimeStampResp (RFC 3161).
+type tsResponse struct {
+	Status         tsStatusInfo
+	TimeStampToken asn1.RawValue `asn1:"optional"`
+}
+
+// tsStatusInfo is a PKIStatusInfo (RFC 3161).
+type tsStatusInfo struct {
+	Status       int
+	StatusString asn1.RawValue  `asn1:"optional"`
+	FailInfo     asn1.BitString `asn1:"optional"`
+}
+
+// Values of tsStatusInfo.Status which indicate that the response contains a timestamp.
+const (
+	tsStatusGranted         = 0
+	tsStatusGrantedWithMods = 1
+)
+
+// tstInfo is a TSTInfo (RFC 3161): the timestamped data, signed by the timestamp authority.
+type tstInfo struct {
+	Version        int
+	Policy         asn1.ObjectIdentifier
+	MessageImprint tsMessageImprint
+	SerialNumber   *big.Int
+	GenTime        time.Time     `asn1:"generalized"`
+	Accuracy       tsAccuracy    `asn1:"optional"`
+	Ordering       bool          `asn1:"optional,default:false"`
+	Nonce          *big.Int      `asn1:"optional"`
+	TSA            asn1.RawValue `asn1:"optional,explicit,tag:0"`
+	Extensions     asn1.RawValue `asn1:"optional,tag:1"`
+}
+
+// tsAccuracy is an Accuracy (RFC 3161).
+type tsAccuracy struct {
+	Seconds int `asn1:"optional"`
+	Millis  int `asn1:"optional,tag:0"`
+	Micros  int `asn1:"optional,tag:1"`
+}
+
+// cmsContentInfo is a ContentInfo (RFC 5652).
+type cmsContentInfo struct {
+	ContentType asn1.ObjectIdentifier
+	Content     asn1.RawValue `asn1:"explicit,tag:0"`
+}
+
+// cmsSignedData is a SignedData (RFC 5652).
+type cmsSignedData struct {
+	Version          int
+	DigestAlgorithms []pkix.AlgorithmIdentifier `asn1:"set"`
+	EncapContentInfo cmsEncapsulatedContentInfo
+	Certificates     asn1.RawValue   `asn1:"optional,tag:0"`
+	CRLs             asn1.RawValue   `asn1:"optional,tag:1"`
+	SignerInfos      []cmsSignerInfo `asn1:"set"`
+}
+
+// cmsEncapsulatedContentInfo is an EncapsulatedContentInfo (RFC 5652).
+type cmsEncapsulatedContentInfo struct {
+	EContentType asn1.ObjectIdentifier
+	EContent     []byte `asn1:"explicit,optional,tag:0"`
+}
+
+// cmsSignerInfo is a SignerInfo (RFC 5652).
+type cmsSignerInfo struct {
+	Version            int
+	SID                asn1.RawValue // IssuerAndSerialNumber, or a [0] SubjectKeyIdentifier
+	DigestAlgorithm    pkix.AlgorithmIdentifier
+	SignedAttrs        asn1.RawValue `asn1:"optional,tag:0"`
+	SignatureAlgorithm pkix.AlgorithmIdentifier
+	Signature          []byte
+	UnsignedAttrs      asn1.RawValue `asn1:"optional,tag:1"`
+}
+
+// cmsIssuerAndSerialNumber is an IssuerAndSerialNumber (RFC 5652).
+type cmsIssuerAndSerialNumber struct {
+	Issuer       asn1.RawValue
+	SerialNumber *big.Int
+}
+
+// cmsAttribute is an Attribute (RFC 5652).
+type cmsAttribute struct {
+	Type   asn1.ObjectIdentifier
+	Values []asn1.RawValue `asn1:"set"`
+}
+
+// TimestampAuthorityOptions configure Signer.UseTimestampAuthority.
+type TimestampAuthorityOptions struct {
+	URL        string       // The URL of an RFC 3161 timestamp authority, which accepts timestamp requests using HTTP POST
+	HTTPClient *http.Client // Used to contact the timestamp authority; if nil, http.DefaultClient is used
+}
+
+// timestampClient obtains timestamps from an RFC 3161 timestamp authority.
+type timestampClient struct {
+	url        *url.URL
+	httpClient *http.Client
+}
+
+// UseTimestampAuthority configures s to include an RFC 3161 timestamp, obtained from the timestamp authority configured by options,
+// in signatures created by SignImage.  The timestamp provides a trusted time of signing, so that the signatures can be verified
+// after the signing certificate (or the key) expires, even without a Rekor entry.
+func (s *Signer) UseTimestampAuthority(options TimestampAuthorityOptions) error {
+	u, err := url.Parse(options.URL)
+	if err != nil {
+		return errors.Wrapf(err, "parsing timestamp authority URL %q", options.URL)
+	}
+	if u.Scheme != "https" && u.Scheme != "http" {
+		return errors.Errorf("unsupported timestamp authority URL %q", options.URL)
+	}
+	httpClient := options.HTTPClient
+	if httpClient == nil {
+		httpClient = http.DefaultClient
+	}
+	s.timestamps = &timestampClient{url: u, httpClient: httpClient}
+	return nil
+}
+
+// timestamp returns a DER-encoded RFC 3161 TimeStampToken of data.
+func (c *timestampClient) timestamp(ctx context.Context, data []byte) ([]byte, error) {
+	digest := crypto.SHA256.New()
+	digest.Write(data)
+	nonce, err := rand.Int(rand.Reader, new(big.Int).Lsh(big.NewInt(1), 64))
+	if err != nil {
+		return nil, err
+	}
+	reqBody, err := asn1.Marshal(tsRequest{
+		Version: 1,
+		MessageImprint: tsMessageImprint{
+			HashAlgorithm: pkix.AlgorithmIdentifier{Algorithm: oidSHA256, Parameters: asn1.NullRawValue},
+			HashedMessage: digest.Sum(nil),
+		},
+		Nonce:   nonce,
+		CertReq: true, // So that the verifier does not need to be configured with the certificate of the authority
+	})
+	if err != nil {
+		return nil, err
+	}
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, c.url.String(), bytes.NewReader(reqBody))
+	if err != nil {
+		return nil, err
+	}
+	req.Header.Set("Content-Type", "application/timestamp-query")
+	req.Header.Set("Accept", "application/timestamp-reply")
+	res, err := c.httpClient.Do(req)
+	if err != nil {
+		return nil, err
+	}
+	defer res.Body.Close()
+	if res.StatusCode != http.StatusOK {
+		return nil, serviceError("The timestamp authority", res)
+	}
+	resBody, err := io.ReadAll(io.LimitReader(res.Body, maxTimestampResponseSize))
+	if err != nil {
+		return nil, err
+	}
+
+	var tsRes tsResponse
+	if rest, err := asn1.Unmarshal(resBody, &tsRes); err != nil || len(rest) != 0 {
+		return nil, errors.New("invalid timestamp response")
+	}
+	if tsRes.Status.Status != tsStatusGranted && tsRes.Status.Status != tsStatusGrantedWithMods {
+		return nil, errors.Errorf("the timestamp authority rejected the request with status %d", tsRes.Status.Status)
+	}
+	token := tsRes.TimeStampToken.FullBytes
+	if len(token) == 0 {
+		return nil, errors.New("the timestamp response does not contain a timestamp")
+	}
+	// Verify that the response matches the request; the signature is verified by VerifyRFC3161Timestamp.
+	_, info, err := parseTimestampToken(token)
+	if err != nil {
+		return nil, err
+	}
+	if err := info.MessageImprint.verify(data); err != nil {
+		return nil, err
+	}
+	if info.Nonce == nil || info.Nonce.Cmp(nonce) != 0 {
+		return nil, errors.New("the timestamp does not match the nonce of the request")
+	}
+	return token, nil
+}
+
+// parseTimestampToken parses token, a DER-encoded RFC 3161 TimeStampToken, without verifying it.
+func parseTimestampToken(token []byte) (*cmsSignedData, *tstInfo, error) {
+	var contentInfo cmsContentInfo
+	if rest, err := asn1.Unmarshal(token, &contentInfo); err != nil || len(rest) != 0 {
+		return nil, nil, errors.New("invalid timestamp: not a CMS ContentInfo")
+	}
+	if !contentInfo.ContentType.Equal(oidSignedData) {
+		return nil, nil, errors.Errorf("invalid timestamp: unexpected content type %s", contentInfo.ContentType)
+	}
+	var signedData cmsSignedData
+	if rest, err := asn1.Unmarshal(contentInfo.Content.Bytes, &signedData); err != nil || len(rest) != 0 {
+		return nil, nil, errors.New("invalid timestamp: not a CMS SignedData")
+	}
+	if !signedData.EncapContentInfo.EContentType.Equal(oidTSTInfo) {
+		return nil, nil, errors.Errorf("invalid timestamp: unexpected signed content type %s", signedData.EncapContentInfo.EContentType)
+	}
+	var info tstInfo
+	if rest, err := asn1.Unmarshal(signedData.EncapContentInfo.EContent, &info); err != nil || len(rest) != 0 {
+		return nil, nil, errors.New("invalid timestamp: invalid TSTInfo")
+	}
+	if info.Version != 1 {
+		return nil, nil, errors.Errorf("unsupported timestamp version %d", info.Version)
+	}
+	return &signedData, &info, nil
+}
+
+// hashFromOID returns the hash function identified by oid.
+func hashFromOID(oid asn1.ObjectIdentifier) (crypto.Hash, error) {
+	switch {
+	case oid.Equal(oidSHA256):
+		return crypto.SHA256, nil
+	case oid.Equal(oidSHA384):
+		return crypto.SHA384, nil
+	case oid.Equal(oidSHA512):
+		return crypto.SHA512, nil
+	default:
+		return 0, errors.Errorf("unsupported hash algorithm %s", oid)
+	}
+}
+
+// verify verifies that mi is an imprint of data.
+func (mi *tsMessageImprint) verify(data []byte) error {
+	hash, err := hashFromOID(mi.HashAlgorithm.Algorithm)
+	if err != nil {
+		return err
+	}
+	digest := hash.New()
+	digest.Write(data)
+	if !bytes.Equal(digest.Sum(nil), mi.HashedMessage) {
+		return errors.New("the timestamp does not match the signature")
+	}
+	return nil
+}
+
+// VerifyRFC3161Timestamp verifies that token, a DER-encoded RFC 3161 TimeStampToken (e.g. in TimestampAnnotationKey), is a timestamp
+// of data issued by a timestamp authority certified by one of caCertificates (which may include intermediate certificates),
+// and returns the time of the timestamp.  The certificate of the timestamp authority must be included in token, or in caCertificates.
+func VerifyRFC3161Timestamp(caCertificates []*x509.Certificate, token, data []byte) (time.Time, error) {
+	signedData, info, err := parseTimestampToken(token)
+	if err != nil {
+		return time.Time{}, err
+	}
+	if err := info.MessageImprint.verify(data); err != nil {
+		return time.Time{}, err
+	}
+	if len(signedData.SignerInfos) != 1 {
+		return time.Time{}, errors.Errorf("expected a single timestamp signer, got %d", len(signedData.SignerInfos))
+	}
+	signerInfo := signedData.SignerInfos[0]
+
+	var embeddedCertificates []*x509.Certificate
+	if len(signedData.Certificates.Bytes) != 0 {
+		embeddedCertificates, err = x509.ParseCertificates(signedData.Certificates.Bytes)
+		if err != nil {
+			return time.Time{}, errors.Wrap(err, "parsing timestamp certificates")
+		}
+	}
+	var signer *x509.Certificate
+	for _, c := range append(embeddedCertificates, caCertificates...) {
+		if signerInfo.identifies(c) {
+			signer = c
+			break
+		}
+	}
+	if signer == nil {
+		return time.Time{}, errors.New("the certificate of the timestamp authority was not found")
+	}
+	if err := signerInfo.verifySignature(signer, signedData.EncapContentInfo.EContent); err != nil {
+		return time.Time{}, err
+	}
+
+	roots := x509.NewCertPool()
+	intermediates := x509.NewCertPool()
+	for _, ca := range caCertificates {
+		if isSelfSigned(ca) {
+			roots.AddCert(ca)
+		} else {
+			intermediates.AddCert(ca)
+		}
+	}
+	for _, c := range embeddedCertificates {
+		intermediates.AddCert(c)
+	}
+	if _, err := signer.Verify(x509.VerifyOptions{
+		Roots:         roots,
+		Intermediates: intermediates,
+		CurrentTime:   info.GenTime,
+		KeyUsages:     []x509.ExtKeyUsage{x509.ExtKeyUsageTimeStamping},
+	}); err != nil {
+		return time.Time{}, errors.Wrap(err, "verifying the timestamp authority certificate")
+	}
+	return info.GenTime, nil
+}
+
+// UntrustedRFC3161TimestampTime returns the time recorded in token, a DER-encoded RFC 3161 TimeStampToken,
+// WITHOUT verifying the timestamp in any way; use VerifyRFC3161Timestamp to obtain a trusted time.
+func UntrustedRFC3161TimestampTime(token []byte) (time.Time, error) {
+	_, info, err := parseTimestampToken(token)
+	if err != nil {
+		return time.Time{}, err
+	}
+	return info.GenTime, nil
+}
+
+// identifies returns true if si identifies cert as the signer.
+func (si *cmsSignerInfo) identifies(cert *x509.Certificate) bool {
+	if si.SID.Class == asn1.ClassContextSpecific && si.SID.Tag == 0 { // subjectKeyIdentifier
+		return len(cert.SubjectKeyId) != 0 && bytes.Equal(si.SID.Bytes, cert.SubjectKeyId)
+	}
+	var ias cmsIssuerAndSerialNumber
+	if rest, err := asn1.Unmarshal(si.SID.FullBytes, &ias); err != nil || len(rest) != 0 {
+		return false
+	}
+	return bytes.Equal(ias.Issuer.FullBytes, cert.RawIssuer) && ias.SerialNumber.Cmp(cert.SerialNumber) == 0
+}
+
+// verifySignature verifies that si contains a valid signature of content made by signer.
+func (si *cmsSignerInfo) verifySignature(signer *x509.Certificate, content []byte) error {
+	hash, err := hashFromOID(si.DigestAlgorithm.Algorithm)
+	if err != nil {
+		return err
+	}
+	// RFC 5652 requires signed attributes for content types other than id-data; the signature covers them,
+	// and they contain the digest of the content.
+	if len(si.SignedAttrs.FullBytes) == 0 {
+		return errors.New("the timestamp does not contain signed attributes")
+	}
+	var attrs []cmsAttribute
+	if rest, err := asn1.UnmarshalWithParams(si.SignedAttrs.FullBytes, &attrs, "set,tag:0"); err != nil || len(rest) != 0 {
+		return errors.New("invalid timestamp signed attributes")
+	}
+	var contentType asn1.ObjectIdentifier
+	var messageDigest []byte
+	for _, attr := range attrs {
+		if len(attr.Values) != 1 {
+			continue
+		}
+		switch {
+		case attr.Type.Equal(oidAttributeContentType):
+			if _, err := asn1.Unmarshal(attr.Values[0].FullBytes, &contentType); err != nil {
+				return errors.New("invalid timestamp content type attribute")
+			}
+		case attr.Type.Equal(oidAttributeMessageDigest):
+			if _, err := asn1.Unmarshal(attr.Values[0].FullBytes, &messageDigest); err != nil {
+				return errors.New("invalid timestamp message digest attribute")
+			}
+		}
+	}
+	if !contentType.Equal(oidTSTInfo) {
+		return errors.New("the timestamp content type attribute does not match the content")
+	}
+	digest := hash.New()
+	digest.Write(content)
+	if !bytes.Equal(digest.Sum(nil), messageDigest) {
+		return errors.New("the timestamp message digest attribute does not match the content")
+	}
+
+	var algorithm x509.SignatureAlgorithm
+	switch signer.PublicKey.(type) {
+	case *ecdsa.PublicKey:
+		algorithm = map[crypto.Hash]x509.SignatureAlgorithm{
+			crypto.SHA256: x509.ECDSAWithSHA256, crypto.SHA384: x509.ECDSAWithSHA384, crypto.SHA512: x509.ECDSAWithSHA512,
+		}[hash]
+	case *rsa.PublicKey:
+		algorithm = map[crypto.Hash]x509.SignatureAlgorithm{
+			crypto.SHA256: x509.SHA256WithRSA, crypto.SHA384: x509.SHA384WithRSA, crypto.SHA512: x509.SHA512WithRSA,
+		}[hash]
+	default:
+		return errors.Errorf("unsupported timestamp authority key type %T", signer.PublicKey)
+	}
+	// The signature is computed over the DER encoding of the attributes as a SET OF, not using the implicit [0] tag.
+	signed := append([]byte{}, si.SignedAttrs.FullBytes...)
+	signed[0] = 0x31 // Universal, constructed, SET
+	if err := signer.CheckSignature(algorithm, signed, si.Signature); err != nil {
+		return errors.Wrap(err, "verifying the timestamp signature")
+	}
+	return nil
+}
@@ -0,0 +1,156 @@
+package sigstore
+
+import (
+	"context"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/x509"
+	"encoding/base64"
+	"encoding/json"
+	"encoding/pem"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/containers/image/v5/internal/testing/tsa"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// newTestTSASigner returns a Signer using a new private key, and a fake timestamp authority it uses.
+func newTestTSASigner(t *testing.T) (*Signer, *tsa.Authority) {
+	authority, err := tsa.New(time.Now().Add(-time.Hour), x509.ExtKeyUsageTimeStamping)
+	require.NoError(t, err)
+	server := httptest.NewServer(authority)
+	t.Cleanup(server.Close)
+
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	require.NoError(t, err)
+	der, err := x509.MarshalPKCS8PrivateKey(key)
+	require.NoError(t, err)
+	signer, err := NewSignerFromPrivateKey(pem.EncodeToMemory(&pem.Block{Type: "PRIVATE KEY", Bytes: der}), nil)
+	require.NoError(t, err)
+	err = signer.UseTimestampAuthority(TimestampAuthorityOptions{URL: server.URL + "/api/v1/timestamp"})
+	require.NoError(t, err)
+	return signer, authority
+}
+
+func TestSignImageWithTimestamp(t *testing.T) {
+	ctx := context.Background()
+	signer, authority := newTestTSASigner(t)
+
+	sig, err := signer.SignImage(ctx, []byte(`{"schemaVersion":2}`), "example.com/repo:tag")
+	require.NoError(t, err)
+	var timestamp RFC3161Timestamp
+	err = json.Unmarshal([]byte(sig.Annotations[TimestampAnnotationKey]), &timestamp)
+	require.NoError(t, err)
+	rawSig, err := base64.StdEncoding.DecodeString(sig.Annotations[SignatureAnnotationKey])
+	require.NoError(t, err)
+	genTime, err := VerifyRFC3161Timestamp([]*x509.Certificate{authority.CACertificate}, timestamp.SignedRFC3161Timestamp, rawSig)
+	require.NoError(t, err)
+	assert.Equal(t, authority.Time.Unix(), genTime.Unix())
+
+	// SignDockerManifest can't include the timestamp
+	_, _, err = signer.SignDockerManifest([]byte(`{"schemaVersion":2}`), "example.com/repo:tag")
+	assert.Error(t, err)
+
+	// Invalid responses
+	for _, options := range []tsa.TokenOptions{
+		{Reject: true},
+		{WrongNonce: true},
+	} {
+		authority.Options = options
+		_, err := signer.SignImage(ctx, []byte(`{"schemaVersion":2}`), "example.com/repo:tag")
+		assert.Error(t, err, options)
+	}
+	for _, handler := range []http.HandlerFunc{
+		func(w http.ResponseWriter, r *http.Request) { w.WriteHeader(http.StatusInternalServerError) },
+		func(w http.ResponseWriter, r *http.Request) {
+			_, _ = w.Write([]byte("this is not a timestamp response"))
+		},
+		func(w http.ResponseWriter, r *http.Request) {
+			_, _ = w.Write([]byte{0x30, 0x05, 0x30, 0x03, 0x02, 0x01, 0x00})
+		}, // Granted, no token
+	} {
+		server := httptest.NewServer(handler)
+		err := signer.UseTimestampAuthority(TimestampAuthorityOptions{URL: server.URL})
+		require.NoError(t, err)
+		_, err = signer.SignImage(ctx, []byte(`{"schemaVersion":2}`), "example.com/repo:tag")
+		assert.Error(t, err)
+		server.Close()
+	}
+}
+
+func TestUseTimestampAuthority(t *testing.T) {
+	signer, _ := newTestTSASigner(t)
+	for _, u := range []string{"", "ftp://example.com", ":invalid"} {
+		err := signer.UseTimestampAuthority(TimestampAuthorityOptions{URL: u})
+		assert.Error(t, err, u)
+	}
+}
+
+func TestVerifyRFC3161Timestamp(t *testing.T) {
+	now := time.Now()
+	authority, err := tsa.New(now, x509.ExtKeyUsageTimeStamping)
+	require.NoError(t, err)
+	data := []byte("signature")
+	cas := []*x509.Certificate{authority.CACertificate}
+
+	token, err := authority.Token(data, tsa.TokenOptions{})
+	require.NoError(t, err)
+	genTime, err := VerifyRFC3161Timestamp(cas, token, data)
+	require.NoError(t, err)
+	assert.Equal(t, authority.Time.Unix(), genTime.Unix())
+
+	untrustedTime, err := UntrustedRFC3161TimestampTime(token)
+	require.NoError(t, err)
+	assert.Equal(t, genTime, untrustedTime)
+	_, err = UntrustedRFC3161TimestampTime([]byte("this is not a timestamp"))
+	assert.Error(t, err)
+
+	// The timestamping certificate can be provided by the caller
+	token, err = authority.Token(data, tsa.TokenOptions{OmitCertificates: true})
+	require.NoError(t, err)
+	_, err = VerifyRFC3161Timestamp(cas, token, data)
+	assert.Error(t, err)
+	_, err = VerifyRFC3161Timestamp([]*x509.Certificate{authority.CACertificate, authority.Certificate}, token, data)
+	assert.NoError(t, err)
+
+	// Failures
+	token, err = authority.Token(data, tsa.TokenOptions{})
+	require.NoError(t, err)
+	_, err = VerifyRFC3161Timestamp(cas, token, []byte("other data"))
+	assert.Error(t, err)
+	_, err = VerifyRFC3161Timestamp(cas, []byte("this is not a timestamp"), data)
+	assert.Error(t, err)
+	_, err = VerifyRFC3161Timestamp(cas, token[:len(token)-1], data)
+	assert.Error(t, err)
+	otherAuthority, err := tsa.New(now, x509.ExtKeyUsageTimeStamping)
+	require.NoError(t, err)
+	_, err = VerifyRFC3161Timestamp([]*x509.Certificate{otherAuthority.CACertificate}, token, data)
+	assert.Error(t, err)
+	_, err = VerifyRFC3161Timestamp(nil, token, data)
+	assert.Error(t, err)
+
+	token, err = authority.Token(data, tsa.TokenOptions{ModifyContent: true})
+	require.NoError(t, err)
+	_, err = VerifyRFC3161Timestamp(cas, token, data)
+	assert.Error(t, err)
+
+	// The timestamping certificate is not valid at the time of the timestamp
+	authority.Time = now.Add(48 * time.Hour)
+	token, err = authority.Token(data, tsa.TokenOptions{})
+	require.NoError(t, err)
+	_, err = VerifyRFC3161Timestamp(cas, token, data)
+	assert.Error(t, err)
+
+	// The certificate is not valid for timestamping
+	codeSigningAuthority, err := tsa.New(now, x509.ExtKeyUsageCodeSigning)
+	require.NoError(t, err)
+	token, err = codeSigningAuthority.Token(data, tsa.TokenOptions{})
+	require.NoError(t, err)
+	_, err = VerifyRFC3161Timestamp([]*x509.Certificate{codeSigningAuthority.CACertificate}, token, data)
+	assert.Error(t, err)
+}
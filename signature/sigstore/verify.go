@@ -0,0 +1,129 @@
+package sigstore
+
+import (
+	"bytes"
+	"crypto"
+	"crypto/ecdsa"
+	"crypto/ed25519"
+	"crypto/rsa"
+	"crypto/sha256"
+	"crypto/x509"
+	"encoding/json"
+	"encoding/pem"
+
+	"github.com/opencontainers/go-digest"
+	"github.com/pkg/errors"
+)
+
+// ParsePublicKeys returns the public keys in pemData, which contains one or more PEM-encoded PKIX public keys
+// (as created e.g. by "cosign generate-key-pair").
+func ParsePublicKeys(pemData []byte) ([]crypto.PublicKey, error) {
+	var res []crypto.PublicKey
+	rest := pemData
+	for {
+		var block *pem.Block
+		block, rest = pem.Decode(rest)
+		if block == nil {
+			break
+		}
+		if block.Type != "PUBLIC KEY" {
+			return nil, errors.Errorf("unexpected PEM block type %q, expected a public key", block.Type)
+		}
+		key, err := x509.ParsePKIXPublicKey(block.Bytes)
+		if err != nil {
+			return nil, errors.Wrap(err, "parsing public key")
+		}
+		res = append(res, key)
+	}
+	if len(res) == 0 {
+		return nil, errors.New("no public keys found")
+	}
+	if len(bytes.TrimSpace(rest)) != 0 {
+		return nil, errors.New("unexpected data after the public keys")
+	}
+	return res, nil
+}
+
+// VerifySignature verifies that sig is a signature of payload made by publicKey, as created by Signer.
+func VerifySignature(publicKey crypto.PublicKey, payload, sig []byte) error {
+	if k, ok := publicKey.(ed25519.PublicKey); ok { // Ed25519 signs the message itself
+		if !ed25519.Verify(k, payload, sig) {
+			return errors.New("invalid signature")
+		}
+		return nil
+	}
+	payloadDigest := sha256.Sum256(payload)
+	return verifyDigestSignature(publicKey, payloadDigest[:], sig)
+}
+
+// verifyDigestSignature verifies that sig is a signature of a SHA-256 digest made by publicKey.
+func verifyDigestSignature(publicKey crypto.PublicKey, digest, sig []byte) error {
+	switch k := publicKey.(type) {
+	case *ecdsa.PublicKey:
+		if !ecdsa.VerifyASN1(k, digest, sig) {
+			return errors.New("invalid signature")
+		}
+		return nil
+	case *rsa.PublicKey:
+		if err := rsa.VerifyPKCS1v15(k, crypto.SHA256, digest, sig); err != nil {
+			return errors.New("invalid signature")
+		}
+		return nil
+	default:
+		return errors.Errorf("unsupported public key type %T", publicKey)
+	}
+}
+
+// publicKeysEqual returns true if a and b are the same public key.
+func publicKeysEqual(a, b crypto.PublicKey) bool {
+	k, ok := a.(interface{ Equal(crypto.PublicKey) bool })
+	return ok && k.Equal(b)
+}
+
+// UntrustedPayload is the contents of a sigstore signature payload.
+// As the name says, the values have not been verified in any way.
+type UntrustedPayload struct {
+	DockerManifestDigest digest.Digest
+	DockerReference      string
+	Creator              string // Or "" if not specified
+	Timestamp            *int64 // Or nil if not specified
+}
+
+// untrustedPayloadJSON is the JSON format of UntrustedPayload.
+type untrustedPayloadJSON struct {
+	Critical struct {
+		Type  string `json:"type"`
+		Image struct {
+			DockerManifestDigest string `json:"docker-manifest-digest"`
+		} `json:"image"`
+		Identity struct {
+			DockerReference string `json:"docker-reference"`
+		} `json:"identity"`
+	} `json:"critical"`
+	Optional struct {
+		Creator   string `json:"creator"`
+		Timestamp *int64 `json:"timestamp"`
+	} `json:"optional"`
+}
+
+// ParsePayload parses a sigstore signature payload.
+// The caller is responsible for verifying the signature of the payload before trusting the result.
+func ParsePayload(payload []byte) (*UntrustedPayload, error) {
+	var p untrustedPayloadJSON
+	if err := json.Unmarshal(payload, &p); err != nil {
+		return nil, errors.Wrap(err, "parsing sigstore signature payload")
+	}
+	if p.Critical.Type != payloadType {
+		return nil, errors.Errorf("unrecognized sigstore signature payload type %q", p.Critical.Type)
+	}
+	d, err := digest.Parse(p.Critical.Image.DockerManifestDigest)
+	if err != nil {
+		return nil, errors.Wrap(err, "invalid docker-manifest-digest in sigstore signature payload")
+	}
+	return &UntrustedPayload{
+		DockerManifestDigest: d,
+		DockerReference:      p.Critical.Identity.DockerReference,
+		Creator:              p.Optional.Creator,
+		Timestamp:            p.Optional.Timestamp,
+	}, nil
+}
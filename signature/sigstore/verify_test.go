@@ -0,0 +1,97 @@
+package sigstore
+
+import (
+	"context"
+	"crypto"
+	"crypto/ecdsa"
+	"crypto/ed25519"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/x509"
+	"encoding/pem"
+	"testing"
+
+	"github.com/opencontainers/go-digest"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// publicKeyPEM returns the PEM-encoded public key.
+func publicKeyPEM(t *testing.T, key crypto.PublicKey) []byte {
+	der, err := x509.MarshalPKIXPublicKey(key)
+	require.NoError(t, err)
+	return pem.EncodeToMemory(&pem.Block{Type: "PUBLIC KEY", Bytes: der})
+}
+
+func TestParsePublicKeys(t *testing.T) {
+	ecdsaKey, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	require.NoError(t, err)
+	ed25519Public, _, err := ed25519.GenerateKey(rand.Reader)
+	require.NoError(t, err)
+
+	keys, err := ParsePublicKeys(append(publicKeyPEM(t, &ecdsaKey.PublicKey), publicKeyPEM(t, ed25519Public)...))
+	require.NoError(t, err)
+	require.Len(t, keys, 2)
+	assert.True(t, publicKeysEqual(keys[0], &ecdsaKey.PublicKey))
+	assert.True(t, publicKeysEqual(keys[1], ed25519Public))
+
+	for _, invalid := range [][]byte{
+		nil,
+		[]byte("not PEM"),
+		pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: []byte("cert")}),
+		pem.EncodeToMemory(&pem.Block{Type: "PUBLIC KEY", Bytes: []byte("invalid")}),
+		append(publicKeyPEM(t, &ecdsaKey.PublicKey), []byte("trailing garbage")...),
+	} {
+		_, err := ParsePublicKeys(invalid)
+		assert.Error(t, err, string(invalid))
+	}
+}
+
+func TestVerifySignature(t *testing.T) {
+	ecdsaKey, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	require.NoError(t, err)
+	rsaKey, err := rsa.GenerateKey(rand.Reader, 2048)
+	require.NoError(t, err)
+	_, ed25519Key, err := ed25519.GenerateKey(rand.Reader)
+	require.NoError(t, err)
+
+	payload := []byte("payload")
+	for _, key := range []crypto.Signer{ecdsaKey, rsaKey, ed25519Key} {
+		signer := &Signer{key: key}
+		sig, err := signer.sign(context.Background(), payload)
+		require.NoError(t, err)
+		assert.NoError(t, VerifySignature(key.Public(), payload, sig))
+		assert.Error(t, VerifySignature(key.Public(), []byte("other payload"), sig))
+		assert.Error(t, VerifySignature(key.Public(), payload, []byte("invalid signature")))
+	}
+	sig, err := (&Signer{key: ecdsaKey}).sign(context.Background(), payload)
+	require.NoError(t, err)
+	assert.Error(t, VerifySignature(rsaKey.Public(), payload, sig))
+	assert.Error(t, VerifySignature("not a key", payload, sig))
+}
+
+func TestParsePayload(t *testing.T) {
+	signer := &Signer{}
+	var err error
+	signer.key, err = ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	require.NoError(t, err)
+	m := []byte(`{"schemaVersion":2}`)
+	payload, _, err := signer.signDockerManifest(context.Background(), m, "example.com/repo:tag")
+	require.NoError(t, err)
+	p, err := ParsePayload(payload)
+	require.NoError(t, err)
+	assert.Equal(t, digest.FromBytes(m), p.DockerManifestDigest)
+	assert.Equal(t, "example.com/repo:tag", p.DockerReference)
+	assert.Contains(t, p.Creator, "containers/image")
+	assert.NotNil(t, p.Timestamp)
+
+	for _, invalid := range []string{
+		"not JSON",
+		`{"critical":{"type":"other","image":{"docker-manifest-digest":"sha256:0000000000000000000000000000000000000000000000000000000000000000"}}}`,
+		`{"critical":{"type":"cosign container image signature","image":{"docker-manifest-digest":"invalid"}}}`,
+	} {
+		_, err := ParsePayload([]byte(invalid))
+		assert.Error(t, err, invalid)
+	}
+}
@@ -2,7 +2,10 @@ package types
 
 import (
 	"context"
+	"fmt"
 	"io"
+	"net/http"
+	"strings"
 	"time"
 
 	"github.com/containers/image/v5/docker/reference"
@@ -199,6 +202,9 @@ type BICReplacementCandidate struct {
 // None of the methods return an error indication: errors when neither reading from, nor writing to, the cache, should be fatal;
 // users of the cache should just fall back to copying the blobs the usual way.
 //
+// Implementations must be safe for concurrent use by multiple goroutines; all implementations in the library's
+// "pkg/blobinfocache" package are, and the persistent ones are also safe for concurrent use by multiple processes.
+//
 // The BlobInfoCache interface is deprecated.  Consumers of this library should use one of the implementations provided by
 // subpackages of the library's "pkg/blobinfocache" package in preference to implementing the interface on their own.
 type BlobInfoCache interface {
@@ -468,6 +474,90 @@ type ImageInspectInfo struct {
 	Env           []string
 }
 
+// DockerRetryPolicy describes how requests to registries are retried after transient failures, using exponential backoff.
+// Requests with a body which can't be rewound (notably uploads of blob contents) are never retried.
+type DockerRetryPolicy struct {
+	// MaxAttempts is the maximum number of attempts of a request, including the first one; if < 1, 5 is used.
+	MaxAttempts int
+	// InitialDelay is the delay before the first retry; it doubles after every retry.  If 0, 2 seconds are used.
+	InitialDelay time.Duration
+	// MaxDelay is the maximum delay before a retry, also limiting delays requested using Retry-After headers.
+	// If 0, 60 seconds are used.
+	MaxDelay time.Duration
+	// Jitter, a value between 0 and 1, is the fraction of each backoff delay which is randomized, to avoid many clients
+	// retrying at the same time: a delay d is replaced by a random value between d*(1-Jitter) and d.
+	Jitter float64
+	// RetryableStatusCodes are the HTTP status codes of responses which cause a retry; if nil, 429 (Too Many Requests),
+	// 502 (Bad Gateway), 503 (Service Unavailable) and 504 (Gateway Timeout) are retried.
+	RetryableStatusCodes []int
+	// If IgnoreRetryAfter is true, delays requested by registries using Retry-After headers are ignored.
+	IgnoreRetryAfter bool
+}
+
+// DigestPolicy controls which digest algorithms are accepted when reading images, and how strictly digests
+// reported by registries are verified.
+type DigestPolicy struct {
+	// AllowedAlgorithms, if not empty, are the only digest algorithms (e.g. digest.SHA256 and digest.SHA512)
+	// accepted in digests of manifests referenced by digest (including manifest list instances) and of blobs;
+	// reading an image which uses any other algorithm fails with a DigestAlgorithmNotAllowedError.
+	AllowedAlgorithms []digest.Algorithm
+	// If RequireContentDigestMatch is true, a Docker-Content-Digest header returned by a registry which does not
+	// match the manifest or blob it was returned with is a fatal error; otherwise, the mismatch is only logged
+	// (the contents are still verified against the expected digest, if any).
+	RequireContentDigestMatch bool
+	// If not nil, called when copying an image, for every manifest and blob whose contents have been verified
+	// against a digest, e.g. to record which digest algorithms were accepted.
+	DigestAcceptedHandler func(DigestAcceptance)
+}
+
+// DigestKind is the kind of the object identified by a digest.
+type DigestKind string
+
+const (
+	// DigestKindManifest is the digest of a manifest or a manifest list.
+	DigestKindManifest DigestKind = "manifest"
+	// DigestKindBlob is the digest of a blob (a layer or a config).
+	DigestKindBlob DigestKind = "blob"
+)
+
+// DigestAcceptance records that contents have been verified against a digest, and accepted.
+// It is reported to DigestPolicy.DigestAcceptedHandler.
+type DigestAcceptance struct {
+	// Image is the image the contents belong to, as returned by transports.ImageName.
+	Image string
+	// Kind is the kind of the contents.
+	Kind DigestKind
+	// Digest is the digest the contents have been verified against.
+	Digest digest.Digest
+}
+
+// DigestAlgorithmNotAllowedError is returned when a digest uses an algorithm not allowed by DigestPolicy.AllowedAlgorithms.
+type DigestAlgorithmNotAllowedError struct {
+	Digest  digest.Digest
+	Allowed []digest.Algorithm
+}
+
+func (e DigestAlgorithmNotAllowedError) Error() string {
+	allowed := make([]string, 0, len(e.Allowed))
+	for _, a := range e.Allowed {
+		allowed = append(allowed, a.String())
+	}
+	return fmt.Sprintf("digest %s uses algorithm %q, which is not allowed (allowed: %s)", e.Digest, e.Digest.Algorithm(), strings.Join(allowed, ", "))
+}
+
+// DockerRateLimit describes a client-side limit on requests sent to a registry host, shared by all operations
+// in the process.
+type DockerRateLimit struct {
+	// RequestsPerSecond is the sustained number of requests per second; if <= 0, the rate is not limited.
+	RequestsPerSecond float64
+	// Burst is the number of requests which can be sent at once, above RequestsPerSecond; if < 1, 1 is used.
+	Burst int
+	// MaxConcurrentRequests is the maximum number of requests in flight at the same time; if <= 0, the number is
+	// not limited.  A request is in flight until its response headers are received; reading the response body
+	// (e.g. downloading a blob) does not count.
+	MaxConcurrentRequests int
+}
+
 // DockerAuthConfig contains authorization information for connecting to a registry.
 // the value of Username and Password can be empty for accessing the registry anonymously
 type DockerAuthConfig struct {
@@ -478,6 +568,18 @@ type DockerAuthConfig struct {
 	// token is set, password should not be set.
 	// Ref: https://docs.docker.com/registry/spec/auth/oauth/
 	IdentityToken string
+	// ClientCertPath and ClientKeyPath, if set, are paths to a PEM-encoded TLS client certificate and its private key,
+	// used to authenticate to the registry, in addition to (or instead of) the other credentials.
+	ClientCertPath string
+	ClientKeyPath  string
+}
+
+// DockerSession is state shared by docker transport operations, created by docker.NewSession;
+// see SystemContext.DockerSession.  It is safe for concurrent use.
+type DockerSession interface {
+	// Close releases resources held by the session, e.g. idle network connections.
+	// The session must not be used after calling Close.
+	Close() error
 }
 
 // OptionalBool is a boolean with an additional undefined value, which is meant
@@ -534,6 +636,216 @@ const (
 	ShortNameModeEnforcing
 )
 
+// AuthFile is an authentication file listed in SystemContext.AuthFilePaths.
+type AuthFile struct {
+	Path string
+	// If true, the file is only used for reading credentials, never for writing them.
+	ReadOnly bool
+}
+
+// AuthFileLocations are the default locations of authentication files, as returned by SystemContext.AuthFilePathResolver.
+type AuthFileLocations struct {
+	// Default is the authentication file used for writing credentials, and read first; if "", credentials can't be written.
+	Default string
+	// Fallbacks are additional authentication files read, in order, after Default; they are never written to.
+	Fallbacks []AuthFileFallback
+}
+
+// AuthFileFallback is a read-only authentication file listed in AuthFileLocations.Fallbacks.
+type AuthFileFallback struct {
+	Path string
+	// If true, the file uses the legacy format of ~/.dockercfg.
+	LegacyFormat bool
+}
+
+// ResolvedLocation is the location of a file chosen by default, as reported by e.g. config.GetAuthFileLocation.
+type ResolvedLocation struct {
+	Path string
+	// If true, Path is in a fallback directory (SystemContext.RuntimeDirFallback or SystemContext.CacheDirFallback)
+	// because the conventional directory was not usable.
+	Fallback bool
+	// FallbackReason describes why the conventional directory was not used, if Fallback.
+	FallbackReason string
+}
+
+// CredentialHelperExecOptions configures how external credential helper processes (docker-credential-*) are executed.
+// Helpers never inherit open file descriptors other than their standard input, output and error.
+type CredentialHelperExecOptions struct {
+	// If not nil, helpers only inherit the environment variables named in this list (e.g. "PATH", "HOME",
+	// "DBUS_SESSION_BUS_ADDRESS"), instead of the full environment of the calling process.
+	// An empty non-nil list means helpers run with an empty environment.
+	EnvironmentAllowlist []string
+	// If not "", the working directory of helper processes; otherwise the working directory of the calling process is used.
+	WorkingDirectory string
+	// If true, the standard error of helper processes is discarded instead of being inherited.
+	DiscardStderr bool
+	// If true, helper processes are started with the no_new_privs flag set, so that they can’t gain privileges
+	// via setuid/setgid binaries or file capabilities.  Only supported on Linux; elsewhere, running a helper fails.
+	NoNewPrivileges bool
+	// If true, helper processes are started with a seccomp filter which denies system calls not needed by credential
+	// helpers (e.g. ptrace, mount and namespace operations, loading kernel modules or BPF programs), and the no_new_privs
+	// flag set.  Only supported on Linux on amd64 and arm64; elsewhere, running a helper fails.
+	Seccomp bool
+}
+
+// CredentialHelperMetrics receives instrumentation events about external credential helper (docker-credential-*) usage,
+// e.g. to record helper latency, error rates and cache hit rates.
+// Implementations must be safe for concurrent use, and should return quickly.
+type CredentialHelperMetrics interface {
+	// ObserveCredentialHelperCall is called after each execution of credHelper with action (e.g. "get", "store", "erase",
+	// "list", or an action of the batch protocol), with the time it took and the error, if any.
+	// A "get" which finds no credentials is not an error.
+	ObserveCredentialHelperCall(credHelper, action string, duration time.Duration, err error)
+	// ObserveCredentialHelperCacheLookup is called when credentials for a registry are looked up in credHelper
+	// while the credentials of helpers were prefetched; hit is true if the lookup did not need to execute the helper.
+	ObserveCredentialHelperCacheLookup(credHelper string, hit bool)
+}
+
+// CredentialLookupEvent describes a single decision made while looking up registry credentials in pkg/docker/config,
+// e.g. that a credential source was consulted and did not contain credentials, or that credentials were found.
+// It never contains passwords or identity tokens.
+type CredentialLookupEvent struct {
+	// Key is the registry, namespace or repository the credentials are looked up for.
+	Key string
+	// Source is the kind of credential storage the event relates to: "system-context", "auth-file"
+	// or "credential-helper" (see pkg/docker/config.CredentialBackend); "" for the final outcome of a lookup
+	// which did not find any credentials.
+	Source string
+	// Path is the auth file the event relates to, if any.
+	Path string
+	// Helper is the name of the external credential helper (without the docker-credential- prefix) the event relates to, if any.
+	Helper string
+	// Found is true if the credentials were found in this source, and will be used.
+	Found bool
+	// Err is the error which occurred while consulting this source, if any.
+	Err error
+	// Message is a human-readable description of the decision.
+	Message string
+}
+
+// DockerPullSourceChoice describes the location an image was pulled from, as reported to
+// SystemContext.DockerPullSourceCallback.
+type DockerPullSourceChoice struct {
+	// Reference is the reference of the image, as requested.
+	Reference reference.Named
+	// PullSource is the reference used to access the image (e.g. in a mirror).
+	PullSource reference.Named
+	// Endpoint is the location of the registry or mirror, as configured in registries.conf.
+	Endpoint string
+	// Mirror is true if the image was pulled from a mirror, not from the primary location.
+	Mirror bool
+	// FailedPullSources describes the locations which were tried, and failed, before PullSource.
+	FailedPullSources []string
+}
+
+// WarningKind is the kind of a Warning.
+type WarningKind string
+
+const (
+	// WarningDeprecation reports the use of a format or behavior which is deprecated, and may stop being supported
+	// in the future.
+	WarningDeprecation WarningKind = "deprecation"
+	// WarningCapabilityGap reports that a registry or other external component lacks a capability, so that
+	// a fallback (typically slower, or less reliable) is used instead.
+	WarningCapabilityGap WarningKind = "capability-gap"
+	// WarningRegistry reports a warning sent by a registry in a HTTP Warning header, e.g. a deprecation notice
+	// or a notice about upcoming rate limits.
+	WarningRegistry WarningKind = "registry"
+)
+
+// Codes of the warnings reported to SystemContext.WarningHandler.  More codes may be added in the future.
+const (
+	// WarningCodeSchema1Manifest: an image with a Docker schema1 manifest was read.
+	WarningCodeSchema1Manifest = "schema1-manifest"
+	// WarningCodeLegacyAuthFile: credentials were found in a legacy-format auth file (~/.dockercfg).
+	WarningCodeLegacyAuthFile = "legacy-auth-file"
+	// WarningCodeLegacyAuthKey: credentials were found in an auth file entry keyed by an URL instead of a registry host name.
+	WarningCodeLegacyAuthKey = "legacy-auth-key"
+	// WarningCodeLookasideSignatures: signatures were read from lookaside storage, because the registry does not support
+	// storing them.
+	WarningCodeLookasideSignatures = "lookaside-signatures"
+	// WarningCodeReferrersAPIUnsupported: the registry does not support the referrers API, so the referrers tag schema
+	// is used instead.
+	WarningCodeReferrersAPIUnsupported = "referrers-api-unsupported"
+	// WarningCodeTagDeletionUnsupported: the registry does not support deleting tags, so a tag was deleted by deleting
+	// its manifest and restoring other tags.
+	WarningCodeTagDeletionUnsupported = "tag-deletion-unsupported"
+	// WarningCodeRegistryWarning: a registry returned a Warning header; Message is the text of the warning.
+	// Each distinct warning is reported at most once per registry client (e.g. per image source or destination).
+	WarningCodeRegistryWarning = "registry-warning"
+)
+
+// Warning is a structured notice about a deprecated or degraded behavior encountered at run time,
+// reported to SystemContext.WarningHandler.
+type Warning struct {
+	// Kind is the kind of the warning.
+	Kind WarningKind
+	// Code identifies the specific situation, e.g. WarningCodeSchema1Manifest; it is stable, and suitable for aggregation.
+	Code string
+	// Subject identifies the object the warning relates to, depending on Code: e.g. an image name, a registry
+	// (host[:port]), or an auth file path.
+	Subject string
+	// Message is a human-readable description of the warning.
+	Message string
+}
+
+// CredentialHelperFailoverMode describes how multiple credential helpers are consulted,
+// and how errors of individual helpers are handled.
+type CredentialHelperFailoverMode int
+
+const (
+	// CredentialHelperFailoverInvalid represents an unset or invalid mode; the default mode is then used.
+	CredentialHelperFailoverInvalid CredentialHelperFailoverMode = iota
+	// CredentialHelperFailoverFirstSuccess consults helpers in order and uses the first one which
+	// contains (or successfully stores) credentials.  Errors of individual helpers are only reported
+	// if no helper succeeds.  This is the default.
+	CredentialHelperFailoverFirstSuccess
+	// CredentialHelperFailoverTryAll consults all helpers for lookups, and merges their results: each kind of
+	// credentials (a username and password, an identity token, a TLS client certificate) is taken from the first helper
+	// which contains it.  Errors of individual helpers are only reported if no helper contains credentials.
+	// Credentials are stored in all helpers, and storing fails if storing in any of them fails.
+	CredentialHelperFailoverTryAll
+	// CredentialHelperFailoverFailFast aborts the operation on the first error of any helper,
+	// even if a later helper could succeed.
+	CredentialHelperFailoverFailFast
+	// CredentialHelperFailoverIgnoreErrors treats lookup errors of individual helpers as missing credentials;
+	// they are only logged.  Writes still fail if no helper succeeds.
+	CredentialHelperFailoverIgnoreErrors
+)
+
+// PlatformMatcher implements a policy for choosing an image from a manifest list based on the platforms of its instances,
+// e.g. to prefer specific CPU variants, or to reject images which don’t specify a variant.
+// See pkg/platform.Policy for a configurable implementation.
+type PlatformMatcher interface {
+	// ChoosePlatform returns the index of the most appropriate platform in candidates for the environment described by sys
+	// (which may be nil), or -1 if none of the candidates is acceptable.
+	// The candidates are the platforms of instances of a single manifest list, in the order of the list;
+	// ties should be resolved in favor of earlier candidates.
+	ChoosePlatform(sys *SystemContext, candidates []v1.Platform) (int, error)
+}
+
+// RemoteSignaturePolicy configures loading a signature policy from a remote source, see SystemContext.SignaturePolicyRemote.
+type RemoteSignaturePolicy struct {
+	// Source is the location of the policy: either an https:// URL of a policy.json file (accessed using the TLS
+	// configuration of SystemContext.DockerCertPath or the certs.d directories, like the docker transport), or an image name in the
+	// transport:reference format (e.g. docker://registry.example.com/policies/containers:latest, using the transports
+	// registered by the caller, usually by importing transports/alltransports) of an OCI artifact which contains
+	// the policy as its only layer.
+	Source string
+	// Exactly one of PublicKeyPath and PublicKeyData must be set, containing PEM-encoded sigstore public keys;
+	// the policy is only accepted if signed by one of them.  A policy at an https:// URL must be signed by a base64-encoded
+	// signature at the same URL with a ".sig" suffix (as created by cosign sign-blob); an OCI artifact must have a sigstore
+	// signature (as created by cosign sign) claiming the artifact’s repository.
+	PublicKeyPath string
+	PublicKeyData []byte
+	// If CacheDir is not "" and CacheTTL is positive, a verified policy is stored in CacheDir, together with the data
+	// necessary to verify its signature again, and used for CacheTTL without contacting Source again; the signature
+	// of the cached policy is verified every time it is used.  If Source can't be reached and there is
+	// no unexpired cached policy, loading the policy fails.
+	CacheDir string
+	CacheTTL time.Duration
+}
+
 // SystemContext allows parameterizing access to implicitly-accessed resources,
 // like configuration files in /etc and users' login state in their home directory.
 // Various components can share the same field only if their semantics is exactly
@@ -550,11 +862,29 @@ type SystemContext struct {
 
 	// === Global configuration overrides ===
 	// If not "", overrides the system's default path for signature.Policy configuration.
+	// The per-user policy, and drop-in policy directories other than SignaturePolicyDirPath, are not used in that case.
 	SignaturePolicyPath string
+	// If true, signature.DefaultPolicy merges the policy from the system and per-user policy files and policy.d
+	// (drop-in signature.Policy configuration) directories, instead of using only the per-user policy file, if it exists,
+	// or otherwise the system one.
+	SignaturePolicyMergeDropIns bool
+	// If not "", overrides the system's default path for policy.d, and the per-user policy.d directory is not used;
+	// this implies SignaturePolicyMergeDropIns.
+	SignaturePolicyDirPath string
+	// If not nil, signature.DefaultPolicy loads the policy from a remote source instead of a local file;
+	// SignaturePolicyPath and SignaturePolicyDirPath must be "" in that case.
+	SignaturePolicyRemote *RemoteSignaturePolicy
 	// If not "", overrides the system's default path for registries.d (Docker signature storage configuration)
 	RegistriesDirPath string
 	// Path to the system-wide registries configuration file
 	SystemRegistriesConfPath string
+	// If not "", overrides the system's default path for containers-copy.conf (defaults for copy.Options).
+	// Only the value in copy.Options.DestinationCtx is used.
+	CopyConfPath string
+	// If not 0, the default for copy.Options.MaxParallelDownloads; only the value in copy.Options.SourceCtx is used.
+	CopyMaxParallelDownloads uint
+	// If not 0, the default for copy.Options.MaxParallelUploads; only the value in copy.Options.DestinationCtx is used.
+	CopyMaxParallelUploads uint
 	// Path to the system-wide registries configuration directory
 	SystemRegistriesConfDirPath string
 	// Path to the user-specific short-names configuration file
@@ -575,18 +905,54 @@ type SystemContext struct {
 	// this field is ignored if `AuthFilePath` is set (we favor the newer format);
 	// only reading of this data is supported;
 	LegacyFormatAuthFilePath string
+	// If not empty, overrides AuthFilePath and LegacyFormatAuthFilePath with a list of (new format) authentication files:
+	// they are consulted in order when reading credentials, before the default fallback locations,
+	// and credentials are written to the first file which is not ReadOnly.
+	AuthFilePaths []AuthFile
+	// If not nil, the default authentication file locations are computed for this UID instead of the UID of the calling process,
+	// e.g. for tools managing credentials on behalf of rootless users.  For a UID other than that of the calling process,
+	// $XDG_RUNTIME_DIR, $XDG_CONFIG_HOME and $DOCKER_CONFIG are ignored, and the home directory of that user is used.
+	AuthFileUID *int
+	// If not "", used instead of $XDG_RUNTIME_DIR to compute the default authentication file path,
+	// e.g. for containers/storage rootless setups where the effective runtime directory differs from $XDG_RUNTIME_DIR.
+	AuthFileRuntimeDir string
+	// If not nil, replaces the built-in strategy for computing the default authentication file locations for goOS
+	// (usually runtime.GOOS), e.g. for operating systems with nonstandard layouts.  It is not used for locations
+	// explicitly set by AuthFilePaths, AuthFilePath or LegacyFormatAuthFilePath, and when it is used,
+	// RootForImplicitAbsolutePaths, AuthFileUID and AuthFileRuntimeDir do not affect the auth file locations.
+	AuthFilePathResolver func(goOS string) (AuthFileLocations, error)
+	// If not "", used instead of $XDG_RUNTIME_DIR to compute the default authentication file path, and the token cache
+	// directory (see DockerTokenCacheInRuntimeDir), if $XDG_RUNTIME_DIR is not set, does not exist, or is not writable
+	// (as is common in containers and systemd user services).
+	// It is not used for the authentication file path if AuthFileRuntimeDir is set.
+	RuntimeDirFallback string
+	// If not "", used instead of the user’s cache directory ($XDG_CACHE_HOME, or ~/.cache) to compute the default
+	// path of the short-name alias cache if the cache directory can’t be determined or is not writable.
+	CacheDirFallback string
 	// If not "", overrides the use of platform.GOARCH when choosing an image or verifying architecture match.
 	ArchitectureChoice string
 	// If not "", overrides the use of platform.GOOS when choosing an image or verifying OS match.
 	OSChoice string
 	// If not "", overrides the use of detected ARM platform variant when choosing an image or verifying variant match.
 	VariantChoice string
+	// If not nil, replaces the built-in heuristics for choosing an image from a manifest list based on the platforms
+	// of its instances.  Implementations should generally honor ArchitectureChoice, OSChoice and VariantChoice.
+	PlatformMatcher PlatformMatcher
 	// If not "", overrides the system's default directory containing a blob info cache.
 	BlobInfoCacheDir string
 	// Additional tags when creating or copying a docker-archive.
 	DockerArchiveAdditionalTags []reference.NamedTagged
 	// If not "", overrides the temporary directory to use for storing big files
 	BigFilesTemporaryDir string
+	// If > 0, blobs which must be read completely to compute their digest or size before they are written
+	// (e.g. with DockerRegistryPushPrecomputeDigests, or by docker-archive destinations) are buffered in memory
+	// as long as they are not larger than this many bytes, and only larger blobs are spooled to a temporary file
+	// in BigFilesTemporaryDir.  Otherwise, such blobs are always spooled to a temporary file.
+	BlobSpoolMemoryThreshold int64
+	// If > 0, the maximum total size of the in-memory buffers of all blobs buffered as described in
+	// BlobSpoolMemoryThreshold, across all concurrent operations in the process; blobs which would exceed it are spooled
+	// to a temporary file instead.  Otherwise, a default of 256 MiB is used.
+	BlobSpoolMemoryLimit int64
 
 	// === OCI.Transport overrides ===
 	// If not "", a directory containing a CA certificate (ending with ".crt"),
@@ -613,24 +979,141 @@ type SystemContext struct {
 	// if nil, the library tries to parse ~/.docker/config.json to retrieve credentials
 	// Ignored if DockerBearerRegistryToken is non-empty.
 	DockerAuthConfig *DockerAuthConfig
+	// If not nil, maps a registry, namespace or repository key (in the same format as keys of auth files)
+	// to credentials which are used, before consulting any auth files or credential helpers, when accessing a matching registry.
+	// The most specific matching key is used. Credentials in this map are never written to disk.
+	// Ignored if DockerAuthConfig is not nil or DockerBearerRegistryToken is non-empty.
+	DockerPerRegistryAuthConfigs map[string]DockerAuthConfig
+	// If not nil, overrides the list of credential helpers (including their order) from registries.conf.
+	// An empty list means the default, ["containers-auth.json"].
+	CredentialHelpers []string
+	// If not nil, maps registries (host[:port], without namespaces) to credential helpers (the suffix of the program name,
+	// i.e. everything after docker-credential-) which are used exclusively to read, store and remove credentials
+	// for that registry, regardless of CredentialHelpers, registries.conf and auth files.
+	CredentialHelperOverrides map[string]string
+	// If set, overrides the credential-helper-failover mode from registries.conf.
+	CredentialHelperFailoverMode *CredentialHelperFailoverMode
+	// If not OptionalBoolUndefined, overrides the require-credential-helper option from registries.conf:
+	// if true, credentials are never stored in plain text (base64-encoded) in auth files.
+	RequireCredentialHelper OptionalBool
+	// If not nil, restricts how external credential helpers (docker-credential-*) are executed.
+	CredentialHelperExecOptions *CredentialHelperExecOptions
+	// If > 0, the maximum number of external credential helpers listed concurrently when enumerating all credentials
+	// (pkg/docker/config.GetAllCredentials); otherwise a default of 4 is used.
+	CredentialHelperListParallelism int
+	// If > 0, the time after which listing credentials stored in a single external credential helper is aborted
+	// and the helper is killed; otherwise a default of 30 seconds is used.
+	CredentialHelperListTimeout time.Duration
+	// If not nil, notified about the executions of external credential helpers, and about lookups of prefetched credentials.
+	CredentialHelperMetrics CredentialHelperMetrics
+	// If not nil, called synchronously with structured events describing how registry credentials are resolved
+	// (which sources are consulted, and which one provides the credentials), e.g. for auditing.
+	CredentialLookupEventHandler func(CredentialLookupEvent)
+	// If not nil, called synchronously with structured warnings about deprecated formats and behaviors (e.g. schema1
+	// images, or legacy auth files), about capabilities missing in registries (e.g. the referrers API), and with
+	// warnings sent by registries in HTTP Warning headers, as they are encountered, e.g. to inventory what needs
+	// migration before such behaviors stop being supported.
+	// The same warning may be reported more than once.
+	WarningHandler func(Warning)
+	// If true, images with Docker schema1 manifests are rejected with a manifest.Schema1RejectedError when they are read,
+	// instead of only reporting a WarningCodeSchema1Manifest warning; see manifest.Schema1ToOCI1 for an explicit conversion.
+	RejectSchema1Manifests bool
+	// If not nil, restricts the digest algorithms accepted when reading images, and controls how strictly digests
+	// reported by registries are verified.
+	DigestPolicy *DigestPolicy
 	// if not "", the library uses this registry token to authenticate to the registry
 	DockerBearerRegistryToken string
+	// Controls whether bearer tokens obtained from registries are stored in a process-wide cache shared by all docker
+	// transport image sources and destinations which also enable it (keyed by registry, credentials and scope), reducing
+	// the load on token endpoints by parallel copies.  Tokens are only shared in this way if this is OptionalBoolTrue.
+	// If DockerSession is set, tokens are instead shared within the session, unless this is OptionalBoolFalse.
+	DockerSharedTokenCache OptionalBool
+	// If not "", a directory in which bearer tokens are additionally cached on disk, so that they can be shared
+	// between processes.  Tokens are sensitive; the directory should only be accessible by the current user.
+	// Ignored unless tokens are shared as described for DockerSharedTokenCache.
+	DockerTokenCacheDir string
+	// If true, and DockerTokenCacheDir is "", bearer tokens are cached on disk in a "containers/tokens" subdirectory
+	// of $XDG_RUNTIME_DIR, or of RuntimeDirFallback if $XDG_RUNTIME_DIR is not set, does not exist, or is not writable.
+	// Ignored unless tokens are shared as described for DockerSharedTokenCache.
+	DockerTokenCacheInRuntimeDir bool
+	// Scopes, in the "repository:name:actions" format, requested up front in every bearer token the docker transport
+	// obtains, in addition to the scopes needed for the repository being accessed; e.g. "repository:source/repo:pull"
+	// for a destination, to allow mounting blobs from source/repo.  Requests which need one of these scopes (notably
+	// cross-repository blob mounts) then use the token obtained up front, instead of obtaining a separate token for
+	// every source repository, which saves round trips on high-latency links.
+	// The scopes which were requested can be inspected using BearerTokenScopes() of docker transport image sources
+	// and destinations.
+	DockerAdditionalTokenScopes []string
+	// If not nil, a session created by docker.NewSession, holding state shared by all docker transport operations
+	// which use it: HTTP connections (which are kept alive between requests), detected registry properties, and
+	// bearer tokens (which are cached in the session, unless DockerSharedTokenCache is OptionalBoolFalse).
+	// Intended for long-running processes performing many operations.
+	DockerSession DockerSession
+	// If not nil, called for every docker transport client (i.e. for every image source, destination, or other registry
+	// access) with the registry (host[:port]) and the HTTP round tripper which would be used for all of its traffic,
+	// including authentication; the returned round tripper is used instead.  The default round tripper is configured
+	// with the TLS settings, certificates and proxies; the returned one can wrap it (e.g. to add tracing), or replace it
+	// (e.g. to use a different TLS identity, or to record and replay traffic in tests).
+	DockerRoundTripperWrapper func(registry string, rt http.RoundTripper) http.RoundTripper
 	// if not "", an User-Agent header is added to each request when contacting a registry.
 	DockerRegistryUserAgent string
 	// if true, a V1 ping attempt isn't done to give users a better error. Default is false.
 	// Note that this field is used mainly to integrate containers/image into projectatomic/docker
 	// in order to not break any existing docker's integration tests.
 	DockerDisableV1Ping bool
+	// If true, the docker transport does not special-case docker.io, for environments running a different registry
+	// named docker.io: requests are sent to docker.io instead of registry-1.docker.io, searches use docker.io instead
+	// of index.docker.io, and credentials for docker.io are not looked up using Docker Hub aliases like index.docker.io
+	// or https://index.docker.io/v1/.
+	// Note that references are still normalized when parsed, e.g. docker.io/busybox refers to docker.io/library/busybox.
+	DockerDisableHubNormalization bool
 	// If true, dockerImageDestination.SupportedManifestMIMETypes will omit the Schema1 media types from the supported list
 	DockerDisableDestSchema1MIMETypes bool
 	// If true, the physical pull source of docker transport images logged as info level
 	DockerLogMirrorChoice bool
+	// Controls whether the docker transport tracks the health of registry mirrors: mirrors which fail in a way indicating
+	// they are unhealthy (e.g. are unreachable) are tried only after the primary location for a while, and other mirrors
+	// are tried in the order of their observed latency.  Otherwise, mirrors are always tried in the configured order.
+	// If DockerSession is set, health is tracked within the session, unless this is OptionalBoolFalse.
+	// Without a DockerSession, health is tracked process-wide only if this is OptionalBoolTrue.
+	DockerMirrorHealthTracking OptionalBool
+	// If not nil, called when a docker transport image source is opened, with the location (a registry mirror,
+	// or the primary location) it has been opened from.
+	DockerPullSourceCallback func(DockerPullSourceChoice)
 	// Directory to use for OSTree temporary files
 	OSTreeTmpDirPath string
 	// If true, all blobs will have precomputed digests to ensure layers are not uploaded that already exist on the registry.
 	// Note that this requires writing blobs to temporary files, and takes more time than the default behavior,
 	// when the digest for a blob is unknown.
 	DockerRegistryPushPrecomputeDigests bool
+	// If > 0, requests rejected by a registry which explicitly reports a maintenance (read-only) window in the body of an
+	// HTTP 503 response with a Retry-After header are automatically retried, as long as the total time spent
+	// waiting does not exceed this budget.  Otherwise, such failures are reported as docker.ErrRegistryMaintenance.
+	DockerRegistryMaintenanceRetryBudget time.Duration
+	// If not nil, how requests to registries (blob and manifest operations, and token fetches) are retried
+	// after transient failures.  If nil, only requests rejected with HTTP 429 (Too Many Requests) are retried.
+	DockerRetryPolicy *DockerRetryPolicy
+	// Client-side limits on requests sent to registries, keyed by host[:port], e.g. to avoid tripping registry
+	// throttling when mirroring many images.  The limits apply to all operations in the process accessing the host.
+	// An entry for a host overrides the rate-limit configured for it in registries.conf.
+	DockerRegistryRateLimits map[string]DockerRateLimit
+	// If OptionalBoolTrue, registries specified as a host name without a port are resolved using DNS SRV records
+	// (_oci._tcp.<host>), if any: connections are made to the targets in the order of their priority and weight,
+	// as specified by RFC 2782, failing over to the next target if connecting fails.  TLS certificates are still
+	// verified against the host name, and registries without SRV records are accessed directly.
+	DockerRegistrySRVLookup OptionalBool
+	// If > 0, blobs are uploaded to registries in chunks of this size (in bytes), and an interrupted chunk is
+	// resumed from the last byte acknowledged by the registry instead of restarting the whole upload.
+	// If the upload of a blob with a known digest fails anyway, a later upload of the same blob using the same
+	// destination (e.g. a retry, see copy.Options.LayerRetryPolicy) resumes it.
+	DockerChunkedUploadSize int64
+	// If not "", and DockerChunkedUploadSize > 0, upload sessions of blobs with known digests are recorded in this
+	// directory, so that an interrupted push can resume uploading such blobs even in a different process.
+	DockerUploadSessionDir string
+	// If not nil, called before reading an image referenced by a tag, to map the tag to a trusted digest, e.g. using
+	// Docker Content Trust (Notary / TUF) metadata (see pkg/contenttrust).  If it returns a digest, the image is read
+	// by that digest instead of by the tag; if it returns "", the tag is used; if it fails, reading the image fails.
+	DockerTagResolver func(ctx context.Context, ref reference.NamedTagged) (digest.Digest, error)
 
 	// === docker/daemon.Transport overrides ===
 	// A directory containing a CA certificate (ending with ".crt"),